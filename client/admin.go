@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// TopicPurgeResponse is the response of Client.PurgeTopic
+type TopicPurgeResponse struct {
+	Topic    string `json:"topic"`
+	Messages int    `json:"messages"` // Number of deleted messages
+}
+
+// TopicCloseResponse is the response of Client.CloseTopic
+type TopicCloseResponse struct {
+	Topic       string `json:"topic"`
+	ClosedUntil int64  `json:"closed_until"` // Unix timestamp
+	Subscribers int    `json:"subscribers"`  // Number of disconnected subscribers
+}
+
+type adminTopicRequest struct {
+	Topic    string `json:"topic"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// PurgeTopic deletes all cached messages of a topic, including scheduled messages and attachments.
+// This requires admin credentials, see WithBasicAuth and WithBearerAuth.
+func (c *Client) PurgeTopic(topic string, options ...RequestOption) (*TopicPurgeResponse, error) {
+	var response TopicPurgeResponse
+	if err := c.adminTopicRequest(http.MethodPost, topic, "purge", "", &response, options...); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// CloseTopic disconnects all subscribers of a topic, and blocks publishing to it for the given duration
+// (e.g. "30m", or empty for the server default of 1 hour). This requires admin credentials.
+func (c *Client) CloseTopic(topic, duration string, options ...RequestOption) (*TopicCloseResponse, error) {
+	var response TopicCloseResponse
+	if err := c.adminTopicRequest(http.MethodPost, topic, "close", duration, &response, options...); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ReopenTopic allows publishing to a topic that was closed with CloseTopic again. This requires admin credentials.
+func (c *Client) ReopenTopic(topic string, options ...RequestOption) error {
+	return c.adminTopicRequest(http.MethodDelete, topic, "close", "", nil, options...)
+}
+
+// adminTopicRequest sends a request to the admin topics API (/v1/admin/topics/<action>) of the server of the topic.
+// The topic can be passed the same way as for Publish, e.g. mytopic or https://myhost.lan/mytopic.
+func (c *Client) adminTopicRequest(method, topic, action, duration string, response any, options ...RequestOption) error {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return err
+	}
+	index := strings.LastIndex(topicURL, "/")
+	baseURL, name := topicURL[:index], topicURL[index+1:]
+	if !topicRegex.MatchString(name) {
+		return fmt.Errorf("invalid topic name: %s", name)
+	}
+	body, err := json.Marshal(&adminTopicRequest{Topic: name, Duration: duration})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/admin/topics/%s", baseURL, action), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if err := option(req); err != nil {
+			return err
+		}
+	}
+	log.Debug("%s Sending %s request to %s", util.ShortTopicURL(topicURL), method, req.URL.String())
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(b)))
+	} else if response == nil {
+		return nil
+	}
+	return json.Unmarshal(b, response)
+}
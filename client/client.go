@@ -11,6 +11,7 @@ import (
 	"heckel.io/ntfy/v2/util"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"sync"
@@ -28,29 +29,41 @@ const (
 
 var (
 	topicRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`) // Same as in server/server.go
+
+	// ErrAttachmentTooLarge is returned by DownloadAttachment if the attachment exceeds the size limit
+	ErrAttachmentTooLarge = errors.New("attachment too large")
 )
 
 // Client is the ntfy client that can be used to publish and subscribe to ntfy topics
 type Client struct {
 	Messages      chan *Message
 	config        *Config
+	httpClient    *http.Client
 	subscriptions map[string]*subscription
 	mu            sync.Mutex
 }
 
 // Message is a struct that represents a ntfy message
 type Message struct { // TODO combine with server.message
-	ID         string
-	Event      string
-	Time       int64
-	Topic      string
-	Message    string
-	Title      string
-	Priority   int
-	Tags       []string
-	Click      string
-	Icon       string
-	Attachment *Attachment
+	ID          string
+	Event       string
+	Time        int64
+	Topic       string
+	Message     string
+	Title       string
+	Priority    int
+	Tags        []string
+	Click       string
+	Icon        string
+	Actions     []*Action
+	Attachment  *Attachment
+	Attachments []*Attachment // Only set if there is more than one attachment
+	Preview     *Preview
+	ContentType string `json:"content_type"`
+	Encryption  string
+	Signature   string
+	ReplyTo     string `json:"reply_to"`
+	Cron        string
 
 	// Additional fields
 	TopicURL       string
@@ -60,12 +73,38 @@ type Message struct { // TODO combine with server.message
 
 // Attachment represents a message attachment
 type Attachment struct {
-	Name    string `json:"name"`
-	Type    string `json:"type,omitempty"`
-	Size    int64  `json:"size,omitempty"`
-	Expires int64  `json:"expires,omitempty"`
-	URL     string `json:"url"`
-	Owner   string `json:"-"` // IP address of uploader, used for rate limiting
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Expires   int64  `json:"expires,omitempty"`
+	URL       string `json:"url"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+	Owner     string `json:"-"` // IP address of uploader, used for rate limiting
+}
+
+// Action represents a user-defined action button of a message, see https://ntfy.sh/docs/publish/#action-buttons
+type Action struct {
+	ID      string            `json:"id"`
+	Action  string            `json:"action"`
+	Label   string            `json:"label"`
+	Clear   bool              `json:"clear"`
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Intent  string            `json:"intent,omitempty"`
+	Extras  map[string]string `json:"extras,omitempty"`
+}
+
+// Preview represents the link preview of a message, see enable-link-previews
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
 }
 
 type subscription struct {
@@ -76,9 +115,18 @@ type subscription struct {
 
 // New creates a new Client using a given Config
 func New(config *Config) *Client {
+	httpClient, err := newHTTPClient(config.Resolver, config.Proxy)
+	if err != nil && config.Proxy != "" {
+		log.Error("Cannot set up connection via proxy %s, all requests will fail: %s", config.Proxy, err.Error())
+		httpClient = &http.Client{Transport: &errorTransport{err}} // Never bypass the proxy
+	} else if err != nil {
+		log.Warn("Cannot use resolver %s, falling back to system resolver: %s", config.Resolver, err.Error())
+		httpClient = http.DefaultClient
+	}
 	return &Client{
 		Messages:      make(chan *Message, 50), // Allow reading a few messages
 		config:        config,
+		httpClient:    httpClient,
 		subscriptions: make(map[string]*subscription),
 	}
 }
@@ -96,7 +144,8 @@ func (c *Client) Publish(topic, message string, options ...PublishOption) (*Mess
 // config (e.g. mytopic -> https://ntfy.sh/mytopic).
 //
 // To pass title, priority and tags, check out WithTitle, WithPriority, WithTagsList, WithDelay, WithNoCache,
-// WithNoFirebase, and the generic WithHeader.
+// WithNoFirebase, and the generic WithHeader. To encrypt the title and message end-to-end, use WithEncryption.
+// To sign them, use WithSignature.
 func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishOption) (*Message, error) {
 	topicURL, err := c.expandTopicURL(topic)
 	if err != nil {
@@ -111,8 +160,14 @@ func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishO
 			return nil, err
 		}
 	}
+	if err := maybeEncryptRequest(req, topicURL); err != nil {
+		return nil, err
+	}
+	if err := maybeSignRequest(req, topicURL); err != nil {
+		return nil, err
+	}
 	log.Debug("%s Publishing message with headers %s", util.ShortTopicURL(topicURL), req.Header)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +207,7 @@ func (c *Client) Poll(topic string, options ...SubscribeOption) ([]*Message, err
 	log.Debug("%s Polling from topic", util.ShortTopicURL(topicURL))
 	options = append(options, WithPoll())
 	go func() {
-		err := performSubscribeRequest(ctx, msgChan, topicURL, "", options...)
+		err := c.performSubscribeRequest(ctx, msgChan, topicURL, "", options...)
 		close(msgChan)
 		errChan <- err
 	}()
@@ -196,7 +251,7 @@ func (c *Client) Subscribe(topic string, options ...SubscribeOption) (string, er
 		topicURL: topicURL,
 		cancel:   cancel,
 	}
-	go handleSubscribeConnLoop(ctx, c.Messages, topicURL, subscriptionID, options...)
+	go c.handleSubscribeConnLoop(ctx, c.Messages, topicURL, subscriptionID, options...)
 	return subscriptionID, nil
 }
 
@@ -213,6 +268,60 @@ func (c *Client) Unsubscribe(subscriptionID string) {
 	sub.cancel()
 }
 
+// DownloadAttachment downloads the attachment of the given message, and writes it to w. If the attachment is
+// larger than maxSize bytes, the download is aborted and ErrAttachmentTooLarge is returned.
+//
+// Options (e.g. WithBasicAuth) are only applied if the attachment is hosted on the same server as the topic,
+// so that credentials are never sent to the host of an external attachment.
+func (c *Client) DownloadAttachment(m *Message, w io.Writer, maxSize int64, options ...RequestOption) error {
+	if m.Attachment == nil || m.Attachment.URL == "" {
+		return errors.New("message has no attachment")
+	} else if m.Attachment.Size > maxSize {
+		return ErrAttachmentTooLarge
+	}
+	req, err := http.NewRequest(http.MethodGet, m.Attachment.URL, nil)
+	if err != nil {
+		return err
+	}
+	if sameHost(m.Attachment.URL, m.TopicURL) {
+		for _, option := range options {
+			if err := option(req); err != nil {
+				return err
+			}
+		}
+	}
+	log.Debug("%s Downloading attachment %s", util.ShortTopicURL(m.TopicURL), m.Attachment.URL)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	} else if resp.ContentLength > maxSize {
+		return ErrAttachmentTooLarge
+	}
+	n, err := io.Copy(w, io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return err
+	} else if n > maxSize {
+		return ErrAttachmentTooLarge
+	}
+	return nil
+}
+
+// TopicURL returns the full URL of the given topic, expanding short topic names and URLs the same way as
+// Publish, Poll and Subscribe do (e.g. mytopic -> https://ntfy.sh/mytopic)
+func (c *Client) TopicURL(topic string) (string, error) {
+	return c.expandTopicURL(topic)
+}
+
+func sameHost(url1, url2 string) bool {
+	u1, err1 := url.Parse(url1)
+	u2, err2 := url.Parse(url2)
+	return err1 == nil && err2 == nil && u1.Scheme == u2.Scheme && u1.Host == u2.Host
+}
+
 func (c *Client) expandTopicURL(topic string) (string, error) {
 	if strings.HasPrefix(topic, "http://") || strings.HasPrefix(topic, "https://") {
 		return topic, nil
@@ -225,11 +334,11 @@ func (c *Client) expandTopicURL(topic string) (string, error) {
 	return fmt.Sprintf("%s/%s", c.config.DefaultHost, topic), nil
 }
 
-func handleSubscribeConnLoop(ctx context.Context, msgChan chan *Message, topicURL, subcriptionID string, options ...SubscribeOption) {
+func (c *Client) handleSubscribeConnLoop(ctx context.Context, msgChan chan *Message, topicURL, subcriptionID string, options ...SubscribeOption) {
 	for {
 		// TODO The retry logic is crude and may lose messages. It should record the last message like the
 		//      Android client, use since=, and do incremental backoff too
-		if err := performSubscribeRequest(ctx, msgChan, topicURL, subcriptionID, options...); err != nil {
+		if err := c.performSubscribeRequest(ctx, msgChan, topicURL, subcriptionID, options...); err != nil {
 			log.Warn("%s Connection failed: %s", util.ShortTopicURL(topicURL), err.Error())
 		}
 		select {
@@ -241,7 +350,7 @@ func handleSubscribeConnLoop(ctx context.Context, msgChan chan *Message, topicUR
 	}
 }
 
-func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicURL string, subscriptionID string, options ...SubscribeOption) error {
+func (c *Client) performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicURL string, subscriptionID string, options ...SubscribeOption) error {
 	streamURL := fmt.Sprintf("%s/json", topicURL)
 	log.Debug("%s Listening to %s", util.ShortTopicURL(topicURL), streamURL)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
@@ -253,7 +362,7 @@ func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicUR
 			return err
 		}
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
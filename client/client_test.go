@@ -1,12 +1,16 @@
 package client_test
 
 import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/client"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/test"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -115,3 +119,155 @@ func nextMessage(c *client.Client) *client.Message {
 		return nil
 	}
 }
+
+func TestClient_Publish_Poll_Encrypted(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+
+	msg, err := c.Publish("mytopic", "top secret message",
+		client.WithTitle("secret title"),
+		client.WithPriority("high"),
+		client.WithEncryption("s3cret"))
+	require.Nil(t, err)
+	require.Equal(t, client.EncryptionAES256GCM, msg.Encryption)
+	require.Equal(t, "", msg.Title)
+	require.NotContains(t, msg.Message, "top secret")
+	require.Equal(t, 4, msg.Priority)
+
+	messages, err := c.Poll("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.NotNil(t, messages[0].Decrypt("wrong password"))
+	require.Nil(t, messages[0].Decrypt("s3cret"))
+	require.Equal(t, "top secret message", messages[0].Message)
+	require.Equal(t, "secret title", messages[0].Title)
+	require.Equal(t, "", messages[0].Encryption)
+	require.Contains(t, messages[0].Raw, `"message":"top secret message"`)
+	require.NotContains(t, messages[0].Raw, "encryption")
+}
+
+func TestClient_Publish_Poll_Signed(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+	keyFile, pubKeyFile := writeTestKeyPair(t)
+	key, err := client.LoadSigningKey(keyFile)
+	require.Nil(t, err)
+	publicKey, err := client.LoadVerifyingKey(pubKeyFile)
+	require.Nil(t, err)
+	otherPublicKey, _, _ := ed25519.GenerateKey(nil)
+
+	msg, err := c.Publish("mytopic", "  deployed v1.2.3\n", client.WithTitle("Deploy"), client.WithSignature(key))
+	require.Nil(t, err)
+	require.NotEmpty(t, msg.Signature)
+	_, err = c.Publish("mytopic", "unsigned message")
+	require.Nil(t, err)
+	_, err = c.Publish("mytopic", "secret message", client.WithEncryption("s3cret"), client.WithSignature(key))
+	require.Nil(t, err)
+
+	messages, err := c.Poll("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 3, len(messages))
+	require.Nil(t, messages[0].Verify(publicKey))
+	require.Equal(t, client.ErrSignatureInvalid, messages[0].Verify(otherPublicKey))
+	require.Equal(t, client.ErrSignatureMissing, messages[1].Verify(publicKey))
+	require.Nil(t, messages[2].Verify(publicKey)) // The ciphertext is signed
+	require.Nil(t, messages[2].Decrypt("s3cret"))
+	require.Equal(t, "secret message", messages[2].Message)
+
+	messages[0].Title = "Tampered"
+	require.Equal(t, client.ErrSignatureInvalid, messages[0].Verify(publicKey))
+
+	_, err = c.Publish("mytopic", "", client.WithSignature(key))
+	require.Error(t, err)
+	_, err = c.Publish("mytopic", "file", client.WithFilename("file.txt"), client.WithSignature(key))
+	require.Error(t, err)
+}
+
+func TestClient_Publish_Poll_Signed_AllFields(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+	keyFile, pubKeyFile := writeTestKeyPair(t)
+	key, err := client.LoadSigningKey(keyFile)
+	require.Nil(t, err)
+	publicKey, err := client.LoadVerifyingKey(pubKeyFile)
+	require.Nil(t, err)
+
+	_, err = c.Publish("mytopic", "**deployed**",
+		client.WithTitle(" Deploy "),
+		client.WithPriority("high"),
+		client.WithTags([]string{"rocket", " prod"}),
+		client.WithClick("https://example.com/deploys/1"),
+		client.WithIcon("https://example.com/icon.png"),
+		client.WithActions(`[{"action":"VIEW","label":"Open","url":"https://example.com/deploys/1"}]`),
+		client.WithAttach("https://example.com/deploys/1/log.txt"),
+		client.WithMarkdown(),
+		client.WithSignature(key),
+	)
+	require.Nil(t, err)
+
+	messages, err := c.Poll("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	m := messages[0]
+	require.Nil(t, m.Verify(publicKey))
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, "view", m.Actions[0].Action)
+	require.Equal(t, "log.txt", m.Attachment.Name)
+	require.Equal(t, "text/markdown", m.ContentType)
+
+	// All delivered fields are covered by the signature
+	m.Priority = 5
+	require.Equal(t, client.ErrSignatureInvalid, m.Verify(publicKey))
+	m.Priority = 4
+	m.Actions[0].URL = "https://evil.example.com"
+	require.Equal(t, client.ErrSignatureInvalid, m.Verify(publicKey))
+
+	// Actions in the simple format cannot be signed
+	_, err = c.Publish("mytopic", "deployed", client.WithActions("view, Open, https://example.com"), client.WithSignature(key))
+	require.Error(t, err)
+}
+
+func TestClient_LoadSigningKey_Invalid(t *testing.T) {
+	keyFile, pubKeyFile := writeTestKeyPair(t)
+	_, err := client.LoadSigningKey(pubKeyFile)
+	require.Error(t, err)
+	_, err = client.LoadVerifyingKey(keyFile)
+	require.Error(t, err)
+	_, err = client.LoadVerifyingKey(filepath.Join(t.TempDir(), "does-not-exist.pem"))
+	require.Error(t, err)
+}
+
+func writeTestKeyPair(t *testing.T) (keyFile, pubKeyFile string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.Nil(t, err)
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.Nil(t, err)
+	keyFile, pubKeyFile = filepath.Join(t.TempDir(), "key.pem"), filepath.Join(t.TempDir(), "pubkey.pem")
+	require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}), 0600))
+	require.Nil(t, os.WriteFile(pubKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}), 0600))
+	return keyFile, pubKeyFile
+}
+
+func TestClient_EncryptDecrypt(t *testing.T) {
+	key, err := client.DeriveKey("s3cret", "https://ntfy.sh/mytopic")
+	require.Nil(t, err)
+	otherKey, err := client.DeriveKey("s3cret", "https://ntfy.sh/othertopic")
+	require.Nil(t, err)
+	require.NotEqual(t, key, otherKey)
+
+	ciphertext, err := client.Encrypt(key, []byte("hello"))
+	require.Nil(t, err)
+	plaintext, err := client.Decrypt(key, ciphertext)
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(plaintext))
+
+	_, err = client.Decrypt(otherKey, ciphertext)
+	require.Error(t, err)
+	_, err = client.Decrypt(key, "not base64!")
+	require.Error(t, err)
+}
@@ -18,17 +18,23 @@ type Config struct {
 	DefaultPassword *string     `yaml:"default-password"`
 	DefaultToken    string      `yaml:"default-token"`
 	DefaultCommand  string      `yaml:"default-command"`
+	Resolver        string      `yaml:"resolver"`          // DNS server or DNS-over-HTTPS URL, see newHTTPClient
+	Proxy           string      `yaml:"proxy"`             // HTTP or SOCKS5 proxy URL, see newHTTPClient
+	DownloadDir     string      `yaml:"download-dir"`      // Directory to automatically download attachments to ("ntfy subscribe")
+	DownloadMaxSize string      `yaml:"download-max-size"` // Max size of automatically downloaded attachments, e.g. 10M
+	DownloadKeep    string      `yaml:"download-keep"`     // Duration after which downloaded attachments are deleted, e.g. 7d
 	Subscribe       []Subscribe `yaml:"subscribe"`
 }
 
 // Subscribe is the struct for a Subscription within Config
 type Subscribe struct {
-	Topic    string            `yaml:"topic"`
-	User     *string           `yaml:"user"`
-	Password *string           `yaml:"password"`
-	Token    *string           `yaml:"token"`
-	Command  string            `yaml:"command"`
-	If       map[string]string `yaml:"if"`
+	Topic              string            `yaml:"topic"`
+	User               *string           `yaml:"user"`
+	Password           *string           `yaml:"password"`
+	Token              *string           `yaml:"token"`
+	EncryptionPassword *string           `yaml:"encryption-password"`
+	Command            string            `yaml:"command"`
+	If                 map[string]string `yaml:"if"`
 }
 
 // NewConfig creates a new Config struct for a Client
@@ -54,5 +60,15 @@ func LoadConfig(filename string) (*Config, error) {
 	if err := yaml.Unmarshal(b, c); err != nil {
 		return nil, err
 	}
+	if c.Resolver != "" {
+		if _, err := newResolver(c.Resolver); err != nil {
+			return nil, err
+		}
+	}
+	if c.Proxy != "" {
+		if _, err := parseProxy(c.Proxy); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
@@ -138,3 +138,27 @@ subscribe:
 	require.Nil(t, conf.Subscribe[0].Password)
 	require.Nil(t, conf.Subscribe[0].Token)
 }
+
+func TestConfig_Resolver(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte("resolver: https://1.1.1.1/dns-query\n"), 0600))
+	conf, err := client.LoadConfig(filename)
+	require.Nil(t, err)
+	require.Equal(t, "https://1.1.1.1/dns-query", conf.Resolver)
+
+	require.Nil(t, os.WriteFile(filename, []byte("resolver: http://1.1.1.1/dns-query\n"), 0600))
+	_, err = client.LoadConfig(filename)
+	require.NotNil(t, err)
+}
+
+func TestConfig_Proxy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte("proxy: socks5h://127.0.0.1:9050\n"), 0600))
+	conf, err := client.LoadConfig(filename)
+	require.Nil(t, err)
+	require.Equal(t, "socks5h://127.0.0.1:9050", conf.Proxy)
+
+	require.Nil(t, os.WriteFile(filename, []byte("proxy: 127.0.0.1:9050\n"), 0600))
+	_, err = client.LoadConfig(filename)
+	require.NotNil(t, err)
+}
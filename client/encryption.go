@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	// EncryptionAES256GCM is the encryption scheme used for end-to-end encrypted messages: the title and message
+	// are encrypted with AES-256-GCM, using a key derived from a password and the topic URL (see DeriveKey)
+	EncryptionAES256GCM = "aes256gcm"
+)
+
+const (
+	encryptionKeyIterations = 100000
+	encryptionKeyLength     = 32
+)
+
+var (
+	errEncryptionSchemeUnsupported = errors.New("unsupported encryption scheme")
+	errCiphertextInvalid           = errors.New("ciphertext invalid")
+)
+
+type encryptionPasswordKey struct{}
+
+// encryptedPayload is the plaintext of an encrypted message, i.e. the fields that are hidden from the server
+type encryptedPayload struct {
+	Title   string `json:"title,omitempty"`
+	Message string `json:"message"`
+}
+
+// WithEncryption encrypts the message end-to-end, so that the server cannot read the title and message.
+// The key is derived from the password and the topic URL, so subscribers must use the same password and
+// topic URL to decrypt the message (see Message.Decrypt). Other fields, such as priority and tags, are not encrypted.
+func WithEncryption(password string) PublishOption {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), encryptionPasswordKey{}, password))
+		return nil
+	}
+}
+
+// DeriveKey derives the AES-256 key used to encrypt and decrypt the messages of a topic from a password.
+// The topic URL is used as salt, so that the same password results in different keys for different topics.
+func DeriveKey(password, topicURL string) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, []byte(topicURL), encryptionKeyIterations, encryptionKeyLength)
+}
+
+// Encrypt encrypts the plaintext with AES-256-GCM, and returns the nonce and ciphertext as URL-safe base64
+func Encrypt(key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Decrypt decrypts a ciphertext created by Encrypt
+func Decrypt(key []byte, ciphertext string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(ciphertext)
+	if err != nil || len(b) < gcm.NonceSize() {
+		return nil, errCiphertextInvalid
+	}
+	plaintext, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errCiphertextInvalid
+	}
+	return plaintext, nil
+}
+
+// Decrypt decrypts the title and message of an end-to-end encrypted message in place, using the key derived from
+// the password and the message's topic URL. The raw JSON message is updated accordingly. If the message is not
+// encrypted, this is a no-op.
+func (m *Message) Decrypt(password string) error {
+	if m.Encryption == "" {
+		return nil
+	} else if m.Encryption != EncryptionAES256GCM {
+		return fmt.Errorf("%w: %s", errEncryptionSchemeUnsupported, m.Encryption)
+	}
+	key, err := DeriveKey(password, m.TopicURL)
+	if err != nil {
+		return err
+	}
+	plaintext, err := Decrypt(key, m.Message)
+	if err != nil {
+		return err
+	}
+	var payload encryptedPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return err
+	}
+	m.Title, m.Message, m.Encryption = payload.Title, payload.Message, ""
+	if m.Raw != "" {
+		var raw map[string]any
+		if err := json.Unmarshal([]byte(m.Raw), &raw); err != nil {
+			return err
+		}
+		raw["message"] = payload.Message
+		if payload.Title != "" {
+			raw["title"] = payload.Title
+		}
+		delete(raw, "encryption")
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return err
+		}
+		m.Raw = string(b)
+	}
+	return nil
+}
+
+// maybeEncryptRequest replaces the body, title and message of the request with the encrypted payload,
+// if WithEncryption was passed
+func maybeEncryptRequest(r *http.Request, topicURL string) error {
+	password, ok := r.Context().Value(encryptionPasswordKey{}).(string)
+	if !ok {
+		return nil
+	}
+	payload := &encryptedPayload{
+		Title:   r.Header.Get("X-Title"),
+		Message: r.Header.Get("X-Message"),
+	}
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		if len(body) > 0 {
+			payload.Message = string(body)
+		}
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	key, err := DeriveKey(password, topicURL)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := Encrypt(key, plaintext)
+	if err != nil {
+		return err
+	}
+	r.Header.Del("X-Title")
+	r.Header.Del("X-Message")
+	r.Header.Set("X-Encryption", EncryptionAES256GCM)
+	r.Body = io.NopCloser(bytes.NewReader([]byte(ciphertext)))
+	r.ContentLength = int64(len(ciphertext))
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
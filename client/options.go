@@ -51,6 +51,12 @@ func WithDelay(delay string) PublishOption {
 	return WithHeader("X-Delay", delay)
 }
 
+// WithCron instructs the server to send the message repeatedly, according to the given cron expression
+// (e.g. "*/5 * * * *" or "@daily"). See https://ntfy.sh/docs/publish/#recurring-messages for details.
+func WithCron(cron string) PublishOption {
+	return WithHeader("X-Cron", cron)
+}
+
 // WithClick makes the notification action open the given URL as opposed to entering the detail view
 func WithClick(url string) PublishOption {
 	return WithHeader("X-Click", url)
@@ -93,6 +99,16 @@ func WithEmail(email string) PublishOption {
 	return WithHeader("X-Email", email)
 }
 
+// WithChannels restricts the delivery channels the server uses for the message, e.g. "push,email" or "none"
+func WithChannels(channels string) PublishOption {
+	return WithHeader("X-Channels", channels)
+}
+
+// WithReplyTo marks the message as a reply to the message with the given ID, see threads and replies
+func WithReplyTo(messageID string) PublishOption {
+	return WithHeader("X-Reply-To", messageID)
+}
+
 // WithBasicAuth adds the Authorization header for basic auth to the request
 func WithBasicAuth(user, pass string) PublishOption {
 	return WithHeader("Authorization", util.BasicAuth(user, pass))
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+var errInvalidProxy = errors.New("invalid proxy, expected HTTP or SOCKS5 proxy URL (e.g. http://proxy.example.com:3128 or socks5h://127.0.0.1:9050)")
+
+// parseProxy parses a proxy URL (see Config.Proxy). Supported schemes are http, https, socks5 and socks5h.
+// With SOCKS5, host names are resolved by the proxy, so .onion addresses can be reached via Tor.
+func parseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Hostname() == "" || u.Port() == "" {
+		return nil, errInvalidProxy
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	default:
+		return nil, errInvalidProxy
+	}
+}
+
+// errorTransport is a http.RoundTripper that fails every request. It is used if the configured proxy is
+// invalid, to avoid silently falling back to a direct connection.
+type errorTransport struct {
+	err error
+}
+
+func (t *errorTransport) RoundTrip(_ *http.Request) (*http.Response, error) {
+	return nil, t.err
+}
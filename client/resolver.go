@@ -0,0 +1,166 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dnsDefaultPort      = "53"
+	dohContentType      = "application/dns-message"
+	dohMaxResponseSize  = 65535
+	resolverDialTimeout = 30 * time.Second
+)
+
+var errInvalidResolver = errors.New("invalid resolver, expected DNS server (e.g. 1.1.1.1 or 1.1.1.1:53) or DNS-over-HTTPS URL (e.g. https://1.1.1.1/dns-query)")
+
+// newHTTPClient returns the HTTP client used for all requests to the ntfy server. If a resolver is given
+// (see Config.Resolver), host names are resolved using this DNS server or DNS-over-HTTPS endpoint instead of
+// the system resolver. This is useful on networks with broken or captive DNS. If a proxy is given (see
+// Config.Proxy), all requests are sent via this proxy instead of the one defined in the environment.
+func newHTTPClient(resolver, proxy string) (*http.Client, error) {
+	if resolver == "" && proxy == "" {
+		return http.DefaultClient, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if resolver != "" {
+		r, err := newResolver(resolver)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{
+			Timeout:  resolverDialTimeout,
+			Resolver: r,
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	if proxy != "" {
+		u, err := parseProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// newResolver returns a Go resolver that sends all DNS queries to the given DNS server, or to the given
+// DNS-over-HTTPS endpoint (RFC 8484)
+func newResolver(resolver string) (*net.Resolver, error) {
+	if strings.HasPrefix(resolver, "https://") {
+		u, err := url.Parse(resolver)
+		if err != nil || u.Host == "" {
+			return nil, errInvalidResolver
+		}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return &dohConn{url: resolver, client: http.DefaultClient, ctx: ctx}, nil
+			},
+		}, nil
+	}
+	server := resolver
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, dnsDefaultPort)
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || host == "" || (net.ParseIP(host) == nil && strings.ContainsAny(host, ":/")) {
+		return nil, errInvalidResolver
+	} else if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return nil, errInvalidResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server) // Ignore the system's DNS server address
+		},
+	}, nil
+}
+
+// dohConn is a net.Conn that sends DNS queries to a DNS-over-HTTPS endpoint. Since it is not a net.PacketConn,
+// the Go resolver uses the TCP wire format, i.e. every message is prefixed with its length (two bytes). Each
+// query written to the connection is sent as an HTTP POST request, and the response is returned by Read.
+type dohConn struct {
+	url      string
+	client   *http.Client // Client used for DoH requests, using the system resolver to resolve the DoH host
+	ctx      context.Context
+	deadline time.Time
+	response bytes.Buffer
+	mu       sync.Mutex
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b[:2])) != len(b)-2 {
+		return 0, errors.New("unexpected DNS message framing")
+	}
+	c.mu.Lock()
+	ctx, deadline := c.ctx, c.deadline
+	c.mu.Unlock()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DNS-over-HTTPS request failed with status %d", resp.StatusCode)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxResponseSize+1))
+	if err != nil {
+		return 0, err
+	} else if len(answer) > dohMaxResponseSize {
+		return 0, errors.New("DNS-over-HTTPS response too large")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.response.Reset()
+	c.response.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+	c.response.Write(answer)
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.response.Read(b)
+}
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.url) }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
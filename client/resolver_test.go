@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_NewResolver_Invalid(t *testing.T) {
+	for _, resolver := range []string{"https://", "https:///dns-query", ":53", "1.1.1.1:53:53", "http://1.1.1.1/dns-query"} {
+		_, err := newResolver(resolver)
+		require.Equal(t, errInvalidResolver, err, resolver)
+	}
+	for _, resolver := range []string{"1.1.1.1", "1.1.1.1:5353", "[2606:4700:4700::1111]:53", "dns.example.com", "https://1.1.1.1/dns-query"} {
+		_, err := newResolver(resolver)
+		require.Nil(t, err, resolver)
+	}
+}
+
+func TestResolver_DNSServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(testDNSAnswer(buf[:n], net.IPv4(127, 0, 0, 1)), addr)
+		}
+	}()
+
+	// Publish to the test server via a host name that only our DNS server knows
+	var published string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		published = string(body)
+		w.Write([]byte(`{"id":"abc","event":"message","topic":"mytopic"}`))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	conf := NewConfig()
+	conf.DefaultHost = fmt.Sprintf("http://ntfy.resolver.test:%s", serverURL.Port())
+	conf.Resolver = conn.LocalAddr().String()
+	c := New(conf)
+	m, err := c.Publish("mytopic", "some message")
+	require.Nil(t, err)
+	require.Equal(t, "abc", m.ID)
+	require.Equal(t, "some message", published)
+}
+
+func TestResolver_DNSOverHTTPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohContentType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(testDNSAnswer(query, net.IPv4(10, 0, 0, 1)))
+	}))
+	defer server.Close()
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dohConn{url: server.URL, client: server.Client(), ctx: ctx}, nil
+		},
+	}
+	addrs, err := resolver.LookupHost(context.Background(), "ntfy.resolver.test")
+	require.Nil(t, err)
+	require.Equal(t, []string{"10.0.0.1"}, addrs)
+}
+
+func TestResolver_DNSOverHTTPS_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dohConn{url: server.URL, client: server.Client(), ctx: ctx}, nil
+		},
+	}
+	_, err := resolver.LookupHost(context.Background(), "ntfy.resolver.test")
+	require.NotNil(t, err)
+}
+
+// testDNSAnswer creates a response to the given DNS query: A queries are answered with the given IPv4 address,
+// all other queries (e.g. AAAA) with an empty answer.
+func testDNSAnswer(query []byte, ip net.IP) []byte {
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5 // Null label, type and class
+	qtype := binary.BigEndian.Uint16(query[end-4 : end-2])
+	answer := append([]byte{}, query[:end]...)
+	answer[2] |= 0x80 // Response flag
+	answer[3] = 0x80  // Recursion available, no error
+	if qtype != 1 {
+		return answer
+	}
+	binary.BigEndian.PutUint16(answer[6:8], 1) // Answer count
+	answer = append(answer, 0xc0, 12)          // Pointer to the name in the question
+	answer = binary.BigEndian.AppendUint16(answer, 1)
+	answer = binary.BigEndian.AppendUint16(answer, 1)
+	answer = binary.BigEndian.AppendUint32(answer, 60)
+	answer = binary.BigEndian.AppendUint16(answer, 4)
+	return append(answer, ip.To4()...)
+}
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+var (
+	// ErrSignatureMissing is returned by Message.Verify if the message is not signed
+	ErrSignatureMissing = errors.New("message is not signed")
+
+	// ErrSignatureInvalid is returned by Message.Verify if the signature does not match the message
+	ErrSignatureInvalid = errors.New("signature invalid")
+
+	errSigningKeyInvalid       = errors.New("invalid signing key, expected Ed25519 private key in PEM format (PKCS #8)")
+	errVerifyingKeyInvalid     = errors.New("invalid verification key, expected Ed25519 public key in PEM format (PKIX)")
+	errSignatureNotAllowed     = errors.New("signed messages cannot be combined with templates or file uploads")
+	errSignatureEmpty          = errors.New("cannot sign an empty message")
+	errSignatureActionsNotJSON = errors.New("signed messages require actions in JSON format")
+
+	// signatureRegex matches the X-Signature header, same as in server/server_signature.go
+	signatureRegex = regexp.MustCompile(`^t=([0-9]{1,12}),s=([-_A-Za-z0-9]{86})$`)
+)
+
+type signingKey struct{}
+
+// signedPayload is the data that is covered by the signature, i.e. the signed time and all fields that are set by the
+// publisher and delivered to subscribers. It must match the payload that the server verifies (see server.signedPayload).
+//
+// The canonical format is the JSON object of this struct, without any whitespace, with the fields in this exact order,
+// and with empty strings, 0 and [] for fields that are not set, e.g.:
+//
+//	{"time":1760572800,"topic":"deploys","title":"","message":"Deployed v1.2.3","priority":0,"tags":[],"click":"",
+//	"icon":"","actions":[],"attachments":[],"content_type":"","reply_to":"","encryption":""}
+type signedPayload struct {
+	Time        int64               `json:"time"`
+	Topic       string              `json:"topic"`
+	Title       string              `json:"title"`
+	Message     string              `json:"message"`
+	Priority    int                 `json:"priority"`
+	Tags        []string            `json:"tags"`
+	Click       string              `json:"click"`
+	Icon        string              `json:"icon"`
+	Actions     []*signedAction     `json:"actions"`
+	Attachments []*signedAttachment `json:"attachments"`
+	ContentType string              `json:"content_type"`
+	ReplyTo     string              `json:"reply_to"`
+	Encryption  string              `json:"encryption"`
+}
+
+// signedAction is an action button as covered by the signature, i.e. without the ID that the server generates
+type signedAction struct {
+	Action  string            `json:"action"`
+	Label   string            `json:"label"`
+	Clear   bool              `json:"clear"`
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Intent  string            `json:"intent,omitempty"`
+	Extras  map[string]string `json:"extras,omitempty"`
+}
+
+// signedAttachment is an external attachment (X-Attach) as covered by the signature
+type signedAttachment struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+func newSignedPayload(m *Message, signed int64) *signedPayload {
+	payload := &signedPayload{
+		Time:        signed,
+		Topic:       m.Topic,
+		Title:       m.Title,
+		Message:     m.Message,
+		Priority:    m.Priority,
+		Tags:        make([]string, 0),
+		Click:       m.Click,
+		Icon:        m.Icon,
+		Actions:     make([]*signedAction, 0),
+		Attachments: make([]*signedAttachment, 0),
+		ContentType: m.ContentType,
+		ReplyTo:     m.ReplyTo,
+		Encryption:  m.Encryption,
+	}
+	payload.Tags = append(payload.Tags, m.Tags...)
+	for _, a := range m.Actions {
+		payload.Actions = append(payload.Actions, &signedAction{
+			Action:  a.Action,
+			Label:   a.Label,
+			Clear:   a.Clear,
+			URL:     a.URL,
+			Method:  a.Method,
+			Headers: a.Headers,
+			Body:    a.Body,
+			Intent:  a.Intent,
+			Extras:  a.Extras,
+		})
+	}
+	attachments := m.Attachments
+	if len(attachments) == 0 && m.Attachment != nil {
+		attachments = []*Attachment{m.Attachment}
+	}
+	for _, a := range attachments {
+		payload.Attachments = append(payload.Attachments, &signedAttachment{URL: a.URL, Name: a.Name})
+	}
+	return payload
+}
+
+// WithSignature signs the message (topic, title, message, priority, tags, actions, ...) with the given Ed25519 private
+// key, and attaches the signed time and the detached signature in the X-Signature header. Subscribers can verify the signature with the public key (see Message.Verify).
+// If the message is also encrypted (see WithEncryption), the encrypted message is signed.
+func WithSignature(key ed25519.PrivateKey) PublishOption {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), signingKey{}, key))
+		return nil
+	}
+}
+
+// LoadSigningKey reads an Ed25519 private key from a PEM file, e.g. as generated by
+// "openssl genpkey -algorithm ed25519 -out key.pem"
+func LoadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errSigningKeyInvalid
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errSigningKeyInvalid
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errSigningKeyInvalid
+	}
+	return privateKey, nil
+}
+
+// LoadVerifyingKey reads an Ed25519 public key from a PEM file, e.g. as generated by
+// "openssl pkey -in key.pem -pubout -out pubkey.pem"
+func LoadVerifyingKey(filename string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errVerifyingKeyInvalid
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errVerifyingKeyInvalid
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errVerifyingKeyInvalid
+	}
+	return publicKey, nil
+}
+
+// Sign returns the X-Signature header value for the given message, i.e. the signed time and the signature of the
+// message fields as URL-safe base64, e.g. t=1760572800,s=p0Yl6Gv2... The message fields must be set as they are
+// delivered by the server, see signedPayload for the canonical format.
+func Sign(key ed25519.PrivateKey, m *Message, signed time.Time) (string, error) {
+	payload, err := json.Marshal(newSignedPayload(m, signed.Unix()))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("t=%d,s=%s", signed.Unix(), base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, payload))), nil
+}
+
+// Verify checks the signature of the message against the given public key. It returns ErrSignatureMissing if the
+// message is not signed, and ErrSignatureInvalid if the signature does not match the message. The signed time is
+// not checked, since the server rejects signatures that are too old when the message is published.
+// Encrypted messages must be verified before they are decrypted.
+func (m *Message) Verify(key ed25519.PublicKey) error {
+	if m.Signature == "" {
+		return ErrSignatureMissing
+	}
+	matches := signatureRegex.FindStringSubmatch(m.Signature)
+	if len(matches) != 3 {
+		return ErrSignatureInvalid
+	}
+	signed, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(matches[2])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return ErrSignatureInvalid
+	}
+	payload, err := json.Marshal(newSignedPayload(m, signed))
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// maybeSignRequest adds the X-Signature header to the request, if WithSignature was passed. The message fields are
+// read from the request headers and body, and normalized the same way the server does it, so that the signature
+// matches the published message.
+func maybeSignRequest(r *http.Request, topicURL string) error {
+	key, ok := r.Context().Value(signingKey{}).(ed25519.PrivateKey)
+	if !ok {
+		return nil
+	} else if r.Header.Get("X-Template") != "" || r.Header.Get("X-Filename") != "" {
+		return errSignatureNotAllowed
+	}
+	u, err := url.Parse(topicURL)
+	if err != nil {
+		return err
+	}
+	m := &Message{
+		Topic:      path.Base(u.Path),
+		Title:      strings.TrimSpace(r.Header.Get("X-Title")),
+		Message:    strings.ReplaceAll(strings.TrimSpace(r.Header.Get("X-Message")), "\\n", "\n"),
+		Tags:       util.Map(util.SplitNoEmpty(r.Header.Get("X-Tags"), ","), strings.TrimSpace),
+		Click:      strings.TrimSpace(r.Header.Get("X-Click")),
+		Icon:       strings.TrimSpace(r.Header.Get("X-Icon")),
+		ReplyTo:    strings.TrimSpace(r.Header.Get("X-Reply-To")),
+		Encryption: strings.ToLower(strings.TrimSpace(r.Header.Get("X-Encryption"))),
+	}
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) > 0 {
+			m.Message = strings.TrimSpace(string(body))
+		}
+	}
+	if m.Message == "" {
+		return errSignatureEmpty
+	}
+	if m.Priority, err = util.ParsePriority(r.Header.Get("X-Priority")); err != nil {
+		return err
+	}
+	if actions := strings.TrimSpace(r.Header.Get("X-Actions")); actions != "" {
+		if !strings.HasPrefix(actions, "[") {
+			return errSignatureActionsNotJSON
+		} else if err := json.Unmarshal([]byte(actions), &m.Actions); err != nil {
+			return fmt.Errorf("cannot sign message, invalid actions: %w", err)
+		}
+		for _, a := range m.Actions {
+			a.Action = strings.ToLower(a.Action)
+			a.Method = strings.ToUpper(a.Method)
+		}
+	}
+	for _, attachURL := range r.Header.Values("X-Attach") {
+		if attachURL = strings.TrimSpace(attachURL); attachURL != "" {
+			m.Attachments = append(m.Attachments, &Attachment{URL: attachURL, Name: attachmentName(attachURL)})
+		}
+	}
+	markdown := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Markdown")))
+	if markdown == "1" || markdown == "yes" || markdown == "true" || strings.ToLower(r.Header.Get("Content-Type")) == "text/markdown" {
+		m.ContentType = "text/markdown"
+	}
+	signature, err := Sign(key, m, time.Now())
+	if err != nil {
+		return fmt.Errorf("cannot sign message: %w", err)
+	}
+	r.Header.Set("X-Signature", signature)
+	return nil
+}
+
+// attachmentName returns the attachment name that the server derives from an attachment URL (X-Attach)
+func attachmentName(attachURL string) string {
+	u, err := url.Parse(attachURL)
+	if err != nil {
+		return "attachment"
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == "" {
+		return "attachment"
+	}
+	return name
+}
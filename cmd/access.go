@@ -5,6 +5,9 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/urfave/cli/v2"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
@@ -21,12 +24,15 @@ const (
 var flagsAccess = append(
 	append([]cli.Flag{}, flagsUser...),
 	&cli.BoolFlag{Name: "reset", Aliases: []string{"r"}, Usage: "reset access for user (and topic)"},
+	&cli.StringFlag{Name: "expires", Aliases: []string{"e"}, Usage: "access expires after duration or at time, e.g. 30d or \"tuesday, 8pm\""},
+	&cli.StringFlag{Name: "hours", Usage: "access is only valid during these hours of the day, e.g. 18:00-08:00"},
+	&cli.StringFlag{Name: "timezone", Usage: "time zone of --hours, e.g. Europe/Berlin (default: UTC)"},
 )
 
 var cmdAccess = &cli.Command{
 	Name:      "access",
 	Usage:     "Grant/revoke access to a topic, or show access",
-	UsageText: "ntfy access [USERNAME [TOPIC [PERMISSION]]]",
+	UsageText: "ntfy access [--expires=..] [--hours=..] [--timezone=..] [USERNAME|group:GROUP [TOPIC [PERMISSION]]]",
 	Flags:     flagsAccess,
 	Before:    initConfigFileInputSourceFunc("config", flagsAccess, initLogFunc),
 	Action:    execUserAccess,
@@ -44,10 +50,13 @@ Usage:
   ntfy access                            # Shows access control list (alias: 'ntfy user list')
   ntfy access USERNAME                   # Shows access control entries for USERNAME
   ntfy access USERNAME TOPIC PERMISSION  # Allow/deny access for USERNAME to TOPIC
+  ntfy access group:GROUP TOPIC PERMISSION  # Allow/deny access for all members of GROUP to TOPIC
 
 Arguments:
   USERNAME     an existing user, as created with 'ntfy user add', or "everyone"/"*"
                to define access rules for anonymous/unauthenticated clients
+  GROUP        an existing group, as created with 'ntfy group add'; the group's entries
+               apply to all of its members, unless a member has a matching entry of their own
   TOPIC        name of a topic with optional wildcards, e.g. "mytopic*"
   PERMISSION   one of the following:
                - read-write (alias: rw) 
@@ -55,15 +64,27 @@ Arguments:
                - write-only (aliases: write, wo)
                - deny (alias: none)
 
+Access control entries can optionally be restricted to a validity window. Outside the window,
+the entry is ignored, and the next matching entry (or the default access) applies:
+  --expires, -e   entry expires after a duration (e.g. 30d) or at a time (e.g. "tuesday, 8pm");
+                  expired entries are removed automatically
+  --hours         entry is only valid during these hours of the day, e.g. 09:00-17:00 or
+                  18:00-08:00 (spanning midnight)
+  --timezone      time zone of --hours, e.g. Europe/Berlin (default: UTC)
+
 Examples:
   ntfy access                        # Shows access control list (alias: 'ntfy user list')
   ntfy access phil                   # Shows access for user phil
   ntfy access phil mytopic rw        # Allow read-write access to mytopic for user phil
   ntfy access everyone mytopic rw    # Allow anonymous read-write access to mytopic
   ntfy access everyone "up*" write   # Allow anonymous write-only access to topics "up..." 
+  ntfy access group:devops "alerts-*" rw  # Allow read-write access to "alerts-..." for group devops
+  ntfy access -e 30d ben deploy rw   # Allow read-write access to deploy for 30 days
+  ntfy access --hours 18:00-08:00 --timezone Europe/Berlin ben alerts rw  # ... only outside of office hours
   ntfy access --reset                # Reset entire access control list
   ntfy access --reset phil           # Reset all access for user phil
   ntfy access --reset phil mytopic   # Reset access for user phil and topic mytopic
+  ntfy access --reset group:devops   # Reset all access for group devops
 `,
 }
 
@@ -82,6 +103,9 @@ func execUserAccess(c *cli.Context) error {
 	topic := c.Args().Get(1)
 	perms := c.Args().Get(2)
 	reset := c.Bool("reset")
+	if group, ok := strings.CutPrefix(username, user.GroupPrefix); ok {
+		return execGroupAccess(c, manager, group, topic, perms, reset)
+	}
 	if reset {
 		if perms != "" {
 			return errors.New("too many arguments, please check 'ntfy access --help' for usage details")
@@ -96,6 +120,50 @@ func execUserAccess(c *cli.Context) error {
 	return changeAccess(c, manager, username, topic, perms)
 }
 
+func execGroupAccess(c *cli.Context, manager *user.Manager, group, topic, perms string, reset bool) error {
+	if c.String("expires") != "" || c.String("hours") != "" || c.String("timezone") != "" {
+		return errors.New("--expires, --hours and --timezone cannot be used for groups")
+	}
+	g, err := manager.Group(group)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", group)
+	} else if err != nil {
+		return err
+	}
+	if reset {
+		if perms != "" {
+			return errors.New("too many arguments, please check 'ntfy access --help' for usage details")
+		} else if err := manager.ResetGroupAccess(group, topic); err != nil {
+			return err
+		}
+		if topic == "" {
+			fmt.Fprintf(c.App.Writer, "reset access for group %s\n\n", group)
+		} else {
+			fmt.Fprintf(c.App.Writer, "reset access for group %s and topic %s\n\n", group, topic)
+		}
+	} else if perms == "" {
+		if topic != "" {
+			return errors.New("invalid syntax, please check 'ntfy access --help' for usage details")
+		}
+	} else {
+		if !util.Contains([]string{"read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo", "none", "deny"}, perms) {
+			return errors.New("permission must be one of: read-write, read-only, write-only, or deny (or the aliases: read, ro, write, wo, none)")
+		}
+		permission, err := user.ParsePermission(perms)
+		if err != nil {
+			return err
+		} else if err := manager.AllowGroupAccess(group, topic, permission); err != nil {
+			return err
+		}
+		if permission == user.PermissionDenyAll {
+			fmt.Fprintf(c.App.Writer, "revoked all access to topic %s for group %s\n\n", topic, group)
+		} else {
+			fmt.Fprintf(c.App.Writer, "granted %s access to topic %s for group %s\n\n", permission, topic, group)
+		}
+	}
+	return showGroups(c, manager, []*user.Group{g})
+}
+
 func changeAccess(c *cli.Context, manager *user.Manager, username string, topic string, perms string) error {
 	if !util.Contains([]string{"", "read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo", "none", "deny"}, perms) {
 		return errors.New("permission must be one of: read-write, read-only, write-only, or deny (or the aliases: read, ro, write, wo, none)")
@@ -112,21 +180,68 @@ func changeAccess(c *cli.Context, manager *user.Manager, username string, topic
 	} else if u.Role == user.RoleAdmin {
 		return fmt.Errorf("user %s is an admin user, access control entries have no effect", username)
 	}
-	if err := manager.AllowAccess(username, topic, permission); err != nil {
+	window, err := parseGrantWindow(c)
+	if err != nil {
 		return err
 	}
+	if err := manager.AllowAccessWindow(username, topic, permission, window); err != nil {
+		return err
+	}
+	suffix := formatGrantWindow(window)
 	if permission.IsReadWrite() {
-		fmt.Fprintf(c.App.Writer, "granted read-write access to topic %s\n\n", topic)
+		fmt.Fprintf(c.App.Writer, "granted read-write access to topic %s%s\n\n", topic, suffix)
 	} else if permission.IsRead() {
-		fmt.Fprintf(c.App.Writer, "granted read-only access to topic %s\n\n", topic)
+		fmt.Fprintf(c.App.Writer, "granted read-only access to topic %s%s\n\n", topic, suffix)
 	} else if permission.IsWrite() {
-		fmt.Fprintf(c.App.Writer, "granted write-only access to topic %s\n\n", topic)
+		fmt.Fprintf(c.App.Writer, "granted write-only access to topic %s%s\n\n", topic, suffix)
 	} else {
-		fmt.Fprintf(c.App.Writer, "revoked all access to topic %s\n\n", topic)
+		fmt.Fprintf(c.App.Writer, "revoked all access to topic %s%s\n\n", topic, suffix)
 	}
 	return showUserAccess(c, manager, username)
 }
 
+func parseGrantWindow(c *cli.Context) (*user.GrantWindow, error) {
+	expiresStr, hours, timezone := c.String("expires"), c.String("hours"), c.String("timezone")
+	if expiresStr == "" && hours == "" && timezone == "" {
+		return nil, nil
+	} else if hours == "" && timezone != "" {
+		return nil, errors.New("--timezone can only be used with --hours")
+	}
+	window := &user.GrantWindow{
+		Hours:    hours,
+		Timezone: timezone,
+	}
+	if expiresStr != "" {
+		expires, err := util.ParseFutureTime(expiresStr, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		window.Expires = expires
+	}
+	if err := window.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid --hours or --timezone: %w", err)
+	}
+	return window, nil
+}
+
+func formatGrantWindow(window *user.GrantWindow) string {
+	if window == nil {
+		return ""
+	}
+	var parts []string
+	if !window.Expires.IsZero() {
+		parts = append(parts, fmt.Sprintf("until %s", window.Expires.Format(time.RFC822)))
+	}
+	if window.Hours != "" {
+		timezone := window.Timezone
+		if timezone == "" {
+			timezone = "UTC"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", window.Hours, timezone))
+	}
+	return fmt.Sprintf(" (%s)", strings.Join(parts, ", "))
+}
+
 func resetAccess(c *cli.Context, manager *user.Manager, username, topic string) error {
 	if username == "" {
 		return resetAllAccess(c, manager)
@@ -172,7 +287,14 @@ func showAllAccess(c *cli.Context, manager *user.Manager) error {
 	if err != nil {
 		return err
 	}
-	return showUsers(c, manager, users)
+	if err := showUsers(c, manager, users); err != nil {
+		return err
+	}
+	groups, err := manager.Groups()
+	if err != nil {
+		return err
+	}
+	return showGroups(c, manager, groups)
 }
 
 func showUserAccess(c *cli.Context, manager *user.Manager, username string) error {
@@ -204,9 +326,9 @@ func showUsers(c *cli.Context, manager *user.Manager, users []*user.User) error
 			fmt.Fprintf(c.App.Writer, "- read-write access to all topics (admin role)\n")
 		} else if len(grants) > 0 {
 			for _, grant := range grants {
-				grantProvisioned := ""
+				grantProvisioned := formatGrantWindow(grant.Window)
 				if grant.Provisioned {
-					grantProvisioned = " (server config)"
+					grantProvisioned += " (server config)"
 				}
 				if grant.Permission.IsReadWrite() {
 					fmt.Fprintf(c.App.Writer, "- read-write access to topic %s%s\n", grant.TopicPattern, grantProvisioned)
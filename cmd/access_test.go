@@ -87,3 +87,19 @@ func runAccessCommand(app *cli.App, conf *server.Config, args ...string) error {
 	}
 	return app.Run(append(userArgs, args...))
 }
+
+func TestCLI_Access_Grant_Window(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("benpass\nbenpass")
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+	require.Nil(t, runAccessCommand(app, conf, "--expires", "30d", "--hours", "18:00-08:00", "--timezone", "Europe/Berlin", "ben", "deploy", "rw"))
+	require.Error(t, runAccessCommand(app, conf, "--hours", "9-5", "ben", "deploy", "rw"))
+	require.Error(t, runAccessCommand(app, conf, "--timezone", "Europe/Berlin", "ben", "deploy", "rw"))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runAccessCommand(app, conf, "ben"))
+	require.Regexp(t, `- read-write access to topic deploy \(until \d\d \w+ \d\d \d\d:\d\d \w+, 18:00-08:00 Europe/Berlin\)`, stdout.String())
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/util"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands = append(commands, cmdAdmin)
+}
+
+var flagsAdmin = append(
+	append([]cli.Flag{}, flagsDefault...),
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG"}, Usage: "client config file"},
+	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] of an admin user"},
+	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token of an admin user"},
+	&cli.StringFlag{Name: "proxy", EnvVars: []string{"NTFY_PROXY"}, Usage: "HTTP or SOCKS5 proxy URL, e.g. socks5h://127.0.0.1:9050 for Tor"},
+)
+
+var cmdAdmin = &cli.Command{
+	Name:      "admin",
+	Usage:     "Manage topics of a ntfy server via the admin API",
+	UsageText: "ntfy admin topic [purge|close|reopen] TOPIC",
+	Category:  categoryClient,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "topic",
+			Usage:     "Purge, close or reopen a topic",
+			UsageText: "ntfy admin topic [purge|close|reopen] TOPIC",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "purge",
+					Usage:     "Delete all cached messages and attachments of a topic",
+					UsageText: "ntfy admin topic purge [OPTIONS..] TOPIC",
+					Action:    execAdminTopicPurge,
+					Flags:     flagsAdmin,
+					Before:    initLogFunc,
+					Description: `Delete all cached messages of a topic, including scheduled messages and attachments.
+
+Examples:
+  ntfy admin topic purge -u phil spam                             # Purge topic "spam" on the default host
+  ntfy admin topic purge -k tk_AgQd.. ntfy.example.com/spam       # Purge topic "spam" on ntfy.example.com`,
+				},
+				{
+					Name:      "close",
+					Usage:     "Disconnect all subscribers of a topic, and temporarily block publishing",
+					UsageText: "ntfy admin topic close [OPTIONS..] [--duration=<duration>] TOPIC",
+					Action:    execAdminTopicClose,
+					Flags: append(append([]cli.Flag{}, flagsAdmin...),
+						&cli.StringFlag{Name: "duration", Usage: "block publishing for this long (default: 1h, max: 30d)"},
+					),
+					Before: initLogFunc,
+					Description: `Disconnect all subscribers of a topic, and block publishing to it for a while (default: 1 hour).
+Subscribers may reconnect, but will not receive any new messages until the topic is reopened.
+
+Examples:
+  ntfy admin topic close -u phil spam                             # Close topic "spam" for 1 hour
+  ntfy admin topic close -u phil --duration=1d spam               # Close topic "spam" for 1 day`,
+				},
+				{
+					Name:      "reopen",
+					Usage:     "Allow publishing to a closed topic again",
+					UsageText: "ntfy admin topic reopen [OPTIONS..] TOPIC",
+					Action:    execAdminTopicReopen,
+					Flags:     flagsAdmin,
+					Before:    initLogFunc,
+				},
+			},
+		},
+	},
+	Description: `Manage topics of a ntfy server via its admin API, e.g. for incident response on abused topics.
+Unlike the other server commands (e.g. 'ntfy user'), these commands talk to a running server, and require
+the credentials of an admin user.
+
+Examples:
+  ntfy admin topic purge -u phil spam        # Delete all messages and attachments of topic "spam"
+  ntfy admin topic close -u phil spam        # Disconnect subscribers and block publishing for 1 hour
+  ntfy admin topic reopen -u phil spam       # Allow publishing to topic "spam" again
+
+` + clientCommandDescriptionSuffix,
+}
+
+func execAdminTopicPurge(c *cli.Context) error {
+	cl, topic, options, err := adminClient(c)
+	if err != nil {
+		return err
+	}
+	response, err := cl.PurgeTopic(topic, options...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "purged %d message(s) of topic %s\n", response.Messages, response.Topic)
+	return nil
+}
+
+func execAdminTopicClose(c *cli.Context) error {
+	cl, topic, options, err := adminClient(c)
+	if err != nil {
+		return err
+	}
+	response, err := cl.CloseTopic(topic, c.String("duration"), options...)
+	if err != nil {
+		return err
+	}
+	closedUntil := time.Unix(response.ClosedUntil, 0).Format(time.RFC3339)
+	fmt.Fprintf(c.App.Writer, "closed topic %s until %s, disconnected %d subscriber(s)\n", response.Topic, closedUntil, response.Subscribers)
+	return nil
+}
+
+func execAdminTopicReopen(c *cli.Context) error {
+	cl, topic, options, err := adminClient(c)
+	if err != nil {
+		return err
+	}
+	if err := cl.ReopenTopic(topic, options...); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "reopened topic %s\n", topic)
+	return nil
+}
+
+// adminClient returns the client, the topic argument, and the auth options for the admin commands. Like for
+// publishing, credentials are taken from --token or --user, or from the client config file.
+func adminClient(c *cli.Context) (*client.Client, string, []client.RequestOption, error) {
+	if c.NArg() != 1 {
+		return nil, "", nil, errors.New("must specify exactly one topic, type 'ntfy admin --help' for help")
+	}
+	conf, err := loadConfig(c)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	token, user := c.String("token"), c.String("user")
+	var options []client.RequestOption
+	if token != "" {
+		options = append(options, client.WithBearerAuth(token))
+	} else if user != "" {
+		var pass string
+		parts := strings.SplitN(user, ":", 2)
+		if len(parts) == 2 {
+			user = parts[0]
+			pass = parts[1]
+		} else {
+			fmt.Fprint(c.App.ErrWriter, "Enter Password: ")
+			p, err := util.ReadPassword(c.App.Reader)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			pass = string(p)
+			fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 20))
+		}
+		options = append(options, client.WithBasicAuth(user, pass))
+	} else if conf.DefaultToken != "" {
+		options = append(options, client.WithBearerAuth(conf.DefaultToken))
+	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
+		options = append(options, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
+	}
+	return client.New(conf), c.Args().Get(0), options, nil
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/test"
+)
+
+func TestCLI_Admin_Topic_PurgeCloseReopen(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/spam", port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "--role=admin", "phil"))
+
+	for i := 0; i < 2; i++ {
+		app, _, _, _ = newTestApp()
+		require.Nil(t, app.Run([]string{"ntfy", "publish", "-u", "phil:mypass", topic, "spam message"}))
+	}
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "admin", "topic", "purge", "-u", "phil:mypass", topic}))
+	require.Equal(t, "purged 2 message(s) of topic spam\n", stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "-u", "phil:mypass", topic}))
+	require.Empty(t, stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "admin", "topic", "close", "-u", "phil:mypass", "--duration", "10m", topic}))
+	require.Contains(t, stdout.String(), "closed topic spam until")
+
+	app, _, _, _ = newTestApp()
+	err := app.Run([]string{"ntfy", "publish", "-u", "phil:mypass", topic, "spam message"})
+	require.ErrorContains(t, err, "40308")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "admin", "topic", "reopen", "-u", "phil:mypass", topic}))
+	require.Equal(t, "reopened topic "+topic+"\n", stdout.String())
+
+	app, _, _, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "-u", "phil:mypass", topic, "spam message"}))
+
+	// Not an admin
+	app, stdin, _, _ = newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+	app, _, _, _ = newTestApp()
+	err = app.Run([]string{"ntfy", "admin", "topic", "purge", "-u", "ben:mypass", topic})
+	require.ErrorContains(t, err, "40101")
+}
@@ -0,0 +1,169 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdBan)
+}
+
+var flagsBan = append([]cli.Flag{}, flagsUser...)
+
+var cmdBan = &cli.Command{
+	Name:      "ban",
+	Usage:     "Ban IP addresses, users or tokens",
+	UsageText: "ntfy ban [list|add|remove] ...",
+	Flags:     flagsBan,
+	Before:    initConfigFileInputSourceFunc("config", flagsBan, initLogFunc),
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Aliases:   []string{"a"},
+			Usage:     "Bans an IP address or range, a user, or a token",
+			UsageText: "ntfy ban add [--expires=<duration>] [--reason=..] (ip|user|token) VALUE",
+			Action:    execBanAdd,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "expires", Aliases: []string{"e"}, Value: "", Usage: "ban expires after"},
+				&cli.StringFlag{Name: "reason", Aliases: []string{"r"}, Value: "", Usage: "reason for the ban (only logged, not shown to the client)"},
+			},
+			Description: `Ban an IP address or range, a user, or an access token.
+
+All requests from a banned IP range, user or token are rejected with "403 Forbidden", before
+any other processing. Banning a user also bans all of the user's tokens. If the IP range, user
+or token is already banned, the ban is replaced.
+
+Examples:
+  ntfy ban add ip 1.2.3.4                              # Ban a single IP address, forever
+  ntfy ban add ip 2001:db8::/32                        # Ban an IPv6 range
+  ntfy ban add --expires=1d --reason=spam user phil    # Ban user phil for a day
+  ntfy ban add token tk_th2srHVlxrANQHAso5t0HuQ1J1TjN  # Ban a single token
+`,
+		},
+		{
+			Name:      "remove",
+			Aliases:   []string{"del", "rm"},
+			Usage:     "Lifts a ban",
+			UsageText: "ntfy ban remove ID",
+			Action:    execBanDel,
+			Description: `Lift a ban, identified by its ID (see 'ntfy ban list').
+
+Example:
+  ntfy ban del ban_Wxb8tHgLHLsE
+`,
+		},
+		{
+			Name:    "list",
+			Aliases: []string{"l"},
+			Usage:   "Shows a list of bans",
+			Action:  execBanList,
+			Description: `Shows a list of all bans that have not expired.
+`,
+		},
+	},
+	Description: `Ban IP addresses or ranges, users, or access tokens.
+
+Requests from banned IP ranges, users and tokens are rejected early in the request path, and
+logged with the "ban" tag. Unlike external tools like fail2ban, bans understand ntfy users and
+tokens, and work behind a proxy (see 'trusted-proxies').
+
+This is a server-only command. It directly manages the user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined. The running server
+picks up changes within the manager interval (see 'manager-interval'). Bans added via the admin
+API (/v1/admin/bans) apply immediately.
+
+Examples:
+  ntfy ban add ip 1.2.3.0/24                    # Ban an IP range, forever
+  ntfy ban add --expires=12h user phil          # Ban user phil for 12 hours
+  ntfy ban list                                 # Show all bans
+  ntfy ban del ban_Wxb8tHgLHLsE                 # Lift a ban
+`,
+}
+
+func execBanAdd(c *cli.Context) error {
+	kind, value := user.BanKind(c.Args().Get(0)), c.Args().Get(1)
+	expiresStr, reason := c.String("expires"), c.String("reason")
+	if kind == "" || value == "" {
+		return errors.New("kind and value expected, type 'ntfy ban add --help' for help")
+	} else if !user.AllowedBanKind(kind) {
+		return fmt.Errorf("invalid kind %s, must be ip, user or token", kind)
+	}
+	expires := time.Unix(0, 0)
+	if expiresStr != "" {
+		var err error
+		expires, err = util.ParseFutureTime(expiresStr, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	ban, err := manager.AddBan(kind, value, reason, expires)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return fmt.Errorf("user %s does not exist", value)
+	} else if errors.Is(err, user.ErrInvalidArgument) {
+		return fmt.Errorf("invalid %s: %s", kind, value)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "%s %s banned (%s), %s\n", ban.Kind, ban.Value, ban.ID, banExpiresString(ban))
+	return nil
+}
+
+func execBanDel(c *cli.Context) error {
+	id := c.Args().Get(0)
+	if id == "" {
+		return errors.New("ban ID expected, type 'ntfy ban remove --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.RemoveBan(id); errors.Is(err, user.ErrBanNotFound) {
+		return fmt.Errorf("ban %s does not exist", id)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "ban %s removed\n", id)
+	return nil
+}
+
+func execBanList(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	bans, err := manager.Bans()
+	if err != nil {
+		return err
+	} else if len(bans) == 0 {
+		fmt.Fprintf(c.App.Writer, "no bans\n")
+		return nil
+	}
+	for _, ban := range bans {
+		var reason string
+		if ban.Reason != "" {
+			reason = fmt.Sprintf(", reason: %s", ban.Reason)
+		}
+		fmt.Fprintf(c.App.Writer, "- %s: %s %s, %s%s, created %s\n", ban.ID, ban.Kind, ban.Value, banExpiresString(ban), reason, ban.Created.Format(time.RFC822))
+	}
+	return nil
+}
+
+func banExpiresString(ban *user.Ban) string {
+	if ban.Expires.Unix() == 0 {
+		return "never expires"
+	}
+	return fmt.Sprintf("expires %s", ban.Expires.Format(time.RFC822))
+}
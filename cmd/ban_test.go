@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+)
+
+func TestCLI_Ban_AddListRemove(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runBanCommand(app, conf, "add", "ip", "1.2.3.4"))
+	require.Regexp(t, `ip 1.2.3.4/32 banned \(ban_\w+\), never expires`, stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runBanCommand(app, conf, "add", "--expires=1d", "--reason=spam", "user", "phil"))
+	require.Regexp(t, `user phil banned \(ban_\w+\), expires .+`, stdout.String())
+	banID := regexp.MustCompile(`ban_\w+`).FindString(stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runBanCommand(app, conf, "list"))
+	require.Regexp(t, `- ban_\w+: ip 1.2.3.4/32, never expires, created .+\n- ban_\w+: user phil, expires .+, reason: spam, created .+`, stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runBanCommand(app, conf, "remove", banID))
+	require.Equal(t, "ban "+banID+" removed\n", stdout.String())
+
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, runBanCommand(app, conf, "remove", banID), "ban "+banID+" does not exist")
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, runBanCommand(app, conf, "add", "user", "nope"), "user nope does not exist")
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, runBanCommand(app, conf, "add", "ip", "1.2.3"), "invalid ip: 1.2.3")
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, runBanCommand(app, conf, "add", "topic", "mytopic"), "invalid kind topic, must be ip, user or token")
+}
+
+func runBanCommand(app *cli.App, conf *server.Config, args ...string) error {
+	banArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"ban",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+	}
+	return app.Run(append(banArgs, args...))
+}
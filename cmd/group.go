@@ -0,0 +1,243 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/user"
+)
+
+func init() {
+	commands = append(commands, cmdGroup)
+}
+
+var flagsGroup = append([]cli.Flag{}, flagsUser...)
+
+var cmdGroup = &cli.Command{
+	Name:      "group",
+	Usage:     "Manage/show groups",
+	UsageText: "ntfy group [list|add|remove|add-member|remove-member] ...",
+	Flags:     flagsGroup,
+	Before:    initConfigFileInputSourceFunc("config", flagsGroup, initLogFunc),
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Aliases:   []string{"a"},
+			Usage:     "Adds a new group",
+			UsageText: "ntfy group add NAME [USERNAME...]",
+			Action:    execGroupAdd,
+			Description: `Add a new group to the ntfy user database, optionally with members.
+
+Access control entries of a group apply to all of its members. Use 'ntfy access group:NAME ...'
+to grant the group access to topics.
+
+Examples:
+  ntfy group add devops            # Add group "devops"
+  ntfy group add devops phil ben   # Add group "devops" with members phil and ben
+`,
+		},
+		{
+			Name:      "remove",
+			Aliases:   []string{"del", "rm"},
+			Usage:     "Removes a group",
+			UsageText: "ntfy group remove NAME",
+			Action:    execGroupDel,
+			Description: `Remove a group from the ntfy user database.
+
+This also removes the group's access control entries. The members themselves are not removed.
+
+Example:
+  ntfy group del devops
+`,
+		},
+		{
+			Name:      "add-member",
+			Aliases:   []string{"am"},
+			Usage:     "Adds users to a group",
+			UsageText: "ntfy group add-member NAME USERNAME...",
+			Action:    execGroupAddMember,
+			Description: `Add one or more users to a group.
+
+Example:
+  ntfy group add-member devops phil ben
+`,
+		},
+		{
+			Name:      "remove-member",
+			Aliases:   []string{"rm-member", "rmm"},
+			Usage:     "Removes users from a group",
+			UsageText: "ntfy group remove-member NAME USERNAME...",
+			Action:    execGroupRemoveMember,
+			Description: `Remove one or more users from a group.
+
+Example:
+  ntfy group remove-member devops ben
+`,
+		},
+		{
+			Name:    "list",
+			Aliases: []string{"l"},
+			Usage:   "Shows a list of groups",
+			Action:  execGroupList,
+			Description: `Shows a list of all groups, their members and access control entries.
+`,
+		},
+	},
+	Description: `Manage groups of the ntfy server.
+
+Groups are named sets of users, e.g. a team. Instead of granting topic access to every user of a
+team individually, you can grant access to the team's group using 'ntfy access group:NAME ...'.
+The group's access control entries apply to all of its members. A user's own entries take
+precedence over the entries of their groups, which in turn take precedence over the entries
+for everyone.
+
+This is a server-only command. It directly manages the user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Examples:
+  ntfy group add devops phil ben                # Add group "devops" with members phil and ben
+  ntfy access group:devops "alerts-*" rw        # Allow read-write access to "alerts-..." for the group
+  ntfy group add-member devops emma             # Add emma to the group
+  ntfy group remove-member devops ben           # Remove ben from the group
+  ntfy group list                               # Show all groups
+  ntfy group del devops                         # Remove the group and its access control entries
+`,
+}
+
+func execGroupAdd(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group name expected, type 'ntfy group add --help' for help")
+	} else if !user.AllowedGroup(name) {
+		return errors.New("group name must consist only of numbers, letters, dashes and underscores")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.AddGroup(name); errors.Is(err, user.ErrGroupExists) {
+		return fmt.Errorf("group %s already exists", name)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "group %s added\n", name)
+	for _, username := range c.Args().Slice()[1:] {
+		if err := addGroupMember(c, manager, name, username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execGroupDel(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group name expected, type 'ntfy group del --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.RemoveGroup(name); errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "group %s removed\n", name)
+	return nil
+}
+
+func execGroupAddMember(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("group name and username(s) expected, type 'ntfy group add-member --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	name := c.Args().Get(0)
+	for _, username := range c.Args().Slice()[1:] {
+		if err := addGroupMember(c, manager, name, username); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addGroupMember(c *cli.Context, manager *user.Manager, name, username string) error {
+	if err := manager.AddGroupMember(name, username); errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if errors.Is(err, user.ErrUserNotFound) || errors.Is(err, user.ErrInvalidArgument) {
+		return fmt.Errorf("user %s does not exist", username)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "user %s added to group %s\n", username, name)
+	return nil
+}
+
+func execGroupRemoveMember(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("group name and username(s) expected, type 'ntfy group remove-member --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	name := c.Args().Get(0)
+	for _, username := range c.Args().Slice()[1:] {
+		if err := manager.RemoveGroupMember(name, username); errors.Is(err, user.ErrGroupNotFound) {
+			return fmt.Errorf("group %s does not exist", name)
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "user %s removed from group %s\n", username, name)
+	}
+	return nil
+}
+
+func execGroupList(c *cli.Context) error {
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	groups, err := manager.Groups()
+	if err != nil {
+		return err
+	}
+	return showGroups(c, manager, groups)
+}
+
+func showGroups(c *cli.Context, manager *user.Manager, groups []*user.Group) error {
+	for _, group := range groups {
+		grants, err := manager.GroupGrants(group.Name)
+		if err != nil {
+			return err
+		}
+		members := "none"
+		if len(group.Members) > 0 {
+			members = strings.Join(group.Members, ", ")
+		}
+		fmt.Fprintf(c.App.Writer, "group %s (members: %s)\n", group.Name, members)
+		if len(grants) == 0 {
+			fmt.Fprintf(c.App.Writer, "- no topic-specific permissions\n")
+		}
+		for _, grant := range grants {
+			if grant.Permission.IsReadWrite() {
+				fmt.Fprintf(c.App.Writer, "- read-write access to topic %s\n", grant.TopicPattern)
+			} else if grant.Permission.IsRead() {
+				fmt.Fprintf(c.App.Writer, "- read-only access to topic %s\n", grant.TopicPattern)
+			} else if grant.Permission.IsWrite() {
+				fmt.Fprintf(c.App.Writer, "- write-only access to topic %s\n", grant.TopicPattern)
+			} else {
+				fmt.Fprintf(c.App.Writer, "- no access to topic %s\n", grant.TopicPattern)
+			}
+		}
+	}
+	return nil
+}
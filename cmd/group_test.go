@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+)
+
+func TestCLI_Group_AddListRemove(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("philpass\nphilpass\nbenpass\nbenpass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "add", "devops", "phil"))
+	require.Equal(t, "group devops added\nuser phil added to group devops\n", stdout.String())
+	require.EqualError(t, runGroupCommand(app, conf, "add", "devops"), "group devops already exists")
+	require.EqualError(t, runGroupCommand(app, conf, "add-member", "devops", "nobody"), "user nobody does not exist")
+	require.EqualError(t, runGroupCommand(app, conf, "add-member", "nogroup", "ben"), "group nogroup does not exist")
+	require.Nil(t, runGroupCommand(app, conf, "add-member", "devops", "ben"))
+	require.Nil(t, runAccessCommand(app, conf, "group:devops", "alerts-*", "rw"))
+	require.Error(t, runAccessCommand(app, conf, "--expires", "30d", "group:devops", "alerts-*", "rw"))
+	require.Error(t, runAccessCommand(app, conf, "group:nogroup", "alerts-*", "rw"))
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "list"))
+	require.Equal(t, "group devops (members: ben, phil)\n- read-write access to topic alerts-*\n", stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runAccessCommand(app, conf))
+	require.Contains(t, stdout.String(), "group devops (members: ben, phil)\n- read-write access to topic alerts-*\n")
+
+	// Group members can publish, others cannot
+	app, _, _, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "-u", "ben:benpass", fmt.Sprintf("http://127.0.0.1:%d/alerts-prod", port), "disk full"}))
+	require.Error(t, app.Run([]string{"ntfy", "publish", fmt.Sprintf("http://127.0.0.1:%d/alerts-prod", port), "disk full"}))
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "remove-member", "devops", "ben"))
+	require.Nil(t, runAccessCommand(app, conf, "--reset", "group:devops", "alerts-*"))
+	require.Contains(t, stdout.String(), "group devops (members: phil)\n- no topic-specific permissions\n")
+	require.Error(t, app.Run([]string{"ntfy", "publish", "-u", "phil:philpass", fmt.Sprintf("http://127.0.0.1:%d/alerts-prod", port), "disk full"}))
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "del", "devops"))
+	require.Equal(t, "group devops removed\n", stdout.String())
+	require.EqualError(t, runGroupCommand(app, conf, "del", "devops"), "group devops does not exist")
+}
+
+func runGroupCommand(app *cli.App, conf *server.Config, args ...string) error {
+	userArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"group",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+		"--auth-default-access=" + conf.AuthDefault.String(),
+	}
+	return app.Run(append(userArgs, args...))
+}
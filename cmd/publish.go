@@ -1,17 +1,21 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
 	"heckel.io/ntfy/v2/client"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
+	"heckel.io/ntfy/v2/util/sprig"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	texttemplate "text/template"
 	"time"
 )
 
@@ -27,17 +31,24 @@ var flagsPublish = append(
 	&cli.StringFlag{Name: "priority", Aliases: []string{"p"}, EnvVars: []string{"NTFY_PRIORITY"}, Usage: "priority of the message (1=min, 2=low, 3=default, 4=high, 5=max)"},
 	&cli.StringFlag{Name: "tags", Aliases: []string{"tag", "T"}, EnvVars: []string{"NTFY_TAGS"}, Usage: "comma separated list of tags and emojis"},
 	&cli.StringFlag{Name: "delay", Aliases: []string{"at", "in", "D"}, EnvVars: []string{"NTFY_DELAY"}, Usage: "delay/schedule message"},
+	&cli.StringFlag{Name: "cron", EnvVars: []string{"NTFY_CRON"}, Usage: "send message repeatedly, according to cron expression"},
 	&cli.StringFlag{Name: "click", Aliases: []string{"U"}, EnvVars: []string{"NTFY_CLICK"}, Usage: "URL to open when notification is clicked"},
 	&cli.StringFlag{Name: "icon", Aliases: []string{"i"}, EnvVars: []string{"NTFY_ICON"}, Usage: "URL to use as notification icon"},
 	&cli.StringFlag{Name: "actions", Aliases: []string{"A"}, EnvVars: []string{"NTFY_ACTIONS"}, Usage: "actions JSON array or simple definition"},
 	&cli.StringFlag{Name: "attach", Aliases: []string{"a"}, EnvVars: []string{"NTFY_ATTACH"}, Usage: "URL to send as an external attachment"},
 	&cli.BoolFlag{Name: "markdown", Aliases: []string{"md"}, EnvVars: []string{"NTFY_MARKDOWN"}, Usage: "Message is formatted as Markdown"},
 	&cli.StringFlag{Name: "template", Aliases: []string{"tpl"}, EnvVars: []string{"NTFY_TEMPLATE"}, Usage: "use templates to transform JSON message body"},
+	&cli.StringFlag{Name: "data", EnvVars: []string{"NTFY_DATA"}, Usage: "render --template as a local template file with this JSON data file"},
 	&cli.StringFlag{Name: "filename", Aliases: []string{"name", "n"}, EnvVars: []string{"NTFY_FILENAME"}, Usage: "filename for the attachment"},
 	&cli.StringFlag{Name: "file", Aliases: []string{"f"}, EnvVars: []string{"NTFY_FILE"}, Usage: "file to upload as an attachment"},
 	&cli.StringFlag{Name: "email", Aliases: []string{"mail", "e"}, EnvVars: []string{"NTFY_EMAIL"}, Usage: "also send to e-mail address"},
+	&cli.StringFlag{Name: "channels", EnvVars: []string{"NTFY_CHANNELS"}, Usage: "restrict delivery channels, e.g. push,email or none"},
+	&cli.StringFlag{Name: "reply-to", Aliases: []string{"reply_to"}, EnvVars: []string{"NTFY_REPLY_TO"}, Usage: "ID of the message this message is a reply to"},
+	&cli.StringFlag{Name: "encryption-password", Aliases: []string{"encryption_password", "E"}, EnvVars: []string{"NTFY_ENCRYPTION_PASSWORD"}, Usage: "encrypt title and message end-to-end using this password"},
+	&cli.StringFlag{Name: "sign", EnvVars: []string{"NTFY_SIGN"}, Usage: "sign the message (incl. title, priority, tags, actions, ...) with the Ed25519 private key in `FILE` (PEM)"},
 	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
 	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+	&cli.StringFlag{Name: "proxy", EnvVars: []string{"NTFY_PROXY"}, Usage: "HTTP or SOCKS5 proxy URL, e.g. socks5h://127.0.0.1:9050 for Tor"},
 	&cli.IntFlag{Name: "wait-pid", Aliases: []string{"wait_pid", "pid"}, EnvVars: []string{"NTFY_WAIT_PID"}, Usage: "wait until PID exits before publishing"},
 	&cli.BoolFlag{Name: "wait-cmd", Aliases: []string{"wait_cmd", "cmd", "done"}, EnvVars: []string{"NTFY_WAIT_CMD"}, Usage: "run command and wait until it finishes before publishing"},
 	&cli.BoolFlag{Name: "no-cache", Aliases: []string{"no_cache", "C"}, EnvVars: []string{"NTFY_NO_CACHE"}, Usage: "do not cache message server-side"},
@@ -65,13 +76,18 @@ Examples:
   ntfy pub --tags=warning,skull backups "Backups failed"  # Add tags/emojis to message
   ntfy pub --delay=10s delayed_topic Laterzz              # Delay message by 10s
   ntfy pub --at=8:30am delayed_topic Laterzz              # Send message at 8:30am
+  ntfy pub --cron='0 9 * * mon-fri' standup 'Standup!'    # Send message every weekday at 9am (UTC)
   ntfy pub -e phil@example.com alerts 'App is down!'      # Also send email to phil@example.com
   ntfy pub --click="https://reddit.com" redd 'New msg'    # Opens Reddit when notification is clicked
   ntfy pub --icon="http://some.tld/icon.png" 'Icon!'      # Send notification with custom icon
   ntfy pub --attach="http://some.tld/file.zip" files      # Send ZIP archive from URL as attachment
   ntfy pub --file=flower.jpg flowers 'Nice!'              # Send image.jpg as attachment
+  ntfy pub --reply-to=eaT3rjHv8l3i incidents 'Fixed'      # Reply to message eaT3rjHv8l3i (threads)
+  ntfy pub --template=disk.tmpl --data=disk.json alerts   # Render local template disk.tmpl with disk.json as message
   echo 'message' | ntfy publish mytopic                   # Send message from stdin
   ntfy pub -u phil:mypass secret Psst                     # Publish with username/password
+  ntfy pub -E mypassword secret 'Disk full'               # Encrypt message end-to-end, see 'ntfy sub -E'
+  ntfy pub --sign key.pem deploys 'Deployed v1.2'         # Sign message, see 'ntfy sub --verify'
   ntfy pub --wait-pid 1234 mytopic                        # Wait for process 1234 to exit before publishing
   ntfy pub --wait-cmd mytopic rsync -av ./ /tmp/a         # Run command and publish after it completes
   NTFY_USER=phil:mypass ntfy pub secret Psst              # Use env variables to set username/password
@@ -94,15 +110,21 @@ func execPublish(c *cli.Context) error {
 	priority := c.String("priority")
 	tags := c.String("tags")
 	delay := c.String("delay")
+	cron := c.String("cron")
 	click := c.String("click")
 	icon := c.String("icon")
 	actions := c.String("actions")
 	attach := c.String("attach")
 	markdown := c.Bool("markdown")
 	template := c.String("template")
+	dataFile := c.String("data")
 	filename := c.String("filename")
 	file := c.String("file")
 	email := c.String("email")
+	channels := c.String("channels")
+	replyTo := c.String("reply-to")
+	encryptionPassword := c.String("encryption-password")
+	signingKeyFile := c.String("sign")
 	user := c.String("user")
 	token := c.String("token")
 	noCache := c.Bool("no-cache")
@@ -113,6 +135,10 @@ func execPublish(c *cli.Context) error {
 	// Checks
 	if user != "" && token != "" {
 		return errors.New("cannot set both --user and --token")
+	} else if dataFile != "" && template == "" {
+		return errors.New("cannot set --data without --template")
+	} else if signingKeyFile != "" && (file != "" || (template != "" && dataFile == "")) {
+		return errors.New("cannot set --sign together with --file or a server-side --template")
 	}
 
 	// Do the things
@@ -120,6 +146,16 @@ func execPublish(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if dataFile != "" {
+		if message != "" || len(command) > 0 || pid > 0 {
+			return errors.New("cannot set a message, --wait-cmd or --wait-pid together with --data")
+		}
+		message, err = renderTemplateFile(template, dataFile)
+		if err != nil {
+			return err
+		}
+		template = "" // Rendered locally, not by the server
+	}
 	var options []client.PublishOption
 	if title != "" {
 		options = append(options, client.WithTitle(title))
@@ -133,6 +169,9 @@ func execPublish(c *cli.Context) error {
 	if delay != "" {
 		options = append(options, client.WithDelay(delay))
 	}
+	if cron != "" {
+		options = append(options, client.WithCron(cron))
+	}
 	if click != "" {
 		options = append(options, client.WithClick(click))
 	}
@@ -157,6 +196,22 @@ func execPublish(c *cli.Context) error {
 	if email != "" {
 		options = append(options, client.WithEmail(email))
 	}
+	if channels != "" {
+		options = append(options, client.WithChannels(channels))
+	}
+	if replyTo != "" {
+		options = append(options, client.WithReplyTo(replyTo))
+	}
+	if encryptionPassword != "" {
+		options = append(options, client.WithEncryption(encryptionPassword))
+	}
+	if signingKeyFile != "" {
+		key, err := client.LoadSigningKey(signingKeyFile)
+		if err != nil {
+			return err
+		}
+		options = append(options, client.WithSignature(key))
+	}
 	if noCache {
 		options = append(options, client.WithNoCache())
 	}
@@ -234,6 +289,32 @@ func execPublish(c *cli.Context) error {
 	return nil
 }
 
+// renderTemplateFile renders the local template file (Go template syntax, with Sprig functions) using the
+// values in the given JSON data file, and returns the result to be used as message
+func renderTemplateFile(templateFile, dataFile string) (string, error) {
+	tpl, err := os.ReadFile(templateFile)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(dataFile)
+	if err != nil {
+		return "", err
+	}
+	var values any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", fmt.Errorf("cannot parse data file %s: %w", dataFile, err)
+	}
+	t, err := texttemplate.New(filepath.Base(templateFile)).Funcs(sprig.TxtFuncMap()).Parse(string(tpl))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
 // parseTopicMessageCommand reads the topic and the remaining arguments from the context.
 
 // There are a few cases to consider:
@@ -88,6 +88,33 @@ func TestCLI_Publish_All_The_Things(t *testing.T) {
 	require.Equal(t, "https://ntfy.sh/static/img/ntfy.png", m.Icon)
 }
 
+func TestCLI_Publish_Template_Data(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	dir := t.TempDir()
+	templateFile, dataFile := filepath.Join(dir, "disk.tmpl"), filepath.Join(dir, "disk.json")
+	require.Nil(t, os.WriteFile(templateFile, []byte(`Disk {{ .mount }} on {{ .host | upper }} is {{ .used }}% full
+{{ range .top }}- {{ . }}
+{{ end }}`), 0644))
+	require.Nil(t, os.WriteFile(dataFile, []byte(`{"host":"nas","mount":"/data","used":93,"top":["backups","photos"]}`), 0644))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--template", templateFile, "--data", dataFile, "--title", "Disk", topic}))
+	m := toMessage(t, stdout.String())
+	require.Equal(t, "Disk /data on NAS is 93% full\n- backups\n- photos", m.Message)
+	require.Equal(t, "Disk", m.Title)
+
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "publish", "--data", dataFile, topic}), "cannot set --data without --template")
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "publish", "--template", templateFile, "--data", dataFile, topic, "some message"}), "cannot set a message")
+	require.Nil(t, os.WriteFile(dataFile, []byte(`not json`), 0644))
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "publish", "--template", templateFile, "--data", dataFile, topic}), "cannot parse data file")
+}
+
 func TestCLI_Publish_Wait_PID_And_Cmd(t *testing.T) {
 	s, port := test.StartServer(t)
 	defer test.StopServer(t, s, port)
@@ -175,6 +202,28 @@ default-password: mypass
 	require.Equal(t, "triggered", m.Message)
 }
 
+func TestCLI_Publish_Proxy_Overrides_Config(t *testing.T) {
+	message := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"triggered"}`
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "http://ntfy.proxy.test/mytopic", r.URL.String())
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(message))
+	}))
+	defer proxy.Close()
+
+	filename := filepath.Join(t.TempDir(), "client.yml")
+	require.Nil(t, os.WriteFile(filename, []byte(`
+default-host: http://ntfy.proxy.test
+proxy: socks5h://127.0.0.1:1
+`), 0600))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--config=" + filename, "--proxy=" + proxy.URL, "mytopic", "triggered"}))
+	m := toMessage(t, stdout.String())
+	require.Equal(t, "triggered", m.Message)
+}
+
 func TestCLI_Publish_Default_Token(t *testing.T) {
 	message := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"triggered"}`
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
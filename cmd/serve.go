@@ -3,18 +3,25 @@
 package cmd
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"math"
 	"net"
+	"net/mail"
 	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
@@ -29,6 +36,27 @@ func init() {
 	commands = append(commands, cmdServe)
 }
 
+// Limits imposed by Stripe on the invoice settings of a customer
+const (
+	stripeInvoiceFooterLimit      = 5000
+	stripeInvoiceCustomFieldLimit = 30
+	stripeInvoiceCustomFieldsMax  = 4
+	webAppNameLimit               = 32
+	templateLoopLimitMax          = 1_000_000
+	templateStringLimitMax        = 10_000_000
+	templateTimeoutMax            = 5 * time.Second
+)
+
+var (
+	experimentNameRegex          = regexp.MustCompile(`^[-_a-z0-9]{1,64}$`)
+	webAppAccentColorRegex       = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+	attachmentExpiryPatternRegex = regexp.MustCompile(`^(\.[a-z0-9][-_.a-z0-9]*|[a-z0-9][-+.a-z0-9]*/(\*|[a-z0-9][-+.a-z0-9]*))$`)
+	matrixRoomIDRegex            = regexp.MustCompile(`^![^:\s]+:[^\s]+$`)
+	matrixUserIDRegex            = regexp.MustCompile(`^@[^:\s]+:[^\s]+$`)
+	telegramChatIDRegex          = regexp.MustCompile(`^(-?[0-9]{1,20}|@[A-Za-z][_A-Za-z0-9]{4,31})$`)
+	countryCodeRegex             = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
 var flagsServe = append(
 	append([]cli.Flag{}, flagsDefault...),
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG_FILE"}, Value: server.DefaultConfigFile, Usage: "config file"},
@@ -37,13 +65,31 @@ var flagsServe = append(
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-https", Aliases: []string{"listen_https", "L"}, EnvVars: []string{"NTFY_LISTEN_HTTPS"}, Usage: "ip:port used as HTTPS listen address"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-unix", Aliases: []string{"listen_unix", "U"}, EnvVars: []string{"NTFY_LISTEN_UNIX"}, Usage: "listen on unix socket path"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "listen-unix-mode", Aliases: []string{"listen_unix_mode"}, EnvVars: []string{"NTFY_LISTEN_UNIX_MODE"}, DefaultText: "system default", Usage: "file permissions of unix socket, e.g. 0700"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-grpc", Aliases: []string{"listen_grpc"}, EnvVars: []string{"NTFY_LISTEN_GRPC"}, Usage: "ip:port used as gRPC listen address"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-tcp", Aliases: []string{"listen_tcp"}, EnvVars: []string{"NTFY_LISTEN_TCP"}, Usage: "ip:port used as listen address for the TCP line protocol ('topic:message' per line)"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "tcp-auth-rules", Aliases: []string{"tcp_auth_rules"}, EnvVars: []string{"NTFY_TCP_AUTH_RULES"}, Usage: "source IPs allowed to connect to listen-tcp, optionally with an access token, e.g. '10.0.0.0/8 tk_...' (can be repeated)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "listen-stomp", Aliases: []string{"listen_stomp"}, EnvVars: []string{"NTFY_LISTEN_STOMP"}, Usage: "ip:port used as listen address for the STOMP 1.2 protocol (SEND/SUBSCRIBE)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "key-file", Aliases: []string{"key_file", "K"}, EnvVars: []string{"NTFY_KEY_FILE"}, Usage: "private key file, if listen-https is set"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cert-file", Aliases: []string{"cert_file", "E"}, EnvVars: []string{"NTFY_CERT_FILE"}, Usage: "certificate file, if listen-https is set"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "acme-domains", Aliases: []string{"acme_domains"}, EnvVars: []string{"NTFY_ACME_DOMAINS"}, Usage: "domains to obtain TLS certificates for via ACME (e.g. Let's Encrypt), if listen-https is set"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "acme-email", Aliases: []string{"acme_email"}, EnvVars: []string{"NTFY_ACME_EMAIL"}, Usage: "contact e-mail address for the ACME account, used for certificate expiry notices"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "acme-cache-dir", Aliases: []string{"acme_cache_dir"}, EnvVars: []string{"NTFY_ACME_CACHE_DIR"}, Usage: "directory to store ACME account keys and certificates in"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "acme-directory-url", Aliases: []string{"acme_directory_url"}, EnvVars: []string{"NTFY_ACME_DIRECTORY_URL"}, Usage: "ACME directory URL, defaults to Let's Encrypt (e.g. use the Let's Encrypt staging URL for testing)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "firebase-key-file", Aliases: []string{"firebase_key_file", "F"}, EnvVars: []string{"NTFY_FIREBASE_KEY_FILE"}, Usage: "Firebase credentials file; if set additionally publish to FCM topic"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "apns-key-file", Aliases: []string{"apns_key_file"}, EnvVars: []string{"NTFY_APNS_KEY_FILE"}, Usage: "APNs token signing key (.p8); if set publish directly to registered iOS devices"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "apns-key-id", Aliases: []string{"apns_key_id"}, EnvVars: []string{"NTFY_APNS_KEY_ID"}, Usage: "key ID of the APNs signing key"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "apns-team-id", Aliases: []string{"apns_team_id"}, EnvVars: []string{"NTFY_APNS_TEAM_ID"}, Usage: "Apple developer team ID"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "apns-bundle-id", Aliases: []string{"apns_bundle_id"}, EnvVars: []string{"NTFY_APNS_BUNDLE_ID"}, Value: server.DefaultAPNSBundleID, Usage: "bundle ID of the iOS app, used as APNs topic"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "apns-sandbox", Aliases: []string{"apns_sandbox"}, EnvVars: []string{"NTFY_APNS_SANDBOX"}, Value: false, Usage: "use the APNs development environment (for debug builds of the iOS app)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "apns-file", Aliases: []string{"apns_file"}, EnvVars: []string{"NTFY_APNS_FILE"}, Usage: "file used to store APNs device tokens"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "apns-device-expiry-duration", Aliases: []string{"apns_device_expiry_duration"}, EnvVars: []string{"NTFY_APNS_DEVICE_EXPIRY_DURATION"}, Value: util.FormatDuration(server.DefaultAPNSDeviceExpiryDuration), Usage: "automatically remove devices that have not re-registered for this time"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-file", Aliases: []string{"cache_file", "C"}, EnvVars: []string{"NTFY_CACHE_FILE"}, Usage: "cache file used for message caching"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-duration", Aliases: []string{"cache_duration", "b"}, EnvVars: []string{"NTFY_CACHE_DURATION"}, Value: util.FormatDuration(server.DefaultCacheDuration), Usage: "buffer messages for this time to allow `since` requests"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "cache-batch-size", Aliases: []string{"cache_batch_size"}, EnvVars: []string{"NTFY_BATCH_SIZE"}, Usage: "max size of messages to batch together when writing to message cache (if zero, writes are synchronous)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-batch-timeout", Aliases: []string{"cache_batch_timeout"}, EnvVars: []string{"NTFY_CACHE_BATCH_TIMEOUT"}, Value: util.FormatDuration(server.DefaultCacheBatchTimeout), Usage: "timeout for batched async writes to the message cache (if zero, writes are synchronous)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-tombstone-duration", Aliases: []string{"cache_tombstone_duration"}, EnvVars: []string{"NTFY_CACHE_TOMBSTONE_DURATION"}, Value: util.FormatDuration(server.DefaultCacheTombstoneDuration), Usage: "keep tombstones of deleted messages for this time, so that polling clients can remove them (if zero, no tombstones are kept)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-encryption-key", Aliases: []string{"cache_encryption_key"}, EnvVars: []string{"NTFY_CACHE_ENCRYPTION_KEY"}, Usage: "base64-encoded 32-byte master key used to encrypt messages and attachments of cache-encrypted-topics"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "cache-encrypted-topics", Aliases: []string{"cache_encrypted_topics"}, EnvVars: []string{"NTFY_CACHE_ENCRYPTED_TOPICS"}, Usage: "topics whose messages and attachments are encrypted at rest"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-startup-queries", Aliases: []string{"cache_startup_queries"}, EnvVars: []string{"NTFY_CACHE_STARTUP_QUERIES"}, Usage: "queries run when the cache database is initialized"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-file", Aliases: []string{"auth_file", "H"}, EnvVars: []string{"NTFY_AUTH_FILE"}, Usage: "auth database file used for access control"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-startup-queries", Aliases: []string{"auth_startup_queries"}, EnvVars: []string{"NTFY_AUTH_STARTUP_QUERIES"}, Usage: "queries run when the auth database is initialized"}),
@@ -51,25 +97,54 @@ var flagsServe = append(
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-users", Aliases: []string{"auth_users"}, EnvVars: []string{"NTFY_AUTH_USERS"}, Usage: "pre-provisioned declarative users"}),
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-access", Aliases: []string{"auth_access"}, EnvVars: []string{"NTFY_AUTH_ACCESS"}, Usage: "pre-provisioned declarative access control entries"}),
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-tokens", Aliases: []string{"auth_tokens"}, EnvVars: []string{"NTFY_AUTH_TOKENS"}, Usage: "pre-provisioned declarative access tokens"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-webhook-url", Aliases: []string{"auth_webhook_url"}, EnvVars: []string{"NTFY_AUTH_WEBHOOK_URL"}, Usage: "external HTTP endpoint that decides whether users may access topics"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-webhook-timeout", Aliases: []string{"auth_webhook_timeout"}, EnvVars: []string{"NTFY_AUTH_WEBHOOK_TIMEOUT"}, Value: util.FormatDuration(server.DefaultAuthWebhookTimeout), Usage: "timeout for requests to the auth webhook, after which access is denied"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-webhook-cache-ttl", Aliases: []string{"auth_webhook_cache_ttl"}, EnvVars: []string{"NTFY_AUTH_WEBHOOK_CACHE_TTL"}, Value: util.FormatDuration(server.DefaultAuthWebhookCacheTTL), Usage: "duration for which auth webhook decisions are cached (0 to disable)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-session-token-duration", Aliases: []string{"auth_session_token_duration"}, EnvVars: []string{"NTFY_AUTH_SESSION_TOKEN_DURATION"}, Value: util.FormatDuration(server.DefaultAuthSessionTokenDuration), Usage: "lifetime of web app access tokens, which are renewed using a refresh token"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-session-refresh-duration", Aliases: []string{"auth_session_refresh_duration"}, EnvVars: []string{"NTFY_AUTH_SESSION_REFRESH_DURATION"}, Value: util.FormatDuration(server.DefaultAuthSessionRefreshDuration), Usage: "duration of inactivity after which web app sessions expire"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "auth-token-alerts", Aliases: []string{"auth_token_alerts"}, EnvVars: []string{"NTFY_AUTH_TOKEN_ALERTS"}, Value: false, Usage: "if set, notify users if one of their access tokens is used from a new country or network"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "prune-provisioned", Aliases: []string{"prune_provisioned"}, EnvVars: []string{"NTFY_PRUNE_PROVISIONED"}, Value: false, Usage: "remove users, access entries and tokens that are not in auth-users, auth-access or auth-tokens on startup"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-cache-dir", Aliases: []string{"attachment_cache_dir"}, EnvVars: []string{"NTFY_ATTACHMENT_CACHE_DIR"}, Usage: "cache directory for attached files"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-total-size-limit", Aliases: []string{"attachment_total_size_limit", "A"}, EnvVars: []string{"NTFY_ATTACHMENT_TOTAL_SIZE_LIMIT"}, Value: util.FormatSize(server.DefaultAttachmentTotalSizeLimit), Usage: "limit of the on-disk attachment cache"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-file-size-limit", Aliases: []string{"attachment_file_size_limit", "Y"}, EnvVars: []string{"NTFY_ATTACHMENT_FILE_SIZE_LIMIT"}, Value: util.FormatSize(server.DefaultAttachmentFileSizeLimit), Usage: "per-file attachment size limit (e.g. 300k, 2M, 100M)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-expiry-duration", Aliases: []string{"attachment_expiry_duration", "X"}, EnvVars: []string{"NTFY_ATTACHMENT_EXPIRY_DURATION"}, Value: util.FormatDuration(server.DefaultAttachmentExpiryDuration), Usage: "duration after which uploaded attachments will be deleted (e.g. 3h, 20h)"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "attachment-expiry-rules", Aliases: []string{"attachment_expiry_rules"}, EnvVars: []string{"NTFY_ATTACHMENT_EXPIRY_RULES"}, Usage: "attachment expiry duration by MIME type or file extension, e.g. 'image/* -> 3d' or '.log -> 12h'"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-scan-url", Aliases: []string{"attachment_scan_url"}, EnvVars: []string{"NTFY_ATTACHMENT_SCAN_URL"}, Usage: "clamd or ICAP server to scan attachments with, e.g. clamd:///run/clamav/clamd.ctl, clamd://127.0.0.1:3310 or icap://127.0.0.1:1344/avscan"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-scan-action", Aliases: []string{"attachment_scan_action"}, EnvVars: []string{"NTFY_ATTACHMENT_SCAN_ACTION"}, Value: server.AttachmentScanActionReject, Usage: "what to do with infected attachments, 'reject' or 'quarantine'"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-scan-timeout", Aliases: []string{"attachment_scan_timeout"}, EnvVars: []string{"NTFY_ATTACHMENT_SCAN_TIMEOUT"}, Value: util.FormatDuration(server.DefaultAttachmentScanTimeout), Usage: "timeout for scanning an attachment, after which the message is rejected"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-quarantine-dir", Aliases: []string{"attachment_quarantine_dir"}, EnvVars: []string{"NTFY_ATTACHMENT_QUARANTINE_DIR"}, Usage: "directory to move infected attachments to, if attachment-scan-action is 'quarantine'"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "template-dir", Aliases: []string{"template_dir"}, EnvVars: []string{"NTFY_TEMPLATE_DIR"}, Value: server.DefaultTemplateDir, Usage: "directory to load named message templates from"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "template-loop-limit", Aliases: []string{"template_loop_limit"}, EnvVars: []string{"NTFY_TEMPLATE_LOOP_LIMIT"}, Value: server.DefaultTemplateLoopLimit, Usage: "max number of loop iterations of template functions, e.g. repeat or until"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "template-string-limit", Aliases: []string{"template_string_limit"}, EnvVars: []string{"NTFY_TEMPLATE_STRING_LIMIT"}, Value: server.DefaultTemplateStringLimit, Usage: "max length of strings built by template functions, e.g. repeat"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "template-timeout", Aliases: []string{"template_timeout"}, EnvVars: []string{"NTFY_TEMPLATE_TIMEOUT"}, Value: server.DefaultTemplateTimeout.String(), Usage: "max time a template may take to render"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "keepalive-interval", Aliases: []string{"keepalive_interval", "k"}, EnvVars: []string{"NTFY_KEEPALIVE_INTERVAL"}, Value: util.FormatDuration(server.DefaultKeepaliveInterval), Usage: "interval of keepalive messages"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "websocket-compression", Aliases: []string{"websocket_compression"}, EnvVars: []string{"NTFY_WEBSOCKET_COMPRESSION"}, Value: false, Usage: "enable permessage-deflate compression for WebSocket subscribers that support it"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "websocket-compression-level", Aliases: []string{"websocket_compression_level"}, EnvVars: []string{"NTFY_WEBSOCKET_COMPRESSION_LEVEL"}, Value: server.DefaultWebSocketCompressionLevel, Usage: "WebSocket compression level, from 1 (fastest, least memory) to 9 (best compression)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "manager-interval", Aliases: []string{"manager_interval", "m"}, EnvVars: []string{"NTFY_MANAGER_INTERVAL"}, Value: util.FormatDuration(server.DefaultManagerInterval), Usage: "interval of for message pruning and stats printing"}),
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "disallowed-topics", Aliases: []string{"disallowed_topics"}, EnvVars: []string{"NTFY_DISALLOWED_TOPICS"}, Usage: "topics that are not allowed to be used"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "topic-content-types", Aliases: []string{"topic_content_types"}, EnvVars: []string{"NTFY_TOPIC_CONTENT_TYPES"}, Usage: "default content type per topic, e.g. 'mytopic:text/markdown'"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-root", Aliases: []string{"web_root"}, EnvVars: []string{"NTFY_WEB_ROOT"}, Value: "/", Usage: "sets root of the web app (e.g. /, or /app), or disables it (disable)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-app-name", Aliases: []string{"web_app_name"}, EnvVars: []string{"NTFY_WEB_APP_NAME"}, Usage: "name of the web app, shown in the title bar instead of 'ntfy'"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-app-logo-url", Aliases: []string{"web_app_logo_url"}, EnvVars: []string{"NTFY_WEB_APP_LOGO_URL"}, Usage: "URL or path of the logo shown in the web app title bar"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-app-accent-color", Aliases: []string{"web_app_accent_color"}, EnvVars: []string{"NTFY_WEB_APP_ACCENT_COLOR"}, Usage: "accent color of the web app, e.g. #338574"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-app-terms-url", Aliases: []string{"web_app_terms_url"}, EnvVars: []string{"NTFY_WEB_APP_TERMS_URL"}, Usage: "URL of the terms of service, linked in the web app"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-static-cache-control", Aliases: []string{"web_static_cache_control"}, EnvVars: []string{"NTFY_WEB_STATIC_CACHE_CONTROL"}, Value: server.DefaultWebStaticCacheControl, Usage: "Cache-Control header for static web app and docs files (except HTML pages), or 'none' to not send it"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-signup", Aliases: []string{"enable_signup"}, EnvVars: []string{"NTFY_ENABLE_SIGNUP"}, Value: false, Usage: "allows users to sign up via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-login", Aliases: []string{"enable_login"}, EnvVars: []string{"NTFY_ENABLE_LOGIN"}, Value: false, Usage: "allows users to log in via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-reservations", Aliases: []string{"enable_reservations"}, EnvVars: []string{"NTFY_ENABLE_RESERVATIONS"}, Value: false, Usage: "allows users to reserve topics (if their tier allows it)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "require-admin-webauthn", Aliases: []string{"require_admin_webauthn"}, EnvVars: []string{"NTFY_REQUIRE_ADMIN_WEBAUTHN"}, Value: false, Usage: "require a WebAuthn (security key/passkey) confirmation for destructive admin API operations"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-impersonation", Aliases: []string{"enable_impersonation"}, EnvVars: []string{"NTFY_ENABLE_IMPERSONATION"}, Value: false, Usage: "allows admins to act as other users via the X-Act-As header (when using an access token)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "account-email-fallback-duration", Aliases: []string{"account_email_fallback_duration"}, EnvVars: []string{"NTFY_ACCOUNT_EMAIL_FALLBACK_DURATION"}, Value: util.FormatDuration(server.DefaultAccountEmailFallbackDuration), Usage: "duration for which the previous email address of an account is kept after it was changed"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "require-login", Aliases: []string{"require_login"}, EnvVars: []string{"NTFY_REQUIRE_LOGIN"}, Value: false, Usage: "all actions via the web app requires a login"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-base-url", Aliases: []string{"upstream_base_url"}, EnvVars: []string{"NTFY_UPSTREAM_BASE_URL"}, Value: "", Usage: "forward poll request to an upstream server, this is needed for iOS push notifications for self-hosted servers"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "cluster-peers", Aliases: []string{"cluster_peers"}, EnvVars: []string{"NTFY_CLUSTER_PEERS"}, Usage: "base URLs of the other ntfy servers in the cluster, messages are replicated to them"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cluster-secret", Aliases: []string{"cluster_secret"}, EnvVars: []string{"NTFY_CLUSTER_SECRET"}, Value: "", Usage: "shared secret used to authenticate messages replicated between cluster peers"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-access-token", Aliases: []string{"upstream_access_token"}, EnvVars: []string{"NTFY_UPSTREAM_ACCESS_TOKEN"}, Value: "", Usage: "access token to use for the upstream server; needed only if upstream rate limits are exceeded or upstream server requires auth"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-addr", Aliases: []string{"smtp_sender_addr"}, EnvVars: []string{"NTFY_SMTP_SENDER_ADDR"}, Usage: "SMTP server address (host:port) for outgoing emails"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-user", Aliases: []string{"smtp_sender_user"}, EnvVars: []string{"NTFY_SMTP_SENDER_USER"}, Usage: "SMTP user (if e-mail sending is enabled)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-pass", Aliases: []string{"smtp_sender_pass"}, EnvVars: []string{"NTFY_SMTP_SENDER_PASS"}, Usage: "SMTP password (if e-mail sending is enabled)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-from", Aliases: []string{"smtp_sender_from"}, EnvVars: []string{"NTFY_SMTP_SENDER_FROM"}, Usage: "SMTP sender address (if e-mail sending is enabled)"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "smtp-sender-from-overrides", Aliases: []string{"smtp_sender_from_overrides"}, EnvVars: []string{"NTFY_SMTP_SENDER_FROM_OVERRIDES"}, Usage: "SMTP sender address per tier or user, e.g. 'tier:pro:alerts@example.com' or 'user:phil:phil@example.com'"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-listen", Aliases: []string{"smtp_server_listen"}, EnvVars: []string{"NTFY_SMTP_SERVER_LISTEN"}, Usage: "SMTP server address (ip:port) for incoming emails, e.g. :25"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-domain", Aliases: []string{"smtp_server_domain"}, EnvVars: []string{"NTFY_SMTP_SERVER_DOMAIN"}, Usage: "SMTP domain for incoming e-mail, e.g. ntfy.sh"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-addr-prefix", Aliases: []string{"smtp_server_addr_prefix"}, EnvVars: []string{"NTFY_SMTP_SERVER_ADDR_PREFIX"}, Usage: "SMTP email address prefix for topics to prevent spam (e.g. 'ntfy-')"}),
@@ -79,27 +154,68 @@ var flagsServe = append(
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-verify-service", Aliases: []string{"twilio_verify_service"}, EnvVars: []string{"NTFY_TWILIO_VERIFY_SERVICE"}, Usage: "Twilio Verify service ID, used for phone number verification"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "message-size-limit", Aliases: []string{"message_size_limit"}, EnvVars: []string{"NTFY_MESSAGE_SIZE_LIMIT"}, Value: util.FormatSize(server.DefaultMessageSizeLimit), Usage: "size limit for the message (see docs for limitations)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "message-delay-limit", Aliases: []string{"message_delay_limit"}, EnvVars: []string{"NTFY_MESSAGE_DELAY_LIMIT"}, Value: util.FormatDuration(server.DefaultMessageDelayMax), Usage: "max duration a message can be scheduled into the future"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "message-dedup-window", Aliases: []string{"message_dedup_window"}, EnvVars: []string{"NTFY_MESSAGE_DEDUP_WINDOW"}, Value: util.FormatDuration(server.DefaultMessageDedupWindow), Usage: "duration in which messages with the same dedup key are suppressed (0 to disable)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-link-previews", Aliases: []string{"enable_link_previews"}, EnvVars: []string{"NTFY_ENABLE_LINK_PREVIEWS"}, Value: false, Usage: "if set, Open Graph metadata of the first URL in a message is fetched and attached as a link preview"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-webhooks", Aliases: []string{"enable_webhooks"}, EnvVars: []string{"NTFY_ENABLE_WEBHOOKS"}, Value: false, Usage: "if set, owners of reserved topics can register outgoing webhooks that every message is POSTed to"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "webhook-allow-private-networks", Aliases: []string{"webhook_allow_private_networks"}, EnvVars: []string{"NTFY_WEBHOOK_ALLOW_PRIVATE_NETWORKS"}, Value: false, Usage: "if set, outgoing webhooks may target private and loopback addresses"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "webhook-tls", Aliases: []string{"webhook_tls"}, EnvVars: []string{"NTFY_WEBHOOK_TLS"}, Usage: "client certificate and/or CA bundle for outgoing webhooks to a host, e.g. 'hooks.example.com cert=/etc/ntfy/client.crt key=/etc/ntfy/client.key ca=/etc/ntfy/ca.pem' (can be repeated)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "matrix-bridge-homeserver", Aliases: []string{"matrix_bridge_homeserver"}, EnvVars: []string{"NTFY_MATRIX_BRIDGE_HOMESERVER"}, Usage: "base URL of the Matrix homeserver that messages are relayed to, e.g. https://matrix.example.com"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "matrix-bridge-token", Aliases: []string{"matrix_bridge_token"}, EnvVars: []string{"NTFY_MATRIX_BRIDGE_TOKEN"}, Usage: "access token of a Matrix bot account, or as_token of an application service"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "matrix-bridge-user-id", Aliases: []string{"matrix_bridge_user_id"}, EnvVars: []string{"NTFY_MATRIX_BRIDGE_USER_ID"}, Usage: "application service user that messages are sent as, e.g. @ntfy:example.com"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "matrix-bridge-rooms", Aliases: []string{"matrix_bridge_rooms"}, EnvVars: []string{"NTFY_MATRIX_BRIDGE_ROOMS"}, Usage: "relay messages of a topic to a Matrix room, e.g. 'alerts:!roomid:example.com' (can be repeated)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "telegram-bot-token", Aliases: []string{"telegram_bot_token"}, EnvVars: []string{"NTFY_TELEGRAM_BOT_TOKEN"}, Usage: "token of the Telegram bot that relays messages of the topics in telegram-relays"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "telegram-relays", Aliases: []string{"telegram_relays"}, EnvVars: []string{"NTFY_TELEGRAM_RELAYS"}, Usage: "relay messages of a topic to a Telegram chat, e.g. 'alerts:-1001234567890' (can be repeated)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-telegram-relays", Aliases: []string{"enable_telegram_relays"}, EnvVars: []string{"NTFY_ENABLE_TELEGRAM_RELAYS"}, Value: false, Usage: "if set, owners of reserved topics can relay messages to Telegram with their own bot"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "report-throttle-threshold", Aliases: []string{"report_throttle_threshold"}, EnvVars: []string{"NTFY_REPORT_THROTTLE_THRESHOLD"}, Value: 0, Usage: "number of distinct abuse reporters after which a topic is throttled (0 = never throttle)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "report-throttle-interval", Aliases: []string{"report_throttle_interval"}, EnvVars: []string{"NTFY_REPORT_THROTTLE_INTERVAL"}, Value: util.FormatDuration(server.DefaultReportThrottleInterval), Usage: "throttled topics can publish one message per interval"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "global-topic-limit", Aliases: []string{"global_topic_limit", "T"}, EnvVars: []string{"NTFY_GLOBAL_TOPIC_LIMIT"}, Value: server.DefaultTotalTopicLimit, Usage: "total number of topics allowed"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-subscription-limit", Aliases: []string{"visitor_subscription_limit"}, EnvVars: []string{"NTFY_VISITOR_SUBSCRIPTION_LIMIT"}, Value: server.DefaultVisitorSubscriptionLimit, Usage: "number of subscriptions per visitor"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-recurring-message-limit", Aliases: []string{"visitor_recurring_message_limit"}, EnvVars: []string{"NTFY_VISITOR_RECURRING_MESSAGE_LIMIT"}, Value: server.DefaultVisitorRecurringMessageLimit, Usage: "number of recurring (cron) messages per visitor"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "visitor-subscriber-rate-limiting", Aliases: []string{"visitor_subscriber_rate_limiting"}, EnvVars: []string{"NTFY_VISITOR_SUBSCRIBER_RATE_LIMITING"}, Value: false, Usage: "enables subscriber-based rate limiting"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "unifiedpush-app-request-limit-burst", Aliases: []string{"unifiedpush_app_request_limit_burst"}, EnvVars: []string{"NTFY_UNIFIEDPUSH_APP_REQUEST_LIMIT_BURST"}, Value: 0, Usage: "initial limit of requests per UnifiedPush app, replaces the subscriber's request and message limits if set (0 = disabled)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "unifiedpush-app-request-limit-replenish", Aliases: []string{"unifiedpush_app_request_limit_replenish"}, EnvVars: []string{"NTFY_UNIFIEDPUSH_APP_REQUEST_LIMIT_REPLENISH"}, Value: util.FormatDuration(server.DefaultVisitorRequestLimitReplenish), Usage: "interval at which the UnifiedPush app request limit is replenished (one per x)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "unifiedpush-app-message-daily-limit", Aliases: []string{"unifiedpush_app_message_daily_limit"}, EnvVars: []string{"NTFY_UNIFIEDPUSH_APP_MESSAGE_DAILY_LIMIT"}, Value: 0, Usage: "max messages per UnifiedPush app per day (0 = unlimited)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-limit-redis-url", Aliases: []string{"visitor_limit_redis_url"}, EnvVars: []string{"NTFY_VISITOR_LIMIT_REDIS_URL"}, Usage: "Redis URL (redis://... or rediss://...) to share request, message and email limits between multiple servers"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-attachment-total-size-limit", Aliases: []string{"visitor_attachment_total_size_limit"}, EnvVars: []string{"NTFY_VISITOR_ATTACHMENT_TOTAL_SIZE_LIMIT"}, Value: util.FormatSize(server.DefaultVisitorAttachmentTotalSizeLimit), Usage: "total storage limit used for attachments per visitor"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-attachment-daily-bandwidth-limit", Aliases: []string{"visitor_attachment_daily_bandwidth_limit"}, EnvVars: []string{"NTFY_VISITOR_ATTACHMENT_DAILY_BANDWIDTH_LIMIT"}, Value: "500M", Usage: "total daily attachment download/upload bandwidth limit per visitor"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "topic-attachment-daily-bandwidth-limit", Aliases: []string{"topic_attachment_daily_bandwidth_limit"}, EnvVars: []string{"NTFY_TOPIC_ATTACHMENT_DAILY_BANDWIDTH_LIMIT"}, Value: "0", Usage: "total daily attachment download bandwidth limit per topic (0 = unlimited)"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-request-limit-burst", Aliases: []string{"visitor_request_limit_burst"}, EnvVars: []string{"NTFY_VISITOR_REQUEST_LIMIT_BURST"}, Value: server.DefaultVisitorRequestLimitBurst, Usage: "initial limit of requests per visitor"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-request-limit-replenish", Aliases: []string{"visitor_request_limit_replenish"}, EnvVars: []string{"NTFY_VISITOR_REQUEST_LIMIT_REPLENISH"}, Value: util.FormatDuration(server.DefaultVisitorRequestLimitReplenish), Usage: "interval at which burst limit is replenished (one per x)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-request-limit-exempt-hosts", Aliases: []string{"visitor_request_limit_exempt_hosts"}, EnvVars: []string{"NTFY_VISITOR_REQUEST_LIMIT_EXEMPT_HOSTS"}, Value: "", Usage: "hostnames and/or IP addresses of hosts that will be exempt from the visitor request limit"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "visitor-request-limit-windows", Aliases: []string{"visitor_request_limit_windows"}, EnvVars: []string{"NTFY_VISITOR_REQUEST_LIMIT_WINDOWS"}, Usage: "daily time windows in which the request limits are multiplied by a factor, e.g. '02:00-04:00 -> 10'"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-message-daily-limit", Aliases: []string{"visitor_message_daily_limit"}, EnvVars: []string{"NTFY_VISITOR_MESSAGE_DAILY_LIMIT"}, Value: server.DefaultVisitorMessageDailyLimit, Usage: "max messages per visitor per day, derived from request limit if unset"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-email-limit-burst", Aliases: []string{"visitor_email_limit_burst"}, EnvVars: []string{"NTFY_VISITOR_EMAIL_LIMIT_BURST"}, Value: server.DefaultVisitorEmailLimitBurst, Usage: "initial limit of e-mails per visitor"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-email-limit-replenish", Aliases: []string{"visitor_email_limit_replenish"}, EnvVars: []string{"NTFY_VISITOR_EMAIL_LIMIT_REPLENISH"}, Value: util.FormatDuration(server.DefaultVisitorEmailLimitReplenish), Usage: "interval at which burst limit is replenished (one per x)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-stats-hourly-retention", Aliases: []string{"visitor_stats_hourly_retention"}, EnvVars: []string{"NTFY_VISITOR_STATS_HOURLY_RETENTION"}, Value: util.FormatDuration(server.DefaultVisitorStatsHourlyRetention), Usage: "duration for which hourly stats rollups are kept"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-stats-daily-retention", Aliases: []string{"visitor_stats_daily_retention"}, EnvVars: []string{"NTFY_VISITOR_STATS_DAILY_RETENTION"}, Value: util.FormatDuration(server.DefaultVisitorStatsDailyRetention), Usage: "duration for which daily stats rollups are kept"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-prefix-bits-ipv4", Aliases: []string{"visitor_prefix_bits_ipv4"}, EnvVars: []string{"NTFY_VISITOR_PREFIX_BITS_IPV4"}, Value: server.DefaultVisitorPrefixBitsIPv4, Usage: "number of bits of the IPv4 address to use for rate limiting (default: 32, full address)"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-prefix-bits-ipv6", Aliases: []string{"visitor_prefix_bits_ipv6"}, EnvVars: []string{"NTFY_VISITOR_PREFIX_BITS_IPV6"}, Value: server.DefaultVisitorPrefixBitsIPv6, Usage: "number of bits of the IPv6 address to use for rate limiting (default: 64, /64 subnet)"}),
-	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "behind-proxy", Aliases: []string{"behind_proxy", "P"}, EnvVars: []string{"NTFY_BEHIND_PROXY"}, Value: false, Usage: "if set, use forwarded header (e.g. X-Forwarded-For, X-Client-IP) to determine visitor IP address (for rate limiting)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "trusted-proxies", Aliases: []string{"trusted_proxies"}, EnvVars: []string{"NTFY_TRUSTED_PROXIES"}, Value: "", Usage: "comma-separated list of IP addresses, hosts, or CIDRs of trusted proxies; the forwarded header is only used for requests from these"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "behind-proxy", Aliases: []string{"behind_proxy", "P"}, EnvVars: []string{"NTFY_BEHIND_PROXY"}, Value: false, Usage: "deprecated, use trusted-proxies; if set, use forwarded header of any client to determine visitor IP address"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "proxy-forwarded-header", Aliases: []string{"proxy_forwarded_header"}, EnvVars: []string{"NTFY_PROXY_FORWARDED_HEADER"}, Value: "X-Forwarded-For", Usage: "use specified header to determine visitor IP address (for rate limiting)"}),
-	altsrc.NewStringFlag(&cli.StringFlag{Name: "proxy-trusted-hosts", Aliases: []string{"proxy_trusted_hosts"}, EnvVars: []string{"NTFY_PROXY_TRUSTED_HOSTS"}, Value: "", Usage: "comma-separated list of trusted IP addresses, hosts, or CIDRs to remove from forwarded header"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "proxy-trusted-hosts", Aliases: []string{"proxy_trusted_hosts"}, EnvVars: []string{"NTFY_PROXY_TRUSTED_HOSTS"}, Value: "", Usage: "deprecated, use trusted-proxies"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "geoip-database", Aliases: []string{"geoip_database"}, EnvVars: []string{"NTFY_GEOIP_DATABASE"}, Value: "", Usage: "MaxMind database file (e.g. GeoLite2-Country.mmdb) to look up the country of visitors"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "geoip-asn-database", Aliases: []string{"geoip_asn_database"}, EnvVars: []string{"NTFY_GEOIP_ASN_DATABASE"}, Value: "", Usage: "MaxMind ASN database file (GeoLite2-ASN.mmdb) to look up the network of visitors"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "geoip-blocked-countries", Aliases: []string{"geoip_blocked_countries"}, EnvVars: []string{"NTFY_GEOIP_BLOCKED_COUNTRIES"}, Value: "", Usage: "comma-separated list of ISO country codes from which all requests are rejected, e.g. 'KP,XY'"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "geoip-request-limit-factors", Aliases: []string{"geoip_request_limit_factors"}, EnvVars: []string{"NTFY_GEOIP_REQUEST_LIMIT_FACTORS"}, Usage: "factors by which the request limits of visitors from a country are multiplied, e.g. 'XY -> 0.2'"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "stripe-secret-key", Aliases: []string{"stripe_secret_key"}, EnvVars: []string{"NTFY_STRIPE_SECRET_KEY"}, Value: "", Usage: "key used for the Stripe API communication, this enables payments"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "stripe-webhook-key", Aliases: []string{"stripe_webhook_key"}, EnvVars: []string{"NTFY_STRIPE_WEBHOOK_KEY"}, Value: "", Usage: "key required to validate the authenticity of incoming webhooks from Stripe"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "stripe-automatic-tax", Aliases: []string{"stripe_automatic_tax"}, EnvVars: []string{"NTFY_STRIPE_AUTOMATIC_TAX"}, Value: true, Usage: "if set, Stripe calculates and collects taxes automatically during checkout"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "stripe-billing-address-collection", Aliases: []string{"stripe_billing_address_collection"}, EnvVars: []string{"NTFY_STRIPE_BILLING_ADDRESS_COLLECTION"}, Value: "auto", Usage: "whether Stripe collects the billing address during checkout ('auto' or 'required')"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "stripe-invoice-footer", Aliases: []string{"stripe_invoice_footer"}, EnvVars: []string{"NTFY_STRIPE_INVOICE_FOOTER"}, Value: "", Usage: "footer displayed on the Stripe invoices of all customers"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "stripe-payment-grace-period", Aliases: []string{"stripe_payment_grace_period"}, EnvVars: []string{"NTFY_STRIPE_PAYMENT_GRACE_PERIOD"}, Value: util.FormatDuration(server.DefaultStripePaymentGracePeriod), Usage: "duration for which users keep their tier after a failed payment, before they are downgraded"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "stripe-invoice-custom-fields", Aliases: []string{"stripe_invoice_custom_fields"}, EnvVars: []string{"NTFY_STRIPE_INVOICE_CUSTOM_FIELDS"}, Usage: "custom fields displayed on the Stripe invoices of all customers, e.g. 'VAT number: DE123456789'"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "billing-contact", Aliases: []string{"billing_contact"}, EnvVars: []string{"NTFY_BILLING_CONTACT"}, Value: "", Usage: "e-mail or website to display in upgrade dialog (only if payments are enabled)"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-metrics", Aliases: []string{"enable_metrics"}, EnvVars: []string{"NTFY_ENABLE_METRICS"}, Value: false, Usage: "if set, Prometheus metrics are exposed via the /metrics endpoint"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "metrics-listen-http", Aliases: []string{"metrics_listen_http"}, EnvVars: []string{"NTFY_METRICS_LISTEN_HTTP"}, Usage: "ip:port used to expose the metrics endpoint (implicitly enables metrics)"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "metrics-cardinality-limit", Aliases: []string{"metrics_cardinality_limit"}, EnvVars: []string{"NTFY_METRICS_CARDINALITY_LIMIT"}, Value: server.DefaultMetricsCardinalityLimit, Usage: "max number of topics/users with their own per-topic/per-user metrics (if zero, per-topic/per-user metrics are disabled)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "metrics-remote-write-url", Aliases: []string{"metrics_remote_write_url"}, EnvVars: []string{"NTFY_METRICS_REMOTE_WRITE_URL"}, Usage: "Prometheus remote write URL to periodically push metrics to"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "metrics-statsd-address", Aliases: []string{"metrics_statsd_address"}, EnvVars: []string{"NTFY_METRICS_STATSD_ADDRESS"}, Usage: "host:port of a StatsD/DogStatsD server to periodically push metrics to via UDP"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "metrics-push-interval", Aliases: []string{"metrics_push_interval"}, EnvVars: []string{"NTFY_METRICS_PUSH_INTERVAL"}, Value: util.FormatDuration(server.DefaultMetricsPushInterval), Usage: "interval in which metrics are pushed to metrics-remote-write-url and/or metrics-statsd-address"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "sentry-dsn", Aliases: []string{"sentry_dsn"}, EnvVars: []string{"NTFY_SENTRY_DSN"}, Usage: "Sentry DSN to report panics and internal server errors to (message contents are never reported)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "sentry-environment", Aliases: []string{"sentry_environment"}, EnvVars: []string{"NTFY_SENTRY_ENVIRONMENT"}, Usage: "environment name reported to Sentry, e.g. production or staging"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "access-log-file", Aliases: []string{"access_log_file"}, EnvVars: []string{"NTFY_ACCESS_LOG_FILE"}, Usage: "write one JSON line per HTTP request to this file, or to stdout if set to '-'"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "experiments", EnvVars: []string{"NTFY_EXPERIMENTS"}, Usage: "experimental features enabled for a percentage of users/visitors, e.g. 'new-web-ui:10'"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "profile-listen-http", Aliases: []string{"profile_listen_http"}, EnvVars: []string{"NTFY_PROFILE_LISTEN_HTTP"}, Usage: "ip:port used to expose the profiling endpoints (implicitly enables profiling)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-public-key", Aliases: []string{"web_push_public_key"}, EnvVars: []string{"NTFY_WEB_PUSH_PUBLIC_KEY"}, Usage: "public key used for web push notifications"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-private-key", Aliases: []string{"web_push_private_key"}, EnvVars: []string{"NTFY_WEB_PUSH_PRIVATE_KEY"}, Usage: "private key used for web push notifications"}),
@@ -132,17 +248,53 @@ func execServe(c *cli.Context) error {
 	if c.NArg() > 0 {
 		return errors.New("no arguments expected, see 'ntfy serve --help' for help")
 	}
+	conf, err := newServerConfig(c)
+	if err != nil {
+		return err
+	}
+
+	// Run server
+	s, err := server.New(conf)
+	if err != nil {
+		log.Fatal("%s", err.Error())
+	}
+
+	// Set up hot-reloading of config
+	go sigHandlerConfigReload(s, c)
+
+	if err := s.Run(); err != nil {
+		log.Fatal("%s", err.Error())
+	}
+	log.Info("Exiting.")
+	return nil
+}
 
+// newServerConfig reads and validates all options of the "serve" command, and converts them to a server.Config
+func newServerConfig(c *cli.Context) (*server.Config, error) {
 	// Read all the options
-	config := c.String("config")
 	baseURL := strings.TrimSuffix(c.String("base-url"), "/")
 	listenHTTP := c.String("listen-http")
 	listenHTTPS := c.String("listen-https")
+	listenGRPC := c.String("listen-grpc")
+	listenTCP := c.String("listen-tcp")
+	tcpAuthRulesRaw := c.StringSlice("tcp-auth-rules")
+	listenSTOMP := c.String("listen-stomp")
 	listenUnix := c.String("listen-unix")
 	listenUnixMode := c.Int("listen-unix-mode")
 	keyFile := c.String("key-file")
 	certFile := c.String("cert-file")
+	acmeDomains := c.StringSlice("acme-domains")
+	acmeEmail := c.String("acme-email")
+	acmeCacheDir := c.String("acme-cache-dir")
+	acmeDirectoryURL := c.String("acme-directory-url")
 	firebaseKeyFile := c.String("firebase-key-file")
+	apnsKeyFile := c.String("apns-key-file")
+	apnsKeyID := c.String("apns-key-id")
+	apnsTeamID := c.String("apns-team-id")
+	apnsBundleID := c.String("apns-bundle-id")
+	apnsSandbox := c.Bool("apns-sandbox")
+	apnsFile := c.String("apns-file")
+	apnsDeviceExpiryDurationStr := c.String("apns-device-expiry-duration")
 	webPushPrivateKey := c.String("web-push-private-key")
 	webPushPublicKey := c.String("web-push-public-key")
 	webPushFile := c.String("web-push-file")
@@ -152,34 +304,66 @@ func execServe(c *cli.Context) error {
 	webPushExpiryWarningDurationStr := c.String("web-push-expiry-warning-duration")
 	cacheFile := c.String("cache-file")
 	cacheDurationStr := c.String("cache-duration")
+	cacheEncryptionKeyStr := c.String("cache-encryption-key")
+	cacheEncryptedTopics := c.StringSlice("cache-encrypted-topics")
 	cacheStartupQueries := c.String("cache-startup-queries")
 	cacheBatchSize := c.Int("cache-batch-size")
 	cacheBatchTimeoutStr := c.String("cache-batch-timeout")
+	cacheTombstoneDurationStr := c.String("cache-tombstone-duration")
 	authFile := c.String("auth-file")
 	authStartupQueries := c.String("auth-startup-queries")
 	authDefaultAccess := c.String("auth-default-access")
 	authUsersRaw := c.StringSlice("auth-users")
 	authAccessRaw := c.StringSlice("auth-access")
 	authTokensRaw := c.StringSlice("auth-tokens")
+	pruneProvisioned := c.Bool("prune-provisioned")
+	authWebhookURL := c.String("auth-webhook-url")
+	authWebhookTimeoutStr := c.String("auth-webhook-timeout")
+	authWebhookCacheTTLStr := c.String("auth-webhook-cache-ttl")
+	authSessionTokenDurationStr := c.String("auth-session-token-duration")
+	authSessionRefreshDurationStr := c.String("auth-session-refresh-duration")
+	authTokenAlerts := c.Bool("auth-token-alerts")
 	attachmentCacheDir := c.String("attachment-cache-dir")
 	attachmentTotalSizeLimitStr := c.String("attachment-total-size-limit")
 	attachmentFileSizeLimitStr := c.String("attachment-file-size-limit")
 	attachmentExpiryDurationStr := c.String("attachment-expiry-duration")
+	attachmentExpiryRulesRaw := c.StringSlice("attachment-expiry-rules")
+	attachmentScanURL := c.String("attachment-scan-url")
+	attachmentScanAction := c.String("attachment-scan-action")
+	attachmentScanTimeoutStr := c.String("attachment-scan-timeout")
+	attachmentQuarantineDir := c.String("attachment-quarantine-dir")
 	templateDir := c.String("template-dir")
+	templateLoopLimit := c.Int("template-loop-limit")
+	templateStringLimit := c.Int("template-string-limit")
+	templateTimeoutStr := c.String("template-timeout")
 	keepaliveIntervalStr := c.String("keepalive-interval")
+	webSocketCompression := c.Bool("websocket-compression")
+	webSocketCompressionLevel := c.Int("websocket-compression-level")
 	managerIntervalStr := c.String("manager-interval")
 	disallowedTopics := c.StringSlice("disallowed-topics")
+	topicContentTypesRaw := c.StringSlice("topic-content-types")
 	webRoot := c.String("web-root")
+	webAppName := c.String("web-app-name")
+	webAppLogoURL := c.String("web-app-logo-url")
+	webAppAccentColor := c.String("web-app-accent-color")
+	webAppTermsURL := c.String("web-app-terms-url")
+	webStaticCacheControl := c.String("web-static-cache-control")
 	enableSignup := c.Bool("enable-signup")
 	enableLogin := c.Bool("enable-login")
 	requireLogin := c.Bool("require-login")
 	enableReservations := c.Bool("enable-reservations")
+	requireAdminWebAuthn := c.Bool("require-admin-webauthn")
+	enableImpersonation := c.Bool("enable-impersonation")
+	accountEmailFallbackDurationStr := c.String("account-email-fallback-duration")
 	upstreamBaseURL := c.String("upstream-base-url")
 	upstreamAccessToken := c.String("upstream-access-token")
+	clusterPeersRaw := c.StringSlice("cluster-peers")
+	clusterSecret := c.String("cluster-secret")
 	smtpSenderAddr := c.String("smtp-sender-addr")
 	smtpSenderUser := c.String("smtp-sender-user")
 	smtpSenderPass := c.String("smtp-sender-pass")
 	smtpSenderFrom := c.String("smtp-sender-from")
+	smtpSenderFromOverridesRaw := c.StringSlice("smtp-sender-from-overrides")
 	smtpServerListen := c.String("smtp-server-listen")
 	smtpServerDomain := c.String("smtp-server-domain")
 	smtpServerAddrPrefix := c.String("smtp-server-addr-prefix")
@@ -189,164 +373,382 @@ func execServe(c *cli.Context) error {
 	twilioVerifyService := c.String("twilio-verify-service")
 	messageSizeLimitStr := c.String("message-size-limit")
 	messageDelayLimitStr := c.String("message-delay-limit")
+	messageDedupWindowStr := c.String("message-dedup-window")
+	enableLinkPreviews := c.Bool("enable-link-previews")
+	enableWebhooks := c.Bool("enable-webhooks")
+	webhookAllowPrivateNetworks := c.Bool("webhook-allow-private-networks")
+	webhookTLSRaw := c.StringSlice("webhook-tls")
+	matrixBridgeHomeserver := c.String("matrix-bridge-homeserver")
+	matrixBridgeToken := c.String("matrix-bridge-token")
+	matrixBridgeUserID := c.String("matrix-bridge-user-id")
+	matrixBridgeRoomsRaw := c.StringSlice("matrix-bridge-rooms")
+	telegramBotToken := c.String("telegram-bot-token")
+	telegramRelaysRaw := c.StringSlice("telegram-relays")
+	enableTelegramRelays := c.Bool("enable-telegram-relays")
+	reportThrottleThreshold := c.Int("report-throttle-threshold")
+	reportThrottleIntervalStr := c.String("report-throttle-interval")
 	totalTopicLimit := c.Int("global-topic-limit")
 	visitorSubscriptionLimit := c.Int("visitor-subscription-limit")
+	visitorRecurringMessageLimit := c.Int("visitor-recurring-message-limit")
 	visitorSubscriberRateLimiting := c.Bool("visitor-subscriber-rate-limiting")
+	unifiedPushAppRequestLimitBurst := c.Int("unifiedpush-app-request-limit-burst")
+	unifiedPushAppRequestLimitReplenishStr := c.String("unifiedpush-app-request-limit-replenish")
+	unifiedPushAppMessageDailyLimit := c.Int("unifiedpush-app-message-daily-limit")
+	visitorLimitRedisURL := c.String("visitor-limit-redis-url")
 	visitorAttachmentTotalSizeLimitStr := c.String("visitor-attachment-total-size-limit")
 	visitorAttachmentDailyBandwidthLimitStr := c.String("visitor-attachment-daily-bandwidth-limit")
+	topicAttachmentDailyBandwidthLimitStr := c.String("topic-attachment-daily-bandwidth-limit")
 	visitorRequestLimitBurst := c.Int("visitor-request-limit-burst")
 	visitorRequestLimitReplenishStr := c.String("visitor-request-limit-replenish")
 	visitorRequestLimitExemptHosts := util.SplitNoEmpty(c.String("visitor-request-limit-exempt-hosts"), ",")
+	visitorRequestLimitWindowsRaw := c.StringSlice("visitor-request-limit-windows")
 	visitorMessageDailyLimit := c.Int("visitor-message-daily-limit")
 	visitorEmailLimitBurst := c.Int("visitor-email-limit-burst")
 	visitorEmailLimitReplenishStr := c.String("visitor-email-limit-replenish")
+	visitorStatsHourlyRetentionStr := c.String("visitor-stats-hourly-retention")
+	visitorStatsDailyRetentionStr := c.String("visitor-stats-daily-retention")
 	visitorPrefixBitsIPv4 := c.Int("visitor-prefix-bits-ipv4")
 	visitorPrefixBitsIPv6 := c.Int("visitor-prefix-bits-ipv6")
 	behindProxy := c.Bool("behind-proxy")
 	proxyForwardedHeader := c.String("proxy-forwarded-header")
-	proxyTrustedHosts := util.SplitNoEmpty(c.String("proxy-trusted-hosts"), ",")
+	proxyTrustedHosts := append(util.SplitNoEmpty(c.String("trusted-proxies"), ","), util.SplitNoEmpty(c.String("proxy-trusted-hosts"), ",")...)
+	geoIPDatabase := c.String("geoip-database")
+	geoIPASNDatabase := c.String("geoip-asn-database")
+	geoIPBlockedCountriesRaw := util.SplitNoEmpty(c.String("geoip-blocked-countries"), ",")
+	geoIPRequestLimitFactorsRaw := c.StringSlice("geoip-request-limit-factors")
 	stripeSecretKey := c.String("stripe-secret-key")
 	stripeWebhookKey := c.String("stripe-webhook-key")
+	stripeAutomaticTax := c.Bool("stripe-automatic-tax")
+	stripeBillingAddressCollection := c.String("stripe-billing-address-collection")
+	stripeInvoiceFooter := c.String("stripe-invoice-footer")
+	stripeInvoiceCustomFieldsRaw := c.StringSlice("stripe-invoice-custom-fields")
+	stripePaymentGracePeriodStr := c.String("stripe-payment-grace-period")
 	billingContact := c.String("billing-contact")
 	metricsListenHTTP := c.String("metrics-listen-http")
 	enableMetrics := c.Bool("enable-metrics") || metricsListenHTTP != ""
+	metricsCardinalityLimit := c.Int("metrics-cardinality-limit")
+	metricsRemoteWriteURL := c.String("metrics-remote-write-url")
+	metricsStatsdAddress := c.String("metrics-statsd-address")
+	metricsPushIntervalStr := c.String("metrics-push-interval")
+	sentryDSN := c.String("sentry-dsn")
+	sentryEnvironment := c.String("sentry-environment")
+	accessLogFile := c.String("access-log-file")
+	experimentsRaw := c.StringSlice("experiments")
 	profileListenHTTP := c.String("profile-listen-http")
 
 	// Convert durations
 	cacheDuration, err := util.ParseDuration(cacheDurationStr)
 	if err != nil {
-		return fmt.Errorf("invalid cache duration: %s", cacheDurationStr)
+		return nil, fmt.Errorf("invalid cache duration: %s", cacheDurationStr)
 	}
 	cacheBatchTimeout, err := util.ParseDuration(cacheBatchTimeoutStr)
 	if err != nil {
-		return fmt.Errorf("invalid cache batch timeout: %s", cacheBatchTimeoutStr)
+		return nil, fmt.Errorf("invalid cache batch timeout: %s", cacheBatchTimeoutStr)
+	}
+	cacheTombstoneDuration, err := util.ParseDuration(cacheTombstoneDurationStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache tombstone duration: %s", cacheTombstoneDurationStr)
 	}
 	attachmentExpiryDuration, err := util.ParseDuration(attachmentExpiryDurationStr)
 	if err != nil {
-		return fmt.Errorf("invalid attachment expiry duration: %s", attachmentExpiryDurationStr)
+		return nil, fmt.Errorf("invalid attachment expiry duration: %s", attachmentExpiryDurationStr)
+	}
+	attachmentScanTimeout, err := util.ParseDuration(attachmentScanTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attachment scan timeout: %s", attachmentScanTimeoutStr)
+	}
+	templateTimeout, err := util.ParseDuration(templateTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template timeout: %s", templateTimeoutStr)
+	}
+	authWebhookTimeout, err := util.ParseDuration(authWebhookTimeoutStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth webhook timeout: %s", authWebhookTimeoutStr)
+	}
+	authWebhookCacheTTL, err := util.ParseDuration(authWebhookCacheTTLStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth webhook cache TTL: %s", authWebhookCacheTTLStr)
+	}
+	authSessionTokenDuration, err := util.ParseDuration(authSessionTokenDurationStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth session token duration: %s", authSessionTokenDurationStr)
+	}
+	authSessionRefreshDuration, err := util.ParseDuration(authSessionRefreshDurationStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth session refresh duration: %s", authSessionRefreshDurationStr)
 	}
 	keepaliveInterval, err := util.ParseDuration(keepaliveIntervalStr)
 	if err != nil {
-		return fmt.Errorf("invalid keepalive interval: %s", keepaliveIntervalStr)
+		return nil, fmt.Errorf("invalid keepalive interval: %s", keepaliveIntervalStr)
 	}
 	managerInterval, err := util.ParseDuration(managerIntervalStr)
 	if err != nil {
-		return fmt.Errorf("invalid manager interval: %s", managerIntervalStr)
+		return nil, fmt.Errorf("invalid manager interval: %s", managerIntervalStr)
+	}
+	metricsPushInterval, err := util.ParseDuration(metricsPushIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics push interval: %s", metricsPushIntervalStr)
 	}
 	messageDelayLimit, err := util.ParseDuration(messageDelayLimitStr)
 	if err != nil {
-		return fmt.Errorf("invalid message delay limit: %s", messageDelayLimitStr)
+		return nil, fmt.Errorf("invalid message delay limit: %s", messageDelayLimitStr)
+	}
+	messageDedupWindow, err := util.ParseDuration(messageDedupWindowStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message dedup window: %s", messageDedupWindowStr)
+	}
+	reportThrottleInterval, err := util.ParseDuration(reportThrottleIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid report throttle interval: %s", reportThrottleIntervalStr)
 	}
 	visitorRequestLimitReplenish, err := util.ParseDuration(visitorRequestLimitReplenishStr)
 	if err != nil {
-		return fmt.Errorf("invalid visitor request limit replenish: %s", visitorRequestLimitReplenishStr)
+		return nil, fmt.Errorf("invalid visitor request limit replenish: %s", visitorRequestLimitReplenishStr)
+	}
+	unifiedPushAppRequestLimitReplenish, err := util.ParseDuration(unifiedPushAppRequestLimitReplenishStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UnifiedPush app request limit replenish: %s", unifiedPushAppRequestLimitReplenishStr)
 	}
 	visitorEmailLimitReplenish, err := util.ParseDuration(visitorEmailLimitReplenishStr)
 	if err != nil {
-		return fmt.Errorf("invalid visitor email limit replenish: %s", visitorEmailLimitReplenishStr)
+		return nil, fmt.Errorf("invalid visitor email limit replenish: %s", visitorEmailLimitReplenishStr)
+	}
+	accountEmailFallbackDuration, err := util.ParseDuration(accountEmailFallbackDurationStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account email fallback duration: %s", accountEmailFallbackDurationStr)
+	}
+	stripePaymentGracePeriod, err := util.ParseDuration(stripePaymentGracePeriodStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stripe payment grace period: %s", stripePaymentGracePeriodStr)
+	}
+	visitorStatsHourlyRetention, err := util.ParseDuration(visitorStatsHourlyRetentionStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid visitor stats hourly retention: %s", visitorStatsHourlyRetentionStr)
+	}
+	visitorStatsDailyRetention, err := util.ParseDuration(visitorStatsDailyRetentionStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid visitor stats daily retention: %s", visitorStatsDailyRetentionStr)
+	}
+	apnsDeviceExpiryDuration, err := util.ParseDuration(apnsDeviceExpiryDurationStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs device expiry duration: %s", apnsDeviceExpiryDurationStr)
 	}
 	webPushExpiryDuration, err := util.ParseDuration(webPushExpiryDurationStr)
 	if err != nil {
-		return fmt.Errorf("invalid web push expiry duration: %s", webPushExpiryDurationStr)
+		return nil, fmt.Errorf("invalid web push expiry duration: %s", webPushExpiryDurationStr)
 	}
 	webPushExpiryWarningDuration, err := util.ParseDuration(webPushExpiryWarningDurationStr)
 	if err != nil {
-		return fmt.Errorf("invalid web push expiry warning duration: %s", webPushExpiryWarningDurationStr)
+		return nil, fmt.Errorf("invalid web push expiry warning duration: %s", webPushExpiryWarningDurationStr)
 	}
 
 	// Convert sizes to bytes
 	messageSizeLimit, err := util.ParseSize(messageSizeLimitStr)
 	if err != nil {
-		return fmt.Errorf("invalid message size limit: %s", messageSizeLimitStr)
+		return nil, fmt.Errorf("invalid message size limit: %s", messageSizeLimitStr)
 	}
 	attachmentTotalSizeLimit, err := util.ParseSize(attachmentTotalSizeLimitStr)
 	if err != nil {
-		return fmt.Errorf("invalid attachment total size limit: %s", attachmentTotalSizeLimitStr)
+		return nil, fmt.Errorf("invalid attachment total size limit: %s", attachmentTotalSizeLimitStr)
 	}
 	attachmentFileSizeLimit, err := util.ParseSize(attachmentFileSizeLimitStr)
 	if err != nil {
-		return fmt.Errorf("invalid attachment file size limit: %s", attachmentFileSizeLimitStr)
+		return nil, fmt.Errorf("invalid attachment file size limit: %s", attachmentFileSizeLimitStr)
 	}
 	visitorAttachmentTotalSizeLimit, err := util.ParseSize(visitorAttachmentTotalSizeLimitStr)
 	if err != nil {
-		return fmt.Errorf("invalid visitor attachment total size limit: %s", visitorAttachmentTotalSizeLimitStr)
+		return nil, fmt.Errorf("invalid visitor attachment total size limit: %s", visitorAttachmentTotalSizeLimitStr)
 	}
 	visitorAttachmentDailyBandwidthLimit, err := util.ParseSize(visitorAttachmentDailyBandwidthLimitStr)
 	if err != nil {
-		return fmt.Errorf("invalid visitor attachment daily bandwidth limit: %s", visitorAttachmentDailyBandwidthLimitStr)
+		return nil, fmt.Errorf("invalid visitor attachment daily bandwidth limit: %s", visitorAttachmentDailyBandwidthLimitStr)
 	} else if visitorAttachmentDailyBandwidthLimit > math.MaxInt {
-		return fmt.Errorf("config option visitor-attachment-daily-bandwidth-limit must be lower than %d", math.MaxInt)
+		return nil, fmt.Errorf("config option visitor-attachment-daily-bandwidth-limit must be lower than %d", math.MaxInt)
+	}
+	topicAttachmentDailyBandwidthLimit, err := util.ParseSize(topicAttachmentDailyBandwidthLimitStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic attachment daily bandwidth limit: %s", topicAttachmentDailyBandwidthLimitStr)
+	} else if topicAttachmentDailyBandwidthLimit > math.MaxInt {
+		return nil, fmt.Errorf("config option topic-attachment-daily-bandwidth-limit must be lower than %d", math.MaxInt)
 	}
 
 	// Check values
 	if firebaseKeyFile != "" && !util.FileExists(firebaseKeyFile) {
-		return errors.New("if set, FCM key file must exist")
+		return nil, errors.New("if set, FCM key file must exist")
 	} else if firebaseKeyFile != "" && !server.FirebaseAvailable {
-		return errors.New("cannot set firebase-key-file, support for Firebase is not available (nofirebase)")
+		return nil, errors.New("cannot set firebase-key-file, support for Firebase is not available (nofirebase)")
+	} else if apnsKeyFile != "" && !util.FileExists(apnsKeyFile) {
+		return nil, errors.New("if set, APNs key file must exist")
+	} else if apnsKeyFile != "" && (apnsKeyID == "" || apnsTeamID == "" || apnsBundleID == "" || apnsFile == "") {
+		return nil, errors.New("if APNs is enabled, apns-key-file, apns-key-id, apns-team-id, apns-bundle-id and apns-file must be set")
+	} else if apnsDeviceExpiryDuration < 24*time.Hour {
+		return nil, errors.New("apns-device-expiry-duration cannot be lower than one day")
 	} else if webPushPublicKey != "" && (webPushPrivateKey == "" || webPushFile == "" || webPushEmailAddress == "" || baseURL == "") {
-		return errors.New("if web push is enabled, web-push-private-key, web-push-public-key, web-push-file, web-push-email-address, and base-url should be set. run 'ntfy webpush keys' to generate keys")
+		return nil, errors.New("if web push is enabled, web-push-private-key, web-push-public-key, web-push-file, web-push-email-address, and base-url should be set. run 'ntfy webpush keys' to generate keys")
+	} else if webSocketCompressionLevel < 1 || webSocketCompressionLevel > 9 {
+		return nil, errors.New("websocket-compression-level must be between 1 and 9")
+	} else if templateLoopLimit < 1 || templateLoopLimit > templateLoopLimitMax {
+		return nil, fmt.Errorf("template-loop-limit must be between 1 and %d", templateLoopLimitMax)
+	} else if templateStringLimit < 1 || templateStringLimit > templateStringLimitMax {
+		return nil, fmt.Errorf("template-string-limit must be between 1 and %d", templateStringLimitMax)
+	} else if templateTimeout < time.Millisecond || templateTimeout > templateTimeoutMax {
+		return nil, fmt.Errorf("template-timeout must be between 1ms and %s", templateTimeoutMax)
+	} else if authWebhookURL != "" && authFile == "" {
+		return nil, errors.New("if auth-webhook-url is set, auth-file must also be set")
+	} else if authWebhookURL != "" && !strings.HasPrefix(authWebhookURL, "http://") && !strings.HasPrefix(authWebhookURL, "https://") {
+		return nil, errors.New("if set, auth-webhook-url must start with http:// or https://")
+	} else if authWebhookTimeout <= 0 {
+		return nil, errors.New("auth-webhook-timeout must be positive")
+	} else if authSessionTokenDuration < 20*time.Minute {
+		return nil, errors.New("auth-session-token-duration cannot be lower than 20 minutes, the web app refreshes its token every 15 minutes")
+	} else if authSessionRefreshDuration <= authSessionTokenDuration {
+		return nil, errors.New("auth-session-refresh-duration must be longer than auth-session-token-duration")
 	} else if keepaliveInterval < 5*time.Second {
-		return errors.New("keepalive interval cannot be lower than five seconds")
+		return nil, errors.New("keepalive interval cannot be lower than five seconds")
 	} else if managerInterval < 5*time.Second {
-		return errors.New("manager interval cannot be lower than five seconds")
+		return nil, errors.New("manager interval cannot be lower than five seconds")
 	} else if cacheDuration > 0 && cacheDuration < managerInterval {
-		return errors.New("cache duration cannot be lower than manager interval")
+		return nil, errors.New("cache duration cannot be lower than manager interval")
+	} else if len(cacheEncryptedTopics) > 0 && cacheEncryptionKeyStr == "" {
+		return nil, errors.New("if cache-encrypted-topics is set, cache-encryption-key must also be set")
 	} else if keyFile != "" && !util.FileExists(keyFile) {
-		return errors.New("if set, key file must exist")
+		return nil, errors.New("if set, key file must exist")
 	} else if certFile != "" && !util.FileExists(certFile) {
-		return errors.New("if set, certificate file must exist")
-	} else if listenHTTPS != "" && (keyFile == "" || certFile == "") {
-		return errors.New("if listen-https is set, both key-file and cert-file must be set")
+		return nil, errors.New("if set, certificate file must exist")
+	} else if len(acmeDomains) > 0 && (keyFile != "" || certFile != "") {
+		return nil, errors.New("if acme-domains is set, key-file and cert-file must not be set")
+	} else if len(acmeDomains) > 0 && (listenHTTPS == "" || acmeCacheDir == "") {
+		return nil, errors.New("if acme-domains is set, listen-https and acme-cache-dir must also be set")
+	} else if len(acmeDomains) == 0 && (acmeEmail != "" || acmeCacheDir != "" || acmeDirectoryURL != "") {
+		return nil, errors.New("if acme-email, acme-cache-dir or acme-directory-url are set, acme-domains must also be set")
+	} else if listenHTTPS != "" && len(acmeDomains) == 0 && (keyFile == "" || certFile == "") {
+		return nil, errors.New("if listen-https is set, both key-file and cert-file (or acme-domains) must be set")
 	} else if smtpSenderAddr != "" && (baseURL == "" || smtpSenderFrom == "") {
-		return errors.New("if smtp-sender-addr is set, base-url, and smtp-sender-from must also be set")
+		return nil, errors.New("if smtp-sender-addr is set, base-url, and smtp-sender-from must also be set")
+	} else if len(smtpSenderFromOverridesRaw) > 0 && smtpSenderAddr == "" {
+		return nil, errors.New("if smtp-sender-from-overrides is set, smtp-sender-addr must also be set")
 	} else if smtpServerListen != "" && smtpServerDomain == "" {
-		return errors.New("if smtp-server-listen is set, smtp-server-domain must also be set")
+		return nil, errors.New("if smtp-server-listen is set, smtp-server-domain must also be set")
 	} else if attachmentCacheDir != "" && baseURL == "" {
-		return errors.New("if attachment-cache-dir is set, base-url must also be set")
+		return nil, errors.New("if attachment-cache-dir is set, base-url must also be set")
+	} else if attachmentScanURL != "" && attachmentCacheDir == "" {
+		return nil, errors.New("if attachment-scan-url is set, attachment-cache-dir must also be set")
+	} else if attachmentScanAction != server.AttachmentScanActionReject && attachmentScanAction != server.AttachmentScanActionQuarantine {
+		return nil, errors.New("attachment-scan-action must be 'reject' or 'quarantine'")
+	} else if attachmentScanAction == server.AttachmentScanActionQuarantine && attachmentQuarantineDir == "" {
+		return nil, errors.New("if attachment-scan-action is 'quarantine', attachment-quarantine-dir must also be set")
+	} else if attachmentScanTimeout <= 0 {
+		return nil, errors.New("attachment-scan-timeout must be positive")
 	} else if baseURL != "" {
 		u, err := url.Parse(baseURL)
 		if err != nil {
-			return fmt.Errorf("if set, base-url must be a valid URL, e.g. https://ntfy.mydomain.com: %v", err)
+			return nil, fmt.Errorf("if set, base-url must be a valid URL, e.g. https://ntfy.mydomain.com: %v", err)
 		} else if u.Scheme != "http" && u.Scheme != "https" {
-			return errors.New("if set, base-url must be a valid URL starting with http:// or https://, e.g. https://ntfy.mydomain.com")
+			return nil, errors.New("if set, base-url must be a valid URL starting with http:// or https://, e.g. https://ntfy.mydomain.com")
 		} else if u.Path != "" {
-			return fmt.Errorf("if set, base-url must not have a path (%s), as hosting ntfy on a sub-path is not supported, e.g. https://ntfy.mydomain.com", u.Path)
+			return nil, fmt.Errorf("if set, base-url must not have a path (%s), as hosting ntfy on a sub-path is not supported, e.g. https://ntfy.mydomain.com", u.Path)
 		}
 	} else if upstreamBaseURL != "" && !strings.HasPrefix(upstreamBaseURL, "http://") && !strings.HasPrefix(upstreamBaseURL, "https://") {
-		return errors.New("if set, upstream-base-url must start with http:// or https://")
+		return nil, errors.New("if set, upstream-base-url must start with http:// or https://")
 	} else if upstreamBaseURL != "" && strings.HasSuffix(upstreamBaseURL, "/") {
-		return errors.New("if set, upstream-base-url must not end with a slash (/)")
+		return nil, errors.New("if set, upstream-base-url must not end with a slash (/)")
 	} else if upstreamBaseURL != "" && baseURL == "" {
-		return errors.New("if upstream-base-url is set, base-url must also be set")
+		return nil, errors.New("if upstream-base-url is set, base-url must also be set")
 	} else if upstreamBaseURL != "" && baseURL != "" && baseURL == upstreamBaseURL {
-		return errors.New("base-url and upstream-base-url cannot be identical, you'll likely want to set upstream-base-url to https://ntfy.sh, see https://ntfy.sh/docs/config/#ios-instant-notifications")
+		return nil, errors.New("base-url and upstream-base-url cannot be identical, you'll likely want to set upstream-base-url to https://ntfy.sh, see https://ntfy.sh/docs/config/#ios-instant-notifications")
+	} else if len(clusterPeersRaw) > 0 && clusterSecret == "" {
+		return nil, errors.New("if cluster-peers is set, cluster-secret must also be set")
 	} else if authFile == "" && (enableSignup || enableLogin || requireLogin || enableReservations || stripeSecretKey != "") {
-		return errors.New("cannot set enable-signup, enable-login, require-login, enable-reserve-topics, or stripe-secret-key if auth-file is not set")
+		return nil, errors.New("cannot set enable-signup, enable-login, require-login, enable-reserve-topics, or stripe-secret-key if auth-file is not set")
+	} else if pruneProvisioned && (authFile == "" || (len(authUsersRaw) == 0 && len(authAccessRaw) == 0 && len(authTokensRaw) == 0)) {
+		return nil, errors.New("cannot set prune-provisioned if auth-file is not set, or if no auth-users, auth-access or auth-tokens are defined")
+	} else if pruneProvisioned && enableSignup {
+		return nil, errors.New("cannot set prune-provisioned if enable-signup is set, since users who signed up would be removed on restart")
+	} else if enableWebhooks && (authFile == "" || !enableReservations) {
+		return nil, errors.New("if enable-webhooks is set, auth-file and enable-reservations must also be set")
+	} else if len(webhookTLSRaw) > 0 && !enableWebhooks {
+		return nil, errors.New("if webhook-tls is set, enable-webhooks must also be set")
+	} else if reportThrottleThreshold > 0 && reportThrottleInterval <= 0 {
+		return nil, errors.New("if report-throttle-threshold is set, report-throttle-interval must be positive")
+	} else if len(matrixBridgeRoomsRaw) > 0 && (matrixBridgeHomeserver == "" || matrixBridgeToken == "") {
+		return nil, errors.New("if matrix-bridge-rooms is set, matrix-bridge-homeserver and matrix-bridge-token must also be set")
+	} else if matrixBridgeHomeserver != "" && !strings.HasPrefix(matrixBridgeHomeserver, "http://") && !strings.HasPrefix(matrixBridgeHomeserver, "https://") {
+		return nil, errors.New("if set, matrix-bridge-homeserver must start with http:// or https://")
+	} else if matrixBridgeUserID != "" && !matrixUserIDRegex.MatchString(matrixBridgeUserID) {
+		return nil, errors.New("if set, matrix-bridge-user-id must be a Matrix user ID, e.g. @ntfy:example.com")
+	} else if len(telegramRelaysRaw) > 0 && telegramBotToken == "" {
+		return nil, errors.New("if telegram-relays is set, telegram-bot-token must also be set")
+	} else if enableTelegramRelays && (authFile == "" || !enableReservations) {
+		return nil, errors.New("if enable-telegram-relays is set, auth-file and enable-reservations must also be set")
+	} else if enableImpersonation && authFile == "" {
+		return nil, errors.New("cannot set enable-impersonation if auth-file is not set")
+	} else if requireAdminWebAuthn && (authFile == "" || baseURL == "") {
+		return nil, errors.New("if require-admin-webauthn is set, auth-file and base-url must also be set")
 	} else if enableSignup && !enableLogin {
-		return errors.New("cannot set enable-signup without also setting enable-login")
+		return nil, errors.New("cannot set enable-signup without also setting enable-login")
 	} else if requireLogin && !enableLogin {
-		return errors.New("cannot set require-login without also setting enable-login")
+		return nil, errors.New("cannot set require-login without also setting enable-login")
 	} else if !payments.Available && (stripeSecretKey != "" || stripeWebhookKey != "") {
-		return errors.New("cannot set stripe-secret-key or stripe-webhook-key, support for payments is not available in this build (nopayments)")
+		return nil, errors.New("cannot set stripe-secret-key or stripe-webhook-key, support for payments is not available in this build (nopayments)")
 	} else if stripeSecretKey != "" && (stripeWebhookKey == "" || baseURL == "") {
-		return errors.New("if stripe-secret-key is set, stripe-webhook-key and base-url must also be set")
+		return nil, errors.New("if stripe-secret-key is set, stripe-webhook-key and base-url must also be set")
+	} else if stripeBillingAddressCollection != "auto" && stripeBillingAddressCollection != "required" {
+		return nil, errors.New("if set, stripe-billing-address-collection must be 'auto' or 'required'")
+	} else if len(stripeInvoiceFooter) > stripeInvoiceFooterLimit {
+		return nil, fmt.Errorf("if set, stripe-invoice-footer must not be longer than %d characters", stripeInvoiceFooterLimit)
 	} else if twilioAccount != "" && (twilioAuthToken == "" || twilioPhoneNumber == "" || twilioVerifyService == "" || baseURL == "" || authFile == "") {
-		return errors.New("if twilio-account is set, twilio-auth-token, twilio-phone-number, twilio-verify-service, base-url, and auth-file must also be set")
+		return nil, errors.New("if twilio-account is set, twilio-auth-token, twilio-phone-number, twilio-verify-service, base-url, and auth-file must also be set")
 	} else if messageSizeLimit > server.DefaultMessageSizeLimit {
 		log.Warn("message-size-limit is greater than 4K, this is not recommended and largely untested, and may lead to issues with some clients")
 		if messageSizeLimit > 5*1024*1024 {
-			return errors.New("message-size-limit cannot be higher than 5M")
+			return nil, errors.New("message-size-limit cannot be higher than 5M")
 		}
 	} else if !server.WebPushAvailable && (webPushPrivateKey != "" || webPushPublicKey != "" || webPushFile != "") {
-		return errors.New("cannot enable WebPush, support is not available in this build (nowebpush)")
+		return nil, errors.New("cannot enable WebPush, support is not available in this build (nowebpush)")
 	} else if webPushExpiryWarningDuration > 0 && webPushExpiryWarningDuration > webPushExpiryDuration {
-		return errors.New("web push expiry warning duration cannot be higher than web push expiry duration")
-	} else if behindProxy && proxyForwardedHeader == "" {
-		return errors.New("if behind-proxy is set, proxy-forwarded-header must also be set")
+		return nil, errors.New("web push expiry warning duration cannot be higher than web push expiry duration")
+	} else if (behindProxy || len(proxyTrustedHosts) > 0) && proxyForwardedHeader == "" {
+		return nil, errors.New("if trusted-proxies or behind-proxy is set, proxy-forwarded-header must also be set")
+	} else if geoIPDatabase != "" && !util.FileExists(geoIPDatabase) {
+		return nil, errors.New("if set, GeoIP database file must exist")
+	} else if geoIPASNDatabase != "" && !util.FileExists(geoIPASNDatabase) {
+		return nil, errors.New("if set, GeoIP ASN database file must exist")
+	} else if authTokenAlerts && (authFile == "" || (geoIPDatabase == "" && geoIPASNDatabase == "")) {
+		return nil, errors.New("if auth-token-alerts is set, auth-file and geoip-database or geoip-asn-database must also be set")
+	} else if geoIPDatabase == "" && (len(geoIPBlockedCountriesRaw) > 0 || len(geoIPRequestLimitFactorsRaw) > 0) {
+		return nil, errors.New("if geoip-blocked-countries or geoip-request-limit-factors is set, geoip-database must also be set")
+	} else if visitorStatsHourlyRetention < 48*time.Hour {
+		return nil, errors.New("if set, visitor-stats-hourly-retention must be at least 48h, so that daily rollups can be computed")
+	} else if visitorStatsDailyRetention < 24*time.Hour {
+		return nil, errors.New("if set, visitor-stats-daily-retention must be at least 24h")
 	} else if visitorPrefixBitsIPv4 < 1 || visitorPrefixBitsIPv4 > 32 {
-		return errors.New("visitor-prefix-bits-ipv4 must be between 1 and 32")
+		return nil, errors.New("visitor-prefix-bits-ipv4 must be between 1 and 32")
 	} else if visitorPrefixBitsIPv6 < 1 || visitorPrefixBitsIPv6 > 128 {
-		return errors.New("visitor-prefix-bits-ipv6 must be between 1 and 128")
+		return nil, errors.New("visitor-prefix-bits-ipv6 must be between 1 and 128")
+	} else if metricsCardinalityLimit < 0 {
+		return nil, errors.New("metrics-cardinality-limit must not be negative")
+	} else if metricsRemoteWriteURL != "" && !strings.HasPrefix(metricsRemoteWriteURL, "http://") && !strings.HasPrefix(metricsRemoteWriteURL, "https://") {
+		return nil, errors.New("if set, metrics-remote-write-url must start with http:// or https://")
+	} else if _, _, err := net.SplitHostPort(metricsStatsdAddress); metricsStatsdAddress != "" && err != nil {
+		return nil, errors.New("if set, metrics-statsd-address must be in the format host:port, e.g. localhost:8125")
+	} else if metricsPushInterval < time.Second {
+		return nil, errors.New("metrics-push-interval must be at least 1s")
+	} else if listenTCP != "" && len(tcpAuthRulesRaw) == 0 {
+		return nil, errors.New("if listen-tcp is set, tcp-auth-rules must also be set")
+	} else if sentryDSN == "" && sentryEnvironment != "" {
+		return nil, errors.New("if sentry-environment is set, sentry-dsn must also be set")
+	} else if visitorLimitRedisURL != "" && !strings.HasPrefix(visitorLimitRedisURL, "redis://") && !strings.HasPrefix(visitorLimitRedisURL, "rediss://") {
+		return nil, errors.New("if set, visitor-limit-redis-url must start with redis:// or rediss://")
+	} else if visitorRecurringMessageLimit < 0 {
+		return nil, errors.New("visitor-recurring-message-limit must not be negative")
+	} else if len(webAppName) > webAppNameLimit || strings.ContainsFunc(webAppName, unicode.IsControl) {
+		return nil, fmt.Errorf("if set, web-app-name must not be longer than %d characters, and must not contain control characters", webAppNameLimit)
+	} else if webAppLogoURL != "" && !strings.HasPrefix(webAppLogoURL, "/") && !strings.HasPrefix(webAppLogoURL, "http://") && !strings.HasPrefix(webAppLogoURL, "https://") {
+		return nil, errors.New("if set, web-app-logo-url must be an absolute path (e.g. /static/logo.svg) or an http:// or https:// URL")
+	} else if webAppAccentColor != "" && !webAppAccentColorRegex.MatchString(webAppAccentColor) {
+		return nil, errors.New("if set, web-app-accent-color must be a hex color, e.g. #338574")
+	} else if webAppTermsURL != "" && !strings.HasPrefix(webAppTermsURL, "http://") && !strings.HasPrefix(webAppTermsURL, "https://") {
+		return nil, errors.New("if set, web-app-terms-url must be an http:// or https:// URL")
 	}
 
 	// Backwards compatibility
@@ -360,22 +762,93 @@ func execServe(c *cli.Context) error {
 		webRoot = "/" + webRoot
 	}
 
+	// Disable Cache-Control header for static files
+	if webStaticCacheControl == "none" {
+		webStaticCacheControl = ""
+	}
+
+	// The forwarded header can be spoofed by any client that can reach the server directly
+	if behindProxy {
+		log.Warn("behind-proxy is deprecated, since it trusts the forwarded header of any client; set trusted-proxies to the addresses of your proxies instead")
+	}
+
 	// Convert default auth permission, read provisioned users
 	authDefault, err := user.ParsePermission(authDefaultAccess)
 	if err != nil {
-		return errors.New("if set, auth-default-access must start set to 'read-write', 'read-only', 'write-only' or 'deny-all'")
+		return nil, errors.New("if set, auth-default-access must start set to 'read-write', 'read-only', 'write-only' or 'deny-all'")
 	}
 	authUsers, err := parseUsers(authUsersRaw)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	authAccess, err := parseAccess(authUsers, authAccessRaw)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	authTokens, err := parseTokens(authUsers, authTokensRaw)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	topicContentTypes, err := parseTopicContentTypes(topicContentTypesRaw)
+	if err != nil {
+		return nil, err
+	}
+	matrixBridgeRooms, err := parseMatrixBridgeRooms(matrixBridgeRoomsRaw)
+	if err != nil {
+		return nil, err
+	}
+	telegramRelays, err := parseTelegramRelays(telegramRelaysRaw)
+	if err != nil {
+		return nil, err
+	}
+	tcpAuthRules, err := parseTCPAuthRules(tcpAuthRulesRaw)
+	if err != nil {
+		return nil, err
+	}
+	webhookTLS, err := parseWebhookTLS(webhookTLSRaw)
+	if err != nil {
+		return nil, err
+	}
+	cacheEncryptionKey, err := parseCacheEncryptionKey(cacheEncryptionKeyStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range cacheEncryptedTopics {
+		if !user.AllowedTopic(topic) {
+			return nil, fmt.Errorf("invalid cache-encrypted-topics: topic %s is invalid", topic)
+		}
+	}
+	smtpSenderFromTiers, smtpSenderFromUsers, err := parseSMTPSenderFromOverrides(smtpSenderFromOverridesRaw)
+	if err != nil {
+		return nil, err
+	}
+	attachmentExpiryRules, err := parseAttachmentExpiryRules(attachmentExpiryRulesRaw)
+	if err != nil {
+		return nil, err
+	}
+	visitorRequestLimitWindows, err := parseRateLimitWindows(visitorRequestLimitWindowsRaw)
+	if err != nil {
+		return nil, err
+	}
+	geoIPBlockedCountries, err := parseCountryCodes(geoIPBlockedCountriesRaw)
+	if err != nil {
+		return nil, err
+	}
+	geoIPRequestLimitFactors, err := parseCountryRequestLimitFactors(geoIPRequestLimitFactorsRaw)
+	if err != nil {
+		return nil, err
+	}
+	clusterPeers, err := parseClusterPeers(clusterPeersRaw, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	stripeInvoiceCustomFields, err := parseStripeInvoiceCustomFields(stripeInvoiceCustomFieldsRaw)
+	if err != nil {
+		return nil, err
+	}
+	experiments, err := parseExperiments(experimentsRaw)
+	if err != nil {
+		return nil, err
 	}
 
 	// Special case: Unset default
@@ -399,7 +872,7 @@ func execServe(c *cli.Context) error {
 	for _, host := range proxyTrustedHosts {
 		prefixes, err := parseIPHostPrefix(host)
 		if err != nil {
-			return fmt.Errorf("cannot resolve trusted proxy host %s: %s", host, err.Error())
+			return nil, fmt.Errorf("cannot resolve trusted proxy host %s: %s", host, err.Error())
 		}
 		trustedProxyPrefixes = append(trustedProxyPrefixes, prefixes...)
 	}
@@ -412,43 +885,88 @@ func execServe(c *cli.Context) error {
 	// Add default forbidden topics
 	disallowedTopics = append(disallowedTopics, server.DefaultDisallowedTopics...)
 
-	// Run server
+	// Create config
 	conf := server.NewConfig()
-	conf.File = config
+	conf.File = c.String("config")
 	conf.BaseURL = baseURL
 	conf.ListenHTTP = listenHTTP
 	conf.ListenHTTPS = listenHTTPS
+	conf.ListenGRPC = listenGRPC
+	conf.ListenTCP = listenTCP
+	conf.TCPAuthRules = tcpAuthRules
+	conf.ListenSTOMP = listenSTOMP
 	conf.ListenUnix = listenUnix
 	conf.ListenUnixMode = fs.FileMode(listenUnixMode)
 	conf.KeyFile = keyFile
 	conf.CertFile = certFile
+	conf.ACMEDomains = acmeDomains
+	conf.ACMEEmail = acmeEmail
+	conf.ACMECacheDir = acmeCacheDir
+	conf.ACMEDirectoryURL = acmeDirectoryURL
 	conf.FirebaseKeyFile = firebaseKeyFile
+	conf.APNSKeyFile = apnsKeyFile
+	conf.APNSKeyID = apnsKeyID
+	conf.APNSTeamID = apnsTeamID
+	conf.APNSBundleID = apnsBundleID
+	conf.APNSSandbox = apnsSandbox
+	conf.APNSFile = apnsFile
+	conf.APNSDeviceExpiryDuration = apnsDeviceExpiryDuration
 	conf.CacheFile = cacheFile
 	conf.CacheDuration = cacheDuration
+	conf.CacheEncryptionKey = cacheEncryptionKey
+	conf.CacheEncryptedTopics = cacheEncryptedTopics
 	conf.CacheStartupQueries = cacheStartupQueries
 	conf.CacheBatchSize = cacheBatchSize
 	conf.CacheBatchTimeout = cacheBatchTimeout
+	conf.CacheTombstoneDuration = cacheTombstoneDuration
 	conf.AuthFile = authFile
 	conf.AuthStartupQueries = authStartupQueries
 	conf.AuthDefault = authDefault
 	conf.AuthUsers = authUsers
 	conf.AuthAccess = authAccess
 	conf.AuthTokens = authTokens
+	conf.AuthPruneProvisioned = pruneProvisioned
+	conf.AuthWebhookURL = authWebhookURL
+	conf.AuthWebhookTimeout = authWebhookTimeout
+	conf.AuthWebhookCacheTTL = authWebhookCacheTTL
+	conf.AuthSessionTokenDuration = authSessionTokenDuration
+	conf.AuthSessionRefreshDuration = authSessionRefreshDuration
+	conf.AuthTokenAlerts = authTokenAlerts
 	conf.AttachmentCacheDir = attachmentCacheDir
 	conf.AttachmentTotalSizeLimit = attachmentTotalSizeLimit
 	conf.AttachmentFileSizeLimit = attachmentFileSizeLimit
 	conf.AttachmentExpiryDuration = attachmentExpiryDuration
+	conf.AttachmentExpiryRules = attachmentExpiryRules
+	conf.AttachmentScanURL = attachmentScanURL
+	conf.AttachmentScanAction = attachmentScanAction
+	conf.AttachmentScanTimeout = attachmentScanTimeout
+	conf.AttachmentQuarantineDir = attachmentQuarantineDir
 	conf.TemplateDir = templateDir
+	conf.TemplateLoopLimit = templateLoopLimit
+	conf.TemplateStringLimit = templateStringLimit
+	conf.TemplateTimeout = templateTimeout
 	conf.KeepaliveInterval = keepaliveInterval
+	conf.WebSocketCompression = webSocketCompression
+	conf.WebSocketCompressionLevel = webSocketCompressionLevel
 	conf.ManagerInterval = managerInterval
 	conf.DisallowedTopics = disallowedTopics
+	conf.TopicContentTypes = topicContentTypes
 	conf.WebRoot = webRoot
+	conf.WebAppName = webAppName
+	conf.WebAppLogoURL = webAppLogoURL
+	conf.WebAppAccentColor = webAppAccentColor
+	conf.WebAppTermsURL = webAppTermsURL
+	conf.WebStaticCacheControl = webStaticCacheControl
 	conf.UpstreamBaseURL = upstreamBaseURL
 	conf.UpstreamAccessToken = upstreamAccessToken
+	conf.ClusterPeers = clusterPeers
+	conf.ClusterSecret = clusterSecret
 	conf.SMTPSenderAddr = smtpSenderAddr
 	conf.SMTPSenderUser = smtpSenderUser
 	conf.SMTPSenderPass = smtpSenderPass
 	conf.SMTPSenderFrom = smtpSenderFrom
+	conf.SMTPSenderFromTiers = smtpSenderFromTiers
+	conf.SMTPSenderFromUsers = smtpSenderFromUsers
 	conf.SMTPServerListen = smtpServerListen
 	conf.SMTPServerDomain = smtpServerDomain
 	conf.SMTPServerAddrPrefix = smtpServerAddrPrefix
@@ -458,31 +976,74 @@ func execServe(c *cli.Context) error {
 	conf.TwilioVerifyService = twilioVerifyService
 	conf.MessageSizeLimit = int(messageSizeLimit)
 	conf.MessageDelayMax = messageDelayLimit
+	conf.MessageDedupWindow = messageDedupWindow
+	conf.EnableLinkPreviews = enableLinkPreviews
+	conf.EnableWebhooks = enableWebhooks
+	conf.WebhookAllowPrivateNetworks = webhookAllowPrivateNetworks
+	conf.WebhookTLS = webhookTLS
+	conf.MatrixBridgeHomeserver = matrixBridgeHomeserver
+	conf.MatrixBridgeToken = matrixBridgeToken
+	conf.MatrixBridgeUserID = matrixBridgeUserID
+	conf.MatrixBridgeRooms = matrixBridgeRooms
+	conf.TelegramBotToken = telegramBotToken
+	conf.TelegramRelays = telegramRelays
+	conf.EnableTelegramRelays = enableTelegramRelays
+	conf.ReportThrottleThreshold = reportThrottleThreshold
+	conf.ReportThrottleInterval = reportThrottleInterval
 	conf.TotalTopicLimit = totalTopicLimit
 	conf.VisitorSubscriptionLimit = visitorSubscriptionLimit
+	conf.VisitorRecurringMessageLimit = visitorRecurringMessageLimit
 	conf.VisitorSubscriberRateLimiting = visitorSubscriberRateLimiting
+	conf.UnifiedPushAppRequestLimitBurst = unifiedPushAppRequestLimitBurst
+	conf.UnifiedPushAppRequestLimitReplenish = unifiedPushAppRequestLimitReplenish
+	conf.UnifiedPushAppMessageDailyLimit = unifiedPushAppMessageDailyLimit
+	conf.VisitorLimitRedisURL = visitorLimitRedisURL
 	conf.VisitorAttachmentTotalSizeLimit = visitorAttachmentTotalSizeLimit
 	conf.VisitorAttachmentDailyBandwidthLimit = visitorAttachmentDailyBandwidthLimit
+	conf.TopicAttachmentDailyBandwidthLimit = topicAttachmentDailyBandwidthLimit
 	conf.VisitorRequestLimitBurst = visitorRequestLimitBurst
 	conf.VisitorRequestLimitReplenish = visitorRequestLimitReplenish
 	conf.VisitorRequestExemptPrefixes = visitorRequestLimitExemptPrefixes
+	conf.VisitorRequestLimitWindows = visitorRequestLimitWindows
 	conf.VisitorMessageDailyLimit = visitorMessageDailyLimit
 	conf.VisitorEmailLimitBurst = visitorEmailLimitBurst
 	conf.VisitorEmailLimitReplenish = visitorEmailLimitReplenish
+	conf.VisitorStatsHourlyRetention = visitorStatsHourlyRetention
+	conf.VisitorStatsDailyRetention = visitorStatsDailyRetention
 	conf.VisitorPrefixBitsIPv4 = visitorPrefixBitsIPv4
 	conf.VisitorPrefixBitsIPv6 = visitorPrefixBitsIPv6
 	conf.BehindProxy = behindProxy
 	conf.ProxyForwardedHeader = proxyForwardedHeader
 	conf.ProxyTrustedPrefixes = trustedProxyPrefixes
+	conf.GeoIPDatabase = geoIPDatabase
+	conf.GeoIPASNDatabase = geoIPASNDatabase
+	conf.GeoIPBlockedCountries = geoIPBlockedCountries
+	conf.GeoIPRequestLimitFactors = geoIPRequestLimitFactors
 	conf.StripeSecretKey = stripeSecretKey
 	conf.StripeWebhookKey = stripeWebhookKey
+	conf.StripeAutomaticTax = stripeAutomaticTax
+	conf.StripeBillingAddressCollection = stripeBillingAddressCollection
+	conf.StripeInvoiceFooter = stripeInvoiceFooter
+	conf.StripeInvoiceCustomFields = stripeInvoiceCustomFields
+	conf.StripePaymentGracePeriod = stripePaymentGracePeriod
 	conf.BillingContact = billingContact
 	conf.EnableSignup = enableSignup
 	conf.EnableLogin = enableLogin
 	conf.RequireLogin = requireLogin
 	conf.EnableReservations = enableReservations
+	conf.RequireAdminWebAuthn = requireAdminWebAuthn
+	conf.EnableImpersonation = enableImpersonation
+	conf.AccountEmailFallbackDuration = accountEmailFallbackDuration
 	conf.EnableMetrics = enableMetrics
 	conf.MetricsListenHTTP = metricsListenHTTP
+	conf.MetricsCardinalityLimit = metricsCardinalityLimit
+	conf.MetricsRemoteWriteURL = metricsRemoteWriteURL
+	conf.MetricsStatsdAddress = metricsStatsdAddress
+	conf.MetricsPushInterval = metricsPushInterval
+	conf.SentryDSN = sentryDSN
+	conf.SentryEnvironment = sentryEnvironment
+	conf.AccessLogFile = accessLogFile
+	conf.Experiments = experiments
 	conf.ProfileListenHTTP = profileListenHTTP
 	conf.WebPushPrivateKey = webPushPrivateKey
 	conf.WebPushPublicKey = webPushPublicKey
@@ -492,26 +1053,15 @@ func execServe(c *cli.Context) error {
 	conf.WebPushExpiryDuration = webPushExpiryDuration
 	conf.WebPushExpiryWarningDuration = webPushExpiryWarningDuration
 	conf.Version = c.App.Version
-
-	// Set up hot-reloading of config
-	go sigHandlerConfigReload(config)
-
-	// Run server
-	s, err := server.New(conf)
-	if err != nil {
-		log.Fatal("%s", err.Error())
-	} else if err := s.Run(); err != nil {
-		log.Fatal("%s", err.Error())
-	}
-	log.Info("Exiting.")
-	return nil
+	return conf, nil
 }
 
-func sigHandlerConfigReload(config string) {
+func sigHandlerConfigReload(s *server.Server, c *cli.Context) {
+	config, args := c.String("config"), commandArgs(os.Args, c.Command.Name)
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGHUP)
 	for range sigs {
-		log.Info("Partially hot reloading configuration ...")
+		log.Info("Hot reloading configuration ...")
 		inputSource, err := newYamlSourceFromFile(config, flagsServe)
 		if err != nil {
 			log.Warn("Hot reload failed: %s", err.Error())
@@ -520,7 +1070,46 @@ func sigHandlerConfigReload(config string) {
 		if err := reloadLogLevel(inputSource); err != nil {
 			log.Warn("Reloading log level failed: %s", err.Error())
 		}
+		conf, err := loadServerConfig(args, c.App.Version)
+		if err != nil {
+			log.Warn("Hot reload failed, keeping current configuration: %s", err.Error())
+			continue
+		}
+		if err := s.Reload(conf); err != nil {
+			log.Warn("Hot reload failed: %s", err.Error())
+		}
+	}
+}
+
+// loadServerConfig parses the options of the "serve" command from the given command line arguments, the
+// environment and the config file, exactly like "ntfy serve" does. It is used to reload the config on SIGHUP.
+func loadServerConfig(args []string, version string) (*server.Config, error) {
+	var conf *server.Config
+	app := &cli.App{
+		Name:      "ntfy",
+		Version:   version,
+		Writer:    io.Discard,
+		ErrWriter: io.Discard,
+		Flags:     flagsServe,
+		Before:    initConfigFileInputSourceFunc("config", flagsServe, nil),
+		Action: func(c *cli.Context) (err error) {
+			conf, err = newServerConfig(c)
+			return err
+		},
+	}
+	if err := app.Run(append([]string{"ntfy"}, args...)); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// commandArgs returns the arguments following the given command in the original command line, e.g. the
+// options in "ntfy --debug serve --listen-http :80", so that they can be parsed again when reloading the config
+func commandArgs(args []string, command string) []string {
+	if i := slices.Index(args, command); i >= 0 {
+		return args[i+1:]
 	}
+	return nil
 }
 
 func parseIPHostPrefix(host string) (prefixes []netip.Prefix, err error) {
@@ -548,6 +1137,309 @@ func parseIPHostPrefix(host string) (prefixes []netip.Prefix, err error) {
 	return
 }
 
+func parseTopicContentTypes(topicContentTypesRaw []string) (map[string]string, error) {
+	topicContentTypes := make(map[string]string)
+	for _, line := range topicContentTypesRaw {
+		parts := strings.Split(line, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid topic-content-types: %s, expected format: 'topic:content-type'", line)
+		}
+		topic := strings.TrimSpace(parts[0])
+		contentType := strings.ToLower(strings.TrimSpace(parts[1]))
+		if !user.AllowedTopic(topic) {
+			return nil, fmt.Errorf("invalid topic-content-types: %s, topic %s is invalid", line, topic)
+		} else if contentType != "text/plain" && contentType != "text/markdown" {
+			return nil, fmt.Errorf("invalid topic-content-types: %s, content type must be 'text/plain' or 'text/markdown'", line)
+		}
+		topicContentTypes[topic] = contentType
+	}
+	return topicContentTypes, nil
+}
+
+func parseMatrixBridgeRooms(roomsRaw []string) (map[string][]string, error) {
+	rooms := make(map[string][]string)
+	for _, line := range roomsRaw {
+		topic, roomID, ok := strings.Cut(line, ":") // Room IDs contain colons, e.g. !abc:example.com
+		topic, roomID = strings.TrimSpace(topic), strings.TrimSpace(roomID)
+		if !ok {
+			return nil, fmt.Errorf("invalid matrix-bridge-rooms: %s, expected format: 'topic:!roomid:server'", line)
+		} else if !user.AllowedTopic(topic) {
+			return nil, fmt.Errorf("invalid matrix-bridge-rooms: %s, topic %s is invalid", line, topic)
+		} else if !matrixRoomIDRegex.MatchString(roomID) {
+			return nil, fmt.Errorf("invalid matrix-bridge-rooms: %s, room must be a Matrix room ID, e.g. '!roomid:example.com' (aliases are not supported)", line)
+		} else if slices.Contains(rooms[topic], roomID) {
+			return nil, fmt.Errorf("invalid matrix-bridge-rooms: %s, defined more than once", line)
+		}
+		rooms[topic] = append(rooms[topic], roomID)
+	}
+	return rooms, nil
+}
+
+func parseTelegramRelays(relaysRaw []string) (map[string][]string, error) {
+	relays := make(map[string][]string)
+	for _, line := range relaysRaw {
+		topic, chatID, ok := strings.Cut(line, ":")
+		topic, chatID = strings.TrimSpace(topic), strings.TrimSpace(chatID)
+		if !ok {
+			return nil, fmt.Errorf("invalid telegram-relays: %s, expected format: 'topic:chat-id'", line)
+		} else if !user.AllowedTopic(topic) {
+			return nil, fmt.Errorf("invalid telegram-relays: %s, topic %s is invalid", line, topic)
+		} else if !telegramChatIDRegex.MatchString(chatID) {
+			return nil, fmt.Errorf("invalid telegram-relays: %s, chat must be a numeric chat ID (e.g. -1001234567890) or a channel username (e.g. @mychannel)", line)
+		} else if slices.Contains(relays[topic], chatID) {
+			return nil, fmt.Errorf("invalid telegram-relays: %s, defined more than once", line)
+		}
+		relays[topic] = append(relays[topic], chatID)
+	}
+	return relays, nil
+}
+
+func parseTCPAuthRules(rulesRaw []string) ([]*server.TCPAuthRule, error) {
+	rules := make([]*server.TCPAuthRule, 0)
+	for _, line := range rulesRaw {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid tcp-auth-rules: %s, expected format: 'ip-or-cidr [token]'", line)
+		}
+		prefixes, err := parseIPHostPrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tcp-auth-rules: %s, cannot resolve %s: %s", line, fields[0], err.Error())
+		}
+		var token string
+		if len(fields) == 2 {
+			token = fields[1]
+			if !user.ValidToken(token) {
+				return nil, fmt.Errorf("invalid tcp-auth-rules: %s, token must be a valid access token (tk_...)", line)
+			}
+		}
+		for _, prefix := range prefixes {
+			rules = append(rules, &server.TCPAuthRule{Prefix: prefix, Token: token})
+		}
+	}
+	return rules, nil
+}
+
+func parseWebhookTLS(settingsRaw []string) ([]*server.WebhookTLS, error) {
+	settings := make([]*server.WebhookTLS, 0)
+	for _, line := range settingsRaw {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid webhook-tls: %s, expected format: 'host [cert=file key=file] [ca=file]'", line)
+		}
+		host := strings.TrimPrefix(fields[0], "*.")
+		if host == "" || strings.ContainsAny(host, "/:*") {
+			return nil, fmt.Errorf("invalid webhook-tls: %s, host must be a hostname, or *.domain for all subdomains", line)
+		}
+		s := &server.WebhookTLS{Host: fields[0]}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid webhook-tls: %s, expected key=value, got %s", line, field)
+			}
+			switch key {
+			case "cert":
+				s.CertFile = value
+			case "key":
+				s.KeyFile = value
+			case "ca":
+				s.CAFile = value
+			default:
+				return nil, fmt.Errorf("invalid webhook-tls: %s, unknown key %s, expected cert, key or ca", line, key)
+			}
+		}
+		if (s.CertFile == "") != (s.KeyFile == "") {
+			return nil, fmt.Errorf("invalid webhook-tls: %s, cert and key must be set together", line)
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+func parseClusterPeers(peersRaw []string, baseURL string) ([]string, error) {
+	peers := make([]string, 0)
+	for _, peer := range peersRaw {
+		peer = strings.TrimSuffix(strings.TrimSpace(peer), "/")
+		if !strings.HasPrefix(peer, "http://") && !strings.HasPrefix(peer, "https://") {
+			return nil, fmt.Errorf("invalid cluster-peers: %s, peer must start with http:// or https://", peer)
+		} else if baseURL != "" && peer == strings.TrimSuffix(baseURL, "/") {
+			return nil, fmt.Errorf("invalid cluster-peers: %s, peer must not be identical to base-url", peer)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+func parseExperiments(experimentsRaw []string) ([]*server.Experiment, error) {
+	experiments := make([]*server.Experiment, 0)
+	names := make(map[string]bool)
+	for _, line := range experimentsRaw {
+		name, percentageStr, ok := strings.Cut(line, ":")
+		name, percentageStr = strings.TrimSpace(name), strings.TrimSuffix(strings.TrimSpace(percentageStr), "%")
+		if !ok || !experimentNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid experiments: %s, expected format: 'name:percentage', e.g. 'new-web-ui:10'", line)
+		} else if names[name] {
+			return nil, fmt.Errorf("invalid experiments: %s, experiment %s is defined more than once", line, name)
+		}
+		percentage, err := strconv.Atoi(percentageStr)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return nil, fmt.Errorf("invalid experiments: %s, percentage must be between 0 and 100", line)
+		}
+		names[name] = true
+		experiments = append(experiments, &server.Experiment{Name: name, Percentage: percentage})
+	}
+	return experiments, nil
+}
+
+func parseStripeInvoiceCustomFields(fieldsRaw []string) ([]*server.StripeInvoiceCustomField, error) {
+	fields := make([]*server.StripeInvoiceCustomField, 0)
+	for _, line := range fieldsRaw {
+		name, value, ok := strings.Cut(line, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid stripe-invoice-custom-fields: %s, expected format: 'name: value'", line)
+		} else if len(name) > stripeInvoiceCustomFieldLimit || len(value) > stripeInvoiceCustomFieldLimit {
+			return nil, fmt.Errorf("invalid stripe-invoice-custom-fields: %s, name and value must not be longer than %d characters", line, stripeInvoiceCustomFieldLimit)
+		}
+		fields = append(fields, &server.StripeInvoiceCustomField{Name: name, Value: value})
+	}
+	if len(fields) > stripeInvoiceCustomFieldsMax {
+		return nil, fmt.Errorf("invalid stripe-invoice-custom-fields: at most %d custom fields are allowed", stripeInvoiceCustomFieldsMax)
+	}
+	return fields, nil
+}
+
+func parseAttachmentExpiryRules(rulesRaw []string) ([]*server.AttachmentExpiryRule, error) {
+	rules := make([]*server.AttachmentExpiryRule, 0)
+	for _, line := range rulesRaw {
+		pattern, durationStr, ok := strings.Cut(line, "->")
+		pattern, durationStr = strings.ToLower(strings.TrimSpace(pattern)), strings.TrimSpace(durationStr)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid attachment-expiry-rules: %s, expected format: 'pattern -> duration', e.g. 'image/* -> 3d'", line)
+		} else if !attachmentExpiryPatternRegex.MatchString(pattern) {
+			return nil, fmt.Errorf("invalid attachment-expiry-rules: %s, pattern must be a file extension (e.g. '.log'), a MIME type (e.g. 'application/pdf'), or a MIME type wildcard (e.g. 'image/*')", line)
+		}
+		duration, err := util.ParseDuration(durationStr)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid attachment-expiry-rules: %s, duration must be positive, e.g. '12h' or '3d'", line)
+		}
+		rules = append(rules, &server.AttachmentExpiryRule{Pattern: pattern, Duration: duration})
+	}
+	return rules, nil
+}
+
+func parseRateLimitWindows(windowsRaw []string) ([]*server.RateLimitWindow, error) {
+	windows := make([]*server.RateLimitWindow, 0)
+	for _, line := range windowsRaw {
+		parts := strings.Split(line, "->")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid visitor-request-limit-windows: %s, expected format: 'HH:MM-HH:MM -> factor'", line)
+		}
+		times := strings.Split(strings.TrimSpace(parts[0]), "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid visitor-request-limit-windows: %s, expected format: 'HH:MM-HH:MM -> factor'", line)
+		}
+		start, err := parseTimeOfDay(times[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid visitor-request-limit-windows: %s, start time invalid", line)
+		}
+		end, err := parseTimeOfDay(times[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid visitor-request-limit-windows: %s, end time invalid", line)
+		} else if start == end {
+			return nil, fmt.Errorf("invalid visitor-request-limit-windows: %s, start and end time must differ", line)
+		}
+		factor, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || factor <= 0 {
+			return nil, fmt.Errorf("invalid visitor-request-limit-windows: %s, factor must be a positive number", line)
+		}
+		windows = append(windows, &server.RateLimitWindow{
+			Start:  start,
+			End:    end,
+			Factor: factor,
+		})
+	}
+	return windows, nil
+}
+
+// parseCountryCodes validates and normalizes a list of two-letter ISO country codes, e.g. "de" -> "DE"
+func parseCountryCodes(codesRaw []string) ([]string, error) {
+	codes := make([]string, 0)
+	for _, code := range codesRaw {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if !countryCodeRegex.MatchString(code) {
+			return nil, fmt.Errorf("invalid country code %s, expected a two-letter ISO country code, e.g. 'DE'", code)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func parseCountryRequestLimitFactors(factorsRaw []string) (map[string]float64, error) {
+	factors := make(map[string]float64)
+	for _, line := range factorsRaw {
+		parts := strings.Split(line, "->")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid geoip-request-limit-factors: %s, expected format: 'XY -> factor'", line)
+		}
+		code := strings.ToUpper(strings.TrimSpace(parts[0]))
+		if !countryCodeRegex.MatchString(code) {
+			return nil, fmt.Errorf("invalid geoip-request-limit-factors: %s, expected a two-letter ISO country code, e.g. 'DE'", line)
+		}
+		factor, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || factor <= 0 {
+			return nil, fmt.Errorf("invalid geoip-request-limit-factors: %s, factor must be a positive number", line)
+		}
+		factors[code] = factor
+	}
+	return factors, nil
+}
+
+// parseTimeOfDay parses a time of day in the format HH:MM, and returns it as offset from midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseCacheEncryptionKey(keyStr string) ([]byte, error) {
+	if keyStr == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(keyStr))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid cache-encryption-key: must be 32 bytes, base64-encoded, e.g. generated with 'openssl rand -base64 32'")
+	}
+	return key, nil
+}
+
+func parseSMTPSenderFromOverrides(overridesRaw []string) (tiers map[string]string, users map[string]string, err error) {
+	tiers, users = make(map[string]string), make(map[string]string)
+	for _, line := range overridesRaw {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			return nil, nil, fmt.Errorf("invalid smtp-sender-from-overrides: %s, expected format: 'tier:<code>:<address>' or 'user:<username>:<address>'", line)
+		}
+		kind, name, from := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+		if addr, err := mail.ParseAddress(from); err != nil || addr.Address != from {
+			return nil, nil, fmt.Errorf("invalid smtp-sender-from-overrides: %s, address %s is invalid", line, from)
+		}
+		switch kind {
+		case "tier":
+			tiers[name] = from
+		case "user":
+			if !user.AllowedUsername(name) {
+				return nil, nil, fmt.Errorf("invalid smtp-sender-from-overrides: %s, username %s invalid", line, name)
+			}
+			users[name] = from
+		default:
+			return nil, nil, fmt.Errorf("invalid smtp-sender-from-overrides: %s, must start with 'tier:' or 'user:'", line)
+		}
+	}
+	return tiers, users, nil
+}
+
 func parseUsers(usersRaw []string) ([]*user.User, error) {
 	users := make([]*user.User, 0)
 	for _, userLine := range usersRaw {
@@ -563,7 +1455,7 @@ func parseUsers(usersRaw []string) ([]*user.User, error) {
 		} else if err := user.ValidPasswordHash(passwordHash, user.DefaultUserPasswordBcryptCost); err != nil {
 			return nil, fmt.Errorf("invalid auth-users: %s, password hash invalid, %s", userLine, err.Error())
 		} else if !user.AllowedRole(role) {
-			return nil, fmt.Errorf("invalid auth-users: %s, role %s is not allowed, allowed roles are 'admin' or 'user'", userLine, role)
+			return nil, fmt.Errorf("invalid auth-users: %s, role %s is not allowed, allowed roles are 'admin', 'support' or 'user'", userLine, role)
 		}
 		users = append(users, &user.User{
 			Name:        username,
@@ -594,8 +1486,8 @@ func parseAccess(users []*user.User, accessRaw []string) (map[string][]*user.Gra
 				return nil, fmt.Errorf("invalid auth-access: %s, user %s is not provisioned", accessLine, username)
 			} else if !user.AllowedUsername(username) {
 				return nil, fmt.Errorf("invalid auth-access: %s, username %s invalid", accessLine, username)
-			} else if u.Role != user.RoleUser {
-				return nil, fmt.Errorf("invalid auth-access: %s, user %s is not a regular user, only regular users can have ACL entries", accessLine, username)
+			} else if u.Role == user.RoleAdmin {
+				return nil, fmt.Errorf("invalid auth-access: %s, user %s is an admin user, only regular and support users can have ACL entries", accessLine, username)
 			}
 		}
 		topic := strings.TrimSpace(parts[1])
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"math/rand"
+	"net/netip"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/server"
 	"heckel.io/ntfy/v2/test"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
@@ -121,7 +123,7 @@ func TestParseUsers_Errors(t *testing.T) {
 		{
 			name:  "invalid role",
 			input: []string{"alice:$2a$10$320YlQeaMghYZsvtu9jzfOQZS32FysWY/T9qu5NWqcIh.DN.u5P5S:invalid"},
-			error: "invalid auth-users: alice:$2a$10$320YlQeaMghYZsvtu9jzfOQZS32FysWY/T9qu5NWqcIh.DN.u5P5S:invalid, role invalid is not allowed, allowed roles are 'admin' or 'user'",
+			error: "invalid auth-users: alice:$2a$10$320YlQeaMghYZsvtu9jzfOQZS32FysWY/T9qu5NWqcIh.DN.u5P5S:invalid, role invalid is not allowed, allowed roles are 'admin', 'support' or 'user'",
 		},
 		{
 			name:  "empty username",
@@ -281,7 +283,7 @@ func TestParseAccess_Errors(t *testing.T) {
 			name:  "admin user cannot have ACL entries",
 			users: users,
 			input: []string{"admin:topic:read"},
-			error: "invalid auth-access: admin:topic:read, user admin is not a regular user, only regular users can have ACL entries",
+			error: "invalid auth-access: admin:topic:read, user admin is an admin user, only regular and support users can have ACL entries",
 		},
 		{
 			name:  "invalid topic pattern",
@@ -469,6 +471,58 @@ func TestParseTokens_Errors(t *testing.T) {
 	}
 }
 
+func TestParseSMTPSenderFromOverrides_Success(t *testing.T) {
+	tiers, users, err := parseSMTPSenderFromOverrides([]string{
+		"tier:pro:alerts@pro.example.com",
+		"tier: business : alerts@business.example.com",
+		"user:phil:phil@example.com",
+	})
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{"pro": "alerts@pro.example.com", "business": "alerts@business.example.com"}, tiers)
+	require.Equal(t, map[string]string{"phil": "phil@example.com"}, users)
+}
+
+func TestParseSMTPSenderFromOverrides_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		error string
+	}{
+		{
+			name:  "invalid format",
+			input: []string{"tier:pro"},
+			error: "invalid smtp-sender-from-overrides: tier:pro, expected format: 'tier:<code>:<address>' or 'user:<username>:<address>'",
+		},
+		{
+			name:  "invalid kind",
+			input: []string{"group:pro:alerts@example.com"},
+			error: "invalid smtp-sender-from-overrides: group:pro:alerts@example.com, must start with 'tier:' or 'user:'",
+		},
+		{
+			name:  "invalid address",
+			input: []string{"tier:pro:not-an-address"},
+			error: "invalid smtp-sender-from-overrides: tier:pro:not-an-address, address not-an-address is invalid",
+		},
+		{
+			name:  "address with display name",
+			input: []string{"tier:pro:Alerts <alerts@example.com>"},
+			error: "address Alerts <alerts@example.com> is invalid",
+		},
+		{
+			name:  "invalid username",
+			input: []string{"user:phil$:phil@example.com"},
+			error: "invalid smtp-sender-from-overrides: user:phil$:phil@example.com, username phil$ invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseSMTPSenderFromOverrides(tt.input)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
+
 func TestCLI_Serve_Unix_Curl(t *testing.T) {
 	sockFile := filepath.Join(t.TempDir(), "ntfy.sock")
 	configFile := newEmptyFile(t) // Avoid issues with existing server.yml file on system
@@ -541,3 +595,222 @@ func newEmptyFile(t *testing.T) string {
 	require.Nil(t, os.WriteFile(filename, []byte{}, 0600))
 	return filename
 }
+
+func TestParseCacheEncryptionKey(t *testing.T) {
+	key, err := parseCacheEncryptionKey("")
+	require.Nil(t, err)
+	require.Nil(t, key)
+
+	key, err = parseCacheEncryptionKey("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=")
+	require.Nil(t, err)
+	require.Equal(t, []byte("01234567890123456789012345678901"), key)
+
+	_, err = parseCacheEncryptionKey("dG9vIHNob3J0")
+	require.Error(t, err)
+	_, err = parseCacheEncryptionKey("not base64!")
+	require.Error(t, err)
+}
+
+func TestParseAttachmentExpiryRules(t *testing.T) {
+	rules, err := parseAttachmentExpiryRules([]string{
+		"image/* -> 3d",
+		" .LOG->12h ",
+		"application/octet-stream -> 1h",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 3, len(rules))
+	require.Equal(t, "image/*", rules[0].Pattern)
+	require.Equal(t, 72*time.Hour, rules[0].Duration)
+	require.Equal(t, ".log", rules[1].Pattern)
+	require.Equal(t, 12*time.Hour, rules[1].Duration)
+	require.Equal(t, "application/octet-stream", rules[2].Pattern)
+	require.Equal(t, time.Hour, rules[2].Duration)
+
+	for _, invalid := range []string{"image/*", "-> 3d", "image -> 3d", "*/* -> 3d", "log -> 12h", ".log -> 0", ".log -> forever"} {
+		_, err := parseAttachmentExpiryRules([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseTCPAuthRules(t *testing.T) {
+	token := "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2"
+	rules, err := parseTCPAuthRules([]string{
+		"10.0.0.0/8 " + token,
+		" 192.168.1.5 ",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rules))
+	require.Equal(t, netip.MustParsePrefix("10.0.0.0/8"), rules[0].Prefix)
+	require.Equal(t, token, rules[0].Token)
+	require.Equal(t, netip.MustParsePrefix("192.168.1.5/32"), rules[1].Prefix)
+	require.Empty(t, rules[1].Token)
+
+	for _, invalid := range []string{"", "10.0.0.0/8 not-a-token", "10.0.0.0/8 " + token + " extra"} {
+		_, err := parseTCPAuthRules([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseWebhookTLS(t *testing.T) {
+	settings, err := parseWebhookTLS([]string{
+		"hooks.example.com cert=/etc/ntfy/client.crt key=/etc/ntfy/client.key ca=/etc/ntfy/ca.pem",
+		" *.internal.example.com  ca=/etc/ntfy/ca.pem ",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(settings))
+	require.Equal(t, &server.WebhookTLS{Host: "hooks.example.com", CertFile: "/etc/ntfy/client.crt", KeyFile: "/etc/ntfy/client.key", CAFile: "/etc/ntfy/ca.pem"}, settings[0])
+	require.Equal(t, &server.WebhookTLS{Host: "*.internal.example.com", CAFile: "/etc/ntfy/ca.pem"}, settings[1])
+
+	for _, invalid := range []string{"hooks.example.com", "https://hooks.example.com ca=ca.pem", "hooks.example.com cert=client.crt", "hooks.example.com ca=", "hooks.example.com crl=crl.pem", "*. ca=ca.pem"} {
+		_, err := parseWebhookTLS([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseMatrixBridgeRooms(t *testing.T) {
+	rooms, err := parseMatrixBridgeRooms([]string{
+		"alerts:!abc123:example.com",
+		" alerts : !def456:matrix.org:8448 ",
+		"backups:!abc123:example.com",
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"!abc123:example.com", "!def456:matrix.org:8448"}, rooms["alerts"])
+	require.Equal(t, []string{"!abc123:example.com"}, rooms["backups"])
+
+	for _, invalid := range []string{"alerts", "alerts:#alias:example.com", "alerts:!abc123", "my topic:!abc:example.com", ":!abc:example.com"} {
+		_, err := parseMatrixBridgeRooms([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+	_, err = parseMatrixBridgeRooms([]string{"alerts:!abc:example.com", "alerts:!abc:example.com"})
+	require.Error(t, err)
+}
+
+func TestParseTelegramRelays(t *testing.T) {
+	relays, err := parseTelegramRelays([]string{
+		"alerts:-1001234567890",
+		" alerts : @mychannel ",
+		"backups:123456",
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"-1001234567890", "@mychannel"}, relays["alerts"])
+	require.Equal(t, []string{"123456"}, relays["backups"])
+
+	for _, invalid := range []string{"alerts", "alerts:abc", "alerts:@ab", "my topic:123", ":123"} {
+		_, err := parseTelegramRelays([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+	_, err = parseTelegramRelays([]string{"alerts:123", "alerts:123"})
+	require.Error(t, err)
+}
+
+func TestParseRateLimitWindows(t *testing.T) {
+	windows, err := parseRateLimitWindows([]string{
+		"02:00-04:00 -> 10",
+		" 22:30 - 06:00->0.5 ",
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(windows))
+	require.Equal(t, 2*time.Hour, windows[0].Start)
+	require.Equal(t, 4*time.Hour, windows[0].End)
+	require.Equal(t, float64(10), windows[0].Factor)
+	require.Equal(t, 22*time.Hour+30*time.Minute, windows[1].Start)
+	require.Equal(t, 6*time.Hour, windows[1].End)
+	require.Equal(t, 0.5, windows[1].Factor)
+
+	for _, invalid := range []string{"02:00-04:00", "02:00 -> 10", "2am-4am -> 10", "02:00-25:00 -> 10", "02:00-02:00 -> 10", "02:00-04:00 -> 0", "02:00-04:00 -> x"} {
+		_, err := parseRateLimitWindows([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseCountryRequestLimitFactors(t *testing.T) {
+	factors, err := parseCountryRequestLimitFactors([]string{"XY -> 0.2", " de->3 "})
+	require.Nil(t, err)
+	require.Equal(t, map[string]float64{"XY": 0.2, "DE": 3}, factors)
+
+	for _, invalid := range []string{"XY", "XYZ -> 1", "-> 1", "XY -> 0", "XY -> x"} {
+		_, err := parseCountryRequestLimitFactors([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+	codes, err := parseCountryCodes([]string{"kp", " XY "})
+	require.Nil(t, err)
+	require.Equal(t, []string{"KP", "XY"}, codes)
+	_, err = parseCountryCodes([]string{"Germany"})
+	require.Error(t, err)
+}
+
+func TestParseClusterPeers(t *testing.T) {
+	peers, err := parseClusterPeers([]string{"https://ntfy2.example.com/", " http://10.0.0.3:8080 "}, "https://ntfy1.example.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"https://ntfy2.example.com", "http://10.0.0.3:8080"}, peers)
+
+	_, err = parseClusterPeers([]string{"ntfy2.example.com"}, "")
+	require.Error(t, err)
+	_, err = parseClusterPeers([]string{"https://ntfy1.example.com/"}, "https://ntfy1.example.com")
+	require.Error(t, err)
+}
+
+func TestParseStripeInvoiceCustomFields(t *testing.T) {
+	fields, err := parseStripeInvoiceCustomFields([]string{"VAT number: DE123456789", " Registration:HRB 12345 "})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(fields))
+	require.Equal(t, "VAT number", fields[0].Name)
+	require.Equal(t, "DE123456789", fields[0].Value)
+	require.Equal(t, "Registration", fields[1].Name)
+	require.Equal(t, "HRB 12345", fields[1].Value)
+
+	for _, invalid := range []string{"no colon", ": value", "name:", "a very long custom field name that exceeds the limit: value"} {
+		_, err := parseStripeInvoiceCustomFields([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+	_, err = parseStripeInvoiceCustomFields([]string{"a: 1", "b: 2", "c: 3", "d: 4", "e: 5"})
+	require.Error(t, err)
+}
+
+func TestParseExperiments(t *testing.T) {
+	experiments, err := parseExperiments([]string{"new-web-ui:10", " fast_cache : 100% "})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(experiments))
+	require.Equal(t, "new-web-ui", experiments[0].Name)
+	require.Equal(t, 10, experiments[0].Percentage)
+	require.Equal(t, "fast_cache", experiments[1].Name)
+	require.Equal(t, 100, experiments[1].Percentage)
+
+	for _, invalid := range []string{"new-web-ui", "new-web-ui:", "New UI:10", "new-web-ui:101", "new-web-ui:-1", "new-web-ui:x"} {
+		_, err := parseExperiments([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+	_, err = parseExperiments([]string{"new-web-ui:10", "new-web-ui:20"})
+	require.Error(t, err)
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "server.yml")
+	require.Nil(t, os.WriteFile(filename, []byte(`
+base-url: https://ntfy.example.com
+auth-default-access: deny-all
+visitor-request-limit-burst: 30
+visitor_email_limit_burst: 5
+`), 0600))
+
+	// Command line arguments take precedence over the config file
+	conf, err := loadServerConfig([]string{"--config", filename, "--visitor-request-limit-burst", "99"}, "1.2.3")
+	require.Nil(t, err)
+	require.Equal(t, filename, conf.File)
+	require.Equal(t, "https://ntfy.example.com", conf.BaseURL)
+	require.Equal(t, user.PermissionDenyAll, conf.AuthDefault)
+	require.Equal(t, 99, conf.VisitorRequestLimitBurst)
+	require.Equal(t, 5, conf.VisitorEmailLimitBurst)
+	require.Equal(t, "1.2.3", conf.Version)
+
+	// Invalid config is rejected
+	require.Nil(t, os.WriteFile(filename, []byte(`keepalive-interval: 1s`), 0600))
+	_, err = loadServerConfig([]string{"--config", filename}, "1.2.3")
+	require.ErrorContains(t, err, "keepalive interval cannot be lower than five seconds")
+}
+
+func TestCommandArgs(t *testing.T) {
+	require.Equal(t, []string{"--listen-http", ":80"}, commandArgs([]string{"ntfy", "--debug", "serve", "--listen-http", ":80"}, "serve"))
+	require.Equal(t, []string{}, commandArgs([]string{"ntfy", "serve"}, "serve"))
+	require.Nil(t, commandArgs([]string{"ntfy"}, "serve"))
+}
@@ -1,18 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
 	"heckel.io/ntfy/v2/client"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -31,9 +34,20 @@ var flagsSubscribe = append(
 	&cli.StringFlag{Name: "since", Aliases: []string{"s"}, Usage: "return events since `SINCE` (Unix timestamp, or all)"},
 	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
 	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+	&cli.StringFlag{Name: "proxy", EnvVars: []string{"NTFY_PROXY"}, Usage: "HTTP or SOCKS5 proxy URL, e.g. socks5h://127.0.0.1:9050 for Tor"},
 	&cli.BoolFlag{Name: "from-config", Aliases: []string{"from_config", "C"}, Usage: "read subscriptions from config file (service mode)"},
 	&cli.BoolFlag{Name: "poll", Aliases: []string{"p"}, Usage: "return events and exit, do not listen for new events"},
 	&cli.BoolFlag{Name: "scheduled", Aliases: []string{"sched", "S"}, Usage: "also return scheduled/delayed events"},
+	&cli.StringFlag{Name: "state-file", Aliases: []string{"state_file"}, EnvVars: []string{"NTFY_STATE_FILE"}, Usage: "remember last seen message per topic in `FILE`, only return new events (requires --poll)"},
+	&cli.StringFlag{Name: "encryption-password", Aliases: []string{"encryption_password", "E"}, EnvVars: []string{"NTFY_ENCRYPTION_PASSWORD"}, Usage: "decrypt end-to-end encrypted messages using this password"},
+	&cli.StringFlag{Name: "verify", EnvVars: []string{"NTFY_VERIFY"}, Usage: "only accept messages signed with the Ed25519 private key belonging to the public key in `FILE` (PEM)"},
+	&cli.StringFlag{Name: "verify-action", Aliases: []string{"verify_action"}, EnvVars: []string{"NTFY_VERIFY_ACTION"}, Usage: "what to do with unsigned or invalid messages, 'drop' or 'flag' (default: drop)"},
+	&cli.IntFlag{Name: "max-concurrent", Aliases: []string{"max_concurrent"}, EnvVars: []string{"NTFY_MAX_CONCURRENT"}, Value: defaultCommandMaxConcurrent, Usage: "max number of commands running at the same time"},
+	&cli.IntFlag{Name: "queue-size", Aliases: []string{"queue_size"}, EnvVars: []string{"NTFY_QUEUE_SIZE"}, Value: defaultCommandQueueSize, Usage: "max number of messages waiting for a command to run, more are dropped"},
+	&cli.DurationFlag{Name: "timeout", EnvVars: []string{"NTFY_TIMEOUT"}, Usage: "kill commands that run longer than this, e.g. 30s (default: no timeout)"},
+	&cli.StringFlag{Name: "download-dir", Aliases: []string{"download_dir"}, EnvVars: []string{"NTFY_DOWNLOAD_DIR"}, Usage: "automatically download attachments to `DIR`, the path is passed to commands as $NTFY_ATTACHMENT_PATH"},
+	&cli.StringFlag{Name: "download-max-size", Aliases: []string{"download_max_size"}, EnvVars: []string{"NTFY_DOWNLOAD_MAX_SIZE"}, Usage: "only download attachments up to this size, e.g. 2M (default: " + defaultDownloadMaxSize + ")"},
+	&cli.StringFlag{Name: "download-keep", Aliases: []string{"download_keep"}, EnvVars: []string{"NTFY_DOWNLOAD_KEEP"}, Usage: "delete downloaded attachments after this duration, e.g. 7d (default: keep forever)"},
 )
 
 var cmdSubscribe = &cli.Command{
@@ -57,7 +71,10 @@ ntfy subscribe TOPIC
     ntfy subscribe mytopic            # Prints JSON for incoming messages for ntfy.sh/mytopic
     ntfy sub home.lan/backups         # Subscribe to topic on different server
     ntfy sub --poll home.lan/backups  # Just query for latest messages and exit
+    ntfy sub --poll --state-file ~/.cache/ntfy/state backups  # Only return messages not seen in previous polls
     ntfy sub -u phil:mypass secret    # Subscribe with username/password
+    ntfy sub -E mypassword secret     # Decrypt end-to-end encrypted messages
+    ntfy sub --verify pubkey.pem deploys  # Drop messages not signed with 'ntfy pub --sign'
   
 ntfy subscribe TOPIC COMMAND
   This executes COMMAND for every incoming messages. The message fields are passed to the
@@ -74,9 +91,28 @@ ntfy subscribe TOPIC COMMAND
     $NTFY_TAGS      $tags, $tag, $ta      Message tags (comma separated list)
     $NTFY_RAW       $raw                  Raw JSON message
 
+    $NTFY_EVENT     $event                Event type (usually "message")
+    $NTFY_CLICK     $click                URL to open when the notification is clicked
+    $NTFY_ICON      $icon                 URL of the notification icon
+    $NTFY_REPLY_TO  $reply_to             ID of the message this message replies to
+    $NTFY_ATTACHMENT_NAME                 Name of the attachment
+    $NTFY_ATTACHMENT_TYPE                 MIME type of the attachment
+    $NTFY_ATTACHMENT_SIZE                 Size of the attachment in bytes
+    $NTFY_ATTACHMENT_URL                  URL of the attachment
+    $NTFY_ATTACHMENT_PATH                 Local file of the attachment (see --download-dir)
+
+  Commands run one at a time by default. Use --max-concurrent to run more in parallel, --queue-size
+  to limit how many messages may wait for a command (further messages are dropped), and --timeout
+  to kill commands that run too long.
+
+  With --download-dir, attachments up to --download-max-size (default: 10M) are downloaded before
+  the command runs. Use --download-keep to delete downloaded files after a while.
+
   Examples:
     ntfy sub mytopic 'notify-send "$m"'    # Execute command for incoming messages
     ntfy sub topic1 myscript.sh            # Execute script for incoming messages
+    ntfy sub --max-concurrent=4 --timeout=1m topic1 myscript.sh  # Run up to 4 scripts at a time, for max. 1 minute
+    ntfy sub --download-dir=/tmp/ntfy scans 'lpr "$NTFY_ATTACHMENT_PATH"'  # Print incoming files
 
 ntfy subscribe --from-config
   Service mode (used in ntfy-client.service). This reads the config file and sets up 
@@ -102,12 +138,27 @@ func execSubscribe(c *cli.Context) error {
 	poll := c.Bool("poll")
 	scheduled := c.Bool("scheduled")
 	fromConfig := c.Bool("from-config")
+	stateFile := c.String("state-file")
+	maxConcurrent := c.Int("max-concurrent")
+	queueSize := c.Int("queue-size")
+	timeout := c.Duration("timeout")
+	encryptionPassword := c.String("encryption-password")
+	verifyKeyFile := c.String("verify")
+	verifyAction := c.String("verify-action")
 	topic := c.Args().Get(0)
 	command := c.Args().Get(1)
 
 	// Checks
 	if user != "" && token != "" {
 		return errors.New("cannot set both --user and --token")
+	} else if stateFile != "" && !poll {
+		return errors.New("cannot set --state-file without --poll")
+	} else if maxConcurrent < 1 {
+		return errors.New("--max-concurrent must be at least 1")
+	} else if queueSize < 0 || timeout < 0 {
+		return errors.New("--queue-size and --timeout must not be negative")
+	} else if verifyAction != "" && verifyKeyFile == "" {
+		return errors.New("cannot set --verify-action without --verify")
 	}
 
 	if !fromConfig {
@@ -147,44 +198,87 @@ func execSubscribe(c *cli.Context) error {
 		return errors.New("must specify topic, type 'ntfy subscribe --help' for help")
 	}
 
+	downloader, err := maybeCreateAttachmentDownloader(c, cl, conf)
+	if err != nil {
+		return err
+	}
+	var verifier *messageVerifier
+	if verifyKeyFile != "" {
+		if verifier, err = newMessageVerifier(verifyKeyFile, verifyAction); err != nil {
+			return err
+		}
+	}
+
 	// Execute poll or subscribe
 	if poll {
-		return doPoll(c, cl, conf, topic, command, options...)
+		var state *pollState
+		if stateFile != "" {
+			if state, err = loadPollState(stateFile); err != nil {
+				return fmt.Errorf("cannot read state file %s: %w", stateFile, err)
+			}
+		}
+		runner := newCommandRunner(c, downloader, maxConcurrent, queueSize, timeout, false)
+		defer runner.Close()
+		return doPoll(c, cl, conf, runner, verifier, state, topic, command, encryptionPassword, options...)
 	}
-	return doSubscribe(c, cl, conf, topic, command, options...)
+	runner := newCommandRunner(c, downloader, maxConcurrent, queueSize, timeout, true)
+	defer runner.Close()
+	return doSubscribe(c, cl, conf, runner, verifier, topic, command, encryptionPassword, options...)
 }
 
-func doPoll(c *cli.Context, cl *client.Client, conf *client.Config, topic, command string, options ...client.SubscribeOption) error {
+func doPoll(c *cli.Context, cl *client.Client, conf *client.Config, runner *commandRunner, verifier *messageVerifier, state *pollState, topic, command, encryptionPassword string, options ...client.SubscribeOption) error {
 	for _, s := range conf.Subscribe { // may be nil
 		if auth := maybeAddAuthHeader(s, conf); auth != nil {
 			options = append(options, auth)
 		}
-		if err := doPollSingle(c, cl, s.Topic, s.Command, options...); err != nil {
+		if err := doPollSingle(c, cl, runner, verifier, state, s.Topic, s.Command, subscriptionEncryptionPassword(s), options...); err != nil {
 			return err
 		}
 	}
 	if topic != "" {
-		if err := doPollSingle(c, cl, topic, command, options...); err != nil {
+		if err := doPollSingle(c, cl, runner, verifier, state, topic, command, encryptionPassword, options...); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func doPollSingle(c *cli.Context, cl *client.Client, topic, command string, options ...client.SubscribeOption) error {
+func doPollSingle(c *cli.Context, cl *client.Client, runner *commandRunner, verifier *messageVerifier, state *pollState, topic, command, encryptionPassword string, options ...client.SubscribeOption) error {
+	var topicURL string
+	if state != nil {
+		var err error
+		if topicURL, err = cl.TopicURL(topic); err != nil {
+			return err
+		}
+		if since := state.Since(topicURL); since != "" {
+			// Prepended, because the server uses the first "since" parameter, i.e. this overrides --since
+			options = append([]client.SubscribeOption{client.WithSince(since)}, options...)
+		}
+	}
 	messages, err := cl.Poll(topic, options...)
 	if err != nil {
 		return err
 	}
 	for _, m := range messages {
-		printMessageOrRunCommand(c, m, command)
+		if !verifier.Verify(m) {
+			continue
+		}
+		maybeDecryptMessage(m, encryptionPassword)
+		printMessageOrRunCommand(c, runner, m, command, options...)
+	}
+	if state != nil {
+		if err := state.Update(topicURL, messages); err != nil {
+			return fmt.Errorf("cannot write state file: %w", err)
+		}
 	}
 	return nil
 }
 
-func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic, command string, options ...client.SubscribeOption) error {
-	cmds := make(map[string]string)    // Subscription ID -> command
-	for _, s := range conf.Subscribe { // May be nil
+func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, runner *commandRunner, verifier *messageVerifier, topic, command, encryptionPassword string, options ...client.SubscribeOption) error {
+	cmds := make(map[string]string)                         // Subscription ID -> command
+	passwords := make(map[string]string)                    // Subscription ID -> encryption password
+	subOptions := make(map[string][]client.SubscribeOption) // Subscription ID -> options, used for downloading attachments
+	for _, s := range conf.Subscribe {                      // May be nil
 		topicOptions := append(make([]client.SubscribeOption, 0), options...)
 		for filter, value := range s.If {
 			topicOptions = append(topicOptions, client.WithFilter(filter, value))
@@ -205,6 +299,8 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 		} else {
 			cmds[subscriptionID] = ""
 		}
+		passwords[subscriptionID] = subscriptionEncryptionPassword(s)
+		subOptions[subscriptionID] = topicOptions
 	}
 	if topic != "" {
 		subscriptionID, err := cl.Subscribe(topic, options...)
@@ -212,6 +308,8 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 			return err
 		}
 		cmds[subscriptionID] = command
+		passwords[subscriptionID] = encryptionPassword
+		subOptions[subscriptionID] = options
 	}
 	for m := range cl.Messages {
 		cmd, ok := cmds[m.SubscriptionID]
@@ -219,11 +317,33 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 			continue
 		}
 		log.Debug("%s Dispatching received message: %s", logMessagePrefix(m), m.Raw)
-		printMessageOrRunCommand(c, m, cmd)
+		if !verifier.Verify(m) {
+			continue
+		}
+		maybeDecryptMessage(m, passwords[m.SubscriptionID])
+		printMessageOrRunCommand(c, runner, m, cmd, subOptions[m.SubscriptionID]...)
 	}
 	return nil
 }
 
+func subscriptionEncryptionPassword(s client.Subscribe) string {
+	if s.EncryptionPassword != nil {
+		return *s.EncryptionPassword
+	}
+	return ""
+}
+
+// maybeDecryptMessage decrypts an end-to-end encrypted message, if a password is given. If decryption fails,
+// the message is passed on as is, so that it is not lost.
+func maybeDecryptMessage(m *client.Message, encryptionPassword string) {
+	if m.Encryption == "" || encryptionPassword == "" {
+		return
+	}
+	if err := m.Decrypt(encryptionPassword); err != nil {
+		log.Warn("%s Cannot decrypt message: %s", logMessagePrefix(m), err.Error())
+	}
+}
+
 func maybeAddAuthHeader(s client.Subscribe, conf *client.Config) client.SubscribeOption {
 	// if an explicit empty token or empty user:pass is given, exit without auth
 	if (s.Token != nil && *s.Token == "") || (s.User != nil && *s.User == "" && s.Password != nil && *s.Password == "") {
@@ -248,22 +368,17 @@ func maybeAddAuthHeader(s client.Subscribe, conf *client.Config) client.Subscrib
 	return nil
 }
 
-func printMessageOrRunCommand(c *cli.Context, m *client.Message, command string) {
+func printMessageOrRunCommand(c *cli.Context, runner *commandRunner, m *client.Message, command string, options ...client.SubscribeOption) {
 	if command != "" {
-		runCommand(c, command, m)
+		runner.Run(command, m, options...)
 	} else {
+		runner.downloader.Download(m, options...)
 		log.Debug("%s Printing raw message", logMessagePrefix(m))
 		fmt.Fprintln(c.App.Writer, m.Raw)
 	}
 }
 
-func runCommand(c *cli.Context, command string, m *client.Message) {
-	if err := runCommandInternal(c, command, m); err != nil {
-		log.Warn("%s Command failed: %s", logMessagePrefix(m), err.Error())
-	}
-}
-
-func runCommandInternal(c *cli.Context, script string, m *client.Message) error {
+func runCommandInternal(c *cli.Context, script string, m *client.Message, attachmentPath string, timeout time.Duration, stdout, stderr io.Writer) error {
 	scriptFile := fmt.Sprintf("%s/ntfy-subscribe-%s.%s", os.TempDir(), util.RandomString(10), scriptExt)
 	log.Debug("%s Running command '%s' via temporary script %s", logMessagePrefix(m), script, scriptFile)
 	script = scriptHeader + script
@@ -272,15 +387,27 @@ func runCommandInternal(c *cli.Context, script string, m *client.Message) error
 	}
 	defer os.Remove(scriptFile)
 	log.Debug("%s Executing script %s", logMessagePrefix(m), scriptFile)
-	cmd := exec.Command(scriptLauncher[0], append(scriptLauncher[1:], scriptFile)...)
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, scriptLauncher[0], append(scriptLauncher[1:], scriptFile)...)
 	cmd.Stdin = c.App.Reader
-	cmd.Stdout = c.App.Writer
-	cmd.Stderr = c.App.ErrWriter
-	cmd.Env = envVars(m)
-	return cmd.Run()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = envVars(m, attachmentPath)
+	cmd.WaitDelay = time.Second // Do not wait for orphaned child processes holding on to stdout/stderr after a timeout
+	if err := cmd.Run(); ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out after %s", timeout)
+	} else if err != nil {
+		return err
+	}
+	return nil
 }
 
-func envVars(m *client.Message) []string {
+func envVars(m *client.Message, attachmentPath string) []string {
 	env := make([]string, 0)
 	env = append(env, envVar(m.ID, "NTFY_ID", "id")...)
 	env = append(env, envVar(m.Topic, "NTFY_TOPIC", "topic")...)
@@ -289,6 +416,19 @@ func envVars(m *client.Message) []string {
 	env = append(env, envVar(m.Title, "NTFY_TITLE", "title", "t")...)
 	env = append(env, envVar(fmt.Sprintf("%d", m.Priority), "NTFY_PRIORITY", "priority", "prio", "p")...)
 	env = append(env, envVar(strings.Join(m.Tags, ","), "NTFY_TAGS", "tags", "tag", "ta")...)
+	env = append(env, envVar(m.Event, "NTFY_EVENT", "event")...)
+	env = append(env, envVar(m.Click, "NTFY_CLICK", "click")...)
+	env = append(env, envVar(m.Icon, "NTFY_ICON", "icon")...)
+	env = append(env, envVar(m.ReplyTo, "NTFY_REPLY_TO", "reply_to")...)
+	if m.Attachment != nil {
+		env = append(env, envVar(m.Attachment.Name, "NTFY_ATTACHMENT_NAME")...)
+		env = append(env, envVar(m.Attachment.Type, "NTFY_ATTACHMENT_TYPE")...)
+		env = append(env, envVar(fmt.Sprintf("%d", m.Attachment.Size), "NTFY_ATTACHMENT_SIZE")...)
+		env = append(env, envVar(m.Attachment.URL, "NTFY_ATTACHMENT_URL")...)
+	}
+	if attachmentPath != "" {
+		env = append(env, envVar(attachmentPath, "NTFY_ATTACHMENT_PATH")...)
+	}
 	env = append(env, envVar(m.Raw, "NTFY_RAW", "raw")...)
 	sort.Strings(env)
 	if log.IsTrace() {
@@ -305,7 +445,53 @@ func envVar(value string, vars ...string) []string {
 	return env
 }
 
+// maybeCreateAttachmentDownloader returns a downloader if attachments should be downloaded automatically, i.e. if
+// --download-dir or download-dir (in the config file) is set. Command line flags override the config file.
+func maybeCreateAttachmentDownloader(c *cli.Context, cl *client.Client, conf *client.Config) (*attachmentDownloader, error) {
+	dir, maxSizeStr, keepStr := conf.DownloadDir, conf.DownloadMaxSize, conf.DownloadKeep
+	if c.String("download-dir") != "" {
+		dir = c.String("download-dir")
+	}
+	if c.String("download-max-size") != "" {
+		maxSizeStr = c.String("download-max-size")
+	}
+	if c.String("download-keep") != "" {
+		keepStr = c.String("download-keep")
+	}
+	if dir == "" {
+		return nil, nil
+	} else if maxSizeStr == "" {
+		maxSizeStr = defaultDownloadMaxSize
+	}
+	maxSize, err := util.ParseSize(maxSizeStr)
+	if err != nil || maxSize <= 0 {
+		return nil, fmt.Errorf("invalid download max size: %s", maxSizeStr)
+	}
+	var keep time.Duration
+	if keepStr != "" {
+		if keep, err = util.ParseDuration(keepStr); err != nil || keep < 0 {
+			return nil, fmt.Errorf("invalid download keep duration: %s", keepStr)
+		}
+	}
+	downloader, err := newAttachmentDownloader(cl, dir, maxSize, keep)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create download directory %s: %w", dir, err)
+	}
+	return downloader, nil
+}
+
 func loadConfig(c *cli.Context) (*client.Config, error) {
+	conf, err := loadConfigFile(c)
+	if err != nil {
+		return nil, err
+	}
+	if proxy := c.String("proxy"); proxy != "" {
+		conf.Proxy = proxy // Overrides the proxy of the config file
+	}
+	return conf, nil
+}
+
+func loadConfigFile(c *cli.Context) (*client.Config, error) {
 	filename := c.String("config")
 	if filename != "" {
 		return client.LoadConfig(filename)
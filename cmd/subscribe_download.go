@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDownloadMaxSize    = "10M"
+	downloadCleanupInterval   = time.Minute
+	downloadFilenameMaxLength = 100
+)
+
+var downloadFilenameDisallowedCharsRegex = regexp.MustCompile(`[^-_.A-Za-z0-9]+`)
+
+// attachmentDownloader downloads the attachments of incoming messages to a local directory (see --download-dir),
+// if they are not larger than a size threshold (--download-max-size). Files are named after a hash of the attachment
+// URL, so that the same attachment is only downloaded once, and are deleted after a while (--download-keep).
+type attachmentDownloader struct {
+	client      *client.Client
+	dir         string
+	maxSize     int64
+	keep        time.Duration // Zero means files are kept forever
+	lastCleanup time.Time
+	mu          sync.Mutex // Serializes downloads, so that an attachment is never downloaded twice at the same time
+}
+
+func newAttachmentDownloader(cl *client.Client, dir string, maxSize int64, keep time.Duration) (*attachmentDownloader, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &attachmentDownloader{
+		client:  cl,
+		dir:     dir,
+		maxSize: maxSize,
+		keep:    keep,
+	}, nil
+}
+
+// Download downloads the attachment of the message (if any) and returns the path of the local file. If the
+// attachment was downloaded before, the existing file is used. An empty path is returned if the message has no
+// attachment, if the attachment is too large, or if the download failed. The downloader may be nil.
+func (d *attachmentDownloader) Download(m *client.Message, options ...client.SubscribeOption) string {
+	if d == nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maybeCleanup()
+	if m.Attachment == nil || m.Attachment.URL == "" {
+		return ""
+	}
+	filename := filepath.Join(d.dir, downloadFilename(m.Attachment))
+	if _, err := os.Stat(filename); err == nil {
+		now := time.Now()
+		os.Chtimes(filename, now, now) // Keep it around for another --download-keep period
+		log.Debug("%s Attachment already downloaded to %s", logMessagePrefix(m), filename)
+		return filename
+	}
+	if err := d.download(m, filename, options...); errors.Is(err, client.ErrAttachmentTooLarge) {
+		log.Info("%s Not downloading attachment, it is larger than %d bytes", logMessagePrefix(m), d.maxSize)
+		return ""
+	} else if err != nil {
+		log.Warn("%s Cannot download attachment: %s", logMessagePrefix(m), err.Error())
+		return ""
+	}
+	log.Debug("%s Attachment downloaded to %s", logMessagePrefix(m), filename)
+	return filename
+}
+
+func (d *attachmentDownloader) download(m *client.Message, filename string, options ...client.SubscribeOption) error {
+	partialFilename := filename + ".part"
+	f, err := os.OpenFile(partialFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partialFilename) // No-op after successful rename
+	if err := d.client.DownloadAttachment(m, f, d.maxSize, options...); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partialFilename, filename) // Commands never see partial files
+}
+
+// maybeCleanup removes files that have not been downloaded or reused for longer than the keep duration
+func (d *attachmentDownloader) maybeCleanup() {
+	if d.keep <= 0 || time.Since(d.lastCleanup) < downloadCleanupInterval {
+		return
+	}
+	d.lastCleanup = time.Now()
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		log.Warn("Cannot clean up download directory %s: %s", d.dir, err.Error())
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || time.Since(info.ModTime()) < d.keep {
+			continue
+		}
+		filename := filepath.Join(d.dir, entry.Name())
+		if err := os.Remove(filename); err != nil {
+			log.Warn("Cannot remove expired download %s: %s", filename, err.Error())
+		} else {
+			log.Debug("Removed expired download %s", filename)
+		}
+	}
+}
+
+// downloadFilename returns the local filename of an attachment, e.g. 3f5a1b2c4d6e7f80-report.pdf. The prefix is
+// derived from the attachment URL, so that the same attachment always ends up in the same file.
+func downloadFilename(attachment *client.Attachment) string {
+	hash := sha256.Sum256([]byte(attachment.URL))
+	name := downloadFilenameDisallowedCharsRegex.ReplaceAllString(filepath.Base(attachment.Name), "_")
+	if name == "" || name == "." || name == ".." || name == "_" {
+		name = "attachment"
+	} else if len(name) > downloadFilenameMaxLength {
+		name = name[len(name)-downloadFilenameMaxLength:] // Keep the extension
+	}
+	return hex.EncodeToString(hash[:8]) + "-" + name
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCommandMaxConcurrent = 1
+	defaultCommandQueueSize     = 100
+)
+
+// commandRunner executes the commands of incoming messages (see "ntfy subscribe TOPIC COMMAND") using a fixed number
+// of workers (--max-concurrent), so that a burst of messages cannot start an unbounded number of processes. Messages
+// wait in a queue of limited size (--queue-size) until a worker is available.
+type commandRunner struct {
+	c          *cli.Context
+	downloader *attachmentDownloader // Downloads attachments before running the command, may be nil
+	queue      chan *commandJob
+	timeout    time.Duration
+	dropFull   bool // Drop messages if the queue is full, instead of waiting for a free slot
+	wg         sync.WaitGroup
+}
+
+type commandJob struct {
+	command string
+	m       *client.Message
+	options []client.SubscribeOption // Used to download the attachment, e.g. for auth
+}
+
+func newCommandRunner(c *cli.Context, downloader *attachmentDownloader, maxConcurrent, queueSize int, timeout time.Duration, dropFull bool) *commandRunner {
+	r := &commandRunner{
+		c:          c,
+		downloader: downloader,
+		queue:      make(chan *commandJob, queueSize),
+		timeout:    timeout,
+		dropFull:   dropFull,
+	}
+	// Commands write to the same output, so writes must be synchronized if they run concurrently
+	out, errOut := &syncWriter{w: c.App.Writer}, &syncWriter{w: c.App.ErrWriter}
+	for i := 0; i < maxConcurrent; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for job := range r.queue {
+				r.run(job, out, errOut)
+			}
+		}()
+	}
+	return r
+}
+
+// Run queues the command for the given message. If the queue is full, the message is either dropped (in subscribe
+// mode, so that the subscription is not blocked), or the method blocks until there is space (in poll mode).
+func (r *commandRunner) Run(command string, m *client.Message, options ...client.SubscribeOption) {
+	job := &commandJob{command: command, m: m, options: options}
+	if !r.dropFull {
+		r.queue <- job
+		return
+	}
+	select {
+	case r.queue <- job:
+	default:
+		log.Warn("%s Command queue is full (%d messages), dropping message", logMessagePrefix(m), cap(r.queue))
+	}
+}
+
+// Close waits for all queued commands to finish
+func (r *commandRunner) Close() {
+	close(r.queue)
+	r.wg.Wait()
+}
+
+func (r *commandRunner) run(job *commandJob, out, errOut io.Writer) {
+	attachmentPath := r.downloader.Download(job.m, job.options...)
+	if err := runCommandInternal(r.c, job.command, job.m, attachmentPath, r.timeout, out, errOut); err != nil {
+		log.Warn("%s Command failed: %s", logMessagePrefix(job.m), err.Error())
+	}
+}
+
+type syncWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"heckel.io/ntfy/v2/client"
+	"os"
+	"path/filepath"
+)
+
+// pollState remembers the ID of the last message that was polled for each topic (see --state-file), so
+// that repeated polls (e.g. from cron) only return messages that were not seen before
+type pollState struct {
+	filename string
+	Topics   map[string]string `json:"topics"` // Topic URL -> ID of the last seen message
+}
+
+// loadPollState reads the state file, or returns an empty state if the file does not exist yet
+func loadPollState(filename string) (*pollState, error) {
+	state := &pollState{
+		filename: filename,
+		Topics:   make(map[string]string),
+	}
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	if state.Topics == nil {
+		state.Topics = make(map[string]string)
+	}
+	return state, nil
+}
+
+// Since returns the ID of the last seen message of the topic, or an empty string if the topic was never polled
+func (s *pollState) Since(topicURL string) string {
+	return s.Topics[topicURL]
+}
+
+// Update remembers the last of the given messages as seen, and writes the state file
+func (s *pollState) Update(topicURL string, messages []*client.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	s.Topics[topicURL] = messages[len(messages)-1].ID
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.filename), 0700); err != nil {
+		return err
+	}
+	tmpFile := s.filename + ".tmp"
+	if err := os.WriteFile(tmpFile, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.filename) // Atomic, so that an interrupted write does not lose the state
+}
@@ -1,14 +1,21 @@
 package cmd
 
 import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/test"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestCLI_Subscribe_Default_UserPass_Subscription_Token(t *testing.T) {
@@ -415,3 +422,184 @@ subscribe:
 
 	require.Equal(t, message, strings.TrimSpace(stdout.String()))
 }
+
+func TestCLI_Subscribe_Poll_StateFile(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+	stateFile := filepath.Join(t.TempDir(), "cache", "state")
+
+	for _, message := range []string{"first", "second"} {
+		app, _, _, _ := newTestApp()
+		require.Nil(t, app.Run([]string{"ntfy", "publish", topic, message}))
+	}
+
+	// First poll returns all messages, subsequent polls only the ones that were not seen before
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--state-file", stateFile, topic}))
+	require.Equal(t, 2, len(strings.Split(strings.TrimSpace(stdout.String()), "\n")))
+	require.FileExists(t, stateFile)
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--state-file", stateFile, topic}))
+	require.Empty(t, strings.TrimSpace(stdout.String()))
+
+	app, _, _, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", topic, "third"}))
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--since", "all", "--state-file", stateFile, topic}))
+	require.Equal(t, 1, len(strings.Split(strings.TrimSpace(stdout.String()), "\n")))
+	require.Equal(t, "third", toMessage(t, stdout.String()).Message)
+
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "subscribe", "--state-file", stateFile, topic}), "cannot set --state-file without --poll")
+}
+
+func TestCLI_Subscribe_Poll_Verify(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/deploys", port)
+	keyFile, pubKeyFile := writeTestKeyPair(t)
+	_, otherPubKeyFile := writeTestKeyPair(t)
+
+	app, _, _, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--sign", keyFile, "--title", "Deploy", topic, "deployed v1.2.3"}))
+	app, _, _, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", topic, "unsigned"}))
+	app, _, _, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--sign", keyFile, "-E", "s3cret", topic, "encrypted"}))
+
+	// Unsigned messages are dropped, encrypted messages are verified before they are decrypted
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--verify", pubKeyFile, "-E", "s3cret", topic}))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 2, len(lines))
+	require.Equal(t, "deployed v1.2.3", toMessage(t, lines[0]).Message)
+	require.Equal(t, "encrypted", toMessage(t, lines[1]).Message)
+
+	// Unverified messages are flagged
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--verify", pubKeyFile, "--verify-action", "flag", topic}))
+	lines = strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 3, len(lines))
+	require.Contains(t, lines[0], `"verified":true`)
+	require.Contains(t, lines[1], `"verified":false`)
+	require.Equal(t, "unsigned", toMessage(t, lines[1]).Message)
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--verify", otherPubKeyFile, "--verify-action", "flag", topic, `echo "$m $NTFY_RAW" | grep -c '"verified":false'`}))
+	require.Equal(t, "1\n1\n1\n", stdout.String())
+
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--verify-action", "flag", topic}), "cannot set --verify-action without --verify")
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--verify", keyFile, topic}), "invalid verification key")
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "publish", "--sign", keyFile, "--file", keyFile, topic}), "cannot set --sign together with --file")
+}
+
+func TestCLI_Subscribe_Poll_Command_EnvVars(t *testing.T) {
+	message := `{"id":"RXIQBFaieLVr","time":124,"event":"message","topic":"mytopic","message":"triggered","click":"https://example.com","attachment":{"name":"log.txt","size":123,"url":"https://example.com/log.txt"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(message))
+	}))
+	defer server.Close()
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", server.URL + "/mytopic", `echo "$NTFY_EVENT $click $NTFY_ATTACHMENT_NAME $NTFY_ATTACHMENT_SIZE"`}))
+	require.Equal(t, "message https://example.com log.txt 123\n", stdout.String())
+}
+
+func TestCLI_Subscribe_Poll_Command_ConcurrencyAndTimeout(t *testing.T) {
+	var messages strings.Builder
+	for i := 0; i < 4; i++ {
+		messages.WriteString(fmt.Sprintf(`{"id":"msg%d","time":124,"event":"message","topic":"mytopic","message":"msg%d"}`+"\n", i, i))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(messages.String()))
+	}))
+	defer server.Close()
+
+	// Four commands that take 500ms each run in parallel
+	start := time.Now()
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--max-concurrent=4", server.URL + "/mytopic", `sleep 0.5; echo "$m"`}))
+	require.Less(t, time.Since(start), 1500*time.Millisecond)
+	require.Equal(t, 4, len(strings.Split(strings.TrimSpace(stdout.String()), "\n")))
+
+	// Commands are killed after the timeout
+	start = time.Now()
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--max-concurrent=4", "--timeout=200ms", server.URL + "/mytopic", `sleep 5; echo "$m"`}))
+	require.Less(t, time.Since(start), 3*time.Second)
+	require.Empty(t, stdout.String())
+
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--max-concurrent=0", server.URL + "/mytopic", "true"}), "--max-concurrent must be at least 1")
+}
+
+func TestCLI_Subscribe_Poll_DownloadAttachments(t *testing.T) {
+	var downloads atomic.Int32
+	var serverURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mytopic/json":
+			fmt.Fprintf(w, `{"id":"msg1","time":124,"event":"message","topic":"mytopic","message":"small","attachment":{"name":"../report.txt","size":11,"url":"%s/file/msg1.txt"}}`+"\n", serverURL)
+			fmt.Fprintf(w, `{"id":"msg2","time":125,"event":"message","topic":"mytopic","message":"large","attachment":{"name":"large.bin","size":2000,"url":"%s/file/msg2.bin"}}`+"\n", serverURL)
+		case "/file/msg1.txt":
+			require.Equal(t, "Bearer tk_secret", r.Header.Get("Authorization"))
+			downloads.Add(1)
+			w.Write([]byte("hello world"))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+	serverURL = server.URL
+	downloadDir := filepath.Join(t.TempDir(), "downloads")
+
+	// Small attachments are downloaded before the command runs, large ones are skipped
+	for i := 0; i < 2; i++ {
+		app, _, stdout, _ := newTestApp()
+		require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--token", "tk_secret", "--download-dir", downloadDir, "--download-max-size", "1k", server.URL + "/mytopic", `echo "$m $(basename "$NTFY_ATTACHMENT_PATH") $(cat "$NTFY_ATTACHMENT_PATH" 2>/dev/null)"`}))
+		require.Contains(t, stdout.String(), "small "+downloadFilename(&client.Attachment{Name: "../report.txt", URL: server.URL + "/file/msg1.txt"})+" hello world\n")
+		require.Contains(t, stdout.String(), "large  \n")
+	}
+	require.Equal(t, int32(1), downloads.Load()) // Second poll reuses the file
+	files, err := os.ReadDir(downloadDir)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(files))
+	require.True(t, strings.HasSuffix(files[0].Name(), "-report.txt"))
+
+	app, _, _, _ := newTestApp()
+	require.ErrorContains(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--download-dir", downloadDir, "--download-max-size", "lots", server.URL + "/mytopic"}), "invalid download max size")
+}
+
+func TestCLI_Subscribe_DownloadAttachments_Cleanup(t *testing.T) {
+	downloadDir := t.TempDir()
+	oldFile, newFile := filepath.Join(downloadDir, "old.txt"), filepath.Join(downloadDir, "new.txt")
+	require.Nil(t, os.WriteFile(oldFile, []byte("old"), 0600))
+	require.Nil(t, os.WriteFile(newFile, []byte("new"), 0600))
+	twoDaysAgo := time.Now().Add(-48 * time.Hour)
+	require.Nil(t, os.Chtimes(oldFile, twoDaysAgo, twoDaysAgo))
+
+	downloader, err := newAttachmentDownloader(nil, downloadDir, 1024, 24*time.Hour)
+	require.Nil(t, err)
+	require.Equal(t, "", downloader.Download(&client.Message{ID: "msg1"})) // No attachment, but triggers cleanup
+	require.NoFileExists(t, oldFile)
+	require.FileExists(t, newFile)
+}
+
+func writeTestKeyPair(t *testing.T) (keyFile, pubKeyFile string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.Nil(t, err)
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.Nil(t, err)
+	dir := t.TempDir()
+	keyFile, pubKeyFile = filepath.Join(dir, "key.pem"), filepath.Join(dir, "pubkey.pem")
+	require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}), 0600))
+	require.Nil(t, os.WriteFile(pubKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}), 0600))
+	return keyFile, pubKeyFile
+}
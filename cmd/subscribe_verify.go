@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	verifyActionDrop = "drop"
+	verifyActionFlag = "flag"
+)
+
+// messageVerifier checks the signatures of incoming messages against a trusted public key (see --verify). Messages
+// that are not signed, or whose signature is invalid, are either dropped or flagged (see --verify-action).
+type messageVerifier struct {
+	key  ed25519.PublicKey
+	flag bool // Flag unverified messages instead of dropping them
+}
+
+func newMessageVerifier(keyFile, action string) (*messageVerifier, error) {
+	if action != "" && action != verifyActionDrop && action != verifyActionFlag {
+		return nil, errors.New("--verify-action must be 'drop' or 'flag'")
+	}
+	key, err := client.LoadVerifyingKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &messageVerifier{
+		key:  key,
+		flag: action == verifyActionFlag,
+	}, nil
+}
+
+// Verify checks the signature of the message, and returns false if the message must be dropped. In flag mode, the
+// raw JSON message is amended with a "verified" field. Other events than messages are always passed on. Encrypted
+// messages must be verified before they are decrypted. The verifier may be nil.
+func (v *messageVerifier) Verify(m *client.Message) bool {
+	if v == nil || m.Event != client.MessageEvent {
+		return true
+	}
+	err := m.Verify(v.key)
+	if err != nil && !v.flag {
+		log.Info("%s Dropping message: %s", logMessagePrefix(m), err.Error())
+		return false
+	} else if err != nil {
+		log.Debug("%s Flagging message as unverified: %s", logMessagePrefix(m), err.Error())
+	}
+	if v.flag {
+		if err := setRawField(m, "verified", err == nil); err != nil {
+			log.Warn("%s Cannot flag message: %s", logMessagePrefix(m), err.Error())
+		}
+	}
+	return true
+}
+
+func setRawField(m *client.Message, key string, value any) error {
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(m.Raw), &raw); err != nil {
+		return err
+	}
+	raw[key] = value
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	m.Raw = string(b)
+	return nil
+}
@@ -0,0 +1,156 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+	"heckel.io/ntfy/v2/server"
+)
+
+func init() {
+	commands = append(commands, cmdTemplate)
+}
+
+const (
+	templateTestFixtureExtension = ".json"
+	templateTestGoldenExtension  = ".golden"
+)
+
+var cmdTemplate = &cli.Command{
+	Name:      "template",
+	Usage:     "Test message templates",
+	UsageText: "ntfy template test [--update] DIR",
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "test",
+			Aliases:   []string{"t"},
+			Usage:     "Renders templates against sample payloads and compares them to golden files",
+			UsageText: "ntfy template test [--update] DIR",
+			Action:    execTemplateTest,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "update", Aliases: []string{"u"}, Usage: "write the rendered output to the golden files instead of comparing"},
+			},
+			Description: `Renders the message templates in a directory against sample payloads, and compares the
+output to golden files. This lets you keep your templates (see template-dir) under CI.
+
+For every template file DIR/<name>.yml, the sample payloads are read from DIR/<name>/*.json. The
+expected output of each payload is stored next to it in a golden file with the same name and the
+extension .golden, containing the rendered title and message (or the error, if rendering fails):
+
+  templates/
+    alertmanager.yml
+    alertmanager/
+      firing.json
+      firing.golden
+
+The command fails if any output differs from its golden file. Run it with --update to (re-)write
+the golden files after changing a template, and review the changes before committing them.
+
+Examples:
+  ntfy template test /etc/ntfy/templates           # Compare all templates with their golden files
+  ntfy template test --update /etc/ntfy/templates  # Write golden files`,
+		},
+	},
+}
+
+// templateTestOutput is the content of a golden file
+type templateTestOutput struct {
+	Title   string `yaml:"title,omitempty"`
+	Message string `yaml:"message,omitempty"`
+	Error   string `yaml:"error,omitempty"`
+}
+
+func execTemplateTest(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("template directory expected, type 'ntfy template test --help' for help")
+	}
+	dir, update := c.Args().Get(0), c.Bool("update")
+	templateFiles, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return err
+	} else if len(templateFiles) == 0 {
+		return fmt.Errorf("no template files (*.yml) found in %s", dir)
+	}
+	sort.Strings(templateFiles)
+	conf := server.NewConfig()
+	var total, failed int
+	for _, templateFile := range templateFiles {
+		name := strings.TrimSuffix(filepath.Base(templateFile), ".yml")
+		templateContent, err := os.ReadFile(templateFile)
+		if err != nil {
+			return err
+		}
+		fixtures, err := filepath.Glob(filepath.Join(dir, name, "*"+templateTestFixtureExtension))
+		if err != nil {
+			return err
+		} else if len(fixtures) == 0 {
+			fmt.Fprintf(c.App.Writer, "?    %s (no sample payloads in %s)\n", name, filepath.Join(dir, name))
+			continue
+		}
+		sort.Strings(fixtures)
+		for _, fixture := range fixtures {
+			total++
+			testName := filepath.Join(name, strings.TrimSuffix(filepath.Base(fixture), templateTestFixtureExtension))
+			ok, err := runTemplateTest(c, conf, templateContent, fixture, testName, update)
+			if err != nil {
+				return err
+			} else if !ok {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d template test(s) failed", failed, total)
+	} else if update {
+		fmt.Fprintf(c.App.Writer, "%d golden file(s) written\n", total)
+		return nil
+	}
+	fmt.Fprintf(c.App.Writer, "%d template test(s) passed\n", total)
+	return nil
+}
+
+// runTemplateTest renders a single sample payload and compares it to (or writes) its golden file
+func runTemplateTest(c *cli.Context, conf *server.Config, templateContent []byte, fixture, testName string, update bool) (bool, error) {
+	payload, err := os.ReadFile(fixture)
+	if err != nil {
+		return false, err
+	}
+	var output templateTestOutput
+	output.Title, output.Message, err = server.RenderTemplateFile(conf, templateContent, string(payload))
+	if err != nil {
+		output = templateTestOutput{Error: err.Error()}
+	}
+	actual, err := yaml.Marshal(&output)
+	if err != nil {
+		return false, err
+	}
+	goldenFile := strings.TrimSuffix(fixture, templateTestFixtureExtension) + templateTestGoldenExtension
+	if update {
+		if err := os.WriteFile(goldenFile, actual, 0644); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(c.App.Writer, "upd  %s\n", testName)
+		return true, nil
+	}
+	expected, err := os.ReadFile(goldenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(c.App.Writer, "FAIL %s (golden file %s missing, run with --update to create it)\n", testName, goldenFile)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else if string(expected) != string(actual) {
+		fmt.Fprintf(c.App.Writer, "FAIL %s\n--- expected (%s)\n%s+++ actual\n%s", testName, goldenFile, expected, actual)
+		return false, nil
+	}
+	fmt.Fprintf(c.App.Writer, "ok   %s\n", testName)
+	return true, nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCLI_Template_Test(t *testing.T) {
+	dir := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "alerts.yml"), []byte(`title: "{{ .status | upper }}: {{ .name }}"
+message: |
+  {{ if eq .status "firing" }}Alert {{ .name }} is firing{{ else }}{{ fail "unknown status" }}{{ end }}
+`), 0644))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "alerts"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "alerts", "firing.json"), []byte(`{"status":"firing","name":"disk full"}`), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "alerts", "unknown.json"), []byte(`{"status":"pending","name":"disk full"}`), 0644))
+
+	// Golden files are missing
+	app, _, stdout, _ := newTestApp()
+	err := runTemplateCommand(app, "test", dir)
+	require.EqualError(t, err, "2 of 2 template test(s) failed")
+	require.Contains(t, stdout.String(), "FAIL alerts/firing (golden file")
+
+	// Write golden files
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runTemplateCommand(app, "test", "--update", dir))
+	require.Contains(t, stdout.String(), "2 golden file(s) written")
+	golden, err := os.ReadFile(filepath.Join(dir, "alerts", "firing.golden"))
+	require.Nil(t, err)
+	require.Equal(t, "title: 'FIRING: disk full'\nmessage: Alert disk full is firing\n", string(golden))
+	golden, err = os.ReadFile(filepath.Join(dir, "alerts", "unknown.golden"))
+	require.Nil(t, err)
+	require.Contains(t, string(golden), "error: ")
+	require.Contains(t, string(golden), "unknown status")
+
+	// Compare with golden files
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runTemplateCommand(app, "test", dir))
+	require.Contains(t, stdout.String(), "ok   alerts/firing\n")
+	require.Contains(t, stdout.String(), "2 template test(s) passed")
+
+	// Changed template output is detected
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "alerts", "firing.golden"), []byte("title: 'FIRING: cpu hot'\nmessage: Alert cpu hot is firing\n"), 0644))
+	app, _, stdout, _ = newTestApp()
+	require.EqualError(t, runTemplateCommand(app, "test", dir), "1 of 2 template test(s) failed")
+	require.Contains(t, stdout.String(), "FAIL alerts/firing\n--- expected")
+	require.Contains(t, stdout.String(), "+++ actual\ntitle: 'FIRING: disk full'")
+}
+
+func TestCLI_Template_Test_NoTemplates(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	require.ErrorContains(t, runTemplateCommand(app, "test", t.TempDir()), "no template files")
+}
+
+func runTemplateCommand(app *cli.App, args ...string) error {
+	return app.Run(append([]string{"ntfy", "--log-level=ERROR", "template"}, args...))
+}
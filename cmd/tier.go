@@ -8,6 +8,8 @@ import (
 	"github.com/urfave/cli/v2"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"sort"
+	"strings"
 )
 
 func init() {
@@ -57,6 +59,7 @@ var cmdTier = &cli.Command{
 				&cli.StringFlag{Name: "attachment-bandwidth-limit", Value: defaultAttachmentBandwidthLimit, Usage: "daily bandwidth limit for attachment uploads/downloads"},
 				&cli.StringFlag{Name: "stripe-monthly-price-id", Usage: "Monthly Stripe price ID for paid tiers (e.g. price_12345)"},
 				&cli.StringFlag{Name: "stripe-yearly-price-id", Usage: "Yearly Stripe price ID for paid tiers (e.g. price_12345)"},
+				&cli.StringSliceFlag{Name: "feature", Usage: "feature flag in the format name=value, e.g. calls=false or max-delay=7d (can be repeated)"},
 				&cli.BoolFlag{Name: "ignore-exists", Usage: "if the tier already exists, perform no action and exit"},
 			},
 			Description: `Add a new tier to the ntfy user database.
@@ -79,6 +82,8 @@ Examples:
     --attachment-total-size-limit=1G \
     --attachment-expiry-duration=12h \
     --attachment-bandwidth-limit=5G \
+    --feature=calls=false \
+    --feature=max-delay=7d \
     pro
 `,
 		},
@@ -101,6 +106,7 @@ Examples:
 				&cli.StringFlag{Name: "attachment-bandwidth-limit", Usage: "daily bandwidth limit for attachment uploads/downloads"},
 				&cli.StringFlag{Name: "stripe-monthly-price-id", Usage: "Monthly Stripe price ID for paid tiers (e.g. price_12345)"},
 				&cli.StringFlag{Name: "stripe-yearly-price-id", Usage: "Yearly Stripe price ID for paid tiers (e.g. price_12345)"},
+				&cli.StringSliceFlag{Name: "feature", Usage: "feature flag in the format name=value; an empty value removes the flag (can be repeated)"},
 			},
 			Description: `Updates a tier to change the limits.
 
@@ -117,6 +123,8 @@ Examples:
     --stripe-monthly-price-id=price_1234 \
     --stripe-monthly-price-id=price_5678 \
     pro
+  ntfy tier change --feature=templates=false pro  # Disable message templating for a tier
+  ntfy tier change --feature=templates= pro       # Remove the feature flag again
 `,
 		},
 		{
@@ -211,6 +219,10 @@ func execTierAdd(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	features := make(user.TierFeatures)
+	if err := parseTierFeatures(features, c.StringSlice("feature")); err != nil {
+		return err
+	}
 	tier := &user.Tier{
 		ID:                       "", // Generated
 		Code:                     code,
@@ -226,6 +238,7 @@ func execTierAdd(c *cli.Context) error {
 		AttachmentBandwidthLimit: attachmentBandwidthLimit,
 		StripeMonthlyPriceID:     c.String("stripe-monthly-price-id"),
 		StripeYearlyPriceID:      c.String("stripe-yearly-price-id"),
+		Features:                 features,
 	}
 	if err := manager.AddTier(tier); err != nil {
 		return err
@@ -307,6 +320,11 @@ func execTierChange(c *cli.Context) error {
 	if c.IsSet("stripe-yearly-price-id") {
 		tier.StripeYearlyPriceID = c.String("stripe-yearly-price-id")
 	}
+	if c.IsSet("feature") {
+		if err := parseTierFeatures(tier.Features, c.StringSlice("feature")); err != nil {
+			return err
+		}
+	}
 	if tier.StripeMonthlyPriceID != "" && tier.StripeYearlyPriceID == "" {
 		return errors.New("if stripe-monthly-price-id is set, stripe-yearly-price-id must also be set")
 	} else if tier.StripeMonthlyPriceID == "" && tier.StripeYearlyPriceID != "" {
@@ -371,4 +389,29 @@ func printTier(c *cli.Context, tier *user.Tier) {
 	fmt.Fprintf(c.App.Writer, "- Attachment expiry duration: %s (%d seconds)\n", tier.AttachmentExpiryDuration.String(), int64(tier.AttachmentExpiryDuration.Seconds()))
 	fmt.Fprintf(c.App.Writer, "- Attachment daily bandwidth limit: %s\n", util.FormatSizeHuman(tier.AttachmentBandwidthLimit))
 	fmt.Fprintf(c.App.Writer, "- Stripe prices (monthly/yearly): %s\n", prices)
+	if len(tier.Features) > 0 {
+		features := make([]string, 0, len(tier.Features))
+		for feature, value := range tier.Features {
+			features = append(features, fmt.Sprintf("%s=%s", feature, value))
+		}
+		sort.Strings(features)
+		fmt.Fprintf(c.App.Writer, "- Features: %s\n", strings.Join(features, ", "))
+	}
+}
+
+// parseTierFeatures parses the feature flags (name=value) and sets them in the given map. An
+// empty value (name=) removes the feature flag.
+func parseTierFeatures(features user.TierFeatures, flags []string) error {
+	for _, flag := range flags {
+		if name, value, ok := strings.Cut(flag, "="); ok && strings.TrimSpace(value) == "" {
+			delete(features, user.TierFeature(strings.TrimSpace(name)))
+			continue
+		}
+		feature, value, err := user.ParseTierFeature(flag)
+		if err != nil {
+			return err
+		}
+		features[feature] = value
+	}
+	return nil
 }
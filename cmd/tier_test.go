@@ -54,6 +54,22 @@ func TestCLI_Tier_AddListChangeDelete(t *testing.T) {
 	require.Contains(t, stdout.String(), "tier pro removed")
 }
 
+func TestCLI_Tier_Features(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runTierCommand(app, conf, "add", "--feature=calls=false", "--feature=max-delay=7d", "pro"))
+	require.Contains(t, stdout.String(), "- Features: calls=false, max-delay=7d")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runTierCommand(app, conf, "change", "--feature=calls=", "--feature=templates=false", "pro"))
+	require.Contains(t, stdout.String(), "- Features: max-delay=7d, templates=false")
+
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, runTierCommand(app, conf, "change", "--feature=unknown=true", "pro"), "unknown tier feature")
+}
+
 func runTierCommand(app *cli.App, conf *server.Config, args ...string) error {
 	userArgs := []string{
 		"ntfy",
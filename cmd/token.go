@@ -9,6 +9,8 @@ import (
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"net/netip"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -21,7 +23,7 @@ var flagsToken = append([]cli.Flag{}, flagsUser...)
 var cmdToken = &cli.Command{
 	Name:      "token",
 	Usage:     "Create, list or delete user tokens",
-	UsageText: "ntfy token [list|add|remove] ...",
+	UsageText: "ntfy token [list|add|remove|inspect] ...",
 	Flags:     flagsToken,
 	Before:    initConfigFileInputSourceFunc("config", flagsToken, initLogFunc),
 	Category:  categoryServer,
@@ -30,11 +32,12 @@ var cmdToken = &cli.Command{
 			Name:      "add",
 			Aliases:   []string{"a"},
 			Usage:     "Create a new token",
-			UsageText: "ntfy token add [--expires=<duration>] [--label=..] USERNAME",
+			UsageText: "ntfy token add [--expires=<duration>] [--label=..] [--scope=..] USERNAME",
 			Action:    execTokenAdd,
 			Flags: []cli.Flag{
 				&cli.StringFlag{Name: "expires", Aliases: []string{"e"}, Value: "", Usage: "token expires after"},
 				&cli.StringFlag{Name: "label", Aliases: []string{"l"}, Value: "", Usage: "token label"},
+				&cli.StringSliceFlag{Name: "scope", Aliases: []string{"s"}, Usage: "restrict token to scope (publish, subscribe, topic:<pattern>, admin)"},
 			},
 			Description: `Create a new user access token.
 
@@ -42,6 +45,15 @@ User access tokens can be used to publish, subscribe, or perform any other user-
 Tokens have full access, and can perform any task a user can do. They are meant to be used to 
 avoid spreading the password to various places.
 
+To limit what a token can do, pass one or more scopes with --scope:
+- publish:          the token can only publish (write access)
+- subscribe:        the token can only subscribe (read access)
+- topic:<pattern>:  the token can only access matching topics, e.g. topic:alerts or topic:backup_*
+- admin:            the token can use the admin API (admins only)
+
+Scopes of the same kind add up, while different kinds restrict each other. Scoped tokens can never
+access topics the user cannot access, and cannot be used to manage the user's account.
+
 This is a server-only command. It directly reads from user.db as defined in the server config
 file server.yml. The command only works if 'auth-file' is properly defined.
 
@@ -49,7 +61,8 @@ Examples:
   ntfy token add phil                   # Create token for user phil which never expires
   ntfy token add --expires=2d phil      # Create token for user phil which expires in 2 days
   ntfy token add -e "tuesday, 8pm" phil # Create token for user phil which expires next Tuesday
-  ntfy token add -l backups phil        # Create token for user phil with label "backups"`,
+  ntfy token add -l backups phil        # Create token for user phil with label "backups"
+  ntfy token add -s publish -s topic:backups phil  # Create token that can only publish to "backups"`,
 		},
 		{
 			Name:      "remove",
@@ -71,6 +84,25 @@ Example:
 
 This is a server-only command. It directly reads from user.db as defined in the server config
 file server.yml. The command only works if 'auth-file' is properly defined.`,
+		},
+		{
+			Name:      "inspect",
+			Aliases:   []string{"i"},
+			Usage:     "Shows details about a token",
+			UsageText: "ntfy token inspect TOKEN",
+			Action:    execTokenInspect,
+			Description: `Shows the user a token belongs to, as well as its label, scopes, expiry date, and when and
+from where it was last used.
+
+This is useful to quickly triage a leaked token: find out who it belongs to and whether it was
+used, and then remove it with 'ntfy token remove', or ban it with 'ntfy ban add token'.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined. The last access time
+is written to the database periodically, so it may lag behind by up to a minute.
+
+Example:
+  ntfy token inspect tk_th2srHVlxrANQHAso5t0HuQ1J1TjN`,
 		},
 		{
 			Name:   "generate",
@@ -96,13 +128,19 @@ Examples:
   ntfy token list phil                          # Shows list of tokens for user phil
   ntfy token add phil                           # Create token for user phil which never expires
   ntfy token add --expires=2d phil              # Create token for user phil which expires in 2 days
-  ntfy token remove phil tk_th2srHVlxr...       # Delete token`,
+  ntfy token add --scope=subscribe phil         # Create token for user phil which can only subscribe
+  ntfy token remove phil tk_th2srHVlxr...       # Delete token
+  ntfy token inspect tk_th2srHVlxr...           # Show user, scopes and last access of token`,
 }
 
 func execTokenAdd(c *cli.Context) error {
 	username := c.Args().Get(0)
 	expiresStr := c.String("expires")
 	label := c.String("label")
+	scopes, err := user.ParseTokenScopes(c.StringSlice("scope"))
+	if err != nil {
+		return err
+	}
 	if username == "" {
 		return errors.New("username expected, type 'ntfy token add --help' for help")
 	} else if username == userEveryone || username == user.Everyone {
@@ -110,7 +148,6 @@ func execTokenAdd(c *cli.Context) error {
 	}
 	expires := time.Unix(0, 0)
 	if expiresStr != "" {
-		var err error
 		expires, err = util.ParseFutureTime(expiresStr, time.Now())
 		if err != nil {
 			return err
@@ -126,14 +163,21 @@ func execTokenAdd(c *cli.Context) error {
 	} else if err != nil {
 		return err
 	}
-	token, err := manager.CreateToken(u.ID, label, expires, netip.IPv4Unspecified(), false)
+	if slices.Contains(scopes, user.TokenScopeAdmin) && !u.IsAdmin() {
+		return fmt.Errorf("admin scope is only allowed for admins, but user %s is not an admin", u.Name)
+	}
+	token, err := manager.CreateScopedToken(u.ID, label, expires, netip.IPv4Unspecified(), false, scopes)
 	if err != nil {
 		return err
 	}
+	var scopesStr string
+	if token.Scopes.Restricted() {
+		scopesStr = fmt.Sprintf(", scopes: %s", token.Scopes)
+	}
 	if expires.Unix() == 0 {
-		fmt.Fprintf(c.App.Writer, "token %s created for user %s, never expires\n", token.Value, u.Name)
+		fmt.Fprintf(c.App.Writer, "token %s created for user %s, never expires%s\n", token.Value, u.Name, scopesStr)
 	} else {
-		fmt.Fprintf(c.App.Writer, "token %s created for user %s, expires %v\n", token.Value, u.Name, expires.Format(time.UnixDate))
+		fmt.Fprintf(c.App.Writer, "token %s created for user %s, expires %v%s\n", token.Value, u.Name, expires.Format(time.UnixDate), scopesStr)
 	}
 	return nil
 }
@@ -200,10 +244,13 @@ func execTokenList(c *cli.Context) error {
 		usersWithTokens++
 		fmt.Fprintf(c.App.Writer, "user %s\n", u.Name)
 		for _, t := range tokens {
-			var label, expires, provisioned string
+			var label, expires, scopes, provisioned string
 			if t.Label != "" {
 				label = fmt.Sprintf(" (%s)", t.Label)
 			}
+			if t.Scopes.Restricted() {
+				scopes = fmt.Sprintf(", scopes: %s", t.Scopes)
+			}
 			if t.Expires.Unix() == 0 {
 				expires = "never expires"
 			} else {
@@ -212,7 +259,7 @@ func execTokenList(c *cli.Context) error {
 			if t.Provisioned {
 				provisioned = " (server config)"
 			}
-			fmt.Fprintf(c.App.Writer, "- %s%s, %s, accessed from %s at %s%s\n", t.Value, label, expires, t.LastOrigin.String(), t.LastAccess.Format(time.RFC822), provisioned)
+			fmt.Fprintf(c.App.Writer, "- %s%s, %s%s, accessed from %s at %s%s\n", t.Value, label, expires, scopes, t.LastOrigin.String(), t.LastAccess.Format(time.RFC822), provisioned)
 		}
 	}
 	if usersWithTokens == 0 {
@@ -221,6 +268,69 @@ func execTokenList(c *cli.Context) error {
 	return nil
 }
 
+func execTokenInspect(c *cli.Context) error {
+	token := c.Args().Get(0)
+	if token == "" {
+		return errors.New("token expected, type 'ntfy token inspect --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	u, t, err := manager.InspectToken(token)
+	if errors.Is(err, user.ErrTokenNotFound) {
+		return fmt.Errorf("token %s does not exist", token)
+	} else if err != nil {
+		return err
+	}
+	label, scopes, expires, provisioned := "(none)", "full access", "never", "no"
+	if t.Label != "" {
+		label = t.Label
+	}
+	if t.Scopes.Restricted() {
+		scopes = t.Scopes.String()
+	}
+	if t.Expires.Unix() != 0 {
+		expires = t.Expires.Format(time.RFC822)
+		if t.Expires.Before(time.Now()) {
+			expires += " (expired)"
+		}
+	}
+	if t.Provisioned {
+		provisioned = "yes (server config)"
+	}
+	fmt.Fprintf(c.App.Writer, "token %s\n", t.Value)
+	fmt.Fprintf(c.App.Writer, "- User: %s (id: %s, role: %s)\n", u.Name, u.ID, u.Role)
+	fmt.Fprintf(c.App.Writer, "- Label: %s\n", label)
+	fmt.Fprintf(c.App.Writer, "- Scopes: %s\n", scopes)
+	fmt.Fprintf(c.App.Writer, "- Expires: %s\n", expires)
+	fmt.Fprintf(c.App.Writer, "- Last access: %s, from %s%s\n", t.LastAccess.Format(time.RFC822), t.LastOrigin.String(), tokenLocationString(t))
+	if t.LastUserAgent != "" {
+		fmt.Fprintf(c.App.Writer, "- Last user agent: %s\n", t.LastUserAgent)
+	}
+	fmt.Fprintf(c.App.Writer, "- Provisioned: %s\n", provisioned)
+	if t.Batch != "" {
+		fmt.Fprintf(c.App.Writer, "- Batch: %s\n", t.Batch)
+	}
+	return nil
+}
+
+// tokenLocationString returns the country and network the token was last used from, e.g. " (DE, AS3320)",
+// or an empty string if they are unknown
+func tokenLocationString(t *user.Token) string {
+	location := make([]string, 0)
+	if t.LastCountry != "" {
+		location = append(location, t.LastCountry)
+	}
+	if t.LastASN != 0 {
+		location = append(location, fmt.Sprintf("AS%d", t.LastASN))
+	}
+	if len(location) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", strings.Join(location, ", "))
+}
+
 func execTokenGenerate(c *cli.Context) error {
 	fmt.Fprintln(c.App.Writer, user.GenerateToken())
 	return nil
@@ -38,6 +38,48 @@ func TestCLI_Token_AddListRemove(t *testing.T) {
 	require.Equal(t, "no users with tokens\n", stdout.String())
 }
 
+func TestCLI_Token_AddScoped(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "add", "--scope=publish", "--scope=topic:alerts", "phil"))
+	require.Regexp(t, `token tk_.+ created for user phil, never expires, scopes: publish,topic:alerts`, stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "list", "phil"))
+	require.Regexp(t, `- tk_.+, never expires, scopes: publish,topic:alerts, accessed from`, stdout.String())
+
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, runTokenCommand(app, conf, "add", "--scope=admin", "phil"), "admin scope is only allowed for admins")
+	app, _, _, _ = newTestApp()
+	require.ErrorContains(t, runTokenCommand(app, conf, "add", "--scope=delete", "phil"), "invalid token scope: delete")
+}
+
+func TestCLI_Token_Inspect(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "add", "--label=backups", "--scope=publish", "phil"))
+	token := regexp.MustCompile(`tk_\w+`).FindString(stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "inspect", token))
+	require.Regexp(t, fmt.Sprintf(`token %s\n- User: phil \(id: u_\w+, role: user\)\n- Label: backups\n- Scopes: publish\n- Expires: never\n- Last access: .+, from 0.0.0.0\n- Provisioned: no\n$`, token), stdout.String())
+
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, runTokenCommand(app, conf, "inspect", "tk_doesnotexist"), "token tk_doesnotexist does not exist")
+}
+
 func runTokenCommand(app *cli.App, conf *server.Config, args ...string) error {
 	userArgs := []string{
 		"ntfy",
@@ -43,7 +43,7 @@ var cmdUser = &cli.Command{
 			Name:      "add",
 			Aliases:   []string{"a"},
 			Usage:     "Adds a new user",
-			UsageText: "ntfy user add [--role=admin|user] USERNAME\nNTFY_PASSWORD=... ntfy user add [--role=admin|user] USERNAME\nNTFY_PASSWORD_HASH=... ntfy user add [--role=admin|user] USERNAME",
+			UsageText: "ntfy user add [--role=admin|support|user] USERNAME\nNTFY_PASSWORD=... ntfy user add [--role=admin|support|user] USERNAME\nNTFY_PASSWORD_HASH=... ntfy user add [--role=admin|support|user] USERNAME",
 			Action:    execUserAdd,
 			Flags: []cli.Flag{
 				&cli.StringFlag{Name: "role", Aliases: []string{"r"}, Value: string(user.RoleUser), Usage: "user role"},
@@ -51,13 +51,15 @@ var cmdUser = &cli.Command{
 			},
 			Description: `Add a new user to the ntfy user database.
 
-A user can be either a regular user, or an admin. A regular user has no read or write access (unless
-granted otherwise by the auth-default-access setting). An admin user has read and write access to all
-topics.
+A user can be either a regular user, a support user, or an admin. A regular user has no read or write
+access (unless granted otherwise by the auth-default-access setting). A support user has the same topic
+access as a regular user, but can also list users and reset the passwords and tokens of regular users via
+the admin API. An admin user has read and write access to all topics.
 
 Examples:
   ntfy user add phil                          # Add regular user phil
   ntfy user add --role=admin phil             # Add admin user phil
+  ntfy user add --role=support phil           # Add support user phil
   NTFY_PASSWORD=... ntfy user add phil        # Add user, using env variable to set password (for scripts)
   NTFY_PASSWORD_HASH=... ntfy user add phil   # Add user, using env variable to set password hash (for scripts)
 
@@ -103,12 +105,14 @@ directly the bcrypt hash. This is useful if you are updating users via scripts.
 			Usage:     "Changes the role of a user",
 			UsageText: "ntfy user change-role USERNAME ROLE",
 			Action:    execUserChangeRole,
-			Description: `Change the role for the given user to admin or user.
+			Description: `Change the role for the given user to admin, support or user.
 
 This command can be used to change the role of a user either from a regular user
-to an admin user, or the other way around:
+to an admin or support user, or the other way around:
 
 - admin: an admin has read/write access to all topics
+- support: a support user only has access to what was explicitly granted via 'ntfy access',
+  but can list users and reset the passwords and tokens of regular users via the admin API
 - user: a regular user only has access to what was explicitly granted via 'ntfy access'
 
 When changing the role of a user to "admin", all access control entries for that 
@@ -116,6 +120,7 @@ user are removed, since they are no longer necessary.
 
 Example:
   ntfy user change-role phil admin   # Make user phil an admin 
+  ntfy user change-role phil support # Make user phil a support user
   ntfy user change-role phil user    # Remove admin role from user phil 
 `,
 		},
@@ -203,7 +208,7 @@ func execUserAdd(c *cli.Context) error {
 	} else if username == userEveryone || username == user.Everyone {
 		return errors.New("username not allowed")
 	} else if !user.AllowedRole(role) {
-		return errors.New("role must be either 'user' or 'admin'")
+		return errors.New("role must be either 'user', 'support' or 'admin'")
 	}
 	manager, err := createUserManager(c)
 	if err != nil {
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	accessLogStdout = "-"
+
+	accessLogActionPublish   = "publish"
+	accessLogActionSubscribe = "subscribe"
+	accessLogActionPoll      = "poll"
+	accessLogActionUpdate    = "update"
+	accessLogActionDelete    = "delete"
+	accessLogActionAuth      = "auth"
+	accessLogActionAck       = "ack"
+	accessLogActionFile      = "file"
+	accessLogActionAccount   = "account"
+	accessLogActionAdmin     = "admin"
+	accessLogActionAPI       = "api"
+	accessLogActionWeb       = "web"
+
+	accessLogOutcomeOK          = "ok"
+	accessLogOutcomeError       = "error"
+	accessLogOutcomeDenied      = "denied"
+	accessLogOutcomeRateLimited = "rate_limited"
+)
+
+// accessLogger writes one JSON line per HTTP request to the access log (see access-log-file). Unlike the
+// regular log, the access log has a fixed format and is not affected by the log level, so that it can be
+// fed into log aggregators such as Loki or Elasticsearch. Message contents are never logged.
+type accessLogger struct {
+	w  io.WriteCloser
+	mu sync.Mutex
+}
+
+// accessLogEntry is a single line in the access log
+type accessLogEntry struct {
+	Time              string  `json:"time"`
+	VisitorIP         string  `json:"visitor_ip"`
+	User              string  `json:"user,omitempty"`
+	Method            string  `json:"method"`
+	Path              string  `json:"path"`
+	Topic             string  `json:"topic,omitempty"` // Comma-separated, if multiple topics are subscribed to
+	Action            string  `json:"action"`
+	Status            int     `json:"status"`
+	ErrorCode         int     `json:"error_code,omitempty"` // ntfy error code, e.g. 42901
+	Outcome           string  `json:"outcome"`
+	BytesIn           int64   `json:"bytes_in"`
+	BytesOut          int64   `json:"bytes_out"`
+	DurationMillis    int64   `json:"duration_ms"`
+	RequestsRemaining float64 `json:"requests_remaining"`
+	MessagesRemaining int64   `json:"messages_remaining"`
+	UserAgent         string  `json:"user_agent,omitempty"`
+}
+
+func newAccessLogger(filename string) (*accessLogger, error) {
+	if filename == accessLogStdout {
+		return &accessLogger{w: nopWriteCloser{os.Stdout}}, nil
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLogger{w: f}, nil
+}
+
+// Log writes the access log entry for the given request. The response writer must be the one returned by Wrap.
+func (l *accessLogger) Log(w http.ResponseWriter, r *http.Request, v *visitor, start time.Time) {
+	aw, ok := w.(*accessLogResponseWriter)
+	if !ok {
+		return
+	}
+	status := aw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	action, topic := accessLogActionAndTopic(r)
+	entry := &accessLogEntry{
+		Time:           start.UTC().Format(time.RFC3339Nano),
+		Method:         r.Method,
+		Path:           r.URL.Path,
+		Topic:          topic,
+		Action:         action,
+		Status:         status,
+		ErrorCode:      aw.errorCode,
+		Outcome:        accessLogOutcome(status),
+		BytesIn:        aw.body.n,
+		BytesOut:       aw.written,
+		DurationMillis: time.Since(start).Milliseconds(),
+		UserAgent:      r.UserAgent(),
+	}
+	if v != nil {
+		entry.VisitorIP = v.IP().String()
+		if u := v.User(); u != nil {
+			entry.User = u.Name
+		}
+		entry.RequestsRemaining, entry.MessagesRemaining = v.Headroom()
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Tag(tagHTTP).Err(err).Warn("Cannot marshal access log entry")
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		log.Tag(tagHTTP).Err(err).Warn("Cannot write access log entry")
+	}
+}
+
+// Wrap returns a response writer and request that count the bytes written and read, and record the status code
+func (l *accessLogger) Wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	body := &accessLogBody{ReadCloser: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+	return &accessLogResponseWriter{ResponseWriter: w, body: body}, r
+}
+
+func (l *accessLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Close()
+}
+
+// accessLogActionAndTopic derives the action and the topic(s) from the request path, without consulting any
+// state. Publishing via JSON (PUT /) has no topic in the path, and is logged without a topic.
+func accessLogActionAndTopic(r *http.Request) (action string, topic string) {
+	p := r.URL.Path
+	firstSegment := func() string {
+		return strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0]
+	}
+	switch {
+	case jsonPathRegex.MatchString(p), ssePathRegex.MatchString(p), rawPathRegex.MatchString(p), wsPathRegex.MatchString(p):
+		if readBoolParam(r, false, "x-poll", "poll", "po") {
+			return accessLogActionPoll, firstSegment()
+		}
+		return accessLogActionSubscribe, firstSegment()
+	case authPathRegex.MatchString(p):
+		return accessLogActionAuth, firstSegment()
+	case ackPathRegex.MatchString(p):
+		return accessLogActionAck, firstSegment()
+	case publishPathRegex.MatchString(p):
+		return accessLogActionPublish, firstSegment()
+	case messagePathRegex.MatchString(p) && r.Method == http.MethodDelete:
+		return accessLogActionDelete, firstSegment()
+	case messagePathRegex.MatchString(p) && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		return accessLogActionUpdate, firstSegment()
+	case topicPathRegex.MatchString(p) && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		return accessLogActionPublish, firstSegment()
+	case p == "/" && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		return accessLogActionPublish, ""
+	case strings.HasPrefix(p, "/file/"):
+		return accessLogActionFile, ""
+	case strings.HasPrefix(p, apiAccountPath):
+		return accessLogActionAccount, ""
+	case strings.HasPrefix(p, "/v1/admin/") || strings.HasPrefix(p, apiUsersPath):
+		return accessLogActionAdmin, ""
+	case strings.HasPrefix(p, "/v1/"):
+		return accessLogActionAPI, ""
+	}
+	return accessLogActionWeb, ""
+}
+
+func accessLogOutcome(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return accessLogOutcomeRateLimited
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return accessLogOutcomeDenied
+	case status >= 400:
+		return accessLogOutcomeError
+	}
+	return accessLogOutcomeOK
+}
+
+// accessLogResponseWriter records the status code and counts the bytes written. It passes through flushing
+// (for JSON/SSE streams) and hijacking (for WebSockets). Bytes written to hijacked connections are not counted.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	body      *accessLogBody
+	status    int
+	errorCode int // Set by handleError
+	written   int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLogBody counts the bytes read from the request body
+type accessLogBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *accessLogBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_AccessLog(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AccessLogFile = filepath.Join(t.TempDir(), "access.log")
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+
+	response := request(t, s, "PUT", "/mytopic", "this is a secret", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic,othertopic/json?poll=1", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	b, err := os.ReadFile(conf.AccessLogFile)
+	require.Nil(t, err)
+	require.NotContains(t, string(b), "this is a secret")
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+
+	var publish, poll accessLogEntry
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &publish))
+	require.Equal(t, "phil", publish.User)
+	require.Equal(t, "9.9.9.9", publish.VisitorIP)
+	require.Equal(t, "PUT", publish.Method)
+	require.Equal(t, "mytopic", publish.Topic)
+	require.Equal(t, accessLogActionPublish, publish.Action)
+	require.Equal(t, 200, publish.Status)
+	require.Equal(t, accessLogOutcomeOK, publish.Outcome)
+	require.Equal(t, int64(len("this is a secret")), publish.BytesIn)
+	require.True(t, publish.BytesOut > 0)
+	require.True(t, publish.RequestsRemaining > 0)
+	require.True(t, publish.MessagesRemaining > 0)
+
+	require.Nil(t, json.Unmarshal([]byte(lines[1]), &poll))
+	require.Empty(t, poll.User)
+	require.Equal(t, "mytopic,othertopic", poll.Topic)
+	require.Equal(t, accessLogActionPoll, poll.Action)
+	require.Equal(t, 403, poll.Status)
+	require.Equal(t, 40301, poll.ErrorCode)
+	require.Equal(t, accessLogOutcomeDenied, poll.Outcome)
+}
+
+func TestAccessLogActionAndTopic(t *testing.T) {
+	tests := []struct {
+		method, target, action, topic string
+	}{
+		{"PUT", "/mytopic", accessLogActionPublish, "mytopic"},
+		{"GET", "/mytopic/publish?message=hi", accessLogActionPublish, "mytopic"},
+		{"PUT", "/", accessLogActionPublish, ""},
+		{"GET", "/mytopic,another/sse", accessLogActionSubscribe, "mytopic,another"},
+		{"GET", "/mytopic/json?poll=1", accessLogActionPoll, "mytopic"},
+		{"PUT", "/mytopic/abcdefghijkl", accessLogActionUpdate, "mytopic"},
+		{"DELETE", "/mytopic/abcdefghijkl", accessLogActionDelete, "mytopic"},
+		{"GET", "/mytopic/auth", accessLogActionAuth, "mytopic"},
+		{"GET", "/file/abcdefghijkl.png", accessLogActionFile, ""},
+		{"GET", "/v1/account", accessLogActionAccount, ""},
+		{"GET", "/v1/admin/users", accessLogActionAdmin, ""},
+		{"GET", "/v1/health", accessLogActionAPI, ""},
+		{"GET", "/mytopic", accessLogActionWeb, ""},
+	}
+	for _, test := range tests {
+		action, topic := accessLogActionAndTopic(httptest.NewRequest(test.method, test.target, nil))
+		require.Equal(t, test.action, action, test.target)
+		require.Equal(t, test.topic, topic, test.target)
+	}
+}
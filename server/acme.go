@@ -0,0 +1,25 @@
+package server
+
+import (
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager creates an autocert.Manager that obtains and renews TLS certificates for the configured
+// domains via ACME (e.g. Let's Encrypt). Certificates and the account key are stored in the ACME cache directory,
+// so that they survive restarts (and we don't run into the CA's rate limits).
+//
+// Challenges are answered via TLS-ALPN-01 on the HTTPS listener, and via HTTP-01 on the HTTP listener (if any),
+// see Run. Since the CA has to reach the server, listen-https (or listen-http) must be reachable on port 443 (or 80).
+func newACMEManager(conf *Config) *autocert.Manager {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(conf.ACMEDomains...),
+		Cache:      autocert.DirCache(conf.ACMECacheDir),
+		Email:      conf.ACMEEmail,
+	}
+	if conf.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: conf.ACMEDirectoryURL}
+	}
+	return manager
+}
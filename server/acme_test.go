@@ -0,0 +1,31 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestNewACMEManager(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.ACMEDomains = []string{"ntfy.example.com", "push.example.com"}
+	conf.ACMEEmail = "admin@example.com"
+	conf.ACMECacheDir = t.TempDir()
+	manager := newACMEManager(conf)
+	require.Equal(t, "admin@example.com", manager.Email)
+	require.Equal(t, autocert.DirCache(conf.ACMECacheDir), manager.Cache)
+	require.Nil(t, manager.Client) // Let's Encrypt
+	require.Nil(t, manager.HostPolicy(context.Background(), "ntfy.example.com"))
+	require.Nil(t, manager.HostPolicy(context.Background(), "push.example.com"))
+	require.Error(t, manager.HostPolicy(context.Background(), "evil.example.com"))
+
+	conf.ACMEDirectoryURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	manager = newACMEManager(conf)
+	require.Equal(t, "https://acme-staging-v02.api.letsencrypt.org/directory", manager.Client.DirectoryURL)
+
+	s := newTestServer(t, conf)
+	require.NotNil(t, s.acme)
+	require.Nil(t, newTestServer(t, newTestConfig(t)).acme)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	apnsProductionURL        = "https://api.push.apple.com"
+	apnsSandboxURL           = "https://api.sandbox.push.apple.com"
+	apnsTimeout              = 15 * time.Second
+	apnsTokenRefreshInterval = 50 * time.Minute // Apple rejects tokens older than one hour, and refreshes more often than every 20 minutes
+	apnsPayloadLimit         = 4096             // Bytes, for alert notifications
+	apnsAlertBodyLimit       = 100              // Characters, see apnsAlertBody
+	apnsTopicSubscribeLimit  = 100
+)
+
+var (
+	apnsDeviceTokenRegex = regexp.MustCompile(`^[0-9a-fA-F]{64,200}$`) // Device tokens are hex-encoded, currently 32 bytes, but Apple says they may grow
+
+	errAPNSKeyInvalid         = errors.New("invalid APNs key file, expected a PKCS#8 .p8 file with an ECDSA P-256 key")
+	errAPNSDeviceTokenInvalid = errors.New("device token invalid or no longer registered")
+)
+
+// apnsClient sends notifications directly to the Apple Push Notification service (APNs), using token-based
+// authentication with a .p8 signing key. Unlike Firebase, which delivers messages to topics, APNs delivers
+// messages to individual devices, so the iOS app registers its device token with the server (see apnsStore).
+//
+// See https://developer.apple.com/documentation/usernotifications/sending-notification-requests-to-apns
+type apnsClient struct {
+	baseURL    string
+	keyID      string
+	teamID     string
+	bundleID   string
+	key        *ecdsa.PrivateKey
+	httpClient *http.Client // APNs requires HTTP/2, which the default transport negotiates via TLS
+	token      string       // Provider authentication token (JWT), refreshed regularly
+	issued     time.Time
+	mu         sync.Mutex
+}
+
+// apnsNotification is a single notification to a device
+type apnsNotification struct {
+	Payload    []byte
+	PushType   string // "alert" or "background"
+	Priority   int    // 10 (immediately), or 5 (power considerations)
+	Expiration int64  // Unix time until which APNs retries delivery, 0 to attempt only once
+}
+
+type apnsErrorResponse struct {
+	Reason string `json:"reason"`
+}
+
+func newAPNSClient(keyFile, keyID, teamID, bundleID string, sandbox bool) (*apnsClient, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseAPNSKey(b)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := apnsProductionURL
+	if sandbox {
+		baseURL = apnsSandboxURL
+	}
+	return &apnsClient{
+		baseURL:    baseURL,
+		keyID:      keyID,
+		teamID:     teamID,
+		bundleID:   bundleID,
+		key:        key,
+		httpClient: &http.Client{Timeout: apnsTimeout},
+	}, nil
+}
+
+// Send sends a notification to the device with the given token. It returns errAPNSDeviceTokenInvalid if
+// APNs reports that the token is invalid, or that the app was uninstalled.
+func (c *apnsClient) Send(deviceToken string, n *apnsNotification) error {
+	token, err := c.authToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/3/device/%s", c.baseURL, deviceToken), bytes.NewReader(n.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apns-topic", c.bundleID)
+	req.Header.Set("apns-push-type", n.PushType)
+	req.Header.Set("apns-priority", strconv.Itoa(n.Priority))
+	req.Header.Set("apns-expiration", strconv.FormatInt(n.Expiration, 10))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var errResponse apnsErrorResponse
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	_ = json.Unmarshal(body, &errResponse)
+	switch {
+	case resp.StatusCode == http.StatusGone, errResponse.Reason == "BadDeviceToken", errResponse.Reason == "DeviceTokenNotForTopic":
+		return fmt.Errorf("%w: %s", errAPNSDeviceTokenInvalid, errResponse.Reason)
+	case errResponse.Reason == "ExpiredProviderToken":
+		c.mu.Lock()
+		c.token = "" // Force refresh on next request
+		c.mu.Unlock()
+	}
+	return fmt.Errorf("unexpected APNs response %s: %s", resp.Status, errResponse.Reason)
+}
+
+// authToken returns the current provider authentication token, or creates a new one if it is too old
+func (c *apnsClient) authToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Since(c.issued) < apnsTokenRefreshInterval {
+		return c.token, nil
+	}
+	now := time.Now()
+	token, err := signAPNSToken(c.key, c.keyID, c.teamID, now)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.issued = token, now
+	return token, nil
+}
+
+// signAPNSToken creates a JSON Web Token signed with ES256, as required for token-based authentication with APNs
+func signAPNSToken(key *ecdsa.PrivateKey, keyID, teamID string, issuedAt time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{"iss": teamID, "iat": issuedAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64) // JWS uses the fixed-size R || S encoding, not ASN.1
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parseAPNSKey(b []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errAPNSKeyInvalid
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errAPNSKeyInvalid
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok || ecKey.Curve.Params().BitSize != 256 {
+		return nil, errAPNSKeyInvalid
+	}
+	return ecKey, nil
+}
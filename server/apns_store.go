@@ -0,0 +1,207 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"net/netip"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+)
+
+const (
+	apnsDeviceLimitPerSubscriberIP = 10
+)
+
+var (
+	errAPNSTooManyDevices      = errors.New("too many devices")
+	errAPNSUserIDCannotBeEmpty = errors.New("user ID cannot be empty")
+)
+
+const (
+	createAPNSDevicesTableQuery = `
+		BEGIN;
+		CREATE TABLE IF NOT EXISTS device (
+			token TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			subscriber_ip TEXT NOT NULL,
+			updated_at INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_subscriber_ip ON device (subscriber_ip);
+		CREATE TABLE IF NOT EXISTS device_topic (
+			token TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			PRIMARY KEY (token, topic),
+			FOREIGN KEY (token) REFERENCES device (token) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_topic ON device_topic (topic);
+		CREATE TABLE IF NOT EXISTS schemaVersion (
+			id INT PRIMARY KEY,
+			version INT NOT NULL
+		);
+		COMMIT;
+	`
+
+	selectAPNSDeviceExistsQuery         = `SELECT COUNT(*) FROM device WHERE token = ?`
+	selectAPNSDeviceCountQuery          = `SELECT COUNT(*) FROM device`
+	selectAPNSDeviceCountBySubscriberIP = `SELECT COUNT(*) FROM device WHERE subscriber_ip = ?`
+	selectAPNSDevicesForTopicQuery      = `
+		SELECT d.token, d.user_id
+		FROM device_topic dt
+		JOIN device d ON d.token = dt.token
+		WHERE dt.topic = ?
+		ORDER BY d.token
+	`
+	upsertAPNSDeviceQuery = `
+		INSERT INTO device (token, user_id, subscriber_ip, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (token)
+		DO UPDATE SET user_id = excluded.user_id, subscriber_ip = excluded.subscriber_ip, updated_at = excluded.updated_at
+	`
+	deleteAPNSDeviceQuery              = `DELETE FROM device WHERE token = ?`
+	deleteAPNSDeviceByUserIDQuery      = `DELETE FROM device WHERE user_id = ?`
+	deleteAPNSDeviceByAgeQuery         = `DELETE FROM device WHERE updated_at <= ?` // Full table scan!
+	insertAPNSDeviceTopicQuery         = `INSERT INTO device_topic (token, topic) VALUES (?, ?)`
+	deleteAPNSDeviceTopicAllQuery      = `DELETE FROM device_topic WHERE token = ?`
+	deleteAPNSDeviceTopicWithoutDevice = `DELETE FROM device_topic WHERE token NOT IN (SELECT token FROM device)`
+)
+
+// Schema management queries
+const (
+	currentAPNSSchemaVersion     = 1
+	insertAPNSSchemaVersion      = `INSERT INTO schemaVersion VALUES (1, ?)`
+	selectAPNSSchemaVersionQuery = `SELECT version FROM schemaVersion WHERE id = 1`
+)
+
+// apnsStore stores the APNs device tokens of iOS devices, and the topics they are subscribed to
+type apnsStore struct {
+	db *sql.DB
+}
+
+// apnsDevice is an iOS device that registered its APNs device token with the server
+type apnsDevice struct {
+	Token  string
+	UserID string // May be empty for anonymous devices
+}
+
+func newAPNSStore(filename string) (*apnsStore, error) {
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupAPNSDB(db); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(builtinStartupQueries); err != nil {
+		return nil, err
+	}
+	return &apnsStore{
+		db: db,
+	}, nil
+}
+
+func setupAPNSDB(db *sql.DB) error {
+	// If 'schemaVersion' table does not exist, this must be a new database
+	rows, err := db.Query(selectAPNSSchemaVersionQuery)
+	if err != nil {
+		if _, err := db.Exec(createAPNSDevicesTableQuery); err != nil {
+			return err
+		}
+		_, err := db.Exec(insertAPNSSchemaVersion, currentAPNSSchemaVersion)
+		return err
+	}
+	return rows.Close()
+}
+
+// UpsertDevice adds or updates the device with the given token, and replaces the topics it is subscribed to
+func (c *apnsStore) UpsertDevice(token, userID string, subscriberIP netip.Addr, topics []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var exists, deviceCount int
+	if err := tx.QueryRow(selectAPNSDeviceExistsQuery, token).Scan(&exists); err != nil {
+		return err
+	} else if err := tx.QueryRow(selectAPNSDeviceCountBySubscriberIP, subscriberIP.String()).Scan(&deviceCount); err != nil {
+		return err
+	} else if exists == 0 && deviceCount >= apnsDeviceLimitPerSubscriberIP {
+		return errAPNSTooManyDevices
+	}
+	if _, err := tx.Exec(upsertAPNSDeviceQuery, token, userID, subscriberIP.String(), time.Now().Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(deleteAPNSDeviceTopicAllQuery, token); err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		if _, err := tx.Exec(insertAPNSDeviceTopicQuery, token, topic); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DevicesForTopic returns all devices subscribed to the given topic
+func (c *apnsStore) DevicesForTopic(topic string) ([]*apnsDevice, error) {
+	rows, err := c.db.Query(selectAPNSDevicesForTopicQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	devices := make([]*apnsDevice, 0)
+	for rows.Next() {
+		var device apnsDevice
+		if err := rows.Scan(&device.Token, &device.UserID); err != nil {
+			return nil, err
+		}
+		devices = append(devices, &device)
+	}
+	return devices, rows.Err()
+}
+
+// RemoveDevice removes the device with the given token, e.g. if APNs reports that the token is no longer valid
+func (c *apnsStore) RemoveDevice(token string) error {
+	_, err := c.db.Exec(deleteAPNSDeviceQuery, token)
+	return err
+}
+
+// RemoveDevicesByUserID removes all devices of the given user
+func (c *apnsStore) RemoveDevicesByUserID(userID string) error {
+	if userID == "" {
+		return errAPNSUserIDCannotBeEmpty
+	}
+	_, err := c.db.Exec(deleteAPNSDeviceByUserIDQuery, userID)
+	return err
+}
+
+// RemoveExpiredDevices removes all devices that have not been updated for a given time period, and returns
+// the number of removed devices. The iOS app registers its device token every time it is opened.
+func (c *apnsStore) RemoveExpiredDevices(expireAfter time.Duration) (int64, error) {
+	res, err := c.db.Exec(deleteAPNSDeviceByAgeQuery, time.Now().Add(-expireAfter).Unix())
+	if err != nil {
+		return 0, err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.db.Exec(deleteAPNSDeviceTopicWithoutDevice); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// DevicesCount returns the number of registered devices
+func (c *apnsStore) DevicesCount() (int64, error) {
+	var count int64
+	if err := c.db.QueryRow(selectAPNSDeviceCountQuery).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Close closes the underlying database connection
+func (c *apnsStore) Close() error {
+	return c.db.Close()
+}
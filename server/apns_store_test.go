@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testAPNSDeviceToken = "6f3c1e0d2b9a8f7e6d5c4b3a29180f1e0d2c3b4a5968778695a4b3c2d1e0f9a8"
+)
+
+func TestAPNSStore_UpsertDevice_DevicesForTopic(t *testing.T) {
+	store := newTestAPNSStore(t)
+	defer store.Close()
+
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken, "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic", "mytopic"}))
+
+	devices, err := store.DevicesForTopic("test-topic")
+	require.Nil(t, err)
+	require.Len(t, devices, 1)
+	require.Equal(t, testAPNSDeviceToken, devices[0].Token)
+	require.Equal(t, "u_1234", devices[0].UserID)
+
+	devices, err = store.DevicesForTopic("mytopic")
+	require.Nil(t, err)
+	require.Len(t, devices, 1)
+
+	// Updating the device replaces its topics
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken, "", netip.MustParseAddr("1.2.3.4"), []string{"mytopic"}))
+	devices, err = store.DevicesForTopic("test-topic")
+	require.Nil(t, err)
+	require.Len(t, devices, 0)
+	devices, err = store.DevicesForTopic("mytopic")
+	require.Nil(t, err)
+	require.Len(t, devices, 1)
+	require.Equal(t, "", devices[0].UserID)
+}
+
+func TestAPNSStore_UpsertDevice_SubscriberIPLimitReached(t *testing.T) {
+	store := newTestAPNSStore(t)
+	defer store.Close()
+
+	for i := 0; i < 10; i++ {
+		require.Nil(t, store.UpsertDevice(fmt.Sprintf("%s%d", testAPNSDeviceToken, i), "", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+	}
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken+"0", "", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+	require.Equal(t, errAPNSTooManyDevices, store.UpsertDevice(testAPNSDeviceToken+"11", "", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken+"99", "", netip.MustParseAddr("9.9.9.9"), []string{"test-topic"}))
+}
+
+func TestAPNSStore_RemoveDevice(t *testing.T) {
+	store := newTestAPNSStore(t)
+	defer store.Close()
+
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken, "u_1234", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken+"1", "u_5678", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+	require.Nil(t, store.RemoveDevice(testAPNSDeviceToken))
+	devices, err := store.DevicesForTopic("test-topic")
+	require.Nil(t, err)
+	require.Len(t, devices, 1)
+
+	require.Equal(t, errAPNSUserIDCannotBeEmpty, store.RemoveDevicesByUserID(""))
+	require.Nil(t, store.RemoveDevicesByUserID("u_5678"))
+	devices, err = store.DevicesForTopic("test-topic")
+	require.Nil(t, err)
+	require.Len(t, devices, 0)
+}
+
+func TestAPNSStore_RemoveExpiredDevices(t *testing.T) {
+	store := newTestAPNSStore(t)
+	defer store.Close()
+
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken, "", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+	require.Nil(t, store.UpsertDevice(testAPNSDeviceToken+"1", "", netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+	removed, err := store.RemoveExpiredDevices(DefaultAPNSDeviceExpiryDuration)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), removed)
+	devices, err := store.DevicesForTopic("test-topic")
+	require.Nil(t, err)
+	require.Len(t, devices, 2)
+
+	_, err = store.db.Exec("UPDATE device SET updated_at = ? WHERE token = ?", time.Now().Add(-61*24*time.Hour).Unix(), testAPNSDeviceToken)
+	require.Nil(t, err)
+	removed, err = store.RemoveExpiredDevices(DefaultAPNSDeviceExpiryDuration)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), removed)
+	devices, err = store.DevicesForTopic("test-topic")
+	require.Nil(t, err)
+	require.Len(t, devices, 1)
+	count, err := store.DevicesCount()
+	require.Nil(t, err)
+	require.Equal(t, int64(1), count)
+}
+
+func newTestAPNSStore(t *testing.T) *apnsStore {
+	store, err := newAPNSStore(filepath.Join(t.TempDir(), "apns.db"))
+	require.Nil(t, err)
+	return store
+}
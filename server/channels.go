@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Delivery channels that can be selected per message via the X-Channels header. Messages are always
+// delivered to subscribers that are connected via HTTP (JSON/SSE/raw/WebSocket) and stored in the cache
+// (unless X-Cache: no is set). Channels only restrict the additional fan-out paths.
+const (
+	channelPush     = "push"     // Firebase (Android/iOS), APNs (iOS), and forwarding poll requests to the upstream server
+	channelWebPush  = "webpush"  // Browser notifications via Web Push
+	channelEmail    = "email"    // E-mail notifications (X-Email)
+	channelCall     = "call"     // Phone calls (X-Call)
+	channelWebhook  = "webhook"  // Outgoing webhooks of the topic
+	channelMatrix   = "matrix"   // Matrix rooms the topic is bridged to (matrix-bridge-rooms)
+	channelTelegram = "telegram" // Telegram chats the topic is relayed to (telegram-relays, or per reserved topic)
+	channelNone     = "none"     // Disables all other channels
+)
+
+var (
+	channelsSupported = []string{channelPush, channelWebPush, channelEmail, channelCall, channelWebhook, channelMatrix, channelTelegram, channelNone}
+)
+
+// parseChannels validates and normalizes a list of channels, e.g. as passed via "X-Channels: push,email".
+// An empty list means that all channels are allowed. The "none" channel cannot be combined with others.
+func parseChannels(channels []string) ([]string, error) {
+	normalized := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		channel = strings.ToLower(channel)
+		if !util.Contains(channelsSupported, channel) {
+			return nil, fmt.Errorf("channel '%s' unknown, supported channels are: %s", channel, strings.Join(channelsSupported, ", "))
+		}
+		if !util.Contains(normalized, channel) {
+			normalized = append(normalized, channel)
+		}
+	}
+	if len(normalized) > 1 && util.Contains(normalized, channelNone) {
+		return nil, fmt.Errorf("channel '%s' cannot be combined with other channels", channelNone)
+	}
+	return normalized, nil
+}
+
+// channelAllowed returns true if the message may be delivered via the given channel. If no channels
+// are defined for the message, all channels are allowed.
+func (m *message) channelAllowed(channel string) bool {
+	return len(m.Channels) == 0 || util.Contains(m.Channels, channel)
+}
+
+// subscriptionChannelAllowed returns true if the user's subscription preferences for the given topic allow
+// delivery via the given channel at this time (see user.DeliveryPrefs). If the user is nil, or has no
+// subscription for the topic on this server, delivery is allowed.
+func (s *Server) subscriptionChannelAllowed(u *user.User, topic, channel string) bool {
+	if u == nil || u.Prefs == nil {
+		return true
+	}
+	for _, sub := range u.Prefs.Subscriptions {
+		if sub.Topic != topic || sub.Delivery == nil || (s.config.Load().BaseURL != "" && sub.BaseURL != s.config.Load().BaseURL) {
+			continue
+		}
+		switch channel {
+		case channelPush:
+			return sub.Delivery.Push.Allowed(time.Now())
+		case channelWebPush:
+			return sub.Delivery.WebPush.Allowed(time.Now())
+		case channelEmail:
+			return sub.Delivery.Email.Allowed(time.Now())
+		case channelCall:
+			return sub.Delivery.Call.Allowed(time.Now())
+		}
+	}
+	return true
+}
+
+// deliveryUsers looks up the users that subscriptions or devices belong to while a message is fanned out,
+// so that each user is only loaded from the database once per message
+type deliveryUsers struct {
+	s     *Server
+	users map[string]*user.User
+}
+
+func newDeliveryUsers(s *Server) *deliveryUsers {
+	return &deliveryUsers{
+		s:     s,
+		users: make(map[string]*user.User),
+	}
+}
+
+// ChannelAllowed returns true if the subscription preferences of the user with the given ID allow delivery
+// via the given channel (see subscriptionChannelAllowed). Anonymous and deleted users are always allowed.
+func (d *deliveryUsers) ChannelAllowed(userID, topic, channel string) bool {
+	if d.s.userManager == nil || userID == "" {
+		return true
+	}
+	u, ok := d.users[userID]
+	if !ok {
+		u, _ = d.s.userManager.UserByID(userID) // User may have been deleted; subscription will be removed eventually
+		d.users[userID] = u
+	}
+	return d.s.subscriptionChannelAllowed(u, topic, channel)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseChannels(t *testing.T) {
+	channels, err := parseChannels([]string{})
+	require.Nil(t, err)
+	require.Empty(t, channels)
+
+	channels, err = parseChannels([]string{"Push", "email", "push"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"push", "email"}, channels)
+
+	channels, err = parseChannels([]string{"none"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"none"}, channels)
+}
+
+func TestParseChannels_Invalid(t *testing.T) {
+	_, err := parseChannels([]string{"push", "sms"})
+	require.EqualError(t, err, "channel 'sms' unknown, supported channels are: push, webpush, email, call, webhook, matrix, telegram, none")
+
+	_, err = parseChannels([]string{"none", "email"})
+	require.EqualError(t, err, "channel 'none' cannot be combined with other channels")
+}
+
+func TestMessage_ChannelAllowed(t *testing.T) {
+	m := newDefaultMessage("mytopic", "hi")
+	require.True(t, m.channelAllowed(channelPush))
+	require.True(t, m.channelAllowed(channelEmail))
+
+	m.Channels = []string{channelEmail}
+	require.False(t, m.channelAllowed(channelPush))
+	require.True(t, m.channelAllowed(channelEmail))
+
+	m.Channels = []string{channelNone}
+	require.False(t, m.channelAllowed(channelPush))
+	require.False(t, m.channelAllowed(channelWebPush))
+}
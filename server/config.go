@@ -3,9 +3,11 @@ package server
 import (
 	"io/fs"
 	"net/netip"
+	"strings"
 	"time"
 
 	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util/sprig"
 )
 
 // Defines default config settings (excluding limits, see below)
@@ -13,17 +15,37 @@ const (
 	DefaultListenHTTP                           = ":80"
 	DefaultConfigFile                           = "/etc/ntfy/server.yml"
 	DefaultTemplateDir                          = "/etc/ntfy/templates"
+	DefaultTemplateLoopLimit                    = sprig.DefaultLoopExecutionLimit
+	DefaultTemplateStringLimit                  = sprig.DefaultStringLengthLimit
+	DefaultTemplateTimeout                      = 100 * time.Millisecond
 	DefaultCacheDuration                        = 12 * time.Hour
 	DefaultCacheBatchTimeout                    = time.Duration(0)
+	DefaultCacheTombstoneDuration               = 12 * time.Hour
 	DefaultKeepaliveInterval                    = 45 * time.Second // Not too frequently to save battery (Android read timeout used to be 77s!)
+	DefaultWebSocketCompressionLevel            = 1                // flate.BestSpeed, uses the least CPU and memory
 	DefaultManagerInterval                      = time.Minute
+	DefaultAuthWebhookTimeout                   = user.DefaultAuthWebhookTimeout
+	DefaultAuthWebhookCacheTTL                  = 30 * time.Second
+	DefaultAuthSessionTokenDuration             = time.Hour
+	DefaultAuthSessionRefreshDuration           = 72 * time.Hour
 	DefaultDelayedSenderInterval                = 10 * time.Second
 	DefaultMessageDelayMin                      = 10 * time.Second
 	DefaultMessageDelayMax                      = 3 * 24 * time.Hour
+	DefaultMessageDedupWindow                   = 10 * time.Minute
+	DefaultReportThrottleInterval               = time.Minute
 	DefaultFirebaseKeepaliveInterval            = 3 * time.Hour    // ~control topic (Android), not too frequently to save battery
 	DefaultFirebasePollInterval                 = 20 * time.Minute // ~poll topic (iOS), max. 2-3 times per hour (see docs)
 	DefaultFirebaseQuotaExceededPenaltyDuration = 10 * time.Minute // Time that over-users are locked out of Firebase if it returns "quota exceeded"
 	DefaultStripePriceCacheDuration             = 3 * time.Hour    // Time to keep Stripe prices cached in memory before a refresh is needed
+	DefaultStripePaymentGracePeriod             = 7 * 24 * time.Hour
+	DefaultAccountEmailFallbackDuration         = 7 * 24 * time.Hour
+	DefaultWebStaticCacheControl                = "public, max-age=3600" // Cache-Control for static web app/docs files (except HTML pages)
+)
+
+// Defines default metrics settings
+const (
+	DefaultMetricsCardinalityLimit = 0 // Per-topic and per-user metrics are disabled by default
+	DefaultMetricsPushInterval     = 15 * time.Second
 )
 
 // Defines default Web Push settings
@@ -32,6 +54,12 @@ const (
 	DefaultWebPushExpiryDuration        = 60 * 24 * time.Hour
 )
 
+// Defines default APNs settings
+const (
+	DefaultAPNSBundleID             = "io.heckel.ntfy"
+	DefaultAPNSDeviceExpiryDuration = 60 * 24 * time.Hour
+)
+
 // Defines all global and per-visitor limits
 // - message size limit: the max number of bytes for a message
 // - total topic limit: max number of topics overall
@@ -42,6 +70,7 @@ const (
 	DefaultAttachmentTotalSizeLimit = int64(5 * 1024 * 1024 * 1024) // 5 GB
 	DefaultAttachmentFileSizeLimit  = int64(15 * 1024 * 1024)       // 15 MB
 	DefaultAttachmentExpiryDuration = 3 * time.Hour
+	DefaultAttachmentScanTimeout    = 30 * time.Second
 )
 
 // Defines all per-visitor limits
@@ -50,10 +79,14 @@ const (
 // - per visitor email limit: max number of emails (here: 16 email bucket, replenished at a rate of one per hour)
 // - per visitor attachment size limit: total per-visitor attachment size in bytes to be stored on the server
 // - per visitor attachment daily bandwidth limit: number of bytes that can be transferred to/from the server
+// - per visitor recurring message limit: max number of recurring messages (see X-Cron) per visitor/user
 const (
 	DefaultVisitorSubscriptionLimit             = 30
+	DefaultVisitorRecurringMessageLimit         = 10
 	DefaultVisitorRequestLimitBurst             = 60
 	DefaultVisitorRequestLimitReplenish         = 5 * time.Second
+	DefaultVisitorStatsHourlyRetention          = 7 * 24 * time.Hour
+	DefaultVisitorStatsDailyRetention           = 365 * 24 * time.Hour
 	DefaultVisitorMessageDailyLimit             = 0
 	DefaultVisitorEmailLimitBurst               = 16
 	DefaultVisitorEmailLimitReplenish           = time.Hour
@@ -61,6 +94,8 @@ const (
 	DefaultVisitorAccountCreationLimitReplenish = 24 * time.Hour
 	DefaultVisitorAuthFailureLimitBurst         = 30
 	DefaultVisitorAuthFailureLimitReplenish     = time.Minute
+	DefaultVisitorReportLimitBurst              = 10
+	DefaultVisitorReportLimitReplenish          = time.Hour
 	DefaultVisitorAttachmentTotalSizeLimit      = 100 * 1024 * 1024 // 100 MB
 	DefaultVisitorAttachmentDailyBandwidthLimit = 500 * 1024 * 1024 // 500 MB
 	DefaultVisitorPrefixBitsIPv4                = 32                // Use the entire IPv4 address for rate limiting
@@ -82,43 +117,88 @@ type Config struct {
 	BaseURL                              string
 	ListenHTTP                           string
 	ListenHTTPS                          string
+	ListenGRPC                           string
+	ListenTCP                            string
+	TCPAuthRules                         []*TCPAuthRule // Source IPs that may connect to ListenTCP, first match wins
+	ListenSTOMP                          string
 	ListenUnix                           string
 	ListenUnixMode                       fs.FileMode
 	KeyFile                              string
 	CertFile                             string
+	ACMEDomains                          []string // If set, certificates are obtained via ACME (e.g. Let's Encrypt) instead of KeyFile/CertFile
+	ACMEEmail                            string
+	ACMECacheDir                         string
+	ACMEDirectoryURL                     string // ACME directory, defaults to Let's Encrypt (production)
 	FirebaseKeyFile                      string
+	APNSKeyFile                          string
+	APNSKeyID                            string
+	APNSTeamID                           string
+	APNSBundleID                         string
+	APNSSandbox                          bool
+	APNSFile                             string
+	APNSDeviceExpiryDuration             time.Duration // Devices that have not re-registered for this long are removed
 	CacheFile                            string
 	CacheDuration                        time.Duration
+	CacheTombstoneDuration               time.Duration
 	CacheStartupQueries                  string
 	CacheBatchSize                       int
 	CacheBatchTimeout                    time.Duration
+	CacheEncryptionKey                   []byte   // Master key (32 bytes) to derive per-topic keys from, see CacheEncryptedTopics
+	CacheEncryptedTopics                 []string // Topics whose messages and attachments are encrypted at rest
 	AuthFile                             string
 	AuthStartupQueries                   string
 	AuthDefault                          user.Permission
 	AuthUsers                            []*user.User
 	AuthAccess                           map[string][]*user.Grant
 	AuthTokens                           map[string][]*user.Token
+	AuthPruneProvisioned                 bool
+	AuthWebhookURL                       string // External HTTP endpoint that decides about topic access, see user.Config
+	AuthWebhookTimeout                   time.Duration
+	AuthWebhookCacheTTL                  time.Duration
 	AuthBcryptCost                       int
 	AuthStatsQueueWriterInterval         time.Duration
+	AuthSessionTokenDuration             time.Duration // Lifetime of web app access tokens, which are renewed with a refresh token
+	AuthSessionRefreshDuration           time.Duration // Web app sessions expire after this much inactivity
+	AuthTokenAlerts                      bool          // Notify users if one of their access tokens is used from a new country or network, requires GeoIP
 	AttachmentCacheDir                   string
 	AttachmentTotalSizeLimit             int64
 	AttachmentFileSizeLimit              int64
 	AttachmentExpiryDuration             time.Duration
-	TemplateDir                          string // Directory to load named templates from
+	AttachmentExpiryRules                []*AttachmentExpiryRule // Expiry durations by MIME type or file extension, first match wins
+	AttachmentScanURL                    string                  // clamd:///path/to/clamd.sock, clamd://host:port or icap://host:port/service
+	AttachmentScanAction                 string                  // What to do with infected attachments, see AttachmentScanActionReject
+	AttachmentScanTimeout                time.Duration           // Max time to scan a single attachment, after which the message is rejected
+	AttachmentQuarantineDir              string                  // Directory for infected attachments, if AttachmentScanAction is "quarantine"
+	TemplateDir                          string                  // Directory to load named templates from
+	TemplateLoopLimit                    int                     // Max number of loop iterations of template functions, e.g. repeat or until
+	TemplateStringLimit                  int                     // Max length of strings built by template functions, e.g. repeat
+	TemplateTimeout                      time.Duration           // Max time a template may take to render
 	KeepaliveInterval                    time.Duration
+	WebSocketCompression                 bool // Negotiate permessage-deflate on WebSocket connections
+	WebSocketCompressionLevel            int
 	ManagerInterval                      time.Duration
 	DisallowedTopics                     []string
-	WebRoot                              string // empty to disable
+	TopicContentTypes                    map[string]string // Topic -> default content type (text/plain or text/markdown)
+	WebRoot                              string            // empty to disable
+	WebAppName                           string            // Branding: name shown instead of "ntfy", empty for default
+	WebAppLogoURL                        string            // Branding: logo URL or path, empty for default
+	WebAppAccentColor                    string            // Branding: hex color (#rrggbb), empty for default
+	WebAppTermsURL                       string            // Branding: terms of service URL, empty to hide the link
+	WebStaticCacheControl                string            // Cache-Control header for static files, empty to not send it
 	DelayedSenderInterval                time.Duration
 	FirebaseKeepaliveInterval            time.Duration
 	FirebasePollInterval                 time.Duration
 	FirebaseQuotaExceededPenaltyDuration time.Duration
 	UpstreamBaseURL                      string
 	UpstreamAccessToken                  string
+	ClusterPeers                         []string
+	ClusterSecret                        string
 	SMTPSenderAddr                       string
 	SMTPSenderUser                       string
 	SMTPSenderPass                       string
 	SMTPSenderFrom                       string
+	SMTPSenderFromTiers                  map[string]string // Tier code -> sender address, overrides SMTPSenderFrom
+	SMTPSenderFromUsers                  map[string]string // Username -> sender address, overrides SMTPSenderFromTiers
 	SMTPServerListen                     string
 	SMTPServerDomain                     string
 	SMTPServerAddrPrefix                 string
@@ -130,18 +210,43 @@ type Config struct {
 	TwilioVerifyService                  string
 	MetricsEnable                        bool
 	MetricsListenHTTP                    string
+	MetricsCardinalityLimit              int
+	MetricsPushInterval                  time.Duration
+	MetricsRemoteWriteURL                string // Prometheus remote write endpoint to push metrics to
+	MetricsStatsdAddress                 string // host:port of a StatsD/DogStatsD server to push metrics to (UDP)
+	SentryDSN                            string // Reports panics and internal errors to Sentry, if set
+	SentryEnvironment                    string
+	AccessLogFile                        string // Writes one JSON line per HTTP request to this file ("-" for stdout), if set
+	Experiments                          []*Experiment
 	ProfileListenHTTP                    string
 	MessageDelayMin                      time.Duration
 	MessageDelayMax                      time.Duration
 	MessageSizeLimit                     int
+	MessageDedupWindow                   time.Duration       // Time in which messages with the same dedup key (X-Dedup-Key) are suppressed, see dedupCache
+	EnableLinkPreviews                   bool                // Fetch Open Graph metadata of the first URL in a message, see linkPreviewer
+	EnableWebhooks                       bool                // Allow owners of reserved topics to register outgoing webhooks, see webhookSender
+	WebhookAllowPrivateNetworks          bool                // Allow webhooks to private/loopback addresses (SSRF protection is disabled!)
+	WebhookTLS                           []*WebhookTLS       // Client certificates and CA bundles per webhook destination host
+	MatrixBridgeHomeserver               string              // Base URL of the Matrix homeserver to relay messages to, see matrixBridge
+	MatrixBridgeToken                    string              // Access token of a bot account, or as_token of an application service
+	MatrixBridgeUserID                   string              // Application service user to send messages as, e.g. @ntfy:example.com
+	MatrixBridgeRooms                    map[string][]string // Topic -> Matrix room IDs
+	TelegramBotToken                     string              // Telegram bot used for TelegramRelays
+	TelegramRelays                       map[string][]string // Topic -> Telegram chat IDs, see telegramRelay
+	EnableTelegramRelays                 bool                // Allow owners of reserved topics to relay messages to Telegram with their own bot
+	ReportThrottleThreshold              int                 // Number of distinct reporters after which a topic is throttled, zero disables throttling
+	ReportThrottleInterval               time.Duration       // Throttled topics can publish one message per interval
 	TotalTopicLimit                      int
 	TotalAttachmentSizeLimit             int64
 	VisitorSubscriptionLimit             int
+	VisitorRecurringMessageLimit         int
 	VisitorAttachmentTotalSizeLimit      int64
 	VisitorAttachmentDailyBandwidthLimit int64
+	TopicAttachmentDailyBandwidthLimit   int64 // Daily attachment download limit per topic, zero means unlimited
 	VisitorRequestLimitBurst             int
 	VisitorRequestLimitReplenish         time.Duration
 	VisitorRequestExemptPrefixes         []netip.Prefix
+	VisitorRequestLimitWindows           []*RateLimitWindow // Daily time windows in which the request limits are relaxed or tightened
 	VisitorMessageDailyLimit             int
 	VisitorEmailLimitBurst               int
 	VisitorEmailLimitReplenish           time.Duration
@@ -149,21 +254,41 @@ type Config struct {
 	VisitorAccountCreationLimitReplenish time.Duration
 	VisitorAuthFailureLimitBurst         int
 	VisitorAuthFailureLimitReplenish     time.Duration
-	VisitorStatsResetTime                time.Time      // Time of the day at which to reset visitor stats
-	VisitorSubscriberRateLimiting        bool           // Enable subscriber-based rate limiting for UnifiedPush topics
-	VisitorPrefixBitsIPv4                int            // Number of bits for IPv4 rate limiting (default: 32)
-	VisitorPrefixBitsIPv6                int            // Number of bits for IPv6 rate limiting (default: 64)
-	BehindProxy                          bool           // If true, the server will trust the proxy client IP header to determine the client IP address (IPv4 and IPv6 supported)
-	ProxyForwardedHeader                 string         // The header field to read the real/client IP address from, if BehindProxy is true, defaults to "X-Forwarded-For" (IPv4 and IPv6 supported)
-	ProxyTrustedPrefixes                 []netip.Prefix // List of trusted proxy networks (IPv4 or IPv6) that will be stripped from the Forwarded header if BehindProxy is true
+	VisitorReportLimitBurst              int
+	VisitorReportLimitReplenish          time.Duration
+	VisitorStatsResetTime                time.Time          // Time of the day at which to reset visitor stats
+	VisitorStatsHourlyRetention          time.Duration      // Duration for which hourly stats rollups are kept
+	VisitorStatsDailyRetention           time.Duration      // Duration for which daily stats rollups are kept
+	VisitorSubscriberRateLimiting        bool               // Enable subscriber-based rate limiting for UnifiedPush topics
+	UnifiedPushAppRequestLimitBurst      int                // Request budget per UnifiedPush app (topic), replaces the rate visitor's budget if set
+	UnifiedPushAppRequestLimitReplenish  time.Duration      // Interval at which the UnifiedPush app request budget is replenished
+	UnifiedPushAppMessageDailyLimit      int                // Daily message limit per UnifiedPush app (topic), zero means unlimited
+	VisitorLimitRedisURL                 string             // Redis URL to share request, message and email limits between servers, if set
+	VisitorPrefixBitsIPv4                int                // Number of bits for IPv4 rate limiting (default: 32)
+	VisitorPrefixBitsIPv6                int                // Number of bits for IPv6 rate limiting (default: 64)
+	BehindProxy                          bool               // If true, the server will trust the proxy client IP header of any client to determine the client IP address (deprecated, use ProxyTrustedPrefixes)
+	ProxyForwardedHeader                 string             // The header field to read the real/client IP address from, defaults to "X-Forwarded-For" (IPv4 and IPv6 supported)
+	ProxyTrustedPrefixes                 []netip.Prefix     // List of trusted proxy networks (IPv4 or IPv6); the header is only used for requests from these, and they are skipped in the header
+	GeoIPDatabase                        string             // MaxMind database file (e.g. GeoLite2-Country.mmdb) to look up the country of visitors, if set
+	GeoIPASNDatabase                     string             // MaxMind ASN database file (GeoLite2-ASN.mmdb) to look up the network of visitors, if set
+	GeoIPBlockedCountries                []string           // ISO country codes (e.g. "KP") from which all requests are rejected, requires GeoIPDatabase
+	GeoIPRequestLimitFactors             map[string]float64 // ISO country code -> factor applied to the request limits of visitors without a tier
 	StripeSecretKey                      string
 	StripeWebhookKey                     string
 	StripePriceCacheDuration             time.Duration
+	StripeAutomaticTax                   bool
+	StripeBillingAddressCollection       string
+	StripeInvoiceFooter                  string
+	StripeInvoiceCustomFields            []*StripeInvoiceCustomField
+	StripePaymentGracePeriod             time.Duration
 	BillingContact                       string
 	EnableSignup                         bool // Enable creation of accounts via API and UI
 	EnableLogin                          bool
 	RequireLogin                         bool
 	EnableReservations                   bool // Allow users with role "user" to own/reserve topics
+	RequireAdminWebAuthn                 bool // Require a WebAuthn assertion for destructive admin API operations
+	EnableImpersonation                  bool // Allow admins to act as other users via the X-Act-As header
+	AccountEmailFallbackDuration         time.Duration
 	EnableMetrics                        bool
 	AccessControlAllowOrigin             string // CORS header field to restrict access from web clients
 	WebPushPrivateKey                    string
@@ -176,6 +301,50 @@ type Config struct {
 	Version                              string // injected by App
 }
 
+// StripeInvoiceCustomField is a custom field (e.g. the VAT number of the operator) that is displayed
+// on the Stripe invoices of all customers, see stripe-invoice-custom-fields
+type StripeInvoiceCustomField struct {
+	Name  string
+	Value string
+}
+
+// AttachmentExpiryRule overrides the attachment expiry duration for attachments matching Pattern. The pattern is
+// either a file extension (e.g. ".log"), a MIME type (e.g. "application/pdf"), or a MIME type wildcard (e.g. "image/*").
+type AttachmentExpiryRule struct {
+	Pattern  string
+	Duration time.Duration
+}
+
+// Matches returns true if an attachment with the given MIME type and file name matches the rule
+func (r *AttachmentExpiryRule) Matches(contentType, filename string) bool {
+	if strings.HasPrefix(r.Pattern, ".") {
+		return strings.HasSuffix(strings.ToLower(filename), r.Pattern)
+	}
+	mimeType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mimeType = strings.TrimSpace(mimeType)
+	if prefix, ok := strings.CutSuffix(r.Pattern, "/*"); ok {
+		return strings.HasPrefix(mimeType, prefix+"/")
+	}
+	return mimeType == r.Pattern
+}
+
+// RateLimitWindow is a daily time window (in the server's local time) in which the visitor request limits
+// (burst and replenish rate) are multiplied by Factor. If End is before Start, the window spans midnight.
+type RateLimitWindow struct {
+	Start  time.Duration // Offset from midnight
+	End    time.Duration // Offset from midnight
+	Factor float64
+}
+
+// Contains returns true if the time of day of t is within the window
+func (w *RateLimitWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
 // NewConfig instantiates a default new server config
 func NewConfig() *Config {
 	return &Config{
@@ -183,40 +352,79 @@ func NewConfig() *Config {
 		BaseURL:                              "",
 		ListenHTTP:                           DefaultListenHTTP,
 		ListenHTTPS:                          "",
+		ListenGRPC:                           "",
+		ListenTCP:                            "",
+		TCPAuthRules:                         make([]*TCPAuthRule, 0),
+		ListenSTOMP:                          "",
 		ListenUnix:                           "",
 		ListenUnixMode:                       0,
 		KeyFile:                              "",
 		CertFile:                             "",
+		ACMEDomains:                          nil,
+		ACMEEmail:                            "",
+		ACMECacheDir:                         "",
+		ACMEDirectoryURL:                     "",
 		FirebaseKeyFile:                      "",
+		APNSKeyFile:                          "",
+		APNSKeyID:                            "",
+		APNSTeamID:                           "",
+		APNSBundleID:                         DefaultAPNSBundleID,
+		APNSSandbox:                          false,
+		APNSFile:                             "",
+		APNSDeviceExpiryDuration:             DefaultAPNSDeviceExpiryDuration,
 		CacheFile:                            "",
 		CacheDuration:                        DefaultCacheDuration,
+		CacheTombstoneDuration:               DefaultCacheTombstoneDuration,
 		CacheStartupQueries:                  "",
 		CacheBatchSize:                       0,
 		CacheBatchTimeout:                    0,
+		CacheEncryptionKey:                   nil,
+		CacheEncryptedTopics:                 []string{},
 		AuthFile:                             "",
 		AuthStartupQueries:                   "",
 		AuthDefault:                          user.PermissionReadWrite,
 		AuthBcryptCost:                       user.DefaultUserPasswordBcryptCost,
 		AuthStatsQueueWriterInterval:         user.DefaultUserStatsQueueWriterInterval,
+		AuthWebhookURL:                       "",
+		AuthWebhookTimeout:                   DefaultAuthWebhookTimeout,
+		AuthWebhookCacheTTL:                  DefaultAuthWebhookCacheTTL,
+		AuthSessionTokenDuration:             DefaultAuthSessionTokenDuration,
+		AuthSessionRefreshDuration:           DefaultAuthSessionRefreshDuration,
+		AuthTokenAlerts:                      false,
 		AttachmentCacheDir:                   "",
 		AttachmentTotalSizeLimit:             DefaultAttachmentTotalSizeLimit,
 		AttachmentFileSizeLimit:              DefaultAttachmentFileSizeLimit,
 		AttachmentExpiryDuration:             DefaultAttachmentExpiryDuration,
+		AttachmentScanURL:                    "",
+		AttachmentScanAction:                 AttachmentScanActionReject,
+		AttachmentScanTimeout:                DefaultAttachmentScanTimeout,
+		AttachmentQuarantineDir:              "",
 		TemplateDir:                          DefaultTemplateDir,
+		TemplateLoopLimit:                    DefaultTemplateLoopLimit,
+		TemplateStringLimit:                  DefaultTemplateStringLimit,
+		TemplateTimeout:                      DefaultTemplateTimeout,
 		KeepaliveInterval:                    DefaultKeepaliveInterval,
+		WebSocketCompression:                 false,
+		WebSocketCompressionLevel:            DefaultWebSocketCompressionLevel,
 		ManagerInterval:                      DefaultManagerInterval,
 		DisallowedTopics:                     DefaultDisallowedTopics,
+		TopicContentTypes:                    make(map[string]string),
 		WebRoot:                              "/",
+		WebStaticCacheControl:                DefaultWebStaticCacheControl,
 		DelayedSenderInterval:                DefaultDelayedSenderInterval,
 		FirebaseKeepaliveInterval:            DefaultFirebaseKeepaliveInterval,
 		FirebasePollInterval:                 DefaultFirebasePollInterval,
 		FirebaseQuotaExceededPenaltyDuration: DefaultFirebaseQuotaExceededPenaltyDuration,
 		UpstreamBaseURL:                      "",
 		UpstreamAccessToken:                  "",
+		ClusterPeers:                         make([]string, 0),
+		ClusterSecret:                        "",
 		SMTPSenderAddr:                       "",
 		SMTPSenderUser:                       "",
 		SMTPSenderPass:                       "",
 		SMTPSenderFrom:                       "",
+		SMTPSenderFromTiers:                  make(map[string]string),
+		SMTPSenderFromUsers:                  make(map[string]string),
 		SMTPServerListen:                     "",
 		SMTPServerDomain:                     "",
 		SMTPServerAddrPrefix:                 "",
@@ -226,15 +434,34 @@ func NewConfig() *Config {
 		TwilioPhoneNumber:                    "",
 		TwilioVerifyBaseURL:                  "https://verify.twilio.com", // Override for tests
 		TwilioVerifyService:                  "",
+		MetricsCardinalityLimit:              DefaultMetricsCardinalityLimit,
+		MetricsPushInterval:                  DefaultMetricsPushInterval,
+		Experiments:                          make([]*Experiment, 0),
 		MessageSizeLimit:                     DefaultMessageSizeLimit,
 		MessageDelayMin:                      DefaultMessageDelayMin,
 		MessageDelayMax:                      DefaultMessageDelayMax,
+		MessageDedupWindow:                   DefaultMessageDedupWindow,
+		EnableLinkPreviews:                   false,
+		EnableWebhooks:                       false,
+		WebhookAllowPrivateNetworks:          false,
+		WebhookTLS:                           make([]*WebhookTLS, 0),
+		MatrixBridgeRooms:                    make(map[string][]string),
+		TelegramRelays:                       make(map[string][]string),
+		EnableTelegramRelays:                 false,
+		ReportThrottleThreshold:              0,
+		ReportThrottleInterval:               DefaultReportThrottleInterval,
 		TotalTopicLimit:                      DefaultTotalTopicLimit,
 		TotalAttachmentSizeLimit:             0,
 		VisitorSubscriptionLimit:             DefaultVisitorSubscriptionLimit,
+		VisitorRecurringMessageLimit:         DefaultVisitorRecurringMessageLimit,
 		VisitorSubscriberRateLimiting:        false,
+		UnifiedPushAppRequestLimitBurst:      0,
+		UnifiedPushAppRequestLimitReplenish:  DefaultVisitorRequestLimitReplenish,
+		UnifiedPushAppMessageDailyLimit:      0,
+		VisitorLimitRedisURL:                 "",
 		VisitorAttachmentTotalSizeLimit:      DefaultVisitorAttachmentTotalSizeLimit,
 		VisitorAttachmentDailyBandwidthLimit: DefaultVisitorAttachmentDailyBandwidthLimit,
+		TopicAttachmentDailyBandwidthLimit:   0,
 		VisitorRequestLimitBurst:             DefaultVisitorRequestLimitBurst,
 		VisitorRequestLimitReplenish:         DefaultVisitorRequestLimitReplenish,
 		VisitorRequestExemptPrefixes:         make([]netip.Prefix, 0),
@@ -245,18 +472,34 @@ func NewConfig() *Config {
 		VisitorAccountCreationLimitReplenish: DefaultVisitorAccountCreationLimitReplenish,
 		VisitorAuthFailureLimitBurst:         DefaultVisitorAuthFailureLimitBurst,
 		VisitorAuthFailureLimitReplenish:     DefaultVisitorAuthFailureLimitReplenish,
+		VisitorReportLimitBurst:              DefaultVisitorReportLimitBurst,
+		VisitorReportLimitReplenish:          DefaultVisitorReportLimitReplenish,
 		VisitorStatsResetTime:                DefaultVisitorStatsResetTime,
+		VisitorStatsHourlyRetention:          DefaultVisitorStatsHourlyRetention,
+		VisitorStatsDailyRetention:           DefaultVisitorStatsDailyRetention,
 		VisitorPrefixBitsIPv4:                DefaultVisitorPrefixBitsIPv4, // Default: use full IPv4 address
 		VisitorPrefixBitsIPv6:                DefaultVisitorPrefixBitsIPv6, // Default: use /64 for IPv6
 		BehindProxy:                          false,                        // If true, the server will trust the proxy client IP header to determine the client IP address
 		ProxyForwardedHeader:                 "X-Forwarded-For",            // Default header for reverse proxy client IPs
+		GeoIPDatabase:                        "",
+		GeoIPASNDatabase:                     "",
+		GeoIPBlockedCountries:                make([]string, 0),
+		GeoIPRequestLimitFactors:             make(map[string]float64),
 		StripeSecretKey:                      "",
 		StripeWebhookKey:                     "",
 		StripePriceCacheDuration:             DefaultStripePriceCacheDuration,
+		StripeAutomaticTax:                   true,
+		StripeBillingAddressCollection:       "auto",
+		StripeInvoiceFooter:                  "",
+		StripeInvoiceCustomFields:            make([]*StripeInvoiceCustomField, 0),
+		StripePaymentGracePeriod:             DefaultStripePaymentGracePeriod,
 		BillingContact:                       "",
 		EnableSignup:                         false,
 		EnableLogin:                          false,
 		EnableReservations:                   false,
+		RequireAdminWebAuthn:                 false,
+		EnableImpersonation:                  false,
+		AccountEmailFallbackDuration:         DefaultAccountEmailFallbackDuration,
 		RequireLogin:                         false,
 		AccessControlAllowOrigin:             "*",
 		Version:                              "",
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	// encryptedPrefix marks encrypted strings in the message cache, so that encrypted and
+	// unencrypted (e.g. older) messages can be told apart when reading them back
+	encryptedPrefix = "ntfy-enc-v1:"
+
+	// encryptionKeyLength is the length of the master key and the per-topic keys (AES-256)
+	encryptionKeyLength = 32
+)
+
+var (
+	errEncryptionKeyInvalid = errors.New("encryption key must be 32 bytes")
+	errDecryptionFailed     = errors.New("unable to decrypt, ciphertext invalid or key changed")
+	errDecryptionNoKey      = errors.New("unable to decrypt, encryption key not configured")
+)
+
+// topicEncryption encrypts message bodies and attachments at rest for a list of topics. Each topic has its own
+// AES-256-GCM key, which is derived from the server's master key (see encryption-key) using HKDF, so that no
+// per-topic keys have to be stored. The topic name is used as additional authenticated data, so ciphertext
+// cannot be moved between topics.
+//
+// A nil *topicEncryption is valid and encrypts nothing.
+type topicEncryption struct {
+	key    []byte
+	topics []string
+}
+
+func newTopicEncryption(key []byte, topics []string) (*topicEncryption, error) {
+	if len(topics) == 0 {
+		return nil, nil
+	} else if len(key) != encryptionKeyLength {
+		return nil, errEncryptionKeyInvalid
+	}
+	return &topicEncryption{
+		key:    key,
+		topics: topics,
+	}, nil
+}
+
+// Enabled returns true if messages and attachments for the given topic are encrypted
+func (e *topicEncryption) Enabled(topic string) bool {
+	return e != nil && util.Contains(e.topics, topic)
+}
+
+// Encrypt encrypts the plaintext with the topic key, and returns nonce and ciphertext
+func (e *topicEncryption) Encrypt(topic string, plaintext []byte) ([]byte, error) {
+	aead, err := e.aead(topic)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(topic)), nil
+}
+
+// Decrypt reverses Encrypt
+func (e *topicEncryption) Decrypt(topic string, ciphertext []byte) ([]byte, error) {
+	if e == nil {
+		return nil, errDecryptionNoKey
+	}
+	aead, err := e.aead(topic)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errDecryptionFailed
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], []byte(topic))
+	if err != nil {
+		return nil, errDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// EncryptString encrypts s and returns it in its prefixed, base64-encoded form. Empty strings are not encrypted.
+func (e *topicEncryption) EncryptString(topic, s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	ciphertext, err := e.Encrypt(topic, []byte(s))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// DecryptString decrypts a string encrypted with EncryptString. Strings without the prefix are returned as is.
+func (e *topicEncryption) DecryptString(topic, s string) (string, error) {
+	if !strings.HasPrefix(s, encryptedPrefix) {
+		return s, nil
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, encryptedPrefix))
+	if err != nil {
+		return "", errDecryptionFailed
+	}
+	plaintext, err := e.Decrypt(topic, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (e *topicEncryption) aead(topic string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, e.key, nil, "ntfy topic key: "+topic, encryptionKeyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+var testEncryptionKey = []byte("01234567890123456789012345678901")
+
+func TestTopicEncryption_EncryptDecrypt(t *testing.T) {
+	e, err := newTopicEncryption(testEncryptionKey, []string{"secret"})
+	require.Nil(t, err)
+	require.True(t, e.Enabled("secret"))
+	require.False(t, e.Enabled("public"))
+
+	ciphertext, err := e.EncryptString("secret", "hello world")
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(ciphertext, encryptedPrefix))
+	require.NotContains(t, ciphertext, "hello")
+
+	plaintext, err := e.DecryptString("secret", ciphertext)
+	require.Nil(t, err)
+	require.Equal(t, "hello world", plaintext)
+
+	// Ciphertext is bound to the topic
+	_, err = e.DecryptString("other", ciphertext)
+	require.Equal(t, errDecryptionFailed, err)
+
+	// Unencrypted strings are passed through
+	plaintext, err = e.DecryptString("secret", "not encrypted")
+	require.Nil(t, err)
+	require.Equal(t, "not encrypted", plaintext)
+}
+
+func TestTopicEncryption_DifferentKeys(t *testing.T) {
+	e1, err := newTopicEncryption(testEncryptionKey, []string{"secret"})
+	require.Nil(t, err)
+	e2, err := newTopicEncryption([]byte("abcdefghijabcdefghijabcdefghijab"), []string{"secret"})
+	require.Nil(t, err)
+
+	ciphertext, err := e1.Encrypt("secret", []byte("hi"))
+	require.Nil(t, err)
+	_, err = e2.Decrypt("secret", ciphertext)
+	require.Equal(t, errDecryptionFailed, err)
+}
+
+func TestTopicEncryption_Disabled(t *testing.T) {
+	e, err := newTopicEncryption(nil, nil)
+	require.Nil(t, err)
+	require.Nil(t, e)
+	require.False(t, e.Enabled("secret"))
+
+	_, err = newTopicEncryption([]byte("too short"), []string{"secret"})
+	require.Equal(t, errEncryptionKeyInvalid, err)
+}
@@ -125,19 +125,91 @@ var (
 	errHTTPBadRequestInvalidUsername                 = &errHTTP{40046, http.StatusBadRequest, "invalid request: invalid username", "", nil}
 	errHTTPBadRequestTemplateFileNotFound            = &errHTTP{40047, http.StatusBadRequest, "invalid request: template file not found", "https://ntfy.sh/docs/publish/#message-templating", nil}
 	errHTTPBadRequestTemplateFileInvalid             = &errHTTP{40048, http.StatusBadRequest, "invalid request: template file invalid", "https://ntfy.sh/docs/publish/#message-templating", nil}
+	errHTTPBadRequestPhoneNumberNotFound             = &errHTTP{40049, http.StatusBadRequest, "invalid request: phone number not found", "https://ntfy.sh/docs/publish/#phone-calls", nil}
+	errHTTPBadRequestChannelsInvalid                 = &errHTTP{40050, http.StatusBadRequest, "invalid request: channels invalid", "https://ntfy.sh/docs/publish/#delivery-channels", nil}
+	errHTTPBadRequestAnonymousChannelsNotAllowed     = &errHTTP{40051, http.StatusBadRequest, "invalid request: selecting delivery channels requires authentication", "https://ntfy.sh/docs/publish/#delivery-channels", nil}
+	errHTTPBadRequestDeliveryPrefsInvalid            = &errHTTP{40052, http.StatusBadRequest, "invalid request: subscription delivery preferences invalid", "https://ntfy.sh/docs/publish/#delivery-channels", nil}
+	errHTTPBadRequestWebAuthnCredentialInvalid       = &errHTTP{40053, http.StatusBadRequest, "invalid request: WebAuthn credential invalid", "https://ntfy.sh/docs/config/#webauthn-confirmation", nil}
+	errHTTPBadRequestStatsRollupPeriodInvalid        = &errHTTP{40054, http.StatusBadRequest, "invalid request: period must be 'hour' or 'day'", "https://ntfy.sh/docs/config/#stats-rollups", nil}
+	errHTTPBadRequestEmailAddressInvalid             = &errHTTP{40055, http.StatusBadRequest, "invalid request: email address invalid", "https://ntfy.sh/docs/config/#email-address-changes", nil}
+	errHTTPBadRequestEmailVerificationCodeInvalid    = &errHTTP{40056, http.StatusBadRequest, "invalid request: email verification code invalid", "https://ntfy.sh/docs/config/#email-address-changes", nil}
+	errHTTPBadRequestEncryptionInvalid               = &errHTTP{40057, http.StatusBadRequest, "invalid request: encryption scheme invalid", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestEncryptionNotAllowed            = &errHTTP{40058, http.StatusBadRequest, "invalid request: encrypted messages cannot be combined with templates, file uploads, emails or phone calls", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestEncryptedMessageEmpty           = &errHTTP{40059, http.StatusBadRequest, "invalid request: encrypted message must not be empty", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestTokenNotFound                   = &errHTTP{40060, http.StatusBadRequest, "invalid request: token not found", "", nil}
+	errHTTPBadRequestReplyToInvalid                  = &errHTTP{40061, http.StatusBadRequest, "invalid request: reply-to message ID invalid, or message not found in topic", "https://ntfy.sh/docs/publish/#threads-and-replies", nil}
+	errHTTPBadRequestCronInvalid                     = &errHTTP{40062, http.StatusBadRequest, "invalid cron parameter: unable to parse cron expression", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
+	errHTTPBadRequestCronNotAllowed                  = &errHTTP{40063, http.StatusBadRequest, "invalid request: recurring messages require the message cache, and cannot be combined with delays, e-mails, phone calls, polling or file uploads", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
+	errHTTPBadRequestAPNSDeviceInvalid               = &errHTTP{40064, http.StatusBadRequest, "invalid request: APNs device token missing or malformed", "https://ntfy.sh/docs/config/#ios-instant-notifications-via-apns", nil}
+	errHTTPBadRequestAPNSTopicCountTooHigh           = &errHTTP{40065, http.StatusBadRequest, "invalid request: too many APNs topic subscriptions", "https://ntfy.sh/docs/config/#ios-instant-notifications-via-apns", nil}
+	errHTTPBadRequestAckNotAllowed                   = &errHTTP{40066, http.StatusBadRequest, "invalid request: acknowledgements require the message cache", "https://ntfy.sh/docs/publish/#acknowledgements", nil}
+	errHTTPBadRequestTopicPolicyInvalid              = &errHTTP{40067, http.StatusBadRequest, "invalid request: topic policy values must not be negative, at least one must be set, and the cache duration must not exceed the limit of your tier", "https://ntfy.sh/docs/config/#per-topic-retention", nil}
+	errHTTPBadRequestImageWidthInvalid               = &errHTTP{40068, http.StatusBadRequest, "invalid request: image width must be a positive number", "https://ntfy.sh/docs/publish/#resized-images", nil}
+	errHTTPBadRequestWebhookURLInvalid               = &errHTTP{40069, http.StatusBadRequest, "invalid request: webhook URL must be an http:// or https:// URL", "https://ntfy.sh/docs/publish/#outgoing-webhooks", nil}
+	errHTTPBadRequestTelegramInvalid                 = &errHTTP{40070, http.StatusBadRequest, "invalid request: invalid Telegram bot token or chat ID", "https://ntfy.sh/docs/config/#telegram-relays", nil}
+	errHTTPBadRequestWebhookFormatInvalid            = &errHTTP{40071, http.StatusBadRequest, "invalid request: webhook format must be ntfy, slack or discord", "https://ntfy.sh/docs/publish/#outgoing-webhooks", nil}
+	errHTTPBadRequestDedupKeyInvalid                 = &errHTTP{40072, http.StatusBadRequest, "invalid request: dedup key too long", "https://ntfy.sh/docs/publish/#message-deduplication", nil}
+	errHTTPBadRequestTokenScopeInvalid               = &errHTTP{40073, http.StatusBadRequest, "invalid request: token scope invalid", "https://ntfy.sh/docs/config/#access-tokens", nil}
+	errHTTPBadRequestGroupNotFound                   = &errHTTP{40074, http.StatusBadRequest, "invalid request: group does not exist", "https://ntfy.sh/docs/config/#groups", nil}
+	errHTTPBadRequestSignatureInvalid                = &errHTTP{40076, http.StatusBadRequest, "invalid request: signature invalid", "https://ntfy.sh/docs/publish/#message-signing", nil}
+	errHTTPBadRequestSignatureNotAllowed             = &errHTTP{40077, http.StatusBadRequest, "invalid request: signed messages cannot be combined with templates or file uploads", "https://ntfy.sh/docs/publish/#message-signing", nil}
+	errHTTPBadRequestSigningKeyInvalid               = &errHTTP{40078, http.StatusBadRequest, "invalid request: invalid signing key, expected Ed25519 public key in PEM format or as base64", "https://ntfy.sh/docs/publish/#requiring-signed-messages", nil}
+	errHTTPBadRequestStatsUsageInvalid               = &errHTTP{40075, http.StatusBadRequest, "invalid request: by must be 'user' or 'topic', sort must be 'messages', 'subscriptions', 'attachments' or 'attachment_bytes', and limit must be between 1 and 1000", "https://ntfy.sh/docs/config/#usage-stats", nil}
+	errHTTPBadRequestHomeAssistantCallbackInvalid    = &errHTTP{40079, http.StatusBadRequest, "invalid request: callback must be an http:// or https:// URL, and is required for actions without a URI", "https://ntfy.sh/docs/publish/#home-assistant", nil}
+	errHTTPBadRequestTriggerLimitInvalid             = &errHTTP{40080, http.StatusBadRequest, "invalid request: limit must be a number between 0 and 100", "https://ntfy.sh/docs/subscribe/api/#triggers-for-zapier-ifttt", nil}
+	errHTTPBadRequestIngestInvalid                   = &errHTTP{40081, http.StatusBadRequest, "invalid request: ingest source must be github, stripe or grafana, and the secret must not be empty", "https://ntfy.sh/docs/publish/#verifying-webhook-signatures", nil}
+	errHTTPBadRequestTopicCloseDurationInvalid       = &errHTTP{40082, http.StatusBadRequest, "invalid request: close duration invalid, must be between 1 minute and 30 days", "https://ntfy.sh/docs/config/#purging-and-closing-topics", nil}
+	errHTTPBadRequestReportInvalid                   = &errHTTP{40083, http.StatusBadRequest, "invalid request: report must contain a valid topic, and a reason of at most 1000 characters", "https://ntfy.sh/docs/publish/#reporting-abuse", nil}
+	errHTTPBadRequestExpiresInvalid                  = &errHTTP{40084, http.StatusBadRequest, "invalid request: expires cannot be parsed, or is not between the delivery time and the end of the cache duration", "https://ntfy.sh/docs/publish/#message-expiration", nil}
+	errHTTPBadRequestExpiresNoCache                  = &errHTTP{40085, http.StatusBadRequest, "cannot disable cache for message with expiry", "https://ntfy.sh/docs/publish/#message-expiration", nil}
+	errHTTPBadRequestUploadInvalid                   = &errHTTP{40086, http.StatusBadRequest, "invalid request: Upload-Length or Upload-Offset header missing or invalid", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPBadRequestUploadIncomplete                = &errHTTP{40087, http.StatusBadRequest, "invalid request: upload is incomplete, or cannot be combined with an attachment URL", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPBadRequestTopicKeyInvalid                 = &errHTTP{40088, http.StatusBadRequest, "invalid request: topic key must be between 8 and 72 characters, and permission must be read-write, read-only or write-only", "https://ntfy.sh/docs/publish/#topic-keys", nil}
+	errHTTPBadRequestAttachmentsTooMany              = &errHTTP{40089, http.StatusBadRequest, "invalid request: too many attachments, a message can have at most 10 attachments", "https://ntfy.sh/docs/publish/#multiple-attachments", nil}
+	errHTTPBadRequestEphemeralTopicTTLInvalid        = &errHTTP{40090, http.StatusBadRequest, "invalid request: ephemeral topic TTL invalid, must be between 1 minute and 7 days", "https://ntfy.sh/docs/publish/#ephemeral-topics", nil}
+	errHTTPBadRequestQRCodeFormatInvalid             = &errHTTP{40091, http.StatusBadRequest, "invalid request: QR code format must be png or svg", "https://ntfy.sh/docs/subscribe/phone/#qr-codes", nil}
+	errHTTPBadRequestQRCodeTopicsInvalid             = &errHTTP{40092, http.StatusBadRequest, "invalid request: QR code requires between 1 and 10 distinct, valid topics", "https://ntfy.sh/docs/subscribe/phone/#qr-codes", nil}
+	errHTTPBadRequestAttachmentRejected              = &errHTTP{40093, http.StatusBadRequest, "invalid request: attachment was rejected by the attachment scanner", "https://ntfy.sh/docs/config/#attachment-scanning", nil}
+	errHTTPBadRequestTokenBulkInvalid                = &errHTTP{40094, http.StatusBadRequest, "invalid request: bulk tokens require a valid topic pattern, a count between 1 and 1000, and only publish or subscribe scopes", "https://ntfy.sh/docs/config/#bulk-device-provisioning", nil}
+	errHTTPBadRequestTokenBulkFormatInvalid          = &errHTTP{40095, http.StatusBadRequest, "invalid request: format must be json, csv or html", "https://ntfy.sh/docs/config/#bulk-device-provisioning", nil}
+	errHTTPBadRequestBanInvalid                      = &errHTTP{40096, http.StatusBadRequest, "invalid request: ban requires a kind (ip, user or token), a valid value, and a valid duration", "https://ntfy.sh/docs/config/#banning-ips-users-and-tokens", nil}
+	errHTTPBadRequestBanNotFound                     = &errHTTP{40097, http.StatusBadRequest, "invalid request: ban not found", "https://ntfy.sh/docs/config/#banning-ips-users-and-tokens", nil}
+	errHTTPBadRequestSessionInvalid                  = &errHTTP{40098, http.StatusBadRequest, "invalid request: label, expires and scopes cannot be set for tokens with a refresh token", "https://ntfy.sh/docs/config/#web-app-sessions", nil}
 	errHTTPNotFound                                  = &errHTTP{40401, http.StatusNotFound, "page not found", "", nil}
 	errHTTPUnauthorized                              = &errHTTP{40101, http.StatusUnauthorized, "unauthorized", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPUnauthorizedWebAuthnRequired              = &errHTTP{40102, http.StatusUnauthorized, "unauthorized: WebAuthn confirmation required", "https://ntfy.sh/docs/config/#webauthn-confirmation", nil}
+	errHTTPUnauthorizedWebAuthnInvalid               = &errHTTP{40103, http.StatusUnauthorized, "unauthorized: WebAuthn assertion invalid", "https://ntfy.sh/docs/config/#webauthn-confirmation", nil}
+	errHTTPUnauthorizedRefreshTokenInvalid           = &errHTTP{40104, http.StatusUnauthorized, "unauthorized: refresh token invalid or expired", "https://ntfy.sh/docs/config/#web-app-sessions", nil}
 	errHTTPForbidden                                 = &errHTTP{40301, http.StatusForbidden, "forbidden", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPForbiddenImpersonation                    = &errHTTP{40302, http.StatusForbidden, "forbidden: impersonation not allowed", "https://ntfy.sh/docs/config/#impersonation", nil}
+	errHTTPForbiddenTierFeature                      = &errHTTP{40303, http.StatusForbidden, "forbidden: feature not included in your tier", "https://ntfy.sh/docs/config/#tier-features", nil}
+	errHTTPForbiddenTopicPolicyManaged               = &errHTTP{40304, http.StatusForbidden, "forbidden: topic policy is managed by an admin", "https://ntfy.sh/docs/config/#per-topic-retention", nil}
+	errHTTPForbiddenTokenScope                       = &errHTTP{40305, http.StatusForbidden, "forbidden: not allowed by the scopes of the access token", "https://ntfy.sh/docs/config/#access-tokens", nil}
+	errHTTPForbiddenSignatureRequired                = &errHTTP{40306, http.StatusForbidden, "forbidden: topic requires messages to be signed with a registered key", "https://ntfy.sh/docs/publish/#requiring-signed-messages", nil}
+	errHTTPForbiddenIngestSignatureInvalid           = &errHTTP{40307, http.StatusForbidden, "forbidden: webhook signature missing or invalid", "https://ntfy.sh/docs/publish/#verifying-webhook-signatures", nil}
+	errHTTPForbiddenTopicClosed                      = &errHTTP{40308, http.StatusForbidden, "forbidden: topic is temporarily closed by an admin", "https://ntfy.sh/docs/config/#purging-and-closing-topics", nil}
+	errHTTPForbiddenBanned                           = &errHTTP{40309, http.StatusForbidden, "forbidden: banned by the server admin", "", nil}
+	errHTTPForbiddenCountryBlocked                   = &errHTTP{40310, http.StatusForbidden, "forbidden: requests from your country are blocked by the server admin", "https://ntfy.sh/docs/config/#geoip-based-rate-limiting-and-blocking", nil}
+	errHTTPForbiddenSignatureExpired                 = &errHTTP{40311, http.StatusForbidden, "forbidden: signature expired or already used", "https://ntfy.sh/docs/publish/#requiring-signed-messages", nil}
 	errHTTPConflictUserExists                        = &errHTTP{40901, http.StatusConflict, "conflict: user already exists", "", nil}
 	errHTTPConflictTopicReserved                     = &errHTTP{40902, http.StatusConflict, "conflict: access control entry for topic or topic pattern already exists", "", nil}
 	errHTTPConflictSubscriptionExists                = &errHTTP{40903, http.StatusConflict, "conflict: topic subscription already exists", "", nil}
 	errHTTPConflictPhoneNumberExists                 = &errHTTP{40904, http.StatusConflict, "conflict: phone number already exists", "", nil}
 	errHTTPConflictProvisionedUserChange             = &errHTTP{40905, http.StatusConflict, "conflict: cannot change or delete provisioned user", "", nil}
 	errHTTPConflictProvisionedTokenChange            = &errHTTP{40906, http.StatusConflict, "conflict: cannot change or delete provisioned token", "", nil}
+	errHTTPConflictWebAuthnCredentialExists          = &errHTTP{40907, http.StatusConflict, "conflict: WebAuthn credential already exists", "", nil}
+	errHTTPConflictEmailExists                       = &errHTTP{40908, http.StatusConflict, "conflict: email address already exists", "", nil}
+	errHTTPConflictGroupExists                       = &errHTTP{40909, http.StatusConflict, "conflict: group already exists", "", nil}
+	errHTTPConflictSigningKeyExists                  = &errHTTP{40910, http.StatusConflict, "conflict: signing key already registered for this topic", "https://ntfy.sh/docs/publish/#requiring-signed-messages", nil}
+	errHTTPConflictUploadOffset                      = &errHTTP{40911, http.StatusConflict, "conflict: Upload-Offset does not match the upload, or another chunk is being uploaded", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPConflictSessionTokenChange                = &errHTTP{40912, http.StatusConflict, "conflict: cannot extend the access token of a session, use the refresh token instead", "https://ntfy.sh/docs/config/#web-app-sessions", nil}
 	errHTTPGonePhoneVerificationExpired              = &errHTTP{41001, http.StatusGone, "phone number verification expired or does not exist", "", nil}
+	errHTTPGoneEmailVerificationExpired              = &errHTTP{41002, http.StatusGone, "email verification expired or does not exist", "", nil}
 	errHTTPEntityTooLargeAttachment                  = &errHTTP{41301, http.StatusRequestEntityTooLarge, "attachment too large, or bandwidth limit reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPEntityTooLargeMatrixRequest               = &errHTTP{41302, http.StatusRequestEntityTooLarge, "Matrix request is larger than the max allowed length", "", nil}
 	errHTTPEntityTooLargeJSONBody                    = &errHTTP{41303, http.StatusRequestEntityTooLarge, "JSON body too large", "", nil}
+	errHTTPEntityTooLargeEncryptedMessage            = &errHTTP{41304, http.StatusRequestEntityTooLarge, "encrypted message too large", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPEntityTooLargeMessage                     = &errHTTP{41305, http.StatusRequestEntityTooLarge, "message too large", "https://ntfy.sh/docs/publish/#editing-and-deleting-messages", nil}
 	errHTTPTooManyRequestsLimitRequests              = &errHTTP{42901, http.StatusTooManyRequests, "limit reached: too many requests", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitEmails                = &errHTTP{42902, http.StatusTooManyRequests, "limit reached: too many emails", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitSubscriptions         = &errHTTP{42903, http.StatusTooManyRequests, "limit reached: too many active subscriptions", "https://ntfy.sh/docs/publish/#limitations", nil}
@@ -148,9 +220,19 @@ var (
 	errHTTPTooManyRequestsLimitMessages              = &errHTTP{42908, http.StatusTooManyRequests, "limit reached: daily message quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitAuthFailure           = &errHTTP{42909, http.StatusTooManyRequests, "limit reached: too many auth failures", "https://ntfy.sh/docs/publish/#limitations", nil} // FIXME document limit
 	errHTTPTooManyRequestsLimitCalls                 = &errHTTP{42910, http.StatusTooManyRequests, "limit reached: daily phone call quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
+	errHTTPTooManyRequestsLimitRecurringMessages     = &errHTTP{42911, http.StatusTooManyRequests, "limit reached: too many recurring messages", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
+	errHTTPTooManyRequestsLimitAPNSDevices           = &errHTTP{42912, http.StatusTooManyRequests, "limit reached: too many APNs devices", "", nil}
+	errHTTPTooManyRequestsLimitTopicBandwidth        = &errHTTP{42913, http.StatusTooManyRequests, "limit reached: daily bandwidth of topic reached", "https://ntfy.sh/docs/config/#attachment-bandwidth-per-topic", nil}
+	errHTTPTooManyRequestsLimitWebhooks              = &errHTTP{42914, http.StatusTooManyRequests, "limit reached: too many webhooks for this topic", "https://ntfy.sh/docs/publish/#outgoing-webhooks", nil}
+	errHTTPTooManyRequestsLimitSigningKeys           = &errHTTP{42915, http.StatusTooManyRequests, "limit reached: too many signing keys for this topic", "https://ntfy.sh/docs/publish/#requiring-signed-messages", nil}
+	errHTTPTooManyRequestsLimitReports               = &errHTTP{42916, http.StatusTooManyRequests, "limit reached: too many abuse reports, please try again later", "https://ntfy.sh/docs/publish/#reporting-abuse", nil}
+	errHTTPTooManyRequestsLimitTopicThrottled        = &errHTTP{42917, http.StatusTooManyRequests, "limit reached: topic is throttled after abuse reports", "https://ntfy.sh/docs/config/#abuse-reports", nil}
+	errHTTPTooManyRequestsLimitUploads               = &errHTTP{42918, http.StatusTooManyRequests, "limit reached: too many unfinished uploads", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPTooManyRequestsLimitEphemeralTopics       = &errHTTP{42919, http.StatusTooManyRequests, "limit reached: too many ephemeral topics", "https://ntfy.sh/docs/publish/#ephemeral-topics", nil}
 	errHTTPInternalError                             = &errHTTP{50001, http.StatusInternalServerError, "internal server error", "", nil}
 	errHTTPInternalErrorInvalidPath                  = &errHTTP{50002, http.StatusInternalServerError, "internal server error: invalid path", "", nil}
 	errHTTPInternalErrorMissingBaseURL               = &errHTTP{50003, http.StatusInternalServerError, "internal server error: base-url must be be configured for this feature", "https://ntfy.sh/docs/config/", nil}
 	errHTTPInternalErrorWebPushUnableToPublish       = &errHTTP{50004, http.StatusInternalServerError, "internal server error: unable to publish web push message", "", nil}
+	errHTTPInternalErrorAttachmentScanFailed         = &errHTTP{50005, http.StatusInternalServerError, "internal server error: attachment could not be scanned", "https://ntfy.sh/docs/config/#attachment-scanning", nil}
 	errHTTPInsufficientStorageUnifiedPush            = &errHTTP{50701, http.StatusInsufficientStorage, "cannot publish to UnifiedPush topic without previously active subscriber", "", nil}
 )
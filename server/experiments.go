@@ -0,0 +1,65 @@
+package server
+
+import (
+	"hash/fnv"
+)
+
+const (
+	experimentVariantEnabled = "enabled"
+	experimentVariantControl = "control"
+)
+
+// Experiment is a feature flag that is only enabled for a percentage of users and visitors, see experiments
+type Experiment struct {
+	Name       string
+	Percentage int // 0-100
+}
+
+// experimentEnabled returns true if the experiment with the given name is enabled for the visitor. Users are
+// assigned to a bucket based on their user ID, anonymous visitors based on their IP address, so the result is
+// stable across requests (and across servers). Unknown experiments are never enabled.
+func (s *Server) experimentEnabled(v *visitor, name string) bool {
+	for _, e := range s.config.Load().Experiments {
+		if e.Name == name {
+			return s.evaluateExperiment(v, e)
+		}
+	}
+	return false
+}
+
+// experimentsEnabled returns the names of all experiments that are enabled for the visitor
+func (s *Server) experimentsEnabled(v *visitor) []string {
+	enabled := make([]string, 0)
+	for _, e := range s.config.Load().Experiments {
+		if s.evaluateExperiment(v, e) {
+			enabled = append(enabled, e.Name)
+		}
+	}
+	return enabled
+}
+
+func (s *Server) evaluateExperiment(v *visitor, e *Experiment) bool {
+	enabled := experimentBucket(e.Name, experimentKey(v)) < e.Percentage
+	if enabled {
+		mincExperiment(metricExperimentExposures, e.Name, experimentVariantEnabled)
+	} else {
+		mincExperiment(metricExperimentExposures, e.Name, experimentVariantControl)
+	}
+	return enabled
+}
+
+// experimentKey returns the key used to assign a visitor to an experiment bucket
+func experimentKey(v *visitor) string {
+	if u := v.User(); u != nil {
+		return u.ID
+	}
+	return v.IP().String()
+}
+
+// experimentBucket deterministically maps the experiment name and key to a bucket between 0 and 99. The experiment
+// name is part of the hash, so that different experiments are enabled for different subsets of users.
+func experimentBucket(name, key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name + ":" + key))
+	return int(h.Sum32() % 100)
+}
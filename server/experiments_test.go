@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestExperimentBucket(t *testing.T) {
+	require.Equal(t, experimentBucket("new-web-ui", "u_abc"), experimentBucket("new-web-ui", "u_abc"))
+
+	// Roughly 10% of keys should be in the first 10 buckets
+	enabled := 0
+	for i := 0; i < 10000; i++ {
+		if experimentBucket("new-web-ui", fmt.Sprintf("u_%d", i)) < 10 {
+			enabled++
+		}
+	}
+	require.InDelta(t, 1000, enabled, 150)
+}
+
+func TestServer_ExperimentEnabled(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.Experiments = []*Experiment{
+		{Name: "never", Percentage: 0},
+		{Name: "always", Percentage: 100},
+	}
+	s := newTestServer(t, c)
+	for i := 0; i < 50; i++ {
+		v := newVisitor(c, nil, nil, nil, netip.MustParseAddr(fmt.Sprintf("1.2.3.%d", i)), nil)
+		require.False(t, s.experimentEnabled(v, "never"))
+		require.True(t, s.experimentEnabled(v, "always"))
+		require.False(t, s.experimentEnabled(v, "unknown"))
+		require.Equal(t, []string{"always"}, s.experimentsEnabled(v))
+	}
+
+	// Users are bucketed by their user ID, not their IP address
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	v1 := newVisitor(c, s.messageCache, s.userManager, nil, netip.MustParseAddr("1.2.3.4"), u)
+	v2 := newVisitor(c, s.messageCache, s.userManager, nil, netip.MustParseAddr("5.6.7.8"), u)
+	require.Equal(t, u.ID, experimentKey(v1))
+	require.Equal(t, experimentKey(v1), experimentKey(v2))
+}
+
+func TestAccount_Experiments(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.Experiments = []*Experiment{
+		{Name: "new-web-ui", Percentage: 100},
+		{Name: "old-web-ui", Percentage: 0},
+	}
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	rr := request(t, s, "GET", "/v1/account", "", nil)
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, []string{"new-web-ui"}, account.Experiments)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, []string{"new-web-ui"}, account.Experiments)
+}
@@ -10,12 +10,16 @@ import (
 	"path/filepath"
 	"regexp"
 	"sync"
+	"time"
 )
 
 var (
 	fileIDRegex      = regexp.MustCompile(fmt.Sprintf(`^[-_A-Za-z0-9]{%d}$`, messageIDLength))
+	fileVariantRegex = regexp.MustCompile(`^[a-z0-9]{1,16}$`)
+	fileUploadRegex  = regexp.MustCompile(fmt.Sprintf(`^%s[A-Za-z0-9]{%d}$`, uploadIDPrefix, uploadIDLength-len(uploadIDPrefix)))
 	errInvalidFileID = errors.New("invalid file ID")
 	errFileExists    = errors.New("file exists")
+	errFileCacheFull = errors.New("file cache full")
 )
 
 type fileCache struct {
@@ -45,7 +49,20 @@ func (c *fileCache) Write(id string, in io.Reader, limiters ...util.Limiter) (in
 		return 0, errInvalidFileID
 	}
 	log.Tag(tagFileCache).Field("message_id", id).Debug("Writing attachment")
-	file := filepath.Join(c.dir, id)
+	return c.write(filepath.Join(c.dir, id), in, limiters...)
+}
+
+// WriteExtra stores an additional attachment of a message (see multiple attachments) as <id>.<index>, with index >= 1.
+// Like variants, additional attachments are deleted along with the original in Remove.
+func (c *fileCache) WriteExtra(id string, index int, in io.Reader, limiters ...util.Limiter) (int64, error) {
+	if !fileIDRegex.MatchString(id) || index < 1 {
+		return 0, errInvalidFileID
+	}
+	log.Tag(tagFileCache).Fields(log.Context{"message_id": id, "attachment_index": index}).Debug("Writing additional attachment")
+	return c.write(filepath.Join(c.dir, fmt.Sprintf("%s.%d", id, index)), in, limiters...)
+}
+
+func (c *fileCache) write(file string, in io.Reader, limiters ...util.Limiter) (int64, error) {
 	if _, err := os.Stat(file); err == nil {
 		return 0, errFileExists
 	}
@@ -82,6 +99,104 @@ func (c *fileCache) Remove(ids ...string) error {
 		if err := os.Remove(file); err != nil {
 			log.Tag(tagFileCache).Field("message_id", id).Err(err).Debug("Error deleting attachment")
 		}
+		variants, _ := filepath.Glob(file + ".*")
+		for _, variant := range variants {
+			if err := os.Remove(variant); err != nil {
+				log.Tag(tagFileCache).Field("message_id", id).Err(err).Debug("Error deleting attachment variant")
+			}
+		}
+	}
+	size, err := dirSize(c.dir)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.totalSizeCurrent = size
+	c.mu.Unlock()
+	mset(metricAttachmentsTotalSize, size)
+	return nil
+}
+
+// WriteVariant stores a derived version of an attachment (e.g. a resized image) next to the original file, as
+// <id>.<variant>. Variants count against the total size limit, and are deleted along with the original in Remove.
+func (c *fileCache) WriteVariant(id, variant string, data []byte) error {
+	if !fileIDRegex.MatchString(id) || !fileVariantRegex.MatchString(variant) {
+		return errInvalidFileID
+	} else if int64(len(data)) > c.Remaining() {
+		return errFileCacheFull
+	}
+	log.Tag(tagFileCache).Fields(log.Context{"message_id": id, "variant": variant}).Debug("Writing attachment variant")
+	f, err := os.CreateTemp(c.dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // No-op after the rename below
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	} else if err := os.Rename(f.Name(), filepath.Join(c.dir, id+"."+variant)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.totalSizeCurrent += int64(len(data))
+	mset(metricAttachmentsTotalSize, c.totalSizeCurrent)
+	c.mu.Unlock()
+	return nil
+}
+
+// ReadVariant returns a variant of an attachment previously stored with WriteVariant
+func (c *fileCache) ReadVariant(id, variant string) ([]byte, error) {
+	if !fileIDRegex.MatchString(id) || !fileVariantRegex.MatchString(variant) {
+		return nil, errInvalidFileID
+	}
+	return os.ReadFile(filepath.Join(c.dir, id+"."+variant))
+}
+
+// WritePartial appends to the partial upload with the given ID (see resumable uploads), creating the file if it
+// does not exist, and returns the number of bytes written. Unlike Write, bytes written before an error (e.g. a
+// dropped connection) are kept, so that the client can resume the upload from there.
+func (c *fileCache) WritePartial(id string, in io.Reader, limiters ...util.Limiter) (int64, error) {
+	if !fileUploadRegex.MatchString(id) {
+		return 0, errInvalidFileID
+	}
+	log.Tag(tagFileCache).Field("upload_id", id).Trace("Writing partial upload")
+	f, err := os.OpenFile(filepath.Join(c.dir, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	limiters = append(limiters, util.NewFixedLimiter(c.Remaining()))
+	size, err := io.Copy(util.NewLimitWriter(f, limiters...), in)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	c.mu.Lock()
+	c.totalSizeCurrent += size
+	mset(metricAttachmentsTotalSize, c.totalSizeCurrent)
+	c.mu.Unlock()
+	return size, err
+}
+
+// OpenPartial opens the partial upload with the given ID for reading
+func (c *fileCache) OpenPartial(id string) (*os.File, error) {
+	if !fileUploadRegex.MatchString(id) {
+		return nil, errInvalidFileID
+	}
+	return os.Open(filepath.Join(c.dir, id))
+}
+
+// RemovePartial deletes the partial uploads with the given IDs
+func (c *fileCache) RemovePartial(ids ...string) error {
+	for _, id := range ids {
+		if !fileUploadRegex.MatchString(id) {
+			return errInvalidFileID
+		}
+		log.Tag(tagFileCache).Field("upload_id", id).Debug("Deleting partial upload")
+		if err := os.Remove(filepath.Join(c.dir, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Tag(tagFileCache).Field("upload_id", id).Err(err).Debug("Error deleting partial upload")
+		}
 	}
 	size, err := dirSize(c.dir)
 	if err != nil {
@@ -94,6 +209,26 @@ func (c *fileCache) Remove(ids ...string) error {
 	return nil
 }
 
+// Partials returns the IDs of all partial uploads, and the time they were last written to
+func (c *fileCache) Partials() (map[string]time.Time, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	partials := make(map[string]time.Time)
+	for _, e := range entries {
+		if !fileUploadRegex.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		partials[e.Name()] = info.ModTime()
+	}
+	return partials, nil
+}
+
 func (c *fileCache) Size() int64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -74,3 +74,50 @@ func readFile(t *testing.T, f string) string {
 	require.Nil(t, err)
 	return string(b)
 }
+
+func TestFileCache_WriteVariant_Remove(t *testing.T) {
+	dir, c := newTestFileCache(t)
+	_, err := c.Write("abcdefghijkl", bytes.NewReader(make([]byte, 999)))
+	require.Nil(t, err)
+	require.Nil(t, c.WriteVariant("abcdefghijkl", "w800", make([]byte, 100)))
+	require.Equal(t, int64(1099), c.Size())
+
+	b, err := c.ReadVariant("abcdefghijkl", "w800")
+	require.Nil(t, err)
+	require.Len(t, b, 100)
+	_, err = c.ReadVariant("abcdefghijkl", "w400")
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, errInvalidFileID, c.WriteVariant("abcdefghijkl", "../x", []byte("x")))
+	require.Equal(t, errFileCacheFull, c.WriteVariant("abcdefghijkl", "w1600", make([]byte, 10000)))
+
+	require.Nil(t, c.Remove("abcdefghijkl"))
+	require.NoFileExists(t, dir+"/abcdefghijkl")
+	require.NoFileExists(t, dir+"/abcdefghijkl.w800")
+	require.Equal(t, int64(0), c.Size())
+}
+
+func TestFileCache_WritePartial_Remove(t *testing.T) {
+	dir, c := newTestFileCache(t)
+	size, err := c.WritePartial("up_abcdefghijklm", strings.NewReader("hello "))
+	require.Nil(t, err)
+	require.Equal(t, int64(6), size)
+	size, err = c.WritePartial("up_abcdefghijklm", strings.NewReader("world, too long"), util.NewFixedLimiter(5))
+	require.Equal(t, util.ErrLimitReached, err)
+	require.Equal(t, int64(0), size)
+	size, err = c.WritePartial("up_abcdefghijklm", strings.NewReader("world"))
+	require.Nil(t, err)
+	require.Equal(t, int64(5), size)
+	require.Equal(t, "hello world", readFile(t, dir+"/up_abcdefghijklm"))
+	require.Equal(t, int64(11), c.Size())
+
+	partials, err := c.Partials()
+	require.Nil(t, err)
+	require.Contains(t, partials, "up_abcdefghijklm")
+
+	_, err = c.WritePartial("abcdefghijkl", strings.NewReader("not an upload ID"))
+	require.Equal(t, errInvalidFileID, err)
+
+	require.Nil(t, c.RemovePartial("up_abcdefghijklm"))
+	require.NoFileExists(t, dir+"/up_abcdefghijklm")
+	require.Equal(t, int64(0), c.Size())
+}
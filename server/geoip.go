@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"heckel.io/ntfy/v2/log"
+)
+
+// geoIPLookup is a small interface to resolve the country and network of an IP address, to facilitate mocking
+// of the MaxMind databases in tests
+type geoIPLookup interface {
+	Country(ip netip.Addr) string
+	ASN(ip netip.Addr) uint32
+	Close() error
+}
+
+// geoIPDatabase resolves countries using a MaxMind database file (e.g. GeoLite2-Country.mmdb, or GeoLite2-City.mmdb),
+// and networks using a MaxMind ASN database file (GeoLite2-ASN.mmdb). Either of the two may be nil.
+type geoIPDatabase struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+// geoIPRecord is the part of a MaxMind country or city record that we care about
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// geoIPASNRecord is the part of a MaxMind ASN record that we care about
+type geoIPASNRecord struct {
+	ASN uint32 `maxminddb:"autonomous_system_number"`
+}
+
+func newGeoIPDatabase(countryFilename, asnFilename string) (*geoIPDatabase, error) {
+	d := &geoIPDatabase{}
+	var err error
+	if countryFilename != "" {
+		if d.country, err = maxminddb.Open(countryFilename); err != nil {
+			return nil, err
+		}
+	}
+	if asnFilename != "" {
+		if d.asn, err = maxminddb.Open(asnFilename); err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Country returns the two-letter ISO country code of the given IP address (e.g. "DE"), or an empty
+// string if the address is not in the database (e.g. private IP addresses)
+func (d *geoIPDatabase) Country(ip netip.Addr) string {
+	if d.country == nil {
+		return ""
+	}
+	var record geoIPRecord
+	if err := d.country.Lookup(net.IP(ip.Unmap().AsSlice()), &record); err != nil {
+		log.Tag(tagGeoIP).Err(err).Debug("Cannot look up country of IP address %s", ip.String())
+		return ""
+	}
+	return strings.ToUpper(record.Country.ISOCode)
+}
+
+// ASN returns the autonomous system number of the given IP address (e.g. 3320), or zero if the
+// address is not in the database
+func (d *geoIPDatabase) ASN(ip netip.Addr) uint32 {
+	if d.asn == nil {
+		return 0
+	}
+	var record geoIPASNRecord
+	if err := d.asn.Lookup(net.IP(ip.Unmap().AsSlice()), &record); err != nil {
+		log.Tag(tagGeoIP).Err(err).Debug("Cannot look up network of IP address %s", ip.String())
+		return 0
+	}
+	return record.ASN
+}
+
+// Close closes the database files
+func (d *geoIPDatabase) Close() error {
+	var errs []error
+	for _, reader := range []*maxminddb.Reader{d.country, d.asn} {
+		if reader != nil {
+			errs = append(errs, reader.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// country returns the country of the given IP address, or an empty string if GeoIP lookups are not enabled
+func (s *Server) country(ip netip.Addr) string {
+	if s.geoip == nil {
+		return ""
+	}
+	return s.geoip.Country(ip)
+}
+
+// asn returns the autonomous system number of the given IP address, or zero if ASN lookups are not enabled
+func (s *Server) asn(ip netip.Addr) uint32 {
+	if s.geoip == nil {
+		return 0
+	}
+	return s.geoip.ASN(ip)
+}
+
+// countryBlocked returns true if requests from the given country are blocked (see geoip-blocked-countries)
+func countryBlocked(conf *Config, country string) bool {
+	return country != "" && slices.Contains(conf.GeoIPBlockedCountries, country)
+}
+
+// countryRequestLimitFactor returns the factor that the request limits of visitors from the given country are
+// multiplied with (see geoip-request-limit-factors), or 1 if there is none
+func countryRequestLimitFactor(conf *Config, country string) float64 {
+	if factor, ok := conf.GeoIPRequestLimitFactors[country]; ok && country != "" {
+		return factor
+	}
+	return 1
+}
+
+// rejectCountry logs a request from a blocked country, and returns the error to reject it with
+func (s *Server) rejectCountry(r *http.Request, v *visitor, country string) error {
+	logvr(v, r).
+		Tag(tagGeoIP).
+		Debug("Rejecting request, country %s is blocked", country)
+	mincGeoIPRequestsBlocked(country)
+	return errHTTPForbiddenCountryBlocked
+}
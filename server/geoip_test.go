@@ -0,0 +1,109 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+type testGeoIP map[string]string // IP address -> country
+
+func (g testGeoIP) Country(ip netip.Addr) string {
+	return g[ip.String()]
+}
+
+func (g testGeoIP) ASN(ip netip.Addr) uint32 {
+	return 0
+}
+
+func (g testGeoIP) Close() error {
+	return nil
+}
+
+// testGeoIPWithASN is like testGeoIP, but also resolves the network of IP addresses
+type testGeoIPWithASN struct {
+	testGeoIP
+	asns map[string]uint32 // IP address -> ASN
+}
+
+func (g testGeoIPWithASN) ASN(ip netip.Addr) uint32 {
+	return g.asns[ip.String()]
+}
+
+func fromIP(ip string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.RemoteAddr = ip + ":1234"
+	}
+}
+
+func TestServer_GeoIP_BlockedCountry(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.GeoIPBlockedCountries = []string{"XY"}
+	s := newTestServer(t, c)
+	s.geoip = testGeoIP{"1.1.1.1": "XY", "2.2.2.2": "DE"}
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	// Requests from blocked countries are rejected, even with valid credentials
+	response := request(t, s, "PUT", "/mytopic", "hi", nil, fromIP("1.1.1.1"))
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40310, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}, fromIP("1.1.1.1"))
+	require.Equal(t, 403, response.Code)
+
+	// Other countries, and addresses without a country, are not affected
+	response = request(t, s, "PUT", "/mytopic", "hi", nil, fromIP("2.2.2.2"))
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "hi", nil, fromIP("3.3.3.3"))
+	require.Equal(t, 200, response.Code)
+
+	// Blocks can be lifted without a restart
+	newConf := *c
+	newConf.GeoIPBlockedCountries = []string{}
+	require.Nil(t, s.Reload(&newConf))
+	response = request(t, s, "PUT", "/mytopic", "hi", nil, fromIP("1.1.1.1"))
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_GeoIP_RequestLimitFactors(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorRequestLimitBurst = 10
+	c.GeoIPRequestLimitFactors = map[string]float64{"XY": 0.5, "DE": 2}
+	s := newTestServer(t, c)
+	s.geoip = testGeoIP{"1.1.1.1": "XY", "2.2.2.2": "DE"}
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro", MessageLimit: 100}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	for ip, limit := range map[string]int64{"1.1.1.1": 5, "2.2.2.2": 20, "3.3.3.3": 10} {
+		response := request(t, s, "GET", "/v1/account/limits", "", nil, fromIP(ip))
+		require.Equal(t, 200, response.Code)
+		limits, err := util.UnmarshalJSON[apiAccountLimitsResponse](io.NopCloser(response.Body))
+		require.Nil(t, err)
+		require.Equal(t, s.geoip.Country(netip.MustParseAddr(ip)), limits.Country)
+		require.Equal(t, limit, limits.Requests.Limit, ip)
+	}
+	for i := 0; i < 5; i++ {
+		response := request(t, s, "PUT", "/mytopic", "hi", nil, fromIP("1.1.1.1"))
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "PUT", "/mytopic", "hi", nil, fromIP("1.1.1.1"))
+	require.Equal(t, 429, response.Code)
+
+	// Visitors with a tier are not affected by the country factors
+	response = request(t, s, "GET", "/v1/account/limits", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}, fromIP("1.1.1.1"))
+	require.Equal(t, 200, response.Code)
+	limits, err := util.UnmarshalJSON[apiAccountLimitsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "tier", limits.Basis)
+	require.Equal(t, "XY", limits.Country)
+	require.Equal(t, int64(10), limits.Requests.Limit) // Not multiplied by 0.5
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+// defaultLanguage is the language of the fallback catalog, used if a user has not picked a language, or if
+// a key is missing from the user's catalog
+const defaultLanguage = "en"
+
+var (
+	//go:embed i18n
+	i18nFs embed.FS
+)
+
+// localizer holds the message catalogs for server-generated text, i.e. email subjects and bodies, phone call
+// scripts, account notifications, and error messages. The catalogs are bundled with the binary (see server/i18n),
+// and loaded once at startup.
+type localizer struct {
+	catalogs map[string]map[string]string // Language -> key -> text
+}
+
+// locale translates keys into a single language, falling back to the default language for missing keys
+type locale struct {
+	language string
+	catalog  map[string]string
+	fallback map[string]string
+}
+
+func newLocalizer() (*localizer, error) {
+	filenames, err := fs.Glob(i18nFs, "i18n/*.json")
+	if err != nil {
+		return nil, err
+	}
+	catalogs := make(map[string]map[string]string)
+	for _, filename := range filenames {
+		b, err := i18nFs.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(b, &catalog); err != nil {
+			return nil, fmt.Errorf("invalid message catalog %s: %w", filename, err)
+		}
+		catalogs[normalizeLanguage(strings.TrimSuffix(path.Base(filename), ".json"))] = catalog
+	}
+	if _, ok := catalogs[defaultLanguage]; !ok {
+		return nil, fmt.Errorf("message catalog for default language %s not found", defaultLanguage)
+	}
+	return &localizer{catalogs: catalogs}, nil
+}
+
+// Language returns the best matching catalog language for the given language tag, e.g. "de" for "de-AT",
+// or the default language if there is no matching catalog
+func (l *localizer) Language(tag string) string {
+	language := normalizeLanguage(tag)
+	if _, ok := l.catalogs[language]; ok {
+		return language
+	}
+	base, _, _ := strings.Cut(language, "-")
+	if _, ok := l.catalogs[base]; ok {
+		return base
+	}
+	return defaultLanguage
+}
+
+// Locale returns the locale for the given user, based on the language in the user's account preferences.
+// The user may be nil, in which case the default language is used.
+func (l *localizer) Locale(u *user.User) *locale {
+	tag := ""
+	if u != nil && u.Prefs != nil && u.Prefs.Language != nil {
+		tag = *u.Prefs.Language
+	}
+	language := l.Language(tag)
+	return &locale{
+		language: language,
+		catalog:  l.catalogs[language],
+		fallback: l.catalogs[defaultLanguage],
+	}
+}
+
+// T returns the text for the given key, and replaces placeholders such as {code} with the given name/value
+// pairs, e.g. T("email_verify_message", "code", "123456", "expiry", "10m")
+func (l *locale) T(key string, args ...string) string {
+	text, ok := l.catalog[key]
+	if !ok {
+		if text, ok = l.fallback[key]; !ok {
+			return key
+		}
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		text = strings.ReplaceAll(text, "{"+args[i]+"}", args[i+1])
+	}
+	return text
+}
+
+// Error returns a copy of the given error with a translated message, if the catalog has a translation for
+// the error code ("error_<code>"). Error messages in the default language are defined in errors.go.
+func (l *locale) Error(e *errHTTP) *errHTTP {
+	message, ok := l.catalog[fmt.Sprintf("error_%d", e.Code)]
+	if !ok {
+		return e
+	}
+	c := e.clone()
+	c.Message = message
+	return &c
+}
+
+// normalizeLanguage converts a language tag to the format of the catalog file names, e.g. "pt_BR" to "pt-br"
+func normalizeLanguage(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
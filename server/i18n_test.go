@@ -0,0 +1,105 @@
+package server
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+var placeholderRegex = regexp.MustCompile(`\{[a-z_]+}`)
+
+func TestLocalizer_CatalogsConsistent(t *testing.T) {
+	l := newTestLocalizer(t)
+	fallback := l.catalogs[defaultLanguage]
+	for language, catalog := range l.catalogs {
+		for key, text := range catalog {
+			if strings.HasPrefix(key, "error_4") {
+				continue // Error messages in the default language are defined in errors.go
+			}
+			expected, ok := fallback[key]
+			require.True(t, ok, "key %s of language %s not in default catalog", key, language)
+			require.Equal(t, placeholders(expected), placeholders(text), "placeholders of key %s in language %s", key, language)
+		}
+	}
+}
+
+func TestLocalizer_Language(t *testing.T) {
+	l := newTestLocalizer(t)
+	require.Equal(t, "en", l.Language(""))
+	require.Equal(t, "de", l.Language("de"))
+	require.Equal(t, "de", l.Language("DE"))
+	require.Equal(t, "de", l.Language("de-AT"))
+	require.Equal(t, "fr", l.Language("fr_CA"))
+	require.Equal(t, "en", l.Language("xx"))
+}
+
+func TestLocale_T(t *testing.T) {
+	de := newTestLocale(t, "de")
+	require.Equal(t, "Dein ntfy-Bestätigungscode lautet 123456. Er läuft in 15m ab.\n\nFalls du keine Änderung der E-Mail-Adresse deines Kontos angefordert hast, kannst du diese Nachricht ignorieren.", de.T("email_verify_message", "code", "123456", "expiry", "15m"))
+	require.Equal(t, "does_not_exist", de.T("does_not_exist"))
+
+	de.catalog = map[string]string{} // Missing keys fall back to the default language
+	require.Equal(t, "Verify your email address", de.T("email_verify_title"))
+}
+
+func TestLocale_Error(t *testing.T) {
+	require.Equal(t, "verboten", newTestLocale(t, "de").Error(errHTTPForbidden).Message)
+	require.Equal(t, "forbidden", errHTTPForbidden.Message) // Original unchanged
+	require.Equal(t, errHTTPForbidden, newTestLocale(t, "en").Error(errHTTPForbidden))
+	require.Equal(t, errHTTPBadRequestTopicInvalid, newTestLocale(t, "de").Error(errHTTPBadRequestTopicInvalid)) // No translation
+}
+
+func TestServer_LocalizedError(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	rr := request(t, s, "PUT", "/mytopic", "test", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, "forbidden", toHTTPError(t, rr.Body.String()).Message)
+
+	rr = request(t, s, "PATCH", "/v1/account/settings", `{"language": "de_AT"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "PUT", "/mytopic", "test", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	err := toHTTPError(t, rr.Body.String())
+	require.Equal(t, 40301, err.Code)
+	require.Equal(t, "verboten", err.Message)
+}
+
+func TestFormatCall_Localized(t *testing.T) {
+	body := formatCall(newTestLocale(t, "de"), "mytopic", "Server <down>", "phil")
+	require.Contains(t, body, `<Say loop="3" language="de-DE">`)
+	require.Contains(t, body, "Du hast eine Nachricht von notify zum Thema mytopic. Nachricht:")
+	require.Contains(t, body, "Server &lt;down&gt;")
+	require.Contains(t, body, `<Say language="de-DE">Auf Wiederhören.</Say>`)
+}
+
+func placeholders(text string) []string {
+	matches := placeholderRegex.FindAllString(text, -1)
+	sort.Strings(matches)
+	return matches
+}
+
+func newTestLocalizer(t *testing.T) *localizer {
+	l, err := newLocalizer()
+	require.Nil(t, err)
+	return l
+}
+
+func newTestLocale(t *testing.T, language string) *locale {
+	return newTestLocalizer(t).Locale(&user.User{Prefs: &user.Prefs{Language: util.String(language)}})
+}
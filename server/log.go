@@ -30,6 +30,23 @@ const (
 	tagWebsocket    = "websocket"
 	tagMatrix       = "matrix"
 	tagWebPush      = "webpush"
+	tagAPNS         = "apns"
+	tagAudit        = "audit"
+	tagCluster      = "cluster"
+	tagMetrics      = "metrics"
+	tagSentry       = "sentry"
+	tagConfig       = "config"
+	tagRedis        = "redis"
+	tagWebhook      = "webhook"
+	tagTelegram     = "telegram"
+	tagTCP          = "tcp"
+	tagSTOMP        = "stomp"
+	tagIngest       = "ingest"
+	tagReport       = "report"
+	tagUpload       = "upload"
+	tagScan         = "scan"
+	tagBan          = "ban"
+	tagGeoIP        = "geoip"
 )
 
 var (
@@ -37,8 +54,12 @@ var (
 	rateLimitingErrorCodes = []int{http.StatusTooManyRequests, http.StatusRequestEntityTooLarge}
 )
 
-// logr creates a new log event with HTTP request fields
+// logr creates a new log event with HTTP request fields. The request may be nil for background
+// tasks that share code with request handlers.
 func logr(r *http.Request) *log.Event {
+	if r == nil {
+		return log.Tag(tagHTTP)
+	}
 	return log.Tag(tagHTTP).Fields(httpContext(r)) // Tag may be overwritten
 }
 
@@ -44,12 +44,24 @@ const (
 			attachment_size INT NOT NULL,
 			attachment_expires INT NOT NULL,
 			attachment_url TEXT NOT NULL,
+			attachment_width INT NOT NULL,
+			attachment_height INT NOT NULL,
+			attachment_thumbnail TEXT NOT NULL,
 			attachment_deleted INT NOT NULL,
+			attachments TEXT NOT NULL,
+			attachments_size INT NOT NULL,
 			sender TEXT NOT NULL,
 			user TEXT NOT NULL,
 			content_type TEXT NOT NULL,
 			encoding TEXT NOT NULL,
-			published INT NOT NULL
+			channels TEXT NOT NULL,
+			encryption TEXT NOT NULL,
+			reply_to TEXT NOT NULL,
+			cron TEXT NOT NULL,
+			preview TEXT NOT NULL,
+			published INT NOT NULL,
+			signature TEXT NOT NULL,
+			ttl INT NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_mid ON messages (mid);
 		CREATE INDEX IF NOT EXISTS idx_time ON messages (time);
@@ -58,80 +70,157 @@ const (
 		CREATE INDEX IF NOT EXISTS idx_sender ON messages (sender);
 		CREATE INDEX IF NOT EXISTS idx_user ON messages (user);
 		CREATE INDEX IF NOT EXISTS idx_attachment_expires ON messages (attachment_expires);
+		CREATE INDEX IF NOT EXISTS idx_reply_to ON messages (reply_to);
 		CREATE TABLE IF NOT EXISTS stats (
 			key TEXT PRIMARY KEY,
 			value INT
 		);
 		INSERT INTO stats (key, value) VALUES ('messages', 0);
+		CREATE TABLE IF NOT EXISTS acks (
+			mid TEXT NOT NULL,
+			acker TEXT NOT NULL,
+			user TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (mid, acker)
+		);
+		CREATE TABLE IF NOT EXISTS reports (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			reporter TEXT NOT NULL,
+			user TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			time INT NOT NULL,
+			UNIQUE (topic, mid, reporter)
+		);
+		CREATE INDEX IF NOT EXISTS idx_reports_topic ON reports (topic);
+		CREATE TABLE IF NOT EXISTS tombstones (
+			mid TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			time INT NOT NULL,
+			deleted INT NOT NULL,
+			expires INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_tombstones_topic_deleted ON tombstones (topic, deleted);
+		CREATE INDEX IF NOT EXISTS idx_tombstones_expires ON tombstones (expires);
 		COMMIT;
 	`
 	insertMessageQuery = `
-		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, sender, user, content_type, encoding, published)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachment_deleted, attachments, attachments_size, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, published, signature, ttl)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	deleteMessageQuery                = `DELETE FROM messages WHERE mid = ?`
+	deleteMessageAcksQuery            = `DELETE FROM acks WHERE mid = ?`
 	updateMessagesForTopicExpiryQuery = `UPDATE messages SET expires = ? WHERE topic = ?`
 	selectRowIDFromMessageID          = `SELECT id FROM messages WHERE mid = ?` // Do not include topic, see #336 and TestServer_PollSinceID_MultipleTopics
 	selectMessagesByIDQuery           = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
 		FROM messages
 		WHERE mid = ?
 	`
 	selectMessagesSinceTimeQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
 		FROM messages
 		WHERE topic = ? AND time >= ? AND published = 1
 		ORDER BY time, id
 	`
 	selectMessagesSinceTimeIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
 		FROM messages
 		WHERE topic = ? AND time >= ?
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
 		FROM messages
 		WHERE topic = ? AND id > ? AND published = 1 
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
 		FROM messages
 		WHERE topic = ? AND (id > ? OR published = 0)
 		ORDER BY time, id
 	`
 	selectMessagesLatestQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
 		FROM messages
 		WHERE topic = ? AND published = 1
 		ORDER BY time DESC, id DESC
 		LIMIT 1
 	`
 	selectMessagesDueQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
 		FROM messages
 		WHERE time <= ? AND published = 0
 		ORDER BY time, id
 	`
+	selectMessagesScheduledQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
+		FROM messages
+		WHERE topic = ? AND published = 0
+		ORDER BY time, id
+	`
+	selectScheduledMessageByIDQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
+		FROM messages
+		WHERE mid = ? AND published = 0
+	`
+	selectMessagesThreadQuery = `
+		WITH RECURSIVE thread(mid) AS (
+			SELECT ?
+			UNION
+			SELECT m.mid FROM messages m JOIN thread t ON m.reply_to = t.mid
+		)
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_width, attachment_height, attachment_thumbnail, attachments, sender, user, content_type, encoding, channels, encryption, reply_to, cron, preview, signature, ttl
+		FROM messages
+		WHERE topic = ? AND mid IN thread AND published = 1
+		ORDER BY time, id
+	`
 	selectMessagesExpiredQuery      = `SELECT mid FROM messages WHERE expires <= ? AND published = 1`
+	selectMessagesExpiredTTLQuery   = `SELECT mid, topic, sender, user FROM messages WHERE expires <= ? AND ttl > 0 AND published = 1`
+	selectMessagesOlderThanQuery    = `SELECT mid FROM messages WHERE topic = ? AND time <= ? AND published = 1`
+	selectMessagesOverLimitQuery    = `SELECT mid FROM messages WHERE topic = ? AND published = 1 ORDER BY time DESC, id DESC LIMIT -1 OFFSET ?`
+	selectMessageIDsByTopicQuery    = `SELECT mid FROM messages WHERE topic = ?`
 	updateMessagePublishedQuery     = `UPDATE messages SET published = 1 WHERE mid = ?`
+	updateMessageRescheduledQuery   = `UPDATE messages SET time = ?, expires = ? WHERE mid = ?`
+	updateMessageContentQuery       = `UPDATE messages SET message = ?, title = ?, priority = ?, tags = ?, click = ?, icon = ?, actions = ?, content_type = ?, encoding = ?, signature = ? WHERE mid = ?`
+	selectRecurringCountBySender    = `SELECT COUNT(*) FROM messages WHERE cron != '' AND published = 0 AND user = '' AND sender = ?`
+	selectRecurringCountByUserID    = `SELECT COUNT(*) FROM messages WHERE cron != '' AND published = 0 AND user = ?`
 	selectMessagesCountQuery        = `SELECT COUNT(*) FROM messages`
+	selectScheduledCountQuery       = `SELECT COUNT(*) FROM messages WHERE published = 0`
 	selectMessageCountPerTopicQuery = `SELECT topic, COUNT(*) FROM messages GROUP BY topic`
 	selectTopicsQuery               = `SELECT topic FROM messages GROUP BY topic`
 
 	updateAttachmentDeleted            = `UPDATE messages SET attachment_deleted = 1 WHERE mid = ?`
 	selectAttachmentsExpiredQuery      = `SELECT mid FROM messages WHERE attachment_expires > 0 AND attachment_expires <= ? AND attachment_deleted = 0`
-	selectAttachmentsSizeBySenderQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?`
-	selectAttachmentsSizeByUserIDQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = ? AND attachment_expires >= ?`
+	selectAttachmentsActiveQuery       = `SELECT mid, time, attachment_name, attachment_type FROM messages WHERE attachment_expires > 0 AND attachment_deleted = 0`
+	selectAttachmentsSizeBySenderQuery = `SELECT IFNULL(SUM(attachment_size + attachments_size), 0) FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?`
+	selectAttachmentsSizeByUserIDQuery = `SELECT IFNULL(SUM(attachment_size + attachments_size), 0) FROM messages WHERE user = ? AND attachment_expires >= ?`
 
 	selectStatsQuery = `SELECT value FROM stats WHERE key = 'messages'`
 	updateStatsQuery = `UPDATE stats SET value = ? WHERE key = 'messages'`
+
+	insertAckQuery  = `INSERT INTO acks (mid, acker, user, time) VALUES (?, ?, ?, ?) ON CONFLICT (mid, acker) DO NOTHING`
+	selectAcksQuery = `SELECT user, time FROM acks WHERE mid = ? ORDER BY time, rowid`
+
+	insertReportQuery                = `INSERT INTO reports (id, topic, mid, reporter, user, sender, reason, time) VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (topic, mid, reporter) DO NOTHING`
+	selectReportsQuery               = `SELECT id, topic, mid, user, sender, reason, time FROM reports ORDER BY time, rowid`
+	selectReportsByTopicQuery        = `SELECT id, topic, mid, user, sender, reason, time FROM reports WHERE topic = ? ORDER BY time, rowid`
+	selectReportersCountByTopicQuery = `SELECT COUNT(DISTINCT reporter) FROM reports WHERE topic = ?`
+	deleteReportQuery                = `DELETE FROM reports WHERE topic = ? AND id = ?`
+	deleteReportsByTopicQuery        = `DELETE FROM reports WHERE topic = ?`
+
+	insertTombstoneQuery           = `INSERT OR IGNORE INTO tombstones (mid, topic, time, deleted, expires) SELECT mid, topic, time, ?, ? FROM messages WHERE mid = ? AND published = 1`
+	selectTombstonesSinceTimeQuery = `SELECT mid, deleted FROM tombstones WHERE topic = ? AND deleted >= ? AND expires > ? ORDER BY deleted, rowid`
+	selectMessageOrTombstoneTime   = `SELECT time FROM messages WHERE mid = ? UNION ALL SELECT time FROM tombstones WHERE mid = ?`
+	deleteTombstonesExpiredQuery   = `DELETE FROM tombstones WHERE expires <= ?`
 )
 
 // Schema management queries
 const (
-	currentSchemaVersion          = 13
+	currentSchemaVersion          = 25
 	createSchemaVersionTableQuery = `
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
@@ -260,6 +349,95 @@ const (
 	migrate12To13AlterMessagesTableQuery = `
 		CREATE INDEX IF NOT EXISTS idx_topic ON messages (topic);
 	`
+
+	// 13 -> 14
+	migrate13To14AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN channels TEXT NOT NULL DEFAULT('');
+	`
+
+	// 14 -> 15
+	migrate14To15AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN encryption TEXT NOT NULL DEFAULT('');
+	`
+
+	// 15 -> 16
+	migrate15To16AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN reply_to TEXT NOT NULL DEFAULT('');
+		CREATE INDEX IF NOT EXISTS idx_reply_to ON messages (reply_to);
+	`
+
+	// 16 -> 17
+	migrate16To17AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN cron TEXT NOT NULL DEFAULT('');
+	`
+
+	// 17 -> 18
+	migrate17To18AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN preview TEXT NOT NULL DEFAULT('');
+	`
+
+	// 18 -> 19
+	migrate18To19CreateAcksTableQuery = `
+		CREATE TABLE IF NOT EXISTS acks (
+			mid TEXT NOT NULL,
+			acker TEXT NOT NULL,
+			user TEXT NOT NULL,
+			time INT NOT NULL,
+			PRIMARY KEY (mid, acker)
+		);
+	`
+
+	// 19 -> 20
+	migrate19To20AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN signature TEXT NOT NULL DEFAULT('');
+	`
+
+	// 20 -> 21
+	migrate20To21CreateReportsTableQuery = `
+		CREATE TABLE IF NOT EXISTS reports (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			reporter TEXT NOT NULL,
+			user TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			time INT NOT NULL,
+			UNIQUE (topic, mid, reporter)
+		);
+		CREATE INDEX IF NOT EXISTS idx_reports_topic ON reports (topic);
+	`
+
+	// 21 -> 22
+	migrate21To22AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN ttl INT NOT NULL DEFAULT('0');
+	`
+
+	// 22 -> 23
+	migrate22To23AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN attachments TEXT NOT NULL DEFAULT('');
+		ALTER TABLE messages ADD COLUMN attachments_size INT NOT NULL DEFAULT('0');
+	`
+
+	// 23 -> 24
+	migrate23To24AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN attachment_width INT NOT NULL DEFAULT('0');
+		ALTER TABLE messages ADD COLUMN attachment_height INT NOT NULL DEFAULT('0');
+		ALTER TABLE messages ADD COLUMN attachment_thumbnail TEXT NOT NULL DEFAULT('');
+	`
+
+	// 24 -> 25
+	migrate24To25CreateTombstonesTableQuery = `
+		CREATE TABLE IF NOT EXISTS tombstones (
+			mid TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			time INT NOT NULL,
+			deleted INT NOT NULL,
+			expires INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_tombstones_topic_deleted ON tombstones (topic, deleted);
+		CREATE INDEX IF NOT EXISTS idx_tombstones_expires ON tombstones (expires);
+	`
 )
 
 var (
@@ -277,14 +455,28 @@ var (
 		10: migrateFrom10,
 		11: migrateFrom11,
 		12: migrateFrom12,
+		13: migrateFrom13,
+		14: migrateFrom14,
+		15: migrateFrom15,
+		16: migrateFrom16,
+		17: migrateFrom17,
+		18: migrateFrom18,
+		19: migrateFrom19,
+		20: migrateFrom20,
+		21: migrateFrom21,
+		22: migrateFrom22,
+		23: migrateFrom23,
+		24: migrateFrom24,
 	}
 )
 
 type messageCache struct {
-	db    *sql.DB
-	queue *util.BatchingQueue[*message]
-	nop   bool
-	mu    sync.Mutex
+	db                *sql.DB
+	queue             *util.BatchingQueue[*message]
+	encryption        *topicEncryption // Encrypts message and title of selected topics at rest, may be nil
+	tombstoneDuration time.Duration    // Time to keep tombstones of deleted messages, see Tombstones; zero disables tombstones
+	nop               bool
+	mu                sync.Mutex
 }
 
 // newSqliteCache creates a SQLite file-backed cache
@@ -373,15 +565,43 @@ func (c *messageCache) addMessages(ms []*message) error {
 			return errUnexpectedMessageType
 		}
 		published := m.Time <= time.Now().Unix()
+		msg, title := m.Message, m.Title
+		if c.encryption.Enabled(m.Topic) {
+			if msg, err = c.encryption.EncryptString(m.Topic, msg); err != nil {
+				return err
+			} else if title, err = c.encryption.EncryptString(m.Topic, title); err != nil {
+				return err
+			}
+		}
 		tags := strings.Join(m.Tags, ",")
-		var attachmentName, attachmentType, attachmentURL string
+		channels := strings.Join(m.Channels, ",")
+		var attachmentName, attachmentType, attachmentURL, attachmentThumbnail string
 		var attachmentSize, attachmentExpires, attachmentDeleted int64
+		var attachmentWidth, attachmentHeight int
 		if m.Attachment != nil {
 			attachmentName = m.Attachment.Name
 			attachmentType = m.Attachment.Type
 			attachmentSize = m.Attachment.Size
 			attachmentExpires = m.Attachment.Expires
 			attachmentURL = m.Attachment.URL
+			attachmentWidth = m.Attachment.Width
+			attachmentHeight = m.Attachment.Height
+			attachmentThumbnail = m.Attachment.Thumbnail
+		}
+		var attachmentsStr string
+		var attachmentsSize int64
+		if len(m.Attachments) > 1 {
+			extra := m.Attachments[1:] // The first attachment is stored in the attachment_* columns
+			attachmentsBytes, err := json.Marshal(extra)
+			if err != nil {
+				return err
+			}
+			attachmentsStr = string(attachmentsBytes)
+			for _, a := range extra {
+				if a.Expires > 0 {
+					attachmentsSize += a.Size // Only attachments stored on this server count towards the limits
+				}
+			}
 		}
 		var actionsStr string
 		if len(m.Actions) > 0 {
@@ -391,6 +611,14 @@ func (c *messageCache) addMessages(ms []*message) error {
 			}
 			actionsStr = string(actionsBytes)
 		}
+		var previewStr string
+		if m.Preview != nil {
+			previewBytes, err := json.Marshal(m.Preview)
+			if err != nil {
+				return err
+			}
+			previewStr = string(previewBytes)
+		}
 		var sender string
 		if m.Sender.IsValid() {
 			sender = m.Sender.String()
@@ -400,8 +628,8 @@ func (c *messageCache) addMessages(ms []*message) error {
 			m.Time,
 			m.Expires,
 			m.Topic,
-			m.Message,
-			m.Title,
+			msg,
+			title,
 			m.Priority,
 			tags,
 			m.Click,
@@ -412,12 +640,24 @@ func (c *messageCache) addMessages(ms []*message) error {
 			attachmentSize,
 			attachmentExpires,
 			attachmentURL,
+			attachmentWidth,
+			attachmentHeight,
+			attachmentThumbnail,
 			attachmentDeleted, // Always zero
+			attachmentsStr,
+			attachmentsSize,
 			sender,
 			m.User,
 			m.ContentType,
 			m.Encoding,
+			channels,
+			m.Encryption,
+			m.ReplyTo,
+			m.Cron,
+			previewStr,
 			published,
+			m.Signature,
+			m.TTL,
 		)
 		if err != nil {
 			return err
@@ -453,7 +693,7 @@ func (c *messageCache) messagesSinceTime(topic string, since sinceMarker, schedu
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 func (c *messageCache) messagesSinceID(topic string, since sinceMarker, scheduled bool) ([]*message, error) {
@@ -479,7 +719,7 @@ func (c *messageCache) messagesSinceID(topic string, since sinceMarker, schedule
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 func (c *messageCache) messagesLatest(topic string) ([]*message, error) {
@@ -487,7 +727,7 @@ func (c *messageCache) messagesLatest(topic string) ([]*message, error) {
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 func (c *messageCache) MessagesDue() ([]*message, error) {
@@ -495,7 +735,7 @@ func (c *messageCache) MessagesDue() ([]*message, error) {
 	if err != nil {
 		return nil, err
 	}
-	return readMessages(rows)
+	return c.readMessages(rows)
 }
 
 // MessagesExpired returns a list of IDs for messages that have expires (should be deleted)
@@ -504,19 +744,58 @@ func (c *messageCache) MessagesExpired() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return c.readMessageIDs(rows)
+}
+
+// MessagesExpiredTTL returns all expired messages whose expiry was set by the publisher (see X-Expires).
+// Only the ID, topic, sender and user of the returned messages are set.
+func (c *messageCache) MessagesExpiredTTL() ([]*message, error) {
+	rows, err := c.db.Query(selectMessagesExpiredTTLQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
-	ids := make([]string, 0)
+	messages := make([]*message, 0)
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
+		var id, topic, sender, user string
+		if err := rows.Scan(&id, &topic, &sender, &user); err != nil {
 			return nil, err
 		}
-		ids = append(ids, id)
+		m := &message{ID: id, Topic: topic, User: user}
+		if sender != "" {
+			m.Sender, _ = netip.ParseAddr(sender)
+		}
+		messages = append(messages, m)
 	}
-	if err := rows.Err(); err != nil {
+	return messages, rows.Err()
+}
+
+// MessagesOlderThan returns the IDs of all published messages in the topic that were sent before the given time.
+// It is used to enforce topic policies that shorten the cache duration of messages that were already stored.
+func (c *messageCache) MessagesOlderThan(topic string, t time.Time) ([]string, error) {
+	rows, err := c.db.Query(selectMessagesOlderThanQuery, topic, t.Unix())
+	if err != nil {
 		return nil, err
 	}
-	return ids, nil
+	return c.readMessageIDs(rows)
+}
+
+// MessageIDs returns the IDs of all messages in the topic, including scheduled messages
+func (c *messageCache) MessageIDs(topic string) ([]string, error) {
+	rows, err := c.db.Query(selectMessageIDsByTopicQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	return c.readMessageIDs(rows)
+}
+
+// MessagesOverLimit returns the IDs of all published messages in the topic except for the newest limit messages
+func (c *messageCache) MessagesOverLimit(topic string, limit int64) ([]string, error) {
+	rows, err := c.db.Query(selectMessagesOverLimitQuery, topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	return c.readMessageIDs(rows)
 }
 
 func (c *messageCache) Message(id string) (*message, error) {
@@ -528,7 +807,131 @@ func (c *messageCache) Message(id string) (*message, error) {
 		return nil, errMessageNotFound
 	}
 	defer rows.Close()
-	return readMessage(rows)
+	return c.readMessage(rows)
+}
+
+// Thread returns the message with the given ID and all (direct and indirect) replies to it, ordered by time
+func (c *messageCache) Thread(topic, id string) ([]*message, error) {
+	rows, err := c.db.Query(selectMessagesThreadQuery, id, topic)
+	if err != nil {
+		return nil, err
+	}
+	return c.readMessages(rows)
+}
+
+// ScheduledMessages returns all messages in the given topic that have not been published yet, i.e. delayed
+// messages and recurring messages (see X-Cron), ordered by the time of their next delivery
+func (c *messageCache) ScheduledMessages(topic string) ([]*message, error) {
+	rows, err := c.db.Query(selectMessagesScheduledQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	return c.readMessages(rows)
+}
+
+// ScheduledMessage returns the delayed or recurring message with the given ID, or errMessageNotFound if
+// there is no such message, or if it has already been published
+func (c *messageCache) ScheduledMessage(id string) (*message, error) {
+	rows, err := c.db.Query(selectScheduledMessageByIDQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, errMessageNotFound
+	}
+	return c.readMessage(rows)
+}
+
+// RecurringMessagesCount returns the number of recurring messages (see X-Cron) created by the given
+// user, or by the given IP address if the user ID is empty
+func (c *messageCache) RecurringMessagesCount(userID string, sender netip.Addr) (int, error) {
+	var rows *sql.Rows
+	var err error
+	if userID != "" {
+		rows, err = c.db.Query(selectRecurringCountByUserID, userID)
+	} else {
+		rows, err = c.db.Query(selectRecurringCountBySender, sender.String())
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errNoRows
+	}
+	var count int
+	if err := rows.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ScheduledMessagesCount returns the number of messages that are waiting to be published, including
+// recurring messages (see X-Cron)
+func (c *messageCache) ScheduledMessagesCount() (int, error) {
+	rows, err := c.db.Query(selectScheduledCountQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errNoRows
+	}
+	var count int
+	if err := rows.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// QueueLength returns the number of messages that are waiting to be written to the database,
+// or zero if messages are written synchronously (see cache-batch-size and cache-batch-timeout)
+func (c *messageCache) QueueLength() int {
+	if c.queue == nil {
+		return 0
+	}
+	return c.queue.Len()
+}
+
+// Reschedule moves the delivery time of a recurring message to the given time. The message stays
+// unpublished, so it will be picked up again by MessagesDue.
+func (c *messageCache) Reschedule(m *message, next int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.Expires += next - m.Time
+	m.Time = next
+	_, err := c.db.Exec(updateMessageRescheduledQuery, m.Time, m.Expires, m.ID)
+	return err
+}
+
+// UpdateMessage replaces the content of the message with the given ID (message, title, priority, tags, click URL,
+// icon, actions, content type, encoding and signature) with the content of m. All other fields remain unchanged.
+func (c *messageCache) UpdateMessage(m *message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nop {
+		return nil
+	}
+	msg, title := m.Message, m.Title
+	if c.encryption.Enabled(m.Topic) {
+		var err error
+		if msg, err = c.encryption.EncryptString(m.Topic, msg); err != nil {
+			return err
+		} else if title, err = c.encryption.EncryptString(m.Topic, title); err != nil {
+			return err
+		}
+	}
+	var actionsStr string
+	if len(m.Actions) > 0 {
+		actionsBytes, err := json.Marshal(m.Actions)
+		if err != nil {
+			return err
+		}
+		actionsStr = string(actionsBytes)
+	}
+	_, err := c.db.Exec(updateMessageContentQuery, msg, title, m.Priority, strings.Join(m.Tags, ","), m.Click, m.Icon, actionsStr, m.ContentType, m.Encoding, m.Signature, m.ID)
+	return err
 }
 
 func (c *messageCache) MarkPublished(m *message) error {
@@ -578,6 +981,8 @@ func (c *messageCache) Topics() (map[string]*topic, error) {
 	return topics, nil
 }
 
+// DeleteMessages deletes the messages with the given IDs, along with their acknowledgements. If tombstones are
+// enabled, a tombstone is kept for each published message, so that polling clients learn about the deletion.
 func (c *messageCache) DeleteMessages(ids ...string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -586,14 +991,198 @@ func (c *messageCache) DeleteMessages(ids ...string) error {
 		return err
 	}
 	defer tx.Rollback()
+	now := time.Now()
 	for _, id := range ids {
+		if c.tombstoneDuration > 0 {
+			if _, err := tx.Exec(insertTombstoneQuery, now.Unix(), now.Add(c.tombstoneDuration).Unix(), id); err != nil {
+				return err
+			}
+		}
 		if _, err := tx.Exec(deleteMessageQuery, id); err != nil {
 			return err
 		}
+		if _, err := tx.Exec(deleteMessageAcksQuery, id); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
+// Tombstones returns a "message_deleted" event for each message of the topic that was deleted since the given
+// marker, e.g. because it expired, or because it was deleted by the publisher. The time of the events is the
+// time of the deletion. For ID markers, all deletions after the referenced message was published are returned.
+func (c *messageCache) Tombstones(topic string, since sinceMarker) ([]*message, error) {
+	if since.IsNone() || since.IsLatest() {
+		return make([]*message, 0), nil
+	}
+	sinceTime := since.Time().Unix()
+	if since.IsID() {
+		rows, err := c.db.Query(selectMessageOrTombstoneTime, since.ID(), since.ID())
+		if err != nil {
+			return nil, err
+		}
+		sinceTime = 0 // Unknown IDs return all tombstones, like in messagesSinceID
+		if rows.Next() {
+			if err := rows.Scan(&sinceTime); err != nil {
+				rows.Close()
+				return nil, err
+			}
+		}
+		rows.Close()
+	}
+	rows, err := c.db.Query(selectTombstonesSinceTimeQuery, topic, sinceTime, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	messages := make([]*message, 0)
+	for rows.Next() {
+		var id string
+		var deleted int64
+		if err := rows.Scan(&id, &deleted); err != nil {
+			return nil, err
+		}
+		m := newMessageDeletedMessage(topic, id)
+		m.Time = deleted
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteExpiredTombstones deletes all tombstones that are older than the tombstone duration, and returns
+// the number of deleted tombstones
+func (c *messageCache) DeleteExpiredTombstones() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.Exec(deleteTombstonesExpiredQuery, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// AddAck stores an acknowledgement of the message with the given ID. The acker identifies who acknowledged
+// the message (user ID or IP address), so that each acker is only counted once; the username is empty for
+// anonymous acknowledgements. It returns false if the acker has already acknowledged the message.
+func (c *messageCache) AddAck(id, acker, username string, t time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.Exec(insertAckQuery, id, acker, username, t.Unix())
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// Acks returns all acknowledgements of the message with the given ID, ordered by time
+func (c *messageCache) Acks(id string) ([]*ack, error) {
+	rows, err := c.db.Query(selectAcksQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	acks := make([]*ack, 0)
+	for rows.Next() {
+		var a ack
+		if err := rows.Scan(&a.User, &a.Time); err != nil {
+			return nil, err
+		}
+		acks = append(acks, &a)
+	}
+	return acks, rows.Err()
+}
+
+// AddReport stores an abuse report. Each reporter (user ID, or IP address for anonymous reporters) can report
+// a message (or topic, if the message ID is empty) only once. It returns false if the report already existed.
+func (c *messageCache) AddReport(r *report, reporter string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.Exec(insertReportQuery, r.ID, r.Topic, r.MessageID, reporter, r.User, r.Sender, r.Reason, r.Time)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// Reports returns all abuse reports, or the reports of the given topic if it is not empty, ordered by time
+func (c *messageCache) Reports(topic string) ([]*report, error) {
+	var rows *sql.Rows
+	var err error
+	if topic == "" {
+		rows, err = c.db.Query(selectReportsQuery)
+	} else {
+		rows, err = c.db.Query(selectReportsByTopicQuery, topic)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := make([]*report, 0)
+	for rows.Next() {
+		var r report
+		if err := rows.Scan(&r.ID, &r.Topic, &r.MessageID, &r.User, &r.Sender, &r.Reason, &r.Time); err != nil {
+			return nil, err
+		}
+		reports = append(reports, &r)
+	}
+	return reports, rows.Err()
+}
+
+// ReportersCount returns the number of distinct reporters that reported the given topic, or any of its messages
+func (c *messageCache) ReportersCount(topic string) (int, error) {
+	rows, err := c.db.Query(selectReportersCountByTopicQuery, topic)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errNoRows
+	}
+	var count int
+	if err := rows.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteReport deletes the abuse report with the given ID of the given topic, and returns false if it did not exist
+func (c *messageCache) DeleteReport(topic, id string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.Exec(deleteReportQuery, topic, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// DeleteReports deletes all abuse reports of the given topic, and returns the number of deleted reports
+func (c *messageCache) DeleteReports(topic string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res, err := c.db.Exec(deleteReportsByTopicQuery, topic)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rows), nil
+}
+
 func (c *messageCache) ExpireMessages(topics ...string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -630,6 +1219,38 @@ func (c *messageCache) AttachmentsExpired() ([]string, error) {
 	return ids, nil
 }
 
+// AttachmentsExpiredByRules returns the IDs of all attachments that are expired according to the given attachment
+// expiry rules, based on the time of the message. This is used to apply shortened rules to existing attachments, since
+// the expiry of an attachment is set when it is uploaded.
+func (c *messageCache) AttachmentsExpiredByRules(rules []*AttachmentExpiryRule) ([]string, error) {
+	rows, err := c.db.Query(selectAttachmentsActiveQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	now := time.Now()
+	for rows.Next() {
+		var id, name, contentType string
+		var timestamp int64
+		if err := rows.Scan(&id, &timestamp, &name, &contentType); err != nil {
+			return nil, err
+		}
+		for _, rule := range rules {
+			if rule.Matches(contentType, name) {
+				if time.Unix(timestamp, 0).Add(rule.Duration).Before(now) {
+					ids = append(ids, id)
+				}
+				break
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 func (c *messageCache) MarkAttachmentsDeleted(ids ...string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -687,11 +1308,27 @@ func (c *messageCache) processMessageBatches() {
 	}
 }
 
-func readMessages(rows *sql.Rows) ([]*message, error) {
+func (c *messageCache) readMessageIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (c *messageCache) readMessages(rows *sql.Rows) ([]*message, error) {
 	defer rows.Close()
 	messages := make([]*message, 0)
 	for rows.Next() {
-		m, err := readMessage(rows)
+		m, err := c.readMessage(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -703,10 +1340,10 @@ func readMessages(rows *sql.Rows) ([]*message, error) {
 	return messages, nil
 }
 
-func readMessage(rows *sql.Rows) (*message, error) {
-	var timestamp, expires, attachmentSize, attachmentExpires int64
-	var priority int
-	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, sender, user, contentType, encoding string
+func (c *messageCache) readMessage(rows *sql.Rows) (*message, error) {
+	var timestamp, expires, attachmentSize, attachmentExpires, ttl int64
+	var priority, attachmentWidth, attachmentHeight int
+	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, attachmentThumbnail, attachmentsStr, sender, user, contentType, encoding, channelsStr, encryption, replyTo, cron, previewStr, signature string
 	err := rows.Scan(
 		&id,
 		&timestamp,
@@ -724,10 +1361,21 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		&attachmentSize,
 		&attachmentExpires,
 		&attachmentURL,
+		&attachmentWidth,
+		&attachmentHeight,
+		&attachmentThumbnail,
+		&attachmentsStr,
 		&sender,
 		&user,
 		&contentType,
 		&encoding,
+		&channelsStr,
+		&encryption,
+		&replyTo,
+		&cron,
+		&previewStr,
+		&signature,
+		&ttl,
 	)
 	if err != nil {
 		return nil, err
@@ -736,12 +1384,22 @@ func readMessage(rows *sql.Rows) (*message, error) {
 	if tagsStr != "" {
 		tags = strings.Split(tagsStr, ",")
 	}
+	var channels []string
+	if channelsStr != "" {
+		channels = strings.Split(channelsStr, ",")
+	}
 	var actions []*action
 	if actionsStr != "" {
 		if err := json.Unmarshal([]byte(actionsStr), &actions); err != nil {
 			return nil, err
 		}
 	}
+	var prev *preview
+	if previewStr != "" {
+		if err := json.Unmarshal([]byte(previewStr), &prev); err != nil {
+			return nil, err
+		}
+	}
 	senderIP, err := netip.ParseAddr(sender)
 	if err != nil {
 		senderIP = netip.Addr{} // if no IP stored in database, return invalid address
@@ -749,11 +1407,28 @@ func readMessage(rows *sql.Rows) (*message, error) {
 	var att *attachment
 	if attachmentName != "" && attachmentURL != "" {
 		att = &attachment{
-			Name:    attachmentName,
-			Type:    attachmentType,
-			Size:    attachmentSize,
-			Expires: attachmentExpires,
-			URL:     attachmentURL,
+			Name:      attachmentName,
+			Type:      attachmentType,
+			Size:      attachmentSize,
+			Expires:   attachmentExpires,
+			URL:       attachmentURL,
+			Width:     attachmentWidth,
+			Height:    attachmentHeight,
+			Thumbnail: attachmentThumbnail,
+		}
+	}
+	var attachments []*attachment
+	if att != nil && attachmentsStr != "" {
+		if err := json.Unmarshal([]byte(attachmentsStr), &attachments); err != nil {
+			return nil, err
+		}
+		attachments = append([]*attachment{att}, attachments...)
+	}
+	if strings.HasPrefix(msg, encryptedPrefix) || strings.HasPrefix(title, encryptedPrefix) {
+		if msg, err = c.encryption.DecryptString(topic, msg); err != nil {
+			return nil, err
+		} else if title, err = c.encryption.DecryptString(topic, title); err != nil {
+			return nil, err
 		}
 	}
 	return &message{
@@ -770,10 +1445,18 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		Icon:        icon,
 		Actions:     actions,
 		Attachment:  att,
+		Attachments: attachments,
+		Preview:     prev,
 		Sender:      senderIP, // Must parse assuming database must be correct
 		User:        user,
 		ContentType: contentType,
 		Encoding:    encoding,
+		Channels:    channels,
+		Encryption:  encryption,
+		Signature:   signature,
+		ReplyTo:     replyTo,
+		Cron:        cron,
+		TTL:         ttl,
 	}, nil
 }
 
@@ -1030,3 +1713,195 @@ func migrateFrom12(db *sql.DB, _ time.Duration) error {
 	}
 	return tx.Commit()
 }
+
+func migrateFrom13(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 13 to 14")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate13To14AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 14); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom14(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 14 to 15")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate14To15AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 15); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom15(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 15 to 16")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate15To16AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 16); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom16(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 16 to 17")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate16To17AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 17); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom17(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 17 to 18")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate17To18AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 18); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom18(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 18 to 19")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate18To19CreateAcksTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 19); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom19(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 19 to 20")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate19To20AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 20); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom20(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 20 to 21")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate20To21CreateReportsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 21); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom21(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 21 to 22")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate21To22AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 22); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom22(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 22 to 23")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate22To23AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 23); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom23(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 23 to 24")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate23To24AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 24); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom24(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 24 to 25")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate24To25CreateTombstonesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 25); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -188,6 +188,15 @@ func testCacheMessagesTagsPrioAndTitle(t *testing.T, c *messageCache) {
 	require.Equal(t, []string{"tag1", "tag2"}, messages[0].Tags)
 	require.Equal(t, 5, messages[0].Priority)
 	require.Equal(t, "some title", messages[0].Title)
+	require.Equal(t, "", messages[0].Encryption)
+
+	m = newDefaultMessage("mytopic", "Y2lwaGVydGV4dA")
+	m.Encryption = "aes256gcm"
+	require.Nil(t, c.AddMessage(m))
+
+	messages, _ = c.Messages("mytopic", sinceAllMessages, false)
+	require.Equal(t, "Y2lwaGVydGV4dA", messages[1].Message)
+	require.Equal(t, "aes256gcm", messages[1].Encryption)
 }
 
 func TestSqliteCache_MessagesSinceID(t *testing.T) {
@@ -307,6 +316,50 @@ func testCachePrune(t *testing.T, c *messageCache) {
 	require.Equal(t, "my other message", messages[0].Message)
 }
 
+func TestSqliteCache_PruneTTL(t *testing.T) {
+	testCachePruneTTL(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_PruneTTL(t *testing.T) {
+	testCachePruneTTL(t, newMemTestCache(t))
+}
+
+func testCachePruneTTL(t *testing.T, c *messageCache) {
+	now := time.Now().Unix()
+
+	m1 := newDefaultMessage("mytopic", "expires early")
+	m1.Time = now - 10
+	m1.Expires = now - 5
+	m1.TTL = 5
+	m1.User = "u_phil"
+	m1.Sender = netip.MustParseAddr("1.2.3.4")
+
+	m2 := newDefaultMessage("mytopic", "expires regularly")
+	m2.Time = now - 10
+	m2.Expires = now - 5
+
+	m3 := newDefaultMessage("mytopic", "expires later")
+	m3.Time = now - 10
+	m3.Expires = now + 50
+	m3.TTL = 60
+
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+	require.Nil(t, c.AddMessage(m3))
+
+	expired, err := c.MessagesExpiredTTL()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(expired))
+	require.Equal(t, m1.ID, expired[0].ID)
+	require.Equal(t, "mytopic", expired[0].Topic)
+	require.Equal(t, "u_phil", expired[0].User)
+	require.Equal(t, "1.2.3.4", expired[0].Sender.String())
+
+	m, err := c.Message(m3.ID)
+	require.Nil(t, err)
+	require.Equal(t, int64(60), m.TTL)
+}
+
 func TestSqliteCache_Attachments(t *testing.T) {
 	testCacheAttachments(t, newSqliteTestCache(t))
 }
@@ -389,6 +442,37 @@ func testCacheAttachments(t *testing.T, c *messageCache) {
 	require.Equal(t, int64(20000), size)
 }
 
+func TestSqliteCache_Attachments_Multiple(t *testing.T) {
+	testCacheAttachmentsMultiple(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Attachments_Multiple(t *testing.T) {
+	testCacheAttachmentsMultiple(t, newMemTestCache(t))
+}
+
+func testCacheAttachmentsMultiple(t *testing.T, c *messageCache) {
+	expires := time.Now().Add(2 * time.Hour).Unix()
+	m := newDefaultMessage("mytopic", "two cars")
+	m.Sender = netip.MustParseAddr("1.2.3.4")
+	m.Attachment = &attachment{Name: "car1.jpg", Type: "image/jpeg", Size: 1000, Expires: expires, URL: "https://ntfy.sh/file/aCaRURL1.jpg"}
+	m.Attachments = []*attachment{
+		m.Attachment,
+		{Name: "car2.jpg", Type: "image/jpeg", Size: 2000, Expires: expires, URL: "https://ntfy.sh/file/aCaRURL1/1.jpg"},
+		{Name: "car3.jpg", URL: "https://example.com/car3.jpg"}, // External, does not count towards the limits
+	}
+	require.Nil(t, c.AddMessage(m))
+
+	messages, err := c.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m.Attachment, messages[0].Attachment)
+	require.Equal(t, m.Attachments, messages[0].Attachments)
+
+	size, err := c.AttachmentBytesUsedBySender("1.2.3.4")
+	require.Nil(t, err)
+	require.Equal(t, int64(3000), size)
+}
+
 func TestSqliteCache_Attachments_Expired(t *testing.T) {
 	testCacheAttachmentsExpired(t, newSqliteTestCache(t))
 }
@@ -696,6 +780,205 @@ func testSender(t *testing.T, c *messageCache) {
 	require.Equal(t, messages[1].Sender, netip.Addr{})
 }
 
+func TestSqliteCache_Thread(t *testing.T) {
+	testCacheThread(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Thread(t *testing.T) {
+	testCacheThread(t, newMemTestCache(t))
+}
+
+func testCacheThread(t *testing.T, c *messageCache) {
+	root := newDefaultMessage("incidents", "database is down")
+	root.Time = 100
+	reply1 := newDefaultMessage("incidents", "looking into it")
+	reply1.Time = 200
+	reply1.ReplyTo = root.ID
+	reply2 := newDefaultMessage("incidents", "restarted the primary")
+	reply2.Time = 300
+	reply2.ReplyTo = reply1.ID
+	other := newDefaultMessage("incidents", "unrelated")
+	other.Time = 150
+	otherTopic := newDefaultMessage("mytopic", "wrong topic")
+	otherTopic.ReplyTo = root.ID
+	require.Nil(t, c.AddMessage(root))
+	require.Nil(t, c.AddMessage(reply1))
+	require.Nil(t, c.AddMessage(reply2))
+	require.Nil(t, c.AddMessage(other))
+	require.Nil(t, c.AddMessage(otherTopic))
+
+	messages, err := c.Thread("incidents", root.ID)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, root.ID, messages[0].ID)
+	require.Equal(t, "", messages[0].ReplyTo)
+	require.Equal(t, reply1.ID, messages[1].ID)
+	require.Equal(t, root.ID, messages[1].ReplyTo)
+	require.Equal(t, reply2.ID, messages[2].ID)
+	require.Equal(t, reply1.ID, messages[2].ReplyTo)
+
+	messages, err = c.Thread("incidents", reply1.ID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+
+	messages, err = c.Thread("incidents", "doesnotexist")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(messages))
+}
+
+func TestSqliteCache_Acks(t *testing.T) {
+	testCacheAcks(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Acks(t *testing.T) {
+	testCacheAcks(t, newMemTestCache(t))
+}
+
+func testCacheAcks(t *testing.T, c *messageCache) {
+	m := newDefaultMessage("oncall", "disk full")
+	require.Nil(t, c.AddMessage(m))
+
+	added, err := c.AddAck(m.ID, "u_123", "phil", time.Unix(100, 0))
+	require.Nil(t, err)
+	require.True(t, added)
+	added, err = c.AddAck(m.ID, "ip:1.2.3.4", "", time.Unix(200, 0))
+	require.Nil(t, err)
+	require.True(t, added)
+	added, err = c.AddAck(m.ID, "u_123", "phil", time.Unix(300, 0)) // Same acker, not added again
+	require.Nil(t, err)
+	require.False(t, added)
+
+	acks, err := c.Acks(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(acks))
+	require.Equal(t, "phil", acks[0].User)
+	require.Equal(t, int64(100), acks[0].Time)
+	require.Equal(t, "", acks[1].User)
+	require.Equal(t, int64(200), acks[1].Time)
+
+	// Acks are deleted with the message
+	require.Nil(t, c.DeleteMessages(m.ID))
+	acks, err = c.Acks(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(acks))
+}
+
+func TestSqliteCache_Reports(t *testing.T) {
+	testCacheReports(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Reports(t *testing.T) {
+	testCacheReports(t, newMemTestCache(t))
+}
+
+func testCacheReports(t *testing.T, c *messageCache) {
+	added, err := c.AddReport(&report{ID: "rp_1", Time: 100, Topic: "spam", MessageID: "abcdefghijkl", User: "phil", Sender: "1.2.3.4", Reason: "phishing"}, "u_123")
+	require.Nil(t, err)
+	require.True(t, added)
+	added, err = c.AddReport(&report{ID: "rp_2", Time: 200, Topic: "spam", Sender: "5.6.7.8"}, "ip:5.6.7.8")
+	require.Nil(t, err)
+	require.True(t, added)
+	added, err = c.AddReport(&report{ID: "rp_3", Time: 300, Topic: "spam", MessageID: "abcdefghijkl", User: "phil", Sender: "1.2.3.4"}, "u_123") // Same reporter and message
+	require.Nil(t, err)
+	require.False(t, added)
+	added, err = c.AddReport(&report{ID: "rp_4", Time: 400, Topic: "spam", MessageID: "mnopqrstuvwx", User: "phil", Sender: "1.2.3.4"}, "u_123")
+	require.Nil(t, err)
+	require.True(t, added)
+	added, err = c.AddReport(&report{ID: "rp_5", Time: 500, Topic: "other", Sender: "5.6.7.8"}, "ip:5.6.7.8")
+	require.Nil(t, err)
+	require.True(t, added)
+
+	reports, err := c.Reports("")
+	require.Nil(t, err)
+	require.Equal(t, 4, len(reports))
+	reports, err = c.Reports("spam")
+	require.Nil(t, err)
+	require.Equal(t, 3, len(reports))
+	require.Equal(t, &report{ID: "rp_1", Time: 100, Topic: "spam", MessageID: "abcdefghijkl", User: "phil", Sender: "1.2.3.4", Reason: "phishing"}, reports[0])
+
+	reporters, err := c.ReportersCount("spam")
+	require.Nil(t, err)
+	require.Equal(t, 2, reporters) // Distinct reporters
+
+	found, err := c.DeleteReport("other", "rp_1") // Wrong topic
+	require.Nil(t, err)
+	require.False(t, found)
+	found, err = c.DeleteReport("spam", "rp_1")
+	require.Nil(t, err)
+	require.True(t, found)
+	deleted, err := c.DeleteReports("spam")
+	require.Nil(t, err)
+	require.Equal(t, 2, deleted)
+	reporters, err = c.ReportersCount("spam")
+	require.Nil(t, err)
+	require.Equal(t, 0, reporters)
+}
+
+func TestSqliteCache_Tombstones(t *testing.T) {
+	testCacheTombstones(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Tombstones(t *testing.T) {
+	testCacheTombstones(t, newMemTestCache(t))
+}
+
+func testCacheTombstones(t *testing.T, c *messageCache) {
+	now := time.Now().Unix()
+	m1 := newDefaultMessage("mytopic", "my message")
+	m1.Time = now - 100
+	m2 := newDefaultMessage("mytopic", "my other message")
+	m2.Time = now - 50
+	m3 := newDefaultMessage("mytopic", "scheduled message")
+	m3.Time = now + 1000
+	m4 := newDefaultMessage("mytopic", "deleted without tombstone")
+	m4.Time = now - 10
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+	require.Nil(t, c.AddMessage(m3))
+	require.Nil(t, c.AddMessage(m4))
+
+	require.Nil(t, c.DeleteMessages(m4.ID)) // Tombstones disabled
+	c.tombstoneDuration = time.Hour
+	require.Nil(t, c.DeleteMessages(m1.ID, m3.ID)) // m3 was never published, so there is no tombstone
+
+	tombstones, err := c.Tombstones("mytopic", sinceAllMessages)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tombstones))
+	require.Equal(t, m1.ID, tombstones[0].ID)
+	require.Equal(t, messageDeletedEvent, tombstones[0].Event)
+	require.Equal(t, "mytopic", tombstones[0].Topic)
+	require.InDelta(t, now, tombstones[0].Time, 1)
+
+	// Deleted after m2 was published, and after m1 was published (even though m1 is gone)
+	tombstones, err = c.Tombstones("mytopic", newSinceID(m2.ID))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tombstones))
+	tombstones, err = c.Tombstones("mytopic", newSinceID(m1.ID))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tombstones))
+	tombstones, err = c.Tombstones("mytopic", newSinceTime(now+10))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tombstones))
+	tombstones, err = c.Tombstones("mytopic", sinceLatestMessage)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tombstones))
+	tombstones, err = c.Tombstones("othertopic", sinceAllMessages)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tombstones))
+
+	// Expired tombstones are no longer returned, and are pruned
+	require.Nil(t, c.DeleteMessages(m2.ID))
+	_, err = c.db.Exec(`UPDATE tombstones SET expires = ? WHERE mid = ?`, now-1, m2.ID)
+	require.Nil(t, err)
+	tombstones, err = c.Tombstones("mytopic", sinceAllMessages)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tombstones))
+	require.Equal(t, m1.ID, tombstones[0].ID)
+	deleted, err := c.DeleteExpiredTombstones()
+	require.Nil(t, err)
+	require.Equal(t, int64(1), deleted)
+}
+
 func checkSchemaVersion(t *testing.T, db *sql.DB) {
 	rows, err := db.Query(`SELECT version FROM schemaVersion`)
 	require.Nil(t, err)
@@ -738,6 +1021,57 @@ func newSqliteTestCacheFromFile(t *testing.T, filename, startupQueries string) *
 	return c
 }
 
+func TestSqliteCache_Recurring(t *testing.T) {
+	testCacheRecurring(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Recurring(t *testing.T) {
+	testCacheRecurring(t, newMemTestCache(t))
+}
+
+func testCacheRecurring(t *testing.T, c *messageCache) {
+	now := time.Now().Unix()
+	recurring := newDefaultMessage("mytopic", "daily reminder")
+	recurring.Time = now + 60
+	recurring.Expires = now + 60 + 3600
+	recurring.Cron = "@daily"
+	recurring.Sender = netip.MustParseAddr("1.2.3.4")
+	delayed := newDefaultMessage("mytopic", "delayed")
+	delayed.Time = now + 120
+	delayed.User = "u_abc"
+	delayed.Sender = netip.MustParseAddr("1.2.3.4")
+	published := newDefaultMessage("mytopic", "published")
+	require.Nil(t, c.AddMessage(recurring))
+	require.Nil(t, c.AddMessage(delayed))
+	require.Nil(t, c.AddMessage(published))
+
+	messages, err := c.ScheduledMessages("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, recurring.ID, messages[0].ID)
+	require.Equal(t, "@daily", messages[0].Cron)
+	require.Equal(t, delayed.ID, messages[1].ID)
+	require.Equal(t, "", messages[1].Cron)
+
+	count, err := c.RecurringMessagesCount("", netip.MustParseAddr("1.2.3.4"))
+	require.Nil(t, err)
+	require.Equal(t, 1, count)
+	count, err = c.RecurringMessagesCount("u_abc", netip.Addr{})
+	require.Nil(t, err)
+	require.Equal(t, 0, count)
+
+	m, err := c.ScheduledMessage(recurring.ID)
+	require.Nil(t, err)
+	require.Nil(t, c.Reschedule(m, now+86400))
+	m, err = c.ScheduledMessage(recurring.ID)
+	require.Nil(t, err)
+	require.Equal(t, now+86400, m.Time)
+	require.Equal(t, now+86400+3600, m.Expires)
+
+	_, err = c.ScheduledMessage(published.ID)
+	require.Equal(t, errMessageNotFound, err)
+}
+
 func newMemTestCache(t *testing.T) *messageCache {
 	c, err := newMemCache()
 	require.Nil(t, err)
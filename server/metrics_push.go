@@ -0,0 +1,326 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	metricsPushTimeout       = 10 * time.Second
+	metricsRemoteWriteJob    = "ntfy"
+	metricsStatsdPacketLimit = 1432 // Fits into a single UDP packet on most networks, see DogStatsD docs
+)
+
+// metricsExporter pushes the current values of all metrics to a remote system. It is used in environments where
+// the /metrics endpoint cannot be scraped by Prometheus.
+type metricsExporter interface {
+	Export(samples []*metricsSample, now time.Time) error
+	Name() string
+	Close() error
+}
+
+// metricsSample is a single value of a metric, flattened from the Prometheus metric families. Histograms
+// and summaries are split into multiple samples, the same way they are rendered in the text format.
+type metricsSample struct {
+	Name    string
+	Labels  [][2]string
+	Value   float64
+	Counter bool // Monotonically increasing value, e.g. a counter, or the _count of a histogram
+}
+
+// runMetricsPusher periodically gathers all metrics and passes them on to the configured exporters
+func (s *Server) runMetricsPusher() {
+	exporters, err := s.metricsExporters()
+	if err != nil {
+		log.Tag(tagMetrics).Err(err).Warn("Unable to set up metrics exporters, not pushing metrics")
+		return
+	} else if len(exporters) == 0 {
+		return
+	}
+	defer func() {
+		for _, exporter := range exporters {
+			exporter.Close()
+		}
+	}()
+	for {
+		select {
+		case <-time.After(s.config.Load().MetricsPushInterval):
+			s.pushMetrics(exporters)
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+func (s *Server) pushMetrics(exporters []metricsExporter) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		log.Tag(tagMetrics).Err(err).Warn("Unable to gather metrics")
+		return
+	}
+	samples, now := metricsSamplesFromFamilies(families), time.Now()
+	for _, exporter := range exporters {
+		if err := exporter.Export(samples, now); err != nil {
+			log.Tag(tagMetrics).Err(err).Warn("Unable to push metrics via %s", exporter.Name())
+		} else {
+			log.Tag(tagMetrics).Trace("Pushed %d metric sample(s) via %s", len(samples), exporter.Name())
+		}
+	}
+}
+
+func (s *Server) metricsExporters() ([]metricsExporter, error) {
+	exporters := make([]metricsExporter, 0)
+	if s.config.Load().MetricsRemoteWriteURL != "" {
+		exporters = append(exporters, newMetricsRemoteWriteExporter(s.config.Load().MetricsRemoteWriteURL))
+	}
+	if s.config.Load().MetricsStatsdAddress != "" {
+		exporter, err := newMetricsStatsdExporter(s.config.Load().MetricsStatsdAddress)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+	return exporters, nil
+}
+
+// metricsRemoteWriteExporter pushes metrics to a Prometheus remote write endpoint (e.g. Prometheus with
+// --web.enable-remote-write-receiver, Grafana Mimir, VictoriaMetrics, ...), using the 1.0 protocol.
+//
+// See https://prometheus.io/docs/specs/prw/remote_write_spec/
+type metricsRemoteWriteExporter struct {
+	url        string
+	instance   string
+	httpClient *http.Client
+}
+
+func newMetricsRemoteWriteExporter(url string) *metricsRemoteWriteExporter {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	return &metricsRemoteWriteExporter{
+		url:        url,
+		instance:   instance,
+		httpClient: &http.Client{Timeout: metricsPushTimeout},
+	}
+}
+
+func (e *metricsRemoteWriteExporter) Name() string {
+	return "remote write"
+}
+
+func (e *metricsRemoteWriteExporter) Close() error {
+	return nil
+}
+
+func (e *metricsRemoteWriteExporter) Export(samples []*metricsSample, now time.Time) error {
+	body := snappy.Encode(nil, e.writeRequest(samples, now))
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "ntfy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if req.URL.User != nil {
+		password, _ := req.URL.User.Password()
+		req.SetBasicAuth(req.URL.User.Username(), password)
+		req.URL.User = nil
+	}
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// writeRequest encodes the samples as a prometheus.WriteRequest protobuf message. The message is simple enough
+// to encode by hand, which saves us from depending on the Prometheus server module:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func (e *metricsRemoteWriteExporter) writeRequest(samples []*metricsSample, now time.Time) []byte {
+	var request []byte
+	for _, sample := range samples {
+		labels := append([][2]string{{"__name__", sample.Name}, {"instance", e.instance}, {"job", metricsRemoteWriteJob}}, sample.Labels...)
+		sort.SliceStable(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] }) // Remote write requires sorted labels
+		var series []byte
+		for _, label := range labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label[0])
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label[1])
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, l)
+		}
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+		s = protowire.AppendFixed64(s, math.Float64bits(sample.Value))
+		s = protowire.AppendTag(s, 2, protowire.VarintType)
+		s = protowire.AppendVarint(s, uint64(now.UnixMilli()))
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, s)
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
+
+// metricsStatsdExporter pushes metrics to a StatsD server via UDP. Labels are sent as tags, using the DogStatsD
+// format (name:value|type|#tag:value), which is understood by the Datadog agent, Telegraf and the statsd_exporter.
+//
+// StatsD counters are deltas, so the exporter remembers the last value of each counter, and only sends the
+// difference to the last push. Gauges are sent as is.
+type metricsStatsdExporter struct {
+	conn net.Conn
+	last map[string]float64
+}
+
+func newMetricsStatsdExporter(address string) (*metricsStatsdExporter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsStatsdExporter{
+		conn: conn,
+		last: make(map[string]float64),
+	}, nil
+}
+
+func (e *metricsStatsdExporter) Name() string {
+	return "statsd"
+}
+
+func (e *metricsStatsdExporter) Close() error {
+	return e.conn.Close()
+}
+
+func (e *metricsStatsdExporter) Export(samples []*metricsSample, _ time.Time) error {
+	var packet bytes.Buffer
+	for _, sample := range samples {
+		line := e.line(sample)
+		if line == "" {
+			continue
+		}
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > metricsStatsdPacketLimit {
+			if _, err := e.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		if _, err := e.conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line returns the StatsD line for the sample, or an empty string if there is nothing to send
+func (e *metricsStatsdExporter) line(sample *metricsSample) string {
+	tags := make([]string, 0, len(sample.Labels))
+	for _, label := range sample.Labels {
+		tags = append(tags, label[0]+":"+label[1])
+	}
+	var suffix string
+	if len(tags) > 0 {
+		suffix = "|#" + strings.Join(tags, ",")
+	}
+	value := sample.Value
+	if !sample.Counter {
+		return sample.Name + ":" + formatMetricsValue(value) + "|g" + suffix
+	}
+	key := sample.Name + suffix
+	last, ok := e.last[key]
+	e.last[key] = value
+	if ok && value >= last {
+		value -= last
+	} // else: first push, or counter was reset, so we send the full value
+	if value == 0 {
+		return ""
+	}
+	return sample.Name + ":" + formatMetricsValue(value) + "|c" + suffix
+}
+
+// metricsSamplesFromFamilies flattens the gathered metric families into a list of samples
+func metricsSamplesFromFamilies(families []*dto.MetricFamily) []*metricsSample {
+	samples := make([]*metricsSample, 0)
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.GetMetric() {
+			labels := make([][2]string, 0, len(metric.GetLabel())+1)
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, [2]string{label.GetName(), label.GetValue()})
+			}
+			add := func(name string, value float64, counter bool, extra ...[2]string) {
+				samples = append(samples, &metricsSample{
+					Name:    name,
+					Labels:  append(append([][2]string{}, labels...), extra...),
+					Value:   value,
+					Counter: counter,
+				})
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue(), true)
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue(), false)
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue(), false)
+			case dto.MetricType_SUMMARY:
+				for _, q := range metric.GetSummary().GetQuantile() {
+					add(name, q.GetValue(), false, [2]string{"quantile", formatMetricsValue(q.GetQuantile())})
+				}
+				add(name+"_sum", metric.GetSummary().GetSampleSum(), true)
+				add(name+"_count", float64(metric.GetSummary().GetSampleCount()), true)
+			case dto.MetricType_HISTOGRAM:
+				buckets := metric.GetHistogram().GetBucket()
+				for _, b := range buckets {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), true, [2]string{"le", formatMetricsValue(b.GetUpperBound())})
+				}
+				if len(buckets) == 0 || !math.IsInf(buckets[len(buckets)-1].GetUpperBound(), 1) {
+					add(name+"_bucket", float64(metric.GetHistogram().GetSampleCount()), true, [2]string{"le", "+Inf"})
+				}
+				add(name+"_sum", metric.GetHistogram().GetSampleSum(), true)
+				add(name+"_count", float64(metric.GetHistogram().GetSampleCount()), true)
+			}
+		}
+	}
+	return samples
+}
+
+func formatMetricsValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestMetricsSamplesFromFamilies(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total"}, []string{"code"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_visitors"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Buckets: []float64{0.1, 1}})
+	registry.MustRegister(counter, gauge, histogram)
+	counter.WithLabelValues("200").Add(3)
+	gauge.Set(5)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+
+	families, err := registry.Gather()
+	require.Nil(t, err)
+	samples := metricsSamplesFromFamilies(families)
+	require.Equal(t, []*metricsSample{
+		{Name: "test_duration_seconds_bucket", Labels: [][2]string{{"le", "0.1"}}, Value: 0, Counter: true},
+		{Name: "test_duration_seconds_bucket", Labels: [][2]string{{"le", "1"}}, Value: 1, Counter: true},
+		{Name: "test_duration_seconds_bucket", Labels: [][2]string{{"le", "+Inf"}}, Value: 2, Counter: true},
+		{Name: "test_duration_seconds_sum", Labels: [][2]string{}, Value: 2.5, Counter: true},
+		{Name: "test_duration_seconds_count", Labels: [][2]string{}, Value: 2, Counter: true},
+		{Name: "test_requests_total", Labels: [][2]string{{"code", "200"}}, Value: 3, Counter: true},
+		{Name: "test_visitors", Labels: [][2]string{}, Value: 5, Counter: false},
+	}, samples)
+}
+
+func TestMetricsRemoteWriteExporter(t *testing.T) {
+	var body []byte
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := io.ReadAll(r.Body)
+		body, _ = snappy.Decode(nil, compressed)
+		headers = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	exporter := newMetricsRemoteWriteExporter(strings.Replace(server.URL, "http://", "http://phil:mypass@", 1))
+	exporter.instance = "myhost"
+	now := time.UnixMilli(1700000000123)
+	require.Nil(t, exporter.Export([]*metricsSample{
+		{Name: "ntfy_messages_published_success", Labels: [][2]string{}, Value: 12, Counter: true},
+		{Name: "ntfy_http_requests_total", Labels: [][2]string{{"http_code", "200"}}, Value: 3.5, Counter: true},
+	}, now))
+	require.Equal(t, "snappy", headers.Get("Content-Encoding"))
+	require.Equal(t, "application/x-protobuf", headers.Get("Content-Type"))
+	require.Equal(t, "0.1.0", headers.Get("X-Prometheus-Remote-Write-Version"))
+	require.Equal(t, "Basic cGhpbDpteXBhc3M=", headers.Get("Authorization"))
+
+	series := decodeTestWriteRequest(t, body)
+	require.Len(t, series, 2)
+	require.Equal(t, [][2]string{{"__name__", "ntfy_messages_published_success"}, {"instance", "myhost"}, {"job", "ntfy"}}, series[0].labels)
+	require.Equal(t, float64(12), series[0].value)
+	require.Equal(t, int64(1700000000123), series[0].timestamp)
+	require.Equal(t, [][2]string{{"__name__", "ntfy_http_requests_total"}, {"http_code", "200"}, {"instance", "myhost"}, {"job", "ntfy"}}, series[1].labels)
+	require.Equal(t, 3.5, series[1].value)
+}
+
+func TestMetricsRemoteWriteExporter_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	exporter := newMetricsRemoteWriteExporter(server.URL)
+	err := exporter.Export([]*metricsSample{{Name: "ntfy_topics_total", Value: 1}}, time.Now())
+	require.ErrorContains(t, err, "out of order sample")
+}
+
+func TestMetricsStatsdExporter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	exporter, err := newMetricsStatsdExporter(conn.LocalAddr().String())
+	require.Nil(t, err)
+	defer exporter.Close()
+
+	// First push sends the full counter values
+	require.Nil(t, exporter.Export([]*metricsSample{
+		{Name: "ntfy_messages_published_success", Value: 10, Counter: true},
+		{Name: "ntfy_http_requests_total", Labels: [][2]string{{"http_code", "200"}, {"http_method", "GET"}}, Value: 4, Counter: true},
+		{Name: "ntfy_subscribers_total", Value: 7},
+	}, time.Now()))
+	require.Equal(t, []string{
+		"ntfy_messages_published_success:10|c",
+		"ntfy_http_requests_total:4|c|#http_code:200,http_method:GET",
+		"ntfy_subscribers_total:7|g",
+	}, readTestStatsdLines(t, conn))
+
+	// Second push only sends the deltas, and skips counters that did not change
+	require.Nil(t, exporter.Export([]*metricsSample{
+		{Name: "ntfy_messages_published_success", Value: 13, Counter: true},
+		{Name: "ntfy_http_requests_total", Labels: [][2]string{{"http_code", "200"}, {"http_method", "GET"}}, Value: 4, Counter: true},
+		{Name: "ntfy_subscribers_total", Value: 2},
+	}, time.Now()))
+	require.Equal(t, []string{
+		"ntfy_messages_published_success:3|c",
+		"ntfy_subscribers_total:2|g",
+	}, readTestStatsdLines(t, conn))
+}
+
+func TestMetricsStatsdExporter_SplitPackets(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+
+	exporter, err := newMetricsStatsdExporter(conn.LocalAddr().String())
+	require.Nil(t, err)
+	defer exporter.Close()
+
+	samples := make([]*metricsSample, 0)
+	for i := 0; i < 100; i++ {
+		samples = append(samples, &metricsSample{Name: "ntfy_some_long_metric_name_to_fill_packets", Labels: [][2]string{{"i", strings.Repeat("x", i)}}, Value: 1})
+	}
+	require.Nil(t, exporter.Export(samples, time.Now()))
+	lines := 0
+	for lines < 100 {
+		packet := readTestStatsdLines(t, conn)
+		require.LessOrEqual(t, len(strings.Join(packet, "\n")), metricsStatsdPacketLimit)
+		lines += len(packet)
+	}
+	require.Equal(t, 100, lines)
+}
+
+type testRemoteWriteSeries struct {
+	labels    [][2]string
+	value     float64
+	timestamp int64
+}
+
+func decodeTestWriteRequest(t *testing.T, b []byte) []*testRemoteWriteSeries {
+	series := make([]*testRemoteWriteSeries, 0)
+	for _, ts := range decodeTestProtoFields(t, b) {
+		s := &testRemoteWriteSeries{labels: make([][2]string, 0)}
+		for _, field := range decodeTestProtoFields(t, ts.bytes) {
+			if field.num == 1 {
+				label := decodeTestProtoFields(t, field.bytes)
+				s.labels = append(s.labels, [2]string{string(label[0].bytes), string(label[1].bytes)})
+			} else {
+				sample := decodeTestProtoFields(t, field.bytes)
+				s.value = math.Float64frombits(sample[0].number)
+				s.timestamp = int64(sample[1].number)
+			}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+type testProtoField struct {
+	num    protowire.Number
+	bytes  []byte
+	number uint64
+}
+
+func decodeTestProtoFields(t *testing.T, b []byte) []*testProtoField {
+	fields := make([]*testProtoField, 0)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		field := &testProtoField{num: num}
+		switch typ {
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			field.number, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			field.number, n = protowire.ConsumeVarint(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func readTestStatsdLines(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, 65536)
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.Nil(t, err)
+	return strings.Split(string(buf[:n]), "\n")
+}
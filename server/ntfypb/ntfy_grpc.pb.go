@@ -0,0 +1,209 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: server/ntfypb/ntfy.proto
+
+package ntfypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Ntfy_Publish_FullMethodName       = "/ntfy.v1.Ntfy/Publish"
+	Ntfy_PublishStream_FullMethodName = "/ntfy.v1.Ntfy/PublishStream"
+	Ntfy_Subscribe_FullMethodName     = "/ntfy.v1.Ntfy/Subscribe"
+)
+
+// NtfyClient is the client API for Ntfy service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Ntfy is the gRPC equivalent of the HTTP publish/subscribe API. It is served on a separate listener
+// (see listen-grpc). Credentials are passed via the "authorization" metadata key, in the same format as
+// the HTTP Authorization header (Basic or Bearer).
+type NtfyClient interface {
+	// Publish publishes a single message, and returns the published message
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Message, error)
+	// PublishStream publishes a stream of messages, and returns the published message for each request
+	PublishStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PublishRequest, Message], error)
+	// Subscribe streams messages for one or more topics, just like the HTTP JSON stream endpoint
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Message], error)
+}
+
+type ntfyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNtfyClient(cc grpc.ClientConnInterface) NtfyClient {
+	return &ntfyClient{cc}
+}
+
+func (c *ntfyClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*Message, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Message)
+	err := c.cc.Invoke(ctx, Ntfy_Publish_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ntfyClient) PublishStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[PublishRequest, Message], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Ntfy_ServiceDesc.Streams[0], Ntfy_PublishStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PublishRequest, Message]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Ntfy_PublishStreamClient = grpc.BidiStreamingClient[PublishRequest, Message]
+
+func (c *ntfyClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Message], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Ntfy_ServiceDesc.Streams[1], Ntfy_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, Message]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Ntfy_SubscribeClient = grpc.ServerStreamingClient[Message]
+
+// NtfyServer is the server API for Ntfy service.
+// All implementations must embed UnimplementedNtfyServer
+// for forward compatibility.
+//
+// Ntfy is the gRPC equivalent of the HTTP publish/subscribe API. It is served on a separate listener
+// (see listen-grpc). Credentials are passed via the "authorization" metadata key, in the same format as
+// the HTTP Authorization header (Basic or Bearer).
+type NtfyServer interface {
+	// Publish publishes a single message, and returns the published message
+	Publish(context.Context, *PublishRequest) (*Message, error)
+	// PublishStream publishes a stream of messages, and returns the published message for each request
+	PublishStream(grpc.BidiStreamingServer[PublishRequest, Message]) error
+	// Subscribe streams messages for one or more topics, just like the HTTP JSON stream endpoint
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Message]) error
+	mustEmbedUnimplementedNtfyServer()
+}
+
+// UnimplementedNtfyServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedNtfyServer struct{}
+
+func (UnimplementedNtfyServer) Publish(context.Context, *PublishRequest) (*Message, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedNtfyServer) PublishStream(grpc.BidiStreamingServer[PublishRequest, Message]) error {
+	return status.Errorf(codes.Unimplemented, "method PublishStream not implemented")
+}
+func (UnimplementedNtfyServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Message]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedNtfyServer) mustEmbedUnimplementedNtfyServer() {}
+func (UnimplementedNtfyServer) testEmbeddedByValue()              {}
+
+// UnsafeNtfyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NtfyServer will
+// result in compilation errors.
+type UnsafeNtfyServer interface {
+	mustEmbedUnimplementedNtfyServer()
+}
+
+func RegisterNtfyServer(s grpc.ServiceRegistrar, srv NtfyServer) {
+	// If the following call pancis, it indicates UnimplementedNtfyServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Ntfy_ServiceDesc, srv)
+}
+
+func _Ntfy_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NtfyServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Ntfy_Publish_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NtfyServer).Publish(ctx, req.(*PublishRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ntfy_PublishStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(NtfyServer).PublishStream(&grpc.GenericServerStream[PublishRequest, Message]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Ntfy_PublishStreamServer = grpc.BidiStreamingServer[PublishRequest, Message]
+
+func _Ntfy_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NtfyServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, Message]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Ntfy_SubscribeServer = grpc.ServerStreamingServer[Message]
+
+// Ntfy_ServiceDesc is the grpc.ServiceDesc for Ntfy service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Ntfy_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ntfy.v1.Ntfy",
+	HandlerType: (*NtfyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Publish",
+			Handler:    _Ntfy_Publish_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PublishStream",
+			Handler:       _Ntfy_PublishStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Ntfy_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "server/ntfypb/ntfy.proto",
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	redisTimeout          = 2 * time.Second
+	redisMaxIdleConns     = 16
+	redisKeyPrefix        = "ntfy:limits:"
+	redisErrorLogInterval = time.Minute // Errors are logged at most once per interval, to not flood the log if Redis is down
+)
+
+var (
+	errRedisURLInvalid      = errors.New("invalid Redis URL, expected format redis://[[user]:password@]host[:port][/db] or rediss://...")
+	errRedisProtocolInvalid = errors.New("invalid Redis response")
+)
+
+// redisError is an error reply sent by the Redis server, e.g. "WRONGTYPE Operation against a key ..."
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisClient is a minimal Redis client, which only supports what is needed to share rate limiter counters between
+// multiple ntfy servers (see redisLimiter). It speaks RESP2, which is simple enough to implement by hand, and saves
+// us from depending on a full-blown Redis client library. Commands are sent as pipelines over pooled connections.
+//
+// See https://redis.io/docs/latest/develop/reference/protocol-spec/
+type redisClient struct {
+	addr      string
+	username  string
+	password  string
+	db        int
+	tlsConfig *tls.Config // nil for plain TCP
+	idle      chan *redisConn
+	lastError time.Time
+	mu        sync.Mutex
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, errRedisURLInvalid
+	}
+	c := &redisClient{
+		addr: u.Host,
+		idle: make(chan *redisConn, redisMaxIdleConns),
+	}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, errRedisURLInvalid
+		}
+	}
+	if u.Scheme == "rediss" {
+		c.tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	return c, nil
+}
+
+// Do sends the given commands in a single pipeline, and returns the replies in the same order. A reply can be
+// an int64, a string, nil, a []any (array), or a redisError. Redis error replies are returned as the error, too.
+func (c *redisClient) Do(commands ...[]string) ([]any, error) {
+	rc, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+	replies, err := rc.do(commands...)
+	if err != nil {
+		var redisErr redisError
+		if errors.As(err, &redisErr) {
+			c.release(rc) // Connection is still fine
+		} else {
+			rc.conn.Close()
+		}
+		return nil, err
+	}
+	c.release(rc)
+	return replies, nil
+}
+
+// Ping checks if the Redis server is reachable, and that the credentials are valid
+func (c *redisClient) Ping() error {
+	_, err := c.Do([]string{"PING"})
+	return err
+}
+
+// Close closes all idle connections
+func (c *redisClient) Close() {
+	for {
+		select {
+		case rc := <-c.idle:
+			rc.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// logError logs the given error, but only once per redisErrorLogInterval
+func (c *redisClient) logError(err error, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastError) < redisErrorLogInterval {
+		return
+	}
+	c.lastError = time.Now()
+	log.Tag(tagRedis).Err(err).Warn("%s, falling back to in-memory rate limiting", message)
+}
+
+func (c *redisClient) conn() (*redisConn, error) {
+	select {
+	case rc := <-c.idle:
+		return rc, nil
+	default:
+	}
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	setup := make([][]string, 0)
+	if c.password != "" && c.username != "" {
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	} else if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	if len(setup) > 0 {
+		if _, err := rc.do(setup...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (c *redisClient) release(rc *redisConn) {
+	select {
+	case c.idle <- rc:
+	default:
+		rc.conn.Close() // Pool is full
+	}
+}
+
+func (rc *redisConn) do(commands ...[]string) ([]any, error) {
+	if err := rc.conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(commands))
+	var firstErr error
+	for i := range commands {
+		reply, err := readRedisReply(rc.reader)
+		if err != nil {
+			var redisErr redisError
+			if !errors.As(err, &redisErr) {
+				return nil, err // Protocol or network error, connection is unusable
+			} else if firstErr == nil {
+				firstErr = err
+			}
+		}
+		replies[i] = reply
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return replies, nil
+}
+
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	} else if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errRedisProtocolInvalid
+	}
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return redisError(value), redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errRedisProtocolInvalid
+		} else if length < 0 {
+			return nil, nil // Null bulk string, e.g. GET of a key that does not exist
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errRedisProtocolInvalid
+		} else if length < 0 {
+			return nil, nil
+		}
+		elements := make([]any, length)
+		for i := range elements {
+			if elements[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	}
+	return nil, errRedisProtocolInvalid
+}
+
+// redisLimiter is a util.Limiter that keeps its value in Redis, so that the limit is shared between multiple
+// ntfy servers behind a load balancer. It counts up to the limit within fixed time windows (e.g. a day); each
+// window is stored in its own key, which expires after the window ended.
+//
+// If Redis is unavailable, the in-memory fallback limiter is used, so that Redis is not a single point of failure.
+type redisLimiter struct {
+	client   *redisClient
+	key      string // Key prefix, e.g. ntfy:limits:ip:1.2.3.4:messages
+	limit    int64
+	window   func(now time.Time) (start, end time.Time)
+	initial  atomic.Int64 // Value to start the current window with, e.g. the user's message count from the user database
+	fallback util.Limiter
+}
+
+var _ util.Limiter = (*redisLimiter)(nil)
+
+// newRedisLimiter creates a limiter that allows limit per window, with windows of the given duration
+func newRedisLimiter(client *redisClient, key string, limit int64, window time.Duration, fallback util.Limiter) *redisLimiter {
+	return &redisLimiter{
+		client: client,
+		key:    redisKeyPrefix + key,
+		limit:  limit,
+		window: func(now time.Time) (time.Time, time.Time) {
+			start := now.Truncate(window)
+			return start, start.Add(window)
+		},
+		fallback: fallback,
+	}
+}
+
+// newDailyRedisLimiter creates a limiter that allows limit per day, with days starting at the given time of day,
+// matching the daily reset of the visitor stats (see visitor-stats-reset-time). If the counter does not exist in Redis
+// yet, it starts at the value of the fallback limiter, e.g. the message count of a user after a restart.
+func newDailyRedisLimiter(client *redisClient, key string, limit int64, resetTime time.Time, fallback util.Limiter) *redisLimiter {
+	l := &redisLimiter{
+		client: client,
+		key:    redisKeyPrefix + key,
+		limit:  limit,
+		window: func(now time.Time) (time.Time, time.Time) {
+			end := util.NextOccurrenceUTC(resetTime, now)
+			return end.AddDate(0, 0, -1), end
+		},
+		fallback: fallback,
+	}
+	l.initial.Store(fallback.Value())
+	return l
+}
+
+func (l *redisLimiter) Allow() bool {
+	return l.AllowN(1)
+}
+
+func (l *redisLimiter) AllowN(n int64) bool {
+	key, ttl := l.windowKey(time.Now())
+	n64 := strconv.FormatInt(n, 10)
+	replies, err := l.client.Do(
+		[]string{"SET", key, strconv.FormatInt(l.initial.Load(), 10), "PX", strconv.FormatInt(ttl.Milliseconds(), 10), "NX"}, // Create with expiry, if missing
+		[]string{"INCRBY", key, n64},
+	)
+	if err != nil {
+		l.client.logError(err, "Unable to update rate limiter in Redis")
+		return l.fallback.AllowN(n)
+	}
+	if value, ok := replies[1].(int64); ok && value > l.limit {
+		if _, err := l.client.Do([]string{"DECRBY", key, n64}); err != nil { // Rejected requests do not count
+			l.client.logError(err, "Unable to update rate limiter in Redis")
+		}
+		return false
+	}
+	l.fallback.AllowN(n) // Keep the fallback roughly in sync, in case Redis goes away
+	return true
+}
+
+func (l *redisLimiter) Value() int64 {
+	key, _ := l.windowKey(time.Now())
+	replies, err := l.client.Do([]string{"GET", key})
+	if err != nil {
+		l.client.logError(err, "Unable to read rate limiter from Redis")
+		return l.fallback.Value()
+	} else if s, ok := replies[0].(string); ok {
+		value, _ := strconv.ParseInt(s, 10, 64)
+		return value
+	}
+	return l.initial.Load()
+}
+
+// Reset only resets the fallback limiter. The counter in Redis is shared with other servers, and starts over
+// automatically with the next window.
+func (l *redisLimiter) Reset() {
+	l.initial.Store(0)
+	l.fallback.Reset()
+}
+
+func (l *redisLimiter) windowKey(now time.Time) (string, time.Duration) {
+	start, end := l.window(now)
+	return fmt.Sprintf("%s:%d", l.key, start.Unix()), end.Sub(now) + time.Minute // Grace period for clock skew
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	sentryTimeout        = 5 * time.Second
+	sentryValueLimit     = 1024 // Characters, longer panic values and error messages are truncated
+	sentryFramesLimit    = 50
+	sentryInAppPrefix    = "heckel.io/ntfy/"
+	sentryLevelFatal     = "fatal"
+	sentryLevelError     = "error"
+	sentryEventTypePanic = "panic"
+)
+
+var (
+	errSentryDSNInvalid = errors.New("invalid Sentry DSN, expected format https://<key>@<host>/<project-id>")
+)
+
+// sentryReporter reports panics and internal server errors to Sentry (or any Sentry-compatible service, e.g.
+// GlitchTip), using the store endpoint.
+//
+// To protect the privacy of users, events are built from scratch rather than from the request: they never contain
+// message contents, request headers or bodies, query strings, topic names, or IP addresses. Only the HTTP method,
+// the first path segment if it is a fixed API prefix (e.g. "v1"), the panic value or error message (truncated),
+// and the stack trace are sent.
+//
+// See https://develop.sentry.dev/sdk/data-model/event-payloads/
+type sentryReporter struct {
+	storeURL    string
+	authHeader  string
+	release     string
+	environment string
+	serverName  string
+	httpClient  *http.Client
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Exception   *sentryExceptions `json:"exception"`
+}
+
+type sentryExceptions struct {
+	Values []*sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []*sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+func newSentryReporter(dsn, environment, version string) (*sentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User == nil || u.User.Username() == "" {
+		return nil, errSentryDSNInvalid
+	}
+	projectID := strings.TrimPrefix(u.Path, "/")
+	if projectID == "" || strings.HasSuffix(projectID, "/") {
+		return nil, errSentryDSNInvalid
+	}
+	path := ""
+	if i := strings.LastIndex(projectID, "/"); i >= 0 { // DSNs may have a path prefix, e.g. https://key@host/sentry/42
+		path, projectID = "/"+projectID[:i], projectID[i+1:]
+	}
+	serverName, _ := os.Hostname()
+	return &sentryReporter{
+		storeURL:    fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path, projectID),
+		authHeader:  fmt.Sprintf("Sentry sentry_version=7, sentry_client=ntfy/%s, sentry_key=%s", version, u.User.Username()),
+		release:     "ntfy@" + version,
+		environment: environment,
+		serverName:  serverName,
+		httpClient:  &http.Client{Timeout: sentryTimeout},
+	}, nil
+}
+
+// CapturePanic reports a recovered panic. It must be called from the deferred function that recovered the panic,
+// so that the stack trace still includes the panicking code. The report is sent synchronously, since the panic
+// may crash the process right after.
+func (r *sentryReporter) CapturePanic(recovered any, tags map[string]string) error {
+	return r.send(sentryLevelFatal, sentryEventTypePanic, fmt.Sprint(recovered), sentryStack(4), tags) // Skip runtime.gopanic
+}
+
+// CaptureError reports an internal server error, e.g. a failed database query. The stack trace is captured
+// right away, but the report is sent in the background, so that the HTTP response is not delayed.
+func (r *sentryReporter) CaptureError(err error, tags map[string]string) {
+	stack := sentryStack(3)
+	go func() {
+		if err := r.send(sentryLevelError, fmt.Sprintf("%T", err), err.Error(), stack, tags); err != nil {
+			log.Tag(tagSentry).Err(err).Warn("Unable to report error to Sentry")
+		}
+	}()
+}
+
+func (r *sentryReporter) send(level, typ, value string, stack *sentryStacktrace, tags map[string]string) error {
+	event := &sentryEvent{
+		EventID:     sentryEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "ntfy",
+		ServerName:  r.serverName,
+		Release:     r.release,
+		Environment: r.environment,
+		Tags:        tags,
+		Exception: &sentryExceptions{
+			Values: []*sentryException{
+				{
+					Type:       typ,
+					Value:      sentryTruncate(value),
+					Stacktrace: stack,
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.authHeader)
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected Sentry response: %s", resp.Status)
+	}
+	return nil
+}
+
+// sentryRequestTags returns the tags describing the HTTP request, without revealing the topic, see sentryReporter
+func sentryRequestTags(r *http.Request) map[string]string {
+	if r == nil {
+		return nil
+	}
+	route := "topic"
+	if first, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/"); first == "" {
+		route = "root"
+	} else if first == "v1" || first == "file" || first == "static" || first == "docs" || first == "app" || first == "_matrix" {
+		route = first
+	}
+	return map[string]string{
+		"http_method": r.Method,
+		"http_route":  route,
+	}
+}
+
+// sentryStack returns the stack trace of the caller, oldest frame first (as Sentry expects it)
+func sentryStack(skip int) *sentryStacktrace {
+	pcs := make([]uintptr, sentryFramesLimit)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	stack := make([]*sentryFrame, 0, n)
+	for {
+		frame, more := frames.Next()
+		module, function := sentrySplitFunction(frame.Function)
+		stack = append([]*sentryFrame{{
+			Function: function,
+			Module:   module,
+			Filename: frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.HasPrefix(frame.Function, sentryInAppPrefix),
+		}}, stack...)
+		if !more {
+			break
+		}
+	}
+	return &sentryStacktrace{Frames: stack}
+}
+
+// sentrySplitFunction splits a fully qualified function name into package and function,
+// e.g. "heckel.io/ntfy/v2/server.(*Server).handle" into "heckel.io/ntfy/v2/server" and "(*Server).handle"
+func sentrySplitFunction(name string) (string, string) {
+	lastSlash := strings.LastIndex(name, "/")
+	if i := strings.Index(name[lastSlash+1:], "."); i >= 0 {
+		return name[:lastSlash+1+i], name[lastSlash+2+i:]
+	}
+	return "", name
+}
+
+func sentryEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sentryTruncate(s string) string {
+	if utf8.RuneCountInString(s) > sentryValueLimit {
+		return string([]rune(s)[:sentryValueLimit]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestSentryReporter_DSN(t *testing.T) {
+	r, err := newSentryReporter("https://abc123@o42.ingest.sentry.io/4711", "production", "2.14.0")
+	require.Nil(t, err)
+	require.Equal(t, "https://o42.ingest.sentry.io/api/4711/store/", r.storeURL)
+	require.Equal(t, "Sentry sentry_version=7, sentry_client=ntfy/2.14.0, sentry_key=abc123", r.authHeader)
+	require.Equal(t, "ntfy@2.14.0", r.release)
+
+	r, err = newSentryReporter("http://abc123@glitchtip.example.com/sentry/3", "", "2.14.0")
+	require.Nil(t, err)
+	require.Equal(t, "http://glitchtip.example.com/sentry/api/3/store/", r.storeURL)
+
+	for _, dsn := range []string{"not a dsn", "https://o42.ingest.sentry.io/4711", "https://abc123@o42.ingest.sentry.io/", "ftp://abc123@host/1"} {
+		_, err := newSentryReporter(dsn, "", "2.14.0")
+		require.Equal(t, errSentryDSNInvalid, err, dsn)
+	}
+}
+
+func TestServer_Sentry_ReportPanic(t *testing.T) {
+	events := make(chan *sentryEvent, 1)
+	sentryServer := newTestSentryServer(t, events)
+
+	s := newTestServer(t, newTestConfig(t))
+	var err error
+	s.sentry, err = newSentryReporter(strings.Replace(sentryServer.URL, "http://", "http://key@", 1)+"/1", "test", "1.0.0")
+	require.Nil(t, err)
+
+	r, _ := http.NewRequest("PUT", "/mysecrettopic?message=secret+message", nil)
+	require.PanicsWithValue(t, "something went terribly wrong", func() {
+		defer s.reportPanic(r, "")
+		panic("something went terribly wrong")
+	})
+	event := <-events
+	require.Equal(t, "fatal", event.Level)
+	require.Equal(t, "test", event.Environment)
+	require.Equal(t, "ntfy@1.0.0", event.Release)
+	require.Equal(t, map[string]string{"http_method": "PUT", "http_route": "topic"}, event.Tags)
+	require.Equal(t, "panic", event.Exception.Values[0].Type)
+	require.Equal(t, "something went terribly wrong", event.Exception.Values[0].Value)
+	frames := event.Exception.Values[0].Stacktrace.Frames
+	require.True(t, frames[len(frames)-1].InApp)
+	require.Contains(t, frames[len(frames)-1].Function, "TestServer_Sentry_ReportPanic")
+
+	// Workers are tagged with their name
+	require.Panics(t, func() {
+		defer s.reportPanic(nil, "manager")
+		panic(errors.New("worker failed"))
+	})
+	event = <-events
+	require.Equal(t, map[string]string{"worker": "manager"}, event.Tags)
+	require.Equal(t, "worker failed", event.Exception.Values[0].Value)
+}
+
+func TestServer_Sentry_ReportError(t *testing.T) {
+	events := make(chan *sentryEvent, 1)
+	sentryServer := newTestSentryServer(t, events)
+
+	s := newTestServer(t, newTestConfig(t))
+	var err error
+	s.sentry, err = newSentryReporter(strings.Replace(sentryServer.URL, "http://", "http://key@", 1)+"/1", "", "1.0.0")
+	require.Nil(t, err)
+
+	// Expected errors are not reported
+	r, _ := http.NewRequest("GET", "/v1/account", nil)
+	s.handleError(httptest.NewRecorder(), r, newVisitor(s.config.Load(), s.messageCache, nil, nil, netip.MustParseAddr("9.9.9.9"), nil), errHTTPNotFound)
+	select {
+	case <-events:
+		t.Fatal("expected no event")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Internal errors are reported
+	s.handleError(httptest.NewRecorder(), r, newVisitor(s.config.Load(), s.messageCache, nil, nil, netip.MustParseAddr("9.9.9.9"), nil), errors.New("database is locked"))
+	event := <-events
+	require.Equal(t, "error", event.Level)
+	require.Equal(t, map[string]string{"http_method": "GET", "http_route": "v1"}, event.Tags)
+	require.Equal(t, "database is locked", event.Exception.Values[0].Value)
+}
+
+func TestSentryRequestTags(t *testing.T) {
+	for path, route := range map[string]string{
+		"/":                       "root",
+		"/mytopic":                "topic",
+		"/mytopic/json":           "topic",
+		"/v1/account":             "v1",
+		"/file/abc.png":           "file",
+		"/_matrix/push/v1/notify": "_matrix",
+	} {
+		r, _ := http.NewRequest("GET", path, nil)
+		require.Equal(t, route, sentryRequestTags(r)["http_route"], path)
+	}
+	require.Nil(t, sentryRequestTags(nil))
+}
+
+func newTestSentryServer(t *testing.T, events chan *sentryEvent) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/1/store/", r.URL.Path)
+		require.Contains(t, r.Header.Get("X-Sentry-Auth"), "sentry_key=key")
+		body, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		require.NotContains(t, string(body), "mysecrettopic")
+		require.NotContains(t, string(body), "secret message")
+		event, err := util.UnmarshalJSON[sentryEvent](io.NopCloser(strings.NewReader(string(body))))
+		require.Nil(t, err)
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
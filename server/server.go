@@ -23,6 +23,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 	"unicode/utf8"
@@ -30,7 +31,9 @@ import (
 	"github.com/emersion/go-smtp"
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
 	"gopkg.in/yaml.v2"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/payments"
@@ -41,29 +44,59 @@ import (
 
 // Server is the main server, providing the UI and API for ntfy
 type Server struct {
-	config            *Config
-	httpServer        *http.Server
-	httpsServer       *http.Server
-	httpMetricsServer *http.Server
-	httpProfileServer *http.Server
-	unixListener      net.Listener
-	smtpServer        *smtp.Server
-	smtpServerBackend *smtpBackend
-	smtpSender        mailer
-	topics            map[string]*topic
-	visitors          map[string]*visitor // ip:<ip> or user:<user>
-	firebaseClient    *firebaseClient
-	messages          int64                               // Total number of messages (persisted if messageCache enabled)
-	messagesHistory   []int64                             // Last n values of the messages counter, used to determine rate
-	userManager       *user.Manager                       // Might be nil!
-	messageCache      *messageCache                       // Database that stores the messages
-	webPush           *webPushStore                       // Database that stores web push subscriptions
-	fileCache         *fileCache                          // File system based cache that stores attachments
-	stripe            stripeAPI                           // Stripe API, can be replaced with a mock
-	priceCache        *util.LookupCache[map[string]int64] // Stripe price ID -> price as cents (USD implied!)
-	metricsHandler    http.Handler                        // Handles /metrics if enable-metrics set, and listen-metrics-http not set
-	closeChan         chan bool
-	mu                sync.RWMutex
+	config             atomic.Pointer[Config] // Swapped when the config is reloaded, see Reload
+	httpServer         *http.Server
+	httpsServer        *http.Server
+	httpMetricsServer  *http.Server
+	httpProfileServer  *http.Server
+	unixListener       net.Listener
+	tcpListener        net.Listener
+	stompListener      net.Listener
+	smtpServer         *smtp.Server
+	smtpServerBackend  *smtpBackend
+	grpcServer         *grpc.Server
+	smtpSender         atomic.Pointer[mailer] // Swapped when the config is reloaded, nil if smtp-sender-addr is not set; see emailSender
+	topics             map[string]*topic
+	visitors           map[string]*visitor // ip:<ip> or user:<user>
+	firebaseClient     *firebaseClient
+	messages           int64                               // Total number of messages (persisted if messageCache enabled)
+	messagesHistory    []int64                             // Last n values of the messages counter, used to determine rate
+	userManager        *user.Manager                       // Might be nil!
+	messageCache       *messageCache                       // Database that stores the messages
+	webPush            *webPushStore                       // Database that stores web push subscriptions
+	apns               *apnsClient                         // Sends notifications directly to iOS devices, may be nil
+	apnsStore          *apnsStore                          // Database that stores APNs device tokens, may be nil
+	fileCache          *fileCache                          // File system based cache that stores attachments
+	uploads            *uploadCache                        // Unfinished resumable uploads, nil if attachment-cache-dir is not set
+	attachmentScanner  attachmentScanner                   // Scans attachments for malware, nil if attachment-scan-url is not set
+	encryption         *topicEncryption                    // Encrypts messages and attachments of selected topics at rest, may be nil
+	webAuthnChallenges map[string][]*webAuthnChallenge     // User ID -> outstanding WebAuthn challenges, see require-admin-webauthn
+	statsCollector     *statsCollector                     // Collects hourly stats rollups, nil if userManager is nil
+	emailVerifications map[string]*emailVerification       // User ID -> pending email address change, see handleAccountEmailVerify
+	tokenAlerts        map[string]time.Time                // Access token -> time of the last alert, see auth-token-alerts
+	signatures         map[string]time.Time                // Message signature -> time it was used, to prevent replays, see verifyTopicSignature
+	deliveries         *deliveryStats                      // Successful and failed deliveries per channel, see admin dashboard
+	started            time.Time                           // Time the server was created, used to determine the uptime
+	cluster            *cluster                            // Replicates messages to cluster peers, nil if cluster-peers is not set
+	stripe             stripeAPI                           // Stripe API, can be replaced with a mock
+	priceCache         *util.LookupCache[map[string]int64] // Stripe price ID -> price as cents (USD implied!)
+	metricsHandler     http.Handler                        // Handles /metrics if enable-metrics set, and listen-metrics-http not set
+	webStaticHandler   *util.StaticHandler                 // Serves the web app, with ETags, caching and precompression
+	docsStaticHandler  *util.StaticHandler                 // Serves the docs, with ETags, caching and precompression
+	localizer          *localizer                          // Translates server-generated text, based on the user's language
+	linkPreviewer      *linkPreviewer                      // Fetches link previews, nil if enable-link-previews is not set
+	sentry             *sentryReporter                     // Reports panics and internal errors, nil if sentry-dsn is not set
+	accessLog          *accessLogger                       // Writes the JSON access log, nil if access-log-file is not set
+	redis              *redisClient                        // Shares visitor rate limits between servers, nil if visitor-limit-redis-url is not set
+	webhookSender      *webhookSender                      // Delivers messages to outgoing webhooks, nil if enable-webhooks is not set
+	matrixBridge       *matrixBridge                       // Relays messages to Matrix rooms, nil if matrix-bridge-rooms is not set
+	telegramRelay      *telegramRelay                      // Relays messages to Telegram, nil if neither telegram-relays nor enable-telegram-relays is set
+	dedup              *dedupCache                         // Suppresses messages with the same dedup key, nil if message-dedup-window is 0
+	bans               *banList                            // Banned IP ranges, users and tokens, nil if userManager is nil
+	geoip              geoIPLookup                         // Resolves the country and network of visitors, nil if neither geoip-database nor geoip-asn-database is set
+	acme               *autocert.Manager                   // Obtains TLS certificates via ACME, nil if acme-domains is not set
+	closeChan          chan bool
+	mu                 sync.RWMutex
 }
 
 // handleFunc extends the normal http.HandlerFunc to be able to easily return errors
@@ -80,6 +113,13 @@ var (
 	wsPathRegex            = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/ws$`)
 	authPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/auth$`)
 	publishPathRegex       = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/(publish|send|trigger)$`)
+	previewPathRegex       = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/preview$`)
+	qrCodePathRegex        = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/qr$`)
+	messageHTMLPathRegex   = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})/html$`)
+	ackPathRegex           = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/ack/([-_A-Za-z0-9]{1,64})$`)
+	messagePathRegex       = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{12})$`)
+	encryptionRegex        = regexp.MustCompile(`^[-a-z0-9]{1,32}$`) // Encryption scheme, e.g. aes256gcm
+	messageIDRegex         = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
 
 	webConfigPath                                        = "/config.js"
 	webManifestPath                                      = "/manifest.webmanifest"
@@ -90,27 +130,82 @@ var (
 	metricsPath                                          = "/metrics"
 	apiHealthPath                                        = "/v1/health"
 	apiStatsPath                                         = "/v1/stats"
+	apiStatsRollupsPath                                  = "/v1/stats/rollups"
+	apiClusterMessagesPath                               = "/v1/cluster/messages"
 	apiWebPushPath                                       = "/v1/webpush"
+	apiAPNSPath                                          = "/v1/apns"
 	apiTiersPath                                         = "/v1/tiers"
+	apiReportPath                                        = "/v1/report"
+	apiUploadsPath                                       = "/v1/uploads"
+	apiTopicsEphemeralPath                               = "/v1/topics/ephemeral"
 	apiUsersPath                                         = "/v1/users"
 	apiUsersAccessPath                                   = "/v1/users/access"
+	apiUsersPhonePath                                    = "/v1/users/phone"
+	apiUsersTokensPath                                   = "/v1/users/tokens"
+	apiAdminUsersPath                                    = "/v1/admin/users"
+	apiAdminAccessPath                                   = "/v1/admin/access"
+	apiAdminGroupsPath                                   = "/v1/admin/groups"
+	apiAdminGroupsMembersPath                            = "/v1/admin/groups/members"
+	apiAdminTokensPath                                   = "/v1/admin/tokens"
+	apiAdminTokensBulkPath                               = "/v1/admin/tokens/bulk"
+	apiAdminTokensInspectPath                            = "/v1/admin/tokens/inspect"
+	apiAdminAuthzExplainPath                             = "/v1/admin/authz/explain"
+	apiAdminTopicPoliciesPath                            = "/v1/admin/topic-policies"
+	apiAdminStatsUsagePath                               = "/v1/admin/stats/usage"
+	apiAdminTopicsPurgePath                              = "/v1/admin/topics/purge"
+	apiAdminTopicsClosePath                              = "/v1/admin/topics/close"
+	apiAdminReportsPath                                  = "/v1/admin/reports"
+	apiAdminBansPath                                     = "/v1/admin/bans"
+	apiAdminDashboardPath                                = "/v1/admin/dashboard"
 	apiAccountPath                                       = "/v1/account"
 	apiAccountTokenPath                                  = "/v1/account/token"
+	apiAccountTokenQRCodePath                            = "/v1/account/token/qrcode"
+	apiAccountTokenRefreshPath                           = "/v1/account/token/refresh"
 	apiAccountPasswordPath                               = "/v1/account/password"
 	apiAccountSettingsPath                               = "/v1/account/settings"
+	apiAccountStatsPath                                  = "/v1/account/stats"
+	apiAccountLimitsPath                                 = "/v1/account/limits"
 	apiAccountSubscriptionPath                           = "/v1/account/subscription"
 	apiAccountReservationPath                            = "/v1/account/reservation"
 	apiAccountPhonePath                                  = "/v1/account/phone"
 	apiAccountPhoneVerifyPath                            = "/v1/account/phone/verify"
+	apiAccountEmailPath                                  = "/v1/account/email"
+	apiAccountEmailVerifyPath                            = "/v1/account/email/verify"
+	apiAccountWebAuthnPath                               = "/v1/account/webauthn"
+	apiAccountWebAuthnChallengePath                      = "/v1/account/webauthn/challenge"
 	apiAccountBillingPortalPath                          = "/v1/account/billing/portal"
 	apiAccountBillingWebhookPath                         = "/v1/account/billing/webhook"
 	apiAccountBillingSubscriptionPath                    = "/v1/account/billing/subscription"
 	apiAccountBillingSubscriptionCheckoutSuccessTemplate = "/v1/account/billing/subscription/success/{CHECKOUT_SESSION_ID}"
 	apiAccountBillingSubscriptionCheckoutSuccessRegex    = regexp.MustCompile(`/v1/account/billing/subscription/success/(.+)$`)
 	apiAccountReservationSingleRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})$`)
+	apiAccountReservationEmailTemplateRegex              = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/email-template$`)
+	apiAccountReservationTemplateRegex                   = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/template$`)
+	apiAccountReservationPolicyRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/policy$`)
+	apiAccountReservationTelegramRegex                   = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/telegram$`)
+	apiAccountReservationIngestRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/ingest$`)
+	apiAccountReservationKeyRegex                        = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/key$`)
+	apiAccountReservationWebhooksRegex                   = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/webhooks$`)
+	apiAccountReservationWebhookRegex                    = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/webhooks/(wh_[A-Za-z0-9]{9})$`)
+	apiAccountReservationWebhookDeliveriesRegex          = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/webhooks/(wh_[A-Za-z0-9]{9})/deliveries$`)
+	apiAccountReservationSigningKeysRegex                = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/signing-keys$`)
+	apiAccountReservationSigningKeyRegex                 = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/signing-keys/(sk_[A-Za-z0-9]{9})$`)
+	apiAccountReservationTopicRegex                      = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/`)
+	apiTopicThreadRegex                                  = regexp.MustCompile(`^/v1/topic/([-_A-Za-z0-9]{1,64})/thread/([-_A-Za-z0-9]{1,64})$`)
+	apiTriggerRegex                                      = regexp.MustCompile(`^/v1/triggers/([-_A-Za-z0-9]{1,64})$`)
+	apiUploadRegex                                       = regexp.MustCompile(`^/v1/uploads/([-_A-Za-z0-9]{1,64})$`)
+	apiTriggerHooksRegex                                 = regexp.MustCompile(`^/v1/triggers/([-_A-Za-z0-9]{1,64})/hooks$`)
+	apiTriggerHookRegex                                  = regexp.MustCompile(`^/v1/triggers/([-_A-Za-z0-9]{1,64})/hooks/(wh_[A-Za-z0-9]{9})$`)
+	apiUnifiedPushRegex                                  = regexp.MustCompile(`^/v1/unifiedpush/([-_A-Za-z0-9]{1,64})$`)
+	apiTopicStatsRegex                                   = regexp.MustCompile(`^/v1/topic/([-_A-Za-z0-9]{1,64})/stats$`)
+	apiScheduledPath                                     = "/v1/scheduled"
+	apiHomeAssistantRegex                                = regexp.MustCompile(`^/v1/homeassistant/([-_A-Za-z0-9]{1,64})$`)
+	apiScheduledSingleRegex                              = regexp.MustCompile(`^/v1/scheduled/([-_A-Za-z0-9]{1,64})$`)
 	staticRegex                                          = regexp.MustCompile(`^/static/.+`)
 	docsRegex                                            = regexp.MustCompile(`^/docs(|/.*)$`)
 	fileRegex                                            = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})(?:\.[A-Za-z0-9]{1,16})?$`)
+	fileExtraRegex                                       = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})/([1-9])(?:\.[A-Za-z0-9]{1,16})?$`)
+	fileThumbRegex                                       = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})/thumb$`)
 	urlRegex                                             = regexp.MustCompile(`^https?://`)
 	phoneNumberRegex                                     = regexp.MustCompile(`^\+\d{1,100}$`)
 
@@ -145,9 +240,9 @@ const (
 	unifiedPushTopicPrefix   = "up"                      // Temporarily, we rate limit all "up*" topics based on the subscriber
 	unifiedPushTopicLength   = 14                        // Length of UnifiedPush topics, including the "up" part
 	messagesHistoryMax       = 10                        // Number of message count values to keep in memory
-	templateMaxExecutionTime = 100 * time.Millisecond    // Maximum time a template can take to execute, used to prevent DoS attacks
 	templateMaxOutputBytes   = 1024 * 1024               // Maximum number of bytes a template can output, used to prevent DoS attacks
 	templateFileExtension    = ".yml"                    // Template files must end with this extension
+	attachmentsPerMessageMax = 10                        // Max number of attachments per message (X-Attach or X-Upload)
 )
 
 // WebSocket constants
@@ -156,14 +251,22 @@ const (
 	wsBufferSize = 1024
 	wsReadLimit  = 64 // We only ever receive PINGs
 	wsPongWait   = 15 * time.Second
+
+	// Messages smaller than this are sent uncompressed, even if compression was negotiated,
+	// since the deflate overhead outweighs the savings (e.g. for keepalive or open events)
+	wsCompressionThreshold = 256
 )
 
 // New instantiates a new Server. It creates the cache and adds a Firebase
 // subscriber (if configured).
 func New(conf *Config) (*Server, error) {
+	localizer, err := newLocalizer()
+	if err != nil {
+		return nil, err
+	}
 	var mailer mailer
 	if conf.SMTPSenderAddr != "" {
-		mailer = &smtpSender{config: conf}
+		mailer = &smtpSender{config: conf, localizer: localizer}
 	}
 	var stripe stripeAPI
 	if payments.Available && conf.StripeSecretKey != "" {
@@ -173,6 +276,12 @@ func New(conf *Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	encryption, err := newTopicEncryption(conf.CacheEncryptionKey, conf.CacheEncryptedTopics)
+	if err != nil {
+		return nil, err
+	}
+	messageCache.encryption = encryption
+	messageCache.tombstoneDuration = conf.CacheTombstoneDuration
 	var webPush *webPushStore
 	if conf.WebPushPublicKey != "" {
 		webPush, err = newWebPushStore(conf.WebPushFile, conf.WebPushStartupQueries)
@@ -180,6 +289,18 @@ func New(conf *Config) (*Server, error) {
 			return nil, err
 		}
 	}
+	var apns *apnsClient
+	var apnsDevices *apnsStore
+	if conf.APNSKeyFile != "" {
+		apns, err = newAPNSClient(conf.APNSKeyFile, conf.APNSKeyID, conf.APNSTeamID, conf.APNSBundleID, conf.APNSSandbox)
+		if err != nil {
+			return nil, err
+		}
+		apnsDevices, err = newAPNSStore(conf.APNSFile)
+		if err != nil {
+			return nil, err
+		}
+	}
 	topics, err := messageCache.Topics()
 	if err != nil {
 		return nil, err
@@ -205,14 +326,22 @@ func New(conf *Config) (*Server, error) {
 			Users:               conf.AuthUsers,
 			Access:              conf.AuthAccess,
 			Tokens:              conf.AuthTokens,
+			ProvisionPrune:      conf.AuthPruneProvisioned,
 			BcryptCost:          conf.AuthBcryptCost,
 			QueueWriterInterval: conf.AuthStatsQueueWriterInterval,
+			AuthWebhookURL:      conf.AuthWebhookURL,
+			AuthWebhookTimeout:  conf.AuthWebhookTimeout,
+			AuthWebhookCacheTTL: conf.AuthWebhookCacheTTL,
 		}
 		userManager, err = user.NewManager(authConfig)
 		if err != nil {
 			return nil, err
 		}
 	}
+	var statsCollector *statsCollector
+	if userManager != nil {
+		statsCollector = newStatsCollector()
+	}
 	var firebaseClient *firebaseClient
 	if conf.FirebaseKeyFile != "" {
 		sender, err := newFirebaseSender(conf.FirebaseKeyFile)
@@ -227,21 +356,101 @@ func New(conf *Config) (*Server, error) {
 		}
 		firebaseClient = newFirebaseClient(sender, auther)
 	}
+	deliveries := newDeliveryStats()
 	s := &Server{
-		config:          conf,
-		messageCache:    messageCache,
-		webPush:         webPush,
-		fileCache:       fileCache,
-		firebaseClient:  firebaseClient,
-		smtpSender:      mailer,
-		topics:          topics,
-		userManager:     userManager,
-		messages:        messages,
-		messagesHistory: []int64{messages},
-		visitors:        make(map[string]*visitor),
-		stripe:          stripe,
+		messageCache:       messageCache,
+		webPush:            webPush,
+		apns:               apns,
+		apnsStore:          apnsDevices,
+		fileCache:          fileCache,
+		encryption:         encryption,
+		firebaseClient:     firebaseClient,
+		topics:             topics,
+		userManager:        userManager,
+		messages:           messages,
+		messagesHistory:    []int64{messages},
+		visitors:           make(map[string]*visitor),
+		webAuthnChallenges: make(map[string][]*webAuthnChallenge),
+		statsCollector:     statsCollector,
+		emailVerifications: make(map[string]*emailVerification),
+		tokenAlerts:        make(map[string]time.Time),
+		signatures:         make(map[string]time.Time),
+		deliveries:         deliveries,
+		started:            time.Now(),
+		cluster:            newCluster(conf, deliveries),
+		stripe:             stripe,
+		localizer:          localizer,
+	}
+	s.config.Store(conf)
+	s.setEmailSender(mailer)
+	if conf.EnableLinkPreviews {
+		s.linkPreviewer = newLinkPreviewer("ntfy/" + conf.Version)
+	}
+	if conf.EnableWebhooks && userManager != nil {
+		s.webhookSender, err = newWebhookSender("ntfy/"+conf.Version, conf.WebhookAllowPrivateNetworks, conf.WebhookTLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.MatrixBridgeRooms) > 0 {
+		s.matrixBridge = newMatrixBridge(conf)
+	}
+	if len(conf.TelegramRelays) > 0 || (conf.EnableTelegramRelays && userManager != nil) {
+		s.telegramRelay = newTelegramRelay()
+	}
+	if conf.MessageDedupWindow > 0 {
+		s.dedup = newDedupCache(conf.MessageDedupWindow)
+	}
+	if userManager != nil {
+		s.bans = newBanList()
+		s.reloadBans()
+	}
+	if conf.GeoIPDatabase != "" || conf.GeoIPASNDatabase != "" {
+		s.geoip, err = newGeoIPDatabase(conf.GeoIPDatabase, conf.GeoIPASNDatabase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fileCache != nil {
+		s.uploads = newUploadCache()
+	}
+	if fileCache != nil && conf.AttachmentScanURL != "" {
+		s.attachmentScanner, err = newAttachmentScanner(conf.AttachmentScanURL)
+		if err != nil {
+			return nil, err
+		}
+		if conf.AttachmentScanAction == AttachmentScanActionQuarantine {
+			if err := os.MkdirAll(conf.AttachmentQuarantineDir, 0700); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(conf.ACMEDomains) > 0 {
+		s.acme = newACMEManager(conf)
+	}
+	if conf.VisitorLimitRedisURL != "" {
+		s.redis, err = newRedisClient(conf.VisitorLimitRedisURL)
+		if err != nil {
+			return nil, err
+		} else if err := s.redis.Ping(); err != nil {
+			log.Tag(tagRedis).Err(err).Warn("Unable to connect to Redis, falling back to in-memory rate limiting until it is available")
+		}
+	}
+	if conf.SentryDSN != "" {
+		s.sentry, err = newSentryReporter(conf.SentryDSN, conf.SentryEnvironment, conf.Version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.AccessLogFile != "" {
+		s.accessLog, err = newAccessLogger(conf.AccessLogFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 	s.priceCache = util.NewLookupCache(s.fetchStripePrices, conf.StripePriceCacheDuration)
+	s.webStaticHandler = util.NewStaticHandler(webFsCached, s.staticCacheControl)
+	s.docsStaticHandler = util.NewStaticHandler(docsStaticCached, s.staticCacheControl)
 	return s, nil
 }
 
@@ -258,27 +467,36 @@ func createMessageCache(conf *Config) (*messageCache, error) {
 // a manager go routine to print stats and prune messages.
 func (s *Server) Run() error {
 	var listenStr string
-	if s.config.ListenHTTP != "" {
-		listenStr += fmt.Sprintf(" %s[http]", s.config.ListenHTTP)
+	if s.config.Load().ListenHTTP != "" {
+		listenStr += fmt.Sprintf(" %s[http]", s.config.Load().ListenHTTP)
+	}
+	if s.config.Load().ListenHTTPS != "" {
+		listenStr += fmt.Sprintf(" %s[https]", s.config.Load().ListenHTTPS)
 	}
-	if s.config.ListenHTTPS != "" {
-		listenStr += fmt.Sprintf(" %s[https]", s.config.ListenHTTPS)
+	if s.config.Load().ListenUnix != "" {
+		listenStr += fmt.Sprintf(" %s[unix]", s.config.Load().ListenUnix)
 	}
-	if s.config.ListenUnix != "" {
-		listenStr += fmt.Sprintf(" %s[unix]", s.config.ListenUnix)
+	if s.config.Load().SMTPServerListen != "" {
+		listenStr += fmt.Sprintf(" %s[smtp]", s.config.Load().SMTPServerListen)
 	}
-	if s.config.SMTPServerListen != "" {
-		listenStr += fmt.Sprintf(" %s[smtp]", s.config.SMTPServerListen)
+	if s.config.Load().ListenGRPC != "" {
+		listenStr += fmt.Sprintf(" %s[grpc]", s.config.Load().ListenGRPC)
 	}
-	if s.config.MetricsListenHTTP != "" {
-		listenStr += fmt.Sprintf(" %s[http/metrics]", s.config.MetricsListenHTTP)
+	if s.config.Load().ListenTCP != "" {
+		listenStr += fmt.Sprintf(" %s[tcp]", s.config.Load().ListenTCP)
 	}
-	if s.config.ProfileListenHTTP != "" {
-		listenStr += fmt.Sprintf(" %s[http/profile]", s.config.ProfileListenHTTP)
+	if s.config.Load().ListenSTOMP != "" {
+		listenStr += fmt.Sprintf(" %s[stomp]", s.config.Load().ListenSTOMP)
 	}
-	log.Tag(tagStartup).Info("Listening on%s, ntfy %s, log level is %s", listenStr, s.config.Version, log.CurrentLevel().String())
+	if s.config.Load().MetricsListenHTTP != "" {
+		listenStr += fmt.Sprintf(" %s[http/metrics]", s.config.Load().MetricsListenHTTP)
+	}
+	if s.config.Load().ProfileListenHTTP != "" {
+		listenStr += fmt.Sprintf(" %s[http/profile]", s.config.Load().ProfileListenHTTP)
+	}
+	log.Tag(tagStartup).Info("Listening on%s, ntfy %s, log level is %s", listenStr, s.config.Load().Version, log.CurrentLevel().String())
 	if log.IsFile() {
-		fmt.Fprintf(os.Stderr, "Listening on%s, ntfy %s\n", listenStr, s.config.Version)
+		fmt.Fprintf(os.Stderr, "Listening on%s, ntfy %s\n", listenStr, s.config.Load().Version)
 		fmt.Fprintf(os.Stderr, "Logs are written to %s\n", log.File())
 	}
 	mux := http.NewServeMux()
@@ -286,32 +504,42 @@ func (s *Server) Run() error {
 	errChan := make(chan error)
 	s.mu.Lock()
 	s.closeChan = make(chan bool)
-	if s.config.ListenHTTP != "" {
-		s.httpServer = &http.Server{Addr: s.config.ListenHTTP, Handler: mux}
+	if s.acme != nil {
+		log.Tag(tagStartup).Info("Obtaining TLS certificates via ACME for %s", strings.Join(s.config.Load().ACMEDomains, ", "))
+	}
+	if s.config.Load().ListenHTTP != "" {
+		var handler http.Handler = mux
+		if s.acme != nil {
+			handler = s.acme.HTTPHandler(mux) // Answers HTTP-01 challenges, passes everything else on to mux
+		}
+		s.httpServer = &http.Server{Addr: s.config.Load().ListenHTTP, Handler: handler}
 		go func() {
 			errChan <- s.httpServer.ListenAndServe()
 		}()
 	}
-	if s.config.ListenHTTPS != "" {
-		s.httpsServer = &http.Server{Addr: s.config.ListenHTTPS, Handler: mux}
+	if s.config.Load().ListenHTTPS != "" {
+		s.httpsServer = &http.Server{Addr: s.config.Load().ListenHTTPS, Handler: mux}
+		if s.acme != nil {
+			s.httpsServer.TLSConfig = s.acme.TLSConfig() // Answers TLS-ALPN-01 challenges
+		}
 		go func() {
-			errChan <- s.httpsServer.ListenAndServeTLS(s.config.CertFile, s.config.KeyFile)
+			errChan <- s.httpsServer.ListenAndServeTLS(s.config.Load().CertFile, s.config.Load().KeyFile) // Empty if ACME is used
 		}()
 	}
-	if s.config.ListenUnix != "" {
+	if s.config.Load().ListenUnix != "" {
 		go func() {
 			var err error
 			s.mu.Lock()
-			os.Remove(s.config.ListenUnix)
-			s.unixListener, err = net.Listen("unix", s.config.ListenUnix)
+			os.Remove(s.config.Load().ListenUnix)
+			s.unixListener, err = net.Listen("unix", s.config.Load().ListenUnix)
 			if err != nil {
 				s.mu.Unlock()
 				errChan <- err
 				return
 			}
 			defer s.unixListener.Close()
-			if s.config.ListenUnixMode > 0 {
-				if err := os.Chmod(s.config.ListenUnix, s.config.ListenUnixMode); err != nil {
+			if s.config.Load().ListenUnixMode > 0 {
+				if err := os.Chmod(s.config.Load().ListenUnix, s.config.Load().ListenUnixMode); err != nil {
 					s.mu.Unlock()
 					errChan <- err
 					return
@@ -322,38 +550,65 @@ func (s *Server) Run() error {
 			errChan <- httpServer.Serve(s.unixListener)
 		}()
 	}
-	if s.config.MetricsListenHTTP != "" {
-		initMetrics()
-		s.httpMetricsServer = &http.Server{Addr: s.config.MetricsListenHTTP, Handler: promhttp.Handler()}
+	if s.config.Load().MetricsListenHTTP != "" {
+		initMetrics(s.config.Load().MetricsCardinalityLimit)
+		s.httpMetricsServer = &http.Server{Addr: s.config.Load().MetricsListenHTTP, Handler: promhttp.Handler()}
 		go func() {
 			errChan <- s.httpMetricsServer.ListenAndServe()
 		}()
-	} else if s.config.EnableMetrics {
-		initMetrics()
+	} else if s.config.Load().EnableMetrics {
+		initMetrics(s.config.Load().MetricsCardinalityLimit)
 		s.metricsHandler = promhttp.Handler()
+	} else if s.config.Load().MetricsRemoteWriteURL != "" || s.config.Load().MetricsStatsdAddress != "" {
+		initMetrics(s.config.Load().MetricsCardinalityLimit) // Push only, metrics are not exposed via HTTP
 	}
-	if s.config.ProfileListenHTTP != "" {
+	if s.config.Load().ProfileListenHTTP != "" {
 		profileMux := http.NewServeMux()
 		profileMux.HandleFunc("/debug/pprof/", pprof.Index)
 		profileMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 		profileMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 		profileMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		profileMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-		s.httpProfileServer = &http.Server{Addr: s.config.ProfileListenHTTP, Handler: profileMux}
+		s.httpProfileServer = &http.Server{Addr: s.config.Load().ProfileListenHTTP, Handler: profileMux}
 		go func() {
 			errChan <- s.httpProfileServer.ListenAndServe()
 		}()
 	}
-	if s.config.SMTPServerListen != "" {
+	if s.config.Load().SMTPServerListen != "" {
 		go func() {
 			errChan <- s.runSMTPServer()
 		}()
 	}
+	if s.config.Load().ListenGRPC != "" {
+		grpcServer, err := s.newGRPCServer()
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.grpcServer = grpcServer
+		go func() {
+			errChan <- s.runGRPCServer(grpcServer)
+		}()
+	}
+	if s.config.Load().ListenTCP != "" {
+		go func() {
+			errChan <- s.runTCPServer()
+		}()
+	}
+	if s.config.Load().ListenSTOMP != "" {
+		go func() {
+			errChan <- s.runSTOMPServer()
+		}()
+	}
 	s.mu.Unlock()
-	go s.runManager()
-	go s.runStatsResetter()
-	go s.runDelayedSender()
-	go s.runFirebaseKeepaliver()
+	s.goReportPanics("manager", s.runManager)
+	s.goReportPanics("stats_resetter", s.runStatsResetter)
+	s.goReportPanics("delayed_sender", s.runDelayedSender)
+	s.goReportPanics("firebase_keepaliver", s.runFirebaseKeepaliver)
+	s.goReportPanics("metrics_pusher", s.runMetricsPusher)
+	if s.config.Load().WebRoot != "" {
+		go s.precompressStaticFiles()
+	}
 
 	return <-errChan
 }
@@ -371,9 +626,25 @@ func (s *Server) Stop() {
 	if s.unixListener != nil {
 		s.unixListener.Close()
 	}
+	if s.tcpListener != nil {
+		s.tcpListener.Close()
+	}
+	if s.stompListener != nil {
+		s.stompListener.Close()
+	}
 	if s.smtpServer != nil {
 		s.smtpServer.Close()
 	}
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+	s.cluster.Stop()
+	if s.redis != nil {
+		s.redis.Close()
+	}
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
 	s.closeDatabases()
 	close(s.closeChan)
 }
@@ -386,15 +657,29 @@ func (s *Server) closeDatabases() {
 	if s.webPush != nil {
 		s.webPush.Close()
 	}
+	if s.apnsStore != nil {
+		s.apnsStore.Close()
+	}
+	if s.geoip != nil {
+		s.geoip.Close()
+	}
 }
 
 // handle is the main entry point for all HTTP requests
 func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	defer s.reportPanic(r, "")
+	var v *visitor
+	if s.accessLog != nil {
+		start := time.Now()
+		w, r = s.accessLog.Wrap(w, r)
+		defer func() { s.accessLog.Log(w, r, v, start) }()
+	}
 	v, err := s.maybeAuthenticate(r) // Note: Always returns v, even when error is returned
 	if err != nil {
 		s.handleError(w, r, v, err)
 		return
 	}
+	s.statsCollector.Visit(v) // Works with nil receiver
 	ev := logvr(v, r)
 	if ev.IsTrace() {
 		ev.Field("http_request", renderHTTPRequest(r)).Trace("HTTP request started")
@@ -418,10 +703,14 @@ func (s *Server) handleError(w http.ResponseWriter, r *http.Request, v *visitor,
 	httpErr, ok := err.(*errHTTP)
 	if !ok {
 		httpErr = errHTTPInternalError
+		s.reportError(r, err)
 	}
 	if metricHTTPRequests != nil {
 		metricHTTPRequests.WithLabelValues(fmt.Sprintf("%d", httpErr.HTTPCode), fmt.Sprintf("%d", httpErr.Code), r.Method).Inc()
 	}
+	if aw, ok := w.(*accessLogResponseWriter); ok {
+		aw.errorCode = httpErr.Code
+	}
 	isRateLimiting := util.Contains(rateLimitingErrorCodes, httpErr.HTTPCode)
 	isNormalError := strings.Contains(err.Error(), "i/o timeout") || util.Contains(normalErrorCodes, httpErr.HTTPCode)
 	ev := logvr(v, r).Err(err)
@@ -440,20 +729,25 @@ func (s *Server) handleError(w http.ResponseWriter, r *http.Request, v *visitor,
 	} else {
 		ev.Info("Connection closed with HTTP %d (ntfy error %d)", httpErr.HTTPCode, httpErr.Code)
 	}
-	if isRateLimiting && s.config.StripeSecretKey != "" {
-		u := v.User()
+	u := v.User()
+	locale := s.localizer.Locale(u)
+	httpErr = locale.Error(httpErr) // Translated for the client only, the log entry above is in English
+	if isRateLimiting && s.config.Load().StripeSecretKey != "" {
 		if u == nil || u.Tier == nil {
-			httpErr = httpErr.Wrap("increase your limits with a paid plan, see %s", s.config.BaseURL)
+			httpErr = httpErr.Wrap("%s", locale.T("error_paid_plan", "url", s.config.Load().BaseURL))
 		}
 	}
+	if httpErr.HTTPCode == http.StatusTooManyRequests {
+		s.setRateLimitHeaders(w, v, httpErr.Code)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
 	w.WriteHeader(httpErr.HTTPCode)
 	io.WriteString(w, httpErr.JSON()+"\n")
 }
 
 func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	if r.Method == http.MethodGet && r.URL.Path == "/" && s.config.WebRoot == "/" {
+	if r.Method == http.MethodGet && r.URL.Path == "/" && s.config.Load().WebRoot == "/" {
 		return s.ensureWebEnabled(s.handleRoot)(w, r, v)
 	} else if r.Method == http.MethodHead && r.URL.Path == "/" {
 		return s.ensureWebEnabled(s.handleEmpty)(w, r, v)
@@ -464,17 +758,87 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 	} else if r.Method == http.MethodGet && r.URL.Path == webManifestPath {
 		return s.ensureWebPushEnabled(s.handleWebManifest)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiUsersPath {
-		return s.ensureAdmin(s.handleUsersGet)(w, r, v)
+		return s.ensureAdminOrSupport(s.handleUsersGet)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiUsersPath {
 		return s.ensureAdmin(s.handleUsersAdd)(w, r, v)
 	} else if r.Method == http.MethodPut && r.URL.Path == apiUsersPath {
-		return s.ensureAdmin(s.handleUsersUpdate)(w, r, v)
+		return s.ensureAdminOrSupport(s.handleUsersUpdate)(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersPath {
 		return s.ensureAdmin(s.handleUsersDelete)(w, r, v)
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiUsersAccessPath {
 		return s.ensureAdmin(s.handleAccessAllow)(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiUsersPhonePath {
+		return s.ensureAdmin(s.handleUsersPhoneNumberAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersPhonePath {
+		return s.ensureAdmin(s.handleUsersPhoneNumberDelete)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersTokensPath {
+		return s.ensureAdminOrSupport(s.handleUsersTokensDelete)(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersAccessPath {
 		return s.ensureAdmin(s.handleAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminUsersPath {
+		return s.ensureAdminOrSupport(s.handleUsersGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminUsersPath {
+		return s.ensureAdmin(s.handleUsersAdd)(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiAdminUsersPath {
+		return s.ensureAdminOrSupport(s.handleUsersUpdate)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminUsersPath {
+		return s.ensureAdmin(s.handleUsersDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminAccessPath {
+		return s.ensureAdmin(s.handleAccessGet)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiAdminAccessPath {
+		return s.ensureAdmin(s.handleAccessAllow)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminAccessPath {
+		return s.ensureAdmin(s.handleAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminGroupsPath {
+		return s.ensureAdmin(s.handleGroupsGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminGroupsPath {
+		return s.ensureAdmin(s.handleGroupsAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminGroupsPath {
+		return s.ensureAdmin(s.handleGroupsDelete)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiAdminGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupsMemberAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupsMemberDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminAuthzExplainPath {
+		return s.ensureAdmin(s.handleAuthzExplain)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminTopicPoliciesPath {
+		return s.ensureAdmin(s.handleTopicPoliciesGet)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiAdminTopicPoliciesPath {
+		return s.ensureAdmin(s.handleTopicPoliciesChange)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminTopicPoliciesPath {
+		return s.ensureAdmin(s.handleTopicPoliciesDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminStatsUsagePath {
+		return s.ensureAdmin(s.handleAdminStatsUsage)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminTopicsPurgePath {
+		return s.ensureAdmin(s.handleAdminTopicPurge)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminTopicsClosePath {
+		return s.ensureAdmin(s.handleAdminTopicClose)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminTopicsClosePath {
+		return s.ensureAdmin(s.handleAdminTopicReopen)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminReportsPath {
+		return s.ensureAdmin(s.handleAdminReportsGet)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminReportsPath {
+		return s.ensureAdmin(s.handleAdminReportsDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminBansPath {
+		return s.ensureAdmin(s.handleAdminBansGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminBansPath {
+		return s.ensureAdmin(s.handleAdminBansAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminBansPath {
+		return s.ensureAdmin(s.handleAdminBansDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminDashboardPath {
+		return s.ensureAdmin(s.handleAdminDashboard)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAdminTokensPath {
+		return s.ensureAdmin(s.handleUsersTokensGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminTokensPath {
+		return s.ensureAdmin(s.handleUsersTokensAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminTokensPath {
+		return s.ensureAdminOrSupport(s.handleUsersTokenDelete)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminTokensBulkPath {
+		return s.ensureAdmin(s.handleAdminTokensBulkCreate)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAdminTokensBulkPath {
+		return s.ensureAdmin(s.handleAdminTokensBulkDelete)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAdminTokensInspectPath {
+		return s.ensureAdmin(s.handleAdminTokenInspect)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPath {
 		return s.ensureUserManager(s.handleAccountCreate)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountPath {
@@ -485,10 +849,18 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.handleAccountPasswordChange)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTokenPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenCreate))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTokenRefreshPath {
+		return s.ensureUserManager(s.handleAccountTokenRefresh)(w, r, v) // Not authenticated, the access token may have expired
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTokenQRCodePath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTokenQRCode))(w, r, v)
 	} else if r.Method == http.MethodPatch && r.URL.Path == apiAccountTokenPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenUpdate))(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountTokenPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountStatsPath {
+		return s.ensureUser(s.handleAccountStats)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountLimitsPath {
+		return s.handleAccountLimitsGet(w, r, v) // Allowed by anonymous
 	} else if r.Method == http.MethodPatch && r.URL.Path == apiAccountSettingsPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountSettingsChange))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountSubscriptionPath {
@@ -501,6 +873,56 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationAdd))(w, r, v)
 	} else if r.Method == http.MethodDelete && apiAccountReservationSingleRegex.MatchString(r.URL.Path) {
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationEmailTemplateRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationEmailTemplateGet)(w, r, v)
+	} else if r.Method == http.MethodPut && apiAccountReservationEmailTemplateRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationEmailTemplateChange)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationEmailTemplateRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationEmailTemplateDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationTemplateRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationTemplateGet)(w, r, v)
+	} else if r.Method == http.MethodPut && apiAccountReservationTemplateRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationTemplateChange)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationTemplateRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationTemplateDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationPolicyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationPolicyGet)(w, r, v)
+	} else if r.Method == http.MethodPut && apiAccountReservationPolicyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationPolicyChange)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationPolicyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationPolicyDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationTelegramRegex.MatchString(r.URL.Path) {
+		return s.ensureTelegramRelaysEnabled(s.ensureUser(s.handleAccountReservationTelegramGet))(w, r, v)
+	} else if r.Method == http.MethodPut && apiAccountReservationTelegramRegex.MatchString(r.URL.Path) {
+		return s.ensureTelegramRelaysEnabled(s.ensureUser(s.handleAccountReservationTelegramChange))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationTelegramRegex.MatchString(r.URL.Path) {
+		return s.ensureTelegramRelaysEnabled(s.ensureUser(s.handleAccountReservationTelegramDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationIngestRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationIngestGet)(w, r, v)
+	} else if r.Method == http.MethodPut && apiAccountReservationIngestRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationIngestChange)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationIngestRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationIngestDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationKeyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationKeyGet)(w, r, v)
+	} else if r.Method == http.MethodPut && apiAccountReservationKeyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationKeyChange)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationKeyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationKeyDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationWebhooksRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhooksGet))(w, r, v)
+	} else if r.Method == http.MethodPost && apiAccountReservationWebhooksRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhookAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationWebhookRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhookDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationWebhookDeliveriesRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhookDeliveriesGet))(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationSigningKeysRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationSigningKeysGet)(w, r, v)
+	} else if r.Method == http.MethodPost && apiAccountReservationSigningKeysRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationSigningKeyAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationSigningKeyRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.handleAccountReservationSigningKeyDelete)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountBillingSubscriptionPath {
 		return s.ensurePaymentsEnabled(s.ensureUser(s.handleAccountBillingSubscriptionCreate))(w, r, v) // Account sync via incoming Stripe webhook
 	} else if r.Method == http.MethodGet && apiAccountBillingSubscriptionCheckoutSuccessRegex.MatchString(r.URL.Path) {
@@ -515,14 +937,38 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensurePaymentsEnabled(s.ensureUserManager(s.handleAccountBillingWebhook))(w, r, v) // This request comes from Stripe!
 	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountPhoneVerifyPath {
 		return s.ensureUser(s.ensureCallsEnabled(s.withAccountSync(s.handleAccountPhoneNumberVerify)))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountPhonePath {
+		return s.ensureUser(s.ensureCallsEnabled(s.handleAccountPhoneNumbersGet))(w, r, v)
 	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountPhonePath {
 		return s.ensureUser(s.ensureCallsEnabled(s.withAccountSync(s.handleAccountPhoneNumberAdd)))(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountPhonePath {
 		return s.ensureUser(s.ensureCallsEnabled(s.withAccountSync(s.handleAccountPhoneNumberDelete)))(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountEmailVerifyPath {
+		return s.ensureUser(s.ensureEmailsEnabled(s.handleAccountEmailVerify))(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountEmailPath {
+		return s.ensureUser(s.ensureEmailsEnabled(s.withAccountSync(s.handleAccountEmailChange)))(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountEmailPath {
+		return s.ensureUser(s.ensureEmailsEnabled(s.withAccountSync(s.handleAccountEmailDelete)))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountWebAuthnChallengePath {
+		return s.ensureUser(s.ensureAdminWebAuthnEnabled(s.handleAccountWebAuthnChallenge))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountWebAuthnPath {
+		return s.ensureUser(s.ensureAdminWebAuthnEnabled(s.handleAccountWebAuthnGet))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountWebAuthnPath {
+		return s.ensureUser(s.ensureAdminWebAuthnEnabled(s.handleAccountWebAuthnAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountWebAuthnPath {
+		return s.ensureUser(s.ensureAdminWebAuthnEnabled(s.handleAccountWebAuthnDelete))(w, r, v)
+	} else if (r.Method == http.MethodPost || r.Method == http.MethodPut) && apiAPNSPath == r.URL.Path {
+		return s.ensureAPNSEnabled(s.limitRequests(s.handleAPNSUpdate))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAPNSPath == r.URL.Path {
+		return s.ensureAPNSEnabled(s.limitRequests(s.handleAPNSDelete))(w, r, v)
 	} else if r.Method == http.MethodPost && apiWebPushPath == r.URL.Path {
 		return s.ensureWebPushEnabled(s.limitRequests(s.handleWebPushUpdate))(w, r, v)
 	} else if r.Method == http.MethodDelete && apiWebPushPath == r.URL.Path {
 		return s.ensureWebPushEnabled(s.limitRequests(s.handleWebPushDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiStatsRollupsPath {
+		return s.ensureAdmin(s.handleStatsRollups)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiClusterMessagesPath && s.cluster != nil {
+		return s.handleClusterMessages(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiStatsPath {
 		return s.handleStats(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiTiersPath {
@@ -535,7 +981,7 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureWebEnabled(s.handleStatic)(w, r, v)
 	} else if r.Method == http.MethodGet && docsRegex.MatchString(r.URL.Path) {
 		return s.ensureWebEnabled(s.handleDocs)(w, r, v)
-	} else if (r.Method == http.MethodGet || r.Method == http.MethodHead) && fileRegex.MatchString(r.URL.Path) && s.config.AttachmentCacheDir != "" {
+	} else if (r.Method == http.MethodGet || r.Method == http.MethodHead) && (fileRegex.MatchString(r.URL.Path) || fileExtraRegex.MatchString(r.URL.Path) || fileThumbRegex.MatchString(r.URL.Path)) && s.config.Load().AttachmentCacheDir != "" {
 		return s.limitRequests(s.handleFile)(w, r, v)
 	} else if r.Method == http.MethodOptions {
 		return s.limitRequests(s.handleOptions)(w, r, v) // Should work even if the web app is not enabled, see #598
@@ -543,10 +989,18 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.transformBodyJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish)))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == matrixPushPath {
 		return s.transformMatrixJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishMatrix)))(w, r, v)
+	} else if r.Method == http.MethodGet && apiHomeAssistantRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleHomeAssistantConfig)(w, r, v)
+	} else if r.Method == http.MethodPost && apiHomeAssistantRegex.MatchString(r.URL.Path) {
+		return s.transformHomeAssistantJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish)))(w, r, v)
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && topicPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
 	} else if r.Method == http.MethodGet && publishPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && previewPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishPreview))(w, r, v)
+	} else if r.Method == http.MethodGet && qrCodePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleTopicQRCode))(w, r, v)
 	} else if r.Method == http.MethodGet && jsonPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeJSON))(w, r, v)
 	} else if r.Method == http.MethodGet && ssePathRegex.MatchString(r.URL.Path) {
@@ -557,6 +1011,46 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeWS))(w, r, v)
 	} else if r.Method == http.MethodGet && authPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleTopicAuth))(w, r, v)
+	} else if r.Method == http.MethodGet && messageHTMLPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleMessageHTML))(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && ackPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleAck))(w, r, v)
+	} else if r.Method == http.MethodGet && ackPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleAcksGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiReportPath {
+		return s.limitRequests(s.handleReport)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiTopicsEphemeralPath {
+		return s.ensureUser(s.limitRequests(s.handleEphemeralTopicCreate))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiUploadsPath {
+		return s.limitRequests(s.handleUploadCreate)(w, r, v)
+	} else if (r.Method == http.MethodGet || r.Method == http.MethodHead) && apiUploadRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleUploadGet)(w, r, v)
+	} else if r.Method == http.MethodPatch && apiUploadRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleUploadPatch)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiUploadRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleUploadDelete)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && messagePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleMessageUpdate)(w, r, v)
+	} else if r.Method == http.MethodDelete && messagePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleMessageDelete)(w, r, v)
+	} else if r.Method == http.MethodGet && apiTopicThreadRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleTopicThread)(w, r, v)
+	} else if r.Method == http.MethodGet && apiTopicStatsRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleTopicStats)(w, r, v)
+	} else if r.Method == http.MethodGet && apiTriggerRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleTriggerPoll)(w, r, v)
+	} else if r.Method == http.MethodPost && apiTriggerRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleTriggerPollIFTTT)(w, r, v)
+	} else if r.Method == http.MethodPost && apiTriggerHooksRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleTriggerHookAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiTriggerHookRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleTriggerHookDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && apiUnifiedPushRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleUnifiedPushBudget)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiScheduledPath {
+		return s.limitRequests(s.handleScheduledGet)(w, r, v)
+	} else if r.Method == http.MethodDelete && apiScheduledSingleRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.handleScheduledDelete)(w, r, v)
 	} else if r.Method == http.MethodGet && (topicPathRegex.MatchString(r.URL.Path) || externalTopicPathRegex.MatchString(r.URL.Path)) {
 		return s.ensureWebEnabled(s.handleTopic)(w, r, v)
 	}
@@ -572,7 +1066,7 @@ func (s *Server) handleTopic(w http.ResponseWriter, r *http.Request, v *visitor)
 	unifiedpush := readBoolParam(r, false, "x-unifiedpush", "unifiedpush", "up") // see PUT/POST too!
 	if unifiedpush {
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+		w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
 		_, err := io.WriteString(w, `{"unifiedpush":{"version":1}}`+"\n")
 		return err
 	}
@@ -598,18 +1092,22 @@ func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request, _ *visitor
 func (s *Server) handleWebConfig(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
 	response := &apiConfigResponse{
 		BaseURL:            "", // Will translate to window.location.origin
-		AppRoot:            s.config.WebRoot,
-		EnableLogin:        s.config.EnableLogin,
-		RequireLogin:       s.config.RequireLogin,
-		EnableSignup:       s.config.EnableSignup,
-		EnablePayments:     s.config.StripeSecretKey != "",
-		EnableCalls:        s.config.TwilioAccount != "",
-		EnableEmails:       s.config.SMTPSenderFrom != "",
-		EnableReservations: s.config.EnableReservations,
-		EnableWebPush:      s.config.WebPushPublicKey != "",
-		BillingContact:     s.config.BillingContact,
-		WebPushPublicKey:   s.config.WebPushPublicKey,
-		DisallowedTopics:   s.config.DisallowedTopics,
+		AppRoot:            s.config.Load().WebRoot,
+		EnableLogin:        s.config.Load().EnableLogin,
+		RequireLogin:       s.config.Load().RequireLogin,
+		EnableSignup:       s.config.Load().EnableSignup,
+		EnablePayments:     s.config.Load().StripeSecretKey != "",
+		EnableCalls:        s.config.Load().TwilioAccount != "",
+		EnableEmails:       s.config.Load().SMTPSenderFrom != "",
+		EnableReservations: s.config.Load().EnableReservations,
+		EnableWebPush:      s.config.Load().WebPushPublicKey != "",
+		BillingContact:     s.config.Load().BillingContact,
+		WebPushPublicKey:   s.config.Load().WebPushPublicKey,
+		DisallowedTopics:   s.config.Load().DisallowedTopics,
+		AppName:            s.config.Load().WebAppName,
+		AppLogoURL:         s.config.Load().WebAppLogoURL,
+		AppAccentColor:     s.config.Load().WebAppAccentColor,
+		AppTermsURL:        s.config.Load().WebAppTermsURL,
 	}
 	b, err := json.MarshalIndent(response, "", "  ")
 	if err != nil {
@@ -628,7 +1126,7 @@ func (s *Server) handleWebManifest(w http.ResponseWriter, _ *http.Request, _ *vi
 		Description:     "ntfy lets you send push notifications via scripts from any computer or phone",
 		ShortName:       "ntfy",
 		Scope:           "/",
-		StartURL:        s.config.WebRoot,
+		StartURL:        s.config.Load().WebRoot,
 		Display:         "standalone",
 		BackgroundColor: "#ffffff",
 		ThemeColor:      "#317f6f",
@@ -637,6 +1135,13 @@ func (s *Server) handleWebManifest(w http.ResponseWriter, _ *http.Request, _ *vi
 			{SRC: "/static/images/pwa-512x512.png", Sizes: "512x512", Type: "image/png"},
 		},
 	}
+	if s.config.Load().WebAppName != "" {
+		response.Name = s.config.Load().WebAppName
+		response.ShortName = s.config.Load().WebAppName
+	}
+	if s.config.Load().WebAppAccentColor != "" {
+		response.ThemeColor = s.config.Load().WebAppAccentColor
+	}
 	return s.writeJSONWithContentType(w, response, "application/manifest+json")
 }
 
@@ -650,22 +1155,44 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request, _ *visito
 // handleStatic returns all static resources (excluding the docs), including the web app
 func (s *Server) handleStatic(w http.ResponseWriter, r *http.Request, _ *visitor) error {
 	r.URL.Path = webSiteDir + r.URL.Path
-	util.Gzip(http.FileServer(http.FS(webFsCached))).ServeHTTP(w, r)
+	s.webStaticHandler.ServeHTTP(w, r)
 	return nil
 }
 
 // handleDocs returns static resources related to the docs
 func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request, _ *visitor) error {
-	util.Gzip(http.FileServer(http.FS(docsStaticCached))).ServeHTTP(w, r)
+	s.docsStaticHandler.ServeHTTP(w, r)
 	return nil
 }
 
+// staticCacheControl returns the Cache-Control header for a static file. HTML pages and the service worker
+// must always be revalidated (cheap thanks to ETags), so that new releases are picked up right away.
+func (s *Server) staticCacheControl(name string) string {
+	if strings.HasSuffix(name, ".html") || name == strings.TrimPrefix(webSiteDir+webServiceWorkerPath, "/") {
+		return "no-cache"
+	}
+	return s.config.Load().WebStaticCacheControl
+}
+
+// precompressStaticFiles generates the compressed variants of all static files of the web app and the docs,
+// so that the first visitors do not have to wait for them to be compressed
+func (s *Server) precompressStaticFiles() {
+	start := time.Now()
+	if err := s.webStaticHandler.Precompress(); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Unable to precompress web app files")
+	}
+	if err := s.docsStaticHandler.Precompress(); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Unable to precompress docs files")
+	}
+	log.Tag(tagManager).Debug("Precompressed static files in %v", time.Since(start))
+}
+
 // handleStats returns the publicly available server stats
 func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
 	s.mu.RLock()
 	messages, n, rate := s.messages, len(s.messagesHistory), float64(0)
 	if n > 1 {
-		rate = float64(s.messagesHistory[n-1]-s.messagesHistory[0]) / (float64(n-1) * s.config.ManagerInterval.Seconds())
+		rate = float64(s.messagesHistory[n-1]-s.messagesHistory[0]) / (float64(n-1) * s.config.Load().ManagerInterval.Seconds())
 	}
 	s.mu.RUnlock()
 	response := &apiStatsResponse{
@@ -677,17 +1204,29 @@ func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request, _ *visitor)
 
 // handleFile processes the download of attachment files. The method handles GET and HEAD requests against a file.
 // Before streaming the file to a client, it locates uploader (m.Sender or m.User) in the message cache, so it
-// can associate the download bandwidth with the uploader.
+// can associate the download bandwidth with the uploader. Additional attachments of a message (see multiple
+// attachments) are served from /file/<message-id>/<index>.
 func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor) error {
-	if s.config.AttachmentCacheDir == "" {
+	if s.config.Load().AttachmentCacheDir == "" {
 		return errHTTPInternalError
 	}
-	matches := fileRegex.FindStringSubmatch(r.URL.Path)
-	if len(matches) != 2 {
+	var messageID string
+	var index int
+	var thumb bool
+	if matches := fileExtraRegex.FindStringSubmatch(r.URL.Path); len(matches) == 3 {
+		messageID = matches[1]
+		index, _ = strconv.Atoi(matches[2]) // Cannot fail, see regex
+	} else if matches := fileThumbRegex.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+		messageID, thumb = matches[1], true
+	} else if matches := fileRegex.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+		messageID = matches[1]
+	} else {
 		return errHTTPInternalErrorInvalidPath
 	}
-	messageID := matches[1]
-	file := filepath.Join(s.config.AttachmentCacheDir, messageID)
+	file := filepath.Join(s.config.Load().AttachmentCacheDir, messageID)
+	if index > 0 {
+		file = fmt.Sprintf("%s.%d", file, index)
+	}
 	stat, err := os.Stat(file)
 	if err != nil {
 		return errHTTPNotFound.Fields(log.Context{
@@ -695,23 +1234,15 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 			"error_context": "filesystem",
 		})
 	}
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
-	if r.Method == http.MethodHead {
-		return nil
-	}
-	// Find message in database, and associate bandwidth to the uploader user
-	// This is an easy way to
-	//   - avoid abuse (e.g. 1 uploader, 1k downloaders)
-	//   - and also uses the higher bandwidth limits of a paying user
+	// Find message in database, to check permissions and to associate bandwidth to the uploader user
 	m, err := s.messageCache.Message(messageID)
 	if errors.Is(err, errMessageNotFound) {
-		if s.config.CacheBatchTimeout > 0 {
+		if s.config.Load().CacheBatchTimeout > 0 {
 			// Strange edge case: If we immediately after upload request the file (the web app does this for images),
 			// and messages are persisted asynchronously, retry fetching from the database
 			m, err = util.Retry(func() (*message, error) {
 				return s.messageCache.Message(messageID)
-			}, s.config.CacheBatchTimeout, 100*time.Millisecond, 300*time.Millisecond, 600*time.Millisecond)
+			}, s.config.Load().CacheBatchTimeout, 100*time.Millisecond, 300*time.Millisecond, 600*time.Millisecond)
 		}
 		if err != nil {
 			return errHTTPNotFound.Fields(log.Context{
@@ -722,6 +1253,96 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 	} else if err != nil {
 		return err
 	}
+	a := m.Attachment
+	if index > 0 {
+		if index >= len(m.Attachments) {
+			return errHTTPNotFound.With(m)
+		}
+		a = m.Attachments[index]
+	}
+	if thumb && (a == nil || a.Thumbnail == "") {
+		return errHTTPNotFound.With(m) // Not an image, or published before thumbnails were introduced
+	}
+	var data []byte // Decrypted or resized attachment, served instead of the file
+	size := stat.Size()
+	if s.encryption.Enabled(m.Topic) {
+		// Encrypted attachments are only decrypted for visitors that are allowed to read the topic
+		if s.userManager != nil {
+			if err := s.userManager.Authorize(v.User(), m.Topic, user.PermissionRead); err != nil {
+				return errHTTPForbidden.With(m)
+			}
+		}
+		ciphertext, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		data, err = s.encryption.Decrypt(m.Topic, ciphertext)
+		if err != nil {
+			return err
+		}
+		size = int64(len(data))
+	}
+	// Attachments never change, so the message ID is a strong ETag. Together with the Last-Modified header, this
+	// allows clients to make conditional requests, and to resume downloads via range requests (If-Range).
+	etag, modTime := fmt.Sprintf(`"%s"`, m.ID), stat.ModTime()
+	if index > 0 {
+		etag = fmt.Sprintf(`"%s-%d"`, m.ID, index)
+	}
+	widthParam := readQueryParam(r, "w", "width")
+	if thumb {
+		widthParam = strconv.Itoa(imageThumbnailWidth)
+	}
+	if widthParam != "" && index == 0 {
+		resized, width, err := s.resizedAttachment(m, file, data, widthParam)
+		if err != nil {
+			return err
+		} else if resized != nil {
+			data, size, etag = resized, int64(len(resized)), fmt.Sprintf(`"%s-w%d"`, m.ID, width)
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("ETag", etag)
+	if r.Method != http.MethodHead {
+		if err := s.attachmentBandwidthAllowed(v, m, attachmentResponseLength(r, etag, modTime, size)); err != nil {
+			return err
+		}
+		if a != nil && a.Name != "" && !thumb {
+			w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(a.Name))
+		}
+	}
+	var content io.ReadSeeker
+	if data != nil {
+		content = bytes.NewReader(data)
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		content = f
+	}
+	// Detect the content type from the beginning of the file, since a range request may only send a part of it
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	} else if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", util.SafeContentType(head[:n], r.URL.Path))
+	http.ServeContent(w, r, "", modTime, content) // Handles HEAD, range and conditional requests
+	return nil
+}
+
+// attachmentBandwidthAllowed counts the given number of bytes against the bandwidth limits of the topic and the
+// uploader of the attachment. Downloads are associated with the uploader (if known) rather than the downloader.
+// This is an easy way to
+//   - avoid abuse (e.g. 1 uploader, 1k downloaders)
+//   - and also uses the higher bandwidth limits of a paying user
+func (s *Server) attachmentBandwidthAllowed(v *visitor, m *message, bytes int64) error {
+	if bytes == 0 {
+		return nil // Not modified, or unsatisfiable range
+	}
 	bandwidthVisitor := v
 	if s.userManager != nil && m.User != "" {
 		u, err := s.userManager.UserByID(m.User)
@@ -732,30 +1353,28 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 	} else if m.Sender.IsValid() {
 		bandwidthVisitor = s.visitor(m.Sender, nil)
 	}
-	if !bandwidthVisitor.BandwidthAllowed(stat.Size()) {
-		return errHTTPTooManyRequestsLimitAttachmentBandwidth.With(m)
-	}
-	// Actually send file
-	f, err := os.Open(file)
+	t, err := s.topicFromID(m.Topic)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	if m.Attachment.Name != "" {
-		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(m.Attachment.Name))
+	if !t.BandwidthAllowed(bytes, s.config.Load().TopicAttachmentDailyBandwidthLimit) {
+		maddTopic(metricTopicRateLimited, t.ID, 1)
+		return errHTTPTooManyRequestsLimitTopicBandwidth.With(m)
+	} else if !bandwidthVisitor.BandwidthAllowed(bytes) {
+		return errHTTPTooManyRequestsLimitAttachmentBandwidth.With(m)
 	}
-	_, err = io.Copy(util.NewContentTypeWriter(w, r.URL.Path), f)
-	return err
+	maddTopic(metricTopicAttachmentsDownloaded, t.ID, bytes)
+	return nil
 }
 
 func (s *Server) handleMatrixDiscovery(w http.ResponseWriter) error {
-	if s.config.BaseURL == "" {
+	if s.config.Load().BaseURL == "" {
 		return errHTTPInternalErrorMissingBaseURL
 	}
 	return writeMatrixDiscoveryResponse(w)
 }
 
-func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, error) {
+func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (_ *message, err error) {
 	start := time.Now()
 	t, err := fromContext[*topic](r, contextTopic)
 	if err != nil {
@@ -765,48 +1384,117 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 	if err != nil {
 		return nil, err
 	}
-	body, err := util.Peek(r.Body, s.config.MessageSizeLimit)
+	// Users without a tier share the visitor of their IP address, so v.User() may change while the message is
+	// delivered in the background. The user is captured here, and passed on wherever delivery preferences matter.
+	u := v.User()
+	preview, _ := fromContext[*apiPublishPreviewResponse](r, contextPublishPreview) // Only set for dry runs, see handlePublishPreview
+	body, err := util.Peek(r.Body, s.config.Load().MessageSizeLimit)
 	if err != nil {
 		return nil, err
 	}
+	if _, closed := t.ClosedUntil(); closed {
+		return nil, errHTTPForbiddenTopicClosed.With(t)
+	}
+	if body, err = s.verifyIngestSignature(r, v, t, body); err != nil {
+		return nil, err
+	}
 	m := newDefaultMessage(t.ID, "")
-	cache, firebase, email, call, template, unifiedpush, e := s.parsePublishParams(r, m)
+	cache, firebase, email, call, template, unifiedpush, e := s.parsePublishParams(r, v, m)
 	if e != nil {
 		return nil, e.With(t)
 	}
-	if unifiedpush && s.config.VisitorSubscriberRateLimiting && t.RateVisitor() == nil {
+	if m.ReplyTo != "" {
+		if err := s.checkReplyTo(m); err != nil {
+			return nil, err
+		}
+	}
+	if unifiedpush && s.config.Load().VisitorSubscriberRateLimiting && t.RateVisitor() == nil {
 		// UnifiedPush clients must subscribe before publishing to allow proper subscriber-based rate limiting.
 		// The 5xx response is because some app servers (in particular Mastodon) will remove
 		// the subscription as invalid if any 400-499 code (except 429/408) is returned.
 		// See https://github.com/mastodon/mastodon/blob/730bb3e211a84a2f30e3e2bbeae3f77149824a68/app/workers/web/push_notification_worker.rb#L35-L46
 		return nil, errHTTPInsufficientStorageUnifiedPush.With(t)
-	} else if !util.ContainsIP(s.config.VisitorRequestExemptPrefixes, v.ip) && !vrate.MessageAllowed() {
+	} else if preview == nil && !util.ContainsIP(s.config.Load().VisitorRequestExemptPrefixes, v.ip) && !s.messageAllowed(t, vrate) {
+		maddTopic(metricTopicRateLimited, t.ID, 1)
 		return nil, errHTTPTooManyRequestsLimitMessages.With(t)
-	} else if email != "" && !vrate.EmailAllowed() {
+	} else if preview == nil && !t.ThrottleAllowed() {
+		maddTopic(metricTopicRateLimited, t.ID, 1)
+		return nil, errHTTPTooManyRequestsLimitTopicThrottled.With(t)
+	} else if len(m.Channels) > 0 && s.userManager != nil && u == nil {
+		return nil, errHTTPBadRequestAnonymousChannelsNotAllowed.With(t)
+	} else if email != "" && !v.FeatureAllowed(user.TierFeatureEmails) {
+		return nil, errHTTPForbiddenTierFeature.Wrap("%s", user.TierFeatureEmails).With(t)
+	} else if call != "" && !v.FeatureAllowed(user.TierFeatureCalls) {
+		return nil, errHTTPForbiddenTierFeature.Wrap("%s", user.TierFeatureCalls).With(t)
+	} else if template.Enabled() && !v.FeatureAllowed(user.TierFeatureTemplates) {
+		return nil, errHTTPForbiddenTierFeature.Wrap("%s", user.TierFeatureTemplates).With(t)
+	} else if preview == nil && email != "" && !vrate.EmailAllowed() {
+		maddTopic(metricTopicRateLimited, t.ID, 1)
 		return nil, errHTTPTooManyRequestsLimitEmails.With(t)
 	} else if call != "" {
 		var httpErr *errHTTP
-		call, httpErr = s.convertPhoneNumber(v.User(), call)
+		call, httpErr = s.convertPhoneNumber(u, call)
 		if httpErr != nil {
 			return nil, httpErr.With(t)
-		} else if !vrate.CallAllowed() {
+		} else if preview == nil && !vrate.CallAllowed() {
+			maddTopic(metricTopicRateLimited, t.ID, 1)
 			return nil, errHTTPTooManyRequestsLimitCalls.With(t)
 		}
 	}
+	if m.Cron != "" {
+		if err := s.checkRecurringMessageLimit(v); err != nil {
+			return nil, err.With(t)
+		}
+	}
+	if dedupKey := readParam(r, "x-dedup-key", "dedup-key", "dedup"); dedupKey != "" && s.dedup != nil && preview == nil && m.PollID == "" && m.Time <= time.Now().Unix() {
+		if len(dedupKey) > dedupKeyLengthLimit {
+			return nil, errHTTPBadRequestDedupKeyInvalid.With(t)
+		}
+		if original, published, duplicate := s.dedup.Add(t.ID, dedupKey, m); duplicate {
+			return s.handlePublishDuplicate(r, v, t, original, published)
+		}
+		defer func() {
+			if err != nil {
+				s.dedup.Remove(t.ID, dedupKey)
+			} else {
+				s.dedup.Published(t.ID, dedupKey, m)
+			}
+		}()
+	}
 	if m.PollID != "" {
 		m = newPollRequestMessage(t.ID, m.PollID)
 	}
 	m.Sender = v.IP()
 	m.User = v.MaybeUserID()
 	if cache {
-		m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
+		expiry := v.Limits().MessageExpiryDuration
+		if policy := s.topicPolicy(v, m); policy != nil && policy.CacheDuration > 0 {
+			expiry = policy.CacheDuration
+		}
+		if m.TTL > 0 {
+			if m.TTL > int64(expiry.Seconds()) {
+				return nil, errHTTPBadRequestExpiresInvalid.With(t) // X-Expires can only shorten the cache duration
+			}
+			expiry = time.Duration(m.TTL) * time.Second
+		}
+		m.Expires = time.Unix(m.Time, 0).Add(expiry).Unix()
 	}
 	if err := s.handlePublishBody(r, v, m, body, template, unifiedpush); err != nil {
 		return nil, err
 	}
+	if err := s.verifyTopicSignature(m); err != nil {
+		return nil, err
+	}
 	if m.Message == "" {
 		m.Message = emptyMessageBody
 	}
+	if s.linkPreviewer != nil && !unifiedpush && m.PollID == "" {
+		s.maybeAddLinkPreview(v, m)
+	}
+	if preview != nil {
+		s.fillPublishPreview(preview, v, t, m, cache, firebase, unifiedpush, email, call)
+		return m, nil
+	}
 	delayed := m.Time > time.Now().Unix()
 	ev := logvrm(v, r, m).
 		Tag(tagPublish).
@@ -817,6 +1505,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 			"message_unifiedpush": unifiedpush,
 			"message_email":       email,
 			"message_call":        call,
+			"message_channels":    m.Channels,
 		})
 	if ev.IsTrace() {
 		ev.Field("message_body", util.MaybeMarshalJSON(m)).Trace("Received message")
@@ -827,21 +1516,34 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 		if err := t.Publish(v, m); err != nil {
 			return nil, err
 		}
+		s.cluster.Publish(m)
 		if s.firebaseClient != nil && firebase {
 			go s.sendToFirebase(v, m)
 		}
-		if s.smtpSender != nil && email != "" {
-			go s.sendEmail(v, m, email)
+		if s.apns != nil && firebase {
+			go s.publishToAPNS(v, m)
 		}
-		if s.config.TwilioAccount != "" && call != "" {
-			go s.callPhone(v, r, m, call)
+		if email != "" {
+			go s.sendEmail(v, u, m, email)
+		}
+		if s.config.Load().TwilioAccount != "" && call != "" {
+			go s.callPhone(v, u, r, m, call)
 		}
-		if s.config.UpstreamBaseURL != "" && !unifiedpush { // UP messages are not sent to upstream
+		if s.config.Load().UpstreamBaseURL != "" && !unifiedpush && m.channelAllowed(channelPush) { // UP messages are not sent to upstream
 			go s.forwardPollRequest(v, m)
 		}
-		if s.config.WebPushPublicKey != "" {
+		if s.config.Load().WebPushPublicKey != "" && m.channelAllowed(channelWebPush) {
 			go s.publishToWebPushEndpoints(v, m)
 		}
+		if s.webhookSender != nil && m.channelAllowed(channelWebhook) {
+			go s.publishToWebhooks(v, m)
+		}
+		if s.matrixBridge != nil && !unifiedpush && m.channelAllowed(channelMatrix) {
+			go s.relayToMatrix(v, m)
+		}
+		if s.telegramRelay != nil && !unifiedpush && m.channelAllowed(channelTelegram) {
+			go s.relayToTelegram(v, m)
+		}
 	} else {
 		logvrm(v, r, m).Tag(tagPublish).Debug("Message delayed, will process later")
 	}
@@ -851,13 +1553,22 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 			return nil, err
 		}
 	}
-	u := v.User()
 	if s.userManager != nil && u != nil && u.Tier != nil {
-		go s.userManager.EnqueueUserStats(u.ID, v.Stats())
+		stats := v.Stats()
+		go s.userManager.EnqueueUserStats(u.ID, stats)
+		if vrate == v && !util.ContainsIP(s.config.Load().VisitorRequestExemptPrefixes, v.ip) {
+			go s.maybeNotifyQuotaWarnings(v, u, stats, email != "")
+		}
+	}
+	maddTopic(metricTopicMessagesPublished, t.ID, 1)
+	if u != nil {
+		mincUser(metricUserMessagesPublished, u.Name)
 	}
 	s.mu.Lock()
 	s.messages++
 	s.mu.Unlock()
+	s.statsCollector.AddMessage()
+	s.statsCollector.AddPublish(v, m)
 	if unifiedpush {
 		minc(metricUnifiedPushPublishedSuccess)
 	}
@@ -904,6 +1615,7 @@ func (s *Server) sendToFirebase(v *visitor, m *message) {
 	logvm(v, m).Tag(tagFirebase).Debug("Publishing to Firebase")
 	if err := s.firebaseClient.Send(v, m); err != nil {
 		minc(metricFirebasePublishedFailure)
+		s.deliveries.Failure(deliveryChannelFirebase)
 		if errors.Is(err, errFirebaseTemporarilyBanned) {
 			logvm(v, m).Tag(tagFirebase).Err(err).Debug("Unable to publish to Firebase: %v", err.Error())
 		} else {
@@ -912,32 +1624,104 @@ func (s *Server) sendToFirebase(v *visitor, m *message) {
 		return
 	}
 	minc(metricFirebasePublishedSuccess)
+	s.deliveries.Success(deliveryChannelFirebase)
 }
 
-func (s *Server) sendEmail(v *visitor, m *message, email string) {
+// sendEmail forwards the message to the given email address, unless the publishing user u (may be nil) disabled
+// emails for the topic in their subscription preferences
+func (s *Server) sendEmail(v *visitor, u *user.User, m *message, email string) {
+	if !s.subscriptionChannelAllowed(u, m.Topic, channelEmail) {
+		logvm(v, m).Tag(tagEmail).Field("email", email).Debug("Not sending email, disabled in subscription preferences")
+		return
+	}
+	sender := s.emailSender()
+	if sender == nil {
+		logvm(v, m).Tag(tagEmail).Field("email", email).Debug("Not sending email, outgoing emails are disabled")
+		return
+	}
 	logvm(v, m).Tag(tagEmail).Field("email", email).Debug("Sending email to %s", email)
-	if err := s.smtpSender.Send(v, m, email); err != nil {
+	if err := sender.Send(v, s.emailMessage(v, m), email); err != nil {
 		logvm(v, m).Tag(tagEmail).Field("email", email).Err(err).Warn("Unable to send email to %s: %v", email, err.Error())
 		minc(metricEmailsPublishedFailure)
+		s.deliveries.Failure(deliveryChannelEmail)
 		return
 	}
+	s.statsCollector.AddEmail()
 	minc(metricEmailsPublishedSuccess)
+	s.deliveries.Success(deliveryChannelEmail)
+}
+
+// emailSender returns the sender for outgoing emails, or nil if smtp-sender-addr is not set. The sender may be
+// replaced when the config is reloaded (see Reload), so callers must only call this once, and use the returned sender.
+func (s *Server) emailSender() mailer {
+	if sender := s.smtpSender.Load(); sender != nil {
+		return *sender
+	}
+	return nil
+}
+
+// setEmailSender replaces the sender for outgoing emails; nil disables outgoing emails
+func (s *Server) setEmailSender(sender mailer) {
+	if sender == nil {
+		s.smtpSender.Store(nil)
+		return
+	}
+	s.smtpSender.Store(&sender)
+}
+
+// emailMessage returns the message to be forwarded via email. If the topic owner defined an email template,
+// the subject (title) and body (message) are rendered from the template, with the message as JSON input. Tags and
+// priority are then not appended by the mailer, since the template is responsible for the entire layout.
+//
+// If there is no template, or if the template cannot be rendered, the original message is returned.
+func (s *Server) emailMessage(v *visitor, m *message) *message {
+	if s.userManager == nil {
+		return m
+	}
+	tpl, err := s.userManager.EmailTemplate(m.Topic)
+	if errors.Is(err, user.ErrEmailTemplateNotFound) {
+		return m
+	} else if err != nil {
+		logvm(v, m).Tag(tagEmail).Err(err).Warn("Unable to read email template, using default layout")
+		return m
+	}
+	source, err := json.Marshal(m)
+	if err != nil {
+		logvm(v, m).Tag(tagEmail).Err(err).Warn("Unable to render email template, using default layout")
+		return m
+	}
+	em := *m // Shallow copy, the original message must not be changed
+	if tpl.Subject != "" {
+		if em.Title, err = s.renderTemplate(tpl.Subject, string(source)); err != nil {
+			logvm(v, m).Tag(tagEmail).Err(err).Warn("Unable to render email subject template, using default layout")
+			return m
+		}
+	}
+	if tpl.Body != "" {
+		if em.Message, err = s.renderTemplate(tpl.Body, string(source)); err != nil {
+			logvm(v, m).Tag(tagEmail).Err(err).Warn("Unable to render email body template, using default layout")
+			return m
+		}
+		em.Tags = nil
+		em.Priority = 0
+	}
+	return &em
 }
 
 func (s *Server) forwardPollRequest(v *visitor, m *message) {
-	topicURL := fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic)
+	topicURL := fmt.Sprintf("%s/%s", s.config.Load().BaseURL, m.Topic)
 	topicHash := fmt.Sprintf("%x", sha256.Sum256([]byte(topicURL)))
-	forwardURL := fmt.Sprintf("%s/%s", s.config.UpstreamBaseURL, topicHash)
+	forwardURL := fmt.Sprintf("%s/%s", s.config.Load().UpstreamBaseURL, topicHash)
 	logvm(v, m).Debug("Publishing poll request to %s", forwardURL)
 	req, err := http.NewRequest("POST", forwardURL, strings.NewReader(""))
 	if err != nil {
 		logvm(v, m).Err(err).Warn("Unable to publish poll request")
 		return
 	}
-	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Load().Version)
 	req.Header.Set("X-Poll-ID", m.ID)
-	if s.config.UpstreamAccessToken != "" {
-		req.Header.Set("Authorization", util.BearerAuth(s.config.UpstreamAccessToken))
+	if s.config.Load().UpstreamAccessToken != "" {
+		req.Header.Set("Authorization", util.BearerAuth(s.config.Load().UpstreamAccessToken))
 	}
 	var httpClient = &http.Client{
 		Timeout: time.Second * 10,
@@ -948,45 +1732,57 @@ func (s *Server) forwardPollRequest(v *visitor, m *message) {
 		return
 	} else if response.StatusCode != http.StatusOK {
 		if response.StatusCode == http.StatusTooManyRequests {
-			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s; you may solve this by sending fewer daily messages, or by configuring upstream-access-token (assuming you have an account with higher rate limits) ", s.config.UpstreamBaseURL, response.Status)
+			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s; you may solve this by sending fewer daily messages, or by configuring upstream-access-token (assuming you have an account with higher rate limits) ", s.config.Load().UpstreamBaseURL, response.Status)
 		} else {
-			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s", s.config.UpstreamBaseURL, response.Status)
+			logvm(v, m).Err(err).Warn("Unable to publish poll request, the upstream server %s responded with HTTP %s", s.config.Load().UpstreamBaseURL, response.Status)
 		}
 		return
 	}
 }
 
-func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, firebase bool, email, call string, template templateMode, unifiedpush bool, err *errHTTP) {
+func (s *Server) parsePublishParams(r *http.Request, v *visitor, m *message) (cache bool, firebase bool, email, call string, template templateMode, unifiedpush bool, err *errHTTP) {
 	cache = readBoolParam(r, true, "x-cache", "cache")
 	firebase = readBoolParam(r, true, "x-firebase", "firebase")
 	m.Title = readParam(r, "x-title", "title", "t")
 	m.Click = readParam(r, "x-click", "click")
 	icon := readParam(r, "x-icon", "icon")
 	filename := readParam(r, "x-filename", "filename", "file", "f")
-	attach := readParam(r, "x-attach", "attach", "a")
-	if attach != "" || filename != "" {
+	attach := readParams(r, "x-attach", "attach", "a")
+	if len(attach) > attachmentsPerMessageMax {
+		return false, false, "", "", "", false, errHTTPBadRequestAttachmentsTooMany
+	}
+	if len(attach) > 0 || filename != "" {
 		m.Attachment = &attachment{}
 	}
 	if filename != "" {
-		m.Attachment.Name = filename
+		m.Attachment.Name = filename // Only applies to the first attachment
 	}
-	if attach != "" {
-		if !urlRegex.MatchString(attach) {
+	attachments := make([]*attachment, 0, len(attach))
+	for i, attachURL := range attach {
+		if !urlRegex.MatchString(attachURL) {
 			return false, false, "", "", "", false, errHTTPBadRequestAttachmentURLInvalid
 		}
-		m.Attachment.URL = attach
-		if m.Attachment.Name == "" {
-			u, err := url.Parse(m.Attachment.URL)
+		a := m.Attachment
+		if i > 0 {
+			a = &attachment{}
+		}
+		a.URL = attachURL
+		if a.Name == "" {
+			u, err := url.Parse(a.URL)
 			if err == nil {
-				m.Attachment.Name = path.Base(u.Path)
-				if m.Attachment.Name == "." || m.Attachment.Name == "/" {
-					m.Attachment.Name = ""
+				a.Name = path.Base(u.Path)
+				if a.Name == "." || a.Name == "/" {
+					a.Name = ""
 				}
 			}
 		}
-		if m.Attachment.Name == "" {
-			m.Attachment.Name = "attachment"
+		if a.Name == "" {
+			a.Name = "attachment"
 		}
+		attachments = append(attachments, a)
+	}
+	if len(attachments) > 1 {
+		m.Attachments = attachments
 	}
 	if icon != "" {
 		if !urlRegex.MatchString(icon) {
@@ -995,15 +1791,27 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		m.Icon = icon
 	}
 	email = readParam(r, "x-email", "x-e-mail", "email", "e-mail", "mail", "e")
-	if s.smtpSender == nil && email != "" {
+	if s.emailSender() == nil && email != "" {
 		return false, false, "", "", "", false, errHTTPBadRequestEmailDisabled
 	}
 	call = readParam(r, "x-call", "call")
-	if call != "" && (s.config.TwilioAccount == "" || s.userManager == nil) {
+	if call != "" && (s.config.Load().TwilioAccount == "" || s.userManager == nil) {
 		return false, false, "", "", "", false, errHTTPBadRequestPhoneCallsDisabled
 	} else if call != "" && !isBoolValue(call) && !phoneNumberRegex.MatchString(call) {
 		return false, false, "", "", "", false, errHTTPBadRequestPhoneNumberInvalid
 	}
+	var e error
+	m.Channels, e = parseChannels(readCommaSeparatedParam(r, "x-channels", "channels"))
+	if e != nil {
+		return false, false, "", "", "", false, errHTTPBadRequestChannelsInvalid.Wrap("%s", e.Error())
+	}
+	firebase = firebase && m.channelAllowed(channelPush)
+	if !m.channelAllowed(channelEmail) {
+		email = ""
+	}
+	if !m.channelAllowed(channelCall) {
+		call = ""
+	}
 	template = templateMode(readParam(r, "x-template", "template", "tpl"))
 	messageStr := readParam(r, "x-message", "message", "m")
 	if !template.InlineMode() {
@@ -1013,7 +1821,6 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 	if messageStr != "" {
 		m.Message = messageStr
 	}
-	var e error
 	m.Priority, e = util.ParsePriority(readParam(r, "x-priority", "priority", "prio", "p"))
 	if e != nil {
 		return false, false, "", "", "", false, errHTTPBadRequestPriorityInvalid
@@ -1033,13 +1840,24 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		delay, err := util.ParseFutureTime(delayStr, time.Now())
 		if err != nil {
 			return false, false, "", "", "", false, errHTTPBadRequestDelayCannotParse
-		} else if delay.Unix() < time.Now().Add(s.config.MessageDelayMin).Unix() {
+		} else if delay.Unix() < time.Now().Add(s.config.Load().MessageDelayMin).Unix() {
 			return false, false, "", "", "", false, errHTTPBadRequestDelayTooSmall
-		} else if delay.Unix() > time.Now().Add(s.config.MessageDelayMax).Unix() {
+		} else if delay.Unix() > time.Now().Add(v.FeatureDuration(user.TierFeatureMaxDelay, s.config.Load().MessageDelayMax)).Unix() {
 			return false, false, "", "", "", false, errHTTPBadRequestDelayTooLarge
 		}
 		m.Time = delay.Unix()
 	}
+	expiresStr := readParam(r, "x-expires", "expires", "x-ttl", "ttl")
+	if expiresStr != "" {
+		if !cache {
+			return false, false, "", "", "", false, errHTTPBadRequestExpiresNoCache
+		}
+		expires, err := util.ParseFutureTime(expiresStr, time.Unix(m.Time, 0)) // Durations are relative to the delivery time
+		if err != nil || expires.Unix() <= m.Time {
+			return false, false, "", "", "", false, errHTTPBadRequestExpiresInvalid
+		}
+		m.TTL = expires.Unix() - m.Time
+	}
 	actionsStr := readParam(r, "x-actions", "actions", "action")
 	if actionsStr != "" {
 		m.Actions, e = parseActions(actionsStr)
@@ -1048,8 +1866,10 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		}
 	}
 	contentType, markdown := readParam(r, "content-type", "content_type"), readBoolParam(r, false, "x-markdown", "markdown", "md")
-	if markdown || strings.ToLower(contentType) == "text/markdown" {
-		m.ContentType = "text/markdown"
+	if markdown || strings.ToLower(contentType) == contentTypeTextMarkdown {
+		m.ContentType = contentTypeTextMarkdown
+	} else if !strings.HasPrefix(strings.ToLower(contentType), contentTypeTextPlain) && readParam(r, "x-signature", "signature") == "" && s.topicDefaultContentType(m.Topic) == contentTypeTextMarkdown {
+		m.ContentType = contentTypeTextMarkdown // Topic default, unless explicitly set to text/plain, or signed (see topic-content-types)
 	}
 	unifiedpush = readBoolParam(r, false, "x-unifiedpush", "unifiedpush", "up") // see GET too!
 	contentEncoding := readParam(r, "content-encoding")
@@ -1063,6 +1883,46 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		cache = false
 		email = ""
 	}
+	cronStr := strings.TrimSpace(readParam(r, "x-cron", "cron"))
+	if cronStr != "" {
+		if !cache || delayStr != "" || email != "" || call != "" || m.PollID != "" {
+			return false, false, "", "", "", false, errHTTPBadRequestCronNotAllowed
+		}
+		schedule, err := util.ParseCron(cronStr)
+		if err != nil {
+			return false, false, "", "", "", false, errHTTPBadRequestCronInvalid.Wrap("%s", err.Error())
+		}
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return false, false, "", "", "", false, errHTTPBadRequestCronInvalid.Wrap("expression never matches")
+		}
+		m.Cron = cronStr
+		m.Time = next.Unix()
+	}
+	m.ReplyTo = readParam(r, "x-reply-to", "reply-to")
+	if m.ReplyTo != "" {
+		if !messageIDRegex.MatchString(m.ReplyTo) {
+			return false, false, "", "", "", false, errHTTPBadRequestReplyToInvalid
+		} else if m.PollID != "" {
+			return false, false, "", "", "", false, errHTTPBadRequestReplyToInvalid.Wrap("poll requests cannot be replies")
+		}
+	}
+	m.Encryption = strings.ToLower(readParam(r, "x-encryption", "encryption"))
+	if m.Encryption != "" {
+		if !encryptionRegex.MatchString(m.Encryption) {
+			return false, false, "", "", "", false, errHTTPBadRequestEncryptionInvalid
+		} else if template.Enabled() || email != "" || call != "" || (m.Attachment != nil && m.Attachment.URL == "") {
+			return false, false, "", "", "", false, errHTTPBadRequestEncryptionNotAllowed
+		}
+	}
+	m.Signature = readParam(r, "x-signature", "signature")
+	if m.Signature != "" {
+		if !signatureRegex.MatchString(m.Signature) {
+			return false, false, "", "", "", false, errHTTPBadRequestSignatureInvalid
+		} else if template.Enabled() || (m.Attachment != nil && m.Attachment.URL == "") {
+			return false, false, "", "", "", false, errHTTPBadRequestSignatureNotAllowed
+		}
+	}
 	return cache, firebase, email, call, template, unifiedpush, nil
 }
 
@@ -1072,31 +1932,43 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 //     If a message is flagged as poll request, the body does not matter and is discarded
 //  2. curl -T somebinarydata.bin "ntfy.sh/mytopic?up=1"
 //     If UnifiedPush is enabled, encode as base64 if body is binary, and do not trim
-//  3. curl -H "Attach: http://example.com/file.jpg" ntfy.sh/mytopic
+//  3. curl -H "Encryption: aes256gcm" -d "<ciphertext>" ntfy.sh/mytopic
+//     If the message is encrypted client-side, the body is the ciphertext, and must not be truncated
+//  4. curl -H "Upload: up_3Xa0pQf8LmZbT" -d "Here's the video" ntfy.sh/mytopic
+//     Body must be a message, because the attachment was uploaded before (see resumable uploads)
+//  5. curl -H "Attach: http://example.com/file.jpg" ntfy.sh/mytopic
 //     Body must be a message, because we attached an external URL
-//  4. curl -T short.txt -H "Filename: short.txt" ntfy.sh/mytopic
+//  6. curl -T short.txt -H "Filename: short.txt" ntfy.sh/mytopic
 //     Body must be attachment, because we passed a filename
-//  5. curl -H "Template: yes" -T file.txt ntfy.sh/mytopic
+//  7. curl -H "Template: yes" -T file.txt ntfy.sh/mytopic
 //     If templating is enabled, read up to 32k and treat message body as JSON
-//  6. curl -T file.txt ntfy.sh/mytopic
+//  8. curl -d '{"status":"down"}' ntfy.sh/mytopic
+//     If the topic owner stored a template for the topic, and the body is JSON, apply the stored template
+//  9. curl -T file.txt ntfy.sh/mytopic
 //     If file.txt is <= 4096 (message limit) and valid UTF-8, treat it as a message
-//  7. curl -T file.txt ntfy.sh/mytopic
+//  10. curl -T file.txt ntfy.sh/mytopic
 //     In all other cases, mostly if file.txt is > message limit, treat it as an attachment
 func (s *Server) handlePublishBody(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, template templateMode, unifiedpush bool) error {
 	if m.Event == pollRequestEvent { // Case 1
 		return s.handleBodyDiscard(body)
 	} else if unifiedpush {
 		return s.handleBodyAsMessageAutoDetect(m, body) // Case 2
+	} else if m.Encryption != "" {
+		return s.handleBodyAsEncryptedMessage(m, body) // Case 3
+	} else if uploadID := readParam(r, "x-upload", "upload"); uploadID != "" {
+		return s.handleBodyAsUpload(r, v, m, body, uploadID) // Case 4
 	} else if m.Attachment != nil && m.Attachment.URL != "" {
-		return s.handleBodyAsTextMessage(m, body) // Case 3
+		return s.handleBodyAsTextMessage(m, body) // Case 5
 	} else if m.Attachment != nil && m.Attachment.Name != "" {
-		return s.handleBodyAsAttachment(r, v, m, body) // Case 4
+		return s.handleBodyAsAttachment(r, v, m, body) // Case 6
 	} else if template.Enabled() {
-		return s.handleBodyAsTemplatedTextMessage(m, template, body) // Case 5
+		return s.handleBodyAsTemplatedTextMessage(m, template, body) // Case 7
+	} else if tpl := s.topicTemplate(v, m, body); tpl != nil {
+		return s.handleBodyAsTopicTemplatedMessage(m, tpl, body) // Case 8
 	} else if !body.LimitReached && utf8.Valid(body.PeekedBytes) {
-		return s.handleBodyAsTextMessage(m, body) // Case 6
+		return s.handleBodyAsTextMessage(m, body) // Case 9
 	}
-	return s.handleBodyAsAttachment(r, v, m, body) // Case 7
+	return s.handleBodyAsAttachment(r, v, m, body) // Case 10
 }
 
 func (s *Server) handleBodyDiscard(body *util.PeekedReadCloser) error {
@@ -1128,8 +2000,25 @@ func (s *Server) handleBodyAsTextMessage(m *message, body *util.PeekedReadCloser
 	return nil
 }
 
+// handleBodyAsEncryptedMessage uses the body as the (client-side encrypted) message. Unlike plain text messages,
+// the ciphertext cannot be truncated, and is never turned into an attachment.
+func (s *Server) handleBodyAsEncryptedMessage(m *message, body *util.PeekedReadCloser) error {
+	if body.LimitReached {
+		return errHTTPEntityTooLargeEncryptedMessage.With(m)
+	} else if !utf8.Valid(body.PeekedBytes) {
+		return errHTTPBadRequestMessageNotUTF8.With(m)
+	}
+	if len(body.PeekedBytes) > 0 { // Message may have been passed via X-Message header
+		m.Message = strings.TrimSpace(string(body.PeekedBytes))
+	}
+	if m.Message == "" {
+		return errHTTPBadRequestEncryptedMessageEmpty.With(m)
+	}
+	return nil
+}
+
 func (s *Server) handleBodyAsTemplatedTextMessage(m *message, template templateMode, body *util.PeekedReadCloser) error {
-	body, err := util.Peek(body, max(s.config.MessageSizeLimit, jsonBodyBytesLimit))
+	body, err := util.Peek(body, max(s.config.Load().MessageSizeLimit, jsonBodyBytesLimit))
 	if err != nil {
 		return err
 	} else if body.LimitReached {
@@ -1145,7 +2034,91 @@ func (s *Server) handleBodyAsTemplatedTextMessage(m *message, template templateM
 			return err
 		}
 	}
-	if len(m.Title) > s.config.MessageSizeLimit || len(m.Message) > s.config.MessageSizeLimit {
+	if len(m.Title) > s.config.Load().MessageSizeLimit || len(m.Message) > s.config.Load().MessageSizeLimit {
+		return errHTTPBadRequestTemplateMessageTooLarge
+	}
+	return nil
+}
+
+// topicPolicy returns the retention and size policy of the message's topic, or nil if there is none
+func (s *Server) topicPolicy(v *visitor, m *message) *user.TopicPolicy {
+	if s.userManager == nil {
+		return nil
+	}
+	policy, err := s.userManager.TopicPolicy(m.Topic)
+	if errors.Is(err, user.ErrTopicPolicyNotFound) {
+		return nil
+	} else if err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Warn("Unable to read topic policy, using default limits")
+		return nil
+	}
+	return policy
+}
+
+// topicTemplate returns the template the topic owner stored for the message's topic, if the body looks like
+// a JSON object or array. It returns nil if there is no template, or if the body is not JSON.
+func (s *Server) topicTemplate(v *visitor, m *message, body *util.PeekedReadCloser) *user.TopicTemplate {
+	if s.userManager == nil {
+		return nil
+	}
+	trimmed := bytes.TrimSpace(body.PeekedBytes)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') || !utf8.Valid(trimmed) {
+		return nil
+	}
+	tpl, err := s.userManager.TopicTemplate(m.Topic)
+	if errors.Is(err, user.ErrTopicTemplateNotFound) {
+		return nil
+	} else if err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Warn("Unable to read topic template, publishing message as is")
+		return nil
+	}
+	return tpl
+}
+
+// handleBodyAsTopicTemplatedMessage transforms the JSON message body according to the template stored by the topic
+// owner, see topicTemplate. If the body turns out not to be JSON after all, it is treated like a regular message.
+func (s *Server) handleBodyAsTopicTemplatedMessage(m *message, tpl *user.TopicTemplate, body *util.PeekedReadCloser) error {
+	limitReached := body.LimitReached
+	body, err := util.Peek(body, max(s.config.Load().MessageSizeLimit, jsonBodyBytesLimit))
+	if err != nil {
+		return err
+	} else if body.LimitReached {
+		return errHTTPEntityTooLargeJSONBody
+	} else if !json.Valid(body.PeekedBytes) {
+		if limitReached {
+			return errHTTPBadRequestTemplateMessageNotJSON
+		}
+		return s.handleBodyAsTextMessage(m, body)
+	}
+	peekedBody := strings.TrimSpace(string(body.PeekedBytes))
+	if tpl.Message != "" {
+		if m.Message, err = s.renderTemplate(tpl.Message, peekedBody); err != nil {
+			return err
+		}
+	}
+	if tpl.Title != "" {
+		if m.Title, err = s.renderTemplate(tpl.Title, peekedBody); err != nil {
+			return err
+		}
+	}
+	if tpl.Tags != "" {
+		tags, err := s.renderTemplate(tpl.Tags, peekedBody)
+		if err != nil {
+			return err
+		}
+		m.Tags = append(m.Tags, util.Map(util.SplitNoEmpty(tags, ","), strings.TrimSpace)...)
+	}
+	if tpl.Priority != "" {
+		priority, err := s.renderTemplate(tpl.Priority, peekedBody)
+		if err != nil {
+			return err
+		} else if priority != "" {
+			if m.Priority, err = util.ParsePriority(priority); err != nil {
+				return errHTTPBadRequestPriorityInvalid
+			}
+		}
+	}
+	if len(m.Title) > s.config.Load().MessageSizeLimit || len(m.Message) > s.config.Load().MessageSizeLimit {
 		return errHTTPBadRequestTemplateMessageTooLarge
 	}
 	return nil
@@ -1158,14 +2131,20 @@ func (s *Server) renderTemplateFromFile(m *message, templateName, peekedBody str
 		return errHTTPBadRequestTemplateFileNotFound
 	}
 	templateContent, _ := templatesFs.ReadFile(filepath.Join(templatesDir, templateName+templateFileExtension)) // Read from the embedded filesystem first
-	if s.config.TemplateDir != "" {
-		if b, _ := os.ReadFile(filepath.Join(s.config.TemplateDir, templateName+templateFileExtension)); len(b) > 0 {
+	if s.config.Load().TemplateDir != "" {
+		if b, _ := os.ReadFile(filepath.Join(s.config.Load().TemplateDir, templateName+templateFileExtension)); len(b) > 0 {
 			templateContent = b
 		}
 	}
 	if len(templateContent) == 0 {
 		return errHTTPBadRequestTemplateFileNotFound
 	}
+	return s.renderTemplateFileContent(m, templateContent, peekedBody)
+}
+
+// renderTemplateFileContent transforms the JSON message body according to the given template file content,
+// see templateFile
+func (s *Server) renderTemplateFileContent(m *message, templateContent []byte, peekedBody string) error {
 	var tpl templateFile
 	if err := yaml.Unmarshal(templateContent, &tpl); err != nil {
 		return errHTTPBadRequestTemplateFileInvalid
@@ -1184,6 +2163,23 @@ func (s *Server) renderTemplateFromFile(m *message, templateName, peekedBody str
 	return nil
 }
 
+// RenderTemplateFile renders the title and message of a template file (see template-dir) with the given JSON
+// payload, using the template limits of the given config. It is used to test templates outside the server,
+// see "ntfy template test".
+func RenderTemplateFile(conf *Config, templateContent []byte, payload string) (string, string, error) {
+	s := &Server{}
+	s.config.Store(conf)
+	m := &message{}
+	if err := s.renderTemplateFileContent(m, templateContent, strings.TrimSpace(payload)); err != nil {
+		var e *errHTTP
+		if errors.As(err, &e) {
+			return "", "", errors.New(e.Message)
+		}
+		return "", "", err
+	}
+	return m.Title, m.Message, nil
+}
+
 // renderTemplateFromParams transforms the JSON message body according to the inline template in the
 // message and title parameters.
 func (s *Server) renderTemplateFromParams(m *message, peekedBody string) error {
@@ -1206,68 +2202,150 @@ func (s *Server) renderTemplate(tpl string, source string) (string, error) {
 	if err := json.Unmarshal([]byte(source), &data); err != nil {
 		return "", errHTTPBadRequestTemplateMessageNotJSON
 	}
-	t, err := template.New("").Funcs(sprig.TxtFuncMap()).Parse(tpl)
+	limits := sprig.Limits{
+		LoopExecutionLimit: s.config.Load().TemplateLoopLimit,
+		StringLengthLimit:  s.config.Load().TemplateStringLimit,
+	}
+	t, err := template.New("").Funcs(sprig.TxtFuncMapWithLimits(limits)).Parse(tpl)
 	if err != nil {
 		return "", errHTTPBadRequestTemplateInvalid.Wrap("%s", err.Error())
 	}
 	var buf bytes.Buffer
-	limitWriter := util.NewLimitWriter(util.NewTimeoutWriter(&buf, templateMaxExecutionTime), util.NewFixedLimiter(templateMaxOutputBytes))
+	limitWriter := util.NewLimitWriter(util.NewTimeoutWriter(&buf, s.config.Load().TemplateTimeout), util.NewFixedLimiter(templateMaxOutputBytes))
 	if err := t.Execute(limitWriter, data); err != nil {
 		return "", errHTTPBadRequestTemplateExecuteFailed.Wrap("%s", err.Error())
 	}
 	return strings.TrimSpace(strings.ReplaceAll(buf.String(), "\\n", "\n")), nil // replace any remaining "\n" (those outside of template curly braces) with newlines
 }
 
+// validateTemplate checks that a template can be parsed, and that it does not contain disallowed
+// function calls. It does not execute the template.
+func validateTemplate(tpl string) error {
+	if templateDisallowedRegex.MatchString(tpl) {
+		return errHTTPBadRequestTemplateDisallowedFunctionCalls
+	}
+	if _, err := template.New("").Funcs(sprig.TxtFuncMap()).Parse(tpl); err != nil {
+		return errHTTPBadRequestTemplateInvalid.Wrap("%s", err.Error())
+	}
+	return nil
+}
+
 func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser) error {
-	if s.fileCache == nil || s.config.BaseURL == "" || s.config.AttachmentCacheDir == "" {
+	if s.fileCache == nil || s.config.Load().BaseURL == "" || s.config.Load().AttachmentCacheDir == "" {
 		return errHTTPBadRequestAttachmentsDisallowed.With(m)
 	}
 	vinfo, err := v.Info()
 	if err != nil {
 		return err
 	}
-	attachmentExpiry := time.Now().Add(vinfo.Limits.AttachmentExpiryDuration).Unix()
-	if m.Time > attachmentExpiry {
+	attachmentFileSizeLimit := s.attachmentFileSizeLimit(v, vinfo, m)
+	if m.Attachment == nil {
+		m.Attachment = &attachment{}
+	}
+	var ext string
+	m.Attachment.Type, ext = util.DetectContentType(body.PeekedBytes, m.Attachment.Name)
+	if m.Attachment.Name == "" {
+		m.Attachment.Name = fmt.Sprintf("attachment%s", ext)
+	}
+	attachmentExpiry := time.Now().Add(s.attachmentExpiryDuration(vinfo.Limits, m.Attachment)).Unix()
+	if m.TTL > 0 {
+		attachmentExpiry = min(attachmentExpiry, m.Expires) // Attachment is deleted with the message, see X-Expires
+	}
+	if m.Cron != "" {
+		return errHTTPBadRequestCronNotAllowed.With(m) // Occurrences would outlive the attachment
+	} else if m.Time > attachmentExpiry {
 		return errHTTPBadRequestAttachmentsExpiryBeforeDelivery.With(m)
 	}
 	contentLengthStr := r.Header.Get("Content-Length")
 	if contentLengthStr != "" { // Early "do-not-trust" check, hard limit see below
 		contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
-		if err == nil && (contentLength > vinfo.Stats.AttachmentTotalSizeRemaining || contentLength > vinfo.Limits.AttachmentFileSizeLimit) {
+		if err == nil && (contentLength > vinfo.Stats.AttachmentTotalSizeRemaining || contentLength > attachmentFileSizeLimit) {
 			return errHTTPEntityTooLargeAttachment.With(m).Fields(log.Context{
 				"message_content_length":          contentLength,
 				"attachment_total_size_remaining": vinfo.Stats.AttachmentTotalSizeRemaining,
-				"attachment_file_size_limit":      vinfo.Limits.AttachmentFileSizeLimit,
+				"attachment_file_size_limit":      attachmentFileSizeLimit,
 			})
 		}
 	}
-	if m.Attachment == nil {
-		m.Attachment = &attachment{}
-	}
-	var ext string
 	m.Attachment.Expires = attachmentExpiry
-	m.Attachment.Type, ext = util.DetectContentType(body.PeekedBytes, m.Attachment.Name)
-	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, m.ID, ext)
-	if m.Attachment.Name == "" {
-		m.Attachment.Name = fmt.Sprintf("attachment%s", ext)
-	}
+	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.config.Load().BaseURL, m.ID, ext)
 	if m.Message == "" {
 		m.Message = fmt.Sprintf(defaultAttachmentMessage, m.Attachment.Name)
 	}
 	limiters := []util.Limiter{
 		v.BandwidthLimiter(),
-		util.NewFixedLimiter(vinfo.Limits.AttachmentFileSizeLimit),
+		util.NewFixedLimiter(attachmentFileSizeLimit),
 		util.NewFixedLimiter(vinfo.Stats.AttachmentTotalSizeRemaining),
 	}
-	m.Attachment.Size, err = s.fileCache.Write(m.ID, body, limiters...)
+	var header imageHeader
+	in := withImageHeader(m.Attachment, body, &header)
+	preview, _ := fromContext[*apiPublishPreviewResponse](r, contextPublishPreview)
+	if preview != nil {
+		m.Attachment.Size, err = io.Copy(util.NewLimitWriter(io.Discard, limiters[1:]...), in) // Attachment is not stored, and does not count towards the bandwidth limit
+	} else if s.encryption.Enabled(m.Topic) {
+		m.Attachment.Size, err = s.writeEncryptedAttachment(m, 0, in, limiters...)
+	} else {
+		m.Attachment.Size, err = s.fileCache.Write(m.ID, in, limiters...)
+	}
 	if errors.Is(err, util.ErrLimitReached) {
 		return errHTTPEntityTooLargeAttachment.With(m)
 	} else if err != nil {
 		return err
+	} else if preview == nil {
+		if err := s.scanAttachment(r, v, m, 0, m.Attachment); err != nil {
+			return err
+		}
 	}
+	s.setImageMetadata(m, 0, m.Attachment, &header)
+	maddTopic(metricTopicAttachmentsSize, m.Topic, m.Attachment.Size)
 	return nil
 }
 
+// attachmentFileSizeLimit returns the maximum size of a single attachment, which is the visitor's limit, or the
+// limit of the topic policy if it is lower
+func (s *Server) attachmentFileSizeLimit(v *visitor, vinfo *visitorInfo, m *message) int64 {
+	limit := vinfo.Limits.AttachmentFileSizeLimit
+	if policy := s.topicPolicy(v, m); policy != nil && policy.AttachmentFileSizeLimit > 0 {
+		limit = min(limit, policy.AttachmentFileSizeLimit)
+	}
+	return limit
+}
+
+// attachmentExpiryDuration returns the duration after which the attachment expires. The first matching attachment
+// expiry rule takes precedence over the visitor's attachment expiry duration.
+func (s *Server) attachmentExpiryDuration(limits *visitorLimits, a *attachment) time.Duration {
+	for _, rule := range s.config.Load().AttachmentExpiryRules {
+		if rule.Matches(a.Type, a.Name) {
+			return rule.Duration
+		}
+	}
+	return limits.AttachmentExpiryDuration
+}
+
+// writeEncryptedAttachment reads the attachment into memory (applying the limiters to the plaintext size),
+// encrypts it with the topic key, and writes it to the file cache. The index is the position of the attachment
+// in the message, see handleBodyAsUpload. It returns the plaintext size.
+func (s *Server) writeEncryptedAttachment(m *message, index int, body io.Reader, limiters ...util.Limiter) (int64, error) {
+	var plaintext bytes.Buffer
+	size, err := io.Copy(util.NewLimitWriter(&plaintext, limiters...), body)
+	if err != nil {
+		return 0, err
+	}
+	ciphertext, err := s.encryption.Encrypt(m.Topic, plaintext.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if index > 0 {
+		_, err = s.fileCache.WriteExtra(m.ID, index, bytes.NewReader(ciphertext))
+	} else {
+		_, err = s.fileCache.Write(m.ID, bytes.NewReader(ciphertext))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
 func (s *Server) handleSubscribeJSON(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	encoder := func(msg *message) (string, error) {
 		var buf bytes.Buffer
@@ -1347,13 +2425,14 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 	if err := s.maybeSetRateVisitors(r, v, topics); err != nil {
 		return err
 	}
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
-	w.Header().Set("Content-Type", contentType+"; charset=utf-8")                    // Android/Volley client needs charset!
+	s.statsCollector.AddSubscribe(v, topics)
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")                           // Android/Volley client needs charset!
 	if poll {
 		for _, t := range topics {
 			t.Keepalive()
 		}
-		return s.sendOldMessages(topics, since, scheduled, v, sub)
+		return s.sendOldMessages(topics, since, scheduled, filters.Deleted, v, sub)
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -1369,7 +2448,7 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 	if err := sub(v, newOpenMessage(topicsStr)); err != nil { // Send out open message
 		return err
 	}
-	if err := s.sendOldMessages(topics, since, scheduled, v, sub); err != nil {
+	if err := s.sendOldMessages(topics, since, scheduled, filters.Deleted, v, sub); err != nil {
 		return err
 	}
 	for {
@@ -1378,7 +2457,7 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 			return nil
 		case <-r.Context().Done():
 			return nil
-		case <-time.After(s.config.KeepaliveInterval):
+		case <-time.After(s.config.Load().KeepaliveInterval):
 			ev := logvr(v, r).Tag(tagSubscribe)
 			if len(topics) == 1 {
 				ev.With(topics[0]).Trace("Sending keepalive message to %s", topics[0].ID)
@@ -1415,8 +2494,9 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		return err
 	}
 	upgrader := &websocket.Upgrader{
-		ReadBufferSize:  wsBufferSize,
-		WriteBufferSize: wsBufferSize,
+		ReadBufferSize:    wsBufferSize,
+		WriteBufferSize:   wsBufferSize,
+		EnableCompression: s.config.Load().WebSocketCompression,
 		CheckOrigin: func(r *http.Request) bool {
 			return true // We're open for business!
 		},
@@ -1426,6 +2506,11 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		return err
 	}
 	defer conn.Close()
+	if s.config.Load().WebSocketCompression {
+		if err := conn.SetCompressionLevel(s.config.Load().WebSocketCompressionLevel); err != nil {
+			return err
+		}
+	}
 
 	// Subscription connections can be canceled externally, see topic.CancelSubscribersExceptUser
 	cancelCtx, cancel := context.WithCancel(context.Background())
@@ -1435,7 +2520,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	var wlock sync.Mutex
 	g, gctx := errgroup.WithContext(cancelCtx)
 	g.Go(func() error {
-		pongWait := s.config.KeepaliveInterval + wsPongWait
+		pongWait := s.config.Load().KeepaliveInterval + wsPongWait
 		conn.SetReadLimit(wsReadLimit)
 		if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
 			return err
@@ -1474,7 +2559,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 				logvr(v, r).Tag(tagWebsocket).Trace("Cancel received, closing subscriber connection")
 				conn.Close()
 				return &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "subscription was canceled"}
-			case <-time.After(s.config.KeepaliveInterval):
+			case <-time.After(s.config.Load().KeepaliveInterval):
 				v.Keepalive()
 				for _, t := range topics {
 					t.Keepalive()
@@ -1494,17 +2579,26 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		if err := conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
 			return err
 		}
-		return conn.WriteJSON(msg)
+		if !s.config.Load().WebSocketCompression {
+			return conn.WriteJSON(msg)
+		}
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		conn.EnableWriteCompression(len(b) >= wsCompressionThreshold) // No-op if the client did not negotiate compression
+		return conn.WriteMessage(websocket.TextMessage, append(b, '\n'))
 	}
 	if err := s.maybeSetRateVisitors(r, v, topics); err != nil {
 		return err
 	}
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	s.statsCollector.AddSubscribe(v, topics)
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
 	if poll {
 		for _, t := range topics {
 			t.Keepalive()
 		}
-		return s.sendOldMessages(topics, since, scheduled, v, sub)
+		return s.sendOldMessages(topics, since, scheduled, filters.Deleted, v, sub)
 	}
 	subscriberIDs := make([]int, 0)
 	for _, t := range topics {
@@ -1518,7 +2612,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	if err := sub(v, newOpenMessage(topicsStr)); err != nil { // Send out open message
 		return err
 	}
-	if err := s.sendOldMessages(topics, since, scheduled, v, sub); err != nil {
+	if err := s.sendOldMessages(topics, since, scheduled, filters.Deleted, v, sub); err != nil {
 		return err
 	}
 	err = g.Wait()
@@ -1554,14 +2648,14 @@ func parseSubscribeParams(r *http.Request) (poll bool, since sinceMarker, schedu
 // This only applies to UnifiedPush topics ("up...").
 func (s *Server) maybeSetRateVisitors(r *http.Request, v *visitor, topics []*topic) error {
 	// Bail out if not enabled
-	if !s.config.VisitorSubscriberRateLimiting {
+	if !s.config.Load().VisitorSubscriberRateLimiting {
 		return nil
 	}
 
 	// Make a list of topics that we'll actually set the RateVisitor on
 	eligibleRateTopics := make([]*topic, 0)
 	for _, t := range topics {
-		if strings.HasPrefix(t.ID, unifiedPushTopicPrefix) && len(t.ID) == unifiedPushTopicLength {
+		if isUnifiedPushTopic(t.ID) {
 			eligibleRateTopics = append(eligibleRateTopics, t)
 		}
 	}
@@ -1609,8 +2703,9 @@ func (s *Server) setRateVisitors(r *http.Request, v *visitor, rateTopics []*topi
 }
 
 // sendOldMessages selects old messages from the messageCache and calls sub for each of them. It uses since as the
-// marker, returning only messages that are newer than the marker.
-func (s *Server) sendOldMessages(topics []*topic, since sinceMarker, scheduled bool, v *visitor, sub subscriber) error {
+// marker, returning only messages that are newer than the marker. If deleted is set, "message_deleted" events for
+// messages that were deleted since the marker are included as well (see messageCache.Tombstones).
+func (s *Server) sendOldMessages(topics []*topic, since sinceMarker, scheduled, deleted bool, v *visitor, sub subscriber) error {
 	if since.IsNone() {
 		return nil
 	}
@@ -1621,8 +2716,15 @@ func (s *Server) sendOldMessages(topics []*topic, since sinceMarker, scheduled b
 			return err
 		}
 		messages = append(messages, topicMessages...)
+		if deleted {
+			tombstones, err := s.messageCache.Tombstones(t.ID, since)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, tombstones...)
+		}
 	}
-	sort.Slice(messages, func(i, j int) bool {
+	sort.SliceStable(messages, func(i, j int) bool {
 		return messages[i].Time < messages[j].Time
 	})
 	for _, m := range messages {
@@ -1667,8 +2769,8 @@ func parseSince(r *http.Request, poll bool) (sinceMarker, error) {
 
 func (s *Server) handleOptions(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
 	w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, PATCH, DELETE")
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
-	w.Header().Set("Access-Control-Allow-Headers", "*")                              // CORS, allow auth via JS // FIXME is this terrible?
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Access-Control-Allow-Headers", "*")                                     // CORS, allow auth via JS // FIXME is this terrible?
 	return nil
 }
 
@@ -1701,11 +2803,11 @@ func (s *Server) topicsFromIDs(ids ...string) ([]*topic, error) {
 	defer s.mu.Unlock()
 	topics := make([]*topic, 0)
 	for _, id := range ids {
-		if util.Contains(s.config.DisallowedTopics, id) {
+		if util.Contains(s.config.Load().DisallowedTopics, id) {
 			return nil, errHTTPBadRequestTopicDisallowed
 		}
 		if _, ok := s.topics[id]; !ok {
-			if len(s.topics) >= s.config.TotalTopicLimit {
+			if len(s.topics) >= s.config.Load().TotalTopicLimit {
 				return nil, errHTTPTooManyRequestsLimitTotalTopics
 			}
 			s.topics[id] = newTopic(id)
@@ -1742,10 +2844,10 @@ func (s *Server) topicsFromPattern(pattern string) ([]*topic, error) {
 }
 
 func (s *Server) runSMTPServer() error {
-	s.smtpServerBackend = newMailBackend(s.config, s.handle)
+	s.smtpServerBackend = newMailBackend(s.config.Load(), s.handle)
 	s.smtpServer = smtp.NewServer(s.smtpServerBackend)
-	s.smtpServer.Addr = s.config.SMTPServerListen
-	s.smtpServer.Domain = s.config.SMTPServerDomain
+	s.smtpServer.Addr = s.config.Load().SMTPServerListen
+	s.smtpServer.Domain = s.config.Load().SMTPServerDomain
 	s.smtpServer.ReadTimeout = 10 * time.Second
 	s.smtpServer.WriteTimeout = 10 * time.Second
 	s.smtpServer.MaxMessageBytes = 1024 * 1024 // Must be much larger than message size (headers, multipart, etc.)
@@ -1757,7 +2859,7 @@ func (s *Server) runSMTPServer() error {
 func (s *Server) runManager() {
 	for {
 		select {
-		case <-time.After(s.config.ManagerInterval):
+		case <-time.After(s.config.Load().ManagerInterval):
 			log.
 				Tag(tagManager).
 				Timing(s.execManager).
@@ -1772,7 +2874,7 @@ func (s *Server) runManager() {
 // email counters. The stats are used to display the counters in the web app, as well as for rate limiting.
 func (s *Server) runStatsResetter() {
 	for {
-		runAt := util.NextOccurrenceUTC(s.config.VisitorStatsResetTime, time.Now())
+		runAt := util.NextOccurrenceUTC(s.config.Load().VisitorStatsResetTime, time.Now())
 		timer := time.NewTimer(time.Until(runAt))
 		log.Tag(tagResetter).Debug("Waiting until %v to reset visitor stats", runAt)
 		select {
@@ -1794,6 +2896,9 @@ func (s *Server) resetStats() {
 	for _, v := range s.visitors {
 		v.ResetStats()
 	}
+	for _, t := range s.topics {
+		t.ResetStats()
+	}
 	if s.userManager != nil {
 		if err := s.userManager.ResetStats(); err != nil {
 			log.Tag(tagResetter).Warn("Failed to write to database: %s", err.Error())
@@ -1805,17 +2910,17 @@ func (s *Server) runFirebaseKeepaliver() {
 	if s.firebaseClient == nil {
 		return
 	}
-	v := newVisitor(s.config, s.messageCache, s.userManager, netip.IPv4Unspecified(), nil) // Background process, not a real visitor, uses IP 0.0.0.0
+	v := newVisitor(s.config.Load(), s.messageCache, s.userManager, s.redis, netip.IPv4Unspecified(), nil) // Background process, not a real visitor, uses IP 0.0.0.0
 	for {
 		select {
-		case <-time.After(s.config.FirebaseKeepaliveInterval):
+		case <-time.After(s.config.Load().FirebaseKeepaliveInterval):
 			s.sendToFirebase(v, newKeepaliveMessage(firebaseControlTopic))
 		/*
 			FIXME: Disable iOS polling entirely for now due to thundering herd problem (see #677)
 			       To solve this, we'd have to shard the iOS poll topics to spread out the polling evenly.
 			       Given that it's not really necessary to poll, turning it off for now should not have any impact.
 
-			case <-time.After(s.config.FirebasePollInterval):
+			case <-time.After(s.config.Load().FirebasePollInterval):
 				s.sendToFirebase(v, newKeepaliveMessage(firebasePollTopic))
 		*/
 		case <-s.closeChan:
@@ -1827,7 +2932,7 @@ func (s *Server) runFirebaseKeepaliver() {
 func (s *Server) runDelayedSender() {
 	for {
 		select {
-		case <-time.After(s.config.DelayedSenderInterval):
+		case <-time.After(s.config.Load().DelayedSenderInterval):
 			if err := s.sendDelayedMessages(); err != nil {
 				log.Tag(tagPublish).Err(err).Warn("Error sending delayed messages")
 			}
@@ -1852,7 +2957,11 @@ func (s *Server) sendDelayedMessages() error {
 			}
 		}
 		v := s.visitor(m.Sender, u)
-		if err := s.sendDelayedMessage(v, m); err != nil {
+		if m.Cron != "" {
+			if err := s.sendRecurringMessage(v, m); err != nil {
+				logvm(v, m).Err(err).Warn("Error sending recurring message")
+			}
+		} else if err := s.sendDelayedMessage(v, m); err != nil {
 			logvm(v, m).Err(err).Warn("Error sending delayed message")
 		}
 	}
@@ -1861,6 +2970,16 @@ func (s *Server) sendDelayedMessages() error {
 
 func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
 	logvm(v, m).Debug("Sending delayed message")
+	s.deliverScheduledMessage(v, m)
+	if err := s.messageCache.MarkPublished(m); err != nil {
+		return err
+	}
+	return nil
+}
+
+// deliverScheduledMessage sends a message that was stored in the cache to all subscribers, and to all
+// external channels (Firebase, upstream server, web push). It does not mark the message as published.
+func (s *Server) deliverScheduledMessage(v *visitor, m *message) {
 	s.mu.RLock()
 	t, ok := s.topics[m.Topic] // If no subscribers, just mark message as published
 	s.mu.RUnlock()
@@ -1872,26 +2991,35 @@ func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
 			}
 		}()
 	}
-	if s.firebaseClient != nil { // Firebase subscribers may not show up in topics map
+	s.cluster.Publish(m)
+	if s.firebaseClient != nil && m.channelAllowed(channelPush) { // Firebase subscribers may not show up in topics map
 		go s.sendToFirebase(v, m)
 	}
-	if s.config.UpstreamBaseURL != "" {
+	if s.apns != nil && m.channelAllowed(channelPush) {
+		go s.publishToAPNS(v, m)
+	}
+	if s.config.Load().UpstreamBaseURL != "" && m.channelAllowed(channelPush) {
 		go s.forwardPollRequest(v, m)
 	}
-	if s.config.WebPushPublicKey != "" {
+	if s.config.Load().WebPushPublicKey != "" && m.channelAllowed(channelWebPush) {
 		go s.publishToWebPushEndpoints(v, m)
 	}
-	if err := s.messageCache.MarkPublished(m); err != nil {
-		return err
+	if s.webhookSender != nil && m.channelAllowed(channelWebhook) {
+		go s.publishToWebhooks(v, m)
+	}
+	if s.matrixBridge != nil && m.channelAllowed(channelMatrix) {
+		go s.relayToMatrix(v, m)
+	}
+	if s.telegramRelay != nil && m.channelAllowed(channelTelegram) {
+		go s.relayToTelegram(v, m)
 	}
-	return nil
 }
 
 // transformBodyJSON peeks the request body, reads the JSON, and converts it to headers
 // before passing it on to the next handler. This is meant to be used in combination with handlePublish.
 func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		m, err := readJSONWithLimit[publishMessage](r.Body, s.config.MessageSizeLimit*2, false) // 2x to account for JSON format overhead
+		m, err := readJSONWithLimit[publishMessage](r.Body, s.config.Load().MessageSizeLimit*2, false) // 2x to account for JSON format overhead
 		if err != nil {
 			return err
 		}
@@ -1915,6 +3043,9 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if m.Attach != "" {
 			r.Header.Set("X-Attach", m.Attach)
 		}
+		for _, attach := range m.Attachments {
+			r.Header.Add("X-Attach", attach)
+		}
 		if m.Filename != "" {
 			r.Header.Set("X-Filename", m.Filename)
 		}
@@ -1940,9 +3071,30 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if m.Delay != "" {
 			r.Header.Set("X-Delay", m.Delay)
 		}
+		if m.Expires != "" {
+			r.Header.Set("X-Expires", m.Expires)
+		}
+		if m.Upload != "" {
+			r.Header.Set("X-Upload", m.Upload)
+		}
+		if m.Cron != "" {
+			r.Header.Set("X-Cron", m.Cron)
+		}
 		if m.Call != "" {
 			r.Header.Set("X-Call", m.Call)
 		}
+		if len(m.Channels) > 0 {
+			r.Header.Set("X-Channels", strings.Join(m.Channels, ","))
+		}
+		if m.Encryption != "" {
+			r.Header.Set("X-Encryption", m.Encryption)
+		}
+		if m.Signature != "" {
+			r.Header.Set("X-Signature", m.Signature)
+		}
+		if m.ReplyTo != "" {
+			r.Header.Set("X-Reply-To", m.ReplyTo)
+		}
 		if m.Cache != "" {
 			r.Header.Set("X-Cache", m.Cache)
 		}
@@ -1955,7 +3107,7 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 
 func (s *Server) transformMatrixJSON(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		newRequest, err := newRequestFromMatrixJSON(r, s.config.BaseURL, s.config.MessageSizeLimit)
+		newRequest, err := newRequestFromMatrixJSON(r, s.config.Load().BaseURL, s.config.Load().MessageSizeLimit)
 		if err != nil {
 			logvr(v, r).Tag(tagMatrix).Err(err).Debug("Invalid Matrix request")
 			if e, ok := err.(*errMatrixPushkeyRejected); ok {
@@ -1991,8 +3143,10 @@ func (s *Server) authorizeTopic(next handleFunc, perm user.Permission) handleFun
 		u := v.User()
 		for _, t := range topics {
 			if err := s.userManager.Authorize(u, t.ID, perm); err != nil {
-				logvr(v, r).With(t).Err(err).Debug("Access to topic %s not authorized", t.ID)
-				return errHTTPForbidden.With(t)
+				if err := s.authorizeTopicKey(r, v, t, perm); err != nil {
+					logvr(v, r).With(t).Err(err).Debug("Access to topic %s not authorized", t.ID)
+					return err
+				}
 			}
 		}
 		return next(w, r, v)
@@ -2012,10 +3166,14 @@ func (s *Server) authorizeTopic(next handleFunc, perm user.Permission) handleFun
 // that subsequent logging calls still have a visitor context.
 func (s *Server) maybeAuthenticate(r *http.Request) (*visitor, error) {
 	// Read the "Authorization" header value and exit out early if it's not set
-	ip := extractIPAddress(r, s.config.BehindProxy, s.config.ProxyForwardedHeader, s.config.ProxyTrustedPrefixes)
+	ip := extractIPAddress(r, s.config.Load().BehindProxy, s.config.Load().ProxyForwardedHeader, s.config.Load().ProxyTrustedPrefixes)
 	vip := s.visitor(ip, nil)
-	if s.userManager == nil {
+	if country := vip.Country(); countryBlocked(s.config.Load(), country) {
+		return vip, s.rejectCountry(r, vip, country)
+	} else if s.userManager == nil {
 		return vip, nil
+	} else if ban := s.bans.IP(ip); ban != nil {
+		return vip, s.rejectBanned(r, vip, ban) // Before authentication, to not waste time on bcrypt
 	}
 	header, err := readAuthHeader(r)
 	if err != nil {
@@ -2032,11 +3190,55 @@ func (s *Server) maybeAuthenticate(r *http.Request) (*visitor, error) {
 		vip.AuthFailed()
 		logr(r).Err(err).Debug("Authentication failed")
 		return vip, errHTTPUnauthorized // Always return visitor, even when error occurs!
+	} else if ban := s.bans.User(u); ban != nil {
+		return vip, s.rejectBanned(r, vip, ban)
+	}
+	// Authentication with user was successful; admins may act as another user
+	if actAs := readHeaderParam(r, "x-act-as", "act-as"); actAs != "" {
+		v, err := s.impersonate(r, ip, u, actAs)
+		if err != nil {
+			return vip, err // Always return visitor, even when error occurs!
+		}
+		return v, nil
 	}
-	// Authentication with user was successful
 	return s.visitor(ip, u), nil
 }
 
+// impersonate returns the visitor for the user given in the X-Act-As header, if impersonation is enabled,
+// and the authenticated user is an admin using an access token. Admins and the anonymous user cannot be
+// impersonated. Impersonated requests are read-only, i.e. only GET and HEAD requests (including subscribing)
+// are allowed, and publishing via GET is not. Every impersonated request is recorded in the audit log.
+func (s *Server) impersonate(r *http.Request, ip netip.Addr, admin *user.User, username string) (*visitor, error) {
+	if !s.config.Load().EnableImpersonation {
+		return nil, errHTTPForbiddenImpersonation.Wrap("impersonation is not enabled on this server")
+	} else if !admin.IsAdmin() || admin.Token == "" {
+		return nil, errHTTPForbiddenImpersonation.Wrap("impersonation requires an admin access token")
+	} else if !admin.TokenScopes.AllowAdmin() {
+		return nil, errHTTPForbiddenImpersonation.Wrap("impersonation requires an access token with the admin scope")
+	} else if (r.Method != http.MethodGet && r.Method != http.MethodHead) || publishPathRegex.MatchString(r.URL.Path) {
+		return nil, errHTTPForbiddenImpersonation.Wrap("impersonated requests are read-only")
+	}
+	u, err := s.userManager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil, errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return nil, err
+	} else if u.Deleted {
+		return nil, errHTTPBadRequestUserNotFound
+	} else if !u.IsUser() && !u.IsSupport() {
+		return nil, errHTTPForbiddenImpersonation.Wrap("user %s cannot be impersonated", u.Name)
+	}
+	v := s.visitor(ip, u)
+	logvr(v, r).
+		Tag(tagAudit).
+		Fields(log.Context{
+			"impersonator_user_id":   admin.ID,
+			"impersonator_user_name": admin.Name,
+		}).
+		Info("Admin %s acting as user %s", admin.Name, u.Name)
+	return v, nil
+}
+
 // authenticate a user based on basic auth username/password (Authorization: Basic ...), or token auth (Authorization: Bearer ...).
 // The Authorization header can be passed as a header or the ?auth=... query param. The latter is required only to
 // support the WebSocket JavaScript class, which does not support passing headers during the initial request. The auth
@@ -2049,7 +3251,8 @@ func (s *Server) authenticate(r *http.Request, header string) (user *user.User,
 }
 
 // readAuthHeader reads the raw value of the Authorization header, either from the actual HTTP header,
-// or from the ?auth... query parameter
+// or from the ?auth... query parameter. As a fallback, an access token can be passed as API key in the
+// X-API-Key header or ?api_key query parameter, since that is what no-code platforms typically support.
 func readAuthHeader(r *http.Request) (string, error) {
 	value := strings.TrimSpace(r.Header.Get("Authorization"))
 	queryParam := readQueryParam(r, "authorization", "auth")
@@ -2060,6 +3263,9 @@ func readAuthHeader(r *http.Request) (string, error) {
 		}
 		value = strings.TrimSpace(string(a))
 	}
+	if apiKey := readParam(r, "x-api-key", "api_key"); value == "" && apiKey != "" {
+		value = "Bearer " + apiKey
+	}
 	return value, nil
 }
 
@@ -2087,25 +3293,25 @@ func (s *Server) authenticateBearerAuth(r *http.Request, token string) (*user.Us
 	if err != nil {
 		return nil, err
 	}
-	ip := extractIPAddress(r, s.config.BehindProxy, s.config.ProxyForwardedHeader, s.config.ProxyTrustedPrefixes)
-	go s.userManager.EnqueueTokenUpdate(token, &user.TokenUpdate{
-		LastAccess: time.Now(),
-		LastOrigin: ip,
-	})
+	ip := extractIPAddress(r, s.config.Load().BehindProxy, s.config.Load().ProxyForwardedHeader, s.config.Load().ProxyTrustedPrefixes)
+	go s.updateTokenLastAccess(u, token, s.newTokenUpdate(ip, r.UserAgent()))
 	return u, nil
 }
 
 func (s *Server) visitor(ip netip.Addr, user *user.User) *visitor {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	id := visitorID(ip, user, s.config)
+	id := visitorID(ip, user, s.config.Load())
 	v, exists := s.visitors[id]
 	if !exists {
-		s.visitors[id] = newVisitor(s.config, s.messageCache, s.userManager, ip, user)
-		return s.visitors[id]
+		v = newVisitor(s.config.Load(), s.messageCache, s.userManager, s.redis, ip, user)
+		v.SetCountry(s.country(ip))
+		s.visitors[id] = v
+		return v
 	}
 	v.Keepalive()
-	v.SetUser(user) // Always update with the latest user, may be nil!
+	v.SetUser(user)             // Always update with the latest user, may be nil!
+	v.SetCountry(s.country(ip)) // User-based visitors may move between countries
 	return v
 }
 
@@ -2115,7 +3321,7 @@ func (s *Server) writeJSON(w http.ResponseWriter, v any) error {
 
 func (s *Server) writeJSONWithContentType(w http.ResponseWriter, v any, contentType string) error {
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
 	if err := json.NewEncoder(w).Encode(v); err != nil {
 		return err
 	}
@@ -1,26 +1,42 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"math/big"
 	"net/http"
+	"net/mail"
 	"net/netip"
+	"slices"
 	"strings"
 	"time"
 )
 
 const (
-	syncTopicAccountSyncEvent = "sync"
-	tokenExpiryDuration       = 72 * time.Hour // Extend tokens by this much
+	syncTopicAccountSyncEvent      = "sync"
+	tokenExpiryDuration            = 72 * time.Hour // Extend tokens by this much
+	emailVerificationExpiry        = 15 * time.Minute
+	emailVerificationAttemptsLimit = 5
 )
 
+// emailVerification is a pending email address change, see handleAccountEmailVerify
+type emailVerification struct {
+	email    string
+	code     string
+	expires  time.Time
+	attempts int
+}
+
 func (s *Server) handleAccountCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
 	if !u.IsAdmin() { // u may be nil, but that's fine
-		if !s.config.EnableSignup {
+		if !s.config.Load().EnableSignup {
 			return errHTTPBadRequestSignupNotEnabled
 		} else if u != nil {
 			return errHTTPUnauthorized // Cannot create account from user context
@@ -99,8 +115,9 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 		}
 		if u.Tier != nil {
 			response.Tier = &apiAccountTier{
-				Code: u.Tier.Code,
-				Name: u.Tier.Name,
+				Code:     u.Tier.Code,
+				Name:     u.Tier.Name,
+				Features: u.Tier.Features,
 			}
 		}
 		if u.Billing.StripeCustomerID != "" {
@@ -111,9 +128,10 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 				Interval:     string(u.Billing.StripeSubscriptionInterval),
 				PaidUntil:    u.Billing.StripeSubscriptionPaidUntil.Unix(),
 				CancelAt:     u.Billing.StripeSubscriptionCancelAt.Unix(),
+				GraceUntil:   u.Billing.StripePaymentGraceUntil.Unix(),
 			}
 		}
-		if s.config.EnableReservations {
+		if s.config.Load().EnableReservations {
 			reservations, err := s.userManager.Reservations(u.Name)
 			if err != nil {
 				return err
@@ -132,7 +150,7 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 		if err != nil {
 			return err
 		}
-		if len(tokens) > 0 {
+		if len(tokens) > 0 && !u.TokenScopes.Restricted() { // Scoped tokens must not reveal the other tokens
 			response.Tokens = make([]*apiAccountTokenResponse, 0)
 			for _, t := range tokens {
 				var lastOrigin string
@@ -140,16 +158,20 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 					lastOrigin = t.LastOrigin.String()
 				}
 				response.Tokens = append(response.Tokens, &apiAccountTokenResponse{
-					Token:       t.Value,
-					Label:       t.Label,
-					LastAccess:  t.LastAccess.Unix(),
-					LastOrigin:  lastOrigin,
-					Expires:     t.Expires.Unix(),
-					Provisioned: t.Provisioned,
+					Token:         t.Value,
+					Label:         t.Label,
+					LastAccess:    t.LastAccess.Unix(),
+					LastOrigin:    lastOrigin,
+					LastUserAgent: t.LastUserAgent,
+					LastCountry:   t.LastCountry,
+					LastASN:       t.LastASN,
+					Expires:       t.Expires.Unix(),
+					Provisioned:   t.Provisioned,
+					Scopes:        t.Scopes.Strings(),
 				})
 			}
 		}
-		if s.config.TwilioAccount != "" {
+		if s.config.Load().TwilioAccount != "" {
 			phoneNumbers, err := s.userManager.PhoneNumbers(u.ID)
 			if err != nil {
 				return err
@@ -158,10 +180,27 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 				response.PhoneNumbers = phoneNumbers
 			}
 		}
+		if s.emailSender() != nil {
+			email, err := s.userManager.Email(u.ID)
+			if err != nil && !errors.Is(err, user.ErrEmailNotFound) {
+				return err
+			} else if email != nil {
+				response.Email = &apiAccountEmail{
+					Address:  email.Address,
+					Fallback: email.Fallback,
+				}
+				if !email.FallbackUntil.IsZero() {
+					response.Email.FallbackUntil = email.FallbackUntil.Unix()
+				}
+			}
+		}
 	} else {
 		response.Username = user.Everyone
 		response.Role = string(user.RoleAnonymous)
 	}
+	if len(s.config.Load().Experiments) > 0 {
+		response.Experiments = s.experimentsEnabled(v)
+	}
 	return s.writeJSON(w, response)
 }
 
@@ -187,6 +226,11 @@ func (s *Server) handleAccountDelete(w http.ResponseWriter, r *http.Request, v *
 			logvr(v, r).Err(err).Warn("Error removing web push subscriptions for %s", u.Name)
 		}
 	}
+	if s.apnsStore != nil && u.ID != "" {
+		if err := s.apnsStore.RemoveDevicesByUserID(u.ID); err != nil {
+			logvr(v, r).Tag(tagAPNS).Err(err).Warn("Error removing APNs devices for %s", u.Name)
+		}
+	}
 	if u.Billing.StripeSubscriptionID != "" {
 		logvr(v, r).Tag(tagStripe).Info("Canceling billing subscription for user %s", u.Name)
 		if _, err := s.stripe.CancelSubscription(u.Billing.StripeSubscriptionID); err != nil {
@@ -228,6 +272,11 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 	req, err := readJSONWithLimit[apiAccountTokenIssueRequest](r.Body, jsonBodyBytesLimit, true) // Allow empty body!
 	if err != nil {
 		return err
+	} else if req.Refresh {
+		if req.Label != nil || req.Expires != nil || len(req.Scopes) > 0 {
+			return errHTTPBadRequestSessionInvalid
+		}
+		return s.handleAccountSessionCreate(w, r, v)
 	}
 	var label string
 	if req.Label != nil {
@@ -238,14 +287,19 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 		expires = time.Unix(*req.Expires, 0)
 	}
 	u := v.User()
+	scopes, err := parseTokenScopes(u, req.Scopes)
+	if err != nil {
+		return err
+	}
 	logvr(v, r).
 		Tag(tagAccount).
 		Fields(log.Context{
 			"token_label":   label,
 			"token_expires": expires,
+			"token_scopes":  scopes.String(),
 		}).
 		Debug("Creating token for user %s", u.Name)
-	token, err := s.userManager.CreateToken(u.ID, label, expires, v.IP(), false)
+	token, err := s.userManager.CreateScopedToken(u.ID, label, expires, v.IP(), false, scopes)
 	if err != nil {
 		return err
 	}
@@ -255,10 +309,95 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 		LastAccess: token.LastAccess.Unix(),
 		LastOrigin: token.LastOrigin.String(),
 		Expires:    token.Expires.Unix(),
+		Scopes:     token.Scopes.Strings(),
 	}
 	return s.writeJSON(w, response)
 }
 
+// handleAccountSessionCreate starts a web app session, consisting of a short-lived access token and a refresh
+// token, see handleAccountTokenRefresh. A leaked access token is only useful until it expires, and a leaked refresh
+// token is detected as soon as both the legitimate client and the attacker have used it.
+func (s *Server) handleAccountSessionCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	session, err := s.userManager.CreateSession(u.ID, time.Now().Add(s.config.Load().AuthSessionTokenDuration), time.Now().Add(s.config.Load().AuthSessionRefreshDuration), v.IP())
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("session_id", session.ID).
+		Debug("Created session for user %s", u.Name)
+	return s.writeJSON(w, newAPIAccountSessionResponse(session))
+}
+
+// handleAccountTokenRefresh exchanges a refresh token for a new access token and a new refresh token. The previous
+// tokens can no longer be used. If a refresh token is used twice, the session is revoked. Since the access token may
+// have already expired, this endpoint does not require authentication, but invalid refresh tokens count as failed
+// authentication attempts.
+func (s *Server) handleAccountTokenRefresh(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if !v.AuthAllowed() {
+		return errHTTPTooManyRequestsLimitAuthFailure
+	}
+	req, err := readJSONWithLimit[apiAccountTokenRefreshRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	// Check the ban before rotating the tokens, so that banned users cannot keep their session alive
+	userID, err := s.userManager.SessionUserID(req.RefreshToken)
+	if errors.Is(err, user.ErrRefreshTokenInvalid) {
+		v.AuthFailed()
+		return errHTTPUnauthorizedRefreshTokenInvalid
+	} else if err != nil {
+		return err
+	}
+	u, err := s.userManager.UserByID(userID)
+	if err != nil {
+		return err
+	} else if ban := s.bans.User(u); ban != nil {
+		return s.rejectBanned(r, v, ban)
+	}
+	session, err := s.userManager.RefreshSession(req.RefreshToken, time.Now().Add(s.config.Load().AuthSessionTokenDuration), time.Now().Add(s.config.Load().AuthSessionRefreshDuration), v.IP())
+	if errors.Is(err, user.ErrRefreshTokenReused) {
+		v.AuthFailed()
+		logvr(v, r).Tag(tagAccount).Warn("Refresh token was used more than once, session revoked (the token may have been stolen)")
+		return errHTTPUnauthorizedRefreshTokenInvalid
+	} else if errors.Is(err, user.ErrRefreshTokenInvalid) {
+		v.AuthFailed()
+		return errHTTPUnauthorizedRefreshTokenInvalid
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"user_name":  u.Name,
+			"session_id": session.ID,
+		}).
+		Debug("Refreshed session for user %s", u.Name)
+	return s.writeJSON(w, newAPIAccountSessionResponse(session))
+}
+
+func newAPIAccountSessionResponse(session *user.Session) *apiAccountSessionResponse {
+	return &apiAccountSessionResponse{
+		Token:          session.AccessToken.Value,
+		Expires:        session.AccessToken.Expires.Unix(),
+		RefreshToken:   session.RefreshToken,
+		RefreshExpires: session.RefreshExpires.Unix(),
+	}
+}
+
+// parseTokenScopes validates the requested scopes of a new token for the given user. Only admins
+// can create tokens with the admin scope.
+func parseTokenScopes(u *user.User, scopes []string) (user.TokenScopes, error) {
+	parsed, err := user.ParseTokenScopes(scopes)
+	if err != nil {
+		return nil, errHTTPBadRequestTokenScopeInvalid.Wrap("%s", err.Error())
+	} else if slices.Contains(parsed, user.TokenScopeAdmin) && !u.IsAdmin() {
+		return nil, errHTTPBadRequestTokenScopeInvalid.Wrap("admin scope is only allowed for admins")
+	}
+	return parsed, nil
+}
+
 func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
 	req, err := readJSONWithLimit[apiAccountTokenUpdateRequest](r.Body, jsonBodyBytesLimit, true) // Allow empty body!
@@ -287,6 +426,8 @@ func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		if errors.Is(err, user.ErrProvisionedTokenChange) {
 			return errHTTPConflictProvisionedTokenChange
+		} else if errors.Is(err, user.ErrSessionTokenChange) {
+			return errHTTPConflictSessionTokenChange
 		}
 		return err
 	}
@@ -296,6 +437,7 @@ func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request
 		LastAccess: token.LastAccess.Unix(),
 		LastOrigin: token.LastOrigin.String(),
 		Expires:    token.Expires.Unix(),
+		Scopes:     token.Scopes.Strings(),
 	}
 	return s.writeJSON(w, response)
 }
@@ -361,6 +503,11 @@ func (s *Server) handleAccountSubscriptionAdd(w http.ResponseWriter, r *http.Req
 	if err != nil {
 		return err
 	}
+	if newSubscription.Delivery != nil {
+		if err := newSubscription.Delivery.Validate(); err != nil {
+			return errHTTPBadRequestDeliveryPrefsInvalid.Wrap("%s", err.Error())
+		}
+	}
 	u := v.User()
 	prefs := u.Prefs
 	if prefs == nil {
@@ -384,6 +531,11 @@ func (s *Server) handleAccountSubscriptionChange(w http.ResponseWriter, r *http.
 	if err != nil {
 		return err
 	}
+	if updatedSubscription.Delivery != nil {
+		if err := updatedSubscription.Delivery.Validate(); err != nil {
+			return errHTTPBadRequestDeliveryPrefsInvalid.Wrap("%s", err.Error())
+		}
+	}
 	u := v.User()
 	prefs := u.Prefs
 	if prefs == nil || prefs.Subscriptions == nil {
@@ -393,6 +545,9 @@ func (s *Server) handleAccountSubscriptionChange(w http.ResponseWriter, r *http.
 	for _, sub := range prefs.Subscriptions {
 		if sub.BaseURL == updatedSubscription.BaseURL && sub.Topic == updatedSubscription.Topic {
 			sub.DisplayName = updatedSubscription.DisplayName
+			if updatedSubscription.Delivery != nil {
+				sub.Delivery = updatedSubscription.Delivery // Only overwritten if set, so older clients do not reset it
+			}
 			subscription = sub
 			break
 		}
@@ -450,11 +605,13 @@ func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Requ
 		return errHTTPBadRequestPermissionInvalid
 	}
 	// Check if we are allowed to reserve this topic
-	if u.IsUser() && u.Tier == nil {
+	if !u.IsAdmin() && u.Tier == nil {
 		return errHTTPUnauthorized
+	} else if !u.IsAdmin() && !v.FeatureAllowed(user.TierFeatureReservations) {
+		return errHTTPForbiddenTierFeature.Wrap("%s", user.TierFeatureReservations)
 	} else if err := s.userManager.AllowReservation(u.Name, req.Topic); err != nil {
 		return errHTTPConflictTopicReserved
-	} else if u.IsUser() {
+	} else if !u.IsAdmin() {
 		hasReservation, err := s.userManager.HasReservation(u.Name, req.Topic)
 		if err != nil {
 			return err
@@ -525,6 +682,250 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+func (s *Server) handleAccountReservationEmailTemplateGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	tpl, err := s.userManager.EmailTemplate(topic)
+	if errors.Is(err, user.ErrEmailTemplateNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountEmailTemplate{
+		Subject: tpl.Subject,
+		Body:    tpl.Body,
+	})
+}
+
+func (s *Server) handleAccountReservationEmailTemplateChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountEmailTemplate](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Subject == "" && req.Body == "" {
+		return errHTTPBadRequest
+	}
+	for _, tpl := range []string{req.Subject, req.Body} {
+		if err := validateTemplate(tpl); err != nil {
+			return err
+		}
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Changing email template for topic %s", topic)
+	if err := s.userManager.ChangeEmailTemplate(v.User().Name, &user.EmailTemplate{
+		Topic:   topic,
+		Subject: req.Subject,
+		Body:    req.Body,
+	}); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationEmailTemplateDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Removing email template for topic %s", topic)
+	if err := s.userManager.RemoveEmailTemplate(topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationTemplateGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	tpl, err := s.userManager.TopicTemplate(topic)
+	if errors.Is(err, user.ErrTopicTemplateNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountTopicTemplate{
+		Title:    tpl.Title,
+		Message:  tpl.Message,
+		Tags:     tpl.Tags,
+		Priority: tpl.Priority,
+	})
+}
+
+func (s *Server) handleAccountReservationTemplateChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	} else if !v.FeatureAllowed(user.TierFeatureTemplates) {
+		return errHTTPForbiddenTierFeature.Wrap("%s", user.TierFeatureTemplates)
+	}
+	req, err := readJSONWithLimit[apiAccountTopicTemplate](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Message == "" {
+		return errHTTPBadRequest.Wrap("message template is required")
+	}
+	for _, tpl := range []string{req.Title, req.Message, req.Tags, req.Priority} {
+		if err := validateTemplate(tpl); err != nil {
+			return err
+		}
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Changing template for topic %s", topic)
+	if err := s.userManager.ChangeTopicTemplate(v.User().Name, &user.TopicTemplate{
+		Topic:    topic,
+		Title:    req.Title,
+		Message:  req.Message,
+		Tags:     req.Tags,
+		Priority: req.Priority,
+	}); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationTemplateDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Removing template for topic %s", topic)
+	if err := s.userManager.RemoveTopicTemplate(topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationPolicyGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	policy, err := s.userManager.TopicPolicy(topic)
+	if errors.Is(err, user.ErrTopicPolicyNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountTopicPolicy{
+		CacheDuration:           int64(policy.CacheDuration.Seconds()),
+		MessageLimit:            policy.MessageLimit,
+		AttachmentFileSizeLimit: policy.AttachmentFileSizeLimit,
+	})
+}
+
+func (s *Server) handleAccountReservationPolicyChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	} else if err := s.ensureTopicPolicyNotManaged(topic); err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountTopicPolicy](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	policy := newTopicPolicy(topic, req.CacheDuration, req.MessageLimit, req.AttachmentFileSizeLimit)
+	if err := validateTopicPolicy(policy); err != nil {
+		return err
+	} else if policy.CacheDuration > v.Limits().MessageExpiryDuration {
+		return errHTTPBadRequestTopicPolicyInvalid
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Changing policy for topic %s", topic)
+	if err := s.userManager.ChangeTopicPolicy(v.User().Name, policy); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationPolicyDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	} else if err := s.ensureTopicPolicyNotManaged(topic); err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Removing policy for topic %s", topic)
+	if err := s.userManager.RemoveTopicPolicy(topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// ensureTopicPolicyNotManaged returns an error if an admin has set the policy for the topic, since
+// policies set by an admin take precedence and cannot be changed by the topic owner
+func (s *Server) ensureTopicPolicyNotManaged(topic string) error {
+	policy, err := s.userManager.TopicPolicy(topic)
+	if errors.Is(err, user.ErrTopicPolicyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	} else if policy.Owner == "" {
+		return errHTTPForbiddenTopicPolicyManaged
+	}
+	return nil
+}
+
+func newTopicPolicy(topic string, cacheDurationSeconds, messageLimit, attachmentFileSizeLimit int64) *user.TopicPolicy {
+	return &user.TopicPolicy{
+		Topic:                   topic,
+		CacheDuration:           time.Duration(cacheDurationSeconds) * time.Second,
+		MessageLimit:            messageLimit,
+		AttachmentFileSizeLimit: attachmentFileSizeLimit,
+	}
+}
+
+func validateTopicPolicy(policy *user.TopicPolicy) error {
+	if policy.CacheDuration < 0 || policy.MessageLimit < 0 || policy.AttachmentFileSizeLimit < 0 {
+		return errHTTPBadRequestTopicPolicyInvalid
+	} else if policy.CacheDuration == 0 && policy.MessageLimit == 0 && policy.AttachmentFileSizeLimit == 0 {
+		return errHTTPBadRequestTopicPolicyInvalid
+	}
+	return nil
+}
+
+// ownedReservationTopicFromPath extracts the topic from a /v1/account/reservation/<topic>/... path, and
+// ensures that the topic is reserved by the visitor's user
+func (s *Server) ownedReservationTopicFromPath(v *visitor, path string) (string, error) {
+	matches := apiAccountReservationTopicRegex.FindStringSubmatch(path)
+	if len(matches) != 2 {
+		return "", errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	if !topicRegex.MatchString(topic) {
+		return "", errHTTPBadRequestTopicInvalid
+	}
+	authorized, err := s.userManager.HasReservation(v.User().Name, topic)
+	if err != nil {
+		return "", err
+	} else if !authorized {
+		return "", errHTTPUnauthorized
+	}
+	return topic, nil
+}
+
 // maybeRemoveMessagesAndExcessReservations deletes topic reservations for the given user (if too many for tier),
 // and marks associated messages for the topics as deleted. This also eventually deletes attachments.
 // The process relies on the manager to perform the actual deletions (see runManager).
@@ -551,6 +952,17 @@ func (s *Server) maybeRemoveMessagesAndExcessReservations(r *http.Request, v *vi
 	return nil
 }
 
+func (s *Server) handleAccountPhoneNumbersGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	phoneNumbers, err := s.userManager.PhoneNumbers(u.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountPhoneNumbersResponse{
+		PhoneNumbers: phoneNumbers,
+	})
+}
+
 func (s *Server) handleAccountPhoneNumberVerify(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
 	req, err := readJSONWithLimit[apiAccountPhoneNumberVerifyRequest](r.Body, jsonBodyBytesLimit, false)
@@ -562,9 +974,9 @@ func (s *Server) handleAccountPhoneNumberVerify(w http.ResponseWriter, r *http.R
 		return errHTTPBadRequestPhoneNumberVerifyChannelInvalid
 	}
 	// Check user is allowed to add phone numbers
-	if u == nil || (u.IsUser() && u.Tier == nil) {
+	if u == nil || (!u.IsAdmin() && u.Tier == nil) {
 		return errHTTPUnauthorized
-	} else if u.IsUser() && u.Tier.CallLimit == 0 {
+	} else if !u.IsAdmin() && u.Tier.CallLimit == 0 {
 		return errHTTPUnauthorized
 	}
 	// Check if phone number exists
@@ -595,7 +1007,9 @@ func (s *Server) handleAccountPhoneNumberAdd(w http.ResponseWriter, r *http.Requ
 		return err
 	}
 	logvr(v, r).Tag(tagAccount).Field("phone_number", req.Number).Debug("Adding phone number as verified")
-	if err := s.userManager.AddPhoneNumber(u.ID, req.Number); err != nil {
+	if err := s.userManager.AddPhoneNumber(u.ID, req.Number); errors.Is(err, user.ErrPhoneNumberExists) {
+		return errHTTPConflictPhoneNumberExists
+	} else if err != nil {
 		return err
 	}
 	return s.writeJSON(w, newSuccessResponse())
@@ -610,6 +1024,12 @@ func (s *Server) handleAccountPhoneNumberDelete(w http.ResponseWriter, r *http.R
 	if !phoneNumberRegex.MatchString(req.Number) {
 		return errHTTPBadRequestPhoneNumberInvalid
 	}
+	phoneNumbers, err := s.userManager.PhoneNumbers(u.ID)
+	if err != nil {
+		return err
+	} else if !util.Contains(phoneNumbers, req.Number) {
+		return errHTTPBadRequestPhoneNumberNotFound
+	}
 	logvr(v, r).Tag(tagAccount).Field("phone_number", req.Number).Debug("Deleting phone number")
 	if err := s.userManager.RemovePhoneNumber(u.ID, req.Number); err != nil {
 		return err
@@ -617,6 +1037,144 @@ func (s *Server) handleAccountPhoneNumberDelete(w http.ResponseWriter, r *http.R
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+func (s *Server) handleAccountEmailVerify(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountEmailVerifyRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !validEmailAddress(req.Email) {
+		return errHTTPBadRequestEmailAddressInvalid
+	}
+	email, err := s.userManager.Email(u.ID)
+	if err != nil && !errors.Is(err, user.ErrEmailNotFound) {
+		return err
+	} else if email != nil && email.Address == req.Email {
+		return errHTTPConflictEmailExists
+	}
+	if !v.EmailAllowed() {
+		return errHTTPTooManyRequestsLimitEmails
+	}
+	code, err := s.newEmailVerification(u.ID, req.Email)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Field("email", req.Email).Debug("Sending email address verification")
+	l := s.localizer.Locale(u)
+	m := newDefaultMessage("", l.T("email_verify_message", "code", code, "expiry", util.FormatDuration(emailVerificationExpiry)))
+	m.Title = l.T("email_verify_title")
+	sender := s.emailSender()
+	if sender == nil {
+		return errHTTPNotFound
+	}
+	if err := sender.Send(v, m, req.Email); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleAccountEmailChange sets the user's email address, after checking the verification code. The previous
+// address is kept as a fallback (see account-email-fallback-duration), and is notified of the change. If the user
+// is a Stripe customer, the email address of the customer is updated as well.
+func (s *Server) handleAccountEmailChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountEmailChangeRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !validEmailAddress(req.Email) {
+		return errHTTPBadRequestEmailAddressInvalid
+	}
+	if err := s.consumeEmailVerification(u.ID, req.Email, req.Code); err != nil {
+		return err
+	}
+	previous, err := s.userManager.Email(u.ID)
+	if err != nil && !errors.Is(err, user.ErrEmailNotFound) {
+		return err
+	}
+	if err := s.updateStripeCustomerEmail(r, v, u, req.Email); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Field("email", req.Email).Info("Changing email address of user %s", u.Name)
+	if err := s.userManager.ChangeEmail(u.ID, req.Email, s.config.Load().AccountEmailFallbackDuration); errors.Is(err, user.ErrEmailExists) {
+		return errHTTPConflictEmailExists
+	} else if err != nil {
+		return err
+	}
+	if previous != nil && previous.Address != req.Email {
+		l := s.localizer.Locale(u)
+		m := newDefaultMessage("", l.T("email_changed_message", "username", u.Name, "email", req.Email))
+		m.Title = l.T("email_changed_title")
+		if sender := s.emailSender(); sender == nil {
+			logvr(v, r).Tag(tagAccount).Warn("Unable to notify previous email address of change, outgoing emails are disabled")
+		} else if err := sender.Send(v, m, previous.Address); err != nil {
+			logvr(v, r).Tag(tagAccount).Err(err).Warn("Unable to notify previous email address of change")
+		}
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountEmailDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	logvr(v, r).Tag(tagAccount).Debug("Deleting email address")
+	if err := s.userManager.RemoveEmail(u.ID); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// newEmailVerification creates a verification code for changing the email address of the given user. Only
+// one verification can be pending per user; a new one replaces the previous one.
+func (s *Server) newEmailVerification(userID, email string) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneEmailVerifications()
+	s.emailVerifications[userID] = &emailVerification{
+		email:   email,
+		code:    code,
+		expires: time.Now().Add(emailVerificationExpiry),
+	}
+	return code, nil
+}
+
+// consumeEmailVerification checks the verification code for the given user and email address, and removes
+// the pending verification if it matches. After too many failed attempts, the verification is discarded.
+func (s *Server) consumeEmailVerification(userID, email, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ev, ok := s.emailVerifications[userID]
+	if !ok || time.Now().After(ev.expires) {
+		delete(s.emailVerifications, userID)
+		return errHTTPGoneEmailVerificationExpired
+	} else if ev.email != email || subtle.ConstantTimeCompare([]byte(ev.code), []byte(code)) != 1 {
+		ev.attempts++
+		if ev.attempts >= emailVerificationAttemptsLimit {
+			delete(s.emailVerifications, userID)
+		}
+		return errHTTPBadRequestEmailVerificationCodeInvalid
+	}
+	delete(s.emailVerifications, userID)
+	return nil
+}
+
+// pruneEmailVerifications removes expired email verifications. Must be called with s.mu held.
+func (s *Server) pruneEmailVerifications() {
+	now := time.Now()
+	for userID, ev := range s.emailVerifications {
+		if now.After(ev.expires) {
+			delete(s.emailVerifications, userID)
+		}
+	}
+}
+
+func validEmailAddress(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
 // publishSyncEventAsync kicks of a Go routine to publish a sync message to the user's sync topic
 func (s *Server) publishSyncEventAsync(v *visitor) {
 	go func() {
@@ -628,16 +1186,21 @@ func (s *Server) publishSyncEventAsync(v *visitor) {
 
 // publishSyncEvent publishes a sync message to the user's sync topic
 func (s *Server) publishSyncEvent(v *visitor) error {
+	return s.publishAccountEvent(v, &apiAccountSyncTopicResponse{Event: syncTopicAccountSyncEvent})
+}
+
+// publishAccountEvent publishes the given event to the user's sync topic
+func (s *Server) publishAccountEvent(v *visitor, event *apiAccountSyncTopicResponse) error {
 	u := v.User()
 	if u == nil || u.SyncTopic == "" {
 		return nil
 	}
-	logv(v).Field("sync_topic", u.SyncTopic).Trace("Publishing sync event to user's sync topic")
+	logv(v).Fields(log.Context{"sync_topic": u.SyncTopic, "sync_event": event.Event}).Trace("Publishing %s event to user's sync topic", event.Event)
 	syncTopic, err := s.topicFromID(u.SyncTopic)
 	if err != nil {
 		return err
 	}
-	messageBytes, err := json.Marshal(&apiAccountSyncTopicResponse{Event: syncTopicAccountSyncEvent})
+	messageBytes, err := json.Marshal(event)
 	if err != nil {
 		return err
 	}
@@ -645,5 +1208,45 @@ func (s *Server) publishSyncEvent(v *visitor) error {
 	if err := syncTopic.Publish(v, m); err != nil {
 		return err
 	}
+	s.cluster.Publish(m)
 	return nil
 }
+
+// notifyUser notifies the user about a problem with their account, both via email (if the user has a verified email
+// address) and by publishing a message to the topics reserved by the user. Errors are logged, but not returned.
+func (s *Server) notifyUser(v *visitor, u *user.User, title, message string) {
+	if sender := s.emailSender(); sender != nil {
+		if email, err := s.userManager.Email(u.ID); err == nil {
+			m := newDefaultMessage("", message)
+			m.Title = title
+			if err := sender.Send(v, m, email.Address); err != nil {
+				logv(v).Tag(tagAccount).Err(err).Warn("Unable to send account notification email")
+			}
+		} else if !errors.Is(err, user.ErrEmailNotFound) {
+			logv(v).Tag(tagAccount).Err(err).Warn("Unable to retrieve email address for account notification")
+		}
+	}
+	reservations, err := s.userManager.Reservations(u.Name)
+	if err != nil {
+		logv(v).Tag(tagAccount).Err(err).Warn("Unable to retrieve reserved topics for account notification")
+		return
+	}
+	for _, reservation := range reservations {
+		t, err := s.topicFromID(reservation.Topic)
+		if err != nil {
+			logv(v).Tag(tagAccount).Err(err).Warn("Unable to publish account notification to topic %s", reservation.Topic)
+			continue
+		}
+		m := newDefaultMessage(t.ID, message)
+		m.Title = title
+		m.Priority = 4
+		m.Tags = []string{"warning"}
+		if err := s.messageCache.AddMessage(m); err != nil {
+			logvm(v, m).Tag(tagAccount).Err(err).Warn("Unable to cache account notification")
+		}
+		if err := t.Publish(v, m); err != nil {
+			logvm(v, m).Tag(tagAccount).Err(err).Warn("Unable to publish account notification")
+		}
+		s.cluster.Publish(m)
+	}
+}
@@ -134,7 +134,7 @@ func TestAccount_Get_Anonymous(t *testing.T) {
 	conf.VisitorAttachmentTotalSizeLimit = 5123
 	conf.AttachmentFileSizeLimit = 512
 	s := newTestServer(t, conf)
-	s.smtpSender = &testMailer{}
+	s.setEmailSender(&testMailer{})
 	defer s.closeDatabases()
 
 	rr := request(t, s, "GET", "/v1/account", "", nil)
@@ -391,6 +391,175 @@ func TestAccount_DeleteToken(t *testing.T) {
 	require.Equal(t, 401, rr.Code)
 }
 
+func TestAccount_Session_RefreshAndRotate(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	rr := request(t, s, "POST", "/v1/account/token", `{"refresh":true}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	session, err := util.UnmarshalJSON[apiAccountSessionResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.NotEmpty(t, session.RefreshToken)
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), session.Expires, 2)
+	require.InDelta(t, time.Now().Add(72*time.Hour).Unix(), session.RefreshExpires, 2)
+
+	// Session tokens cannot be extended
+	rr = request(t, s, "PATCH", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BearerAuth(session.Token),
+	})
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, 40912, toHTTPError(t, rr.Body.String()).Code)
+
+	// Refreshing does not require authentication, and rotates both tokens
+	rr = request(t, s, "POST", "/v1/account/token/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, session.RefreshToken), nil)
+	require.Equal(t, 200, rr.Code)
+	refreshed, err := util.UnmarshalJSON[apiAccountSessionResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.NotEqual(t, session.Token, refreshed.Token)
+	require.NotEqual(t, session.RefreshToken, refreshed.RefreshToken)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(session.Token),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(refreshed.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Reusing the old refresh token fails; right after the rotation, this does not revoke the session, since it may
+	// have been another browser tab (see user.Manager.RefreshSession for the revocation)
+	rr = request(t, s, "POST", "/v1/account/token/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, session.RefreshToken), nil)
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40104, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(refreshed.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestAccount_Session_LogoutAndInvalid(t *testing.T) {
+	t.Parallel()
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorAuthFailureLimitBurst = 3
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	rr := request(t, s, "POST", "/v1/account/token", `{"refresh":true,"expires":123}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40098, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/v1/account/token", `{"refresh":true}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	session, err := util.UnmarshalJSON[apiAccountSessionResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+
+	// Logging out deletes the access token, and ends the session
+	rr = request(t, s, "DELETE", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BearerAuth(session.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/v1/account/token/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, session.RefreshToken), nil)
+	require.Equal(t, 401, rr.Code)
+
+	// Invalid refresh tokens count as failed auth attempts
+	for i := 0; i < 2; i++ {
+		rr = request(t, s, "POST", "/v1/account/token/refresh", `{"refresh_token":"rt_invalid"}`, nil)
+		require.Equal(t, 401, rr.Code)
+	}
+	rr = request(t, s, "POST", "/v1/account/token/refresh", `{"refresh_token":"rt_invalid"}`, nil)
+	require.Equal(t, 429, rr.Code)
+}
+
+func TestAccount_CreateToken_Scopes(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("phil", "backups", user.PermissionReadWrite))
+
+	rr := request(t, s, "POST", "/v1/account/token", `{"scopes":["publish","topic:alerts"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, []string{"publish", "topic:alerts"}, token.Scopes)
+	auth := map[string]string{"Authorization": util.BearerAuth(token.Token)}
+
+	// Token can only publish to "alerts"
+	rr = request(t, s, "PUT", "/alerts", "disk full", auth)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/backups", "backup done", auth)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "GET", "/alerts/json?poll=1", "", auth)
+	require.Equal(t, 403, rr.Code)
+
+	// Token cannot manage the account, e.g. to create an unscoped token, and does not reveal other tokens
+	rr = request(t, s, "POST", "/v1/account/token", "", auth)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40305, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/account", "", auth)
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Empty(t, account.Tokens)
+
+	// Invalid scopes, and admin scope for non-admins
+	rr = request(t, s, "POST", "/v1/account/token", `{"scopes":["delete"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40073, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/token", `{"scopes":["admin"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40073, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestAccount_CreateToken_AdminScope(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("admin", "admin", user.RoleAdmin, false))
+	admin, err := s.userManager.User("admin")
+	require.Nil(t, err)
+	publishToken, err := s.userManager.CreateScopedToken(admin.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false, user.TokenScopes{user.TokenScopePublish})
+	require.Nil(t, err)
+	adminToken, err := s.userManager.CreateScopedToken(admin.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false, user.TokenScopes{user.TokenScopeAdmin})
+	require.Nil(t, err)
+
+	rr := request(t, s, "GET", "/v1/users", "", map[string]string{
+		"Authorization": util.BearerAuth(publishToken.Value),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40305, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/mytopic", "published by admin", map[string]string{
+		"Authorization": util.BearerAuth(publishToken.Value),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/users", "", map[string]string{
+		"Authorization": util.BearerAuth(adminToken.Value),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
 func TestAccount_Delete_Success(t *testing.T) {
 	conf := newTestConfigWithAuthFile(t)
 	conf.EnableSignup = true
@@ -664,25 +833,25 @@ func TestAccount_Reservation_Delete_Messages_And_Attachments(t *testing.T) {
 	})
 	require.Equal(t, 200, rr.Code)
 	m1 := toMessage(t, rr.Body.String())
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m1.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m1.ID))
 
 	rr = request(t, s, "POST", "/mytopic2?f=attach.txt", `Howdy`, map[string]string{
 		"Authorization": util.BasicAuth("phil", "mypass"),
 	})
 	require.Equal(t, 200, rr.Code)
 	m2 := toMessage(t, rr.Body.String())
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m2.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m2.ID))
 
 	// Pre-verify message count and file
 	ms, err := s.messageCache.Messages("mytopic1", sinceAllMessages, false)
 	require.Nil(t, err)
 	require.Equal(t, 1, len(ms))
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m1.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m1.ID))
 
 	ms, err = s.messageCache.Messages("mytopic2", sinceAllMessages, false)
 	require.Nil(t, err)
 	require.Equal(t, 1, len(ms))
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m2.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m2.ID))
 
 	// Delete reservation
 	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic1", ``, map[string]string{
@@ -702,19 +871,19 @@ func TestAccount_Reservation_Delete_Messages_And_Attachments(t *testing.T) {
 	waitFor(t, func() bool {
 		ms, err := s.messageCache.Messages("mytopic1", sinceAllMessages, false)
 		require.Nil(t, err)
-		return len(ms) == 0 && !util.FileExists(filepath.Join(s.config.AttachmentCacheDir, m1.ID))
+		return len(ms) == 0 && !util.FileExists(filepath.Join(s.config.Load().AttachmentCacheDir, m1.ID))
 	})
 
 	ms, err = s.messageCache.Messages("mytopic1", sinceAllMessages, false)
 	require.Nil(t, err)
 	require.Equal(t, 0, len(ms))
-	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m1.ID))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m1.ID))
 
 	ms, err = s.messageCache.Messages("mytopic2", sinceAllMessages, false)
 	require.Nil(t, err)
 	require.Equal(t, 1, len(ms))
 	require.Equal(t, m2.ID, ms[0].ID)
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m2.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m2.ID))
 }
 
 /*func TestAccount_Persist_UserStats_After_Tier_Change(t *testing.T) {
@@ -779,3 +948,405 @@ func TestAccount_Reservation_Delete_Messages_And_Attachments(t *testing.T) {
 	account, _ = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
 	require.Equal(t, int64(2), account.Stats.Messages) // Is not reset!
 }*/
+
+func TestAccount_Reservation_EmailTemplate(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	// Create users, reserve topic
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+
+	// No template yet
+	rr := request(t, s, "GET", "/v1/account/reservation/mytopic/email-template", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+
+	// Non-owners cannot set templates
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/email-template", `{"subject":"hi"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Invalid templates are rejected
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/email-template", `{"subject":"{{ .title "}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40043, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/email-template", `{"body":"{{ template \"x\" }}"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40044, toHTTPError(t, rr.Body.String()).Code)
+
+	// Set and read template
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/email-template", `{"subject":"[{{ .topic }}] {{ .title | upper }}","body":"{{ .message }}\nPriority: {{ .priority }}"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account/reservation/mytopic/email-template", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	tpl, _ := util.UnmarshalJSON[apiAccountEmailTemplate](io.NopCloser(rr.Body))
+	require.Equal(t, "[{{ .topic }}] {{ .title | upper }}", tpl.Subject)
+
+	// Template is applied to emails
+	m := newDefaultMessage("mytopic", "Disk full")
+	m.Title = "alert"
+	m.Priority = 5
+	m.Tags = []string{"warning"}
+	em := s.emailMessage(s.visitor(netip.MustParseAddr("1.2.3.4"), nil), m)
+	require.Equal(t, "[mytopic] ALERT", em.Title)
+	require.Equal(t, "Disk full\nPriority: 5", em.Message)
+	require.Nil(t, em.Tags)
+	require.Equal(t, "alert", m.Title) // Original unchanged
+
+	// Other topics are not affected
+	other := newDefaultMessage("othertopic", "Disk full")
+	require.Equal(t, other, s.emailMessage(s.visitor(netip.MustParseAddr("1.2.3.4"), nil), other))
+
+	// Delete template
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/email-template", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, m, s.emailMessage(s.visitor(netip.MustParseAddr("1.2.3.4"), nil), m))
+}
+
+func TestAccount_Reservation_TopicTemplate(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	// Create users, reserve topic
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionReadWrite))
+
+	// No template yet
+	rr := request(t, s, "GET", "/v1/account/reservation/mytopic/template", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+
+	// Non-owners cannot set templates, and templates must be valid
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/template", `{"message":"hi"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/template", `{"title":"{{ .title "}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/template", `{"message":"{{ .title "}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40043, toHTTPError(t, rr.Body.String()).Code)
+
+	// Set and read template
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/template", `{"title":"{{ .host }} is {{ .status }}","message":"{{ .details }}","tags":"{{ if eq .status \"down\" }}rotating_light{{ end }},{{ .host }}","priority":"{{ if eq .status \"down\" }}urgent{{ end }}"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account/reservation/mytopic/template", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	tpl, _ := util.UnmarshalJSON[apiAccountTopicTemplate](io.NopCloser(rr.Body))
+	require.Equal(t, "{{ .details }}", tpl.Message)
+
+	// Template is applied to JSON publishes
+	rr = request(t, s, "POST", "/mytopic", `{"host":"db1","status":"down","details":"No route to host"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Tags":          "prod",
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, "db1 is down", m.Title)
+	require.Equal(t, "No route to host", m.Message)
+	require.Equal(t, []string{"prod", "rotating_light", "db1"}, m.Tags)
+	require.Equal(t, 5, m.Priority)
+
+	rr = request(t, s, "POST", "/mytopic", `{"host":"db1","status":"up","details":"Back online"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	m = toMessage(t, rr.Body.String())
+	require.Equal(t, "db1 is up", m.Title)
+	require.Equal(t, []string{"db1"}, m.Tags)
+	require.Equal(t, 0, m.Priority)
+
+	// Non-JSON bodies, and publishes with their own template are not affected
+	rr = request(t, s, "POST", "/mytopic", `{not json}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, "{not json}", toMessage(t, rr.Body.String()).Message)
+	rr = request(t, s, "POST", "/mytopic?template=1&message={{.details}}!", `{"details":"custom"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	m = toMessage(t, rr.Body.String())
+	require.Equal(t, "custom!", m.Message)
+	require.Equal(t, "", m.Title)
+
+	// Delete template
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/template", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/mytopic", `{"host":"db1"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, `{"host":"db1"}`, toMessage(t, rr.Body.String()).Message)
+}
+
+func TestAccount_Reservation_TopicPolicy(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	// Create users, reserve topic
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionReadWrite))
+
+	// No policy yet
+	rr := request(t, s, "GET", "/v1/account/reservation/mytopic/policy", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, rr.Code)
+
+	// Non-owners cannot set policies, and values must be valid
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/policy", `{"message_limit":2}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	for _, body := range []string{`{"message_limit":-1}`, `{}`, `{"cache_duration":864000}`} { // Last one exceeds the default cache duration
+		rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/policy", body, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 400, rr.Code)
+		require.Equal(t, 40067, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Set and read policy
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/policy", `{"cache_duration":3600,"message_limit":2,"attachment_file_size_limit":10}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/account/reservation/mytopic/policy", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	policy, _ := util.UnmarshalJSON[apiAccountTopicPolicy](io.NopCloser(rr.Body))
+	require.Equal(t, int64(3600), policy.CacheDuration)
+	require.Equal(t, int64(2), policy.MessageLimit)
+	require.Equal(t, int64(10), policy.AttachmentFileSizeLimit)
+
+	// Cache duration and attachment size limit are applied when publishing
+	for i := 0; i < 3; i++ {
+		rr = request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, rr.Code)
+		m := toMessage(t, rr.Body.String())
+		require.Equal(t, m.Time+3600, m.Expires)
+	}
+	rr = request(t, s, "PUT", "/mytopic", "this is more than ten bytes", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+		"Filename":      "file.txt",
+	})
+	require.Equal(t, 413, rr.Code)
+
+	// Message limit is enforced by the manager
+	s.execManager()
+	rr = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	messages := toMessages(t, rr.Body.String())
+	require.Len(t, messages, 2)
+	require.Equal(t, "message 1", messages[0].Message)
+	require.Equal(t, "message 2", messages[1].Message)
+
+	// Policies set by an admin cannot be changed by the owner
+	require.Nil(t, s.userManager.ChangeTopicPolicy("", &user.TopicPolicy{Topic: "mytopic", MessageLimit: 1}))
+	rr = request(t, s, "PUT", "/v1/account/reservation/mytopic/policy", `{"message_limit":5}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40304, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/policy", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Delete policy
+	require.Nil(t, s.userManager.ChangeTopicPolicy("ben", &user.TopicPolicy{Topic: "mytopic", MessageLimit: 5}))
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/policy", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/mytopic", "message 3", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, m.Time+int64(conf.CacheDuration.Seconds()), m.Expires)
+}
+
+func TestAccount_Reservation_TopicPolicy_CacheDurationPrune(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "mytopic", user.PermissionReadWrite))
+
+	// Messages published before a policy was set are pruned once the (lowered) cache duration is exceeded
+	m := newDefaultMessage("mytopic", "old message")
+	m.Time = time.Now().Add(-2 * time.Hour).Unix()
+	m.Expires = time.Now().Add(10 * time.Hour).Unix()
+	require.Nil(t, s.messageCache.AddMessage(m))
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "new message", nil).Code)
+	require.Nil(t, s.userManager.ChangeTopicPolicy("", &user.TopicPolicy{Topic: "mytopic", CacheDuration: time.Hour}))
+
+	s.execManager()
+	messages := toMessages(t, request(t, s, "GET", "/mytopic/json?poll=1", "", nil).Body.String())
+	require.Len(t, messages, 1)
+	require.Equal(t, "new message", messages[0].Message)
+}
+
+func TestAccount_EmailChange(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, conf)
+	mailer := &testMailer{}
+	s.setEmailSender(mailer)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	require.Nil(t, s.userManager.ChangeEmail(ben.ID, "ben@example.com", 0))
+
+	// Invalid address
+	rr := request(t, s, "PUT", "/v1/account/email/verify", `{"email":"Phil <phil@example.com>"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40055, toHTTPError(t, rr.Body.String()).Code)
+
+	// Request verification, and fail with wrong code
+	rr = request(t, s, "PUT", "/v1/account/email/verify", `{"email":"phil@example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 1, mailer.Count())
+	code := s.emailVerifications[phil.ID].code
+	require.Len(t, code, 6)
+
+	rr = request(t, s, "PUT", "/v1/account/email", `{"email":"phil@example.com","code":"wrong"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40056, toHTTPError(t, rr.Body.String()).Code)
+
+	// Correct code, but different address
+	rr = request(t, s, "PUT", "/v1/account/email", fmt.Sprintf(`{"email":"other@example.com","code":"%s"}`, code), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+
+	// Success
+	rr = request(t, s, "PUT", "/v1/account/email", fmt.Sprintf(`{"email":"phil@example.com","code":"%s"}`, code), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 1, mailer.Count()) // No previous address to notify
+
+	// Code cannot be used twice
+	rr = request(t, s, "PUT", "/v1/account/email", fmt.Sprintf(`{"email":"phil@example.com","code":"%s"}`, code), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 410, rr.Code)
+
+	// Change again, previous address is kept as fallback and notified
+	rr = request(t, s, "PUT", "/v1/account/email/verify", `{"email":"phil@new.example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/account/email", fmt.Sprintf(`{"email":"phil@new.example.com","code":"%s"}`, s.emailVerifications[phil.ID].code), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 3, mailer.Count())
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "phil@new.example.com", account.Email.Address)
+	require.Equal(t, "phil@example.com", account.Email.Fallback)
+	require.True(t, account.Email.FallbackUntil > time.Now().Add(6*24*time.Hour).Unix())
+
+	// Address of another user cannot be used
+	rr = request(t, s, "PUT", "/v1/account/email/verify", `{"email":"ben@example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/account/email", fmt.Sprintf(`{"email":"ben@example.com","code":"%s"}`, s.emailVerifications[phil.ID].code), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, 40908, toHTTPError(t, rr.Body.String()).Code)
+
+	// Delete
+	rr = request(t, s, "DELETE", "/v1/account/email", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	account, _ = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, account.Email)
+}
+
+func TestAccount_EmailChange_TooManyAttempts(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, conf)
+	s.setEmailSender(&testMailer{})
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	rr := request(t, s, "PUT", "/v1/account/email/verify", `{"email":"phil@example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	for i := 0; i < emailVerificationAttemptsLimit; i++ {
+		rr = request(t, s, "PUT", "/v1/account/email", `{"email":"phil@example.com","code":"wrong"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+	}
+	rr = request(t, s, "PUT", "/v1/account/email", `{"email":"phil@example.com","code":"wrong"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 410, rr.Code)
+}
+
+func TestAccount_EmailChange_EmailsDisabled(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	rr := request(t, s, "PUT", "/v1/account/email/verify", `{"email":"phil@example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+// handleAck stores the acknowledgement of a message by a subscriber (POST /<topic>/ack/<message-id>), and
+// publishes an "ack" event to the topic. Each user (or IP address, for anonymous subscribers) can acknowledge
+// a message only once; repeated acknowledgements succeed, but do not publish another event.
+func (s *Server) handleAck(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, m, err := s.ackMessageFromPath(r)
+	if err != nil {
+		return err
+	}
+	username, acker := "", "ip:"+v.IP().String()
+	if u := v.User(); u != nil {
+		username, acker = u.Name, u.ID
+	}
+	added, err := s.messageCache.AddAck(m.ID, acker, username, time.Now())
+	if err != nil {
+		return err
+	}
+	ev := newAckMessage(t.ID, m.ID, username)
+	if added {
+		logvrm(v, r, m).Tag(tagPublish).Debug("Message acknowledged")
+		if err := t.Publish(v, ev); err != nil {
+			return err
+		}
+		s.cluster.Publish(ev)
+	}
+	return s.writeJSON(w, ev)
+}
+
+// handleAcksGet returns all acknowledgements of a message. Unlike acknowledging a message, listing the
+// acknowledgements is also allowed for publishers that only have write access to the topic.
+func (s *Server) handleAcksGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, m, err := s.ackMessageFromPath(r)
+	if err != nil {
+		return err
+	}
+	if s.userManager != nil {
+		u := v.User()
+		if s.userManager.Authorize(u, t.ID, user.PermissionRead) != nil && s.userManager.Authorize(u, t.ID, user.PermissionWrite) != nil {
+			return errHTTPForbidden.With(t)
+		}
+	}
+	acks, err := s.messageCache.Acks(m.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAcksResponse{
+		MessageID: m.ID,
+		Acks:      acks,
+	})
+}
+
+func (s *Server) ackMessageFromPath(r *http.Request) (*topic, *message, error) {
+	if s.config.Load().CacheDuration == 0 {
+		return nil, nil, errHTTPBadRequestAckNotAllowed
+	}
+	matches := ackPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return nil, nil, errHTTPInternalErrorInvalidPath
+	}
+	t, err := s.topicFromID(matches[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := s.messageCache.Message(matches[2])
+	if errors.Is(err, errMessageNotFound) || (err == nil && m.Topic != t.ID) {
+		return nil, nil, errHTTPNotFound.With(t).Fields(log.Context{
+			"message_id":    matches[2],
+			"error_context": "message_cache",
+		})
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return t, m, nil
+}
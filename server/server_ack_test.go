@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Ack(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/oncall/json?acks=1", subscribeRR)
+	defaultRR := httptest.NewRecorder()
+	defaultCancel := subscribe(t, s, "/oncall/json", defaultRR)
+
+	response := request(t, s, "PUT", "/oncall", "disk full", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "POST", "/oncall/ack/"+m.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	ev := toMessage(t, response.Body.String())
+	require.Equal(t, ackEvent, ev.Event)
+	require.Equal(t, "oncall", ev.Topic)
+	require.Equal(t, m.ID, ev.Ack.MessageID)
+	require.Equal(t, "", ev.Ack.User)
+
+	// Acknowledging again does not publish another event
+	response = request(t, s, "POST", "/oncall/ack/"+m.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+
+	subscribeCancel()
+	defaultCancel()
+	messages := toMessages(t, subscribeRR.Body.String())
+	require.Equal(t, 3, len(messages)) // open, message and ack (message and ack are delivered asynchronously)
+	idx := slices.IndexFunc(messages, func(m *message) bool { return m.Event == ackEvent })
+	require.NotEqual(t, -1, idx)
+	require.Equal(t, m.ID, messages[idx].Ack.MessageID)
+	messages = toMessages(t, defaultRR.Body.String())
+	require.Equal(t, 2, len(messages)) // open and message, ack events are opt-in
+	require.False(t, slices.ContainsFunc(messages, func(m *message) bool { return m.Event == ackEvent }))
+
+	response = request(t, s, "GET", "/oncall/ack/"+m.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	acks := toAcksResponse(t, response.Body.String())
+	require.Equal(t, m.ID, acks.MessageID)
+	require.Equal(t, 1, len(acks.Acks))
+	require.Equal(t, "", acks.Acks[0].User)
+	require.True(t, acks.Acks[0].Time > 0)
+}
+
+func TestServer_Ack_NotFound(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/oncall", "disk full", nil)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "POST", "/oncall/ack/doesnotexist", "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "POST", "/othertopic/ack/"+m.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/othertopic/ack/"+m.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Ack_CacheDisabled(t *testing.T) {
+	c := newTestConfig(t)
+	c.CacheDuration = 0
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/oncall/ack/abcdefghijkl", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40066, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Ack_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("publisher", "publisher", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("publisher", "oncall", user.PermissionWrite))
+	require.Nil(t, s.userManager.AllowAccess("phil", "oncall", user.PermissionRead))
+	require.Nil(t, s.userManager.AllowAccess("ben", "oncall", user.PermissionRead))
+
+	response := request(t, s, "PUT", "/oncall", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("publisher", "publisher"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Anonymous users and publishers without read access cannot acknowledge
+	response = request(t, s, "POST", "/oncall/ack/"+m.ID, "", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "POST", "/oncall/ack/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("publisher", "publisher"),
+	})
+	require.Equal(t, 403, response.Code)
+
+	// Subscribers can acknowledge
+	response = request(t, s, "POST", "/oncall/ack/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "phil", toMessage(t, response.Body.String()).Ack.User)
+	response = request(t, s, "POST", "/oncall/ack/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Publisher can list acknowledgements, anonymous users cannot
+	response = request(t, s, "GET", "/oncall/ack/"+m.ID, "", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/oncall/ack/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("publisher", "publisher"),
+	})
+	require.Equal(t, 200, response.Code)
+	acks := toAcksResponse(t, response.Body.String())
+	require.Equal(t, 2, len(acks.Acks))
+	require.Equal(t, "phil", acks.Acks[0].User)
+	require.Equal(t, "ben", acks.Acks[1].User)
+}
+
+func toAcksResponse(t *testing.T, s string) *apiAcksResponse {
+	var response apiAcksResponse
+	require.Nil(t, json.NewDecoder(strings.NewReader(s)).Decode(&response))
+	return &response
+}
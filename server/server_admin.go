@@ -2,18 +2,36 @@ package server
 
 import (
 	"errors"
+	"fmt"
+	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
 	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
 )
 
+// handleUsersGet lists all users, including their stats. Phone numbers and grants are only included for admins,
+// since support users have no need to see them.
 func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	users, err := s.userManager.Users()
 	if err != nil {
 		return err
 	}
-	grants, err := s.userManager.AllGrants()
-	if err != nil {
-		return err
+	admin := !v.User().IsSupport()
+	var grants map[string][]user.Grant
+	var phoneNumbers map[string][]string
+	if admin {
+		grants, err = s.userManager.AllGrants()
+		if err != nil {
+			return err
+		}
+		phoneNumbers, err = s.userManager.AllPhoneNumbers()
+		if err != nil {
+			return err
+		}
 	}
 	usersResponse := make([]*apiUserResponse, len(users))
 	for i, u := range users {
@@ -21,6 +39,19 @@ func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visit
 		if u.Tier != nil {
 			tier = u.Tier.Code
 		}
+		usersResponse[i] = &apiUserResponse{
+			Username: u.Name,
+			Role:     string(u.Role),
+			Tier:     tier,
+			Stats: &apiUserStatsResponse{
+				Messages: u.Stats.Messages,
+				Emails:   u.Stats.Emails,
+				Calls:    u.Stats.Calls,
+			},
+		}
+		if !admin {
+			continue
+		}
 		userGrants := make([]*apiUserGrantResponse, len(grants[u.ID]))
 		for i, g := range grants[u.ID] {
 			userGrants[i] = &apiUserGrantResponse{
@@ -28,12 +59,8 @@ func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visit
 				Permission: g.Permission.String(),
 			}
 		}
-		usersResponse[i] = &apiUserResponse{
-			Username: u.Name,
-			Role:     string(u.Role),
-			Tier:     tier,
-			Grants:   userGrants,
-		}
+		usersResponse[i].Grants = userGrants
+		usersResponse[i].PhoneNumbers = phoneNumbers[u.ID]
 	}
 	return s.writeJSON(w, usersResponse)
 }
@@ -75,6 +102,8 @@ func (s *Server) handleUsersAdd(w http.ResponseWriter, r *http.Request, v *visit
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// handleUsersUpdate changes a user's password and/or tier, or adds the user if it does not exist. Support
+// users may only change the password of existing regular users.
 func (s *Server) handleUsersUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	req, err := readJSONWithLimit[apiUserAddOrUpdateRequest](r.Body, jsonBodyBytesLimit, false)
 	if err != nil {
@@ -87,10 +116,17 @@ func (s *Server) handleUsersUpdate(w http.ResponseWriter, r *http.Request, v *vi
 	u, err := s.userManager.User(req.Username)
 	if err != nil && !errors.Is(err, user.ErrUserNotFound) {
 		return err
+	} else if v.User().IsSupport() && (u == nil || !u.IsUser() || req.Tier != "") {
+		return errHTTPForbidden.Wrap("support users can only change the password of existing regular users")
 	} else if u != nil {
 		if u.IsAdmin() {
 			return errHTTPForbidden
 		}
+		if req.Tier != "" && (u.Tier == nil || u.Tier.Code != req.Tier) {
+			if err := s.confirmAdminWebAuthn(r, v); err != nil {
+				return err
+			}
+		}
 		if req.Hash != "" {
 			if err := s.userManager.ChangePassword(req.Username, req.Hash, true); err != nil {
 				return err
@@ -135,6 +171,9 @@ func (s *Server) handleUsersDelete(w http.ResponseWriter, r *http.Request, v *vi
 	} else if !u.IsUser() {
 		return errHTTPUnauthorized.Wrap("can only remove regular users from API")
 	}
+	if err := s.confirmAdminWebAuthn(r, v); err != nil {
+		return err
+	}
 	if err := s.userManager.RemoveUser(req.Username); err != nil {
 		return err
 	}
@@ -149,16 +188,25 @@ func (s *Server) handleAccessAllow(w http.ResponseWriter, r *http.Request, v *vi
 	if err != nil {
 		return err
 	}
+	permission, err := user.ParsePermission(req.Permission)
+	if err != nil {
+		return errHTTPBadRequestPermissionInvalid
+	}
+	if group, ok := strings.CutPrefix(req.Username, user.GroupPrefix); ok {
+		if err := s.userManager.AllowGroupAccess(group, req.Topic, permission); errors.Is(err, user.ErrGroupNotFound) {
+			return errHTTPBadRequestGroupNotFound
+		} else if err != nil {
+			return err
+		}
+		return s.writeJSON(w, newSuccessResponse())
+	}
+	req.Username = adminUsername(req.Username)
 	_, err = s.userManager.User(req.Username)
 	if errors.Is(err, user.ErrUserNotFound) {
 		return errHTTPBadRequestUserNotFound
 	} else if err != nil {
 		return err
 	}
-	permission, err := user.ParsePermission(req.Permission)
-	if err != nil {
-		return errHTTPBadRequestPermissionInvalid
-	}
 	if err := s.userManager.AllowAccess(req.Username, req.Topic, permission); err != nil {
 		return err
 	}
@@ -170,8 +218,14 @@ func (s *Server) handleAccessReset(w http.ResponseWriter, r *http.Request, v *vi
 	if err != nil {
 		return err
 	}
+	if group, ok := strings.CutPrefix(req.Username, user.GroupPrefix); ok {
+		return s.handleGroupAccessReset(w, group, req.Topic)
+	}
+	req.Username = adminUsername(req.Username)
 	u, err := s.userManager.User(req.Username)
-	if err != nil {
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
 		return err
 	}
 	if err := s.userManager.ResetAccess(req.Username, req.Topic); err != nil {
@@ -183,6 +237,444 @@ func (s *Server) handleAccessReset(w http.ResponseWriter, r *http.Request, v *vi
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// handleAccessGet returns the default access and the access control list of all users, similar to
+// what "ntfy access" prints. If the "username" query parameter is set, only that user is returned.
+func (s *Server) handleAccessGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	var users []*user.User
+	if username := readQueryParam(r, "username"); username != "" {
+		u, err := s.userManager.User(adminUsername(username))
+		if errors.Is(err, user.ErrUserNotFound) {
+			return errHTTPBadRequestUserNotFound
+		} else if err != nil {
+			return err
+		}
+		users = []*user.User{u}
+	} else {
+		var err error
+		users, err = s.userManager.Users()
+		if err != nil {
+			return err
+		}
+	}
+	grants, err := s.userManager.AllGrants()
+	if err != nil {
+		return err
+	}
+	usersResponse := make([]*apiAccessUserResponse, len(users))
+	for i, u := range users {
+		userGrants := make([]*apiUserGrantResponse, len(grants[u.ID]))
+		for j, g := range grants[u.ID] {
+			userGrants[j] = &apiUserGrantResponse{
+				Topic:      g.TopicPattern,
+				Permission: g.Permission.String(),
+			}
+		}
+		usersResponse[i] = &apiAccessUserResponse{
+			Username: u.Name,
+			Role:     string(u.Role),
+			Grants:   userGrants,
+		}
+	}
+	groupsResponse, err := s.groupsResponse()
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccessResponse{
+		DefaultAccess: s.userManager.DefaultAccess().String(),
+		Users:         usersResponse,
+		Groups:        groupsResponse,
+	})
+}
+
+// handleGroupsGet returns all groups, including their members and access control entries
+func (s *Server) handleGroupsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	groupsResponse, err := s.groupsResponse()
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, groupsResponse)
+}
+
+func (s *Server) groupsResponse() ([]*apiGroupResponse, error) {
+	groups, err := s.userManager.Groups()
+	if err != nil {
+		return nil, err
+	}
+	groupsResponse := make([]*apiGroupResponse, len(groups))
+	for i, g := range groups {
+		grants, err := s.userManager.GroupGrants(g.Name)
+		if err != nil {
+			return nil, err
+		}
+		groupGrants := make([]*apiUserGrantResponse, len(grants))
+		for j, grant := range grants {
+			groupGrants[j] = &apiUserGrantResponse{
+				Topic:      grant.TopicPattern,
+				Permission: grant.Permission.String(),
+			}
+		}
+		groupsResponse[i] = &apiGroupResponse{
+			Name:    g.Name,
+			Members: g.Members,
+			Grants:  groupGrants,
+		}
+	}
+	return groupsResponse, nil
+}
+
+// handleGroupsAdd creates a group, optionally with members, similar to "ntfy group add"
+func (s *Server) handleGroupsAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupAddRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedGroup(req.Name) {
+		return errHTTPBadRequest.Wrap("group name invalid")
+	}
+	for _, username := range req.Members {
+		if _, err := s.userManager.User(username); errors.Is(err, user.ErrUserNotFound) {
+			return errHTTPBadRequestUserNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := s.userManager.AddGroup(req.Name); errors.Is(err, user.ErrGroupExists) {
+		return errHTTPConflictGroupExists
+	} else if err != nil {
+		return err
+	}
+	for _, username := range req.Members {
+		if err := s.userManager.AddGroupMember(req.Name, username); err != nil {
+			return err
+		}
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"group_name": req.Name, "group_members": len(req.Members)}).Info("Admin creating group")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleGroupsDelete removes a group and its access control entries. The members' subscriptions to topics
+// of the group are canceled, so that they have to re-authorize.
+func (s *Server) handleGroupsDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	group, err := s.userManager.Group(req.Name)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	grants, err := s.userManager.GroupGrants(group.Name)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveGroup(group.Name); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Field("group_name", group.Name).Info("Admin removing group")
+	for _, username := range group.Members {
+		if err := s.killGroupMemberSubscriber(username, grants); err != nil {
+			return err
+		}
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleGroupsMemberAdd adds a user to a group, similar to "ntfy group add-member"
+func (s *Server) handleGroupsMemberAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMemberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.AddGroupMember(req.Group, req.Username); errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if errors.Is(err, user.ErrUserNotFound) || errors.Is(err, user.ErrInvalidArgument) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"group_name": req.Group, "user_name": req.Username}).Info("Admin adding user to group")
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleGroupsMemberDelete removes a user from a group, and cancels the user's subscriptions to topics of the group
+func (s *Server) handleGroupsMemberDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMemberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	grants, err := s.userManager.GroupGrants(req.Group)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveGroupMember(req.Group, req.Username); errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"group_name": req.Group, "user_name": req.Username}).Info("Admin removing user from group")
+	if err := s.killGroupMemberSubscriber(req.Username, grants); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleGroupAccessReset removes access control entries of a group (see handleAccessReset), and cancels the
+// members' subscriptions to the affected topics
+func (s *Server) handleGroupAccessReset(w http.ResponseWriter, name, topicPattern string) error {
+	group, err := s.userManager.Group(name)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManager.ResetGroupAccess(group.Name, topicPattern); err != nil {
+		return err
+	}
+	if topicPattern == "" {
+		topicPattern = "*"
+	}
+	for _, username := range group.Members {
+		if err := s.killGroupMemberSubscriber(username, []user.Grant{{TopicPattern: topicPattern}}); err != nil {
+			return err
+		}
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// killGroupMemberSubscriber cancels the subscriptions of a (former) group member to all topics matching the given
+// group grants, e.g. after the member was removed from the group
+func (s *Server) killGroupMemberSubscriber(username string, grants []user.Grant) error {
+	u, err := s.userManager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		if err := s.killUserSubscriber(u, grant.TopicPattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleUsersPhoneNumberAdd adds a phone number to a user without requiring verification. This allows
+// admins to provision phone numbers, e.g. if verification via SMS or call is not possible for a user.
+func (s *Server) handleUsersPhoneNumberAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, req, err := s.readUsersPhoneNumberRequest(r)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "phone_number": req.Number}).Info("Admin adding phone number for user")
+	if err := s.userManager.AddPhoneNumber(u.ID, req.Number); errors.Is(err, user.ErrPhoneNumberExists) {
+		return errHTTPConflictPhoneNumberExists
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleUsersPhoneNumberDelete removes a phone number from a user
+func (s *Server) handleUsersPhoneNumberDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, req, err := s.readUsersPhoneNumberRequest(r)
+	if err != nil {
+		return err
+	}
+	phoneNumbers, err := s.userManager.PhoneNumbers(u.ID)
+	if err != nil {
+		return err
+	} else if !util.Contains(phoneNumbers, req.Number) {
+		return errHTTPBadRequestPhoneNumberNotFound
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "phone_number": req.Number}).Info("Admin deleting phone number for user")
+	if err := s.userManager.RemovePhoneNumber(u.ID, req.Number); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleUsersTokensDelete revokes all tokens of a user, except for provisioned tokens. Support users
+// may only revoke the tokens of regular users.
+func (s *Server) handleUsersTokensDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUserDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u, err := s.userManager.User(req.Username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	} else if v.User().IsSupport() && !u.IsUser() {
+		return errHTTPForbidden.Wrap("support users can only revoke tokens of regular users")
+	}
+	if err := s.confirmAdminWebAuthn(r, v); err != nil {
+		return err
+	}
+	removed, err := s.userManager.RemoveAllTokens(u.ID)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "tokens_removed": removed}).Info("Admin revoking all tokens for user")
+	return s.writeJSON(w, &apiUsersTokensDeleteResponse{
+		Success: true,
+		Removed: removed,
+	})
+}
+
+// handleUsersTokensGet lists the tokens of a user, or of all users if the "username" query parameter is not set
+func (s *Server) handleUsersTokensGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	var users []*user.User
+	if username := readQueryParam(r, "username"); username != "" {
+		u, err := s.userManager.User(username)
+		if errors.Is(err, user.ErrUserNotFound) {
+			return errHTTPBadRequestUserNotFound
+		} else if err != nil {
+			return err
+		}
+		users = []*user.User{u}
+	} else {
+		var err error
+		users, err = s.userManager.Users()
+		if err != nil {
+			return err
+		}
+	}
+	response := make([]*apiUsersTokenResponse, 0)
+	for _, u := range users {
+		tokens, err := s.userManager.Tokens(u.ID)
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			response = append(response, newAPIUsersTokenResponse(u, t))
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAdminTokenInspect returns the user a token belongs to, as well as the token's label, scopes, expiry date, and
+// when and from where it was last used, e.g. to triage a leaked token. The token is passed in the request body rather
+// than as a query parameter, so that it does not end up in access logs.
+func (s *Server) handleAdminTokenInspect(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminTokenInspectRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u, t, err := s.userManager.InspectToken(req.Token)
+	if errors.Is(err, user.ErrTokenNotFound) {
+		return errHTTPBadRequestTokenNotFound
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "token": maskToken(t.Value)}).Debug("Admin inspecting token of user")
+	return s.writeJSON(w, newAPIUsersTokenResponse(u, t))
+}
+
+// handleUsersTokensAdd creates a token for a user. Unlike tokens created via the account API, tokens
+// created by admins never expire unless "expires" is set, just like with "ntfy token add".
+func (s *Server) handleUsersTokensAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUsersTokenRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u, err := s.userManager.User(req.Username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	}
+	var label string
+	if req.Label != nil {
+		label = *req.Label
+	}
+	expires := time.Unix(0, 0)
+	if req.Expires != nil {
+		expires = time.Unix(*req.Expires, 0)
+	}
+	scopes, err := parseTokenScopes(u, req.Scopes)
+	if err != nil {
+		return err
+	}
+	token, err := s.userManager.CreateScopedToken(u.ID, label, expires, v.IP(), false, scopes)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "token_label": label, "token_expires": expires, "token_scopes": scopes.String()}).Info("Admin creating token for user")
+	return s.writeJSON(w, &apiUsersTokenResponse{
+		Username:   u.Name,
+		Token:      token.Value,
+		Label:      token.Label,
+		LastAccess: token.LastAccess.Unix(),
+		LastOrigin: token.LastOrigin.String(),
+		Expires:    token.Expires.Unix(),
+		Scopes:     token.Scopes.Strings(),
+	})
+}
+
+// handleUsersTokenDelete removes a single token of a user, or all non-provisioned tokens if no token is given.
+// Support users may only revoke the tokens of regular users.
+func (s *Server) handleUsersTokenDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiUsersTokenRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u, err := s.userManager.User(req.Username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	} else if v.User().IsSupport() && !u.IsUser() {
+		return errHTTPForbidden.Wrap("support users can only revoke tokens of regular users")
+	}
+	if err := s.confirmAdminWebAuthn(r, v); err != nil {
+		return err
+	}
+	if req.Token == "" {
+		removed, err := s.userManager.RemoveAllTokens(u.ID)
+		if err != nil {
+			return err
+		}
+		logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "tokens_removed": removed}).Info("Admin revoking all tokens for user")
+		return s.writeJSON(w, &apiUsersTokensDeleteResponse{
+			Success: true,
+			Removed: removed,
+		})
+	}
+	if _, err := s.userManager.Token(u.ID, req.Token); errors.Is(err, user.ErrTokenNotFound) {
+		return errHTTPBadRequestTokenNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveToken(u.ID, req.Token); errors.Is(err, user.ErrProvisionedTokenChange) {
+		return errHTTPConflictProvisionedTokenChange
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "token": maskToken(req.Token)}).Info("Admin revoking token for user")
+	return s.writeJSON(w, &apiUsersTokensDeleteResponse{
+		Success: true,
+		Removed: 1,
+	})
+}
+
+func (s *Server) readUsersPhoneNumberRequest(r *http.Request) (*user.User, *apiUserPhoneNumberRequest, error) {
+	req, err := readJSONWithLimit[apiUserPhoneNumberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return nil, nil, err
+	} else if !phoneNumberRegex.MatchString(req.Number) {
+		return nil, nil, errHTTPBadRequestPhoneNumberInvalid
+	}
+	u, err := s.userManager.User(req.Username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return nil, nil, errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return u, req, nil
+}
+
 func (s *Server) killUserSubscriber(u *user.User, topicPattern string) error {
 	topics, err := s.topicsFromPattern(topicPattern)
 	if err != nil {
@@ -193,3 +685,351 @@ func (s *Server) killUserSubscriber(u *user.User, topicPattern string) error {
 	}
 	return nil
 }
+
+// handleAuthzExplain reports whether a user may read from or write to a topic, and which rule (admin role,
+// ACL entry, reservation, or default access) produced the decision, e.g. ?user=ben&topic=prod-alerts&perm=write
+func (s *Server) handleAuthzExplain(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	username, topic := adminUsername(readQueryParam(r, "user", "username")), readQueryParam(r, "topic")
+	if username == "" {
+		username = user.Everyone
+	}
+	if !topicRegex.MatchString(topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	permission := readQueryParam(r, "perm", "permission")
+	if permission == "" {
+		permission = "read"
+	}
+	var perm user.Permission
+	switch permission {
+	case "read":
+		perm = user.PermissionRead
+	case "write":
+		perm = user.PermissionWrite
+	default:
+		return errHTTPBadRequestPermissionInvalid
+	}
+	u, err := s.userManager.User(username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	}
+	decision, err := s.userManager.Explain(u, topic, perm)
+	if err != nil {
+		return err
+	}
+	tier := ""
+	if u.Tier != nil {
+		tier = u.Tier.Code
+	}
+	response := &apiAuthzExplainResponse{
+		Username:      u.Name,
+		Role:          string(u.Role),
+		Tier:          tier,
+		Topic:         topic,
+		Permission:    permission,
+		Allowed:       decision.Allowed,
+		Rule:          string(decision.Rule),
+		Reason:        authzExplainReason(decision, s.userManager.DefaultAccess()),
+		DefaultAccess: s.userManager.DefaultAccess().String(),
+	}
+	if decision.Entry != nil {
+		response.Entry = newAuthzExplainEntry(decision.Entry)
+	}
+	for _, entry := range decision.Skipped {
+		response.Skipped = append(response.Skipped, newAuthzExplainEntry(entry))
+	}
+	return s.writeJSON(w, response)
+}
+
+func newAuthzExplainEntry(entry *user.DecisionEntry) *apiAuthzExplainEntry {
+	e := &apiAuthzExplainEntry{
+		Username:    entry.Username,
+		Group:       entry.Group,
+		Topic:       entry.TopicPattern,
+		Permission:  entry.Permission.String(),
+		Owner:       entry.Owner,
+		Provisioned: entry.Provisioned,
+	}
+	if entry.Window != nil {
+		if !entry.Window.Expires.IsZero() {
+			e.Expires = entry.Window.Expires.Unix()
+		}
+		e.Hours = entry.Window.Hours
+		e.Timezone = entry.Window.Timezone
+	}
+	return e
+}
+
+func authzExplainReason(decision *user.Decision, defaultAccess user.Permission) string {
+	verb := "denied"
+	if decision.Allowed {
+		verb = "allowed"
+	}
+	switch decision.Rule {
+	case user.DecisionRuleTokenScope:
+		return "denied, because the scopes of the access token do not include the topic or permission"
+	case user.DecisionRuleAdmin:
+		return "allowed, because admins can access all topics"
+	case user.DecisionRuleReservation:
+		return fmt.Sprintf("%s by the %s access control entry for topic %s, created by user %s's reservation", verb, decision.Entry.Permission, decision.Entry.TopicPattern, decision.Entry.Owner)
+	case user.DecisionRuleUser:
+		return fmt.Sprintf("%s by the user's %s access control entry for topic %s", verb, decision.Entry.Permission, decision.Entry.TopicPattern)
+	case user.DecisionRuleGroup:
+		return fmt.Sprintf("%s by the %s access control entry for topic %s of group %s", verb, decision.Entry.Permission, decision.Entry.TopicPattern, decision.Entry.Group)
+	case user.DecisionRuleEveryone:
+		return fmt.Sprintf("%s by the %s access control entry for topic %s, which applies to everyone", verb, decision.Entry.Permission, decision.Entry.TopicPattern)
+	case user.DecisionRuleWebhook:
+		return fmt.Sprintf("%s by the auth webhook", verb)
+	default:
+		return fmt.Sprintf("%s by the default access (%s), because no access control entry matches the topic", verb, defaultAccess)
+	}
+}
+
+// handleTopicPoliciesGet returns the retention and size policies of all topics, including the ones set by topic owners
+func (s *Server) handleTopicPoliciesGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	policies, err := s.userManager.TopicPolicies()
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAdminTopicPolicy, len(policies))
+	for i, policy := range policies {
+		var owner string
+		if policy.Owner != "" {
+			u, err := s.userManager.UserByID(policy.Owner)
+			if err != nil {
+				return err
+			}
+			owner = u.Name
+		}
+		response[i] = &apiAdminTopicPolicy{
+			Topic:                   policy.Topic,
+			Owner:                   owner,
+			CacheDuration:           int64(policy.CacheDuration.Seconds()),
+			MessageLimit:            policy.MessageLimit,
+			AttachmentFileSizeLimit: policy.AttachmentFileSizeLimit,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleTopicPoliciesChange sets the policy of a topic. Unlike policies set by topic owners, the topic does not
+// have to be reserved, the cache duration is not limited by a tier, and the topic owner cannot change the policy.
+func (s *Server) handleTopicPoliciesChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminTopicPolicy](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	policy := newTopicPolicy(req.Topic, req.CacheDuration, req.MessageLimit, req.AttachmentFileSizeLimit)
+	if err := validateTopicPolicy(policy); err != nil {
+		return err
+	}
+	if err := s.userManager.ChangeTopicPolicy("", policy); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleTopicPoliciesDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminTopicPolicy](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	if err := s.userManager.RemoveTopicPolicy(req.Topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleAdminTopicPurge deletes all messages of a topic, including scheduled messages and attachments
+func (s *Server) handleAdminTopicPurge(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminTopicRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	if err := s.confirmAdminWebAuthn(r, v); err != nil {
+		return err
+	}
+	ids, err := s.messageCache.MessageIDs(req.Topic)
+	if err != nil {
+		return err
+	}
+	if s.fileCache != nil {
+		if err := s.fileCache.Remove(ids...); err != nil {
+			return err
+		}
+	}
+	if err := s.messageCache.DeleteMessages(ids...); err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAudit).
+		Fields(log.Context{"topic": req.Topic, "messages": len(ids)}).
+		Info("Admin %s purged %d message(s) of topic %s", v.User().Name, len(ids), req.Topic)
+	return s.writeJSON(w, &apiAdminTopicPurgeResponse{
+		Topic:    req.Topic,
+		Messages: len(ids),
+	})
+}
+
+// handleAdminTopicClose disconnects all subscribers of a topic, and blocks publishing to it for the given duration
+func (s *Server) handleAdminTopicClose(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminTopicRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	duration := topicCloseDurationDefault
+	if req.Duration != "" {
+		duration, err = util.ParseDuration(req.Duration)
+		if err != nil || duration < time.Minute || duration > topicCloseDurationMax {
+			return errHTTPBadRequestTopicCloseDurationInvalid
+		}
+	}
+	t, err := s.topicFromID(req.Topic)
+	if err != nil {
+		return err
+	}
+	until := time.Now().Add(duration)
+	subscribers := t.Close(until)
+	logvr(v, r).
+		Tag(tagAudit).
+		Fields(log.Context{"topic": req.Topic, "closed_until": until.Unix(), "subscribers": subscribers}).
+		Info("Admin %s closed topic %s for %s, disconnected %d subscriber(s)", v.User().Name, req.Topic, duration, subscribers)
+	return s.writeJSON(w, &apiAdminTopicCloseResponse{
+		Topic:       req.Topic,
+		ClosedUntil: until.Unix(),
+		Subscribers: subscribers,
+	})
+}
+
+// handleAdminTopicReopen allows publishing to a closed topic again
+func (s *Server) handleAdminTopicReopen(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminTopicRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	t, err := s.topicFromID(req.Topic)
+	if err != nil {
+		return err
+	}
+	t.Reopen()
+	logvr(v, r).
+		Tag(tagAudit).
+		Field("topic", req.Topic).
+		Info("Admin %s reopened topic %s", v.User().Name, req.Topic)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleAdminReportsGet returns all abuse reports (or the reports of one topic with ?topic=...), as well as the
+// topics that are currently throttled due to abuse reports
+func (s *Server) handleAdminReportsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic := r.URL.Query().Get("topic")
+	if topic != "" && !topicRegex.MatchString(topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	reports, err := s.messageCache.Reports(topic)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAdminReportsResponse{
+		Reports:   reports,
+		Throttled: s.throttledTopics(),
+	})
+}
+
+// handleAdminReportsDelete resolves abuse reports, either a single report (if "id" is set), or all reports of a topic.
+// If the topic was throttled, and not enough reports remain, the throttle is lifted.
+func (s *Server) handleAdminReportsDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminReportDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	var deleted int
+	if req.ID != "" {
+		found, err := s.messageCache.DeleteReport(req.Topic, req.ID)
+		if err != nil {
+			return err
+		} else if !found {
+			return errHTTPNotFound
+		}
+		deleted = 1
+	} else {
+		deleted, err = s.messageCache.DeleteReports(req.Topic)
+		if err != nil {
+			return err
+		}
+	}
+	t, err := s.topicFromID(req.Topic)
+	if err != nil {
+		return err
+	}
+	if req.ID == "" {
+		t.Unthrottle() // Lift the throttle even if report-throttle-threshold was changed since
+	} else if err := s.maybeThrottleReportedTopic(t); err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAudit).
+		Fields(log.Context{"topic": req.Topic, "report_id": req.ID, "reports": deleted}).
+		Info("Admin %s resolved %d abuse report(s) of topic %s", v.User().Name, deleted, req.Topic)
+	return s.writeJSON(w, &apiAdminReportDeleteResponse{
+		Reports: deleted,
+	})
+}
+
+// throttledTopics returns the sorted IDs of all topics that are currently throttled due to abuse reports
+func (s *Server) throttledTopics() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	throttled := make([]string, 0)
+	for _, t := range s.topics {
+		if t.Throttled() {
+			throttled = append(throttled, t.ID)
+		}
+	}
+	sort.Strings(throttled)
+	return throttled
+}
+
+// adminUsername maps "everyone" to user.Everyone ("*"), so that the admin API accepts the same
+// usernames as "ntfy access"
+func adminUsername(username string) string {
+	if username == "everyone" {
+		return user.Everyone
+	}
+	return username
+}
+
+func newAPIUsersTokenResponse(u *user.User, t *user.Token) *apiUsersTokenResponse {
+	var lastOrigin string
+	if t.LastOrigin != netip.IPv4Unspecified() {
+		lastOrigin = t.LastOrigin.String()
+	}
+	return &apiUsersTokenResponse{
+		Username:      u.Name,
+		Token:         t.Value,
+		Label:         t.Label,
+		LastAccess:    t.LastAccess.Unix(),
+		LastOrigin:    lastOrigin,
+		LastUserAgent: t.LastUserAgent,
+		LastCountry:   t.LastCountry,
+		LastASN:       t.LastASN,
+		Expires:       t.Expires.Unix(),
+		Provisioned:   t.Provisioned,
+		Scopes:        t.Scopes.Strings(),
+		Batch:         t.Batch,
+	}
+}
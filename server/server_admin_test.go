@@ -4,6 +4,8 @@ import (
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"io"
+	"net/netip"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -271,6 +273,151 @@ func TestUser_DontChangeAdminPassword(t *testing.T) {
 	require.Equal(t, 403, rr.Code)
 }
 
+func TestUser_SupportRole(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro", Name: "Pro"}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("sam", "sam", user.RoleSupport, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleSupport, false))
+	auth := map[string]string{
+		"Authorization": util.BasicAuth("sam", "sam"),
+	}
+
+	// Support can list users, including stats, but not their phone numbers and grants
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	require.Nil(t, s.userManager.AddPhoneNumber(ben.ID, "+12223334444"))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	rr := request(t, s, "GET", "/v1/users", "", auth)
+	require.Equal(t, 200, rr.Code)
+	require.NotContains(t, rr.Body.String(), "+12223334444")
+	users, err := util.UnmarshalJSON[[]apiUserResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 5, len(*users))
+	require.Equal(t, "phil", (*users)[0].Username)
+	require.Equal(t, "support", (*users)[1].Role)
+	require.NotNil(t, (*users)[0].Stats)
+	require.Equal(t, "ben", (*users)[3].Username)
+	require.Nil(t, (*users)[3].PhoneNumbers)
+	require.Nil(t, (*users)[3].Grants)
+
+	// Admins see them
+	rr = request(t, s, "GET", "/v1/users", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	users, err = util.UnmarshalJSON[[]apiUserResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, []string{"+12223334444"}, (*users)[3].PhoneNumbers)
+	require.Equal(t, "mytopic", (*users)[3].Grants[0].Topic)
+
+	// Support can change the password of a regular user
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "ben", "password": "ben-two"}`, auth)
+	require.Equal(t, 200, rr.Code)
+	_, err = s.userManager.Authenticate("ben", "ben-two")
+	require.Nil(t, err)
+
+	// Support can revoke the tokens of a regular user
+	_, err = s.userManager.CreateToken(ben.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	rr = request(t, s, "DELETE", "/v1/users/tokens", `{"username": "ben"}`, auth)
+	require.Equal(t, 200, rr.Code)
+	tokens, err := s.userManager.Tokens(ben.ID)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tokens))
+
+	// Support cannot change tiers, add users, or change admins and other support users
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "ben", "tier": "pro"}`, auth)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "ben", "password": "ben-three", "tier": "pro"}`, auth)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "new", "password": "new"}`, auth)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "phil", "password": "phil-new"}`, auth)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "emma", "password": "emma-new"}`, auth)
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/users/tokens", `{"username": "phil"}`, auth)
+	require.Equal(t, 403, rr.Code)
+	_, err = s.userManager.Authenticate("ben", "ben-two")
+	require.Nil(t, err)
+
+	// Support cannot add or remove users, or change ACLs and phone numbers
+	rr = request(t, s, "POST", "/v1/users", `{"username": "new", "password": "new"}`, auth)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, auth)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users/access", `{"username": "ben", "topic": "mytopic", "permission": "rw"}`, auth)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/users/access", `{"username": "ben", "topic": "mytopic"}`, auth)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users/phone", `{"username": "ben", "number": "+1222333444"}`, auth)
+	require.Equal(t, 401, rr.Code)
+
+	// Regular users cannot use the admin API at all
+	rr = request(t, s, "GET", "/v1/users", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben-two"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Admins can change the password of support users
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "emma", "password": "emma-new"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestUser_SupportRole_AdminAPI(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("sam", "sam", user.RoleSupport, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	auth := map[string]string{
+		"Authorization": util.BasicAuth("sam", "sam"),
+	}
+
+	// The /v1/admin endpoints have the same policy as the /v1/users endpoints
+	rr := request(t, s, "GET", "/v1/admin/users", "", auth)
+	require.Equal(t, 200, rr.Code)
+	users, err := util.UnmarshalJSON[[]apiUserResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 4, len(*users))
+
+	rr = request(t, s, "PUT", "/v1/admin/users", `{"username": "ben", "password": "ben-two"}`, auth)
+	require.Equal(t, 200, rr.Code)
+	_, err = s.userManager.Authenticate("ben", "ben-two")
+	require.Nil(t, err)
+	rr = request(t, s, "PUT", "/v1/admin/users", `{"username": "phil", "password": "phil-new"}`, auth)
+	require.Equal(t, 403, rr.Code)
+
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	_, err = s.userManager.CreateToken(ben.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	rr = request(t, s, "DELETE", "/v1/admin/tokens", `{"username": "ben"}`, auth)
+	require.Equal(t, 200, rr.Code)
+	tokens, err := s.userManager.Tokens(ben.ID)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tokens))
+	rr = request(t, s, "DELETE", "/v1/admin/tokens", `{"username": "phil"}`, auth)
+	require.Equal(t, 403, rr.Code)
+
+	// Everything else is still admin-only
+	rr = request(t, s, "POST", "/v1/admin/users", `{"username": "new", "password": "new"}`, auth)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/admin/users", `{"username": "ben"}`, auth)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "GET", "/v1/admin/access", "", auth)
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "GET", "/v1/admin/tokens", "", auth)
+	require.Equal(t, 401, rr.Code)
+}
+
 func TestUser_AddRemove_Failures(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithAuthFile(t))
 	defer s.closeDatabases()
@@ -399,3 +546,503 @@ func TestAccess_AllowReset_KillConnection(t *testing.T) {
 		return timeTaken.Load() >= 500
 	})
 }
+
+func TestUsers_PhoneNumber_AddListDelete(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.TwilioAccount = "AC1234567890"
+	c.TwilioAuthToken = "AAEAA1234567890"
+	c.TwilioPhoneNumber = "+1234567890"
+	c.TwilioVerifyService = "VA1234567890"
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	// Create admin, tier and user
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro", MessageLimit: 10, CallLimit: 1}))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("ben", "pro"))
+
+	// Non-admin cannot add phone numbers without verification
+	rr := request(t, s, "PUT", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Admin adds phone number, no verification needed
+	rr = request(t, s, "PUT", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Adding it again fails, as do invalid numbers and unknown users
+	rr = request(t, s, "PUT", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, 40904, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/users/phone", `{"username":"ben","number":"12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40033, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/users/phone", `{"username":"nobody","number":"+12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+
+	// User and admin can see the phone number
+	rr = request(t, s, "GET", "/v1/account/phone", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	phoneNumbers, err := util.UnmarshalJSON[apiAccountPhoneNumbersResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, []string{"+12223334444"}, phoneNumbers.PhoneNumbers)
+
+	rr = request(t, s, "GET", "/v1/users", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	users, err := util.UnmarshalJSON[[]apiUserResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	for _, u := range *users {
+		if u.Username == "ben" {
+			require.Equal(t, []string{"+12223334444"}, u.PhoneNumbers)
+		} else {
+			require.Nil(t, u.PhoneNumbers)
+		}
+	}
+
+	// Admin removes phone number; removing it again fails
+	rr = request(t, s, "DELETE", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/users/phone", `{"username":"ben","number":"+12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40049, toHTTPError(t, rr.Body.String()).Code)
+
+	// User's list is now empty, and deleting from the account API fails too
+	rr = request(t, s, "GET", "/v1/account/phone", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	phoneNumbers, err = util.UnmarshalJSON[apiAccountPhoneNumbersResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 0, len(phoneNumbers.PhoneNumbers))
+	rr = request(t, s, "DELETE", "/v1/account/phone", `{"number":"+12223334444"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 40049, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestAdmin_UsersAccessTokens(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	admin := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// Create user and list users
+	rr := request(t, s, "POST", "/v1/admin/users", `{"username": "ben", "password":"ben"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/admin/users", "", admin)
+	require.Equal(t, 200, rr.Code)
+	users, err := util.UnmarshalJSON[[]apiUserResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 3, len(*users))
+	require.Equal(t, "ben", (*users)[1].Username)
+
+	// Allow access for ben and everyone
+	rr = request(t, s, "PUT", "/v1/admin/access", `{"username": "ben", "topic":"mytopic", "permission": "rw"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/admin/access", `{"username": "everyone", "topic":"announcements", "permission": "ro"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/admin/access", "", admin)
+	require.Equal(t, 200, rr.Code)
+	access, err := util.UnmarshalJSON[apiAccessResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, "read-write", access.DefaultAccess)
+	require.Equal(t, 3, len(access.Users))
+	require.Equal(t, "ben", access.Users[1].Username)
+	require.Equal(t, "mytopic", access.Users[1].Grants[0].Topic)
+	require.Equal(t, "read-write", access.Users[1].Grants[0].Permission)
+	require.Equal(t, user.Everyone, access.Users[2].Username)
+	require.Equal(t, "announcements", access.Users[2].Grants[0].Topic)
+	require.Equal(t, "read-only", access.Users[2].Grants[0].Permission)
+
+	// Reset access for everyone
+	rr = request(t, s, "DELETE", "/v1/admin/access", `{"username": "everyone", "topic":"announcements"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/admin/access?username=everyone", "", admin)
+	require.Equal(t, 200, rr.Code)
+	access, err = util.UnmarshalJSON[apiAccessResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(access.Users))
+	require.Equal(t, 0, len(access.Users[0].Grants))
+
+	// Create token for ben, and use it
+	rr = request(t, s, "POST", "/v1/admin/tokens", `{"username": "ben", "label": "ci"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiUsersTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, "ben", token.Username)
+	require.Equal(t, "ci", token.Label)
+	require.Equal(t, int64(0), token.Expires)
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// List tokens
+	rr = request(t, s, "GET", "/v1/admin/tokens?username=ben", "", admin)
+	require.Equal(t, 200, rr.Code)
+	tokens, err := util.UnmarshalJSON[[]apiUsersTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*tokens))
+	require.Equal(t, token.Token, (*tokens)[0].Token)
+
+	// Inspect token, including the last access that was not written to the database yet
+	var inspected *apiUsersTokenResponse
+	waitFor(t, func() bool {
+		rr = request(t, s, "POST", "/v1/admin/tokens/inspect", `{"token": "`+token.Token+`"}`, admin)
+		inspected, err = util.UnmarshalJSON[apiUsersTokenResponse](io.NopCloser(rr.Body))
+		return err == nil && inspected.LastOrigin == "9.9.9.9"
+	})
+	require.Equal(t, "ben", inspected.Username)
+	require.Equal(t, "ci", inspected.Label)
+	rr = request(t, s, "POST", "/v1/admin/tokens/inspect", `{"token": "tk_doesnotexist0000000000000000"}`, admin)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40060, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/admin/tokens/inspect", `{"token": "`+token.Token+`"}`, map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Delete token
+	rr = request(t, s, "DELETE", "/v1/admin/tokens", `{"username": "ben", "token": "tk_doesnotexist0000000000000000"}`, admin)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40060, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/admin/tokens", `{"username": "ben", "token": "`+token.Token+`"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Delete user
+	rr = request(t, s, "DELETE", "/v1/admin/users", `{"username": "ben"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	_, err = s.userManager.User("ben")
+	require.Equal(t, user.ErrUserNotFound, err)
+}
+
+func TestAdmin_Groups(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser, false))
+	admin := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+	ben := map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	}
+
+	// Create group and grant access
+	rr := request(t, s, "POST", "/v1/admin/groups", `{"name": "devops", "members": ["ben"]}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/v1/admin/groups", `{"name": "devops"}`, admin)
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, 40909, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/admin/groups", `{"name": "other", "members": ["nobody"]}`, admin)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/admin/groups/members", `{"group": "devops", "username": "emma"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/admin/access", `{"username": "group:devops", "topic": "alerts-*", "permission": "rw"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/admin/access", `{"username": "group:nope", "topic": "alerts-*", "permission": "rw"}`, admin)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40074, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "GET", "/v1/admin/groups", "", admin)
+	require.Equal(t, 200, rr.Code)
+	groups, err := util.UnmarshalJSON[[]apiGroupResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*groups))
+	require.Equal(t, "devops", (*groups)[0].Name)
+	require.Equal(t, []string{"ben", "emma"}, (*groups)[0].Members)
+	require.Equal(t, "alerts-*", (*groups)[0].Grants[0].Topic)
+	require.Equal(t, "read-write", (*groups)[0].Grants[0].Permission)
+	rr = request(t, s, "GET", "/v1/admin/access", "", admin)
+	access, err := util.UnmarshalJSON[apiAccessResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(access.Groups))
+
+	// Members have access
+	require.Equal(t, 200, request(t, s, "PUT", "/alerts-prod", "disk full", ben).Code)
+	rr = request(t, s, "GET", "/v1/admin/authz/explain?user=ben&topic=alerts-prod&perm=write", "", admin)
+	require.Equal(t, 200, rr.Code)
+	explain, err := util.UnmarshalJSON[apiAuthzExplainResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, explain.Allowed)
+	require.Equal(t, "group", explain.Rule)
+	require.Equal(t, "devops", explain.Entry.Group)
+	require.Equal(t, "allowed by the read-write access control entry for topic alerts-* of group devops", explain.Reason)
+
+	// Removed members lose access
+	rr = request(t, s, "DELETE", "/v1/admin/groups/members", `{"group": "devops", "username": "ben"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 403, request(t, s, "PUT", "/alerts-prod", "disk full", ben).Code)
+
+	// Reset group access, and delete group
+	rr = request(t, s, "DELETE", "/v1/admin/access", `{"username": "group:devops", "topic": "alerts-*"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	grants, err := s.userManager.GroupGrants("devops")
+	require.Nil(t, err)
+	require.Empty(t, grants)
+	rr = request(t, s, "DELETE", "/v1/admin/groups", `{"name": "devops"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/admin/groups", `{"name": "devops"}`, admin)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40074, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestAdmin_NonAdminAttempt(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	for _, path := range []string{"/v1/admin/users", "/v1/admin/access", "/v1/admin/groups", "/v1/admin/tokens", "/v1/admin/topic-policies", "/v1/admin/dashboard"} {
+		rr := request(t, s, "GET", path, "", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 401, rr.Code)
+	}
+}
+
+func TestAccess_AuthzExplain(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionRead
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "prod-*", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.AllowAccess("ben", "prod-alerts", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccessWindow("ben", "prod-alerts*", user.PermissionDenyAll, &user.GrantWindow{Hours: time.Now().UTC().Add(2*time.Hour).Format("15:00") + "-" + time.Now().UTC().Add(3*time.Hour).Format("15:00")})) // Not now
+	require.Nil(t, s.userManager.AddReservation("ben", "bens-topic", user.PermissionDenyAll))
+
+	explain := func(query string) *apiAuthzExplainResponse {
+		rr := request(t, s, "GET", "/v1/admin/authz/explain?"+query, "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		response, err := util.UnmarshalJSON[apiAuthzExplainResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		return response
+	}
+
+	// User entry wins over everyone entry
+	response := explain("user=ben&topic=prod-alerts&perm=write")
+	require.True(t, response.Allowed)
+	require.Equal(t, "user", response.Rule)
+	require.Equal(t, "ben", response.Entry.Username)
+	require.Equal(t, "prod-alerts", response.Entry.Topic)
+	require.Equal(t, "read-write", response.Entry.Permission)
+	require.Equal(t, "allowed by the user's read-write access control entry for topic prod-alerts", response.Reason)
+	require.Equal(t, 1, len(response.Skipped))
+	require.Equal(t, "prod-alerts*", response.Skipped[0].Topic)
+
+	// Everyone entry
+	response = explain("user=ben&topic=prod-db&perm=read")
+	require.False(t, response.Allowed)
+	require.Equal(t, "everyone", response.Rule)
+	require.Equal(t, "*", response.Entry.Username)
+	require.Equal(t, "prod-*", response.Entry.Topic)
+
+	// Reservation
+	response = explain("user=everyone&topic=bens-topic&perm=write")
+	require.False(t, response.Allowed)
+	require.Equal(t, "reservation", response.Rule)
+	require.Equal(t, "ben", response.Entry.Owner)
+
+	// Default access, and admin
+	response = explain("topic=other")
+	require.True(t, response.Allowed)
+	require.Equal(t, "default", response.Rule)
+	require.Equal(t, "read", response.Permission)
+	require.Equal(t, "read-only", response.DefaultAccess)
+	require.Nil(t, response.Entry)
+	require.Equal(t, "admin", explain("user=phil&topic=prod-alerts&perm=write").Rule)
+
+	// Invalid requests
+	rr := request(t, s, "GET", "/v1/admin/authz/explain?user=nobody&topic=prod-alerts", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/admin/authz/explain?user=ben&topic=prod-alerts&perm=rw", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40025, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/admin/authz/explain?user=ben&topic=prod-alerts", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+}
+
+func TestAdmin_TopicPolicies(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTopicPolicy("ben", &user.TopicPolicy{Topic: "bens-topic", MessageLimit: 10}))
+
+	// Invalid requests
+	for _, body := range []string{`{"topic":"invalid topic","message_limit":1}`, `{"topic":"alerts"}`, `{"topic":"alerts","cache_duration":-1}`} {
+		rr := request(t, s, "PUT", "/v1/admin/topic-policies", body, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, rr.Code)
+	}
+
+	// Admins are not limited by tiers, and the topic does not have to be reserved
+	rr := request(t, s, "PUT", "/v1/admin/topic-policies", `{"topic":"alerts","cache_duration":2592000,"attachment_file_size_limit":1048576}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/admin/topic-policies", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	policies, err := util.UnmarshalJSON[[]*apiAdminTopicPolicy](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, []*apiAdminTopicPolicy{
+		{Topic: "alerts", CacheDuration: 2592000, AttachmentFileSizeLimit: 1048576},
+		{Topic: "bens-topic", Owner: "ben", MessageLimit: 10},
+	}, *policies)
+
+	// Delete policy
+	rr = request(t, s, "DELETE", "/v1/admin/topic-policies", `{"topic":"alerts"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	_, err = s.userManager.TopicPolicy("alerts")
+	require.Equal(t, user.ErrTopicPolicyNotFound, err)
+}
+
+func TestAdmin_TopicPurgeCloseReopen(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "spam", user.PermissionReadWrite))
+
+	for i := 0; i < 3; i++ {
+		rr := request(t, s, "PUT", "/spam", "spam message", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+
+	// Non-admins cannot purge or close topics
+	rr := request(t, s, "POST", "/v1/admin/topics/purge", `{"topic":"spam"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Purge
+	rr = request(t, s, "POST", "/v1/admin/topics/purge", `{"topic":"spam"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	purged, err := util.UnmarshalJSON[apiAdminTopicPurgeResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 3, purged.Messages)
+	ids, err := s.messageCache.MessageIDs("spam")
+	require.Nil(t, err)
+	require.Empty(t, ids)
+
+	// Close: invalid duration, then publishing is blocked
+	rr = request(t, s, "POST", "/v1/admin/topics/close", `{"topic":"spam","duration":"60d"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40082, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/v1/admin/topics/close", `{"topic":"spam","duration":"10m"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	closed, err := util.UnmarshalJSON[apiAdminTopicCloseResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.InDelta(t, time.Now().Add(10*time.Minute).Unix(), closed.ClosedUntil, 2)
+
+	rr = request(t, s, "PUT", "/spam", "spam message", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40308, toHTTPError(t, rr.Body.String()).Code)
+
+	// Reopen
+	rr = request(t, s, "DELETE", "/v1/admin/topics/close", `{"topic":"spam"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/spam", "spam message", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestAdmin_Dashboard(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.Version = "1.2.3"
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "mytopic", user.PermissionReadWrite))
+
+	// Publish a regular and a scheduled message, and record some deliveries
+	rr := request(t, s, "PUT", "/mytopic", "hi", nil)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/mytopic", "later", map[string]string{
+		"X-Delay": "1h",
+	})
+	require.Equal(t, 200, rr.Code)
+	s.deliveries.Success(deliveryChannelFirebase)
+	s.deliveries.Success(deliveryChannelFirebase)
+	s.deliveries.Success(deliveryChannelFirebase)
+	s.deliveries.Failure(deliveryChannelFirebase)
+
+	// Non-admins cannot see the dashboard
+	rr = request(t, s, "GET", "/v1/admin/dashboard", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Admins can
+	rr = request(t, s, "GET", "/v1/admin/dashboard", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	dashboard, err := util.UnmarshalJSON[apiAdminDashboardResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, "1.2.3", dashboard.Version)
+	require.InDelta(t, time.Now().Unix(), dashboard.Started, 5)
+	require.Equal(t, int64(2), dashboard.Messages)
+	require.Equal(t, int64(3), dashboard.Users) // phil, ben and the everyone user
+	require.Equal(t, int64(3), dashboard.Deliveries["firebase"].Success)
+	require.Equal(t, int64(1), dashboard.Deliveries["firebase"].Failure)
+	require.Equal(t, 0.25, dashboard.Deliveries["firebase"].ErrorRate)
+	require.Equal(t, float64(0), dashboard.Deliveries["email"].ErrorRate)
+	require.Equal(t, 2, dashboard.Cache.Messages)
+	require.Equal(t, 1, dashboard.Pending.ScheduledMessages)
+	require.Equal(t, 0, dashboard.Pending.ClusterQueue)
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func (s *Server) handleAPNSUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAPNSUpdateDeviceRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil || !apnsDeviceTokenRegex.MatchString(req.Token) {
+		return errHTTPBadRequestAPNSDeviceInvalid
+	} else if len(req.Topics) > apnsTopicSubscribeLimit {
+		return errHTTPBadRequestAPNSTopicCountTooHigh
+	}
+	topics, err := s.topicsFromIDs(req.Topics...)
+	if err != nil {
+		return err
+	}
+	if s.userManager != nil {
+		u := v.User()
+		for _, t := range topics {
+			if err := s.userManager.Authorize(u, t.ID, user.PermissionRead); err != nil {
+				logvr(v, r).With(t).Err(err).Debug("Access to topic %s not authorized", t.ID)
+				return errHTTPForbidden.With(t)
+			}
+		}
+	}
+	if err := s.apnsStore.UpsertDevice(req.Token, v.MaybeUserID(), v.IP(), req.Topics); errors.Is(err, errAPNSTooManyDevices) {
+		return errHTTPTooManyRequestsLimitAPNSDevices
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAPNSDelete(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	req, err := readJSONWithLimit[apiAPNSUpdateDeviceRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil || !apnsDeviceTokenRegex.MatchString(req.Token) {
+		return errHTTPBadRequestAPNSDeviceInvalid
+	}
+	if err := s.apnsStore.RemoveDevice(req.Token); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// publishToAPNS sends the message to all iOS devices that registered for the message's topic. Like with Firebase,
+// messages in topics that anonymous users cannot read are sent as poll requests, so their content does not pass
+// through Apple's servers. The app then fetches the message from the server with the user's credentials.
+func (s *Server) publishToAPNS(v *visitor, m *message) {
+	devices, err := s.apnsStore.DevicesForTopic(m.Topic)
+	if err != nil {
+		logvm(v, m).Tag(tagAPNS).Err(err).Warn("Unable to publish to APNs")
+		return
+	} else if len(devices) == 0 {
+		return
+	}
+	if s.userManager != nil && m.Event == messageEvent {
+		if err := s.userManager.Authorize(nil, m.Topic, user.PermissionRead); err != nil {
+			m = newAPNSPollRequest(m)
+		}
+	}
+	notification, err := newAPNSNotification(m)
+	if err != nil {
+		logvm(v, m).Tag(tagAPNS).Err(err).Warn("Unable to create APNs notification")
+		return
+	}
+	logvm(v, m).Tag(tagAPNS).Debug("Publishing to %d APNs device(s)", len(devices))
+	users := newDeliveryUsers(s)
+	for _, device := range devices {
+		if !users.ChannelAllowed(device.UserID, m.Topic, channelPush) {
+			logvm(v, m).Tag(tagAPNS).Debug("Not publishing to APNs device, disabled in subscription preferences")
+			continue
+		}
+		if err := s.apns.Send(device.Token, notification); errors.Is(err, errAPNSDeviceTokenInvalid) {
+			logvm(v, m).Tag(tagAPNS).Err(err).Debug("APNs device token no longer valid, removing device")
+			maddPushDevicesRemoved(pushDeviceTypeAPNS, pushDeviceReasonInvalid, 1)
+			if err := s.apnsStore.RemoveDevice(device.Token); err != nil {
+				logvm(v, m).Tag(tagAPNS).Err(err).Warn("Unable to remove APNs device")
+			}
+			minc(metricAPNSPublishedFailure)
+			s.deliveries.Failure(deliveryChannelAPNS)
+		} else if err != nil {
+			logvm(v, m).Tag(tagAPNS).Err(err).Warn("Unable to publish to APNs: %v", err.Error())
+			minc(metricAPNSPublishedFailure)
+			s.deliveries.Failure(deliveryChannelAPNS)
+		} else {
+			minc(metricAPNSPublishedSuccess)
+			s.deliveries.Success(deliveryChannelAPNS)
+		}
+	}
+}
+
+func (s *Server) pruneAPNSDevices() {
+	if s.apnsStore == nil {
+		return
+	}
+	removed, err := s.apnsStore.RemoveExpiredDevices(s.config.Load().APNSDeviceExpiryDuration)
+	if err != nil {
+		log.Tag(tagAPNS).Err(err).Warn("Unable to remove expired APNs devices")
+		return
+	} else if removed > 0 {
+		log.Tag(tagAPNS).Field("devices_expired", removed).Info("Removed %d APNs device(s) not seen for %s", removed, util.FormatDuration(s.config.Load().APNSDeviceExpiryDuration))
+	}
+	maddPushDevicesRemoved(pushDeviceTypeAPNS, pushDeviceReasonExpired, removed)
+	devices, err := s.apnsStore.DevicesCount()
+	if err != nil {
+		log.Tag(tagAPNS).Err(err).Warn("Unable to count APNs devices")
+		return
+	}
+	msetPushDevices(pushDeviceTypeAPNS, devices)
+}
+
+// newAPNSNotification creates an APNs alert notification for the message. The payload has the same fields as
+// messages sent via Firebase (see toFirebaseMessage), so the Notification Service Extension of the iOS app can
+// process both the same way.
+func newAPNSNotification(m *message) (*apnsNotification, error) {
+	data := map[string]any{
+		"id":       m.ID,
+		"time":     fmt.Sprintf("%d", m.Time),
+		"event":    m.Event,
+		"topic":    m.Topic,
+		"priority": fmt.Sprintf("%d", m.Priority),
+		"tags":     strings.Join(m.Tags, ","),
+		"click":    m.Click,
+		"icon":     m.Icon,
+		"title":    m.Title,
+		"message":  m.Message,
+		"encoding": m.Encoding,
+	}
+	if m.PollID != "" {
+		data["poll_id"] = m.PollID
+	}
+	if m.Encryption != "" {
+		data["encryption"] = m.Encryption
+	}
+	if len(m.Actions) > 0 {
+		actions, err := json.Marshal(m.Actions)
+		if err != nil {
+			return nil, err
+		}
+		data["actions"] = string(actions)
+	}
+	if m.Attachment != nil {
+		data["attachment_name"] = m.Attachment.Name
+		data["attachment_type"] = m.Attachment.Type
+		data["attachment_size"] = fmt.Sprintf("%d", m.Attachment.Size)
+		data["attachment_expires"] = fmt.Sprintf("%d", m.Attachment.Expires)
+		data["attachment_url"] = m.Attachment.URL
+		if m.Attachment.Thumbnail != "" {
+			data["attachment_thumbnail"] = m.Attachment.Thumbnail
+		}
+	}
+	data["aps"] = map[string]any{
+		"mutable-content": 1,
+		"alert": map[string]string{
+			"title": m.Title,
+			"body":  apnsAlertBody(m.Message),
+		},
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if over := len(payload) - apnsPayloadLimit; over > 0 && len(m.Message) > over+16 {
+		data["message"] = strings.ToValidUTF8(m.Message[:len(m.Message)-over-16], "") // 16 = len(`,"truncated":"1"`)
+		data["truncated"] = "1"
+		if payload, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	}
+	priority := 10
+	if m.Priority > 0 && m.Priority <= 2 {
+		priority = 5
+	}
+	return &apnsNotification{
+		Payload:    payload,
+		PushType:   "alert",
+		Priority:   priority,
+		Expiration: time.Now().Add(time.Hour).Unix(),
+	}, nil
+}
+
+// newAPNSPollRequest converts a message to a poll request message, see toPollRequest
+func newAPNSPollRequest(m *message) *message {
+	pr := newPollRequestMessage(m.Topic, m.ID)
+	pr.ID = m.ID
+	pr.Time = m.Time
+	pr.Priority = m.Priority
+	pr.Message = newMessageBody
+	return pr
+}
+
+// apnsAlertBody truncates the message for the visible "body" of the alert, since the full message is also
+// part of the payload, and would otherwise count twice against the payload limit
+func apnsAlertBody(s string) string {
+	if utf8.RuneCountInString(s) > apnsAlertBodyLimit {
+		return string([]rune(s)[:apnsAlertBodyLimit-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_APNS_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_APNS_Register(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAPNS(t))
+
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, nil)
+	require.Equal(t, 200, response.Code)
+	requireAPNSDeviceCount(t, s, "mytopic", 1)
+
+	response = request(t, s, "POST", "/v1/apns", `{"token":"not-hex","topics":["mytopic"]}`, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40064, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "DELETE", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`"}`, nil)
+	require.Equal(t, 200, response.Code)
+	requireAPNSDeviceCount(t, s, "mytopic", 0)
+}
+
+func TestServer_APNS_Register_TooManyTopics(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAPNS(t))
+
+	topics := make([]string, 0)
+	for i := 0; i < 101; i++ {
+		topics = append(topics, util.RandomString(5))
+	}
+	topicsJSON, _ := json.Marshal(topics)
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":`+string(topicsJSON)+`}`, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40065, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_APNS_Register_ProtectedDenied(t *testing.T) {
+	conf := configureAuth(t, newTestConfigWithAPNS(t))
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, nil)
+	require.Equal(t, 403, response.Code)
+	requireAPNSDeviceCount(t, s, "mytopic", 0)
+}
+
+func TestServer_APNS_DeleteAccountRemovesDevices(t *testing.T) {
+	s := newTestServer(t, configureAuth(t, newTestConfigWithAPNS(t)))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	requireAPNSDeviceCount(t, s, "mytopic", 1)
+
+	request(t, s, "DELETE", "/v1/account", `{"password":"ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	requireAPNSDeviceCount(t, s, "mytopic", 0)
+}
+
+func TestServer_APNS_PruneExpiredDevices(t *testing.T) {
+	c := newTestConfigWithAPNS(t)
+	c.APNSDeviceExpiryDuration = 7 * 24 * time.Hour
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`1","topics":["mytopic"]}`, nil)
+	require.Equal(t, 200, response.Code)
+	s.execManager()
+	requireAPNSDeviceCount(t, s, "mytopic", 2)
+
+	_, err := s.apnsStore.db.Exec("UPDATE device SET updated_at = ? WHERE token = ?", time.Now().Add(-8*24*time.Hour).Unix(), testAPNSDeviceToken)
+	require.Nil(t, err)
+	s.execManager()
+	requireAPNSDeviceCount(t, s, "mytopic", 1)
+}
+
+func TestServer_APNS_Publish(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAPNS(t))
+
+	var payload atomic.Pointer[map[string]any]
+	apnsServer := newTestAPNSServer(t, s, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/3/device/"+testAPNSDeviceToken, r.URL.Path)
+		require.Equal(t, "io.heckel.ntfy", r.Header.Get("apns-topic"))
+		require.Equal(t, "alert", r.Header.Get("apns-push-type"))
+		require.Equal(t, "10", r.Header.Get("apns-priority"))
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "bearer "))
+		var m map[string]any
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&m))
+		payload.Store(&m)
+	})
+	defer apnsServer.Close()
+
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, nil)
+	require.Equal(t, 200, response.Code)
+	request(t, s, "POST", "/mytopic", "hi there", map[string]string{"Title": "Test"})
+
+	waitFor(t, func() bool {
+		return payload.Load() != nil
+	})
+	m := *payload.Load()
+	require.Equal(t, "message", m["event"])
+	require.Equal(t, "mytopic", m["topic"])
+	require.Equal(t, "hi there", m["message"])
+	aps := m["aps"].(map[string]any)
+	require.Equal(t, float64(1), aps["mutable-content"])
+	require.Equal(t, map[string]any{"title": "Test", "body": "hi there"}, aps["alert"])
+}
+
+func TestServer_APNS_Publish_PollRequestIfProtected(t *testing.T) {
+	conf := configureAuth(t, newTestConfigWithAPNS(t))
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	var payload atomic.Pointer[map[string]any]
+	apnsServer := newTestAPNSServer(t, s, func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]any
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&m))
+		payload.Store(&m)
+	})
+	defer apnsServer.Close()
+
+	headers := map[string]string{"Authorization": util.BasicAuth("ben", "ben")}
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, headers)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/mytopic", "secret message", headers)
+	msg := toMessage(t, response.Body.String())
+
+	waitFor(t, func() bool {
+		return payload.Load() != nil
+	})
+	m := *payload.Load()
+	require.Equal(t, "poll_request", m["event"])
+	require.Equal(t, msg.ID, m["poll_id"])
+	require.Equal(t, "New message", m["message"])
+}
+
+func TestServer_APNS_Publish_DeliveryPrefs(t *testing.T) {
+	s := newTestServer(t, configureAuth(t, newTestConfigWithAPNS(t)))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	var mu sync.Mutex
+	received := make([]string, 0)
+	apnsServer := newTestAPNSServer(t, s, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, strings.TrimPrefix(r.URL.Path, "/3/device/"))
+	})
+	defer apnsServer.Close()
+
+	// Ben disables mobile push for the subscription, the anonymous device still gets the message
+	headers := map[string]string{"Authorization": util.BasicAuth("ben", "ben")}
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, headers)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`1","topics":["mytopic"]}`, nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/v1/account/subscription", `{"base_url":"http://127.0.0.1:12345","topic":"mytopic","delivery":{"push":{"enabled":false}}}`, headers)
+	require.Equal(t, 200, response.Code)
+
+	request(t, s, "POST", "/mytopic", "hi there", nil)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	})
+	time.Sleep(300 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{testAPNSDeviceToken + "1"}, received)
+}
+
+func TestServer_APNS_Publish_RemoveOnError(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAPNS(t))
+
+	var received atomic.Bool
+	apnsServer := newTestAPNSServer(t, s, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(`{"reason":"Unregistered","timestamp":1700000000000}`))
+		received.Store(true)
+	})
+	defer apnsServer.Close()
+
+	response := request(t, s, "POST", "/v1/apns", `{"token":"`+testAPNSDeviceToken+`","topics":["mytopic"]}`, nil)
+	require.Equal(t, 200, response.Code)
+	request(t, s, "POST", "/mytopic", "hi there", nil)
+
+	waitFor(t, func() bool {
+		devices, err := s.apnsStore.DevicesForTopic("mytopic")
+		require.Nil(t, err)
+		return received.Load() && len(devices) == 0
+	})
+}
+
+func TestAPNSClient_Send_Errors(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAPNS(t))
+
+	var status atomic.Int32
+	var reason atomic.Value
+	apnsServer := newTestAPNSServer(t, s, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(`{"reason":"` + reason.Load().(string) + `"}`))
+	})
+	defer apnsServer.Close()
+
+	notification := &apnsNotification{Payload: []byte(`{}`), PushType: "alert", Priority: 10}
+	status.Store(400)
+	reason.Store("BadDeviceToken")
+	require.True(t, errors.Is(s.apns.Send(testAPNSDeviceToken, notification), errAPNSDeviceTokenInvalid))
+
+	token, err := s.apns.authToken()
+	require.Nil(t, err)
+	status.Store(403)
+	reason.Store("ExpiredProviderToken")
+	err = s.apns.Send(testAPNSDeviceToken, notification)
+	require.NotNil(t, err)
+	require.False(t, errors.Is(err, errAPNSDeviceTokenInvalid))
+	require.Equal(t, "", s.apns.token)
+	require.NotEqual(t, "", token)
+}
+
+func TestAPNS_SignToken(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	token, err := signAPNSToken(key, "ABC123DEFG", "DEF123GHIJ", time.Unix(1700000000, 0))
+	require.Nil(t, err)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	require.Nil(t, err)
+	require.JSONEq(t, `{"alg":"ES256","kid":"ABC123DEFG"}`, string(header))
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.Nil(t, err)
+	require.JSONEq(t, `{"iss":"DEF123GHIJ","iat":1700000000}`, string(claims))
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.Nil(t, err)
+	require.Len(t, signature, 64)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	require.True(t, ecdsa.Verify(&key.PublicKey, hash[:], r, s))
+}
+
+func TestAPNS_ParseKey_Invalid(t *testing.T) {
+	_, err := parseAPNSKey([]byte("not a key"))
+	require.Equal(t, errAPNSKeyInvalid, err)
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.Nil(t, err)
+	b, err := x509.MarshalPKCS8PrivateKey(p384Key)
+	require.Nil(t, err)
+	_, err = parseAPNSKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+	require.Equal(t, errAPNSKeyInvalid, err)
+}
+
+func TestAPNS_NewNotification_Truncated(t *testing.T) {
+	m := newDefaultMessage("mytopic", strings.Repeat("this is a long message ", 300))
+	notification, err := newAPNSNotification(m)
+	require.Nil(t, err)
+	require.LessOrEqual(t, len(notification.Payload), apnsPayloadLimit)
+
+	var payload map[string]any
+	require.Nil(t, json.Unmarshal(notification.Payload, &payload))
+	require.Equal(t, "1", payload["truncated"])
+	body := payload["aps"].(map[string]any)["alert"].(map[string]any)["body"].(string)
+	require.Equal(t, apnsAlertBodyLimit, len([]rune(body)))
+	require.True(t, strings.HasSuffix(body, "..."))
+}
+
+func newTestConfigWithAPNS(t *testing.T) *Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "AuthKey_ABC123DEFG.p8")
+	require.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), 0600))
+	conf := newTestConfig(t)
+	conf.APNSKeyFile = keyFile
+	conf.APNSKeyID = "ABC123DEFG"
+	conf.APNSTeamID = "DEF123GHIJ"
+	conf.APNSFile = filepath.Join(t.TempDir(), "apns.db")
+	return conf
+}
+
+// newTestAPNSServer starts an HTTP/2 server that stands in for APNs, and points the server's APNs client to it
+func newTestAPNSServer(t *testing.T, s *Server, handler http.HandlerFunc) *httptest.Server {
+	apnsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "HTTP/2.0", r.Proto)
+		handler(w, r)
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	apnsServer.EnableHTTP2 = true
+	apnsServer.StartTLS()
+	s.apns.httpClient = apnsServer.Client()
+	s.apns.baseURL = apnsServer.URL
+	return apnsServer
+}
+
+func requireAPNSDeviceCount(t *testing.T, s *Server, topic string, expectedLength int) {
+	devices, err := s.apnsStore.DevicesForTopic(topic)
+	require.Nil(t, err)
+	require.Len(t, devices, expectedLength)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// banList is an in-memory copy of the bans in the user database, so that banned IP ranges, users and tokens can be
+// rejected early in the request path, without a database query per request. It is reloaded by the manager (to pick
+// up bans added via "ntfy ban"), and whenever bans are changed via the admin API.
+type banList struct {
+	prefixes []*bannedPrefix
+	users    map[string]*user.Ban // Username -> ban
+	tokens   map[string]*user.Ban // Token -> ban
+	mu       sync.RWMutex
+}
+
+type bannedPrefix struct {
+	prefix netip.Prefix
+	ban    *user.Ban
+}
+
+func newBanList() *banList {
+	return &banList{
+		prefixes: make([]*bannedPrefix, 0),
+		users:    make(map[string]*user.Ban),
+		tokens:   make(map[string]*user.Ban),
+	}
+}
+
+// Update replaces all bans in the list
+func (b *banList) Update(bans []*user.Ban) {
+	prefixes, users, tokens := make([]*bannedPrefix, 0), make(map[string]*user.Ban), make(map[string]*user.Ban)
+	for _, ban := range bans {
+		switch ban.Kind {
+		case user.BanKindIP:
+			prefix, err := netip.ParsePrefix(ban.Value)
+			if err != nil {
+				log.Tag(tagBan).Err(err).Warn("Ignoring ban %s, invalid IP range %s", ban.ID, ban.Value)
+				continue
+			}
+			prefixes = append(prefixes, &bannedPrefix{prefix: prefix, ban: ban})
+		case user.BanKindUser:
+			users[ban.Value] = ban
+		case user.BanKindToken:
+			tokens[ban.Value] = ban
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prefixes, b.users, b.tokens = prefixes, users, tokens
+}
+
+// IP returns the ban that applies to the given IP address, or nil if it is not banned. Works with a nil receiver.
+func (b *banList) IP(ip netip.Addr) *user.Ban {
+	if b == nil {
+		return nil
+	}
+	ip = ip.Unmap()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, p := range b.prefixes {
+		if p.prefix.Contains(ip) && !p.ban.Expired() {
+			return p.ban
+		}
+	}
+	return nil
+}
+
+// User returns the ban that applies to the given user, or to the token it authenticated with, or nil if neither
+// is banned. Works with a nil receiver.
+func (b *banList) User(u *user.User) *user.Ban {
+	if b == nil || u == nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if ban, ok := b.users[u.Name]; ok && !ban.Expired() {
+		return ban
+	} else if ban, ok := b.tokens[u.Token]; ok && u.Token != "" && !ban.Expired() {
+		return ban
+	}
+	return nil
+}
+
+// reloadBans reads all bans from the user database into the in-memory ban list
+func (s *Server) reloadBans() {
+	if s.bans == nil {
+		return
+	}
+	bans, err := s.userManager.Bans()
+	if err != nil {
+		log.Tag(tagBan).Err(err).Warn("Cannot load bans")
+		return
+	}
+	s.bans.Update(bans)
+}
+
+// rejectBanned logs a request from a banned IP address, user or token, and returns the error to reject it with.
+// The reason of the ban is only logged, and not returned to the client.
+func (s *Server) rejectBanned(r *http.Request, v *visitor, ban *user.Ban) error {
+	logvr(v, r).
+		Tag(tagBan).
+		Fields(log.Context{"ban_id": ban.ID, "ban_kind": ban.Kind, "ban_value": ban.Value, "ban_reason": ban.Reason}).
+		Info("Rejecting request, %s %s is banned", ban.Kind, ban.Value)
+	return errHTTPForbiddenBanned
+}
+
+// handleAdminBansGet returns all active bans, oldest first
+func (s *Server) handleAdminBansGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	bans, err := s.userManager.Bans()
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAdminBan, len(bans))
+	for i, ban := range bans {
+		response[i] = newAPIAdminBan(ban)
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAdminBansAdd bans an IP address or range, a user, or an access token, optionally for a limited duration.
+// Banning the same IP range, user or token again replaces the existing ban. The ban applies immediately.
+func (s *Server) handleAdminBansAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminBanRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedBanKind(user.BanKind(req.Kind)) {
+		return errHTTPBadRequestBanInvalid
+	}
+	expires := time.Unix(0, 0)
+	if req.Duration != "" {
+		duration, err := util.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			return errHTTPBadRequestBanInvalid
+		}
+		expires = time.Now().Add(duration)
+	}
+	ban, err := s.userManager.AddBan(user.BanKind(req.Kind), req.Value, req.Reason, expires)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if errors.Is(err, user.ErrInvalidArgument) {
+		return errHTTPBadRequestBanInvalid
+	} else if err != nil {
+		return err
+	}
+	s.reloadBans()
+	logvr(v, r).
+		Tag(tagAudit).
+		Fields(log.Context{"ban_id": ban.ID, "ban_kind": ban.Kind, "ban_value": ban.Value, "ban_reason": ban.Reason, "ban_expires": ban.Expires.Unix()}).
+		Info("Admin %s banned %s %s", v.User().Name, ban.Kind, ban.Value)
+	return s.writeJSON(w, newAPIAdminBan(ban))
+}
+
+// handleAdminBansDelete lifts a ban, identified by its ID
+func (s *Server) handleAdminBansDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminBanRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if err := s.userManager.RemoveBan(req.ID); errors.Is(err, user.ErrBanNotFound) {
+		return errHTTPBadRequestBanNotFound
+	} else if err != nil {
+		return err
+	}
+	s.reloadBans()
+	logvr(v, r).
+		Tag(tagAudit).
+		Fields(log.Context{"ban_id": req.ID}).
+		Info("Admin %s lifted ban %s", v.User().Name, req.ID)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func newAPIAdminBan(ban *user.Ban) *apiAdminBan {
+	return &apiAdminBan{
+		ID:      ban.ID,
+		Kind:    string(ban.Kind),
+		Value:   ban.Value,
+		Reason:  ban.Reason,
+		Created: ban.Created.Unix(),
+		Expires: ban.Expires.Unix(),
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_AdminBans_IP(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	fromBannedRange := func(r *http.Request) {
+		r.RemoteAddr = "[2001:db8:1::5]:1234"
+	}
+
+	response := request(t, s, "POST", "/v1/admin/bans", `{"kind":"ip","value":"2001:db8::/32","reason":"spam"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	ban, err := util.UnmarshalJSON[apiAdminBan](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "ip", ban.Kind)
+	require.Equal(t, "2001:db8::/32", ban.Value)
+	require.Equal(t, int64(0), ban.Expires)
+
+	// Banned range is rejected, even with valid credentials; everyone else is not affected
+	response = request(t, s, "PUT", "/mytopic", "hi", nil, fromBannedRange)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40309, toHTTPError(t, response.Body.String()).Code)
+	require.NotContains(t, response.Body.String(), "spam")
+	response = request(t, s, "GET", "/v1/admin/bans", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}, fromBannedRange)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "hi", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/admin/bans", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	bans, err := util.UnmarshalJSON[[]*apiAdminBan](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*bans))
+	require.Equal(t, "spam", (*bans)[0].Reason)
+
+	// Lifting the ban applies immediately
+	response = request(t, s, "DELETE", "/v1/admin/bans", fmt.Sprintf(`{"id":"%s"}`, ban.ID), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "hi", nil, fromBannedRange)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/admin/bans", fmt.Sprintf(`{"id":"%s"}`, ban.ID), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40097, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AdminBans_UserAndToken(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	token1, err := s.userManager.CreateToken(ben.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	token2, err := s.userManager.CreateToken(ben.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+
+	// Banning a token only affects that token
+	response := request(t, s, "POST", "/v1/admin/bans", fmt.Sprintf(`{"kind":"token","value":"%s","duration":"1h"}`, token1.Value), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	ban, err := util.UnmarshalJSON[apiAdminBan](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), ban.Expires, 2)
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": "Bearer " + token1.Value,
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40309, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": "Bearer " + token2.Value,
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Banning a user affects the password and all tokens
+	response = request(t, s, "POST", "/v1/admin/bans", `{"kind":"user","value":"ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": "Bearer " + token2.Value,
+	})
+	require.Equal(t, 403, response.Code)
+
+	// Bans added outside of the server (e.g. via the CLI) are picked up by the manager
+	_, err = s.userManager.AddBan(user.BanKindIP, "9.9.9.9", "", time.Unix(0, 0))
+	require.Nil(t, err)
+	response = request(t, s, "PUT", "/othertopic", "hi", nil)
+	require.Equal(t, 200, response.Code)
+	s.execManager()
+	response = request(t, s, "PUT", "/othertopic", "hi", nil)
+	require.Equal(t, 403, response.Code)
+}
+
+func TestServer_AdminBans_UserSessionRefresh(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	session, err := s.userManager.CreateSession(ben.ID, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Nil(t, err)
+
+	response := request(t, s, "POST", "/v1/admin/bans", `{"kind":"user","value":"ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	ban, err := util.UnmarshalJSON[apiAdminBan](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	// Banned users cannot refresh their session
+	response = request(t, s, "POST", "/v1/account/token/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, session.RefreshToken), nil)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40309, toHTTPError(t, response.Body.String()).Code)
+
+	// The tokens were not rotated, so the refresh token still works once the ban is lifted
+	response = request(t, s, "DELETE", "/v1/admin/bans", fmt.Sprintf(`{"id":"%s"}`, ban.ID), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/v1/account/token/refresh", fmt.Sprintf(`{"refresh_token":"%s"}`, session.RefreshToken), nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_AdminBans_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	response := request(t, s, "POST", "/v1/admin/bans", `{"kind":"ip","value":"1.2.3.4"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+	for _, body := range []string{
+		`{"kind":"ip","value":"1.2.3"}`,
+		`{"kind":"topic","value":"mytopic"}`,
+		`{"kind":"token","value":"not-a-token"}`,
+		`{"kind":"ip","value":"1.2.3.4","duration":"forever"}`,
+	} {
+		response = request(t, s, "POST", "/v1/admin/bans", body, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, response.Code, body)
+		require.Equal(t, 40096, toHTTPError(t, response.Body.String()).Code, body)
+	}
+	response = request(t, s, "POST", "/v1/admin/bans", `{"kind":"user","value":"doesnotexist"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40031, toHTTPError(t, response.Body.String()).Code)
+}
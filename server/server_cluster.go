@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	clusterSecretHeader    = "X-Cluster-Secret"
+	clusterQueueSize       = 10000 // Max. number of messages queued per peer, before messages are dropped
+	clusterBatchSize       = 100   // Max. number of messages sent to a peer in one request
+	clusterSendRetries     = 3
+	clusterSendRetryDelay  = time.Second
+	clusterSendTimeout     = 10 * time.Second
+	clusterLineLimitFactor = 4 // Max. size of a replicated message line, as a factor of the message size limit
+	clusterLineLimitExtra  = 64 * 1024
+)
+
+// cluster replicates published messages to the other ntfy servers in the cluster (see cluster-peers), so that
+// subscribers connected to any of the servers receive them. Each peer has its own queue and worker, so that
+// a slow or unreachable peer does not hold up the others. Messages are sent in batches as JSON lines to the
+// cluster endpoint of the peer (see handleClusterMessages), authenticated via the shared cluster secret.
+//
+// All methods work with a nil receiver, in which case they do nothing.
+type cluster struct {
+	peers     []*clusterPeer
+	closeChan chan bool
+}
+
+type clusterPeer struct {
+	url        string
+	secret     string
+	version    string
+	queue      chan *message
+	client     *http.Client
+	deliveries *deliveryStats
+}
+
+// clusterMessage is the wire format of a replicated message. In addition to the JSON fields of the message,
+// it contains the fields that are not exposed to clients, but are needed to cache the message on the peer.
+type clusterMessage struct {
+	*message
+	Sender   string   `json:"sender,omitempty"`
+	User     string   `json:"user,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	TTL      int64    `json:"ttl,omitempty"`
+}
+
+func newCluster(conf *Config, deliveries *deliveryStats) *cluster {
+	if len(conf.ClusterPeers) == 0 {
+		return nil
+	}
+	c := &cluster{
+		peers:     make([]*clusterPeer, 0),
+		closeChan: make(chan bool),
+	}
+	for _, peerURL := range conf.ClusterPeers {
+		peer := &clusterPeer{
+			url:        peerURL,
+			secret:     conf.ClusterSecret,
+			version:    conf.Version,
+			queue:      make(chan *message, clusterQueueSize),
+			client:     &http.Client{Timeout: clusterSendTimeout},
+			deliveries: deliveries,
+		}
+		c.peers = append(c.peers, peer)
+		go peer.run(c.closeChan)
+	}
+	return c
+}
+
+// Publish queues the message for replication to all peers. It never blocks; if the queue of a
+// peer is full, the message is dropped for that peer.
+func (c *cluster) Publish(m *message) {
+	if c == nil {
+		return
+	}
+	for _, peer := range c.peers {
+		select {
+		case peer.queue <- m:
+		default:
+			minc(metricClusterForwardedFailure)
+			peer.deliveries.Failure(deliveryChannelCluster)
+			log.Tag(tagCluster).With(m).Field("cluster_peer", peer.url).Warn("Cluster queue for peer is full, dropping message")
+		}
+	}
+}
+
+// QueueLength returns the number of messages that are waiting to be replicated, across all peers
+func (c *cluster) QueueLength() int {
+	if c == nil {
+		return 0
+	}
+	length := 0
+	for _, peer := range c.peers {
+		length += len(peer.queue)
+	}
+	return length
+}
+
+// Stop stops all peer workers. Queued messages are discarded.
+func (c *cluster) Stop() {
+	if c == nil {
+		return
+	}
+	close(c.closeChan)
+}
+
+func (p *clusterPeer) run(closeChan chan bool) {
+	for {
+		select {
+		case <-closeChan:
+			return
+		case m := <-p.queue:
+			batch := []*message{m}
+		drain:
+			for len(batch) < clusterBatchSize {
+				select {
+				case m := <-p.queue:
+					batch = append(batch, m)
+				default:
+					break drain
+				}
+			}
+			p.sendWithRetry(batch, closeChan)
+		}
+	}
+}
+
+func (p *clusterPeer) sendWithRetry(batch []*message, closeChan chan bool) {
+	var err error
+	for i := 0; i < clusterSendRetries; i++ {
+		if err = p.send(batch); err == nil {
+			for range batch {
+				minc(metricClusterForwardedSuccess)
+				p.deliveries.Success(deliveryChannelCluster)
+			}
+			log.Tag(tagCluster).Field("cluster_peer", p.url).Trace("Replicated %d message(s) to peer", len(batch))
+			return
+		}
+		select {
+		case <-closeChan:
+			return
+		case <-time.After(clusterSendRetryDelay):
+		}
+	}
+	for range batch {
+		minc(metricClusterForwardedFailure)
+		p.deliveries.Failure(deliveryChannelCluster)
+	}
+	log.Tag(tagCluster).Field("cluster_peer", p.url).Err(err).Warn("Unable to replicate %d message(s) to peer, giving up", len(batch))
+}
+
+func (p *clusterPeer) send(batch []*message) error {
+	var buf bytes.Buffer
+	for _, m := range batch {
+		cm := &clusterMessage{
+			message:  m,
+			User:     m.User,
+			Channels: m.Channels,
+			TTL:      m.TTL,
+		}
+		if m.Sender.IsValid() {
+			cm.Sender = m.Sender.String()
+		}
+		if err := json.NewEncoder(&buf).Encode(cm); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url+apiClusterMessagesPath, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "ntfy/"+p.version)
+	req.Header.Set(clusterSecretHeader, p.secret)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer responded with HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// handleClusterMessages receives messages replicated by a cluster peer. Messages are cached (if they were cached
+// on the originating server) and published to the local subscribers of the topic. They are not replicated further,
+// and not forwarded to Firebase, web push, email, etc., since the originating server already did that.
+func (s *Server) handleClusterMessages(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(clusterSecretHeader)), []byte(s.config.Load().ClusterSecret)) != 1 {
+		return errHTTPUnauthorized
+	}
+	lineLimit := clusterLineLimitFactor*s.config.Load().MessageSizeLimit + clusterLineLimitExtra
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), lineLimit)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		cm := &clusterMessage{message: &message{}}
+		if err := json.Unmarshal(scanner.Bytes(), cm); err != nil {
+			return errHTTPBadRequestJSONInvalid
+		} else if cm.ID == "" || !topicRegex.MatchString(cm.Topic) {
+			return errHTTPBadRequestJSONInvalid
+		}
+		m := cm.message
+		m.User = cm.User
+		m.Channels = cm.Channels
+		m.TTL = cm.TTL
+		if cm.Sender != "" {
+			if sender, err := netip.ParseAddr(cm.Sender); err == nil {
+				m.Sender = sender
+			}
+		}
+		if err := s.receiveClusterMessage(v, m); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return errHTTPEntityTooLargeJSONBody
+		}
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) receiveClusterMessage(v *visitor, m *message) error {
+	if m.Event == messageUpdatedEvent || m.Event == messageDeletedEvent {
+		return s.receiveClusterMessageChange(v, m) // Same ID as the original message, see newMessageUpdatedMessage
+	} else if _, err := s.messageCache.Message(m.ID); err == nil {
+		logvm(v, m).Tag(tagCluster).Debug("Replicated message already known, ignoring")
+		return nil
+	} else if !errors.Is(err, errMessageNotFound) {
+		return err
+	}
+	logvm(v, m).Tag(tagCluster).Debug("Received replicated message")
+	minc(metricClusterReceived)
+	if m.Expires > 0 {
+		if err := s.messageCache.AddMessage(m); err != nil {
+			return err
+		}
+	}
+	s.mu.RLock()
+	t, ok := s.topics[m.Topic] // If no subscribers, there is nothing to publish
+	s.mu.RUnlock()
+	if ok {
+		if err := t.Publish(v, m); err != nil {
+			logvm(v, m).Tag(tagCluster).Err(err).Warn("Unable to publish replicated message")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Cluster_ReplicateMessage(t *testing.T) {
+	c2 := newTestConfig(t)
+	c2.ClusterPeers = []string{"http://127.0.0.1:1"} // Enables the cluster endpoint
+	c2.ClusterSecret = "s3cret"
+	s2 := newTestServer(t, c2)
+	defer s2.cluster.Stop()
+	peer := httptest.NewServer(http.HandlerFunc(s2.handle))
+	defer peer.Close()
+
+	c1 := newTestConfig(t)
+	c1.ClusterPeers = []string{peer.URL}
+	c1.ClusterSecret = "s3cret"
+	s1 := newTestServer(t, c1)
+	defer s1.cluster.Stop()
+
+	response := request(t, s1, "PUT", "/mytopic", "hi from node 1", map[string]string{
+		"Title": "A title",
+		"Tags":  "tag1",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	var replicated []*message
+	waitFor(t, func() bool {
+		response = request(t, s2, "GET", "/mytopic/json?poll=1", "", nil)
+		replicated = toMessages(t, response.Body.String())
+		return len(replicated) == 1
+	})
+	require.Equal(t, m.ID, replicated[0].ID)
+	require.Equal(t, "hi from node 1", replicated[0].Message)
+	require.Equal(t, "A title", replicated[0].Title)
+	require.Equal(t, []string{"tag1"}, replicated[0].Tags)
+	require.Equal(t, m.Expires, replicated[0].Expires)
+
+	// Replicating the same message again does not duplicate it
+	s1.cluster.Publish(m)
+	response = request(t, s1, "PUT", "/mytopic", "second message", nil)
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		response = request(t, s2, "GET", "/mytopic/json?poll=1", "", nil)
+		replicated = toMessages(t, response.Body.String())
+		return len(replicated) == 2
+	})
+	require.Equal(t, "second message", replicated[1].Message)
+}
+
+func TestServer_Cluster_Unauthorized(t *testing.T) {
+	c := newTestConfig(t)
+	c.ClusterPeers = []string{"http://127.0.0.1:1"}
+	c.ClusterSecret = "s3cret"
+	s := newTestServer(t, c)
+	defer s.cluster.Stop()
+
+	body := `{"id":"abcdefghijkl","time":1700000000,"expires":1900000000,"event":"message","topic":"mytopic","message":"hi"}`
+	response := request(t, s, "POST", "/v1/cluster/messages", body, nil)
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "POST", "/v1/cluster/messages", body, map[string]string{
+		"X-Cluster-Secret": "wrong",
+	})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "POST", "/v1/cluster/messages", `{"id":"abcdefghijkl","topic":"my/topic"}`, map[string]string{
+		"X-Cluster-Secret": "s3cret",
+	})
+	require.Equal(t, 400, response.Code)
+
+	response = request(t, s, "POST", "/v1/cluster/messages", body, map[string]string{
+		"X-Cluster-Secret": "s3cret",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, "hi", toMessage(t, response.Body.String()).Message)
+}
+
+func TestServer_Cluster_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "POST", "/v1/cluster/messages", "", map[string]string{
+		"X-Cluster-Secret": "",
+	})
+	require.Equal(t, 404, response.Code)
+}
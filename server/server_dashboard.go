@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Delivery channels counted by deliveryStats, see the admin dashboard
+const (
+	deliveryChannelFirebase = "firebase"
+	deliveryChannelAPNS     = "apns"
+	deliveryChannelWebPush  = "webpush"
+	deliveryChannelEmail    = "email"
+	deliveryChannelCall     = "call"
+	deliveryChannelWebhook  = "webhook"
+	deliveryChannelCluster  = "cluster"
+)
+
+var deliveryChannels = []string{
+	deliveryChannelFirebase,
+	deliveryChannelAPNS,
+	deliveryChannelWebPush,
+	deliveryChannelEmail,
+	deliveryChannelCall,
+	deliveryChannelWebhook,
+	deliveryChannelCluster,
+}
+
+// deliveryStats counts successful and failed deliveries per channel (Firebase, APNs, emails, ...) since the
+// server was started. Unlike the Prometheus metrics, the counters are always kept, so that the admin dashboard
+// works even if enable-metrics is not set.
+//
+// All methods work with a nil receiver, in which case they do nothing.
+type deliveryStats struct {
+	success map[string]int64
+	failure map[string]int64
+	mu      sync.Mutex
+}
+
+func newDeliveryStats() *deliveryStats {
+	return &deliveryStats{
+		success: make(map[string]int64),
+		failure: make(map[string]int64),
+	}
+}
+
+// Success records a successful delivery via the given channel
+func (d *deliveryStats) Success(channel string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.success[channel]++
+}
+
+// Failure records a failed delivery via the given channel
+func (d *deliveryStats) Failure(channel string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failure[channel]++
+}
+
+// Counts returns the number of successful and failed deliveries via the given channel
+func (d *deliveryStats) Counts(channel string) (success int64, failure int64) {
+	if d == nil {
+		return 0, 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.success[channel], d.failure[channel]
+}
+
+// handleAdminDashboard returns the service-level stats that are needed for a status page (uptime, subscribers,
+// message rate, delivery error rates, cache sizes and pending background jobs) in a single JSON document
+func (s *Server) handleAdminDashboard(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	s.mu.RLock()
+	messages, n, rate := s.messages, len(s.messagesHistory), float64(0)
+	if n > 1 {
+		rate = float64(s.messagesHistory[n-1]-s.messagesHistory[0]) / (float64(n-1) * s.config.Load().ManagerInterval.Seconds())
+	}
+	topics, visitors, subscribers := len(s.topics), len(s.visitors), 0
+	for _, t := range s.topics {
+		subs, _ := t.Stats()
+		subscribers += subs
+	}
+	s.mu.RUnlock()
+	response := &apiAdminDashboardResponse{
+		Version:           s.config.Load().Version,
+		Started:           s.started.Unix(),
+		Uptime:            int64(time.Since(s.started).Seconds()),
+		Topics:            topics,
+		Subscribers:       subscribers,
+		Visitors:          visitors,
+		Messages:          messages,
+		MessagesPerMinute: rate * 60,
+		Deliveries:        make(map[string]*apiAdminDashboardDelivery),
+		Cache:             &apiAdminDashboardCache{},
+		Pending: &apiAdminDashboardPending{
+			ClusterQueue: s.cluster.QueueLength(),
+		},
+	}
+	if s.userManager != nil {
+		users, err := s.userManager.UsersCount()
+		if err != nil {
+			return err
+		}
+		response.Users = users
+	}
+	for _, channel := range deliveryChannels {
+		success, failure := s.deliveries.Counts(channel)
+		delivery := &apiAdminDashboardDelivery{
+			Success: success,
+			Failure: failure,
+		}
+		if success+failure > 0 {
+			delivery.ErrorRate = float64(failure) / float64(success+failure)
+		}
+		response.Deliveries[channel] = delivery
+	}
+	messageCounts, err := s.messageCache.MessageCounts()
+	if err != nil {
+		return err
+	}
+	for _, count := range messageCounts {
+		response.Cache.Messages += count
+	}
+	if s.fileCache != nil {
+		response.Cache.AttachmentsSize = s.fileCache.Size()
+		response.Cache.AttachmentsRemaining = s.fileCache.Remaining()
+	}
+	response.Pending.ScheduledMessages, err = s.messageCache.ScheduledMessagesCount()
+	if err != nil {
+		return err
+	}
+	response.Pending.MessageCacheQueue = s.messageCache.QueueLength()
+	return s.writeJSON(w, response)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	dedupKeyLengthLimit = 256
+)
+
+// dedupCache remembers the messages that were published with a dedup key (X-Dedup-Key) for the duration of the
+// dedup window (see message-dedup-window). Messages with the same key on the same topic are suppressed within
+// this window, and only counted as duplicates of the first message.
+type dedupCache struct {
+	window  time.Duration
+	entries map[string]*dedupEntry // Topic + "/" + dedup key
+	mu      sync.Mutex
+}
+
+type dedupEntry struct {
+	message    *message // Only ID, time and topic are set until the message is published, see Published
+	published  bool
+	duplicates int
+	expires    time.Time
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// Add registers the message under the given key, unless a message with the same key was already added within the
+// dedup window. In that case, it counts the duplicate and returns a copy of the original message (with the number of
+// duplicates set), and whether the original message was already published.
+func (c *dedupCache) Add(topic, key string, m *message) (original *message, published bool, duplicate bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[topic+"/"+key]
+	if ok && time.Now().Before(entry.expires) {
+		entry.duplicates++
+		original := *entry.message
+		original.Duplicates = entry.duplicates
+		return &original, entry.published, true
+	}
+	c.entries[topic+"/"+key] = &dedupEntry{
+		message: &message{ID: m.ID, Time: m.Time, Event: m.Event, Topic: m.Topic},
+		expires: time.Now().Add(c.window),
+	}
+	return nil, false, false
+}
+
+// Published stores a copy of the published message, so that it can be returned for duplicates
+func (c *dedupCache) Published(topic, key string, m *message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[topic+"/"+key]; ok && entry.message.ID == m.ID {
+		published := *m
+		entry.message = &published
+		entry.published = true
+	}
+}
+
+// Remove forgets the given key, e.g. because publishing the message failed
+func (c *dedupCache) Remove(topic, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, topic+"/"+key)
+}
+
+// Prune removes all entries whose dedup window has passed
+func (c *dedupCache) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if time.Now().After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// handlePublishDuplicate is called instead of publishing a message whose dedup key was already used within the
+// dedup window. The message is not delivered; instead, subscribers receive a "message_updated" event for the original
+// message with the number of suppressed duplicates, so clients can show a counter instead of another notification.
+func (s *Server) handlePublishDuplicate(r *http.Request, v *visitor, t *topic, original *message, published bool) (*message, error) {
+	logvrm(v, r, original).Tag(tagPublish).Field("message_duplicates", original.Duplicates).Debug("Suppressing duplicate message")
+	minc(metricMessagesDeduplicated)
+	if !published {
+		return original, nil // Original message is still being published, or is scheduled for later
+	}
+	ev := newMessageUpdatedMessage(original)
+	if err := t.Publish(v, ev); err != nil {
+		return nil, err
+	}
+	s.cluster.Publish(ev)
+	return original, nil
+}
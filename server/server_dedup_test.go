@@ -0,0 +1,104 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_PublishDedupKey(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	subscribeRR := newSyncResponseRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+
+	response := request(t, s, "PUT", "/mytopic", "disk full", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, 0, m.Duplicates)
+
+	// Duplicates return the original message with a counter
+	response = request(t, s, "PUT", "/mytopic", "disk full again", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	require.Equal(t, 200, response.Code)
+	dup := toMessage(t, response.Body.String())
+	require.Equal(t, m.ID, dup.ID)
+	require.Equal(t, "disk full", dup.Message)
+	require.Equal(t, 1, dup.Duplicates)
+	response = request(t, s, "PUT", "/mytopic?dedup=disk-monitor", "disk full again", nil)
+	require.Equal(t, 2, toMessage(t, response.Body.String()).Duplicates)
+
+	// Other keys and other topics are not affected
+	response = request(t, s, "PUT", "/mytopic", "cpu hot", map[string]string{"X-Dedup-Key": "cpu-monitor"})
+	require.NotEqual(t, m.ID, toMessage(t, response.Body.String()).ID)
+	response = request(t, s, "PUT", "/othertopic", "disk full", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	require.NotEqual(t, m.ID, toMessage(t, response.Body.String()).ID)
+
+	// Only the original message is cached
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "disk full", messages[0].Message)
+	require.Equal(t, "cpu hot", messages[1].Message)
+
+	// Subscribers receive the original message, and the counter as message_updated events
+	waitFor(t, func() bool {
+		return len(toMessages(t, subscribeRR.Body())) == 5 // open, 2 messages, 2 updates
+	})
+	subscribeCancel()
+	updates := slices.DeleteFunc(toMessages(t, subscribeRR.Body()), func(m *message) bool { return m.Event != messageUpdatedEvent })
+	require.Equal(t, 2, len(updates))
+	require.Equal(t, m.ID, updates[0].ID)
+	require.Equal(t, "disk full", updates[0].Message)
+}
+
+func TestServer_PublishDedupKey_WindowExpired(t *testing.T) {
+	c := newTestConfig(t)
+	c.MessageDedupWindow = 200 * time.Millisecond
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "disk full", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	m := toMessage(t, response.Body.String())
+	time.Sleep(300 * time.Millisecond)
+	s.execManager()
+	require.Empty(t, s.dedup.entries)
+
+	response = request(t, s, "PUT", "/mytopic", "disk full", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	require.Equal(t, 200, response.Code)
+	require.NotEqual(t, m.ID, toMessage(t, response.Body.String()).ID)
+}
+
+func TestServer_PublishDedupKey_FailedPublishNotRemembered(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentCacheDir = "" // Attachments are rejected after the dedup key was checked
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "disk full", map[string]string{
+		"X-Dedup-Key": "disk-monitor",
+		"Filename":    "disk.txt",
+	})
+	require.Equal(t, 400, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "disk full", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 0, toMessage(t, response.Body.String()).Duplicates)
+}
+
+func TestServer_PublishDedupKey_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "disk full", map[string]string{"X-Dedup-Key": strings.Repeat("x", 257)})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40072, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishDedupKey_Disabled(t *testing.T) {
+	c := newTestConfig(t)
+	c.MessageDedupWindow = 0
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "disk full", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	m := toMessage(t, response.Body.String())
+	response = request(t, s, "PUT", "/mytopic", "disk full", map[string]string{"X-Dedup-Key": "disk-monitor"})
+	require.NotEqual(t, m.ID, toMessage(t, response.Body.String()).ID)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	ephemeralTopicLength      = 24
+	ephemeralTopicTTLDefault  = 24 * time.Hour
+	ephemeralTopicTTLMin      = time.Minute
+	ephemeralTopicTTLMax      = 7 * 24 * time.Hour
+	ephemeralTopicsPerUserMax = 10
+)
+
+// handleEphemeralTopicCreate creates a random, short-lived topic (POST /v1/topics/ephemeral), along with a read token
+// and a write token that are only valid for this topic. Once the TTL is reached, the topic's messages, attachments
+// and tokens are deleted by the manager, see pruneEphemeralTopics.
+func (s *Server) handleEphemeralTopicCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiEphemeralTopicRequest](r.Body, jsonBodyBytesLimit, true) // Allow empty body!
+	if err != nil {
+		return err
+	}
+	ttl := ephemeralTopicTTLDefault
+	if req.TTL != "" {
+		ttl, err = util.ParseDuration(req.TTL)
+		if err != nil || ttl < ephemeralTopicTTLMin || ttl > ephemeralTopicTTLMax {
+			return errHTTPBadRequestEphemeralTopicTTLInvalid
+		}
+	}
+	u := v.User()
+	count, err := s.userManager.EphemeralTopicsCount(u.ID)
+	if err != nil {
+		return err
+	} else if count >= ephemeralTopicsPerUserMax {
+		return errHTTPTooManyRequestsLimitEphemeralTopics
+	}
+	topic, err := s.userManager.AddEphemeralTopic(u, util.RandomString(ephemeralTopicLength), time.Now().Add(ttl), v.IP())
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{"topic": topic.Topic, "topic_expires": topic.Expires.Unix()}).
+		Info("Created ephemeral topic %s for user %s, expires in %s", topic.Topic, u.Name, ttl)
+	response := &apiEphemeralTopicResponse{
+		Topic:      topic.Topic,
+		ReadToken:  topic.ReadToken.Value,
+		WriteToken: topic.WriteToken.Value,
+		Expires:    topic.Expires.Unix(),
+	}
+	if s.config.Load().BaseURL != "" {
+		response.URL = s.config.Load().BaseURL + "/" + topic.Topic
+	}
+	return s.writeJSON(w, response)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_EphemeralTopic_CreatePublishSubscribe(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	response := request(t, s, "POST", "/v1/topics/ephemeral", "", nil)
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "POST", "/v1/topics/ephemeral", `{"ttl":"1h"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	topic, err := util.UnmarshalJSON[apiEphemeralTopicResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, ephemeralTopicLength, len(topic.Topic))
+	require.Equal(t, "http://127.0.0.1:12345/"+topic.Topic, topic.URL)
+	require.NotEqual(t, topic.ReadToken, topic.WriteToken)
+
+	// Anonymous access is denied, the write token can only publish, the read token can only subscribe
+	response = request(t, s, "PUT", "/"+topic.Topic, "hi", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "PUT", "/"+topic.Topic, "hi", map[string]string{
+		"Authorization": "Bearer " + topic.ReadToken,
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "PUT", "/"+topic.Topic, "the file is ready", map[string]string{
+		"Authorization": "Bearer " + topic.WriteToken,
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/othertopic", "hi", map[string]string{
+		"Authorization": "Bearer " + topic.WriteToken,
+	})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/"+topic.Topic+"/json?poll=1", "", map[string]string{
+		"Authorization": "Bearer " + topic.WriteToken,
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/"+topic.Topic+"/json?poll=1", "", map[string]string{
+		"Authorization": "Bearer " + topic.ReadToken,
+	})
+	require.Equal(t, 200, response.Code)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "the file is ready", messages[0].Message)
+
+	// Tokens cannot create more ephemeral topics
+	response = request(t, s, "POST", "/v1/topics/ephemeral", "", map[string]string{
+		"Authorization": "Bearer " + topic.WriteToken,
+	})
+	require.Equal(t, 403, response.Code)
+}
+
+func TestServer_EphemeralTopic_Limits(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	for _, ttl := range []string{"30s", "8d", "invalid"} {
+		response := request(t, s, "POST", "/v1/topics/ephemeral", fmt.Sprintf(`{"ttl":"%s"}`, ttl), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40090, toHTTPError(t, response.Body.String()).Code)
+	}
+	for i := 0; i < ephemeralTopicsPerUserMax; i++ {
+		response := request(t, s, "POST", "/v1/topics/ephemeral", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "POST", "/v1/topics/ephemeral", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42919, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_EphemeralTopic_Prune(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+
+	active, err := s.userManager.AddEphemeralTopic(phil, "activehandoff", time.Now().Add(time.Hour), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	expired, err := s.userManager.AddEphemeralTopic(phil, "expiredhandoff", time.Now().Add(-time.Second), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	ids := make(map[string]string)
+	for _, topic := range []string{active.Topic, expired.Topic} {
+		response := request(t, s, "PUT", "/"+topic, strings.Repeat("x", 5000), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+			"Filename":      "handoff.txt",
+		})
+		require.Equal(t, 200, response.Code)
+		ids[topic] = toMessage(t, response.Body.String()).ID
+	}
+
+	// Messages, attachments and tokens of the expired topic are gone
+	s.execManager()
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, ids[active.Topic]))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, ids[expired.Topic]))
+	messageIDs, err := s.messageCache.MessageIDs(expired.Topic)
+	require.Nil(t, err)
+	require.Empty(t, messageIDs)
+	messageIDs, err = s.messageCache.MessageIDs(active.Topic)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messageIDs))
+	remaining, err := s.userManager.EphemeralTopicsExpired()
+	require.Nil(t, err)
+	require.Empty(t, remaining)
+
+	response := request(t, s, "GET", "/"+expired.Topic+"/json?poll=1", "", map[string]string{
+		"Authorization": "Bearer " + expired.ReadToken.Value,
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", "/"+active.Topic+"/json?poll=1", "", map[string]string{
+		"Authorization": "Bearer " + active.ReadToken.Value,
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 1, len(toMessages(t, response.Body.String())))
+}
@@ -170,6 +170,12 @@ func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, erro
 			"content_type": m.ContentType,
 			"encoding":     m.Encoding,
 		}
+		if m.Encryption != "" {
+			data["encryption"] = m.Encryption
+		}
+		if m.ReplyTo != "" {
+			data["reply_to"] = m.ReplyTo
+		}
 		if len(m.Actions) > 0 {
 			actions, err := json.Marshal(m.Actions)
 			if err != nil {
@@ -183,6 +189,9 @@ func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, erro
 			data["attachment_size"] = fmt.Sprintf("%d", m.Attachment.Size)
 			data["attachment_expires"] = fmt.Sprintf("%d", m.Attachment.Expires)
 			data["attachment_url"] = m.Attachment.URL
+			if m.Attachment.Thumbnail != "" {
+				data["attachment_thumbnail"] = m.Attachment.Thumbnail
+			}
 		}
 		if m.PollID != "" {
 			data["poll_id"] = m.PollID
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"heckel.io/ntfy/v2/server/ntfypb"
+)
+
+// grpcService implements the gRPC publish/subscribe API (see ntfypb/ntfy.proto). Similar to the SMTP server,
+// gRPC calls are translated to internal HTTP requests and passed to the HTTP handler, so that authentication,
+// access control, rate limiting and all publishing features work exactly as they do for the HTTP API.
+type grpcService struct {
+	ntfypb.UnimplementedNtfyServer
+	config  *Config
+	handler func(http.ResponseWriter, *http.Request)
+}
+
+func newGRPCService(conf *Config, handler func(http.ResponseWriter, *http.Request)) *grpcService {
+	return &grpcService{
+		config:  conf,
+		handler: handler,
+	}
+}
+
+// newGRPCServer creates the gRPC server, using the HTTPS key and cert file (or the ACME certificates) for TLS if they are set
+func (s *Server) newGRPCServer() (*grpc.Server, error) {
+	options := make([]grpc.ServerOption, 0)
+	if s.acme != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(&tls.Config{GetCertificate: s.acme.GetCertificate})))
+	} else if s.config.Load().CertFile != "" && s.config.Load().KeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(s.config.Load().CertFile, s.config.Load().KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	grpcServer := grpc.NewServer(options...)
+	ntfypb.RegisterNtfyServer(grpcServer, newGRPCService(s.config.Load(), s.handle))
+	return grpcServer, nil
+}
+
+func (s *Server) runGRPCServer(grpcServer *grpc.Server) error {
+	listener, err := net.Listen("tcp", s.config.Load().ListenGRPC)
+	if err != nil {
+		return err
+	}
+	return grpcServer.Serve(listener)
+}
+
+// Publish publishes a single message
+func (g *grpcService) Publish(ctx context.Context, req *ntfypb.PublishRequest) (*ntfypb.Message, error) {
+	r, err := g.newPublishRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	rr := httptest.NewRecorder()
+	g.handler(rr, r)
+	if rr.Code != http.StatusOK {
+		return nil, grpcErrorFromResponse(rr.Code, rr.Body.Bytes())
+	}
+	var m message
+	if err := json.NewDecoder(rr.Body).Decode(&m); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return toGRPCMessage(&m), nil
+}
+
+// PublishStream publishes all messages received on the stream, and sends back the published message for each
+// of them. The stream is closed with an error status as soon as a message cannot be published.
+func (g *grpcService) PublishStream(stream grpc.BidiStreamingServer[ntfypb.PublishRequest, ntfypb.Message]) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		m, err := g.Publish(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(m); err != nil {
+			return err
+		}
+	}
+}
+
+// Subscribe streams messages for the requested topics, by passing a JSON stream request (/<topics>/json)
+// to the HTTP handler, and converting the streamed JSON messages
+func (g *grpcService) Subscribe(req *ntfypb.SubscribeRequest, stream grpc.ServerStreamingServer[ntfypb.Message]) error {
+	r, err := g.newSubscribeRequest(stream.Context(), req)
+	if err != nil {
+		return err
+	}
+	w := newGRPCStreamWriter(func(m *message) error {
+		return stream.Send(toGRPCMessage(m))
+	})
+	g.handler(w, r)
+	if w.code != 0 && w.code != http.StatusOK {
+		return grpcErrorFromResponse(w.code, w.body.Bytes())
+	}
+	return w.err
+}
+
+func (g *grpcService) newPublishRequest(ctx context.Context, req *ntfypb.PublishRequest) (*http.Request, error) {
+	if !topicRegex.MatchString(req.Topic) {
+		return nil, status.Error(codes.InvalidArgument, "topic invalid")
+	}
+	r, err := g.newRequest(ctx, http.MethodPost, "/"+req.Topic, strings.NewReader(req.Message))
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{
+		"X-Title":    req.Title,
+		"X-Tags":     strings.Join(req.Tags, ","),
+		"X-Click":    req.Click,
+		"X-Icon":     req.Icon,
+		"X-Actions":  req.Actions,
+		"X-Attach":   req.Attach,
+		"X-Filename": req.Filename,
+		"X-Email":    req.Email,
+		"X-Delay":    req.Delay,
+		"X-Channels": strings.Join(req.Channels, ","),
+	}
+	if req.Priority != 0 {
+		headers["X-Priority"] = strconv.Itoa(int(req.Priority))
+	}
+	if req.Markdown {
+		headers["X-Markdown"] = "yes"
+	}
+	for k, v := range headers {
+		if v != "" {
+			r.Header.Set(k, v)
+		}
+	}
+	return r, nil
+}
+
+func (g *grpcService) newSubscribeRequest(ctx context.Context, req *ntfypb.SubscribeRequest) (*http.Request, error) {
+	if len(req.Topics) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one topic is required")
+	}
+	for _, topic := range req.Topics {
+		if !topicRegex.MatchString(topic) {
+			return nil, status.Error(codes.InvalidArgument, "topic invalid")
+		}
+	}
+	params := map[string]string{
+		"since":    req.Since,
+		"id":       req.Id,
+		"message":  req.Message,
+		"title":    req.Title,
+		"priority": req.Priority,
+		"tags":     req.Tags,
+	}
+	if req.Poll {
+		params["poll"] = "1"
+	}
+	if req.Scheduled {
+		params["scheduled"] = "1"
+	}
+	query := url.Values{}
+	for k, v := range params {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	path := "/" + strings.Join(req.Topics, ",") + "/json"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return g.newRequest(ctx, http.MethodGet, path, nil)
+}
+
+// newRequest creates an internal HTTP request, with the authorization and remote address taken from the
+// gRPC metadata and peer. The request is bound to the context of the gRPC call.
+func (g *grpcService) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, method, g.config.BaseURL+path, body)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r.RequestURI = path // just for the logs
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String() // rate limiting!!
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			r.Header.Set(g.config.ProxyForwardedHeader, host) // Set X-Forwarded-For header
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if authorization := md.Get("authorization"); len(authorization) > 0 {
+			r.Header.Set("Authorization", authorization[0])
+		}
+	}
+	return r, nil
+}
+
+// grpcStreamWriter is an http.ResponseWriter that converts the JSON lines written by the HTTP subscribe
+// handler to messages, and passes them to the send function. Error responses are buffered.
+type grpcStreamWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+	send   func(m *message) error
+	err    error
+}
+
+func newGRPCStreamWriter(send func(m *message) error) *grpcStreamWriter {
+	return &grpcStreamWriter{
+		header: make(http.Header),
+		send:   send,
+	}
+}
+
+func (w *grpcStreamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcStreamWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *grpcStreamWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.code != http.StatusOK {
+		return w.body.Write(b)
+	}
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		w.err = status.Error(codes.Internal, err.Error())
+		return 0, w.err
+	}
+	if err := w.send(&m); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// grpcErrorFromResponse converts an HTTP error response (see errHTTP) to a gRPC status error
+func grpcErrorFromResponse(httpCode int, body []byte) error {
+	var e errHTTP
+	if err := json.Unmarshal(body, &e); err != nil || e.Message == "" {
+		return status.Error(grpcCodeFromHTTP(httpCode), http.StatusText(httpCode))
+	}
+	return status.Error(grpcCodeFromHTTP(httpCode), fmt.Sprintf("%s (ntfy error %d)", e.Message, e.Code))
+}
+
+func grpcCodeFromHTTP(httpCode int) codes.Code {
+	switch httpCode {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
+
+func toGRPCMessage(m *message) *ntfypb.Message {
+	pm := &ntfypb.Message{
+		Id:          m.ID,
+		Time:        m.Time,
+		Expires:     m.Expires,
+		Event:       m.Event,
+		Topic:       m.Topic,
+		Title:       m.Title,
+		Message:     m.Message,
+		Priority:    int32(m.Priority),
+		Tags:        m.Tags,
+		Click:       m.Click,
+		Icon:        m.Icon,
+		PollId:      m.PollID,
+		ContentType: m.ContentType,
+		Encoding:    m.Encoding,
+	}
+	for _, a := range m.Actions {
+		pm.Actions = append(pm.Actions, &ntfypb.Action{
+			Id:      a.ID,
+			Action:  a.Action,
+			Label:   a.Label,
+			Clear:   a.Clear,
+			Url:     a.URL,
+			Method:  a.Method,
+			Headers: a.Headers,
+			Body:    a.Body,
+			Intent:  a.Intent,
+			Extras:  a.Extras,
+		})
+	}
+	if m.Attachment != nil {
+		pm.Attachment = &ntfypb.Attachment{
+			Name:    m.Attachment.Name,
+			Type:    m.Attachment.Type,
+			Size:    m.Attachment.Size,
+			Expires: m.Attachment.Expires,
+			Url:     m.Attachment.URL,
+		}
+	}
+	return pm
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"heckel.io/ntfy/v2/server/ntfypb"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_GRPC_PublishAndPoll(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	client := newTestGRPCClient(t, s)
+
+	m, err := client.Publish(context.Background(), &ntfypb.PublishRequest{
+		Topic:    "mytopic",
+		Message:  "hi there",
+		Title:    "A title",
+		Priority: 5,
+		Tags:     []string{"tag1", "tag2"},
+		Markdown: true,
+	})
+	require.Nil(t, err)
+	require.NotEmpty(t, m.Id)
+	require.Equal(t, "message", m.Event)
+	require.Equal(t, "mytopic", m.Topic)
+	require.Equal(t, "hi there", m.Message)
+	require.Equal(t, "A title", m.Title)
+	require.Equal(t, int32(5), m.Priority)
+	require.Equal(t, []string{"tag1", "tag2"}, m.Tags)
+	require.Equal(t, "text/markdown", m.ContentType)
+
+	// Message is visible via HTTP
+	rr := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, m.Id, toMessage(t, rr.Body.String()).ID)
+
+	// Poll via gRPC
+	stream, err := client.Subscribe(context.Background(), &ntfypb.SubscribeRequest{
+		Topics: []string{"mytopic"},
+		Poll:   true,
+	})
+	require.Nil(t, err)
+	polled, err := stream.Recv()
+	require.Nil(t, err)
+	require.Equal(t, m.Id, polled.Id)
+	require.Equal(t, "hi there", polled.Message)
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestServer_GRPC_PublishStream(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	client := newTestGRPCClient(t, s)
+
+	stream, err := client.PublishStream(context.Background())
+	require.Nil(t, err)
+	for _, msg := range []string{"one", "two", "three"} {
+		require.Nil(t, stream.Send(&ntfypb.PublishRequest{Topic: "mytopic", Message: msg}))
+		m, err := stream.Recv()
+		require.Nil(t, err)
+		require.Equal(t, msg, m.Message)
+	}
+	require.Nil(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+
+	rr := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 200, rr.Code)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "three", messages[2].Message)
+}
+
+func TestServer_GRPC_Subscribe(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	client := newTestGRPCClient(t, s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &ntfypb.SubscribeRequest{
+		Topics:   []string{"mytopic", "othertopic"},
+		Priority: "high,urgent",
+	})
+	require.Nil(t, err)
+	open, err := stream.Recv()
+	require.Nil(t, err)
+	require.Equal(t, "open", open.Event)
+	require.Equal(t, "mytopic,othertopic", open.Topic)
+
+	request(t, s, "PUT", "/othertopic", "low priority", map[string]string{"Priority": "low"})
+	request(t, s, "PUT", "/othertopic", "urgent message", map[string]string{
+		"Priority": "urgent",
+		"Actions":  "view, Open, https://ntfy.sh",
+	})
+	m, err := stream.Recv()
+	require.Nil(t, err)
+	require.Equal(t, "message", m.Event)
+	require.Equal(t, "othertopic", m.Topic)
+	require.Equal(t, "urgent message", m.Message)
+	require.Equal(t, 1, len(m.Actions))
+	require.Equal(t, "view", m.Actions[0].Action)
+	require.Equal(t, "https://ntfy.sh", m.Actions[0].Url)
+
+	cancel()
+	_, err = stream.Recv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func TestServer_GRPC_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	client := newTestGRPCClient(t, s)
+
+	// Anonymous
+	_, err := client.Publish(context.Background(), &ntfypb.PublishRequest{Topic: "mytopic", Message: "hi"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Wrong password
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", util.BasicAuth("phil", "wrong"))
+	_, err = client.Publish(ctx, &ntfypb.PublishRequest{Topic: "mytopic", Message: "hi"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Correct password, but no access to topic
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", util.BasicAuth("phil", "phil"))
+	_, err = client.Publish(ctx, &ntfypb.PublishRequest{Topic: "othertopic", Message: "hi"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Success
+	m, err := client.Publish(ctx, &ntfypb.PublishRequest{Topic: "mytopic", Message: "hi"})
+	require.Nil(t, err)
+	require.Equal(t, "hi", m.Message)
+}
+
+func TestServer_GRPC_InvalidRequest(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	client := newTestGRPCClient(t, s)
+
+	_, err := client.Publish(context.Background(), &ntfypb.PublishRequest{Topic: "my/topic", Message: "hi"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Publish(context.Background(), &ntfypb.PublishRequest{Topic: "mytopic", Message: "hi", Priority: 10})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, err.Error(), "ntfy error 40007")
+}
+
+func newTestGRPCClient(t *testing.T, s *Server) ntfypb.NtfyClient {
+	grpcServer, err := s.newGRPCServer()
+	require.Nil(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return ntfypb.NewNtfyClient(conn)
+}
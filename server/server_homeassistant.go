@@ -0,0 +1,291 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Home Assistant integration:
+//
+// Home Assistant's RESTful notify platform (https://www.home-assistant.io/integrations/notify.rest/) can be pointed
+// at /v1/homeassistant/<topic>. GET returns a ready-to-paste YAML snippet for configuration.yaml, and POST accepts
+// the JSON body that the notify platform sends (method POST_JSON), e.g.
+//
+//	{
+//	  "message": "The garage door has been open for 10 minutes",
+//	  "title": "Garage",
+//	  "target": "othertopic",
+//	  "data": {
+//	    "priority": "high",
+//	    "tags": ["warning"],
+//	    "actions": [{"action": "CLOSE_GARAGE", "title": "Close door"}]
+//	  }
+//	}
+//
+// The "data" field may also be a JSON string, since the notify platform renders all data templates as strings.
+// Actions follow the format of the Home Assistant companion apps: actions with a "uri" open the URI (ntfy "view"
+// action), all other actions POST {"action":"<action>","topic":"<topic>"} to the callback URL (ntfy "http" action),
+// which is typically a Home Assistant webhook URL, e.g. http://homeassistant.local:8123/api/webhook/ntfy_actions.
+
+const (
+	homeAssistantCallbackParam = "callback"
+)
+
+// homeAssistantNotifyRequest is the JSON body sent by Home Assistant's RESTful notify platform
+type homeAssistantNotifyRequest struct {
+	Message string          `json:"message"`
+	Title   string          `json:"title"`
+	Target  any             `json:"target"` // Overrides the topic in the path, string or list (only the first entry is used)
+	Data    json.RawMessage `json:"data"`
+}
+
+// homeAssistantNotifyData is the "data" field of a notify service call, modeled after the companion apps
+type homeAssistantNotifyData struct {
+	Priority    any                    `json:"priority"` // Number or name, e.g. 4 or "high"
+	Tags        any                    `json:"tags"`     // List or comma-separated string
+	Click       string                 `json:"click"`
+	URL         string                 `json:"url"`         // Alias for click, as in the iOS companion app
+	ClickAction string                 `json:"clickAction"` // Alias for click, as in the Android companion app
+	Image       string                 `json:"image"`
+	Icon        string                 `json:"icon"`
+	Markdown    bool                   `json:"markdown"`
+	Delay       string                 `json:"delay"`
+	Callback    string                 `json:"callback"`
+	Actions     []*homeAssistantAction `json:"actions"`
+}
+
+type homeAssistantAction struct {
+	Action string `json:"action"`
+	Title  string `json:"title"`
+	URI    string `json:"uri"`
+}
+
+// homeAssistantCallback is the body sent to the callback URL when an action button is tapped
+type homeAssistantCallback struct {
+	Action string `json:"action"`
+	Topic  string `json:"topic"`
+}
+
+// homeAssistantNotifyConfig is a single entry in the "notify" section of Home Assistant's configuration.yaml
+type homeAssistantNotifyConfig struct {
+	Name             string            `yaml:"name"`
+	Platform         string            `yaml:"platform"`
+	Resource         string            `yaml:"resource"`
+	Method           string            `yaml:"method"`
+	Headers          map[string]string `yaml:"headers,omitempty"`
+	MessageParamName string            `yaml:"message_param_name"`
+	TitleParamName   string            `yaml:"title_param_name"`
+	TargetParamName  string            `yaml:"target_param_name"`
+	DataTemplate     map[string]string `yaml:"data_template"`
+}
+
+// handleHomeAssistantConfig returns a YAML snippet that configures a Home Assistant notify service for the topic.
+// If the request is authenticated, the Authorization header is included, so that the snippet can be used as is.
+func (s *Server) handleHomeAssistantConfig(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	if s.config.Load().BaseURL == "" {
+		return errHTTPInternalErrorMissingBaseURL
+	}
+	topic, err := homeAssistantTopicFromPath(r.URL.Path)
+	if err != nil {
+		return err
+	}
+	resource := fmt.Sprintf("%s/v1/homeassistant/%s", s.config.Load().BaseURL, topic)
+	if callback := r.URL.Query().Get(homeAssistantCallbackParam); callback != "" {
+		if !urlRegex.MatchString(callback) {
+			return errHTTPBadRequestHomeAssistantCallbackInvalid
+		}
+		resource += "?" + url.Values{homeAssistantCallbackParam: {callback}}.Encode()
+	}
+	conf := &homeAssistantNotifyConfig{
+		Name:             "ntfy_" + strings.ReplaceAll(topic, "-", "_"),
+		Platform:         "rest",
+		Resource:         resource,
+		Method:           "POST_JSON",
+		MessageParamName: "message",
+		TitleParamName:   "title",
+		TargetParamName:  "target",
+		DataTemplate: map[string]string{
+			"data": "{{ data | default({}) | tojson }}",
+		},
+	}
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		conf.Headers = map[string]string{"Authorization": authorization}
+	}
+	b, err := yaml.Marshal(map[string][]*homeAssistantNotifyConfig{"notify": {conf}})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	_, err = w.Write(b)
+	return err
+}
+
+// transformHomeAssistantJSON converts a Home Assistant notify request to a regular publish request, so that it
+// can be passed on to the publish handler, similar to transformBodyJSON
+func (s *Server) transformHomeAssistantJSON(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		topic, err := homeAssistantTopicFromPath(r.URL.Path)
+		if err != nil {
+			return err
+		}
+		req, err := readJSONWithLimit[homeAssistantNotifyRequest](r.Body, s.config.Load().MessageSizeLimit*2, false) // 2x to account for JSON format overhead
+		if err != nil {
+			return err
+		}
+		data, err := parseHomeAssistantNotifyData(req.Data)
+		if err != nil {
+			return errHTTPBadRequestMessageJSONInvalid
+		}
+		if target := homeAssistantTarget(req.Target); target != "" {
+			topic = strings.TrimPrefix(target, "/")
+			if !topicRegex.MatchString(topic) {
+				return errHTTPBadRequestTopicInvalid
+			}
+		}
+		callback := r.URL.Query().Get(homeAssistantCallbackParam)
+		if data.Callback != "" {
+			callback = data.Callback
+		}
+		if callback != "" && !urlRegex.MatchString(callback) {
+			return errHTTPBadRequestHomeAssistantCallbackInvalid
+		}
+		message := req.Message
+		if message == "" {
+			message = emptyMessageBody
+		}
+		r.URL.Path = "/" + topic
+		r.URL.RawQuery = ""
+		r.Body = io.NopCloser(strings.NewReader(message))
+		if req.Title != "" {
+			r.Header.Set("X-Title", req.Title)
+		}
+		if data.Priority != nil {
+			r.Header.Set("X-Priority", fmt.Sprintf("%v", data.Priority))
+		}
+		if tags := homeAssistantTags(data.Tags); tags != "" {
+			r.Header.Set("X-Tags", tags)
+		}
+		if click := firstNonEmpty(data.Click, data.URL, data.ClickAction); click != "" {
+			r.Header.Set("X-Click", click)
+		}
+		if data.Image != "" {
+			r.Header.Set("X-Attach", data.Image)
+		}
+		if data.Icon != "" {
+			r.Header.Set("X-Icon", data.Icon)
+		}
+		if data.Markdown {
+			r.Header.Set("X-Markdown", "yes")
+		}
+		if data.Delay != "" {
+			r.Header.Set("X-Delay", data.Delay)
+		}
+		if len(data.Actions) > 0 {
+			actions, err := homeAssistantActions(data.Actions, topic, callback)
+			if err != nil {
+				return err
+			}
+			r.Header.Set("X-Actions", actions)
+		}
+		return next(w, r, v)
+	}
+}
+
+// parseHomeAssistantNotifyData parses the "data" field, which may be a JSON object, or a JSON string containing
+// a JSON object (as rendered by the data_template in the generated config)
+func parseHomeAssistantNotifyData(raw json.RawMessage) (*homeAssistantNotifyData, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		raw = []byte(s)
+	}
+	var data homeAssistantNotifyData
+	if len(raw) == 0 || string(raw) == "null" {
+		return &data, nil
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// homeAssistantActions converts companion app actions to ntfy actions (JSON), see the file header for details
+func homeAssistantActions(haActions []*homeAssistantAction, topic, callback string) (string, error) {
+	actions := make([]*action, 0, len(haActions))
+	for _, haAction := range haActions {
+		a := newAction()
+		a.Label = haAction.Title
+		if a.Label == "" {
+			a.Label = haAction.Action
+		}
+		if haAction.URI != "" {
+			a.Action = actionView
+			a.URL = haAction.URI
+		} else {
+			if callback == "" {
+				return "", errHTTPBadRequestHomeAssistantCallbackInvalid
+			}
+			body, err := json.Marshal(&homeAssistantCallback{Action: haAction.Action, Topic: topic})
+			if err != nil {
+				return "", err
+			}
+			a.Action = actionHTTP
+			a.URL = callback
+			a.Method = http.MethodPost
+			a.Headers["Content-Type"] = "application/json"
+			a.Body = string(body)
+			a.Clear = true
+		}
+		actions = append(actions, a)
+	}
+	b, err := json.Marshal(actions)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func homeAssistantTags(tags any) string {
+	switch t := tags.(type) {
+	case string:
+		return t
+	case []any:
+		s := make([]string, 0, len(t))
+		for _, tag := range t {
+			s = append(s, fmt.Sprintf("%v", tag))
+		}
+		return strings.Join(s, ",")
+	}
+	return ""
+}
+
+// homeAssistantTarget returns the target topic; the notify platform sends the service call's "target" as a list
+func homeAssistantTarget(target any) string {
+	switch t := target.(type) {
+	case string:
+		return t
+	case []any:
+		if len(t) > 0 {
+			return fmt.Sprintf("%v", t[0])
+		}
+	}
+	return ""
+}
+
+func homeAssistantTopicFromPath(path string) (string, error) {
+	matches := apiHomeAssistantRegex.FindStringSubmatch(path)
+	if len(matches) != 2 {
+		return "", errHTTPBadRequestTopicInvalid
+	}
+	return matches[1], nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+)
+
+func TestServer_HomeAssistant_Config(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "GET", "/v1/homeassistant/garage-door?callback=http://homeassistant.local:8123/api/webhook/ntfy", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "text/yaml; charset=utf-8", response.Header().Get("Content-Type"))
+	body := response.Body.String()
+	require.Contains(t, body, "name: ntfy_garage_door")
+	require.Contains(t, body, "platform: rest")
+	require.Contains(t, body, "method: POST_JSON")
+	require.Contains(t, body, "resource: http://127.0.0.1:12345/v1/homeassistant/garage-door?callback=http%3A%2F%2Fhomeassistant.local%3A8123%2Fapi%2Fwebhook%2Fntfy")
+	require.NotContains(t, body, "Authorization")
+
+	response = request(t, s, "GET", "/v1/homeassistant/garage-door?callback=ftp://invalid", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40079, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_HomeAssistant_Publish(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	body := `{"message":"The garage door has been open for 10 minutes","title":"Garage","data":{"priority":"high","tags":["warning","house"],"url":"https://homeassistant.local/garage"}}`
+	response := request(t, s, "POST", "/v1/homeassistant/garage", body, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "garage", m.Topic)
+	require.Equal(t, "The garage door has been open for 10 minutes", m.Message)
+	require.Equal(t, "Garage", m.Title)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, []string{"warning", "house"}, m.Tags)
+	require.Equal(t, "https://homeassistant.local/garage", m.Click)
+}
+
+func TestServer_HomeAssistant_Publish_TargetAndDataString(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	body := `{"message":"hi there","target":["othertopic"],"data":"{\"priority\":2,\"tags\":\"a,b\"}"}`
+	response := request(t, s, "POST", "/v1/homeassistant/garage", body, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "othertopic", m.Topic)
+	require.Equal(t, 2, m.Priority)
+	require.Equal(t, []string{"a", "b"}, m.Tags)
+
+	response = request(t, s, "POST", "/v1/homeassistant/garage", `{"message":"hi","target":"not a topic!"}`, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40009, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_HomeAssistant_Publish_Actions(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	body := `{"message":"Garage door open","data":{"actions":[{"action":"CLOSE_GARAGE","title":"Close door"},{"action":"URI","title":"Camera","uri":"https://homeassistant.local/camera"}]}}`
+	response := request(t, s, "POST", "/v1/homeassistant/garage?callback=http://homeassistant.local:8123/api/webhook/ntfy", body, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, 2, len(m.Actions))
+	require.Equal(t, "http", m.Actions[0].Action)
+	require.Equal(t, "Close door", m.Actions[0].Label)
+	require.Equal(t, "http://homeassistant.local:8123/api/webhook/ntfy", m.Actions[0].URL)
+	require.Equal(t, "POST", m.Actions[0].Method)
+	require.True(t, m.Actions[0].Clear)
+	var callback homeAssistantCallback
+	require.Nil(t, json.Unmarshal([]byte(m.Actions[0].Body), &callback))
+	require.Equal(t, "CLOSE_GARAGE", callback.Action)
+	require.Equal(t, "garage", callback.Topic)
+	require.Equal(t, "view", m.Actions[1].Action)
+	require.Equal(t, "https://homeassistant.local/camera", m.Actions[1].URL)
+
+	// Callback actions require a callback URL
+	response = request(t, s, "POST", "/v1/homeassistant/garage", body, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40079, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_HomeAssistant_Publish_Unauthorized(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/v1/homeassistant/garage", `{"message":"hi"}`, nil)
+	require.Equal(t, 403, response.Code)
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	imageResizeMaxPixels   = 40 * 1000 * 1000 // Larger images are served as is, to protect against decompression bombs
+	imageResizeJPEGQuality = 85
+	imageThumbnailWidth    = 320        // Width of the thumbnail served at /file/<id>/thumb
+	imageHeaderBytesLimit  = 256 * 1024 // Enough to find the dimensions and EXIF orientation of camera photos
+)
+
+var (
+	// imageResizeWidths are the widths in which resized images are available. Requested widths are rounded up to
+	// the next available width, so that only a handful of variants are generated and cached per image.
+	imageResizeWidths = []int{160, 320, 640, 800, 1280, 1920}
+
+	// imageMetadataTypes are the attachment types for which dimensions are determined and thumbnails are offered
+	imageMetadataTypes = []string{"image/jpeg", "image/png"}
+)
+
+// imageHeader keeps the first bytes of an image attachment while it is written to the file cache, so that its
+// dimensions can be determined without reading the file again. It never fails, so it can be used with io.TeeReader.
+type imageHeader struct {
+	buf bytes.Buffer
+}
+
+func (h *imageHeader) Write(p []byte) (int, error) {
+	if remaining := imageHeaderBytesLimit - h.buf.Len(); remaining > 0 {
+		h.buf.Write(p[:min(len(p), remaining)])
+	}
+	return len(p), nil
+}
+
+// withImageHeader returns a reader that records the header of the attachment in h while it is read, if the
+// attachment is an image. Otherwise, the body is returned as is.
+func withImageHeader(a *attachment, body io.Reader, h *imageHeader) io.Reader {
+	if !slices.Contains(imageMetadataTypes, a.Type) {
+		return body
+	}
+	return io.TeeReader(body, h)
+}
+
+// setImageMetadata sets the dimensions of an image attachment, as they are displayed (i.e. after applying the EXIF
+// orientation), and the thumbnail URL for the first attachment of a message. No other metadata is exposed; in particular,
+// EXIF data such as the GPS location is never passed on, and is not contained in resized images and thumbnails.
+func (s *Server) setImageMetadata(m *message, index int, a *attachment, h *imageHeader) {
+	if h.buf.Len() == 0 {
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(h.buf.Bytes()))
+	if err != nil {
+		return // Broken or truncated header, e.g. huge EXIF data
+	}
+	a.Width, a.Height = config.Width, config.Height
+	if imageOrientationSwapsDimensions(imageOrientation(h.buf.Bytes())) {
+		a.Width, a.Height = a.Height, a.Width
+	}
+	if index == 0 {
+		a.Thumbnail = fmt.Sprintf("%s/file/%s/thumb", s.config.Load().BaseURL, m.ID)
+	}
+}
+
+// resizedAttachment returns a variant of an image attachment (JPEG or PNG) that is scaled down to the requested
+// width (see imageResizeWidths), as well as the actual width. It returns a nil slice if the attachment is not a
+// supported image, or if it already fits into the requested width, in which case the original should be served.
+//
+// Resized images are stored in the file cache next to the original, except for attachments in encrypted topics,
+// since the variant would otherwise be stored unencrypted. Those are resized on every request.
+func (s *Server) resizedAttachment(m *message, file string, plaintext []byte, widthParam string) ([]byte, int, error) {
+	width, err := imageResizeWidth(widthParam)
+	if err != nil {
+		return nil, 0, err
+	}
+	variant := fmt.Sprintf("w%d", width)
+	cacheable := plaintext == nil
+	if cacheable {
+		if resized, err := s.fileCache.ReadVariant(m.ID, variant); err == nil {
+			return resized, width, nil
+		}
+	}
+	var original io.ReadSeeker
+	if plaintext != nil {
+		original = bytes.NewReader(plaintext)
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer f.Close()
+		original = f
+	}
+	resized, err := resizeImage(original, width)
+	if err != nil || resized == nil {
+		return nil, 0, err
+	}
+	if cacheable {
+		if err := s.fileCache.WriteVariant(m.ID, variant, resized); err != nil {
+			log.Tag(tagFileCache).Field("message_id", m.ID).Err(err).Debug("Unable to cache resized image")
+		}
+	}
+	return resized, width, nil
+}
+
+// imageResizeWidth parses the requested width, and rounds it up to the next available width
+func imageResizeWidth(widthParam string) (int, error) {
+	width, err := strconv.Atoi(widthParam)
+	if err != nil || width <= 0 {
+		return 0, errHTTPBadRequestImageWidthInvalid
+	}
+	for _, w := range imageResizeWidths {
+		if width <= w {
+			return w, nil
+		}
+	}
+	return imageResizeWidths[len(imageResizeWidths)-1], nil
+}
+
+// resizeImage scales down a JPEG or PNG image to the given width, keeping the aspect ratio and the format. It
+// returns nil if the image cannot be resized (unsupported format, too large), or if it is not wider than width.
+//
+// Since the EXIF data is not copied to the resized image, the EXIF orientation of JPEG images (e.g. portrait photos
+// taken with a phone) is applied to the pixels, and width refers to the width of the image as it is displayed.
+func resizeImage(r io.ReadSeeker, width int) ([]byte, error) {
+	var header imageHeader
+	config, format, err := image.DecodeConfig(bufio.NewReader(io.TeeReader(r, &header)))
+	if err != nil || (format != "jpeg" && format != "png") {
+		return nil, nil // Not a supported image, serve as is
+	}
+	orientation := imageOrientation(header.buf.Bytes())
+	displayWidth, displayHeight := config.Width, config.Height
+	if imageOrientationSwapsDimensions(orientation) {
+		displayWidth, displayHeight = displayHeight, displayWidth
+	}
+	if displayWidth <= width || config.Width*config.Height > imageResizeMaxPixels {
+		return nil, nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(r))
+	if err != nil {
+		return nil, nil // Broken image, serve as is
+	}
+	height := max(1, displayHeight*width/displayWidth)
+	var resized *image.RGBA
+	if imageOrientationSwapsDimensions(orientation) {
+		resized = orientImage(scaleImage(img, height, width), orientation)
+	} else {
+		resized = orientImage(scaleImage(img, width, height), orientation)
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: imageResizeJPEGQuality})
+	} else {
+		err = png.Encode(&buf, resized)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleImage scales down an image using a box filter, i.e. each target pixel is the average of the source
+// pixels it covers. This is only meant for scaling down; it is simple, but good enough for previews.
+func scaleImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := bounds.Min.Y+y*srcHeight/height, bounds.Min.Y+max((y+1)*srcHeight/height, y*srcHeight/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := bounds.Min.X+x*srcWidth/width, bounds.Min.X+max((x+1)*srcWidth/width, x*srcWidth/width+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA() // Alpha-premultiplied, 16 bits per channel
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(b / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// imageOrientation returns the EXIF orientation (1-8) of a JPEG image, given the beginning of the file, or 1 (normal)
+// if the image has no EXIF data, or is not a JPEG image. See https://www.exif.org/Exif2-2.PDF, section 4.6.4.
+func imageOrientation(header []byte) int {
+	if len(header) < 4 || header[0] != 0xFF || header[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(header); {
+		if header[i] != 0xFF {
+			return 1
+		}
+		marker, length := header[i+1], int(binary.BigEndian.Uint16(header[i+2:]))
+		if marker == 0xDA || length < 2 { // Start of scan, no more metadata
+			return 1
+		}
+		segment := header[i+4 : min(i+2+length, len(header))]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of the given TIFF structure, i.e. the EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 { // Orientation, type SHORT
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// imageOrientationSwapsDimensions returns true if the EXIF orientation rotates the image by 90 degrees
+func imageOrientationSwapsDimensions(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// orientImage transforms the pixels of an image according to the EXIF orientation, so that it is displayed
+// correctly without the EXIF data
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	dstWidth, dstHeight := width, height
+	if imageOrientationSwapsDimensions(orientation) {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = width-1-x, y
+			case 3: // Rotated 180 degrees
+				dx, dy = width-1-x, height-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, height-1-y
+			case 5: // Mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // Rotated 90 degrees clockwise
+				dx, dy = height-1-y, x
+			case 7: // Mirrored along the top-right diagonal
+				dx, dy = height-1-y, width-1-x
+			case 8: // Rotated 90 degrees counter-clockwise
+				dx, dy = y, width-1-x
+			}
+			si, di := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
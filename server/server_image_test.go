@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_FileResizedImage(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	original := newTestPNG(t, 1000, 500)
+	response := request(t, s, "PUT", "/mytopic", string(original), map[string]string{
+		"Filename": "flower.png",
+	})
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, "image/png", msg.Attachment.Type)
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+	// Width is rounded up to the next available width
+	response = request(t, s, "GET", path+"?w=700", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/png", response.Header().Get("Content-Type"))
+	require.Equal(t, `"`+msg.ID+`-w800"`, response.Header().Get("ETag"))
+	img, format, err := image.Decode(bytes.NewReader(response.Body.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, "png", format)
+	require.Equal(t, image.Rect(0, 0, 800, 400), img.Bounds())
+	resized := response.Body.Bytes()
+
+	// Variant is cached, and served from the cache
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID+".w800"))
+	response = request(t, s, "GET", path+"?w=800", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, resized, response.Body.Bytes())
+
+	// Conditional request for the variant
+	response = request(t, s, "GET", path+"?w=800", "", map[string]string{
+		"If-None-Match": `"` + msg.ID + `-w800"`,
+	})
+	require.Equal(t, 304, response.Code)
+
+	// Images that are smaller than the requested width are served as is
+	response = request(t, s, "GET", path+"?w=1200", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `"`+msg.ID+`"`, response.Header().Get("ETag"))
+	require.Equal(t, original, response.Body.Bytes())
+
+	// Invalid width
+	response = request(t, s, "GET", path+"?w=abc", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40068, toHTTPError(t, response.Body.String()).Code)
+
+	// Variants are deleted with the attachment
+	require.Nil(t, s.fileCache.Remove(msg.ID))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID+".w800"))
+}
+
+func TestServer_FileResizedImage_NotAnImage(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	content := util.RandomString(5000)
+	response := request(t, s, "PUT", "/mytopic", content, nil)
+	msg := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+	response = request(t, s, "GET", path+"?w=320", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, content, response.Body.String())
+}
+
+func TestServer_FileResizedImage_Encrypted(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.CacheEncryptionKey = []byte("01234567890123456789012345678901")
+	c.CacheEncryptedTopics = []string{"secret"}
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	response := request(t, s, "PUT", "/secret", string(newTestJPEG(t, 2000, 1000)), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Filename":      "photo.jpg",
+	})
+	msg := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+	response = request(t, s, "GET", path+"?w=320", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/jpeg", response.Header().Get("Content-Type"))
+	config, err := jpeg.DecodeConfig(bytes.NewReader(response.Body.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, 320, config.Width)
+	require.Equal(t, 160, config.Height)
+
+	// Resized variants of encrypted attachments are never stored
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID+".w320"))
+}
+
+func TestServer_FileThumbnail(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", string(newTestPNG(t, 1000, 500)), map[string]string{
+		"Filename": "flower.png",
+	})
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, 1000, msg.Attachment.Width)
+	require.Equal(t, 500, msg.Attachment.Height)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+msg.ID+"/thumb", msg.Attachment.Thumbnail)
+
+	response = request(t, s, "GET", "/file/"+msg.ID+"/thumb", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/png", response.Header().Get("Content-Type"))
+	require.Equal(t, "", response.Header().Get("Content-Disposition"))
+	config, err := png.DecodeConfig(bytes.NewReader(response.Body.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, imageThumbnailWidth, config.Width)
+	require.Equal(t, 160, config.Height)
+
+	// Metadata is stored with the message
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1000, messages[0].Attachment.Width)
+	require.Equal(t, msg.Attachment.Thumbnail, messages[0].Attachment.Thumbnail)
+
+	// No metadata and no thumbnail for other files
+	response = request(t, s, "PUT", "/mytopic", util.RandomString(5000), nil)
+	msg = toMessage(t, response.Body.String())
+	require.Equal(t, 0, msg.Attachment.Width)
+	require.Equal(t, "", msg.Attachment.Thumbnail)
+	response = request(t, s, "GET", "/file/"+msg.ID+"/thumb", "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_FileThumbnail_EXIFOrientation(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	photo := newTestJPEGWithOrientation(t, 800, 400, 6) // Portrait photo, stored in landscape
+	response := request(t, s, "PUT", "/mytopic", string(photo), map[string]string{
+		"Filename": "photo.jpg",
+	})
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, 400, msg.Attachment.Width)
+	require.Equal(t, 800, msg.Attachment.Height)
+
+	response = request(t, s, "GET", "/file/"+msg.ID+"/thumb", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.NotContains(t, response.Body.String(), "Exif")
+	config, err := jpeg.DecodeConfig(bytes.NewReader(response.Body.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, 320, config.Width)
+	require.Equal(t, 640, config.Height)
+}
+
+func TestImageOrientation(t *testing.T) {
+	for orientation := 1; orientation <= 8; orientation++ {
+		require.Equal(t, orientation, imageOrientation(newTestJPEGWithOrientation(t, 10, 10, orientation)))
+	}
+	require.Equal(t, 1, imageOrientation(newTestJPEG(t, 10, 10)))
+	require.Equal(t, 1, imageOrientation(newTestPNG(t, 10, 10)))
+	require.Equal(t, 1, imageOrientation(newTestJPEGWithOrientation(t, 10, 10, 6)[:20])) // Truncated
+}
+
+func TestOrientImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{R: 255, A: 255})
+	src.Set(1, 0, color.RGBA{B: 255, A: 255})
+	dst := orientImage(src, 6) // Rotate clockwise: left pixel ends up on top
+	require.Equal(t, image.Rect(0, 0, 1, 2), dst.Bounds())
+	require.Equal(t, color.RGBA{R: 255, A: 255}, dst.RGBAAt(0, 0))
+	require.Equal(t, color.RGBA{B: 255, A: 255}, dst.RGBAAt(0, 1))
+	dst = orientImage(src, 8) // Rotate counter-clockwise: left pixel ends up at the bottom
+	require.Equal(t, color.RGBA{B: 255, A: 255}, dst.RGBAAt(0, 0))
+	require.Equal(t, color.RGBA{R: 255, A: 255}, dst.RGBAAt(0, 1))
+	require.Equal(t, src, orientImage(src, 1))
+}
+
+func TestImageResizeWidth(t *testing.T) {
+	for param, expected := range map[string]int{"1": 160, "160": 160, "161": 320, "800": 800, "1000": 1280, "99999": 1920} {
+		width, err := imageResizeWidth(param)
+		require.Nil(t, err)
+		require.Equal(t, expected, width, param)
+	}
+	for _, param := range []string{"", "0", "-5", "abc", "1.5"} {
+		_, err := imageResizeWidth(param)
+		require.Equal(t, errHTTPBadRequestImageWidthInvalid, err, param)
+	}
+}
+
+func TestScaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		src.Set(0, y, color.RGBA{R: 255, A: 255})
+		src.Set(1, y, color.RGBA{R: 255, A: 255})
+		src.Set(2, y, color.RGBA{B: 200, A: 255})
+		src.Set(3, y, color.RGBA{B: 100, A: 255})
+	}
+	dst := scaleImage(src, 2, 1)
+	require.Equal(t, color.RGBA{R: 255, A: 255}, dst.RGBAAt(0, 0))
+	require.Equal(t, color.RGBA{B: 150, A: 255}, dst.RGBAAt(1, 0))
+}
+
+func newTestPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.Nil(t, png.Encode(&buf, newTestImage(width, height)))
+	return buf.Bytes()
+}
+
+func newTestJPEG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.Nil(t, jpeg.Encode(&buf, newTestImage(width, height), nil))
+	return buf.Bytes()
+}
+
+// newTestJPEGWithOrientation creates a JPEG image with an EXIF segment that only contains the orientation tag
+func newTestJPEGWithOrientation(t *testing.T, width, height, orientation int) []byte {
+	var exif bytes.Buffer
+	exif.WriteString("Exif\x00\x00")
+	exif.WriteString("MM\x00\x2a")                                               // TIFF header, big endian
+	exif.Write([]byte{0, 0, 0, 8})                                               // Offset of the first IFD
+	exif.Write([]byte{0, 1})                                                     // One entry
+	exif.Write([]byte{0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0}) // Orientation, SHORT, count 1
+	exif.Write([]byte{0, 0, 0, 0})                                               // No next IFD
+	length := exif.Len() + 2
+	original := newTestJPEG(t, width, height)
+	photo := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}, exif.Bytes()...)
+	return append(photo, original[2:]...)
+}
+
+func newTestImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	return img
+}
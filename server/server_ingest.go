@@ -0,0 +1,192 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Webhook signature verification:
+//
+// Services like GitHub, Stripe or Grafana sign the webhooks they send with a shared secret (HMAC-SHA256).
+// If the owner of a reserved topic stores the source and secret via PUT /v1/account/reservation/<topic>/ingest,
+// every message published to the topic must carry a valid signature of that source. This is typically combined
+// with a pre-defined or topic template (e.g. ?template=github) to turn the JSON payload into a message.
+
+const (
+	ingestTimestampTolerance = 5 * time.Minute // Max age of a signed timestamp (Stripe, Grafana), to prevent replay attacks
+)
+
+// verifyIngestSignature checks the HMAC signature of the request body against the ingest settings of the topic.
+// If the topic has no ingest settings, the body is returned as is. Otherwise, the entire body is read (up to
+// the JSON body limit) to compute the signature, and a fresh peeked body is returned.
+func (s *Server) verifyIngestSignature(r *http.Request, v *visitor, t *topic, body *util.PeekedReadCloser) (*util.PeekedReadCloser, error) {
+	if s.userManager == nil {
+		return body, nil
+	}
+	ingest, err := s.userManager.TopicIngest(t.ID)
+	if errors.Is(err, user.ErrTopicIngestNotFound) {
+		return body, nil
+	} else if err != nil {
+		return nil, err
+	}
+	full, err := util.Peek(body, max(s.config.Load().MessageSizeLimit, jsonBodyBytesLimit))
+	if err != nil {
+		return nil, err
+	}
+	if full.LimitReached {
+		err = errors.New("body too large to verify")
+	} else {
+		err = verifyIngestPayload(ingest, r.Header, full.PeekedBytes, time.Now())
+	}
+	if err != nil {
+		logvr(v, r).
+			Tag(tagIngest).
+			Fields(log.Context{"topic": t.ID, "ingest_source": ingest.Source}).
+			Err(err).
+			Info("Rejecting message to topic %s, %s webhook signature verification failed", t.ID, ingest.Source)
+		return nil, errHTTPForbiddenIngestSignatureInvalid.With(t)
+	}
+	return util.Peek(full, s.config.Load().MessageSizeLimit)
+}
+
+// verifyIngestPayload verifies the signature headers of the given source, see
+// https://docs.github.com/en/webhooks/using-webhooks/validating-webhook-deliveries,
+// https://docs.stripe.com/webhooks#verify-manually, and
+// https://grafana.com/docs/grafana/latest/alerting/configure-notifications/manage-contact-points/integrations/webhook-notifier/#hmac-signature
+func verifyIngestPayload(ingest *user.TopicIngest, header http.Header, body []byte, now time.Time) error {
+	switch ingest.Source {
+	case user.IngestSourceGitHub:
+		signature, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return errors.New("X-Hub-Signature-256 header missing or invalid")
+		}
+		return verifyHMAC(ingest.Secret, body, signature)
+	case user.IngestSourceStripe:
+		var timestamp string
+		var signatures []string
+		for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if key == "t" {
+				timestamp = value
+			} else if key == "v1" {
+				signatures = append(signatures, value)
+			}
+		}
+		if timestamp == "" || len(signatures) == 0 {
+			return errors.New("Stripe-Signature header missing or invalid")
+		} else if err := verifyIngestTimestamp(timestamp, now); err != nil {
+			return err
+		}
+		payload := append([]byte(timestamp+"."), body...)
+		for _, signature := range signatures {
+			if verifyHMAC(ingest.Secret, payload, signature) == nil {
+				return nil
+			}
+		}
+		return errors.New("signature mismatch")
+	case user.IngestSourceGrafana:
+		signature := header.Get("X-Grafana-Alerting-Signature")
+		if signature == "" {
+			return errors.New("X-Grafana-Alerting-Signature header missing")
+		}
+		payload := body
+		if timestamp := header.Get("X-Grafana-Alerting-Signature-Timestamp"); timestamp != "" {
+			if err := verifyIngestTimestamp(timestamp, now); err != nil {
+				return err
+			}
+			payload = append([]byte(timestamp+":"), body...)
+		}
+		return verifyHMAC(ingest.Secret, payload, signature)
+	}
+	return errors.New("unknown ingest source")
+}
+
+func verifyHMAC(secret string, payload []byte, signature string) error {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("signature is not hex encoded")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func verifyIngestTimestamp(timestamp string, now time.Time) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("signature timestamp invalid")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > ingestTimestampTolerance || age < -ingestTimestampTolerance {
+		return errors.New("signature timestamp outside of tolerance")
+	}
+	return nil
+}
+
+func (s *Server) handleAccountReservationIngestGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	ingest, err := s.userManager.TopicIngest(topic)
+	if errors.Is(err, user.ErrTopicIngestNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountTopicIngest{
+		Source: string(ingest.Source), // The secret is never returned
+	})
+}
+
+func (s *Server) handleAccountReservationIngestChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountTopicIngest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedIngestSource(user.IngestSource(req.Source)) || req.Secret == "" {
+		return errHTTPBadRequestIngestInvalid
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{"topic": topic, "ingest_source": req.Source}).
+		Debug("Changing ingest settings for topic %s", topic)
+	if err := s.userManager.ChangeTopicIngest(v.User().Name, &user.TopicIngest{
+		Topic:  topic,
+		Source: user.IngestSource(req.Source),
+		Secret: req.Secret,
+	}); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationIngestDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Removing ingest settings for topic %s", topic)
+	if err := s.userManager.RemoveTopicIngest(topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
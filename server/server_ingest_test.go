@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Ingest_Settings(t *testing.T) {
+	s := newTestServerWithIngest(t)
+
+	response := request(t, s, "GET", "/v1/account/reservation/mytopic/ingest", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "PUT", "/v1/account/reservation/mytopic/ingest", `{"source":"github","secret":"mysecret"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/ingest", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	ingest, err := util.UnmarshalJSON[apiAccountTopicIngest](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "github", ingest.Source)
+	require.Equal(t, "", ingest.Secret) // Never returned
+
+	response = request(t, s, "PUT", "/v1/account/reservation/mytopic/ingest", `{"source":"gitlab","secret":"mysecret"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40081, toHTTPError(t, response.Body.String()).Code)
+
+	// Topic not owned by user
+	response = request(t, s, "PUT", "/v1/account/reservation/othertopic/ingest", `{"source":"github","secret":"mysecret"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "DELETE", "/v1/account/reservation/mytopic/ingest", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	_, err = s.userManager.TopicIngest("mytopic")
+	require.Equal(t, user.ErrTopicIngestNotFound, err)
+}
+
+func TestServer_Ingest_GitHub(t *testing.T) {
+	s := newTestServerWithIngest(t)
+	require.Nil(t, s.userManager.ChangeTopicIngest("ben", &user.TopicIngest{Topic: "mytopic", Source: user.IngestSourceGitHub, Secret: "mysecret"}))
+
+	// Payloads larger than the message limit are verified in full
+	body := fmt.Sprintf(`{"action":"opened","repository":{"full_name":"binwiederhier/ntfy","description":"%s"}}`, strings.Repeat("x", 5000))
+	response := request(t, s, "POST", "/mytopic?tpl=1&m={{.action}}+{{.repository.full_name}}", body, map[string]string{
+		"X-Hub-Signature-256": "sha256=" + testIngestHMAC("mysecret", body),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "opened binwiederhier/ntfy", toMessage(t, response.Body.String()).Message)
+
+	// Forged or missing signature
+	response = request(t, s, "POST", "/mytopic", body, map[string]string{
+		"X-Hub-Signature-256": "sha256=" + testIngestHMAC("wrongsecret", body),
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40307, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/mytopic", body, nil)
+	require.Equal(t, 403, response.Code)
+
+	// Other topics are not affected
+	response = request(t, s, "POST", "/othertopic", body, nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_Ingest_Stripe(t *testing.T) {
+	s := newTestServerWithIngest(t)
+	require.Nil(t, s.userManager.ChangeTopicIngest("ben", &user.TopicIngest{Topic: "mytopic", Source: user.IngestSourceStripe, Secret: "whsec_abc"}))
+
+	body := `{"type":"invoice.paid"}`
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	response := request(t, s, "POST", "/mytopic", body, map[string]string{
+		"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s,v1=%s", timestamp, testIngestHMAC("whsec_old", timestamp+"."+body), testIngestHMAC("whsec_abc", timestamp+"."+body)),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, body, toMessage(t, response.Body.String()).Message)
+
+	// Replayed request with an old timestamp
+	timestamp = fmt.Sprintf("%d", time.Now().Add(-10*time.Minute).Unix())
+	response = request(t, s, "POST", "/mytopic", body, map[string]string{
+		"Stripe-Signature": fmt.Sprintf("t=%s,v1=%s", timestamp, testIngestHMAC("whsec_abc", timestamp+"."+body)),
+	})
+	require.Equal(t, 403, response.Code)
+}
+
+func TestServer_Ingest_Grafana(t *testing.T) {
+	s := newTestServerWithIngest(t)
+	require.Nil(t, s.userManager.ChangeTopicIngest("ben", &user.TopicIngest{Topic: "mytopic", Source: user.IngestSourceGrafana, Secret: "mysecret"}))
+
+	body := `{"status":"firing"}`
+	response := request(t, s, "POST", "/mytopic", body, map[string]string{
+		"X-Grafana-Alerting-Signature": testIngestHMAC("mysecret", body),
+	})
+	require.Equal(t, 200, response.Code)
+
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	response = request(t, s, "POST", "/mytopic", body, map[string]string{
+		"X-Grafana-Alerting-Signature":           testIngestHMAC("mysecret", timestamp+":"+body),
+		"X-Grafana-Alerting-Signature-Timestamp": timestamp,
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Signature without the timestamp does not match
+	response = request(t, s, "POST", "/mytopic", body, map[string]string{
+		"X-Grafana-Alerting-Signature":           testIngestHMAC("mysecret", body),
+		"X-Grafana-Alerting-Signature-Timestamp": timestamp,
+	})
+	require.Equal(t, 403, response.Code)
+}
+
+func newTestServerWithIngest(t *testing.T) *Server {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	conf.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionReadWrite))
+	return s
+}
+
+func testIngestHMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// handleAccountLimitsGet returns the current usage of the visitor's limits, and when they are replenished
+// (GET /v1/account/limits). This works for anonymous visitors too, in which case the limits are based on
+// the IP address. The endpoint is not rate limited itself, so that scripts can poll it before publishing.
+func (s *Server) handleAccountLimitsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	quotas, err := v.Quotas()
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountLimitsResponse{
+		Basis:               string(quotas.Basis),
+		Country:             quotas.Country,
+		Requests:            newAPIAccountQuota(quotas.Requests),
+		Messages:            newAPIAccountQuota(quotas.Messages),
+		Emails:              newAPIAccountQuota(quotas.Emails),
+		Calls:               newAPIAccountQuota(quotas.Calls),
+		Subscriptions:       newAPIAccountQuota(quotas.Subscriptions),
+		AttachmentTotalSize: newAPIAccountQuota(quotas.AttachmentTotalSize),
+		AttachmentBandwidth: newAPIAccountQuota(quotas.AttachmentBandwidth),
+	})
+}
+
+func newAPIAccountQuota(q *visitorQuota) *apiAccountQuota {
+	quota := &apiAccountQuota{
+		Limit:     q.Limit,
+		Used:      q.Used,
+		Remaining: q.Remaining,
+	}
+	if !q.Reset.IsZero() {
+		quota.Reset = q.Reset.Unix()
+	}
+	return quota
+}
+
+// setRateLimitHeaders adds the RateLimit-* and Retry-After headers to a "429 Too Many Requests" response,
+// describing the limit that was reached, e.g. the daily message limit for errHTTPTooManyRequestsLimitMessages.
+// The RateLimit-* headers follow the IETF draft (draft-ietf-httpapi-ratelimit-headers), so RateLimit-Reset is
+// the number of seconds until the quota is fully replenished, not a timestamp.
+func (s *Server) setRateLimitHeaders(w http.ResponseWriter, v *visitor, code int) {
+	quota := rateLimitQuota(v.QuotasLight(), code)
+	if quota == nil {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", fmt.Sprintf("%d", quota.Limit))
+	w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d", quota.Remaining))
+	if !quota.Reset.IsZero() {
+		w.Header().Set("RateLimit-Reset", fmt.Sprintf("%d", ceilSeconds(time.Until(quota.Reset))))
+	}
+	if quota.RetryAfter > 0 {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", max(ceilSeconds(quota.RetryAfter), 1)))
+	}
+	w.Header().Set("Access-Control-Expose-Headers", "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After") // CORS, allow scripts to read the headers
+}
+
+// rateLimitQuota returns the quota that corresponds to the given ntfy error code, or nil if the error does not
+// correspond to a visitor limit (e.g. the total number of topics)
+func rateLimitQuota(quotas *visitorQuotas, code int) *visitorQuota {
+	switch code {
+	case errHTTPTooManyRequestsLimitRequests.Code:
+		return quotas.Requests
+	case errHTTPTooManyRequestsLimitMessages.Code:
+		return quotas.Messages
+	case errHTTPTooManyRequestsLimitEmails.Code:
+		return quotas.Emails
+	case errHTTPTooManyRequestsLimitCalls.Code:
+		return quotas.Calls
+	case errHTTPTooManyRequestsLimitSubscriptions.Code:
+		return quotas.Subscriptions
+	case errHTTPTooManyRequestsLimitAttachmentBandwidth.Code:
+		return quotas.AttachmentBandwidth
+	}
+	return nil
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(max(d, 0).Seconds()))
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_AccountLimits_Anonymous(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 10
+	c.VisitorMessageDailyLimit = 5
+	s := newTestServer(t, c)
+
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", "some message", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "GET", "/v1/account/limits", "", nil)
+	require.Equal(t, 200, response.Code)
+	limits, err := util.UnmarshalJSON[apiAccountLimitsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "ip", limits.Basis)
+	require.Equal(t, int64(10), limits.Requests.Limit)
+	require.Equal(t, int64(3), limits.Requests.Used)
+	require.Equal(t, int64(7), limits.Requests.Remaining)
+	require.Equal(t, int64(5), limits.Messages.Limit)
+	require.Equal(t, int64(3), limits.Messages.Used)
+	require.Equal(t, int64(2), limits.Messages.Remaining)
+	require.Equal(t, util.NextOccurrenceUTC(c.VisitorStatsResetTime, time.Now()).Unix(), limits.Messages.Reset)
+	require.Equal(t, int64(c.VisitorSubscriptionLimit), limits.Subscriptions.Remaining)
+	require.Equal(t, int64(0), limits.Subscriptions.Reset)
+	require.Equal(t, c.VisitorAttachmentTotalSizeLimit, limits.AttachmentTotalSize.Remaining)
+	require.Equal(t, c.VisitorAttachmentDailyBandwidthLimit, limits.AttachmentBandwidth.Remaining)
+
+	// Querying the limits does not count as a request
+	response = request(t, s, "GET", "/v1/account/limits", "", nil)
+	limits, err = util.UnmarshalJSON[apiAccountLimitsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(7), limits.Requests.Remaining)
+}
+
+func TestServer_AccountLimits_Tier(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                     "pro",
+		MessageLimit:             123,
+		EmailLimit:               12,
+		AttachmentTotalSizeLimit: 1000,
+		AttachmentBandwidthLimit: 5000,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/v1/account/limits", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	limits, err := util.UnmarshalJSON[apiAccountLimitsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "tier", limits.Basis)
+	require.Equal(t, int64(123), limits.Messages.Limit)
+	require.Equal(t, int64(1), limits.Messages.Used)
+	require.Equal(t, int64(12), limits.Emails.Remaining)
+	require.Equal(t, int64(1000), limits.AttachmentTotalSize.Limit)
+	require.Equal(t, int64(5000), limits.AttachmentBandwidth.Limit)
+}
+
+func TestServer_AccountLimits_TooManyRequestsHeaders(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 3
+	s := newTestServer(t, c)
+
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", "some message", nil)
+		require.Equal(t, 200, response.Code)
+		require.Equal(t, "", response.Header().Get("Retry-After"))
+	}
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42901, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, "3", response.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", response.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "5", response.Header().Get("Retry-After")) // One request per 5 seconds
+	reset, err := strconv.Atoi(response.Header().Get("RateLimit-Reset"))
+	require.Nil(t, err)
+	require.InDelta(t, 15, reset, 1)
+}
+
+func TestServer_AccountLimits_TooManyMessagesHeaders(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorMessageDailyLimit = 2
+	s := newTestServer(t, c)
+
+	for i := 0; i < 2; i++ {
+		response := request(t, s, "PUT", "/mytopic", "some message", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42908, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, "2", response.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", response.Header().Get("RateLimit-Remaining"))
+	untilReset := int(time.Until(util.NextOccurrenceUTC(c.VisitorStatsResetTime, time.Now())).Seconds())
+	retryAfter, err := strconv.Atoi(response.Header().Get("Retry-After"))
+	require.Nil(t, err)
+	require.InDelta(t, untilReset, retryAfter, 2)
+	reset, err := strconv.Atoi(response.Header().Get("RateLimit-Reset"))
+	require.Nil(t, err)
+	require.InDelta(t, untilReset, reset, 2)
+}
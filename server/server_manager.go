@@ -2,8 +2,11 @@ package server
 
 import (
 	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"slices"
 	"strings"
+	"time"
 )
 
 func (s *Server) execManager() {
@@ -12,10 +15,17 @@ func (s *Server) execManager() {
 
 	// Prune all the things
 	s.pruneVisitors()
+	s.pruneEphemeralTopics()
 	s.pruneTokens()
 	s.pruneAttachments()
+	s.pruneUploads()
 	s.pruneMessages()
+	if s.dedup != nil {
+		s.dedup.Prune()
+	}
 	s.pruneAndNotifyWebPushSubscriptions()
+	s.pruneAPNSDevices()
+	s.downgradeUsersWithExpiredPaymentGrace()
 
 	// Message count per topic
 	var messagesCached int
@@ -30,6 +40,7 @@ func (s *Server) execManager() {
 
 	// Remove subscriptions without subscribers
 	var emptyTopics, subscribers int
+	subscribersByTopic := make(map[string]int)
 	log.
 		Tag(tagManager).
 		Timing(func() {
@@ -49,6 +60,7 @@ func (s *Server) execManager() {
 						ev.Trace("- topic %s: %d subscribers, accessed %s", t.ID, subs, util.FormatTime(lastAccess))
 					}
 					subscribers += subs
+					subscribersByTopic[t.ID] = subs
 				}
 			}
 		}).
@@ -60,8 +72,8 @@ func (s *Server) execManager() {
 		receivedMailTotal, receivedMailSuccess, receivedMailFailure = s.smtpServerBackend.Counts()
 	}
 	var sentMailTotal, sentMailSuccess, sentMailFailure int64
-	if s.smtpSender != nil {
-		sentMailTotal, sentMailSuccess, sentMailFailure = s.smtpSender.Counts()
+	if sender := s.emailSender(); sender != nil {
+		sentMailTotal, sentMailSuccess, sentMailFailure = sender.Counts()
 	}
 
 	// Users
@@ -80,6 +92,7 @@ func (s *Server) execManager() {
 
 	// Update stats
 	s.updateAndWriteStats(messagesCount)
+	s.writeStatsRollups()
 
 	// Log stats
 	log.
@@ -104,6 +117,7 @@ func (s *Server) execManager() {
 	mset(metricUsers, usersCount)
 	mset(metricSubscribers, subscribers)
 	mset(metricTopics, topicsCount)
+	msetTopics(metricTopicSubscribers, subscribersByTopic)
 }
 
 func (s *Server) pruneVisitors() {
@@ -136,11 +150,63 @@ func (s *Server) pruneTokens() {
 				if err := s.userManager.RemoveDeletedUsers(); err != nil {
 					log.Tag(tagManager).Err(err).Warn("Error deleting soft-deleted users")
 				}
+				if err := s.userManager.RemoveExpiredEmailFallbacks(); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error removing expired fallback email addresses")
+				}
+				if err := s.userManager.RemoveExpiredGrants(); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error removing expired access control entries")
+				}
+				if err := s.userManager.RemoveExpiredBans(); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error removing expired bans")
+				}
+				s.reloadBans() // Picks up bans that were added or removed via the CLI
 			}).
 			Debug("Removed expired tokens and users")
 	}
 }
 
+// pruneEphemeralTopics deletes all messages and attachments of expired ephemeral topics, disconnects their
+// subscribers, and removes the topics' tokens and access control entries
+func (s *Server) pruneEphemeralTopics() {
+	if s.userManager == nil {
+		return
+	}
+	topics, err := s.userManager.EphemeralTopicsExpired()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error retrieving expired ephemeral topics")
+		return
+	} else if len(topics) == 0 {
+		log.Tag(tagManager).Debug("No expired ephemeral topics to delete")
+		return
+	}
+	log.Tag(tagManager).Debug("Deleting %d expired ephemeral topic(s)", len(topics))
+	for _, topic := range topics {
+		ids, err := s.messageCache.MessageIDs(topic)
+		if err != nil {
+			log.Tag(tagManager).Field("topic", topic).Err(err).Warn("Error retrieving messages of ephemeral topic")
+			continue
+		}
+		if s.fileCache != nil {
+			if err := s.fileCache.Remove(ids...); err != nil {
+				log.Tag(tagManager).Field("topic", topic).Err(err).Warn("Error deleting attachments of ephemeral topic")
+			}
+		}
+		if err := s.messageCache.DeleteMessages(ids...); err != nil {
+			log.Tag(tagManager).Field("topic", topic).Err(err).Warn("Error deleting messages of ephemeral topic")
+			continue
+		}
+		s.mu.Lock()
+		if t, ok := s.topics[topic]; ok {
+			t.Close(time.Time{})
+			delete(s.topics, topic)
+		}
+		s.mu.Unlock()
+		if err := s.userManager.RemoveEphemeralTopics(topic); err != nil {
+			log.Tag(tagManager).Field("topic", topic).Err(err).Warn("Error removing ephemeral topic")
+		}
+	}
+}
+
 func (s *Server) pruneAttachments() {
 	if s.fileCache == nil {
 		return
@@ -148,7 +214,7 @@ func (s *Server) pruneAttachments() {
 	log.
 		Tag(tagManager).
 		Timing(func() {
-			ids, err := s.messageCache.AttachmentsExpired()
+			ids, err := s.expiredAttachments()
 			if err != nil {
 				log.Tag(tagManager).Err(err).Warn("Error retrieving expired attachments")
 			} else if len(ids) > 0 {
@@ -168,10 +234,59 @@ func (s *Server) pruneAttachments() {
 		Debug("Deleted expired attachments")
 }
 
+// pruneUploads deletes resumable uploads that were not finished in time, as well as partial uploads that are not
+// known to the server anymore (e.g. after a restart, since unfinished uploads are only kept in memory)
+func (s *Server) pruneUploads() {
+	if s.uploads == nil {
+		return
+	}
+	ids := s.uploads.Expired()
+	partials, err := s.fileCache.Partials()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error retrieving partial uploads")
+	}
+	for id := range partials {
+		if !s.uploads.Exists(id) && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		log.Tag(tagManager).Debug("No expired uploads to delete")
+		return
+	}
+	log.Tag(tagManager).Debug("Deleting %d expired or orphaned upload(s)", len(ids))
+	if err := s.fileCache.RemovePartial(ids...); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error deleting partial uploads")
+	}
+}
+
+// expiredAttachments returns the IDs of all expired attachments, including the ones that expired because of an
+// attachment expiry rule that was shortened after the attachment was uploaded
+func (s *Server) expiredAttachments() ([]string, error) {
+	ids, err := s.messageCache.AttachmentsExpired()
+	if err != nil || len(s.config.Load().AttachmentExpiryRules) == 0 {
+		return ids, err
+	}
+	idsByRule, err := s.messageCache.AttachmentsExpiredByRules(s.config.Load().AttachmentExpiryRules)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range idsByRule {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
 func (s *Server) pruneMessages() {
 	log.
 		Tag(tagManager).
 		Timing(func() {
+			expiredTTLMessages, err := s.messageCache.MessagesExpiredTTL()
+			if err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error retrieving messages expired by publisher")
+			}
 			expiredMessageIDs, err := s.messageCache.MessagesExpired()
 			if err != nil {
 				log.Tag(tagManager).Err(err).Warn("Error retrieving expired messages")
@@ -187,6 +302,85 @@ func (s *Server) pruneMessages() {
 			} else {
 				log.Tag(tagManager).Debug("No expired messages to delete")
 			}
+			s.publishExpiredMessagesDeleted(expiredTTLMessages)
+			s.pruneMessagesByTopicPolicy()
+			if deleted, err := s.messageCache.DeleteExpiredTombstones(); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error deleting expired tombstones")
+			} else if deleted > 0 {
+				log.Tag(tagManager).Debug("Deleted %d expired tombstone(s)", deleted)
+			}
 		}).
 		Debug("Pruned messages")
 }
+
+// publishExpiredMessagesDeleted publishes a "message_deleted" event for each of the given messages, so that
+// clients also remove messages whose expiry was set by the publisher (see X-Expires). Messages that expire at
+// the end of the regular cache duration are deleted silently.
+func (s *Server) publishExpiredMessagesDeleted(messages []*message) {
+	for _, m := range messages {
+		var u *user.User
+		if s.userManager != nil && m.User != "" {
+			u, _ = s.userManager.UserByID(m.User) // User may have been deleted in the meantime
+		}
+		v := s.visitor(m.Sender, u)
+		ev := newMessageDeletedMessage(m.Topic, m.ID)
+		logvm(v, ev).Tag(tagManager).Debug("Message expired, publishing deletion event")
+		s.mu.RLock()
+		t, ok := s.topics[m.Topic]
+		s.mu.RUnlock()
+		if ok {
+			if err := t.Publish(v, ev); err != nil {
+				logvm(v, ev).Tag(tagManager).Err(err).Warn("Unable to publish deletion event of expired message")
+			}
+		}
+		s.cluster.Publish(ev)
+	}
+}
+
+// pruneMessagesByTopicPolicy deletes messages of topics with a policy, if they are older than the topic's cache
+// duration, or if the topic has more messages than the policy allows. Messages are usually pruned when they
+// expire (see pruneMessages), but the expiry is set when the message is published, so without this, lowering
+// the cache duration of a topic would only affect new messages.
+func (s *Server) pruneMessagesByTopicPolicy() {
+	if s.userManager == nil {
+		return
+	}
+	policies, err := s.userManager.TopicPolicies()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error retrieving topic policies")
+		return
+	}
+	for _, policy := range policies {
+		if policy.CacheDuration > 0 {
+			ids, err := s.messageCache.MessagesOlderThan(policy.Topic, time.Now().Add(-policy.CacheDuration))
+			if err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error retrieving expired messages of topic %s", policy.Topic)
+			} else {
+				s.deletePrunedMessages(policy.Topic, ids)
+			}
+		}
+		if policy.MessageLimit > 0 {
+			ids, err := s.messageCache.MessagesOverLimit(policy.Topic, policy.MessageLimit)
+			if err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error retrieving messages over limit of topic %s", policy.Topic)
+			} else {
+				s.deletePrunedMessages(policy.Topic, ids)
+			}
+		}
+	}
+}
+
+func (s *Server) deletePrunedMessages(topic string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	log.Tag(tagManager).Debug("Deleting %d message(s) of topic %s due to topic policy", len(ids), topic)
+	if s.fileCache != nil {
+		if err := s.fileCache.Remove(ids...); err != nil {
+			log.Tag(tagManager).Err(err).Warn("Error deleting attachments of pruned messages")
+		}
+	}
+	if err := s.messageCache.DeleteMessages(ids...); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error deleting pruned messages")
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday/v2"
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	contentTypeTextPlain    = "text/plain"
+	contentTypeTextMarkdown = "text/markdown"
+)
+
+var (
+	// markdownPolicy is the HTML sanitizer policy used for rendered Markdown. It allows the common
+	// user-generated-content elements (links, lists, code, tables, ...), but strips scripts, styles and
+	// event handlers, and forces rel="nofollow noopener" on links.
+	markdownPolicy = bluemonday.UGCPolicy().
+			RequireNoFollowOnLinks(true).
+			AddTargetBlankToFullyQualifiedLinks(true)
+
+	// markdownExtensions are the blackfriday extensions used to render Markdown messages. They are
+	// chosen to match the Markdown flavor that the web app renders.
+	markdownExtensions = blackfriday.CommonExtensions | blackfriday.HardLineBreak
+)
+
+// handleMessageHTML renders a single cached message as sanitized HTML. Markdown messages are
+// rendered to HTML, and plain text messages are HTML-escaped. This is used to show messages in
+// a consistent way outside of the web app and apps (e.g. in emails, or in embedded web views).
+func (s *Server) handleMessageHTML(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := messageHTMLPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topicID, messageID := matches[1], matches[2]
+	m, err := s.messageCache.Message(messageID)
+	if errors.Is(err, errMessageNotFound) || (err == nil && m.Topic != topicID) {
+		return errHTTPNotFound.Fields(log.Context{
+			"message_id":    messageID,
+			"error_context": "message_cache",
+		})
+	} else if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src https: data:; style-src 'unsafe-inline'")
+	_, err = w.Write([]byte(renderMessageHTML(m)))
+	return err
+}
+
+// renderMessageHTML returns the sanitized HTML representation of the message body. If the
+// message has a title, it is rendered as a heading above the body.
+func renderMessageHTML(m *message) string {
+	var b strings.Builder
+	if m.Title != "" {
+		b.WriteString("<h1>" + html.EscapeString(m.Title) + "</h1>\n")
+	}
+	if m.ContentType == contentTypeTextMarkdown {
+		b.WriteString(renderMarkdownHTML(m.Message))
+	} else {
+		b.WriteString(renderPlainHTML(m.Message))
+	}
+	return b.String()
+}
+
+// renderMarkdownHTML converts Markdown to HTML, and sanitizes the result, so that it is
+// safe to embed in a web page or an email
+func renderMarkdownHTML(markdown string) string {
+	unsafe := blackfriday.Run([]byte(strings.ReplaceAll(markdown, "\r\n", "\n")), blackfriday.WithExtensions(markdownExtensions))
+	return string(markdownPolicy.SanitizeBytes(unsafe))
+}
+
+// renderPlainHTML escapes plain text and converts line breaks to <br> tags
+func renderPlainHTML(text string) string {
+	escaped := html.EscapeString(strings.ReplaceAll(text, "\r\n", "\n"))
+	return "<p>" + strings.ReplaceAll(escaped, "\n", "<br>\n") + "</p>\n"
+}
+
+// topicDefaultContentType returns the default content type for the given topic, as defined
+// in the topic-content-types config option, or an empty string if none is defined.
+func (s *Server) topicDefaultContentType(topic string) string {
+	if s.config.Load().TopicContentTypes == nil {
+		return ""
+	}
+	return s.config.Load().TopicContentTypes[topic]
+}
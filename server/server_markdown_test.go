@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_MessageHTML_Markdown(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "**bold** [link](https://ntfy.sh) <script>alert('hi')</script>", map[string]string{
+		"Title":    "Some <b>title</b>",
+		"Markdown": "yes",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/html", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+	body := response.Body.String()
+	require.Contains(t, body, "<h1>Some &lt;b&gt;title&lt;/b&gt;</h1>")
+	require.Contains(t, body, "<strong>bold</strong>")
+	require.Contains(t, body, `<a href="https://ntfy.sh" rel="nofollow noopener" target="_blank">link</a>`)
+	require.NotContains(t, body, "<script>")
+}
+
+func TestServer_MessageHTML_PlainText(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "line 1 <i>\nline 2", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/html", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "<p>line 1 &lt;i&gt;<br>\nline 2</p>\n", response.Body.String())
+}
+
+func TestServer_MessageHTML_NotFound(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/othertopic/"+m.ID+"/html", "", nil)
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/doesnotexist/html", "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_MessageHTML_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/html", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/"+m.ID+"/html", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_PublishMarkdown_TopicDefaultContentType(t *testing.T) {
+	c := newTestConfig(t)
+	c.TopicContentTypes = map[string]string{"reports": "text/markdown"}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/reports", "**make this bold**", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded", // Like curl -d
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "text/markdown", toMessage(t, response.Body.String()).ContentType)
+
+	response = request(t, s, "PUT", "/reports", "**not bold**", map[string]string{
+		"Content-Type": "text/plain",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "", toMessage(t, response.Body.String()).ContentType)
+
+	response = request(t, s, "PUT", "/othertopic", "**not bold**", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "", toMessage(t, response.Body.String()).ContentType)
+}
+
+func TestRenderMarkdownHTML_Sanitized(t *testing.T) {
+	require.NotContains(t, renderMarkdownHTML(`hi<img src=x onerror="alert(1)">`), "onerror")
+	require.NotContains(t, renderMarkdownHTML(`[click](javascript:alert(1))`), "javascript:")
+	require.Contains(t, renderMarkdownHTML("| a | b |\n|---|---|\n| 1 | 2 |"), "<table>")
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	matrixBridgeTimeout       = 10 * time.Second
+	matrixBridgeMaxAttempts   = 3
+	matrixBridgeRetryDelay    = 2 * time.Second  // Used if the homeserver does not send retry_after_ms
+	matrixBridgeMaxRetryDelay = 30 * time.Second // Never wait longer than this, even if the homeserver asks for it
+	matrixBridgeResponseLimit = 512
+)
+
+// matrixBridge relays messages from configured topics into Matrix rooms (see matrix-bridge-rooms), using the
+// Matrix client-server API: https://spec.matrix.org/v1.9/client-server-api/#put_matrixclientv3roomsroomidsendeventtypetxnid
+//
+// The access token can be the token of a regular bot account that has joined the rooms, or the as_token of an
+// application service. In the latter case, matrix-bridge-user-id selects the user the messages are sent as.
+//
+// This is the opposite direction of the Matrix Push Gateway (see server_matrix.go), which receives push
+// notifications from a homeserver.
+type matrixBridge struct {
+	homeserver string // Base URL, without trailing slash
+	token      string
+	userID     string              // Application service user to impersonate, may be empty
+	rooms      map[string][]string // Topic -> room IDs
+	client     *http.Client
+	retryDelay time.Duration // Can be replaced in tests
+}
+
+// matrixRoomMessage is the content of an m.room.message event, see https://spec.matrix.org/v1.9/client-server-api/#mroommessage
+type matrixRoomMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+type matrixErrorResponse struct {
+	ErrCode      string `json:"errcode"`
+	Error        string `json:"error"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+func newMatrixBridge(conf *Config) *matrixBridge {
+	return &matrixBridge{
+		homeserver: strings.TrimSuffix(conf.MatrixBridgeHomeserver, "/"),
+		token:      conf.MatrixBridgeToken,
+		userID:     conf.MatrixBridgeUserID,
+		rooms:      conf.MatrixBridgeRooms,
+		client:     &http.Client{Timeout: matrixBridgeTimeout},
+		retryDelay: matrixBridgeRetryDelay,
+	}
+}
+
+// Rooms returns the room IDs that messages of the given topic are relayed to
+func (b *matrixBridge) Rooms(topic string) []string {
+	return b.rooms[topic]
+}
+
+// Send sends the message to the given room. The message ID is used as transaction ID, so if a request is
+// retried after the homeserver already accepted it, the message does not show up twice.
+func (b *matrixBridge) Send(roomID string, m *message) error {
+	body, err := json.Marshal(newMatrixRoomMessage(m))
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s", b.homeserver, url.PathEscape(roomID), url.PathEscape(m.ID))
+	if b.userID != "" {
+		u += "?user_id=" + url.QueryEscape(b.userID)
+	}
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := b.send(u, body)
+		if err == nil {
+			return nil
+		} else if retryAfter == 0 || attempt >= matrixBridgeMaxAttempts {
+			return err
+		}
+		time.Sleep(retryAfter)
+	}
+}
+
+// send makes a single request, and returns how long to wait before retrying if the request can be retried
+func (b *matrixBridge) send(u string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return b.retryDelay, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return 0, nil
+	}
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, matrixBridgeResponseLimit))
+	err = fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	var matrixErr matrixErrorResponse
+	if json.Unmarshal(response, &matrixErr) == nil && matrixErr.ErrCode != "" {
+		err = fmt.Errorf("unexpected HTTP status %s: %s %s", resp.Status, matrixErr.ErrCode, matrixErr.Error)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests && matrixErr.RetryAfterMs > 0 {
+		return min(time.Duration(matrixErr.RetryAfterMs)*time.Millisecond, matrixBridgeMaxRetryDelay), err
+	} else if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return b.retryDelay, err
+	}
+	return 0, err
+}
+
+// newMatrixRoomMessage converts a message to an m.room.message event, with the title in bold, followed by the
+// message, and links to the click URL and attachment (if any)
+func newMatrixRoomMessage(m *message) *matrixRoomMessage {
+	var text, formatted strings.Builder
+	if m.Title != "" {
+		text.WriteString(m.Title + "\n")
+		formatted.WriteString("<strong>" + html.EscapeString(m.Title) + "</strong><br>")
+	}
+	text.WriteString(m.Message)
+	formatted.WriteString(strings.ReplaceAll(html.EscapeString(m.Message), "\n", "<br>"))
+	links := make([][2]string, 0) // Name, URL
+	if m.Click != "" {
+		links = append(links, [2]string{"Open", m.Click})
+	}
+	if m.Attachment != nil {
+		links = append(links, [2]string{m.Attachment.Name, m.Attachment.URL})
+	}
+	for _, link := range links {
+		text.WriteString(fmt.Sprintf("\n%s: %s", link[0], link[1]))
+		formatted.WriteString(fmt.Sprintf(`<br><a href="%s">%s</a>`, html.EscapeString(link[1]), html.EscapeString(link[0])))
+	}
+	return &matrixRoomMessage{
+		MsgType:       "m.text",
+		Body:          text.String(),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted.String(),
+	}
+}
+
+// relayToMatrix sends the message to all Matrix rooms configured for its topic (see matrixBridge). Client-side
+// encrypted and binary messages are not relayed, since they cannot be displayed in Matrix.
+func (s *Server) relayToMatrix(v *visitor, m *message) {
+	rooms := s.matrixBridge.Rooms(m.Topic)
+	if len(rooms) == 0 || m.Event != messageEvent {
+		return
+	} else if m.Encryption != "" || m.Encoding != "" {
+		logvm(v, m).Tag(tagMatrix).Debug("Not relaying encrypted or binary message to Matrix")
+		return
+	}
+	for _, roomID := range rooms {
+		ev := logvm(v, m).Tag(tagMatrix).Fields(log.Context{"matrix_room_id": roomID})
+		if err := s.matrixBridge.Send(roomID, m); err != nil {
+			ev.Err(err).Warn("Unable to relay message to Matrix room")
+			continue
+		}
+		ev.Debug("Relayed message to Matrix room")
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_MatrixBridge_Relay(t *testing.T) {
+	var mu sync.Mutex
+	requests := make([]*http.Request, 0)
+	events := make([]*matrixRoomMessage, 0)
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event matrixRoomMessage
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&event))
+		mu.Lock()
+		requests = append(requests, r)
+		events = append(events, &event)
+		mu.Unlock()
+		w.Write([]byte(`{"event_id":"$abc"}`))
+	}))
+	defer homeserver.Close()
+
+	c := newTestConfig(t)
+	c.MatrixBridgeHomeserver = homeserver.URL + "/"
+	c.MatrixBridgeToken = "syt_secret"
+	c.MatrixBridgeUserID = "@ntfy:example.com"
+	c.MatrixBridgeRooms = map[string][]string{"alerts": {"!room1:example.com", "!room2:example.com"}}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/alerts", "Disk <full>\non backup01", map[string]string{
+		"Title": "Backup failed",
+		"Click": "https://example.com/backups",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Other topics and excluded channels are not relayed
+	require.Equal(t, 200, request(t, s, "PUT", "/other", "not bridged", nil).Code)
+	require.Equal(t, 200, request(t, s, "PUT", "/alerts?channels=push", "not for matrix", nil).Code)
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests) == 2
+	})
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, requests, 2)
+	paths := []string{requests[0].URL.Path, requests[1].URL.Path}
+	require.ElementsMatch(t, []string{
+		"/_matrix/client/v3/rooms/!room1:example.com/send/m.room.message/" + m.ID,
+		"/_matrix/client/v3/rooms/!room2:example.com/send/m.room.message/" + m.ID,
+	}, paths)
+	require.Equal(t, "PUT", requests[0].Method)
+	require.Equal(t, "Bearer syt_secret", requests[0].Header.Get("Authorization"))
+	require.Equal(t, "@ntfy:example.com", requests[0].URL.Query().Get("user_id"))
+	require.Equal(t, "m.text", events[0].MsgType)
+	require.Equal(t, "Backup failed\nDisk <full>\non backup01\nOpen: https://example.com/backups", events[0].Body)
+	require.Equal(t, "org.matrix.custom.html", events[0].Format)
+	require.Equal(t, `<strong>Backup failed</strong><br>Disk &lt;full&gt;<br>on backup01<br><a href="https://example.com/backups">Open</a>`, events[0].FormattedBody)
+}
+
+func TestServer_MatrixBridge_RetryAfterRateLimit(t *testing.T) {
+	var count atomic.Int32
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":50}`))
+			return
+		}
+		w.Write([]byte(`{"event_id":"$abc"}`))
+	}))
+	defer homeserver.Close()
+
+	c := newTestConfig(t)
+	c.MatrixBridgeHomeserver = homeserver.URL
+	c.MatrixBridgeToken = "syt_secret"
+	c.MatrixBridgeRooms = map[string][]string{"alerts": {"!room1:example.com"}}
+	b := newMatrixBridge(c)
+	require.Nil(t, b.Send("!room1:example.com", newDefaultMessage("alerts", "hi")))
+	require.Equal(t, int32(2), count.Load())
+}
+
+func TestServer_MatrixBridge_PermanentError(t *testing.T) {
+	var count atomic.Int32
+	homeserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errcode":"M_FORBIDDEN","error":"User not in room"}`))
+	}))
+	defer homeserver.Close()
+
+	c := newTestConfig(t)
+	c.MatrixBridgeHomeserver = homeserver.URL
+	c.MatrixBridgeToken = "syt_secret"
+	b := newMatrixBridge(c)
+	b.retryDelay = time.Millisecond
+	err := b.Send("!room1:example.com", newDefaultMessage("alerts", "hi"))
+	require.EqualError(t, err, "unexpected HTTP status 403 Forbidden: M_FORBIDDEN User not in room")
+	require.Equal(t, int32(1), count.Load())
+}
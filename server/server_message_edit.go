@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// handleMessageUpdate changes a published message (PUT/POST /<topic>/<message-id>), and publishes a
+// "message_updated" event with the new content to the topic. A non-empty body replaces the message text,
+// and the usual publish headers (title, priority, tags, click, icon, actions, markdown) replace the respective
+// fields. Fields that are not passed remain unchanged.
+func (s *Server) handleMessageUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, m, err := s.editableMessageFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	body, err := util.Peek(r.Body, s.config.Load().MessageSizeLimit)
+	if err != nil {
+		return err
+	} else if body.LimitReached {
+		return errHTTPEntityTooLargeMessage.With(m)
+	} else if !utf8.Valid(body.PeekedBytes) {
+		return errHTTPBadRequestMessageNotUTF8.With(m)
+	}
+	if len(body.PeekedBytes) > 0 {
+		m.Message = strings.TrimSpace(string(body.PeekedBytes))
+		m.Encoding = ""
+	} else if messageStr := readParam(r, "x-message", "message", "m"); messageStr != "" {
+		m.Message = strings.ReplaceAll(messageStr, "\\n", "\n")
+		m.Encoding = ""
+	}
+	if e := s.parseMessageUpdateParams(r, m); e != nil {
+		return e.With(m)
+	}
+	m.Signature = readParam(r, "x-signature", "signature") // The old signature does not match the new content
+	if m.Signature != "" && !signatureRegex.MatchString(m.Signature) {
+		return errHTTPBadRequestSignatureInvalid.With(m)
+	} else if err := s.verifyTopicSignature(m); err != nil {
+		return err
+	}
+	if err := s.messageCache.UpdateMessage(m); err != nil {
+		return err
+	}
+	logvrm(v, r, m).Tag(tagPublish).Debug("Message updated")
+	ev := newMessageUpdatedMessage(m)
+	if err := t.Publish(v, ev); err != nil {
+		return err
+	}
+	s.cluster.Publish(ev)
+	return s.writeJSON(w, ev)
+}
+
+// handleMessageDelete deletes a published message and its attachment (DELETE /<topic>/<message-id>), and
+// publishes a "message_deleted" event to the topic
+func (s *Server) handleMessageDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, m, err := s.editableMessageFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	if err := s.messageCache.DeleteMessages(m.ID); err != nil {
+		return err
+	}
+	if s.fileCache != nil && m.Attachment != nil {
+		if err := s.fileCache.Remove(m.ID); err != nil {
+			logvrm(v, r, m).Tag(tagPublish).Err(err).Warn("Error deleting attachment of deleted message")
+		}
+	}
+	logvrm(v, r, m).Tag(tagPublish).Debug("Message deleted")
+	ev := newMessageDeletedMessage(t.ID, m.ID)
+	if err := t.Publish(v, ev); err != nil {
+		return err
+	}
+	s.cluster.Publish(ev)
+	return s.writeJSON(w, ev)
+}
+
+// parseMessageUpdateParams reads the publish headers (or query parameters) that can be changed after a
+// message was published, and replaces the respective fields of the message if they are set
+func (s *Server) parseMessageUpdateParams(r *http.Request, m *message) *errHTTP {
+	if title := readParam(r, "x-title", "title", "t"); title != "" {
+		m.Title = title
+	}
+	if click := readParam(r, "x-click", "click"); click != "" {
+		m.Click = click
+	}
+	if icon := readParam(r, "x-icon", "icon"); icon != "" {
+		if !urlRegex.MatchString(icon) {
+			return errHTTPBadRequestIconURLInvalid
+		}
+		m.Icon = icon
+	}
+	if priorityStr := readParam(r, "x-priority", "priority", "prio", "p"); priorityStr != "" {
+		priority, err := util.ParsePriority(priorityStr)
+		if err != nil {
+			return errHTTPBadRequestPriorityInvalid
+		}
+		m.Priority = priority
+	}
+	if tags := readCommaSeparatedParam(r, "x-tags", "tags", "tag", "ta"); len(tags) > 0 {
+		m.Tags = tags
+	}
+	if actionsStr := readParam(r, "x-actions", "actions", "action"); actionsStr != "" {
+		actions, err := parseActions(actionsStr)
+		if err != nil {
+			return errHTTPBadRequestActionsInvalid.Wrap("%s", err.Error())
+		}
+		m.Actions = actions
+	}
+	contentType, markdown := strings.ToLower(readParam(r, "content-type", "content_type")), readParam(r, "x-markdown", "markdown", "md")
+	if (markdown != "" && toBool(strings.ToLower(markdown))) || contentType == contentTypeTextMarkdown {
+		m.ContentType = contentTypeTextMarkdown
+	} else if (markdown != "" && !toBool(strings.ToLower(markdown))) || strings.HasPrefix(contentType, contentTypeTextPlain) {
+		m.ContentType = ""
+	}
+	return nil
+}
+
+// editableMessageFromPath returns the topic and the published message referenced in the request path, if the
+// visitor is allowed to change it. Messages can only be changed by the user who published them, by the owner
+// of the topic (see topic reservations), or by an admin, and only if the user (still) has write access to the topic.
+func (s *Server) editableMessageFromPath(r *http.Request, v *visitor) (*topic, *message, error) {
+	matches := messagePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return nil, nil, errHTTPInternalErrorInvalidPath
+	}
+	t, err := s.topicFromID(matches[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := s.messageCache.Message(matches[2])
+	if errors.Is(err, errMessageNotFound) || (err == nil && (m.Topic != t.ID || m.Event != messageEvent || m.Time > time.Now().Unix())) {
+		return nil, nil, errHTTPNotFound.With(t).Fields(log.Context{
+			"message_id":    matches[2],
+			"error_context": "message_cache",
+		})
+	} else if err != nil {
+		return nil, nil, err
+	}
+	u := v.User()
+	if s.userManager == nil || u == nil {
+		return nil, nil, errHTTPForbidden.With(m)
+	} else if u.TokenScopes.Restricted() && !u.TokenScopes.AllowTopic(t.ID, user.PermissionWrite) {
+		return nil, nil, errHTTPForbiddenTokenScope.With(m)
+	} else if err := s.userManager.Authorize(u, t.ID, user.PermissionWrite); err != nil {
+		return nil, nil, errHTTPForbidden.With(m) // Write access may have been revoked since the message was published
+	} else if u.IsAdmin() || (m.User != "" && m.User == u.ID) {
+		return t, m, nil
+	}
+	owner, err := s.userManager.HasReservation(u.Name, t.ID)
+	if err != nil {
+		return nil, nil, err
+	} else if !owner {
+		return nil, nil, errHTTPForbidden.With(m)
+	}
+	return t, m, nil
+}
+
+// receiveClusterMessageChange applies a message update or deletion that was replicated by a cluster peer to
+// the local cache, and publishes the event to the local subscribers of the topic
+func (s *Server) receiveClusterMessageChange(v *visitor, ev *message) error {
+	logvm(v, ev).Tag(tagCluster).Debug("Received replicated %s event", ev.Event)
+	if ev.Event == messageUpdatedEvent {
+		m := *ev
+		m.Event = messageEvent
+		if err := s.messageCache.UpdateMessage(&m); err != nil {
+			return err
+		}
+	} else if err := s.messageCache.DeleteMessages(ev.ID); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	t, ok := s.topics[ev.Topic]
+	s.mu.RUnlock()
+	if ok {
+		if err := t.Publish(v, ev); err != nil {
+			logvm(v, ev).Tag(tagCluster).Err(err).Warn("Unable to publish replicated %s event", ev.Event)
+		}
+	}
+	return nil
+}
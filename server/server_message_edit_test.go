@@ -0,0 +1,227 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_MessageUpdateAndDelete(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	auth := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	subscribeRR := newSyncResponseRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+
+	response := request(t, s, "PUT", "/mytopic", "backup running", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Title":         "Backup",
+		"Tags":          "hourglass",
+		"X-Signature":   "t=1760572800,s=" + strings.Repeat("a", 85) + "Q",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Body replaces the message, headers replace the other fields, everything else is kept
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, "backup done", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Tags":          "white_check_mark",
+		"Priority":      "high",
+	})
+	require.Equal(t, 200, response.Code)
+	ev := toMessage(t, response.Body.String())
+	require.Equal(t, messageUpdatedEvent, ev.Event)
+	require.Equal(t, m.ID, ev.ID)
+	require.Equal(t, m.Time, ev.Time)
+	require.Equal(t, "backup done", ev.Message)
+	require.Equal(t, "Backup", ev.Title)
+	require.Equal(t, []string{"white_check_mark"}, ev.Tags)
+	require.Equal(t, 4, ev.Priority)
+	require.Equal(t, "", ev.Signature) // The signature does not match the new content
+
+	// Cache was updated
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, messageEvent, messages[0].Event)
+	require.Equal(t, "backup done", messages[0].Message)
+	require.Equal(t, 4, messages[0].Priority)
+
+	response = request(t, s, "DELETE", "/mytopic/"+m.ID, "", auth)
+	require.Equal(t, 200, response.Code)
+	ev = toMessage(t, response.Body.String())
+	require.Equal(t, messageDeletedEvent, ev.Event)
+	require.Equal(t, m.ID, ev.ID)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Empty(t, toMessages(t, response.Body.String()))
+	response = request(t, s, "DELETE", "/mytopic/"+m.ID, "", auth)
+	require.Equal(t, 404, response.Code)
+
+	waitFor(t, func() bool {
+		return len(toMessages(t, subscribeRR.Body())) == 4
+	})
+	subscribeCancel()
+	messages = toMessages(t, subscribeRR.Body()) // Events are delivered asynchronously, order may vary
+	idx := slices.IndexFunc(messages, func(m *message) bool { return m.Event == messageUpdatedEvent })
+	require.NotEqual(t, -1, idx)
+	require.Equal(t, m.ID, messages[idx].ID)
+	require.Equal(t, "backup done", messages[idx].Message)
+	idx = slices.IndexFunc(messages, func(m *message) bool { return m.Event == messageDeletedEvent })
+	require.NotEqual(t, -1, idx)
+	require.Equal(t, m.ID, messages[idx].ID)
+}
+
+func TestServer_MessageUpdate_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("owner", "owner", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("owner", "mytopic", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionWrite))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	response := request(t, s, "PUT", "/mytopic", "original", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Anonymous users and other users with write access cannot change the message
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, "changed", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, "changed", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "DELETE", "/mytopic/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+
+	// Publisher and topic owner can
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, "changed by publisher", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, "changed by owner", map[string]string{
+		"Authorization": util.BasicAuth("owner", "owner"),
+		"Markdown":      "yes",
+	})
+	require.Equal(t, 200, response.Code)
+	ev := toMessage(t, response.Body.String())
+	require.Equal(t, "changed by owner", ev.Message)
+	require.Equal(t, "text/markdown", ev.ContentType)
+
+	// Message must belong to the topic
+	response = request(t, s, "DELETE", "/othertopic/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("owner", "owner"),
+	})
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "DELETE", "/mytopic/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("owner", "owner"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_MessageUpdate_WriteAccessRevoked(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	auth := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	response := request(t, s, "PUT", "/mytopic", "original", auth)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Publishers cannot change or delete their messages once their write access is revoked
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionRead))
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, "changed", auth)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "DELETE", "/mytopic/"+m.ID, "", auth)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", auth)
+	require.Equal(t, "original", toMessage(t, response.Body.String()).Message)
+}
+
+func TestServer_MessageUpdate_Invalid(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.MessageSizeLimit = 100
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	auth := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	response := request(t, s, "PUT", "/mytopic", "original", auth)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Priority":      "super-high",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40007, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic/"+m.ID, string(make([]byte, 101)), auth)
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41305, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic/abcdefghijkl", "changed", auth)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_MessageDelete_PollDeleted(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	auth := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+
+	response := request(t, s, "PUT", "/mytopic", "first", auth)
+	require.Equal(t, 200, response.Code)
+	m1 := toMessage(t, response.Body.String())
+	response = request(t, s, "PUT", "/mytopic", "second", auth)
+	require.Equal(t, 200, response.Code)
+	m2 := toMessage(t, response.Body.String())
+	response = request(t, s, "DELETE", "/mytopic/"+m1.ID, "", auth)
+	require.Equal(t, 200, response.Code)
+
+	// Without deleted=1, the deleted message is simply gone
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m2.ID, messages[0].ID)
+
+	// With deleted=1, clients are told to remove it
+	response = request(t, s, "GET", "/mytopic/json?poll=1&deleted=1", "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	idx := slices.IndexFunc(messages, func(m *message) bool { return m.Event == messageDeletedEvent })
+	require.NotEqual(t, -1, idx)
+	require.Equal(t, m1.ID, messages[idx].ID)
+
+	// Clients that have already seen the latest message still learn about the deletion
+	response = request(t, s, "GET", "/mytopic/json?poll=1&deleted=1&since="+m2.ID, "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, messageDeletedEvent, messages[0].Event)
+	require.Equal(t, m1.ID, messages[0].ID)
+
+	// No tombstones are kept if disabled
+	s.messageCache.tombstoneDuration = 0
+	response = request(t, s, "DELETE", "/mytopic/"+m2.ID, "", auth)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1&deleted=1", "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m1.ID, messages[0].ID)
+}
@@ -1,16 +1,32 @@
 package server
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// metricsLabelOther is the label value used for topics and users beyond the cardinality limit
+	metricsLabelOther = "_other"
+
+	// Label values of the push device metrics, see msetPushDevices and maddPushDevicesRemoved
+	pushDeviceTypeAPNS      = "apns"
+	pushDeviceTypeWebPush   = "webpush"
+	pushDeviceReasonExpired = "expired" // Not seen for the configured expiry duration
+	pushDeviceReasonInvalid = "invalid" // Rejected by the push service, e.g. because the app was uninstalled
+)
+
 var (
 	metricMessagesPublishedSuccess     prometheus.Counter
 	metricMessagesPublishedFailure     prometheus.Counter
+	metricMessagesDeduplicated         prometheus.Counter
 	metricMessagesCached               prometheus.Gauge
 	metricMessagePublishDurationMillis prometheus.Gauge
 	metricFirebasePublishedSuccess     prometheus.Counter
 	metricFirebasePublishedFailure     prometheus.Counter
+	metricAPNSPublishedSuccess         prometheus.Counter
+	metricAPNSPublishedFailure         prometheus.Counter
 	metricEmailsPublishedSuccess       prometheus.Counter
 	metricEmailsPublishedFailure       prometheus.Counter
 	metricEmailsReceivedSuccess        prometheus.Counter
@@ -20,21 +36,42 @@ var (
 	metricUnifiedPushPublishedSuccess  prometheus.Counter
 	metricMatrixPublishedSuccess       prometheus.Counter
 	metricMatrixPublishedFailure       prometheus.Counter
+	metricClusterForwardedSuccess      prometheus.Counter
+	metricClusterForwardedFailure      prometheus.Counter
+	metricClusterReceived              prometheus.Counter
 	metricAttachmentsTotalSize         prometheus.Gauge
+	metricAttachmentsRejected          prometheus.Counter
 	metricVisitors                     prometheus.Gauge
 	metricSubscribers                  prometheus.Gauge
 	metricTopics                       prometheus.Gauge
 	metricUsers                        prometheus.Gauge
 	metricHTTPRequests                 *prometheus.CounterVec
+	metricExperimentExposures          *prometheus.CounterVec
+	metricPushDevices                  *prometheus.GaugeVec
+	metricPushDevicesRemoved           *prometheus.CounterVec
+	metricGeoIPRequestsBlocked         *prometheus.CounterVec
+
+	// Per-topic and per-user metrics, only set if metrics-cardinality-limit is set
+	metricTopicMessagesPublished     *prometheus.CounterVec
+	metricTopicSubscribers           *prometheus.GaugeVec
+	metricTopicAttachmentsSize       *prometheus.CounterVec
+	metricTopicAttachmentsDownloaded *prometheus.CounterVec
+	metricTopicRateLimited           *prometheus.CounterVec
+	metricUserMessagesPublished      *prometheus.CounterVec
+	metricTopicLabels                *metricsLabelLimiter
+	metricUserLabels                 *metricsLabelLimiter
 )
 
-func initMetrics() {
+func initMetrics(cardinalityLimit int) {
 	metricMessagesPublishedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_messages_published_success",
 	})
 	metricMessagesPublishedFailure = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_messages_published_failure",
 	})
+	metricMessagesDeduplicated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_messages_deduplicated",
+	})
 	metricMessagesCached = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_messages_cached_total",
 	})
@@ -47,6 +84,12 @@ func initMetrics() {
 	metricFirebasePublishedFailure = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_firebase_published_failure",
 	})
+	metricAPNSPublishedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_apns_published_success",
+	})
+	metricAPNSPublishedFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_apns_published_failure",
+	})
 	metricEmailsPublishedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_emails_sent_success",
 	})
@@ -74,9 +117,21 @@ func initMetrics() {
 	metricMatrixPublishedFailure = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_matrix_published_failure",
 	})
+	metricClusterForwardedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_cluster_forwarded_success",
+	})
+	metricClusterForwardedFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_cluster_forwarded_failure",
+	})
+	metricClusterReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_cluster_received_total",
+	})
 	metricAttachmentsTotalSize = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_attachments_total_size",
 	})
+	metricAttachmentsRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_attachments_rejected_total",
+	})
 	metricVisitors = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_visitors_total",
 	})
@@ -92,13 +147,28 @@ func initMetrics() {
 	metricHTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "ntfy_http_requests_total",
 	}, []string{"http_code", "ntfy_code", "http_method"})
+	metricExperimentExposures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_experiment_exposures_total",
+	}, []string{"experiment", "variant"})
+	metricPushDevices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ntfy_push_devices_total",
+	}, []string{"type"})
+	metricPushDevicesRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_push_devices_removed_total",
+	}, []string{"type", "reason"})
+	metricGeoIPRequestsBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_geoip_requests_blocked_total",
+	}, []string{"country"})
 	prometheus.MustRegister(
 		metricMessagesPublishedSuccess,
 		metricMessagesPublishedFailure,
+		metricMessagesDeduplicated,
 		metricMessagesCached,
 		metricMessagePublishDurationMillis,
 		metricFirebasePublishedSuccess,
 		metricFirebasePublishedFailure,
+		metricAPNSPublishedSuccess,
+		metricAPNSPublishedFailure,
 		metricEmailsPublishedSuccess,
 		metricEmailsPublishedFailure,
 		metricEmailsReceivedSuccess,
@@ -108,13 +178,57 @@ func initMetrics() {
 		metricUnifiedPushPublishedSuccess,
 		metricMatrixPublishedSuccess,
 		metricMatrixPublishedFailure,
+		metricClusterForwardedSuccess,
+		metricClusterForwardedFailure,
+		metricClusterReceived,
 		metricAttachmentsTotalSize,
+		metricAttachmentsRejected,
 		metricVisitors,
 		metricUsers,
 		metricSubscribers,
 		metricTopics,
 		metricHTTPRequests,
+		metricExperimentExposures,
+		metricPushDevices,
+		metricPushDevicesRemoved,
+		metricGeoIPRequestsBlocked,
 	)
+	if cardinalityLimit > 0 {
+		initTopicAndUserMetrics(cardinalityLimit)
+		prometheus.MustRegister(
+			metricTopicMessagesPublished,
+			metricTopicSubscribers,
+			metricTopicAttachmentsSize,
+			metricTopicAttachmentsDownloaded,
+			metricTopicRateLimited,
+			metricUserMessagesPublished,
+		)
+	}
+}
+
+// initTopicAndUserMetrics creates the per-topic and per-user metrics. To keep the number of time series bounded,
+// only the first cardinalityLimit topics and users get their own label value; all others are counted as "_other".
+func initTopicAndUserMetrics(cardinalityLimit int) {
+	metricTopicMessagesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_topic_messages_published_total",
+	}, []string{"topic"})
+	metricTopicSubscribers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ntfy_topic_subscribers_total",
+	}, []string{"topic"})
+	metricTopicAttachmentsSize = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_topic_attachments_total_size",
+	}, []string{"topic"})
+	metricTopicAttachmentsDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_topic_attachments_downloaded_size",
+	}, []string{"topic"})
+	metricTopicRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_topic_rate_limited_total",
+	}, []string{"topic"})
+	metricUserMessagesPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ntfy_user_messages_published_total",
+	}, []string{"user"})
+	metricTopicLabels = newMetricsLabelLimiter(cardinalityLimit)
+	metricUserLabels = newMetricsLabelLimiter(cardinalityLimit)
 }
 
 // minc increments a prometheus.Counter if it is non-nil
@@ -130,3 +244,86 @@ func mset[T int | int64 | float64](gauge prometheus.Gauge, value T) {
 		gauge.Set(float64(value))
 	}
 }
+
+// mincExperiment increments the exposure counter of an experiment variant if it is non-nil
+func mincExperiment(counter *prometheus.CounterVec, experiment, variant string) {
+	if counter != nil {
+		counter.WithLabelValues(experiment, variant).Inc()
+	}
+}
+
+// msetPushDevices sets the number of registered push devices (APNs devices or Web Push subscriptions) if the
+// metric is non-nil
+func msetPushDevices(deviceType string, value int64) {
+	if metricPushDevices != nil {
+		metricPushDevices.WithLabelValues(deviceType).Set(float64(value))
+	}
+}
+
+// maddPushDevicesRemoved counts removed push devices (APNs devices or Web Push subscriptions) if the metric is non-nil
+func maddPushDevicesRemoved(deviceType, reason string, value int64) {
+	if metricPushDevicesRemoved != nil {
+		metricPushDevicesRemoved.WithLabelValues(deviceType, reason).Add(float64(value))
+	}
+}
+
+// maddTopic adds the value to a per-topic prometheus.CounterVec if it is non-nil
+func maddTopic[T int | int64 | float64](counter *prometheus.CounterVec, topic string, value T) {
+	if counter != nil {
+		counter.WithLabelValues(metricTopicLabels.Label(topic)).Add(float64(value))
+	}
+}
+
+// mincUser increments a per-user prometheus.CounterVec if it is non-nil
+func mincUser(counter *prometheus.CounterVec, username string) {
+	if counter != nil {
+		counter.WithLabelValues(metricUserLabels.Label(username)).Inc()
+	}
+}
+
+// msetTopics sets the values of a per-topic prometheus.GaugeVec if it is non-nil. Topics that are not
+// in the map are removed, and topics beyond the cardinality limit are summed up.
+func msetTopics(gauge *prometheus.GaugeVec, values map[string]int) {
+	if gauge == nil {
+		return
+	}
+	gauge.Reset()
+	for topic, value := range values {
+		gauge.WithLabelValues(metricTopicLabels.Label(topic)).Add(float64(value))
+	}
+}
+
+// metricsLabelLimiter limits the number of distinct label values of a metric. The first limit values
+// are passed through as is; all other values are replaced with metricsLabelOther.
+type metricsLabelLimiter struct {
+	limit  int
+	values map[string]struct{}
+	mu     sync.Mutex
+}
+
+func newMetricsLabelLimiter(limit int) *metricsLabelLimiter {
+	return &metricsLabelLimiter{
+		limit:  limit,
+		values: make(map[string]struct{}),
+	}
+}
+
+// Label returns the label value to use for the given value
+func (l *metricsLabelLimiter) Label(value string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.values[value]; ok {
+		return value
+	} else if len(l.values) >= l.limit {
+		return metricsLabelOther
+	}
+	l.values[value] = struct{}{}
+	return value
+}
+
+// mincGeoIPRequestsBlocked counts a request that was rejected because its country is blocked, if the metric is non-nil
+func mincGeoIPRequestsBlocked(country string) {
+	if metricGeoIPRequestsBlocked != nil {
+		metricGeoIPRequestsBlocked.WithLabelValues(country).Inc()
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestMetricsLabelLimiter(t *testing.T) {
+	l := newMetricsLabelLimiter(2)
+	require.Equal(t, "mytopic1", l.Label("mytopic1"))
+	require.Equal(t, "mytopic2", l.Label("mytopic2"))
+	require.Equal(t, "_other", l.Label("mytopic3"))
+	require.Equal(t, "mytopic1", l.Label("mytopic1"))
+	require.Equal(t, "_other", l.Label("mytopic4"))
+}
+
+func TestServer_Metrics_TopicAndUser(t *testing.T) {
+	initTopicAndUserMetrics(2)
+	t.Cleanup(func() {
+		metricTopicMessagesPublished = nil
+		metricTopicSubscribers = nil
+		metricTopicAttachmentsSize = nil
+		metricTopicRateLimited = nil
+		metricUserMessagesPublished = nil
+	})
+
+	c := newTestConfigWithAuthFile(t)
+	c.VisitorMessageDailyLimit = 8 // Shared between user and anonymous visitor
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", "user", false))
+
+	var response *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		response = request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	response = request(t, s, "PUT", "/mytopic", "some attachment", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Filename":      "file.txt",
+	})
+	require.Equal(t, 200, response.Code)
+	for _, topic := range []string{"othertopic0", "othertopic1", "othertopic2", "othertopic0"} {
+		require.Equal(t, 200, request(t, s, "PUT", "/"+topic, "hi", nil).Code)
+	}
+	require.Equal(t, 429, request(t, s, "PUT", "/othertopic0", "hi", nil).Code)
+
+	require.Equal(t, float64(4), testutil.ToFloat64(metricTopicMessagesPublished.WithLabelValues("mytopic")))
+	require.Equal(t, float64(2), testutil.ToFloat64(metricTopicMessagesPublished.WithLabelValues("othertopic0")))
+	require.Equal(t, float64(2), testutil.ToFloat64(metricTopicMessagesPublished.WithLabelValues("_other")))
+	require.Equal(t, float64(4), testutil.ToFloat64(metricUserMessagesPublished.WithLabelValues("phil")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metricTopicRateLimited.WithLabelValues("othertopic0")))
+	require.Equal(t, float64(15), testutil.ToFloat64(metricTopicAttachmentsSize.WithLabelValues("mytopic")))
+
+	s.execManager()
+	require.Equal(t, 3, testutil.CollectAndCount(metricTopicSubscribers)) // mytopic, othertopic0, _other
+	require.Equal(t, float64(0), testutil.ToFloat64(metricTopicSubscribers.WithLabelValues("mytopic")))
+}
@@ -12,11 +12,12 @@ const (
 	contextRateVisitor contextKey = iota + 2586
 	contextTopic
 	contextMatrixPushKey
+	contextPublishPreview
 )
 
 func (s *Server) limitRequests(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if util.ContainsIP(s.config.VisitorRequestExemptPrefixes, v.ip) {
+		if util.ContainsIP(s.config.Load().VisitorRequestExemptPrefixes, v.ip) {
 			return next(w, r, v)
 		} else if !v.RequestAllowed() {
 			return errHTTPTooManyRequestsLimitRequests
@@ -40,7 +41,12 @@ func (s *Server) limitRequestsWithTopic(next handleFunc) handleFunc {
 			contextRateVisitor: vrate,
 			contextTopic:       t,
 		})
-		if util.ContainsIP(s.config.VisitorRequestExemptPrefixes, v.ip) {
+		if util.ContainsIP(s.config.Load().VisitorRequestExemptPrefixes, v.ip) {
+			return next(w, r, v)
+		} else if s.appBudgetEnabled(t) {
+			if !s.appRequestAllowed(t) {
+				return errHTTPTooManyRequestsLimitRequests
+			}
 			return next(w, r, v)
 		} else if !vrate.RequestAllowed() {
 			return errHTTPTooManyRequestsLimitRequests
@@ -51,7 +57,7 @@ func (s *Server) limitRequestsWithTopic(next handleFunc) handleFunc {
 
 func (s *Server) ensureWebEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if s.config.WebRoot == "" {
+		if s.config.Load().WebRoot == "" {
 			return errHTTPNotFound
 		}
 		return next(w, r, v)
@@ -60,7 +66,16 @@ func (s *Server) ensureWebEnabled(next handleFunc) handleFunc {
 
 func (s *Server) ensureWebPushEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if s.config.WebRoot == "" || s.config.WebPushPublicKey == "" {
+		if s.config.Load().WebRoot == "" || s.config.Load().WebPushPublicKey == "" {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureAPNSEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.apns == nil {
 			return errHTTPNotFound
 		}
 		return next(w, r, v)
@@ -76,10 +91,14 @@ func (s *Server) ensureUserManager(next handleFunc) handleFunc {
 	}
 }
 
+// ensureUser allows all users, except for those authenticated with a scoped access token. Scoped tokens
+// are meant for publishing and subscribing (and the admin API), not for managing the account.
 func (s *Server) ensureUser(next handleFunc) handleFunc {
 	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if v.User() == nil {
 			return errHTTPUnauthorized
+		} else if v.User().TokenScopes.Restricted() {
+			return errHTTPForbiddenTokenScope
 		}
 		return next(w, r, v)
 	})
@@ -89,6 +108,21 @@ func (s *Server) ensureAdmin(next handleFunc) handleFunc {
 	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if !v.User().IsAdmin() {
 			return errHTTPUnauthorized
+		} else if !v.User().TokenScopes.AllowAdmin() {
+			return errHTTPForbiddenTokenScope
+		}
+		return next(w, r, v)
+	})
+}
+
+// ensureAdminOrSupport allows admins and support users. Handlers are responsible for restricting what support
+// users can do, e.g. only changing regular users.
+func (s *Server) ensureAdminOrSupport(next handleFunc) handleFunc {
+	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if !v.User().IsAdmin() && !v.User().IsSupport() {
+			return errHTTPUnauthorized
+		} else if !v.User().TokenScopes.AllowAdmin() {
+			return errHTTPForbiddenTokenScope
 		}
 		return next(w, r, v)
 	})
@@ -96,7 +130,43 @@ func (s *Server) ensureAdmin(next handleFunc) handleFunc {
 
 func (s *Server) ensureCallsEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if s.config.TwilioAccount == "" || s.userManager == nil {
+		if s.config.Load().TwilioAccount == "" || s.userManager == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureEmailsEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.emailSender() == nil || s.userManager == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureWebhooksEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.webhookSender == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureTelegramRelaysEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if !s.config.Load().EnableTelegramRelays || s.userManager == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureAdminWebAuthnEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if !s.config.Load().RequireAdminWebAuthn || s.userManager == nil {
 			return errHTTPNotFound
 		}
 		return next(w, r, v)
@@ -105,7 +175,7 @@ func (s *Server) ensureCallsEnabled(next handleFunc) handleFunc {
 
 func (s *Server) ensurePaymentsEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
-		if s.config.StripeSecretKey == "" || s.stripe == nil {
+		if s.config.Load().StripeSecretKey == "" || s.stripe == nil {
 			return errHTTPNotFound
 		}
 		return next(w, r, v)
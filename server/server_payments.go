@@ -62,7 +62,7 @@ func (s *Server) handleBillingTiersGet(w http.ResponseWriter, _ *http.Request, _
 	if err != nil {
 		return err
 	}
-	freeTier := configBasedVisitorLimits(s.config)
+	freeTier := configBasedVisitorLimits(s.config.Load())
 	response := []*apiAccountBillingTier{
 		{
 			// This is a bit of a hack: This is the "Free" tier. It has no tier code, name or price.
@@ -152,7 +152,7 @@ func (s *Server) handleAccountBillingSubscriptionCreate(w http.ResponseWriter, r
 			return errMultipleBillingSubscriptions
 		}
 	}
-	successURL := s.config.BaseURL + apiAccountBillingSubscriptionCheckoutSuccessTemplate
+	successURL := s.config.Load().BaseURL + apiAccountBillingSubscriptionCheckoutSuccessTemplate
 	params := &stripe.CheckoutSessionParams{
 		Customer:            stripeCustomerID, // A user may have previously deleted their subscription
 		ClientReferenceID:   &u.ID,
@@ -166,8 +166,32 @@ func (s *Server) handleAccountBillingSubscriptionCreate(w http.ResponseWriter, r
 			},
 		},
 		AutomaticTax: &stripe.CheckoutSessionAutomaticTaxParams{
-			Enabled: stripe.Bool(true),
+			Enabled: stripe.Bool(s.config.Load().StripeAutomaticTax),
 		},
+		BillingAddressCollection: stripe.String(s.config.Load().StripeBillingAddressCollection),
+	}
+	if stripeCustomerID != nil {
+		// Existing customers may not have an address yet, which is required for automatic tax. Let Checkout
+		// save the collected address to the customer, and make sure the invoice settings are up-to-date.
+		if s.config.Load().StripeAutomaticTax {
+			params.CustomerUpdate = &stripe.CheckoutSessionCustomerUpdateParams{
+				Address: stripe.String("auto"),
+			}
+		}
+		if invoiceSettings := s.stripeInvoiceSettings(); invoiceSettings != nil {
+			if _, err := s.stripe.UpdateCustomer(*stripeCustomerID, &stripe.CustomerParams{InvoiceSettings: invoiceSettings}); err != nil {
+				return err
+			}
+		}
+	} else {
+		// Pre-fill the verified email address for new customers; existing customers are kept in sync
+		// when the email address is changed, see handleAccountEmailChange
+		email, err := s.userManager.Email(u.ID)
+		if err != nil && !errors.Is(err, user.ErrEmailNotFound) {
+			return err
+		} else if email != nil {
+			params.CustomerEmail = &email.Address
+		}
 	}
 	sess, err := s.stripe.NewCheckoutSession(params)
 	if err != nil {
@@ -230,6 +254,7 @@ func (s *Server) handleAccountBillingSubscriptionCreateSuccess(w http.ResponseWr
 				"user_name": u.Name,
 			},
 		},
+		InvoiceSettings: s.stripeInvoiceSettings(),
 	}
 	if _, err := s.stripe.UpdateCustomer(sess.Customer.ID, customerParams); err != nil {
 		return err
@@ -237,10 +262,47 @@ func (s *Server) handleAccountBillingSubscriptionCreateSuccess(w http.ResponseWr
 	if err := s.updateSubscriptionAndTier(r, v, u, tier, sess.Customer.ID, sub.ID, string(sub.Status), string(interval), sub.CurrentPeriodEnd, sub.CancelAt); err != nil {
 		return err
 	}
-	http.Redirect(w, r, s.config.BaseURL+accountPath, http.StatusSeeOther)
+	http.Redirect(w, r, s.config.Load().BaseURL+accountPath, http.StatusSeeOther)
 	return nil
 }
 
+// stripeInvoiceSettings returns the invoice footer and custom fields to be set on Stripe customers, or nil if
+// neither is configured. In subscription mode, Checkout does not allow setting invoice details on the session
+// itself, so they are stored on the customer and apply to all of their invoices.
+func (s *Server) stripeInvoiceSettings() *stripe.CustomerInvoiceSettingsParams {
+	if s.config.Load().StripeInvoiceFooter == "" && len(s.config.Load().StripeInvoiceCustomFields) == 0 {
+		return nil
+	}
+	settings := &stripe.CustomerInvoiceSettingsParams{}
+	if s.config.Load().StripeInvoiceFooter != "" {
+		settings.Footer = stripe.String(s.config.Load().StripeInvoiceFooter)
+	}
+	for _, field := range s.config.Load().StripeInvoiceCustomFields {
+		settings.CustomFields = append(settings.CustomFields, &stripe.CustomerInvoiceSettingsCustomFieldParams{
+			Name:  stripe.String(field.Name),
+			Value: stripe.String(field.Value),
+		})
+	}
+	return settings
+}
+
+// updateStripeCustomerEmail updates the email address of the Stripe customer, if the user is a Stripe customer.
+// This is called when the user changes their email address, see handleAccountEmailChange.
+func (s *Server) updateStripeCustomerEmail(r *http.Request, v *visitor, u *user.User, email string) error {
+	if u.Billing.StripeCustomerID == "" {
+		return nil
+	}
+	logvr(v, r).
+		Tag(tagStripe).
+		Fields(log.Context{
+			"stripe_customer_id": u.Billing.StripeCustomerID,
+			"email":              email,
+		}).
+		Info("Updating email address of Stripe customer")
+	_, err := s.stripe.UpdateCustomer(u.Billing.StripeCustomerID, &stripe.CustomerParams{Email: stripe.String(email)})
+	return err
+}
+
 // handleAccountBillingSubscriptionUpdate updates an existing Stripe subscription to a new price, and updates
 // a user's tier accordingly. This endpoint only works if there is an existing subscription.
 func (s *Server) handleAccountBillingSubscriptionUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -325,7 +387,7 @@ func (s *Server) handleAccountBillingPortalSessionCreate(w http.ResponseWriter,
 	}
 	params := &stripe.BillingPortalSessionParams{
 		Customer:  stripe.String(u.Billing.StripeCustomerID),
-		ReturnURL: stripe.String(s.config.BaseURL),
+		ReturnURL: stripe.String(s.config.Load().BaseURL),
 	}
 	ps, err := s.stripe.NewPortalSession(params)
 	if err != nil {
@@ -351,7 +413,7 @@ func (s *Server) handleAccountBillingWebhook(_ http.ResponseWriter, r *http.Requ
 	} else if body.LimitReached {
 		return errHTTPEntityTooLargeJSONBody
 	}
-	event, err := s.stripe.ConstructWebhookEvent(body.PeekedBytes, stripeSignature, s.config.StripeWebhookKey)
+	event, err := s.stripe.ConstructWebhookEvent(body.PeekedBytes, stripeSignature, s.config.Load().StripeWebhookKey)
 	if err != nil {
 		return err
 	} else if event.Data == nil || event.Data.Raw == nil {
@@ -362,6 +424,10 @@ func (s *Server) handleAccountBillingWebhook(_ http.ResponseWriter, r *http.Requ
 		return s.handleAccountBillingWebhookSubscriptionUpdated(r, v, event)
 	case "customer.subscription.deleted":
 		return s.handleAccountBillingWebhookSubscriptionDeleted(r, v, event)
+	case "invoice.payment_failed":
+		return s.handleAccountBillingWebhookInvoicePaymentFailed(r, v, event)
+	case "invoice.paid":
+		return s.handleAccountBillingWebhookInvoicePaid(r, v, event)
 	default:
 		logvr(v, r).
 			Tag(tagStripe).
@@ -427,13 +493,135 @@ func (s *Server) handleAccountBillingWebhookSubscriptionDeleted(r *http.Request,
 		return err
 	}
 	v.SetUser(u)
+	if graceUntil := u.Billing.StripePaymentGraceUntil; graceUntil.After(time.Now()) {
+		// The tier is reset by downgradeUsersWithExpiredPaymentGrace when the grace period ends
+		logvr(v, r).
+			Tag(tagStripe).
+			Field("stripe_webhook_type", event.Type).
+			Info("Subscription deleted during payment grace period, keeping tier until %s", util.FormatTime(graceUntil))
+		if err := s.updateSubscriptionAndTier(r, v, u, u.Tier, ev.Customer, "", "", "", 0, 0); err != nil {
+			return err
+		}
+	} else {
+		logvr(v, r).
+			Tag(tagStripe).
+			Field("stripe_webhook_type", event.Type).
+			Info("Subscription deleted, downgrading to unpaid tier")
+		if err := s.updateSubscriptionAndTier(r, v, u, nil, ev.Customer, "", "", "", 0, 0); err != nil {
+			return err
+		}
+	}
+	s.publishSyncEventAsync(s.visitor(netip.IPv4Unspecified(), u))
+	return nil
+}
+
+// handleAccountBillingWebhookInvoicePaymentFailed starts the payment grace period (see stripe-payment-grace-period)
+// when a payment for a subscription fails. During the grace period, the user keeps their tier, and is notified
+// about every failed payment attempt. If the grace period is disabled, the user is downgraded right away.
+func (s *Server) handleAccountBillingWebhookInvoicePaymentFailed(r *http.Request, v *visitor, event stripe.Event) error {
+	ev, err := util.UnmarshalJSON[apiStripeInvoiceEvent](io.NopCloser(bytes.NewReader(event.Data.Raw)))
+	if err != nil {
+		return err
+	} else if ev.Customer == "" {
+		return errHTTPBadRequestBillingRequestInvalid
+	}
+	u, err := s.userManager.UserByStripeCustomer(ev.Customer)
+	if err != nil {
+		return err
+	}
+	v.SetUser(u)
+	logFields := log.Context{
+		"stripe_webhook_type":          event.Type,
+		"stripe_invoice_id":            ev.ID,
+		"stripe_invoice_attempt_count": ev.AttemptCount,
+		"stripe_subscription_id":       ev.Subscription,
+	}
+	if u.Tier == nil {
+		logvr(v, r).Tag(tagStripe).Fields(logFields).Info("Payment failed, but user has no tier; ignoring")
+		return nil
+	} else if s.config.Load().StripePaymentGracePeriod == 0 {
+		logvr(v, r).Tag(tagStripe).Fields(logFields).Info("Payment failed, downgrading to unpaid tier")
+		return s.downgradeAfterFailedPayment(r, v, u)
+	}
+	graceUntil := u.Billing.StripePaymentGraceUntil
+	if graceUntil.Unix() <= 0 {
+		graceUntil = time.Now().Add(s.config.Load().StripePaymentGracePeriod)
+		if err := s.userManager.ChangeBillingPaymentGrace(u.Name, graceUntil); err != nil {
+			return err
+		}
+	}
+	logvr(v, r).Tag(tagStripe).Fields(logFields).Info("Payment failed, keeping tier until %s", util.FormatTime(graceUntil))
+	l := s.localizer.Locale(u)
+	s.notifyUser(v, u, l.T("payment_failed_title"), l.T("payment_failed_message", "tier", u.Tier.Name, "grace_until", util.FormatTime(graceUntil)))
+	s.publishSyncEventAsync(s.visitor(netip.IPv4Unspecified(), u))
+	return nil
+}
+
+// handleAccountBillingWebhookInvoicePaid ends the payment grace period, if the user was in one
+func (s *Server) handleAccountBillingWebhookInvoicePaid(r *http.Request, v *visitor, event stripe.Event) error {
+	ev, err := util.UnmarshalJSON[apiStripeInvoiceEvent](io.NopCloser(bytes.NewReader(event.Data.Raw)))
+	if err != nil {
+		return err
+	} else if ev.Customer == "" {
+		return errHTTPBadRequestBillingRequestInvalid
+	}
+	u, err := s.userManager.UserByStripeCustomer(ev.Customer)
+	if err != nil {
+		return err
+	} else if u.Billing.StripePaymentGraceUntil.Unix() <= 0 {
+		return nil
+	}
+	v.SetUser(u)
 	logvr(v, r).
 		Tag(tagStripe).
-		Field("stripe_webhook_type", event.Type).
-		Info("Subscription deleted, downgrading to unpaid tier")
-	if err := s.updateSubscriptionAndTier(r, v, u, nil, ev.Customer, "", "", "", 0, 0); err != nil {
+		Fields(log.Context{
+			"stripe_webhook_type": event.Type,
+			"stripe_invoice_id":   ev.ID,
+		}).
+		Info("Invoice paid, ending payment grace period")
+	if err := s.userManager.ChangeBillingPaymentGrace(u.Name, time.Time{}); err != nil {
+		return err
+	}
+	s.publishSyncEventAsync(s.visitor(netip.IPv4Unspecified(), u))
+	return nil
+}
+
+// downgradeUsersWithExpiredPaymentGrace downgrades all users whose payment grace period has ended without
+// the outstanding invoice being paid. It is called periodically by the manager.
+func (s *Server) downgradeUsersWithExpiredPaymentGrace() {
+	if s.userManager == nil || s.stripe == nil {
+		return
+	}
+	users, err := s.userManager.UsersWithExpiredPaymentGrace()
+	if err != nil {
+		log.Tag(tagStripe).Err(err).Warn("Error retrieving users with expired payment grace period")
+		return
+	}
+	for _, u := range users {
+		v := s.visitor(netip.IPv4Unspecified(), u)
+		logv(v).Tag(tagStripe).Info("Payment grace period ended, downgrading to unpaid tier")
+		if err := s.downgradeAfterFailedPayment(nil, v, u); err != nil {
+			logv(v).Tag(tagStripe).Err(err).Warn("Error downgrading user after payment grace period")
+		}
+	}
+}
+
+// downgradeAfterFailedPayment cancels the Stripe subscription of the user (if any), resets their tier, and
+// ends the payment grace period. The request (r) may be nil if this is not called from a webhook.
+func (s *Server) downgradeAfterFailedPayment(r *http.Request, v *visitor, u *user.User) error {
+	if u.Billing.StripeSubscriptionID != "" {
+		if _, err := s.stripe.CancelSubscription(u.Billing.StripeSubscriptionID); err != nil {
+			return err
+		}
+	}
+	if err := s.updateSubscriptionAndTier(r, v, u, nil, u.Billing.StripeCustomerID, "", "", "", 0, 0); err != nil {
+		return err
+	}
+	if err := s.userManager.ChangeBillingPaymentGrace(u.Name, time.Time{}); err != nil {
 		return err
 	}
+	l := s.localizer.Locale(u)
+	s.notifyUser(v, u, l.T("subscription_canceled_title"), l.T("subscription_canceled_message"))
 	s.publishSyncEventAsync(s.visitor(netip.IPv4Unspecified(), u))
 	return nil
 }
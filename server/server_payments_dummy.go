@@ -3,6 +3,7 @@
 package server
 
 import (
+	"heckel.io/ntfy/v2/user"
 	"net/http"
 )
 
@@ -45,3 +46,11 @@ func (s *Server) handleAccountBillingPortalSessionCreate(w http.ResponseWriter,
 func (s *Server) handleAccountBillingWebhook(_ http.ResponseWriter, r *http.Request, v *visitor) error {
 	return errHTTPNotFound
 }
+
+func (s *Server) updateStripeCustomerEmail(_ *http.Request, _ *visitor, _ *user.User, _ string) error {
+	return nil
+}
+
+func (s *Server) downgradeUsersWithExpiredPaymentGrace() {
+	// Nothing to do
+}
@@ -4,6 +4,7 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/stripe-go/v74"
@@ -207,6 +208,92 @@ func TestPayments_SubscriptionCreate_StripeCustomer_Success(t *testing.T) {
 	require.Equal(t, "https://billing.stripe.com/abc/def", redirectResponse.RedirectURL)
 }
 
+func TestPayments_SubscriptionCreate_TaxAndInvoiceSettings(t *testing.T) {
+	stripeMock := &testStripeAPI{}
+	defer stripeMock.AssertExpectations(t)
+
+	c := newTestConfigWithAuthFile(t)
+	c.StripeSecretKey = "secret key"
+	c.StripeWebhookKey = "webhook key"
+	c.StripeBillingAddressCollection = "required"
+	c.StripeInvoiceFooter = "ntfy GmbH, Example Street 1, Berlin"
+	c.StripeInvoiceCustomFields = []*StripeInvoiceCustomField{{Name: "VAT number", Value: "DE123456789"}}
+	s := newTestServer(t, c)
+	s.stripe = stripeMock
+
+	// Define how the mock should react
+	stripeMock.
+		On("GetCustomer", "acct_123").
+		Return(&stripe.Customer{Subscriptions: &stripe.SubscriptionList{}}, nil)
+	stripeMock.
+		On("UpdateCustomer", "acct_123", &stripe.CustomerParams{
+			InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+				Footer: stripe.String("ntfy GmbH, Example Street 1, Berlin"),
+				CustomFields: []*stripe.CustomerInvoiceSettingsCustomFieldParams{
+					{Name: stripe.String("VAT number"), Value: stripe.String("DE123456789")},
+				},
+			},
+		}).
+		Return(&stripe.Customer{}, nil)
+	stripeMock.
+		On("NewCheckoutSession", mock.MatchedBy(func(params *stripe.CheckoutSessionParams) bool {
+			return *params.AutomaticTax.Enabled &&
+				*params.BillingAddressCollection == "required" &&
+				params.CustomerUpdate != nil && *params.CustomerUpdate.Address == "auto"
+		})).
+		Return(&stripe.CheckoutSession{URL: "https://billing.stripe.com/abc/def"}, nil)
+
+	// Create tier and user
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		ID:                   "ti_123",
+		Code:                 "pro",
+		StripeMonthlyPriceID: "price_123",
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeBilling("phil", &user.Billing{StripeCustomerID: "acct_123"}))
+
+	// Create subscription
+	response := request(t, s, "POST", "/v1/account/billing/subscription", `{"tier": "pro", "interval": "month"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestPayments_EmailChange_UpdatesCustomer(t *testing.T) {
+	stripeMock := &testStripeAPI{}
+	defer stripeMock.AssertExpectations(t)
+
+	c := newTestConfigWithAuthFile(t)
+	c.StripeSecretKey = "secret key"
+	c.StripeWebhookKey = "webhook key"
+	s := newTestServer(t, c)
+	s.stripe = stripeMock
+	s.setEmailSender(&testMailer{})
+
+	// Define how the mock should react
+	stripeMock.
+		On("UpdateCustomer", "acct_123", &stripe.CustomerParams{Email: stripe.String("phil@example.com")}).
+		Return(&stripe.Customer{}, nil)
+
+	// Create user with billing info
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, s.userManager.ChangeBilling(u.Name, &user.Billing{
+		StripeCustomerID: "acct_123",
+	}))
+
+	// Change email address
+	response := request(t, s, "PUT", "/v1/account/email/verify", `{"email":"phil@example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/v1/account/email", fmt.Sprintf(`{"email":"phil@example.com","code":"%s"}`, s.emailVerifications[u.ID].code), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
 func TestPayments_AccountDelete_Cancels_Subscription(t *testing.T) {
 	stripeMock := &testStripeAPI{}
 	defer stripeMock.AssertExpectations(t)
@@ -494,7 +581,7 @@ func TestPayments_Webhook_Subscription_Updated_Downgrade_From_PastDue_To_Active(
 	})
 	require.Equal(t, 200, rr.Code)
 	a2 := toMessage(t, rr.Body.String())
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, a2.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, a2.ID))
 
 	rr = request(t, s, "PUT", "/ztopic", "some zzz message", map[string]string{
 		"Authorization": util.BasicAuth("phil", "phil"),
@@ -506,7 +593,7 @@ func TestPayments_Webhook_Subscription_Updated_Downgrade_From_PastDue_To_Active(
 	})
 	require.Equal(t, 200, rr.Code)
 	z2 := toMessage(t, rr.Body.String())
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, z2.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, z2.ID))
 
 	// Call the webhook: This does all the magic
 	rr = request(t, s, "POST", "/v1/account/billing/webhook", "dummy", map[string]string{
@@ -538,12 +625,12 @@ func TestPayments_Webhook_Subscription_Updated_Downgrade_From_PastDue_To_Active(
 	ms, err := s.messageCache.Messages("atopic", sinceAllMessages, false)
 	require.Nil(t, err)
 	require.Equal(t, 2, len(ms))
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, a2.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, a2.ID))
 
 	ms, err = s.messageCache.Messages("ztopic", sinceAllMessages, false)
 	require.Nil(t, err)
 	require.Equal(t, 0, len(ms))
-	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, z2.ID))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, z2.ID))
 }
 
 func TestPayments_Webhook_Subscription_Deleted(t *testing.T) {
@@ -611,6 +698,135 @@ func TestPayments_Webhook_Subscription_Deleted(t *testing.T) {
 	require.Equal(t, 0, len(r))
 }
 
+func TestPayments_Webhook_Invoice_PaymentFailed_GracePeriod(t *testing.T) {
+	// This tests that a failed payment starts the grace period, during which the user keeps their tier
+	// (even if the subscription is deleted), and that a paid invoice ends it.
+
+	stripeMock := &testStripeAPI{}
+	defer stripeMock.AssertExpectations(t)
+
+	c := newTestConfigWithAuthFile(t)
+	c.StripeSecretKey = "secret key"
+	c.StripeWebhookKey = "webhook key"
+	c.StripePaymentGracePeriod = 3 * 24 * time.Hour
+	s := newTestServer(t, c)
+	s.stripe = stripeMock
+
+	// Define how the mock should react
+	stripeMock.
+		On("ConstructWebhookEvent", mock.Anything, "stripe signature", "webhook key").
+		Return(jsonToStripeEvent(t, invoicePaymentFailedEventJSON), nil).
+		Once()
+	stripeMock.
+		On("ConstructWebhookEvent", mock.Anything, "stripe signature", "webhook key").
+		Return(jsonToStripeEvent(t, subscriptionDeletedEventJSON), nil).
+		Once()
+	stripeMock.
+		On("ConstructWebhookEvent", mock.Anything, "stripe signature", "webhook key").
+		Return(jsonToStripeEvent(t, invoicePaidEventJSON), nil).
+		Once()
+
+	// Create a user with a Stripe subscription and a reservation
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		ID:                   "ti_1",
+		Code:                 "pro",
+		Name:                 "Pro",
+		StripeMonthlyPriceID: "price_1234",
+		ReservationLimit:     1,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddReservation("phil", "atopic", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.ChangeBilling("phil", &user.Billing{
+		StripeCustomerID:     "acct_5555",
+		StripeSubscriptionID: "sub_1234",
+	}))
+
+	// Payment fails: grace period starts, user is notified via their reserved topic
+	rr := request(t, s, "POST", "/v1/account/billing/webhook", "dummy", map[string]string{
+		"Stripe-Signature": "stripe signature",
+	})
+	require.Equal(t, 200, rr.Code)
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, "pro", u.Tier.Code)
+	require.InDelta(t, time.Now().Add(3*24*time.Hour).Unix(), u.Billing.StripePaymentGraceUntil.Unix(), 5)
+
+	rr = request(t, s, "GET", "/atopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, "Payment failed", m.Title)
+	require.Contains(t, m.Message, "Please update your payment method")
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, u.Billing.StripePaymentGraceUntil.Unix(), account.Billing.GraceUntil)
+
+	// Subscription is deleted during the grace period: tier is kept
+	rr = request(t, s, "POST", "/v1/account/billing/webhook", "dummy", map[string]string{
+		"Stripe-Signature": "stripe signature",
+	})
+	require.Equal(t, 200, rr.Code)
+	u, err = s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, "pro", u.Tier.Code)
+	require.Equal(t, "", u.Billing.StripeSubscriptionID)
+
+	// Invoice is paid: grace period ends
+	rr = request(t, s, "POST", "/v1/account/billing/webhook", "dummy", map[string]string{
+		"Stripe-Signature": "stripe signature",
+	})
+	require.Equal(t, 200, rr.Code)
+	u, err = s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, int64(0), u.Billing.StripePaymentGraceUntil.Unix())
+}
+
+func TestPayments_PaymentGrace_Expired_Downgrade(t *testing.T) {
+	stripeMock := &testStripeAPI{}
+	defer stripeMock.AssertExpectations(t)
+
+	c := newTestConfigWithAuthFile(t)
+	c.StripeSecretKey = "secret key"
+	c.StripeWebhookKey = "webhook key"
+	s := newTestServer(t, c)
+	s.stripe = stripeMock
+
+	// Define how the mock should react
+	stripeMock.
+		On("CancelSubscription", "sub_1234").
+		Return(&stripe.Subscription{}, nil)
+
+	// Create a user whose grace period has ended
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		ID:                   "ti_1",
+		Code:                 "pro",
+		StripeMonthlyPriceID: "price_1234",
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.ChangeBilling("phil", &user.Billing{
+		StripeCustomerID:     "acct_5555",
+		StripeSubscriptionID: "sub_1234",
+	}))
+	require.Nil(t, s.userManager.ChangeBillingPaymentGrace("phil", time.Now().Add(-time.Minute)))
+
+	// Run the downgrade, as the manager would
+	s.downgradeUsersWithExpiredPaymentGrace()
+
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, u.Tier)
+	require.Equal(t, "acct_5555", u.Billing.StripeCustomerID)
+	require.Equal(t, "", u.Billing.StripeSubscriptionID)
+	require.Equal(t, int64(0), u.Billing.StripePaymentGraceUntil.Unix())
+}
+
 func TestPayments_Subscription_Update_Different_Tier(t *testing.T) {
 	stripeMock := &testStripeAPI{}
 	defer stripeMock.AssertExpectations(t)
@@ -720,7 +936,7 @@ func TestPayments_CreatePortalSession(t *testing.T) {
 	stripeMock.
 		On("NewPortalSession", &stripe.BillingPortalSessionParams{
 			Customer:  stripe.String("acct_123"),
-			ReturnURL: stripe.String(s.config.BaseURL),
+			ReturnURL: stripe.String(s.config.Load().BaseURL),
 		}).
 		Return(&stripe.BillingPortalSession{
 			URL: "https://billing.stripe.com/blablabla",
@@ -857,3 +1073,29 @@ const subscriptionDeletedEventJSON = `
 		}
 	}
 }`
+
+const invoicePaymentFailedEventJSON = `
+{
+	"type": "invoice.payment_failed",
+	"data": {
+		"object": {
+			"id": "in_1234",
+			"customer": "acct_5555",
+			"subscription": "sub_1234",
+			"attempt_count": 1
+		}
+	}
+}`
+
+const invoicePaidEventJSON = `
+{
+	"type": "invoice.paid",
+	"data": {
+		"object": {
+			"id": "in_1234",
+			"customer": "acct_5555",
+			"subscription": "sub_1234",
+			"attempt_count": 2
+		}
+	}
+}`
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	linkPreviewTimeout          = 5 * time.Second
+	linkPreviewSizeLimit        = 512 * 1024 // Only the <head> is parsed, which is usually at the very beginning
+	linkPreviewMaxRedirects     = 3
+	linkPreviewCacheDuration    = time.Hour
+	linkPreviewCacheSize        = 1000
+	linkPreviewTitleLimit       = 200 // Characters
+	linkPreviewDescriptionLimit = 500 // Characters
+)
+
+var (
+	linkPreviewURLRegex         = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+	linkPreviewURLTrailingChars = ".,;:!?)]}*_~"
+
+	// linkPreviewDeniedPrefixes are non-public address ranges that are not covered by the netip.Addr.Is* functions
+	linkPreviewDeniedPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),      // "This" network
+		netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+		netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+		netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+		netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, and broadcast
+		netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, may map to private IPv4 addresses
+		netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+		netip.MustParsePrefix("2001:db8::/32"),  // Documentation
+	}
+
+	errLinkPreviewAddressNotAllowed = errors.New("address not allowed")
+	errLinkPreviewNoMetadata        = errors.New("no title or description found")
+)
+
+// linkPreviewer fetches Open Graph metadata (title, description, image) of web pages, so that clients can
+// render link cards for messages containing URLs (see enable-link-previews).
+//
+// To prevent server-side request forgery (SSRF), connections to loopback, private, link-local and other
+// non-public addresses are refused. The check is performed on the resolved IP address right before
+// connecting, so it also applies to redirects, and cannot be bypassed by DNS rebinding. Only the first
+// linkPreviewSizeLimit bytes of a page are read, and results (including failures) are cached.
+type linkPreviewer struct {
+	client    *http.Client
+	userAgent string
+	allowAddr func(addr netip.Addr) bool // Can be replaced in tests
+	cache     map[string]*linkPreviewCacheEntry
+	mu        sync.Mutex
+}
+
+type linkPreviewCacheEntry struct {
+	preview *preview // nil if fetching the preview failed
+	expires time.Time
+}
+
+func newLinkPreviewer(userAgent string) *linkPreviewer {
+	p := &linkPreviewer{
+		userAgent: userAgent,
+		allowAddr: publicAddr,
+		cache:     make(map[string]*linkPreviewCacheEntry),
+	}
+	dialer := &net.Dialer{
+		Timeout: linkPreviewTimeout,
+		Control: p.dialControl,
+	}
+	p.client = &http.Client{
+		Timeout: linkPreviewTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // Never use a proxy, the address check would only see the proxy address
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   linkPreviewTimeout,
+			ResponseHeaderTimeout: linkPreviewTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= linkPreviewMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", linkPreviewMaxRedirects)
+			} else if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return p
+}
+
+// Preview returns the link preview for the given URL, either from the cache or by fetching the page
+func (p *linkPreviewer) Preview(link string) (*preview, error) {
+	p.mu.Lock()
+	entry, ok := p.cache[link]
+	p.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		if entry.preview == nil {
+			return nil, errLinkPreviewNoMetadata
+		}
+		return entry.preview, nil
+	}
+	prev, err := p.fetch(link)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneCache()
+	p.cache[link] = &linkPreviewCacheEntry{
+		preview: prev,
+		expires: time.Now().Add(linkPreviewCacheDuration),
+	}
+	return prev, err
+}
+
+func (p *linkPreviewer) fetch(link string) (*preview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), linkPreviewTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType != "text/html" && contentType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("unexpected content type %s", contentType)
+	}
+	return parseLinkPreview(io.LimitReader(resp.Body, linkPreviewSizeLimit), resp.Request.URL)
+}
+
+// dialControl is called right before connecting to the resolved address, see net.Dialer
+func (p *linkPreviewer) dialControl(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	} else if !p.allowAddr(addrPort.Addr().Unmap()) {
+		return errLinkPreviewAddressNotAllowed
+	}
+	return nil
+}
+
+// pruneCache removes expired entries, and an arbitrary entry if the cache is still full. Must be called with p.mu held.
+func (p *linkPreviewer) pruneCache() {
+	if len(p.cache) < linkPreviewCacheSize {
+		return
+	}
+	now := time.Now()
+	for link, entry := range p.cache {
+		if now.After(entry.expires) {
+			delete(p.cache, link)
+		}
+	}
+	for link := range p.cache {
+		if len(p.cache) < linkPreviewCacheSize {
+			break
+		}
+		delete(p.cache, link)
+	}
+}
+
+// maybeAddLinkPreview adds a link preview for the first URL in the message, if there is one. Messages in
+// end-to-end encrypted or encrypted-at-rest topics never get a preview, since it would reveal their content.
+func (s *Server) maybeAddLinkPreview(v *visitor, m *message) {
+	if m.Encryption != "" || m.Encoding != "" || s.encryption.Enabled(m.Topic) {
+		return
+	}
+	link := firstLink(m.Message)
+	if link == "" {
+		return
+	}
+	prev, err := s.linkPreviewer.Preview(link)
+	if err != nil {
+		logvm(v, m).Tag(tagPublish).Err(err).Field("link_preview_url", link).Debug("Unable to fetch link preview")
+		return
+	}
+	logvm(v, m).Tag(tagPublish).Field("link_preview_url", link).Trace("Adding link preview")
+	m.Preview = prev
+}
+
+// firstLink returns the first http:// or https:// URL in the given text, or an empty string. Trailing
+// punctuation is not considered part of the URL, e.g. "see https://ntfy.sh." returns "https://ntfy.sh".
+func firstLink(text string) string {
+	link := strings.TrimRight(linkPreviewURLRegex.FindString(text), linkPreviewURLTrailingChars)
+	if u, err := url.Parse(link); err != nil || u.Host == "" {
+		return ""
+	}
+	return link
+}
+
+// parseLinkPreview parses the <head> of an HTML document, and extracts the Open Graph metadata, falling back
+// to the <title> and <meta name="description"> tags. Relative image URLs are resolved against the page URL.
+func parseLinkPreview(r io.Reader, pageURL *url.URL) (*preview, error) {
+	meta := make(map[string]string)
+	var title string
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break // EOF, or size limit reached
+		}
+		token := tokenizer.Token()
+		if (tokenType == html.StartTagToken && token.Data == "body") || (tokenType == html.EndTagToken && token.Data == "head") {
+			break
+		} else if tokenType == html.StartTagToken && token.Data == "title" && title == "" {
+			if tokenizer.Next() == html.TextToken {
+				title = string(tokenizer.Text())
+			}
+		} else if (tokenType == html.StartTagToken || tokenType == html.SelfClosingTagToken) && token.Data == "meta" {
+			var key, content string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "property", "name":
+					key = strings.ToLower(attr.Val)
+				case "content":
+					content = attr.Val
+				}
+			}
+			if _, exists := meta[key]; key != "" && !exists {
+				meta[key] = content
+			}
+		}
+	}
+	prev := &preview{
+		URL:         pageURL.String(),
+		Title:       linkPreviewText(firstNonEmpty(meta["og:title"], meta["twitter:title"], title), linkPreviewTitleLimit),
+		Description: linkPreviewText(firstNonEmpty(meta["og:description"], meta["twitter:description"], meta["description"]), linkPreviewDescriptionLimit),
+		SiteName:    linkPreviewText(meta["og:site_name"], linkPreviewTitleLimit),
+	}
+	if prev.Title == "" && prev.Description == "" {
+		return nil, errLinkPreviewNoMetadata
+	}
+	if image := strings.TrimSpace(firstNonEmpty(meta["og:image"], meta["og:image:url"], meta["twitter:image"])); image != "" {
+		if imageURL, err := pageURL.Parse(image); err == nil && (imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+			prev.Image = imageURL.String()
+		}
+	}
+	return prev, nil
+}
+
+// linkPreviewText collapses whitespace, and truncates the text to the given number of characters
+func linkPreviewText(text string, limit int) string {
+	text = strings.Join(strings.Fields(strings.ToValidUTF8(text, "")), " ")
+	if utf8.RuneCountInString(text) > limit {
+		text = strings.TrimSpace(string([]rune(text)[:limit-1])) + "…"
+	}
+	return text
+}
+
+// publicAddr returns true if the address is a public unicast address, i.e. not loopback, private,
+// link-local, multicast, or otherwise reserved
+func publicAddr(addr netip.Addr) bool {
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() || addr.IsMulticast() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, prefix := range linkPreviewDeniedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
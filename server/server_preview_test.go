@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testLinkPreviewPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Fallback title</title>
+	<meta property="og:title" content="ntfy  is
+		awesome">
+	<meta property="og:description" content="Send push notifications to your phone via PUT/POST">
+	<meta property="og:image" content="/static/img/ntfy.png">
+	<meta property="og:site_name" content="ntfy">
+</head>
+<body>
+	<meta property="og:title" content="Ignored, not in head">
+</body>
+</html>`
+
+func TestServer_LinkPreview(t *testing.T) {
+	var requests atomic.Int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		require.Equal(t, "/docs", r.URL.Path)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(testLinkPreviewPage))
+	}))
+	defer page.Close()
+
+	c := newTestConfig(t)
+	c.EnableLinkPreviews = true
+	s := newTestServer(t, c)
+	s.linkPreviewer.allowAddr = func(addr netip.Addr) bool { return true } // Test server runs on localhost
+
+	response := request(t, s, "PUT", "/mytopic", "Have you seen "+page.URL+"/docs?", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.NotNil(t, m.Preview)
+	require.Equal(t, page.URL+"/docs", m.Preview.URL)
+	require.Equal(t, "ntfy is awesome", m.Preview.Title)
+	require.Equal(t, "Send push notifications to your phone via PUT/POST", m.Preview.Description)
+	require.Equal(t, page.URL+"/static/img/ntfy.png", m.Preview.Image)
+	require.Equal(t, "ntfy", m.Preview.SiteName)
+
+	// Preview is stored in the message cache
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "ntfy is awesome", messages[0].Preview.Title)
+
+	// Second message with the same link uses the cached preview
+	response = request(t, s, "PUT", "/mytopic", page.URL+"/docs", nil)
+	require.Equal(t, "ntfy is awesome", toMessage(t, response.Body.String()).Preview.Title)
+	require.Equal(t, int32(1), requests.Load())
+
+	// No link, no preview
+	response = request(t, s, "PUT", "/mytopic", "no link here", nil)
+	require.Nil(t, toMessage(t, response.Body.String()).Preview)
+}
+
+func TestServer_LinkPreview_PrivateAddressDenied(t *testing.T) {
+	var requests atomic.Int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(testLinkPreviewPage))
+	}))
+	defer page.Close()
+
+	c := newTestConfig(t)
+	c.EnableLinkPreviews = true
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", page.URL+"/admin", nil)
+	require.Equal(t, 200, response.Code)
+	require.Nil(t, toMessage(t, response.Body.String()).Preview)
+	require.Equal(t, int32(0), requests.Load())
+}
+
+func TestServer_LinkPreview_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	require.Nil(t, s.linkPreviewer)
+	response := request(t, s, "PUT", "/mytopic", "https://ntfy.sh", nil)
+	require.Nil(t, toMessage(t, response.Body.String()).Preview)
+}
+
+func TestFirstLink(t *testing.T) {
+	require.Equal(t, "https://ntfy.sh", firstLink("see https://ntfy.sh."))
+	require.Equal(t, "https://ntfy.sh/docs/publish/?a=b#c", firstLink("Docs (https://ntfy.sh/docs/publish/?a=b#c), and http://example.com"))
+	require.Equal(t, "http://example.com/path", firstLink(`<a href="http://example.com/path">link</a>`))
+	require.Equal(t, "", firstLink("no link, or ftp://example.com"))
+	require.Equal(t, "", firstLink("https://"))
+}
+
+func TestPublicAddr(t *testing.T) {
+	for _, addr := range []string{"1.1.1.1", "8.8.8.8", "2606:4700:4700::1111"} {
+		require.True(t, publicAddr(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "255.255.255.255", "224.0.0.1", "::1", "::", "fd00::1", "fe80::1", "64:ff9b::a00:1"} {
+		require.False(t, publicAddr(netip.MustParseAddr(addr)), addr)
+	}
+}
+
+func TestParseLinkPreview_Fallbacks(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/blog/post")
+	prev, err := parseLinkPreview(strings.NewReader(`<html><head><title>My post</title><meta name="description" content="A `+strings.Repeat("very ", 200)+`long post"><meta property="og:image" content="javascript:alert(1)"></head></html>`), pageURL)
+	require.Nil(t, err)
+	require.Equal(t, "My post", prev.Title)
+	require.Equal(t, 500, len([]rune(prev.Description)))
+	require.True(t, strings.HasSuffix(prev.Description, "…"))
+	require.Equal(t, "", prev.Image)
+
+	_, err = parseLinkPreview(strings.NewReader(`<html><head></head><body><title>Not in head</title></body></html>`), pageURL)
+	require.Equal(t, errLinkPreviewNoMetadata, err)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util/qrcode"
+)
+
+const (
+	tokenBulkCountMax     = 1000
+	tokenBulkLabelDefault = "device"
+	tokenBulkFormatJSON   = "json"
+	tokenBulkFormatCSV    = "csv"
+	tokenBulkFormatHTML   = "html" // Printable sheet of QR codes, one per token
+	tokenBulkQRCodeScale  = 4
+)
+
+// handleAdminTokensBulkCreate creates a batch of tokens for a user, e.g. for a fleet of kiosks or IoT devices that all
+// need to publish to the same topics (POST /v1/admin/tokens/bulk). All tokens are restricted to the given topic pattern,
+// and can only publish unless other scopes are requested. They are returned as JSON, CSV, or as a printable sheet of
+// QR codes (see handleAccountTokenQRCode for the payload), depending on the "format" query parameter.
+func (s *Server) handleAdminTokensBulkCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	format := readQueryParam(r, "format", "f")
+	if format == "" {
+		format = tokenBulkFormatJSON
+	} else if format != tokenBulkFormatJSON && format != tokenBulkFormatCSV && format != tokenBulkFormatHTML {
+		return errHTTPBadRequestTokenBulkFormatInvalid
+	} else if format == tokenBulkFormatHTML && s.config.Load().BaseURL == "" {
+		return errHTTPInternalErrorMissingBaseURL
+	}
+	req, err := readJSONWithLimit[apiAdminTokensBulkRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Count < 1 || req.Count > tokenBulkCountMax || !user.AllowedTopicPattern(req.Topic) {
+		return errHTTPBadRequestTokenBulkInvalid
+	}
+	u, err := s.userManager.User(req.Username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{string(user.TokenScopePublish)}
+	}
+	scopes, err := parseTokenScopes(u, req.Scopes)
+	if err != nil {
+		return err
+	} else if slices.ContainsFunc(scopes, func(scope user.TokenScope) bool {
+		return scope != user.TokenScopePublish && scope != user.TokenScopeSubscribe
+	}) {
+		return errHTTPBadRequestTokenBulkInvalid
+	}
+	scopes = append(scopes, user.NewTopicScope(req.Topic))
+	label := req.Label
+	if label == "" {
+		label = tokenBulkLabelDefault
+	}
+	labels := make([]string, req.Count)
+	for i := range labels {
+		labels[i] = fmt.Sprintf("%s-%0*d", label, len(fmt.Sprint(req.Count)), i+1)
+	}
+	expires := time.Unix(0, 0)
+	if req.Expires != nil {
+		expires = time.Unix(*req.Expires, 0)
+	}
+	tokens, err := s.userManager.CreateTokenBatch(u.ID, labels, expires, v.IP(), scopes)
+	if err != nil {
+		return err
+	}
+	batch := tokens[0].Batch
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "token_batch": batch, "token_count": len(tokens), "token_expires": expires, "token_scopes": scopes.String()}).Info("Admin creating batch of tokens for user")
+	response := &apiAdminTokensBulkResponse{
+		Batch:    batch,
+		Username: u.Name,
+		Topic:    req.Topic,
+		Scopes:   scopes.Strings(),
+		Expires:  expires.Unix(),
+		Tokens:   make([]*apiAdminTokensBulkToken, 0, len(tokens)),
+	}
+	for _, t := range tokens {
+		response.Tokens = append(response.Tokens, &apiAdminTokensBulkToken{Label: t.Label, Token: t.Value})
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	switch format {
+	case tokenBulkFormatCSV:
+		return s.writeTokensBulkCSV(w, response)
+	case tokenBulkFormatHTML:
+		if err := s.writeTokensBulkHTML(w, response); err != nil {
+			if _, err := s.userManager.RemoveTokenBatch(u.ID, batch); err != nil {
+				logvr(v, r).Tag(tagAccount).Err(err).Warn("Unable to remove batch of tokens %s", batch)
+			}
+			return err
+		}
+		return nil
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAdminTokensBulkDelete revokes all tokens of a batch that was created with handleAdminTokensBulkCreate
+func (s *Server) handleAdminTokensBulkDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAdminTokensBulkRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u, err := s.userManager.User(req.Username)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.confirmAdminWebAuthn(r, v); err != nil {
+		return err
+	}
+	removed, err := s.userManager.RemoveTokenBatch(u.ID, req.Batch)
+	if err != nil && !errors.Is(err, user.ErrTokenNotFound) {
+		return err
+	} else if removed == 0 {
+		return errHTTPBadRequestTokenNotFound
+	}
+	logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "token_batch": req.Batch, "tokens_removed": removed}).Info("Admin revoking batch of tokens for user")
+	return s.writeJSON(w, &apiUsersTokensDeleteResponse{
+		Success: true,
+		Removed: removed,
+	})
+}
+
+func (s *Server) writeTokensBulkCSV(w http.ResponseWriter, response *apiAdminTokensBulkResponse) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, response.Batch))
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"label", "token", "username", "topic", "scopes", "expires", "batch"}); err != nil {
+		return err
+	}
+	scopes := strings.Join(response.Scopes, " ")
+	for _, t := range response.Tokens {
+		if err := writer.Write([]string{t.Label, t.Token, response.Username, response.Topic, scopes, fmt.Sprint(response.Expires), response.Batch}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeTokensBulkHTML renders a printable sheet with one QR code per token, so that each device can be set up
+// by scanning its code. The QR codes contain the same payload as the ones created by handleAccountTokenQRCode.
+// Topic patterns with wildcards cannot be subscribed to, so they are left out of the payload.
+func (s *Server) writeTokensBulkHTML(w http.ResponseWriter, response *apiAdminTokensBulkResponse) error {
+	var topics []string
+	if !strings.Contains(response.Topic, "*") {
+		topics = []string{response.Topic}
+	}
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<title>ntfy tokens " + html.EscapeString(response.Batch) + "</title>\n")
+	b.WriteString("<style>body{font-family:sans-serif}.card{display:inline-block;width:190px;margin:6px;padding:6px;border:1px dashed #999;text-align:center;vertical-align:top;break-inside:avoid}.card svg{width:180px;height:180px}.label{font-weight:bold}.token{font-family:monospace;font-size:8px;word-break:break-all}</style>\n")
+	b.WriteString("</head>\n<body>\n")
+	b.WriteString(fmt.Sprintf("<p>Server: %s, user: %s, topic: %s, batch: %s</p>\n", html.EscapeString(s.config.Load().BaseURL), html.EscapeString(response.Username), html.EscapeString(response.Topic), html.EscapeString(response.Batch)))
+	for _, t := range response.Tokens {
+		payload, err := json.Marshal(&apiAccountTokenQRCodePayload{
+			BaseURL: s.config.Load().BaseURL,
+			Topics:  topics,
+			Token:   t.Token,
+		})
+		if err != nil {
+			return err
+		}
+		code, err := qrcode.Encode(string(payload))
+		if err != nil {
+			return err
+		}
+		b.WriteString("<div class=\"card\">")
+		b.Write(code.SVG(tokenBulkQRCodeScale))
+		b.WriteString("<div class=\"label\">" + html.EscapeString(t.Label) + "</div>")
+		b.WriteString("<div class=\"token\">" + html.EscapeString(t.Token) + "</div>")
+		b.WriteString("</div>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
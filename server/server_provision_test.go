@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_AdminTokensBulk(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("fleet", "fleet", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("fleet", "kiosk_*", user.PermissionReadWrite))
+
+	// Only admins
+	response := request(t, s, "POST", "/v1/admin/tokens/bulk", `{"username":"fleet","topic":"kiosk_*","count":3}`, map[string]string{
+		"Authorization": util.BasicAuth("fleet", "fleet"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "POST", "/v1/admin/tokens/bulk", `{"username":"fleet","topic":"kiosk_*","count":12,"label":"kiosk"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "no-store", response.Header().Get("Cache-Control"))
+	bulk, err := util.UnmarshalJSON[apiAdminTokensBulkResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(bulk.Batch, "tb_"))
+	require.Equal(t, []string{"publish", "topic:kiosk_*"}, bulk.Scopes)
+	require.Equal(t, int64(0), bulk.Expires)
+	require.Equal(t, 12, len(bulk.Tokens))
+	require.Equal(t, "kiosk-01", bulk.Tokens[0].Label)
+	require.Equal(t, "kiosk-12", bulk.Tokens[11].Label)
+
+	// Tokens can publish to matching topics, but not subscribe
+	response = request(t, s, "PUT", "/kiosk_lobby", "door opened", map[string]string{
+		"Authorization": "Bearer " + bulk.Tokens[5].Token,
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/alerts", "door opened", map[string]string{
+		"Authorization": "Bearer " + bulk.Tokens[5].Token,
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/kiosk_lobby/json?poll=1", "", map[string]string{
+		"Authorization": "Bearer " + bulk.Tokens[5].Token,
+	})
+	require.Equal(t, 403, response.Code)
+
+	// Tokens show up in the token list, with their batch
+	response = request(t, s, "GET", "/v1/admin/tokens?username=fleet", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	tokens, err := util.UnmarshalJSON[[]*apiUsersTokenResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 12, len(*tokens))
+	require.Equal(t, bulk.Batch, (*tokens)[0].Batch)
+
+	// Revoke the entire batch
+	response = request(t, s, "DELETE", "/v1/admin/tokens/bulk", fmt.Sprintf(`{"username":"fleet","batch":"%s"}`, bulk.Batch), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	deleted, err := util.UnmarshalJSON[apiUsersTokensDeleteResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(12), deleted.Removed)
+	response = request(t, s, "PUT", "/kiosk_lobby", "door opened", map[string]string{
+		"Authorization": "Bearer " + bulk.Tokens[5].Token,
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "DELETE", "/v1/admin/tokens/bulk", fmt.Sprintf(`{"username":"fleet","batch":"%s"}`, bulk.Batch), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+}
+
+func TestServer_AdminTokensBulk_Formats(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("fleet", "fleet", user.RoleUser, false))
+
+	response := request(t, s, "POST", "/v1/admin/tokens/bulk?format=csv", `{"username":"fleet","topic":"sensors","count":2,"scopes":["publish","subscribe"],"expires":1900000000}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "text/csv; charset=utf-8", response.Header().Get("Content-Type"))
+	records, err := csv.NewReader(response.Body).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, 3, len(records))
+	require.Equal(t, []string{"label", "token", "username", "topic", "scopes", "expires", "batch"}, records[0])
+	require.Equal(t, "device-1", records[1][0])
+	require.True(t, strings.HasPrefix(records[1][1], "tk_"))
+	require.Equal(t, "publish subscribe topic:sensors", records[1][4])
+	require.Equal(t, "1900000000", records[2][5])
+
+	response = request(t, s, "POST", "/v1/admin/tokens/bulk?format=html", `{"username":"fleet","topic":"sensors","count":2}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "text/html; charset=utf-8", response.Header().Get("Content-Type"))
+	body := response.Body.String()
+	require.Equal(t, 2, strings.Count(body, "<svg "))
+	require.Contains(t, body, `<div class="label">device-2</div>`)
+
+	fleet, err := s.userManager.User("fleet")
+	require.Nil(t, err)
+	tokens, err := s.userManager.Tokens(fleet.ID)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(tokens))
+
+	// Invalid requests
+	for _, body := range []string{
+		`{"username":"fleet","topic":"sensors","count":0}`,
+		`{"username":"fleet","topic":"sensors","count":1001}`,
+		`{"username":"fleet","topic":"invalid topic","count":1}`,
+		`{"username":"fleet","topic":"sensors","count":1,"scopes":["admin"]}`,
+		`{"username":"fleet","topic":"sensors","count":1,"scopes":["topic:other"]}`,
+	} {
+		response = request(t, s, "POST", "/v1/admin/tokens/bulk", body, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, response.Code, body)
+	}
+	response = request(t, s, "POST", "/v1/admin/tokens/bulk?format=pdf", `{"username":"fleet","topic":"sensors","count":1}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40095, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/v1/admin/tokens/bulk", `{"username":"doesnotexist","topic":"sensors","count":1}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40031, toHTTPError(t, response.Body.String()).Code)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// handlePublishPreview runs the same parsing, validation and template rendering as a regular publish request
+// (PUT/POST /<topic>/preview), but instead of publishing the message, it returns the message and the channels it
+// would be delivered to. Attachments are read (to check the limits), but not stored, and the message, e-mail and
+// phone call limits of the visitor are not counted. This is meant for developing integrations.
+func (s *Server) handlePublishPreview(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	preview := &apiPublishPreviewResponse{
+		Channels: make([]*apiPublishPreviewChannel, 0),
+	}
+	r = withContext(r, map[contextKey]any{
+		contextPublishPreview: preview,
+	})
+	m, err := s.handlePublishInternal(r, v)
+	if err != nil {
+		return err
+	}
+	preview.Message = m
+	return s.writeJSON(w, preview)
+}
+
+// fillPublishPreview determines the channels a message would be delivered to. This must match the conditions
+// used when publishing a message, see handlePublishInternal.
+func (s *Server) fillPublishPreview(p *apiPublishPreviewResponse, v *visitor, t *topic, m *message, cache, firebase, unifiedpush bool, email, call string) {
+	p.Cached = cache
+	p.Delayed = m.Time > time.Now().Unix()
+	p.Subscribers, _ = t.Stats()
+	add := func(channel, recipient string, count int) {
+		p.Channels = append(p.Channels, &apiPublishPreviewChannel{Channel: channel, Recipient: recipient, Count: count})
+	}
+	if (firebase && (s.firebaseClient != nil || s.apns != nil)) || (s.config.Load().UpstreamBaseURL != "" && !unifiedpush && m.channelAllowed(channelPush)) {
+		add(channelPush, "", 0)
+	}
+	if s.config.Load().WebPushPublicKey != "" && m.channelAllowed(channelWebPush) {
+		add(channelWebPush, "", 0)
+	}
+	if s.emailSender() != nil && email != "" {
+		add(channelEmail, email, 0)
+	}
+	if s.config.Load().TwilioAccount != "" && call != "" {
+		add(channelCall, call, 0)
+	}
+	if s.webhookSender != nil && m.channelAllowed(channelWebhook) {
+		if webhooks, err := s.userManager.TopicWebhooks(m.Topic); err == nil && len(webhooks) > 0 {
+			add(channelWebhook, "", len(webhooks))
+		}
+	}
+	relayable := !unifiedpush && m.Encryption == "" && m.Encoding == ""
+	if s.matrixBridge != nil && relayable && m.channelAllowed(channelMatrix) {
+		if rooms := s.matrixBridge.Rooms(m.Topic); len(rooms) > 0 {
+			add(channelMatrix, "", len(rooms))
+		}
+	}
+	if s.telegramRelay != nil && relayable && m.channelAllowed(channelTelegram) {
+		if targets := s.telegramTargets(v, m); len(targets) > 0 {
+			add(channelTelegram, "", len(targets))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_PublishPreview(t *testing.T) {
+	sender := newTestFirebaseSender(10)
+	mailer := &testMailer{}
+	s := newTestServer(t, newTestConfig(t))
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+	s.setEmailSender(mailer)
+
+	response := request(t, s, "POST", "/mytopic/preview", `{"status":"firing","name":"disk full"}`, map[string]string{
+		"Template": "yes",
+		"Title":    "{{.status}}: {{.name}}",
+		"Message":  "Alert {{.name}} is {{.status}}",
+		"Tags":     "warning",
+		"Email":    "phil@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+	preview := toPublishPreviewResponse(t, response.Body.String())
+	require.Equal(t, "firing: disk full", preview.Message.Title)
+	require.Equal(t, "Alert disk full is firing", preview.Message.Message)
+	require.Equal(t, []string{"warning"}, preview.Message.Tags)
+	require.True(t, preview.Cached)
+	require.False(t, preview.Delayed)
+	require.Equal(t, 2, len(preview.Channels))
+	require.Equal(t, channelPush, preview.Channels[0].Channel)
+	require.Equal(t, channelEmail, preview.Channels[1].Channel)
+	require.Equal(t, "phil@example.com", preview.Channels[1].Recipient)
+
+	// Nothing was published, cached or sent
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, sender.Messages())
+	require.Equal(t, 0, mailer.Count())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Empty(t, toMessages(t, response.Body.String()))
+}
+
+func TestServer_PublishPreview_ChannelsAndValidation(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic/preview", "hi", map[string]string{
+		"Channels": "none",
+		"Cache":    "no",
+		"Delay":    "1h",
+	})
+	require.Equal(t, 400, response.Code) // Delay requires the cache
+	require.Equal(t, 40002, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic/preview", "hi", map[string]string{
+		"Channels": "none",
+		"Delay":    "1h",
+	})
+	require.Equal(t, 200, response.Code)
+	preview := toPublishPreviewResponse(t, response.Body.String())
+	require.True(t, preview.Delayed)
+	require.Empty(t, preview.Channels)
+
+	response = request(t, s, "PUT", "/mytopic/preview", "hi", map[string]string{
+		"Priority": "super-high",
+	})
+	require.Equal(t, 400, response.Code)
+}
+
+func TestServer_PublishPreview_AttachmentNotStored(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentFileSizeLimit = 5000
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic/preview", strings.Repeat("a", 4097), map[string]string{
+		"Filename": "log.txt",
+	})
+	require.Equal(t, 200, response.Code)
+	preview := toPublishPreviewResponse(t, response.Body.String())
+	require.Equal(t, "log.txt", preview.Message.Attachment.Name)
+	require.Equal(t, int64(4097), preview.Message.Attachment.Size)
+	require.NoFileExists(t, c.AttachmentCacheDir+"/"+preview.Message.ID)
+
+	response = request(t, s, "PUT", "/mytopic/preview", strings.Repeat("a", 5001), map[string]string{
+		"Filename": "log.txt",
+	})
+	require.Equal(t, 413, response.Code)
+}
+
+func toPublishPreviewResponse(t *testing.T, s string) *apiPublishPreviewResponse {
+	var response apiPublishPreviewResponse
+	require.Nil(t, json.NewDecoder(strings.NewReader(s)).Decode(&response))
+	return &response
+}
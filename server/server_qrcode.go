@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util/qrcode"
+)
+
+const (
+	qrCodeScale          = 8 // Pixels per module in PNG images, i.e. ~260px for a topic link
+	qrCodeFormatPNG      = "png"
+	qrCodeFormatSVG      = "svg"
+	tokenQRCodeTopicsMax = 10
+	tokenQRCodeLabel     = "QR code pairing"
+)
+
+// handleTopicQRCode renders a QR code with an ntfy:// link to the topic (GET /<topic>/qr). Scanning it with a phone
+// opens the topic in the Android app and subscribes to it, see https://ntfy.sh/docs/subscribe/phone/#ntfy-links.
+func (s *Server) handleTopicQRCode(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	if s.config.Load().BaseURL == "" {
+		return errHTTPInternalErrorMissingBaseURL
+	}
+	format, err := qrCodeFormat(r)
+	if err != nil {
+		return err
+	}
+	t, err := s.topicFromPath(r.URL.Path)
+	if err != nil {
+		return err
+	}
+	link, err := topicDeepLink(s.config.Load().BaseURL, t.ID)
+	if err != nil {
+		return err
+	}
+	code, err := qrcode.Encode(link)
+	if err != nil {
+		return err
+	}
+	return s.writeQRCode(w, code, format)
+}
+
+// handleAccountTokenQRCode creates an access token that is limited to subscribing to (and optionally publishing to)
+// the given topics, and renders a QR code with the provisioning payload (POST /v1/account/token/qrcode). The payload
+// contains everything a phone needs to subscribe: the server URL, the topics and the token.
+func (s *Server) handleAccountTokenQRCode(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.config.Load().BaseURL == "" {
+		return errHTTPInternalErrorMissingBaseURL
+	}
+	format, err := qrCodeFormat(r)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountTokenQRCodeRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if len(req.Topics) == 0 || len(req.Topics) > tokenQRCodeTopicsMax {
+		return errHTTPBadRequestQRCodeTopicsInvalid
+	}
+	u := v.User()
+	perms := []user.Permission{user.PermissionRead}
+	scopes := user.TokenScopes{user.TokenScopeSubscribe}
+	if req.Write {
+		perms = append(perms, user.PermissionWrite)
+		scopes = append(scopes, user.TokenScopePublish)
+	}
+	for _, topic := range req.Topics {
+		if !topicRegex.MatchString(topic) || slices.Contains(scopes, user.NewTopicScope(topic)) {
+			return errHTTPBadRequestQRCodeTopicsInvalid
+		}
+		for _, perm := range perms {
+			if err := s.userManager.Authorize(u, topic, perm); err != nil {
+				return errHTTPForbidden.Fields(log.Context{"topic": topic})
+			}
+		}
+		scopes = append(scopes, user.NewTopicScope(topic))
+	}
+	label := tokenQRCodeLabel
+	if req.Label != nil {
+		label = *req.Label
+	}
+	expires := time.Unix(0, 0) // Never expires, the token is meant for a phone
+	if req.Expires != nil {
+		expires = time.Unix(*req.Expires, 0)
+	}
+	token, err := s.userManager.CreateScopedToken(u.ID, label, expires, v.IP(), false, scopes)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(&apiAccountTokenQRCodePayload{
+		BaseURL: s.config.Load().BaseURL,
+		Topics:  req.Topics,
+		Token:   token.Value,
+	})
+	if err != nil {
+		return err
+	}
+	code, err := qrcode.Encode(string(payload))
+	if errors.Is(err, qrcode.ErrTooLong) {
+		if err := s.userManager.RemoveToken(u.ID, token.Value); err != nil {
+			return err
+		}
+		return errHTTPBadRequestQRCodeTopicsInvalid
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"token_label":   label,
+			"token_expires": expires,
+			"token_scopes":  scopes.String(),
+		}).
+		Debug("Created token for QR code pairing for user %s", u.Name)
+	w.Header().Set("Cache-Control", "no-store") // The QR code contains the token, so it must never be cached
+	return s.writeQRCode(w, code, format)
+}
+
+func (s *Server) writeQRCode(w http.ResponseWriter, code *qrcode.Code, format string) error {
+	var b []byte
+	if format == qrCodeFormatSVG {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		b = code.SVG(qrCodeScale)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		var err error
+		if b, err = code.PNG(qrCodeScale); err != nil {
+			return err
+		}
+	}
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	_, err := w.Write(b)
+	return err
+}
+
+// qrCodeFormat reads the requested image format from the "format" query parameter, defaulting to PNG
+func qrCodeFormat(r *http.Request) (string, error) {
+	format := readQueryParam(r, "format", "f")
+	if format == "" {
+		return qrCodeFormatPNG, nil
+	} else if format != qrCodeFormatPNG && format != qrCodeFormatSVG {
+		return "", errHTTPBadRequestQRCodeFormatInvalid
+	}
+	return format, nil
+}
+
+// topicDeepLink returns the ntfy:// link for the given topic, e.g. ntfy://ntfy.sh/mytopic. Servers that are only
+// reachable via plain HTTP are marked with secure=false.
+func topicDeepLink(baseURL, topic string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	link := fmt.Sprintf("ntfy://%s%s/%s", u.Host, u.Path, topic)
+	if u.Scheme == "http" {
+		link += "?secure=false"
+	}
+	return link, nil
+}
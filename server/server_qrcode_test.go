@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_TopicQRCode(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "GET", "/mytopic/qr", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/png", response.Header().Get("Content-Type"))
+	config, err := png.DecodeConfig(bytes.NewReader(response.Body.Bytes()))
+	require.Nil(t, err)
+	require.Equal(t, config.Width, config.Height)
+
+	response = request(t, s, "GET", "/mytopic/qr?format=svg", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/svg+xml", response.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(response.Body.String(), "<svg "))
+
+	response = request(t, s, "GET", "/mytopic/qr?format=gif", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40091, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_TopicQRCode_AccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionRead))
+
+	response := request(t, s, "GET", "/mytopic/qr", "", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/mytopic/qr", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestTopicDeepLink(t *testing.T) {
+	link, err := topicDeepLink("https://ntfy.sh", "mytopic")
+	require.Nil(t, err)
+	require.Equal(t, "ntfy://ntfy.sh/mytopic", link)
+	link, err = topicDeepLink("http://192.168.1.2:8080", "mytopic")
+	require.Nil(t, err)
+	require.Equal(t, "ntfy://192.168.1.2:8080/mytopic?secure=false", link)
+}
+
+func TestServer_AccountTokenQRCode(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("phil", "backups", user.PermissionRead))
+
+	response := request(t, s, "POST", "/v1/account/token/qrcode", `{"topics":["alerts"]}`, nil)
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "POST", "/v1/account/token/qrcode?format=svg", `{"topics":["alerts","backups"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/svg+xml", response.Header().Get("Content-Type"))
+	require.Equal(t, "no-store", response.Header().Get("Cache-Control"))
+
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	tokens, err := s.userManager.Tokens(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	require.Equal(t, "QR code pairing", tokens[0].Label)
+	require.Equal(t, "subscribe,topic:alerts,topic:backups", tokens[0].Scopes.String())
+
+	// The token can only subscribe to the given topics
+	response = request(t, s, "GET", "/alerts/json?poll=1", "", map[string]string{
+		"Authorization": "Bearer " + tokens[0].Value,
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/alerts", "hi", map[string]string{
+		"Authorization": "Bearer " + tokens[0].Value,
+	})
+	require.Equal(t, 403, response.Code)
+
+	// Write access requires write permission on all topics
+	response = request(t, s, "POST", "/v1/account/token/qrcode", `{"topics":["alerts","backups"],"write":true}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "POST", "/v1/account/token/qrcode", `{"topics":["alerts"],"write":true}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/png", response.Header().Get("Content-Type"))
+
+	for _, body := range []string{`{"topics":[]}`, `{"topics":["alerts","alerts"]}`, `{"topics":["invalid topic"]}`} {
+		response = request(t, s, "POST", "/v1/account/token/qrcode", body, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40092, toHTTPError(t, response.Body.String()).Code)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"strconv"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	syncTopicAccountQuotaWarningEvent = "quota_warning"
+	quotaMessages                     = "messages"
+	quotaEmails                       = "emails"
+)
+
+// quotaWarningThresholds are the percentages of the daily message/email quota at which users are warned
+var quotaWarningThresholds = []int{95, 80} // Highest first!
+
+// maybeNotifyQuotaWarnings checks the message quota, and the email quota if an email was sent with the message
+func (s *Server) maybeNotifyQuotaWarnings(v *visitor, u *user.User, stats *user.Stats, emailSent bool) {
+	limits := v.Limits()
+	s.maybeNotifyQuotaWarning(v, u, quotaMessages, stats.Messages, limits.MessageLimit)
+	if emailSent {
+		s.maybeNotifyQuotaWarning(v, u, quotaEmails, stats.Emails, limits.EmailLimit)
+	}
+}
+
+// maybeNotifyQuotaWarning warns the user (via notifyUser) and emits a "quota_warning" account event if the
+// given usage just reached one of the quotaWarningThresholds. Since each publish increases the usage by exactly
+// one, every threshold is reached at most once per day, so no additional state is required.
+//
+// Only tier-based limits are fixed daily quotas; IP-based limits are approximated from replenish rates.
+func (s *Server) maybeNotifyQuotaWarning(v *visitor, u *user.User, quota string, used, limit int64) {
+	if limit <= 0 || v.Limits().Basis != visitorLimitBasisTier {
+		return
+	}
+	percent := quotaWarningThreshold(used, limit)
+	if percent == 0 {
+		return
+	}
+	logv(v).
+		Tag(tagAccount).
+		Fields(log.Context{"quota": quota, "quota_used": used, "quota_limit": limit}).
+		Info("User reached %d%% of their daily %s quota", percent, quota)
+	l := s.localizer.Locale(u)
+	title := l.T("quota_warning_" + quota + "_title")
+	message := l.T("quota_warning_"+quota+"_message", "percent", strconv.Itoa(percent), "used", strconv.FormatInt(used, 10), "limit", strconv.FormatInt(limit, 10))
+	s.notifyUser(v, u, title, message)
+	if err := s.publishAccountEvent(v, &apiAccountSyncTopicResponse{Event: syncTopicAccountQuotaWarningEvent, Quota: quota, Percent: percent}); err != nil {
+		logv(v).Tag(tagAccount).Err(err).Warn("Unable to publish quota warning event")
+	}
+}
+
+// quotaWarningThreshold returns the threshold (in percent) that was reached by the last unit of usage, or 0
+// if no threshold was reached
+func quotaWarningThreshold(used, limit int64) int {
+	for _, percent := range quotaWarningThresholds {
+		if used == (limit*int64(percent)+99)/100 { // Round up
+			return percent
+		}
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestQuotaWarningThreshold(t *testing.T) {
+	require.Equal(t, 0, quotaWarningThreshold(7, 10))
+	require.Equal(t, 80, quotaWarningThreshold(8, 10))
+	require.Equal(t, 0, quotaWarningThreshold(9, 10))
+	require.Equal(t, 95, quotaWarningThreshold(10, 10))
+	require.Equal(t, 0, quotaWarningThreshold(11, 10))
+	require.Equal(t, 80, quotaWarningThreshold(800, 1000))
+	require.Equal(t, 95, quotaWarningThreshold(950, 1000))
+	require.Equal(t, 95, quotaWarningThreshold(1, 1))
+}
+
+func TestServer_QuotaWarning_Messages(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.EnableReservations = true
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     10,
+		ReservationLimit: 1,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddReservation("phil", "philsalerts", user.PermissionDenyAll))
+
+	countWarnings := func() int {
+		messages, err := s.messageCache.Messages("philsalerts", sinceAllMessages, false)
+		require.Nil(t, err)
+		return len(messages)
+	}
+	for i := 1; i <= 7; i++ {
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	require.Equal(t, 0, countWarnings())
+
+	// 8th message reaches 80%
+	response := request(t, s, "PUT", "/mytopic", "message 8", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return countWarnings() == 1
+	})
+	messages, err := s.messageCache.Messages("philsalerts", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, "Daily message quota almost reached", messages[0].Title)
+	require.Contains(t, messages[0].Message, "You have used 80% of your daily message quota (8 of 10)")
+	require.Equal(t, 4, messages[0].Priority)
+
+	// 9th message does not warn, 10th message reaches 95%
+	for i := 9; i <= 10; i++ {
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	waitFor(t, func() bool {
+		return countWarnings() == 2
+	})
+	messages, err = s.messageCache.Messages("philsalerts", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Contains(t, messages[1].Message, "You have used 95% of your daily message quota (10 of 10)")
+}
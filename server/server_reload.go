@@ -0,0 +1,122 @@
+package server
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+// reloadableConfigFields are the fields of the Config that can be changed while the server is running, see Reload.
+// All other fields (e.g. listen addresses, database files, or the web push keys) can only be changed by restarting
+// the server.
+var reloadableConfigFields = []string{
+	// Access control
+	"AuthDefault",
+	"AuthUsers",
+	"AuthAccess",
+	"AuthTokens",
+
+	// Rate limits
+	"TotalTopicLimit",
+	"VisitorSubscriptionLimit",
+	"VisitorRecurringMessageLimit",
+	"VisitorAttachmentTotalSizeLimit",
+	"VisitorAttachmentDailyBandwidthLimit",
+	"TopicAttachmentDailyBandwidthLimit",
+	"VisitorRequestLimitBurst",
+	"VisitorRequestLimitReplenish",
+	"VisitorRequestExemptPrefixes",
+	"VisitorRequestLimitWindows",
+	"GeoIPBlockedCountries",
+	"GeoIPRequestLimitFactors",
+	"VisitorMessageDailyLimit",
+	"VisitorEmailLimitBurst",
+	"VisitorEmailLimitReplenish",
+	"VisitorAccountCreationLimitBurst",
+	"VisitorAccountCreationLimitReplenish",
+	"VisitorAuthFailureLimitBurst",
+	"VisitorAuthFailureLimitReplenish",
+	"VisitorReportLimitBurst",
+	"VisitorReportLimitReplenish",
+	"VisitorSubscriberRateLimiting",
+	"UnifiedPushAppRequestLimitBurst",
+	"UnifiedPushAppRequestLimitReplenish",
+	"UnifiedPushAppMessageDailyLimit",
+	"ReportThrottleThreshold",
+	"ReportThrottleInterval",
+
+	// Templates
+	"TemplateLoopLimit",
+	"TemplateStringLimit",
+	"TemplateTimeout",
+
+	// Outgoing emails
+	"SMTPSenderAddr",
+	"SMTPSenderUser",
+	"SMTPSenderPass",
+	"SMTPSenderFrom",
+	"SMTPSenderFromTiers",
+	"SMTPSenderFromUsers",
+}
+
+// Reload applies the reloadable fields of the given config (see reloadableConfigFields) to the running server,
+// e.g. after the config file was changed and SIGHUP was sent. Unlike a restart, this does not disconnect any
+// subscribers. Changes to other fields are ignored, and a warning is logged.
+//
+// The new limits apply to existing visitors right away. Their message, email and call counts are kept, but
+// the request and bandwidth limiters start over, just like when a user's tier changes.
+func (s *Server) Reload(conf *Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	newConf := *s.config.Load()
+	current, updated, target := reflect.ValueOf(s.config.Load()).Elem(), reflect.ValueOf(&newConf).Elem(), reflect.ValueOf(conf).Elem()
+	changed, ignored := make([]string, 0), make([]string, 0)
+	for i := 0; i < target.NumField(); i++ {
+		name := target.Type().Field(i).Name
+		if reflect.DeepEqual(current.Field(i).Interface(), target.Field(i).Interface()) {
+			continue
+		} else if !slices.Contains(reloadableConfigFields, name) {
+			ignored = append(ignored, name)
+			continue
+		}
+		updated.Field(i).Set(target.Field(i))
+		changed = append(changed, name)
+	}
+	if len(ignored) > 0 {
+		log.Tag(tagConfig).Warn("Configuration changes to %s can only be applied by restarting the server", strings.Join(ignored, ", "))
+	}
+	if len(changed) == 0 {
+		log.Tag(tagConfig).Info("Configuration reloaded, no changes to apply")
+		return nil
+	}
+	if s.userManager != nil && changedWithPrefix(changed, "Auth") {
+		if err := s.userManager.Reload(newConf.AuthDefault, newConf.AuthUsers, newConf.AuthAccess, newConf.AuthTokens); err != nil {
+			return err
+		}
+	}
+	s.config.Store(&newConf)
+	if changedWithPrefix(changed, "SMTPSender") {
+		if newConf.SMTPSenderAddr != "" {
+			sender := &smtpSender{config: &newConf, localizer: s.localizer}
+			if previous, ok := s.emailSender().(*smtpSender); ok {
+				_, sender.success, sender.failure = previous.Counts()
+			}
+			s.setEmailSender(sender)
+		} else {
+			s.setEmailSender(nil)
+		}
+	}
+	for _, v := range s.visitors {
+		v.SetConfig(&newConf)
+	}
+	log.Tag(tagConfig).Info("Configuration reloaded, applied changes to %s", strings.Join(changed, ", "))
+	return nil
+}
+
+func changedWithPrefix(changed []string, prefix string) bool {
+	return slices.ContainsFunc(changed, func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Reload_RateLimits(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 3
+	c.VisitorMessageDailyLimit = 10
+	s := newTestServer(t, c)
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "some message", nil).Code)
+	}
+	require.Equal(t, 429, request(t, s, "PUT", "/mytopic", "some message", nil).Code)
+
+	// Raise the request limit, and lower the message limit; the message count is kept
+	newConf := *c
+	newConf.VisitorRequestLimitBurst = 100
+	newConf.VisitorMessageDailyLimit = 5
+	newConf.ListenHTTP = ":1234" // Ignored, requires restart
+	require.Nil(t, s.Reload(&newConf))
+	require.Equal(t, 100, s.config.Load().VisitorRequestLimitBurst)
+	require.Equal(t, c.ListenHTTP, s.config.Load().ListenHTTP)
+
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "some message", nil).Code)
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "some message", nil).Code)
+	rr := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 429, rr.Code)
+	require.Equal(t, 42908, toHTTPError(t, rr.Body.String()).Code) // Daily message limit
+}
+
+func TestServer_Reload_AccessControlAndProvisionedUsers(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "anonymous", nil).Code)
+
+	newConf := *c
+	newConf.AuthDefault = user.PermissionDenyAll
+	newConf.AuthUsers = []*user.User{
+		{Name: "phil", Hash: "$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C", Role: user.RoleAdmin}, // phil:phil
+	}
+	require.Nil(t, s.Reload(&newConf))
+
+	require.Equal(t, 403, request(t, s, "PUT", "/mytopic", "anonymous", nil).Code)
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "authenticated", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}).Code)
+}
+
+func TestServer_Reload_KeepsSubscribers(t *testing.T) {
+	c := newTestConfig(t)
+	s := newTestServer(t, c)
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+	time.Sleep(100 * time.Millisecond)
+
+	newConf := *c
+	newConf.VisitorSubscriptionLimit = 1
+	newConf.SMTPSenderAddr = "localhost:25"
+	newConf.SMTPSenderFrom = "ntfy@example.com"
+	require.Nil(t, s.Reload(&newConf))
+	require.NotNil(t, s.emailSender())
+
+	// The active subscription is carried over to the new limiter
+	subscriptions := int64(0)
+	s.mu.RLock()
+	for _, v := range s.visitors {
+		subscriptions += v.subscriptionLimiter.Value()
+	}
+	s.mu.RUnlock()
+	require.Equal(t, int64(1), subscriptions)
+
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "after reload", nil).Code)
+	subscribeCancel()
+	messages := toMessages(t, subscribeRR.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "after reload", messages[1].Message)
+}
+
+func TestServer_Reload_DisableEmails(t *testing.T) {
+	c := newTestConfig(t)
+	c.SMTPSenderAddr = "localhost:25"
+	c.SMTPSenderFrom = "ntfy@example.com"
+	s := newTestServer(t, c)
+	s.setEmailSender(&testMailer{})
+
+	// Publishing while emails are disabled concurrently must not crash the server
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+				"Email": "phil@example.com",
+			})
+		}()
+	}
+	newConf := *c
+	newConf.SMTPSenderAddr = ""
+	require.Nil(t, s.Reload(&newConf))
+	wg.Wait()
+	require.Nil(t, s.emailSender())
+
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Email": "phil@example.com",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, errHTTPBadRequestEmailDisabled.Code, toHTTPError(t, response.Body.String()).Code)
+}
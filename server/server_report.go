@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	reportIDPrefix          = "rp_"
+	reportIDLength          = 12
+	reportReasonLengthLimit = 1000
+)
+
+// handleReport stores an abuse report of a topic or message (POST /v1/report). Reporters must be allowed to read
+// the topic, and can report each message (or the topic itself) only once. Once the number of distinct reporters of a
+// topic reaches report-throttle-threshold, publishing to the topic is throttled until an admin resolves the reports.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiReportRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) || (req.MessageID != "" && !validMessageID(req.MessageID)) || utf8.RuneCountInString(req.Reason) > reportReasonLengthLimit {
+		return errHTTPBadRequestReportInvalid
+	}
+	t, err := s.topicFromID(req.Topic)
+	if err != nil {
+		return err
+	}
+	if s.userManager != nil && s.userManager.Authorize(v.User(), t.ID, user.PermissionRead) != nil {
+		return errHTTPForbidden.With(t)
+	}
+	if req.MessageID != "" && s.config.Load().CacheDuration > 0 {
+		m, err := s.messageCache.Message(req.MessageID)
+		if errors.Is(err, errMessageNotFound) || (err == nil && m.Topic != t.ID) {
+			return errHTTPNotFound.With(t).Fields(log.Context{
+				"message_id":    req.MessageID,
+				"error_context": "message_cache",
+			})
+		} else if err != nil {
+			return err
+		}
+	}
+	if !v.ReportAllowed() {
+		return errHTTPTooManyRequestsLimitReports.With(t)
+	}
+	username, reporter := "", "ip:"+v.IP().String()
+	if u := v.User(); u != nil {
+		username, reporter = u.Name, u.ID
+	}
+	rep := &report{
+		ID:        util.RandomStringPrefix(reportIDPrefix, reportIDLength),
+		Time:      time.Now().Unix(),
+		Topic:     t.ID,
+		MessageID: req.MessageID,
+		User:      username,
+		Sender:    v.IP().String(),
+		Reason:    req.Reason,
+	}
+	added, err := s.messageCache.AddReport(rep, reporter)
+	if err != nil {
+		return err
+	}
+	if added {
+		logvr(v, r).
+			Tag(tagReport).
+			With(t).
+			Fields(log.Context{"report_id": rep.ID, "message_id": rep.MessageID}).
+			Info("Topic %s reported as abusive", t.ID)
+		if err := s.maybeThrottleReportedTopic(t); err != nil {
+			return err
+		}
+	}
+	return s.writeJSON(w, &apiReportResponse{
+		ID:        rep.ID,
+		Topic:     rep.Topic,
+		MessageID: rep.MessageID,
+	})
+}
+
+// maybeThrottleReportedTopic throttles the given topic if it was reported by at least report-throttle-threshold
+// distinct reporters, and lifts the throttle if it was reported by fewer (e.g. after an admin dismissed reports)
+func (s *Server) maybeThrottleReportedTopic(t *topic) error {
+	if s.config.Load().ReportThrottleThreshold <= 0 {
+		return nil
+	}
+	reporters, err := s.messageCache.ReportersCount(t.ID)
+	if err != nil {
+		return err
+	}
+	if reporters >= s.config.Load().ReportThrottleThreshold && !t.Throttled() {
+		log.
+			Tag(tagReport).
+			With(t).
+			Field("reporters", reporters).
+			Warn("Topic %s reported by %d reporters, throttling to one message per %s", t.ID, reporters, s.config.Load().ReportThrottleInterval)
+		t.Throttle(s.config.Load().ReportThrottleInterval)
+	} else if reporters < s.config.Load().ReportThrottleThreshold && t.Throttled() {
+		log.
+			Tag(tagReport).
+			With(t).
+			Field("reporters", reporters).
+			Info("Topic %s reported by only %d reporters, lifting throttle", t.ID, reporters)
+		t.Unthrottle()
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Report(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/spam", "free money", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "POST", "/v1/report", fmt.Sprintf(`{"topic":"spam","message_id":"%s","reason":"phishing link"}`, m.ID), nil)
+	require.Equal(t, 200, response.Code)
+	rep, err := util.UnmarshalJSON[apiReportResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(rep.ID, "rp_"))
+	require.Equal(t, "spam", rep.Topic)
+	require.Equal(t, m.ID, rep.MessageID)
+
+	// Entire topic
+	response = request(t, s, "POST", "/v1/report", `{"topic":"spam"}`, nil)
+	require.Equal(t, 200, response.Code)
+
+	reports, err := s.messageCache.Reports("spam")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(reports))
+	require.Equal(t, m.ID, reports[0].MessageID)
+	require.Equal(t, "phishing link", reports[0].Reason)
+	require.Equal(t, "9.9.9.9", reports[0].Sender)
+	require.Equal(t, "", reports[1].MessageID)
+
+	// Invalid requests
+	for _, body := range []string{`{"topic":"invalid topic"}`, `{"topic":"spam","message_id":"x"}`, `{"topic":"spam","reason":"` + strings.Repeat("x", 1001) + `"}`} {
+		response = request(t, s, "POST", "/v1/report", body, nil)
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40083, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Message of another topic
+	response = request(t, s, "POST", "/v1/report", fmt.Sprintf(`{"topic":"other","message_id":"%s"}`, m.ID), nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Report_RateLimit(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.VisitorReportLimitBurst = 2
+	s := newTestServer(t, conf)
+
+	for i := 0; i < 2; i++ {
+		response := request(t, s, "POST", "/v1/report", fmt.Sprintf(`{"topic":"spam%d"}`, i), nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "POST", "/v1/report", `{"topic":"spam3"}`, nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42916, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Report_AccessControl(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "alerts", user.PermissionRead))
+
+	response := request(t, s, "POST", "/v1/report", `{"topic":"alerts"}`, nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "POST", "/v1/report", `{"topic":"alerts"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	reports, err := s.messageCache.Reports("alerts")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reports))
+	require.Equal(t, "ben", reports[0].User)
+}
+
+func TestServer_Report_ThrottleAndResolve(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionReadWrite
+	conf.ReportThrottleThreshold = 2
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	// Reporting twice from the same reporter does not count twice
+	for i := 0; i < 2; i++ {
+		response := request(t, s, "POST", "/v1/report", `{"topic":"spam"}`, nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "PUT", "/spam", "spam 1", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/spam", "spam 2", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Second reporter reaches the threshold, and the topic is throttled to one message per minute
+	response = request(t, s, "POST", "/v1/report", `{"topic":"spam","reason":"ads"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/spam", "spam 3", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/spam", "spam 4", nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42917, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/other", "not spam", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Admins see reports and throttled topics
+	response = request(t, s, "GET", "/v1/admin/reports", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", "/v1/admin/reports?topic=spam", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	reports, err := util.UnmarshalJSON[apiAdminReportsResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(reports.Reports))
+	require.Equal(t, "ben", reports.Reports[1].User)
+	require.Equal(t, "ads", reports.Reports[1].Reason)
+	require.Equal(t, []string{"spam"}, reports.Throttled)
+
+	// Dismissing one report lifts the throttle, since only one reporter remains
+	response = request(t, s, "DELETE", "/v1/admin/reports", fmt.Sprintf(`{"topic":"spam","id":"%s"}`, reports.Reports[1].ID), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/spam", "spam 5", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/admin/reports", `{"topic":"spam","id":"rp_doesnotexist"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	// Resolving all reports of a topic
+	response = request(t, s, "DELETE", "/v1/admin/reports", `{"topic":"spam"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	deleted, err := util.UnmarshalJSON[apiAdminReportDeleteResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, deleted.Reports)
+	remaining, err := s.messageCache.Reports("spam")
+	require.Nil(t, err)
+	require.Empty(t, remaining)
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	// AttachmentScanActionReject rejects messages with infected attachments, and deletes the attachment
+	AttachmentScanActionReject = "reject"
+
+	// AttachmentScanActionQuarantine rejects messages with infected attachments, and moves the attachment to the
+	// quarantine directory (see attachment-quarantine-dir), so that it can be reviewed by the admin
+	AttachmentScanActionQuarantine = "quarantine"
+)
+
+const (
+	clamdChunkSize          = 64 * 1024
+	clamdResponseLimit      = 4096
+	icapDefaultPort         = "1344"
+	attachmentScanThreatMax = 256 // Max length of the threat name, as reported by the scanner
+)
+
+var (
+	icapThreatRegex        = regexp.MustCompile(`(?i)\bthreat=([^;]+)`)
+	errAttachmentScanURL   = errors.New("invalid attachment-scan-url, must be clamd:///path/to/clamd.sock, clamd://host:port or icap://host:port/service")
+	errAttachmentScanReply = errors.New("unexpected reply from attachment scanner")
+)
+
+// attachmentScanner checks attachments for malware before they are accepted. Scan returns the name of the threat,
+// or an empty string if the attachment is clean. An error means that the attachment could not be scanned.
+type attachmentScanner interface {
+	Scan(ctx context.Context, in io.Reader) (threat string, err error)
+}
+
+// newAttachmentScanner creates a scanner from the attachment-scan-url, which is either a clamd socket (Unix or TCP),
+// or an ICAP service (e.g. c-icap with squidclamav, or a commercial antivirus ICAP server)
+func newAttachmentScanner(rawURL string) (attachmentScanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errAttachmentScanURL
+	}
+	switch u.Scheme {
+	case "clamd":
+		if u.Host != "" {
+			return &clamdScanner{network: "tcp", addr: u.Host}, nil
+		} else if u.Path != "" {
+			return &clamdScanner{network: "unix", addr: u.Path}, nil
+		}
+	case "icap":
+		if u.Host != "" {
+			addr := u.Host
+			if u.Port() == "" {
+				addr = net.JoinHostPort(u.Hostname(), icapDefaultPort)
+			}
+			return &icapScanner{addr: addr, url: u}, nil
+		}
+	}
+	return nil, errAttachmentScanURL
+}
+
+// clamdScanner sends attachments to clamd using the INSTREAM command, see
+// https://docs.clamav.net/manual/Usage/Scanning.html#clamd
+type clamdScanner struct {
+	network string // "tcp" or "unix"
+	addr    string
+}
+
+func (c *clamdScanner) Scan(ctx context.Context, in io.Reader) (string, error) {
+	conn, err := dialScanner(ctx, c.network, c.addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	buf := make([]byte, clamdChunkSize)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return "", err
+			} else if _, err := w.Write(buf[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return "", err
+	} else if err := w.Flush(); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(io.LimitReader(conn, clamdResponseLimit)).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	if reply == "stream: OK" {
+		return "", nil
+	} else if strings.HasPrefix(reply, "stream: ") && strings.HasSuffix(reply, " FOUND") {
+		return strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND"), nil
+	}
+	return "", fmt.Errorf("%w: %s", errAttachmentScanReply, reply)
+}
+
+// icapScanner sends attachments to an ICAP server as the body of an HTTP response (RESPMOD), see RFC 3507. The
+// server replies with "204 No Content" if the attachment is clean. Any other reply means that the server would
+// modify the response (e.g. replace it with a block page), so the attachment is considered infected.
+type icapScanner struct {
+	addr string
+	url  *url.URL
+}
+
+func (c *icapScanner) Scan(ctx context.Context, in io.Reader) (string, error) {
+	conn, err := dialScanner(ctx, "tcp", c.addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	httpHeader := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", c.url.String())
+	fmt.Fprintf(w, "Host: %s\r\n", c.url.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(httpHeader))
+	w.WriteString(httpHeader)
+	chunked := httputil.NewChunkedWriter(w)
+	if _, err := io.Copy(chunked, in); err != nil {
+		return "", err
+	} else if err := chunked.Close(); err != nil {
+		return "", err
+	} else if _, err := w.WriteString("\r\n"); err != nil { // Final CRLF after the last chunk, see httputil.NewChunkedWriter
+		return "", err
+	} else if err := w.Flush(); err != nil {
+		return "", err
+	}
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	status, err := reader.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	code, ok := strings.CutPrefix(status, "ICAP/1.0 ")
+	if !ok || len(code) < 3 {
+		return "", fmt.Errorf("%w: %s", errAttachmentScanReply, status)
+	}
+	switch code[:3] {
+	case "204":
+		return "", nil
+	case "200":
+		if matches := icapThreatRegex.FindStringSubmatch(header.Get("X-Infection-Found")); len(matches) == 2 {
+			return strings.TrimSpace(matches[1]), nil
+		} else if virus := header.Get("X-Virus-ID"); virus != "" {
+			return virus, nil
+		}
+		return "unknown", nil
+	}
+	return "", fmt.Errorf("%w: %s", errAttachmentScanReply, status)
+}
+
+func dialScanner(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// scanAttachment runs the attachment scanner (see attachment-scan-url) on the stored attachment with the given index.
+// If the scanner finds a threat, or cannot scan the attachment, all files of the message are deleted (or moved to the
+// quarantine directory), and the message is rejected. Encrypted attachments are decrypted before they are scanned.
+func (s *Server) scanAttachment(r *http.Request, v *visitor, m *message, index int, a *attachment) error {
+	if s.attachmentScanner == nil {
+		return nil
+	}
+	name := m.ID
+	if index > 0 {
+		name = fmt.Sprintf("%s.%d", m.ID, index)
+	}
+	file, err := os.Open(filepath.Join(s.config.Load().AttachmentCacheDir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var in io.ReadSeeker = file
+	if s.encryption.Enabled(m.Topic) {
+		ciphertext, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		plaintext, err := s.encryption.Decrypt(m.Topic, ciphertext)
+		if err != nil {
+			return err
+		}
+		in = bytes.NewReader(plaintext)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.Load().AttachmentScanTimeout)
+	defer cancel()
+	start := time.Now()
+	threat, err := s.attachmentScanner.Scan(ctx, in)
+	ev := logvrm(v, r, m).Tag(tagScan).Fields(log.Context{
+		"attachment_index":         index,
+		"attachment_scan_duration": time.Since(start).String(),
+	})
+	if err != nil {
+		ev.Err(err).Warn("Unable to scan attachment, rejecting message")
+		s.fileCache.Remove(m.ID)
+		return errHTTPInternalErrorAttachmentScanFailed.With(m)
+	} else if threat == "" {
+		ev.Debug("Attachment scanned, no threat found")
+		return nil
+	}
+	if len(threat) > attachmentScanThreatMax {
+		threat = threat[:attachmentScanThreatMax]
+	}
+	ev.Field("attachment_threat", threat).Warn("Attachment scanner found threat %s, rejecting message", threat)
+	minc(metricAttachmentsRejected)
+	if s.config.Load().AttachmentScanAction == AttachmentScanActionQuarantine {
+		if err := s.quarantineAttachment(v, m, name, a, threat, in); err != nil {
+			ev.Err(err).Warn("Unable to quarantine attachment")
+		}
+	}
+	s.fileCache.Remove(m.ID)
+	return errHTTPBadRequestAttachmentRejected.With(m).Fields(log.Context{"attachment_threat": threat})
+}
+
+// quarantineAttachment copies the (decrypted) attachment to the quarantine directory, along with a JSON file that
+// describes where it came from (<name>.json)
+func (s *Server) quarantineAttachment(v *visitor, m *message, name string, a *attachment, threat string, in io.ReadSeeker) error {
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.config.Load().AttachmentQuarantineDir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, in); err != nil {
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+	info := &quarantinedAttachment{
+		MessageID: m.ID,
+		Topic:     m.Topic,
+		Time:      time.Now().Unix(),
+		Sender:    v.IP().String(),
+		Name:      a.Name,
+		Type:      a.Type,
+		Size:      a.Size,
+		Threat:    threat,
+	}
+	if u := v.User(); u != nil {
+		info.User = u.Name
+	}
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.config.Load().AttachmentQuarantineDir, name+".json"), b, 0600)
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+)
+
+const testEICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+func TestServer_AttachmentScan_Clamd(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentScanURL = "clamd://" + newTestClamd(t)
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "this is a harmless file", map[string]string{
+		"Filename": "harmless.txt",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m.ID))
+
+	response = request(t, s, "PUT", "/mytopic", testEICAR, map[string]string{
+		"Filename": "eicar.com",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40093, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, int64(len("this is a harmless file")), s.fileCache.Size())
+
+	// Rejected messages are not stored
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m.ID, messages[0].ID)
+}
+
+func TestServer_AttachmentScan_Clamd_UnixSocketAndUploads(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	listener, err := net.Listen("unix", socket)
+	require.Nil(t, err)
+	go serveTestClamd(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	c := newTestConfig(t)
+	c.AttachmentScanURL = "clamd://" + socket
+	s := newTestServer(t, c)
+
+	// Second attachment is infected, so the entire message is rejected
+	ids := make([]string, 0)
+	for _, content := range []string{"first file", testEICAR} {
+		response := request(t, s, "POST", "/v1/uploads", "", map[string]string{
+			"Upload-Length": fmt.Sprintf("%d", len(content)),
+		})
+		require.Equal(t, 200, response.Code)
+		upload, err := util.UnmarshalJSON[apiUploadResponse](io.NopCloser(response.Body))
+		require.Nil(t, err)
+		response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, content, map[string]string{
+			"Upload-Offset": "0",
+		})
+		require.Equal(t, 200, response.Code)
+		ids = append(ids, upload.ID)
+	}
+	response := request(t, s, "PUT", "/mytopic", "Two files", map[string]string{
+		"Upload": strings.Join(ids, ","),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40093, toHTTPError(t, response.Body.String()).Code)
+	files, err := filepath.Glob(filepath.Join(s.config.Load().AttachmentCacheDir, "*"))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(files)) // Only the two uploads are left
+}
+
+func TestServer_AttachmentScan_ICAP_Quarantine(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentScanURL = "icap://" + newTestICAPServer(t) + "/avscan"
+	c.AttachmentScanAction = AttachmentScanActionQuarantine
+	c.AttachmentQuarantineDir = filepath.Join(t.TempDir(), "quarantine")
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "this is a harmless file", map[string]string{
+		"Filename": "harmless.txt",
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", testEICAR, map[string]string{
+		"Filename": "eicar.com",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40093, toHTTPError(t, response.Body.String()).Code)
+
+	files, err := filepath.Glob(filepath.Join(c.AttachmentQuarantineDir, "*.json"))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(files))
+	b, err := os.ReadFile(files[0])
+	require.Nil(t, err)
+	var info quarantinedAttachment
+	require.Nil(t, json.Unmarshal(b, &info))
+	require.Equal(t, "mytopic", info.Topic)
+	require.Equal(t, "eicar.com", info.Name)
+	require.Equal(t, "9.9.9.9", info.Sender)
+	require.Equal(t, "Eicar-Test-Signature", info.Threat)
+	quarantined, err := os.ReadFile(strings.TrimSuffix(files[0], ".json"))
+	require.Nil(t, err)
+	require.Equal(t, testEICAR, string(quarantined))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, info.MessageID))
+}
+
+func TestServer_AttachmentScan_Encrypted(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentScanURL = "clamd://" + newTestClamd(t)
+	c.CacheEncryptionKey = []byte("01234567890123456789012345678901")
+	c.CacheEncryptedTopics = []string{"secret"}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/secret", testEICAR, map[string]string{
+		"Filename": "eicar.com",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40093, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AttachmentScan_Unavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := listener.Addr().String()
+	require.Nil(t, listener.Close())
+
+	c := newTestConfig(t)
+	c.AttachmentScanURL = "clamd://" + addr
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "this is a harmless file", map[string]string{
+		"Filename": "harmless.txt",
+	})
+	require.Equal(t, 500, response.Code)
+	require.Equal(t, 50005, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, int64(0), s.fileCache.Size())
+
+	// Messages without attachments are not affected
+	response = request(t, s, "PUT", "/mytopic", "just a message", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestNewAttachmentScanner(t *testing.T) {
+	scanner, err := newAttachmentScanner("clamd:///run/clamav/clamd.ctl")
+	require.Nil(t, err)
+	require.Equal(t, &clamdScanner{network: "unix", addr: "/run/clamav/clamd.ctl"}, scanner)
+
+	scanner, err = newAttachmentScanner("clamd://127.0.0.1:3310")
+	require.Nil(t, err)
+	require.Equal(t, &clamdScanner{network: "tcp", addr: "127.0.0.1:3310"}, scanner)
+
+	scanner, err = newAttachmentScanner("icap://av.example.com/avscan")
+	require.Nil(t, err)
+	require.Equal(t, "av.example.com:1344", scanner.(*icapScanner).addr)
+
+	for _, rawURL := range []string{"", "http://127.0.0.1:3310", "icap:///avscan", "clamd://"} {
+		_, err = newAttachmentScanner(rawURL)
+		require.Equal(t, errAttachmentScanURL, err, rawURL)
+	}
+}
+
+// newTestClamd starts a fake clamd that reports files containing the EICAR test string as infected
+func newTestClamd(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go serveTestClamd(listener)
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+func serveTestClamd(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			command, err := r.ReadString(0)
+			if err != nil || command != "zINSTREAM\x00" {
+				return
+			}
+			var data bytes.Buffer
+			for {
+				var length uint32
+				if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+					return
+				} else if length == 0 {
+					break
+				} else if _, err := io.CopyN(&data, r, int64(length)); err != nil {
+					return
+				}
+			}
+			if bytes.Contains(data.Bytes(), []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+				conn.Write([]byte("stream: Win.Test.EICAR_HDB-1 FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+		}()
+	}
+}
+
+// newTestICAPServer starts a fake ICAP server that replies like c-icap with the virus_scan service
+func newTestICAPServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := textproto.NewReader(bufio.NewReader(conn))
+				if line, err := r.ReadLine(); err != nil || !strings.HasPrefix(line, "RESPMOD icap://") {
+					return
+				} else if _, err := r.ReadMIMEHeader(); err != nil { // ICAP headers
+					return
+				} else if _, err := r.ReadLine(); err != nil { // Encapsulated HTTP status line
+					return
+				} else if _, err := r.ReadMIMEHeader(); err != nil { // Encapsulated HTTP response headers
+					return
+				}
+				body, err := io.ReadAll(httputil.NewChunkedReader(r.R))
+				if err != nil {
+					return
+				}
+				if bytes.Contains(body, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+					conn.Write([]byte("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=Eicar-Test-Signature;\r\nEncapsulated: null-body=0\r\n\r\n"))
+				} else {
+					conn.Write([]byte("ICAP/1.0 204 No Content\r\nEncapsulated: null-body=0\r\n\r\n"))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// checkRecurringMessageLimit returns an error if the visitor (or the user, if the visitor is logged in)
+// has already created the maximum number of recurring messages, see visitor-recurring-message-limit
+func (s *Server) checkRecurringMessageLimit(v *visitor) *errHTTP {
+	count, err := s.messageCache.RecurringMessagesCount(v.MaybeUserID(), v.IP())
+	if err != nil {
+		return errHTTPInternalError
+	} else if count >= s.config.Load().VisitorRecurringMessageLimit {
+		return errHTTPTooManyRequestsLimitRecurringMessages
+	}
+	return nil
+}
+
+// sendRecurringMessage publishes an occurrence of the recurring message m, and reschedules m to its next
+// occurrence. Each occurrence is a copy of m with its own message ID, so clients do not treat them as
+// duplicates. Unlike delayed messages, every occurrence counts against the visitor's message limit.
+func (s *Server) sendRecurringMessage(v *visitor, m *message) error {
+	schedule, err := util.ParseCron(m.Cron)
+	if err != nil {
+		logvm(v, m).Err(err).Warn("Deleting recurring message with invalid cron expression")
+		return s.messageCache.DeleteMessages(m.ID)
+	}
+	next := schedule.Next(time.Now())
+	if next.IsZero() {
+		logvm(v, m).Warn("Deleting recurring message, cron expression does not match any future time")
+		return s.messageCache.DeleteMessages(m.ID)
+	}
+	if err := s.messageCache.Reschedule(m, next.Unix()); err != nil {
+		return err
+	}
+	if !util.ContainsIP(s.config.Load().VisitorRequestExemptPrefixes, v.ip) && !v.MessageAllowed() {
+		logvm(v, m).Tag(tagPublish).Info("Skipping recurring message, daily message limit reached")
+		return nil
+	}
+	occurrence := *m
+	occurrence.ID = util.RandomString(messageIDLength)
+	occurrence.Time = time.Now().Unix()
+	occurrence.Expires = time.Now().Add(v.Limits().MessageExpiryDuration).Unix()
+	if m.TTL > 0 {
+		occurrence.Expires = occurrence.Time + m.TTL // Each occurrence expires relative to its own delivery time
+	}
+	occurrence.Cron = ""
+	logvm(v, &occurrence).Tag(tagPublish).Field("scheduled_message_id", m.ID).Debug("Sending recurring message")
+	if err := s.messageCache.AddMessage(&occurrence); err != nil {
+		return err
+	}
+	s.deliverScheduledMessage(v, &occurrence)
+	if u := v.User(); s.userManager != nil && u != nil && u.Tier != nil {
+		go s.userManager.EnqueueUserStats(u.ID, v.Stats())
+	}
+	maddTopic(metricTopicMessagesPublished, occurrence.Topic, 1)
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+	s.statsCollector.AddMessage()
+	s.statsCollector.AddPublish(v, &occurrence)
+	return nil
+}
+
+// handleScheduledGet returns all delayed and recurring messages of a topic that have not been sent yet,
+// e.g. GET /v1/scheduled?topic=mytopic. Reading them requires read access to the topic.
+func (s *Server) handleScheduledGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topicID := readParam(r, "x-topic", "topic")
+	if !topicRegex.MatchString(topicID) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	t, err := s.topicFromID(topicID)
+	if err != nil {
+		return err
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), t.ID, user.PermissionRead); err != nil {
+			return errHTTPForbidden.With(t)
+		}
+	}
+	messages, err := s.messageCache.ScheduledMessages(t.ID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, messages)
+}
+
+// handleScheduledDelete cancels a delayed or recurring message before it is (next) sent, e.g.
+// DELETE /v1/scheduled/<message-id>. Cancelling requires write access to the message's topic.
+func (s *Server) handleScheduledDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiScheduledSingleRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	m, err := s.messageCache.ScheduledMessage(matches[1])
+	if errors.Is(err, errMessageNotFound) {
+		return errHTTPNotFound.Fields(log.Context{
+			"message_id":    matches[1],
+			"error_context": "message_cache",
+		})
+	} else if err != nil {
+		return err
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), m.Topic, user.PermissionWrite); err != nil {
+			return errHTTPForbidden.With(m)
+		}
+	}
+	logvrm(v, r, m).Tag(tagPublish).Info("Cancelling scheduled message")
+	if err := s.messageCache.DeleteMessages(m.ID); err != nil {
+		return err
+	}
+	if s.fileCache != nil && m.Attachment != nil {
+		if err := s.fileCache.Remove(m.ID); err != nil {
+			logvrm(v, r, m).Tag(tagPublish).Err(err).Warn("Error deleting attachment of scheduled message")
+		}
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_PublishCron(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "backup reminder", map[string]string{
+		"X-Cron": "*/5 * * * *",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "*/5 * * * *", m.Cron)
+	require.Greater(t, m.Time, time.Now().Unix())
+	require.Equal(t, int64(0), m.Time%300)
+
+	// Not published yet, but listed as scheduled
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 0, len(toMessages(t, response.Body.String())))
+	scheduled := toScheduledMessages(t, s, "mytopic")
+	require.Equal(t, 1, len(scheduled))
+	require.Equal(t, m.ID, scheduled[0].ID)
+
+	// Force the message to be due, and send it; an occurrence with a new ID is published
+	for i := 0; i < 2; i++ {
+		sm, err := s.messageCache.ScheduledMessage(m.ID)
+		require.Nil(t, err)
+		require.Nil(t, s.messageCache.Reschedule(sm, time.Now().Unix()-10))
+		require.Nil(t, s.sendDelayedMessages())
+	}
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.NotEqual(t, m.ID, messages[0].ID)
+	require.NotEqual(t, messages[0].ID, messages[1].ID)
+	require.Equal(t, "backup reminder", messages[0].Message)
+	require.Equal(t, "", messages[0].Cron)
+
+	// Rescheduled for the next occurrence
+	scheduled = toScheduledMessages(t, s, "mytopic")
+	require.Equal(t, 1, len(scheduled))
+	require.Greater(t, scheduled[0].Time, time.Now().Unix())
+
+	// Cancel
+	response = request(t, s, "DELETE", "/v1/scheduled/"+m.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 0, len(toScheduledMessages(t, s, "mytopic")))
+	response = request(t, s, "DELETE", "/v1/scheduled/"+m.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+
+	// Published messages cannot be cancelled
+	response = request(t, s, "DELETE", "/v1/scheduled/"+messages[0].ID, "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_PublishCron_JSONAndDelayed(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/", `{"topic":"mytopic","message":"weekly","cron":"0 9 * * mon"}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "0 9 * * mon", toMessage(t, response.Body.String()).Cron)
+
+	response = request(t, s, "PUT", "/mytopic", "delayed", map[string]string{"X-Delay": "1h"})
+	require.Equal(t, 200, response.Code)
+	delayed := toMessage(t, response.Body.String())
+
+	// Delayed messages are listed and can be cancelled as well
+	scheduled := toScheduledMessages(t, s, "mytopic")
+	require.Equal(t, 2, len(scheduled))
+	response = request(t, s, "DELETE", "/v1/scheduled/"+delayed.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 1, len(toScheduledMessages(t, s, "mytopic")))
+}
+
+func TestServer_PublishCron_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Cron": "every day"})
+	require.Equal(t, 40062, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Cron": "0 0 30 2 *"})
+	require.Equal(t, 40062, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Cron": "@daily", "X-Delay": "1h"})
+	require.Equal(t, 40063, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Cron": "@daily", "Cache": "no"})
+	require.Equal(t, 40063, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Cron": "@daily", "Filename": "test.txt"})
+	require.Equal(t, 40063, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/v1/scheduled", "", nil)
+	require.Equal(t, 400, response.Code)
+}
+
+func TestServer_PublishCron_Limit(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRecurringMessageLimit = 2
+	s := newTestServer(t, c)
+
+	for i := 0; i < 2; i++ {
+		response := request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Cron": "@hourly"})
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Cron": "@hourly"})
+	require.Equal(t, 42911, toHTTPError(t, response.Body.String()).Code)
+
+	// Delayed messages do not count towards the limit
+	response = request(t, s, "PUT", "/mytopic", "test", map[string]string{"X-Delay": "1h"})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_Scheduled_Unauthorized(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionRead))
+
+	response := request(t, s, "PUT", "/mytopic", "test", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-Cron":        "@daily",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/v1/scheduled?topic=mytopic", "", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/v1/scheduled?topic=mytopic", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "DELETE", "/v1/scheduled/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "DELETE", "/v1/scheduled/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func toScheduledMessages(t *testing.T, s *Server, topic string) []*message {
+	response := request(t, s, "GET", "/v1/scheduled?topic="+topic, "", nil)
+	require.Equal(t, 200, response.Code)
+	var messages []*message
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&messages))
+	return messages
+}
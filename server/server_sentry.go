@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+// goReportPanics runs a background worker in a goroutine, and reports to Sentry if it panics
+func (s *Server) goReportPanics(worker string, fn func()) {
+	go func() {
+		defer s.reportPanic(nil, worker)
+		fn()
+	}()
+}
+
+// reportPanic reports a panic of an HTTP handler or a background worker to Sentry, and then re-panics, so that
+// the panic is handled as it would be without Sentry (i.e. net/http closes the connection, and a panicking
+// background worker crashes the server). It must be deferred directly, otherwise recover() returns nil.
+func (s *Server) reportPanic(r *http.Request, worker string) {
+	if s.sentry == nil {
+		return
+	}
+	if recovered := recover(); recovered != nil {
+		if recovered != http.ErrAbortHandler { // Used by net/http to abort a response, not an error
+			tags := sentryRequestTags(r)
+			if worker != "" {
+				tags = map[string]string{"worker": worker}
+			}
+			if err := s.sentry.CapturePanic(recovered, tags); err != nil {
+				log.Tag(tagSentry).Err(err).Warn("Unable to report panic to Sentry")
+			}
+		}
+		panic(recovered)
+	}
+}
+
+// reportError reports an internal server error to Sentry. Errors with a defined ntfy error code
+// (e.g. 404 or 429) are not reported, since they are expected.
+func (s *Server) reportError(r *http.Request, err error) {
+	if s.sentry == nil {
+		return
+	}
+	s.sentry.CaptureError(err, sentryRequestTags(r))
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	signingKeyTopicLimit = 10 // Max number of signing keys per topic
+
+	// signatureMaxAge is the max difference between the signed time of a message and the server time. Signatures
+	// are remembered for this long, so that a signed message cannot be replayed to a topic that requires signatures.
+	signatureMaxAge = 5 * time.Minute
+)
+
+var (
+	// signatureRegex matches the X-Signature header: the Unix time at which the message was signed, and the
+	// Ed25519 signature as URL-safe base64 without padding, e.g. t=1760572800,s=p0Yl6Gv2...
+	signatureRegex = regexp.MustCompile(`^t=([0-9]{1,12}),s=([-_A-Za-z0-9]{86})$`)
+)
+
+// signedPayload is the data that is covered by the signature of a message (X-Signature header), i.e. all fields
+// that are delivered to subscribers and can be set by the publisher. It is serialized as JSON in this exact order,
+// and must match the payload signed by the client, see client.Sign.
+type signedPayload struct {
+	Time        int64               `json:"time"`
+	Topic       string              `json:"topic"`
+	Title       string              `json:"title"`
+	Message     string              `json:"message"`
+	Priority    int                 `json:"priority"`
+	Tags        []string            `json:"tags"`
+	Click       string              `json:"click"`
+	Icon        string              `json:"icon"`
+	Actions     []*signedAction     `json:"actions"`
+	Attachments []*signedAttachment `json:"attachments"`
+	ContentType string              `json:"content_type"`
+	ReplyTo     string              `json:"reply_to"`
+	Encryption  string              `json:"encryption"`
+}
+
+// signedAction is an action button as covered by the signature. The action ID is not signed, since it is
+// generated by the server.
+type signedAction struct {
+	Action  string            `json:"action"`
+	Label   string            `json:"label"`
+	Clear   bool              `json:"clear"`
+	URL     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Intent  string            `json:"intent,omitempty"`
+	Extras  map[string]string `json:"extras,omitempty"`
+}
+
+// signedAttachment is an external attachment (X-Attach) as covered by the signature
+type signedAttachment struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+func newSignedPayload(m *message, signed int64) *signedPayload {
+	payload := &signedPayload{
+		Time:        signed,
+		Topic:       m.Topic,
+		Title:       m.Title,
+		Message:     m.Message,
+		Priority:    m.Priority,
+		Tags:        make([]string, 0),
+		Click:       m.Click,
+		Icon:        m.Icon,
+		Actions:     make([]*signedAction, 0),
+		Attachments: make([]*signedAttachment, 0),
+		ContentType: m.ContentType,
+		ReplyTo:     m.ReplyTo,
+		Encryption:  m.Encryption,
+	}
+	payload.Tags = append(payload.Tags, m.Tags...)
+	for _, a := range m.Actions {
+		payload.Actions = append(payload.Actions, &signedAction{
+			Action:  a.Action,
+			Label:   a.Label,
+			Clear:   a.Clear,
+			URL:     a.URL,
+			Method:  a.Method,
+			Headers: a.Headers,
+			Body:    a.Body,
+			Intent:  a.Intent,
+			Extras:  a.Extras,
+		})
+	}
+	for _, a := range m.attachments() {
+		payload.Attachments = append(payload.Attachments, &signedAttachment{URL: a.URL, Name: a.Name})
+	}
+	return payload
+}
+
+// parseSignature splits the X-Signature header into the signed time and the raw signature
+func parseSignature(s string) (signed int64, signature []byte, err error) {
+	matches := signatureRegex.FindStringSubmatch(s)
+	if len(matches) != 3 {
+		return 0, nil, errors.New("invalid signature format")
+	}
+	signed, err = strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, nil, err
+	}
+	signature, err = base64.RawURLEncoding.DecodeString(matches[2])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return 0, nil, errors.New("invalid signature")
+	}
+	return signed, signature, nil
+}
+
+// verifyTopicSignature checks the signature of the message against the signing keys of its topic. If the
+// topic has no signing keys, all messages are accepted. Otherwise, messages that are not signed with one of
+// the keys, that were signed more than signatureMaxAge ago, or whose signature was already used, are rejected.
+func (s *Server) verifyTopicSignature(m *message) error {
+	if s.userManager == nil || m.Event != messageEvent {
+		return nil
+	}
+	keys, err := s.userManager.TopicSigningKeys(m.Topic)
+	if err != nil {
+		return err
+	} else if len(keys) == 0 {
+		return nil
+	}
+	signed, signature, err := parseSignature(m.Signature)
+	if err != nil {
+		return errHTTPForbiddenSignatureRequired.With(m)
+	}
+	payload, err := json.Marshal(newSignedPayload(m, signed))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key.PublicKey, payload, signature) {
+			return s.checkSignatureReplay(m, signed)
+		}
+	}
+	return errHTTPForbiddenSignatureRequired.With(m)
+}
+
+// checkSignatureReplay rejects signatures that are too old (or too far in the future), and signatures that
+// were already used within signatureMaxAge, so that signed messages cannot be replayed
+func (s *Server) checkSignatureReplay(m *message, signed int64) error {
+	now := time.Now()
+	if age := now.Sub(time.Unix(signed, 0)); age > signatureMaxAge || age < -signatureMaxAge {
+		return errHTTPForbiddenSignatureExpired.With(m)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for signature, used := range s.signatures {
+		if now.Sub(used) > 2*signatureMaxAge {
+			delete(s.signatures, signature)
+		}
+	}
+	if _, ok := s.signatures[m.Signature]; ok {
+		return errHTTPForbiddenSignatureExpired.With(m)
+	}
+	s.signatures[m.Signature] = now
+	return nil
+}
+
+func (s *Server) handleAccountReservationSigningKeysGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	keys, err := s.userManager.TopicSigningKeys(topic)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountSigningKey, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, newAPIAccountSigningKey(key))
+	}
+	return s.writeJSON(w, response)
+}
+
+func (s *Server) handleAccountReservationSigningKeyAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountSigningKeyAddRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	publicKey, err := parseSigningKey(req.PublicKey)
+	if err != nil {
+		return errHTTPBadRequestSigningKeyInvalid
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Adding signing key for topic %s", topic)
+	key, err := s.userManager.AddTopicSigningKey(v.User().Name, topic, publicKey, signingKeyTopicLimit)
+	if errors.Is(err, user.ErrTooManyTopicSigningKeys) {
+		return errHTTPTooManyRequestsLimitSigningKeys
+	} else if errors.Is(err, user.ErrTopicSigningKeyExists) {
+		return errHTTPConflictSigningKeyExists
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newAPIAccountSigningKey(key))
+}
+
+func (s *Server) handleAccountReservationSigningKeyDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	matches := apiAccountReservationSigningKeyRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	keyID := matches[2]
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{"topic": topic, "signing_key_id": keyID}).
+		Debug("Removing signing key for topic %s", topic)
+	if err := s.userManager.RemoveTopicSigningKey(topic, keyID); errors.Is(err, user.ErrTopicSigningKeyNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// parseSigningKey parses an Ed25519 public key, either in PEM format (PKIX, as generated by
+// "openssl pkey -in key.pem -pubout"), or as the raw 32-byte key encoded as standard or URL-safe base64
+func parseSigningKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("not an Ed25519 public key")
+		}
+		return publicKey, nil
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := encoding.DecodeString(s); err == nil && len(b) == ed25519.PublicKeySize {
+			return b, nil
+		}
+	}
+	return nil, errors.New("invalid public key")
+}
+
+func newAPIAccountSigningKey(key *user.TopicSigningKey) *apiAccountSigningKey {
+	return &apiAccountSigningKey{
+		ID:        key.ID,
+		PublicKey: base64.StdEncoding.EncodeToString(key.PublicKey),
+		Created:   key.Created.Unix(),
+	}
+}
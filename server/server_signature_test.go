@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_SigningKeys_RequireSignature(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	conf.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionReadWrite))
+
+	// Without signing keys, unsigned messages are accepted
+	response := request(t, s, "PUT", "/mytopic", "unsigned", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Register key as PEM
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	require.Nil(t, err)
+	body, _ := json.Marshal(&apiAccountSigningKeyAddRequest{PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))})
+	response = request(t, s, "POST", "/v1/account/reservation/mytopic/signing-keys", string(body), map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	key, err := util.UnmarshalJSON[apiAccountSigningKey](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, base64.StdEncoding.EncodeToString(publicKey), key.PublicKey)
+
+	// Same key again, and invalid keys
+	response = request(t, s, "POST", "/v1/account/reservation/mytopic/signing-keys", `{"public_key":"`+key.PublicKey+`"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 40910, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/v1/account/reservation/mytopic/signing-keys", `{"public_key":"not a key"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 40078, toHTTPError(t, response.Body.String()).Code)
+
+	// Unsigned and wrongly signed messages are rejected
+	response = request(t, s, "PUT", "/mytopic", "unsigned", nil)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40306, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", "tampered", map[string]string{
+		"X-Signature": signTestMessage(t, privateKey, "mytopic", "", "original"),
+	})
+	require.Equal(t, 40306, toHTTPError(t, response.Body.String()).Code)
+
+	// Signed messages are accepted
+	response = request(t, s, "PUT", "/mytopic", "deployed", map[string]string{
+		"X-Title":     "Deploy",
+		"X-Signature": signTestMessage(t, privateKey, "mytopic", "Deploy", "deployed"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Other topics are not affected
+	response = request(t, s, "PUT", "/othertopic", "unsigned", nil)
+	require.Equal(t, 200, response.Code)
+
+	// List and delete
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/signing-keys", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	keys, err := util.UnmarshalJSON[[]*apiAccountSigningKey](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Len(t, *keys, 1)
+	require.Equal(t, key.ID, (*keys)[0].ID)
+
+	response = request(t, s, "DELETE", "/v1/account/reservation/mytopic/signing-keys/"+key.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/account/reservation/mytopic/signing-keys/"+key.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "unsigned", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_SigningKeys_NotOwner(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionDenyAll))
+
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	response := request(t, s, "POST", "/v1/account/reservation/mytopic/signing-keys", `{"public_key":"`+base64.StdEncoding.EncodeToString(publicKey)+`"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, response.Code)
+}
+
+func signTestMessage(t *testing.T, key ed25519.PrivateKey, topic, title, msg string) string {
+	return signTestMessageAt(t, key, &message{Topic: topic, Title: title, Message: msg}, time.Now())
+}
+
+func signTestMessageAt(t *testing.T, key ed25519.PrivateKey, m *message, signed time.Time) string {
+	payload, err := json.Marshal(newSignedPayload(m, signed.Unix()))
+	require.Nil(t, err)
+	return fmt.Sprintf("t=%d,s=%s", signed.Unix(), base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, payload)))
+}
+
+func TestServer_SigningKeys_ExpiredReplayedAndTampered(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	conf.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionReadWrite))
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	_, err = s.userManager.AddTopicSigningKey("ben", "mytopic", publicKey, signingKeyTopicLimit)
+	require.Nil(t, err)
+
+	// All delivered fields are signed
+	m := &message{
+		Topic:    "mytopic",
+		Title:    "Deploy",
+		Message:  "deployed",
+		Priority: 4,
+		Tags:     []string{"rocket", "prod"},
+		Click:    "https://example.com/deploys/1",
+		Actions: []*action{
+			{Action: "view", Label: "Open", URL: "https://example.com/deploys/1"},
+		},
+	}
+	signature := signTestMessageAt(t, privateKey, m, time.Now())
+	headers := map[string]string{
+		"X-Title":     "Deploy",
+		"X-Priority":  "4",
+		"X-Tags":      "rocket,prod",
+		"X-Click":     "https://example.com/deploys/1",
+		"X-Actions":   `[{"action":"view","label":"Open","url":"https://example.com/deploys/1"}]`,
+		"X-Signature": signature,
+	}
+	for _, header := range []string{"X-Priority", "X-Tags", "X-Click", "X-Actions"} {
+		tampered := make(map[string]string)
+		for k, v := range headers {
+			tampered[k] = v
+		}
+		delete(tampered, header)
+		response := request(t, s, "PUT", "/mytopic", "deployed", tampered)
+		require.Equal(t, 40306, toHTTPError(t, response.Body.String()).Code, header)
+	}
+	response := request(t, s, "PUT", "/mytopic", "deployed", headers)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, signature, toMessage(t, response.Body.String()).Signature)
+
+	// The same signature cannot be used twice
+	response = request(t, s, "PUT", "/mytopic", "deployed", headers)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40311, toHTTPError(t, response.Body.String()).Code)
+
+	// Signatures outside the time window are rejected
+	response = request(t, s, "PUT", "/mytopic", "old", map[string]string{
+		"X-Signature": signTestMessageAt(t, privateKey, &message{Topic: "mytopic", Message: "old"}, time.Now().Add(-signatureMaxAge-time.Minute)),
+	})
+	require.Equal(t, 40311, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", "future", map[string]string{
+		"X-Signature": signTestMessageAt(t, privateKey, &message{Topic: "mytopic", Message: "future"}, time.Now().Add(signatureMaxAge+time.Minute)),
+	})
+	require.Equal(t, 40311, toHTTPError(t, response.Body.String()).Code)
+}
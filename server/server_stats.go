@@ -0,0 +1,333 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	// statsRollupsDefaultSince is the default time range returned by the stats rollups endpoint
+	statsRollupsDefaultSince = 7 * 24 * time.Hour
+
+	// statsUsageMaxEntries is the max number of distinct (day, user, topic) usage counters kept in memory
+	// between two flushes. Usage of further users/topics is not counted until the next flush.
+	statsUsageMaxEntries = 50000
+
+	// statsUsageDefaultLimit and statsUsageMaxLimit define the number of entries returned by the admin usage endpoint
+	statsUsageDefaultLimit = 20
+	statsUsageMaxLimit     = 1000
+)
+
+// statsCollector counts messages, emails, calls, as well as distinct visitors and users for the current hour.
+// The counters are periodically written to the hourly stats rollups in the user database (see user.StatsRollup),
+// so that only a bounded amount of aggregated data is stored, no matter how many visitors there are.
+//
+// In addition, it counts published messages, subscriptions and attachments per user and topic, which are
+// written to the daily usage stats (see user.UsageStats).
+//
+// All methods work with a nil receiver, in which case they do nothing.
+type statsCollector struct {
+	hour      time.Time                          // Start of the current hour
+	current   *user.StatsRollup                  // Counters for the current hour, since the last flush
+	completed []*user.StatsRollup                // Counters for completed hours, since the last flush
+	visitors  map[string]struct{}                // Distinct visitor IDs in the current hour
+	users     map[string]struct{}                // Distinct user IDs in the current hour
+	usage     map[statsUsageKey]*user.UsageStats // Usage per day, user and topic, since the last flush
+	mu        sync.Mutex
+}
+
+type statsUsageKey struct {
+	day    int64
+	userID string
+	topic  string
+}
+
+func newStatsCollector() *statsCollector {
+	c := &statsCollector{
+		completed: make([]*user.StatsRollup, 0),
+		usage:     make(map[statsUsageKey]*user.UsageStats),
+	}
+	c.resetNoLock(time.Now())
+	return c
+}
+
+// Visit records a request by the given visitor
+func (c *statsCollector) Visit(v *visitor) {
+	if c == nil {
+		return
+	}
+	ip, u := v.IP(), v.User()
+	id := visitorID(ip, u, v.config)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maybeRotateNoLock(time.Now())
+	c.visitors[id] = struct{}{}
+	if u != nil {
+		c.users[u.ID] = struct{}{}
+	}
+}
+
+// AddMessage records a published message
+func (c *statsCollector) AddMessage() {
+	c.add(func(r *user.StatsRollup) { r.Messages++ })
+}
+
+// AddEmail records a sent email
+func (c *statsCollector) AddEmail() {
+	c.add(func(r *user.StatsRollup) { r.Emails++ })
+}
+
+// AddCall records a phone call
+func (c *statsCollector) AddCall() {
+	c.add(func(r *user.StatsRollup) { r.Calls++ })
+}
+
+// AddPublish records a message published by the given visitor, including its attachments (if any)
+func (c *statsCollector) AddPublish(v *visitor, m *message) {
+	c.addUsage(v, m.Topic, func(s *user.UsageStats) {
+		s.Messages++
+		for _, a := range m.attachments() {
+			s.Attachments++
+			s.AttachmentBytes += a.Size
+		}
+	})
+}
+
+// AddSubscribe records a subscribe (or poll) request of the given visitor to the given topics
+func (c *statsCollector) AddSubscribe(v *visitor, topics []*topic) {
+	for _, t := range topics {
+		c.addUsage(v, t.ID, func(s *user.UsageStats) { s.Subscriptions++ })
+	}
+}
+
+func (c *statsCollector) addUsage(v *visitor, topic string, fn func(s *user.UsageStats)) {
+	if c == nil {
+		return
+	}
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	key := statsUsageKey{day: day.Unix(), userID: v.MaybeUserID(), topic: topic}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats, ok := c.usage[key]
+	if !ok {
+		if len(c.usage) >= statsUsageMaxEntries {
+			return
+		}
+		stats = &user.UsageStats{Day: day, UserID: key.userID, Topic: topic}
+		c.usage[key] = stats
+	}
+	fn(stats)
+}
+
+func (c *statsCollector) add(fn func(r *user.StatsRollup)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maybeRotateNoLock(time.Now())
+	fn(c.current)
+}
+
+// Flush returns the counters of all completed hours and of the current hour since the last flush, and
+// resets the counters. The distinct visitor and user counts are totals for the hour.
+func (c *statsCollector) Flush() []*user.StatsRollup {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maybeRotateNoLock(time.Now())
+	current := *c.current
+	current.Visitors, current.Users = int64(len(c.visitors)), int64(len(c.users))
+	rollups := append(c.completed, &current)
+	c.completed = make([]*user.StatsRollup, 0)
+	c.current = &user.StatsRollup{Period: user.StatsRollupPeriodHour, Start: c.hour}
+	return rollups
+}
+
+// FlushUsage returns the usage counters since the last flush, and resets them
+func (c *statsCollector) FlushUsage() []*user.UsageStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	usage := make([]*user.UsageStats, 0, len(c.usage))
+	for _, stats := range c.usage {
+		usage = append(usage, stats)
+	}
+	c.usage = make(map[statsUsageKey]*user.UsageStats)
+	return usage
+}
+
+func (c *statsCollector) maybeRotateNoLock(now time.Time) {
+	if now.Truncate(time.Hour).Equal(c.hour) {
+		return
+	}
+	c.current.Visitors, c.current.Users = int64(len(c.visitors)), int64(len(c.users))
+	c.completed = append(c.completed, c.current)
+	c.resetNoLock(now)
+}
+
+func (c *statsCollector) resetNoLock(now time.Time) {
+	c.hour = now.Truncate(time.Hour)
+	c.current = &user.StatsRollup{Period: user.StatsRollupPeriodHour, Start: c.hour}
+	c.visitors = make(map[string]struct{})
+	c.users = make(map[string]struct{})
+}
+
+// writeStatsRollups writes the collected stats to the hourly rollups, aggregates them into daily rollups,
+// and removes rollups that are older than the configured retention
+func (s *Server) writeStatsRollups() {
+	if s.userManager == nil || s.statsCollector == nil {
+		return
+	}
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			for _, rollup := range s.statsCollector.Flush() {
+				if err := s.userManager.AddStatsRollup(rollup.Start, rollup); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error writing hourly stats rollup")
+				}
+			}
+			if err := s.userManager.AddUsageStats(s.statsCollector.FlushUsage()); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error writing usage stats")
+			}
+			since := time.Now().Add(-24 * time.Hour) // Yesterday and today, older days are complete
+			if err := s.userManager.RollupStats(since, s.config.Load().VisitorStatsHourlyRetention, s.config.Load().VisitorStatsDailyRetention); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error writing daily stats rollups")
+			}
+		}).
+		Debug("Wrote stats rollups")
+}
+
+// handleStatsRollups returns the hourly or daily stats rollups (?period=hour|day), starting at the time
+// given in the "since" parameter (duration or Unix timestamp, defaults to 7 days)
+func (s *Server) handleStatsRollups(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	period := user.StatsRollupPeriod(readQueryParam(r, "period"))
+	if period == "" {
+		period = user.StatsRollupPeriodHour
+	} else if period != user.StatsRollupPeriodHour && period != user.StatsRollupPeriodDay {
+		return errHTTPBadRequestStatsRollupPeriodInvalid
+	}
+	since, err := readStatsSince(r)
+	if err != nil {
+		return err
+	}
+	rollups, err := s.userManager.StatsRollups(period, since)
+	if err != nil {
+		return err
+	}
+	response := &apiStatsRollupsResponse{
+		Period:  string(period),
+		Rollups: make([]*apiStatsRollup, len(rollups)),
+	}
+	for i, rollup := range rollups {
+		response.Rollups[i] = &apiStatsRollup{
+			Start:    rollup.Start.Unix(),
+			Messages: rollup.Messages,
+			Emails:   rollup.Emails,
+			Calls:    rollup.Calls,
+			Visitors: rollup.Visitors,
+			Users:    rollup.Users,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAccountStats returns the daily usage stats of the current user per topic, starting at the time
+// given in the "since" parameter (duration or Unix timestamp, defaults to 7 days)
+func (s *Server) handleAccountStats(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	since, err := readStatsSince(r)
+	if err != nil {
+		return err
+	}
+	stats, err := s.userManager.UserUsageStats(v.User().ID, since)
+	if err != nil {
+		return err
+	}
+	response := &apiAccountUsageStatsResponse{
+		Stats: make([]*apiUsageStats, len(stats)),
+	}
+	for i, stat := range stats {
+		response.Stats[i] = &apiUsageStats{
+			Day:             stat.Day.Unix(),
+			Topic:           stat.Topic,
+			Messages:        stat.Messages,
+			Subscriptions:   stat.Subscriptions,
+			Attachments:     stat.Attachments,
+			AttachmentBytes: stat.AttachmentBytes,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAdminStatsUsage returns the users (?by=user) or topics (?by=topic) with the highest usage, sorted by
+// the counter given in the "sort" parameter, starting at the time given in the "since" parameter
+func (s *Server) handleAdminStatsUsage(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	group := user.UsageGroup(readQueryParam(r, "by"))
+	if group == "" {
+		group = user.UsageGroupUser
+	} else if group != user.UsageGroupUser && group != user.UsageGroupTopic {
+		return errHTTPBadRequestStatsUsageInvalid
+	}
+	sort := user.UsageSort(readQueryParam(r, "sort"))
+	if sort == "" {
+		sort = user.UsageSortMessages
+	} else if sort != user.UsageSortMessages && sort != user.UsageSortSubscriptions && sort != user.UsageSortAttachments && sort != user.UsageSortAttachmentBytes {
+		return errHTTPBadRequestStatsUsageInvalid
+	}
+	limit := statsUsageDefaultLimit
+	if limitStr := readQueryParam(r, "limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > statsUsageMaxLimit {
+			return errHTTPBadRequestStatsUsageInvalid
+		}
+	}
+	since, err := readStatsSince(r)
+	if err != nil {
+		return err
+	}
+	stats, err := s.userManager.TopUsageStats(group, sort, since, limit)
+	if err != nil {
+		return err
+	}
+	response := &apiAdminUsageStatsResponse{
+		By:    string(group),
+		Sort:  string(sort),
+		Stats: make([]*apiUsageStats, len(stats)),
+	}
+	for i, stat := range stats {
+		response.Stats[i] = &apiUsageStats{
+			Username:        stat.Username,
+			Topic:           stat.Topic,
+			Messages:        stat.Messages,
+			Subscriptions:   stat.Subscriptions,
+			Attachments:     stat.Attachments,
+			AttachmentBytes: stat.AttachmentBytes,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// readStatsSince parses the "since" query parameter of the stats endpoints, which may be a Unix timestamp
+// or a duration, and defaults to 7 days
+func readStatsSince(r *http.Request) (time.Time, error) {
+	sinceStr := readQueryParam(r, "since")
+	if sinceStr == "" {
+		return time.Now().Add(-statsRollupsDefaultSince), nil
+	} else if sinceUnix, err := strconv.ParseInt(sinceStr, 10, 64); err == nil {
+		return time.Unix(sinceUnix, 0), nil
+	} else if d, err := util.ParseDuration(sinceStr); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, errHTTPBadRequestSinceInvalid
+}
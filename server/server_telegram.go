@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	telegramAPIURL          = "https://api.telegram.org"
+	telegramTimeout         = 30 * time.Second // Uploads may take a while
+	telegramMaxAttempts     = 3
+	telegramRetryDelay      = 2 * time.Second  // Used if Telegram does not send retry_after
+	telegramMaxRetryDelay   = 30 * time.Second // Never wait longer than this, even if Telegram asks for it
+	telegramTextLimit       = 4096             // Characters, see https://core.telegram.org/bots/api#sendmessage
+	telegramCaptionLimit    = 1024             // Characters, see https://core.telegram.org/bots/api#senddocument
+	telegramPhotoSizeLimit  = 10 * 1024 * 1024 // Bytes, larger images are sent as documents
+	telegramUploadSizeLimit = 50 * 1024 * 1024 // Bytes, larger attachments are linked instead
+)
+
+var (
+	telegramBotTokenRegex = regexp.MustCompile(`^[0-9]{1,20}:[-_A-Za-z0-9]{30,64}$`)
+	telegramChatIDRegex   = regexp.MustCompile(`^(-?[0-9]{1,20}|@[A-Za-z][_A-Za-z0-9]{4,31})$`)
+	telegramPhotoTypes    = []string{"image/jpeg", "image/png", "image/webp"}
+)
+
+// telegramRelay mirrors messages to Telegram chats via the Telegram Bot API (https://core.telegram.org/bots/api).
+// Chats are either configured by the admin (see telegram-relays, using telegram-bot-token), or by the owner of a
+// reserved topic with their own bot (see enable-telegram-relays and user.TopicTelegram).
+//
+// Attachments stored on this server are uploaded to Telegram, so that they also work if the server is not
+// reachable from the Internet. External attachments are passed to Telegram as URL.
+type telegramRelay struct {
+	apiURL     string // Can be replaced in tests
+	client     *http.Client
+	retryDelay time.Duration // Can be replaced in tests
+}
+
+// telegramTarget is a chat that a message is relayed to, along with the bot that sends it
+type telegramTarget struct {
+	botToken string
+	chatID   string
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"` // Seconds
+	} `json:"parameters"`
+}
+
+// telegramUpload is an attachment that is uploaded to Telegram
+type telegramUpload struct {
+	field string // "photo" or "document"
+	name  string
+	data  []byte
+}
+
+func newTelegramRelay() *telegramRelay {
+	return &telegramRelay{
+		apiURL:     telegramAPIURL,
+		client:     &http.Client{Timeout: telegramTimeout},
+		retryDelay: telegramRetryDelay,
+	}
+}
+
+// Send relays a message to the target chat. If upload is set, the attachment is uploaded along with the message.
+func (t *telegramRelay) Send(target *telegramTarget, m *message, upload *telegramUpload) error {
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := t.send(target, m, upload)
+		if err == nil {
+			return nil
+		} else if retryAfter == 0 || attempt >= telegramMaxAttempts {
+			return err
+		}
+		time.Sleep(retryAfter)
+	}
+}
+
+// send makes a single request, and returns how long to wait before retrying if the request can be retried
+func (t *telegramRelay) send(target *telegramTarget, m *message, upload *telegramUpload) (time.Duration, error) {
+	var method, contentType string
+	var body io.Reader
+	var err error
+	if upload != nil {
+		method = "send" + strings.ToUpper(upload.field[:1]) + upload.field[1:]
+		contentType, body, err = newTelegramUploadBody(target, m, upload)
+	} else {
+		params := map[string]any{
+			"chat_id":    target.chatID,
+			"parse_mode": "HTML",
+		}
+		if m.Attachment != nil {
+			method = "sendDocument"
+			params["document"] = m.Attachment.URL
+			params["caption"] = telegramText(m, telegramCaptionLimit)
+		} else {
+			method = "sendMessage"
+			params["text"] = telegramText(m, telegramTextLimit)
+		}
+		var b []byte
+		b, err = json.Marshal(params)
+		contentType, body = "application/json", bytes.NewReader(b)
+	}
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/bot%s/%s", t.apiURL, target.botToken, method), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err // The URL contains the bot token, which must not end up in the logs
+		}
+		return t.retryDelay, err
+	}
+	defer resp.Body.Close()
+	var response telegramResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jsonBodyBytesLimit)).Decode(&response); err != nil {
+		return 0, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	} else if response.OK {
+		return 0, nil
+	}
+	err = fmt.Errorf("unexpected HTTP status %s: %s", resp.Status, response.Description)
+	if resp.StatusCode == http.StatusTooManyRequests && response.Parameters.RetryAfter > 0 {
+		return min(time.Duration(response.Parameters.RetryAfter)*time.Second, telegramMaxRetryDelay), err
+	} else if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return t.retryDelay, err
+	}
+	return 0, err
+}
+
+func newTelegramUploadBody(target *telegramTarget, m *message, upload *telegramUpload) (string, io.Reader, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	for name, value := range map[string]string{"chat_id": target.chatID, "caption": telegramText(m, telegramCaptionLimit), "parse_mode": "HTML"} {
+		if err := w.WriteField(name, value); err != nil {
+			return "", nil, err
+		}
+	}
+	part, err := w.CreateFormFile(upload.field, upload.name)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := part.Write(upload.data); err != nil {
+		return "", nil, err
+	}
+	if err := w.Close(); err != nil {
+		return "", nil, err
+	}
+	return w.FormDataContentType(), &b, nil
+}
+
+// telegramText formats the message as Telegram HTML, with the title in bold, and a link to the click URL
+// (if any). The message is shortened to fit into the given limit.
+func telegramText(m *message, limit int) string {
+	var prefix, suffix string
+	if m.Title != "" {
+		prefix = "<b>" + html.EscapeString(m.Title) + "</b>\n"
+	}
+	if m.Click != "" {
+		suffix = fmt.Sprintf("\n<a href=\"%s\">Open</a>", html.EscapeString(m.Click))
+	}
+	available := limit - utf8.RuneCountInString(prefix) - utf8.RuneCountInString(suffix)
+	escaped := html.EscapeString(m.Message)
+	if utf8.RuneCountInString(escaped) <= available {
+		return prefix + escaped + suffix
+	}
+	var b strings.Builder
+	var length int
+	for _, r := range m.Message {
+		e := html.EscapeString(string(r))
+		if length+utf8.RuneCountInString(e) > available-1 {
+			break
+		}
+		b.WriteString(e)
+		length += utf8.RuneCountInString(e)
+	}
+	return prefix + b.String() + "…" + suffix
+}
+
+// relayToTelegram sends the message to all Telegram chats configured for its topic, see telegramRelay
+func (s *Server) relayToTelegram(v *visitor, m *message) {
+	if m.Event != messageEvent {
+		return
+	}
+	targets := s.telegramTargets(v, m)
+	if len(targets) == 0 {
+		return
+	} else if m.Encryption != "" || m.Encoding != "" {
+		logvm(v, m).Tag(tagTelegram).Debug("Not relaying encrypted or binary message to Telegram")
+		return
+	}
+	upload, err := s.telegramUpload(m)
+	if err != nil {
+		logvm(v, m).Tag(tagTelegram).Err(err).Warn("Unable to read attachment, relaying link to attachment instead")
+	}
+	for _, target := range targets {
+		ev := logvm(v, m).Tag(tagTelegram).Fields(log.Context{"telegram_chat_id": target.chatID})
+		if err := s.telegramRelay.Send(target, m, upload); err != nil {
+			ev.Err(err).Warn("Unable to relay message to Telegram")
+			continue
+		}
+		ev.Debug("Relayed message to Telegram")
+	}
+}
+
+// telegramTargets returns the chats configured for the topic by the admin (telegram-relays), and by the owner
+// of the topic (if enable-telegram-relays is set)
+func (s *Server) telegramTargets(v *visitor, m *message) []*telegramTarget {
+	targets := make([]*telegramTarget, 0)
+	for _, chatID := range s.config.Load().TelegramRelays[m.Topic] {
+		targets = append(targets, &telegramTarget{botToken: s.config.Load().TelegramBotToken, chatID: chatID})
+	}
+	if s.config.Load().EnableTelegramRelays && s.userManager != nil {
+		telegram, err := s.userManager.TopicTelegram(m.Topic)
+		if err != nil && !errors.Is(err, user.ErrTopicTelegramNotFound) {
+			logvm(v, m).Tag(tagTelegram).Err(err).Warn("Unable to read Telegram relay for topic")
+		} else if telegram != nil {
+			targets = append(targets, &telegramTarget{botToken: telegram.BotToken, chatID: telegram.ChatID})
+		}
+	}
+	return targets
+}
+
+// telegramUpload reads the attachment of the message, if it is stored on this server and small enough to be
+// uploaded to Telegram. Otherwise, it returns nil, and the attachment is linked (see telegramRelay.send).
+func (s *Server) telegramUpload(m *message) (*telegramUpload, error) {
+	if m.Attachment == nil || s.config.Load().BaseURL == "" || s.config.Load().AttachmentCacheDir == "" || !strings.HasPrefix(m.Attachment.URL, s.config.Load().BaseURL+"/file/") {
+		return nil, nil
+	} else if m.Attachment.Size > telegramUploadSizeLimit {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.config.Load().AttachmentCacheDir, m.ID))
+	if err != nil {
+		return nil, err
+	}
+	if s.encryption.Enabled(m.Topic) {
+		if data, err = s.encryption.Decrypt(m.Topic, data); err != nil {
+			return nil, err
+		}
+	}
+	field := "document"
+	if len(data) <= telegramPhotoSizeLimit && slices.Contains(telegramPhotoTypes, m.Attachment.Type) {
+		field = "photo"
+	}
+	return &telegramUpload{field: field, name: m.Attachment.Name, data: data}, nil
+}
+
+func (s *Server) handleAccountReservationTelegramGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	telegram, err := s.userManager.TopicTelegram(topic)
+	if errors.Is(err, user.ErrTopicTelegramNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	botID, _, _ := strings.Cut(telegram.BotToken, ":")
+	return s.writeJSON(w, &apiAccountTopicTelegram{
+		BotToken: botID + ":***", // The token is a secret, and is never returned in full
+		ChatID:   telegram.ChatID,
+	})
+}
+
+func (s *Server) handleAccountReservationTelegramChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	} else if !v.FeatureAllowed(user.TierFeatureTelegram) {
+		return errHTTPForbiddenTierFeature.Wrap("%s", user.TierFeatureTelegram)
+	}
+	req, err := readJSONWithLimit[apiAccountTopicTelegram](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !telegramBotTokenRegex.MatchString(req.BotToken) || !telegramChatIDRegex.MatchString(req.ChatID) {
+		return errHTTPBadRequestTelegramInvalid
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{"topic": topic, "telegram_chat_id": req.ChatID}).
+		Debug("Changing Telegram relay for topic %s", topic)
+	if err := s.userManager.ChangeTopicTelegram(v.User().Name, &user.TopicTelegram{
+		Topic:    topic,
+		BotToken: req.BotToken,
+		ChatID:   req.ChatID,
+	}); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationTelegramDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Removing Telegram relay for topic %s", topic)
+	if err := s.userManager.RemoveTopicTelegram(topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
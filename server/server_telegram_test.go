@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	testTelegramBotToken  = "123456789:AAEhBP0av28Ra7uFmNPQ2H3eXyZ6_abcdef"
+	testTelegramUserToken = "987654321:AAGcQx1yV7wLkRn2Tb5Ez8Wq3Mu9_uvwxyz"
+)
+
+// testTelegramAPI is a fake Telegram Bot API, recording all requests
+type testTelegramAPI struct {
+	server   *httptest.Server
+	requests []*testTelegramRequest
+	mu       sync.Mutex
+}
+
+type testTelegramRequest struct {
+	path   string
+	params map[string]string
+	file   []byte // Uploaded file, if any
+}
+
+func newTestTelegramAPI(t *testing.T) *testTelegramAPI {
+	api := &testTelegramAPI{requests: make([]*testTelegramRequest, 0)}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &testTelegramRequest{path: r.URL.Path, params: make(map[string]string)}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			require.Nil(t, r.ParseMultipartForm(10*1024*1024))
+			for name, values := range r.MultipartForm.Value {
+				req.params[name] = values[0]
+			}
+			for name, files := range r.MultipartForm.File {
+				f, err := files[0].Open()
+				require.Nil(t, err)
+				req.file, _ = io.ReadAll(f)
+				req.params[name] = files[0].Filename
+			}
+		} else {
+			var params map[string]any
+			require.Nil(t, json.NewDecoder(r.Body).Decode(&params))
+			for name, value := range params {
+				req.params[name] = value.(string)
+			}
+		}
+		api.mu.Lock()
+		api.requests = append(api.requests, req)
+		api.mu.Unlock()
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (a *testTelegramAPI) Requests() []*testTelegramRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*testTelegramRequest{}, a.requests...)
+}
+
+func TestServer_Telegram_ConfiguredRelay(t *testing.T) {
+	api := newTestTelegramAPI(t)
+	c := newTestConfig(t)
+	c.TelegramBotToken = testTelegramBotToken
+	c.TelegramRelays = map[string][]string{"alerts": {"-1001234567890"}}
+	s := newTestServer(t, c)
+	s.telegramRelay.apiURL = api.server.URL
+
+	response := request(t, s, "PUT", "/alerts", "Disk <full>", map[string]string{
+		"Title": "Backup failed",
+		"Click": "https://example.com/backups",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 200, request(t, s, "PUT", "/other", "not relayed", nil).Code)
+	require.Equal(t, 200, request(t, s, "PUT", "/alerts?channels=push", "not for telegram", nil).Code)
+
+	waitFor(t, func() bool { return len(api.Requests()) == 1 })
+	time.Sleep(200 * time.Millisecond)
+	requests := api.Requests()
+	require.Len(t, requests, 1)
+	require.Equal(t, "/bot"+testTelegramBotToken+"/sendMessage", requests[0].path)
+	require.Equal(t, "-1001234567890", requests[0].params["chat_id"])
+	require.Equal(t, "HTML", requests[0].params["parse_mode"])
+	require.Equal(t, "<b>Backup failed</b>\nDisk &lt;full&gt;\n<a href=\"https://example.com/backups\">Open</a>", requests[0].params["text"])
+}
+
+func TestServer_Telegram_Attachments(t *testing.T) {
+	api := newTestTelegramAPI(t)
+	c := newTestConfig(t)
+	c.TelegramBotToken = testTelegramBotToken
+	c.TelegramRelays = map[string][]string{"alerts": {"@mychannel"}}
+	s := newTestServer(t, c)
+	s.telegramRelay.apiURL = api.server.URL
+
+	// Local attachments are uploaded
+	response := request(t, s, "PUT", "/alerts", "some log lines", map[string]string{
+		"Filename": "backup.log",
+		"Message":  "Here's the log",
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool { return len(api.Requests()) == 1 })
+	requests := api.Requests()
+	require.Equal(t, "/bot"+testTelegramBotToken+"/sendDocument", requests[0].path)
+	require.Equal(t, "@mychannel", requests[0].params["chat_id"])
+	require.Equal(t, "Here&#39;s the log", requests[0].params["caption"])
+	require.Equal(t, "backup.log", requests[0].params["document"])
+	require.Equal(t, "some log lines", string(requests[0].file))
+
+	// External attachments are passed as URL
+	response = request(t, s, "PUT", "/alerts", "Look at this", map[string]string{
+		"Attach": "https://example.com/cat.jpg",
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool { return len(api.Requests()) == 2 })
+	requests = api.Requests()
+	require.Equal(t, "/bot"+testTelegramBotToken+"/sendDocument", requests[1].path)
+	require.Equal(t, "https://example.com/cat.jpg", requests[1].params["document"])
+	require.Equal(t, "Look at this", requests[1].params["caption"])
+}
+
+func TestServer_Telegram_ReservedTopic(t *testing.T) {
+	api := newTestTelegramAPI(t)
+	c := newTestConfigWithAuthFile(t)
+	c.EnableReservations = true
+	c.EnableTelegramRelays = true
+	c.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, c)
+	s.telegramRelay.apiURL = api.server.URL
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionReadWrite))
+
+	// Not configured yet
+	response := request(t, s, "GET", "/v1/account/reservation/mytopic/telegram", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	// Invalid settings, and non-owners
+	for _, body := range []string{`{"bot_token":"invalid","chat_id":"-100123"}`, `{"bot_token":"` + testTelegramUserToken + `","chat_id":"abc"}`} {
+		response = request(t, s, "PUT", "/v1/account/reservation/mytopic/telegram", body, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40070, toHTTPError(t, response.Body.String()).Code)
+	}
+	body := `{"bot_token":"` + testTelegramUserToken + `","chat_id":"-100123"}`
+	response = request(t, s, "PUT", "/v1/account/reservation/mytopic/telegram", body, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Configure, token is masked when reading
+	response = request(t, s, "PUT", "/v1/account/reservation/mytopic/telegram", body, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/telegram", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	telegram, _ := util.UnmarshalJSON[apiAccountTopicTelegram](io.NopCloser(response.Body))
+	require.Equal(t, "987654321:***", telegram.BotToken)
+	require.Equal(t, "-100123", telegram.ChatID)
+
+	// Messages are relayed with the owner's bot
+	require.Equal(t, 200, request(t, s, "PUT", "/mytopic", "hi there", nil).Code)
+	waitFor(t, func() bool { return len(api.Requests()) == 1 })
+	requests := api.Requests()
+	require.Equal(t, "/bot"+testTelegramUserToken+"/sendMessage", requests[0].path)
+	require.Equal(t, "-100123", requests[0].params["chat_id"])
+	require.Equal(t, "hi there", requests[0].params["text"])
+
+	// Remove
+	response = request(t, s, "DELETE", "/v1/account/reservation/mytopic/telegram", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	_, err := s.userManager.TopicTelegram("mytopic")
+	require.Equal(t, user.ErrTopicTelegramNotFound, err)
+}
+
+func TestServer_Telegram_RetryAfterRateLimit(t *testing.T) {
+	var count atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer api.Close()
+
+	relay := newTelegramRelay()
+	relay.apiURL = api.URL
+	require.Nil(t, relay.Send(&telegramTarget{botToken: testTelegramBotToken, chatID: "-100123"}, newDefaultMessage("alerts", "hi"), nil))
+	require.Equal(t, int32(2), count.Load())
+}
+
+func TestServer_Telegram_ErrorDoesNotContainToken(t *testing.T) {
+	relay := newTelegramRelay()
+	relay.apiURL = "http://127.0.0.1:1" // Nothing is listening here
+	relay.retryDelay = time.Millisecond
+	err := relay.Send(&telegramTarget{botToken: testTelegramBotToken, chatID: "-100123"}, newDefaultMessage("alerts", "hi"), nil)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), testTelegramBotToken)
+}
+
+func TestTelegramText_Truncate(t *testing.T) {
+	m := newDefaultMessage("alerts", strings.Repeat("<", 100))
+	m.Title = "Title"
+	text := telegramText(m, 50)
+	require.Equal(t, "<b>Title</b>\n"+strings.Repeat("&lt;", 9)+"…", text)
+	require.LessOrEqual(t, len([]rune(text)), 50)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/rand"
 	_ "embed"
@@ -11,6 +12,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"heckel.io/ntfy/v2/user"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -23,6 +25,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
@@ -100,6 +103,124 @@ func TestServer_PublishWithoutFirebase(t *testing.T) {
 	require.Equal(t, 0, len(sender.Messages()))
 }
 
+func TestServer_PublishWithChannels(t *testing.T) {
+	sender := newTestFirebaseSender(10)
+	mailer := &testMailer{}
+	s := newTestServer(t, newTestConfig(t))
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+	s.setEmailSender(mailer)
+
+	// Only e-mail, no Firebase
+	response := request(t, s, "PUT", "/mytopic", "email only", map[string]string{
+		"X-Channels": "email",
+		"X-Email":    "test@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Only Firebase, e-mail is dropped
+	response = request(t, s, "PUT", "/mytopic", "push only", map[string]string{
+		"X-Channels": "push",
+		"X-Email":    "test@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// No additional channels, only subscribers and cache
+	response = request(t, s, "PUT", "/mytopic?channels=none", "subscribers only", map[string]string{
+		"X-Email": "test@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+
+	time.Sleep(100 * time.Millisecond) // Firebase and e-mail publishing happens
+	require.Equal(t, 1, len(sender.Messages()))
+	require.Equal(t, "push only", sender.Messages()[0].Data["message"])
+	require.Equal(t, 1, mailer.Count())
+
+	// All messages are still delivered to subscribers
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 3, len(messages))
+}
+
+func TestServer_PublishWithChannels_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"X-Channels": "push,carrier-pigeon",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40050, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"X-Channels": "none,email",
+	})
+	require.Equal(t, 40050, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishWithChannels_RequiresAuth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"X-Channels": "push",
+	})
+	require.Equal(t, 40051, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"X-Channels":    "push",
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_PublishEmail_SubscriptionDeliveryPrefs(t *testing.T) {
+	mailer := &testMailer{}
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	s.setEmailSender(mailer)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	response := request(t, s, "POST", "/v1/account/subscription", `{"base_url":"http://127.0.0.1:12345","topic":"mytopic","delivery":{"email":{"enabled":false}}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "no email", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-Email":       "phil@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/othertopic", "email", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-Email":       "phil@example.com",
+	})
+	require.Equal(t, 200, response.Code)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 1, mailer.Count())
+}
+
+func TestServer_PublishDelayedWithChannels(t *testing.T) {
+	sender := newTestFirebaseSender(10)
+	s := newTestServer(t, newTestConfig(t))
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+
+	response := request(t, s, "PUT", "/mytopic", "delayed, no push", map[string]string{
+		"X-Channels": "webpush",
+		"X-Delay":    "10s",
+	})
+	require.Equal(t, 200, response.Code)
+
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, true)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, []string{"webpush"}, messages[0].Channels)
+
+	require.Nil(t, s.sendDelayedMessage(s.visitor(netip.MustParseAddr("9.9.9.9"), nil), messages[0]))
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 0, len(sender.Messages()))
+}
+
 func TestServer_PublishWithFirebase_WithoutUsers_AndWithoutPanic(t *testing.T) {
 	// This tests issue #641, which used to panic before the fix
 
@@ -280,6 +401,59 @@ func TestServer_WebEnabled(t *testing.T) {
 	rr = request(t, s2, "GET", "/app.html", "", nil)
 	require.Equal(t, 200, rr.Code)
 }
+
+func TestServer_StaticCaching(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	rr := request(t, s, "GET", "/static/css/home.css", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "public, max-age=3600", rr.Header().Get("Cache-Control"))
+	etag := rr.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rr = request(t, s, "GET", "/static/css/home.css", "", map[string]string{"If-None-Match": etag})
+	require.Equal(t, 304, rr.Code)
+
+	rr = request(t, s, "GET", "/sw.js", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+
+	rr = request(t, s, "GET", "/app.html", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+
+	conf := newTestConfig(t)
+	conf.WebStaticCacheControl = ""
+	s = newTestServer(t, conf)
+	rr = request(t, s, "GET", "/static/css/home.css", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "", rr.Header().Get("Cache-Control"))
+}
+
+func TestServer_WebConfig_Branding(t *testing.T) {
+	c := newTestConfigWithWebPush(t)
+	c.WebAppName = "Acme Alerts"
+	c.WebAppLogoURL = "/static/acme.svg"
+	c.WebAppAccentColor = "#ff6600"
+	c.WebAppTermsURL = "https://acme.example.com/terms"
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "GET", "/config.js", "", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Contains(t, rr.Body.String(), `"app_name": "Acme Alerts"`)
+	require.Contains(t, rr.Body.String(), `"app_logo_url": "/static/acme.svg"`)
+	require.Contains(t, rr.Body.String(), `"app_accent_color": "#ff6600"`)
+	require.Contains(t, rr.Body.String(), `"app_terms_url": "https://acme.example.com/terms"`)
+
+	rr = request(t, s, "GET", "/manifest.webmanifest", "", nil)
+	require.Equal(t, 200, rr.Code)
+	var manifest webManifestResponse
+	require.Nil(t, json.NewDecoder(rr.Body).Decode(&manifest))
+	require.Equal(t, "Acme Alerts", manifest.Name)
+	require.Equal(t, "Acme Alerts", manifest.ShortName)
+	require.Equal(t, "#ff6600", manifest.ThemeColor)
+}
+
 func TestServer_PublishLargeMessage(t *testing.T) {
 	c := newTestConfig(t)
 	c.AttachmentCacheDir = "" // Disable attachments
@@ -508,6 +682,84 @@ func TestServer_PublishAtAndPrune(t *testing.T) {
 	time.Sleep(time.Second) // FIXME CI failing not sure why
 }
 
+func TestServer_PublishExpiresAndPrune(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	subscribeRR := newSyncResponseRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+
+	response := request(t, s, "PUT", "/mytopic", "your code is 123456", map[string]string{
+		"X-Expires": "10m",
+	})
+	require.Equal(t, 200, response.Code)
+	m1 := toMessage(t, response.Body.String())
+	require.True(t, m1.Expires > time.Now().Add(10*time.Minute-time.Minute).Unix())
+	require.True(t, m1.Expires < time.Now().Add(10*time.Minute+time.Minute).Unix())
+
+	response = request(t, s, "PUT", "/mytopic?expires=5m", "some attachment", map[string]string{
+		"Filename": "code.txt",
+	})
+	require.Equal(t, 200, response.Code)
+	m2 := toMessage(t, response.Body.String())
+	require.Equal(t, m2.Expires, m2.Attachment.Expires) // Attachment does not outlive the message
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m2.ID))
+
+	response = request(t, s, "PUT", "/mytopic", "regular message", nil)
+	require.Equal(t, 200, response.Code)
+	m3 := toMessage(t, response.Body.String())
+
+	// Fake expiry, and fire pruning
+	_, err := s.messageCache.db.Exec(`UPDATE messages SET expires = ? WHERE mid IN (?, ?)`, time.Now().Add(-time.Second).Unix(), m1.ID, m2.ID)
+	require.Nil(t, err)
+	s.execManager()
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m3.ID, messages[0].ID)
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m2.ID))
+
+	waitFor(t, func() bool {
+		return len(toMessages(t, subscribeRR.Body())) == 6
+	})
+	subscribeCancel()
+	deleted := make([]string, 0)
+	for _, m := range toMessages(t, subscribeRR.Body()) {
+		if m.Event == messageDeletedEvent {
+			deleted = append(deleted, m.ID)
+		}
+	}
+	require.ElementsMatch(t, []string{m1.ID, m2.ID}, deleted)
+}
+
+func TestServer_PublishExpiresInvalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	for _, expires := range []string{"INVALID", "0s", "13h", "99999h"} {
+		response := request(t, s, "PUT", "/mytopic", "a message", map[string]string{
+			"X-Expires": expires,
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40084, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	response := request(t, s, "PUT", "/mytopic", "a message", map[string]string{
+		"X-Expires": "10m",
+		"Cache":     "no",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40085, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishExpiresWithDelay(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/", `{"topic":"mytopic","message":"a message","delay":"1h","expires":"30m"}`, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, m.Time+30*60, m.Expires) // Relative to the delivery time
+}
+
 func TestServer_PublishAndMultiPoll(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 
@@ -673,7 +925,7 @@ func TestServer_PublishMessageInHeaderWithNewlines(t *testing.T) {
 
 func TestServer_PublishInvalidTopic(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
-	s.smtpSender = &testMailer{}
+	s.setEmailSender(&testMailer{})
 	response := request(t, s, "PUT", "/docs", "fail", nil)
 	require.Equal(t, 40010, toHTTPError(t, response.Body.String()).Code)
 }
@@ -747,6 +999,60 @@ func TestServer_PollWithQueryFilters(t *testing.T) {
 	}
 }
 
+func TestServer_SubscribeWS_Compression(t *testing.T) {
+	t.Parallel()
+	for _, enabled := range []bool{false, true} {
+		c := newTestConfig(t)
+		c.WebSocketCompression = enabled
+		s := newTestServer(t, c)
+		httpServer := httptest.NewServer(http.HandlerFunc(s.handle))
+
+		var bytesRead atomic.Int64
+		dialer := &websocket.Dialer{
+			EnableCompression: true,
+			NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				return &testCountingConn{Conn: conn, read: &bytesRead}, nil
+			},
+		}
+		conn, resp, err := dialer.Dial(strings.Replace(httpServer.URL, "http://", "ws://", 1)+"/mytopic/ws", nil)
+		require.Nil(t, err)
+		require.Equal(t, enabled, strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate"))
+
+		_, b, err := conn.ReadMessage()
+		require.Nil(t, err)
+		require.Equal(t, openEvent, toMessage(t, string(b)).Event)
+
+		content := strings.Repeat("all work and no play makes jack a dull boy, ", 50) + "!" // Compresses well
+		before := bytesRead.Load()
+		request(t, s, "PUT", "/mytopic", content, nil)
+		_, b, err = conn.ReadMessage()
+		require.Nil(t, err)
+		require.Equal(t, content, toMessage(t, string(b)).Message)
+		if enabled {
+			require.Less(t, bytesRead.Load()-before, int64(500))
+		} else {
+			require.Greater(t, bytesRead.Load()-before, int64(len(content)))
+		}
+		conn.Close()
+		httpServer.Close()
+	}
+}
+
+type testCountingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c *testCountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
 func TestServer_SubscribeWithQueryFilters(t *testing.T) {
 	t.Parallel()
 	c := newTestConfig(t)
@@ -914,6 +1220,46 @@ func TestServer_Auth_ViaQuery(t *testing.T) {
 	require.Equal(t, 401, response.Code)
 }
 
+func TestServer_Auth_Webhook(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username   string `json:"username"`
+			Topic      string `json:"topic"`
+			Permission string `json:"permission"`
+		}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Topic == "acl-topic" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		allow := req.Username == "ben" && strings.HasPrefix(req.Topic, "ben-")
+		w.Write([]byte(fmt.Sprintf(`{"allow":%t}`, allow)))
+	}))
+	defer webhook.Close()
+
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionReadWrite
+	c.AuthWebhookURL = webhook.URL
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	response := request(t, s, "PUT", "/ben-alerts", "test", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/phil-alerts", "test", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "PUT", "/ben-alerts", "test", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "PUT", "/acl-topic", "test", nil) // Default access applies
+	require.Equal(t, 200, response.Code)
+}
+
 func TestServer_Auth_NonBasicHeader(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithAuthFile(t))
 
@@ -1043,7 +1389,7 @@ func TestServer_StatsResetter_MessageLimiter_EmailsLimiter(t *testing.T) {
 
 	c := newTestConfigWithAuthFile(t)
 	s := newTestServer(t, c)
-	s.smtpSender = &testMailer{}
+	s.setEmailSender(&testMailer{})
 
 	// Publish some messages, and check stats
 	for i := 0; i < 3; i++ {
@@ -1255,7 +1601,7 @@ func TestServer_PublishTooManyRequests_ShortReplenish(t *testing.T) {
 
 func TestServer_PublishTooManyEmails_Defaults(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
-	s.smtpSender = &testMailer{}
+	s.setEmailSender(&testMailer{})
 	for i := 0; i < 16; i++ {
 		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
 			"E-Mail": "test@example.com",
@@ -1273,7 +1619,7 @@ func TestServer_PublishTooManyEmails_Replenish(t *testing.T) {
 	c := newTestConfig(t)
 	c.VisitorEmailLimitReplenish = 500 * time.Millisecond
 	s := newTestServer(t, c)
-	s.smtpSender = &testMailer{}
+	s.setEmailSender(&testMailer{})
 	for i := 0; i < 16; i++ {
 		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
 			"E-Mail": "test@example.com",
@@ -1299,7 +1645,7 @@ func TestServer_PublishTooManyEmails_Replenish(t *testing.T) {
 
 func TestServer_PublishDelayedEmail_Fail(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
-	s.smtpSender = &testMailer{}
+	s.setEmailSender(&testMailer{})
 	response := request(t, s, "PUT", "/mytopic", "fail", map[string]string{
 		"E-Mail": "test@example.com",
 		"Delay":  "20 min",
@@ -1630,6 +1976,84 @@ func TestServer_PublishMarkdown_NotMarkdown(t *testing.T) {
 	require.Equal(t, "", m.ContentType)
 }
 
+func TestServer_PublishEncrypted(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	ciphertext := "bm90IHJlYWxseSBlbmNyeXB0ZWQsIGJ1dCBjbG9zZSBlbm91Z2g"
+	response := request(t, s, "PUT", "/mytopic", ciphertext, map[string]string{
+		"X-Encryption": "aes256gcm",
+		"X-Priority":   "4",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, ciphertext, m.Message)
+	require.Equal(t, "aes256gcm", m.Encryption)
+	require.Equal(t, 4, m.Priority)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, ciphertext, m.Message)
+	require.Equal(t, "aes256gcm", m.Encryption)
+
+	// Publishing as JSON
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"c2Vjb25k","encryption":"aes256gcm"}`, nil)
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "c2Vjb25k", m.Message)
+	require.Equal(t, "aes256gcm", m.Encryption)
+}
+
+func TestServer_PublishEncrypted_Invalid(t *testing.T) {
+	c := newTestConfig(t)
+	c.MessageSizeLimit = 100
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "ciphertext", map[string]string{"X-Encryption": "not valid!"})
+	require.Equal(t, 40057, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "ciphertext", map[string]string{"X-Encryption": "aes256gcm", "X-Template": "yes"})
+	require.Equal(t, 40058, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "ciphertext", map[string]string{"X-Encryption": "aes256gcm", "X-Filename": "file.txt"})
+	require.Equal(t, 40058, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{"X-Encryption": "aes256gcm"})
+	require.Equal(t, 40059, toHTTPError(t, response.Body.String()).Code)
+
+	// Ciphertext is never truncated or turned into an attachment
+	response = request(t, s, "PUT", "/mytopic", strings.Repeat("a", 101), map[string]string{"X-Encryption": "aes256gcm"})
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41304, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishSigned(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	signature := "t=1760572800,s=" + strings.Repeat("a", 85) + "Q"
+	response := request(t, s, "PUT", "/mytopic", "deployed", map[string]string{
+		"X-Signature": signature,
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, signature, m.Signature)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "deployed", m.Message)
+	require.Equal(t, signature, m.Signature)
+
+	// Publishing as JSON
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"second","signature":"`+signature+`"}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, signature, toMessage(t, response.Body.String()).Signature)
+
+	// Invalid signatures
+	response = request(t, s, "PUT", "/mytopic", "message", map[string]string{"X-Signature": "not valid!"})
+	require.Equal(t, 40076, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", "message", map[string]string{"X-Signature": signature, "X-Template": "yes"})
+	require.Equal(t, 40077, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "PUT", "/mytopic", "message", map[string]string{"X-Signature": signature, "X-Filename": "file.txt"})
+	require.Equal(t, 40077, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_PublishAsJSON(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 	body := `{"topic":"mytopic","message":"A message","title":"a title\nwith lines","tags":["tag1","tag 2"],` +
@@ -1686,7 +2110,7 @@ func TestServer_PublishAsJSON_WithEmail(t *testing.T) {
 	t.Parallel()
 	mailer := &testMailer{}
 	s := newTestServer(t, newTestConfig(t))
-	s.smtpSender = mailer
+	s.setEmailSender(mailer)
 	body := `{"topic":"mytopic","message":"A message","email":"phil@example.com"}`
 	response := request(t, s, "PUT", "/", body, nil)
 	require.Equal(t, 200, response.Code)
@@ -1805,6 +2229,60 @@ func TestServer_PublishWithTierBasedMessageLimitAndExpiry(t *testing.T) {
 	require.Empty(t, response.Body)
 }
 
+func TestServer_PublishWithTierFeatures(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.EnableReservations = true
+	s := newTestServer(t, c)
+
+	// Create tier without templates and reservations, but with a longer max. delay
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "test",
+		MessageLimit:     100,
+		ReservationLimit: 5,
+		Features: user.TierFeatures{
+			user.TierFeatureTemplates:    "false",
+			user.TierFeatureReservations: "false",
+			user.TierFeatureMaxDelay:     "30d",
+		},
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "test"))
+
+	response := request(t, s, "PUT", "/mytopic", `{"a":"b"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Template":      "yes",
+		"Message":       "{{.a}}",
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/v1/account/reservation", `{"topic":"mytopic","everyone":"deny-all"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+
+	// Delay beyond the server-wide max. delay (3 days), but within the tier's max. delay
+	response = request(t, s, "PUT", "/mytopic", "later", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Delay":         "10d",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "too late", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Delay":         "31d",
+	})
+	require.Equal(t, 400, response.Code)
+
+	// Anonymous users are not affected by tier features
+	response = request(t, s, "PUT", "/mytopic", `{"a":"b"}`, map[string]string{
+		"Template": "yes",
+		"Message":  "{{.a}}",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "b", toMessage(t, response.Body.String()).Message)
+}
+
 func TestServer_PublishAttachment(t *testing.T) {
 	content := "text file!" + util.RandomString(4990) // > 4096
 	s := newTestServer(t, newTestConfig(t))
@@ -1816,7 +2294,7 @@ func TestServer_PublishAttachment(t *testing.T) {
 	require.GreaterOrEqual(t, msg.Attachment.Expires, time.Now().Add(179*time.Minute).Unix()) // Almost 3 hours
 	require.Contains(t, msg.Attachment.URL, "http://127.0.0.1:12345/file/")
 	require.Equal(t, netip.Addr{}, msg.Sender) // Should never be returned
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, msg.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID))
 
 	// GET
 	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
@@ -1837,22 +2315,101 @@ func TestServer_PublishAttachment(t *testing.T) {
 	require.Equal(t, int64(5000), size)
 }
 
-func TestServer_PublishAttachmentShortWithFilename(t *testing.T) {
-	c := newTestConfig(t)
-	c.BehindProxy = true
+func TestServer_PublishAttachment_Encrypted(t *testing.T) {
+	content := "secret file!" + util.RandomString(4988) // > 4096
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.CacheEncryptionKey = []byte("01234567890123456789012345678901")
+	c.CacheEncryptedTopics = []string{"secret"}
 	s := newTestServer(t, c)
-	content := "this is an ATTACHMENT"
-	response := request(t, s, "PUT", "/mytopic?f=myfile.txt", content, map[string]string{
-		"X-Forwarded-For": "1.2.3.4",
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	response := request(t, s, "PUT", "/secret", content, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
 	})
 	msg := toMessage(t, response.Body.String())
-	require.Equal(t, "myfile.txt", msg.Attachment.Name)
-	require.Equal(t, "text/plain; charset=utf-8", msg.Attachment.Type)
-	require.Equal(t, int64(21), msg.Attachment.Size)
-	require.GreaterOrEqual(t, msg.Attachment.Expires, time.Now().Add(3*time.Hour).Unix())
-	require.Contains(t, msg.Attachment.URL, "http://127.0.0.1:12345/file/")
-	require.Equal(t, netip.Addr{}, msg.Sender) // Should never be returned
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, msg.ID))
+	require.Equal(t, int64(5000), msg.Attachment.Size)
+
+	// File is encrypted on disk
+	stored, err := os.ReadFile(filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID))
+	require.Nil(t, err)
+	require.NotContains(t, string(stored), "secret file!")
+
+	// GET and HEAD return the plaintext, but only to authorized users
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "5000", response.Header().Get("Content-Length"))
+	require.Equal(t, content, response.Body.String())
+
+	response = request(t, s, "HEAD", path, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "5000", response.Header().Get("Content-Length"))
+
+	// Range requests work on the plaintext
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Range":         "bytes=0-11",
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, "bytes 0-11/5000", response.Header().Get("Content-Range"))
+	require.Equal(t, "secret file!", response.Body.String())
+
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 403, response.Code)
+}
+
+func TestServer_PublishMessage_Encrypted(t *testing.T) {
+	c := newTestConfig(t)
+	c.CacheEncryptionKey = []byte("01234567890123456789012345678901")
+	c.CacheEncryptedTopics = []string{"secret"}
+	s := newTestServer(t, c)
+
+	request(t, s, "PUT", "/secret", "top secret message", map[string]string{"Title": "top secret title"})
+	request(t, s, "PUT", "/public", "public message", nil)
+
+	// Message and title are encrypted in the database
+	rows, err := s.messageCache.db.Query(`SELECT message, title FROM messages WHERE topic = 'secret'`)
+	require.Nil(t, err)
+	require.True(t, rows.Next())
+	var storedMessage, storedTitle string
+	require.Nil(t, rows.Scan(&storedMessage, &storedTitle))
+	require.Nil(t, rows.Close())
+	require.True(t, strings.HasPrefix(storedMessage, encryptedPrefix))
+	require.True(t, strings.HasPrefix(storedTitle, encryptedPrefix))
+	require.NotContains(t, storedMessage, "secret")
+
+	// Reads are decrypted
+	response := request(t, s, "GET", "/secret/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "top secret message", messages[0].Message)
+	require.Equal(t, "top secret title", messages[0].Title)
+
+	response = request(t, s, "GET", "/public/json?poll=1", "", nil)
+	require.Equal(t, "public message", toMessages(t, response.Body.String())[0].Message)
+}
+
+func TestServer_PublishAttachmentShortWithFilename(t *testing.T) {
+	c := newTestConfig(t)
+	c.BehindProxy = true
+	s := newTestServer(t, c)
+	content := "this is an ATTACHMENT"
+	response := request(t, s, "PUT", "/mytopic?f=myfile.txt", content, map[string]string{
+		"X-Forwarded-For": "1.2.3.4",
+	})
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, "myfile.txt", msg.Attachment.Name)
+	require.Equal(t, "text/plain; charset=utf-8", msg.Attachment.Type)
+	require.Equal(t, int64(21), msg.Attachment.Size)
+	require.GreaterOrEqual(t, msg.Attachment.Expires, time.Now().Add(3*time.Hour).Unix())
+	require.Contains(t, msg.Attachment.URL, "http://127.0.0.1:12345/file/")
+	require.Equal(t, netip.Addr{}, msg.Sender) // Should never be returned
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID))
 
 	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
 	response = request(t, s, "GET", path, "", nil)
@@ -1886,6 +2443,42 @@ func TestServer_PublishAttachmentExternalWithoutFilename(t *testing.T) {
 	require.Equal(t, int64(0), size)
 }
 
+func TestServer_PublishAttachmentExternalMultiple(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic?attach=https://example.com/a.jpg&attach=https://example.com/b.pdf&filename=first.jpg", "Two files", nil)
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, "Two files", msg.Message)
+	require.Equal(t, "first.jpg", msg.Attachment.Name)
+	require.Equal(t, "https://example.com/a.jpg", msg.Attachment.URL)
+	require.Equal(t, 2, len(msg.Attachments))
+	require.Equal(t, msg.Attachment, msg.Attachments[0])
+	require.Equal(t, "b.pdf", msg.Attachments[1].Name)
+	require.Equal(t, "https://example.com/b.pdf", msg.Attachments[1].URL)
+
+	// JSON publishing, and attachments are returned when polling
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","attach":"https://example.com/c.png","attachments":["https://example.com/d.png"]}`, nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, 2, len(messages[0].Attachments))
+	require.Equal(t, "https://example.com/b.pdf", messages[0].Attachments[1].URL)
+	require.Equal(t, "c.png", messages[1].Attachment.Name)
+	require.Equal(t, "d.png", messages[1].Attachments[1].Name)
+
+	// A single attachment does not have the attachments list, for compatibility
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Attach": "https://example.com/a.jpg",
+	})
+	require.Equal(t, 200, response.Code)
+	require.NotContains(t, response.Body.String(), `"attachments"`)
+
+	response = request(t, s, "PUT", "/mytopic?attach=https://example.com/a.jpg"+strings.Repeat("&attach=https://example.com/a.jpg", 10), "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40089, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_PublishAttachmentExternalWithFilename(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 	response := request(t, s, "PUT", "/mytopic", "This is a custom message", map[string]string{
@@ -1979,7 +2572,7 @@ func TestServer_PublishAttachmentAndExpire(t *testing.T) {
 	response := request(t, s, "PUT", "/mytopic", content, nil)
 	msg := toMessage(t, response.Body.String())
 	require.Contains(t, msg.Attachment.URL, "http://127.0.0.1:12345/file/")
-	file := filepath.Join(s.config.AttachmentCacheDir, msg.ID)
+	file := filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID)
 	require.FileExists(t, file)
 
 	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
@@ -1996,6 +2589,53 @@ func TestServer_PublishAttachmentAndExpire(t *testing.T) {
 	require.Equal(t, 404, response.Code)
 }
 
+func TestServer_PublishAttachmentWithExpiryRules(t *testing.T) {
+	t.Parallel()
+	c := newTestConfig(t)
+	c.AttachmentExpiryRules = []*AttachmentExpiryRule{
+		{Pattern: ".log", Duration: 12 * time.Hour},
+		{Pattern: "image/*", Duration: 72 * time.Hour},
+	}
+	s := newTestServer(t, c)
+
+	// Matches extension
+	response := request(t, s, "PUT", "/mytopic", util.RandomString(5000), map[string]string{
+		"Filename": "app.LOG",
+	})
+	logMsg := toMessage(t, response.Body.String())
+	require.Equal(t, "app.LOG", logMsg.Attachment.Name)
+	require.InDelta(t, time.Now().Add(12*time.Hour).Unix(), logMsg.Attachment.Expires, 5)
+
+	// Matches MIME type wildcard
+	response = request(t, s, "PUT", "/mytopic", "\x89PNG\r\n\x1a\n"+util.RandomString(5000), nil)
+	msg := toMessage(t, response.Body.String())
+	require.Equal(t, "image/png", msg.Attachment.Type)
+	require.InDelta(t, time.Now().Add(72*time.Hour).Unix(), msg.Attachment.Expires, 5)
+
+	// No match, default expiry
+	response = request(t, s, "PUT", "/mytopic", util.RandomString(5000), nil)
+	msg = toMessage(t, response.Body.String())
+	require.Equal(t, "attachment.txt", msg.Attachment.Name)
+	require.InDelta(t, time.Now().Add(3*time.Hour).Unix(), msg.Attachment.Expires, 5)
+
+	// Scheduled messages must not be delivered after the attachment expired
+	response = request(t, s, "PUT", "/mytopic", util.RandomString(5000), map[string]string{
+		"Filename": "app.log",
+		"Delay":    "13h",
+	})
+	require.Equal(t, 40015, toHTTPError(t, response.Body.String()).Code)
+
+	// Shortening a rule applies to existing attachments
+	s.config.Load().AttachmentExpiryRules[0].Duration = time.Millisecond
+	file := filepath.Join(s.config.Load().AttachmentCacheDir, logMsg.ID)
+	require.FileExists(t, file)
+	waitFor(t, func() bool {
+		s.execManager() // May run many times
+		return !util.FileExists(file)
+	})
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID))
+}
+
 func TestServer_PublishAttachmentWithTierBasedExpiry(t *testing.T) {
 	t.Parallel()
 	content := util.RandomString(5000) // > 4096
@@ -2027,7 +2667,7 @@ func TestServer_PublishAttachmentWithTierBasedExpiry(t *testing.T) {
 	require.Contains(t, msg.Attachment.URL, "http://127.0.0.1:12345/file/")
 	require.True(t, msg.Attachment.Expires > time.Now().Add(sevenDays-30*time.Second).Unix())
 	require.True(t, msg.Expires > time.Now().Add(sevenDays-30*time.Second).Unix())
-	file := filepath.Join(s.config.AttachmentCacheDir, msg.ID)
+	file := filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID)
 	require.FileExists(t, file)
 
 	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
@@ -2105,7 +2745,7 @@ func TestServer_PublishAttachmentWithTierBasedLimits(t *testing.T) {
 	response := request(t, s, "PUT", "/mytopic", smallFile, nil)
 	msg := toMessage(t, response.Body.String())
 	require.Contains(t, msg.Attachment.URL, "http://127.0.0.1:12345/file/")
-	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, msg.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID))
 
 	// Publish large file as anonymous
 	response = request(t, s, "PUT", "/mytopic", largeFile, nil)
@@ -2127,7 +2767,7 @@ func TestServer_PublishAttachmentWithTierBasedLimits(t *testing.T) {
 		require.Equal(t, 200, response.Code)
 		msg = toMessage(t, response.Body.String())
 		require.Contains(t, msg.Attachment.URL, "http://127.0.0.1:12345/file/")
-		require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, msg.ID))
+		require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, msg.ID))
 	}
 	response = request(t, s, "PUT", "/mytopic", largeFile, map[string]string{
 		"Authorization": util.BasicAuth("phil", "phil"),
@@ -2184,6 +2824,100 @@ func TestServer_PublishAttachmentBandwidthLimitUploadOnly(t *testing.T) {
 	require.Equal(t, 41301, err.Code)
 }
 
+func TestServer_PublishAttachmentRangeRequest(t *testing.T) {
+	content := "text file!" + util.RandomString(4990) // > 4096
+
+	c := newTestConfig(t)
+	c.VisitorAttachmentDailyBandwidthLimit = 5000 + 3000 + 123 // 1 upload, and a little more than 3000 bytes
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", content, nil)
+	msg := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+	// HEAD advertises range support, and is not counted
+	response = request(t, s, "HEAD", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "5000", response.Header().Get("Content-Length"))
+	require.Equal(t, "bytes", response.Header().Get("Accept-Ranges"))
+	require.Equal(t, `"`+msg.ID+`"`, response.Header().Get("ETag"))
+	require.NotEmpty(t, response.Header().Get("Last-Modified"))
+
+	// Range requests return a part of the file, with the content type of the entire file
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=0-9",
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, "bytes 0-9/5000", response.Header().Get("Content-Range"))
+	require.Equal(t, "text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+	require.Equal(t, "text file!", response.Body.String())
+
+	// Resume download, only the remaining bytes are counted against the bandwidth limit
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range":    "bytes=2000-",
+		"If-Range": `"` + msg.ID + `"`,
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, "bytes 2000-4999/5000", response.Header().Get("Content-Range"))
+	require.Equal(t, content[2000:], response.Body.String())
+
+	// Unsatisfiable range
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=6000-",
+	})
+	require.Equal(t, 416, response.Code)
+
+	// Mismatching If-Range sends the entire file, which exceeds the limit
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range":    "bytes=4000-",
+		"If-Range": `"someotheretag"`,
+	})
+	require.Equal(t, 429, response.Code)
+
+	// But the last 100 bytes are fine
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=-100",
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, content[4900:], response.Body.String())
+}
+
+func TestServer_PublishAttachmentConditionalGet(t *testing.T) {
+	content := util.RandomString(5000) // > 4096
+
+	c := newTestConfig(t)
+	c.VisitorAttachmentDailyBandwidthLimit = 2*5000 + 123 // 1 upload and 1 download
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", content, nil)
+	msg := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	etag, lastModified := response.Header().Get("ETag"), response.Header().Get("Last-Modified")
+
+	// Conditional requests are answered with 304, and are not counted against the bandwidth limit
+	for i := 0; i < 3; i++ {
+		response = request(t, s, "GET", path, "", map[string]string{
+			"If-None-Match": etag,
+		})
+		require.Equal(t, 304, response.Code)
+		require.Equal(t, "", response.Body.String())
+
+		response = request(t, s, "GET", path, "", map[string]string{
+			"If-Modified-Since": lastModified,
+		})
+		require.Equal(t, 304, response.Code)
+	}
+
+	// Other ETags download the file again, which exceeds the limit
+	response = request(t, s, "GET", path, "", map[string]string{
+		"If-None-Match": `"someotheretag"`,
+	})
+	require.Equal(t, 429, response.Code)
+}
+
 func TestServer_PublishAttachmentAndImmediatelyGetItWithCacheTimeout(t *testing.T) {
 	// This tests the awkward util.Retry in handleFile: Due to the async persisting of messages,
 	// the message is not immediately available when attempting to download it.
@@ -2228,6 +2962,160 @@ func TestServer_PublishAttachmentAccountStats(t *testing.T) {
 	require.Equal(t, int64(1), account.Stats.Messages)
 }
 
+func TestServer_Impersonation(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetLevelOverride("tag", "audit", log.InfoLevel)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.ResetLevelOverrides()
+	}()
+
+	c := newTestConfigWithAuthFile(t)
+	c.EnableImpersonation = true
+	c.EnableReservations = true
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro", Name: "Pro", MessageLimit: 123, ReservationLimit: 2}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("ben", "pro"))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionDenyAll))
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+
+	// Admin with token sees ben's account
+	rr := request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "ben", account.Username)
+	require.Equal(t, "pro", account.Tier.Code)
+	require.Equal(t, int64(123), account.Limits.Messages)
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "mytopic", account.Reservations[0].Topic)
+
+	// Impersonated requests are recorded in the audit log
+	require.Contains(t, buf.String(), "Admin phil acting as user ben")
+	require.Contains(t, buf.String(), "tag=audit")
+	require.Contains(t, buf.String(), "impersonator_user_name=phil")
+
+	// Impersonated requests are read-only
+	rr = request(t, s, "DELETE", "/v1/account", `{"password":"ben"}`, map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/mytopic", "as ben", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/mytopic/publish?message=as+ben", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 200, rr.Code)
+	_, err = s.userManager.User("ben")
+	require.Nil(t, err)
+
+	// Admin password (no token) is not enough
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+
+	// Regular users cannot impersonate
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+		"X-Act-As":      "phil",
+	})
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+
+	// Admins cannot be impersonated, and users must exist
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "phil",
+	})
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "doesnotexist",
+	})
+	require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_Impersonation_NotEnabled(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+
+	rr := request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+		"X-Act-As":      "ben",
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_Visitor_RequestLimitWindows(t *testing.T) {
+	offset := timeOfDay(time.Now())
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 5
+	c.VisitorRequestLimitWindows = []*RateLimitWindow{
+		{Start: (offset + 2*time.Hour) % oneDay, End: (offset + 3*time.Hour) % oneDay, Factor: 100}, // Not now
+		{Start: (offset + oneDay - time.Hour) % oneDay, End: (offset + time.Hour) % oneDay, Factor: 2},
+	}
+	v := newVisitor(c, nil, nil, nil, netip.MustParseAddr("1.2.3.4"), nil)
+	for i := 0; i < 10; i++ {
+		require.True(t, v.RequestAllowed())
+	}
+	require.False(t, v.RequestAllowed())
+
+	// Window ends, the limiter is reset with the normal limits
+	c.VisitorRequestLimitWindows[1].End = (c.VisitorRequestLimitWindows[1].Start + time.Minute) % oneDay
+	for i := 0; i < 5; i++ {
+		require.True(t, v.RequestAllowed())
+	}
+	require.False(t, v.RequestAllowed())
+}
+
+func TestServer_Visitor_RequestLimitWindows_Tighten(t *testing.T) {
+	offset := timeOfDay(time.Now())
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 10
+	c.VisitorRequestLimitWindows = []*RateLimitWindow{
+		{Start: (offset + oneDay - time.Hour) % oneDay, End: (offset + time.Hour) % oneDay, Factor: 0.2},
+	}
+	v := newVisitor(c, nil, nil, nil, netip.MustParseAddr("1.2.3.4"), nil)
+	require.True(t, v.RequestAllowed())
+	require.True(t, v.RequestAllowed())
+	require.False(t, v.RequestAllowed())
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+}
+
 func TestServer_Visitor_XForwardedFor_None(t *testing.T) {
 	c := newTestConfig(t)
 	c.BehindProxy = true
@@ -2318,6 +3206,28 @@ func TestServer_Visitor_Custom_Forwarded_Header_IPv6(t *testing.T) {
 	require.Equal(t, "2001:db8:3333::1", v.ip.String())
 }
 
+func TestServer_Visitor_TrustedProxies(t *testing.T) {
+	c := newTestConfig(t)
+	c.ProxyTrustedPrefixes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	s := newTestServer(t, c)
+
+	// Request via the trusted proxy
+	r, _ := http.NewRequest("GET", "/bla", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+	v, err := s.maybeAuthenticate(r)
+	require.Nil(t, err)
+	require.Equal(t, "5.6.7.8", v.ip.String())
+
+	// Request that bypasses the proxy cannot spoof its address
+	r, _ = http.NewRequest("GET", "/bla", nil)
+	r.RemoteAddr = "8.9.10.11:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8")
+	v, err = s.maybeAuthenticate(r)
+	require.Nil(t, err)
+	require.Equal(t, "8.9.10.11", v.ip.String())
+}
+
 func TestServer_PublishWhileUpdatingStatsWithLotsOfMessages(t *testing.T) {
 	t.Parallel()
 	count := 50000
@@ -2656,6 +3566,141 @@ func TestServer_MessageHistoryAndStatsEndpoint(t *testing.T) {
 	require.Equal(t, `{"messages":15,"messages_rate":3.75}`+"\n", response.Body.String()) // 15 messages in 4 seconds = 3.75 messages per second
 }
 
+func TestServer_StatsRollups(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	// Publish some messages as anonymous and as a user
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "POST", "/mytopic", "some message", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "POST", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Only admins can see the rollups
+	response = request(t, s, "GET", "/v1/stats/rollups", "", nil)
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", "/v1/stats/rollups", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Rollups are written by the manager
+	s.writeStatsRollups()
+	response = request(t, s, "GET", "/v1/stats/rollups?period=hour&since=1d", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	hours, _ := util.UnmarshalJSON[apiStatsRollupsResponse](io.NopCloser(response.Body))
+	require.Equal(t, "hour", hours.Period)
+	require.NotEmpty(t, hours.Rollups)
+	var messages, users int64
+	for _, rollup := range hours.Rollups {
+		messages += rollup.Messages
+		users = max(users, rollup.Users)
+	}
+	require.Equal(t, int64(4), messages)
+	require.Equal(t, int64(1), users)
+
+	response = request(t, s, "GET", "/v1/stats/rollups?period=day", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	days, _ := util.UnmarshalJSON[apiStatsRollupsResponse](io.NopCloser(response.Body))
+	require.Equal(t, "day", days.Period)
+	require.NotEmpty(t, days.Rollups)
+
+	// Invalid parameters
+	response = request(t, s, "GET", "/v1/stats/rollups?period=week", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40054, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "GET", "/v1/stats/rollups?since=yesterday", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+}
+
+func TestServer_StatsUsage(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "public", user.PermissionReadWrite))
+
+	// Publish and poll as a user and anonymously
+	for i := 0; i < 2; i++ {
+		response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	for i := 0; i < 3; i++ {
+		response = request(t, s, "PUT", "/public", "some message", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	s.writeStatsRollups()
+
+	// Users can see their own usage
+	response = request(t, s, "GET", "/v1/account/stats", "", nil)
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", "/v1/account/stats?since=1d", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	account, _ := util.UnmarshalJSON[apiAccountUsageStatsResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(account.Stats))
+	require.Equal(t, "mytopic", account.Stats[0].Topic)
+	require.Equal(t, int64(2), account.Stats[0].Messages)
+	require.Equal(t, int64(1), account.Stats[0].Subscriptions)
+	require.Equal(t, time.Now().UTC().Truncate(24*time.Hour).Unix(), account.Stats[0].Day)
+
+	// Only admins can see the top users and topics
+	response = request(t, s, "GET", "/v1/admin/stats/usage", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", "/v1/admin/stats/usage?by=topic&sort=messages", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	topics, _ := util.UnmarshalJSON[apiAdminUsageStatsResponse](io.NopCloser(response.Body))
+	require.Equal(t, "topic", topics.By)
+	require.Equal(t, 2, len(topics.Stats))
+	require.Equal(t, "public", topics.Stats[0].Topic)
+	require.Equal(t, int64(3), topics.Stats[0].Messages)
+	require.Equal(t, "mytopic", topics.Stats[1].Topic)
+
+	response = request(t, s, "GET", "/v1/admin/stats/usage?sort=subscriptions&limit=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	users, _ := util.UnmarshalJSON[apiAdminUsageStatsResponse](io.NopCloser(response.Body))
+	require.Equal(t, "user", users.By)
+	require.Equal(t, 1, len(users.Stats))
+	require.Equal(t, "ben", users.Stats[0].Username)
+	require.Equal(t, int64(1), users.Stats[0].Subscriptions)
+
+	// Invalid parameters
+	for _, query := range []string{"by=visitor", "sort=emails", "limit=0", "limit=abc"} {
+		response = request(t, s, "GET", "/v1/admin/stats/usage?"+query, "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40075, toHTTPError(t, response.Body.String()).Code)
+	}
+}
+
 func TestServer_MessageHistoryMaxSize(t *testing.T) {
 	t.Parallel()
 	s := newTestServer(t, newTestConfig(t))
@@ -3209,6 +4254,31 @@ func TestServer_MessageTemplate_Until100_000(t *testing.T) {
 	require.Contains(t, toHTTPError(t, response.Body.String()).Message, "too many iterations")
 }
 
+func TestServer_MessageTemplate_CustomLimits(t *testing.T) {
+	t.Parallel()
+	c := newTestConfig(t)
+	c.TemplateLoopLimit = 20_000
+	c.TemplateStringLimit = 50
+	s := newTestServer(t, c)
+
+	// Loop limit was raised
+	response := request(t, s, "POST", "/mytopic", `{}`, map[string]string{
+		"X-Message":  `{{ len (until 15000) }}`,
+		"X-Template": "1",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "15000", toMessage(t, response.Body.String()).Message)
+
+	// String limit was lowered
+	response = request(t, s, "POST", "/mytopic", `{}`, map[string]string{
+		"X-Message":  `{{ repeat 10 "mystring" }}`,
+		"X-Template": "1",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40045, toHTTPError(t, response.Body.String()).Code)
+	require.Contains(t, toHTTPError(t, response.Body.String()).Message, "exceeds limit of 50")
+}
+
 func newTestConfig(t *testing.T) *Config {
 	conf := NewConfig()
 	conf.BaseURL = "http://127.0.0.1:12345"
@@ -3255,7 +4325,7 @@ func request(t *testing.T, s *Server, method, url, body string, headers map[stri
 	return rr
 }
 
-func subscribe(t *testing.T, s *Server, url string, rr *httptest.ResponseRecorder) context.CancelFunc {
+func subscribe(t *testing.T, s *Server, url string, rr http.ResponseWriter) context.CancelFunc {
 	ctx, cancel := context.WithCancel(context.Background())
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -3275,6 +4345,46 @@ func subscribe(t *testing.T, s *Server, url string, rr *httptest.ResponseRecorde
 	return cancelAndWaitForDone
 }
 
+// syncResponseRecorder is a response recorder whose body can be read while a subscriber is still writing to it,
+// e.g. to wait for asynchronously delivered events with waitFor
+type syncResponseRecorder struct {
+	rr *httptest.ResponseRecorder
+	mu sync.Mutex
+}
+
+func newSyncResponseRecorder() *syncResponseRecorder {
+	return &syncResponseRecorder{rr: httptest.NewRecorder()}
+}
+
+func (r *syncResponseRecorder) Header() http.Header {
+	return r.rr.Header()
+}
+
+func (r *syncResponseRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rr.Write(b)
+}
+
+func (r *syncResponseRecorder) WriteHeader(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rr.WriteHeader(code)
+}
+
+func (r *syncResponseRecorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rr.Flush()
+}
+
+// Body returns a copy of the body written so far
+func (r *syncResponseRecorder) Body() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rr.Body.String()
+}
+
 func toMessages(t *testing.T, s string) []*message {
 	messages := make([]*message, 0)
 	scanner := bufio.NewScanner(strings.NewReader(s))
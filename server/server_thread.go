@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+// checkReplyTo ensures that the message referenced by m.ReplyTo exists and was published to the same
+// topic. If the message cache is disabled, replies cannot be verified and are accepted as is.
+func (s *Server) checkReplyTo(m *message) error {
+	if s.config.Load().CacheDuration == 0 {
+		return nil
+	}
+	parent, err := s.messageCache.Message(m.ReplyTo)
+	if errors.Is(err, errMessageNotFound) || (err == nil && parent.Topic != m.Topic) {
+		return errHTTPBadRequestReplyToInvalid
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// handleTopicThread returns the message with the given ID and all replies to it (including replies to
+// replies) as newline-delimited JSON, in the same format as the JSON stream (GET /<topic>/json)
+func (s *Server) handleTopicThread(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiTopicThreadRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	t, err := s.topicFromID(matches[1])
+	if err != nil {
+		return err
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), t.ID, user.PermissionRead); err != nil {
+			return errHTTPForbidden.With(t)
+		}
+	}
+	messages, err := s.messageCache.Thread(t.ID, matches[2])
+	if err != nil {
+		return err
+	} else if !slices.ContainsFunc(messages, func(m *message) bool { return m.ID == matches[2] }) {
+		return errHTTPNotFound.With(t).Fields(log.Context{
+			"message_id":    matches[2],
+			"error_context": "message_cache",
+		})
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Access-Control-Allow-Origin", s.config.Load().AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	encoder := json.NewEncoder(w)
+	for _, m := range messages {
+		if err := encoder.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_PublishReplyTo(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/incidents", "database is down", nil)
+	require.Equal(t, 200, response.Code)
+	root := toMessage(t, response.Body.String())
+	require.Equal(t, "", root.ReplyTo)
+
+	response = request(t, s, "PUT", "/incidents", "looking into it", map[string]string{
+		"X-Reply-To": root.ID,
+	})
+	require.Equal(t, 200, response.Code)
+	reply1 := toMessage(t, response.Body.String())
+	require.Equal(t, root.ID, reply1.ReplyTo)
+
+	response = request(t, s, "PUT", "/", `{"topic":"incidents","message":"fixed","reply_to":"`+reply1.ID+`"}`, nil)
+	require.Equal(t, 200, response.Code)
+	reply2 := toMessage(t, response.Body.String())
+	require.Equal(t, reply1.ID, reply2.ReplyTo)
+
+	response = request(t, s, "PUT", "/incidents", "unrelated", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Poll returns reply_to
+	response = request(t, s, "GET", "/incidents/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 4, len(messages))
+	require.Equal(t, root.ID, messages[1].ReplyTo)
+
+	// Thread endpoint returns root and all replies
+	response = request(t, s, "GET", "/v1/topic/incidents/thread/"+root.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, root.ID, messages[0].ID)
+	require.Equal(t, reply1.ID, messages[1].ID)
+	require.Equal(t, reply2.ID, messages[2].ID)
+
+	// Unknown message, or wrong topic
+	response = request(t, s, "GET", "/v1/topic/incidents/thread/doesnotexist", "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/v1/topic/othertopic/thread/"+root.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_PublishReplyTo_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/incidents", "database is down", nil)
+	root := toMessage(t, response.Body.String())
+
+	response = request(t, s, "PUT", "/incidents", "reply", map[string]string{"X-Reply-To": "not valid!"})
+	require.Equal(t, 40061, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/incidents", "reply", map[string]string{"X-Reply-To": "doesnotexist"})
+	require.Equal(t, 40061, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/othertopic", "reply", map[string]string{"X-Reply-To": root.ID})
+	require.Equal(t, 40061, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_TopicThread_Unauthorized(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "incidents", user.PermissionReadWrite))
+
+	response := request(t, s, "PUT", "/incidents", "database is down", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	root := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/v1/topic/incidents/thread/"+root.ID, "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/v1/topic/incidents/thread/"+root.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 1, len(toMessages(t, response.Body.String())))
+}
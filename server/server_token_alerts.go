@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	// tokenAlertInterval is the minimum time between two alerts for the same access token, so that users that
+	// switch between networks (e.g. home and mobile) are not notified on every switch
+	tokenAlertInterval = time.Hour
+
+	// tokenUserAgentLengthLimit is the max length of the user agent that is recorded for access tokens
+	tokenUserAgentLengthLimit = 256
+)
+
+// updateTokenLastAccess records the last access of the given token (time, IP address, user agent, country and network),
+// and notifies the user if the token is suddenly used from a new country or network (see auth-token-alerts).
+func (s *Server) updateTokenLastAccess(u *user.User, token string, update *user.TokenUpdate) {
+	if !s.config.Load().AuthTokenAlerts {
+		s.userManager.EnqueueTokenUpdate(token, update)
+		return
+	}
+	previous, err := s.userManager.SwapTokenUpdate(token, update)
+	if err != nil {
+		log.Tag(tagAccount).Err(err).Warn("Unable to record last access of token")
+		return
+	} else if !tokenLocationChanged(previous, update) || !s.tokenAlertAllowed(token) {
+		return
+	}
+	t, err := s.userManager.Token(u.ID, token)
+	if err != nil {
+		log.Tag(tagAccount).Err(err).Warn("Unable to retrieve token for token alert")
+		return
+	}
+	v := s.visitor(update.LastOrigin, u)
+	logv(v).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"token_label":             t.Label,
+			"token_previous_origin":   previous.LastOrigin.String(),
+			"token_previous_location": tokenLocation(previous.LastCountry, previous.LastASN),
+			"token_location":          tokenLocation(update.LastCountry, update.LastASN),
+		}).
+		Info("Access token used from new location, notifying user")
+	l := s.localizer.Locale(u)
+	s.notifyUser(v, u, l.T("token_alert_title"), l.T("token_alert_message",
+		"token", tokenAlertName(t),
+		"location", tokenLocation(update.LastCountry, update.LastASN),
+		"ip", update.LastOrigin.String(),
+		"user_agent", update.LastUserAgent,
+		"previous_location", tokenLocation(previous.LastCountry, previous.LastASN),
+	))
+}
+
+// tokenAlertAllowed returns true if no alert was sent for the given token within the tokenAlertInterval,
+// and records the current time as the time of the last alert if so
+func (s *Server) tokenAlertAllowed(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for t, alerted := range s.tokenAlerts {
+		if now.Sub(alerted) >= tokenAlertInterval {
+			delete(s.tokenAlerts, t)
+		}
+	}
+	if _, ok := s.tokenAlerts[token]; ok {
+		return false
+	}
+	s.tokenAlerts[token] = now
+	return true
+}
+
+// tokenLocationChanged returns true if the country or the network of the token changed. Unknown countries
+// and networks (e.g. private IP addresses, or tokens that were last used before GeoIP lookups were enabled)
+// are never considered a change.
+func tokenLocationChanged(previous, update *user.TokenUpdate) bool {
+	countryChanged := previous.LastCountry != "" && update.LastCountry != "" && previous.LastCountry != update.LastCountry
+	asnChanged := previous.LastASN != 0 && update.LastASN != 0 && previous.LastASN != update.LastASN
+	return countryChanged || asnChanged
+}
+
+// tokenLocation returns a human-readable location, e.g. "DE, AS3320"
+func tokenLocation(country string, asn uint32) string {
+	location := make([]string, 0)
+	if country != "" {
+		location = append(location, country)
+	}
+	if asn != 0 {
+		location = append(location, fmt.Sprintf("AS%d", asn))
+	}
+	return strings.Join(location, ", ")
+}
+
+// tokenAlertName returns the label of the token, or a shortened version of the token if it has no label,
+// so that the full token is never sent in a notification
+func tokenAlertName(t *user.Token) string {
+	if t.Label != "" {
+		return t.Label
+	}
+	return maskToken(t.Value)
+}
+
+// maskToken shortens the token to its first few characters, e.g. so that it can be logged safely
+func maskToken(token string) string {
+	return token[:min(len(token), 8)] + "..."
+}
+
+// newTokenUpdate creates the token update for a request from the given IP address
+func (s *Server) newTokenUpdate(ip netip.Addr, userAgent string) *user.TokenUpdate {
+	if len(userAgent) > tokenUserAgentLengthLimit {
+		userAgent = userAgent[:tokenUserAgentLengthLimit]
+	}
+	return &user.TokenUpdate{
+		LastAccess:    time.Now(),
+		LastOrigin:    ip,
+		LastUserAgent: userAgent,
+		LastCountry:   s.country(ip),
+		LastASN:       s.asn(ip),
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_TokenAlerts(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthTokenAlerts = true
+	s := newTestServer(t, c)
+	s.geoip = testGeoIPWithASN{
+		testGeoIP: testGeoIP{"1.1.1.1": "DE", "2.2.2.2": "US", "3.3.3.3": "FR"},
+		asns:      map[string]uint32{"1.1.1.1": 3320, "2.2.2.2": 19281, "3.3.3.3": 3215},
+	}
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "backups", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	publishFrom := func(ip string) {
+		response := request(t, s, "PUT", "/othertopic", "hi", map[string]string{
+			"Authorization": util.BearerAuth(token.Value),
+			"User-Agent":    "backup-script/1.0",
+		}, fromIP(ip))
+		require.Equal(t, 200, response.Code)
+	}
+	waitForCountry := func(country string) {
+		waitFor(t, func() bool {
+			_, tk, err := s.userManager.InspectToken(token.Value)
+			return err == nil && tk.LastCountry == country
+		})
+	}
+	alerts := func() []*message {
+		response := request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		return toMessages(t, response.Body.String())
+	}
+
+	// First use records the location, but does not alert
+	publishFrom("1.1.1.1")
+	waitForCountry("DE")
+	_, t2, err := s.userManager.InspectToken(token.Value)
+	require.Nil(t, err)
+	require.Equal(t, "1.1.1.1", t2.LastOrigin.String())
+	require.Equal(t, "backup-script/1.0", t2.LastUserAgent)
+	require.Equal(t, uint32(3320), t2.LastASN)
+	require.Empty(t, alerts())
+
+	// Use from a new country alerts the user
+	publishFrom("2.2.2.2")
+	waitFor(t, func() bool {
+		return len(alerts()) == 1
+	})
+	m := alerts()[0]
+	require.Equal(t, "New location for access token", m.Title)
+	require.Contains(t, m.Message, "Your access token backups was just used from a new location: US, AS19281 (IP address 2.2.2.2, user agent: backup-script/1.0)")
+	require.Contains(t, m.Message, "Previously, it was used from DE, AS3320.")
+	require.False(t, strings.Contains(m.Message, token.Value))
+
+	// Further changes within the alert interval do not alert again
+	publishFrom("3.3.3.3")
+	waitForCountry("FR")
+	require.Equal(t, 1, len(alerts()))
+}
+
+func TestServer_TokenAlerts_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	s.geoip = testGeoIP{"1.1.1.1": "DE", "2.2.2.2": "US"}
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2"} {
+		response := request(t, s, "PUT", "/othertopic", "hi", map[string]string{
+			"Authorization": util.BearerAuth(token.Value),
+		}, fromIP(ip))
+		require.Equal(t, 200, response.Code)
+		country := s.country(netip.MustParseAddr(ip))
+		waitFor(t, func() bool {
+			_, tk, err := s.userManager.InspectToken(token.Value)
+			return err == nil && tk.LastCountry == country
+		})
+	}
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Empty(t, toMessages(t, response.Body.String()))
+}
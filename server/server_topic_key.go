@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+// Topic keys:
+//
+// The owner of a reserved topic can set a shared key (passphrase) via PUT /v1/account/reservation/<topic>/key.
+// Anyone who passes the key in the X-Topic-Key header (or ?topic-key=...) is allowed to read and/or write the topic,
+// even without a user account. This is meant for casual groups who do not want to manage accounts. The key is an
+// additional way to gain access: users with access to the topic do not need it.
+
+// authorizeTopicKey checks the topic key passed in the request, if any. It is only called if the visitor is not
+// otherwise allowed to access the topic. Failed attempts count as auth failures, to prevent guessing the key.
+func (s *Server) authorizeTopicKey(r *http.Request, v *visitor, t *topic, perm user.Permission) error {
+	key := readParam(r, "x-topic-key", "topic-key")
+	if key == "" {
+		return errHTTPForbidden.With(t)
+	} else if !v.AuthAllowed() {
+		return errHTTPTooManyRequestsLimitAuthFailure.With(t)
+	}
+	if err := s.userManager.AuthorizeTopicKey(t.ID, key, perm); err != nil {
+		v.AuthFailed()
+		logvr(v, r).With(t).Err(err).Debug("Topic key for topic %s invalid", t.ID)
+		return errHTTPForbidden.With(t)
+	}
+	return nil
+}
+
+func (s *Server) handleAccountReservationKeyGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	key, err := s.userManager.TopicKey(topic)
+	if errors.Is(err, user.ErrTopicKeyNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountTopicKey{
+		Permission: key.Permission.String(), // The key is only stored as a hash, and can never be returned
+	})
+}
+
+func (s *Server) handleAccountReservationKeyChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountTopicKey](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedTopicKey(req.Key) {
+		return errHTTPBadRequestTopicKeyInvalid
+	}
+	perm, err := user.ParsePermission(req.Permission)
+	if err != nil || perm == user.PermissionDenyAll {
+		return errHTTPBadRequestTopicKeyInvalid
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{"topic": topic, "permission": perm.String()}).
+		Debug("Changing topic key for topic %s", topic)
+	if err := s.userManager.ChangeTopicKey(v.User().Name, topic, req.Key, perm); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationKeyDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Removing topic key for topic %s", topic)
+	if err := s.userManager.RemoveTopicKey(topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_TopicKey_Settings(t *testing.T) {
+	s := newTestServerWithTopicKey(t)
+
+	response := request(t, s, "GET", "/v1/account/reservation/mytopic/key", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "PUT", "/v1/account/reservation/mytopic/key", `{"key":"correct horse","permission":"read-only"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/key", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	key, err := util.UnmarshalJSON[apiAccountTopicKey](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "read-only", key.Permission)
+	require.Equal(t, "", key.Key) // Never returned
+
+	for _, body := range []string{`{"key":"short","permission":"read-only"}`, `{"key":"correct horse","permission":"deny-all"}`, `{"key":"correct horse","permission":"invalid"}`} {
+		response = request(t, s, "PUT", "/v1/account/reservation/mytopic/key", body, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40088, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Topic not owned by user
+	response = request(t, s, "PUT", "/v1/account/reservation/othertopic/key", `{"key":"correct horse","permission":"read-only"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "DELETE", "/v1/account/reservation/mytopic/key", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/key", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_TopicKey_PublishAndSubscribe(t *testing.T) {
+	s := newTestServerWithTopicKey(t)
+	require.Nil(t, s.userManager.ChangeTopicKey("ben", "mytopic", "correct horse", user.PermissionWrite))
+
+	// Without or with a wrong key, the topic is only accessible to the owner
+	response := request(t, s, "PUT", "/mytopic", "without key", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "wrong key", map[string]string{
+		"X-Topic-Key": "battery staple",
+	})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "with key", map[string]string{
+		"X-Topic-Key": "correct horse",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic?topic-key=correct+horse", "with key in query", nil)
+	require.Equal(t, 200, response.Code)
+
+	// The key only grants write access
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"X-Topic-Key": "correct horse",
+	})
+	require.Equal(t, 403, response.Code)
+
+	require.Nil(t, s.userManager.ChangeTopicKey("ben", "mytopic", "correct horse", user.PermissionReadWrite))
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"X-Topic-Key": "correct horse",
+	})
+	require.Equal(t, 200, response.Code)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "with key", messages[0].Message)
+
+	// Owner does not need the key
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_TopicKey_AuthFailureLimit(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	conf.VisitorAuthFailureLimitBurst = 3
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.ChangeTopicKey("ben", "mytopic", "correct horse", user.PermissionReadWrite))
+
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", "guessing", map[string]string{
+			"X-Topic-Key": "wrong key",
+		})
+		require.Equal(t, 403, response.Code)
+	}
+	response := request(t, s, "PUT", "/mytopic", "correct, but too late", map[string]string{
+		"X-Topic-Key": "correct horse",
+	})
+	require.Equal(t, 429, response.Code)
+}
+
+func newTestServerWithTopicKey(t *testing.T) *Server {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionDenyAll))
+	return s
+}
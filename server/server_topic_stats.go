@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+// handleTopicStats returns the number of subscribers and the attachment bandwidth used by a topic today
+// (GET /v1/topic/<topic>/stats). Like subscribing, this requires read access to the topic.
+func (s *Server) handleTopicStats(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiTopicStatsRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	t, err := s.topicFromID(matches[1])
+	if err != nil {
+		return err
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), t.ID, user.PermissionRead); err != nil {
+			return errHTTPForbidden.With(t)
+		}
+	}
+	subscribers, _ := t.Stats()
+	return s.writeJSON(w, &apiTopicStatsResponse{
+		Topic:                    t.ID,
+		Subscribers:              subscribers,
+		AttachmentBandwidth:      t.BandwidthUsed(),
+		AttachmentBandwidthLimit: s.config.Load().TopicAttachmentDailyBandwidthLimit,
+	})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_TopicStats_AttachmentBandwidth(t *testing.T) {
+	content := util.RandomString(5000) // > 4096
+
+	c := newTestConfig(t)
+	c.TopicAttachmentDailyBandwidthLimit = 2*5000 + 123 // A little more than 2 downloads
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", content, nil)
+	msg := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+
+	// Two downloads succeed, the third one fails, even though the visitor has bandwidth left
+	for i := 0; i < 2; i++ {
+		response = request(t, s, "GET", path, "", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42913, toHTTPError(t, response.Body.String()).Code)
+
+	// HEAD requests do not count
+	response = request(t, s, "HEAD", path, "", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/topic/mytopic/stats", "", nil)
+	require.Equal(t, 200, response.Code)
+	stats, _ := util.UnmarshalJSON[apiTopicStatsResponse](io.NopCloser(response.Body))
+	require.Equal(t, "mytopic", stats.Topic)
+	require.Equal(t, int64(10000), stats.AttachmentBandwidth)
+	require.Equal(t, int64(10123), stats.AttachmentBandwidthLimit)
+
+	// Other topics are not affected
+	response = request(t, s, "GET", "/v1/topic/othertopic/stats", "", nil)
+	stats, _ = util.UnmarshalJSON[apiTopicStatsResponse](io.NopCloser(response.Body))
+	require.Equal(t, int64(0), stats.AttachmentBandwidth)
+
+	// Daily reset
+	s.resetStats()
+	response = request(t, s, "GET", "/v1/topic/mytopic/stats", "", nil)
+	stats, _ = util.UnmarshalJSON[apiTopicStatsResponse](io.NopCloser(response.Body))
+	require.Equal(t, int64(0), stats.AttachmentBandwidth)
+}
+
+func TestServer_TopicStats_Unlimited(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", util.RandomString(5000), nil)
+	msg := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(msg.Attachment.URL, "http://127.0.0.1:12345")
+	for i := 0; i < 3; i++ {
+		require.Equal(t, 200, request(t, s, "GET", path, "", nil).Code)
+	}
+	response = request(t, s, "GET", "/v1/topic/mytopic/stats", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `{"topic":"mytopic","subscribers":0,"attachment_bandwidth":15000}`+"\n", response.Body.String())
+}
+
+func TestServer_TopicStats_Forbidden(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionRead))
+
+	response := request(t, s, "GET", "/v1/topic/mytopic/stats", "", nil)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "GET", "/v1/topic/mytopic/stats", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
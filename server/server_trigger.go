@@ -0,0 +1,173 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+// Triggers for no-code platforms (Zapier, IFTTT, Make, ...):
+//
+// These platforms can already publish messages via JSON (POST /), but to receive messages, they expect either
+// a polling endpoint that returns the newest items first (deduplicated by their "id" field), or a "REST hook"
+// that they can register and unregister a callback URL with.
+//
+//   - GET /v1/triggers/<topic>?cursor=<id>&limit=<n> returns a JSON array of messages, newest first (Zapier)
+//   - POST /v1/triggers/<topic> with {"limit":<n>,"cursor":"<id>"} returns {"data":[...],"cursor":"<id>"},
+//     where each item has a "meta" object with "id" and "timestamp" (IFTTT)
+//   - POST /v1/triggers/<topic>/hooks with {"hookUrl":"<url>"} registers a REST hook, which is stored as
+//     an outgoing webhook of the (reserved) topic, and DELETE /v1/triggers/<topic>/hooks/<id> removes it
+//
+// Since these platforms typically only support a static API key, an access token can also be passed in the
+// X-API-Key header, or the api_key query parameter (see readAuthHeader).
+
+const (
+	triggerLimitDefault = 50
+	triggerLimitMax     = 100
+)
+
+// handleTriggerPoll returns the newest messages of a topic as a JSON array, newest first, as expected
+// by Zapier's polling triggers. The X-Cursor header contains the ID of the newest message, which can be
+// passed as "cursor" in the next request to only receive newer messages.
+func (s *Server) handleTriggerPoll(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	limit := triggerLimitDefault
+	if limitStr := readQueryParam(r, "limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil {
+			return errHTTPBadRequestTriggerLimitInvalid
+		}
+	}
+	messages, cursor, err := s.triggerMessages(r, v, readQueryParam(r, "cursor"), limit)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Cursor", cursor)
+	return s.writeJSON(w, messages)
+}
+
+// handleTriggerPollIFTTT is like handleTriggerPoll, but accepts and returns the request and response format of
+// IFTTT's triggers (POST with JSON body, items wrapped in "data", with a "meta" object each)
+func (s *Server) handleTriggerPollIFTTT(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiTriggerIFTTTRequest](r.Body, jsonBodyBytesLimit, true)
+	if err != nil {
+		return err
+	}
+	limit := triggerLimitDefault
+	if req.Limit != nil {
+		limit = *req.Limit
+	}
+	messages, cursor, err := s.triggerMessages(r, v, req.Cursor, limit)
+	if err != nil {
+		return err
+	}
+	items := make([]*apiTriggerIFTTTItem, 0, len(messages))
+	for _, m := range messages {
+		items = append(items, &apiTriggerIFTTTItem{
+			message: m,
+			Meta: &apiTriggerIFTTTMeta{
+				ID:        m.ID,
+				Timestamp: m.Time,
+			},
+		})
+	}
+	return s.writeJSON(w, &apiTriggerIFTTTResponse{
+		Data:   items,
+		Cursor: cursor,
+	})
+}
+
+// triggerMessages returns up to limit messages newer than the cursor (a message ID, or empty for all messages),
+// newest first, as well as the new cursor. Like subscribing, this requires read access to the topic.
+func (s *Server) triggerMessages(r *http.Request, v *visitor, cursor string, limit int) ([]*message, string, error) {
+	if limit < 0 || limit > triggerLimitMax {
+		return nil, "", errHTTPBadRequestTriggerLimitInvalid
+	}
+	t, err := s.topicFromID(triggerTopicFromPath(r.URL.Path))
+	if err != nil {
+		return nil, "", err
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), t.ID, user.PermissionRead); err != nil {
+			return nil, "", errHTTPForbidden.With(t)
+		}
+	}
+	since := sinceAllMessages
+	if cursor != "" {
+		if !validMessageID(cursor) {
+			return nil, "", errHTTPBadRequestSinceInvalid
+		}
+		since = newSinceID(cursor)
+	}
+	messages, err := s.messageCache.Messages(t.ID, since, false)
+	if err != nil {
+		return nil, "", err
+	}
+	slices.Reverse(messages)
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	if len(messages) > 0 {
+		cursor = messages[0].ID
+	}
+	return messages, cursor, nil
+}
+
+// handleTriggerHookAdd registers a REST hook (e.g. from Zapier) as outgoing webhook of the topic. Like other
+// webhooks, this requires the topic to be reserved by the user (see handleAccountReservationWebhookAdd).
+func (s *Server) handleTriggerHookAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedTriggerTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiTriggerHookAddRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	webhook, err := s.addTopicWebhook(r, v, topic, firstNonEmpty(req.HookURL, req.TargetURL, req.URL), "")
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newAPIAccountWebhook(webhook))
+}
+
+// handleTriggerHookDelete unregisters a REST hook, see handleTriggerHookAdd
+func (s *Server) handleTriggerHookDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedTriggerTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	matches := apiTriggerHookRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	if err := s.removeTopicWebhook(r, v, topic, matches[2]); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) ownedTriggerTopicFromPath(v *visitor, path string) (string, error) {
+	topic := triggerTopicFromPath(path)
+	if topic == "" {
+		return "", errHTTPInternalErrorInvalidPath
+	}
+	authorized, err := s.userManager.HasReservation(v.User().Name, topic)
+	if err != nil {
+		return "", err
+	} else if !authorized {
+		return "", errHTTPUnauthorized
+	}
+	return topic, nil
+}
+
+func triggerTopicFromPath(path string) string {
+	for _, re := range []*regexp.Regexp{apiTriggerRegex, apiTriggerHooksRegex, apiTriggerHookRegex} {
+		if matches := re.FindStringSubmatch(path); len(matches) >= 2 {
+			return matches[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Trigger_Poll(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	for i := 1; i <= 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), nil)
+		require.Equal(t, 200, response.Code)
+	}
+
+	response := request(t, s, "GET", "/v1/triggers/mytopic?limit=2", "", nil)
+	require.Equal(t, 200, response.Code)
+	messages, err := util.UnmarshalJSON[[]*message](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*messages))
+	require.Equal(t, "message 3", (*messages)[0].Message) // Newest first
+	require.Equal(t, "message 2", (*messages)[1].Message)
+	cursor := response.Header().Get("X-Cursor")
+	require.Equal(t, (*messages)[0].ID, cursor)
+
+	// Nothing new since the cursor
+	response = request(t, s, "GET", "/v1/triggers/mytopic?cursor="+cursor, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "[]\n", response.Body.String())
+	require.Equal(t, cursor, response.Header().Get("X-Cursor"))
+
+	response = request(t, s, "PUT", "/mytopic", "message 4", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/v1/triggers/mytopic?cursor="+cursor, "", nil)
+	messages, err = util.UnmarshalJSON[[]*message](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*messages))
+	require.Equal(t, "message 4", (*messages)[0].Message)
+
+	response = request(t, s, "GET", "/v1/triggers/mytopic?limit=1000", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40080, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Trigger_PollIFTTT(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "message 1", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "POST", "/v1/triggers/mytopic", `{"limit":10}`, nil)
+	require.Equal(t, 200, response.Code)
+	var res struct {
+		Data []struct {
+			Message string               `json:"message"`
+			Meta    *apiTriggerIFTTTMeta `json:"meta"`
+		} `json:"data"`
+		Cursor string `json:"cursor"`
+	}
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&res))
+	require.Equal(t, 1, len(res.Data))
+	require.Equal(t, "message 1", res.Data[0].Message)
+	require.Equal(t, m.ID, res.Data[0].Meta.ID)
+	require.Equal(t, m.Time, res.Data[0].Meta.Timestamp)
+	require.Equal(t, m.ID, res.Cursor)
+
+	// IFTTT sends a limit of 0 to check the endpoint
+	response = request(t, s, "POST", "/v1/triggers/mytopic", `{"limit":0}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `{"data":[]}`+"\n", response.Body.String())
+}
+
+func TestServer_Trigger_APIKey(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleAdmin, false))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+
+	response := request(t, s, "GET", "/v1/triggers/mytopic", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/v1/triggers/mytopic", "", map[string]string{
+		"X-API-Key": token.Value,
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/triggers/mytopic?api_key="+token.Value, "", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/v1/triggers/mytopic?api_key=tk_invalid", "", nil)
+	require.Equal(t, 401, response.Code)
+}
+
+func TestServer_Trigger_Hooks(t *testing.T) {
+	s := newTestServerWithWebhooks(t)
+
+	response := request(t, s, "POST", "/v1/triggers/mytopic/hooks", `{"hookUrl":"https://hooks.zapier.com/hooks/standard/123"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	webhook, err := util.UnmarshalJSON[apiAccountWebhook](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "https://hooks.zapier.com/hooks/standard/123", webhook.URL)
+	require.Equal(t, "ntfy", webhook.Format)
+
+	webhooks, err := s.userManager.TopicWebhooks("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(webhooks))
+	require.Equal(t, webhook.ID, webhooks[0].ID)
+
+	// Topic not owned by user
+	response = request(t, s, "POST", "/v1/triggers/othertopic/hooks", `{"hookUrl":"https://hooks.zapier.com/hooks/standard/123"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "DELETE", "/v1/triggers/mytopic/hooks/"+webhook.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	webhooks, err = s.userManager.TopicWebhooks("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(webhooks))
+
+	response = request(t, s, "DELETE", "/v1/triggers/mytopic/hooks/"+webhook.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+}
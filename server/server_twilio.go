@@ -17,18 +17,18 @@ const (
 	twilioCallFormat = `
 <Response>
 	<Pause length="1"/>
-	<Say loop="3">
-		You have a message from notify on topic %s. Message:
+	<Say loop="3" language="{language}">
+		{intro}
 		<break time="1s"/>
-		%s
+		{message}
 		<break time="1s"/>
-		End of message.
+		{outro}
 		<break time="1s"/>
-		This message was sent by user %s. It will be repeated three times.
-		To unsubscribe from calls like this, remove your phone number in the notify web app.
+		{sender}
+		{unsubscribe}
 		<break time="3s"/>
 	</Say>
-	<Say>Goodbye.</Say>
+	<Say language="{language}">{goodbye}</Say>
 </Response>`
 )
 
@@ -59,15 +59,20 @@ func (s *Server) convertPhoneNumber(u *user.User, phoneNumber string) (string, *
 }
 
 // callPhone calls the Twilio API to make a phone call to the given phone number, using the given message.
-// Failures will be logged, but not returned to the caller.
-func (s *Server) callPhone(v *visitor, r *http.Request, m *message, to string) {
-	u, sender := v.User(), m.Sender.String()
+// The user u is the publishing user, captured when the message was published. Failures will be logged, but not
+// returned to the caller.
+func (s *Server) callPhone(v *visitor, u *user.User, r *http.Request, m *message, to string) {
+	sender := m.Sender.String()
 	if u != nil {
 		sender = u.Name
 	}
-	body := fmt.Sprintf(twilioCallFormat, xmlEscapeText(m.Topic), xmlEscapeText(m.Message), xmlEscapeText(sender))
+	if !s.subscriptionChannelAllowed(u, m.Topic, channelCall) {
+		logvrm(v, r, m).Tag(tagTwilio).Field("twilio_to", to).Debug("Not calling phone, disabled in subscription preferences")
+		return
+	}
+	body := formatCall(s.localizer.Locale(u), m.Topic, m.Message, sender)
 	data := url.Values{}
-	data.Set("From", s.config.TwilioPhoneNumber)
+	data.Set("From", s.config.Load().TwilioPhoneNumber)
 	data.Set("To", to)
 	data.Set("Twiml", body)
 	ev := logvrm(v, r, m).Tag(tagTwilio).Field("twilio_to", to).FieldIf("twilio_body", body, log.TraceLevel).Debug("Sending Twilio request")
@@ -75,21 +80,24 @@ func (s *Server) callPhone(v *visitor, r *http.Request, m *message, to string) {
 	if err != nil {
 		ev.Field("twilio_response", response).Err(err).Warn("Error sending Twilio request")
 		minc(metricCallsMadeFailure)
+		s.deliveries.Failure(deliveryChannelCall)
 		return
 	}
 	ev.FieldIf("twilio_response", response, log.TraceLevel).Debug("Received successful Twilio response")
+	s.statsCollector.AddCall()
 	minc(metricCallsMadeSuccess)
+	s.deliveries.Success(deliveryChannelCall)
 }
 
 func (s *Server) callPhoneInternal(data url.Values) (string, error) {
-	requestURL := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", s.config.TwilioCallsBaseURL, s.config.TwilioAccount)
+	requestURL := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Calls.json", s.config.Load().TwilioCallsBaseURL, s.config.Load().TwilioAccount)
 	req, err := http.NewRequest(http.MethodPost, requestURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Load().Version)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", util.BasicAuth(s.config.TwilioAccount, s.config.TwilioAuthToken))
+	req.Header.Set("Authorization", util.BasicAuth(s.config.Load().TwilioAccount, s.config.Load().TwilioAuthToken))
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
@@ -106,14 +114,14 @@ func (s *Server) verifyPhoneNumber(v *visitor, r *http.Request, phoneNumber, cha
 	data := url.Values{}
 	data.Set("To", phoneNumber)
 	data.Set("Channel", channel)
-	requestURL := fmt.Sprintf("%s/v2/Services/%s/Verifications", s.config.TwilioVerifyBaseURL, s.config.TwilioVerifyService)
+	requestURL := fmt.Sprintf("%s/v2/Services/%s/Verifications", s.config.Load().TwilioVerifyBaseURL, s.config.Load().TwilioVerifyService)
 	req, err := http.NewRequest(http.MethodPost, requestURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return err
 	}
-	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Load().Version)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", util.BasicAuth(s.config.TwilioAccount, s.config.TwilioAuthToken))
+	req.Header.Set("Authorization", util.BasicAuth(s.config.Load().TwilioAccount, s.config.Load().TwilioAuthToken))
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -132,14 +140,14 @@ func (s *Server) verifyPhoneNumberCheck(v *visitor, r *http.Request, phoneNumber
 	data := url.Values{}
 	data.Set("To", phoneNumber)
 	data.Set("Code", code)
-	requestURL := fmt.Sprintf("%s/v2/Services/%s/VerificationCheck", s.config.TwilioVerifyBaseURL, s.config.TwilioVerifyService)
+	requestURL := fmt.Sprintf("%s/v2/Services/%s/VerificationCheck", s.config.Load().TwilioVerifyBaseURL, s.config.Load().TwilioVerifyService)
 	req, err := http.NewRequest(http.MethodPost, requestURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return err
 	}
-	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Load().Version)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", util.BasicAuth(s.config.TwilioAccount, s.config.TwilioAuthToken))
+	req.Header.Set("Authorization", util.BasicAuth(s.config.Load().TwilioAccount, s.config.Load().TwilioAuthToken))
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
@@ -169,6 +177,19 @@ func (s *Server) verifyPhoneNumberCheck(v *visitor, r *http.Request, phoneNumber
 	return nil
 }
 
+// formatCall returns the TwiML for a phone call reading out the given message, in the language of the given locale
+func formatCall(l *locale, topic, message, sender string) string {
+	return strings.NewReplacer(
+		"{language}", xmlEscapeText(l.T("call_language")),
+		"{intro}", xmlEscapeText(l.T("call_intro", "topic", topic)),
+		"{message}", xmlEscapeText(message),
+		"{outro}", xmlEscapeText(l.T("call_outro")),
+		"{sender}", xmlEscapeText(l.T("call_sender", "sender", sender)),
+		"{unsubscribe}", xmlEscapeText(l.T("call_unsubscribe")),
+		"{goodbye}", xmlEscapeText(l.T("call_goodbye")),
+	).Replace(twilioCallFormat)
+}
+
 func xmlEscapeText(text string) string {
 	var buf bytes.Buffer
 	_ = xml.EscapeText(&buf, []byte(text))
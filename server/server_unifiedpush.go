@@ -0,0 +1,81 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strings"
+
+	"golang.org/x/time/rate"
+	"heckel.io/ntfy/v2/user"
+)
+
+// UnifiedPush per-app budgets:
+//
+// With subscriber-based rate limiting (visitor-subscriber-rate-limiting), all messages published to a device's UnifiedPush
+// topics are counted against the device's visitor. Since a phone typically has one topic per app, a single chatty app could
+// use up the entire budget of the device. If unifiedpush-app-request-limit-burst is set, each UnifiedPush topic
+// (= app endpoint) that has a rate visitor instead gets its own request and daily message budget, kept in the topic.
+
+// isUnifiedPushTopic returns true if the topic ID looks like a UnifiedPush topic, e.g. "up123456789012"
+func isUnifiedPushTopic(id string) bool {
+	return strings.HasPrefix(id, unifiedPushTopicPrefix) && len(id) == unifiedPushTopicLength
+}
+
+// appBudgetEnabled returns true if requests and messages for the given topic are counted against the
+// topic's own UnifiedPush app budget, instead of against the rate visitor
+func (s *Server) appBudgetEnabled(t *topic) bool {
+	return s.config.Load().UnifiedPushAppRequestLimitBurst > 0 && isUnifiedPushTopic(t.ID) && t.RateVisitor() != nil
+}
+
+func (s *Server) appRequestAllowed(t *topic) bool {
+	return t.AppRequestAllowed(rate.Every(s.config.Load().UnifiedPushAppRequestLimitReplenish), s.config.Load().UnifiedPushAppRequestLimitBurst)
+}
+
+// messageAllowed counts a message against the topic's UnifiedPush app budget if enabled, or against the rate visitor otherwise
+func (s *Server) messageAllowed(t *topic, vrate *visitor) bool {
+	if s.appBudgetEnabled(t) {
+		return t.AppMessageAllowed(int64(s.config.Load().UnifiedPushAppMessageDailyLimit))
+	}
+	return vrate.MessageAllowed()
+}
+
+// handleUnifiedPushBudget returns the remaining request and message budget of a UnifiedPush app
+// (GET /v1/unifiedpush/<topic>). Like subscribing, this requires read access to the topic.
+func (s *Server) handleUnifiedPushBudget(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.config.Load().UnifiedPushAppRequestLimitBurst <= 0 {
+		return errHTTPNotFound
+	}
+	matches := apiUnifiedPushRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	} else if !isUnifiedPushTopic(matches[1]) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	t, err := s.topicFromID(matches[1])
+	if err != nil {
+		return err
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), t.ID, user.PermissionRead); err != nil {
+			return errHTTPForbidden.With(t)
+		}
+	}
+	requestsLimit := s.config.Load().UnifiedPushAppRequestLimitBurst
+	requestsRemaining, messages := t.AppStats()
+	if requestsRemaining < 0 {
+		requestsRemaining = float64(requestsLimit)
+	}
+	messagesLimit := int64(s.config.Load().UnifiedPushAppMessageDailyLimit)
+	response := &apiUnifiedPushBudgetResponse{
+		Topic:             t.ID,
+		Active:            t.RateVisitor() != nil,
+		RequestsLimit:     int64(requestsLimit),
+		RequestsRemaining: int64(math.Floor(requestsRemaining)),
+		Messages:          messages,
+		MessagesLimit:     messagesLimit,
+	}
+	if messagesLimit > 0 {
+		response.MessagesRemaining = max(messagesLimit-messages, 0)
+	}
+	return s.writeJSON(w, response)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_UnifiedPushAppBudget_Success(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 3
+	c.VisitorSubscriberRateLimiting = true
+	c.UnifiedPushAppRequestLimitBurst = 5
+	c.UnifiedPushAppMessageDailyLimit = 2
+	s := newTestServer(t, c)
+
+	// Device 1.2.3.4 subscribes to two apps' topics
+	deviceFn := func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4:1234"
+	}
+	rr := request(t, s, "GET", "/upAAAAAAAAAAAA,upBBBBBBBBBBBB/json?poll=1", "", nil, deviceFn)
+	require.Equal(t, 200, rr.Code)
+
+	// App A uses up its own daily message budget ...
+	for i := 0; i < 2; i++ {
+		rr := request(t, s, "PUT", "/upAAAAAAAAAAAA", "some message", nil)
+		require.Equal(t, 200, rr.Code)
+	}
+	rr = request(t, s, "PUT", "/upAAAAAAAAAAAA", "some message", nil)
+	require.Equal(t, 429, rr.Code)
+	require.Equal(t, 42908, toHTTPError(t, rr.Body.String()).Code)
+
+	// ... but app B still has its budget, and the device's limits were not touched
+	for i := 0; i < 2; i++ {
+		rr := request(t, s, "PUT", "/upBBBBBBBBBBBB", "some message", nil)
+		require.Equal(t, 200, rr.Code)
+	}
+	require.Equal(t, int64(0), s.topics["upAAAAAAAAAAAA"].rateVisitor.Stats().Messages)
+
+	// Budget is visible via the API
+	rr = request(t, s, "GET", "/v1/unifiedpush/upAAAAAAAAAAAA", "", nil)
+	require.Equal(t, 200, rr.Code)
+	budget, err := util.UnmarshalJSON[apiUnifiedPushBudgetResponse](rr.Result().Body)
+	require.Nil(t, err)
+	require.Equal(t, "upAAAAAAAAAAAA", budget.Topic)
+	require.True(t, budget.Active)
+	require.Equal(t, int64(5), budget.RequestsLimit)
+	require.Equal(t, int64(2), budget.RequestsRemaining)
+	require.Equal(t, int64(2), budget.Messages)
+	require.Equal(t, int64(2), budget.MessagesLimit)
+	require.Equal(t, int64(0), budget.MessagesRemaining)
+
+	// Message budget is reset daily
+	s.resetStats()
+	rr = request(t, s, "PUT", "/upAAAAAAAAAAAA", "some message", nil)
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestServer_UnifiedPushAppBudget_RequestLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorSubscriberRateLimiting = true
+	c.UnifiedPushAppRequestLimitBurst = 2
+	s := newTestServer(t, c)
+
+	rr := request(t, s, "GET", "/upAAAAAAAAAAAA/json?poll=1", "", nil)
+	require.Equal(t, 200, rr.Code)
+	for i := 0; i < 2; i++ {
+		rr := request(t, s, "PUT", "/upAAAAAAAAAAAA", "some message", nil)
+		require.Equal(t, 200, rr.Code)
+	}
+	rr = request(t, s, "PUT", "/upAAAAAAAAAAAA", "some message", nil)
+	require.Equal(t, 429, rr.Code)
+	require.Equal(t, 42901, toHTTPError(t, rr.Body.String()).Code)
+
+	// Budget for a topic without a subscriber is full, but not active
+	rr = request(t, s, "GET", "/v1/unifiedpush/upBBBBBBBBBBBB", "", nil)
+	require.Equal(t, 200, rr.Code)
+	budget, err := util.UnmarshalJSON[apiUnifiedPushBudgetResponse](rr.Result().Body)
+	require.Nil(t, err)
+	require.False(t, budget.Active)
+	require.Equal(t, int64(2), budget.RequestsRemaining)
+	require.Equal(t, int64(0), budget.MessagesLimit)
+}
+
+func TestServer_UnifiedPushAppBudget_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	rr := request(t, s, "GET", "/v1/unifiedpush/upAAAAAAAAAAAA", "", nil)
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestServer_UnifiedPushAppBudget_NotUnifiedPushTopic(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorSubscriberRateLimiting = true
+	c.UnifiedPushAppRequestLimitBurst = 2
+	s := newTestServer(t, c)
+	rr := request(t, s, "GET", "/v1/unifiedpush/mytopic", "", nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40009, toHTTPError(t, rr.Body.String()).Code)
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	uploadIDPrefix         = "up_"
+	uploadIDLength         = 16
+	uploadExpiryDuration   = time.Hour // Unfinished uploads are deleted if no chunk was received for this long
+	uploadsPerVisitorLimit = 5
+)
+
+// uploadCache keeps track of unfinished resumable uploads (see POST /v1/uploads). The uploaded bytes are stored as
+// partial files in the attachment cache, until the upload is published as the attachment of a message (X-Upload).
+// Uploads are only kept in memory, so they cannot be resumed after a server restart.
+type uploadCache struct {
+	uploads map[string]*upload
+	mu      sync.Mutex
+}
+
+type upload struct {
+	ID      string
+	User    string     // User ID of the uploader, or empty for anonymous uploads
+	Sender  netip.Addr // IP address of anonymous uploaders, only used to limit the number of uploads
+	Name    string     // Optional filename, used as attachment name unless X-Filename is passed when publishing
+	Length  int64
+	Offset  int64
+	Expires time.Time // Extended with every chunk
+	writing bool      // A chunk is currently being written, see Begin
+}
+
+func newUploadCache() *uploadCache {
+	return &uploadCache{
+		uploads: make(map[string]*upload),
+	}
+}
+
+// Add registers a new upload, unless the uploader already has too many unfinished uploads
+func (c *uploadCache) Add(u *upload) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	for _, existing := range c.uploads {
+		if (u.User != "" && existing.User == u.User) || (u.User == "" && existing.User == "" && existing.Sender == u.Sender) {
+			count++
+		}
+	}
+	if count >= uploadsPerVisitorLimit {
+		return false
+	}
+	c.uploads[u.ID] = u
+	return true
+}
+
+// Get returns a copy of the upload with the given ID, if the visitor is allowed to access it. Uploads of users
+// can only be accessed by the same user. Anonymous uploads can be accessed by anyone who knows the upload ID, so
+// that clients can resume an upload after switching networks (and IP addresses).
+func (c *uploadCache) Get(id string, v *visitor) (*upload, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.uploads[id]
+	if !ok || !u.allowed(v) {
+		return nil, false
+	}
+	upload := *u
+	return &upload, true
+}
+
+// Begin marks the upload as being written to, and returns a copy of it. It fails if the given offset does not match
+// the current offset of the upload, or if another chunk is being written at the same time.
+func (c *uploadCache) Begin(id string, v *visitor, offset int64) (*upload, *errHTTP) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.uploads[id]
+	if !ok || !u.allowed(v) {
+		return nil, errHTTPNotFound
+	} else if u.writing || u.Offset != offset {
+		return nil, errHTTPConflictUploadOffset
+	}
+	u.writing = true
+	upload := *u
+	return &upload, nil
+}
+
+// End advances the offset of an upload by the given number of bytes after a chunk was written (or partially
+// written), and returns a copy of the upload
+func (c *uploadCache) End(id string, written int64) *upload {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.uploads[id]
+	if !ok {
+		return nil
+	}
+	u.Offset += written
+	u.Expires = time.Now().Add(uploadExpiryDuration)
+	u.writing = false
+	upload := *u
+	return &upload
+}
+
+// Remove forgets the upload with the given ID, e.g. because it was published or cancelled
+func (c *uploadCache) Remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.uploads, id)
+}
+
+// Exists returns true if an upload with the given ID exists
+func (c *uploadCache) Exists(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.uploads[id]
+	return ok
+}
+
+// Expired removes all uploads that have expired (and are not being written to), and returns their IDs
+func (c *uploadCache) Expired() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0)
+	for id, u := range c.uploads {
+		if !u.writing && time.Now().After(u.Expires) {
+			ids = append(ids, id)
+			delete(c.uploads, id)
+		}
+	}
+	return ids
+}
+
+func (u *upload) allowed(v *visitor) bool {
+	if u.User == "" {
+		return true
+	}
+	vu := v.User()
+	return vu != nil && vu.ID == u.User
+}
+
+// handleUploadCreate starts a resumable upload (POST /v1/uploads). The total size of the file must be passed in
+// the Upload-Length header, and is checked against the visitor's attachment limits right away, so that clients
+// do not upload large files only to be rejected at the end. The filename can be passed in the Filename header.
+func (s *Server) handleUploadCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.uploads == nil || s.config.Load().BaseURL == "" {
+		return errHTTPBadRequestAttachmentsDisallowed
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return errHTTPBadRequestUploadInvalid
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	if length > vinfo.Limits.AttachmentFileSizeLimit || length > vinfo.Stats.AttachmentTotalSizeRemaining || length > s.fileCache.Remaining() {
+		return errHTTPEntityTooLargeAttachment.Fields(log.Context{
+			"upload_length":                   length,
+			"attachment_total_size_remaining": vinfo.Stats.AttachmentTotalSizeRemaining,
+			"attachment_file_size_limit":      vinfo.Limits.AttachmentFileSizeLimit,
+		})
+	}
+	u := &upload{
+		ID:      util.RandomStringPrefix(uploadIDPrefix, uploadIDLength),
+		Name:    readParam(r, "x-filename", "filename"),
+		Length:  length,
+		Expires: time.Now().Add(uploadExpiryDuration),
+	}
+	if vu := v.User(); vu != nil {
+		u.User = vu.ID
+	} else {
+		u.Sender = v.IP()
+	}
+	if !s.uploads.Add(u) {
+		return errHTTPTooManyRequestsLimitUploads
+	}
+	if _, err := s.fileCache.WritePartial(u.ID, strings.NewReader("")); err != nil {
+		s.uploads.Remove(u.ID)
+		return err
+	}
+	logvr(v, r).
+		Tag(tagUpload).
+		Fields(log.Context{"upload_id": u.ID, "upload_length": u.Length}).
+		Debug("Started upload %s", u.ID)
+	return s.writeUploadResponse(w, u)
+}
+
+// handleUploadGet returns the current offset of an upload (GET/HEAD /v1/uploads/<id>), so that clients know where
+// to resume after the connection dropped
+func (s *Server) handleUploadGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.uploads == nil {
+		return errHTTPNotFound
+	}
+	u, ok := s.uploads.Get(uploadIDFromPath(r), v)
+	if !ok {
+		return errHTTPNotFound
+	}
+	return s.writeUploadResponse(w, u)
+}
+
+// handleUploadPatch appends a chunk to an upload (PATCH /v1/uploads/<id>). The Upload-Offset header must match the
+// current offset of the upload. If the connection drops in the middle of a chunk, all bytes received until then
+// are kept, and the client can resume from the offset returned by handleUploadGet.
+func (s *Server) handleUploadPatch(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.uploads == nil {
+		return errHTTPNotFound
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return errHTTPBadRequestUploadInvalid
+	}
+	id := uploadIDFromPath(r)
+	u, e := s.uploads.Begin(id, v, offset)
+	if e != nil {
+		return e
+	}
+	written, err := s.fileCache.WritePartial(id, r.Body, util.NewFixedLimiter(u.Length-u.Offset))
+	u = s.uploads.End(id, written)
+	if errors.Is(err, util.ErrLimitReached) {
+		return errHTTPEntityTooLargeAttachment
+	} else if err != nil {
+		return err
+	} else if u == nil {
+		return errHTTPNotFound // Upload expired or was cancelled while writing
+	}
+	logvr(v, r).
+		Tag(tagUpload).
+		Fields(log.Context{"upload_id": u.ID, "upload_offset": u.Offset, "upload_length": u.Length}).
+		Trace("Received %d byte(s) for upload %s", written, u.ID)
+	return s.writeUploadResponse(w, u)
+}
+
+// handleUploadDelete cancels an upload (DELETE /v1/uploads/<id>), and deletes the bytes uploaded so far
+func (s *Server) handleUploadDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if s.uploads == nil {
+		return errHTTPNotFound
+	}
+	u, ok := s.uploads.Get(uploadIDFromPath(r), v)
+	if !ok {
+		return errHTTPNotFound
+	}
+	s.uploads.Remove(u.ID)
+	if err := s.fileCache.RemovePartial(u.ID); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleBodyAsUpload publishes one or more completed uploads (X-Upload, comma-separated) as the attachments of the
+// message. The request body is the message text, like for attachments passed by URL. The first upload is stored like
+// a regular attachment, all further uploads are stored next to it, see handleUploadAsExtraAttachment.
+func (s *Server) handleBodyAsUpload(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, uploadIDs string) error {
+	if s.uploads == nil {
+		return errHTTPBadRequestAttachmentsDisallowed.With(m)
+	}
+	ids := strings.Split(uploadIDs, ",")
+	if len(ids) > attachmentsPerMessageMax {
+		return errHTTPBadRequestAttachmentsTooMany.With(m)
+	}
+	uploads := make([]*upload, 0, len(ids))
+	for _, id := range ids {
+		u, ok := s.uploads.Get(strings.TrimSpace(id), v)
+		if !ok {
+			return errHTTPNotFound.With(m).Fields(log.Context{"upload_id": id})
+		} else if u.Offset < u.Length || (m.Attachment != nil && m.Attachment.URL != "") {
+			return errHTTPBadRequestUploadIncomplete.With(m)
+		} else if slices.ContainsFunc(uploads, func(other *upload) bool { return other.ID == u.ID }) {
+			return errHTTPBadRequestUploadInvalid.With(m)
+		}
+		uploads = append(uploads, u)
+	}
+	if err := s.handleBodyAsTextMessage(m, body); err != nil {
+		return err
+	}
+	if m.Attachment == nil && uploads[0].Name != "" {
+		m.Attachment = &attachment{Name: uploads[0].Name}
+	}
+	if err := s.handleUploadAsAttachment(r, v, m, uploads[0]); err != nil {
+		return err
+	}
+	if len(uploads) > 1 {
+		m.Attachments = []*attachment{m.Attachment}
+		for i, u := range uploads[1:] {
+			if err := s.handleUploadAsExtraAttachment(r, v, m, i+1, u); err != nil {
+				s.fileCache.Remove(m.ID) // Do not leave the files of the other attachments behind
+				return err
+			}
+		}
+	}
+	if preview, _ := fromContext[*apiPublishPreviewResponse](r, contextPublishPreview); preview != nil {
+		return nil // Dry run, uploads can still be published
+	}
+	for _, u := range uploads {
+		s.uploads.Remove(u.ID)
+		if err := s.fileCache.RemovePartial(u.ID); err != nil {
+			logvrm(v, r, m).Tag(tagUpload).Err(err).Warn("Error deleting published upload %s", u.ID)
+		}
+	}
+	return nil
+}
+
+// handleUploadAsAttachment stores the given upload as the (first) attachment of the message
+func (s *Server) handleUploadAsAttachment(r *http.Request, v *visitor, m *message, u *upload) error {
+	f, err := s.fileCache.OpenPartial(u.ID)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	file, err := util.Peek(f, s.config.Load().MessageSizeLimit)
+	if err != nil {
+		return err
+	}
+	return s.handleBodyAsAttachment(r, v, m, file)
+}
+
+// handleUploadAsExtraAttachment stores the given upload as an additional attachment of the message, next to the file
+// of the first attachment (see fileCache.WriteExtra). It expires together with the first attachment, so that all
+// attachments of a message are deleted at the same time. The attachment limits apply to each file, and to the
+// sum of all files of the message.
+func (s *Server) handleUploadAsExtraAttachment(r *http.Request, v *visitor, m *message, index int, u *upload) error {
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	f, err := s.fileCache.OpenPartial(u.ID)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	file, err := util.Peek(f, s.config.Load().MessageSizeLimit)
+	if err != nil {
+		return err
+	}
+	a := &attachment{Name: u.Name}
+	var ext string
+	a.Type, ext = util.DetectContentType(file.PeekedBytes, a.Name)
+	if a.Name == "" {
+		a.Name = fmt.Sprintf("attachment%s", ext)
+	}
+	a.Expires = m.Attachment.Expires
+	a.URL = fmt.Sprintf("%s/file/%s/%d%s", s.config.Load().BaseURL, m.ID, index, ext)
+	remaining := vinfo.Stats.AttachmentTotalSizeRemaining
+	for _, other := range m.Attachments {
+		remaining -= other.Size
+	}
+	limiters := []util.Limiter{
+		v.BandwidthLimiter(),
+		util.NewFixedLimiter(s.attachmentFileSizeLimit(v, vinfo, m)),
+		util.NewFixedLimiter(max(remaining, 0)),
+	}
+	var header imageHeader
+	in := withImageHeader(a, file, &header)
+	preview, _ := fromContext[*apiPublishPreviewResponse](r, contextPublishPreview)
+	if preview != nil {
+		a.Size, err = io.Copy(util.NewLimitWriter(io.Discard, limiters[1:]...), in)
+	} else if s.encryption.Enabled(m.Topic) {
+		a.Size, err = s.writeEncryptedAttachment(m, index, in, limiters...)
+	} else {
+		a.Size, err = s.fileCache.WriteExtra(m.ID, index, in, limiters...)
+	}
+	if errors.Is(err, util.ErrLimitReached) {
+		return errHTTPEntityTooLargeAttachment.With(m)
+	} else if err != nil {
+		return err
+	} else if preview == nil {
+		if err := s.scanAttachment(r, v, m, index, a); err != nil {
+			return err
+		}
+	}
+	s.setImageMetadata(m, index, a, &header)
+	m.Attachments = append(m.Attachments, a)
+	maddTopic(metricTopicAttachmentsSize, m.Topic, a.Size)
+	return nil
+}
+
+func (s *Server) writeUploadResponse(w http.ResponseWriter, u *upload) error {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	return s.writeJSON(w, &apiUploadResponse{
+		ID:      u.ID,
+		Offset:  u.Offset,
+		Length:  u.Length,
+		Expires: u.Expires.Unix(),
+	})
+}
+
+func uploadIDFromPath(r *http.Request) string {
+	matches := apiUploadRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return ""
+	}
+	return matches[1]
+}
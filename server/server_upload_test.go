@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Upload_ResumeAndPublish(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	content := strings.Repeat("0123456789", 1000)
+
+	response := request(t, s, "POST", "/v1/uploads", "", map[string]string{
+		"Upload-Length": "10000",
+	})
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(upload.ID, "up_"))
+	require.Equal(t, int64(0), upload.Offset)
+	require.Equal(t, int64(10000), upload.Length)
+
+	// First chunk
+	response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, content[:4000], map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "4000", response.Header().Get("Upload-Offset"))
+
+	// Wrong offset, e.g. because the client did not see the response of the last chunk
+	response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, content[:4000], map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 409, response.Code)
+	require.Equal(t, 40911, toHTTPError(t, response.Body.String()).Code)
+
+	// Resume from where the server is at
+	response = request(t, s, "HEAD", "/v1/uploads/"+upload.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "4000", response.Header().Get("Upload-Offset"))
+	require.Equal(t, "10000", response.Header().Get("Upload-Length"))
+
+	// Publishing an incomplete upload fails
+	response = request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": upload.ID,
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40087, toHTTPError(t, response.Body.String()).Code)
+
+	// More bytes than announced
+	response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, content[4000:]+"x", map[string]string{
+		"Upload-Offset": "4000",
+	})
+	require.Equal(t, 413, response.Code)
+
+	response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, content[4000:], map[string]string{
+		"Upload-Offset": "4000",
+	})
+	require.Equal(t, 200, response.Code)
+	upload, err = util.UnmarshalJSON[apiUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(10000), upload.Offset)
+
+	// Publish, body is the message
+	response = request(t, s, "PUT", "/mytopic", "Here are the digits", map[string]string{
+		"Upload":   upload.ID,
+		"Filename": "digits.txt",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Here are the digits", m.Message)
+	require.Equal(t, "digits.txt", m.Attachment.Name)
+	require.Equal(t, int64(10000), m.Attachment.Size)
+	require.Equal(t, "text/plain; charset=utf-8", m.Attachment.Type)
+
+	file, err := os.ReadFile(filepath.Join(s.config.Load().AttachmentCacheDir, m.ID))
+	require.Nil(t, err)
+	require.Equal(t, content, string(file))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, upload.ID))
+
+	// Upload is gone after publishing
+	response = request(t, s, "GET", "/v1/uploads/"+upload.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Upload_MultipleAttachments(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	ids := make([]string, 0)
+	for _, file := range []struct{ name, content string }{{"a.txt", "first file"}, {"b.txt", "second file"}} {
+		response := request(t, s, "POST", "/v1/uploads", "", map[string]string{
+			"Upload-Length": fmt.Sprintf("%d", len(file.content)),
+			"Filename":      file.name,
+		})
+		require.Equal(t, 200, response.Code)
+		upload, err := util.UnmarshalJSON[apiUploadResponse](io.NopCloser(response.Body))
+		require.Nil(t, err)
+		response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, file.content, map[string]string{
+			"Upload-Offset": "0",
+		})
+		require.Equal(t, 200, response.Code)
+		ids = append(ids, upload.ID)
+	}
+
+	// Same upload twice
+	response := request(t, s, "PUT", "/mytopic", "", map[string]string{
+		"Upload": ids[0] + "," + ids[0],
+	})
+	require.Equal(t, 400, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "Two files", map[string]string{
+		"Upload": ids[0] + ", " + ids[1],
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Two files", m.Message)
+	require.Equal(t, "a.txt", m.Attachment.Name)
+	require.Equal(t, 2, len(m.Attachments))
+	require.Equal(t, "b.txt", m.Attachments[1].Name)
+	require.Equal(t, int64(11), m.Attachments[1].Size)
+	require.Equal(t, m.Attachment.Expires, m.Attachments[1].Expires)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"/1.txt", m.Attachments[1].URL)
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m.ID+".1"))
+	for _, id := range ids {
+		require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, id))
+	}
+
+	response = request(t, s, "GET", "/file/"+m.ID+"/1.txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "second file", response.Body.String())
+	require.Equal(t, `attachment; filename="b.txt"`, response.Header().Get("Content-Disposition"))
+	response = request(t, s, "GET", "/file/"+m.ID+".txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "first file", response.Body.String())
+	response = request(t, s, "GET", "/file/"+m.ID+"/2.txt", "", nil)
+	require.Equal(t, 404, response.Code)
+
+	size, err := s.messageCache.AttachmentBytesUsedBySender("9.9.9.9")
+	require.Nil(t, err)
+	require.Equal(t, int64(21), size)
+
+	// All files of the message are deleted together, e.g. when the attachments expire
+	require.Nil(t, s.fileCache.Remove(m.ID))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m.ID))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, m.ID+".1"))
+}
+
+func TestServer_Upload_Limits(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentFileSizeLimit = 1000
+	s := newTestServer(t, c)
+
+	for _, length := range []string{"", "-1", "abc"} {
+		response := request(t, s, "POST", "/v1/uploads", "", map[string]string{
+			"Upload-Length": length,
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40086, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	response := request(t, s, "POST", "/v1/uploads", "", map[string]string{
+		"Upload-Length": "1001",
+	})
+	require.Equal(t, 413, response.Code)
+
+	for i := 0; i < uploadsPerVisitorLimit; i++ {
+		response = request(t, s, "POST", "/v1/uploads", "", map[string]string{
+			"Upload-Length": "1000",
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	response = request(t, s, "POST", "/v1/uploads", "", map[string]string{
+		"Upload-Length": "1000",
+	})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42918, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Upload_UserAndCancel(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	response := request(t, s, "POST", "/v1/uploads", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Upload-Length": "5",
+	})
+	require.Equal(t, 200, response.Code)
+	upload, err := util.UnmarshalJSON[apiUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	// Only the user who started the upload can continue it
+	response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, "hello", map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "PATCH", "/v1/uploads/"+upload.ID, "hello", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "DELETE", "/v1/uploads/"+upload.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, upload.ID))
+	response = request(t, s, "GET", "/v1/uploads/"+upload.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Upload_Prune(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/v1/uploads", "", map[string]string{
+		"Upload-Length": "100",
+	})
+	require.Equal(t, 200, response.Code)
+	expired, err := util.UnmarshalJSON[apiUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	response = request(t, s, "POST", "/v1/uploads", "", map[string]string{
+		"Upload-Length": "100",
+	})
+	require.Equal(t, 200, response.Code)
+	active, err := util.UnmarshalJSON[apiUploadResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	// Partial upload from before a restart, and an upload that was abandoned
+	orphan := fmt.Sprintf("%s%s", uploadIDPrefix, strings.Repeat("a", uploadIDLength-len(uploadIDPrefix)))
+	require.Nil(t, os.WriteFile(filepath.Join(s.config.Load().AttachmentCacheDir, orphan), []byte("partial"), 0600))
+	s.uploads.mu.Lock()
+	s.uploads.uploads[expired.ID].Expires = time.Now().Add(-time.Minute)
+	s.uploads.mu.Unlock()
+
+	s.execManager()
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, orphan))
+	require.NoFileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, expired.ID))
+	require.FileExists(t, filepath.Join(s.config.Load().AttachmentCacheDir, active.ID))
+	response = request(t, s, "GET", "/v1/uploads/"+expired.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/v1/uploads/"+active.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+}
@@ -0,0 +1,431 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+// WebAuthn confirmation for destructive admin API operations (see require-admin-webauthn)
+//
+// Admins register one or more security keys or passkeys via the account API. Destructive operations
+// (deleting users, changing tiers, revoking all tokens of a user) then require a fresh WebAuthn assertion
+// in the X-WebAuthn header, in addition to the admin's credentials. This protects hosted instances from
+// stolen admin tokens, since the token alone is not enough to perform these operations.
+//
+// The flow is:
+//  1. POST /v1/account/webauthn/challenge returns a one-time challenge
+//  2. The browser calls navigator.credentials.get() with that challenge
+//  3. The result is passed as JSON in the X-WebAuthn header (see apiWebAuthnAssertion)
+//
+// Only the parts of WebAuthn that are needed to verify assertions are implemented. Attestation statements
+// are not verified; the public key is taken from the browser's AuthenticatorAttestationResponse.getPublicKey().
+
+const (
+	webAuthnHeader                 = "X-WebAuthn"
+	webAuthnTypeCreate             = "webauthn.create"
+	webAuthnTypeGet                = "webauthn.get"
+	webAuthnChallengeLength        = 32
+	webAuthnChallengeExpiry        = 5 * time.Minute
+	webAuthnChallengesPerUserLimit = 5
+	webAuthnFlagUserPresent        = 0x01
+	webAuthnAuthenticatorDataMin   = 37 // rpIdHash (32) + flags (1) + signCount (4)
+	webAuthnCredentialNameLimit    = 64
+)
+
+var (
+	errWebAuthnClientDataInvalid        = errors.New("client data invalid")
+	errWebAuthnAuthenticatorDataInvalid = errors.New("authenticator data invalid")
+	errWebAuthnSignatureInvalid         = errors.New("signature invalid")
+	errWebAuthnPublicKeyUnsupported     = errors.New("public key type not supported, must be ECDSA, RSA or Ed25519")
+)
+
+// webAuthnChallenge is a one-time challenge handed out to a user, see handleAccountWebAuthnChallenge
+type webAuthnChallenge struct {
+	value   string
+	expires time.Time
+}
+
+// webAuthnClientData is the subset of the CollectedClientData structure that is verified
+type webAuthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (s *Server) handleAccountWebAuthnChallenge(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	credentials, err := s.userManager.WebAuthnCredentials(u.ID)
+	if err != nil {
+		return err
+	}
+	challenge, err := s.newWebAuthnChallenge(u.ID)
+	if err != nil {
+		return err
+	}
+	credentialIDs := make([]string, len(credentials))
+	for i, c := range credentials {
+		credentialIDs[i] = c.ID
+	}
+	rpID, _, err := s.webAuthnRelyingParty()
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountWebAuthnChallengeResponse{
+		Challenge:   challenge,
+		RPID:        rpID,
+		Credentials: credentialIDs,
+	})
+}
+
+func (s *Server) handleAccountWebAuthnGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	credentials, err := s.userManager.WebAuthnCredentials(v.User().ID)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountWebAuthnCredentialResponse, len(credentials))
+	for i, c := range credentials {
+		response[i] = &apiAccountWebAuthnCredentialResponse{
+			ID:      c.ID,
+			Name:    c.Name,
+			Created: c.Created.Unix(),
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleAccountWebAuthnAdd registers a new credential. It requires the user's password, and, if the user
+// already has credentials, an assertion from one of them, so that a stolen token cannot be used to register
+// an attacker's security key.
+func (s *Server) handleAccountWebAuthnAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountWebAuthnAddRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Password == "" {
+		return errHTTPBadRequest
+	} else if len(req.Name) > webAuthnCredentialNameLimit {
+		return errHTTPBadRequestWebAuthnCredentialInvalid.Wrap("name too long")
+	}
+	if _, err := s.userManager.Authenticate(u.Name, req.Password); err != nil {
+		return errHTTPBadRequestIncorrectPasswordConfirmation
+	}
+	credentials, err := s.userManager.WebAuthnCredentials(u.ID)
+	if err != nil {
+		return err
+	} else if len(credentials) > 0 {
+		if err := s.verifyWebAuthnAssertion(r, u, credentials); err != nil {
+			return err
+		}
+	}
+	credential, err := s.parseWebAuthnRegistration(u, req)
+	if err != nil {
+		return errHTTPBadRequestWebAuthnCredentialInvalid.Wrap("%s", err.Error())
+	}
+	logvr(v, r).Tag(tagAccount).Field("webauthn_credential_id", credential.ID).Info("Adding WebAuthn credential")
+	if err := s.userManager.AddWebAuthnCredential(u.ID, credential); errors.Is(err, user.ErrWebAuthnCredentialExists) {
+		return errHTTPConflictWebAuthnCredentialExists
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleAccountWebAuthnDelete removes a credential. This requires an assertion, otherwise a stolen token
+// could be used to remove all credentials, and with them the confirmation requirement.
+func (s *Server) handleAccountWebAuthnDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountWebAuthnDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	credentials, err := s.userManager.WebAuthnCredentials(u.ID)
+	if err != nil {
+		return err
+	} else if !webAuthnCredentialExists(credentials, req.CredentialID) {
+		return errHTTPBadRequestWebAuthnCredentialInvalid.Wrap("credential not found")
+	}
+	if err := s.verifyWebAuthnAssertion(r, u, credentials); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Field("webauthn_credential_id", req.CredentialID).Info("Removing WebAuthn credential")
+	if err := s.userManager.RemoveWebAuthnCredential(u.ID, req.CredentialID); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// confirmAdminWebAuthn checks the WebAuthn assertion for a destructive admin operation. If
+// require-admin-webauthn is not set, it does nothing. If it is set, the admin must have registered
+// at least one credential, and the request must carry a valid assertion for one of them.
+func (s *Server) confirmAdminWebAuthn(r *http.Request, v *visitor) error {
+	if !s.config.Load().RequireAdminWebAuthn {
+		return nil
+	}
+	u := v.User()
+	if u == nil {
+		return errHTTPUnauthorized
+	}
+	credentials, err := s.userManager.WebAuthnCredentials(u.ID)
+	if err != nil {
+		return err
+	} else if len(credentials) == 0 {
+		return errHTTPUnauthorizedWebAuthnRequired.Wrap("no WebAuthn credential registered for this user")
+	}
+	if err := s.verifyWebAuthnAssertion(r, u, credentials); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Debug("Admin operation confirmed via WebAuthn")
+	return nil
+}
+
+// verifyWebAuthnAssertion verifies the assertion in the X-WebAuthn header against the given credentials,
+// consumes the challenge, and updates the credential's signature counter
+func (s *Server) verifyWebAuthnAssertion(r *http.Request, u *user.User, credentials []*user.WebAuthnCredential) error {
+	header := strings.TrimSpace(r.Header.Get(webAuthnHeader))
+	if header == "" {
+		return errHTTPUnauthorizedWebAuthnRequired
+	}
+	var assertion apiWebAuthnAssertion
+	if err := json.NewDecoder(strings.NewReader(header)).Decode(&assertion); err != nil {
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("cannot parse header")
+	}
+	var credential *user.WebAuthnCredential
+	for _, c := range credentials {
+		if c.ID == assertion.CredentialID {
+			credential = c
+			break
+		}
+	}
+	if credential == nil {
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("credential not registered for this user")
+	}
+	clientDataJSON, err1 := decodeBase64URL(assertion.ClientDataJSON)
+	authenticatorData, err2 := decodeBase64URL(assertion.AuthenticatorData)
+	signature, err3 := decodeBase64URL(assertion.Signature)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("invalid base64 encoding")
+	}
+	rpID, origin, err := s.webAuthnRelyingParty()
+	if err != nil {
+		return err
+	}
+	challenge, err := verifyWebAuthnClientData(clientDataJSON, webAuthnTypeGet, origin)
+	if err != nil {
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("%s", err.Error())
+	}
+	signCount, err := verifyWebAuthnAuthenticatorData(authenticatorData, rpID)
+	if err != nil {
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("%s", err.Error())
+	}
+	if err := verifyWebAuthnSignature(credential.PublicKey, authenticatorData, clientDataJSON, signature); err != nil {
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("%s", err.Error())
+	}
+	if (signCount != 0 || credential.SignCount != 0) && signCount <= credential.SignCount {
+		// A counter that does not increase hints at a cloned authenticator, see WebAuthn spec, section 6.1.1
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("signature counter did not increase")
+	}
+	if !s.consumeWebAuthnChallenge(u.ID, challenge) {
+		return errHTTPUnauthorizedWebAuthnInvalid.Wrap("challenge unknown or expired")
+	}
+	return s.userManager.ChangeWebAuthnSignCount(u.ID, credential.ID, signCount)
+}
+
+// parseWebAuthnRegistration verifies the client data and authenticator data of a navigator.credentials.create()
+// response, consumes the challenge, and returns the new credential
+func (s *Server) parseWebAuthnRegistration(u *user.User, req *apiAccountWebAuthnAddRequest) (*user.WebAuthnCredential, error) {
+	credentialID, err := decodeBase64URL(req.CredentialID)
+	if err != nil || len(credentialID) == 0 {
+		return nil, errors.New("credential ID invalid")
+	}
+	publicKey, err1 := decodeBase64URL(req.PublicKey)
+	clientDataJSON, err2 := decodeBase64URL(req.ClientDataJSON)
+	authenticatorData, err3 := decodeBase64URL(req.AuthenticatorData)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, errors.New("invalid base64 encoding")
+	}
+	if _, err := parseWebAuthnPublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	rpID, origin, err := s.webAuthnRelyingParty()
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := verifyWebAuthnClientData(clientDataJSON, webAuthnTypeCreate, origin)
+	if err != nil {
+		return nil, err
+	}
+	signCount, err := verifyWebAuthnAuthenticatorData(authenticatorData, rpID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.consumeWebAuthnChallenge(u.ID, challenge) {
+		return nil, errors.New("challenge unknown or expired")
+	}
+	return &user.WebAuthnCredential{
+		ID:        base64.RawURLEncoding.EncodeToString(credentialID),
+		PublicKey: publicKey,
+		SignCount: signCount,
+		Name:      req.Name,
+	}, nil
+}
+
+// newWebAuthnChallenge creates a new one-time challenge for the given user. Only a few challenges
+// can be outstanding per user; if there are too many, the oldest one is dropped.
+func (s *Server) newWebAuthnChallenge(userID string) (string, error) {
+	b := make([]byte, webAuthnChallengeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(b)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneWebAuthnChallenges()
+	challenges := append(s.webAuthnChallenges[userID], &webAuthnChallenge{
+		value:   challenge,
+		expires: time.Now().Add(webAuthnChallengeExpiry),
+	})
+	if len(challenges) > webAuthnChallengesPerUserLimit {
+		challenges = challenges[len(challenges)-webAuthnChallengesPerUserLimit:]
+	}
+	s.webAuthnChallenges[userID] = challenges
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge removes the challenge, and returns true if it was outstanding and not expired
+func (s *Server) consumeWebAuthnChallenge(userID, challenge string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenges := s.webAuthnChallenges[userID]
+	for i, c := range challenges {
+		if c.value == challenge {
+			s.webAuthnChallenges[userID] = append(challenges[:i:i], challenges[i+1:]...)
+			return time.Now().Before(c.expires)
+		}
+	}
+	return false
+}
+
+// pruneWebAuthnChallenges removes expired challenges. Must be called with s.mu held.
+func (s *Server) pruneWebAuthnChallenges() {
+	now := time.Now()
+	for userID, challenges := range s.webAuthnChallenges {
+		valid := make([]*webAuthnChallenge, 0, len(challenges))
+		for _, c := range challenges {
+			if now.Before(c.expires) {
+				valid = append(valid, c)
+			}
+		}
+		if len(valid) == 0 {
+			delete(s.webAuthnChallenges, userID)
+		} else {
+			s.webAuthnChallenges[userID] = valid
+		}
+	}
+}
+
+// webAuthnRelyingParty returns the relying party ID (the host name) and origin, as derived from base-url
+func (s *Server) webAuthnRelyingParty() (rpID string, origin string, err error) {
+	u, err := url.Parse(s.config.Load().BaseURL)
+	if err != nil || u.Host == "" {
+		return "", "", errHTTPInternalError.Wrap("base-url must be set for WebAuthn")
+	}
+	return u.Hostname(), fmt.Sprintf("%s://%s", u.Scheme, u.Host), nil
+}
+
+// verifyWebAuthnClientData checks the type and origin of the client data, and returns the challenge
+func verifyWebAuthnClientData(clientDataJSON []byte, typ, origin string) (string, error) {
+	var clientData webAuthnClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return "", errWebAuthnClientDataInvalid
+	} else if clientData.Type != typ {
+		return "", fmt.Errorf("%w: unexpected type %s", errWebAuthnClientDataInvalid, clientData.Type)
+	} else if clientData.Origin != origin {
+		return "", fmt.Errorf("%w: unexpected origin %s", errWebAuthnClientDataInvalid, clientData.Origin)
+	} else if clientData.Challenge == "" {
+		return "", fmt.Errorf("%w: challenge missing", errWebAuthnClientDataInvalid)
+	}
+	return clientData.Challenge, nil
+}
+
+// verifyWebAuthnAuthenticatorData checks the relying party ID hash and the user presence flag,
+// and returns the signature counter
+func verifyWebAuthnAuthenticatorData(authenticatorData []byte, rpID string) (uint32, error) {
+	if len(authenticatorData) < webAuthnAuthenticatorDataMin {
+		return 0, errWebAuthnAuthenticatorDataInvalid
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(authenticatorData[:32], rpIDHash[:]) {
+		return 0, fmt.Errorf("%w: relying party ID mismatch", errWebAuthnAuthenticatorDataInvalid)
+	} else if authenticatorData[32]&webAuthnFlagUserPresent == 0 {
+		return 0, fmt.Errorf("%w: user not present", errWebAuthnAuthenticatorDataInvalid)
+	}
+	return binary.BigEndian.Uint32(authenticatorData[33:37]), nil
+}
+
+// verifyWebAuthnSignature verifies the assertion signature over authenticatorData || SHA-256(clientDataJSON)
+func verifyWebAuthnSignature(publicKey, authenticatorData, clientDataJSON, signature []byte) error {
+	pub, err := parseWebAuthnPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+	var valid bool
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, signed, signature)
+	}
+	if !valid {
+		return errWebAuthnSignatureInvalid
+	}
+	return nil
+}
+
+func parseWebAuthnPublicKey(publicKey []byte) (crypto.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, errWebAuthnPublicKeyUnsupported
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return pub, nil
+	}
+	return nil, errWebAuthnPublicKeyUnsupported
+}
+
+func webAuthnCredentialExists(credentials []*user.WebAuthnCredential, id string) bool {
+	for _, c := range credentials {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBase64URL decodes base64 URL-encoded strings, with or without padding, as WebAuthn
+// libraries are not consistent in that regard
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_WebAuthn_DeleteUser(t *testing.T) {
+	s, key := newTestServerWithWebAuthn(t)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	// No credential registered yet
+	rr := request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40102, toHTTPError(t, rr.Body.String()).Code)
+
+	// Register credential
+	key.register(t, s)
+
+	// Missing assertion
+	rr = request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40102, toHTTPError(t, rr.Body.String()).Code)
+
+	// Assertion with unknown challenge
+	rr = request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    key.assert(t, "not-a-challenge", s.config.Load().BaseURL),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40103, toHTTPError(t, rr.Body.String()).Code)
+
+	// Assertion with wrong origin
+	rr = request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    key.assert(t, key.challenge(t, s), "https://evil.example.com"),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40103, toHTTPError(t, rr.Body.String()).Code)
+
+	// Valid assertion
+	challenge := key.challenge(t, s)
+	assertion := key.assert(t, challenge, s.config.Load().BaseURL)
+	rr = request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    assertion,
+	})
+	require.Equal(t, 200, rr.Code)
+	_, err := s.userManager.User("ben")
+	require.Equal(t, user.ErrUserNotFound, err)
+
+	// Replaying the same assertion fails
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	rr = request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    assertion,
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40103, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_WebAuthn_ChangeTierAndRevokeTokens(t *testing.T) {
+	s, key := newTestServerWithWebAuthn(t)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro"}))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	_, err = s.userManager.CreateToken(ben.ID, "token1", time.Time{}, netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	_, err = s.userManager.CreateToken(ben.ID, "token2", time.Time{}, netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	key.register(t, s)
+
+	// Password changes do not require confirmation
+	rr := request(t, s, "PUT", "/v1/users", `{"username": "ben", "password": "ben2"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Tier change requires confirmation
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "ben", "tier": "pro"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "ben", "tier": "pro"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    key.assert(t, key.challenge(t, s), s.config.Load().BaseURL),
+	})
+	require.Equal(t, 200, rr.Code)
+	ben, err = s.userManager.User("ben")
+	require.Nil(t, err)
+	require.Equal(t, "pro", ben.Tier.Code)
+
+	// Revoking all tokens requires confirmation
+	rr = request(t, s, "DELETE", "/v1/users/tokens", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/users/tokens", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    key.assert(t, key.challenge(t, s), s.config.Load().BaseURL),
+	})
+	require.Equal(t, 200, rr.Code)
+	response, err := util.UnmarshalJSON[apiUsersTokensDeleteResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, int64(2), response.Removed)
+	tokens, err := s.userManager.Tokens(ben.ID)
+	require.Nil(t, err)
+	require.Empty(t, tokens)
+}
+
+func TestServer_WebAuthn_PurgeTopic(t *testing.T) {
+	s, key := newTestServerWithWebAuthn(t)
+	rr := request(t, s, "PUT", "/spam", "spam message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	key.register(t, s)
+
+	// Purging a topic requires confirmation
+	rr = request(t, s, "POST", "/v1/admin/topics/purge", `{"topic":"spam"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	ids, err := s.messageCache.MessageIDs("spam")
+	require.Nil(t, err)
+	require.Len(t, ids, 1)
+
+	rr = request(t, s, "POST", "/v1/admin/topics/purge", `{"topic":"spam"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    key.assert(t, key.challenge(t, s), s.config.Load().BaseURL),
+	})
+	require.Equal(t, 200, rr.Code)
+	ids, err = s.messageCache.MessageIDs("spam")
+	require.Nil(t, err)
+	require.Empty(t, ids)
+}
+
+func TestServer_WebAuthn_RevokeTokens_NotRequired(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	_, err = s.userManager.CreateToken(ben.ID, "token1", time.Time{}, netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+
+	rr := request(t, s, "DELETE", "/v1/users/tokens", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	tokens, err := s.userManager.Tokens(ben.ID)
+	require.Nil(t, err)
+	require.Empty(t, tokens)
+
+	// Credential endpoints are not available
+	rr = request(t, s, "POST", "/v1/account/webauthn/challenge", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestServer_WebAuthn_AddSecondCredentialAndDelete(t *testing.T) {
+	s, key := newTestServerWithWebAuthn(t)
+	key.register(t, s)
+
+	// Registering a second key requires an assertion from the first key
+	key2 := newTestWebAuthnKey(t, "key2")
+	rr := request(t, s, "POST", "/v1/account/webauthn", key2.registrationBody(t, key2.challenge(t, s), s.config.Load().BaseURL, "phil"), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "POST", "/v1/account/webauthn", key2.registrationBody(t, key2.challenge(t, s), s.config.Load().BaseURL, "phil"), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    key.assert(t, key.challenge(t, s), s.config.Load().BaseURL),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account/webauthn", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	credentials, err := util.UnmarshalJSON[[]*apiAccountWebAuthnCredentialResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*credentials))
+	require.Equal(t, "key1", (*credentials)[0].Name)
+	require.Equal(t, "key2", (*credentials)[1].Name)
+
+	// Delete first key using second key
+	rr = request(t, s, "DELETE", "/v1/account/webauthn", fmt.Sprintf(`{"credential_id":"%s"}`, key.id), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-WebAuthn":    key2.assert(t, key2.challenge(t, s), s.config.Load().BaseURL),
+	})
+	require.Equal(t, 200, rr.Code)
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	remaining, err := s.userManager.WebAuthnCredentials(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(remaining))
+	require.Equal(t, key2.id, remaining[0].ID)
+}
+
+func TestServer_WebAuthn_AddCredential_WrongPassword(t *testing.T) {
+	s, key := newTestServerWithWebAuthn(t)
+	rr := request(t, s, "POST", "/v1/account/webauthn", key.registrationBody(t, key.challenge(t, s), s.config.Load().BaseURL, "wrong"), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40026, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestVerifyWebAuthnAuthenticatorData(t *testing.T) {
+	rpIDHash := sha256.Sum256([]byte("ntfy.example.com"))
+	authData := append(rpIDHash[:], webAuthnFlagUserPresent, 0, 0, 1, 2)
+	signCount, err := verifyWebAuthnAuthenticatorData(authData, "ntfy.example.com")
+	require.Nil(t, err)
+	require.Equal(t, uint32(258), signCount)
+
+	_, err = verifyWebAuthnAuthenticatorData(authData, "evil.example.com")
+	require.ErrorIs(t, err, errWebAuthnAuthenticatorDataInvalid)
+
+	authData[32] = 0 // User not present
+	_, err = verifyWebAuthnAuthenticatorData(authData, "ntfy.example.com")
+	require.ErrorIs(t, err, errWebAuthnAuthenticatorDataInvalid)
+
+	_, err = verifyWebAuthnAuthenticatorData(authData[:10], "ntfy.example.com")
+	require.ErrorIs(t, err, errWebAuthnAuthenticatorDataInvalid)
+}
+
+func newTestServerWithWebAuthn(t *testing.T) (*Server, *testWebAuthnKey) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.RequireAdminWebAuthn = true
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	return s, newTestWebAuthnKey(t, "key1")
+}
+
+// testWebAuthnKey simulates a security key (authenticator) and the browser's WebAuthn API
+type testWebAuthnKey struct {
+	id        string
+	name      string
+	key       *ecdsa.PrivateKey
+	signCount uint32
+}
+
+func newTestWebAuthnKey(t *testing.T, name string) *testWebAuthnKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	require.Nil(t, err)
+	return &testWebAuthnKey{
+		id:   base64.RawURLEncoding.EncodeToString(id),
+		name: name,
+		key:  key,
+	}
+}
+
+func (k *testWebAuthnKey) challenge(t *testing.T, s *Server) string {
+	rr := request(t, s, "POST", "/v1/account/webauthn/challenge", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	response, err := util.UnmarshalJSON[apiAccountWebAuthnChallengeResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1", response.RPID)
+	return response.Challenge
+}
+
+func (k *testWebAuthnKey) register(t *testing.T, s *Server) {
+	rr := request(t, s, "POST", "/v1/account/webauthn", k.registrationBody(t, k.challenge(t, s), s.config.Load().BaseURL, "phil"), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func (k *testWebAuthnKey) registrationBody(t *testing.T, challenge, origin, password string) string {
+	publicKey, err := x509.MarshalPKIXPublicKey(&k.key.PublicKey)
+	require.Nil(t, err)
+	body, err := json.Marshal(&apiAccountWebAuthnAddRequest{
+		Password:          password,
+		Name:              k.name,
+		CredentialID:      k.id,
+		PublicKey:         base64.RawURLEncoding.EncodeToString(publicKey),
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(k.clientData(t, webAuthnTypeCreate, challenge, origin)),
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(k.authenticatorData()),
+	})
+	require.Nil(t, err)
+	return string(body)
+}
+
+func (k *testWebAuthnKey) assert(t *testing.T, challenge, origin string) string {
+	k.signCount++
+	clientData := k.clientData(t, webAuthnTypeGet, challenge, origin)
+	authData := k.authenticatorData()
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, k.key, digest[:])
+	require.Nil(t, err)
+	assertion, err := json.Marshal(&apiWebAuthnAssertion{
+		CredentialID:      k.id,
+		ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientData),
+		AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+		Signature:         base64.RawURLEncoding.EncodeToString(signature),
+	})
+	require.Nil(t, err)
+	return string(assertion)
+}
+
+func (k *testWebAuthnKey) clientData(t *testing.T, typ, challenge, origin string) []byte {
+	clientData, err := json.Marshal(&webAuthnClientData{
+		Type:      typ,
+		Challenge: challenge,
+		Origin:    origin,
+	})
+	require.Nil(t, err)
+	return clientData
+}
+
+func (k *testWebAuthnKey) authenticatorData() []byte {
+	rpIDHash := sha256.Sum256([]byte("127.0.0.1"))
+	authData := append(rpIDHash[:], webAuthnFlagUserPresent)
+	return binary.BigEndian.AppendUint32(authData, k.signCount)
+}
@@ -0,0 +1,421 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	webhookTimeout       = 10 * time.Second
+	webhookTopicLimit    = 5    // Max number of webhooks per topic
+	webhookURLLimit      = 2048 // Characters
+	webhookConcurrency   = 50   // Max number of webhook requests in flight
+	webhookResponseLimit = 512  // Only this many bytes of an error response are kept in the delivery log
+)
+
+var (
+	// webhookRetryDelays are the delays between delivery attempts. A delivery is retried if the request
+	// failed, or if the receiver responded with a 5xx or 429 status code.
+	webhookRetryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+	errWebhookAddressNotAllowed = errors.New("address not allowed")
+)
+
+// WebhookTLS defines the TLS settings for outgoing webhooks to a destination host (see webhook-tls), e.g. to
+// present a client certificate (mTLS), or to verify the receiver's certificate against an internal CA
+type WebhookTLS struct {
+	Host     string // Hostname of the destination, or *.example.com for all subdomains of example.com
+	CertFile string // Client certificate (PEM), requires KeyFile
+	KeyFile  string // Private key of the client certificate (PEM)
+	CAFile   string // CA bundle (PEM) to verify the destination's certificate against, instead of the system roots
+}
+
+// Matches returns true if the TLS settings apply to the given hostname
+func (t *WebhookTLS) Matches(host string) bool {
+	if suffix, ok := strings.CutPrefix(t.Host, "*."); ok {
+		return strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(suffix))
+	}
+	return strings.EqualFold(t.Host, host)
+}
+
+// webhookSender POSTs messages to the outgoing webhooks of topics (see enable-webhooks), either as JSON, or converted
+// to a Slack or Discord payload (see user.WebhookFormat and newWebhookPayload). Each request is signed
+// with the webhook's secret, so that receivers can verify that it came from this server:
+//
+//	X-Ntfy-Signature: sha256=hex(HMAC-SHA256(secret, "<X-Ntfy-Timestamp>.<body>"))
+//
+// Like for link previews, connections to non-public addresses are refused to prevent server-side request forgery,
+// unless webhook-allow-private-networks is set. Redirects are not followed. Destinations with TLS settings (see
+// WebhookTLS) are called with a separate client, the first matching settings win.
+type webhookSender struct {
+	client      *http.Client
+	tlsClients  []*webhookTLSClient
+	userAgent   string
+	allowAddr   func(addr netip.Addr) bool // Can be replaced in tests
+	retryDelays []time.Duration            // Can be replaced in tests
+	slots       chan struct{}
+}
+
+type webhookTLSClient struct {
+	tls    *WebhookTLS
+	client *http.Client
+}
+
+func newWebhookSender(userAgent string, allowPrivateNetworks bool, tlsSettings []*WebhookTLS) (*webhookSender, error) {
+	sender := &webhookSender{
+		userAgent:   userAgent,
+		allowAddr:   publicAddr,
+		retryDelays: webhookRetryDelays,
+		slots:       make(chan struct{}, webhookConcurrency),
+	}
+	if allowPrivateNetworks {
+		sender.allowAddr = func(addr netip.Addr) bool { return true }
+	}
+	sender.client = sender.newClient(nil)
+	for _, settings := range tlsSettings {
+		tlsConfig, err := newWebhookTLSConfig(settings)
+		if err != nil {
+			return nil, err
+		}
+		sender.tlsClients = append(sender.tlsClients, &webhookTLSClient{
+			tls:    settings,
+			client: sender.newClient(tlsConfig),
+		})
+	}
+	return sender, nil
+}
+
+func (w *webhookSender) newClient(tlsConfig *tls.Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: w.dialControl,
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, // Never use a proxy, the address check would only see the proxy address
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   webhookTimeout,
+			ResponseHeaderTimeout: webhookTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// newWebhookTLSConfig loads the client certificate and CA bundle of the given TLS settings
+func newWebhookTLSConfig(settings *WebhookTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if settings.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate for webhook host %s: %w", settings.Host, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle for webhook host %s: %w", settings.Host, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cannot read CA bundle for webhook host %s: no PEM certificates found in %s", settings.Host, settings.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// clientFor returns the HTTP client for the given webhook URL, i.e. the client of the first matching
+// TLS settings, or the default client
+func (w *webhookSender) clientFor(u *url.URL) *http.Client {
+	for _, c := range w.tlsClients {
+		if c.tls.Matches(u.Hostname()) {
+			return c.client
+		}
+	}
+	return w.client
+}
+
+// Send makes a single delivery attempt, and returns the HTTP status code of the response (0 if there was none),
+// and an error if the request failed or the status code was not 2xx
+func (w *webhookSender) Send(webhook *user.TopicWebhook, payload []byte) (int, error) {
+	w.slots <- struct{}{}
+	defer func() { <-w.slots }()
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("User-Agent", w.userAgent)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ntfy-Webhook-Id", webhook.ID)
+	req.Header.Set("X-Ntfy-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Ntfy-Signature", webhookSignature(webhook.Secret, timestamp, payload))
+	resp, err := w.clientFor(req.URL).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+		return resp.StatusCode, fmt.Errorf("unexpected HTTP status %s: %s", resp.Status, string(body))
+	}
+	return resp.StatusCode, nil
+}
+
+// dialControl is called right before connecting to the resolved address, see net.Dialer
+func (w *webhookSender) dialControl(_, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	} else if !w.allowAddr(addrPort.Addr().Unmap()) {
+		return errWebhookAddressNotAllowed
+	}
+	return nil
+}
+
+// webhookSignature returns the value of the X-Ntfy-Signature header, see webhookSender
+func webhookSignature(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryable returns true if a failed delivery attempt should be retried
+func webhookRetryable(statusCode int) bool {
+	return statusCode == 0 || statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
+
+// publishToWebhooks delivers the message to all outgoing webhooks of its topic, see deliverToWebhook
+func (s *Server) publishToWebhooks(v *visitor, m *message) {
+	webhooks, err := s.userManager.TopicWebhooks(m.Topic)
+	if err != nil {
+		logvm(v, m).Tag(tagWebhook).Err(err).Warn("Unable to read webhooks for topic")
+		return
+	} else if len(webhooks) == 0 {
+		return
+	}
+	logvm(v, m).Tag(tagWebhook).Debug("Publishing message to %d webhook(s)", len(webhooks))
+	payloads := make(map[user.WebhookFormat][]byte)
+	for _, webhook := range webhooks {
+		payload, ok := payloads[webhook.Format]
+		if !ok {
+			if payload, err = newWebhookPayload(webhook.Format, m); err != nil {
+				logvm(v, m).Tag(tagWebhook).Err(err).Warn("Unable to marshal webhook payload")
+				return
+			}
+			payloads[webhook.Format] = payload
+		}
+		go s.deliverToWebhook(v, m, webhook, payload)
+	}
+}
+
+// deliverToWebhook POSTs the payload to the webhook, and retries with backoff (see webhookRetryDelays) if the
+// attempt failed. The outcome is recorded in the webhook's delivery log. Retries are kept in memory only, so
+// pending retries are dropped when the server shuts down.
+func (s *Server) deliverToWebhook(v *visitor, m *message, webhook *user.TopicWebhook, payload []byte) {
+	ev := logvm(v, m).Tag(tagWebhook).Field("webhook_id", webhook.ID)
+	var statusCode, attempts int
+	var err error
+	for {
+		statusCode, err = s.webhookSender.Send(webhook, payload)
+		attempts++
+		if err == nil || attempts > len(s.webhookSender.retryDelays) || !webhookRetryable(statusCode) {
+			break
+		}
+		delay := s.webhookSender.retryDelays[attempts-1]
+		ev.Err(err).Debug("Webhook delivery attempt %d failed, retrying in %s", attempts, delay)
+		select {
+		case <-time.After(delay):
+		case <-s.closeChan:
+			return
+		}
+	}
+	delivery := &user.WebhookDelivery{
+		WebhookID:  webhook.ID,
+		MessageID:  m.ID,
+		Time:       time.Now(),
+		Attempts:   attempts,
+		StatusCode: statusCode,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+		ev.Err(err).Warn("Unable to deliver message to webhook after %d attempt(s)", attempts)
+		s.deliveries.Failure(deliveryChannelWebhook)
+	} else {
+		ev.Debug("Delivered message to webhook")
+		s.deliveries.Success(deliveryChannelWebhook)
+	}
+	if err := s.userManager.AddWebhookDelivery(delivery); err != nil {
+		ev.Err(err).Warn("Unable to record webhook delivery")
+	}
+}
+
+func (s *Server) handleAccountReservationWebhooksGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	webhooks, err := s.userManager.TopicWebhooks(topic)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountWebhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		response = append(response, newAPIAccountWebhook(webhook))
+	}
+	return s.writeJSON(w, response)
+}
+
+func (s *Server) handleAccountReservationWebhookAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountWebhookAddRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	webhook, err := s.addTopicWebhook(r, v, topic, req.URL, req.Format)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newAPIAccountWebhook(webhook))
+}
+
+// addTopicWebhook validates the URL and format (empty means "ntfy"), and adds an outgoing webhook to the topic,
+// owned by the visitor's user. The caller must ensure that the user owns the topic reservation.
+func (s *Server) addTopicWebhook(r *http.Request, v *visitor, topic, webhookURL, webhookFormat string) (*user.TopicWebhook, error) {
+	if !v.FeatureAllowed(user.TierFeatureWebhooks) {
+		return nil, errHTTPForbiddenTierFeature.Wrap("%s", user.TierFeatureWebhooks)
+	}
+	u, err := url.Parse(webhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(webhookURL) > webhookURLLimit {
+		return nil, errHTTPBadRequestWebhookURLInvalid
+	}
+	format := user.WebhookFormatNtfy
+	if webhookFormat != "" {
+		format = user.WebhookFormat(webhookFormat)
+	}
+	if !user.AllowedWebhookFormat(format) {
+		return nil, errHTTPBadRequestWebhookFormatInvalid
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Field("topic", topic).
+		Debug("Adding webhook for topic %s", topic)
+	webhook, err := s.userManager.AddTopicWebhook(v.User().Name, topic, webhookURL, format, webhookTopicLimit)
+	if errors.Is(err, user.ErrTooManyTopicWebhooks) {
+		return nil, errHTTPTooManyRequestsLimitWebhooks
+	} else if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *Server) handleAccountReservationWebhookDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	if err := s.removeTopicWebhook(r, v, topic, webhookIDFromPath(r.URL.Path)); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) removeTopicWebhook(r *http.Request, v *visitor, topic, webhookID string) error {
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{"topic": topic, "webhook_id": webhookID}).
+		Debug("Removing webhook for topic %s", topic)
+	if err := s.userManager.RemoveTopicWebhook(topic, webhookID); errors.Is(err, user.ErrTopicWebhookNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleAccountReservationWebhookDeliveriesGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.ownedReservationTopicFromPath(v, r.URL.Path)
+	if err != nil {
+		return err
+	}
+	webhooks, err := s.userManager.TopicWebhooks(topic)
+	if err != nil {
+		return err
+	}
+	webhookID := webhookIDFromPath(r.URL.Path)
+	for _, webhook := range webhooks {
+		if webhook.ID != webhookID {
+			continue
+		}
+		deliveries, err := s.userManager.WebhookDeliveries(webhook.ID)
+		if err != nil {
+			return err
+		}
+		response := make([]*apiAccountWebhookDelivery, 0, len(deliveries))
+		for _, delivery := range deliveries {
+			response = append(response, &apiAccountWebhookDelivery{
+				MessageID:  delivery.MessageID,
+				Time:       delivery.Time.Unix(),
+				Attempts:   delivery.Attempts,
+				StatusCode: delivery.StatusCode,
+				Error:      delivery.Error,
+			})
+		}
+		return s.writeJSON(w, response)
+	}
+	return errHTTPNotFound
+}
+
+func webhookIDFromPath(path string) string {
+	if matches := apiAccountReservationWebhookRegex.FindStringSubmatch(path); len(matches) == 3 {
+		return matches[2]
+	} else if matches := apiAccountReservationWebhookDeliveriesRegex.FindStringSubmatch(path); len(matches) == 3 {
+		return matches[2]
+	}
+	return ""
+}
+
+func newAPIAccountWebhook(webhook *user.TopicWebhook) *apiAccountWebhook {
+	return &apiAccountWebhook{
+		ID:      webhook.ID,
+		URL:     webhook.URL,
+		Format:  string(webhook.Format),
+		Secret:  webhook.Secret,
+		Created: webhook.Created.Unix(),
+	}
+}
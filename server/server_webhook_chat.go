@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	slackHeaderLimit        = 150  // Characters, see https://api.slack.com/reference/block-kit/blocks#header
+	slackSectionLimit       = 3000 // Characters, see https://api.slack.com/reference/block-kit/blocks#section
+	discordUsername         = "ntfy"
+	discordTitleLimit       = 256  // Characters, see https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+	discordDescriptionLimit = 4096 // Characters
+)
+
+// discordPriorityColors are the colors of the embed's left border, by message priority
+var discordPriorityColors = map[int]int{
+	1: 0x9e9e9e, // Grey
+	2: 0x9e9e9e,
+	3: 0x338574, // ntfy green
+	4: 0xff9800, // Orange
+	5: 0xf44336, // Red
+}
+
+type slackPayload struct {
+	Text   string        `json:"text"` // Fallback for notifications
+	Blocks []*slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string     `json:"type"`
+	Text     *slackText `json:"text,omitempty"`
+	Elements []any      `json:"elements,omitempty"` // Buttons (*slackBlock) or texts (*slackText) of actions and context blocks
+	URL      string     `json:"url,omitempty"`      // For buttons
+	ImageURL string     `json:"image_url,omitempty"`
+	AltText  string     `json:"alt_text,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type discordPayload struct {
+	Username string          `json:"username"`
+	Embeds   []*discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title       string               `json:"title,omitempty"`
+	Description string               `json:"description,omitempty"`
+	URL         string               `json:"url,omitempty"`
+	Color       int                  `json:"color"`
+	Timestamp   string               `json:"timestamp"`
+	Footer      *discordEmbedFooter  `json:"footer"`
+	Image       *discordEmbedImage   `json:"image,omitempty"`
+	Fields      []*discordEmbedField `json:"fields,omitempty"`
+}
+
+type discordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type discordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+type discordEmbedField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// newWebhookPayload returns the body that is POSTed to a webhook of the given format, see user.WebhookFormat
+func newWebhookPayload(format user.WebhookFormat, m *message) ([]byte, error) {
+	switch format {
+	case user.WebhookFormatSlack:
+		return json.Marshal(newSlackPayload(m))
+	case user.WebhookFormatDiscord:
+		return json.Marshal(newDiscordPayload(m))
+	default:
+		return json.Marshal(m)
+	}
+}
+
+// newSlackPayload converts a message to a Slack incoming webhook payload (https://api.slack.com/messaging/webhooks),
+// with the title as header, the message as section, an image block for image attachments, and buttons for the
+// click URL and other attachments
+func newSlackPayload(m *message) *slackPayload {
+	title := chatWebhookTitle(m)
+	blocks := make([]*slackBlock, 0)
+	if title != "" {
+		blocks = append(blocks, &slackBlock{
+			Type: "header",
+			Text: &slackText{Type: "plain_text", Text: truncateRunes(title, slackHeaderLimit)},
+		})
+	}
+	if m.Message != "" {
+		blocks = append(blocks, &slackBlock{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: truncateRunes(slackEscape(m.Message), slackSectionLimit)},
+		})
+	}
+	buttons := make([]any, 0)
+	if m.Click != "" {
+		buttons = append(buttons, &slackBlock{Type: "button", Text: &slackText{Type: "plain_text", Text: "Open"}, URL: m.Click})
+	}
+	if m.Attachment != nil && strings.HasPrefix(m.Attachment.Type, "image/") {
+		blocks = append(blocks, &slackBlock{Type: "image", ImageURL: m.Attachment.URL, AltText: m.Attachment.Name})
+	} else if m.Attachment != nil {
+		buttons = append(buttons, &slackBlock{Type: "button", Text: &slackText{Type: "plain_text", Text: truncateRunes(m.Attachment.Name, 75)}, URL: m.Attachment.URL})
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, &slackBlock{Type: "actions", Elements: buttons})
+	}
+	blocks = append(blocks, &slackBlock{
+		Type:     "context",
+		Elements: []any{&slackText{Type: "mrkdwn", Text: slackEscape(chatWebhookFooter(m))}},
+	})
+	text := m.Message
+	if title != "" {
+		text = title + ": " + m.Message
+	}
+	return &slackPayload{
+		Text:   truncateRunes(slackEscape(text), slackSectionLimit),
+		Blocks: blocks,
+	}
+}
+
+// newDiscordPayload converts a message to a Discord webhook payload with a single embed
+// (https://discord.com/developers/docs/resources/webhook#execute-webhook). The embed is colored by priority.
+func newDiscordPayload(m *message) *discordPayload {
+	embed := &discordEmbed{
+		Title:       truncateRunes(chatWebhookTitle(m), discordTitleLimit),
+		Description: truncateRunes(m.Message, discordDescriptionLimit),
+		URL:         m.Click,
+		Color:       discordPriorityColors[3],
+		Timestamp:   time.Unix(m.Time, 0).UTC().Format(time.RFC3339),
+		Footer:      &discordEmbedFooter{Text: chatWebhookFooter(m)},
+	}
+	if color, ok := discordPriorityColors[m.Priority]; ok {
+		embed.Color = color
+	}
+	if m.Attachment != nil && strings.HasPrefix(m.Attachment.Type, "image/") {
+		embed.Image = &discordEmbedImage{URL: m.Attachment.URL}
+	} else if m.Attachment != nil {
+		embed.Fields = []*discordEmbedField{{Name: "Attachment", Value: fmt.Sprintf("[%s](%s)", m.Attachment.Name, m.Attachment.URL)}}
+	}
+	return &discordPayload{
+		Username: discordUsername,
+		Embeds:   []*discordEmbed{embed},
+	}
+}
+
+// chatWebhookTitle returns the title of the message, prefixed with the emojis of its tags (like in e-mails)
+func chatWebhookTitle(m *message) string {
+	emojis, _, err := toEmojis(m.Tags)
+	if err != nil || len(emojis) == 0 {
+		return m.Title
+	} else if m.Title == "" {
+		return strings.Join(emojis, " ")
+	}
+	return strings.Join(emojis, " ") + " " + m.Title
+}
+
+// chatWebhookFooter returns the topic of the message, along with its non-emoji tags and priority (if not default)
+func chatWebhookFooter(m *message) string {
+	parts := []string{m.Topic}
+	if _, tags, err := toEmojis(m.Tags); err == nil && len(tags) > 0 {
+		parts = append(parts, strings.Join(tags, ", "))
+	}
+	if m.Priority != 0 && m.Priority != 3 {
+		parts = append(parts, fmt.Sprintf("priority %d", m.Priority))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// slackEscape escapes the control characters of Slack's mrkdwn format, see
+// https://api.slack.com/reference/surfaces/formatting#escaping
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// truncateRunes truncates s to at most limit characters, and adds an ellipsis if it was truncated
+func truncateRunes(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return string([]rune(s)[:limit-1]) + "…"
+}
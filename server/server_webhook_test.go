@@ -0,0 +1,460 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Webhooks_PublishAndSignature(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	s := newTestServerWithWebhooks(t)
+	webhook := addTestWebhook(t, s, receiver.URL+"/hook")
+	require.Regexp(t, `^wh_[A-Za-z0-9]{9}$`, webhook.ID)
+	require.Regexp(t, `^whsec_[A-Za-z0-9]{26}$`, webhook.Secret)
+	require.Equal(t, "ntfy", webhook.Format)
+
+	response := request(t, s, "PUT", "/mytopic", "hi there", map[string]string{
+		"Title": "a title",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	var r *http.Request
+	var body []byte
+	select {
+	case r = <-received:
+		body = <-bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+	require.Equal(t, "POST", r.Method)
+	require.Equal(t, "/hook", r.URL.Path)
+	require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	require.Equal(t, webhook.ID, r.Header.Get("X-Ntfy-Webhook-Id"))
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Ntfy-Timestamp"), 10, 64)
+	require.Nil(t, err)
+	require.Equal(t, webhookSignature(webhook.Secret, timestamp, body), r.Header.Get("X-Ntfy-Signature"))
+	require.NotEqual(t, webhookSignature("whsec_wrong", timestamp, body), r.Header.Get("X-Ntfy-Signature"))
+	payload := toMessage(t, string(body))
+	require.Equal(t, m.ID, payload.ID)
+	require.Equal(t, "hi there", payload.Message)
+	require.Equal(t, "a title", payload.Title)
+
+	// Delivery is logged
+	waitFor(t, func() bool {
+		return len(listTestWebhookDeliveries(t, s, webhook.ID)) == 1
+	})
+	deliveries := listTestWebhookDeliveries(t, s, webhook.ID)
+	require.Equal(t, m.ID, deliveries[0].MessageID)
+	require.Equal(t, 1, deliveries[0].Attempts)
+	require.Equal(t, 200, deliveries[0].StatusCode)
+	require.Equal(t, "", deliveries[0].Error)
+
+	// Messages that exclude the webhook channel are not delivered
+	response = request(t, s, "PUT", "/mytopic", "not for webhooks", map[string]string{
+		"X-Channels":    "none",
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	select {
+	case <-received:
+		t.Fatal("webhook should not have been called")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestServer_Webhooks_Retry(t *testing.T) {
+	var count atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	s := newTestServerWithWebhooks(t)
+	s.webhookSender.retryDelays = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}
+	webhook := addTestWebhook(t, s, receiver.URL)
+
+	response := request(t, s, "PUT", "/mytopic", "retry me", nil)
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(listTestWebhookDeliveries(t, s, webhook.ID)) == 1
+	})
+	deliveries := listTestWebhookDeliveries(t, s, webhook.ID)
+	require.Equal(t, 3, deliveries[0].Attempts)
+	require.Equal(t, 200, deliveries[0].StatusCode)
+	require.Equal(t, int32(3), count.Load())
+}
+
+func TestServer_Webhooks_FailureNotRetried(t *testing.T) {
+	var count atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+		http.Error(w, "nope", http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	s := newTestServerWithWebhooks(t)
+	webhook := addTestWebhook(t, s, receiver.URL)
+	response := request(t, s, "PUT", "/mytopic", "bad request", nil)
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(listTestWebhookDeliveries(t, s, webhook.ID)) == 1
+	})
+	deliveries := listTestWebhookDeliveries(t, s, webhook.ID)
+	require.Equal(t, 1, deliveries[0].Attempts)
+	require.Equal(t, 400, deliveries[0].StatusCode)
+	require.Contains(t, deliveries[0].Error, "nope")
+	require.Equal(t, int32(1), count.Load())
+}
+
+func TestServer_Webhooks_PrivateAddressNotAllowed(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("webhook should not have been called")
+	}))
+	defer receiver.Close()
+
+	s := newTestServerWithWebhooks(t)
+	s.webhookSender.allowAddr = publicAddr
+	s.webhookSender.retryDelays = []time.Duration{}
+	webhook := addTestWebhook(t, s, receiver.URL)
+	response := request(t, s, "PUT", "/mytopic", "to localhost", nil)
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(listTestWebhookDeliveries(t, s, webhook.ID)) == 1
+	})
+	deliveries := listTestWebhookDeliveries(t, s, webhook.ID)
+	require.Equal(t, 0, deliveries[0].StatusCode)
+	require.Contains(t, deliveries[0].Error, errWebhookAddressNotAllowed.Error())
+}
+
+func TestServer_Webhooks_SlackAndDiscord(t *testing.T) {
+	bodies := make(map[string]chan []byte)
+	for _, path := range []string{"/slack", "/discord"} {
+		bodies[path] = make(chan []byte, 10)
+	}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies[r.URL.Path] <- body
+		if r.URL.Path == "/discord" {
+			w.WriteHeader(http.StatusNoContent) // Discord responds with 204
+		}
+	}))
+	defer receiver.Close()
+
+	s := newTestServerWithWebhooks(t)
+	for _, format := range []string{"slack", "discord"} {
+		response := request(t, s, "POST", "/v1/account/reservation/mytopic/webhooks", fmt.Sprintf(`{"url":"%s/%s","format":"%s"}`, receiver.URL, format, format), map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, response.Code)
+		webhook, err := util.UnmarshalJSON[apiAccountWebhook](io.NopCloser(response.Body))
+		require.Nil(t, err)
+		require.Equal(t, format, webhook.Format)
+	}
+
+	response := request(t, s, "PUT", "/mytopic", "Disk usage is at <95%> & rising", map[string]string{
+		"Title":    "Disk full",
+		"Tags":     "warning,server1",
+		"Priority": "5",
+		"Click":    "https://example.com/disks",
+	})
+	require.Equal(t, 200, response.Code)
+
+	var slackBody, discordBody []byte
+	select {
+	case slackBody = <-bodies["/slack"]:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slack webhook was not called")
+	}
+	select {
+	case discordBody = <-bodies["/discord"]:
+	case <-time.After(5 * time.Second):
+		t.Fatal("discord webhook was not called")
+	}
+
+	var slack slackPayload
+	require.Nil(t, json.Unmarshal(slackBody, &slack))
+	require.Equal(t, "⚠️ Disk full: Disk usage is at &lt;95%&gt; &amp; rising", slack.Text)
+	require.Len(t, slack.Blocks, 4)
+	require.Equal(t, "header", slack.Blocks[0].Type)
+	require.Equal(t, "⚠️ Disk full", slack.Blocks[0].Text.Text)
+	require.Equal(t, "section", slack.Blocks[1].Type)
+	require.Equal(t, "Disk usage is at &lt;95%&gt; &amp; rising", slack.Blocks[1].Text.Text)
+	require.Equal(t, "actions", slack.Blocks[2].Type)
+	require.Equal(t, "https://example.com/disks", slack.Blocks[2].Elements[0].(map[string]any)["url"])
+	require.Equal(t, "context", slack.Blocks[3].Type)
+	require.Equal(t, "mytopic · server1 · priority 5", slack.Blocks[3].Elements[0].(map[string]any)["text"])
+
+	var discord discordPayload
+	require.Nil(t, json.Unmarshal(discordBody, &discord))
+	require.Equal(t, "ntfy", discord.Username)
+	require.Len(t, discord.Embeds, 1)
+	require.Equal(t, "⚠️ Disk full", discord.Embeds[0].Title)
+	require.Equal(t, "Disk usage is at <95%> & rising", discord.Embeds[0].Description)
+	require.Equal(t, "https://example.com/disks", discord.Embeds[0].URL)
+	require.Equal(t, 0xf44336, discord.Embeds[0].Color)
+	require.Equal(t, "mytopic · server1 · priority 5", discord.Embeds[0].Footer.Text)
+
+	// 204 counts as successful delivery
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/webhooks", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	webhooks, err := util.UnmarshalJSON[[]*apiAccountWebhook](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	waitFor(t, func() bool {
+		return len(listTestWebhookDeliveries(t, s, (*webhooks)[1].ID)) == 1
+	})
+	require.Equal(t, 204, listTestWebhookDeliveries(t, s, (*webhooks)[1].ID)[0].StatusCode)
+}
+
+func TestServer_Webhooks_ChatPayloadAttachments(t *testing.T) {
+	m := newDefaultMessage("mytopic", "")
+	m.Attachment = &attachment{Name: "cat.jpg", Type: "image/jpeg", URL: "https://example.com/cat.jpg"}
+	slack := newSlackPayload(m)
+	require.Equal(t, "image", slack.Blocks[0].Type)
+	require.Equal(t, "https://example.com/cat.jpg", slack.Blocks[0].ImageURL)
+	discord := newDiscordPayload(m)
+	require.Equal(t, "https://example.com/cat.jpg", discord.Embeds[0].Image.URL)
+	require.Equal(t, 0x338574, discord.Embeds[0].Color)
+
+	m.Attachment = &attachment{Name: "backup.log", Type: "text/plain", URL: "https://example.com/backup.log"}
+	slack = newSlackPayload(m)
+	require.Equal(t, "actions", slack.Blocks[0].Type)
+	require.Equal(t, "backup.log", slack.Blocks[0].Elements[0].(*slackBlock).Text.Text)
+	discord = newDiscordPayload(m)
+	require.Nil(t, discord.Embeds[0].Image)
+	require.Equal(t, "[backup.log](https://example.com/backup.log)", discord.Embeds[0].Fields[0].Value)
+
+	m.Message = strings.Repeat("x", 5000)
+	require.Equal(t, discordDescriptionLimit, utf8.RuneCountInString(newDiscordPayload(m).Embeds[0].Description))
+}
+
+func TestServer_Webhooks_ClientCertificateAndCA(t *testing.T) {
+	clientCert, clientCertPEM, clientKeyPEM := newTestClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	receiver := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "ntfy-test-client", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	receiver.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	receiver.StartTLS()
+	defer receiver.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem")
+	require.Nil(t, os.WriteFile(certFile, clientCertPEM, 0600))
+	require.Nil(t, os.WriteFile(keyFile, clientKeyPEM, 0600))
+	require.Nil(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: receiver.Certificate().Raw}), 0600))
+
+	webhook := &user.TopicWebhook{ID: "wh_1234567890", URL: receiver.URL + "/hook", Secret: "whsec_1234"}
+	sender, err := newWebhookSender("ntfy/test", true, []*WebhookTLS{
+		{Host: "*.example.com", CAFile: caFile},
+		{Host: "127.0.0.1", CertFile: certFile, KeyFile: keyFile, CAFile: caFile},
+	})
+	require.Nil(t, err)
+	statusCode, err := sender.Send(webhook, []byte(`{}`))
+	require.Nil(t, err)
+	require.Equal(t, 200, statusCode)
+
+	// Without a client certificate, the handshake fails
+	sender, err = newWebhookSender("ntfy/test", true, []*WebhookTLS{{Host: "127.0.0.1", CAFile: caFile}})
+	require.Nil(t, err)
+	_, err = sender.Send(webhook, []byte(`{}`))
+	require.Error(t, err)
+
+	// Without the CA bundle, the receiver's certificate is not trusted
+	sender, err = newWebhookSender("ntfy/test", true, nil)
+	require.Nil(t, err)
+	_, err = sender.Send(webhook, []byte(`{}`))
+	require.ErrorContains(t, err, "certificate")
+
+	_, err = newWebhookSender("ntfy/test", true, []*WebhookTLS{{Host: "127.0.0.1", CAFile: keyFile}})
+	require.ErrorContains(t, err, "no PEM certificates found")
+}
+
+func TestWebhookTLS_Matches(t *testing.T) {
+	require.True(t, (&WebhookTLS{Host: "hooks.example.com"}).Matches("HOOKS.example.com"))
+	require.False(t, (&WebhookTLS{Host: "hooks.example.com"}).Matches("example.com"))
+	require.True(t, (&WebhookTLS{Host: "*.example.com"}).Matches("a.b.example.com"))
+	require.False(t, (&WebhookTLS{Host: "*.example.com"}).Matches("example.com"))
+	require.False(t, (&WebhookTLS{Host: "*.example.com"}).Matches("badexample.com"))
+}
+
+func TestServer_Webhooks_Management(t *testing.T) {
+	s := newTestServerWithWebhooks(t)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	// Invalid URLs
+	for _, body := range []string{`{"url":"ftp://example.com"}`, `{"url":"example.com/hook"}`, `{"url":""}`} {
+		response := request(t, s, "POST", "/v1/account/reservation/mytopic/webhooks", body, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40069, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Invalid format
+	response := request(t, s, "POST", "/v1/account/reservation/mytopic/webhooks", `{"url":"https://example.com/hook","format":"teams"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40071, toHTTPError(t, response.Body.String()).Code)
+
+	// Non-owners cannot add webhooks
+	response = request(t, s, "POST", "/v1/account/reservation/mytopic/webhooks", `{"url":"https://example.com/hook"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Limit per topic
+	var first *apiAccountWebhook
+	for i := 0; i < webhookTopicLimit; i++ {
+		webhook := addTestWebhook(t, s, fmt.Sprintf("https://example.com/hook%d", i))
+		if first == nil {
+			first = webhook
+		}
+	}
+	response = request(t, s, "POST", "/v1/account/reservation/mytopic/webhooks", `{"url":"https://example.com/one-too-many"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42914, toHTTPError(t, response.Body.String()).Code)
+
+	// List and delete
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/webhooks", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	webhooks, err := util.UnmarshalJSON[[]*apiAccountWebhook](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Len(t, *webhooks, webhookTopicLimit)
+	require.Equal(t, "https://example.com/hook0", (*webhooks)[0].URL)
+
+	response = request(t, s, "DELETE", "/v1/account/reservation/mytopic/webhooks/"+first.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/account/reservation/mytopic/webhooks/"+first.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "GET", "/v1/account/reservation/mytopic/webhooks/"+first.ID+"/deliveries", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Webhooks_TierFeature(t *testing.T) {
+	s := newTestServerWithWebhooks(t)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:     "basic",
+		Features: user.TierFeatures{user.TierFeatureWebhooks: "false"},
+	}))
+	require.Nil(t, s.userManager.ChangeTier("ben", "basic"))
+	response := request(t, s, "POST", "/v1/account/reservation/mytopic/webhooks", `{"url":"https://example.com/hook"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, response.Code)
+}
+
+func TestServer_Webhooks_Disabled(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	response := request(t, s, "GET", "/v1/account/reservation/mytopic/webhooks", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+}
+
+func newTestServerWithWebhooks(t *testing.T) *Server {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	conf.EnableWebhooks = true
+	conf.AuthDefault = user.PermissionReadWrite
+	s := newTestServer(t, conf)
+	s.webhookSender.allowAddr = func(addr netip.Addr) bool { return true } // Test receivers listen on localhost
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionReadWrite))
+	return s
+}
+
+func addTestWebhook(t *testing.T, s *Server, url string) *apiAccountWebhook {
+	response := request(t, s, "POST", "/v1/account/reservation/mytopic/webhooks", fmt.Sprintf(`{"url":"%s"}`, url), map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	webhook, err := util.UnmarshalJSON[apiAccountWebhook](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	return webhook
+}
+
+func listTestWebhookDeliveries(t *testing.T, s *Server, webhookID string) []*apiAccountWebhookDelivery {
+	response := request(t, s, "GET", "/v1/account/reservation/mytopic/webhooks/"+webhookID+"/deliveries", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	deliveries, err := util.UnmarshalJSON[[]*apiAccountWebhookDelivery](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	return *deliveries
+}
+
+func newTestClientCertificate(t *testing.T) (*x509.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ntfy-test-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/SherClockHolmes/webpush-go"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
 )
 
 const (
@@ -89,20 +90,28 @@ func (s *Server) publishToWebPushEndpoints(v *visitor, m *message) {
 		return
 	}
 	log.Tag(tagWebPush).With(v, m).Debug("Publishing web push message to %d subscribers", len(subscriptions))
-	payload, err := json.Marshal(newWebPushPayload(fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic), m))
+	payload, err := json.Marshal(newWebPushPayload(fmt.Sprintf("%s/%s", s.config.Load().BaseURL, m.Topic), m))
 	if err != nil {
 		log.Tag(tagWebPush).Err(err).With(v, m).Warn("Unable to marshal expiring payload")
 		return
 	}
+	users := newDeliveryUsers(s)
 	for _, subscription := range subscriptions {
+		if !users.ChannelAllowed(subscription.UserID, m.Topic, channelWebPush) {
+			log.Tag(tagWebPush).With(v, m, subscription).Debug("Not publishing web push message, disabled in subscription preferences")
+			continue
+		}
 		if err := s.sendWebPushNotification(subscription, payload, v, m); err != nil {
 			log.Tag(tagWebPush).Err(err).With(v, m, subscription).Warn("Unable to publish web push message")
+			s.deliveries.Failure(deliveryChannelWebPush)
+		} else {
+			s.deliveries.Success(deliveryChannelWebPush)
 		}
 	}
 }
 
 func (s *Server) pruneAndNotifyWebPushSubscriptions() {
-	if s.config.WebPushPublicKey == "" {
+	if s.config.Load().WebPushPublicKey == "" {
 		return
 	}
 	go func() {
@@ -114,11 +123,20 @@ func (s *Server) pruneAndNotifyWebPushSubscriptions() {
 
 func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	// Expire old subscriptions
-	if err := s.webPush.RemoveExpiredSubscriptions(s.config.WebPushExpiryDuration); err != nil {
+	removed, err := s.webPush.RemoveExpiredSubscriptions(s.config.Load().WebPushExpiryDuration)
+	if err != nil {
+		return err
+	} else if removed > 0 {
+		log.Tag(tagWebPush).Field("subscriptions_expired", removed).Info("Removed %d web push subscription(s) not seen for %s", removed, util.FormatDuration(s.config.Load().WebPushExpiryDuration))
+	}
+	maddPushDevicesRemoved(pushDeviceTypeWebPush, pushDeviceReasonExpired, removed)
+	count, err := s.webPush.SubscriptionsCount()
+	if err != nil {
 		return err
 	}
+	msetPushDevices(pushDeviceTypeWebPush, count)
 	// Notify subscriptions that will expire soon
-	subscriptions, err := s.webPush.SubscriptionsExpiring(s.config.WebPushExpiryWarningDuration)
+	subscriptions, err := s.webPush.SubscriptionsExpiring(s.config.Load().WebPushExpiryWarningDuration)
 	if err != nil {
 		return err
 	} else if len(subscriptions) == 0 {
@@ -153,14 +171,15 @@ func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byt
 		},
 	}
 	resp, err := webpush.SendNotification(message, payload, &webpush.Options{
-		Subscriber:      s.config.WebPushEmailAddress,
-		VAPIDPublicKey:  s.config.WebPushPublicKey,
-		VAPIDPrivateKey: s.config.WebPushPrivateKey,
+		Subscriber:      s.config.Load().WebPushEmailAddress,
+		VAPIDPublicKey:  s.config.Load().WebPushPublicKey,
+		VAPIDPrivateKey: s.config.Load().WebPushPrivateKey,
 		Urgency:         webpush.UrgencyHigh, // iOS requires this to ensure delivery
-		TTL:             int(s.config.CacheDuration.Seconds()),
+		TTL:             int(s.config.Load().CacheDuration.Seconds()),
 	})
 	if err != nil {
 		log.Tag(tagWebPush).With(sub).With(contexters...).Err(err).Debug("Unable to publish web push message, removing endpoint")
+		maddPushDevicesRemoved(pushDeviceTypeWebPush, pushDeviceReasonInvalid, 1)
 		if err := s.webPush.RemoveSubscriptionsByEndpoint(sub.Endpoint); err != nil {
 			return err
 		}
@@ -168,6 +187,7 @@ func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byt
 	}
 	if (resp.StatusCode < 200 || resp.StatusCode > 299) && resp.StatusCode != 429 {
 		log.Tag(tagWebPush).With(sub).With(contexters...).Field("response_code", resp.StatusCode).Debug("Unable to publish web push message, unexpected response")
+		maddPushDevicesRemoved(pushDeviceTypeWebPush, pushDeviceReasonInvalid, 1)
 		if err := s.webPush.RemoveSubscriptionsByEndpoint(sub.Endpoint); err != nil {
 			return err
 		}
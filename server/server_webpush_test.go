@@ -193,6 +193,55 @@ func TestServer_WebPush_Publish(t *testing.T) {
 	})
 }
 
+func TestServer_WebPush_Publish_DeliveryPrefs(t *testing.T) {
+	s := newTestServer(t, configureAuth(t, newTestConfigWithWebPush(t)))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+
+	var received atomic.Int32
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer pushService.Close()
+	require.Nil(t, s.webPush.UpsertSubscription(pushService.URL+"/push-receive", "kSC3T8aN1JCQxxPdrFLrZg", "BMKKbxdUU_xLS7G1Wh5AN8PvWOjCzkCuKZYb8apcqYrDxjOF_2piggBnoJLQYx9IeSD70fNuwawI3e9Y8m3S3PE", u.ID, netip.MustParseAddr("1.2.3.4"), []string{"test-topic"}))
+
+	// Disable web push for the subscription
+	response := request(t, s, "POST", "/v1/account/subscription", `{"base_url":"http://127.0.0.1:12345","topic":"test-topic","delivery":{"web_push":{"enabled":false}}}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	request(t, s, "POST", "/test-topic", "not delivered", nil)
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, int32(0), received.Load())
+
+	// Enable it again (with a window that covers the whole day)
+	response = request(t, s, "PATCH", "/v1/account/subscription", `{"base_url":"http://127.0.0.1:12345","topic":"test-topic","delivery":{"web_push":{"enabled":true,"hours":"00:00-24:00","timezone":"Europe/Berlin"}}}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	request(t, s, "POST", "/test-topic", "delivered", nil)
+	waitFor(t, func() bool {
+		return received.Load() == 1
+	})
+}
+
+func TestServer_WebPush_DeliveryPrefs_Invalid(t *testing.T) {
+	s := newTestServer(t, configureAuth(t, newTestConfigWithWebPush(t)))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	response := request(t, s, "POST", "/v1/account/subscription", `{"base_url":"http://127.0.0.1:12345","topic":"test-topic","delivery":{"web_push":{"enabled":true,"hours":"9-17"}}}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40052, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/v1/account/subscription", `{"base_url":"http://127.0.0.1:12345","topic":"test-topic","delivery":{"email":{"enabled":true,"hours":"09:00-17:00","timezone":"Mars/Olympus"}}}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 40052, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_WebPush_Publish_RemoveOnError(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithWebPush(t))
 
@@ -4,6 +4,7 @@ import (
 	_ "embed" // required by go:embed
 	"encoding/json"
 	"fmt"
+	"html"
 	"mime"
 	"net"
 	"net/smtp"
@@ -15,16 +16,21 @@ import (
 	"heckel.io/ntfy/v2/util"
 )
 
+const (
+	emailImageWidth = 640 // Width of image attachments embedded in HTML emails, see resizedAttachment
+)
+
 type mailer interface {
 	Send(v *visitor, m *message, to string) error
 	Counts() (total int64, success int64, failure int64)
 }
 
 type smtpSender struct {
-	config  *Config
-	success int64
-	failure int64
-	mu      sync.Mutex
+	config    *Config
+	localizer *localizer
+	success   int64
+	failure   int64
+	mu        sync.Mutex
 }
 
 func (s *smtpSender) Send(v *visitor, m *message, to string) error {
@@ -33,7 +39,8 @@ func (s *smtpSender) Send(v *visitor, m *message, to string) error {
 		if err != nil {
 			return err
 		}
-		message, err := formatMail(s.config.BaseURL, v.ip.String(), s.config.SMTPSenderFrom, to, m)
+		from := s.senderFrom(v)
+		message, err := formatMail(s.config.BaseURL, v.ip.String(), from, to, m, s.localizer.Locale(v.User()))
 		if err != nil {
 			return err
 		}
@@ -46,6 +53,7 @@ func (s *smtpSender) Send(v *visitor, m *message, to string) error {
 			Fields(log.Context{
 				"email_via":  s.config.SMTPSenderAddr,
 				"email_user": s.config.SMTPSenderUser,
+				"email_from": from,
 				"email_to":   to,
 			})
 		if ev.IsTrace() {
@@ -53,10 +61,27 @@ func (s *smtpSender) Send(v *visitor, m *message, to string) error {
 		} else if ev.IsDebug() {
 			ev.Debug("Sending email")
 		}
-		return smtp.SendMail(s.config.SMTPSenderAddr, auth, s.config.SMTPSenderFrom, []string{to}, []byte(message))
+		return smtp.SendMail(s.config.SMTPSenderAddr, auth, from, []string{to}, []byte(message))
 	})
 }
 
+// senderFrom returns the sender address for emails sent on behalf of the given visitor. A user-specific
+// address takes precedence over a tier-specific address, which takes precedence over smtp-sender-from.
+func (s *smtpSender) senderFrom(v *visitor) string {
+	u := v.User()
+	if u == nil {
+		return s.config.SMTPSenderFrom
+	}
+	if from, ok := s.config.SMTPSenderFromUsers[u.Name]; ok {
+		return from
+	} else if u.Tier != nil {
+		if from, ok := s.config.SMTPSenderFromTiers[u.Tier.Code]; ok {
+			return from
+		}
+	}
+	return s.config.SMTPSenderFrom
+}
+
 func (s *smtpSender) Counts() (total int64, success int64, failure int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -76,7 +101,9 @@ func (s *smtpSender) withCount(v *visitor, m *message, fn func() error) error {
 	return err
 }
 
-func formatMail(baseURL, senderIP, from, to string, m *message) (string, error) {
+// formatMail formats the message m as an email. The footer, tags and priority are translated using the
+// given locale, i.e. the language of the sender (not the recipient, whose language is unknown).
+func formatMail(baseURL, senderIP, from, to string, m *message, l *locale) (string, error) {
 	topicURL := baseURL + "/" + m.Topic
 	subject := m.Title
 	if subject == "" {
@@ -94,7 +121,7 @@ func formatMail(baseURL, senderIP, from, to string, m *message) (string, error)
 			subject = strings.Join(emojis, " ") + " " + subject
 		}
 		if len(tags) > 0 {
-			trailer = "Tags: " + strings.Join(tags, ", ")
+			trailer = l.T("email_tags", "tags", strings.Join(tags, ", "))
 		}
 	}
 	if m.Priority != 0 && m.Priority != 3 {
@@ -105,13 +132,25 @@ func formatMail(baseURL, senderIP, from, to string, m *message) (string, error)
 		if trailer != "" {
 			trailer += "\n"
 		}
-		trailer += fmt.Sprintf("Priority: %s", priority)
+		trailer += l.T("email_priority", "priority", priority)
 	}
 	if trailer != "" {
 		message += "\n\n" + trailer
 	}
 	date := time.Unix(m.Time, 0).UTC().Format(time.RFC1123Z)
+	footer := l.T("email_footer", "ip", senderIP, "time", time.Unix(m.Time, 0).UTC().Format(time.RFC1123))
 	subject = mime.BEncoding.Encode("utf-8", subject)
+	shortTopicURL := util.ShortTopicURL(topicURL)
+	replacements := []string{
+		"{footer}", strings.ReplaceAll(footer, "{topic_url}", topicURL),
+		"{from}", from,
+		"{to}", to,
+		"{date}", date,
+		"{subject}", subject,
+		"{message}", message,
+		"{topicURL}", topicURL,
+		"{shortTopicURL}", shortTopicURL,
+	}
 	body := `From: "{shortTopicURL}" <{from}>
 To: {to}
 Date: {date}
@@ -121,17 +160,47 @@ Content-Type: text/plain; charset="utf-8"
 {message}
 
 --
-This message was sent by {ip} at {time} via {topicURL}`
-	body = strings.ReplaceAll(body, "{from}", from)
-	body = strings.ReplaceAll(body, "{to}", to)
-	body = strings.ReplaceAll(body, "{date}", date)
-	body = strings.ReplaceAll(body, "{subject}", subject)
-	body = strings.ReplaceAll(body, "{message}", message)
-	body = strings.ReplaceAll(body, "{topicURL}", topicURL)
-	body = strings.ReplaceAll(body, "{shortTopicURL}", util.ShortTopicURL(topicURL))
-	body = strings.ReplaceAll(body, "{time}", time.Unix(m.Time, 0).UTC().Format(time.RFC1123))
-	body = strings.ReplaceAll(body, "{ip}", senderIP)
-	return body, nil
+{footer}`
+	if m.ContentType == contentTypeTextMarkdown {
+		// Markdown messages are sent as multipart/alternative, so that mail clients can display the
+		// rendered (and sanitized) HTML, and fall back to the raw Markdown text otherwise.
+		htmlMessage := renderMarkdownHTML(m.Message)
+		if m.Attachment != nil && strings.HasPrefix(m.Attachment.Type, "image/") && strings.HasPrefix(m.Attachment.URL, baseURL+"/file/") {
+			imageURL := fmt.Sprintf("%s?w=%d", m.Attachment.URL, emailImageWidth)
+			htmlMessage += fmt.Sprintf(`<p><img src="%s" alt="%s" style="max-width: 100%%"></p>`+"\n", html.EscapeString(imageURL), html.EscapeString(m.Attachment.Name))
+		}
+		if trailer != "" {
+			htmlMessage += renderPlainHTML(trailer)
+		}
+		body = `From: "{shortTopicURL}" <{from}>
+To: {to}
+Date: {date}
+Subject: {subject}
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="{boundary}"
+
+--{boundary}
+Content-Type: text/plain; charset="utf-8"
+
+{message}
+
+--
+{footer}
+--{boundary}
+Content-Type: text/html; charset="utf-8"
+
+{htmlMessage}<hr>
+<p>{htmlFooter}</p>
+--{boundary}--`
+		replacements = append(replacements,
+			"{boundary}", "ntfy-"+m.ID,
+			"{htmlMessage}", htmlMessage,
+			"{htmlFooter}", strings.ReplaceAll(html.EscapeString(footer), "{topic_url}", fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(topicURL), html.EscapeString(shortTopicURL))),
+		)
+	}
+	// All placeholders are replaced in a single pass, so that placeholders in the inserted values
+	// (e.g. "{message}" in a Markdown message) are never replaced with raw, unsanitized text
+	return strings.NewReplacer(replacements...).Replace(body), nil
 }
 
 var (
@@ -2,6 +2,9 @@ package server
 
 import (
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"net/netip"
+	"strings"
 	"testing"
 )
 
@@ -12,7 +15,7 @@ func TestFormatMail_Basic(t *testing.T) {
 		Event:   "message",
 		Topic:   "alerts",
 		Message: "A simple message",
-	})
+	}, newTestLocale(t, "en"))
 	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
 To: phil@example.com
 Date: Fri, 24 Dec 2021 21:43:24 +0000
@@ -34,7 +37,7 @@ func TestFormatMail_JustEmojis(t *testing.T) {
 		Topic:   "alerts",
 		Message: "A simple message",
 		Tags:    []string{"grinning"},
-	})
+	}, newTestLocale(t, "en"))
 	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
 To: phil@example.com
 Date: Fri, 24 Dec 2021 21:43:24 +0000
@@ -56,7 +59,7 @@ func TestFormatMail_JustOtherTags(t *testing.T) {
 		Topic:   "alerts",
 		Message: "A simple message",
 		Tags:    []string{"not-an-emoji"},
-	})
+	}, newTestLocale(t, "en"))
 	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
 To: phil@example.com
 Date: Fri, 24 Dec 2021 21:43:24 +0000
@@ -80,7 +83,7 @@ func TestFormatMail_JustPriority(t *testing.T) {
 		Topic:    "alerts",
 		Message:  "A simple message",
 		Priority: 2,
-	})
+	}, newTestLocale(t, "en"))
 	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
 To: phil@example.com
 Date: Fri, 24 Dec 2021 21:43:24 +0000
@@ -104,7 +107,7 @@ func TestFormatMail_UTF8Subject(t *testing.T) {
 		Topic:   "alerts",
 		Message: "A simple message",
 		Title:   " :: A not so simple title öäüß ¡Hola, señor!",
-	})
+	}, newTestLocale(t, "en"))
 	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
 To: phil@example.com
 Date: Fri, 24 Dec 2021 21:43:24 +0000
@@ -128,7 +131,7 @@ func TestFormatMail_WithAllTheThings(t *testing.T) {
 		Tags:     []string{"warning", "skull", "tag123", "other"},
 		Title:    "Oh no 🙈\nThis is a message across\nmultiple lines",
 		Message:  "A message that contains monkeys 🙉\nNo really, though. Monkeys!",
-	})
+	}, newTestLocale(t, "en"))
 	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
 To: phil@example.com
 Date: Fri, 24 Dec 2021 21:43:24 +0000
@@ -145,3 +148,106 @@ Priority: max
 This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://ntfy.sh/alerts`
 	require.Equal(t, expected, actual)
 }
+
+func TestFormatMail_Markdown(t *testing.T) {
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+		ID:          "abc",
+		Time:        1640382204,
+		Event:       "message",
+		Topic:       "alerts",
+		Message:     "**Disk** is <script>alert(1)</script> full",
+		ContentType: "text/markdown",
+	}, newTestLocale(t, "en"))
+	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
+To: phil@example.com
+Date: Fri, 24 Dec 2021 21:43:24 +0000
+Subject: **Disk** is <script>alert(1)</script> full
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="ntfy-abc"
+
+--ntfy-abc
+Content-Type: text/plain; charset="utf-8"
+
+**Disk** is <script>alert(1)</script> full
+
+--
+This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://ntfy.sh/alerts
+--ntfy-abc
+Content-Type: text/html; charset="utf-8"
+
+<p><strong>Disk</strong> is  full</p>
+<hr>
+<p>This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via <a href="https://ntfy.sh/alerts">ntfy.sh/alerts</a></p>
+--ntfy-abc--`
+	require.Equal(t, expected, actual)
+}
+
+func TestFormatMail_MarkdownPlaceholderNotReplaced(t *testing.T) {
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+		ID:          "abc",
+		Time:        1640382204,
+		Event:       "message",
+		Topic:       "alerts",
+		Message:     "{message}<script>alert(1)</script> {subject}<img src=x onerror=alert(1)>",
+		ContentType: "text/markdown",
+	}, newTestLocale(t, "en"))
+	parts := strings.SplitN(actual, `Content-Type: text/html; charset="utf-8"`, 2)
+	require.Len(t, parts, 2)
+	require.Contains(t, parts[0], "\n{message}<script>alert(1)</script> {subject}<img src=x onerror=alert(1)>\n")
+	require.NotContains(t, parts[1], "<script>")
+	require.NotContains(t, parts[1], "onerror")
+	require.Contains(t, parts[1], `<p>{message} {subject}<img src="x"></p>`)
+}
+
+func TestFormatMail_MarkdownWithImage(t *testing.T) {
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+		ID:          "abc",
+		Time:        1640382204,
+		Event:       "message",
+		Topic:       "alerts",
+		Message:     "Motion **detected**",
+		ContentType: "text/markdown",
+		Attachment: &attachment{
+			Name: "camera<1>.jpg",
+			Type: "image/jpeg",
+			URL:  "https://ntfy.sh/file/abcdefghijkl.jpg",
+		},
+	}, newTestLocale(t, "en"))
+	require.Contains(t, actual, `<p>Motion <strong>detected</strong></p>
+<p><img src="https://ntfy.sh/file/abcdefghijkl.jpg?w=640" alt="camera&lt;1&gt;.jpg" style="max-width: 100%"></p>
+<hr>`)
+
+	// External images are not embedded
+	actual, _ = formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+		ID:          "abc",
+		Time:        1640382204,
+		Event:       "message",
+		Topic:       "alerts",
+		Message:     "Motion **detected**",
+		ContentType: "text/markdown",
+		Attachment: &attachment{
+			Name: "camera.jpg",
+			Type: "image/jpeg",
+			URL:  "https://example.com/camera.jpg",
+		},
+	}, newTestLocale(t, "en"))
+	require.NotContains(t, actual, "<img")
+}
+
+func TestSMTPSender_SenderFrom(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.SMTPSenderFrom = "ntfy@ntfy.sh"
+	conf.SMTPSenderFromTiers = map[string]string{"pro": "alerts@pro.example.com"}
+	conf.SMTPSenderFromUsers = map[string]string{"phil": "phil@example.com"}
+	sender := &smtpSender{config: conf}
+	ip := netip.MustParseAddr("1.2.3.4")
+	pro := &user.Tier{Code: "pro"}
+	newUser := func(name string, tier *user.Tier) *user.User {
+		return &user.User{Name: name, Tier: tier, Stats: &user.Stats{}, Billing: &user.Billing{}}
+	}
+
+	require.Equal(t, "ntfy@ntfy.sh", sender.senderFrom(newVisitor(conf, nil, nil, nil, ip, nil)))
+	require.Equal(t, "ntfy@ntfy.sh", sender.senderFrom(newVisitor(conf, nil, nil, nil, ip, newUser("ben", nil))))
+	require.Equal(t, "alerts@pro.example.com", sender.senderFrom(newVisitor(conf, nil, nil, nil, ip, newUser("ben", pro))))
+	require.Equal(t, "phil@example.com", sender.senderFrom(newVisitor(conf, nil, nil, nil, ip, newUser("phil", pro))))
+}
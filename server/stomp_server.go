@@ -0,0 +1,541 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	stompVersion          = "1.2"
+	stompConnectTimeout   = 30 * time.Second // The CONNECT frame must be received within this time
+	stompWriteTimeout     = 10 * time.Second
+	stompHeaderLineLimit  = 8192 // Bytes per command or header line
+	stompHeaderCountLimit = 64   // Headers per frame
+	stompTopicPrefix      = "/topic/"
+)
+
+var (
+	errStompLineTooLong     = errors.New("frame line too long")
+	errStompTooManyHeaders  = errors.New("too many headers")
+	errStompBodyTooLarge    = errors.New("frame body too large")
+	errStompInvalidEscape   = errors.New("invalid escape sequence in header")
+	errStompMissingNull     = errors.New("frame body not terminated by NULL octet")
+	errStompInvalidTopic    = errors.New("invalid destination, expected /topic/<topic> or <topic>")
+	errStompVersionMismatch = errors.New("unsupported protocol version, only STOMP 1.2 is supported")
+)
+
+// stompPublishHeaders are the SEND frame headers that are passed on as publish headers (e.g. X-Title)
+var stompPublishHeaders = []string{"title", "priority", "tags", "click", "icon", "actions", "attach", "filename", "delay", "email", "markdown"}
+
+// stompServer implements a subset of STOMP 1.2 (see listen-stomp): SEND publishes a message to a topic, and
+// SUBSCRIBE streams messages of a topic as MESSAGE frames. Similar to the SMTP server and the gRPC API, frames are
+// translated to internal HTTP requests and passed to the HTTP handler, so that authentication, access control and
+// rate limiting work exactly as they do for the HTTP API. Acknowledgements are accepted but ignored (i.e. all
+// subscriptions behave like ack:auto), transactions and heart-beating are not supported.
+//
+// See https://stomp.github.io/stomp-specification-1.2.html
+type stompServer struct {
+	config  *Config
+	handler func(http.ResponseWriter, *http.Request)
+}
+
+// stompFrame is a single STOMP frame. Headers are kept in order, since the first occurrence of a repeated
+// header wins (see the "Repeated Header Entries" section of the spec).
+type stompFrame struct {
+	command string
+	headers [][2]string
+	body    []byte
+}
+
+// stompConn is a single client connection, including its subscriptions
+type stompConn struct {
+	server        *stompServer
+	conn          net.Conn
+	reader        *bufio.Reader
+	ip            netip.Addr
+	authorization string                        // Authorization header for internal requests, derived from login/passcode
+	subscriptions map[string]context.CancelFunc // Subscription ID -> cancel function
+	ctx           context.Context
+	mu            sync.Mutex // Protects writes to conn and subscriptions
+}
+
+func newSTOMPServer(conf *Config, handler func(http.ResponseWriter, *http.Request)) *stompServer {
+	return &stompServer{
+		config:  conf,
+		handler: handler,
+	}
+}
+
+func (s *Server) runSTOMPServer() error {
+	listener, err := net.Listen("tcp", s.config.Load().ListenSTOMP)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.stompListener = listener
+	s.mu.Unlock()
+	return newSTOMPServer(s.config.Load(), s.handle).Serve(listener)
+}
+
+// Serve accepts connections until the listener is closed
+func (s *stompServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *stompServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	ip, err := tcpRemoteIP(conn)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // Closes all subscriptions
+	c := &stompConn{
+		server:        s,
+		conn:          conn,
+		reader:        bufio.NewReader(conn),
+		ip:            ip,
+		subscriptions: make(map[string]context.CancelFunc),
+		ctx:           ctx,
+	}
+	ev := log.Tag(tagSTOMP).Field("stomp_remote_addr", conn.RemoteAddr().String())
+	if err := c.run(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		ev.Err(err).Debug("STOMP connection closed with error")
+		return
+	}
+	ev.Debug("STOMP connection closed")
+}
+
+// run handles the CONNECT frame, and then all following frames until the client disconnects, or an error occurs.
+// Errors are sent to the client as ERROR frames, after which the connection is closed, as required by the spec.
+func (c *stompConn) run() error {
+	c.conn.SetReadDeadline(time.Now().Add(stompConnectTimeout))
+	frame, err := c.readFrame(true)
+	if err != nil {
+		return c.sendError(err, nil)
+	} else if frame.command != "CONNECT" && frame.command != "STOMP" {
+		return c.sendError(errors.New("expected CONNECT frame"), frame)
+	} else if err := c.connect(frame); err != nil {
+		return c.sendError(err, frame)
+	}
+	c.conn.SetReadDeadline(time.Time{}) // Heart-beating is not supported, so connections may be idle
+	for {
+		frame, err := c.readFrame(false)
+		if err != nil {
+			return c.sendError(err, nil)
+		}
+		switch frame.command {
+		case "SEND":
+			err = c.send(frame)
+		case "SUBSCRIBE":
+			err = c.subscribe(frame)
+		case "UNSUBSCRIBE":
+			err = c.unsubscribe(frame)
+		case "ACK", "NACK":
+			err = nil // All subscriptions behave like ack:auto
+		case "DISCONNECT":
+			return c.sendReceipt(frame)
+		case "BEGIN", "COMMIT", "ABORT":
+			err = errors.New("transactions are not supported")
+		default:
+			err = fmt.Errorf("unknown command %s", frame.command)
+		}
+		if err != nil {
+			return c.sendError(err, frame)
+		} else if err := c.sendReceipt(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// connect checks the protocol version, and the login/passcode headers. If login is set, the credentials are used as
+// username and password. If only passcode is set, it is used as access token. Credentials are checked right away by
+// requesting the account, so that clients are not connected with wrong credentials.
+func (c *stompConn) connect(frame *stompFrame) error {
+	if !strings.Contains(","+frame.header("accept-version")+",", ","+stompVersion+",") {
+		return errStompVersionMismatch
+	}
+	login, passcode := frame.header("login"), frame.header("passcode")
+	if login != "" {
+		c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(login+":"+passcode))
+	} else if passcode != "" {
+		c.authorization = "Bearer " + passcode
+	}
+	if c.authorization != "" {
+		rr := httptest.NewRecorder()
+		c.server.handler(rr, c.newRequest(c.ctx, http.MethodGet, apiAccountPath, nil))
+		if rr.Code == http.StatusUnauthorized {
+			return errHTTPUnauthorized
+		}
+	}
+	return c.write(&stompFrame{
+		command: "CONNECTED",
+		headers: [][2]string{
+			{"version", stompVersion},
+			{"heart-beat", "0,0"},
+			{"server", "ntfy/" + c.server.config.Version},
+		},
+	})
+}
+
+// send publishes the body of a SEND frame to the destination topic
+func (c *stompConn) send(frame *stompFrame) error {
+	topic, err := stompTopic(frame.header("destination"))
+	if err != nil {
+		return err
+	}
+	r := c.newRequest(c.ctx, http.MethodPost, "/"+topic, bytes.NewReader(frame.body))
+	for _, name := range stompPublishHeaders {
+		if value := frame.header(name); value != "" {
+			r.Header.Set("X-"+name, value)
+		}
+	}
+	rr := httptest.NewRecorder()
+	c.server.handler(rr, r)
+	if rr.Code != http.StatusOK {
+		return stompErrorFromResponse(rr.Code, rr.Body.Bytes())
+	}
+	return nil
+}
+
+// subscribe starts streaming the messages of the destination topic as MESSAGE frames, by passing a JSON stream
+// request (/<topic>/json) to the HTTP handler. If the subscription is rejected (e.g. due to missing permissions),
+// an ERROR frame is sent, and the connection is closed.
+func (c *stompConn) subscribe(frame *stompFrame) error {
+	id := frame.header("id")
+	topic, err := stompTopic(frame.header("destination"))
+	if err != nil {
+		return err
+	} else if id == "" {
+		return errors.New("missing id header")
+	}
+	c.mu.Lock()
+	if _, exists := c.subscriptions[id]; exists {
+		c.mu.Unlock()
+		return fmt.Errorf("subscription %s already exists", id)
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.subscriptions[id] = cancel
+	c.mu.Unlock()
+	path := "/" + topic + "/json"
+	if since := frame.header("since"); since != "" {
+		path += "?since=" + url.QueryEscape(since)
+	}
+	r := c.newRequest(ctx, http.MethodGet, path, nil)
+	w := newSTOMPStreamWriter(func(m *message) error {
+		if m.Event != messageEvent {
+			return nil
+		}
+		return c.write(newSTOMPMessageFrame(id, m))
+	})
+	go func() {
+		defer c.removeSubscription(id)
+		c.server.handler(w, r)
+		if w.code != 0 && w.code != http.StatusOK {
+			c.sendError(stompErrorFromResponse(w.code, w.body.Bytes()), frame)
+			c.conn.Close()
+		}
+	}()
+	return nil
+}
+
+func (c *stompConn) unsubscribe(frame *stompFrame) error {
+	id := frame.header("id")
+	c.mu.Lock()
+	cancel, exists := c.subscriptions[id]
+	c.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("subscription %s does not exist", id)
+	}
+	cancel()
+	c.removeSubscription(id)
+	return nil
+}
+
+func (c *stompConn) removeSubscription(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, exists := c.subscriptions[id]; exists {
+		cancel()
+		delete(c.subscriptions, id)
+	}
+}
+
+// newRequest creates an internal HTTP request, with the remote address of the connection and the authorization
+// derived from the CONNECT frame
+func (c *stompConn) newRequest(ctx context.Context, method, path string, body io.Reader) *http.Request {
+	r := httptest.NewRequestWithContext(ctx, method, c.server.config.BaseURL+path, body)
+	r.RequestURI = path                                               // Just for the logs
+	r.RemoteAddr = c.ip.String()                                      // Rate limiting
+	r.Header.Set(c.server.config.ProxyForwardedHeader, c.ip.String()) // In case we're behind a proxy
+	if c.authorization != "" {
+		r.Header.Set("Authorization", c.authorization)
+	}
+	return r
+}
+
+func (c *stompConn) sendReceipt(frame *stompFrame) error {
+	receipt := frame.header("receipt")
+	if receipt == "" {
+		return nil
+	}
+	return c.write(&stompFrame{
+		command: "RECEIPT",
+		headers: [][2]string{{"receipt-id", receipt}},
+	})
+}
+
+// sendError sends an ERROR frame for the given error, and returns the error, so that the connection is closed
+func (c *stompConn) sendError(err error, frame *stompFrame) error {
+	headers := [][2]string{{"message", err.Error()}, {"content-type", "text/plain"}}
+	if frame != nil && frame.header("receipt") != "" {
+		headers = append(headers, [2]string{"receipt-id", frame.header("receipt")})
+	}
+	c.write(&stompFrame{command: "ERROR", headers: headers, body: []byte(err.Error())})
+	return err
+}
+
+// write sends a frame to the client. It is safe to call from multiple goroutines.
+func (c *stompConn) write(frame *stompFrame) error {
+	var buf bytes.Buffer
+	buf.WriteString(frame.command + "\n")
+	for _, header := range frame.headers {
+		buf.WriteString(stompEscape(header[0]) + ":" + stompEscape(header[1]) + "\n")
+	}
+	if len(frame.body) > 0 {
+		buf.WriteString("content-length:" + strconv.Itoa(len(frame.body)) + "\n")
+	}
+	buf.WriteString("\n")
+	buf.Write(frame.body)
+	buf.WriteByte(0)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(stompWriteTimeout))
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// readFrame reads the next frame, skipping heart-beat EOLs. Headers of CONNECT frames are not unescaped, as per spec.
+func (c *stompConn) readFrame(connect bool) (*stompFrame, error) {
+	var command string
+	for command == "" {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		command = line
+	}
+	frame := &stompFrame{command: command}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		} else if line == "" {
+			break
+		} else if len(frame.headers) >= stompHeaderCountLimit {
+			return nil, errStompTooManyHeaders
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if !connect {
+			if name, err = stompUnescape(name); err != nil {
+				return nil, err
+			} else if value, err = stompUnescape(value); err != nil {
+				return nil, err
+			}
+		}
+		frame.headers = append(frame.headers, [2]string{name, value})
+	}
+	limit := int(c.server.config.MessageSizeLimit)
+	if contentLength := frame.header("content-length"); contentLength != "" {
+		n, err := strconv.Atoi(contentLength)
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid content-length header")
+		} else if n > limit {
+			return nil, errStompBodyTooLarge
+		}
+		frame.body = make([]byte, n)
+		if _, err := io.ReadFull(c.reader, frame.body); err != nil {
+			return nil, err
+		}
+		if b, err := c.reader.ReadByte(); err != nil {
+			return nil, err
+		} else if b != 0 {
+			return nil, errStompMissingNull
+		}
+		return frame, nil
+	}
+	var body bytes.Buffer
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		} else if b == 0 {
+			break
+		} else if body.Len() >= limit {
+			return nil, errStompBodyTooLarge
+		}
+		body.WriteByte(b)
+	}
+	frame.body = body.Bytes()
+	return frame, nil
+}
+
+// readLine reads a single line, terminated by LF or CRLF, limited to stompHeaderLineLimit bytes
+func (c *stompConn) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := c.reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > stompHeaderLineLimit {
+			return "", errStompLineTooLong
+		} else if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// header returns the value of the first header with the given name, or an empty string
+func (f *stompFrame) header(name string) string {
+	for _, header := range f.headers {
+		if header[0] == name {
+			return header[1]
+		}
+	}
+	return ""
+}
+
+func newSTOMPMessageFrame(subscription string, m *message) *stompFrame {
+	headers := [][2]string{
+		{"subscription", subscription},
+		{"message-id", m.ID},
+		{"destination", stompTopicPrefix + m.Topic},
+		{"content-type", "text/plain;charset=utf-8"},
+		{"time", strconv.FormatInt(m.Time, 10)},
+	}
+	if m.Title != "" {
+		headers = append(headers, [2]string{"title", m.Title})
+	}
+	if m.Priority != 0 {
+		headers = append(headers, [2]string{"priority", strconv.Itoa(m.Priority)})
+	}
+	if len(m.Tags) > 0 {
+		headers = append(headers, [2]string{"tags", strings.Join(m.Tags, ",")})
+	}
+	if m.Click != "" {
+		headers = append(headers, [2]string{"click", m.Click})
+	}
+	if m.Attachment != nil && m.Attachment.URL != "" {
+		headers = append(headers, [2]string{"attach", m.Attachment.URL})
+	}
+	return &stompFrame{
+		command: "MESSAGE",
+		headers: headers,
+		body:    []byte(m.Message),
+	}
+}
+
+// stompTopic returns the topic of a destination, which may be "/topic/<topic>", "/<topic>" or "<topic>"
+func stompTopic(destination string) (string, error) {
+	topic := strings.TrimPrefix(strings.TrimPrefix(destination, stompTopicPrefix), "/")
+	if !topicRegex.MatchString(topic) {
+		return "", errStompInvalidTopic
+	}
+	return topic, nil
+}
+
+// stompErrorFromResponse converts an HTTP error response (see errHTTP) to an error
+func stompErrorFromResponse(httpCode int, body []byte) error {
+	var e errHTTP
+	if err := json.Unmarshal(body, &e); err != nil || e.Message == "" {
+		return errors.New(http.StatusText(httpCode))
+	}
+	return fmt.Errorf("%s (ntfy error %d)", e.Message, e.Code)
+}
+
+var (
+	stompEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+func stompEscape(s string) string {
+	return stompEscaper.Replace(s)
+}
+
+func stompUnescape(s string) (string, error) {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		} else if i+1 >= len(s) || !strings.ContainsRune(`\rnc`, rune(s[i+1])) {
+			return "", errStompInvalidEscape
+		}
+		i++
+	}
+	return stompUnescaper.Replace(s), nil
+}
+
+// stompStreamWriter is an http.ResponseWriter that converts the JSON lines written by the HTTP subscribe
+// handler to messages, and passes them to the send function. Error responses are buffered.
+type stompStreamWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+	send   func(m *message) error
+}
+
+func newSTOMPStreamWriter(send func(m *message) error) *stompStreamWriter {
+	return &stompStreamWriter{
+		header: make(http.Header),
+		send:   send,
+	}
+}
+
+func (w *stompStreamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *stompStreamWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *stompStreamWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.code != http.StatusOK {
+		return w.body.Write(b)
+	}
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		return 0, err
+	}
+	if err := w.send(&m); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_STOMP_SendAndSubscribe(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts", user.PermissionReadWrite))
+
+	c := newTestSTOMPConn(t, s)
+	connected := c.roundtrip(t, &stompFrame{
+		command: "CONNECT",
+		headers: [][2]string{{"accept-version", "1.1,1.2"}, {"host", "ntfy"}, {"login", "phil"}, {"passcode", "phil"}},
+	})
+	require.Equal(t, "CONNECTED", connected.command)
+	require.Equal(t, "1.2", connected.header("version"))
+
+	receipt := c.roundtrip(t, &stompFrame{
+		command: "SUBSCRIBE",
+		headers: [][2]string{{"id", "sub-0"}, {"destination", "/topic/alerts"}, {"receipt", "r1"}},
+	})
+	require.Equal(t, "RECEIPT", receipt.command)
+	require.Equal(t, "r1", receipt.header("receipt-id"))
+	waitFor(t, func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if topic, ok := s.topics["alerts"]; ok {
+			subscribers, _ := topic.Stats()
+			return subscribers == 1
+		}
+		return false
+	})
+
+	require.Nil(t, c.write(&stompFrame{
+		command: "SEND",
+		headers: [][2]string{{"destination", "/topic/alerts"}, {"title", "UPS: power:failure"}, {"priority", "5"}, {"tags", "warning,ups"}},
+		body:    []byte("On battery\x00power"), // Sent with content-length, so NULL octets are allowed
+	}))
+	m := c.read(t)
+	require.Equal(t, "MESSAGE", m.command)
+	require.Equal(t, "sub-0", m.header("subscription"))
+	require.Equal(t, "/topic/alerts", m.header("destination"))
+	require.NotEmpty(t, m.header("message-id"))
+	require.Equal(t, "UPS: power:failure", m.header("title"))
+	require.Equal(t, "5", m.header("priority"))
+	require.Equal(t, "warning,ups", m.header("tags"))
+	require.Equal(t, "On battery\x00power", string(m.body))
+
+	response := request(t, s, "GET", "/alerts/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "UPS: power:failure", messages[0].Title)
+}
+
+func TestServer_STOMP_SendForbidden(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+
+	c := newTestSTOMPConn(t, s)
+	connected := c.roundtrip(t, &stompFrame{command: "STOMP", headers: [][2]string{{"accept-version", "1.2"}}})
+	require.Equal(t, "CONNECTED", connected.command)
+
+	errFrame := c.roundtrip(t, &stompFrame{
+		command: "SEND",
+		headers: [][2]string{{"destination", "mytopic"}, {"receipt", "r1"}},
+		body:    []byte("hi"),
+	})
+	require.Equal(t, "ERROR", errFrame.command)
+	require.Equal(t, "r1", errFrame.header("receipt-id"))
+	require.Contains(t, errFrame.header("message"), "forbidden")
+	_, err := c.readFrame(false)
+	require.Error(t, err) // Connection closed
+}
+
+func TestServer_STOMP_ConnectErrors(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+
+	c := newTestSTOMPConn(t, s)
+	errFrame := c.roundtrip(t, &stompFrame{command: "CONNECT", headers: [][2]string{{"accept-version", "1.0,1.1"}}})
+	require.Equal(t, "ERROR", errFrame.command)
+	require.Equal(t, errStompVersionMismatch.Error(), errFrame.header("message"))
+
+	c = newTestSTOMPConn(t, s)
+	errFrame = c.roundtrip(t, &stompFrame{
+		command: "CONNECT",
+		headers: [][2]string{{"accept-version", "1.2"}, {"login", "phil"}, {"passcode", "wrong"}},
+	})
+	require.Equal(t, "ERROR", errFrame.command)
+	require.Equal(t, "unauthorized", errFrame.header("message"))
+
+	c = newTestSTOMPConn(t, s)
+	errFrame = c.roundtrip(t, &stompFrame{command: "SEND", headers: [][2]string{{"destination", "mytopic"}}})
+	require.Equal(t, "ERROR", errFrame.command)
+	require.Equal(t, "expected CONNECT frame", errFrame.header("message"))
+}
+
+func TestSTOMPEscape(t *testing.T) {
+	require.Equal(t, `a\cb\nc\\d\re`, stompEscape("a:b\nc\\d\re"))
+	s, err := stompUnescape(`a\cb\nc\\d\re`)
+	require.Nil(t, err)
+	require.Equal(t, "a:b\nc\\d\re", s)
+	_, err = stompUnescape(`a\tb`)
+	require.Equal(t, errStompInvalidEscape, err)
+	_, err = stompUnescape(`a\`)
+	require.Equal(t, errStompInvalidEscape, err)
+}
+
+func TestSTOMPTopic(t *testing.T) {
+	for _, destination := range []string{"/topic/alerts", "/alerts", "alerts"} {
+		topic, err := stompTopic(destination)
+		require.Nil(t, err)
+		require.Equal(t, "alerts", topic)
+	}
+	_, err := stompTopic("/queue/alerts")
+	require.Equal(t, errStompInvalidTopic, err)
+}
+
+type testSTOMPConn struct {
+	*stompConn
+}
+
+func (c *testSTOMPConn) read(t *testing.T) *stompFrame {
+	frame, err := c.readFrame(false)
+	require.Nil(t, err)
+	return frame
+}
+
+func (c *testSTOMPConn) roundtrip(t *testing.T, frame *stompFrame) *stompFrame {
+	require.Nil(t, c.write(frame))
+	return c.read(t)
+}
+
+// newTestSTOMPConn returns a client connection to a STOMP server. Since frames are symmetric, the client side
+// uses the same frame reader and writer as the server.
+func newTestSTOMPConn(t *testing.T, s *Server) *testSTOMPConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	server := newSTOMPServer(s.config.Load(), s.handle)
+	go server.Serve(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testSTOMPConn{&stompConn{server: server, conn: conn, reader: bufio.NewReader(conn)}}
+}
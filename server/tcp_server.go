@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	tcpReadTimeout  = 5 * time.Minute  // Idle connections are closed after this time
+	tcpWriteTimeout = 10 * time.Second // Replies are dropped if the client does not read them
+	tcpLineOverhead = 128              // Bytes allowed per line on top of the message size limit, for the topic
+)
+
+// TCPAuthRule allows line protocol connections from an IP range (see listen-tcp). If Token is set, messages
+// are published with this access token (i.e. as the token's user), otherwise they are published anonymously.
+type TCPAuthRule struct {
+	Prefix netip.Prefix
+	Token  string
+}
+
+// tcpServer implements the TCP line protocol (see listen-tcp): each line "topic:message" publishes a message.
+// Similar to the SMTP server, lines are translated to internal HTTP requests and passed to the HTTP handler, so
+// that access control and rate limiting work exactly as they do for the HTTP API. Connections are only accepted
+// from source IPs that match one of the auth rules.
+type tcpServer struct {
+	config  *Config
+	handler func(http.ResponseWriter, *http.Request)
+}
+
+func newTCPServer(conf *Config, handler func(http.ResponseWriter, *http.Request)) *tcpServer {
+	return &tcpServer{
+		config:  conf,
+		handler: handler,
+	}
+}
+
+func (s *Server) runTCPServer() error {
+	listener, err := net.Listen("tcp", s.config.Load().ListenTCP)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.tcpListener = listener
+	s.mu.Unlock()
+	return newTCPServer(s.config.Load(), s.handle).Serve(listener)
+}
+
+// Serve accepts connections until the listener is closed
+func (t *tcpServer) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go t.handleConn(conn)
+	}
+}
+
+func (t *tcpServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+	ip, err := tcpRemoteIP(conn)
+	if err != nil {
+		return
+	}
+	ev := log.Tag(tagTCP).Field("tcp_remote_addr", conn.RemoteAddr().String())
+	rule := t.authRule(ip)
+	if rule == nil {
+		ev.Info("Rejecting TCP connection, no auth rule matches source IP %s", ip.String())
+		return
+	}
+	ev.Debug("Accepted TCP connection")
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), int(t.config.MessageSizeLimit)+tcpLineOverhead)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpReadTimeout))
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		reply := "ok"
+		if err := t.publish(ip, rule, line); err != nil {
+			ev.Err(err).Debug("Cannot publish TCP line")
+			reply = "error: " + err.Error()
+		}
+		conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		ev.Err(err).Debug("TCP connection closed with error")
+		if errors.Is(err, bufio.ErrTooLong) {
+			fmt.Fprintln(conn, "error: line too long")
+		}
+	}
+}
+
+// publish parses a "topic:message" line, and publishes it by calling the HTTP handler with a fake request
+func (t *tcpServer) publish(ip netip.Addr, rule *TCPAuthRule, line string) error {
+	topic, message, ok := strings.Cut(line, ":")
+	topic, message = strings.TrimSpace(topic), strings.TrimSpace(message)
+	if !ok || !topicRegex.MatchString(topic) {
+		return errors.New("invalid line, expected format 'topic:message'")
+	} else if message == "" {
+		return errors.New("empty message")
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/%s", t.config.BaseURL, topic), strings.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.RequestURI = "/" + topic                               // Just for the logs
+	req.RemoteAddr = ip.String()                               // Rate limiting
+	req.Header.Set(t.config.ProxyForwardedHeader, ip.String()) // In case we're behind a proxy
+	if rule.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+rule.Token)
+	}
+	rr := httptest.NewRecorder()
+	t.handler(rr, req)
+	if rr.Code != http.StatusOK {
+		if httpErr, err := util.UnmarshalJSON[errHTTP](io.NopCloser(rr.Body)); err == nil {
+			return errors.New(httpErr.Message)
+		}
+		return fmt.Errorf("HTTP %d", rr.Code)
+	}
+	return nil
+}
+
+// authRule returns the first auth rule that matches the IP address, or nil if there is none
+func (t *tcpServer) authRule(ip netip.Addr) *TCPAuthRule {
+	for _, rule := range t.config.TCPAuthRules {
+		if rule.Prefix.Contains(ip) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func tcpRemoteIP(conn net.Conn) (netip.Addr, error) {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addrPort.Addr().Unmap(), nil
+}
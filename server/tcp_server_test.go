@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+)
+
+func TestServer_TCP_Publish(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts", user.PermissionReadWrite))
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(phil.ID, "tcp", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	conf.TCPAuthRules = []*TCPAuthRule{{Prefix: netip.MustParsePrefix("127.0.0.1/32"), Token: token.Value}}
+
+	conn, reader := newTestTCPConn(t, s)
+	_, err = conn.Write([]byte("alerts:disk full\r\n\nalerts:  second  \nmytopic:not allowed\nnot a valid line\n"))
+	require.Nil(t, err)
+	for _, expected := range []string{"ok", "ok", "error: forbidden", "error: invalid line, expected format 'topic:message'"} {
+		line, err := reader.ReadString('\n')
+		require.Nil(t, err)
+		require.Equal(t, expected+"\n", line)
+	}
+
+	response := request(t, s, "GET", "/alerts/json?poll=1", "", map[string]string{
+		"Authorization": "Bearer " + token.Value,
+	})
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "disk full", messages[0].Message)
+	require.Equal(t, "second", messages[1].Message)
+}
+
+func TestServer_TCP_SourceIPNotAllowed(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.TCPAuthRules = []*TCPAuthRule{{Prefix: netip.MustParsePrefix("10.0.0.0/8")}}
+	s := newTestServer(t, conf)
+
+	conn, reader := newTestTCPConn(t, s)
+	conn.Write([]byte("mytopic:hi\n"))
+	_, err := reader.ReadString('\n')
+	require.Error(t, err) // Connection closed
+
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Empty(t, response.Body.String())
+}
+
+func newTestTCPConn(t *testing.T, s *Server) (net.Conn, *bufio.Reader) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go newTCPServer(s.config.Load(), s.handle).Serve(listener)
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
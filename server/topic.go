@@ -5,6 +5,8 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/time/rate"
+
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
 )
@@ -14,16 +16,27 @@ const (
 	// This must be larger than matrixRejectPushKeyForUnifiedPushTopicWithoutRateVisitorAfter to give
 	// time for more requests to come in, so that we can send a {"rejected":["<pushkey>"]} response back.
 	topicExpungeAfter = 16 * time.Hour
+
+	// topicCloseDurationDefault and topicCloseDurationMax define how long a topic is closed by an admin, see topic.Close
+	topicCloseDurationDefault = time.Hour
+	topicCloseDurationMax     = 30 * 24 * time.Hour
 )
 
 // topic represents a channel to which subscribers can subscribe, and publishers
 // can publish a message
 type topic struct {
-	ID          string
-	subscribers map[int]*topicSubscriber
-	rateVisitor *visitor
-	lastAccess  time.Time
-	mu          sync.RWMutex
+	ID               string
+	subscribers      map[int]*topicSubscriber
+	rateVisitor      *visitor
+	lastAccess       time.Time
+	bandwidthLimiter util.Limiter  // Limiter for attachment bandwidth downloads, only set if there is a limit
+	bandwidthLimit   int64         // Daily limit the bandwidthLimiter was created with
+	bandwidthUsed    int64         // Attachment bytes downloaded since the last stats reset
+	appLimiter       *rate.Limiter // Request limiter of a UnifiedPush app, only set if per-app budgets are enabled
+	appMessages      int64         // Messages published by a UnifiedPush app since the last stats reset
+	closedUntil      time.Time     // Publishing is blocked until this time, see Close
+	throttleLimiter  *rate.Limiter // Message limiter of a topic that was reported too often, see Throttle
+	mu               sync.RWMutex
 }
 
 type topicSubscriber struct {
@@ -69,6 +82,10 @@ func (t *topic) Stale() bool {
 	defer t.mu.Unlock()
 	if t.rateVisitor != nil && !t.rateVisitor.Stale() {
 		return false
+	} else if time.Now().Before(t.closedUntil) {
+		return false // Keep topic in memory, so that it stays closed
+	} else if t.throttleLimiter != nil {
+		return false // Keep topic in memory, so that it stays throttled
 	}
 	return len(t.subscribers) == 0 && time.Since(t.lastAccess) > topicExpungeAfter
 }
@@ -134,6 +151,75 @@ func (t *topic) Stats() (int, time.Time) {
 	return len(t.subscribers), t.lastAccess
 }
 
+// BandwidthAllowed adds the given number of attachment bytes to the topic's bandwidth, and returns false if the
+// daily limit would be exceeded. If the limit is zero, the bandwidth is counted, but never limited.
+func (t *topic) BandwidthAllowed(bytes, limit int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastAccess = time.Now() // Keep topic (and its bandwidth limiter) in memory
+	if limit > 0 {
+		if t.bandwidthLimiter == nil || t.bandwidthLimit != limit {
+			t.bandwidthLimiter = util.NewBytesLimiter(int(limit), oneDay)
+			t.bandwidthLimit = limit
+		}
+		if bytes > 0 && !t.bandwidthLimiter.AllowN(bytes) {
+			return false
+		}
+	}
+	t.bandwidthUsed += bytes
+	return true
+}
+
+// BandwidthUsed returns the number of attachment bytes downloaded from this topic since the last stats reset
+func (t *topic) BandwidthUsed() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.bandwidthUsed
+}
+
+// AppRequestAllowed counts a request against the topic's own request limiter, which is (re-)created if the limits
+// changed. This is only used for UnifiedPush topics, so that each app has its own budget (see appBudgetEnabled).
+func (t *topic) AppRequestAllowed(limit rate.Limit, burst int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.appLimiter == nil || t.appLimiter.Limit() != limit || t.appLimiter.Burst() != burst {
+		t.appLimiter = rate.NewLimiter(limit, burst)
+	}
+	return t.appLimiter.Allow()
+}
+
+// AppMessageAllowed counts a message against the topic's own daily message budget, and returns false if the
+// limit is reached. If the limit is zero, messages are counted, but never limited.
+func (t *topic) AppMessageAllowed(limit int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if limit > 0 && t.appMessages >= limit {
+		return false
+	}
+	t.appMessages++
+	return true
+}
+
+// AppStats returns the remaining requests of the topic's request limiter (or -1 if it was never used),
+// and the number of messages published since the last stats reset
+func (t *topic) AppStats() (requestsRemaining float64, messages int64) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.appLimiter == nil {
+		return -1, t.appMessages
+	}
+	return t.appLimiter.Tokens(), t.appMessages
+}
+
+// ResetStats resets the attachment bandwidth used by this topic, as well as the message count of UnifiedPush apps.
+// It is called once a day, together with the visitor stats. The limiters are not reset, since they replenish continuously.
+func (t *topic) ResetStats() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bandwidthUsed = 0
+	t.appMessages = 0
+}
+
 // Keepalive sets the last access time and ensures that Stale does not return true
 func (t *topic) Keepalive() {
 	t.mu.Lock()
@@ -141,6 +227,70 @@ func (t *topic) Keepalive() {
 	t.lastAccess = time.Now()
 }
 
+// Close cancels all subscribers, and blocks publishing to the topic until the given time. It returns the number
+// of subscribers that were disconnected. Subscribers may reconnect right away, but will not receive new messages.
+func (t *topic) Close(until time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closedUntil = until
+	for _, s := range t.subscribers {
+		s.cancel()
+	}
+	return len(t.subscribers)
+}
+
+// Reopen allows publishing to a closed topic again
+func (t *topic) Reopen() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closedUntil = time.Time{}
+}
+
+// ClosedUntil returns the time until which publishing to the topic is blocked, or false if it is not closed
+func (t *topic) ClosedUntil() (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if time.Now().Before(t.closedUntil) {
+		return t.closedUntil, true
+	}
+	return time.Time{}, false
+}
+
+// Throttle limits publishing to the topic to one message per the given interval, until Unthrottle is called.
+// It is used to slow down topics that were reported as abusive too often, see handleReport.
+func (t *topic) Throttle(interval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.throttleLimiter == nil || t.throttleLimiter.Limit() != rate.Every(interval) {
+		t.throttleLimiter = rate.NewLimiter(rate.Every(interval), 1)
+	}
+}
+
+// Unthrottle lifts the limit set by Throttle
+func (t *topic) Unthrottle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.throttleLimiter = nil
+}
+
+// Throttled returns true if publishing to the topic is throttled
+func (t *topic) Throttled() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.throttleLimiter != nil
+}
+
+// ThrottleAllowed counts a message against the topic's throttle limiter, and returns false if the topic is
+// throttled and the limit is reached. If the topic is not throttled, it always returns true.
+func (t *topic) ThrottleAllowed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.throttleLimiter == nil {
+		return true
+	}
+	return t.throttleLimiter.Allow()
+}
+
 // CancelSubscribersExceptUser calls the cancel function for all subscribers, forcing
 func (t *topic) CancelSubscribersExceptUser(exceptUserID string) {
 	t.mu.Lock()
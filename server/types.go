@@ -16,6 +16,10 @@ const (
 	keepaliveEvent   = "keepalive"
 	messageEvent     = "message"
 	pollRequestEvent = "poll_request"
+	ackEvent         = "ack"
+
+	messageUpdatedEvent = "message_updated"
+	messageDeletedEvent = "message_deleted"
 )
 
 const (
@@ -24,24 +28,44 @@ const (
 
 // message represents a message published to a topic
 type message struct {
-	ID          string      `json:"id"`                // Random message ID
-	Time        int64       `json:"time"`              // Unix time in seconds
-	Expires     int64       `json:"expires,omitempty"` // Unix time in seconds (not required for open/keepalive)
-	Event       string      `json:"event"`             // One of the above
-	Topic       string      `json:"topic"`
-	Title       string      `json:"title,omitempty"`
-	Message     string      `json:"message,omitempty"`
-	Priority    int         `json:"priority,omitempty"`
-	Tags        []string    `json:"tags,omitempty"`
-	Click       string      `json:"click,omitempty"`
-	Icon        string      `json:"icon,omitempty"`
-	Actions     []*action   `json:"actions,omitempty"`
-	Attachment  *attachment `json:"attachment,omitempty"`
-	PollID      string      `json:"poll_id,omitempty"`
-	ContentType string      `json:"content_type,omitempty"` // text/plain by default (if empty), or text/markdown
-	Encoding    string      `json:"encoding,omitempty"`     // empty for raw UTF-8, or "base64" for encoded bytes
-	Sender      netip.Addr  `json:"-"`                      // IP address of uploader, used for rate limiting
-	User        string      `json:"-"`                      // UserID of the uploader, used to associated attachments
+	ID          string        `json:"id"`                // Random message ID
+	Time        int64         `json:"time"`              // Unix time in seconds
+	Expires     int64         `json:"expires,omitempty"` // Unix time in seconds (not required for open/keepalive)
+	Event       string        `json:"event"`             // One of the above
+	Topic       string        `json:"topic"`
+	Title       string        `json:"title,omitempty"`
+	Message     string        `json:"message,omitempty"`
+	Priority    int           `json:"priority,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
+	Click       string        `json:"click,omitempty"`
+	Icon        string        `json:"icon,omitempty"`
+	Actions     []*action     `json:"actions,omitempty"`
+	Attachment  *attachment   `json:"attachment,omitempty"`  // First attachment, for clients that do not support multiple attachments
+	Attachments []*attachment `json:"attachments,omitempty"` // All attachments (including the first), only set if there is more than one
+	Preview     *preview      `json:"preview,omitempty"`
+	PollID      string        `json:"poll_id,omitempty"`
+	ContentType string        `json:"content_type,omitempty"` // text/plain by default (if empty), or text/markdown
+	Encoding    string        `json:"encoding,omitempty"`     // empty for raw UTF-8, or "base64" for encoded bytes
+	Encryption  string        `json:"encryption,omitempty"`   // empty for plaintext, or the scheme of a client-side encrypted message, e.g. "aes256gcm"
+	Signature   string        `json:"signature,omitempty"`    // Signed time and detached Ed25519 signature of the message, created by the publisher, see X-Signature
+	ReplyTo     string        `json:"reply_to,omitempty"`     // ID of the message this message is a reply to, see X-Reply-To
+	Cron        string        `json:"cron,omitempty"`         // Cron expression of a recurring message, only set for the scheduled message itself, see X-Cron
+	Ack         *ack          `json:"ack,omitempty"`          // Acknowledgement, only set for "ack" events
+	Duplicates  int           `json:"duplicates,omitempty"`   // Number of suppressed duplicates (see X-Dedup-Key), not stored in the cache
+	Sender      netip.Addr    `json:"-"`                      // IP address of uploader, used for rate limiting
+	User        string        `json:"-"`                      // UserID of the uploader, used to associated attachments
+	Channels    []string      `json:"-"`                      // Delivery channels (see X-Channels), or empty for all channels
+	TTL         int64         `json:"-"`                      // Seconds after delivery at which the message expires, if set by the publisher (see X-Expires)
+}
+
+// attachments returns all attachments of the message, or nil if it has none
+func (m *message) attachments() []*attachment {
+	if len(m.Attachments) > 0 {
+		return m.Attachments
+	} else if m.Attachment != nil {
+		return []*attachment{m.Attachment}
+	}
+	return nil
 }
 
 func (m *message) Context() log.Context {
@@ -62,11 +86,54 @@ func (m *message) Context() log.Context {
 }
 
 type attachment struct {
-	Name    string `json:"name"`
-	Type    string `json:"type,omitempty"`
-	Size    int64  `json:"size,omitempty"`
-	Expires int64  `json:"expires,omitempty"`
-	URL     string `json:"url"`
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Expires   int64  `json:"expires,omitempty"`
+	URL       string `json:"url"`
+	Width     int    `json:"width,omitempty"`     // Only set for JPEG and PNG images, see setImageMetadata
+	Height    int    `json:"height,omitempty"`    // Only set for JPEG and PNG images
+	Thumbnail string `json:"thumbnail,omitempty"` // Only set for the first attachment, if it is an image
+}
+
+// quarantinedAttachment describes an attachment that was moved to the quarantine directory, see quarantineAttachment
+type quarantinedAttachment struct {
+	MessageID string `json:"message_id"`
+	Topic     string `json:"topic"`
+	Time      int64  `json:"time"`
+	Sender    string `json:"sender"`
+	User      string `json:"user,omitempty"`
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Size      int64  `json:"size"`
+	Threat    string `json:"threat"`
+}
+
+// preview is the Open Graph metadata of the first URL in a message, see enable-link-previews
+type preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// ack is an acknowledgement of a message by a subscriber, see POST /<topic>/ack/<message-id>
+type ack struct {
+	MessageID string `json:"message_id,omitempty"` // Only set in "ack" events
+	User      string `json:"user,omitempty"`       // Empty for anonymous acknowledgements
+	Time      int64  `json:"time,omitempty"`       // Only set when listing acknowledgements, events have their own time
+}
+
+// report is an abuse report of a topic or message, see handleReport
+type report struct {
+	ID        string `json:"id"`
+	Time      int64  `json:"time"`
+	Topic     string `json:"topic"`
+	MessageID string `json:"message_id,omitempty"` // Empty if the entire topic was reported
+	User      string `json:"user,omitempty"`       // Empty for anonymous reporters
+	Sender    string `json:"sender"`               // IP address of the reporter
+	Reason    string `json:"reason,omitempty"`
 }
 
 type action struct {
@@ -91,22 +158,30 @@ func newAction() *action {
 
 // publishMessage is used as input when publishing as JSON
 type publishMessage struct {
-	Topic    string   `json:"topic"`
-	Title    string   `json:"title"`
-	Message  string   `json:"message"`
-	Priority int      `json:"priority"`
-	Tags     []string `json:"tags"`
-	Click    string   `json:"click"`
-	Icon     string   `json:"icon"`
-	Actions  []action `json:"actions"`
-	Attach   string   `json:"attach"`
-	Markdown bool     `json:"markdown"`
-	Filename string   `json:"filename"`
-	Email    string   `json:"email"`
-	Call     string   `json:"call"`
-	Cache    string   `json:"cache"`    // use string as it defaults to true (or use &bool instead)
-	Firebase string   `json:"firebase"` // use string as it defaults to true (or use &bool instead)
-	Delay    string   `json:"delay"`
+	Topic       string   `json:"topic"`
+	Title       string   `json:"title"`
+	Message     string   `json:"message"`
+	Priority    int      `json:"priority"`
+	Tags        []string `json:"tags"`
+	Click       string   `json:"click"`
+	Icon        string   `json:"icon"`
+	Actions     []action `json:"actions"`
+	Attach      string   `json:"attach"`
+	Attachments []string `json:"attachments"` // Additional attachment URLs, see X-Attach
+	Markdown    bool     `json:"markdown"`
+	Filename    string   `json:"filename"`
+	Email       string   `json:"email"`
+	Call        string   `json:"call"`
+	Channels    []string `json:"channels"`
+	Encryption  string   `json:"encryption"`
+	Signature   string   `json:"signature"`
+	ReplyTo     string   `json:"reply_to"`
+	Cache       string   `json:"cache"`    // use string as it defaults to true (or use &bool instead)
+	Firebase    string   `json:"firebase"` // use string as it defaults to true (or use &bool instead)
+	Delay       string   `json:"delay"`
+	Expires     string   `json:"expires"`
+	Upload      string   `json:"upload"`
+	Cron        string   `json:"cron"`
 }
 
 // messageEncoder is a function that knows how to encode a message
@@ -145,6 +220,31 @@ func newPollRequestMessage(topic, pollID string) *message {
 	return m
 }
 
+// newAckMessage is a convenience method to create an ack event for the given message
+func newAckMessage(topic, messageID, username string) *message {
+	m := newMessage(ackEvent, topic, "")
+	m.Ack = &ack{
+		MessageID: messageID,
+		User:      username,
+	}
+	return m
+}
+
+// newMessageUpdatedMessage is a convenience method to create a message_updated event for the given (updated)
+// message. The event has the same ID as the message, so that clients can replace the message they have.
+func newMessageUpdatedMessage(m *message) *message {
+	ev := *m
+	ev.Event = messageUpdatedEvent
+	return &ev
+}
+
+// newMessageDeletedMessage is a convenience method to create a message_deleted event for the given message
+func newMessageDeletedMessage(topic, messageID string) *message {
+	m := newMessage(messageDeletedEvent, topic, "")
+	m.ID = messageID
+	return m
+}
+
 func validMessageID(s string) bool {
 	return util.ValidRandomString(s, messageIDLength)
 }
@@ -198,6 +298,8 @@ type queryFilter struct {
 	Title    string
 	Tags     []string
 	Priority []int
+	Acks     bool
+	Deleted  bool // Include "message_deleted" events for messages deleted since the since marker, see deleted=1
 }
 
 func parseQueryFilters(r *http.Request) (*queryFilter, error) {
@@ -205,6 +307,8 @@ func parseQueryFilters(r *http.Request) (*queryFilter, error) {
 	messageFilter := readParam(r, "x-message", "message", "m")
 	titleFilter := readParam(r, "x-title", "title", "t")
 	tagsFilter := util.SplitNoEmpty(readParam(r, "x-tags", "tags", "tag", "ta"), ",")
+	acksFilter := readBoolParam(r, false, "x-acks", "acks")
+	deletedFilter := readBoolParam(r, false, "x-deleted", "deleted")
 	priorityFilter := make([]int, 0)
 	for _, p := range util.SplitNoEmpty(readParam(r, "x-priority", "priority", "prio", "p"), ",") {
 		priority, err := util.ParsePriority(p)
@@ -219,11 +323,15 @@ func parseQueryFilters(r *http.Request) (*queryFilter, error) {
 		Title:    titleFilter,
 		Tags:     tagsFilter,
 		Priority: priorityFilter,
+		Acks:     acksFilter,
+		Deleted:  deletedFilter,
 	}, nil
 }
 
 func (q *queryFilter) Pass(msg *message) bool {
-	if msg.Event != messageEvent {
+	if msg.Event == ackEvent {
+		return q.Acks && (q.ID == "" || msg.Ack.MessageID == q.ID) // ack events are opt-in, see acks=1
+	} else if msg.Event != messageEvent {
 		return true // filters only apply to messages
 	} else if q.ID != "" && msg.ID != q.ID {
 		return false
@@ -299,6 +407,73 @@ type apiStatsResponse struct {
 	MessagesRate float64 `json:"messages_rate"` // Average number of messages per second
 }
 
+type apiAdminDashboardResponse struct {
+	Version           string                                `json:"version"`
+	Started           int64                                 `json:"started"` // Unix timestamp
+	Uptime            int64                                 `json:"uptime"`  // Seconds since the server was started
+	Topics            int                                   `json:"topics"`
+	Subscribers       int                                   `json:"subscribers"`
+	Visitors          int                                   `json:"visitors"`
+	Users             int64                                 `json:"users"`
+	Messages          int64                                 `json:"messages"`
+	MessagesPerMinute float64                               `json:"messages_per_minute"`
+	Deliveries        map[string]*apiAdminDashboardDelivery `json:"deliveries"` // Channel (e.g. firebase) -> delivery counts
+	Cache             *apiAdminDashboardCache               `json:"cache"`
+	Pending           *apiAdminDashboardPending             `json:"pending"`
+}
+
+type apiAdminDashboardDelivery struct {
+	Success   int64   `json:"success"`
+	Failure   int64   `json:"failure"`
+	ErrorRate float64 `json:"error_rate"` // Between 0 and 1
+}
+
+type apiAdminDashboardCache struct {
+	Messages             int   `json:"messages"`
+	AttachmentsSize      int64 `json:"attachments_size"`
+	AttachmentsRemaining int64 `json:"attachments_remaining"`
+}
+
+type apiAdminDashboardPending struct {
+	ScheduledMessages int `json:"scheduled_messages"`
+	MessageCacheQueue int `json:"message_cache_queue"`
+	ClusterQueue      int `json:"cluster_queue"`
+}
+
+type apiStatsRollupsResponse struct {
+	Period  string            `json:"period"`
+	Rollups []*apiStatsRollup `json:"rollups"`
+}
+
+type apiStatsRollup struct {
+	Start    int64 `json:"start"`
+	Messages int64 `json:"messages"`
+	Emails   int64 `json:"emails"`
+	Calls    int64 `json:"calls"`
+	Visitors int64 `json:"visitors"`
+	Users    int64 `json:"users"`
+}
+
+type apiUsageStats struct {
+	Day             int64  `json:"day,omitempty"`
+	Username        string `json:"username,omitempty"`
+	Topic           string `json:"topic,omitempty"`
+	Messages        int64  `json:"messages"`
+	Subscriptions   int64  `json:"subscriptions"`
+	Attachments     int64  `json:"attachments"`
+	AttachmentBytes int64  `json:"attachment_bytes"`
+}
+
+type apiAccountUsageStatsResponse struct {
+	Stats []*apiUsageStats `json:"stats"`
+}
+
+type apiAdminUsageStatsResponse struct {
+	By    string           `json:"by"`
+	Sort  string           `json:"sort"`
+	Stats []*apiUsageStats `json:"stats"`
+}
+
 type apiUserAddOrUpdateRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -308,10 +483,18 @@ type apiUserAddOrUpdateRequest struct {
 }
 
 type apiUserResponse struct {
-	Username string                  `json:"username"`
-	Role     string                  `json:"role"`
-	Tier     string                  `json:"tier,omitempty"`
-	Grants   []*apiUserGrantResponse `json:"grants,omitempty"`
+	Username     string                  `json:"username"`
+	Role         string                  `json:"role"`
+	Tier         string                  `json:"tier,omitempty"`
+	Grants       []*apiUserGrantResponse `json:"grants,omitempty"`
+	PhoneNumbers []string                `json:"phone_numbers,omitempty"`
+	Stats        *apiUserStatsResponse   `json:"stats,omitempty"`
+}
+
+type apiUserStatsResponse struct {
+	Messages int64 `json:"messages"`
+	Emails   int64 `json:"emails"`
+	Calls    int64 `json:"calls"`
 }
 
 type apiUserGrantResponse struct {
@@ -334,6 +517,64 @@ type apiAccessResetRequest struct {
 	Topic    string `json:"topic"`
 }
 
+type apiAccessResponse struct {
+	DefaultAccess string                   `json:"default_access"`
+	Users         []*apiAccessUserResponse `json:"users"`
+	Groups        []*apiGroupResponse      `json:"groups"`
+}
+
+type apiAccessUserResponse struct {
+	Username string                  `json:"username"`
+	Role     string                  `json:"role"`
+	Grants   []*apiUserGrantResponse `json:"grants"`
+}
+
+type apiGroupResponse struct {
+	Name    string                  `json:"name"`
+	Members []string                `json:"members"`
+	Grants  []*apiUserGrantResponse `json:"grants"`
+}
+
+type apiGroupAddRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members,omitempty"`
+}
+
+type apiGroupDeleteRequest struct {
+	Name string `json:"name"`
+}
+
+type apiGroupMemberRequest struct {
+	Group    string `json:"group"`
+	Username string `json:"username"`
+}
+
+type apiAuthzExplainResponse struct {
+	Username      string                  `json:"username"`
+	Role          string                  `json:"role"`
+	Tier          string                  `json:"tier,omitempty"`
+	Topic         string                  `json:"topic"`
+	Permission    string                  `json:"permission"`
+	Allowed       bool                    `json:"allowed"`
+	Rule          string                  `json:"rule"`   // "admin", "reservation", "user", "group", "everyone" or "default"
+	Reason        string                  `json:"reason"` // Human-readable explanation
+	Entry         *apiAuthzExplainEntry   `json:"entry,omitempty"`
+	Skipped       []*apiAuthzExplainEntry `json:"skipped,omitempty"` // Entries outside their validity window
+	DefaultAccess string                  `json:"default_access"`
+}
+
+type apiAuthzExplainEntry struct {
+	Username    string `json:"username"`
+	Group       string `json:"group,omitempty"` // Group the entry belongs to, if it is a group entry
+	Topic       string `json:"topic"`           // This may be a pattern
+	Permission  string `json:"permission"`
+	Owner       string `json:"owner,omitempty"` // User that reserved the topic
+	Provisioned bool   `json:"provisioned,omitempty"`
+	Expires     int64  `json:"expires,omitempty"`
+	Hours       string `json:"hours,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+}
+
 type apiAccountCreateRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -349,8 +590,28 @@ type apiAccountDeleteRequest struct {
 }
 
 type apiAccountTokenIssueRequest struct {
-	Label   *string `json:"label"`
-	Expires *int64  `json:"expires"` // Unix timestamp
+	Label   *string  `json:"label"`
+	Expires *int64   `json:"expires"` // Unix timestamp
+	Scopes  []string `json:"scopes"`  // Token scopes, e.g. "publish" or "topic:alerts", see user.TokenScope
+	Refresh bool     `json:"refresh"` // Start a session with a short-lived access token and a refresh token, see user.Session
+}
+
+type apiAccountTokenRefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type apiAccountTokenQRCodeRequest struct {
+	Topics  []string `json:"topics"`
+	Write   bool     `json:"write"` // Also allow publishing to the topics
+	Label   *string  `json:"label"`
+	Expires *int64   `json:"expires"` // Unix timestamp
+}
+
+// apiAccountTokenQRCodePayload is the content of the QR code rendered by handleAccountTokenQRCode
+type apiAccountTokenQRCodePayload struct {
+	BaseURL string   `json:"base_url"`
+	Topics  []string `json:"topics,omitempty"`
+	Token   string   `json:"token"`
 }
 
 type apiAccountTokenUpdateRequest struct {
@@ -360,12 +621,23 @@ type apiAccountTokenUpdateRequest struct {
 }
 
 type apiAccountTokenResponse struct {
-	Token       string `json:"token"`
-	Label       string `json:"label,omitempty"`
-	LastAccess  int64  `json:"last_access,omitempty"`
-	LastOrigin  string `json:"last_origin,omitempty"`
-	Expires     int64  `json:"expires,omitempty"`     // Unix timestamp
-	Provisioned bool   `json:"provisioned,omitempty"` // True if this token was provisioned by the server config
+	Token         string   `json:"token"`
+	Label         string   `json:"label,omitempty"`
+	LastAccess    int64    `json:"last_access,omitempty"`
+	LastOrigin    string   `json:"last_origin,omitempty"`
+	LastUserAgent string   `json:"last_user_agent,omitempty"`
+	LastCountry   string   `json:"last_country,omitempty"` // Only set if GeoIP lookups are enabled
+	LastASN       uint32   `json:"last_asn,omitempty"`     // Only set if GeoIP lookups are enabled
+	Expires       int64    `json:"expires,omitempty"`      // Unix timestamp
+	Provisioned   bool     `json:"provisioned,omitempty"`  // True if this token was provisioned by the server config
+	Scopes        []string `json:"scopes,omitempty"`
+}
+
+type apiAccountSessionResponse struct {
+	Token          string `json:"token"`
+	Expires        int64  `json:"expires"` // Unix timestamp
+	RefreshToken   string `json:"refresh_token"`
+	RefreshExpires int64  `json:"refresh_expires"` // Unix timestamp
 }
 
 type apiAccountPhoneNumberVerifyRequest struct {
@@ -378,9 +650,124 @@ type apiAccountPhoneNumberAddRequest struct {
 	Code   string `json:"code"` // Only set when adding a phone number
 }
 
+type apiAccountPhoneNumbersResponse struct {
+	PhoneNumbers []string `json:"phone_numbers"`
+}
+
+type apiAccountEmailVerifyRequest struct {
+	Email string `json:"email"`
+}
+
+type apiAccountEmailChangeRequest struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+type apiAccountEmail struct {
+	Address       string `json:"address"`
+	Fallback      string `json:"fallback,omitempty"`
+	FallbackUntil int64  `json:"fallback_until,omitempty"`
+}
+
+type apiUserPhoneNumberRequest struct {
+	Username string `json:"username"`
+	Number   string `json:"number"`
+}
+
+type apiUsersTokensDeleteResponse struct {
+	Success bool  `json:"success"`
+	Removed int64 `json:"removed"`
+}
+
+type apiUsersTokenRequest struct {
+	Username string   `json:"username"`
+	Token    string   `json:"token"`   // Only used when deleting a token
+	Label    *string  `json:"label"`   // Only used when creating a token
+	Expires  *int64   `json:"expires"` // Unix timestamp, only used when creating a token
+	Scopes   []string `json:"scopes"`  // Only used when creating a token
+}
+
+type apiAdminTokenInspectRequest struct {
+	Token string `json:"token"`
+}
+
+type apiAdminTokensBulkRequest struct {
+	Username string   `json:"username"`
+	Topic    string   `json:"topic"`   // Topic pattern, e.g. kiosk_*, only used when creating tokens
+	Count    int      `json:"count"`   // Only used when creating tokens
+	Label    string   `json:"label"`   // Label prefix, e.g. kiosk -> kiosk-001, only used when creating tokens
+	Expires  *int64   `json:"expires"` // Unix timestamp, only used when creating tokens
+	Scopes   []string `json:"scopes"`  // Only publish and subscribe, only used when creating tokens
+	Batch    string   `json:"batch"`   // Only used when deleting tokens
+}
+
+type apiAdminTokensBulkResponse struct {
+	Batch    string                     `json:"batch"`
+	Username string                     `json:"username"`
+	Topic    string                     `json:"topic"`
+	Scopes   []string                   `json:"scopes"`
+	Expires  int64                      `json:"expires"`
+	Tokens   []*apiAdminTokensBulkToken `json:"tokens"`
+}
+
+type apiAdminTokensBulkToken struct {
+	Label string `json:"label"`
+	Token string `json:"token"`
+}
+
+type apiUsersTokenResponse struct {
+	Username      string   `json:"username"`
+	Token         string   `json:"token"`
+	Label         string   `json:"label,omitempty"`
+	LastAccess    int64    `json:"last_access,omitempty"`
+	LastOrigin    string   `json:"last_origin,omitempty"`
+	LastUserAgent string   `json:"last_user_agent,omitempty"`
+	LastCountry   string   `json:"last_country,omitempty"`
+	LastASN       uint32   `json:"last_asn,omitempty"`
+	Expires       int64    `json:"expires,omitempty"` // Unix timestamp, 0 if the token never expires
+	Provisioned   bool     `json:"provisioned,omitempty"`
+	Scopes        []string `json:"scopes,omitempty"`
+	Batch         string   `json:"batch,omitempty"` // Only set for tokens created in bulk, see handleAdminTokensBulkCreate
+}
+
+type apiAccountWebAuthnChallengeResponse struct {
+	Challenge   string   `json:"challenge"`
+	RPID        string   `json:"rp_id"`
+	Credentials []string `json:"credentials"`
+}
+
+type apiAccountWebAuthnCredentialResponse struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Created int64  `json:"created"`
+}
+
+type apiAccountWebAuthnAddRequest struct {
+	Password          string `json:"password"`
+	Name              string `json:"name"`
+	CredentialID      string `json:"credential_id"`
+	PublicKey         string `json:"public_key"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+}
+
+type apiAccountWebAuthnDeleteRequest struct {
+	CredentialID string `json:"credential_id"`
+}
+
+// apiWebAuthnAssertion is the result of navigator.credentials.get(), passed via the X-WebAuthn header
+// as JSON to confirm an operation. All fields are base64 URL-encoded.
+type apiWebAuthnAssertion struct {
+	CredentialID      string `json:"credential_id"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+	Signature         string `json:"signature"`
+}
+
 type apiAccountTier struct {
-	Code string `json:"code"`
-	Name string `json:"name"`
+	Code     string            `json:"code"`
+	Name     string            `json:"name"`
+	Features user.TierFeatures `json:"features,omitempty"`
 }
 
 type apiAccountLimits struct {
@@ -396,6 +783,25 @@ type apiAccountLimits struct {
 	AttachmentBandwidth      int64  `json:"attachment_bandwidth"`
 }
 
+type apiAccountLimitsResponse struct {
+	Basis               string           `json:"basis"`             // "ip" or "tier"
+	Country             string           `json:"country,omitempty"` // ISO country code of the visitor's IP address, if geoip-database is set
+	Requests            *apiAccountQuota `json:"requests"`
+	Messages            *apiAccountQuota `json:"messages"`
+	Emails              *apiAccountQuota `json:"emails"`
+	Calls               *apiAccountQuota `json:"calls"`
+	Subscriptions       *apiAccountQuota `json:"subscriptions"`
+	AttachmentTotalSize *apiAccountQuota `json:"attachment_total_size"`
+	AttachmentBandwidth *apiAccountQuota `json:"attachment_bandwidth"`
+}
+
+type apiAccountQuota struct {
+	Limit     int64 `json:"limit"`
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining"`
+	Reset     int64 `json:"reset,omitempty"` // Unix timestamp at which the quota is fully replenished
+}
+
 type apiAccountStats struct {
 	Messages                     int64 `json:"messages"`
 	MessagesRemaining            int64 `json:"messages_remaining"`
@@ -421,6 +827,7 @@ type apiAccountBilling struct {
 	Interval     string `json:"interval,omitempty"`
 	PaidUntil    int64  `json:"paid_until,omitempty"`
 	CancelAt     int64  `json:"cancel_at,omitempty"`
+	GraceUntil   int64  `json:"grace_until,omitempty"`
 }
 
 type apiAccountResponse struct {
@@ -434,10 +841,12 @@ type apiAccountResponse struct {
 	Reservations  []*apiAccountReservation   `json:"reservations,omitempty"`
 	Tokens        []*apiAccountTokenResponse `json:"tokens,omitempty"`
 	PhoneNumbers  []string                   `json:"phone_numbers,omitempty"`
+	Email         *apiAccountEmail           `json:"email,omitempty"`
 	Tier          *apiAccountTier            `json:"tier,omitempty"`
 	Limits        *apiAccountLimits          `json:"limits,omitempty"`
 	Stats         *apiAccountStats           `json:"stats,omitempty"`
 	Billing       *apiAccountBilling         `json:"billing,omitempty"`
+	Experiments   []string                   `json:"experiments,omitempty"`
 }
 
 type apiAccountReservationRequest struct {
@@ -445,6 +854,153 @@ type apiAccountReservationRequest struct {
 	Everyone string `json:"everyone"`
 }
 
+type apiAccountEmailTemplate struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type apiAccountTopicTemplate struct {
+	Title    string `json:"title,omitempty"`
+	Message  string `json:"message"`
+	Tags     string `json:"tags,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+type apiAccountTopicPolicy struct {
+	CacheDuration           int64 `json:"cache_duration,omitempty"` // Seconds
+	MessageLimit            int64 `json:"message_limit,omitempty"`
+	AttachmentFileSizeLimit int64 `json:"attachment_file_size_limit,omitempty"`
+}
+
+type apiAccountTopicTelegram struct {
+	BotToken string `json:"bot_token"`
+	ChatID   string `json:"chat_id"`
+}
+
+type apiAccountTopicIngest struct {
+	Source string `json:"source"`
+	Secret string `json:"secret,omitempty"` // Only set when changing the settings, never returned
+}
+
+type apiAccountTopicKey struct {
+	Key        string `json:"key,omitempty"` // Only set when changing the key, never returned
+	Permission string `json:"permission"`
+}
+
+type apiAccountWebhook struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	Format  string `json:"format"`
+	Secret  string `json:"secret"`
+	Created int64  `json:"created"`
+}
+
+type apiAccountWebhookAddRequest struct {
+	URL    string `json:"url"`
+	Format string `json:"format,omitempty"` // Defaults to "ntfy"
+}
+
+// apiTriggerHookAddRequest is the body of a REST hook subscription. Zapier sends "hookUrl" (or "target_url"
+// for legacy REST hooks), and "url" is accepted for consistency with apiAccountWebhookAddRequest.
+type apiTriggerHookAddRequest struct {
+	HookURL   string `json:"hookUrl"`
+	TargetURL string `json:"target_url"`
+	URL       string `json:"url"`
+}
+
+type apiTriggerIFTTTRequest struct {
+	Limit  *int   `json:"limit"` // IFTTT may send a limit of 0, so this must be a pointer
+	Cursor string `json:"cursor"`
+}
+
+type apiTriggerIFTTTResponse struct {
+	Data   []*apiTriggerIFTTTItem `json:"data"`
+	Cursor string                 `json:"cursor,omitempty"`
+}
+
+type apiTriggerIFTTTItem struct {
+	*message
+	Meta *apiTriggerIFTTTMeta `json:"meta"`
+}
+
+type apiTriggerIFTTTMeta struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type apiAccountWebhookDelivery struct {
+	MessageID  string `json:"message_id"`
+	Time       int64  `json:"time"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+type apiAccountSigningKey struct {
+	ID        string `json:"id"`
+	PublicKey string `json:"public_key"` // Base64
+	Created   int64  `json:"created"`
+}
+
+type apiAccountSigningKeyAddRequest struct {
+	PublicKey string `json:"public_key"` // PEM (PKIX) or base64
+}
+
+type apiAdminTopicPolicy struct {
+	Topic                   string `json:"topic"`
+	Owner                   string `json:"owner,omitempty"` // Username, empty if managed by an admin
+	CacheDuration           int64  `json:"cache_duration,omitempty"`
+	MessageLimit            int64  `json:"message_limit,omitempty"`
+	AttachmentFileSizeLimit int64  `json:"attachment_file_size_limit,omitempty"`
+}
+
+type apiAdminBan struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Value   string `json:"value"`
+	Reason  string `json:"reason,omitempty"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires,omitempty"` // Unix timestamp, empty if the ban never expires
+}
+
+type apiAdminBanRequest struct {
+	ID       string `json:"id,omitempty"` // Only for lifting a ban
+	Kind     string `json:"kind,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Duration string `json:"duration,omitempty"` // e.g. "1d", empty if the ban never expires
+}
+
+type apiAdminTopicRequest struct {
+	Topic    string `json:"topic"`
+	Duration string `json:"duration,omitempty"` // Only for closing a topic, e.g. "1h", default is 1 hour
+}
+
+type apiAdminTopicPurgeResponse struct {
+	Topic    string `json:"topic"`
+	Messages int    `json:"messages"` // Number of deleted messages
+}
+
+type apiAdminTopicCloseResponse struct {
+	Topic       string `json:"topic"`
+	ClosedUntil int64  `json:"closed_until"`
+	Subscribers int    `json:"subscribers"` // Number of disconnected subscribers
+}
+
+type apiAdminReportsResponse struct {
+	Reports   []*report `json:"reports"`
+	Throttled []string  `json:"throttled"` // Topics that are currently throttled due to abuse reports
+}
+
+type apiAdminReportDeleteRequest struct {
+	Topic string `json:"topic"`
+	ID    string `json:"id"` // Optional, all reports of the topic are deleted if empty
+}
+
+type apiAdminReportDeleteResponse struct {
+	Reports int `json:"reports"` // Number of deleted reports
+}
+
 type apiConfigResponse struct {
 	BaseURL            string   `json:"base_url"`
 	AppRoot            string   `json:"app_root"`
@@ -459,6 +1015,10 @@ type apiConfigResponse struct {
 	BillingContact     string   `json:"billing_contact"`
 	WebPushPublicKey   string   `json:"web_push_public_key"`
 	DisallowedTopics   []string `json:"disallowed_topics"`
+	AppName            string   `json:"app_name"`
+	AppLogoURL         string   `json:"app_logo_url"`
+	AppAccentColor     string   `json:"app_accent_color"`
+	AppTermsURL        string   `json:"app_terms_url"`
 }
 
 type apiAccountBillingPrices struct {
@@ -487,7 +1047,9 @@ type apiAccountBillingPortalRedirectResponse struct {
 }
 
 type apiAccountSyncTopicResponse struct {
-	Event string `json:"event"`
+	Event   string `json:"event"`
+	Quota   string `json:"quota,omitempty"`   // Only set for "quota_warning" events, "messages" or "emails"
+	Percent int    `json:"percent,omitempty"` // Only set for "quota_warning" events
 }
 
 type apiSuccessResponse struct {
@@ -523,6 +1085,13 @@ type apiStripeSubscriptionDeletedEvent struct {
 	Customer string `json:"customer"`
 }
 
+type apiStripeInvoiceEvent struct {
+	ID           string `json:"id"`
+	Customer     string `json:"customer"`
+	Subscription string `json:"subscription"`
+	AttemptCount int    `json:"attempt_count"`
+}
+
 type apiWebPushUpdateSubscriptionRequest struct {
 	Endpoint string   `json:"endpoint"`
 	Auth     string   `json:"auth"`
@@ -530,6 +1099,81 @@ type apiWebPushUpdateSubscriptionRequest struct {
 	Topics   []string `json:"topics"`
 }
 
+type apiTopicStatsResponse struct {
+	Topic                    string `json:"topic"`
+	Subscribers              int    `json:"subscribers"`
+	AttachmentBandwidth      int64  `json:"attachment_bandwidth"`                 // Bytes downloaded today
+	AttachmentBandwidthLimit int64  `json:"attachment_bandwidth_limit,omitempty"` // Daily limit, zero means unlimited
+}
+
+// apiUnifiedPushBudgetResponse is the remaining budget of a UnifiedPush app, see handleUnifiedPushBudget
+type apiUnifiedPushBudgetResponse struct {
+	Topic             string `json:"topic"`
+	Active            bool   `json:"active"` // True if the topic has a rate visitor, i.e. the budget is currently in use
+	RequestsLimit     int64  `json:"requests_limit"`
+	RequestsRemaining int64  `json:"requests_remaining"`
+	Messages          int64  `json:"messages"`                     // Messages published today
+	MessagesLimit     int64  `json:"messages_limit,omitempty"`     // Daily limit, zero means unlimited
+	MessagesRemaining int64  `json:"messages_remaining,omitempty"` // Only set if there is a daily limit
+}
+
+type apiReportRequest struct {
+	Topic     string `json:"topic"`
+	MessageID string `json:"message_id"`
+	Reason    string `json:"reason"`
+}
+
+type apiReportResponse struct {
+	ID        string `json:"id"`
+	Topic     string `json:"topic"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+type apiUploadResponse struct {
+	ID      string `json:"id"`
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+	Expires int64  `json:"expires"` // Unix time, extended with every chunk
+}
+
+type apiEphemeralTopicRequest struct {
+	TTL string `json:"ttl"`
+}
+
+type apiEphemeralTopicResponse struct {
+	Topic      string `json:"topic"`
+	URL        string `json:"url,omitempty"`
+	ReadToken  string `json:"read_token"`
+	WriteToken string `json:"write_token"`
+	Expires    int64  `json:"expires"` // Unix time
+}
+
+type apiAcksResponse struct {
+	MessageID string `json:"message_id"`
+	Acks      []*ack `json:"acks"`
+}
+
+// apiPublishPreviewResponse is the response of a dry run publish (see handlePublishPreview), describing the
+// message as it would have been published, and the channels it would have been delivered to
+type apiPublishPreviewResponse struct {
+	Message     *message                    `json:"message"`
+	Cached      bool                        `json:"cached"`
+	Delayed     bool                        `json:"delayed"`
+	Subscribers int                         `json:"subscribers"` // Number of currently connected subscribers
+	Channels    []*apiPublishPreviewChannel `json:"channels"`
+}
+
+type apiPublishPreviewChannel struct {
+	Channel   string `json:"channel"`             // See channels.go
+	Recipient string `json:"recipient,omitempty"` // E-mail address or phone number
+	Count     int    `json:"count,omitempty"`     // Number of webhooks, Matrix rooms or Telegram chats
+}
+
+type apiAPNSUpdateDeviceRequest struct {
+	Token  string   `json:"token"`
+	Topics []string `json:"topics"`
+}
+
 // List of possible Web Push events (see sw.js)
 const (
 	webPushMessageEvent  = "message"
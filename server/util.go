@@ -9,7 +9,9 @@ import (
 	"net/http"
 	"net/netip"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"heckel.io/ntfy/v2/util"
 )
@@ -61,6 +63,29 @@ func readParam(r *http.Request, names ...string) string {
 	return readQueryParam(r, names...)
 }
 
+// readParams is like readParam, but returns all values of the parameter, e.g. if a header is passed more than once
+func readParams(r *http.Request, names ...string) []string {
+	values := make([]string, 0)
+	for _, name := range names {
+		for _, value := range r.Header.Values(name) {
+			if value = strings.TrimSpace(maybeDecodeHeader(name, value)); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) > 0 {
+		return values
+	}
+	for _, name := range names {
+		for _, value := range r.URL.Query()[strings.ToLower(name)] {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
 func readHeaderParam(r *http.Request, names ...string) string {
 	for _, name := range names {
 		value := strings.TrimSpace(maybeDecodeHeader(name, r.Header.Get(name)))
@@ -83,66 +108,75 @@ func readQueryParam(r *http.Request, names ...string) string {
 
 // extractIPAddress extracts the IP address of the visitor from the request,
 // either from the TCP socket or from a proxy header.
+//
+// The proxy header is only used if the request comes from one of the trusted proxies (see trusted-proxies),
+// or if behindProxy is set. The latter trusts the header of any client, and only exists for backwards compatibility.
 func extractIPAddress(r *http.Request, behindProxy bool, proxyForwardedHeader string, proxyTrustedPrefixes []netip.Prefix) netip.Addr {
-	if behindProxy && proxyForwardedHeader != "" {
+	remoteAddr, err := netip.ParseAddrPort(r.RemoteAddr)
+	fromTrustedProxy := err == nil && util.ContainsIP(proxyTrustedPrefixes, remoteAddr.Addr().Unmap())
+	if proxyForwardedHeader != "" && (behindProxy || fromTrustedProxy) {
 		if addr, err := extractIPAddressFromHeader(r, proxyForwardedHeader, proxyTrustedPrefixes); err == nil {
 			return addr
 		}
 		// Fall back to the remote address if the header is not found or invalid
 	}
-	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
 	if err != nil {
 		logr(r).Err(err).Warn("unable to parse IP (%s), new visitor with unspecified IP (0.0.0.0) created", r.RemoteAddr)
 		return netip.IPv4Unspecified()
 	}
-	return addrPort.Addr()
+	return remoteAddr.Addr()
 }
 
-// extractIPAddressFromHeader extracts the last IP address from the specified header.
+// extractIPAddressFromHeader extracts the client IP address from the specified header.
 //
 // It supports multiple formats:
 // - single IP address
 // - comma-separated list
 // - RFC 7239-style list (Forwarded header)
 //
-// If there are multiple addresses, we first remove the trusted IP addresses from the list, and
-// then take the right-most address in the list (as this is the one added by our proxy server).
+// Each proxy appends the address it received the request from to the list, so only the right-most entries
+// can be trusted. We walk the list from right to left, skip the addresses of trusted proxies, and return the
+// first address that is not a trusted proxy. Anything left of it may have been sent by the client, and is ignored.
+// If the header is set more than once, all values are considered, since proxies may append a new header line.
 // See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Forwarded-For for details.
 func extractIPAddressFromHeader(r *http.Request, forwardedHeader string, trustedPrefixes []netip.Prefix) (netip.Addr, error) {
-	value := strings.TrimSpace(strings.ToLower(r.Header.Get(forwardedHeader)))
-	if value == "" {
+	value := strings.TrimSpace(strings.ToLower(strings.Join(r.Header.Values(forwardedHeader), ",")))
+	hops := util.Filter(util.Map(strings.Split(value, ","), strings.TrimSpace), func(hop string) bool {
+		return hop != ""
+	})
+	if len(hops) == 0 {
 		return netip.IPv4Unspecified(), fmt.Errorf("no %s header found", forwardedHeader)
 	}
-	// Extract valid addresses
-	addrsStrs := util.Map(util.SplitNoEmpty(value, ","), strings.TrimSpace)
-	var validAddrs []netip.Addr
-	for _, addrStr := range addrsStrs {
-		// Handle Forwarded header with for="[IPv6]" or for="IPv4"
-		if m := forwardedHeaderRegex.FindStringSubmatch(addrStr); len(m) == 2 {
-			addrRaw := m[1]
-			if strings.HasPrefix(addrRaw, "[") && strings.HasSuffix(addrRaw, "]") {
-				addrRaw = addrRaw[1 : len(addrRaw)-1]
-			}
-			if addr, err := netip.ParseAddr(addrRaw); err == nil {
-				validAddrs = append(validAddrs, addr)
-			}
-		} else if addr, err := netip.ParseAddr(addrStr); err == nil {
-			validAddrs = append(validAddrs, addr)
+	var addr netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		var err error
+		addr, err = parseForwardedHop(hops[i])
+		if err != nil {
+			// Do not skip invalid entries: everything left of it may be spoofed
+			return netip.IPv4Unspecified(), fmt.Errorf("invalid address %s in %s header: %s", hops[i], forwardedHeader, value)
+		} else if !util.ContainsIP(trustedPrefixes, addr) {
+			return addr, nil
 		}
 	}
-	// Filter out proxy addresses
-	clientAddrs := util.Filter(validAddrs, func(addr netip.Addr) bool {
-		for _, prefix := range trustedPrefixes {
-			if prefix.Contains(addr) {
-				return false // Address is in the trusted range, ignore it
-			}
+	// All addresses are trusted proxies, so the left-most one is the client
+	return addr, nil
+}
+
+// parseForwardedHop parses a single entry of a forwarded header, which is either an IP address (e.g. 1.2.3.4),
+// an IP address with port (e.g. 1.2.3.4:8080), or an RFC 7239-style element (e.g. for="[2001:db8::1]:8080";by=phil)
+func parseForwardedHop(hop string) (netip.Addr, error) {
+	if m := forwardedHeaderRegex.FindStringSubmatch(hop); len(m) == 2 {
+		hop = strings.TrimSuffix(strings.TrimPrefix(m[1], "["), "]")
+	}
+	addr, err := netip.ParseAddr(hop)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(hop)
+		if err != nil {
+			return netip.Addr{}, err
 		}
-		return true
-	})
-	if len(clientAddrs) == 0 {
-		return netip.IPv4Unspecified(), fmt.Errorf("no client IP address found in %s header: %s", forwardedHeader, value)
+		addr = addrPort.Addr()
 	}
-	return clientAddrs[len(clientAddrs)-1], nil
+	return addr.Unmap(), nil
 }
 
 func readJSONWithLimit[T any](r io.ReadCloser, limit int, allowEmpty bool) (*T, error) {
@@ -196,3 +230,60 @@ func maybeIgnoreSpecialHeader(name, value string) string {
 	}
 	return value
 }
+
+// attachmentResponseLength returns the number of bytes that http.ServeContent will send for the given request,
+// so that only those bytes are counted against the bandwidth limits. It returns 0 if the request is answered with
+// "304 Not Modified" or "416 Range Not Satisfiable", the length of the range for a single range request (e.g. to
+// resume a download), and the full size otherwise. Multi-range requests are counted as the full size.
+func attachmentResponseLength(r *http.Request, etag string, modTime time.Time, size int64) int64 {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return 0
+			}
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.Truncate(time.Second).After(ims) {
+		return 0
+	}
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || strings.Contains(rangeHeader, ",") || !ifRangeMatches(r.Header.Get("If-Range"), etag, modTime) {
+		return size
+	}
+	spec, ok := strings.CutPrefix(strings.TrimSpace(rangeHeader), "bytes=")
+	if !ok {
+		return 0 // Invalid range, answered with 416
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0
+	}
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0
+		}
+		return min(suffix, size)
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0
+	}
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+			return 0
+		}
+		end = min(end, size-1)
+	}
+	return end - start + 1
+}
+
+// ifRangeMatches returns true if the "If-Range" header is empty, or if it matches the ETag or modification time
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	if ifRange == "" || ifRange == etag {
+		return true
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Truncate(time.Second).Equal(t)
+}
@@ -9,6 +9,7 @@ import (
 	"net/netip"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -138,6 +139,56 @@ func TestExtractIPAddress_TrustedIPv6Prefix(t *testing.T) {
 	require.Equal(t, "2001:db8:abcd:2::3", extractIPAddress(r, true, "X-Forwarded-For", trustedProxies).String())
 }
 
+func TestExtractIPAddress_TrustedProxies(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://ntfy.sh/mytopic/json?since=all", nil)
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 5.6.7.8, 10.0.0.2")
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	// Header is only used if the request comes from a trusted proxy
+	r.RemoteAddr = "10.0.0.1:1234"
+	require.Equal(t, "5.6.7.8", extractIPAddress(r, false, "X-Forwarded-For", trustedProxies).String())
+	r.RemoteAddr = "[::ffff:10.0.0.1]:1234"
+	require.Equal(t, "5.6.7.8", extractIPAddress(r, false, "X-Forwarded-For", trustedProxies).String())
+	r.RemoteAddr = "8.8.8.8:1234"
+	require.Equal(t, "8.8.8.8", extractIPAddress(r, false, "X-Forwarded-For", trustedProxies).String())
+	require.Equal(t, "5.6.7.8", extractIPAddress(r, true, "X-Forwarded-For", trustedProxies).String())
+}
+
+func TestExtractIPAddress_SpoofedHeader(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://ntfy.sh/mytopic/json?since=all", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	// Header set by the client, and a second header line added by the proxy
+	r.Header.Add("X-Forwarded-For", "6.6.6.6")
+	r.Header.Add("X-Forwarded-For", "5.6.7.8")
+	require.Equal(t, "5.6.7.8", extractIPAddress(r, false, "X-Forwarded-For", trustedProxies).String())
+
+	// Invalid entries are not skipped, since everything left of them cannot be trusted
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, not-an-ip, 10.0.0.2")
+	require.Equal(t, "10.0.0.1", extractIPAddress(r, false, "X-Forwarded-For", trustedProxies).String())
+
+	// All hops are trusted proxies, so the left-most one is the client
+	r.Header.Set("X-Forwarded-For", "10.1.1.1, 10.0.0.2")
+	require.Equal(t, "10.1.1.1", extractIPAddress(r, false, "X-Forwarded-For", trustedProxies).String())
+
+	// Ports and empty entries
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 5.6.7.8:4711, ")
+	require.Equal(t, "5.6.7.8", extractIPAddress(r, false, "X-Forwarded-For", trustedProxies).String())
+}
+
+func TestExtractIPAddress_ForwardedHeaderMultiple(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://ntfy.sh/mytopic/json?since=all", nil)
+	r.RemoteAddr = "[2001:db8:1111::1]:1234"
+	r.Header.Add("Forwarded", `for=6.6.6.6`)
+	r.Header.Add("Forwarded", `for="[2001:db8:3333::1]:4711";proto=https, for="[2001:db8:1111::2]";by=proxy`)
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix("2001:db8:1111::/64")}
+	require.Equal(t, "2001:db8:3333::1", extractIPAddress(r, false, "Forwarded", trustedProxies).String())
+
+	r.Header.Set("Forwarded", `for=6.6.6.6, for=unknown`)
+	require.Equal(t, "2001:db8:1111::1", extractIPAddress(r, false, "Forwarded", trustedProxies).String())
+}
+
 func TestVisitorID(t *testing.T) {
 	confWithDefaults := &Config{
 		VisitorPrefixBitsIPv4: 32,
@@ -162,3 +213,38 @@ func TestVisitorID(t *testing.T) {
 	require.Equal(t, "ip:1.2.0.0", visitorID(netip.MustParseAddr("1.2.3.4"), nil, confWithShortenedPrefixes))
 	require.Equal(t, "ip:2a01:599:b26:2300::", visitorID(netip.MustParseAddr("2a01:599:b26:2397:dbe7:5aa2:95ce:1e83"), nil, confWithShortenedPrefixes))
 }
+
+func TestAttachmentResponseLength(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	etag := `"abc"`
+	for _, tc := range []struct {
+		headers  map[string]string
+		expected int64
+	}{
+		{map[string]string{}, 5000},
+		{map[string]string{"Range": "bytes=0-9"}, 10},
+		{map[string]string{"Range": "bytes=4000-"}, 1000},
+		{map[string]string{"Range": "bytes=4000-9999"}, 1000},
+		{map[string]string{"Range": "bytes=-100"}, 100},
+		{map[string]string{"Range": "bytes=-10000"}, 5000},
+		{map[string]string{"Range": "bytes=0-9,20-29"}, 5000},
+		{map[string]string{"Range": "bytes=5000-"}, 0},
+		{map[string]string{"Range": "bytes=9-0"}, 0},
+		{map[string]string{"Range": "lines=1-2"}, 0},
+		{map[string]string{"Range": "bytes=10-", "If-Range": `"abc"`}, 4990},
+		{map[string]string{"Range": "bytes=10-", "If-Range": `"xyz"`}, 5000},
+		{map[string]string{"Range": "bytes=10-", "If-Range": modTime.UTC().Format(http.TimeFormat)}, 4990},
+		{map[string]string{"If-None-Match": `"xyz", W/"abc"`}, 0},
+		{map[string]string{"If-None-Match": "*"}, 0},
+		{map[string]string{"If-None-Match": `"xyz"`}, 5000},
+		{map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": modTime.UTC().Format(http.TimeFormat)}, 5000},
+		{map[string]string{"If-Modified-Since": modTime.UTC().Format(http.TimeFormat)}, 0},
+		{map[string]string{"If-Modified-Since": modTime.Add(-time.Hour).UTC().Format(http.TimeFormat)}, 5000},
+	} {
+		r, _ := http.NewRequest("GET", "/file/abc", nil)
+		for k, v := range tc.headers {
+			r.Header.Set(k, v)
+		}
+		require.Equal(t, tc.expected, attachmentResponseLength(r, etag, modTime, 5000), tc.headers)
+	}
+}
@@ -55,16 +55,21 @@ type visitor struct {
 	config              *Config
 	messageCache        *messageCache
 	userManager         *user.Manager      // May be nil
+	redis               *redisClient       // May be nil, if rate limits are not shared via Redis
 	ip                  netip.Addr         // Visitor IP address
+	country             string             // Country of the IP address, if geoip-database is set (e.g. "DE")
 	user                *user.User         // Only set if authenticated user, otherwise nil
 	requestLimiter      *rate.Limiter      // Rate limiter for (almost) all requests (including messages)
-	messagesLimiter     *util.FixedLimiter // Rate limiter for messages
-	emailsLimiter       *util.RateLimiter  // Rate limiter for emails
+	requestLimitFactor  float64            // Factor applied to the request limiter, based on the current rate limit window
+	sharedLimiter       util.Limiter       // Rate limiter for requests shared via Redis, replaces requestLimiter if set
+	messagesLimiter     util.Limiter       // Rate limiter for messages, shared via Redis if configured
+	emailsLimiter       util.Limiter       // Rate limiter for emails, shared via Redis if configured
 	callsLimiter        *util.FixedLimiter // Rate limiter for calls
 	subscriptionLimiter *util.FixedLimiter // Fixed limiter for active subscriptions (ongoing connections)
 	bandwidthLimiter    *util.RateLimiter  // Limiter for attachment bandwidth downloads
 	accountLimiter      *rate.Limiter      // Rate limiter for account creation, may be nil
 	authLimiter         *rate.Limiter      // Limiter for incorrect login attempts, may be nil
+	reportLimiter       *rate.Limiter      // Rate limiter for abuse reports
 	firebase            time.Time          // Next allowed Firebase message
 	seen                time.Time          // Last seen time of this visitor (needed for removal of stale visitors)
 	mu                  sync.RWMutex
@@ -105,6 +110,28 @@ type visitorStats struct {
 	AttachmentTotalSizeRemaining int64
 }
 
+// visitorQuotas describes the current usage of the visitor's limits, and when they are replenished. Unlike
+// visitorInfo, it also covers the limits that are not persisted, e.g. requests and attachment bandwidth.
+type visitorQuotas struct {
+	Basis               visitorLimitBasis
+	Country             string
+	Requests            *visitorQuota
+	Messages            *visitorQuota
+	Emails              *visitorQuota
+	Calls               *visitorQuota
+	Subscriptions       *visitorQuota
+	AttachmentTotalSize *visitorQuota // Only set by Quotas, not by QuotasLight
+	AttachmentBandwidth *visitorQuota
+}
+
+type visitorQuota struct {
+	Limit      int64
+	Used       int64
+	Remaining  int64
+	Reset      time.Time     // Time at which the quota is fully replenished, zero if it is not replenished over time
+	RetryAfter time.Duration // Time until the next unit is available, if the limit has been reached
+}
+
 // visitorLimitBasis describes how the visitor limits were derived, either from a user's
 // IP address (default config), or from its tier
 type visitorLimitBasis string
@@ -114,7 +141,7 @@ const (
 	visitorLimitBasisTier = visitorLimitBasis("tier")
 )
 
-func newVisitor(conf *Config, messageCache *messageCache, userManager *user.Manager, ip netip.Addr, user *user.User) *visitor {
+func newVisitor(conf *Config, messageCache *messageCache, userManager *user.Manager, redis *redisClient, ip netip.Addr, user *user.User) *visitor {
 	var messages, emails, calls int64
 	if user != nil {
 		messages = user.Stats.Messages
@@ -125,6 +152,7 @@ func newVisitor(conf *Config, messageCache *messageCache, userManager *user.Mana
 		config:              conf,
 		messageCache:        messageCache,
 		userManager:         userManager, // May be nil
+		redis:               redis,       // May be nil
 		ip:                  ip,
 		user:                user,
 		firebase:            time.Unix(0, 0),
@@ -137,6 +165,7 @@ func newVisitor(conf *Config, messageCache *messageCache, userManager *user.Mana
 		bandwidthLimiter:    nil, // Set in resetLimiters
 		accountLimiter:      nil, // Set in resetLimiters, may be nil
 		authLimiter:         nil, // Set in resetLimiters, may be nil
+		reportLimiter:       nil, // Set in resetLimiters
 	}
 	v.resetLimitersNoLock(messages, emails, calls, false)
 	return v
@@ -160,6 +189,9 @@ func (v *visitor) contextNoLock() log.Context {
 		"visitor_request_limiter_limit":  v.requestLimiter.Limit(),
 		"visitor_request_limiter_tokens": v.requestLimiter.Tokens(),
 	}
+	if v.country != "" {
+		fields["visitor_country"] = v.country
+	}
 	if v.config.SMTPSenderFrom != "" {
 		fields["visitor_emails"] = info.Stats.Emails
 		fields["visitor_emails_limit"] = info.Limits.EmailLimit
@@ -170,6 +202,9 @@ func (v *visitor) contextNoLock() log.Context {
 		fields["visitor_calls_limit"] = info.Limits.CallLimit
 		fields["visitor_calls_remaining"] = info.Stats.CallsRemaining
 	}
+	if len(v.config.VisitorRequestLimitWindows) > 0 {
+		fields["visitor_request_limiter_factor"] = v.requestLimitFactor
+	}
 	if v.authLimiter != nil {
 		fields["visitor_auth_limiter_limit"] = v.authLimiter.Limit()
 		fields["visitor_auth_limiter_tokens"] = v.authLimiter.Tokens()
@@ -204,11 +239,48 @@ func visitorExtendedInfoContext(info *visitorInfo) log.Context {
 
 }
 func (v *visitor) RequestAllowed() bool {
+	if len(v.config.VisitorRequestLimitWindows) > 0 {
+		v.maybeResetRequestLimiter()
+	}
 	v.mu.RLock() // limiters could be replaced!
 	defer v.mu.RUnlock()
+	if v.sharedLimiter != nil {
+		return v.sharedLimiter.Allow()
+	}
 	return v.requestLimiter.Allow()
 }
 
+// maybeResetRequestLimiter replaces the request limiter if a rate limit window (see visitor-request-limit-windows)
+// started or ended since the limiter was created. The new limiter starts with a full bucket.
+func (v *visitor) maybeResetRequestLimiter() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	factor := requestLimitFactor(v.config.VisitorRequestLimitWindows, time.Now())
+	if factor == v.requestLimitFactor {
+		return
+	}
+	v.resetRequestLimiterNoLock(v.limitsNoLock(), factor)
+	log.Fields(v.contextNoLock()).Debug("Request limiter reset for visitor, rate limit window changed (factor %.2f)", factor)
+}
+
+// resetRequestLimiterNoLock replaces the request limiter. The limits are multiplied by the factor of the current rate
+// limit window, and for visitors without a tier, by the factor of the visitor's country (see geoip-request-limit-factors).
+func (v *visitor) resetRequestLimiterNoLock(limits *visitorLimits, factor float64) {
+	v.requestLimitFactor = factor
+	if limits.Basis == visitorLimitBasisIP {
+		factor *= countryRequestLimitFactor(v.config, v.country)
+	}
+	burst := util.Max(int(float64(limits.RequestLimitBurst)*factor), 1)
+	v.requestLimiter = rate.NewLimiter(limits.RequestLimitReplenish*rate.Limit(factor), burst)
+	if v.redis != nil {
+		// A token bucket cannot be shared efficiently, so the shared limiter counts requests in fixed windows
+		// instead; each window is as long as it takes to refill the bucket, and allows as many requests as the burst.
+		window := max(time.Duration(float64(limits.RequestLimitBurst)/float64(limits.RequestLimitReplenish)*float64(time.Second)), time.Second)
+		fallback := util.NewRateLimiter(limits.RequestLimitReplenish*rate.Limit(factor), burst)
+		v.sharedLimiter = newRedisLimiter(v.redis, v.redisKeyNoLock("requests"), int64(burst), window, fallback)
+	}
+}
+
 func (v *visitor) FirebaseAllowed() bool {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
@@ -239,6 +311,26 @@ func (v *visitor) CallAllowed() bool {
 	return v.callsLimiter.Allow()
 }
 
+// FeatureAllowed returns whether the given feature is allowed by the visitor's tier (see user.TierFeatures).
+// Visitors without a tier are not restricted by feature flags.
+func (v *visitor) FeatureAllowed(feature user.TierFeature) bool {
+	u := v.User()
+	if u == nil || u.Tier == nil {
+		return true
+	}
+	return u.Tier.Features.Allowed(feature)
+}
+
+// FeatureDuration returns the value of the given duration feature of the visitor's tier, or the
+// default value if the visitor has no tier, or the tier does not set the feature
+func (v *visitor) FeatureDuration(feature user.TierFeature, defaultValue time.Duration) time.Duration {
+	u := v.User()
+	if u == nil || u.Tier == nil {
+		return defaultValue
+	}
+	return u.Tier.Features.Duration(feature, defaultValue)
+}
+
 func (v *visitor) SubscriptionAllowed() bool {
 	v.mu.RLock() // limiters could be replaced!
 	defer v.mu.RUnlock()
@@ -283,6 +375,13 @@ func (v *visitor) AccountCreated() {
 	}
 }
 
+// ReportAllowed counts an abuse report against the report limiter, and returns false if the limit is reached
+func (v *visitor) ReportAllowed() bool {
+	v.mu.RLock() // limiters could be replaced!
+	defer v.mu.RUnlock()
+	return v.reportLimiter.Allow()
+}
+
 func (v *visitor) BandwidthAllowed(bytes int64) bool {
 	v.mu.RLock() // limiters could be replaced!
 	defer v.mu.RUnlock()
@@ -345,6 +444,36 @@ func (v *visitor) IP() netip.Addr {
 	return v.ip
 }
 
+// Country returns the country of the visitor's IP address, or an empty string if it is unknown
+func (v *visitor) Country() string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.country
+}
+
+// SetCountry sets the country of the visitor's IP address, and resets the request limiter if the
+// country's request limit factor differs from the current one (see geoip-request-limit-factors)
+func (v *visitor) SetCountry(country string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.country == country {
+		return
+	}
+	shouldResetLimiter := countryRequestLimitFactor(v.config, v.country) != countryRequestLimitFactor(v.config, country)
+	v.country = country
+	if shouldResetLimiter {
+		v.resetRequestLimiterNoLock(v.limitsNoLock(), v.requestLimitFactor)
+	}
+}
+
+// Headroom returns the number of requests the visitor can make before it is rate limited, and the number of
+// messages it can still publish today
+func (v *visitor) Headroom() (requests float64, messages int64) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.requestLimiter.Tokens(), v.infoLightNoLock().Stats.MessagesRemaining
+}
+
 // Authenticated returns true if a user successfully authenticated
 func (v *visitor) Authenticated() bool {
 	v.mu.RLock()
@@ -367,6 +496,22 @@ func (v *visitor) SetUser(u *user.User) {
 	}
 }
 
+// SetConfig replaces the config of the visitor (see Server.Reload), and resets its limiters to the new limits.
+// Like in SetUser, the message, email and call counts are carried over, and so is the number of subscriptions.
+func (v *visitor) SetConfig(conf *Config) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.config = conf
+	v.subscriptionLimiter = util.NewFixedLimiterWithValue(int64(conf.VisitorSubscriptionLimit), v.subscriptionLimiter.Value())
+	v.resetLimitersNoLock(v.messagesLimiter.Value(), v.emailsLimiter.Value(), v.callsLimiter.Value(), false)
+}
+
+// redisKeyNoLock returns the Redis key of the given limiter, e.g. "ip:1.2.3.4:messages". Keys are derived from
+// the visitor ID, so that all servers use the same counters for the same visitor.
+func (v *visitor) redisKeyNoLock(kind string) string {
+	return visitorID(v.ip, v.user, v.config) + ":" + kind
+}
+
 // MaybeUserID returns the user ID of the visitor (if any). If this is an anonymous visitor,
 // an empty string is returned.
 func (v *visitor) MaybeUserID() string {
@@ -380,11 +525,17 @@ func (v *visitor) MaybeUserID() string {
 
 func (v *visitor) resetLimitersNoLock(messages, emails, calls int64, enqueueUpdate bool) {
 	limits := v.limitsNoLock()
-	v.requestLimiter = rate.NewLimiter(limits.RequestLimitReplenish, limits.RequestLimitBurst)
+	v.resetRequestLimiterNoLock(limits, requestLimitFactor(v.config.VisitorRequestLimitWindows, time.Now()))
 	v.messagesLimiter = util.NewFixedLimiterWithValue(limits.MessageLimit, messages)
 	v.emailsLimiter = util.NewRateLimiterWithValue(limits.EmailLimitReplenish, limits.EmailLimitBurst, emails)
+	if v.redis != nil {
+		resetTime := v.config.VisitorStatsResetTime
+		v.messagesLimiter = newDailyRedisLimiter(v.redis, v.redisKeyNoLock("messages"), limits.MessageLimit, resetTime, v.messagesLimiter)
+		v.emailsLimiter = newDailyRedisLimiter(v.redis, v.redisKeyNoLock("emails"), limits.EmailLimit, resetTime, v.emailsLimiter)
+	}
 	v.callsLimiter = util.NewFixedLimiterWithValue(limits.CallLimit, calls)
 	v.bandwidthLimiter = util.NewBytesLimiter(int(limits.AttachmentBandwidthLimit), oneDay)
+	v.reportLimiter = rate.NewLimiter(rate.Every(v.config.VisitorReportLimitReplenish), v.config.VisitorReportLimitBurst)
 	if v.user == nil {
 		v.accountLimiter = rate.NewLimiter(rate.Every(v.config.VisitorAccountCreationLimitReplenish), v.config.VisitorAccountCreationLimitBurst)
 		v.authLimiter = rate.NewLimiter(rate.Every(v.config.VisitorAuthFailureLimitReplenish), v.config.VisitorAuthFailureLimitBurst)
@@ -509,6 +660,55 @@ func (v *visitor) infoLightNoLock() *visitorInfo {
 		Stats:  stats,
 	}
 }
+
+// Quotas returns the current usage of all of the visitor's limits. Like Info, it queries the database
+// for the attachment stats, so QuotasLight should be preferred if they are not needed.
+func (v *visitor) Quotas() (*visitorQuotas, error) {
+	info, err := v.Info()
+	if err != nil {
+		return nil, err
+	}
+	quotas := v.QuotasLight()
+	quotas.AttachmentTotalSize = &visitorQuota{
+		Limit:     info.Limits.AttachmentTotalSizeLimit,
+		Used:      info.Stats.AttachmentTotalSize,
+		Remaining: info.Stats.AttachmentTotalSizeRemaining,
+	}
+	return quotas, nil
+}
+
+// QuotasLight returns the current usage of the visitor's in-memory (or Redis) limiters
+func (v *visitor) QuotasLight() *visitorQuotas {
+	if len(v.config.VisitorRequestLimitWindows) > 0 {
+		v.maybeResetRequestLimiter()
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	now := time.Now()
+	limits := v.limitsNoLock()
+	statsReset := util.NextOccurrenceUTC(v.config.VisitorStatsResetTime, now)
+	quotas := &visitorQuotas{
+		Basis:               limits.Basis,
+		Country:             v.country,
+		Messages:            fixedQuota(limits.MessageLimit, v.messagesLimiter.Value(), statsReset, now),
+		Emails:              fixedQuota(limits.EmailLimit, v.emailsLimiter.Value(), statsReset, now),
+		Calls:               fixedQuota(limits.CallLimit, v.callsLimiter.Value(), statsReset, now),
+		Subscriptions:       fixedQuota(int64(v.config.VisitorSubscriptionLimit), v.subscriptionLimiter.Value(), time.Time{}, now),
+		AttachmentBandwidth: tokenBucketQuota(limits.AttachmentBandwidthLimit, v.bandwidthLimiter.Tokens(), dailyLimitToRate(limits.AttachmentBandwidthLimit), now),
+	}
+	if l, ok := v.sharedLimiter.(*redisLimiter); ok {
+		_, end := l.window(now)
+		quotas.Requests = fixedQuota(l.limit, l.Value(), end, now)
+	} else {
+		quotas.Requests = tokenBucketQuota(int64(v.requestLimiter.Burst()), v.requestLimiter.TokensAt(now), v.requestLimiter.Limit(), now)
+	}
+	if quotas.Emails.Remaining > 0 && v.redis == nil {
+		// The in-memory email limiter is a token bucket, so the limit may be reached before the daily quota is used up
+		quotas.Emails.RetryAfter = durationForTokens(1, limits.EmailLimitReplenish)
+	}
+	return quotas
+}
+
 func zeroIfNegative(value int64) int64 {
 	if value < 0 {
 		return 0
@@ -516,6 +716,17 @@ func zeroIfNegative(value int64) int64 {
 	return value
 }
 
+// requestLimitFactor returns the factor of the first rate limit window that contains t, or 1 if
+// there is none
+func requestLimitFactor(windows []*RateLimitWindow, t time.Time) float64 {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return w.Factor
+		}
+	}
+	return 1
+}
+
 func replenishDurationToDailyLimit(duration time.Duration) int64 {
 	return int64(oneDay / duration)
 }
@@ -524,6 +735,44 @@ func dailyLimitToRate(limit int64) rate.Limit {
 	return rate.Limit(limit) * rate.Every(oneDay)
 }
 
+// fixedQuota describes a limit that is reset at a fixed time (if any), e.g. the daily message limit
+func fixedQuota(limit, used int64, reset, now time.Time) *visitorQuota {
+	q := &visitorQuota{
+		Limit:     limit,
+		Used:      used,
+		Remaining: zeroIfNegative(limit - used),
+		Reset:     reset,
+	}
+	if q.Remaining == 0 && !reset.IsZero() {
+		q.RetryAfter = reset.Sub(now)
+	}
+	return q
+}
+
+// tokenBucketQuota describes a limit that is replenished continuously, e.g. the request limit. The bucket
+// holds up to burst tokens, and is refilled at the given rate (tokens per second).
+func tokenBucketQuota(burst int64, tokens float64, replenish rate.Limit, now time.Time) *visitorQuota {
+	remaining := util.MinMax(int64(tokens), 0, burst)
+	q := &visitorQuota{
+		Limit:     burst,
+		Used:      burst - remaining,
+		Remaining: remaining,
+		Reset:     now.Add(durationForTokens(float64(burst)-tokens, replenish)),
+	}
+	if tokens < 1 {
+		q.RetryAfter = durationForTokens(1-tokens, replenish)
+	}
+	return q
+}
+
+// durationForTokens returns the time it takes to replenish the given number of tokens at the given rate
+func durationForTokens(tokens float64, replenish rate.Limit) time.Duration {
+	if tokens <= 0 || replenish <= 0 || replenish == rate.Inf {
+		return 0
+	}
+	return time.Duration(tokens / float64(replenish) * float64(time.Second))
+}
+
 // visitorID returns a unique identifier for a visitor based on user or IP, using configurable prefix bits for IPv4/IPv6
 func visitorID(ip netip.Addr, u *user.User, conf *Config) string {
 	if u != nil && u.Tier != nil {
@@ -56,6 +56,7 @@ const (
 
 	selectWebPushSubscriptionIDByEndpoint        = `SELECT id FROM subscription WHERE endpoint = ?`
 	selectWebPushSubscriptionCountBySubscriberIP = `SELECT COUNT(*) FROM subscription WHERE subscriber_ip = ?`
+	selectWebPushSubscriptionCountQuery          = `SELECT COUNT(*) FROM subscription`
 	selectWebPushSubscriptionsForTopicQuery      = `
 		SELECT id, endpoint, key_auth, key_p256dh, user_id
 		FROM subscription_topic st
@@ -269,14 +270,30 @@ func (c *webPushStore) RemoveSubscriptionsByUserID(userID string) error {
 	return err
 }
 
-// RemoveExpiredSubscriptions removes all subscriptions that have not been updated for a given time period
-func (c *webPushStore) RemoveExpiredSubscriptions(expireAfter time.Duration) error {
-	_, err := c.db.Exec(deleteWebPushSubscriptionByAgeQuery, time.Now().Add(-expireAfter).Unix())
+// RemoveExpiredSubscriptions removes all subscriptions that have not been updated for a given time period,
+// and returns the number of removed subscriptions
+func (c *webPushStore) RemoveExpiredSubscriptions(expireAfter time.Duration) (int64, error) {
+	res, err := c.db.Exec(deleteWebPushSubscriptionByAgeQuery, time.Now().Add(-expireAfter).Unix())
 	if err != nil {
-		return err
+		return 0, err
 	}
-	_, err = c.db.Exec(deleteWebPushSubscriptionTopicWithoutSubscription)
-	return err
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.db.Exec(deleteWebPushSubscriptionTopicWithoutSubscription); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// SubscriptionsCount returns the number of subscriptions
+func (c *webPushStore) SubscriptionsCount() (int64, error) {
+	var count int64
+	if err := c.db.QueryRow(selectWebPushSubscriptionCountQuery).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
 // Close closes the underlying database connection
@@ -160,7 +160,9 @@ func TestWebPushStore_SubscriptionsExpiring(t *testing.T) {
 	require.Nil(t, err)
 
 	// Should not be cleaned up yet
-	require.Nil(t, webPush.RemoveExpiredSubscriptions(9*24*time.Hour))
+	removed, err := webPush.RemoveExpiredSubscriptions(9 * 24 * time.Hour)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), removed)
 
 	// Run expiration
 	subs, err = webPush.SubscriptionsExpiring(7 * 24 * time.Hour)
@@ -184,7 +186,12 @@ func TestWebPushStore_RemoveExpiredSubscriptions(t *testing.T) {
 	require.Nil(t, err)
 
 	// Run expiration
-	require.Nil(t, webPush.RemoveExpiredSubscriptions(9*24*time.Hour))
+	removed, err := webPush.RemoveExpiredSubscriptions(9 * 24 * time.Hour)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), removed)
+	count, err := webPush.SubscriptionsCount()
+	require.Nil(t, err)
+	require.Equal(t, int64(0), count)
 
 	// List again, should be 0
 	subs, err = webPush.SubscriptionsForTopic("topic1")
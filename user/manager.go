@@ -2,7 +2,9 @@
 package user
 
 import (
+	"crypto/ed25519"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,11 +28,31 @@ const (
 	syncTopicLength                 = 16
 	userIDPrefix                    = "u_"
 	userIDLength                    = 12
+	groupIDPrefix                   = "gr_"
+	groupIDLength                   = 12
 	userAuthIntentionalSlowDownHash = "$2a$10$YFCQvqQDwIIwnJM1xkAYOeih0dg17UVGanaTStnrSzC8NCWxcLDwy" // Cost should match DefaultUserPasswordBcryptCost
 	userHardDeleteAfterDuration     = 7 * 24 * time.Hour
 	tokenPrefix                     = "tk_"
 	tokenLength                     = 32
-	tokenMaxCount                   = 60 // Only keep this many tokens in the table per user
+	tokenMaxCount                   = 60 // Only keep this many tokens in the table per user (not counting batches)
+	tokenBatchIDPrefix              = "tb_"
+	tokenBatchIDLength              = 12
+	webhookIDPrefix                 = "wh_"
+	webhookIDLength                 = 12
+	webhookSecretPrefix             = "whsec_"
+	webhookSecretLength             = 32
+	webhookDeliveriesLimit          = 50 // Only keep this many deliveries in the table per webhook
+	signingKeyIDPrefix              = "sk_"
+	signingKeyIDLength              = 12
+	banIDPrefix                     = "ban_"
+	banIDLength                     = 12
+	refreshTokenPrefix              = "rt_"
+	refreshTokenLength              = 32
+	refreshTokenReuseGracePeriod    = time.Minute // Reusing a rotated refresh token within this period does not revoke the session (e.g. two tabs refreshing at once)
+	sessionIDPrefix                 = "se_"
+	sessionIDLength                 = 12
+	topicKeyLengthMin               = 8
+	topicKeyLengthMax               = 72 // Bcrypt ignores everything after 72 bytes
 	tag                             = "user_manager"
 )
 
@@ -38,6 +60,7 @@ const (
 const (
 	DefaultUserStatsQueueWriterInterval = 33 * time.Second
 	DefaultUserPasswordBcryptCost       = 10
+	DefaultAuthWebhookTimeout           = 5 * time.Second
 )
 
 var (
@@ -64,7 +87,8 @@ const (
 			attachment_expiry_duration INT NOT NULL,
 			attachment_bandwidth_limit INT NOT NULL,
 			stripe_monthly_price_id TEXT,
-			stripe_yearly_price_id TEXT
+			stripe_yearly_price_id TEXT,
+			features JSON NOT NULL DEFAULT '{}'
 		);
 		CREATE UNIQUE INDEX idx_tier_code ON tier (code);
 		CREATE UNIQUE INDEX idx_tier_stripe_monthly_price_id ON tier (stripe_monthly_price_id);
@@ -74,7 +98,7 @@ const (
 			tier_id TEXT,
 			user TEXT NOT NULL,
 			pass TEXT NOT NULL,
-			role TEXT CHECK (role IN ('anonymous', 'admin', 'user')) NOT NULL,
+			role TEXT CHECK (role IN ('anonymous', 'admin', 'support', 'user')) NOT NULL,
 			prefs JSON NOT NULL DEFAULT '{}',
 			sync_topic TEXT NOT NULL,
 			provisioned INT NOT NULL,
@@ -87,6 +111,7 @@ const (
 			stripe_subscription_interval TEXT,
 			stripe_subscription_paid_until INT,
 			stripe_subscription_cancel_at INT,
+			stripe_payment_grace_until INT,
 			created INT NOT NULL,
 			deleted INT,
 		    FOREIGN KEY (tier_id) REFERENCES tier (id)
@@ -101,6 +126,9 @@ const (
 			write INT NOT NULL,
 			owner_user_id INT,
 			provisioned INT NOT NULL,
+			expires INT NOT NULL DEFAULT (0),
+			hours TEXT NOT NULL DEFAULT (''),
+			timezone TEXT NOT NULL DEFAULT (''),
 			PRIMARY KEY (user_id, topic),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE,
 		    FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
@@ -111,18 +139,191 @@ const (
 			label TEXT NOT NULL,
 			last_access INT NOT NULL,
 			last_origin TEXT NOT NULL,
+			last_user_agent TEXT NOT NULL DEFAULT (''),
+			last_country TEXT NOT NULL DEFAULT (''),
+			last_asn INT NOT NULL DEFAULT (0),
 			expires INT NOT NULL,
 			provisioned INT NOT NULL,
+			scopes TEXT NOT NULL DEFAULT (''),
+			batch TEXT NOT NULL DEFAULT (''),
 			PRIMARY KEY (user_id, token),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
 		CREATE UNIQUE INDEX idx_user_token ON user_token (token);
+		CREATE INDEX idx_user_token_batch ON user_token (batch);
 		CREATE TABLE IF NOT EXISTS user_phone (
 			user_id TEXT NOT NULL,
 			phone_number TEXT NOT NULL,
 			PRIMARY KEY (user_id, phone_number),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
+		CREATE TABLE IF NOT EXISTS user_email_template (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_topic_template (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			title TEXT NOT NULL,
+			message TEXT NOT NULL,
+			tags TEXT NOT NULL,
+			priority TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_topic_policy (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT,
+			cache_duration INT NOT NULL,
+			message_limit INT NOT NULL,
+			attachment_file_size_limit INT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_topic_webhook (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			owner_user_id TEXT NOT NULL,
+			url TEXT NOT NULL,
+			format TEXT NOT NULL DEFAULT ('ntfy'),
+			secret TEXT NOT NULL,
+			created INT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_webhook_topic ON user_topic_webhook (topic);
+		CREATE TABLE IF NOT EXISTS user_topic_webhook_delivery (
+			webhook_id TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			time INT NOT NULL,
+			attempts INT NOT NULL,
+			status_code INT NOT NULL,
+			error TEXT NOT NULL,
+			FOREIGN KEY (webhook_id) REFERENCES user_topic_webhook (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_webhook_delivery_webhook_id ON user_topic_webhook_delivery (webhook_id, time);
+		CREATE TABLE IF NOT EXISTS user_topic_telegram (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			bot_token TEXT NOT NULL,
+			chat_id TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_topic_signing_key (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			owner_user_id TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			created INT NOT NULL,
+			UNIQUE (topic, public_key),
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_signing_key_topic ON user_topic_signing_key (topic);
+		CREATE TABLE IF NOT EXISTS user_topic_ingest (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_topic_key (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_ephemeral_topic (
+			topic TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			read_token TEXT NOT NULL,
+			write_token TEXT NOT NULL,
+			expires INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_ephemeral_topic_user_id ON user_ephemeral_topic (user_id);
+		CREATE TABLE IF NOT EXISTS user_webauthn (
+			user_id TEXT NOT NULL,
+			credential_id TEXT NOT NULL,
+			public_key BLOB NOT NULL,
+			sign_count INT NOT NULL,
+			name TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (credential_id),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_webauthn_user_id ON user_webauthn (user_id);
+		CREATE TABLE IF NOT EXISTS stats_rollup (
+			period TEXT NOT NULL,
+			start INT NOT NULL,
+			messages INT NOT NULL,
+			emails INT NOT NULL,
+			calls INT NOT NULL,
+			visitors INT NOT NULL,
+			users INT NOT NULL,
+			PRIMARY KEY (period, start)
+		);
+		CREATE TABLE IF NOT EXISTS user_email (
+			user_id TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			fallback_email TEXT,
+			fallback_until INT,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email ON user_email (email);
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS stats_usage (
+			day INT NOT NULL,
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			messages INT NOT NULL,
+			subscriptions INT NOT NULL,
+			attachments INT NOT NULL,
+			attachment_bytes INT NOT NULL,
+			PRIMARY KEY (day, user_id, topic)
+		);
+		CREATE INDEX IF NOT EXISTS idx_stats_usage_user_id ON stats_usage (user_id, day);
+		CREATE TABLE IF NOT EXISTS user_ban (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			value TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			created INT NOT NULL,
+			expires INT NOT NULL,
+			UNIQUE (kind, value)
+		);
+		CREATE TABLE IF NOT EXISTS user_refresh_token (
+			token TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			session TEXT NOT NULL,
+			access_token TEXT NOT NULL,
+			expires INT NOT NULL,
+			used INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_refresh_token_session ON user_refresh_token (session);
+		CREATE INDEX IF NOT EXISTS idx_user_refresh_token_access_token ON user_refresh_token (access_token);
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
 			version INT NOT NULL
@@ -138,36 +339,47 @@ const (
 	`
 
 	selectUserByIDQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.stripe_payment_grace_until, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id, t.features
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.id = ?
 	`
 	selectUserByNameQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.stripe_payment_grace_until, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id, t.features
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE user = ?
 	`
 	selectUserByTokenQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.stripe_payment_grace_until, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id, t.features
 		FROM user u
 		JOIN user_token tk on u.id = tk.user_id
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE tk.token = ? AND (tk.expires = 0 OR tk.expires >= ?)
 	`
 	selectUserByStripeCustomerIDQuery = `
-		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id
+		SELECT u.id, u.user, u.pass, u.role, u.prefs, u.sync_topic, u.provisioned, u.stats_messages, u.stats_emails, u.stats_calls, u.stripe_customer_id, u.stripe_subscription_id, u.stripe_subscription_status, u.stripe_subscription_interval, u.stripe_subscription_paid_until, u.stripe_subscription_cancel_at, u.stripe_payment_grace_until, deleted, t.id, t.code, t.name, t.messages_limit, t.messages_expiry_duration, t.emails_limit, t.calls_limit, t.reservations_limit, t.attachment_file_size_limit, t.attachment_total_size_limit, t.attachment_expiry_duration, t.attachment_bandwidth_limit, t.stripe_monthly_price_id, t.stripe_yearly_price_id, t.features
 		FROM user u
 		LEFT JOIN tier t on t.id = u.tier_id
 		WHERE u.stripe_customer_id = ?
 	`
 	selectTopicPermsQuery = `
-		SELECT read, write
-		FROM user_access a
-		JOIN user u ON u.id = a.user_id
-		WHERE (u.user = ? OR u.user = ?) AND ? LIKE a.topic ESCAPE '\'
-		ORDER BY u.user DESC, LENGTH(a.topic) DESC, a.write DESC
+		SELECT username, group_name, topic, read, write, provisioned, expires, hours, timezone, owner
+		FROM (
+			SELECT u.user AS username, '' AS group_name, a.topic, a.read, a.write, a.provisioned, a.expires, a.hours, a.timezone, IFNULL(o.user, '') AS owner, IIF(u.user = '*', 3, 1) AS precedence
+			FROM user_access a
+			JOIN user u ON u.id = a.user_id
+			LEFT JOIN user o ON o.id = a.owner_user_id
+			WHERE (u.user = ? OR u.user = ?) AND ? LIKE a.topic ESCAPE '\' AND (a.expires = 0 OR a.expires > ?)
+			UNION ALL
+			SELECT u.user, g.name, ga.topic, ga.read, ga.write, 0, 0, '', '', '', 2
+			FROM user_group_access ga
+			JOIN user_group g ON g.id = ga.group_id
+			JOIN user_group_member m ON m.group_id = ga.group_id
+			JOIN user u ON u.id = m.user_id
+			WHERE u.user = ? AND ? LIKE ga.topic ESCAPE '\'
+		)
+		ORDER BY precedence, LENGTH(topic) DESC, write DESC
 	`
 
 	insertUserQuery = `
@@ -180,35 +392,55 @@ const (
 		ORDER BY
 			CASE role
 				WHEN 'admin' THEN 1
-				WHEN 'anonymous' THEN 3
-				ELSE 2
+				WHEN 'support' THEN 2
+				WHEN 'anonymous' THEN 4
+				ELSE 3
 			END, user
 	`
-	selectUserCountQuery          = `SELECT COUNT(*) FROM user`
-	selectUserIDFromUsernameQuery = `SELECT id FROM user WHERE user = ?`
-	updateUserPassQuery           = `UPDATE user SET pass = ? WHERE user = ?`
-	updateUserRoleQuery           = `UPDATE user SET role = ? WHERE user = ?`
-	updateUserProvisionedQuery    = `UPDATE user SET provisioned = ? WHERE user = ?`
-	updateUserPrefsQuery          = `UPDATE user SET prefs = ? WHERE id = ?`
-	updateUserStatsQuery          = `UPDATE user SET stats_messages = ?, stats_emails = ?, stats_calls = ? WHERE id = ?`
-	updateUserStatsResetAllQuery  = `UPDATE user SET stats_messages = 0, stats_emails = 0, stats_calls = 0`
-	updateUserDeletedQuery        = `UPDATE user SET deleted = ? WHERE id = ?`
-	deleteUsersMarkedQuery        = `DELETE FROM user WHERE deleted < ?`
-	deleteUserQuery               = `DELETE FROM user WHERE user = ?`
+	selectUserCountQuery           = `SELECT COUNT(*) FROM user`
+	selectUserIDFromUsernameQuery  = `SELECT id FROM user WHERE user = ?`
+	selectUnprovisionedUsersQuery  = `SELECT user FROM user WHERE provisioned = 0 AND user != '*' AND deleted IS NULL ORDER BY user`
+	selectUnprovisionedAccessQuery = `
+		SELECT u.user, a.topic, a.read, a.write
+		FROM user_access a
+		JOIN user u ON u.id = a.user_id
+		WHERE a.provisioned = 0
+		  AND a.owner_user_id IS NULL
+		  AND (u.provisioned = 1 OR u.user = '*')
+		ORDER BY u.user, a.topic
+	`
+	selectUnprovisionedTokensQuery = `
+		SELECT u.user, t.token, t.label
+		FROM user_token t
+		JOIN user u ON u.id = t.user_id
+		WHERE t.provisioned = 0
+		  AND t.expires = 0
+		  AND u.provisioned = 1
+		ORDER BY u.user, t.label
+	`
+	updateUserPassQuery          = `UPDATE user SET pass = ? WHERE user = ?`
+	updateUserRoleQuery          = `UPDATE user SET role = ? WHERE user = ?`
+	updateUserProvisionedQuery   = `UPDATE user SET provisioned = ? WHERE user = ?`
+	updateUserPrefsQuery         = `UPDATE user SET prefs = ? WHERE id = ?`
+	updateUserStatsQuery         = `UPDATE user SET stats_messages = ?, stats_emails = ?, stats_calls = ? WHERE id = ?`
+	updateUserStatsResetAllQuery = `UPDATE user SET stats_messages = 0, stats_emails = 0, stats_calls = 0`
+	updateUserDeletedQuery       = `UPDATE user SET deleted = ? WHERE id = ?`
+	deleteUsersMarkedQuery       = `DELETE FROM user WHERE deleted < ?`
+	deleteUserQuery              = `DELETE FROM user WHERE user = ?`
 
 	upsertUserAccessQuery = `
-		INSERT INTO user_access (user_id, topic, read, write, owner_user_id, provisioned)
-		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, (SELECT IIF(?='',NULL,(SELECT id FROM user WHERE user=?))), ?)
+		INSERT INTO user_access (user_id, topic, read, write, owner_user_id, provisioned, expires, hours, timezone)
+		VALUES ((SELECT id FROM user WHERE user = ?), ?, ?, ?, (SELECT IIF(?='',NULL,(SELECT id FROM user WHERE user=?))), ?, ?, ?, ?)
 		ON CONFLICT (user_id, topic)
-		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id, provisioned=excluded.provisioned
+		DO UPDATE SET read=excluded.read, write=excluded.write, owner_user_id=excluded.owner_user_id, provisioned=excluded.provisioned, expires=excluded.expires, hours=excluded.hours, timezone=excluded.timezone
 	`
 	selectUserAllAccessQuery = `
-		SELECT user_id, topic, read, write, provisioned
+		SELECT user_id, topic, read, write, provisioned, expires, hours, timezone
 		FROM user_access
 		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
 	`
 	selectUserAccessQuery = `
-		SELECT topic, read, write, provisioned
+		SELECT topic, read, write, provisioned, expires, hours, timezone
 		FROM user_access
 		WHERE user_id = (SELECT id FROM user WHERE user = ?)
 		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
@@ -241,10 +473,16 @@ const (
 		  AND topic = ?
 	`
 	selectOtherAccessCountQuery = `
-		SELECT COUNT(*)
-		FROM user_access
-		WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
-		  AND (owner_user_id IS NULL OR owner_user_id != (SELECT id FROM user WHERE user = ?))
+		SELECT (
+			SELECT COUNT(*)
+			FROM user_access
+			WHERE (topic = ? OR ? LIKE topic ESCAPE '\')
+			  AND (owner_user_id IS NULL OR owner_user_id != (SELECT id FROM user WHERE user = ?))
+		) + (
+			SELECT COUNT(*)
+			FROM user_group_access
+			WHERE topic = ? OR ? LIKE topic ESCAPE '\'
+		)
 	`
 	deleteAllAccessQuery  = `DELETE FROM user_access`
 	deleteUserAccessQuery = `
@@ -252,66 +490,360 @@ const (
 		WHERE user_id = (SELECT id FROM user WHERE user = ?)
 		   OR owner_user_id = (SELECT id FROM user WHERE user = ?)
 	`
-	deleteUserAccessProvisionedQuery = `DELETE FROM user_access WHERE provisioned = 1`
-	deleteTopicAccessQuery           = `
+	deleteUserAccessProvisionedQuery   = `DELETE FROM user_access WHERE provisioned = 1`
+	deleteUserAccessUnprovisionedQuery = `
+		DELETE FROM user_access
+		WHERE provisioned = 0
+		  AND owner_user_id IS NULL
+		  AND user_id IN (SELECT id FROM user WHERE provisioned = 1 OR user = '*')
+	`
+	deleteUserAccessExpiredQuery = `DELETE FROM user_access WHERE expires > 0 AND expires <= ?`
+	deleteTopicAccessQuery       = `
 		DELETE FROM user_access
 	   	WHERE (user_id = (SELECT id FROM user WHERE user = ?) OR owner_user_id = (SELECT id FROM user WHERE user = ?))
 	   	  AND topic = ?
   	`
 
-	selectTokenCountQuery           = `SELECT COUNT(*) FROM user_token WHERE user_id = ?`
-	selectTokensQuery               = `SELECT token, label, last_access, last_origin, expires, provisioned FROM user_token WHERE user_id = ?`
-	selectTokenQuery                = `SELECT token, label, last_access, last_origin, expires, provisioned FROM user_token WHERE user_id = ? AND token = ?`
-	selectAllProvisionedTokensQuery = `SELECT token, label, last_access, last_origin, expires, provisioned FROM user_token WHERE provisioned = 1`
+	insertGroupQuery        = `INSERT INTO user_group (id, name, created) VALUES (?, ?, ?)`
+	selectGroupsQuery       = `SELECT id, name FROM user_group ORDER BY name`
+	selectGroupByNameQuery  = `SELECT id, name FROM user_group WHERE name = ?`
+	selectGroupMembersQuery = `
+		SELECT u.user
+		FROM user_group_member m
+		JOIN user u ON u.id = m.user_id
+		WHERE m.group_id = ?
+		ORDER BY u.user
+	`
+	selectUserGroupsQuery = `
+		SELECT g.name
+		FROM user_group g
+		JOIN user_group_member m ON m.group_id = g.id
+		WHERE m.user_id = (SELECT id FROM user WHERE user = ?)
+		ORDER BY g.name
+	`
+	deleteGroupQuery       = `DELETE FROM user_group WHERE name = ?`
+	upsertGroupMemberQuery = `
+		INSERT INTO user_group_member (group_id, user_id)
+		VALUES ((SELECT id FROM user_group WHERE name = ?), (SELECT id FROM user WHERE user = ?))
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`
+	deleteGroupMemberQuery = `
+		DELETE FROM user_group_member
+		WHERE group_id = (SELECT id FROM user_group WHERE name = ?)
+		  AND user_id = (SELECT id FROM user WHERE user = ?)
+	`
+	upsertGroupAccessQuery = `
+		INSERT INTO user_group_access (group_id, topic, read, write)
+		VALUES ((SELECT id FROM user_group WHERE name = ?), ?, ?, ?)
+		ON CONFLICT (group_id, topic)
+		DO UPDATE SET read=excluded.read, write=excluded.write
+	`
+	selectGroupAccessQuery = `
+		SELECT topic, read, write
+		FROM user_group_access
+		WHERE group_id = (SELECT id FROM user_group WHERE name = ?)
+		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
+	`
+	deleteGroupAccessQuery      = `DELETE FROM user_group_access WHERE group_id = (SELECT id FROM user_group WHERE name = ?)`
+	deleteGroupTopicAccessQuery = `DELETE FROM user_group_access WHERE group_id = (SELECT id FROM user_group WHERE name = ?) AND topic = ?`
+
+	upsertBanQuery = `
+		INSERT INTO user_ban (id, kind, value, reason, created, expires)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, value)
+		DO UPDATE SET reason = excluded.reason, expires = excluded.expires
+	`
+	selectBanQuery         = `SELECT id, kind, value, reason, created, expires FROM user_ban WHERE kind = ? AND value = ?`
+	selectBansQuery        = `SELECT id, kind, value, reason, created, expires FROM user_ban WHERE expires = 0 OR expires > ? ORDER BY created, rowid`
+	deleteBanQuery         = `DELETE FROM user_ban WHERE id = ?`
+	deleteBansExpiredQuery = `DELETE FROM user_ban WHERE expires > 0 AND expires <= ?`
+
+	insertRefreshTokenQuery            = `INSERT INTO user_refresh_token (token, user_id, session, access_token, expires, used) VALUES (?, ?, ?, ?, ?, 0)`
+	selectRefreshTokenQuery            = `SELECT user_id, session, access_token, expires, used FROM user_refresh_token WHERE token = ?`
+	selectSessionAccessTokenCountQuery = `SELECT COUNT(*) FROM user_refresh_token WHERE user_id = ? AND access_token = ?`
+	updateRefreshTokenUsedQuery        = `UPDATE user_refresh_token SET used = ? WHERE token = ?`
+	deleteSessionAccessTokensQuery     = `DELETE FROM user_token WHERE token IN (SELECT access_token FROM user_refresh_token WHERE session = ?)`
+	deleteSessionQuery                 = `DELETE FROM user_refresh_token WHERE session = ?`
+	deleteSessionByAccessTokenQuery    = `DELETE FROM user_refresh_token WHERE session IN (SELECT session FROM user_refresh_token WHERE user_id = ? AND access_token = ?)`
+	deleteAllRefreshTokensQuery        = `DELETE FROM user_refresh_token WHERE user_id = ?`
+	deleteExpiredRefreshTokensQuery    = `DELETE FROM user_refresh_token WHERE expires < ?`
+
+	selectTokenCountQuery           = `SELECT COUNT(*) FROM user_token WHERE user_id = ? AND batch = ''`
+	selectTokensQuery               = `SELECT token, label, last_access, last_origin, last_user_agent, last_country, last_asn, expires, provisioned, scopes, batch FROM user_token WHERE user_id = ?`
+	selectTokenQuery                = `SELECT token, label, last_access, last_origin, last_user_agent, last_country, last_asn, expires, provisioned, scopes, batch FROM user_token WHERE user_id = ? AND token = ?`
+	selectAllProvisionedTokensQuery = `SELECT token, label, last_access, last_origin, last_user_agent, last_country, last_asn, expires, provisioned, scopes, batch FROM user_token WHERE provisioned = 1`
+	selectTokenScopesQuery          = `SELECT scopes FROM user_token WHERE token = ?`
+	selectTokenOwnerQuery           = `SELECT user_id FROM user_token WHERE token = ?`
+	selectTokenLastAccessQuery      = `SELECT last_access, last_origin, last_user_agent, last_country, last_asn FROM user_token WHERE token = ?`
 	upsertTokenQuery                = `
-		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, provisioned)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, provisioned, scopes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT (user_id, token)
-		DO UPDATE SET label = excluded.label, expires = excluded.expires, provisioned = excluded.provisioned;
+		DO UPDATE SET label = excluded.label, expires = excluded.expires, provisioned = excluded.provisioned, scopes = excluded.scopes;
+	`
+	insertBatchTokenQuery = `
+		INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, provisioned, scopes, batch)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)
 	`
 	updateTokenExpiryQuery      = `UPDATE user_token SET expires = ? WHERE user_id = ? AND token = ?`
 	updateTokenLabelQuery       = `UPDATE user_token SET label = ? WHERE user_id = ? AND token = ?`
-	updateTokenLastAccessQuery  = `UPDATE user_token SET last_access = ?, last_origin = ? WHERE token = ?`
+	updateTokenLastAccessQuery  = `UPDATE user_token SET last_access = ?, last_origin = ?, last_user_agent = ?, last_country = ?, last_asn = ? WHERE token = ?`
 	deleteTokenQuery            = `DELETE FROM user_token WHERE user_id = ? AND token = ?`
 	deleteProvisionedTokenQuery = `DELETE FROM user_token WHERE token = ?`
 	deleteAllTokenQuery         = `DELETE FROM user_token WHERE user_id = ?`
-	deleteExpiredTokensQuery    = `DELETE FROM user_token WHERE expires > 0 AND expires < ?`
-	deleteExcessTokensQuery     = `
+	deleteBatchTokensQuery      = `DELETE FROM user_token WHERE user_id = ? AND batch = ?`
+	deleteNonProvisionedTokens  = `DELETE FROM user_token WHERE user_id = ? AND provisioned = 0`
+	deleteUnprovisionedTokens   = `
+		DELETE FROM user_token
+		WHERE provisioned = 0
+		  AND expires = 0
+		  AND user_id IN (SELECT id FROM user WHERE provisioned = 1)
+	`
+	deleteExpiredTokensQuery = `DELETE FROM user_token WHERE expires > 0 AND expires < ?`
+	deleteExcessTokensQuery  = `
 		DELETE FROM user_token
 		WHERE user_id = ?
+		  AND batch = ''
 		  AND (user_id, token) NOT IN (
 			SELECT user_id, token
 			FROM user_token
-			WHERE user_id = ?
+			WHERE user_id = ? AND batch = ''
 			ORDER BY expires DESC
 			LIMIT ?
 		)
 	`
 
-	selectPhoneNumbersQuery = `SELECT phone_number FROM user_phone WHERE user_id = ?`
-	insertPhoneNumberQuery  = `INSERT INTO user_phone (user_id, phone_number) VALUES (?, ?)`
-	deletePhoneNumberQuery  = `DELETE FROM user_phone WHERE user_id = ? AND phone_number = ?`
+	selectEmailTemplateQuery = `SELECT topic, subject, body FROM user_email_template WHERE topic = ?`
+	upsertEmailTemplateQuery = `
+		INSERT INTO user_email_template (topic, owner_user_id, subject, body)
+		VALUES (?, (SELECT id FROM user WHERE user = ?), ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET owner_user_id = excluded.owner_user_id, subject = excluded.subject, body = excluded.body
+	`
+	deleteEmailTemplateQuery = `DELETE FROM user_email_template WHERE topic = ?`
+
+	selectTopicTemplateQuery = `SELECT topic, title, message, tags, priority FROM user_topic_template WHERE topic = ?`
+	upsertTopicTemplateQuery = `
+		INSERT INTO user_topic_template (topic, owner_user_id, title, message, tags, priority)
+		VALUES (?, (SELECT id FROM user WHERE user = ?), ?, ?, ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET owner_user_id = excluded.owner_user_id, title = excluded.title, message = excluded.message, tags = excluded.tags, priority = excluded.priority
+	`
+	deleteTopicTemplateQuery = `DELETE FROM user_topic_template WHERE topic = ?`
+
+	selectTopicTelegramQuery = `SELECT topic, bot_token, chat_id FROM user_topic_telegram WHERE topic = ?`
+	upsertTopicTelegramQuery = `
+		INSERT INTO user_topic_telegram (topic, owner_user_id, bot_token, chat_id)
+		VALUES (?, (SELECT id FROM user WHERE user = ?), ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET owner_user_id = excluded.owner_user_id, bot_token = excluded.bot_token, chat_id = excluded.chat_id
+	`
+	deleteTopicTelegramQuery = `DELETE FROM user_topic_telegram WHERE topic = ?`
+
+	selectTopicIngestQuery = `SELECT topic, source, secret FROM user_topic_ingest WHERE topic = ?`
+	upsertTopicIngestQuery = `
+		INSERT INTO user_topic_ingest (topic, owner_user_id, source, secret)
+		VALUES (?, (SELECT id FROM user WHERE user = ?), ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET owner_user_id = excluded.owner_user_id, source = excluded.source, secret = excluded.secret
+	`
+	deleteTopicIngestQuery = `DELETE FROM user_topic_ingest WHERE topic = ?`
+
+	selectTopicKeyQuery = `SELECT topic, hash, read, write FROM user_topic_key WHERE topic = ?`
+	upsertTopicKeyQuery = `
+		INSERT INTO user_topic_key (topic, owner_user_id, hash, read, write)
+		VALUES (?, (SELECT id FROM user WHERE user = ?), ?, ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET owner_user_id = excluded.owner_user_id, hash = excluded.hash, read = excluded.read, write = excluded.write
+	`
+	deleteTopicKeyQuery = `DELETE FROM user_topic_key WHERE topic = ?`
+
+	insertEphemeralTopicQuery = `
+		INSERT INTO user_ephemeral_topic (topic, user_id, read_token, write_token, expires)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	selectEphemeralTopicsCountQuery   = `SELECT COUNT(*) FROM user_ephemeral_topic WHERE user_id = ? AND expires > ?`
+	selectEphemeralTopicsExpiredQuery = `SELECT topic FROM user_ephemeral_topic WHERE expires <= ?`
+	deleteEphemeralTopicTokensQuery   = `
+		DELETE FROM user_token
+		WHERE token IN (
+			SELECT read_token FROM user_ephemeral_topic WHERE topic = ?
+			UNION
+			SELECT write_token FROM user_ephemeral_topic WHERE topic = ?
+		)
+	`
+	deleteEphemeralTopicAccessQuery = `DELETE FROM user_access WHERE topic = ?`
+	deleteEphemeralTopicQuery       = `DELETE FROM user_ephemeral_topic WHERE topic = ?`
+
+	selectTopicPolicyQuery = `
+		SELECT topic, IFNULL(owner_user_id, ''), cache_duration, message_limit, attachment_file_size_limit
+		FROM user_topic_policy
+		WHERE topic = ?
+	`
+	selectTopicPoliciesQuery = `
+		SELECT topic, IFNULL(owner_user_id, ''), cache_duration, message_limit, attachment_file_size_limit
+		FROM user_topic_policy
+		ORDER BY topic
+	`
+	upsertTopicPolicyQuery = `
+		INSERT INTO user_topic_policy (topic, owner_user_id, cache_duration, message_limit, attachment_file_size_limit)
+		VALUES (?, (SELECT id FROM user WHERE user = ?), ?, ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET owner_user_id = excluded.owner_user_id, cache_duration = excluded.cache_duration, message_limit = excluded.message_limit, attachment_file_size_limit = excluded.attachment_file_size_limit
+	`
+	deleteTopicPolicyQuery      = `DELETE FROM user_topic_policy WHERE topic = ?`
+	deleteOwnedTopicPolicyQuery = `DELETE FROM user_topic_policy WHERE topic = ? AND owner_user_id IS NOT NULL`
+
+	selectTopicWebhooksQuery = `
+		SELECT id, topic, url, format, secret, created
+		FROM user_topic_webhook
+		WHERE topic = ?
+		ORDER BY created, rowid
+	`
+	selectTopicWebhookCountQuery = `SELECT COUNT(*) FROM user_topic_webhook WHERE topic = ?`
+	insertTopicWebhookQuery      = `
+		INSERT INTO user_topic_webhook (id, topic, owner_user_id, url, format, secret, created)
+		VALUES (?, ?, (SELECT id FROM user WHERE user = ?), ?, ?, ?, ?)
+	`
+	deleteTopicWebhookQuery  = `DELETE FROM user_topic_webhook WHERE topic = ? AND id = ?`
+	deleteTopicWebhooksQuery = `DELETE FROM user_topic_webhook WHERE topic = ?`
+
+	selectTopicSigningKeysQuery = `
+		SELECT id, topic, public_key, created
+		FROM user_topic_signing_key
+		WHERE topic = ?
+		ORDER BY created, rowid
+	`
+	selectTopicSigningKeyCountQuery = `SELECT COUNT(*) FROM user_topic_signing_key WHERE topic = ?`
+	insertTopicSigningKeyQuery      = `
+		INSERT INTO user_topic_signing_key (id, topic, owner_user_id, public_key, created)
+		VALUES (?, ?, (SELECT id FROM user WHERE user = ?), ?, ?)
+	`
+	deleteTopicSigningKeyQuery  = `DELETE FROM user_topic_signing_key WHERE topic = ? AND id = ?`
+	deleteTopicSigningKeysQuery = `DELETE FROM user_topic_signing_key WHERE topic = ?`
+
+	selectWebhookDeliveriesQuery = `
+		SELECT webhook_id, message_id, time, attempts, status_code, error
+		FROM user_topic_webhook_delivery
+		WHERE webhook_id = ?
+		ORDER BY time DESC, rowid DESC
+	`
+	insertWebhookDeliveryQuery = `
+		INSERT INTO user_topic_webhook_delivery (webhook_id, message_id, time, attempts, status_code, error)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	deleteOldWebhookDeliveriesQuery = `
+		DELETE FROM user_topic_webhook_delivery
+		WHERE webhook_id = ? AND rowid NOT IN (
+			SELECT rowid FROM user_topic_webhook_delivery WHERE webhook_id = ? ORDER BY time DESC, rowid DESC LIMIT ?
+		)
+	`
+
+	selectPhoneNumbersQuery    = `SELECT phone_number FROM user_phone WHERE user_id = ?`
+	selectAllPhoneNumbersQuery = `SELECT user_id, phone_number FROM user_phone`
+	insertPhoneNumberQuery     = `INSERT INTO user_phone (user_id, phone_number) VALUES (?, ?)`
+	deletePhoneNumberQuery     = `DELETE FROM user_phone WHERE user_id = ? AND phone_number = ?`
+
+	selectEmailQuery = `SELECT email, fallback_email, fallback_until FROM user_email WHERE user_id = ?`
+	upsertEmailQuery = `
+		INSERT INTO user_email (user_id, email, fallback_email, fallback_until)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id)
+		DO UPDATE SET email = excluded.email, fallback_email = excluded.fallback_email, fallback_until = excluded.fallback_until
+	`
+	deleteEmailQuery                = `DELETE FROM user_email WHERE user_id = ?`
+	updateEmailFallbackExpiredQuery = `UPDATE user_email SET fallback_email = NULL, fallback_until = NULL WHERE fallback_until <= ?`
+
+	selectWebAuthnCredentialsQuery    = `SELECT credential_id, public_key, sign_count, name, created FROM user_webauthn WHERE user_id = ? ORDER BY created`
+	insertWebAuthnCredentialQuery     = `INSERT INTO user_webauthn (user_id, credential_id, public_key, sign_count, name, created) VALUES (?, ?, ?, ?, ?, ?)`
+	updateWebAuthnCredentialSignCount = `UPDATE user_webauthn SET sign_count = ? WHERE user_id = ? AND credential_id = ?`
+	deleteWebAuthnCredentialQuery     = `DELETE FROM user_webauthn WHERE user_id = ? AND credential_id = ?`
+
+	upsertStatsRollupHourQuery = `
+		INSERT INTO stats_rollup (period, start, messages, emails, calls, visitors, users)
+		VALUES ('hour', ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (period, start) DO UPDATE SET
+			messages = messages + excluded.messages,
+			emails = emails + excluded.emails,
+			calls = calls + excluded.calls,
+			visitors = MAX(visitors, excluded.visitors),
+			users = MAX(users, excluded.users)
+	`
+	upsertStatsRollupDaysQuery = `
+		INSERT INTO stats_rollup (period, start, messages, emails, calls, visitors, users)
+		SELECT 'day', start - (start % 86400), SUM(messages), SUM(emails), SUM(calls), MAX(visitors), MAX(users)
+		FROM stats_rollup
+		WHERE period = 'hour' AND start >= ?
+		GROUP BY start - (start % 86400)
+		ON CONFLICT (period, start) DO UPDATE SET
+			messages = excluded.messages,
+			emails = excluded.emails,
+			calls = excluded.calls,
+			visitors = excluded.visitors,
+			users = excluded.users
+	`
+	selectStatsRollupsQuery = `
+		SELECT start, messages, emails, calls, visitors, users
+		FROM stats_rollup
+		WHERE period = ? AND start >= ?
+		ORDER BY start
+	`
+	deleteStatsRollupsQuery = `DELETE FROM stats_rollup WHERE period = ? AND start < ?`
+
+	upsertStatsUsageQuery = `
+		INSERT INTO stats_usage (day, user_id, topic, messages, subscriptions, attachments, attachment_bytes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (day, user_id, topic) DO UPDATE SET
+			messages = messages + excluded.messages,
+			subscriptions = subscriptions + excluded.subscriptions,
+			attachments = attachments + excluded.attachments,
+			attachment_bytes = attachment_bytes + excluded.attachment_bytes
+	`
+	selectStatsUsageByUserQuery = `
+		SELECT day, topic, messages, subscriptions, attachments, attachment_bytes
+		FROM stats_usage
+		WHERE user_id = ? AND day >= ?
+		ORDER BY day, topic
+	`
+	selectStatsUsageTopUsersQuery = `
+		SELECT s.user_id, COALESCE(u.user, ''), SUM(s.messages), SUM(s.subscriptions), SUM(s.attachments), SUM(s.attachment_bytes)
+		FROM stats_usage s
+		LEFT JOIN user u ON u.id = s.user_id
+		WHERE s.day >= ?
+		GROUP BY s.user_id
+		ORDER BY %s DESC, s.user_id
+		LIMIT ?
+	`
+	selectStatsUsageTopTopicsQuery = `
+		SELECT s.topic, SUM(s.messages), SUM(s.subscriptions), SUM(s.attachments), SUM(s.attachment_bytes)
+		FROM stats_usage s
+		WHERE s.day >= ?
+		GROUP BY s.topic
+		ORDER BY %s DESC, s.topic
+		LIMIT ?
+	`
+	deleteStatsUsageQuery = `DELETE FROM stats_usage WHERE day < ?`
 
 	insertTierQuery = `
-		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, features)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	updateTierQuery = `
 		UPDATE tier
-		SET name = ?, messages_limit = ?, messages_expiry_duration = ?, emails_limit = ?, calls_limit = ?, reservations_limit = ?, attachment_file_size_limit = ?, attachment_total_size_limit = ?, attachment_expiry_duration = ?, attachment_bandwidth_limit = ?, stripe_monthly_price_id = ?, stripe_yearly_price_id = ?
+		SET name = ?, messages_limit = ?, messages_expiry_duration = ?, emails_limit = ?, calls_limit = ?, reservations_limit = ?, attachment_file_size_limit = ?, attachment_total_size_limit = ?, attachment_expiry_duration = ?, attachment_bandwidth_limit = ?, stripe_monthly_price_id = ?, stripe_yearly_price_id = ?, features = ?
 		WHERE code = ?
 	`
 	selectTiersQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, features
 		FROM tier
 	`
 	selectTierByCodeQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, features
 		FROM tier
 		WHERE code = ?
 	`
 	selectTierByPriceIDQuery = `
-		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id
+		SELECT id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id, features
 		FROM tier
 		WHERE (stripe_monthly_price_id = ? OR stripe_yearly_price_id = ?)
 	`
@@ -324,11 +856,13 @@ const (
 		SET stripe_customer_id = ?, stripe_subscription_id = ?, stripe_subscription_status = ?, stripe_subscription_interval = ?, stripe_subscription_paid_until = ?, stripe_subscription_cancel_at = ?
 		WHERE user = ?
 	`
+	updateBillingPaymentGraceQuery              = `UPDATE user SET stripe_payment_grace_until = ? WHERE user = ?`
+	selectUsernamesWithExpiredPaymentGraceQuery = `SELECT user FROM user WHERE stripe_payment_grace_until IS NOT NULL AND stripe_payment_grace_until <= ?`
 )
 
 // Schema management queries
 const (
-	currentSchemaVersion     = 6
+	currentSchemaVersion     = 30
 	insertSchemaVersion      = `INSERT INTO schemaVersion VALUES (1, ?)`
 	updateSchemaVersion      = `UPDATE schemaVersion SET version = ? WHERE id = 1`
 	selectSchemaVersionQuery = `SELECT version FROM schemaVersion WHERE id = 1`
@@ -537,106 +1071,460 @@ const (
 		-- Re-enable foreign keys
 		PRAGMA foreign_keys=on;
 	`
-)
 
-var (
-	migrations = map[int]func(db *sql.DB) error{
-		1: migrateFrom1,
-		2: migrateFrom2,
-		3: migrateFrom3,
-		4: migrateFrom4,
-		5: migrateFrom5,
-	}
-)
+	// 6 -> 7
+	migrate6To7UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_email_template (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
 
-// Manager is an implementation of Manager. It stores users and access control list
-// in a SQLite database.
-type Manager struct {
-	config     *Config
-	db         *sql.DB
-	statsQueue map[string]*Stats       // "Queue" to asynchronously write user stats to the database (UserID -> Stats)
-	tokenQueue map[string]*TokenUpdate // "Queue" to asynchronously write token access stats to the database (Token ID -> TokenUpdate)
-	mu         sync.Mutex
-}
+	// 7 -> 8
+	migrate7To8UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_webauthn (
+			user_id TEXT NOT NULL,
+			credential_id TEXT NOT NULL,
+			public_key BLOB NOT NULL,
+			sign_count INT NOT NULL,
+			name TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (credential_id),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_webauthn_user_id ON user_webauthn (user_id);
+	`
 
-// Config holds the configuration for the user Manager
-type Config struct {
-	Filename            string              // Database filename, e.g. "/var/lib/ntfy/user.db"
-	StartupQueries      string              // Queries to run on startup, e.g. to create initial users or tiers
-	DefaultAccess       Permission          // Default permission if no ACL matches
-	ProvisionEnabled    bool                // Hack: Enable auto-provisioning of users and access grants, disabled for "ntfy user" commands
-	Users               []*User             // Predefined users to create on startup
-	Access              map[string][]*Grant // Predefined access grants to create on startup (username -> []*Grant)
-	Tokens              map[string][]*Token // Predefined users to create on startup (username -> []*Token)
-	QueueWriterInterval time.Duration       // Interval for the async queue writer to flush stats and token updates to the database
-	BcryptCost          int                 // Cost of generated passwords; lowering makes testing faster
-}
+	// 8 -> 9
+	migrate8To9UpdateQueries = `
+		PRAGMA foreign_keys=off;
 
-var _ Auther = (*Manager)(nil)
+		-- Alter user table: Add 'support' role to check constraint
+		CREATE TABLE user_new (
+		    id TEXT PRIMARY KEY,
+			tier_id TEXT,
+			user TEXT NOT NULL,
+			pass TEXT NOT NULL,
+			role TEXT CHECK (role IN ('anonymous', 'admin', 'support', 'user')) NOT NULL,
+			prefs JSON NOT NULL DEFAULT '{}',
+			sync_topic TEXT NOT NULL,
+			provisioned INT NOT NULL,
+			stats_messages INT NOT NULL DEFAULT (0),
+			stats_emails INT NOT NULL DEFAULT (0),
+			stats_calls INT NOT NULL DEFAULT (0),
+			stripe_customer_id TEXT,
+			stripe_subscription_id TEXT,
+			stripe_subscription_status TEXT,
+			stripe_subscription_interval TEXT,
+			stripe_subscription_paid_until INT,
+			stripe_subscription_cancel_at INT,
+			created INT NOT NULL,
+			deleted INT,
+		    FOREIGN KEY (tier_id) REFERENCES tier (id)
+		);
+		INSERT INTO user_new SELECT * FROM user;
+		DROP TABLE user;
+		ALTER TABLE user_new RENAME TO user;
 
-// NewManager creates a new Manager instance
-func NewManager(config *Config) (*Manager, error) {
-	// Set defaults
-	if config.BcryptCost <= 0 {
-		config.BcryptCost = DefaultUserPasswordBcryptCost
-	}
-	if config.QueueWriterInterval.Seconds() <= 0 {
-		config.QueueWriterInterval = DefaultUserStatsQueueWriterInterval
-	}
-	// Check the parent directory of the database file (makes for friendly error messages)
-	parentDir := filepath.Dir(config.Filename)
-	if !util.FileExists(parentDir) {
-		return nil, fmt.Errorf("user database directory %s does not exist or is not accessible", parentDir)
-	}
-	// Open DB and run setup queries
-	db, err := sql.Open("sqlite3", config.Filename)
-	if err != nil {
-		return nil, err
-	}
-	if err := setupDB(db); err != nil {
-		return nil, err
-	}
-	if err := runStartupQueries(db, config.StartupQueries); err != nil {
-		return nil, err
-	}
-	manager := &Manager{
-		db:         db,
-		config:     config,
-		statsQueue: make(map[string]*Stats),
-		tokenQueue: make(map[string]*TokenUpdate),
-	}
-	if err := manager.maybeProvisionUsersAccessAndTokens(); err != nil {
-		return nil, err
-	}
-	go manager.asyncQueueWriter(config.QueueWriterInterval)
-	return manager, nil
-}
+		-- Recreate indices
+		CREATE UNIQUE INDEX idx_user ON user (user);
+		CREATE UNIQUE INDEX idx_user_stripe_customer_id ON user (stripe_customer_id);
+		CREATE UNIQUE INDEX idx_user_stripe_subscription_id ON user (stripe_subscription_id);
 
-// Authenticate checks username and password and returns a User if correct, and the user has not been
-// marked as deleted. The method returns in constant-ish time, regardless of whether the user exists or
-// the password is correct or incorrect.
-func (a *Manager) Authenticate(username, password string) (*User, error) {
-	if username == Everyone {
-		return nil, ErrUnauthenticated
-	}
-	user, err := a.User(username)
-	if err != nil {
-		log.Tag(tag).Field("user_name", username).Err(err).Trace("Authentication of user failed (1)")
-		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
-		return nil, ErrUnauthenticated
-	} else if user.Deleted {
-		log.Tag(tag).Field("user_name", username).Trace("Authentication of user failed (2): user marked deleted")
-		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
-		return nil, ErrUnauthenticated
-	} else if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
-		log.Tag(tag).Field("user_name", username).Err(err).Trace("Authentication of user failed (3)")
-		return nil, ErrUnauthenticated
-	}
-	return user, nil
-}
+		-- Re-enable foreign keys
+		PRAGMA foreign_keys=on;
+	`
+
+	// 9 -> 10
+	migrate9To10UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS stats_rollup (
+			period TEXT NOT NULL,
+			start INT NOT NULL,
+			messages INT NOT NULL,
+			emails INT NOT NULL,
+			calls INT NOT NULL,
+			visitors INT NOT NULL,
+			users INT NOT NULL,
+			PRIMARY KEY (period, start)
+		);
+	`
+
+	// 10 -> 11
+	migrate10To11UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_email (
+			user_id TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			fallback_email TEXT,
+			fallback_until INT,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_user_email ON user_email (email);
+	`
+
+	// 11 -> 12
+	migrate11To12UpdateQueries = `
+		ALTER TABLE user ADD COLUMN stripe_payment_grace_until INT;
+	`
+
+	// 12 -> 13
+	migrate12To13UpdateQueries = `
+		ALTER TABLE tier ADD COLUMN features JSON NOT NULL DEFAULT '{}';
+	`
+
+	// 13 -> 14
+	migrate13To14UpdateQueries = `
+		ALTER TABLE user_access ADD COLUMN expires INT NOT NULL DEFAULT (0);
+		ALTER TABLE user_access ADD COLUMN hours TEXT NOT NULL DEFAULT ('');
+		ALTER TABLE user_access ADD COLUMN timezone TEXT NOT NULL DEFAULT ('');
+	`
+
+	// 14 -> 15
+	migrate14To15UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_template (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			title TEXT NOT NULL,
+			message TEXT NOT NULL,
+			tags TEXT NOT NULL,
+			priority TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 15 -> 16
+	migrate15To16UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_policy (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT,
+			cache_duration INT NOT NULL,
+			message_limit INT NOT NULL,
+			attachment_file_size_limit INT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 16 -> 17
+	migrate16To17UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_webhook (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			owner_user_id TEXT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created INT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_webhook_topic ON user_topic_webhook (topic);
+		CREATE TABLE IF NOT EXISTS user_topic_webhook_delivery (
+			webhook_id TEXT NOT NULL,
+			message_id TEXT NOT NULL,
+			time INT NOT NULL,
+			attempts INT NOT NULL,
+			status_code INT NOT NULL,
+			error TEXT NOT NULL,
+			FOREIGN KEY (webhook_id) REFERENCES user_topic_webhook (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_webhook_delivery_webhook_id ON user_topic_webhook_delivery (webhook_id, time);
+	`
+
+	// 17 -> 18
+	migrate17To18UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_telegram (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			bot_token TEXT NOT NULL,
+			chat_id TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 18 -> 19
+	migrate18To19UpdateQueries = `
+		ALTER TABLE user_topic_webhook ADD COLUMN format TEXT NOT NULL DEFAULT ('ntfy');
+	`
+
+	// 19 -> 20
+	migrate19To20UpdateQueries = `
+		ALTER TABLE user_token ADD COLUMN scopes TEXT NOT NULL DEFAULT ('');
+	`
+
+	// 20 -> 21
+	migrate20To21UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE
+		);
+	`
+
+	// 21 -> 22
+	migrate21To22UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS stats_usage (
+			day INT NOT NULL,
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			messages INT NOT NULL,
+			subscriptions INT NOT NULL,
+			attachments INT NOT NULL,
+			attachment_bytes INT NOT NULL,
+			PRIMARY KEY (day, user_id, topic)
+		);
+		CREATE INDEX IF NOT EXISTS idx_stats_usage_user_id ON stats_usage (user_id, day);
+	`
+
+	// 22 -> 23
+	migrate22To23UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_signing_key (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			owner_user_id TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			created INT NOT NULL,
+			UNIQUE (topic, public_key),
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_signing_key_topic ON user_topic_signing_key (topic);
+	`
+
+	// 23 -> 24
+	migrate23To24UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_ingest (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 24 -> 25
+	migrate24To25UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_key (
+			topic TEXT PRIMARY KEY,
+			owner_user_id TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			FOREIGN KEY (owner_user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 25 -> 26
+	migrate25To26UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_ephemeral_topic (
+			topic TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			read_token TEXT NOT NULL,
+			write_token TEXT NOT NULL,
+			expires INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_ephemeral_topic_user_id ON user_ephemeral_topic (user_id);
+	`
+
+	// 26 -> 27
+	migrate26To27UpdateQueries = `
+		ALTER TABLE user_token ADD COLUMN batch TEXT NOT NULL DEFAULT ('');
+		CREATE INDEX IF NOT EXISTS idx_user_token_batch ON user_token (batch);
+	`
+
+	// 27 -> 28
+	migrate27To28UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_ban (
+			id TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			value TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			created INT NOT NULL,
+			expires INT NOT NULL,
+			UNIQUE (kind, value)
+		);
+	`
+
+	// 28 -> 29
+	migrate28To29UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_refresh_token (
+			token TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			session TEXT NOT NULL,
+			access_token TEXT NOT NULL,
+			expires INT NOT NULL,
+			used INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_refresh_token_session ON user_refresh_token (session);
+		CREATE INDEX IF NOT EXISTS idx_user_refresh_token_access_token ON user_refresh_token (access_token);
+	`
+
+	// 29 -> 30
+	migrate29To30UpdateQueries = `
+		ALTER TABLE user_token ADD COLUMN last_user_agent TEXT NOT NULL DEFAULT ('');
+		ALTER TABLE user_token ADD COLUMN last_country TEXT NOT NULL DEFAULT ('');
+		ALTER TABLE user_token ADD COLUMN last_asn INT NOT NULL DEFAULT (0);
+	`
+)
+
+var (
+	// usageSortColumns maps the sort orders of TopUsageStats to SQL expressions, to avoid SQL injection
+	usageSortColumns = map[UsageSort]string{
+		UsageSortMessages:        "SUM(s.messages)",
+		UsageSortSubscriptions:   "SUM(s.subscriptions)",
+		UsageSortAttachments:     "SUM(s.attachments)",
+		UsageSortAttachmentBytes: "SUM(s.attachment_bytes)",
+	}
+
+	migrations = map[int]func(db *sql.DB) error{
+		1:  migrateFrom1,
+		2:  migrateFrom2,
+		3:  migrateFrom3,
+		4:  migrateFrom4,
+		5:  migrateFrom5,
+		6:  migrateFrom6,
+		7:  migrateFrom7,
+		8:  migrateFrom8,
+		9:  migrateFrom9,
+		10: migrateFrom10,
+		11: migrateFrom11,
+		12: migrateFrom12,
+		13: migrateFrom13,
+		14: migrateFrom14,
+		15: migrateFrom15,
+		16: migrateFrom16,
+		17: migrateFrom17,
+		18: migrateFrom18,
+		19: migrateFrom19,
+		20: migrateFrom20,
+		21: migrateFrom21,
+		22: migrateFrom22,
+		23: migrateFrom23,
+		24: migrateFrom24,
+		25: migrateFrom25,
+		26: migrateFrom26,
+		27: migrateFrom27,
+		28: migrateFrom28,
+		29: migrateFrom29,
+	}
+)
+
+// Manager is an implementation of Manager. It stores users and access control list
+// in a SQLite database.
+type Manager struct {
+	config     *Config
+	db         *sql.DB
+	statsQueue map[string]*Stats       // "Queue" to asynchronously write user stats to the database (UserID -> Stats)
+	tokenQueue map[string]*TokenUpdate // "Queue" to asynchronously write token access stats to the database (Token ID -> TokenUpdate)
+	webhook    *authWebhook            // External authorization webhook, may be nil
+	mu         sync.Mutex
+}
+
+// Config holds the configuration for the user Manager
+type Config struct {
+	Filename            string              // Database filename, e.g. "/var/lib/ntfy/user.db"
+	StartupQueries      string              // Queries to run on startup, e.g. to create initial users or tiers
+	DefaultAccess       Permission          // Default permission if no ACL matches
+	ProvisionEnabled    bool                // Hack: Enable auto-provisioning of users and access grants, disabled for "ntfy user" commands
+	Users               []*User             // Predefined users to create on startup
+	Access              map[string][]*Grant // Predefined access grants to create on startup (username -> []*Grant)
+	Tokens              map[string][]*Token // Predefined users to create on startup (username -> []*Token)
+	ProvisionPrune      bool                // Remove users, access entries and tokens that are not in the config on startup (see ProvisionDrift)
+	QueueWriterInterval time.Duration       // Interval for the async queue writer to flush stats and token updates to the database
+	BcryptCost          int                 // Cost of generated passwords; lowering makes testing faster
+	AuthWebhookURL      string              // External HTTP endpoint that decides about topic access, see authWebhook
+	AuthWebhookTimeout  time.Duration       // Timeout for requests to the auth webhook, after which access is denied
+	AuthWebhookCacheTTL time.Duration       // Duration for which auth webhook decisions are cached, 0 disables caching
+}
+
+var _ Auther = (*Manager)(nil)
+
+// NewManager creates a new Manager instance
+func NewManager(config *Config) (*Manager, error) {
+	// Set defaults
+	if config.BcryptCost <= 0 {
+		config.BcryptCost = DefaultUserPasswordBcryptCost
+	}
+	if config.QueueWriterInterval.Seconds() <= 0 {
+		config.QueueWriterInterval = DefaultUserStatsQueueWriterInterval
+	}
+	if config.AuthWebhookTimeout <= 0 {
+		config.AuthWebhookTimeout = DefaultAuthWebhookTimeout
+	}
+	// Check the parent directory of the database file (makes for friendly error messages)
+	parentDir := filepath.Dir(config.Filename)
+	if !util.FileExists(parentDir) {
+		return nil, fmt.Errorf("user database directory %s does not exist or is not accessible", parentDir)
+	}
+	// Open DB and run setup queries
+	db, err := sql.Open("sqlite3", config.Filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupDB(db); err != nil {
+		return nil, err
+	}
+	if err := runStartupQueries(db, config.StartupQueries); err != nil {
+		return nil, err
+	}
+	manager := &Manager{
+		db:         db,
+		config:     config,
+		statsQueue: make(map[string]*Stats),
+		tokenQueue: make(map[string]*TokenUpdate),
+	}
+	if config.AuthWebhookURL != "" {
+		manager.webhook = newAuthWebhook(config.AuthWebhookURL, config.AuthWebhookTimeout, config.AuthWebhookCacheTTL)
+	}
+	if err := manager.maybeProvisionUsersAccessAndTokens(); err != nil {
+		return nil, err
+	}
+	go manager.asyncQueueWriter(config.QueueWriterInterval)
+	return manager, nil
+}
+
+// Authenticate checks username and password and returns a User if correct, and the user has not been
+// marked as deleted. The method returns in constant-ish time, regardless of whether the user exists or
+// the password is correct or incorrect.
+func (a *Manager) Authenticate(username, password string) (*User, error) {
+	if username == Everyone {
+		return nil, ErrUnauthenticated
+	}
+	user, err := a.User(username)
+	if err != nil {
+		log.Tag(tag).Field("user_name", username).Err(err).Trace("Authentication of user failed (1)")
+		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
+		return nil, ErrUnauthenticated
+	} else if user.Deleted {
+		log.Tag(tag).Field("user_name", username).Trace("Authentication of user failed (2): user marked deleted")
+		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
+		return nil, ErrUnauthenticated
+	} else if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
+		log.Tag(tag).Field("user_name", username).Err(err).Trace("Authentication of user failed (3)")
+		return nil, ErrUnauthenticated
+	}
+	return user, nil
+}
 
 // AuthenticateToken checks if the token exists and returns the associated User if it does.
-// The method sets the User.Token value to the token that was used for authentication.
+// The method sets the User.Token value to the token that was used for authentication, and
+// User.TokenScopes to the scopes of the token.
 func (a *Manager) AuthenticateToken(token string) (*User, error) {
 	if len(token) != tokenLength {
 		return nil, ErrUnauthenticated
@@ -646,7 +1534,13 @@ func (a *Manager) AuthenticateToken(token string) (*User, error) {
 		log.Tag(tag).Field("token", token).Err(err).Trace("Authentication of token failed")
 		return nil, ErrUnauthenticated
 	}
+	scopes, err := a.tokenScopes(token)
+	if err != nil {
+		log.Tag(tag).Field("token", token).Err(err).Trace("Authentication of token failed")
+		return nil, ErrUnauthenticated
+	}
 	user.Token = token
+	user.TokenScopes = scopes
 	return user, nil
 }
 
@@ -654,14 +1548,60 @@ func (a *Manager) AuthenticateToken(token string) (*User, error) {
 // after a fixed duration unless ChangeToken is called. This function also prunes tokens for the
 // given user, if there are too many of them.
 func (a *Manager) CreateToken(userID, label string, expires time.Time, origin netip.Addr, provisioned bool) (*Token, error) {
+	return a.CreateScopedToken(userID, label, expires, origin, provisioned, nil)
+}
+
+// CreateScopedToken is like CreateToken, but restricts what the token can be used for, see TokenScope
+func (a *Manager) CreateScopedToken(userID, label string, expires time.Time, origin netip.Addr, provisioned bool, scopes TokenScopes) (*Token, error) {
 	return queryTx(a.db, func(tx *sql.Tx) (*Token, error) {
-		return a.createTokenTx(tx, userID, GenerateToken(), label, expires, origin, provisioned)
+		return a.createTokenTx(tx, userID, GenerateToken(), label, expires, origin, provisioned, scopes)
+	})
+}
+
+// CreateTokenBatch generates one token per label for the given user in a single transaction (e.g. for a fleet
+// of devices), and returns them. All tokens share a random batch ID (Token.Batch), so that they can be revoked
+// together with RemoveTokenBatch. Unlike other tokens, batch tokens do not count towards the max number of tokens
+// per user, so they are never pruned to make room for new tokens.
+func (a *Manager) CreateTokenBatch(userID string, labels []string, expires time.Time, origin netip.Addr, scopes TokenScopes) ([]*Token, error) {
+	batch := util.RandomStringPrefix(tokenBatchIDPrefix, tokenBatchIDLength)
+	access := time.Now()
+	return queryTx(a.db, func(tx *sql.Tx) ([]*Token, error) {
+		tokens := make([]*Token, 0, len(labels))
+		for _, label := range labels {
+			token := GenerateToken()
+			if _, err := tx.Exec(insertBatchTokenQuery, userID, token, label, access.Unix(), origin.String(), expires.Unix(), scopes.String(), batch); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, &Token{
+				Value:      token,
+				Label:      label,
+				LastAccess: access,
+				LastOrigin: origin,
+				Expires:    expires,
+				Scopes:     scopes,
+				Batch:      batch,
+			})
+		}
+		return tokens, nil
 	})
 }
 
-func (a *Manager) createTokenTx(tx *sql.Tx, userID, token, label string, expires time.Time, origin netip.Addr, provisioned bool) (*Token, error) {
+// RemoveTokenBatch deletes all tokens of the given user that were created in the given batch (see
+// CreateTokenBatch), and returns the number of deleted tokens
+func (a *Manager) RemoveTokenBatch(userID, batch string) (int64, error) {
+	if batch == "" {
+		return 0, ErrTokenNotFound
+	}
+	res, err := a.db.Exec(deleteBatchTokensQuery, userID, batch)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (a *Manager) createTokenTx(tx *sql.Tx, userID, token, label string, expires time.Time, origin netip.Addr, provisioned bool, scopes TokenScopes) (*Token, error) {
 	access := time.Now()
-	if _, err := tx.Exec(upsertTokenQuery, userID, token, label, access.Unix(), origin.String(), expires.Unix(), provisioned); err != nil {
+	if _, err := tx.Exec(upsertTokenQuery, userID, token, label, access.Unix(), origin.String(), expires.Unix(), provisioned, scopes.String()); err != nil {
 		return nil, err
 	}
 	rows, err := tx.Query(selectTokenCountQuery, userID)
@@ -690,6 +1630,7 @@ func (a *Manager) createTokenTx(tx *sql.Tx, userID, token, label string, expires
 		LastOrigin:  origin,
 		Expires:     expires,
 		Provisioned: provisioned,
+		Scopes:      scopes,
 	}, nil
 }
 
@@ -742,14 +1683,51 @@ func (a *Manager) Token(userID, token string) (*Token, error) {
 	return a.readToken(rows)
 }
 
+// InspectToken returns the given token, and the user it belongs to, e.g. to investigate a leaked token. Unlike
+// AuthenticateToken, it also returns tokens that have expired but have not been removed yet. Token updates that have
+// not been written to the database yet (see EnqueueTokenUpdate) are taken into account, so that the last access
+// time and origin are up-to-date.
+func (a *Manager) InspectToken(token string) (*User, *Token, error) {
+	var userID string
+	if err := a.db.QueryRow(selectTokenOwnerQuery, token).Scan(&userID); errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrTokenNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	u, err := a.UserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := a.Token(userID, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if update, ok := a.tokenQueue[token]; ok {
+		t.LastAccess, t.LastOrigin = update.LastAccess, update.LastOrigin
+		t.LastUserAgent, t.LastCountry, t.LastASN = update.LastUserAgent, update.LastCountry, update.LastASN
+	}
+	return u, t, nil
+}
+
+func (a *Manager) tokenScopes(token string) (TokenScopes, error) {
+	var scopes string
+	if err := a.db.QueryRow(selectTokenScopesQuery, token).Scan(&scopes); err != nil {
+		return nil, err
+	}
+	return ParseTokenScopes([]string{scopes})
+}
+
 func (a *Manager) readToken(rows *sql.Rows) (*Token, error) {
-	var token, label, lastOrigin string
+	var token, label, lastOrigin, lastUserAgent, lastCountry, scopes, batch string
 	var lastAccess, expires int64
+	var lastASN uint32
 	var provisioned bool
 	if !rows.Next() {
 		return nil, ErrTokenNotFound
 	}
-	if err := rows.Scan(&token, &label, &lastAccess, &lastOrigin, &expires, &provisioned); err != nil {
+	if err := rows.Scan(&token, &label, &lastAccess, &lastOrigin, &lastUserAgent, &lastCountry, &lastASN, &expires, &provisioned, &scopes, &batch); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
@@ -758,13 +1736,22 @@ func (a *Manager) readToken(rows *sql.Rows) (*Token, error) {
 	if err != nil {
 		lastOriginIP = netip.IPv4Unspecified()
 	}
+	tokenScopes, err := ParseTokenScopes([]string{scopes})
+	if err != nil {
+		return nil, err
+	}
 	return &Token{
-		Value:       token,
-		Label:       label,
-		LastAccess:  time.Unix(lastAccess, 0),
-		LastOrigin:  lastOriginIP,
-		Expires:     time.Unix(expires, 0),
-		Provisioned: provisioned,
+		Value:         token,
+		Label:         label,
+		LastAccess:    time.Unix(lastAccess, 0),
+		LastOrigin:    lastOriginIP,
+		LastUserAgent: lastUserAgent,
+		LastCountry:   lastCountry,
+		LastASN:       lastASN,
+		Expires:       time.Unix(expires, 0),
+		Provisioned:   provisioned,
+		Scopes:        tokenScopes,
+		Batch:         batch,
 	}, nil
 }
 
@@ -775,6 +1762,12 @@ func (a *Manager) ChangeToken(userID, token string, label *string, expires *time
 	}
 	if err := a.CanChangeToken(userID, token); err != nil {
 		return nil, err
+	} else if expires != nil {
+		if isSession, err := a.isSessionToken(userID, token); err != nil {
+			return nil, err
+		} else if isSession {
+			return nil, ErrSessionTokenChange // Session tokens are short-lived on purpose, see RefreshSession
+		}
 	}
 	tx, err := a.db.Begin()
 	if err != nil {
@@ -814,9 +1807,27 @@ func (a *Manager) removeTokenTx(tx *sql.Tx, userID, token string) error {
 	if _, err := tx.Exec(deleteTokenQuery, userID, token); err != nil {
 		return err
 	}
+	if _, err := tx.Exec(deleteSessionByAccessTokenQuery, userID, token); err != nil {
+		return err // Deleting the access token of a session ends the session
+	}
 	return nil
 }
 
+// RemoveAllTokens deletes all tokens of the user with the given user ID, except for provisioned tokens,
+// and returns the number of deleted tokens
+func (a *Manager) RemoveAllTokens(userID string) (int64, error) {
+	return queryTx(a.db, func(tx *sql.Tx) (int64, error) {
+		if _, err := tx.Exec(deleteAllRefreshTokensQuery, userID); err != nil {
+			return 0, err
+		}
+		res, err := tx.Exec(deleteNonProvisionedTokens, userID)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+}
+
 // CanChangeToken checks if the token can be changed. If the token is provisioned, it cannot be changed.
 func (a *Manager) CanChangeToken(userID, token string) error {
 	t, err := a.Token(userID, token)
@@ -828,25 +1839,132 @@ func (a *Manager) CanChangeToken(userID, token string) error {
 	return nil
 }
 
-// RemoveExpiredTokens deletes all expired tokens from the database
+// RemoveExpiredTokens deletes all expired tokens and refresh tokens from the database
 func (a *Manager) RemoveExpiredTokens() error {
-	if _, err := a.db.Exec(deleteExpiredTokensQuery, time.Now().Unix()); err != nil {
+	now := time.Now().Unix()
+	if _, err := a.db.Exec(deleteExpiredTokensQuery, now); err != nil {
+		return err
+	}
+	if _, err := a.db.Exec(deleteExpiredRefreshTokensQuery, now); err != nil {
 		return err
 	}
 	return nil
 }
 
-// PhoneNumbers returns all phone numbers for the user with the given user ID
-func (a *Manager) PhoneNumbers(userID string) ([]string, error) {
-	rows, err := a.db.Query(selectPhoneNumbersQuery, userID)
+// CreateSession starts a new login session (e.g. for the web app) for the given user. It creates an access token
+// that expires at accessExpires, and a refresh token that expires at refreshExpires. Before the access token expires,
+// the refresh token can be exchanged for a new pair of tokens with RefreshSession.
+func (a *Manager) CreateSession(userID string, accessExpires, refreshExpires time.Time, origin netip.Addr) (*Session, error) {
+	sessionID := util.RandomStringPrefix(sessionIDPrefix, sessionIDLength)
+	return queryTx(a.db, func(tx *sql.Tx) (*Session, error) {
+		return a.createSessionTx(tx, userID, sessionID, accessExpires, refreshExpires, origin)
+	})
+}
+
+// SessionUserID returns the ID of the user that the session of the given refresh token belongs to, without
+// using the refresh token. It returns ErrRefreshTokenInvalid if the refresh token does not exist.
+func (a *Manager) SessionUserID(refreshToken string) (string, error) {
+	var userID, sessionID, accessToken string
+	var expires, used int64
+	if err := a.db.QueryRow(selectRefreshTokenQuery, refreshToken).Scan(&userID, &sessionID, &accessToken, &expires, &used); errors.Is(err, sql.ErrNoRows) {
+		return "", ErrRefreshTokenInvalid
+	} else if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new refresh token. The previous access
+// token is deleted, and the refresh token cannot be used again (rotation). If a refresh token is used a second time,
+// it has likely been stolen, so the entire session is revoked and ErrRefreshTokenReused is returned. Reusing a
+// refresh token shortly after it was rotated (e.g. by two browser tabs at once) only returns ErrRefreshTokenInvalid.
+func (a *Manager) RefreshSession(refreshToken string, accessExpires, refreshExpires time.Time, origin netip.Addr) (*Session, error) {
+	if !strings.HasPrefix(refreshToken, refreshTokenPrefix) || len(refreshToken) != refreshTokenLength {
+		return nil, ErrRefreshTokenInvalid
+	}
+	tx, err := a.db.Begin()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	phoneNumbers := make([]string, 0)
-	for {
-		phoneNumber, err := a.readPhoneNumber(rows)
-		if errors.Is(err, ErrPhoneNumberNotFound) {
+	defer tx.Rollback()
+	var userID, sessionID, accessToken string
+	var expires, used int64
+	if err := tx.QueryRow(selectRefreshTokenQuery, refreshToken).Scan(&userID, &sessionID, &accessToken, &expires, &used); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenInvalid
+	} else if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if expires < now.Unix() {
+		return nil, ErrRefreshTokenInvalid
+	} else if used > 0 {
+		if now.Sub(time.Unix(used, 0)) < refreshTokenReuseGracePeriod {
+			return nil, ErrRefreshTokenInvalid
+		}
+		if _, err := tx.Exec(deleteSessionAccessTokensQuery, sessionID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(deleteSessionQuery, sessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if _, err := tx.Exec(updateRefreshTokenUsedQuery, now.Unix(), refreshToken); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(deleteTokenQuery, userID, accessToken); err != nil {
+		return nil, err
+	}
+	session, err := a.createSessionTx(tx, userID, sessionID, accessExpires, refreshExpires, origin)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (a *Manager) createSessionTx(tx *sql.Tx, userID, sessionID string, accessExpires, refreshExpires time.Time, origin netip.Addr) (*Session, error) {
+	accessToken, err := a.createTokenTx(tx, userID, GenerateToken(), "", accessExpires, origin, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken := util.RandomStringPrefix(refreshTokenPrefix, refreshTokenLength)
+	if _, err := tx.Exec(insertRefreshTokenQuery, refreshToken, userID, sessionID, accessToken.Value, refreshExpires.Unix()); err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:             sessionID,
+		UserID:         userID,
+		AccessToken:    accessToken,
+		RefreshToken:   refreshToken,
+		RefreshExpires: refreshExpires,
+	}, nil
+}
+
+func (a *Manager) isSessionToken(userID, token string) (bool, error) {
+	var count int
+	if err := a.db.QueryRow(selectSessionAccessTokenCountQuery, userID, token).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// PhoneNumbers returns all phone numbers for the user with the given user ID
+func (a *Manager) PhoneNumbers(userID string) ([]string, error) {
+	rows, err := a.db.Query(selectPhoneNumbersQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	phoneNumbers := make([]string, 0)
+	for {
+		phoneNumber, err := a.readPhoneNumber(rows)
+		if errors.Is(err, ErrPhoneNumberNotFound) {
 			break
 		} else if err != nil {
 			return nil, err
@@ -856,6 +1974,26 @@ func (a *Manager) PhoneNumbers(userID string) ([]string, error) {
 	return phoneNumbers, nil
 }
 
+// AllPhoneNumbers returns the phone numbers of all users, keyed by user ID
+func (a *Manager) AllPhoneNumbers() (map[string][]string, error) {
+	rows, err := a.db.Query(selectAllPhoneNumbersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	phoneNumbers := make(map[string][]string)
+	for rows.Next() {
+		var userID, phoneNumber string
+		if err := rows.Scan(&userID, &phoneNumber); err != nil {
+			return nil, err
+		} else if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		phoneNumbers[userID] = append(phoneNumbers[userID], phoneNumber)
+	}
+	return phoneNumbers, nil
+}
+
 func (a *Manager) readPhoneNumber(rows *sql.Rows) (string, error) {
 	var phoneNumber string
 	if !rows.Next() {
@@ -872,7 +2010,7 @@ func (a *Manager) readPhoneNumber(rows *sql.Rows) (string, error) {
 // AddPhoneNumber adds a phone number to the user with the given user ID
 func (a *Manager) AddPhoneNumber(userID string, phoneNumber string) error {
 	if _, err := a.db.Exec(insertPhoneNumberQuery, userID, phoneNumber); err != nil {
-		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
 			return ErrPhoneNumberExists
 		}
 		return err
@@ -886,6 +2024,252 @@ func (a *Manager) RemovePhoneNumber(userID string, phoneNumber string) error {
 	return err
 }
 
+// Email returns the verified email address of the user with the given user ID, along with the previous
+// address if it is still kept as a fallback. If the user has no email address, ErrEmailNotFound is returned.
+func (a *Manager) Email(userID string) (*Email, error) {
+	var email string
+	var fallbackEmail sql.NullString
+	var fallbackUntil sql.NullInt64
+	if err := a.db.QueryRow(selectEmailQuery, userID).Scan(&email, &fallbackEmail, &fallbackUntil); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEmailNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	e := &Email{
+		Address: email,
+	}
+	if fallbackEmail.Valid && fallbackUntil.Valid && time.Now().Before(time.Unix(fallbackUntil.Int64, 0)) {
+		e.Fallback = fallbackEmail.String
+		e.FallbackUntil = time.Unix(fallbackUntil.Int64, 0)
+	}
+	return e, nil
+}
+
+// ChangeEmail sets the verified email address of the user with the given user ID. If the user already had
+// a different address, it is kept as a fallback for the given duration. If the address is already used by
+// another user, ErrEmailExists is returned.
+func (a *Manager) ChangeEmail(userID, email string, fallbackDuration time.Duration) error {
+	return execTx(a.db, func(tx *sql.Tx) error {
+		var previous string
+		if err := tx.QueryRow(selectEmailQuery, userID).Scan(&previous, new(sql.NullString), new(sql.NullInt64)); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		var fallbackEmail sql.NullString
+		var fallbackUntil sql.NullInt64
+		if previous != "" && previous != email && fallbackDuration > 0 {
+			fallbackEmail = sql.NullString{String: previous, Valid: true}
+			fallbackUntil = sql.NullInt64{Int64: time.Now().Add(fallbackDuration).Unix(), Valid: true}
+		}
+		if _, err := tx.Exec(upsertEmailQuery, userID, email, fallbackEmail, fallbackUntil); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				return ErrEmailExists
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// RemoveEmail removes the email address (and the fallback address) of the user with the given user ID
+func (a *Manager) RemoveEmail(userID string) error {
+	_, err := a.db.Exec(deleteEmailQuery, userID)
+	return err
+}
+
+// RemoveExpiredEmailFallbacks removes all fallback email addresses whose fallback period has ended
+func (a *Manager) RemoveExpiredEmailFallbacks() error {
+	_, err := a.db.Exec(updateEmailFallbackExpiredQuery, time.Now().Unix())
+	return err
+}
+
+// WebAuthnCredentials returns all WebAuthn credentials (security keys, passkeys) for the user with the given user ID
+func (a *Manager) WebAuthnCredentials(userID string) ([]*WebAuthnCredential, error) {
+	rows, err := a.db.Query(selectWebAuthnCredentialsQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	credentials := make([]*WebAuthnCredential, 0)
+	for rows.Next() {
+		var id, name string
+		var publicKey []byte
+		var signCount, created int64
+		if err := rows.Scan(&id, &publicKey, &signCount, &name, &created); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, &WebAuthnCredential{
+			ID:        id,
+			PublicKey: publicKey,
+			SignCount: uint32(signCount),
+			Name:      name,
+			Created:   time.Unix(created, 0),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// AddWebAuthnCredential adds a WebAuthn credential to the user with the given user ID
+func (a *Manager) AddWebAuthnCredential(userID string, credential *WebAuthnCredential) error {
+	if _, err := a.db.Exec(insertWebAuthnCredentialQuery, userID, credential.ID, credential.PublicKey, int64(credential.SignCount), credential.Name, time.Now().Unix()); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+			return ErrWebAuthnCredentialExists
+		}
+		return err
+	}
+	return nil
+}
+
+// ChangeWebAuthnSignCount updates the signature counter of a WebAuthn credential after a successful assertion
+func (a *Manager) ChangeWebAuthnSignCount(userID, credentialID string, signCount uint32) error {
+	_, err := a.db.Exec(updateWebAuthnCredentialSignCount, int64(signCount), userID, credentialID)
+	return err
+}
+
+// RemoveWebAuthnCredential deletes a WebAuthn credential from the user with the given user ID
+func (a *Manager) RemoveWebAuthnCredential(userID, credentialID string) error {
+	_, err := a.db.Exec(deleteWebAuthnCredentialQuery, userID, credentialID)
+	return err
+}
+
+// AddStatsRollup adds the given counters to the hourly stats rollup of the hour that contains the given time.
+// Messages, emails and calls are added to the existing values, while the visitor and user counts are
+// distinct counts, so the higher value is kept.
+func (a *Manager) AddStatsRollup(t time.Time, stats *StatsRollup) error {
+	start := t.Truncate(time.Hour).Unix()
+	_, err := a.db.Exec(upsertStatsRollupHourQuery, start, stats.Messages, stats.Emails, stats.Calls, stats.Visitors, stats.Users)
+	return err
+}
+
+// RollupStats aggregates the hourly stats rollups of all days starting with the day that contains the given
+// time into daily rollups, and removes hourly and daily rollups older than the given retention durations.
+// Visitor and user counts of daily rollups are the peak hourly values.
+func (a *Manager) RollupStats(since time.Time, hourlyRetention, dailyRetention time.Duration) error {
+	return execTx(a.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(upsertStatsRollupDaysQuery, since.Truncate(24*time.Hour).Unix()); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteStatsRollupsQuery, string(StatsRollupPeriodHour), time.Now().Add(-hourlyRetention).Unix()); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteStatsRollupsQuery, string(StatsRollupPeriodDay), time.Now().Add(-dailyRetention).Unix()); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteStatsUsageQuery, time.Now().Add(-dailyRetention).Unix()); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// StatsRollups returns all stats rollups of the given period, starting at the given time
+func (a *Manager) StatsRollups(period StatsRollupPeriod, since time.Time) ([]*StatsRollup, error) {
+	rows, err := a.db.Query(selectStatsRollupsQuery, string(period), since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rollups := make([]*StatsRollup, 0)
+	for rows.Next() {
+		var start, messages, emails, calls, visitors, users int64
+		if err := rows.Scan(&start, &messages, &emails, &calls, &visitors, &users); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, &StatsRollup{
+			Period:   period,
+			Start:    time.Unix(start, 0),
+			Messages: messages,
+			Emails:   emails,
+			Calls:    calls,
+			Visitors: visitors,
+			Users:    users,
+		})
+	}
+	return rollups, rows.Err()
+}
+
+// AddUsageStats adds the given counters to the daily usage stats of the respective users and topics. The day of
+// each entry is the UTC day that contains UsageStats.Day, and an empty user ID denotes anonymous usage.
+func (a *Manager) AddUsageStats(stats []*UsageStats) error {
+	return execTx(a.db, func(tx *sql.Tx) error {
+		for _, s := range stats {
+			userID := s.UserID
+			if userID == "" {
+				userID = everyoneID
+			}
+			day := s.Day.UTC().Truncate(24 * time.Hour).Unix()
+			if _, err := tx.Exec(upsertStatsUsageQuery, day, userID, s.Topic, s.Messages, s.Subscriptions, s.Attachments, s.AttachmentBytes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UserUsageStats returns the daily usage stats of the given user per topic, starting with the day that contains
+// the given time. The usage of anonymous users can be queried with an empty user ID.
+func (a *Manager) UserUsageStats(userID string, since time.Time) ([]*UsageStats, error) {
+	if userID == "" {
+		userID = everyoneID
+	}
+	rows, err := a.db.Query(selectStatsUsageByUserQuery, userID, since.UTC().Truncate(24*time.Hour).Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make([]*UsageStats, 0)
+	for rows.Next() {
+		s := &UsageStats{UserID: userID}
+		var day int64
+		if err := rows.Scan(&day, &s.Topic, &s.Messages, &s.Subscriptions, &s.Attachments, &s.AttachmentBytes); err != nil {
+			return nil, err
+		}
+		s.Day = time.Unix(day, 0).UTC()
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// TopUsageStats returns the total usage stats of the users (UsageGroupUser) or topics (UsageGroupTopic) with
+// the highest value of the given counter, starting with the day that contains the given time. The entries only
+// have UserID and Username, or Topic set, depending on the group.
+func (a *Manager) TopUsageStats(group UsageGroup, sort UsageSort, since time.Time, limit int) ([]*UsageStats, error) {
+	column, ok := usageSortColumns[sort]
+	if !ok {
+		return nil, ErrInvalidArgument
+	}
+	var query string
+	switch group {
+	case UsageGroupUser:
+		query = fmt.Sprintf(selectStatsUsageTopUsersQuery, column)
+	case UsageGroupTopic:
+		query = fmt.Sprintf(selectStatsUsageTopTopicsQuery, column)
+	default:
+		return nil, ErrInvalidArgument
+	}
+	rows, err := a.db.Query(query, since.UTC().Truncate(24*time.Hour).Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make([]*UsageStats, 0)
+	for rows.Next() {
+		s := &UsageStats{}
+		if group == UsageGroupUser {
+			err = rows.Scan(&s.UserID, &s.Username, &s.Messages, &s.Subscriptions, &s.Attachments, &s.AttachmentBytes)
+		} else {
+			err = rows.Scan(&s.Topic, &s.Messages, &s.Subscriptions, &s.Attachments, &s.AttachmentBytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
 // RemoveDeletedUsers deletes all users that have been marked deleted for
 func (a *Manager) RemoveDeletedUsers() error {
 	if _, err := a.db.Exec(deleteUsersMarkedQuery, time.Now().Unix()); err != nil {
@@ -933,6 +2317,52 @@ func (a *Manager) EnqueueTokenUpdate(tokenID string, update *TokenUpdate) {
 	a.tokenQueue[tokenID] = update
 }
 
+// SwapTokenUpdate is like EnqueueTokenUpdate, but also returns the previous update of the token, either from the
+// queue, or from the database if the token is not in the queue. This allows callers to detect changes, e.g. a token
+// that is suddenly used from a new country.
+func (a *Manager) SwapTokenUpdate(tokenID string, update *TokenUpdate) (*TokenUpdate, error) {
+	a.mu.Lock()
+	if previous, ok := a.tokenQueue[tokenID]; ok {
+		a.tokenQueue[tokenID] = update
+		a.mu.Unlock()
+		return previous, nil
+	}
+	a.mu.Unlock()
+	previous, err := a.tokenLastAccess(tokenID)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if queued, ok := a.tokenQueue[tokenID]; ok {
+		previous = queued // Token was updated while we were reading from the database
+	}
+	a.tokenQueue[tokenID] = update
+	return previous, nil
+}
+
+func (a *Manager) tokenLastAccess(token string) (*TokenUpdate, error) {
+	var lastAccess int64
+	var lastOrigin, lastUserAgent, lastCountry string
+	var lastASN uint32
+	if err := a.db.QueryRow(selectTokenLastAccessQuery, token).Scan(&lastAccess, &lastOrigin, &lastUserAgent, &lastCountry, &lastASN); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	lastOriginIP, err := netip.ParseAddr(lastOrigin)
+	if err != nil {
+		lastOriginIP = netip.IPv4Unspecified()
+	}
+	return &TokenUpdate{
+		LastAccess:    time.Unix(lastAccess, 0),
+		LastOrigin:    lastOriginIP,
+		LastUserAgent: lastUserAgent,
+		LastCountry:   lastCountry,
+		LastASN:       lastASN,
+	}, nil
+}
+
 func (a *Manager) asyncQueueWriter(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	for range ticker.C {
@@ -996,15 +2426,15 @@ func (a *Manager) writeTokenUpdateQueue() error {
 	log.Tag(tag).Debug("Writing token update queue for %d token(s)", len(tokenQueue))
 	for tokenID, update := range tokenQueue {
 		log.Tag(tag).Trace("Updating token %s with last access time %v", tokenID, update.LastAccess.Unix())
-		if err := a.updateTokenLastAccessTx(tx, tokenID, update.LastAccess.Unix(), update.LastOrigin.String()); err != nil {
+		if err := a.updateTokenLastAccessTx(tx, tokenID, update); err != nil {
 			return err
 		}
 	}
 	return tx.Commit()
 }
 
-func (a *Manager) updateTokenLastAccessTx(tx *sql.Tx, token string, lastAccess int64, lastOrigin string) error {
-	if _, err := tx.Exec(updateTokenLastAccessQuery, lastAccess, lastOrigin, token); err != nil {
+func (a *Manager) updateTokenLastAccessTx(tx *sql.Tx, token string, update *TokenUpdate) error {
+	if _, err := tx.Exec(updateTokenLastAccessQuery, update.LastAccess.Unix(), update.LastOrigin.String(), update.LastUserAgent, update.LastCountry, update.LastASN, token); err != nil {
 		return err
 	}
 	return nil
@@ -1013,8 +2443,27 @@ func (a *Manager) updateTokenLastAccessTx(tx *sql.Tx, token string, lastAccess i
 // Authorize returns nil if the given user has access to the given topic using the desired
 // permission. The user param may be nil to signal an anonymous user.
 func (a *Manager) Authorize(user *User, topic string, perm Permission) error {
-	if user != nil && user.Role == RoleAdmin {
-		return nil // Admin can do everything
+	decision, err := a.Explain(user, topic, perm)
+	if err != nil {
+		return err
+	} else if !decision.Allowed {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// Explain performs the same checks as Authorize, but instead of an error, it returns the decision and
+// the rule that produced it. This is used to debug complex access control lists.
+func (a *Manager) Explain(user *User, topic string, perm Permission) (*Decision, error) {
+	if user != nil && user.TokenScopes.Restricted() && !user.TokenScopes.AllowTopic(topic, perm) {
+		return &Decision{Allowed: false, Rule: DecisionRuleTokenScope}, nil
+	} else if user != nil && user.Role == RoleAdmin {
+		return &Decision{Allowed: true, Rule: DecisionRuleAdmin}, nil // Admin can do everything
+	}
+	if a.webhook != nil {
+		if decision := a.webhook.Explain(user, topic, perm); decision != nil {
+			return decision, nil
+		}
 	}
 	username := Everyone
 	if user != nil {
@@ -1022,32 +2471,62 @@ func (a *Manager) Authorize(user *User, topic string, perm Permission) error {
 	}
 	// Select the read/write permissions for this user/topic combo.
 	// - The query may return two rows (one for everyone, and one for the user), but prioritizes the user.
+	// - Entries of the user's groups come after the user's own entries, but before the ones for everyone
 	// - Furthermore, the query prioritizes more specific permissions (longer!) over more generic ones, e.g. "test*" > "*"
 	// - It also prioritizes write permissions over read permissions
-	rows, err := a.db.Query(selectTopicPermsQuery, Everyone, username, topic)
+	// - Expired grants are filtered by the query, grants outside of their hour window are skipped below
+	now := time.Now()
+	rows, err := a.db.Query(selectTopicPermsQuery, Everyone, username, topic, now.Unix(), username, topic)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer rows.Close()
-	if !rows.Next() {
-		return a.resolvePerms(a.config.DefaultAccess, perm)
+	decision := &Decision{}
+	for rows.Next() {
+		var entryUsername, group, topicPattern, hours, timezone, owner string
+		var read, write, provisioned bool
+		var expires int64
+		if err := rows.Scan(&entryUsername, &group, &topicPattern, &read, &write, &provisioned, &expires, &hours, &timezone, &owner); err != nil {
+			return nil, err
+		}
+		entry := &DecisionEntry{
+			Grant: Grant{
+				TopicPattern: fromSQLWildcard(topicPattern),
+				Permission:   NewPermission(read, write),
+				Provisioned:  provisioned,
+				Window:       toGrantWindow(expires, hours, timezone),
+			},
+			Username: entryUsername,
+			Group:    group,
+			Owner:    owner,
+		}
+		if !withinHours(hours, timezone, now, false) {
+			decision.Skipped = append(decision.Skipped, entry)
+			continue
+		}
+		decision.Entry = entry
+		decision.Allowed = resolvePerms(entry.Permission, perm)
+		if owner != "" {
+			decision.Rule = DecisionRuleReservation
+		} else if group != "" {
+			decision.Rule = DecisionRuleGroup
+		} else if entryUsername == Everyone {
+			decision.Rule = DecisionRuleEveryone
+		} else {
+			decision.Rule = DecisionRuleUser
+		}
+		return decision, nil
 	}
-	var read, write bool
-	if err := rows.Scan(&read, &write); err != nil {
-		return err
-	} else if err := rows.Err(); err != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
-	return a.resolvePerms(NewPermission(read, write), perm)
+	decision.Allowed = resolvePerms(a.config.DefaultAccess, perm)
+	decision.Rule = DecisionRuleDefault
+	return decision, nil
 }
 
-func (a *Manager) resolvePerms(base, perm Permission) error {
-	if perm == PermissionRead && base.IsRead() {
-		return nil
-	} else if perm == PermissionWrite && base.IsWrite() {
-		return nil
-	}
-	return ErrUnauthorized
+func resolvePerms(base, perm Permission) bool {
+	return (perm == PermissionRead && base.IsRead()) || (perm == PermissionWrite && base.IsWrite())
 }
 
 // AddUser adds a user with the given username, password and role
@@ -1125,6 +2604,9 @@ func (a *Manager) MarkUserRemoved(user *User) error {
 	if _, err := tx.Exec(deleteAllTokenQuery, user.ID); err != nil {
 		return err
 	}
+	if _, err := tx.Exec(deleteAllRefreshTokensQuery, user.ID); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(updateUserDeletedQuery, time.Now().Add(userHardDeleteAfterDuration).Unix(), user.ID); err != nil {
 		return err
 	}
@@ -1217,13 +2699,13 @@ func (a *Manager) readUser(rows *sql.Rows) (*User, error) {
 	defer rows.Close()
 	var id, username, hash, role, prefs, syncTopic string
 	var provisioned bool
-	var stripeCustomerID, stripeSubscriptionID, stripeSubscriptionStatus, stripeSubscriptionInterval, stripeMonthlyPriceID, stripeYearlyPriceID, tierID, tierCode, tierName sql.NullString
+	var stripeCustomerID, stripeSubscriptionID, stripeSubscriptionStatus, stripeSubscriptionInterval, stripeMonthlyPriceID, stripeYearlyPriceID, tierID, tierCode, tierName, tierFeatures sql.NullString
 	var messages, emails, calls int64
-	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit, stripeSubscriptionPaidUntil, stripeSubscriptionCancelAt, deleted sql.NullInt64
+	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit, stripeSubscriptionPaidUntil, stripeSubscriptionCancelAt, stripePaymentGraceUntil, deleted sql.NullInt64
 	if !rows.Next() {
 		return nil, ErrUserNotFound
 	}
-	if err := rows.Scan(&id, &username, &hash, &role, &prefs, &syncTopic, &provisioned, &messages, &emails, &calls, &stripeCustomerID, &stripeSubscriptionID, &stripeSubscriptionStatus, &stripeSubscriptionInterval, &stripeSubscriptionPaidUntil, &stripeSubscriptionCancelAt, &deleted, &tierID, &tierCode, &tierName, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+	if err := rows.Scan(&id, &username, &hash, &role, &prefs, &syncTopic, &provisioned, &messages, &emails, &calls, &stripeCustomerID, &stripeSubscriptionID, &stripeSubscriptionStatus, &stripeSubscriptionInterval, &stripeSubscriptionPaidUntil, &stripeSubscriptionCancelAt, &stripePaymentGraceUntil, &deleted, &tierID, &tierCode, &tierName, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID, &tierFeatures); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
@@ -1248,6 +2730,7 @@ func (a *Manager) readUser(rows *sql.Rows) (*User, error) {
 			StripeSubscriptionInterval:  payments.PriceRecurringInterval(stripeSubscriptionInterval.String), // May be empty
 			StripeSubscriptionPaidUntil: time.Unix(stripeSubscriptionPaidUntil.Int64, 0),                    // May be zero
 			StripeSubscriptionCancelAt:  time.Unix(stripeSubscriptionCancelAt.Int64, 0),                     // May be zero
+			StripePaymentGraceUntil:     time.Unix(stripePaymentGraceUntil.Int64, 0),                        // May be zero
 		},
 		Deleted: deleted.Valid,
 	}
@@ -1272,6 +2755,11 @@ func (a *Manager) readUser(rows *sql.Rows) (*User, error) {
 			StripeMonthlyPriceID:     stripeMonthlyPriceID.String, // May be empty
 			StripeYearlyPriceID:      stripeYearlyPriceID.String,  // May be empty
 		}
+		features, err := readTierFeatures(tierFeatures.String)
+		if err != nil {
+			return nil, err
+		}
+		user.Tier.Features = features
 	}
 	return user, nil
 }
@@ -1285,9 +2773,10 @@ func (a *Manager) AllGrants() (map[string][]Grant, error) {
 	defer rows.Close()
 	grants := make(map[string][]Grant, 0)
 	for rows.Next() {
-		var userID, topic string
+		var userID, topic, hours, timezone string
 		var read, write, provisioned bool
-		if err := rows.Scan(&userID, &topic, &read, &write, &provisioned); err != nil {
+		var expires int64
+		if err := rows.Scan(&userID, &topic, &read, &write, &provisioned, &expires, &hours, &timezone); err != nil {
 			return nil, err
 		} else if err := rows.Err(); err != nil {
 			return nil, err
@@ -1298,6 +2787,7 @@ func (a *Manager) AllGrants() (map[string][]Grant, error) {
 		grants[userID] = append(grants[userID], Grant{
 			TopicPattern: fromSQLWildcard(topic),
 			Permission:   NewPermission(read, write),
+			Window:       toGrantWindow(expires, hours, timezone),
 			Provisioned:  provisioned,
 		})
 	}
@@ -1313,9 +2803,10 @@ func (a *Manager) Grants(username string) ([]Grant, error) {
 	defer rows.Close()
 	grants := make([]Grant, 0)
 	for rows.Next() {
-		var topic string
+		var topic, hours, timezone string
 		var read, write, provisioned bool
-		if err := rows.Scan(&topic, &read, &write, &provisioned); err != nil {
+		var expires int64
+		if err := rows.Scan(&topic, &read, &write, &provisioned, &expires, &hours, &timezone); err != nil {
 			return nil, err
 		} else if err := rows.Err(); err != nil {
 			return nil, err
@@ -1323,12 +2814,33 @@ func (a *Manager) Grants(username string) ([]Grant, error) {
 		grants = append(grants, Grant{
 			TopicPattern: fromSQLWildcard(topic),
 			Permission:   NewPermission(read, write),
+			Window:       toGrantWindow(expires, hours, timezone),
 			Provisioned:  provisioned,
 		})
 	}
 	return grants, nil
 }
 
+// RemoveExpiredGrants deletes all access control entries whose validity window has expired
+func (a *Manager) RemoveExpiredGrants() error {
+	_, err := a.db.Exec(deleteUserAccessExpiredQuery, time.Now().Unix())
+	return err
+}
+
+func toGrantWindow(expires int64, hours, timezone string) *GrantWindow {
+	if expires == 0 && hours == "" {
+		return nil
+	}
+	window := &GrantWindow{
+		Hours:    hours,
+		Timezone: timezone,
+	}
+	if expires > 0 {
+		window.Expires = time.Unix(expires, 0)
+	}
+	return window
+}
+
 // Reservations returns all user-owned topics, and the associated everyone-access
 func (a *Manager) Reservations(username string) ([]Reservation, error) {
 	rows, err := a.db.Query(selectUserReservationsQuery, Everyone, username)
@@ -1535,7 +3047,7 @@ func (a *Manager) AllowReservation(username string, topic string) error {
 	if (!AllowedUsername(username) && username != Everyone) || !AllowedTopic(topic) {
 		return ErrInvalidArgument
 	}
-	rows, err := a.db.Query(selectOtherAccessCountQuery, escapeUnderscore(topic), escapeUnderscore(topic), username)
+	rows, err := a.db.Query(selectOtherAccessCountQuery, escapeUnderscore(topic), escapeUnderscore(topic), username, escapeUnderscore(topic), escapeUnderscore(topic))
 	if err != nil {
 		return err
 	}
@@ -1557,19 +3069,36 @@ func (a *Manager) AllowReservation(username string, topic string) error {
 // read/write access to a topic. The parameter topicPattern may include wildcards (*). The ACL entry
 // owner may either be a user (username), or the system (empty).
 func (a *Manager) AllowAccess(username string, topicPattern string, permission Permission) error {
+	return a.AllowAccessWindow(username, topicPattern, permission, nil)
+}
+
+// AllowAccessWindow is like AllowAccess, but the entry is only valid within the given window (e.g. until a
+// certain date, or during certain hours of the day). If window is nil, the entry is always valid.
+func (a *Manager) AllowAccessWindow(username string, topicPattern string, permission Permission, window *GrantWindow) error {
 	return execTx(a.db, func(tx *sql.Tx) error {
-		return a.allowAccessTx(tx, username, topicPattern, permission, false)
+		return a.allowAccessTx(tx, username, topicPattern, permission, window, false)
 	})
 }
 
-func (a *Manager) allowAccessTx(tx *sql.Tx, username string, topicPattern string, permission Permission, provisioned bool) error {
+func (a *Manager) allowAccessTx(tx *sql.Tx, username string, topicPattern string, permission Permission, window *GrantWindow, provisioned bool) error {
 	if !AllowedUsername(username) && username != Everyone {
 		return ErrInvalidArgument
 	} else if !AllowedTopicPattern(topicPattern) {
 		return ErrInvalidArgument
 	}
+	var expires int64
+	var hours, timezone string
+	if window != nil {
+		if err := window.Validate(); err != nil {
+			return err
+		}
+		if !window.Expires.IsZero() {
+			expires = window.Expires.Unix()
+		}
+		hours, timezone = window.Hours, window.Timezone
+	}
 	owner := ""
-	if _, err := tx.Exec(upsertUserAccessQuery, username, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), owner, owner, provisioned); err != nil {
+	if _, err := tx.Exec(upsertUserAccessQuery, username, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), owner, owner, provisioned, expires, hours, timezone); err != nil {
 		return err
 	}
 	return nil
@@ -1600,22 +3129,285 @@ func (a *Manager) resetAccessTx(tx *sql.Tx, username string, topicPattern string
 	return err
 }
 
-// AddReservation creates two access control entries for the given topic: one with full read/write access for the
-// given user, and one for Everyone with the permission passed as everyone. The user also owns the entries, and
-// can modify or delete them.
-func (a *Manager) AddReservation(username string, topic string, everyone Permission) error {
-	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) {
+// AddGroup creates a new group with the given name. Members and access control entries can be added
+// with AddGroupMember and AllowGroupAccess.
+func (a *Manager) AddGroup(name string) error {
+	if !AllowedGroup(name) {
 		return ErrInvalidArgument
 	}
-	tx, err := a.db.Begin()
-	if err != nil {
+	groupID := util.RandomStringPrefix(groupIDPrefix, groupIDLength)
+	if _, err := a.db.Exec(insertGroupQuery, groupID, name, time.Now().Unix()); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrGroupExists
+		}
 		return err
 	}
-	defer tx.Rollback()
-	if _, err := tx.Exec(upsertUserAccessQuery, username, escapeUnderscore(topic), true, true, username, username, false); err != nil {
+	return nil
+}
+
+// RemoveGroup deletes the group with the given name, including its memberships and access control entries
+func (a *Manager) RemoveGroup(name string) error {
+	if _, err := a.Group(name); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(upsertUserAccessQuery, Everyone, escapeUnderscore(topic), everyone.IsRead(), everyone.IsWrite(), username, username, false); err != nil {
+	// Rows in user_group_member and user_group_access are deleted via foreign keys
+	_, err := a.db.Exec(deleteGroupQuery, name)
+	return err
+}
+
+// Groups returns all groups, including their members
+func (a *Manager) Groups() ([]*Group, error) {
+	rows, err := a.db.Query(selectGroupsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make([]*Group, 0)
+	for rows.Next() {
+		group := &Group{}
+		if err := rows.Scan(&group.ID, &group.Name); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if group.Members, err = a.groupMembers(group.ID); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// Group returns the group with the given name, including its members
+func (a *Manager) Group(name string) (*Group, error) {
+	group := &Group{}
+	if err := a.db.QueryRow(selectGroupByNameQuery, name).Scan(&group.ID, &group.Name); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGroupNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	members, err := a.groupMembers(group.ID)
+	if err != nil {
+		return nil, err
+	}
+	group.Members = members
+	return group, nil
+}
+
+func (a *Manager) groupMembers(groupID string) ([]string, error) {
+	rows, err := a.db.Query(selectGroupMembersQuery, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		members = append(members, username)
+	}
+	return members, rows.Err()
+}
+
+// UserGroups returns the names of the groups the given user is a member of
+func (a *Manager) UserGroups(username string) ([]string, error) {
+	rows, err := a.db.Query(selectUserGroupsQuery, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make([]string, 0)
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+// AddGroupMember adds a user to a group. Adding a user that is already a member does nothing.
+func (a *Manager) AddGroupMember(name, username string) error {
+	if !AllowedUsername(username) {
+		return ErrInvalidArgument
+	} else if _, err := a.Group(name); err != nil {
+		return err
+	} else if _, err := a.User(username); err != nil {
+		return err
+	}
+	_, err := a.db.Exec(upsertGroupMemberQuery, name, username)
+	return err
+}
+
+// RemoveGroupMember removes a user from a group
+func (a *Manager) RemoveGroupMember(name, username string) error {
+	if _, err := a.Group(name); err != nil {
+		return err
+	}
+	_, err := a.db.Exec(deleteGroupMemberQuery, name, username)
+	return err
+}
+
+// GroupGrants returns the access control entries of a group
+func (a *Manager) GroupGrants(name string) ([]Grant, error) {
+	rows, err := a.db.Query(selectGroupAccessQuery, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	grants := make([]Grant, 0)
+	for rows.Next() {
+		var topic string
+		var read, write bool
+		if err := rows.Scan(&topic, &read, &write); err != nil {
+			return nil, err
+		}
+		grants = append(grants, Grant{
+			TopicPattern: fromSQLWildcard(topic),
+			Permission:   NewPermission(read, write),
+		})
+	}
+	return grants, rows.Err()
+}
+
+// AllowGroupAccess adds or updates an access control entry for a group. The entry applies to all members
+// of the group, unless they have a more specific entry of their own, see Explain.
+func (a *Manager) AllowGroupAccess(name string, topicPattern string, permission Permission) error {
+	if !AllowedTopicPattern(topicPattern) {
+		return ErrInvalidArgument
+	} else if _, err := a.Group(name); err != nil {
+		return err
+	}
+	_, err := a.db.Exec(upsertGroupAccessQuery, name, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite())
+	return err
+}
+
+// ResetGroupAccess removes the access control entry of a group for the given topic pattern, or all
+// entries of the group if topicPattern is empty
+func (a *Manager) ResetGroupAccess(name string, topicPattern string) error {
+	if !AllowedTopicPattern(topicPattern) && topicPattern != "" {
+		return ErrInvalidArgument
+	} else if _, err := a.Group(name); err != nil {
+		return err
+	}
+	if topicPattern == "" {
+		_, err := a.db.Exec(deleteGroupAccessQuery, name)
+		return err
+	}
+	_, err := a.db.Exec(deleteGroupTopicAccessQuery, name, toSQLWildcard(topicPattern))
+	return err
+}
+
+// AddBan bans an IP address or range, a user, or an access token until the given time. Bans with an expiry
+// time of Unix 0 never expire. If the IP range, user or token is already banned, the existing ban is replaced.
+// IP addresses are stored as prefixes, e.g. 1.2.3.4 is stored as 1.2.3.4/32.
+func (a *Manager) AddBan(kind BanKind, value, reason string, expires time.Time) (*Ban, error) {
+	value, err := normalizeBanValue(kind, value)
+	if err != nil {
+		return nil, err
+	}
+	if kind == BanKindUser {
+		if _, err := a.User(value); err != nil {
+			return nil, err
+		}
+	}
+	banID := util.RandomStringPrefix(banIDPrefix, banIDLength)
+	return queryTx(a.db, func(tx *sql.Tx) (*Ban, error) {
+		if _, err := tx.Exec(upsertBanQuery, banID, string(kind), value, reason, time.Now().Unix(), max(expires.Unix(), 0)); err != nil {
+			return nil, err
+		}
+		rows, err := tx.Query(selectBanQuery, string(kind), value)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			return nil, ErrBanNotFound
+		}
+		return readBan(rows)
+	})
+}
+
+// Bans returns all bans that have not expired, oldest first
+func (a *Manager) Bans() ([]*Ban, error) {
+	rows, err := a.db.Query(selectBansQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := make([]*Ban, 0)
+	for rows.Next() {
+		ban, err := readBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// RemoveBan lifts the ban with the given ID, or returns ErrBanNotFound if it does not exist
+func (a *Manager) RemoveBan(id string) error {
+	result, err := a.db.Exec(deleteBanQuery, id)
+	if err != nil {
+		return err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if removed == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+// RemoveExpiredBans deletes all bans whose expiry time has passed
+func (a *Manager) RemoveExpiredBans() error {
+	_, err := a.db.Exec(deleteBansExpiredQuery, time.Now().Unix())
+	return err
+}
+
+func readBan(rows *sql.Rows) (*Ban, error) {
+	var id, kind, value, reason string
+	var created, expires int64
+	if err := rows.Scan(&id, &kind, &value, &reason, &created, &expires); err != nil {
+		return nil, err
+	}
+	return &Ban{
+		ID:      id,
+		Kind:    BanKind(kind),
+		Value:   value,
+		Reason:  reason,
+		Created: time.Unix(created, 0),
+		Expires: time.Unix(expires, 0),
+	}, nil
+}
+
+// AddReservation creates two access control entries for the given topic: one with full read/write access for the
+// given user, and one for Everyone with the permission passed as everyone. The user also owns the entries, and
+// can modify or delete them.
+func (a *Manager) AddReservation(username string, topic string, everyone Permission) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(upsertUserAccessQuery, username, escapeUnderscore(topic), true, true, username, username, false, 0, "", ""); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(upsertUserAccessQuery, Everyone, escapeUnderscore(topic), everyone.IsRead(), everyone.IsWrite(), username, username, false, 0, "", ""); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -1644,453 +3436,1670 @@ func (a *Manager) RemoveReservations(username string, topics ...string) error {
 		if _, err := tx.Exec(deleteTopicAccessQuery, Everyone, Everyone, escapeUnderscore(topic)); err != nil {
 			return err
 		}
+		if _, err := tx.Exec(deleteEmailTemplateQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicTemplateQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteOwnedTopicPolicyQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicWebhooksQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicSigningKeysQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicTelegramQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicIngestQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteTopicKeyQuery, topic); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
-// DefaultAccess returns the default read/write access if no access control entry matches
-func (a *Manager) DefaultAccess() Permission {
-	return a.config.DefaultAccess
+// EmailTemplate returns the email template for the given topic, or ErrEmailTemplateNotFound
+// if the topic owner has not defined one
+func (a *Manager) EmailTemplate(topic string) (*EmailTemplate, error) {
+	rows, err := a.db.Query(selectEmailTemplateQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, ErrEmailTemplateNotFound
+	}
+	var tpl EmailTemplate
+	if err := rows.Scan(&tpl.Topic, &tpl.Subject, &tpl.Body); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &tpl, nil
 }
 
-// AddTier creates a new tier in the database
-func (a *Manager) AddTier(tier *Tier) error {
-	if tier.ID == "" {
-		tier.ID = util.RandomStringPrefix(tierIDPrefix, tierIDLength)
+// ChangeEmailTemplate sets or replaces the email template for a topic. The template is owned by the
+// given user, and is removed when the user or the topic reservation is removed.
+func (a *Manager) ChangeEmailTemplate(username string, tpl *EmailTemplate) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(tpl.Topic) {
+		return ErrInvalidArgument
 	}
-	if _, err := a.db.Exec(insertTierQuery, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID)); err != nil {
+	if _, err := a.db.Exec(upsertEmailTemplateQuery, tpl.Topic, username, tpl.Subject, tpl.Body); err != nil {
 		return err
 	}
 	return nil
 }
 
-// UpdateTier updates a tier's properties in the database
-func (a *Manager) UpdateTier(tier *Tier) error {
-	if _, err := a.db.Exec(updateTierQuery, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Code); err != nil {
+// RemoveEmailTemplate deletes the email template for the given topic
+func (a *Manager) RemoveEmailTemplate(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(deleteEmailTemplateQuery, topic); err != nil {
 		return err
 	}
 	return nil
 }
 
-// RemoveTier deletes the tier with the given code
-func (a *Manager) RemoveTier(code string) error {
-	if !AllowedTier(code) {
+// TopicTemplate returns the transformation template for the given topic, or ErrTopicTemplateNotFound
+// if the topic owner has not defined one
+func (a *Manager) TopicTemplate(topic string) (*TopicTemplate, error) {
+	rows, err := a.db.Query(selectTopicTemplateQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, ErrTopicTemplateNotFound
+	}
+	var tpl TopicTemplate
+	if err := rows.Scan(&tpl.Topic, &tpl.Title, &tpl.Message, &tpl.Tags, &tpl.Priority); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+// ChangeTopicTemplate sets or replaces the transformation template for a topic. The template is owned by the
+// given user, and is removed when the user or the topic reservation is removed.
+func (a *Manager) ChangeTopicTemplate(username string, tpl *TopicTemplate) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(tpl.Topic) {
 		return ErrInvalidArgument
 	}
-	// This fails if any user has this tier
-	if _, err := a.db.Exec(deleteTierQuery, code); err != nil {
+	if _, err := a.db.Exec(upsertTopicTemplateQuery, tpl.Topic, username, tpl.Title, tpl.Message, tpl.Tags, tpl.Priority); err != nil {
 		return err
 	}
 	return nil
 }
 
-// ChangeBilling updates a user's billing fields, namely the Stripe customer ID, and subscription information
-func (a *Manager) ChangeBilling(username string, billing *Billing) error {
-	if _, err := a.db.Exec(updateBillingQuery, nullString(billing.StripeCustomerID), nullString(billing.StripeSubscriptionID), nullString(string(billing.StripeSubscriptionStatus)), nullString(string(billing.StripeSubscriptionInterval)), nullInt64(billing.StripeSubscriptionPaidUntil.Unix()), nullInt64(billing.StripeSubscriptionCancelAt.Unix()), username); err != nil {
+// RemoveTopicTemplate deletes the transformation template for the given topic
+func (a *Manager) RemoveTopicTemplate(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(deleteTopicTemplateQuery, topic); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Tiers returns a list of all Tier structs
-func (a *Manager) Tiers() ([]*Tier, error) {
-	rows, err := a.db.Query(selectTiersQuery)
+// TopicTelegram returns the Telegram relay settings for the given topic, or ErrTopicTelegramNotFound
+func (a *Manager) TopicTelegram(topic string) (*TopicTelegram, error) {
+	rows, err := a.db.Query(selectTopicTelegramQuery, topic)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	tiers := make([]*Tier, 0)
-	for {
-		tier, err := a.readTier(rows)
-		if errors.Is(err, ErrTierNotFound) {
-			break
-		} else if err != nil {
-			return nil, err
-		}
-		tiers = append(tiers, tier)
+	if !rows.Next() {
+		return nil, ErrTopicTelegramNotFound
 	}
-	return tiers, nil
+	var telegram TopicTelegram
+	if err := rows.Scan(&telegram.Topic, &telegram.BotToken, &telegram.ChatID); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &telegram, nil
 }
 
-// Tier returns a Tier based on the code, or ErrTierNotFound if it does not exist
-func (a *Manager) Tier(code string) (*Tier, error) {
-	rows, err := a.db.Query(selectTierByCodeQuery, code)
-	if err != nil {
-		return nil, err
+// ChangeTopicTelegram sets or replaces the Telegram relay settings for a topic. The settings are owned by the
+// given user, and are removed when the user or the topic reservation is removed.
+func (a *Manager) ChangeTopicTelegram(username string, telegram *TopicTelegram) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(telegram.Topic) || telegram.BotToken == "" || telegram.ChatID == "" {
+		return ErrInvalidArgument
 	}
-	defer rows.Close()
-	return a.readTier(rows)
+	if _, err := a.db.Exec(upsertTopicTelegramQuery, telegram.Topic, username, telegram.BotToken, telegram.ChatID); err != nil {
+		return err
+	}
+	return nil
 }
 
-// TierByStripePrice returns a Tier based on the Stripe price ID, or ErrTierNotFound if it does not exist
-func (a *Manager) TierByStripePrice(priceID string) (*Tier, error) {
-	rows, err := a.db.Query(selectTierByPriceIDQuery, priceID, priceID)
+// RemoveTopicTelegram deletes the Telegram relay settings for the given topic
+func (a *Manager) RemoveTopicTelegram(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(deleteTopicTelegramQuery, topic); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TopicIngest returns the ingest settings (webhook source and secret) for the given topic, or ErrTopicIngestNotFound
+func (a *Manager) TopicIngest(topic string) (*TopicIngest, error) {
+	rows, err := a.db.Query(selectTopicIngestQuery, topic)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	return a.readTier(rows)
-}
-
-func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
-	var id, code, name string
-	var stripeMonthlyPriceID, stripeYearlyPriceID sql.NullString
-	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit sql.NullInt64
 	if !rows.Next() {
-		return nil, ErrTierNotFound
+		return nil, ErrTopicIngestNotFound
 	}
-	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+	var ingest TopicIngest
+	var source string
+	if err := rows.Scan(&ingest.Topic, &source, &ingest.Secret); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	// When changed, note readUser() as well
-	return &Tier{
-		ID:                       id,
-		Code:                     code,
-		Name:                     name,
-		MessageLimit:             messagesLimit.Int64,
-		MessageExpiryDuration:    time.Duration(messagesExpiryDuration.Int64) * time.Second,
-		EmailLimit:               emailsLimit.Int64,
-		CallLimit:                callsLimit.Int64,
-		ReservationLimit:         reservationsLimit.Int64,
-		AttachmentFileSizeLimit:  attachmentFileSizeLimit.Int64,
-		AttachmentTotalSizeLimit: attachmentTotalSizeLimit.Int64,
-		AttachmentExpiryDuration: time.Duration(attachmentExpiryDuration.Int64) * time.Second,
-		AttachmentBandwidthLimit: attachmentBandwidthLimit.Int64,
-		StripeMonthlyPriceID:     stripeMonthlyPriceID.String, // May be empty
-		StripeYearlyPriceID:      stripeYearlyPriceID.String,  // May be empty
-	}, nil
+	ingest.Source = IngestSource(source)
+	return &ingest, nil
 }
 
-// Close closes the underlying database
-func (a *Manager) Close() error {
-	return a.db.Close()
+// ChangeTopicIngest sets or replaces the ingest settings for a topic. The settings are owned by the
+// given user, and are removed when the user or the topic reservation is removed.
+func (a *Manager) ChangeTopicIngest(username string, ingest *TopicIngest) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(ingest.Topic) || !AllowedIngestSource(ingest.Source) || ingest.Secret == "" {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(upsertTopicIngestQuery, ingest.Topic, username, string(ingest.Source), ingest.Secret); err != nil {
+		return err
+	}
+	return nil
 }
 
-// maybeProvisionUsersAccessAndTokens provisions users, access control entries, and tokens based on the config.
-func (a *Manager) maybeProvisionUsersAccessAndTokens() error {
-	if !a.config.ProvisionEnabled {
-		return nil
+// RemoveTopicIngest deletes the ingest settings for the given topic
+func (a *Manager) RemoveTopicIngest(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
 	}
-	existingUsers, err := a.Users()
+	if _, err := a.db.Exec(deleteTopicIngestQuery, topic); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TopicKey returns the shared key settings for the given topic, or ErrTopicKeyNotFound. The key itself is
+// only stored as a hash.
+func (a *Manager) TopicKey(topic string) (*TopicKey, error) {
+	rows, err := a.db.Query(selectTopicKeyQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, ErrTopicKeyNotFound
+	}
+	var key TopicKey
+	var read, write bool
+	if err := rows.Scan(&key.Topic, &key.Hash, &read, &write); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	key.Permission = NewPermission(read, write)
+	return &key, nil
+}
+
+// ChangeTopicKey sets or replaces the shared key of a topic, which grants the given permission to anyone who
+// knows it. The key is owned by the given user, and is removed when the user or the topic reservation is removed.
+func (a *Manager) ChangeTopicKey(username, topic, key string, perm Permission) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) || !AllowedTopicKey(key) || perm == PermissionDenyAll {
+		return ErrInvalidArgument
+	}
+	hash, err := hashPassword(key, a.config.BcryptCost)
 	if err != nil {
 		return err
 	}
-	provisionUsernames := util.Map(a.config.Users, func(u *User) string {
-		return u.Name
-	})
-	return execTx(a.db, func(tx *sql.Tx) error {
-		if err := a.maybeProvisionUsers(tx, provisionUsernames, existingUsers); err != nil {
-			return fmt.Errorf("failed to provision users: %v", err)
+	if _, err := a.db.Exec(upsertTopicKeyQuery, topic, username, hash, perm.IsRead(), perm.IsWrite()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveTopicKey deletes the shared key of the given topic
+func (a *Manager) RemoveTopicKey(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(deleteTopicKeyQuery, topic); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AuthorizeTopicKey returns nil if the given key matches the shared key of the topic, and the key grants the given
+// permission. It returns ErrTopicKeyNotFound if the topic has no shared key, and ErrUnauthorized otherwise.
+func (a *Manager) AuthorizeTopicKey(topic, key string, perm Permission) error {
+	topicKey, err := a.TopicKey(topic)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(topicKey.Hash), []byte(key)); err != nil {
+		return ErrUnauthorized
+	} else if perm&topicKey.Permission != perm {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// AddEphemeralTopic creates a short-lived topic for the given user: the user gets read-write access to the topic, everyone
+// else is denied, and two tokens scoped to the topic (one for subscribing, one for publishing) are created. Access
+// entries and tokens expire at the given time. The topic itself is removed by RemoveEphemeralTopics.
+func (a *Manager) AddEphemeralTopic(user *User, topic string, expires time.Time, origin netip.Addr) (*EphemeralTopic, error) {
+	if user == nil || user.Name == Everyone || !AllowedTopic(topic) || expires.IsZero() {
+		return nil, ErrInvalidArgument
+	}
+	return queryTx(a.db, func(tx *sql.Tx) (*EphemeralTopic, error) {
+		if _, err := tx.Exec(upsertUserAccessQuery, user.Name, escapeUnderscore(topic), true, true, "", "", false, expires.Unix(), "", ""); err != nil {
+			return nil, err
 		}
-		if err := a.maybeProvisionGrants(tx); err != nil {
-			return fmt.Errorf("failed to provision grants: %v", err)
+		if _, err := tx.Exec(upsertUserAccessQuery, Everyone, escapeUnderscore(topic), false, false, "", "", false, expires.Unix(), "", ""); err != nil {
+			return nil, err
 		}
-		if err := a.maybeProvisionTokens(tx, provisionUsernames); err != nil {
-			return fmt.Errorf("failed to provision tokens: %v", err)
+		readToken, err := a.createTokenTx(tx, user.ID, GenerateToken(), fmt.Sprintf("Ephemeral topic %s (read)", topic), expires, origin, false, TokenScopes{TokenScopeSubscribe, NewTopicScope(topic)})
+		if err != nil {
+			return nil, err
 		}
-		return nil
+		writeToken, err := a.createTokenTx(tx, user.ID, GenerateToken(), fmt.Sprintf("Ephemeral topic %s (write)", topic), expires, origin, false, TokenScopes{TokenScopePublish, NewTopicScope(topic)})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(insertEphemeralTopicQuery, topic, user.ID, readToken.Value, writeToken.Value, expires.Unix()); err != nil {
+			return nil, err
+		}
+		return &EphemeralTopic{
+			Topic:      topic,
+			ReadToken:  readToken,
+			WriteToken: writeToken,
+			Expires:    expires,
+		}, nil
 	})
 }
 
-// maybeProvisionUsers checks if the users in the config are provisioned, and adds or updates them.
-// It also removes users that are provisioned, but not in the config anymore.
-func (a *Manager) maybeProvisionUsers(tx *sql.Tx, provisionUsernames []string, existingUsers []*User) error {
-	// Remove users that are provisioned, but not in the config anymore
-	for _, user := range existingUsers {
-		if user.Name == Everyone {
-			continue
-		} else if user.Provisioned && !util.Contains(provisionUsernames, user.Name) {
-			if err := a.removeUserTx(tx, user.Name); err != nil {
-				return fmt.Errorf("failed to remove provisioned user %s: %v", user.Name, err)
-			}
-		}
+// EphemeralTopicsCount returns the number of ephemeral topics of the given user that have not expired yet
+func (a *Manager) EphemeralTopicsCount(userID string) (int64, error) {
+	rows, err := a.db.Query(selectEphemeralTopicsCountQuery, userID, time.Now().Unix())
+	if err != nil {
+		return 0, err
 	}
-	// Add or update provisioned users
-	for _, user := range a.config.Users {
-		if user.Name == Everyone {
-			continue
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, errNoRows
+	}
+	var count int64
+	if err := rows.Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// EphemeralTopicsExpired returns the names of all ephemeral topics that have expired
+func (a *Manager) EphemeralTopicsExpired() ([]string, error) {
+	rows, err := a.db.Query(selectEphemeralTopicsExpiredQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	topics := make([]string, 0)
+	for rows.Next() {
+		var topic string
+		if err := rows.Scan(&topic); err != nil {
+			return nil, err
 		}
-		existingUser, exists := util.Find(existingUsers, func(u *User) bool {
-			return u.Name == user.Name
-		})
-		if !exists {
-			if err := a.addUserTx(tx, user.Name, user.Hash, user.Role, true, true); err != nil && !errors.Is(err, ErrUserExists) {
-				return fmt.Errorf("failed to add provisioned user %s: %v", user.Name, err)
-			}
-		} else {
-			if !existingUser.Provisioned {
-				if err := a.changeProvisionedTx(tx, user.Name, true); err != nil {
-					return fmt.Errorf("failed to change provisioned status for user %s: %v", user.Name, err)
-				}
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
+}
+
+// RemoveEphemeralTopics deletes the given ephemeral topics, including their tokens and access control entries.
+// This is the counterpart for AddEphemeralTopic.
+func (a *Manager) RemoveEphemeralTopics(topics ...string) error {
+	return execTx(a.db, func(tx *sql.Tx) error {
+		for _, topic := range topics {
+			if _, err := tx.Exec(deleteEphemeralTopicTokensQuery, topic, topic); err != nil {
+				return err
 			}
-			if existingUser.Hash != user.Hash {
-				if err := a.changePasswordTx(tx, user.Name, user.Hash, true); err != nil {
-					return fmt.Errorf("failed to change password for provisioned user %s: %v", user.Name, err)
-				}
+			if _, err := tx.Exec(deleteEphemeralTopicAccessQuery, escapeUnderscore(topic)); err != nil {
+				return err
 			}
-			if existingUser.Role != user.Role {
-				if err := a.changeRoleTx(tx, user.Name, user.Role); err != nil {
-					return fmt.Errorf("failed to change role for provisioned user %s: %v", user.Name, err)
-				}
+			if _, err := tx.Exec(deleteEphemeralTopicQuery, topic); err != nil {
+				return err
 			}
 		}
+		return nil
+	})
+}
+
+// TopicPolicy returns the retention and size policy for the given topic, or ErrTopicPolicyNotFound
+// if neither the topic owner nor an admin has defined one
+func (a *Manager) TopicPolicy(topic string) (*TopicPolicy, error) {
+	rows, err := a.db.Query(selectTopicPolicyQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, ErrTopicPolicyNotFound
+	}
+	policy, err := a.readTopicPolicy(rows)
+	if err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// TopicPolicies returns the retention and size policies of all topics, sorted by topic
+func (a *Manager) TopicPolicies() ([]*TopicPolicy, error) {
+	rows, err := a.db.Query(selectTopicPoliciesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	policies := make([]*TopicPolicy, 0)
+	for rows.Next() {
+		policy, err := a.readTopicPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (a *Manager) readTopicPolicy(rows *sql.Rows) (*TopicPolicy, error) {
+	var policy TopicPolicy
+	var cacheDuration int64
+	if err := rows.Scan(&policy.Topic, &policy.Owner, &cacheDuration, &policy.MessageLimit, &policy.AttachmentFileSizeLimit); err != nil {
+		return nil, err
+	}
+	policy.CacheDuration = time.Duration(cacheDuration) * time.Second
+	return &policy, nil
+}
+
+// ChangeTopicPolicy sets or replaces the retention and size policy for a topic. If username is set, the policy
+// is owned by the given user, and is removed when the user or the topic reservation is removed. If username
+// is empty, the policy is managed by an admin, and is kept until it is removed explicitly.
+func (a *Manager) ChangeTopicPolicy(username string, policy *TopicPolicy) error {
+	if (username != "" && (!AllowedUsername(username) || username == Everyone)) || !AllowedTopic(policy.Topic) {
+		return ErrInvalidArgument
+	} else if policy.CacheDuration < 0 || policy.MessageLimit < 0 || policy.AttachmentFileSizeLimit < 0 {
+		return ErrInvalidArgument
+	}
+	cacheDuration := int64(policy.CacheDuration.Seconds())
+	if _, err := a.db.Exec(upsertTopicPolicyQuery, policy.Topic, username, cacheDuration, policy.MessageLimit, policy.AttachmentFileSizeLimit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveTopicPolicy deletes the retention and size policy for the given topic
+func (a *Manager) RemoveTopicPolicy(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.db.Exec(deleteTopicPolicyQuery, topic); err != nil {
+		return err
+	}
+	return nil
+}
+
+// TopicWebhooks returns the outgoing webhooks of the given topic, oldest first
+func (a *Manager) TopicWebhooks(topic string) ([]*TopicWebhook, error) {
+	rows, err := a.db.Query(selectTopicWebhooksQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := make([]*TopicWebhook, 0)
+	for rows.Next() {
+		var webhook TopicWebhook
+		var created int64
+		if err := rows.Scan(&webhook.ID, &webhook.Topic, &webhook.URL, &webhook.Format, &webhook.Secret, &created); err != nil {
+			return nil, err
+		}
+		webhook.Created = time.Unix(created, 0)
+		webhooks = append(webhooks, &webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// AddTopicWebhook adds an outgoing webhook to the given topic, and returns it, including its generated ID and
+// signing secret. The webhook is owned by the given user, and is removed when the user or the topic reservation
+// is removed. It returns ErrTooManyTopicWebhooks if the topic already has limit webhooks.
+func (a *Manager) AddTopicWebhook(username, topic, url string, format WebhookFormat, limit int) (*TopicWebhook, error) {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) || url == "" || !AllowedWebhookFormat(format) {
+		return nil, ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var count int
+	if err := tx.QueryRow(selectTopicWebhookCountQuery, topic).Scan(&count); err != nil {
+		return nil, err
+	} else if count >= limit {
+		return nil, ErrTooManyTopicWebhooks
+	}
+	webhook := &TopicWebhook{
+		ID:      util.RandomStringPrefix(webhookIDPrefix, webhookIDLength),
+		Topic:   topic,
+		URL:     url,
+		Format:  format,
+		Secret:  util.RandomStringPrefix(webhookSecretPrefix, webhookSecretLength),
+		Created: time.Unix(time.Now().Unix(), 0),
+	}
+	if _, err := tx.Exec(insertTopicWebhookQuery, webhook.ID, webhook.Topic, username, webhook.URL, webhook.Format, webhook.Secret, webhook.Created.Unix()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// RemoveTopicWebhook deletes the outgoing webhook with the given ID from the topic, including its delivery log.
+// It returns ErrTopicWebhookNotFound if the topic has no such webhook.
+func (a *Manager) RemoveTopicWebhook(topic, id string) error {
+	result, err := a.db.Exec(deleteTopicWebhookQuery, topic, id)
+	if err != nil {
+		return err
+	} else if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrTopicWebhookNotFound
+	}
+	return nil
+}
+
+// TopicSigningKeys returns the public keys that messages published to the given topic must be signed with,
+// oldest first. If a topic has no signing keys, unsigned messages are accepted.
+func (a *Manager) TopicSigningKeys(topic string) ([]*TopicSigningKey, error) {
+	rows, err := a.db.Query(selectTopicSigningKeysQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]*TopicSigningKey, 0)
+	for rows.Next() {
+		var key TopicSigningKey
+		var publicKey string
+		var created int64
+		if err := rows.Scan(&key.ID, &key.Topic, &publicKey, &created); err != nil {
+			return nil, err
+		}
+		key.PublicKey, err = base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.Created = time.Unix(created, 0)
+		keys = append(keys, &key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// AddTopicSigningKey registers an Ed25519 public key for the given topic, and returns it, including its generated ID.
+// Once a topic has a signing key, messages must be signed with one of its keys. The key is owned by the given user,
+// and is removed when the user or the topic reservation is removed. It returns ErrTooManyTopicSigningKeys if the
+// topic already has limit keys, and ErrTopicSigningKeyExists if the key is already registered for the topic.
+func (a *Manager) AddTopicSigningKey(username, topic string, publicKey ed25519.PublicKey, limit int) (*TopicSigningKey, error) {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) || len(publicKey) != ed25519.PublicKeySize {
+		return nil, ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var count int
+	if err := tx.QueryRow(selectTopicSigningKeyCountQuery, topic).Scan(&count); err != nil {
+		return nil, err
+	} else if count >= limit {
+		return nil, ErrTooManyTopicSigningKeys
+	}
+	key := &TopicSigningKey{
+		ID:        util.RandomStringPrefix(signingKeyIDPrefix, signingKeyIDLength),
+		Topic:     topic,
+		PublicKey: publicKey,
+		Created:   time.Unix(time.Now().Unix(), 0),
+	}
+	if _, err := tx.Exec(insertTopicSigningKeyQuery, key.ID, key.Topic, username, base64.StdEncoding.EncodeToString(key.PublicKey), key.Created.Unix()); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return nil, ErrTopicSigningKeyExists
+		}
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RemoveTopicSigningKey deletes the signing key with the given ID from the topic. It returns
+// ErrTopicSigningKeyNotFound if the topic has no such key.
+func (a *Manager) RemoveTopicSigningKey(topic, id string) error {
+	result, err := a.db.Exec(deleteTopicSigningKeyQuery, topic, id)
+	if err != nil {
+		return err
+	} else if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrTopicSigningKeyNotFound
+	}
+	return nil
+}
+
+// AddWebhookDelivery records the outcome of delivering a message to a webhook. Only the most recent
+// webhookDeliveriesLimit deliveries are kept per webhook.
+func (a *Manager) AddWebhookDelivery(delivery *WebhookDelivery) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(insertWebhookDeliveryQuery, delivery.WebhookID, delivery.MessageID, delivery.Time.Unix(), delivery.Attempts, delivery.StatusCode, delivery.Error); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(deleteOldWebhookDeliveriesQuery, delivery.WebhookID, delivery.WebhookID, webhookDeliveriesLimit); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// WebhookDeliveries returns the delivery log of the given webhook, most recent first
+func (a *Manager) WebhookDeliveries(webhookID string) ([]*WebhookDelivery, error) {
+	rows, err := a.db.Query(selectWebhookDeliveriesQuery, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]*WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var t int64
+		if err := rows.Scan(&delivery.WebhookID, &delivery.MessageID, &t, &delivery.Attempts, &delivery.StatusCode, &delivery.Error); err != nil {
+			return nil, err
+		}
+		delivery.Time = time.Unix(t, 0)
+		deliveries = append(deliveries, &delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// DefaultAccess returns the default read/write access if no access control entry matches
+func (a *Manager) DefaultAccess() Permission {
+	return a.config.DefaultAccess
+}
+
+// AddTier creates a new tier in the database
+func (a *Manager) AddTier(tier *Tier) error {
+	if tier.ID == "" {
+		tier.ID = util.RandomStringPrefix(tierIDPrefix, tierIDLength)
+	}
+	features, err := tierFeaturesJSON(tier.Features)
+	if err != nil {
+		return err
+	}
+	if _, err := a.db.Exec(insertTierQuery, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), features); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateTier updates a tier's properties in the database
+func (a *Manager) UpdateTier(tier *Tier) error {
+	features, err := tierFeaturesJSON(tier.Features)
+	if err != nil {
+		return err
+	}
+	if _, err := a.db.Exec(updateTierQuery, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), features, tier.Code); err != nil {
+		return err
+	}
+	return nil
+}
+
+// tierFeaturesJSON validates the feature flags of a tier, and returns them as JSON for the database
+func tierFeaturesJSON(features TierFeatures) (string, error) {
+	if len(features) == 0 {
+		return "{}", nil
+	}
+	for feature, value := range features {
+		if err := ValidateTierFeature(feature, value); err != nil {
+			return "", err
+		}
+	}
+	b, err := json.Marshal(features)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// RemoveTier deletes the tier with the given code
+func (a *Manager) RemoveTier(code string) error {
+	if !AllowedTier(code) {
+		return ErrInvalidArgument
+	}
+	// This fails if any user has this tier
+	if _, err := a.db.Exec(deleteTierQuery, code); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeBilling updates a user's billing fields, namely the Stripe customer ID, and subscription information
+func (a *Manager) ChangeBilling(username string, billing *Billing) error {
+	if _, err := a.db.Exec(updateBillingQuery, nullString(billing.StripeCustomerID), nullString(billing.StripeSubscriptionID), nullString(string(billing.StripeSubscriptionStatus)), nullString(string(billing.StripeSubscriptionInterval)), nullInt64(billing.StripeSubscriptionPaidUntil.Unix()), nullInt64(billing.StripeSubscriptionCancelAt.Unix()), username); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeBillingPaymentGrace sets the end of the grace period after a failed payment for the given user. Until
+// then, the user keeps their tier. Passing a zero time ends the grace period.
+func (a *Manager) ChangeBillingPaymentGrace(username string, until time.Time) error {
+	var graceUntil sql.NullInt64
+	if !until.IsZero() {
+		graceUntil = sql.NullInt64{Int64: until.Unix(), Valid: true}
+	}
+	if _, err := a.db.Exec(updateBillingPaymentGraceQuery, graceUntil, username); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UsersWithExpiredPaymentGrace returns all users whose grace period after a failed payment has ended
+func (a *Manager) UsersWithExpiredPaymentGrace() ([]*User, error) {
+	rows, err := a.db.Query(selectUsernamesWithExpiredPaymentGraceQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	usernames := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	users := make([]*User, 0)
+	for _, username := range usernames {
+		u, err := a.User(username)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// Tiers returns a list of all Tier structs
+func (a *Manager) Tiers() ([]*Tier, error) {
+	rows, err := a.db.Query(selectTiersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tiers := make([]*Tier, 0)
+	for {
+		tier, err := a.readTier(rows)
+		if errors.Is(err, ErrTierNotFound) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// Tier returns a Tier based on the code, or ErrTierNotFound if it does not exist
+func (a *Manager) Tier(code string) (*Tier, error) {
+	rows, err := a.db.Query(selectTierByCodeQuery, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTier(rows)
+}
+
+// TierByStripePrice returns a Tier based on the Stripe price ID, or ErrTierNotFound if it does not exist
+func (a *Manager) TierByStripePrice(priceID string) (*Tier, error) {
+	rows, err := a.db.Query(selectTierByPriceIDQuery, priceID, priceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTier(rows)
+}
+
+func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
+	var id, code, name, features string
+	var stripeMonthlyPriceID, stripeYearlyPriceID sql.NullString
+	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit sql.NullInt64
+	if !rows.Next() {
+		return nil, ErrTierNotFound
+	}
+	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID, &features); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	tierFeatures, err := readTierFeatures(features)
+	if err != nil {
+		return nil, err
+	}
+	// When changed, note readUser() as well
+	return &Tier{
+		ID:                       id,
+		Code:                     code,
+		Name:                     name,
+		MessageLimit:             messagesLimit.Int64,
+		MessageExpiryDuration:    time.Duration(messagesExpiryDuration.Int64) * time.Second,
+		EmailLimit:               emailsLimit.Int64,
+		CallLimit:                callsLimit.Int64,
+		ReservationLimit:         reservationsLimit.Int64,
+		AttachmentFileSizeLimit:  attachmentFileSizeLimit.Int64,
+		AttachmentTotalSizeLimit: attachmentTotalSizeLimit.Int64,
+		AttachmentExpiryDuration: time.Duration(attachmentExpiryDuration.Int64) * time.Second,
+		AttachmentBandwidthLimit: attachmentBandwidthLimit.Int64,
+		StripeMonthlyPriceID:     stripeMonthlyPriceID.String, // May be empty
+		StripeYearlyPriceID:      stripeYearlyPriceID.String,  // May be empty
+		Features:                 tierFeatures,
+	}, nil
+}
+
+func readTierFeatures(s string) (TierFeatures, error) {
+	features := make(TierFeatures)
+	if s == "" {
+		return features, nil
+	}
+	if err := json.Unmarshal([]byte(s), &features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+// Close closes the underlying database
+func (a *Manager) Close() error {
+	return a.db.Close()
+}
+
+// Reload replaces the default access, as well as the provisioned users, access control entries and tokens, and
+// provisions them again, e.g. after the server config was reloaded. The rest of the config cannot be changed.
+func (a *Manager) Reload(defaultAccess Permission, users []*User, access map[string][]*Grant, tokens map[string][]*Token) error {
+	a.mu.Lock()
+	config := *a.config
+	config.DefaultAccess = defaultAccess
+	config.Users = users
+	config.Access = access
+	config.Tokens = tokens
+	a.config = &config
+	a.mu.Unlock()
+	return a.maybeProvisionUsersAccessAndTokens()
+}
+
+// maybeProvisionUsersAccessAndTokens provisions users, access control entries, and tokens based on the config.
+func (a *Manager) maybeProvisionUsersAccessAndTokens() error {
+	if !a.config.ProvisionEnabled {
+		return nil
+	}
+	existingUsers, err := a.Users()
+	if err != nil {
+		return err
+	}
+	provisionUsernames := util.Map(a.config.Users, func(u *User) string {
+		return u.Name
+	})
+	return execTx(a.db, func(tx *sql.Tx) error {
+		if err := a.maybeProvisionUsers(tx, provisionUsernames, existingUsers); err != nil {
+			return fmt.Errorf("failed to provision users: %v", err)
+		}
+		if err := a.maybeProvisionGrants(tx); err != nil {
+			return fmt.Errorf("failed to provision grants: %v", err)
+		}
+		if err := a.maybeProvisionTokens(tx, provisionUsernames); err != nil {
+			return fmt.Errorf("failed to provision tokens: %v", err)
+		}
+		if err := a.maybeReportOrPruneProvisionDrift(tx); err != nil {
+			return fmt.Errorf("failed to check for provisioning drift: %v", err)
+		}
+		return nil
+	})
+}
+
+// ProvisionDrift returns the users, access control entries and tokens in the database that are not defined
+// in the config, e.g. because they were added with "ntfy user" or "ntfy access". It returns an empty result
+// if no users, access entries or tokens are provisioned at all, since then the config is not declarative.
+func (a *Manager) ProvisionDrift() (*ProvisionDrift, error) {
+	return queryTx(a.db, func(tx *sql.Tx) (*ProvisionDrift, error) {
+		return a.provisionDriftTx(tx)
+	})
+}
+
+// maybeReportOrPruneProvisionDrift logs entries that are not defined in the config, and removes them
+// if ProvisionPrune is set. Provisioned entries that were removed from the config are already removed
+// by maybeProvisionUsers, maybeProvisionGrants and maybeProvisionTokens.
+func (a *Manager) maybeReportOrPruneProvisionDrift(tx *sql.Tx) error {
+	drift, err := a.provisionDriftTx(tx)
+	if err != nil {
+		return err
+	} else if drift.Empty() {
+		return nil
+	}
+	action := "keeping"
+	if a.config.ProvisionPrune {
+		action = "removing"
+	}
+	for _, username := range drift.Users {
+		log.Tag(tag).Warn("User %s is not defined in the config, %s it", username, action)
+	}
+	for username, grants := range drift.Access {
+		for _, grant := range grants {
+			log.Tag(tag).Warn("Access entry %s for user %s (%s) is not defined in the config, %s it", grant.TopicPattern, username, grant.Permission, action)
+		}
+	}
+	for username, tokens := range drift.Tokens {
+		for _, token := range tokens {
+			log.Tag(tag).Warn("Token with label '%s' for user %s is not defined in the config, %s it", token.Label, username, action)
+		}
+	}
+	if !a.config.ProvisionPrune {
+		return nil
+	}
+	for _, username := range drift.Users {
+		if err := a.removeUserTx(tx, username); err != nil {
+			return fmt.Errorf("failed to remove user %s: %v", username, err)
+		}
+	}
+	if _, err := tx.Exec(deleteUserAccessUnprovisionedQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(deleteUnprovisionedTokens); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *Manager) provisionDriftTx(tx *sql.Tx) (*ProvisionDrift, error) {
+	drift := &ProvisionDrift{
+		Users:  make([]string, 0),
+		Access: make(map[string][]*Grant),
+		Tokens: make(map[string][]*Token),
+	}
+	if len(a.config.Users) == 0 && len(a.config.Access) == 0 && len(a.config.Tokens) == 0 {
+		return drift, nil
+	}
+	rows, err := tx.Query(selectUnprovisionedUsersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		drift.Users = append(drift.Users, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	accessRows, err := tx.Query(selectUnprovisionedAccessQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer accessRows.Close()
+	for accessRows.Next() {
+		var username, topic string
+		var read, write bool
+		if err := accessRows.Scan(&username, &topic, &read, &write); err != nil {
+			return nil, err
+		}
+		drift.Access[username] = append(drift.Access[username], &Grant{
+			TopicPattern: fromSQLWildcard(topic),
+			Permission:   NewPermission(read, write),
+		})
+	}
+	if err := accessRows.Err(); err != nil {
+		return nil, err
+	}
+	tokenRows, err := tx.Query(selectUnprovisionedTokensQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer tokenRows.Close()
+	for tokenRows.Next() {
+		var username, token, label string
+		if err := tokenRows.Scan(&username, &token, &label); err != nil {
+			return nil, err
+		}
+		drift.Tokens[username] = append(drift.Tokens[username], &Token{
+			Value: token,
+			Label: label,
+		})
+	}
+	return drift, tokenRows.Err()
+}
+
+// maybeProvisionUsers checks if the users in the config are provisioned, and adds or updates them.
+// It also removes users that are provisioned, but not in the config anymore.
+func (a *Manager) maybeProvisionUsers(tx *sql.Tx, provisionUsernames []string, existingUsers []*User) error {
+	// Remove users that are provisioned, but not in the config anymore
+	for _, user := range existingUsers {
+		if user.Name == Everyone {
+			continue
+		} else if user.Provisioned && !util.Contains(provisionUsernames, user.Name) {
+			if err := a.removeUserTx(tx, user.Name); err != nil {
+				return fmt.Errorf("failed to remove provisioned user %s: %v", user.Name, err)
+			}
+		}
+	}
+	// Add or update provisioned users
+	for _, user := range a.config.Users {
+		if user.Name == Everyone {
+			continue
+		}
+		existingUser, exists := util.Find(existingUsers, func(u *User) bool {
+			return u.Name == user.Name
+		})
+		if !exists {
+			if err := a.addUserTx(tx, user.Name, user.Hash, user.Role, true, true); err != nil && !errors.Is(err, ErrUserExists) {
+				return fmt.Errorf("failed to add provisioned user %s: %v", user.Name, err)
+			}
+		} else {
+			if !existingUser.Provisioned {
+				if err := a.changeProvisionedTx(tx, user.Name, true); err != nil {
+					return fmt.Errorf("failed to change provisioned status for user %s: %v", user.Name, err)
+				}
+			}
+			if existingUser.Hash != user.Hash {
+				if err := a.changePasswordTx(tx, user.Name, user.Hash, true); err != nil {
+					return fmt.Errorf("failed to change password for provisioned user %s: %v", user.Name, err)
+				}
+			}
+			if existingUser.Role != user.Role {
+				if err := a.changeRoleTx(tx, user.Name, user.Role); err != nil {
+					return fmt.Errorf("failed to change role for provisioned user %s: %v", user.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// maybyProvisionGrants removes all provisioned grants, and (re-)adds the grants from the config.
+//
+// Unlike users and tokens, grants can be just re-added, because they do not carry any state (such as last
+// access time) or do not have dependent resources (such as grants or tokens).
+func (a *Manager) maybeProvisionGrants(tx *sql.Tx) error {
+	// Remove all provisioned grants
+	if _, err := tx.Exec(deleteUserAccessProvisionedQuery); err != nil {
+		return err
+	}
+	// (Re-)add provisioned grants
+	for username, grants := range a.config.Access {
+		user, exists := util.Find(a.config.Users, func(u *User) bool {
+			return u.Name == username
+		})
+		if !exists && username != Everyone {
+			return fmt.Errorf("user %s is not a provisioned user, refusing to add ACL entry", username)
+		} else if user != nil && user.Role == RoleAdmin {
+			return fmt.Errorf("adding access control entries is not allowed for admin roles for user %s", username)
+		}
+		for _, grant := range grants {
+			if err := a.resetAccessTx(tx, username, grant.TopicPattern); err != nil {
+				return fmt.Errorf("failed to reset access for user %s and topic %s: %v", username, grant.TopicPattern, err)
+			}
+			if err := a.allowAccessTx(tx, username, grant.TopicPattern, grant.Permission, grant.Window, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Manager) maybeProvisionTokens(tx *sql.Tx, provisionUsernames []string) error {
+	// Remove tokens that are provisioned, but not in the config anymore
+	existingTokens, err := a.allProvisionedTokens()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve existing provisioned tokens: %v", err)
+	}
+	var provisionTokens []string
+	for _, userTokens := range a.config.Tokens {
+		for _, token := range userTokens {
+			provisionTokens = append(provisionTokens, token.Value)
+		}
+	}
+	for _, existingToken := range existingTokens {
+		if !slices.Contains(provisionTokens, existingToken.Value) {
+			if _, err := tx.Exec(deleteProvisionedTokenQuery, existingToken.Value); err != nil {
+				return fmt.Errorf("failed to remove provisioned token %s: %v", existingToken.Value, err)
+			}
+		}
+	}
+	// (Re-)add provisioned tokens
+	for username, tokens := range a.config.Tokens {
+		if !slices.Contains(provisionUsernames, username) && username != Everyone {
+			return fmt.Errorf("user %s is not a provisioned user, refusing to add tokens", username)
+		}
+		var userID string
+		row := tx.QueryRow(selectUserIDFromUsernameQuery, username)
+		if err := row.Scan(&userID); err != nil {
+			return fmt.Errorf("failed to find provisioned user %s for provisioned tokens", username)
+		}
+		for _, token := range tokens {
+			if _, err := a.createTokenTx(tx, userID, token.Value, token.Label, time.Unix(0, 0), netip.IPv4Unspecified(), true, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// toSQLWildcard converts a wildcard string to a SQL wildcard string. It only allows '*' as wildcards,
+// and escapes '_', assuming '\' as escape character.
+func toSQLWildcard(s string) string {
+	return escapeUnderscore(strings.ReplaceAll(s, "*", "%"))
+}
+
+// fromSQLWildcard converts a SQL wildcard string to a wildcard string. It converts '%' to '*',
+// and removes the '\_' escape character.
+func fromSQLWildcard(s string) string {
+	return strings.ReplaceAll(unescapeUnderscore(s), "%", "*")
+}
+
+func escapeUnderscore(s string) string {
+	return strings.ReplaceAll(s, "_", "\\_")
+}
+
+func unescapeUnderscore(s string) string {
+	return strings.ReplaceAll(s, "\\_", "_")
+}
+
+func runStartupQueries(db *sql.DB, startupQueries string) error {
+	if _, err := db.Exec(startupQueries); err != nil {
+		return err
+	}
+	if _, err := db.Exec(builtinStartupQueries); err != nil {
+		return err
+	}
+	return nil
+}
+
+func setupDB(db *sql.DB) error {
+	// If 'schemaVersion' table does not exist, this must be a new database
+	rowsSV, err := db.Query(selectSchemaVersionQuery)
+	if err != nil {
+		return setupNewDB(db)
+	}
+	defer rowsSV.Close()
+
+	// If 'schemaVersion' table exists, read version and potentially upgrade
+	schemaVersion := 0
+	if !rowsSV.Next() {
+		return errors.New("cannot determine schema version: database file may be corrupt")
+	}
+	if err := rowsSV.Scan(&schemaVersion); err != nil {
+		return err
+	}
+	rowsSV.Close()
+
+	// Do migrations
+	if schemaVersion == currentSchemaVersion {
+		return nil
+	} else if schemaVersion > currentSchemaVersion {
+		return fmt.Errorf("unexpected schema version: version %d is higher than current version %d", schemaVersion, currentSchemaVersion)
+	}
+	for i := schemaVersion; i < currentSchemaVersion; i++ {
+		fn, ok := migrations[i]
+		if !ok {
+			return fmt.Errorf("cannot find migration step from schema version %d to %d", i, i+1)
+		} else if err := fn(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setupNewDB(db *sql.DB) error {
+	if _, err := db.Exec(createTablesQueries); err != nil {
+		return err
+	}
+	if _, err := db.Exec(insertSchemaVersion, currentSchemaVersion); err != nil {
+		return err
+	}
+	return nil
+}
+
+func migrateFrom1(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 1 to 2")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Rename user -> user_old, and create new tables
+	if _, err := tx.Exec(migrate1To2CreateTablesQueries); err != nil {
+		return err
+	}
+	// Insert users from user_old into new user table, with ID and sync_topic
+	rows, err := tx.Query(migrate1To2SelectAllOldUsernamesNoTx)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	usernames := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return err
+		}
+		usernames = append(usernames, username)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		userID := util.RandomStringPrefix(userIDPrefix, userIDLength)
+		syncTopic := util.RandomStringPrefix(syncTopicPrefix, syncTopicLength)
+		if _, err := tx.Exec(migrate1To2InsertUserNoTx, userID, syncTopic, username); err != nil {
+			return err
+		}
+	}
+	// Migrate old "access" table to "user_access" and drop "access" and "user_old"
+	if _, err := tx.Exec(migrate1To2InsertFromOldTablesAndDropNoTx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 2); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func migrateFrom2(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 2 to 3")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate2To3UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 3); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom3(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 3 to 4")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate3To4UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 4); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom4(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 4 to 5")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate4To5UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 5); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom5(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 5 to 6")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate5To6UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 6); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom6(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 6 to 7")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate6To7UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 7); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom7(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 7 to 8")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate7To8UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 8); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom8(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 8 to 9")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate8To9UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 9); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom9(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 9 to 10")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate9To10UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 10); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom10(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 10 to 11")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate10To11UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 11); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom11(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 11 to 12")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
 	}
-	return nil
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate11To12UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 12); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// maybyProvisionGrants removes all provisioned grants, and (re-)adds the grants from the config.
-//
-// Unlike users and tokens, grants can be just re-added, because they do not carry any state (such as last
-// access time) or do not have dependent resources (such as grants or tokens).
-func (a *Manager) maybeProvisionGrants(tx *sql.Tx) error {
-	// Remove all provisioned grants
-	if _, err := tx.Exec(deleteUserAccessProvisionedQuery); err != nil {
+func migrateFrom12(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 12 to 13")
+	tx, err := db.Begin()
+	if err != nil {
 		return err
 	}
-	// (Re-)add provisioned grants
-	for username, grants := range a.config.Access {
-		user, exists := util.Find(a.config.Users, func(u *User) bool {
-			return u.Name == username
-		})
-		if !exists && username != Everyone {
-			return fmt.Errorf("user %s is not a provisioned user, refusing to add ACL entry", username)
-		} else if user != nil && user.Role == RoleAdmin {
-			return fmt.Errorf("adding access control entries is not allowed for admin roles for user %s", username)
-		}
-		for _, grant := range grants {
-			if err := a.resetAccessTx(tx, username, grant.TopicPattern); err != nil {
-				return fmt.Errorf("failed to reset access for user %s and topic %s: %v", username, grant.TopicPattern, err)
-			}
-			if err := a.allowAccessTx(tx, username, grant.TopicPattern, grant.Permission, true); err != nil {
-				return err
-			}
-		}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate12To13UpdateQueries); err != nil {
+		return err
 	}
-	return nil
+	if _, err := tx.Exec(updateSchemaVersion, 13); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (a *Manager) maybeProvisionTokens(tx *sql.Tx, provisionUsernames []string) error {
-	// Remove tokens that are provisioned, but not in the config anymore
-	existingTokens, err := a.allProvisionedTokens()
+func migrateFrom13(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 13 to 14")
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to retrieve existing provisioned tokens: %v", err)
-	}
-	var provisionTokens []string
-	for _, userTokens := range a.config.Tokens {
-		for _, token := range userTokens {
-			provisionTokens = append(provisionTokens, token.Value)
-		}
+		return err
 	}
-	for _, existingToken := range existingTokens {
-		if !slices.Contains(provisionTokens, existingToken.Value) {
-			if _, err := tx.Exec(deleteProvisionedTokenQuery, existingToken.Value); err != nil {
-				return fmt.Errorf("failed to remove provisioned token %s: %v", existingToken.Value, err)
-			}
-		}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate13To14UpdateQueries); err != nil {
+		return err
 	}
-	// (Re-)add provisioned tokens
-	for username, tokens := range a.config.Tokens {
-		if !slices.Contains(provisionUsernames, username) && username != Everyone {
-			return fmt.Errorf("user %s is not a provisioned user, refusing to add tokens", username)
-		}
-		var userID string
-		row := tx.QueryRow(selectUserIDFromUsernameQuery, username)
-		if err := row.Scan(&userID); err != nil {
-			return fmt.Errorf("failed to find provisioned user %s for provisioned tokens", username)
-		}
-		for _, token := range tokens {
-			if _, err := a.createTokenTx(tx, userID, token.Value, token.Label, time.Unix(0, 0), netip.IPv4Unspecified(), true); err != nil {
-				return err
-			}
-		}
+	if _, err := tx.Exec(updateSchemaVersion, 14); err != nil {
+		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-// toSQLWildcard converts a wildcard string to a SQL wildcard string. It only allows '*' as wildcards,
-// and escapes '_', assuming '\' as escape character.
-func toSQLWildcard(s string) string {
-	return escapeUnderscore(strings.ReplaceAll(s, "*", "%"))
+func migrateFrom14(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 14 to 15")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate14To15UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 15); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// fromSQLWildcard converts a SQL wildcard string to a wildcard string. It converts '%' to '*',
-// and removes the '\_' escape character.
-func fromSQLWildcard(s string) string {
-	return strings.ReplaceAll(unescapeUnderscore(s), "%", "*")
+func migrateFrom15(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 15 to 16")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate15To16UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 16); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func escapeUnderscore(s string) string {
-	return strings.ReplaceAll(s, "_", "\\_")
+func migrateFrom16(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 16 to 17")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate16To17UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 17); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func unescapeUnderscore(s string) string {
-	return strings.ReplaceAll(s, "\\_", "_")
+func migrateFrom17(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 17 to 18")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate17To18UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 18); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func runStartupQueries(db *sql.DB, startupQueries string) error {
-	if _, err := db.Exec(startupQueries); err != nil {
+func migrateFrom18(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 18 to 19")
+	tx, err := db.Begin()
+	if err != nil {
 		return err
 	}
-	if _, err := db.Exec(builtinStartupQueries); err != nil {
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate18To19UpdateQueries); err != nil {
 		return err
 	}
-	return nil
+	if _, err := tx.Exec(updateSchemaVersion, 19); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func setupDB(db *sql.DB) error {
-	// If 'schemaVersion' table does not exist, this must be a new database
-	rowsSV, err := db.Query(selectSchemaVersionQuery)
+func migrateFrom19(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 19 to 20")
+	tx, err := db.Begin()
 	if err != nil {
-		return setupNewDB(db)
+		return err
 	}
-	defer rowsSV.Close()
-
-	// If 'schemaVersion' table exists, read version and potentially upgrade
-	schemaVersion := 0
-	if !rowsSV.Next() {
-		return errors.New("cannot determine schema version: database file may be corrupt")
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate19To20UpdateQueries); err != nil {
+		return err
 	}
-	if err := rowsSV.Scan(&schemaVersion); err != nil {
+	if _, err := tx.Exec(updateSchemaVersion, 20); err != nil {
 		return err
 	}
-	rowsSV.Close()
+	return tx.Commit()
+}
 
-	// Do migrations
-	if schemaVersion == currentSchemaVersion {
-		return nil
-	} else if schemaVersion > currentSchemaVersion {
-		return fmt.Errorf("unexpected schema version: version %d is higher than current version %d", schemaVersion, currentSchemaVersion)
+func migrateFrom20(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 20 to 21")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
 	}
-	for i := schemaVersion; i < currentSchemaVersion; i++ {
-		fn, ok := migrations[i]
-		if !ok {
-			return fmt.Errorf("cannot find migration step from schema version %d to %d", i, i+1)
-		} else if err := fn(db); err != nil {
-			return err
-		}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate20To21UpdateQueries); err != nil {
+		return err
 	}
-	return nil
+	if _, err := tx.Exec(updateSchemaVersion, 21); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func setupNewDB(db *sql.DB) error {
-	if _, err := db.Exec(createTablesQueries); err != nil {
+func migrateFrom21(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 21 to 22")
+	tx, err := db.Begin()
+	if err != nil {
 		return err
 	}
-	if _, err := db.Exec(insertSchemaVersion, currentSchemaVersion); err != nil {
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate21To22UpdateQueries); err != nil {
 		return err
 	}
-	return nil
+	if _, err := tx.Exec(updateSchemaVersion, 22); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func migrateFrom1(db *sql.DB) error {
-	log.Tag(tag).Info("Migrating user database schema: from 1 to 2")
+func migrateFrom22(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 22 to 23")
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	// Rename user -> user_old, and create new tables
-	if _, err := tx.Exec(migrate1To2CreateTablesQueries); err != nil {
+	if _, err := tx.Exec(migrate22To23UpdateQueries); err != nil {
 		return err
 	}
-	// Insert users from user_old into new user table, with ID and sync_topic
-	rows, err := tx.Query(migrate1To2SelectAllOldUsernamesNoTx)
+	if _, err := tx.Exec(updateSchemaVersion, 23); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom23(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 23 to 24")
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	usernames := make([]string, 0)
-	for rows.Next() {
-		var username string
-		if err := rows.Scan(&username); err != nil {
-			return err
-		}
-		usernames = append(usernames, username)
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate23To24UpdateQueries); err != nil {
+		return err
 	}
-	if err := rows.Close(); err != nil {
+	if _, err := tx.Exec(updateSchemaVersion, 24); err != nil {
 		return err
 	}
-	for _, username := range usernames {
-		userID := util.RandomStringPrefix(userIDPrefix, userIDLength)
-		syncTopic := util.RandomStringPrefix(syncTopicPrefix, syncTopicLength)
-		if _, err := tx.Exec(migrate1To2InsertUserNoTx, userID, syncTopic, username); err != nil {
-			return err
-		}
+	return tx.Commit()
+}
+
+func migrateFrom24(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 24 to 25")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
 	}
-	// Migrate old "access" table to "user_access" and drop "access" and "user_old"
-	if _, err := tx.Exec(migrate1To2InsertFromOldTablesAndDropNoTx); err != nil {
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate24To25UpdateQueries); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(updateSchemaVersion, 2); err != nil {
+	if _, err := tx.Exec(updateSchemaVersion, 25); err != nil {
 		return err
 	}
-	if err := tx.Commit(); err != nil {
+	return tx.Commit()
+}
+
+func migrateFrom25(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 25 to 26")
+	tx, err := db.Begin()
+	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate25To26UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 26); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func migrateFrom2(db *sql.DB) error {
-	log.Tag(tag).Info("Migrating user database schema: from 2 to 3")
+func migrateFrom26(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 26 to 27")
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(migrate2To3UpdateQueries); err != nil {
+	if _, err := tx.Exec(migrate26To27UpdateQueries); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(updateSchemaVersion, 3); err != nil {
+	if _, err := tx.Exec(updateSchemaVersion, 27); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func migrateFrom3(db *sql.DB) error {
-	log.Tag(tag).Info("Migrating user database schema: from 3 to 4")
+func migrateFrom27(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 27 to 28")
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(migrate3To4UpdateQueries); err != nil {
+	if _, err := tx.Exec(migrate27To28UpdateQueries); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(updateSchemaVersion, 4); err != nil {
+	if _, err := tx.Exec(updateSchemaVersion, 28); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func migrateFrom4(db *sql.DB) error {
-	log.Tag(tag).Info("Migrating user database schema: from 4 to 5")
+func migrateFrom28(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 28 to 29")
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(migrate4To5UpdateQueries); err != nil {
+	if _, err := tx.Exec(migrate28To29UpdateQueries); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(updateSchemaVersion, 5); err != nil {
+	if _, err := tx.Exec(updateSchemaVersion, 29); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-func migrateFrom5(db *sql.DB) error {
-	log.Tag(tag).Info("Migrating user database schema: from 5 to 6")
+func migrateFrom29(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 29 to 30")
 	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(migrate5To6UpdateQueries); err != nil {
+	if _, err := tx.Exec(migrate29To30UpdateQueries); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(updateSchemaVersion, 6); err != nil {
+	if _, err := tx.Exec(updateSchemaVersion, 30); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -1,6 +1,7 @@
 package user
 
 import (
+	"crypto/ed25519"
 	"database/sql"
 	"fmt"
 	"github.com/stretchr/testify/require"
@@ -51,10 +52,10 @@ func TestManager_FullScenario_Default_DenyAll(t *testing.T) {
 	benGrants, err := a.Grants("ben")
 	require.Nil(t, err)
 	require.Equal(t, []Grant{
-		{"everyonewrite", PermissionDenyAll, false},
-		{"mytopic", PermissionReadWrite, false},
-		{"writeme", PermissionWrite, false},
-		{"readme", PermissionRead, false},
+		{"everyonewrite", PermissionDenyAll, false, nil},
+		{"mytopic", PermissionReadWrite, false, nil},
+		{"writeme", PermissionWrite, false, nil},
+		{"readme", PermissionRead, false, nil},
 	}, benGrants)
 
 	john, err := a.Authenticate("john", "john")
@@ -66,10 +67,10 @@ func TestManager_FullScenario_Default_DenyAll(t *testing.T) {
 	johnGrants, err := a.Grants("john")
 	require.Nil(t, err)
 	require.Equal(t, []Grant{
-		{"mytopic_deny*", PermissionDenyAll, false},
-		{"mytopic_ro*", PermissionRead, false},
-		{"mytopic*", PermissionReadWrite, false},
-		{"*", PermissionRead, false},
+		{"mytopic_deny*", PermissionDenyAll, false, nil},
+		{"mytopic_ro*", PermissionRead, false, nil},
+		{"mytopic*", PermissionReadWrite, false, nil},
+		{"*", PermissionRead, false, nil},
 	}, johnGrants)
 
 	notben, err := a.Authenticate("ben", "this is wrong")
@@ -144,6 +145,185 @@ func TestManager_Access_Order_LengthWriteRead(t *testing.T) {
 	require.Nil(t, a.Authorize(ben, "test123", PermissionWrite))
 }
 
+func TestManager_Access_Window(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AllowAccessWindow("ben", "deploy-*", PermissionReadWrite, &GrantWindow{Expires: time.Now().Add(time.Hour)}))
+	require.Nil(t, a.AllowAccessWindow("ben", "old-*", PermissionReadWrite, &GrantWindow{Expires: time.Now().Add(-time.Hour)}))
+	require.Nil(t, a.AllowAccess("ben", "oncall*", PermissionRead))
+	require.Nil(t, a.AllowAccessWindow("ben", "oncall-alerts", PermissionReadWrite, &GrantWindow{Hours: outsideHours(time.Now()), Timezone: "UTC"}))
+	require.Equal(t, ErrInvalidHours, a.AllowAccessWindow("ben", "x", PermissionRead, &GrantWindow{Hours: "9-5"}))
+	require.Equal(t, ErrInvalidTimezone, a.AllowAccessWindow("ben", "x", PermissionRead, &GrantWindow{Timezone: "Nowhere/Land"}))
+
+	ben, err := a.Authenticate("ben", "ben")
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(ben, "deploy-prod", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "old-prod", PermissionRead))
+	require.Nil(t, a.Authorize(ben, "oncall-alerts", PermissionRead)) // Falls through to less specific "oncall*"
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "oncall-alerts", PermissionWrite))
+
+	grants, err := a.Grants("ben")
+	require.Nil(t, err)
+	require.Equal(t, 4, len(grants))
+	for _, grant := range grants {
+		switch grant.TopicPattern {
+		case "oncall*":
+			require.Nil(t, grant.Window)
+		case "oncall-alerts":
+			require.Equal(t, "UTC", grant.Window.Timezone)
+			require.True(t, grant.Window.Expires.IsZero())
+		default:
+			require.False(t, grant.Window.Expires.IsZero())
+		}
+	}
+
+	require.Nil(t, a.RemoveExpiredGrants())
+	grants, err = a.Grants("ben")
+	require.Nil(t, err)
+	require.Equal(t, 3, len(grants))
+}
+
+func TestManager_Explain(t *testing.T) {
+	a := newTestManager(t, PermissionRead)
+	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AllowAccess(Everyone, "announcements", PermissionRead))
+	require.Nil(t, a.AllowAccess("ben", "announce*", PermissionReadWrite))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	decision, err := a.Explain(phil, "announcements", PermissionWrite)
+	require.Nil(t, err)
+	require.Equal(t, &Decision{Allowed: true, Rule: DecisionRuleAdmin}, decision)
+
+	decision, err = a.Explain(ben, "announcements", PermissionWrite)
+	require.Nil(t, err)
+	require.True(t, decision.Allowed)
+	require.Equal(t, DecisionRuleUser, decision.Rule)
+	require.Equal(t, "announce*", decision.Entry.TopicPattern)
+
+	decision, err = a.Explain(nil, "announcements", PermissionWrite)
+	require.Nil(t, err)
+	require.False(t, decision.Allowed)
+	require.Equal(t, DecisionRuleEveryone, decision.Rule)
+	require.Equal(t, Everyone, decision.Entry.Username)
+
+	decision, err = a.Explain(nil, "mytopic", PermissionRead)
+	require.Nil(t, err)
+	require.False(t, decision.Allowed)
+	require.Equal(t, DecisionRuleReservation, decision.Rule)
+	require.Equal(t, "ben", decision.Entry.Owner)
+
+	decision, err = a.Explain(nil, "other", PermissionRead)
+	require.Nil(t, err)
+	require.Equal(t, &Decision{Allowed: true, Rule: DecisionRuleDefault}, decision)
+}
+
+func TestManager_Groups(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddUser("emma", "emma", RoleUser, false))
+	require.Nil(t, a.AddGroup("devops"))
+	require.Nil(t, a.AddGroup("support"))
+	require.Equal(t, ErrGroupExists, a.AddGroup("devops"))
+	require.Equal(t, ErrInvalidArgument, a.AddGroup("dev ops"))
+
+	require.Nil(t, a.AddGroupMember("devops", "phil"))
+	require.Nil(t, a.AddGroupMember("devops", "ben"))
+	require.Nil(t, a.AddGroupMember("devops", "ben")) // Already a member
+	require.Nil(t, a.AddGroupMember("support", "ben"))
+	require.Equal(t, ErrGroupNotFound, a.AddGroupMember("nope", "ben"))
+	require.Equal(t, ErrUserNotFound, a.AddGroupMember("devops", "nope"))
+
+	groups, err := a.Groups()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(groups))
+	require.Equal(t, "devops", groups[0].Name)
+	require.Equal(t, []string{"ben", "phil"}, groups[0].Members)
+	require.Equal(t, "support", groups[1].Name)
+	require.Equal(t, []string{"ben"}, groups[1].Members)
+
+	userGroups, err := a.UserGroups("ben")
+	require.Nil(t, err)
+	require.Equal(t, []string{"devops", "support"}, userGroups)
+
+	require.Nil(t, a.RemoveGroupMember("devops", "phil"))
+	group, err := a.Group("devops")
+	require.Nil(t, err)
+	require.Equal(t, []string{"ben"}, group.Members)
+
+	// Removing a user removes the memberships, removing a group removes its members and grants
+	require.Nil(t, a.AllowGroupAccess("support", "tickets", PermissionRead))
+	require.Nil(t, a.RemoveUser("ben"))
+	group, err = a.Group("support")
+	require.Nil(t, err)
+	require.Empty(t, group.Members)
+	require.Nil(t, a.RemoveGroup("support"))
+	_, err = a.Group("support")
+	require.Equal(t, ErrGroupNotFound, err)
+	require.Equal(t, ErrGroupNotFound, a.RemoveGroup("support"))
+	grants, err := a.GroupGrants("support")
+	require.Nil(t, err)
+	require.Empty(t, grants)
+}
+
+func TestManager_GroupAccess(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddUser("emma", "emma", RoleUser, false))
+	require.Nil(t, a.AddGroup("devops"))
+	require.Nil(t, a.AddGroupMember("devops", "phil"))
+	require.Nil(t, a.AddGroupMember("devops", "ben"))
+	require.Nil(t, a.AllowGroupAccess("devops", "alerts-*", PermissionReadWrite))
+	require.Nil(t, a.AllowGroupAccess("devops", "alerts-billing", PermissionRead))
+	require.Nil(t, a.AllowAccess("ben", "alerts-*", PermissionDenyAll))           // User entries win over group entries
+	require.Nil(t, a.AllowAccess(Everyone, "alerts-public", PermissionReadWrite)) // Group entries win over everyone entries
+	require.Equal(t, ErrInvalidArgument, a.AllowGroupAccess("devops", "alerts/*", PermissionRead))
+	require.Equal(t, ErrGroupNotFound, a.AllowGroupAccess("nope", "alerts-*", PermissionRead))
+
+	phil, _ := a.User("phil")
+	ben, _ := a.User("ben")
+	emma, _ := a.User("emma")
+
+	require.Nil(t, a.Authorize(phil, "alerts-prod", PermissionWrite))
+	require.Nil(t, a.Authorize(phil, "alerts-billing", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "alerts-billing", PermissionWrite))
+	require.Nil(t, a.Authorize(phil, "alerts-public", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "other", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts-prod", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(emma, "alerts-prod", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "alerts-prod", PermissionRead))
+
+	decision, err := a.Explain(phil, "alerts-prod", PermissionRead)
+	require.Nil(t, err)
+	require.True(t, decision.Allowed)
+	require.Equal(t, DecisionRuleGroup, decision.Rule)
+	require.Equal(t, "devops", decision.Entry.Group)
+	require.Equal(t, "alerts-*", decision.Entry.TopicPattern)
+
+	// Group entries block reservations by other users
+	require.Equal(t, errTopicOwnedByOthers, a.AllowReservation("emma", "alerts-prod"))
+	require.Nil(t, a.AllowReservation("emma", "other"))
+
+	grants, err := a.GroupGrants("devops")
+	require.Nil(t, err)
+	require.Equal(t, []Grant{
+		{TopicPattern: "alerts-billing", Permission: PermissionRead},
+		{TopicPattern: "alerts-*", Permission: PermissionReadWrite},
+	}, grants)
+
+	require.Nil(t, a.ResetGroupAccess("devops", "alerts-billing"))
+	require.Nil(t, a.Authorize(phil, "alerts-billing", PermissionWrite))
+	require.Nil(t, a.ResetGroupAccess("devops", ""))
+	require.Equal(t, ErrUnauthorized, a.Authorize(phil, "alerts-prod", PermissionRead))
+}
+
 func TestManager_AddUser_Invalid(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Equal(t, ErrInvalidArgument, a.AddUser("  invalid  ", "pass", RoleAdmin, false))
@@ -276,10 +456,10 @@ func TestManager_UserManagement(t *testing.T) {
 	benGrants, err := a.Grants("ben")
 	require.Nil(t, err)
 	require.Equal(t, []Grant{
-		{"everyonewrite", PermissionDenyAll, false},
-		{"mytopic", PermissionReadWrite, false},
-		{"writeme", PermissionWrite, false},
-		{"readme", PermissionRead, false},
+		{"everyonewrite", PermissionDenyAll, false, nil},
+		{"mytopic", PermissionReadWrite, false, nil},
+		{"writeme", PermissionWrite, false, nil},
+		{"readme", PermissionRead, false, nil},
 	}, benGrants)
 
 	everyone, err := a.User(Everyone)
@@ -291,8 +471,8 @@ func TestManager_UserManagement(t *testing.T) {
 	everyoneGrants, err := a.Grants(Everyone)
 	require.Nil(t, err)
 	require.Equal(t, []Grant{
-		{"everyonewrite", PermissionReadWrite, false},
-		{"announcements", PermissionRead, false},
+		{"everyonewrite", PermissionReadWrite, false, nil},
+		{"announcements", PermissionRead, false, nil},
 	}, everyoneGrants)
 
 	// Ben: Before revoking
@@ -385,6 +565,160 @@ func TestManager_ChangeRole(t *testing.T) {
 	require.Equal(t, 0, len(benGrants))
 }
 
+func TestManager_ChangeRoleToSupport(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin, false))
+	require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite))
+
+	require.Nil(t, a.ChangeRole("ben", RoleSupport))
+
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	require.Equal(t, RoleSupport, ben.Role)
+	require.True(t, ben.IsSupport())
+	require.False(t, ben.IsUser())
+	require.False(t, ben.IsAdmin())
+
+	// Support users keep their grants, and have no access beyond them
+	benGrants, err := a.Grants("ben")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(benGrants))
+	require.Nil(t, a.Authorize(ben, "mytopic", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "othertopic", PermissionRead))
+
+	// Support users are listed after admins
+	require.Nil(t, a.AddUser("emma", "emma", RoleUser, false))
+	users, err := a.Users()
+	require.Nil(t, err)
+	require.Equal(t, 4, len(users))
+	require.Equal(t, "phil", users[0].Name)
+	require.Equal(t, "ben", users[1].Name)
+	require.Equal(t, "emma", users[2].Name)
+	require.Equal(t, Everyone, users[3].Name)
+}
+
+func TestManager_StatsRollups(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	today := time.Now().Truncate(24 * time.Hour)
+	yesterday := today.Add(-24 * time.Hour)
+	lastMonth := today.Add(-30 * 24 * time.Hour)
+
+	// Add hourly rollups, counters are added up, distinct counts keep the max
+	require.Nil(t, a.AddStatsRollup(today.Add(10*time.Minute), &StatsRollup{Messages: 3, Emails: 1, Visitors: 5, Users: 2}))
+	require.Nil(t, a.AddStatsRollup(today.Add(50*time.Minute), &StatsRollup{Messages: 2, Calls: 1, Visitors: 4, Users: 3}))
+	require.Nil(t, a.AddStatsRollup(today.Add(time.Hour), &StatsRollup{Messages: 10, Visitors: 7, Users: 1}))
+	require.Nil(t, a.AddStatsRollup(yesterday.Add(5*time.Hour), &StatsRollup{Messages: 1, Visitors: 1}))
+	require.Nil(t, a.AddStatsRollup(lastMonth, &StatsRollup{Messages: 100, Visitors: 50}))
+
+	hours, err := a.StatsRollups(StatsRollupPeriodHour, yesterday)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(hours))
+	require.Equal(t, yesterday.Add(5*time.Hour).Unix(), hours[0].Start.Unix())
+	require.Equal(t, today.Unix(), hours[1].Start.Unix())
+	require.Equal(t, int64(5), hours[1].Messages)
+	require.Equal(t, int64(1), hours[1].Emails)
+	require.Equal(t, int64(1), hours[1].Calls)
+	require.Equal(t, int64(5), hours[1].Visitors)
+	require.Equal(t, int64(3), hours[1].Users)
+	require.Equal(t, StatsRollupPeriodHour, hours[1].Period)
+
+	// Roll up into days, and remove old hourly rollups
+	require.Nil(t, a.RollupStats(lastMonth, 7*24*time.Hour, 365*24*time.Hour))
+	days, err := a.StatsRollups(StatsRollupPeriodDay, time.Unix(0, 0))
+	require.Nil(t, err)
+	require.Equal(t, 3, len(days))
+	require.Equal(t, lastMonth.Unix(), days[0].Start.Unix())
+	require.Equal(t, int64(100), days[0].Messages)
+	require.Equal(t, yesterday.Unix(), days[1].Start.Unix())
+	require.Equal(t, int64(1), days[1].Messages)
+	require.Equal(t, today.Unix(), days[2].Start.Unix())
+	require.Equal(t, int64(15), days[2].Messages)
+	require.Equal(t, int64(7), days[2].Visitors)
+	require.Equal(t, int64(3), days[2].Users)
+
+	hours, err = a.StatsRollups(StatsRollupPeriodHour, time.Unix(0, 0))
+	require.Nil(t, err)
+	require.Equal(t, 3, len(hours)) // Hourly rollup from last month was removed
+
+	// Rolling up again only recomputes the given days, and removes old daily rollups
+	require.Nil(t, a.AddStatsRollup(today.Add(2*time.Hour), &StatsRollup{Messages: 5}))
+	require.Nil(t, a.RollupStats(today, 7*24*time.Hour, 7*24*time.Hour))
+	days, err = a.StatsRollups(StatsRollupPeriodDay, time.Unix(0, 0))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(days))
+	require.Equal(t, int64(1), days[0].Messages)
+	require.Equal(t, int64(20), days[1].Messages)
+}
+
+func TestManager_UsageStats(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.Add(-24 * time.Hour)
+	lastMonth := today.Add(-30 * 24 * time.Hour)
+
+	// Counters of the same day, user and topic are added up
+	require.Nil(t, a.AddUsageStats([]*UsageStats{
+		{Day: today.Add(3 * time.Hour), UserID: phil.ID, Topic: "alerts", Messages: 3, Attachments: 1, AttachmentBytes: 1000},
+		{Day: yesterday, UserID: phil.ID, Topic: "alerts", Messages: 1, Subscriptions: 2},
+		{Day: lastMonth, UserID: phil.ID, Topic: "backups", Messages: 50},
+		{Day: today, UserID: ben.ID, Topic: "alerts", Messages: 2, Subscriptions: 5},
+		{Day: today, Topic: "public", Messages: 7},
+	}))
+	require.Nil(t, a.AddUsageStats([]*UsageStats{
+		{Day: today, UserID: phil.ID, Topic: "alerts", Messages: 1, Attachments: 1, AttachmentBytes: 500},
+	}))
+
+	stats, err := a.UserUsageStats(phil.ID, yesterday)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(stats))
+	require.Equal(t, yesterday.Unix(), stats[0].Day.Unix())
+	require.Equal(t, int64(2), stats[0].Subscriptions)
+	require.Equal(t, today.Unix(), stats[1].Day.Unix())
+	require.Equal(t, "alerts", stats[1].Topic)
+	require.Equal(t, int64(4), stats[1].Messages)
+	require.Equal(t, int64(2), stats[1].Attachments)
+	require.Equal(t, int64(1500), stats[1].AttachmentBytes)
+
+	stats, err = a.UserUsageStats("", today)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(stats))
+	require.Equal(t, int64(7), stats[0].Messages)
+
+	// Top users and topics
+	top, err := a.TopUsageStats(UsageGroupUser, UsageSortSubscriptions, yesterday, 2)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(top))
+	require.Equal(t, "ben", top[0].Username)
+	require.Equal(t, int64(5), top[0].Subscriptions)
+	require.Equal(t, "phil", top[1].Username)
+	require.Equal(t, int64(5), top[1].Messages)
+
+	top, err = a.TopUsageStats(UsageGroupTopic, UsageSortMessages, lastMonth, 10)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(top))
+	require.Equal(t, "backups", top[0].Topic)
+	require.Equal(t, int64(50), top[0].Messages)
+	require.Equal(t, "alerts", top[1].Topic)
+	require.Equal(t, int64(7), top[1].Messages)
+	require.Equal(t, "public", top[2].Topic)
+
+	_, err = a.TopUsageStats(UsageGroupTopic, UsageSort("emails"), lastMonth, 10)
+	require.Equal(t, ErrInvalidArgument, err)
+
+	// Old usage stats are removed with the daily rollups
+	require.Nil(t, a.RollupStats(today, 7*24*time.Hour, 7*24*time.Hour))
+	stats, err = a.UserUsageStats(phil.ID, lastMonth)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(stats))
+}
+
 func TestManager_Reservations(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
@@ -558,6 +892,48 @@ func TestManager_Token_Valid(t *testing.T) {
 	require.Equal(t, 0, len(tokens))
 }
 
+func TestManager_Token_Scopes(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AllowAccess("ben", "alerts", PermissionReadWrite))
+	require.Nil(t, a.AllowAccess("ben", "backups", PermissionReadWrite))
+	u, err := a.User("ben")
+	require.Nil(t, err)
+
+	scopes, err := ParseTokenScopes([]string{"publish", "topic:alerts"})
+	require.Nil(t, err)
+	token, err := a.CreateScopedToken(u.ID, "alerts only", time.Unix(0, 0), netip.IPv4Unspecified(), false, scopes)
+	require.Nil(t, err)
+	require.Equal(t, TokenScopes{TokenScopePublish, NewTopicScope("alerts")}, token.Scopes)
+
+	u2, err := a.AuthenticateToken(token.Value)
+	require.Nil(t, err)
+	require.Equal(t, scopes, u2.TokenScopes)
+	require.Nil(t, a.Authorize(u2, "alerts", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(u2, "alerts", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(u2, "backups", PermissionWrite))
+	decision, err := a.Explain(u2, "backups", PermissionWrite)
+	require.Nil(t, err)
+	require.Equal(t, &Decision{Allowed: false, Rule: DecisionRuleTokenScope}, decision)
+
+	// Scopes never grant more than the user's own access
+	require.Nil(t, a.AllowAccess("ben", "alerts", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(u2, "alerts", PermissionWrite))
+
+	tokens, err := a.Tokens(u.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	require.Equal(t, "publish,topic:alerts", tokens[0].Scopes.String())
+
+	// Unscoped tokens have full access
+	token, err = a.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	u3, err := a.AuthenticateToken(token.Value)
+	require.Nil(t, err)
+	require.False(t, u3.TokenScopes.Restricted())
+	require.Nil(t, a.Authorize(u3, "backups", PermissionRead))
+}
+
 func TestManager_Token_Invalid(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
@@ -577,6 +953,111 @@ func TestManager_Token_NotFound(t *testing.T) {
 	require.Equal(t, ErrTokenNotFound, err)
 }
 
+func TestManager_Token_Inspect(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	u, err := a.User("ben")
+	require.Nil(t, err)
+	token, err := a.CreateScopedToken(u.ID, "backups", time.Unix(0, 0), netip.MustParseAddr("1.2.3.4"), false, TokenScopes{TokenScopePublish})
+	require.Nil(t, err)
+
+	u2, token2, err := a.InspectToken(token.Value)
+	require.Nil(t, err)
+	require.Equal(t, "ben", u2.Name)
+	require.Equal(t, "backups", token2.Label)
+	require.Equal(t, "publish", token2.Scopes.String())
+	require.Equal(t, "1.2.3.4", token2.LastOrigin.String())
+
+	// Queued token updates are taken into account before they are written
+	lastAccess := time.Now().Add(time.Minute).Truncate(time.Second)
+	a.EnqueueTokenUpdate(token.Value, &TokenUpdate{LastAccess: lastAccess, LastOrigin: netip.MustParseAddr("5.6.7.8")})
+	_, token2, err = a.InspectToken(token.Value)
+	require.Nil(t, err)
+	require.Equal(t, lastAccess.Unix(), token2.LastAccess.Unix())
+	require.Equal(t, "5.6.7.8", token2.LastOrigin.String())
+
+	_, _, err = a.InspectToken("tk_notfound")
+	require.Equal(t, ErrTokenNotFound, err)
+}
+
+func TestManager_Token_SwapUpdate(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	u, err := a.User("ben")
+	require.Nil(t, err)
+	token, err := a.CreateToken(u.ID, "", time.Unix(0, 0), netip.MustParseAddr("1.2.3.4"), false)
+	require.Nil(t, err)
+
+	// Previous update is read from the database first, then from the queue
+	previous, err := a.SwapTokenUpdate(token.Value, &TokenUpdate{LastAccess: time.Now(), LastOrigin: netip.MustParseAddr("5.6.7.8"), LastUserAgent: "ntfy/2.14.0", LastCountry: "DE", LastASN: 3320})
+	require.Nil(t, err)
+	require.Equal(t, "1.2.3.4", previous.LastOrigin.String())
+	require.Equal(t, "", previous.LastCountry)
+	previous, err = a.SwapTokenUpdate(token.Value, &TokenUpdate{LastAccess: time.Now(), LastOrigin: netip.MustParseAddr("9.9.9.9"), LastCountry: "US", LastASN: 19281})
+	require.Nil(t, err)
+	require.Equal(t, "5.6.7.8", previous.LastOrigin.String())
+	require.Equal(t, "DE", previous.LastCountry)
+	require.Equal(t, uint32(3320), previous.LastASN)
+
+	// All fields are persisted
+	require.Nil(t, a.writeTokenUpdateQueue())
+	token2, err := a.Token(u.ID, token.Value)
+	require.Nil(t, err)
+	require.Equal(t, "9.9.9.9", token2.LastOrigin.String())
+	require.Equal(t, "", token2.LastUserAgent)
+	require.Equal(t, "US", token2.LastCountry)
+	require.Equal(t, uint32(19281), token2.LastASN)
+	previous, err = a.SwapTokenUpdate(token.Value, &TokenUpdate{LastAccess: time.Now(), LastOrigin: netip.MustParseAddr("9.9.9.9")})
+	require.Nil(t, err)
+	require.Equal(t, "US", previous.LastCountry)
+
+	_, err = a.SwapTokenUpdate("tk_notfound", &TokenUpdate{})
+	require.Equal(t, ErrTokenNotFound, err)
+}
+
+func TestManager_TokenBatch(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("fleet", "fleet", RoleUser, false))
+	require.Nil(t, a.AllowAccess("fleet", "kiosk_*", PermissionReadWrite))
+	fleet, err := a.User("fleet")
+	require.Nil(t, err)
+
+	labels := make([]string, 0)
+	for i := 0; i < 70; i++ {
+		labels = append(labels, fmt.Sprintf("kiosk-%03d", i+1))
+	}
+	tokens, err := a.CreateTokenBatch(fleet.ID, labels, time.Unix(0, 0), netip.IPv4Unspecified(), TokenScopes{TokenScopePublish, NewTopicScope("kiosk_*")})
+	require.Nil(t, err)
+	require.Equal(t, 70, len(tokens))
+	require.True(t, strings.HasPrefix(tokens[0].Batch, "tb_"))
+	require.Equal(t, tokens[0].Batch, tokens[69].Batch)
+	require.Equal(t, "kiosk-070", tokens[69].Label)
+
+	// Batch tokens are not pruned when other tokens are created
+	for i := 0; i < tokenMaxCount+1; i++ {
+		_, err := a.CreateToken(fleet.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified(), false)
+		require.Nil(t, err)
+	}
+	all, err := a.Tokens(fleet.ID)
+	require.Nil(t, err)
+	require.Equal(t, 70+tokenMaxCount, len(all))
+
+	device, err := a.AuthenticateToken(tokens[0].Value)
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(device, "kiosk_lobby", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(device, "kiosk_lobby", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(device, "alerts", PermissionWrite))
+
+	removed, err := a.RemoveTokenBatch(fleet.ID, tokens[0].Batch)
+	require.Nil(t, err)
+	require.Equal(t, int64(70), removed)
+	_, err = a.AuthenticateToken(tokens[0].Value)
+	require.Equal(t, ErrUnauthenticated, err)
+	all, err = a.Tokens(fleet.ID)
+	require.Nil(t, err)
+	require.Equal(t, tokenMaxCount, len(all))
+}
+
 func TestManager_Token_Expire(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
@@ -1034,6 +1515,55 @@ func TestManager_Tier_Change_And_Reset(t *testing.T) {
 	require.Nil(t, a.ResetTier("phil"))
 }
 
+func TestUser_EmailChangeAndFallback(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	_, err = a.Email(phil.ID)
+	require.Equal(t, ErrEmailNotFound, err)
+
+	// First address has no fallback
+	require.Nil(t, a.ChangeEmail(phil.ID, "phil@example.com", 7*24*time.Hour))
+	email, err := a.Email(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, "phil@example.com", email.Address)
+	require.Equal(t, "", email.Fallback)
+	require.True(t, email.FallbackUntil.IsZero())
+
+	// Changing the address keeps the old one as a fallback
+	require.Nil(t, a.ChangeEmail(phil.ID, "phil@new.example.com", 7*24*time.Hour))
+	email, err = a.Email(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, "phil@new.example.com", email.Address)
+	require.Equal(t, "phil@example.com", email.Fallback)
+	require.True(t, email.FallbackUntil.After(time.Now().Add(6*24*time.Hour)))
+
+	// Addresses are unique across users
+	require.Equal(t, ErrEmailExists, a.ChangeEmail(ben.ID, "phil@new.example.com", time.Hour))
+	require.Nil(t, a.ChangeEmail(ben.ID, "ben@example.com", time.Hour))
+
+	// Expired fallbacks are not returned, and removed
+	require.Nil(t, a.ChangeEmail(phil.ID, "phil@other.example.com", time.Second))
+	time.Sleep(1100 * time.Millisecond)
+	email, err = a.Email(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, "", email.Fallback)
+	require.Nil(t, a.RemoveExpiredEmailFallbacks())
+	var count int
+	require.Nil(t, a.db.QueryRow(`SELECT COUNT(*) FROM user_email WHERE fallback_email IS NOT NULL`).Scan(&count))
+	require.Equal(t, 0, count)
+
+	// Remove address
+	require.Nil(t, a.RemoveEmail(phil.ID))
+	_, err = a.Email(phil.ID)
+	require.Equal(t, ErrEmailNotFound, err)
+}
+
 func TestUser_PhoneNumberAddListRemove(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 
@@ -1041,12 +1571,22 @@ func TestUser_PhoneNumberAddListRemove(t *testing.T) {
 	phil, err := a.User("phil")
 	require.Nil(t, err)
 	require.Nil(t, a.AddPhoneNumber(phil.ID, "+1234567890"))
+	require.Equal(t, ErrPhoneNumberExists, a.AddPhoneNumber(phil.ID, "+1234567890"))
 
 	phoneNumbers, err := a.PhoneNumbers(phil.ID)
 	require.Nil(t, err)
 	require.Equal(t, 1, len(phoneNumbers))
 	require.Equal(t, "+1234567890", phoneNumbers[0])
 
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	require.Nil(t, a.AddPhoneNumber(ben.ID, "+1987654321"))
+	allPhoneNumbers, err := a.AllPhoneNumbers()
+	require.Nil(t, err)
+	require.Equal(t, map[string][]string{phil.ID: {"+1234567890"}, ben.ID: {"+1987654321"}}, allPhoneNumbers)
+	require.Nil(t, a.RemovePhoneNumber(ben.ID, "+1987654321"))
+
 	require.Nil(t, a.RemovePhoneNumber(phil.ID, "+1234567890"))
 	phoneNumbers, err = a.PhoneNumbers(phil.ID)
 	require.Nil(t, err)
@@ -1059,6 +1599,58 @@ func TestUser_PhoneNumberAddListRemove(t *testing.T) {
 	require.Nil(t, rows.Close())
 }
 
+func TestUser_WebAuthnCredentialAddListRemove(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+
+	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin, false))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	credential := &WebAuthnCredential{
+		ID:        "cred1",
+		PublicKey: []byte{1, 2, 3},
+		SignCount: 5,
+		Name:      "YubiKey",
+	}
+	require.Nil(t, a.AddWebAuthnCredential(phil.ID, credential))
+	require.Equal(t, ErrWebAuthnCredentialExists, a.AddWebAuthnCredential(phil.ID, credential))
+	require.Nil(t, a.ChangeWebAuthnSignCount(phil.ID, "cred1", 6))
+
+	credentials, err := a.WebAuthnCredentials(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(credentials))
+	require.Equal(t, "cred1", credentials[0].ID)
+	require.Equal(t, []byte{1, 2, 3}, credentials[0].PublicKey)
+	require.Equal(t, uint32(6), credentials[0].SignCount)
+	require.Equal(t, "YubiKey", credentials[0].Name)
+
+	require.Nil(t, a.RemoveWebAuthnCredential(phil.ID, "cred1"))
+	credentials, err = a.WebAuthnCredentials(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(credentials))
+}
+
+func TestUser_RemoveAllTokens_KeepsProvisioned(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	_, err = a.CreateToken(phil.ID, "token1", time.Time{}, netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	_, err = a.CreateToken(phil.ID, "token2", time.Time{}, netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	_, err = a.CreateToken(phil.ID, "provisioned", time.Time{}, netip.IPv4Unspecified(), true)
+	require.Nil(t, err)
+
+	removed, err := a.RemoveAllTokens(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), removed)
+	tokens, err := a.Tokens(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(tokens))
+	require.True(t, tokens[0].Provisioned)
+}
+
 func TestUser_PhoneNumberAdd_Multiple_Users_Same_Number(t *testing.T) {
 	a := newTestManager(t, PermissionDenyAll)
 
@@ -1155,7 +1747,7 @@ func TestManager_WithProvisionedUsers(t *testing.T) {
 	lastAccessTime := time.Now().Add(time.Hour)
 	lastOrigin := netip.MustParseAddr("1.1.9.9")
 	err = execTx(a.db, func(tx *sql.Tx) error {
-		return a.updateTokenLastAccessTx(tx, tokens[0].Value, lastAccessTime.Unix(), lastOrigin.String())
+		return a.updateTokenLastAccessTx(tx, tokens[0].Value, &TokenUpdate{LastAccess: lastAccessTime, LastOrigin: lastOrigin})
 	})
 	require.Nil(t, err)
 
@@ -1244,6 +1836,37 @@ func TestManager_WithProvisionedUsers(t *testing.T) {
 	a.db.QueryRow("SELECT COUNT(*) FROM user_token WHERE provisioned = 1").Scan(&count)
 }
 
+func TestManager_Reload(t *testing.T) {
+	conf := &Config{
+		Filename:         filepath.Join(t.TempDir(), "user.db"),
+		DefaultAccess:    PermissionReadWrite,
+		ProvisionEnabled: true,
+		Users: []*User{
+			{Name: "philuser", Hash: "$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C", Role: RoleUser},
+		},
+	}
+	a, err := NewManager(conf)
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(nil, "mytopic", PermissionWrite))
+
+	// Replace the provisioned user, and add a grant
+	require.Nil(t, a.Reload(PermissionDenyAll, []*User{
+		{Name: "philadmin", Hash: "$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C", Role: RoleUser},
+	}, map[string][]*Grant{
+		"philadmin": {{TopicPattern: "alerts", Permission: PermissionRead}},
+	}, nil))
+	require.Equal(t, PermissionDenyAll, a.DefaultAccess())
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionWrite))
+
+	_, err = a.User("philuser")
+	require.Equal(t, ErrUserNotFound, err)
+	u, err := a.User("philadmin")
+	require.Nil(t, err)
+	require.True(t, u.Provisioned)
+	require.Nil(t, a.Authorize(u, "alerts", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(u, "alerts", PermissionWrite))
+}
+
 func TestManager_UpdateNonProvisionedUsersToProvisionedUsers(t *testing.T) {
 	f := filepath.Join(t.TempDir(), "user.db")
 	conf := &Config{
@@ -1326,26 +1949,114 @@ func TestManager_UpdateNonProvisionedUsersToProvisionedUsers(t *testing.T) {
 	require.Empty(t, grants)
 }
 
-func TestToFromSQLWildcard(t *testing.T) {
-	require.Equal(t, "up%", toSQLWildcard("up*"))
-	require.Equal(t, "up\\_%", toSQLWildcard("up_*"))
-	require.Equal(t, "foo", toSQLWildcard("foo"))
-
-	require.Equal(t, "up*", fromSQLWildcard("up%"))
-	require.Equal(t, "up_*", fromSQLWildcard("up\\_%"))
-	require.Equal(t, "foo", fromSQLWildcard("foo"))
-
-	require.Equal(t, "up*", fromSQLWildcard(toSQLWildcard("up*")))
-	require.Equal(t, "up_*", fromSQLWildcard(toSQLWildcard("up_*")))
-	require.Equal(t, "foo", fromSQLWildcard(toSQLWildcard("foo")))
-}
-
-func TestMigrationFrom1(t *testing.T) {
-	filename := filepath.Join(t.TempDir(), "user.db")
-	db, err := sql.Open("sqlite3", filename)
-	require.Nil(t, err)
-
-	// Create "version 1" schema
+func TestManager_ProvisionDrift(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "user.db")
+	conf := &Config{
+		Filename:         f,
+		DefaultAccess:    PermissionReadWrite,
+		ProvisionEnabled: true,
+		Users: []*User{
+			{Name: "philuser", Hash: "$2a$10$YLiO8U21sX1uhZamTLJXHuxgVC0Z/GKISibrKCLohPgtG7yIxSk4C", Role: RoleUser},
+		},
+		Access: map[string][]*Grant{
+			"philuser": {
+				{TopicPattern: "stats", Permission: PermissionReadWrite},
+			},
+		},
+		Tokens: map[string][]*Token{
+			"philuser": {
+				{Value: "tk_op56p8lz5bf3cxkz9je99v9oc37lo", Label: "Alerts token"},
+			},
+		},
+	}
+	a, err := NewManager(conf)
+	require.Nil(t, err)
+
+	// Manually add entries that are not in the config
+	require.Nil(t, a.AddUser("philmanual", "manual", RoleUser, false))
+	require.Nil(t, a.AllowAccess("philuser", "manual_*", PermissionRead))
+	require.Nil(t, a.AllowAccess(Everyone, "announcements", PermissionRead))
+	require.Nil(t, a.AddReservation("philuser", "mytopic", PermissionDenyAll)) // Reservations are not drift
+	u, err := a.User("philuser")
+	require.Nil(t, err)
+	_, err = a.CreateToken(u.ID, "Manual token", time.Unix(0, 0), netip.IPv4Unspecified(), false)
+	require.Nil(t, err)
+	_, err = a.CreateToken(u.ID, "Web session", time.Now().Add(time.Hour), netip.IPv4Unspecified(), false) // Expiring tokens are not drift
+	require.Nil(t, err)
+
+	drift, err := a.ProvisionDrift()
+	require.Nil(t, err)
+	require.Equal(t, []string{"philmanual"}, drift.Users)
+	require.Len(t, drift.Access, 2)
+	require.Equal(t, "manual_*", drift.Access["philuser"][0].TopicPattern)
+	require.Equal(t, PermissionRead, drift.Access["philuser"][0].Permission)
+	require.Equal(t, "announcements", drift.Access[Everyone][0].TopicPattern)
+	require.Len(t, drift.Tokens["philuser"], 1)
+	require.Equal(t, "Manual token", drift.Tokens["philuser"][0].Label)
+
+	// Restart without pruning: entries are reported, but kept
+	require.Nil(t, a.db.Close())
+	a, err = NewManager(conf)
+	require.Nil(t, err)
+	drift, err = a.ProvisionDrift()
+	require.Nil(t, err)
+	require.False(t, drift.Empty())
+
+	// Restart with pruning: entries are removed, reservations and expiring tokens are kept
+	require.Nil(t, a.db.Close())
+	conf.ProvisionPrune = true
+	a, err = NewManager(conf)
+	require.Nil(t, err)
+	drift, err = a.ProvisionDrift()
+	require.Nil(t, err)
+	require.True(t, drift.Empty())
+
+	_, err = a.User("philmanual")
+	require.Equal(t, ErrUserNotFound, err)
+	grants, err := a.Grants("philuser")
+	require.Nil(t, err)
+	require.Len(t, grants, 2)
+	require.Equal(t, "mytopic", grants[0].TopicPattern)
+	require.Equal(t, "stats", grants[1].TopicPattern)
+	grants, err = a.Grants(Everyone)
+	require.Nil(t, err)
+	require.Len(t, grants, 1)
+	require.Equal(t, "mytopic", grants[0].TopicPattern)
+	tokens, err := a.Tokens(u.ID)
+	require.Nil(t, err)
+	require.Len(t, tokens, 2)
+	labels := []string{tokens[0].Label, tokens[1].Label}
+	require.ElementsMatch(t, []string{"Alerts token", "Web session"}, labels)
+}
+
+func TestManager_ProvisionDrift_NotDeclarative(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	drift, err := a.ProvisionDrift()
+	require.Nil(t, err)
+	require.True(t, drift.Empty())
+}
+
+func TestToFromSQLWildcard(t *testing.T) {
+	require.Equal(t, "up%", toSQLWildcard("up*"))
+	require.Equal(t, "up\\_%", toSQLWildcard("up_*"))
+	require.Equal(t, "foo", toSQLWildcard("foo"))
+
+	require.Equal(t, "up*", fromSQLWildcard("up%"))
+	require.Equal(t, "up_*", fromSQLWildcard("up\\_%"))
+	require.Equal(t, "foo", fromSQLWildcard("foo"))
+
+	require.Equal(t, "up*", fromSQLWildcard(toSQLWildcard("up*")))
+	require.Equal(t, "up_*", fromSQLWildcard(toSQLWildcard("up_*")))
+	require.Equal(t, "foo", fromSQLWildcard(toSQLWildcard("foo")))
+}
+
+func TestMigrationFrom1(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "user.db")
+	db, err := sql.Open("sqlite3", filename)
+	require.Nil(t, err)
+
+	// Create "version 1" schema
 	_, err = db.Exec(`
 		BEGIN;
 		CREATE TABLE IF NOT EXISTS user (
@@ -1571,6 +2282,517 @@ func TestMigrationFrom4(t *testing.T) {
 	require.Nil(t, a.Authorize(nil, "up", PermissionRead)) // % matches 0 or more characters
 }
 
+func TestManager_EmailTemplates(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	_, err := a.EmailTemplate("mytopic")
+	require.Equal(t, ErrEmailTemplateNotFound, err)
+
+	require.Nil(t, a.ChangeEmailTemplate("ben", &EmailTemplate{
+		Topic:   "mytopic",
+		Subject: "Alert: {{.title}}",
+		Body:    "{{.message}}",
+	}))
+	tpl, err := a.EmailTemplate("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", tpl.Topic)
+	require.Equal(t, "Alert: {{.title}}", tpl.Subject)
+	require.Equal(t, "{{.message}}", tpl.Body)
+
+	require.Nil(t, a.ChangeEmailTemplate("ben", &EmailTemplate{
+		Topic:   "mytopic",
+		Subject: "Changed",
+		Body:    "",
+	}))
+	tpl, err = a.EmailTemplate("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, "Changed", tpl.Subject)
+	require.Equal(t, "", tpl.Body)
+
+	// Removing the reservation removes the template
+	require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+	_, err = a.EmailTemplate("mytopic")
+	require.Equal(t, ErrEmailTemplateNotFound, err)
+
+	// Removing the user removes the template
+	require.Nil(t, a.ChangeEmailTemplate("ben", &EmailTemplate{Topic: "othertopic", Subject: "a", Body: "b"}))
+	require.Nil(t, a.RemoveUser("ben"))
+	_, err = a.EmailTemplate("othertopic")
+	require.Equal(t, ErrEmailTemplateNotFound, err)
+
+	require.Equal(t, ErrInvalidArgument, a.ChangeEmailTemplate("ben", &EmailTemplate{Topic: "invalid topic"}))
+}
+
+func TestManager_TopicTemplates(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	_, err := a.TopicTemplate("mytopic")
+	require.Equal(t, ErrTopicTemplateNotFound, err)
+
+	require.Nil(t, a.ChangeTopicTemplate("ben", &TopicTemplate{
+		Topic:    "mytopic",
+		Title:    "{{.host}} is {{.status}}",
+		Message:  "{{.details}}",
+		Tags:     "{{.host}}",
+		Priority: "{{if eq .status \"down\"}}5{{end}}",
+	}))
+	tpl, err := a.TopicTemplate("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, &TopicTemplate{
+		Topic:    "mytopic",
+		Title:    "{{.host}} is {{.status}}",
+		Message:  "{{.details}}",
+		Tags:     "{{.host}}",
+		Priority: "{{if eq .status \"down\"}}5{{end}}",
+	}, tpl)
+
+	// Removing the reservation removes the template
+	require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+	_, err = a.TopicTemplate("mytopic")
+	require.Equal(t, ErrTopicTemplateNotFound, err)
+
+	// Removing the user removes the template
+	require.Nil(t, a.ChangeTopicTemplate("ben", &TopicTemplate{Topic: "othertopic", Message: "{{.details}}"}))
+	require.Nil(t, a.RemoveUser("ben"))
+	_, err = a.TopicTemplate("othertopic")
+	require.Equal(t, ErrTopicTemplateNotFound, err)
+
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicTemplate("ben", &TopicTemplate{Topic: "invalid topic"}))
+}
+
+func TestManager_TopicWebhooks(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	webhooks, err := a.TopicWebhooks("mytopic")
+	require.Nil(t, err)
+	require.Empty(t, webhooks)
+
+	webhook1, err := a.AddTopicWebhook("ben", "mytopic", "https://example.com/hook1", WebhookFormatNtfy, 2)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(webhook1.ID, "wh_"))
+	require.True(t, strings.HasPrefix(webhook1.Secret, "whsec_"))
+	webhook2, err := a.AddTopicWebhook("ben", "mytopic", "https://hooks.slack.com/services/T000/B000/XXX", WebhookFormatSlack, 2)
+	require.Nil(t, err)
+	_, err = a.AddTopicWebhook("ben", "mytopic", "https://example.com/hook3", WebhookFormatNtfy, 2)
+	require.Equal(t, ErrTooManyTopicWebhooks, err)
+
+	webhooks, err = a.TopicWebhooks("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, []*TopicWebhook{webhook1, webhook2}, webhooks)
+
+	// Delivery log keeps the most recent deliveries
+	for i := 0; i < webhookDeliveriesLimit+5; i++ {
+		require.Nil(t, a.AddWebhookDelivery(&WebhookDelivery{
+			WebhookID:  webhook1.ID,
+			MessageID:  fmt.Sprintf("msg%d", i),
+			Time:       time.Unix(1700000000+int64(i), 0),
+			Attempts:   1,
+			StatusCode: 200,
+		}))
+	}
+	require.Nil(t, a.AddWebhookDelivery(&WebhookDelivery{WebhookID: webhook2.ID, MessageID: "failed", Time: time.Unix(1700000000, 0), Attempts: 5, Error: "connection refused"}))
+	deliveries, err := a.WebhookDeliveries(webhook1.ID)
+	require.Nil(t, err)
+	require.Len(t, deliveries, webhookDeliveriesLimit)
+	require.Equal(t, fmt.Sprintf("msg%d", webhookDeliveriesLimit+4), deliveries[0].MessageID)
+	deliveries, err = a.WebhookDeliveries(webhook2.ID)
+	require.Nil(t, err)
+	require.Equal(t, []*WebhookDelivery{{WebhookID: webhook2.ID, MessageID: "failed", Time: time.Unix(1700000000, 0), Attempts: 5, Error: "connection refused"}}, deliveries)
+
+	// Removing a webhook removes its deliveries
+	require.Nil(t, a.RemoveTopicWebhook("mytopic", webhook1.ID))
+	require.Equal(t, ErrTopicWebhookNotFound, a.RemoveTopicWebhook("mytopic", webhook1.ID))
+	deliveries, err = a.WebhookDeliveries(webhook1.ID)
+	require.Nil(t, err)
+	require.Empty(t, deliveries)
+
+	// Removing the reservation removes the webhooks
+	require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+	webhooks, err = a.TopicWebhooks("mytopic")
+	require.Nil(t, err)
+	require.Empty(t, webhooks)
+
+	_, err = a.AddTopicWebhook("ben", "invalid topic", "https://example.com", WebhookFormatNtfy, 2)
+	require.Equal(t, ErrInvalidArgument, err)
+	_, err = a.AddTopicWebhook("ben", "mytopic", "https://example.com", WebhookFormat("teams"), 2)
+	require.Equal(t, ErrInvalidArgument, err)
+}
+
+func TestManager_TopicSigningKeys(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	keys, err := a.TopicSigningKeys("mytopic")
+	require.Nil(t, err)
+	require.Empty(t, keys)
+
+	publicKey1, _, _ := ed25519.GenerateKey(nil)
+	publicKey2, _, _ := ed25519.GenerateKey(nil)
+	publicKey3, _, _ := ed25519.GenerateKey(nil)
+	key1, err := a.AddTopicSigningKey("ben", "mytopic", publicKey1, 2)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(key1.ID, "sk_"))
+	_, err = a.AddTopicSigningKey("ben", "mytopic", publicKey1, 2)
+	require.Equal(t, ErrTopicSigningKeyExists, err)
+	key2, err := a.AddTopicSigningKey("ben", "mytopic", publicKey2, 2)
+	require.Nil(t, err)
+	_, err = a.AddTopicSigningKey("ben", "mytopic", publicKey3, 2)
+	require.Equal(t, ErrTooManyTopicSigningKeys, err)
+
+	keys, err = a.TopicSigningKeys("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, []*TopicSigningKey{key1, key2}, keys)
+
+	require.Nil(t, a.RemoveTopicSigningKey("mytopic", key1.ID))
+	require.Equal(t, ErrTopicSigningKeyNotFound, a.RemoveTopicSigningKey("mytopic", key1.ID))
+
+	// Removing the reservation removes the keys
+	require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+	keys, err = a.TopicSigningKeys("mytopic")
+	require.Nil(t, err)
+	require.Empty(t, keys)
+
+	_, err = a.AddTopicSigningKey("ben", "mytopic", publicKey1[:16], 2)
+	require.Equal(t, ErrInvalidArgument, err)
+}
+
+func TestManager_TopicTelegram(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	_, err := a.TopicTelegram("mytopic")
+	require.Equal(t, ErrTopicTelegramNotFound, err)
+
+	require.Nil(t, a.ChangeTopicTelegram("ben", &TopicTelegram{Topic: "mytopic", BotToken: "123:abc", ChatID: "-100123"}))
+	require.Nil(t, a.ChangeTopicTelegram("ben", &TopicTelegram{Topic: "mytopic", BotToken: "123:def", ChatID: "@mychannel"}))
+	telegram, err := a.TopicTelegram("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, &TopicTelegram{Topic: "mytopic", BotToken: "123:def", ChatID: "@mychannel"}, telegram)
+
+	require.Nil(t, a.RemoveTopicTelegram("mytopic"))
+	_, err = a.TopicTelegram("mytopic")
+	require.Equal(t, ErrTopicTelegramNotFound, err)
+
+	// Removing the reservation removes the settings
+	require.Nil(t, a.ChangeTopicTelegram("ben", &TopicTelegram{Topic: "mytopic", BotToken: "123:abc", ChatID: "-100123"}))
+	require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+	_, err = a.TopicTelegram("mytopic")
+	require.Equal(t, ErrTopicTelegramNotFound, err)
+
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicTelegram("ben", &TopicTelegram{Topic: "mytopic", ChatID: "-100123"}))
+}
+
+func TestManager_TopicIngest(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	_, err := a.TopicIngest("mytopic")
+	require.Equal(t, ErrTopicIngestNotFound, err)
+
+	require.Nil(t, a.ChangeTopicIngest("ben", &TopicIngest{Topic: "mytopic", Source: IngestSourceGitHub, Secret: "secret1"}))
+	require.Nil(t, a.ChangeTopicIngest("ben", &TopicIngest{Topic: "mytopic", Source: IngestSourceStripe, Secret: "whsec_abc"}))
+	ingest, err := a.TopicIngest("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, &TopicIngest{Topic: "mytopic", Source: IngestSourceStripe, Secret: "whsec_abc"}, ingest)
+
+	require.Nil(t, a.RemoveTopicIngest("mytopic"))
+	_, err = a.TopicIngest("mytopic")
+	require.Equal(t, ErrTopicIngestNotFound, err)
+
+	// Removing the reservation removes the settings
+	require.Nil(t, a.ChangeTopicIngest("ben", &TopicIngest{Topic: "mytopic", Source: IngestSourceGrafana, Secret: "secret1"}))
+	require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+	_, err = a.TopicIngest("mytopic")
+	require.Equal(t, ErrTopicIngestNotFound, err)
+
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicIngest("ben", &TopicIngest{Topic: "mytopic", Source: IngestSourceGitHub}))
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicIngest("ben", &TopicIngest{Topic: "mytopic", Source: IngestSource("gitlab"), Secret: "secret1"}))
+}
+
+func TestManager_TopicKey(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	_, err := a.TopicKey("mytopic")
+	require.Equal(t, ErrTopicKeyNotFound, err)
+	require.Equal(t, ErrTopicKeyNotFound, a.AuthorizeTopicKey("mytopic", "correct horse", PermissionRead))
+
+	require.Nil(t, a.ChangeTopicKey("ben", "mytopic", "correct horse", PermissionRead))
+	key, err := a.TopicKey("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", key.Topic)
+	require.Equal(t, PermissionRead, key.Permission)
+	require.NotEqual(t, "correct horse", key.Hash)
+
+	require.Nil(t, a.AuthorizeTopicKey("mytopic", "correct horse", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.AuthorizeTopicKey("mytopic", "correct horse", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.AuthorizeTopicKey("mytopic", "battery staple", PermissionRead))
+	require.Equal(t, ErrTopicKeyNotFound, a.AuthorizeTopicKey("othertopic", "correct horse", PermissionRead))
+
+	// Changing the key replaces the old one
+	require.Nil(t, a.ChangeTopicKey("ben", "mytopic", "battery staple", PermissionReadWrite))
+	require.Nil(t, a.AuthorizeTopicKey("mytopic", "battery staple", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.AuthorizeTopicKey("mytopic", "correct horse", PermissionRead))
+
+	require.Nil(t, a.RemoveTopicKey("mytopic"))
+	_, err = a.TopicKey("mytopic")
+	require.Equal(t, ErrTopicKeyNotFound, err)
+
+	// Removing the reservation removes the key
+	require.Nil(t, a.ChangeTopicKey("ben", "mytopic", "correct horse", PermissionWrite))
+	require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+	_, err = a.TopicKey("mytopic")
+	require.Equal(t, ErrTopicKeyNotFound, err)
+
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicKey("ben", "mytopic", "short", PermissionRead))
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicKey("ben", "mytopic", "correct horse", PermissionDenyAll))
+}
+
+func TestManager_EphemeralTopics(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	topic, err := a.AddEphemeralTopic(ben, "handoff123", time.Now().Add(time.Hour), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, "handoff123", topic.Topic)
+	count, err := a.EphemeralTopicsCount(ben.ID)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), count)
+
+	// The read token can only subscribe, the write token can only publish, and only to this topic
+	reader, err := a.AuthenticateToken(topic.ReadToken.Value)
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(reader, "handoff123", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(reader, "handoff123", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(reader, "othertopic", PermissionRead))
+	writer, err := a.AuthenticateToken(topic.WriteToken.Value)
+	require.Nil(t, err)
+	require.Nil(t, a.Authorize(writer, "handoff123", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(writer, "handoff123", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "handoff123", PermissionRead))
+
+	expired, err := a.EphemeralTopicsExpired()
+	require.Nil(t, err)
+	require.Empty(t, expired)
+	_, err = a.db.Exec("UPDATE user_ephemeral_topic SET expires = 1")
+	require.Nil(t, err)
+	expired, err = a.EphemeralTopicsExpired()
+	require.Nil(t, err)
+	require.Equal(t, []string{"handoff123"}, expired)
+
+	require.Nil(t, a.RemoveEphemeralTopics(expired...))
+	_, err = a.AuthenticateToken(topic.WriteToken.Value)
+	require.Equal(t, ErrUnauthenticated, err)
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "handoff123", PermissionRead))
+	expired, err = a.EphemeralTopicsExpired()
+	require.Nil(t, err)
+	require.Empty(t, expired)
+}
+
+func TestManager_TopicPolicies(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+	_, err := a.TopicPolicy("mytopic")
+	require.Equal(t, ErrTopicPolicyNotFound, err)
+
+	require.Nil(t, a.ChangeTopicPolicy("ben", &TopicPolicy{
+		Topic:         "mytopic",
+		CacheDuration: 72 * time.Hour,
+		MessageLimit:  100,
+	}))
+	require.Nil(t, a.ChangeTopicPolicy("", &TopicPolicy{
+		Topic:                   "alerts",
+		MessageLimit:            10,
+		AttachmentFileSizeLimit: 1024,
+	}))
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	policy, err := a.TopicPolicy("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, &TopicPolicy{
+		Topic:         "mytopic",
+		Owner:         ben.ID,
+		CacheDuration: 72 * time.Hour,
+		MessageLimit:  100,
+	}, policy)
+	policies, err := a.TopicPolicies()
+	require.Nil(t, err)
+	require.Len(t, policies, 2)
+	require.Equal(t, "alerts", policies[0].Topic)
+	require.Equal(t, "", policies[0].Owner)
+	require.Equal(t, int64(1024), policies[0].AttachmentFileSizeLimit)
+	require.Equal(t, "mytopic", policies[1].Topic)
+
+	// Removing the reservation removes the owner's policy, but not the admin's
+	require.Nil(t, a.AddReservation("ben", "alerts", PermissionDenyAll))
+	require.Nil(t, a.RemoveReservations("ben", "mytopic", "alerts"))
+	_, err = a.TopicPolicy("mytopic")
+	require.Equal(t, ErrTopicPolicyNotFound, err)
+	_, err = a.TopicPolicy("alerts")
+	require.Nil(t, err)
+
+	// Removing the user removes the policy
+	require.Nil(t, a.ChangeTopicPolicy("ben", &TopicPolicy{Topic: "othertopic", MessageLimit: 5}))
+	require.Nil(t, a.RemoveUser("ben"))
+	_, err = a.TopicPolicy("othertopic")
+	require.Equal(t, ErrTopicPolicyNotFound, err)
+
+	require.Nil(t, a.RemoveTopicPolicy("alerts"))
+	_, err = a.TopicPolicy("alerts")
+	require.Equal(t, ErrTopicPolicyNotFound, err)
+
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicPolicy("", &TopicPolicy{Topic: "invalid topic"}))
+	require.Equal(t, ErrInvalidArgument, a.ChangeTopicPolicy("", &TopicPolicy{Topic: "mytopic", MessageLimit: -1}))
+}
+
+func TestManager_Bans(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+
+	ipBan, err := a.AddBan(BanKindIP, "1.2.3.4", "spam", time.Unix(0, 0))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(ipBan.ID, "ban_"))
+	require.Equal(t, "1.2.3.4/32", ipBan.Value)
+	require.False(t, ipBan.Expired())
+	rangeBan, err := a.AddBan(BanKindIP, "2001:db8::1/32", "", time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, "2001:db8::/32", rangeBan.Value)
+	userBan, err := a.AddBan(BanKindUser, "ben", "abuse", time.Unix(0, 0))
+	require.Nil(t, err)
+	_, err = a.AddBan(BanKindToken, "tk_aaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "leaked", time.Unix(0, 0))
+	require.Nil(t, err)
+
+	// Banning the same IP again replaces the ban, but keeps its ID
+	ipBan2, err := a.AddBan(BanKindIP, "1.2.3.4/32", "more spam", time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, ipBan.ID, ipBan2.ID)
+	require.Equal(t, "more spam", ipBan2.Reason)
+
+	bans, err := a.Bans()
+	require.Nil(t, err)
+	require.Len(t, bans, 4)
+	require.Equal(t, BanKindIP, bans[0].Kind)
+	require.Equal(t, "more spam", bans[0].Reason)
+	require.Equal(t, BanKindUser, bans[2].Kind)
+	require.Equal(t, "ben", bans[2].Value)
+
+	// Expired bans are not returned, and are removed by RemoveExpiredBans
+	_, err = a.db.Exec("UPDATE user_ban SET expires = ? WHERE id = ?", time.Now().Add(-time.Minute).Unix(), rangeBan.ID)
+	require.Nil(t, err)
+	bans, err = a.Bans()
+	require.Nil(t, err)
+	require.Len(t, bans, 3)
+	require.Nil(t, a.RemoveExpiredBans())
+	require.Equal(t, ErrBanNotFound, a.RemoveBan(rangeBan.ID))
+
+	require.Nil(t, a.RemoveBan(userBan.ID))
+	require.Equal(t, ErrBanNotFound, a.RemoveBan(userBan.ID))
+	bans, err = a.Bans()
+	require.Nil(t, err)
+	require.Len(t, bans, 2)
+
+	// Invalid bans
+	_, err = a.AddBan(BanKindIP, "1.2.3", "", time.Unix(0, 0))
+	require.Equal(t, ErrInvalidArgument, err)
+	_, err = a.AddBan(BanKindUser, "nope", "", time.Unix(0, 0))
+	require.Equal(t, ErrUserNotFound, err)
+	_, err = a.AddBan(BanKindUser, Everyone, "", time.Unix(0, 0))
+	require.Equal(t, ErrInvalidArgument, err)
+	_, err = a.AddBan(BanKindToken, "not-a-token", "", time.Unix(0, 0))
+	require.Equal(t, ErrInvalidArgument, err)
+	_, err = a.AddBan(BanKind("topic"), "mytopic", "", time.Unix(0, 0))
+	require.Equal(t, ErrInvalidArgument, err)
+}
+
+func TestManager_Sessions(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	u, err := a.User("ben")
+	require.Nil(t, err)
+
+	session, err := a.CreateSession(u.ID, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(session.ID, "se_"))
+	require.True(t, strings.HasPrefix(session.RefreshToken, "rt_"))
+	_, err = a.AuthenticateToken(session.AccessToken.Value)
+	require.Nil(t, err)
+	_, err = a.AuthenticateToken(session.RefreshToken)
+	require.Equal(t, ErrUnauthenticated, err)
+
+	// Session tokens cannot be extended, but can be renamed
+	_, err = a.ChangeToken(u.ID, session.AccessToken.Value, nil, util.Time(time.Now().Add(72*time.Hour)))
+	require.Equal(t, ErrSessionTokenChange, err)
+	_, err = a.ChangeToken(u.ID, session.AccessToken.Value, util.String("laptop"), nil)
+	require.Nil(t, err)
+
+	// Looking up the user of a session does not use the refresh token
+	userID, err := a.SessionUserID(session.RefreshToken)
+	require.Nil(t, err)
+	require.Equal(t, u.ID, userID)
+	_, err = a.SessionUserID("rt_doesnotexist")
+	require.Equal(t, ErrRefreshTokenInvalid, err)
+
+	// Refreshing rotates both tokens, and deletes the old access token
+	session2, err := a.RefreshSession(session.RefreshToken, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Equal(t, session.ID, session2.ID)
+	require.NotEqual(t, session.RefreshToken, session2.RefreshToken)
+	_, err = a.AuthenticateToken(session.AccessToken.Value)
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.AuthenticateToken(session2.AccessToken.Value)
+	require.Nil(t, err)
+
+	// Reusing a rotated refresh token within the grace period fails, but does not revoke the session
+	_, err = a.RefreshSession(session.RefreshToken, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Equal(t, ErrRefreshTokenInvalid, err)
+	_, err = a.AuthenticateToken(session2.AccessToken.Value)
+	require.Nil(t, err)
+
+	// Reusing it after the grace period revokes the entire session
+	_, err = a.db.Exec("UPDATE user_refresh_token SET used = ? WHERE token = ?", time.Now().Add(-2*time.Minute).Unix(), session.RefreshToken)
+	require.Nil(t, err)
+	_, err = a.RefreshSession(session.RefreshToken, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Equal(t, ErrRefreshTokenReused, err)
+	_, err = a.AuthenticateToken(session2.AccessToken.Value)
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.RefreshSession(session2.RefreshToken, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Equal(t, ErrRefreshTokenInvalid, err)
+
+	// Deleting the access token ends the session
+	session3, err := a.CreateSession(u.ID, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	require.Nil(t, a.RemoveToken(u.ID, session3.AccessToken.Value))
+	_, err = a.RefreshSession(session3.RefreshToken, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Equal(t, ErrRefreshTokenInvalid, err)
+
+	// Expired refresh tokens cannot be used, and are removed by RemoveExpiredTokens
+	session4, err := a.CreateSession(u.ID, time.Now().Add(time.Hour), time.Now().Add(-time.Minute), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	_, err = a.RefreshSession(session4.RefreshToken, time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Equal(t, ErrRefreshTokenInvalid, err)
+	require.Nil(t, a.RemoveExpiredTokens())
+	var count int
+	require.Nil(t, a.db.QueryRow("SELECT COUNT(*) FROM user_refresh_token WHERE token = ?", session4.RefreshToken).Scan(&count))
+	require.Equal(t, 0, count)
+
+	_, err = a.RefreshSession("not-a-refresh-token", time.Now().Add(time.Hour), time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+	require.Equal(t, ErrRefreshTokenInvalid, err)
+}
+
 func checkSchemaVersion(t *testing.T, db *sql.DB) {
 	rows, err := db.Query(`SELECT version FROM schemaVersion`)
 	require.Nil(t, err)
@@ -1582,6 +2804,12 @@ func checkSchemaVersion(t *testing.T, db *sql.DB) {
 	require.Nil(t, rows.Close())
 }
 
+// outsideHours returns a one hour window (in UTC) that does not include the given time
+func outsideHours(t time.Time) string {
+	start := t.UTC().Add(2 * time.Hour)
+	return fmt.Sprintf("%02d:00-%02d:00", start.Hour(), start.Add(time.Hour).Hour())
+}
+
 func newTestManager(t *testing.T, defaultAccess Permission) *Manager {
 	return newTestManagerFromFile(t, filepath.Join(t.TempDir(), "user.db"), "", defaultAccess, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
 }
@@ -1598,3 +2826,69 @@ func newTestManagerFromFile(t *testing.T, filename, startupQueries string, defau
 	require.Nil(t, err)
 	return a
 }
+
+func TestManager_ChangeBillingPaymentGrace(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+
+	u, err := a.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, int64(0), u.Billing.StripePaymentGraceUntil.Unix())
+
+	graceUntil := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.Nil(t, a.ChangeBillingPaymentGrace("phil", graceUntil))
+	require.Nil(t, a.ChangeBillingPaymentGrace("ben", time.Now().Add(-time.Minute)))
+	u, err = a.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, graceUntil.Unix(), u.Billing.StripePaymentGraceUntil.Unix())
+
+	// Updating the billing fields does not end the grace period
+	require.Nil(t, a.ChangeBilling("phil", &Billing{StripeCustomerID: "acct_123"}))
+	u, err = a.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, graceUntil.Unix(), u.Billing.StripePaymentGraceUntil.Unix())
+
+	users, err := a.UsersWithExpiredPaymentGrace()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(users))
+	require.Equal(t, "ben", users[0].Name)
+
+	require.Nil(t, a.ChangeBillingPaymentGrace("ben", time.Time{}))
+	users, err = a.UsersWithExpiredPaymentGrace()
+	require.Nil(t, err)
+	require.Equal(t, 0, len(users))
+}
+
+func TestManager_Tier_Features(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddTier(&Tier{
+		Code: "pro",
+		Name: "Pro",
+		Features: TierFeatures{
+			TierFeatureCalls:    "false",
+			TierFeatureMaxDelay: "7d",
+		},
+	}))
+	require.Nil(t, a.AddTier(&Tier{Code: "basic", Name: "Basic"}))
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.ChangeTier("phil", "pro"))
+
+	u, err := a.User("phil")
+	require.Nil(t, err)
+	require.False(t, u.Tier.Features.Allowed(TierFeatureCalls))
+	require.Equal(t, 7*24*time.Hour, u.Tier.Features.Duration(TierFeatureMaxDelay, 0))
+
+	tier, err := a.Tier("basic")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(tier.Features))
+
+	tier.Features[TierFeatureTemplates] = "false"
+	require.Nil(t, a.UpdateTier(tier))
+	tier, err = a.Tier("basic")
+	require.Nil(t, err)
+	require.Equal(t, TierFeatures{TierFeatureTemplates: "false"}, tier.Features)
+
+	tier.Features["unknown"] = "true"
+	require.ErrorIs(t, a.UpdateTier(tier), ErrInvalidArgument)
+}
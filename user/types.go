@@ -1,10 +1,16 @@
 package user
 
 import (
+	"crypto/ed25519"
 	"errors"
+	"fmt"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/payments"
+	"heckel.io/ntfy/v2/util"
 	"net/netip"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -13,8 +19,9 @@ import (
 type User struct {
 	ID          string
 	Name        string
-	Hash        string // Password hash (bcrypt)
-	Token       string // Only set if token was used to log in
+	Hash        string      // Password hash (bcrypt)
+	Token       string      // Only set if token was used to log in
+	TokenScopes TokenScopes // Only set if a scoped token was used to log in, see Token.Scopes
 	Role        Role
 	Prefs       *Prefs
 	Tier        *Tier
@@ -44,6 +51,12 @@ func (u *User) IsUser() bool {
 	return u != nil && u.Role == RoleUser
 }
 
+// IsSupport returns true if the user is a support user. Support users have the same topic access
+// as regular users, but may view users and reset passwords and tokens of regular users.
+func (u *User) IsSupport() bool {
+	return u != nil && u.Role == RoleSupport
+}
+
 // Auther is an interface for authentication and authorization
 type Auther interface {
 	// Authenticate checks username and password and returns a user if correct. The method
@@ -58,18 +71,152 @@ type Auther interface {
 
 // Token represents a user token, including expiry date
 type Token struct {
-	Value       string
-	Label       string
-	LastAccess  time.Time
-	LastOrigin  netip.Addr
-	Expires     time.Time
-	Provisioned bool
+	Value         string
+	Label         string
+	LastAccess    time.Time
+	LastOrigin    netip.Addr
+	LastUserAgent string
+	LastCountry   string // Two-letter ISO country code of LastOrigin, empty if unknown (see TokenUpdate)
+	LastASN       uint32 // Autonomous system number of LastOrigin, zero if unknown (see TokenUpdate)
+	Expires       time.Time
+	Provisioned   bool
+	Scopes        TokenScopes // Restrictions of the token, empty if the token has full access
+	Batch         string      // ID of the batch the token was created in, see Manager.CreateTokenBatch
+}
+
+// EphemeralTopic is a short-lived topic with its own access tokens, see Manager.AddEphemeralTopic
+type EphemeralTopic struct {
+	Topic      string
+	ReadToken  *Token
+	WriteToken *Token
+	Expires    time.Time
+}
+
+// TokenScope restricts what an access token can be used for. A token without scopes can do anything the
+// user can do. Scopes of the same kind add up (e.g. "publish" and "subscribe"), while different kinds restrict
+// each other (e.g. "publish" and "topic:alerts" only allows publishing to the topic "alerts").
+type TokenScope string
+
+// Token scopes; topic scopes are created with NewTopicScope
+const (
+	TokenScopePublish   = TokenScope("publish")   // Allows publishing (write access)
+	TokenScopeSubscribe = TokenScope("subscribe") // Allows subscribing (read access)
+	TokenScopeAdmin     = TokenScope("admin")     // Allows using the admin API, only for admins
+	tokenScopeTopic     = "topic:"                // Prefix of topic scopes, e.g. "topic:alerts" or "topic:alerts_*"
+)
+
+// NewTopicScope returns a scope that restricts a token to the topics matching the given topic pattern
+func NewTopicScope(topicPattern string) TokenScope {
+	return TokenScope(tokenScopeTopic + topicPattern)
+}
+
+// TokenScopes is the list of scopes of a token, see TokenScope
+type TokenScopes []TokenScope
+
+// ParseTokenScopes parses and validates a list of scopes, e.g. []string{"publish", "topic:alerts"}.
+// Each entry may also be a comma-separated list of scopes.
+func ParseTokenScopes(scopes []string) (TokenScopes, error) {
+	parsed := make(TokenScopes, 0)
+	for _, scope := range scopes {
+		for _, s := range util.SplitNoEmpty(scope, ",") {
+			scope := TokenScope(strings.TrimSpace(s))
+			if topicPattern, ok := strings.CutPrefix(string(scope), tokenScopeTopic); ok && !AllowedTopicPattern(topicPattern) {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidTokenScope, scope)
+			} else if !ok && scope != TokenScopePublish && scope != TokenScopeSubscribe && scope != TokenScopeAdmin {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidTokenScope, scope)
+			} else if !slices.Contains(parsed, scope) {
+				parsed = append(parsed, scope)
+			}
+		}
+	}
+	return parsed, nil
+}
+
+// Restricted returns true if there are any scopes, i.e. if the token does not have full access
+func (s TokenScopes) Restricted() bool {
+	return len(s) > 0
+}
+
+// AllowTopic returns true if the scopes allow the given permission on the given topic. Only PermissionRead
+// and PermissionWrite are checked. If there are no publish/subscribe scopes, both are allowed, and if there
+// are no topic scopes, all topics are allowed.
+func (s TokenScopes) AllowTopic(topic string, perm Permission) bool {
+	var hasPermScope, permAllowed, hasTopicScope, topicAllowed bool
+	for _, scope := range s {
+		if topicPattern, ok := strings.CutPrefix(string(scope), tokenScopeTopic); ok {
+			hasTopicScope = true
+			if matched, _ := path.Match(topicPattern, topic); matched {
+				topicAllowed = true
+			}
+		} else if scope == TokenScopePublish || scope == TokenScopeSubscribe {
+			hasPermScope = true
+			if (scope == TokenScopePublish && perm == PermissionWrite) || (scope == TokenScopeSubscribe && perm == PermissionRead) {
+				permAllowed = true
+			}
+		}
+	}
+	return (!hasPermScope || permAllowed) && (!hasTopicScope || topicAllowed)
+}
+
+// AllowAdmin returns true if the scopes allow using the admin API
+func (s TokenScopes) AllowAdmin() bool {
+	return !s.Restricted() || slices.Contains(s, TokenScopeAdmin)
 }
 
-// TokenUpdate holds information about the last access time and origin IP address of a token
+// Strings returns the scopes as a list of strings
+func (s TokenScopes) Strings() []string {
+	scopes := make([]string, len(s))
+	for i, scope := range s {
+		scopes[i] = string(scope)
+	}
+	return scopes
+}
+
+// String returns the scopes as a comma-separated list, as stored in the database
+func (s TokenScopes) String() string {
+	return strings.Join(s.Strings(), ",")
+}
+
+// ProvisionDrift lists the entries in the database that are not defined in the config (auth-users, auth-access,
+// auth-tokens), see Manager.ProvisionDrift. Topic reservations, and tokens that expire (e.g. web app sessions),
+// are not considered drift.
+type ProvisionDrift struct {
+	Users  []string            // Users that are not provisioned
+	Access map[string][]*Grant // Access entries of provisioned users (or everyone) that are not provisioned (username -> grants)
+	Tokens map[string][]*Token // Non-expiring tokens of provisioned users that are not provisioned (username -> tokens)
+}
+
+// Empty returns true if there is no drift between the config and the database
+func (d *ProvisionDrift) Empty() bool {
+	return len(d.Users) == 0 && len(d.Access) == 0 && len(d.Tokens) == 0
+}
+
+// WebAuthnCredential is a security key or passkey registered by a user. It is used to confirm
+// destructive admin operations (see require-admin-webauthn).
+type WebAuthnCredential struct {
+	ID        string // Base64 URL-encoded credential ID, as returned by the authenticator
+	PublicKey []byte // PKIX/SPKI DER-encoded public key, as returned by the browser's getPublicKey()
+	SignCount uint32
+	Name      string
+	Created   time.Time
+}
+
+// Email is a user's verified email address. When the address is changed, the previous address is kept
+// as a fallback for a while (see Manager.ChangeEmail), e.g. so that the user can be notified of the change.
+type Email struct {
+	Address       string
+	Fallback      string    // Previous address, empty if there is none or if the fallback period has ended
+	FallbackUntil time.Time // End of the fallback period, zero if there is no fallback address
+}
+
+// TokenUpdate holds information about the last access of a token, i.e. the time, the origin IP address, the user
+// agent, and the country and network of the origin IP address (if GeoIP lookups are enabled on the server)
 type TokenUpdate struct {
-	LastAccess time.Time
-	LastOrigin netip.Addr
+	LastAccess    time.Time
+	LastOrigin    netip.Addr
+	LastUserAgent string
+	LastCountry   string
+	LastASN       uint32
 }
 
 // Prefs represents a user's configuration settings
@@ -95,6 +242,100 @@ type Tier struct {
 	AttachmentBandwidthLimit int64         // Daily bandwidth limit for the user
 	StripeMonthlyPriceID     string        // Monthly price ID for paid tiers (price_...)
 	StripeYearlyPriceID      string        // Yearly price ID for paid tiers (price_...)
+	Features                 TierFeatures  // Feature flags, e.g. whether phone calls are allowed
+}
+
+// TierFeature is the name of a feature flag of a tier, see TierFeatures
+type TierFeature string
+
+// Feature flags of a tier. Boolean features that are not set are allowed, so that new features
+// do not change the behavior of existing tiers.
+const (
+	TierFeatureCalls        = TierFeature("calls")        // Whether phone calls are allowed (bool)
+	TierFeatureEmails       = TierFeature("emails")       // Whether email notifications are allowed (bool)
+	TierFeatureReservations = TierFeature("reservations") // Whether topics can be reserved (bool)
+	TierFeatureTemplates    = TierFeature("templates")    // Whether message templating is allowed (bool)
+	TierFeatureWebhooks     = TierFeature("webhooks")     // Whether outgoing webhooks can be added to reserved topics (bool)
+	TierFeatureTelegram     = TierFeature("telegram")     // Whether reserved topics can be relayed to Telegram (bool)
+	TierFeatureMaxDelay     = TierFeature("max-delay")    // Max. delay of scheduled messages (duration)
+)
+
+// tierFeatureTypes defines the value type of each known feature flag. To add a new feature flag, add it here;
+// no database schema change is needed, since feature flags are stored as a JSON map.
+var tierFeatureTypes = map[TierFeature]tierFeatureType{
+	TierFeatureCalls:        tierFeatureTypeBool,
+	TierFeatureEmails:       tierFeatureTypeBool,
+	TierFeatureReservations: tierFeatureTypeBool,
+	TierFeatureTemplates:    tierFeatureTypeBool,
+	TierFeatureWebhooks:     tierFeatureTypeBool,
+	TierFeatureTelegram:     tierFeatureTypeBool,
+	TierFeatureMaxDelay:     tierFeatureTypeDuration,
+}
+
+type tierFeatureType int
+
+const (
+	tierFeatureTypeBool tierFeatureType = iota
+	tierFeatureTypeDuration
+)
+
+// TierFeatures maps feature flags to their values, e.g. "calls" -> "false", or "max-delay" -> "7d"
+type TierFeatures map[TierFeature]string
+
+// Allowed returns whether the given boolean feature is allowed. Features that are not set are allowed.
+func (f TierFeatures) Allowed(feature TierFeature) bool {
+	value, ok := f[feature]
+	if !ok {
+		return true
+	}
+	allowed, err := strconv.ParseBool(value)
+	return err != nil || allowed
+}
+
+// Duration returns the value of the given duration feature, or the default value if it is not set
+func (f TierFeatures) Duration(feature TierFeature, defaultValue time.Duration) time.Duration {
+	value, ok := f[feature]
+	if !ok {
+		return defaultValue
+	}
+	d, err := util.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return d
+}
+
+// ValidateTierFeature checks that the feature flag is known, and that the value has the right type
+func ValidateTierFeature(feature TierFeature, value string) error {
+	featureType, ok := tierFeatureTypes[feature]
+	if !ok {
+		return fmt.Errorf("%w: unknown tier feature %s", ErrInvalidArgument, feature)
+	}
+	switch featureType {
+	case tierFeatureTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%w: tier feature %s must be true or false", ErrInvalidArgument, feature)
+		}
+	case tierFeatureTypeDuration:
+		if _, err := util.ParseDuration(value); err != nil {
+			return fmt.Errorf("%w: tier feature %s must be a duration", ErrInvalidArgument, feature)
+		}
+	}
+	return nil
+}
+
+// ParseTierFeature parses a feature flag in the format "name=value", e.g. "calls=false" or "max-delay=7d"
+func ParseTierFeature(s string) (TierFeature, string, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return "", "", fmt.Errorf("%w: tier feature must be in the format name=value", ErrInvalidArgument)
+	}
+	feature := TierFeature(strings.TrimSpace(name))
+	value = strings.TrimSpace(value)
+	if err := ValidateTierFeature(feature, value); err != nil {
+		return "", "", err
+	}
+	return feature, value, nil
 }
 
 // Context returns fields for the log
@@ -109,9 +350,10 @@ func (t *Tier) Context() log.Context {
 
 // Subscription represents a user's topic subscription
 type Subscription struct {
-	BaseURL     string  `json:"base_url"`
-	Topic       string  `json:"topic"`
-	DisplayName *string `json:"display_name"`
+	BaseURL     string         `json:"base_url"`
+	Topic       string         `json:"topic"`
+	DisplayName *string        `json:"display_name"`
+	Delivery    *DeliveryPrefs `json:"delivery,omitempty"`
 }
 
 // Context returns fields for the log
@@ -122,6 +364,100 @@ func (s *Subscription) Context() log.Context {
 	}
 }
 
+// DeliveryPrefs defines through which channels messages of a subscription are delivered to the user.
+// If a channel is not defined, messages are delivered through it as usual.
+type DeliveryPrefs struct {
+	Push    *ChannelPrefs `json:"push,omitempty"`
+	WebPush *ChannelPrefs `json:"web_push,omitempty"`
+	Email   *ChannelPrefs `json:"email,omitempty"`
+	Call    *ChannelPrefs `json:"call,omitempty"`
+}
+
+// ChannelPrefs defines whether a delivery channel is enabled, and optionally restricts it to
+// certain hours of the day, e.g. "09:00-17:00" (in the given time zone, UTC by default)
+type ChannelPrefs struct {
+	Enabled  bool   `json:"enabled"`
+	Hours    string `json:"hours,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Validate checks that the hours and time zone of all channels can be parsed
+func (p *DeliveryPrefs) Validate() error {
+	for _, c := range []*ChannelPrefs{p.Push, p.WebPush, p.Email, p.Call} {
+		if c == nil {
+			continue
+		}
+		if _, _, err := parseHours(c.Hours); err != nil {
+			return err
+		} else if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return ErrInvalidTimezone
+		}
+	}
+	return nil
+}
+
+// Allowed returns true if the channel is enabled at the given time. A nil ChannelPrefs is always allowed.
+func (c *ChannelPrefs) Allowed(t time.Time) bool {
+	if c == nil {
+		return true
+	} else if !c.Enabled {
+		return false
+	}
+	return withinHours(c.Hours, c.Timezone, t, true) // Validated when saving; fail open rather than dropping messages
+}
+
+// withinHours returns true if the time is within the hour window (e.g. "09:00-17:00") in the given time zone
+// (UTC if empty). An empty window includes the whole day. If the window cannot be parsed, failOpen is returned.
+func withinHours(hours, timezone string, t time.Time, failOpen bool) bool {
+	if hours == "" {
+		return true
+	}
+	start, end, err := parseHours(hours)
+	if err != nil {
+		return failOpen
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end // Window spans midnight, e.g. 22:00-06:00
+}
+
+// parseHours parses an hour window like "09:00-17:00" and returns the start and end as minutes of the day.
+// An empty string is valid and returns the full day.
+func parseHours(hours string) (start int, end int, err error) {
+	if hours == "" {
+		return 0, 24 * 60, nil
+	}
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidHours
+	}
+	if start, err = parseMinuteOfDay(parts[0]); err != nil {
+		return 0, 0, err
+	} else if end, err = parseMinuteOfDay(parts[1]); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseMinuteOfDay(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, ErrInvalidHours
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
 // NotificationPrefs represents the user's notification settings
 type NotificationPrefs struct {
 	Sound       *string `json:"sound,omitempty"`
@@ -136,6 +472,60 @@ type Stats struct {
 	Calls    int64
 }
 
+// StatsRollupPeriod is the aggregation period of a StatsRollup
+type StatsRollupPeriod string
+
+// Stats rollup periods
+const (
+	StatsRollupPeriodHour = StatsRollupPeriod("hour")
+	StatsRollupPeriodDay  = StatsRollupPeriod("day")
+)
+
+// StatsRollup holds aggregated server statistics for one hour or day. Visitors and Users are the number of
+// distinct visitors and users seen in the hour (for daily rollups, the peak hourly value).
+type StatsRollup struct {
+	Period   StatsRollupPeriod
+	Start    time.Time
+	Messages int64
+	Emails   int64
+	Calls    int64
+	Visitors int64
+	Users    int64
+}
+
+// UsageStats holds the number of published messages, subscribe requests (including polls), and uploaded
+// attachments of a user (or anonymous visitors) for a topic and day
+type UsageStats struct {
+	Day             time.Time // Start of the day (UTC)
+	UserID          string    // Empty for anonymous usage
+	Username        string    // Only set by Manager.TopUsageStats
+	Topic           string
+	Messages        int64
+	Subscriptions   int64
+	Attachments     int64
+	AttachmentBytes int64
+}
+
+// UsageGroup defines how usage stats are aggregated, see Manager.TopUsageStats
+type UsageGroup string
+
+// Usage stats groups
+const (
+	UsageGroupUser  = UsageGroup("user")
+	UsageGroupTopic = UsageGroup("topic")
+)
+
+// UsageSort is the counter by which usage stats are sorted, see Manager.TopUsageStats
+type UsageSort string
+
+// Usage stats sort orders
+const (
+	UsageSortMessages        = UsageSort("messages")
+	UsageSortSubscriptions   = UsageSort("subscriptions")
+	UsageSortAttachments     = UsageSort("attachments")
+	UsageSortAttachmentBytes = UsageSort("attachment_bytes")
+)
+
 // Billing is a struct holding a user's billing information
 type Billing struct {
 	StripeCustomerID            string
@@ -144,15 +534,123 @@ type Billing struct {
 	StripeSubscriptionInterval  payments.PriceRecurringInterval
 	StripeSubscriptionPaidUntil time.Time
 	StripeSubscriptionCancelAt  time.Time
+	StripePaymentGraceUntil     time.Time // Set if a payment failed; the tier is kept until then
 }
 
 // Grant is a struct that represents an access control entry to a topic by a user
 type Grant struct {
 	TopicPattern string // May include wildcard (*)
 	Permission   Permission
-	Provisioned  bool // Whether the grant was provisioned by the config file
+	Provisioned  bool         // Whether the grant was provisioned by the config file
+	Window       *GrantWindow // Optional validity window, nil if the grant is always valid
+}
+
+// Group is a named set of users, e.g. a team. Access control entries of a group apply to all of its
+// members, see Manager.AllowGroupAccess.
+type Group struct {
+	ID      string
+	Name    string
+	Members []string // Usernames of the members
+}
+
+// BanKind defines what a ban applies to, see Ban
+type BanKind string
+
+// Ban kinds
+const (
+	BanKindIP    = BanKind("ip")    // An IP address or range, e.g. 1.2.3.4 or 2001:db8::/32
+	BanKindUser  = BanKind("user")  // A user, identified by username
+	BanKindToken = BanKind("token") // A single access token
+)
+
+// Ban blocks all requests from an IP range, a user, or an access token until it expires, see Manager.AddBan
+type Ban struct {
+	ID      string
+	Kind    BanKind
+	Value   string // IP prefix (e.g. 1.2.3.4/32), username, or token, depending on the kind
+	Reason  string
+	Created time.Time
+	Expires time.Time // Unix 0 if the ban never expires
+}
+
+// Expired returns true if the ban has an expiry time, and that time has passed
+func (b *Ban) Expired() bool {
+	return b.Expires.Unix() > 0 && time.Now().After(b.Expires)
+}
+
+// Session is a login session of the web app, consisting of a short-lived access token and a longer-lived refresh
+// token. Every time the refresh token is used, it is replaced by a new one, and a new access token is issued, see
+// Manager.RefreshSession.
+type Session struct {
+	ID             string
+	UserID         string
+	AccessToken    *Token
+	RefreshToken   string
+	RefreshExpires time.Time
+}
+
+// GroupPrefix is used to refer to a group instead of a user, e.g. in "ntfy access group:devops alerts-* rw"
+const GroupPrefix = "group:"
+
+// GrantWindow restricts the validity of a Grant, e.g. to give a contractor access to "deploy-*" until a
+// certain date, or only during on-call hours. Outside the window, the grant is ignored, as if it did not exist.
+type GrantWindow struct {
+	Expires  time.Time // Zero if the grant does not expire
+	Hours    string    // Hours of the day during which the grant is valid, e.g. "18:00-08:00", empty for all day
+	Timezone string    // Time zone of Hours, UTC by default
+}
+
+// Validate checks that the hours and time zone of the window can be parsed
+func (w *GrantWindow) Validate() error {
+	if _, _, err := parseHours(w.Hours); err != nil {
+		return err
+	} else if _, err := time.LoadLocation(w.Timezone); err != nil {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
+
+// Active returns true if the window includes the given time. A nil GrantWindow is always active.
+func (w *GrantWindow) Active(t time.Time) bool {
+	if w == nil {
+		return true
+	} else if !w.Expires.IsZero() && !t.Before(w.Expires) {
+		return false
+	}
+	return withinHours(w.Hours, w.Timezone, t, false)
+}
+
+// Decision explains the outcome of an authorization check, i.e. which rule allowed or denied access, see Manager.Explain
+type Decision struct {
+	Allowed bool
+	Rule    DecisionRule
+	Entry   *DecisionEntry   // ACL entry that produced the decision, nil for DecisionRuleAdmin, DecisionRuleWebhook and DecisionRuleDefault
+	Skipped []*DecisionEntry // ACL entries matching the topic that were ignored because they were outside their validity window
+}
+
+// DecisionEntry is an ACL entry that matched the topic during an authorization check
+type DecisionEntry struct {
+	Grant
+	Username string // User the entry belongs to, i.e. the user itself or Everyone
+	Group    string // Group the entry belongs to if it is a group entry, empty otherwise
+	Owner    string // User that reserved the topic if the entry was created by a reservation, empty otherwise
 }
 
+// DecisionRule describes which kind of rule produced an authorization decision
+type DecisionRule string
+
+// Authorization decision rules, in order of precedence
+const (
+	DecisionRuleTokenScope  = DecisionRule("token-scope") // Denied by the scopes of the access token, see Token.Scopes
+	DecisionRuleAdmin       = DecisionRule("admin")       // Admins can do everything
+	DecisionRuleReservation = DecisionRule("reservation") // ACL entry created by a topic reservation (see tier reservations)
+	DecisionRuleUser        = DecisionRule("user")        // ACL entry of the user
+	DecisionRuleGroup       = DecisionRule("group")       // ACL entry of a group the user is a member of
+	DecisionRuleEveryone    = DecisionRule("everyone")    // ACL entry of the anonymous user, which applies to all users
+	DecisionRuleWebhook     = DecisionRule("webhook")     // Decided by the external auth webhook, see Config.AuthWebhookURL
+	DecisionRuleDefault     = DecisionRule("default")     // No matching ACL entry, the default access applies (auth-default-access)
+)
+
 // Reservation is a struct that represents the ownership over a topic by a user
 type Reservation struct {
 	Topic    string
@@ -160,6 +658,108 @@ type Reservation struct {
 	Everyone Permission
 }
 
+// EmailTemplate is a topic owner's custom subject and body template for messages forwarded via email.
+// Both are Go templates (with sprig functions) that are rendered with the message as input.
+type EmailTemplate struct {
+	Topic   string
+	Subject string
+	Body    string
+}
+
+// TopicTemplate is a topic owner's transformation template, which is applied to all JSON messages published to the
+// topic (unless the publisher passes its own template). All fields are Go templates (with sprig functions) that are
+// rendered with the JSON body as input. Empty fields are not rendered.
+type TopicTemplate struct {
+	Topic    string
+	Title    string
+	Message  string
+	Tags     string // Renders to a comma-separated list of tags
+	Priority string // Renders to a priority, e.g. "5" or "high"
+}
+
+// TopicPolicy overrides the server-wide retention and size limits for a single topic. Zero values mean that
+// the server-wide (or tier) limit applies.
+type TopicPolicy struct {
+	Topic                   string
+	Owner                   string        // User ID of the topic owner, or empty if the policy is managed by an admin
+	CacheDuration           time.Duration // Duration for which messages are kept, replaces the cache duration of the publisher
+	MessageLimit            int64         // Max number of messages kept for the topic, older messages are pruned
+	AttachmentFileSizeLimit int64         // Max size of a single attachment, may only lower the publisher's limit
+}
+
+// TopicWebhook is an outgoing webhook of a topic. All messages published to the topic are POSTed to its URL,
+// signed with its secret (HMAC-SHA256), so that the receiver can verify that they came from this server.
+type TopicWebhook struct {
+	ID      string
+	Topic   string
+	URL     string
+	Format  WebhookFormat
+	Secret  string
+	Created time.Time
+}
+
+// TopicSigningKey is an Ed25519 public key registered for a topic. If a topic has signing keys, messages published
+// to it must carry a valid signature (X-Signature header) made with one of the corresponding private keys.
+type TopicSigningKey struct {
+	ID        string
+	Topic     string
+	PublicKey ed25519.PublicKey
+	Created   time.Time
+}
+
+// WebhookFormat defines the payload that is POSTed to a webhook
+type WebhookFormat string
+
+// Webhook formats
+const (
+	WebhookFormatNtfy    = WebhookFormat("ntfy")    // The message as JSON, as it is returned by the JSON stream
+	WebhookFormatSlack   = WebhookFormat("slack")   // Slack incoming webhook, using Block Kit
+	WebhookFormatDiscord = WebhookFormat("discord") // Discord webhook, using an embed
+)
+
+// WebhookDelivery is the outcome of delivering a message to a webhook, after all attempts
+type WebhookDelivery struct {
+	WebhookID  string
+	MessageID  string
+	Time       time.Time
+	Attempts   int
+	StatusCode int    // HTTP status code of the last attempt, or 0 if the request failed
+	Error      string // Empty if the delivery succeeded
+}
+
+// TopicTelegram defines the Telegram chat that messages of a topic are relayed to, see Manager.ChangeTopicTelegram
+type TopicTelegram struct {
+	Topic    string
+	BotToken string // Token of the Telegram bot, e.g. 123456789:AAE...
+	ChatID   string // Numeric chat ID (e.g. -1001234567890), or @channelusername
+}
+
+// TopicIngest defines the source and secret used to verify the signatures of webhooks that are published
+// to a topic, see Manager.ChangeTopicIngest
+type TopicIngest struct {
+	Topic  string
+	Source IngestSource
+	Secret string // Shared secret used to compute the HMAC signature
+}
+
+// TopicKey is a shared key (passphrase) that grants access to a topic without a user account, see
+// Manager.ChangeTopicKey
+type TopicKey struct {
+	Topic      string
+	Hash       string     // Bcrypt hash of the key
+	Permission Permission // Permission granted to anyone who knows the key
+}
+
+// IngestSource defines the service that sends webhooks to a topic, which determines how its signature is verified
+type IngestSource string
+
+// Ingest sources
+const (
+	IngestSourceGitHub  = IngestSource("github")  // X-Hub-Signature-256 header, e.g. GitHub, Gitea, Forgejo
+	IngestSourceStripe  = IngestSource("stripe")  // Stripe-Signature header with timestamp
+	IngestSourceGrafana = IngestSource("grafana") // X-Grafana-Alerting-Signature header, with optional timestamp
+)
+
 // Permission represents a read or write permission to a topic
 type Permission uint8
 
@@ -226,12 +826,13 @@ func (p Permission) String() string {
 	return "deny-all"
 }
 
-// Role represents a user's role, either admin or regular user
+// Role represents a user's role, either admin, support or regular user
 type Role string
 
 // User roles
 const (
 	RoleAdmin     = Role("admin") // Some queries have these values hardcoded!
+	RoleSupport   = Role("support")
 	RoleUser      = Role("user")
 	RoleAnonymous = Role("anonymous")
 )
@@ -244,18 +845,41 @@ const (
 
 // Error constants used by the package
 var (
-	ErrUnauthenticated        = errors.New("unauthenticated")
-	ErrUnauthorized           = errors.New("unauthorized")
-	ErrInvalidArgument        = errors.New("invalid argument")
-	ErrUserNotFound           = errors.New("user not found")
-	ErrUserExists             = errors.New("user already exists")
-	ErrPasswordHashInvalid    = errors.New("password hash must be a bcrypt hash, use 'ntfy user hash' to generate")
-	ErrPasswordHashWeak       = errors.New("password hash too weak, use 'ntfy user hash' to generate")
-	ErrTierNotFound           = errors.New("tier not found")
-	ErrTokenNotFound          = errors.New("token not found")
-	ErrPhoneNumberNotFound    = errors.New("phone number not found")
-	ErrTooManyReservations    = errors.New("new tier has lower reservation limit")
-	ErrPhoneNumberExists      = errors.New("phone number already exists")
-	ErrProvisionedUserChange  = errors.New("cannot change or delete provisioned user")
-	ErrProvisionedTokenChange = errors.New("cannot change or delete provisioned token")
+	ErrUnauthenticated          = errors.New("unauthenticated")
+	ErrUnauthorized             = errors.New("unauthorized")
+	ErrInvalidArgument          = errors.New("invalid argument")
+	ErrUserNotFound             = errors.New("user not found")
+	ErrUserExists               = errors.New("user already exists")
+	ErrPasswordHashInvalid      = errors.New("password hash must be a bcrypt hash, use 'ntfy user hash' to generate")
+	ErrPasswordHashWeak         = errors.New("password hash too weak, use 'ntfy user hash' to generate")
+	ErrTierNotFound             = errors.New("tier not found")
+	ErrTokenNotFound            = errors.New("token not found")
+	ErrPhoneNumberNotFound      = errors.New("phone number not found")
+	ErrTooManyReservations      = errors.New("new tier has lower reservation limit")
+	ErrPhoneNumberExists        = errors.New("phone number already exists")
+	ErrProvisionedUserChange    = errors.New("cannot change or delete provisioned user")
+	ErrProvisionedTokenChange   = errors.New("cannot change or delete provisioned token")
+	ErrEmailTemplateNotFound    = errors.New("email template not found")
+	ErrTopicTemplateNotFound    = errors.New("topic template not found")
+	ErrTopicPolicyNotFound      = errors.New("topic policy not found")
+	ErrTopicWebhookNotFound     = errors.New("topic webhook not found")
+	ErrTooManyTopicWebhooks     = errors.New("too many webhooks for topic")
+	ErrTopicSigningKeyNotFound  = errors.New("topic signing key not found")
+	ErrTopicSigningKeyExists    = errors.New("topic signing key already exists")
+	ErrTooManyTopicSigningKeys  = errors.New("too many signing keys for topic")
+	ErrTopicTelegramNotFound    = errors.New("topic telegram relay not found")
+	ErrTopicIngestNotFound      = errors.New("topic ingest settings not found")
+	ErrTopicKeyNotFound         = errors.New("topic key not found")
+	ErrInvalidHours             = errors.New("invalid hours, expected format HH:MM-HH:MM")
+	ErrInvalidTimezone          = errors.New("invalid time zone")
+	ErrWebAuthnCredentialExists = errors.New("webauthn credential already exists")
+	ErrEmailNotFound            = errors.New("email address not found")
+	ErrEmailExists              = errors.New("email address already exists")
+	ErrInvalidTokenScope        = errors.New("invalid token scope")
+	ErrGroupNotFound            = errors.New("group not found")
+	ErrGroupExists              = errors.New("group already exists")
+	ErrBanNotFound              = errors.New("ban not found")
+	ErrRefreshTokenInvalid      = errors.New("refresh token invalid or expired")
+	ErrRefreshTokenReused       = errors.New("refresh token reused, session revoked")
+	ErrSessionTokenChange       = errors.New("cannot change session token")
 )
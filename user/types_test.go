@@ -3,6 +3,7 @@ package user
 import (
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestPermission(t *testing.T) {
@@ -73,3 +74,95 @@ func TestUsernameRegex(t *testing.T) {
 	require.True(t, AllowedUsername(usernameEmailAlias))
 	require.False(t, AllowedUsername(usernameInvalid))
 }
+
+func TestChannelPrefs_Allowed(t *testing.T) {
+	var nilPrefs *ChannelPrefs
+	require.True(t, nilPrefs.Allowed(time.Now()))
+	require.False(t, (&ChannelPrefs{Enabled: false}).Allowed(time.Now()))
+	require.True(t, (&ChannelPrefs{Enabled: true}).Allowed(time.Now()))
+
+	workHours := &ChannelPrefs{Enabled: true, Hours: "09:00-17:00", Timezone: "America/New_York"}
+	require.True(t, workHours.Allowed(time.Date(2024, 1, 15, 14, 0, 0, 0, time.UTC)))   // 09:00 in New York
+	require.False(t, workHours.Allowed(time.Date(2024, 1, 15, 13, 59, 0, 0, time.UTC))) // 08:59 in New York
+	require.False(t, workHours.Allowed(time.Date(2024, 1, 15, 22, 0, 0, 0, time.UTC)))  // 17:00 in New York
+
+	overnight := &ChannelPrefs{Enabled: true, Hours: "22:00-06:00"}
+	require.True(t, overnight.Allowed(time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)))
+	require.True(t, overnight.Allowed(time.Date(2024, 1, 15, 5, 59, 0, 0, time.UTC)))
+	require.False(t, overnight.Allowed(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestDeliveryPrefs_Validate(t *testing.T) {
+	require.Nil(t, (&DeliveryPrefs{}).Validate())
+	require.Nil(t, (&DeliveryPrefs{WebPush: &ChannelPrefs{Enabled: true, Hours: "09:00-24:00", Timezone: "Europe/Berlin"}}).Validate())
+	require.Equal(t, ErrInvalidHours, (&DeliveryPrefs{Email: &ChannelPrefs{Hours: "9-5"}}).Validate())
+	require.Equal(t, ErrInvalidHours, (&DeliveryPrefs{Email: &ChannelPrefs{Hours: "25:00-26:00"}}).Validate())
+	require.Equal(t, ErrInvalidTimezone, (&DeliveryPrefs{Call: &ChannelPrefs{Timezone: "Nowhere/Land"}}).Validate())
+	require.Equal(t, ErrInvalidHours, (&DeliveryPrefs{Push: &ChannelPrefs{Hours: "17:00"}}).Validate())
+}
+
+func TestTierFeatures(t *testing.T) {
+	features := TierFeatures{
+		TierFeatureCalls:    "false",
+		TierFeatureMaxDelay: "7d",
+	}
+	require.False(t, features.Allowed(TierFeatureCalls))
+	require.True(t, features.Allowed(TierFeatureEmails)) // Not set
+	require.Equal(t, 7*24*time.Hour, features.Duration(TierFeatureMaxDelay, time.Hour))
+	require.Equal(t, time.Hour, TierFeatures{}.Duration(TierFeatureMaxDelay, time.Hour))
+
+	feature, value, err := ParseTierFeature(" templates = true")
+	require.Nil(t, err)
+	require.Equal(t, TierFeatureTemplates, feature)
+	require.Equal(t, "true", value)
+
+	_, _, err = ParseTierFeature("templates")
+	require.ErrorIs(t, err, ErrInvalidArgument)
+	_, _, err = ParseTierFeature("unknown=true")
+	require.ErrorIs(t, err, ErrInvalidArgument)
+	_, _, err = ParseTierFeature("calls=maybe")
+	require.ErrorIs(t, err, ErrInvalidArgument)
+	_, _, err = ParseTierFeature("max-delay=soon")
+	require.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestGrantWindow_Active(t *testing.T) {
+	now := time.Date(2026, 3, 14, 20, 30, 0, 0, time.UTC)
+	require.True(t, (*GrantWindow)(nil).Active(now))
+	require.True(t, (&GrantWindow{Expires: now.Add(time.Minute)}).Active(now))
+	require.False(t, (&GrantWindow{Expires: now}).Active(now))
+	require.True(t, (&GrantWindow{Hours: "18:00-08:00"}).Active(now))
+	require.False(t, (&GrantWindow{Hours: "04:00-06:00"}).Active(now))
+	require.True(t, (&GrantWindow{Hours: "04:00-06:00", Timezone: "Asia/Tokyo"}).Active(now)) // 05:30 in Tokyo
+	require.False(t, (&GrantWindow{Hours: "invalid"}).Active(now))                            // Fails closed, unlike ChannelPrefs
+	require.Equal(t, ErrInvalidHours, (&GrantWindow{Hours: "9-5"}).Validate())
+	require.Equal(t, ErrInvalidTimezone, (&GrantWindow{Timezone: "Nowhere/Land"}).Validate())
+}
+
+func TestTokenScopes(t *testing.T) {
+	scopes, err := ParseTokenScopes([]string{"subscribe, topic:backup_*", "subscribe"})
+	require.Nil(t, err)
+	require.Equal(t, TokenScopes{TokenScopeSubscribe, NewTopicScope("backup_*")}, scopes)
+	require.True(t, scopes.Restricted())
+	require.True(t, scopes.AllowTopic("backup_db", PermissionRead))
+	require.False(t, scopes.AllowTopic("backup_db", PermissionWrite))
+	require.False(t, scopes.AllowTopic("alerts", PermissionRead))
+	require.False(t, scopes.AllowAdmin())
+
+	scopes, err = ParseTokenScopes([]string{"publish", "subscribe", "admin"})
+	require.Nil(t, err)
+	require.True(t, scopes.AllowTopic("alerts", PermissionRead))
+	require.True(t, scopes.AllowTopic("alerts", PermissionWrite))
+	require.True(t, scopes.AllowAdmin())
+
+	require.False(t, TokenScopes{}.Restricted())
+	require.True(t, TokenScopes{}.AllowTopic("alerts", PermissionWrite))
+	require.True(t, TokenScopes{}.AllowAdmin())
+
+	_, err = ParseTokenScopes([]string{"delete"})
+	require.ErrorIs(t, err, ErrInvalidTokenScope)
+	_, err = ParseTokenScopes([]string{"topic:"})
+	require.ErrorIs(t, err, ErrInvalidTokenScope)
+	_, err = ParseTokenScopes([]string{"topic:a/b"})
+	require.ErrorIs(t, err, ErrInvalidTokenScope)
+}
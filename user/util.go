@@ -3,6 +3,7 @@ package user
 import (
 	"golang.org/x/crypto/bcrypt"
 	"heckel.io/ntfy/v2/util"
+	"net/netip"
 	"regexp"
 	"strings"
 )
@@ -12,12 +13,33 @@ var (
 	allowedTopicRegex        = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)  // No '*'
 	allowedTopicPatternRegex = regexp.MustCompile(`^[-_*A-Za-z0-9]{1,64}$`) // Adds '*' for wildcards!
 	allowedTierRegex         = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+	allowedGroupRegex        = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
 	allowedTokenRegex        = regexp.MustCompile(`^tk_[-_A-Za-z0-9]{29}$`) // Must be tokenLength-len(tokenPrefix)
 )
 
 // AllowedRole returns true if the given role can be used for new users
 func AllowedRole(role Role) bool {
-	return role == RoleUser || role == RoleAdmin
+	return role == RoleUser || role == RoleSupport || role == RoleAdmin
+}
+
+// AllowedWebhookFormat returns true if the given format can be used for new webhooks
+func AllowedWebhookFormat(format WebhookFormat) bool {
+	return format == WebhookFormatNtfy || format == WebhookFormatSlack || format == WebhookFormatDiscord
+}
+
+// AllowedIngestSource returns true if the given ingest source is supported
+func AllowedIngestSource(source IngestSource) bool {
+	return source == IngestSourceGitHub || source == IngestSourceStripe || source == IngestSourceGrafana
+}
+
+// AllowedBanKind returns true if the given ban kind is supported
+func AllowedBanKind(kind BanKind) bool {
+	return kind == BanKindIP || kind == BanKindUser || kind == BanKindToken
+}
+
+// AllowedTopicKey returns true if the given topic key (shared passphrase) has a valid length
+func AllowedTopicKey(key string) bool {
+	return len(key) >= topicKeyLengthMin && len(key) <= topicKeyLengthMax
 }
 
 // AllowedUsername returns true if the given username is valid
@@ -40,6 +62,11 @@ func AllowedTier(tier string) bool {
 	return allowedTierRegex.MatchString(tier)
 }
 
+// AllowedGroup returns true if the given group name is valid
+func AllowedGroup(group string) bool {
+	return allowedGroupRegex.MatchString(group)
+}
+
 // ValidPasswordHash checks if the given password hash is a valid bcrypt hash
 func ValidPasswordHash(hash string, minCost int) error {
 	if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
@@ -77,3 +104,31 @@ func hashPassword(password string, cost int) (string, error) {
 	}
 	return string(hash), nil
 }
+
+// normalizeBanValue validates the value of a ban of the given kind, and converts IP addresses and ranges
+// to their canonical prefix form, e.g. 1.2.3.4 to 1.2.3.4/32, or 10.1.2.3/8 to 10.0.0.0/8
+func normalizeBanValue(kind BanKind, value string) (string, error) {
+	switch kind {
+	case BanKindIP:
+		if addr, err := netip.ParseAddr(value); err == nil {
+			addr = addr.Unmap()
+			return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return "", ErrInvalidArgument
+		}
+		return prefix.Masked().String(), nil
+	case BanKindUser:
+		if !AllowedUsername(value) || value == Everyone {
+			return "", ErrInvalidArgument
+		}
+		return value, nil
+	case BanKindToken:
+		if !ValidToken(value) {
+			return "", ErrInvalidArgument
+		}
+		return value, nil
+	}
+	return "", ErrInvalidArgument
+}
@@ -0,0 +1,150 @@
+package user
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+)
+
+const (
+	authWebhookCacheMaxSize     = 10000
+	authWebhookMaxResponseBytes = 4096
+)
+
+// authWebhook asks an external HTTP endpoint, e.g. a central policy engine, whether a user may access a topic
+// (see Config.AuthWebhookURL). For every check, the endpoint receives a JSON request (authWebhookRequest) and
+// may respond with:
+//   - 200 OK with {"allow":true} or {"allow":false}, to allow or deny access
+//   - 204 No Content, to leave the decision to the built-in access control list
+//
+// Any other response, as well as timeouts and connection errors, deny access. Decisions (but not errors)
+// are cached for Config.AuthWebhookCacheTTL.
+type authWebhook struct {
+	url      string
+	client   *http.Client
+	cacheTTL time.Duration
+	cache    map[authWebhookCacheKey]*authWebhookCacheEntry
+	mu       sync.Mutex
+}
+
+type authWebhookRequest struct {
+	Username   string `json:"username"` // "*" for anonymous users
+	Role       Role   `json:"role"`
+	Topic      string `json:"topic"`
+	Permission string `json:"permission"` // "read" or "write"
+}
+
+type authWebhookResponse struct {
+	Allow bool `json:"allow"`
+}
+
+type authWebhookCacheKey struct {
+	username   string
+	role       Role
+	topic      string
+	permission string
+}
+
+type authWebhookCacheEntry struct {
+	decision *Decision // nil if the webhook left the decision to the built-in access control list
+	expires  time.Time
+}
+
+func newAuthWebhook(url string, timeout, cacheTTL time.Duration) *authWebhook {
+	return &authWebhook{
+		url:      url,
+		client:   &http.Client{Timeout: timeout},
+		cacheTTL: cacheTTL,
+		cache:    make(map[authWebhookCacheKey]*authWebhookCacheEntry),
+	}
+}
+
+// Explain returns the decision of the webhook, or nil if the webhook left the decision to the
+// built-in access control list
+func (w *authWebhook) Explain(user *User, topic string, perm Permission) *Decision {
+	key := authWebhookCacheKey{username: Everyone, role: RoleAnonymous, topic: topic, permission: "read"}
+	if user != nil {
+		key.username, key.role = user.Name, user.Role
+	}
+	if perm == PermissionWrite {
+		key.permission = "write"
+	}
+	if decision, ok := w.cached(key); ok {
+		return decision
+	}
+	decision, err := w.request(key)
+	if err != nil {
+		log.Tag(tag).Field("user_name", key.username).Field("topic", topic).Err(err).Warn("Auth webhook failed, denying access")
+		return &Decision{Allowed: false, Rule: DecisionRuleWebhook}
+	}
+	w.store(key, decision)
+	return decision
+}
+
+func (w *authWebhook) request(key authWebhookCacheKey) (*Decision, error) {
+	body, err := json.Marshal(&authWebhookRequest{
+		Username:   key.username,
+		Role:       key.role,
+		Topic:      key.topic,
+		Permission: key.permission,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+	var response authWebhookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, authWebhookMaxResponseBytes)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &Decision{Allowed: response.Allow, Rule: DecisionRuleWebhook}, nil
+}
+
+func (w *authWebhook) cached(key authWebhookCacheKey) (*Decision, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry, ok := w.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.decision, true
+}
+
+func (w *authWebhook) store(key authWebhookCacheKey, decision *Decision) {
+	if w.cacheTTL <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	if len(w.cache) >= authWebhookCacheMaxSize {
+		for k, entry := range w.cache {
+			if now.After(entry.expires) {
+				delete(w.cache, k)
+			}
+		}
+		if len(w.cache) >= authWebhookCacheMaxSize {
+			w.cache = make(map[authWebhookCacheKey]*authWebhookCacheEntry)
+		}
+	}
+	w.cache[key] = &authWebhookCacheEntry{decision: decision, expires: now.Add(w.cacheTTL)}
+}
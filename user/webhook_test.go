@@ -0,0 +1,94 @@
+package user
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestManager_AuthWebhook(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req authWebhookRequest
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		switch {
+		case req.Topic == "builtin":
+			w.WriteHeader(http.StatusNoContent) // Leave the decision to the ACL
+		case req.Topic == "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case req.Username == "ben" && req.Role == RoleUser && req.Permission == "read":
+			w.Write([]byte(`{"allow":true}`))
+		default:
+			w.Write([]byte(`{"allow":false}`))
+		}
+	}))
+	defer server.Close()
+
+	a := newTestManagerWithAuthWebhook(t, server.URL, time.Minute)
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin, false))
+	require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite)) // Ignored, the webhook decides
+	require.Nil(t, a.AllowAccess("ben", "builtin", PermissionRead))
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+
+	decision, err := a.Explain(ben, "mytopic", PermissionRead)
+	require.Nil(t, err)
+	require.True(t, decision.Allowed)
+	require.Equal(t, DecisionRuleWebhook, decision.Rule)
+	require.Nil(t, a.Authorize(ben, "mytopic", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "mytopic", PermissionWrite))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionRead))
+	require.Equal(t, int32(3), requests.Load()) // Second read of ben was cached
+
+	// Admins are not checked, 204 falls back to the ACL, errors deny access
+	require.Nil(t, a.Authorize(phil, "mytopic", PermissionWrite))
+	require.Nil(t, a.Authorize(ben, "builtin", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "builtin", PermissionWrite))
+	decision, err = a.Explain(ben, "builtin", PermissionRead)
+	require.Nil(t, err)
+	require.Equal(t, DecisionRuleUser, decision.Rule)
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "broken", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "broken", PermissionRead))
+	require.Equal(t, int32(7), requests.Load()) // Errors are not cached
+}
+
+func TestManager_AuthWebhook_NoCache_Timeout(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 2 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		w.Write([]byte(`{"allow":true}`))
+	}))
+	defer server.Close()
+
+	a := newTestManagerWithAuthWebhook(t, server.URL, 0)
+	a.webhook.client.Timeout = 100 * time.Millisecond
+	require.Nil(t, a.Authorize(nil, "mytopic", PermissionRead))
+	require.Nil(t, a.Authorize(nil, "mytopic", PermissionRead))
+	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionRead))
+	require.Equal(t, int32(3), requests.Load())
+}
+
+func newTestManagerWithAuthWebhook(t *testing.T, url string, cacheTTL time.Duration) *Manager {
+	a, err := NewManager(&Config{
+		Filename:            filepath.Join(t.TempDir(), "user.db"),
+		DefaultAccess:       PermissionDenyAll,
+		BcryptCost:          bcrypt.MinCost,
+		AuthWebhookURL:      url,
+		AuthWebhookCacheTTL: cacheTTL,
+	})
+	require.Nil(t, err)
+	return a
+}
@@ -58,6 +58,13 @@ func (q *BatchingQueue[T]) Enqueue(element T) {
 	}
 }
 
+// Len returns the number of elements that are waiting to be emitted as part of a batch
+func (q *BatchingQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.in)
+}
+
 // Dequeue returns a channel emitting batches of elements
 func (q *BatchingQueue[T]) Dequeue() <-chan []T {
 	return q.out
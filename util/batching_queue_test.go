@@ -28,6 +28,7 @@ func TestBatchingQueue_InfTimeout(t *testing.T) {
 	mu.Lock()
 	require.Equal(t, 100, total) // One is missing, stuck in the last batch!
 	require.Equal(t, 4, len(batches))
+	require.Equal(t, 1, q.Len())
 	mu.Unlock()
 }
 
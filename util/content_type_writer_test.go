@@ -55,3 +55,9 @@ func TestSniffWriter_WriteWithFilenameAPK(t *testing.T) {
 	sw.Write([]byte{0x50, 0x4B, 0x03, 0x04})
 	require.Equal(t, "application/vnd.android.package-archive", rr.Header().Get("Content-Type"))
 }
+
+func TestSafeContentType(t *testing.T) {
+	require.Equal(t, "text/plain; charset=utf-8", SafeContentType([]byte("<html><body>hi</body></html>"), ""))
+	require.Equal(t, "application/pdf", SafeContentType([]byte{0x25, 0x50, 0x44, 0x46, 0x2d, 0x11}, ""))
+	require.Equal(t, "application/vnd.android.package-archive", SafeContentType([]byte{0x50, 0x4B, 0x03, 0x04}, "ntfy.apk"))
+}
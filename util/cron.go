@@ -0,0 +1,158 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidCron = errors.New("invalid cron expression")
+
+// cronMaxSearchYears limits how far into the future CronSchedule.Next looks for a matching time. Expressions
+// like "0 0 30 2 *" (February 30th) never match, and must not loop forever.
+const cronMaxSearchYears = 5
+
+// cronDescriptors are the supported shorthands for common schedules
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// CronSchedule is a parsed standard 5-field cron expression (minute, hour, day of month, month, day of week).
+// Schedules are always evaluated in UTC.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit sets of allowed values
+	domStar, dowStar              bool   // True if the field was "*", see dayMatches
+}
+
+// ParseCron parses a standard 5-field cron expression, e.g. "*/5 * * * *" or "0 9 * * 1-5". Each field
+// supports "*", single values, ranges ("1-5"), lists ("1,3,5") and steps ("*/15", "0-30/10"). Months and
+// days of the week may be given by name ("jan", "mon"), and Sunday can be either 0 or 7. Descriptors such
+// as "@hourly" or "@daily" are supported as well.
+func ParseCron(s string) (*CronSchedule, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if expr, ok := cronDescriptors[s]; ok {
+		s = expr
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: expected 5 fields, got %d", errInvalidCron, len(fields))
+	}
+	var err error
+	c := &CronSchedule{
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	} else if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	} else if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	} else if c.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	} else if c.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow = (c.dow | 1) &^ (1 << 7) // Sunday can be 0 or 7
+	}
+	return c, nil
+}
+
+// Next returns the first time strictly after t (truncated to the minute) that matches the schedule, or
+// the zero time if there is no such time within the next few years.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronMaxSearchYears, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		} else if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		} else if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		} else if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches implements the (odd, but standard) cron behavior for day fields: if both day of month and
+// day of week are restricted, a day matches if either of them matches; otherwise both must match.
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if !c.domStar && !c.dowStar {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+var (
+	cronMonthNames = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	cronDayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseCronField(field string, low, high int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeStr, step := part, 1
+		if before, after, found := strings.Cut(part, "/"); found {
+			var err error
+			rangeStr = before
+			if step, err = strconv.Atoi(after); err != nil || step < 1 {
+				return 0, fmt.Errorf("%w: invalid step in %q", errInvalidCron, part)
+			}
+		}
+		var start, end int
+		if rangeStr == "*" {
+			start, end = low, high
+		} else if before, after, found := strings.Cut(rangeStr, "-"); found {
+			var err error
+			if start, err = parseCronValue(before, low, high, names); err != nil {
+				return 0, err
+			} else if end, err = parseCronValue(after, low, high, names); err != nil {
+				return 0, err
+			} else if start > end {
+				return 0, fmt.Errorf("%w: invalid range %q", errInvalidCron, rangeStr)
+			}
+		} else {
+			var err error
+			if start, err = parseCronValue(rangeStr, low, high, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if strings.Contains(part, "/") {
+				end = high // "5/10" means "5-max/10"
+			}
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, low, high int, names []string) (int, error) {
+	for i, name := range names {
+		if name != "" && s == name {
+			return i, nil
+		}
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil || value < low || value > high {
+		return 0, fmt.Errorf("%w: value %q out of range %d-%d", errInvalidCron, s, low, high)
+	}
+	return value, nil
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseCron_Next(t *testing.T) {
+	// base is 2021-12-10 10:17:23 (Friday)
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2021, 12, 10, 10, 18, 0, 0, time.UTC)},
+		{"*/5 * * * *", time.Date(2021, 12, 10, 10, 20, 0, 0, time.UTC)},
+		{"17 * * * *", time.Date(2021, 12, 10, 11, 17, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2021, 12, 11, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2021, 12, 13, 9, 0, 0, 0, time.UTC)},
+		{"30 8,20 * * *", time.Date(2021, 12, 10, 20, 30, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 12, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 6", time.Date(2021, 12, 11, 0, 0, 0, 0, time.UTC)}, // Saturday matches before the 13th
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"10-20/5 11 * * *", time.Date(2021, 12, 10, 11, 10, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 12, 10, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, 12, 11, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			schedule, err := ParseCron(test.expr)
+			require.Nil(t, err)
+			require.Equal(t, test.next, schedule.Next(base))
+		})
+	}
+}
+
+func TestParseCron_NeverMatches(t *testing.T) {
+	schedule, err := ParseCron("0 0 30 2 *")
+	require.Nil(t, err)
+	require.True(t, schedule.Next(base).IsZero())
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "abc * * * *", "@often"} {
+		_, err := ParseCron(expr)
+		require.Error(t, err, expr)
+	}
+}
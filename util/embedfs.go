@@ -36,6 +36,12 @@ func (f CachingEmbedFS) Open(name string) (fs.File, error) {
 	return &cachingEmbedFile{file, f.ModTime, stat}, nil
 }
 
+// ReadDir reads the named directory of the embedded filesystem. It is required to walk the
+// filesystem, e.g. with fs.WalkDir.
+func (f CachingEmbedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return f.FS.ReadDir(name)
+}
+
 type cachingEmbedFile struct {
 	file    fs.File
 	modTime time.Time
@@ -3,6 +3,7 @@ package util
 import (
 	"embed"
 	"github.com/stretchr/testify/require"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -33,6 +34,19 @@ func TestCachingEmbedFS(t *testing.T) {
 	require.Equal(t, 304, rr.Code) // Huzzah!
 }
 
+func TestCachingEmbedFS_WalkDir(t *testing.T) {
+	files := make([]string, 0)
+	require.Nil(t, fs.WalkDir(testFsCached, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if !d.IsDir() {
+			files = append(files, name)
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"embedfs/test.txt"}, files)
+}
+
 func TestCachingEmbedFS_Range(t *testing.T) {
 	s := http.FileServer(http.FS(testFsCached))
 	rr := httptest.NewRecorder()
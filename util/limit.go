@@ -144,6 +144,14 @@ func (l *RateLimiter) Value() int64 {
 	return l.value
 }
 
+// Tokens returns the number of tokens that are currently available in the underlying rate.Limiter, i.e.
+// the value that can still be added before the limit is reached
+func (l *RateLimiter) Tokens() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limiter.Tokens()
+}
+
 // Reset sets the limiter's value back to zero, and resets the underlying rate.Limiter
 func (l *RateLimiter) Reset() {
 	l.mu.Lock()
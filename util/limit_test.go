@@ -47,6 +47,7 @@ func TestBytesLimiter_Add_Simple(t *testing.T) {
 
 	require.False(t, l.AllowN(300*1024*1024))
 	require.Equal(t, int64(200*1024*1024), l.Value())
+	require.InDelta(t, float64(50*1024*1024), l.Tokens(), 1000)
 }
 
 func TestBytesLimiter_Add_Wait(t *testing.T) {
@@ -0,0 +1,174 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode_Version(t *testing.T) {
+	c, err := Encode("ntfy://ntfy.sh/mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 2, c.version)
+	require.Equal(t, 25, c.Size())
+
+	c, err = Encode(strings.Repeat("x", 666)) // Largest text that fits into version 20
+	require.Nil(t, err)
+	require.Equal(t, 20, c.version)
+	require.Equal(t, 97, c.Size())
+
+	_, err = Encode(strings.Repeat("x", 667))
+	require.Equal(t, ErrTooLong, err)
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	for _, text := range []string{"", "hello", "ntfy://ntfy.example.com/mytopic?secure=false", `{"base_url":"https://ntfy.example.com","topics":["alerts","backups"],"token":"tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2"}`, strings.Repeat("0123456789", 50)} {
+		c, err := Encode(text)
+		require.Nil(t, err)
+		require.Equal(t, text, decode(t, c))
+	}
+}
+
+func TestEncode_FunctionPatterns(t *testing.T) {
+	c, err := Encode("hello")
+	require.Nil(t, err)
+	for _, corner := range [][2]int{{0, 0}, {c.Size() - 7, 0}, {0, c.Size() - 7}} {
+		for i := 0; i < 7; i++ {
+			require.True(t, c.Dark(corner[0]+i, corner[1]))   // Top edge of finder pattern
+			require.True(t, c.Dark(corner[0]+i, corner[1]+6)) // Bottom edge
+		}
+		require.False(t, c.Dark(corner[0]+1, corner[1]+1))
+		require.True(t, c.Dark(corner[0]+3, corner[1]+3))
+	}
+	for i := 8; i < c.Size()-8; i++ {
+		require.Equal(t, i%2 == 0, c.Dark(i, 6)) // Timing pattern
+		require.Equal(t, i%2 == 0, c.Dark(6, i))
+	}
+	require.True(t, c.Dark(8, c.Size()-8)) // Dark module
+	require.False(t, c.Dark(-1, 0))
+}
+
+func TestReedSolomon(t *testing.T) {
+	// Example from https://www.thonky.com/qr-code-tutorial/error-correction-coding (1-M, "HELLO WORLD")
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	require.Equal(t, expected, reedSolomonRemainder(data, reedSolomonDivisor(10)))
+}
+
+func TestDataCodewords(t *testing.T) {
+	// Data capacity for error correction level M, see table 7 of the spec
+	for version, expected := range map[int]int{1: 16, 2: 28, 5: 86, 7: 124, 10: 216, 14: 365, 20: 669} {
+		require.Equal(t, expected, dataCodewords(version), version)
+	}
+}
+
+func TestCode_PNGAndSVG(t *testing.T) {
+	c, err := Encode("hello")
+	require.Nil(t, err)
+	b, err := c.PNG(4)
+	require.Nil(t, err)
+	config, err := png.DecodeConfig(bytes.NewReader(b))
+	require.Nil(t, err)
+	require.Equal(t, (21+8)*4, config.Width)
+	require.Equal(t, (21+8)*4, config.Height)
+
+	svg := string(c.SVG(4))
+	require.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg"`))
+	require.Contains(t, svg, `viewBox="0 0 29 29" width="116" height="116"`)
+	require.Contains(t, svg, "M4,4h1v1h-1z") // Top left module of the finder pattern
+}
+
+// decode reads the text from a code created by Encode, by reversing the steps of the encoder. It relies on the
+// encoder's function patterns, so it mainly verifies masking, placement, interleaving and error correction.
+func decode(t *testing.T, c *Code) string {
+	var format int
+	for i := 0; i < 8; i++ {
+		if c.modules[8][c.size-1-i] {
+			format |= 1 << i
+		}
+	}
+	for i := 8; i < 15; i++ {
+		if c.modules[c.size-15+i][8] {
+			format |= 1 << i
+		}
+	}
+	format ^= 0x5412
+	require.Equal(t, formatBitsLevelM, format>>13)
+	mask := (format >> 10) & 0b111
+
+	unmasked := newCode(c.version)
+	for y := range c.modules {
+		copy(unmasked.modules[y], c.modules[y])
+		copy(unmasked.function[y], c.function[y])
+	}
+	unmasked.applyMask(mask)
+	var bits bitBuffer
+	for right := c.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vert
+				}
+				if !unmasked.function[y][x] {
+					bits = append(bits, unmasked.modules[y][x])
+				}
+			}
+		}
+	}
+	codewords := make([]byte, rawDataModules(c.version)/8)
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				codewords[i] |= 1 << (7 - j)
+			}
+		}
+	}
+
+	// De-interleave, and check the error correction codewords of each block
+	numBlocks, eccLen := eccBlocks[c.version], eccCodewordsPerBlock[c.version]
+	numShortBlocks := numBlocks - len(codewords)%numBlocks
+	shortDataLen := len(codewords)/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	i := 0
+	for k := 0; k <= shortDataLen; k++ {
+		for b := 0; b < numBlocks; b++ {
+			if k < shortDataLen || b >= numShortBlocks {
+				blocks[b] = append(blocks[b], codewords[i])
+				i++
+			}
+		}
+	}
+	var data []byte
+	for b := 0; b < numBlocks; b++ {
+		ecc := make([]byte, eccLen)
+		for k := 0; k < eccLen; k++ {
+			ecc[k] = codewords[i+k*numBlocks+b]
+		}
+		require.Equal(t, reedSolomonRemainder(blocks[b], reedSolomonDivisor(eccLen)), ecc)
+		data = append(data, blocks[b]...)
+	}
+
+	// Parse the byte mode segment
+	read := func(offset, length int) int {
+		value := 0
+		for j := offset; j < offset+length; j++ {
+			value = value<<1 | int(data[j/8]>>(7-j%8)&1)
+		}
+		return value
+	}
+	require.Equal(t, modeByte, read(0, 4))
+	countBits := charCountBits(c.version)
+	length := read(4, countBits)
+	text := make([]byte, length)
+	for j := range text {
+		text[j] = byte(read(4+countBits+j*8, 8))
+	}
+	return string(text)
+}
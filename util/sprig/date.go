@@ -158,7 +158,7 @@ func duration(sec any) string {
 // durationRound formats a duration in a human-readable rounded format.
 //
 // Parameters:
-//   - duration: Can be a string (parsed as duration), int64 (nanoseconds),
+//   - duration: Can be a string (parsed as duration), int64 (nanoseconds), time.Duration,
 //     or time.Time (time since that moment)
 //
 // Returns a string with the largest appropriate unit (y, mo, d, h, m, s).
@@ -173,6 +173,8 @@ func durationRound(duration any) string {
 		d, _ = time.ParseDuration(duration)
 	case int64:
 		d = time.Duration(duration)
+	case time.Duration:
+		d = duration
 	case time.Time:
 		d = time.Since(duration)
 	}
@@ -202,6 +204,87 @@ func durationRound(duration any) string {
 	return "0s"
 }
 
+// durationAdd adds two durations.
+//
+// Parameters:
+//   - a, b: Can be a time.Duration, a duration string (e.g., "1h30m"), or a number of seconds
+//     (int, int64, float64, or a numeric string)
+//
+// Values that cannot be converted count as zero.
+//
+// Example usage in templates: {{ durationAdd "4h" "30m" }} -> "4h30m0s"
+func durationAdd(a, b any) time.Duration {
+	return toDuration(a) + toDuration(b)
+}
+
+// durationSub subtracts the second duration from the first.
+//
+// Parameters:
+//   - a, b: Can be a time.Duration, a duration string (e.g., "1h30m"), or a number of seconds
+//     (int, int64, float64, or a numeric string)
+//
+// Values that cannot be converted count as zero.
+//
+// Example usage in templates: {{ durationSub "4h" (durationBetween .startsAt now) | durationRound }}
+func durationSub(a, b any) time.Duration {
+	return toDuration(a) - toDuration(b)
+}
+
+// durationBetween returns the duration between two points in time, i.e. end minus start.
+//
+// Parameters:
+//   - start, end: Can be a time.Time, *time.Time, int/int32/int64/float64 (seconds since UNIX epoch),
+//     or an RFC 3339/ISO 8601 string (see parseRFC3339)
+//
+// The result is negative if end is before start. If either value cannot be converted, zero is returned.
+//
+// Example usage in templates: {{ durationBetween .startsAt now | durationRound }}
+func durationBetween(start, end any) time.Duration {
+	s, ok1 := toTime(start)
+	e, ok2 := toTime(end)
+	if !ok1 || !ok2 {
+		return 0
+	}
+	return e.Sub(s)
+}
+
+// toDuration converts a value to a time.Duration, see durationAdd
+func toDuration(v any) time.Duration {
+	switch v := v.(type) {
+	case time.Duration:
+		return v
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(f * float64(time.Second))
+		}
+		return 0
+	case int, int32, int64, float32, float64:
+		return time.Duration(toFloat64(v) * float64(time.Second))
+	}
+	return 0
+}
+
+// toTime converts a value to a time.Time, see durationBetween
+func toTime(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, false
+		}
+		return *v, true
+	case int, int32, int64, float64:
+		return time.Unix(toInt64(v), 0), true
+	case string:
+		t := parseRFC3339(v)
+		return t, !t.IsZero()
+	}
+	return time.Time{}, false
+}
+
 // toDate parses a string into a time.Time using the specified format.
 //
 // Parameters:
@@ -229,6 +312,68 @@ func mustToDate(fmt, str string) (time.Time, error) {
 	return time.ParseInLocation(fmt, str, time.Local)
 }
 
+// toDateInZone parses a string into a time.Time using the specified format in the specified timezone.
+//
+// Parameters:
+//   - fmt: A Go time format string (e.g., "2006-01-02 15:04")
+//   - str: The date string to parse
+//   - zone: Timezone name (e.g., "UTC", "America/New_York"), used if the string has no offset
+//
+// If parsing fails, returns a zero time.Time. If the timezone is invalid, UTC is used.
+//
+// Example usage in templates: {{ toDateInZone "2006-01-02 15:04" "2023-01-01 09:30" "Europe/Berlin" }}
+func toDateInZone(fmt, str, zone string) time.Time {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		loc = time.UTC
+	}
+	t, _ := time.ParseInLocation(fmt, str, loc)
+	return t
+}
+
+// iso8601Layouts are the layouts accepted by parseRFC3339, in order. Besides RFC 3339, a few common
+// ISO 8601 variants are accepted, e.g. offsets without a colon, or no offset at all (which means UTC).
+var iso8601Layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// parseRFC3339 parses an RFC 3339 or ISO 8601 timestamp into a time.Time.
+//
+// Parameters:
+//   - str: The timestamp to parse (e.g., "2023-01-01T09:30:00Z", "2023-01-01T09:30:00.123+0100")
+//
+// Fractional seconds are optional. Timestamps without an offset are interpreted as UTC.
+// If parsing fails, returns a zero time.Time.
+//
+// Example usage in templates: {{ parseRFC3339 .startsAt | date "15:04" }}
+func parseRFC3339(str string) time.Time {
+	for _, layout := range iso8601Layouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseUnixMilli converts a Unix timestamp in milliseconds into a time.Time.
+//
+// Parameters:
+//   - ms: Milliseconds since January 1, 1970 UTC, as a number or a string
+//
+// If the value cannot be converted, the Unix epoch is returned.
+//
+// Example usage in templates: {{ parseUnixMilli .timestamp | date "2006-01-02 15:04:05" }}
+func parseUnixMilli(ms any) time.Time {
+	return time.UnixMilli(toInt64(ms))
+}
+
 // unixEpoch returns the Unix timestamp (seconds since January 1, 1970 UTC) for the given time.
 //
 // Parameters:
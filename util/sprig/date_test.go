@@ -35,6 +35,82 @@ func TestToDate(t *testing.T) {
 	}
 }
 
+func TestToDateInZone(t *testing.T) {
+	tpl := `{{ dateInZone "15:04 -0700" (toDateInZone "2006-01-02 15:04" "2023-07-01 09:30" "Europe/Berlin") "UTC" }}`
+	if err := runt(tpl, "07:30 +0000"); err != nil {
+		t.Error(err)
+	}
+
+	// Offset in the string takes precedence, invalid timezone falls back to UTC
+	tpl = `{{ toDateInZone "2006-01-02 15:04 -0700" "2023-07-01 09:30 +0200" "Europe/Berlin" | unixEpoch }}`
+	if err := runt(tpl, "1688196600"); err != nil {
+		t.Error(err)
+	}
+	tpl = `{{ toDateInZone "2006-01-02 15:04" "2023-07-01 07:30" "Not/AZone" | unixEpoch }}`
+	if err := runt(tpl, "1688196600"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseRFC3339(t *testing.T) {
+	for _, s := range []string{
+		"2023-07-01T07:30:00Z",
+		"2023-07-01T09:30:00+02:00",
+		"2023-07-01T07:30:00.000123Z",
+		"2023-07-01T09:30:00+0200",
+		"2023-07-01T07:30:00",
+		"2023-07-01 07:30:00",
+		"2023-07-01T09:30+02:00",
+	} {
+		tpl := `{{ parseRFC3339 .Time | unixEpoch }}`
+		if err := runtv(tpl, "1688196600", map[string]any{"Time": s}); err != nil {
+			t.Error(s, err)
+		}
+	}
+	if err := runt(`{{ parseRFC3339 "2023-07-01" | date "2006-01-02" }}`, "2023-07-01"); err != nil {
+		t.Error(err)
+	}
+	if err := runt(`{{ parseRFC3339 "yesterday" | unixEpoch }}`, "-62135596800"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseUnixMilli(t *testing.T) {
+	tpl := `{{ dateInZone "2006-01-02 15:04:05.000" (parseUnixMilli .Time) "UTC" }}`
+	for _, v := range []any{int64(1688196600123), float64(1688196600123), "1688196600123"} {
+		if err := runtv(tpl, "2023-07-01 07:30:00.123", map[string]any{"Time": v}); err != nil {
+			t.Error(v, err)
+		}
+	}
+}
+
+func TestDurationArithmetic(t *testing.T) {
+	tests := map[string]string{
+		`{{ durationAdd "4h" "30m" }}`:                                             "4h30m0s",
+		`{{ durationAdd 90 "1m" }}`:                                                "2m30s",
+		`{{ durationAdd "1.5" "bad" }}`:                                            "1.5s",
+		`{{ durationSub "4h" "4h30m" }}`:                                           "-30m0s",
+		`{{ durationSub (durationAdd "1h" "1h") "90m" | durationRound }}`:          "30m",
+		`{{ durationBetween "2023-07-01T07:30:00Z" "2023-07-02T09:00:00+02:00" }}`: "23h30m0s",
+		`{{ durationBetween 1688196600 1688196000 }}`:                              "-10m0s",
+		`{{ durationBetween "yesterday" 1688196000 }}`:                             "0s",
+	}
+	for tpl, expected := range tests {
+		if err := runt(tpl, expected); err != nil {
+			t.Error(tpl, err)
+		}
+	}
+
+	tpl := `{{ durationBetween .Start .End | durationRound }}`
+	start := time.Date(2023, 7, 1, 7, 30, 0, 0, time.UTC)
+	if err := runtv(tpl, "3d", map[string]any{"Start": start, "End": start.Add(80 * time.Hour)}); err != nil {
+		t.Error(err)
+	}
+	if err := runtv(tpl, "2h", map[string]any{"Start": &start, "End": float64(start.Unix() + 9000)}); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestUnixEpoch(t *testing.T) {
 	tm, err := time.Parse("02 Jan 06 15:04:05 MST", "13 Jun 19 20:39:39 GMT")
 	if err != nil {
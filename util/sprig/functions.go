@@ -10,11 +10,25 @@ import (
 )
 
 const (
-	loopExecutionLimit = 10_000  // Limit the number of loop executions to prevent execution from taking too long
-	stringLengthLimit  = 100_000 // Limit the length of strings to prevent memory issues
-	sliceSizeLimit     = 10_000  // Limit the size of slices to prevent memory issues
+	DefaultLoopExecutionLimit = 10_000  // Limit the number of loop executions to prevent execution from taking too long
+	DefaultStringLengthLimit  = 100_000 // Limit the length of strings to prevent memory issues
+	sliceSizeLimit            = 10_000  // Limit the size of slices to prevent memory issues
 )
 
+// Limits defines how much work the template functions may do, to prevent a template from taking too long to
+// execute, or from using too much memory. It only applies to functions that loop or build strings based on
+// their arguments, e.g. repeat, until or untilStep.
+type Limits struct {
+	LoopExecutionLimit int // Maximum number of loop executions, e.g. the count in repeat or the sequence length in until
+	StringLengthLimit  int // Maximum length of strings built by a function, e.g. repeat
+}
+
+// DefaultLimits are the limits used by TxtFuncMap
+var DefaultLimits = Limits{
+	LoopExecutionLimit: DefaultLoopExecutionLimit,
+	StringLengthLimit:  DefaultStringLengthLimit,
+}
+
 // TxtFuncMap produces the function map.
 //
 // Use this to pass the functions into the template engine:
@@ -23,21 +37,32 @@ const (
 //
 // TxtFuncMap returns a 'text/template'.FuncMap
 func TxtFuncMap() template.FuncMap {
+	return TxtFuncMapWithLimits(DefaultLimits)
+}
+
+// TxtFuncMapWithLimits produces the function map, just like TxtFuncMap, but with the given limits
+func TxtFuncMapWithLimits(limits Limits) template.FuncMap {
 	return map[string]any{
 		// Date functions
-		"ago":            dateAgo,
-		"date":           date,
-		"dateInZone":     dateInZone,
-		"dateModify":     dateModify,
-		"duration":       duration,
-		"durationRound":  durationRound,
-		"htmlDate":       htmlDate,
-		"htmlDateInZone": htmlDateInZone,
-		"mustDateModify": mustDateModify,
-		"mustToDate":     mustToDate,
-		"now":            time.Now,
-		"toDate":         toDate,
-		"unixEpoch":      unixEpoch,
+		"ago":             dateAgo,
+		"date":            date,
+		"dateInZone":      dateInZone,
+		"dateModify":      dateModify,
+		"duration":        duration,
+		"durationAdd":     durationAdd,
+		"durationBetween": durationBetween,
+		"durationSub":     durationSub,
+		"durationRound":   durationRound,
+		"htmlDate":        htmlDate,
+		"htmlDateInZone":  htmlDateInZone,
+		"mustDateModify":  mustDateModify,
+		"mustToDate":      mustToDate,
+		"now":             time.Now,
+		"parseRFC3339":    parseRFC3339,
+		"parseUnixMilli":  parseUnixMilli,
+		"toDate":          toDate,
+		"toDateInZone":    toDateInZone,
+		"unixEpoch":       unixEpoch,
 
 		// Strings
 		"trunc":      trunc,
@@ -46,7 +71,7 @@ func TxtFuncMap() template.FuncMap {
 		"lower":      strings.ToLower,
 		"title":      title,
 		"substr":     substring,
-		"repeat":     repeat,
+		"repeat":     limits.repeat,
 		"trimAll":    trimAll,
 		"trimPrefix": trimPrefix,
 		"trimSuffix": trimSuffix,
@@ -68,15 +93,15 @@ func TxtFuncMap() template.FuncMap {
 
 		// Wrap Atoi to stop errors.
 		"atoi":      atoi,
-		"seq":       seq,
+		"seq":       limits.seq,
 		"toDecimal": toDecimal,
 		"split":     split,
 		"splitList": splitList,
 		"splitn":    splitn,
 		"toStrings": strslice,
 
-		"until":     until,
-		"untilStep": untilStep,
+		"until":     limits.until,
+		"untilStep": limits.untilStep,
 
 		// Basic arithmetic
 		"add1":    add1,
@@ -116,6 +141,8 @@ func TxtFuncMap() template.FuncMap {
 		"mustToJSON":       mustToJSON,
 		"mustToPrettyJSON": mustToPrettyJSON,
 		"mustToRawJSON":    mustToRawJSON,
+		"jsonPath":         jsonPath,
+		"mustJSONPath":     mustJSONPath,
 		"ternary":          ternary,
 
 		// Reflection
@@ -188,6 +215,18 @@ func TxtFuncMap() template.FuncMap {
 		"dig":         dig,
 		"chunk":       chunk,
 		"mustChunk":   mustChunk,
+		"flatten":     flatten,
+		"mustFlatten": mustFlatten,
+		"zip":         zip,
+		"mustZip":     mustZip,
+		"sum":         sum,
+		"mustSum":     mustSum,
+		"avg":         avg,
+		"mustAvg":     mustAvg,
+		"minOf":       minOf,
+		"mustMinOf":   mustMinOf,
+		"maxOf":       maxOf,
+		"mustMaxOf":   mustMaxOf,
 
 		// Flow Control
 		"fail": fail,
@@ -35,6 +35,24 @@ func TestRegex(t *testing.T) {
 	assert.NoError(t, runt(`{{ regexQuoteMeta "pretzel" }}`, "pretzel"))
 }
 
+func TestTxtFuncMapWithLimits(t *testing.T) {
+	funcs := TxtFuncMapWithLimits(Limits{LoopExecutionLimit: 3, StringLengthLimit: 10})
+	run := func(tpl string) (string, error) {
+		var b bytes.Buffer
+		err := template.Must(template.New("test").Funcs(funcs).Parse(tpl)).Execute(&b, nil)
+		return b.String(), err
+	}
+	out, err := run(`{{ until 3 }} {{ seq 3 }} {{ repeat 3 "abc" }}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "[0 1 2] 1 2 3 abcabcabc", out)
+	_, err = run(`{{ until 4 }}`)
+	assert.ErrorContains(t, err, "too many iterations in untilStep; max allowed is 3")
+	_, err = run(`{{ seq 1 5 }}`)
+	assert.ErrorContains(t, err, "too many iterations in untilStep; max allowed is 3")
+	_, err = run(`{{ repeat 2 "abcde" }}`)
+	assert.ErrorContains(t, err, "exceeds limit of 10")
+}
+
 // runt runs a template and checks that the output exactly matches the expected string.
 func runt(tpl, expect string) error {
 	return runtv(tpl, expect, map[string]string{})
@@ -0,0 +1,491 @@
+package sprig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathSegment is a single step of a JSONPath expression, e.g. ".name", "[0]", "[*]" or "..name".
+// Exactly one of the selectors (keys, indexes, wildcard, slice, filter) is set.
+type jsonPathSegment struct {
+	recursive bool // Segment is preceded by "..", i.e. it applies to the node and all of its descendants
+	keys      []string
+	indexes   []int
+	wildcard  bool
+	slice     *jsonPathSlice
+	filter    *jsonPathFilter
+}
+
+// jsonPathSlice is an array slice, e.g. "[1:3]" or "[::2]"; nil values mean "not set"
+type jsonPathSlice struct {
+	start, end *int
+	step       int
+}
+
+// jsonPathFilter is a filter expression, e.g. "[?(@.status == 'firing')]". If op is empty, the filter
+// only checks whether the path exists.
+type jsonPathFilter struct {
+	path  []*jsonPathSegment
+	op    string
+	value any
+}
+
+var jsonPathFilterOperators = []string{"==", "!=", "<=", ">=", "<", ">"} // Two-character operators first!
+
+// jsonPath evaluates a JSONPath expression (https://goessner.net/articles/JsonPath/) against data, which is
+// typically the decoded JSON payload (or a JSON string). If the path selects a single value (e.g. "$.a.b[0]"),
+// that value is returned, or nil if it does not exist. Otherwise (e.g. "$.items[*].name"), a list of all
+// matches is returned. This function will panic if the path is invalid.
+func jsonPath(path string, data any) any {
+	result, err := evalJSONPath(path, data)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// mustJSONPath is the implementation of jsonPath that returns an error instead of panicking.
+// Unlike jsonPath, it also returns an error if the path does not match anything.
+func mustJSONPath(path string, data any) (any, error) {
+	result, err := evalJSONPath(path, data)
+	if err != nil {
+		return nil, err
+	} else if result == nil {
+		return nil, fmt.Errorf("jsonPath %s does not match", path)
+	} else if l, ok := result.([]any); ok && len(l) == 0 {
+		return nil, fmt.Errorf("jsonPath %s does not match", path)
+	}
+	return result, nil
+}
+
+func evalJSONPath(path string, data any) (any, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := data.(string); ok {
+		if err := json.Unmarshal([]byte(s), &data); err != nil {
+			return nil, fmt.Errorf("cannot evaluate jsonPath: %w", err)
+		}
+	}
+	nodes, err := selectJSONPath(segments, []any{data})
+	if err != nil {
+		return nil, err
+	}
+	if jsonPathDefinite(segments) {
+		if len(nodes) == 0 {
+			return nil, nil
+		}
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+// parseJSONPath parses a JSONPath expression. The leading "$" is optional, so "a.b" is the same as "$.a.b".
+func parseJSONPath(path string) ([]*jsonPathSegment, error) {
+	p := strings.TrimSpace(path)
+	if strings.HasPrefix(p, "$") {
+		p = p[1:]
+	} else if p != "" && p[0] != '.' && p[0] != '[' {
+		p = "." + p
+	}
+	segments := make([]*jsonPathSegment, 0)
+	for p != "" {
+		segment := &jsonPathSegment{}
+		if strings.HasPrefix(p, "..") {
+			segment.recursive = true
+			p = p[2:]
+			if p == "" {
+				return nil, fmt.Errorf("invalid jsonPath %s: .. must be followed by a name or selector", path)
+			} else if p[0] != '[' {
+				p = "." + p // Treat "..name" like "..[.name]", so that the name is parsed below
+			}
+		}
+		if strings.HasPrefix(p, ".") {
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end == -1 {
+				end = len(p)
+			}
+			name := strings.TrimSpace(p[:end])
+			if name == "" {
+				return nil, fmt.Errorf("invalid jsonPath %s: empty name", path)
+			} else if name == "*" {
+				segment.wildcard = true
+			} else {
+				segment.keys = []string{name}
+			}
+			p = p[end:]
+		} else if strings.HasPrefix(p, "[") {
+			end, err := jsonPathBracketEnd(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jsonPath %s: %w", path, err)
+			}
+			if err := parseJSONPathBracket(segment, strings.TrimSpace(p[1:end])); err != nil {
+				return nil, fmt.Errorf("invalid jsonPath %s: %w", path, err)
+			}
+			p = p[end+1:]
+		} else {
+			return nil, fmt.Errorf("invalid jsonPath %s: unexpected character %q", path, p[0])
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// jsonPathBracketEnd returns the index of the "]" that closes the bracket at the start of p, skipping
+// over quoted strings and parentheses (for filters)
+func jsonPathBracketEnd(p string) (int, error) {
+	var quote byte
+	depth := 0
+	for i := 1; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ']' && depth == 0:
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("missing ]")
+}
+
+func parseJSONPathBracket(segment *jsonPathSegment, content string) error {
+	if content == "*" {
+		segment.wildcard = true
+		return nil
+	} else if strings.HasPrefix(content, "?") {
+		return parseJSONPathFilter(segment, strings.TrimSpace(content[1:]))
+	}
+	parts := splitJSONPathUnquoted(content, ',')
+	if len(parts) == 1 && len(splitJSONPathUnquoted(content, ':')) > 1 {
+		return parseJSONPathSlice(segment, content)
+	}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if key, ok := unquoteJSONPath(part); ok {
+			segment.keys = append(segment.keys, key)
+		} else if index, err := strconv.Atoi(part); err == nil {
+			segment.indexes = append(segment.indexes, index)
+		} else {
+			return fmt.Errorf("invalid selector [%s]", content)
+		}
+	}
+	if len(segment.keys) > 0 && len(segment.indexes) > 0 {
+		return fmt.Errorf("cannot mix names and indexes in [%s]", content)
+	}
+	return nil
+}
+
+func parseJSONPathSlice(segment *jsonPathSegment, content string) error {
+	parts := strings.Split(content, ":")
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid slice [%s]", content)
+	}
+	slice := &jsonPathSlice{step: 1}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("invalid slice [%s]", content)
+		}
+		switch i {
+		case 0:
+			slice.start = &n
+		case 1:
+			slice.end = &n
+		case 2:
+			slice.step = n
+		}
+	}
+	if slice.step <= 0 {
+		return fmt.Errorf("invalid slice [%s]: step must be positive", content)
+	}
+	segment.slice = slice
+	return nil
+}
+
+func parseJSONPathFilter(segment *jsonPathSegment, content string) error {
+	if !strings.HasPrefix(content, "(") || !strings.HasSuffix(content, ")") {
+		return fmt.Errorf("invalid filter [?%s], expected [?(...)]", content)
+	}
+	expr := strings.TrimSpace(content[1 : len(content)-1])
+	filter := &jsonPathFilter{}
+	left := expr
+	if idx, op := findJSONPathOperator(expr); op != "" {
+		filter.op = op
+		left = strings.TrimSpace(expr[:idx])
+		right := strings.TrimSpace(expr[idx+len(op):])
+		if s, ok := unquoteJSONPath(right); ok {
+			filter.value = s
+		} else if err := json.Unmarshal([]byte(right), &filter.value); err != nil {
+			return fmt.Errorf("invalid filter value %s", right)
+		}
+	}
+	if !strings.HasPrefix(left, "@") {
+		return fmt.Errorf("invalid filter [?(%s)], must start with @", expr)
+	}
+	path, err := parseJSONPath("$" + left[1:])
+	if err != nil {
+		return err
+	} else if !jsonPathDefinite(path) {
+		return fmt.Errorf("invalid filter [?(%s)], path must select a single value", expr)
+	}
+	filter.path = path
+	segment.filter = filter
+	return nil
+}
+
+// findJSONPathOperator returns the position of the first comparison operator in expr that is not part of a
+// quoted string, or an empty operator if there is none
+func findJSONPathOperator(expr string) (int, string) {
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		} else if c == '\'' || c == '"' {
+			quote = c
+			continue
+		}
+		for _, op := range jsonPathFilterOperators {
+			if strings.HasPrefix(expr[i:], op) {
+				return i, op
+			}
+		}
+	}
+	return -1, ""
+}
+
+// splitJSONPathUnquoted splits s at sep, ignoring separators in quoted strings
+func splitJSONPathUnquoted(s string, sep byte) []string {
+	parts := make([]string, 0)
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+		} else if c == '\'' || c == '"' {
+			quote = c
+		} else if c == sep {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteJSONPath(s string) (string, bool) {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1], true
+	}
+	return "", false
+}
+
+// jsonPathDefinite returns true if the path selects at most one value, i.e. it consists of single
+// names and indexes only
+func jsonPathDefinite(segments []*jsonPathSegment) bool {
+	for _, segment := range segments {
+		if segment.recursive || len(segment.keys)+len(segment.indexes) != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+func selectJSONPath(segments []*jsonPathSegment, nodes []any) ([]any, error) {
+	for _, segment := range segments {
+		if segment.recursive {
+			nodes = jsonPathDescendants(nodes)
+		}
+		selected := make([]any, 0)
+		for _, node := range nodes {
+			selected = append(selected, segment.apply(node)...)
+			if len(selected) > sliceSizeLimit {
+				return nil, fmt.Errorf("jsonPath result exceeds maximum limit of %d elements", sliceSizeLimit)
+			}
+		}
+		nodes = selected
+	}
+	return nodes, nil
+}
+
+func (s *jsonPathSegment) apply(node any) []any {
+	result := make([]any, 0)
+	switch {
+	case len(s.keys) > 0:
+		for _, key := range s.keys {
+			if child, ok := jsonPathChild(node, key); ok {
+				result = append(result, child)
+			}
+		}
+	case len(s.indexes) > 0:
+		l := reflect.ValueOf(node)
+		if l.Kind() != reflect.Slice && l.Kind() != reflect.Array {
+			return result
+		}
+		for _, index := range s.indexes {
+			if index < 0 {
+				index += l.Len()
+			}
+			if index >= 0 && index < l.Len() {
+				result = append(result, l.Index(index).Interface())
+			}
+		}
+	case s.wildcard:
+		result = jsonPathChildren(node)
+	case s.slice != nil:
+		l := reflect.ValueOf(node)
+		if l.Kind() != reflect.Slice && l.Kind() != reflect.Array {
+			return result
+		}
+		start, end := 0, l.Len()
+		if s.slice.start != nil {
+			start = jsonPathSliceIndex(*s.slice.start, l.Len())
+		}
+		if s.slice.end != nil {
+			end = jsonPathSliceIndex(*s.slice.end, l.Len())
+		}
+		for i := start; i < end; i += s.slice.step {
+			result = append(result, l.Index(i).Interface())
+		}
+	case s.filter != nil:
+		for _, child := range jsonPathChildren(node) {
+			if s.filter.matches(child) {
+				result = append(result, child)
+			}
+		}
+	}
+	return result
+}
+
+func (f *jsonPathFilter) matches(node any) bool {
+	values, err := selectJSONPath(f.path, []any{node})
+	if err != nil || len(values) == 0 {
+		return false
+	} else if f.op == "" {
+		return true
+	}
+	value := values[0]
+	if a, ok := jsonPathNumber(value); ok {
+		if b, ok := jsonPathNumber(f.value); ok {
+			return compareJSONPath(f.op, a < b, a == b)
+		}
+	}
+	if a, ok := value.(string); ok {
+		if b, ok := f.value.(string); ok {
+			return compareJSONPath(f.op, a < b, a == b)
+		}
+	}
+	switch f.op {
+	case "==":
+		return reflect.DeepEqual(value, f.value)
+	case "!=":
+		return !reflect.DeepEqual(value, f.value)
+	}
+	return false
+}
+
+func compareJSONPath(op string, less, equal bool) bool {
+	switch op {
+	case "==":
+		return equal
+	case "!=":
+		return !equal
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	}
+	return false
+}
+
+func jsonPathNumber(v any) (float64, bool) {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return toFloat64(v), true
+	}
+	return 0, false
+}
+
+func jsonPathSliceIndex(index, length int) int {
+	if index < 0 {
+		index += length
+	}
+	return max(0, min(index, length))
+}
+
+func jsonPathChild(node any, key string) (any, bool) {
+	if m, ok := node.(map[string]any); ok {
+		child, ok := m[key]
+		return child, ok
+	}
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	child := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+	if !child.IsValid() {
+		return nil, false
+	}
+	return child.Interface(), true
+}
+
+// jsonPathChildren returns the elements of a list, or the values of a map, sorted by key
+func jsonPathChildren(node any) []any {
+	children := make([]any, 0)
+	v := reflect.ValueOf(node)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			children = append(children, v.Index(i).Interface())
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return children
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			children = append(children, v.MapIndex(key).Interface())
+		}
+	}
+	return children
+}
+
+// jsonPathDescendants returns the nodes and all of their descendants, depth-first
+func jsonPathDescendants(nodes []any) []any {
+	result := make([]any, 0)
+	for _, node := range nodes {
+		result = append(result, node)
+		result = append(result, jsonPathDescendants(jsonPathChildren(node))...)
+	}
+	return result
+}
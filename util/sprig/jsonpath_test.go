@@ -0,0 +1,70 @@
+package sprig
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testJSONPathPayload = `{
+  "status": "firing",
+  "alerts": [
+    {"status": "firing", "labels": {"alertname": "DiskFull", "instance": "db1"}, "value": 97.5},
+    {"status": "resolved", "labels": {"alertname": "HighLoad", "instance": "web1"}, "value": 1.2},
+    {"status": "firing", "labels": {"alertname": "DiskFull", "instance": "db2"}, "value": 91}
+  ],
+  "meta": {"a.b": "dotted", "tags": ["x", "y", "z"]}
+}`
+
+func TestJSONPath(t *testing.T) {
+	var data any
+	require.Nil(t, json.Unmarshal([]byte(testJSONPathPayload), &data))
+	tests := map[string]string{
+		`{{ jsonPath "$.status" . }}`:                                              "firing",
+		`{{ jsonPath "status" . }}`:                                                "firing",
+		`{{ jsonPath "$.alerts[0].labels.alertname" . }}`:                          "DiskFull",
+		`{{ jsonPath "$['alerts'][-1]['labels']['instance']" . }}`:                 "db2",
+		`{{ jsonPath "$.meta['a.b']" . }}`:                                         "dotted",
+		`{{ jsonPath "$.alerts[*].labels.instance" . }}`:                           "[db1 web1 db2]",
+		`{{ jsonPath "$..instance" . }}`:                                           "[db1 web1 db2]",
+		`{{ jsonPath "$.alerts[?(@.status == 'firing')].labels.instance" . }}`:     "[db1 db2]",
+		`{{ jsonPath "$.alerts[?(@.value > 90)].value" . }}`:                       "[97.5 91]",
+		`{{ jsonPath "$.alerts[?(@.labels.alertname != \"DiskFull\")].value" . }}`: "[1.2]",
+		`{{ jsonPath "$.alerts[?(@.missing)]" . }}`:                                "[]",
+		`{{ jsonPath "$.meta.tags[1:]" . }}`:                                       "[y z]",
+		`{{ jsonPath "$.meta.tags[::2]" . }}`:                                      "[x z]",
+		`{{ jsonPath "$.meta.tags[0,2]" . }}`:                                      "[x z]",
+		`{{ jsonPath "$.meta.*" . | len }}`:                                        "2",
+		`{{ jsonPath "$.nope.nope" . }}`:                                           "<no value>",
+		`{{ jsonPath "$.alerts[5]" . | default "none" }}`:                          "none",
+		`{{ jsonPath "$.alerts[*].value" . | sum }}`:                               "189.7",
+		`{{ jsonPath "$.a[1]" "{\"a\":[1,2]}" }}`:                                  "2",
+		`{{ mustJSONPath "$.alerts[0].value" . }}`:                                 "97.5",
+	}
+	for tpl, expect := range tests {
+		assert.NoError(t, runtv(tpl, expect, data), tpl)
+	}
+}
+
+func TestJSONPath_Errors(t *testing.T) {
+	var data any
+	require.Nil(t, json.Unmarshal([]byte(testJSONPathPayload), &data))
+	tests := map[string]string{
+		`{{ jsonPath "$.alerts[" . }}`:                      "missing ]",
+		`{{ jsonPath "$.alerts[abc]" . }}`:                  "invalid selector [abc]",
+		`{{ jsonPath "$.alerts[::-1]" . }}`:                 "step must be positive",
+		`{{ jsonPath "$.alerts[?(status == 1)]" . }}`:       "must start with @",
+		`{{ jsonPath "$.alerts[?(@.value == abc)]" . }}`:    "invalid filter value abc",
+		`{{ jsonPath "$.alerts[?(@.labels.* == 1)]" . }}`:   "path must select a single value",
+		`{{ jsonPath "$.." . }}`:                            ".. must be followed by a name or selector",
+		`{{ jsonPath "$.a" "not json" }}`:                   "cannot evaluate jsonPath",
+		`{{ mustJSONPath "$.nope" . }}`:                     "jsonPath $.nope does not match",
+		`{{ mustJSONPath "$.alerts[?(@.value > 100)]" . }}`: "does not match",
+	}
+	for tpl, expect := range tests {
+		_, err := runRaw(tpl, data)
+		assert.ErrorContains(t, err, expect, tpl)
+	}
+}
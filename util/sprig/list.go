@@ -1,12 +1,19 @@
 package sprig
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 )
 
+// errEmptyList is returned by the aggregate functions (e.g. mustAvg) if the list is empty
+var errEmptyList = errors.New("list is empty")
+
 // Reflection is used in these functions so that slices and arrays of strings,
 // ints, and other types not implementing []any can be worked with.
 // For example, this is useful if you need to work on the output of regexs.
@@ -503,3 +510,216 @@ func concat(lists ...any) any {
 	}
 	return res
 }
+
+// flatten returns a new list with all nested lists replaced by their elements, recursively.
+// This function will panic if the argument is not a slice or array, or if the result would be too large.
+func flatten(list any) []any {
+	l, err := mustFlatten(list)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// mustFlatten is the implementation of flatten that returns an error instead of panicking.
+// It returns a new list with all nested lists replaced by their elements, recursively.
+func mustFlatten(list any) ([]any, error) {
+	tp := reflect.TypeOf(list).Kind()
+	switch tp {
+	case reflect.Slice, reflect.Array:
+		res := []any{}
+		if err := flattenInto(&res, reflect.ValueOf(list)); err != nil {
+			return nil, err
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("cannot flatten type %s", tp)
+	}
+}
+
+func flattenInto(res *[]any, l reflect.Value) error {
+	for i := 0; i < l.Len(); i++ {
+		item := reflect.Indirect(l.Index(i))
+		if item.Kind() == reflect.Interface {
+			item = item.Elem()
+		}
+		if item.Kind() == reflect.Slice || item.Kind() == reflect.Array {
+			if err := flattenInto(res, item); err != nil {
+				return err
+			}
+			continue
+		} else if len(*res) >= sliceSizeLimit {
+			return fmt.Errorf("flattened list exceeds maximum limit of %d elements", sliceSizeLimit)
+		}
+		*res = append(*res, l.Index(i).Interface())
+	}
+	return nil
+}
+
+// zip combines multiple lists into a list of tuples, where the n-th tuple contains the n-th element
+// of each list. The result is as long as the shortest list.
+// This function will panic if any argument is not a slice or array.
+func zip(lists ...any) [][]any {
+	l, err := mustZip(lists...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// mustZip is the implementation of zip that returns an error instead of panicking.
+// It combines multiple lists into a list of tuples.
+func mustZip(lists ...any) ([][]any, error) {
+	if len(lists) == 0 {
+		return [][]any{}, nil
+	}
+	values := make([]reflect.Value, len(lists))
+	length := -1
+	for i, list := range lists {
+		tp := reflect.TypeOf(list).Kind()
+		if tp != reflect.Slice && tp != reflect.Array {
+			return nil, fmt.Errorf("cannot zip type %s", tp)
+		}
+		values[i] = reflect.ValueOf(list)
+		if length == -1 || values[i].Len() < length {
+			length = values[i].Len()
+		}
+	}
+	if length*len(lists) > sliceSizeLimit {
+		return nil, fmt.Errorf("zipped list exceeds maximum limit of %d elements", sliceSizeLimit)
+	}
+	res := make([][]any, length)
+	for i := 0; i < length; i++ {
+		res[i] = make([]any, len(lists))
+		for j, l := range values {
+			res[i][j] = l.Index(i).Interface()
+		}
+	}
+	return res, nil
+}
+
+// sum returns the sum of all numbers in a list as a float64, or 0 if the list is empty.
+// This function will panic if the argument is not a slice or array, or if an element is not a number.
+func sum(list any) float64 {
+	s, err := mustSum(list)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// mustSum is the implementation of sum that returns an error instead of panicking.
+// It returns the sum of all numbers in a list.
+func mustSum(list any) (float64, error) {
+	numbers, err := toFloat64List("sum", list)
+	if err != nil {
+		return 0, err
+	}
+	var s float64
+	for _, n := range numbers {
+		s += n
+	}
+	return s, nil
+}
+
+// avg returns the arithmetic mean of all numbers in a list as a float64, or 0 if the list is empty.
+// This function will panic if the argument is not a slice or array, or if an element is not a number.
+func avg(list any) float64 {
+	a, err := mustAvg(list)
+	if err != nil && !errors.Is(err, errEmptyList) {
+		panic(err)
+	}
+	return a
+}
+
+// mustAvg is the implementation of avg that returns an error instead of panicking.
+// Unlike avg, it returns an error if the list is empty.
+func mustAvg(list any) (float64, error) {
+	numbers, err := toFloat64List("avg", list)
+	if err != nil {
+		return 0, err
+	} else if len(numbers) == 0 {
+		return 0, errEmptyList
+	}
+	s, _ := mustSum(numbers)
+	return s / float64(len(numbers)), nil
+}
+
+// minOf returns the smallest number in a list as a float64, or 0 if the list is empty.
+// This function will panic if the argument is not a slice or array, or if an element is not a number.
+func minOf(list any) float64 {
+	m, err := mustMinOf(list)
+	if err != nil && !errors.Is(err, errEmptyList) {
+		panic(err)
+	}
+	return m
+}
+
+// mustMinOf is the implementation of minOf that returns an error instead of panicking.
+// Unlike minOf, it returns an error if the list is empty.
+func mustMinOf(list any) (float64, error) {
+	numbers, err := toFloat64List("minOf", list)
+	if err != nil {
+		return 0, err
+	} else if len(numbers) == 0 {
+		return 0, errEmptyList
+	}
+	return slices.Min(numbers), nil
+}
+
+// maxOf returns the largest number in a list as a float64, or 0 if the list is empty.
+// This function will panic if the argument is not a slice or array, or if an element is not a number.
+func maxOf(list any) float64 {
+	m, err := mustMaxOf(list)
+	if err != nil && !errors.Is(err, errEmptyList) {
+		panic(err)
+	}
+	return m
+}
+
+// mustMaxOf is the implementation of maxOf that returns an error instead of panicking.
+// Unlike maxOf, it returns an error if the list is empty.
+func mustMaxOf(list any) (float64, error) {
+	numbers, err := toFloat64List("maxOf", list)
+	if err != nil {
+		return 0, err
+	} else if len(numbers) == 0 {
+		return 0, errEmptyList
+	}
+	return slices.Max(numbers), nil
+}
+
+// toFloat64List converts a list of numbers (or strings containing numbers) to a []float64.
+// It returns an error if the argument is not a slice or array, or if an element is not a number.
+func toFloat64List(fn string, list any) ([]float64, error) {
+	if list == nil {
+		return nil, fmt.Errorf("cannot %s type nil", fn)
+	}
+	tp := reflect.TypeOf(list).Kind()
+	if tp != reflect.Slice && tp != reflect.Array {
+		return nil, fmt.Errorf("cannot %s type %s", fn, tp)
+	}
+	l := reflect.ValueOf(list)
+	if l.Len() > sliceSizeLimit {
+		return nil, fmt.Errorf("list length %d exceeds maximum limit of %d", l.Len(), sliceSizeLimit)
+	}
+	numbers := make([]float64, l.Len())
+	for i := 0; i < l.Len(); i++ {
+		item := l.Index(i).Interface()
+		switch reflect.Indirect(reflect.ValueOf(item)).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			numbers[i] = toFloat64(item)
+		case reflect.String:
+			n, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(item)), 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot %s list element %d: %q is not a number", fn, i, item)
+			}
+			numbers[i] = n
+		default:
+			return nil, fmt.Errorf("cannot %s list element %d of type %T", fn, i, item)
+		}
+	}
+	return numbers, nil
+}
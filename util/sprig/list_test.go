@@ -365,3 +365,71 @@ func TestConcat(t *testing.T) {
 		assert.NoError(t, runt(tpl, expect))
 	}
 }
+
+func TestFlatten(t *testing.T) {
+	tests := map[string]string{
+		`{{ flatten (list 1 2 3) }}`:                                     "[1 2 3]",
+		`{{ flatten (list 1 (list 2 3) (list (list 4) (list))) }}`:       "[1 2 3 4]",
+		`{{ flatten (list) }}`:                                           "[]",
+		`{{ mustFlatten (list "a" (splitList "," "b,c") nil) }}`:         "[a b c <nil>]",
+		`{{ (fromJSON "{\"a\":[[1,2],[3,[4,5]]]}").a | flatten | len }}`: "5",
+	}
+	for tpl, expect := range tests {
+		assert.NoError(t, runt(tpl, expect))
+	}
+	_, err := runRaw(`{{ mustFlatten "abc" }}`, nil)
+	assert.ErrorContains(t, err, "cannot flatten type string")
+	_, err = runRaw(`{{ flatten (list (until 6000) (until 6000)) }}`, nil)
+	assert.ErrorContains(t, err, "exceeds maximum limit of 10000 elements")
+}
+
+func TestZip(t *testing.T) {
+	tests := map[string]string{
+		`{{ zip (list 1 2 3) (list "a" "b" "c") }}`:                                                "[[1 a] [2 b] [3 c]]",
+		`{{ zip (list 1 2 3) (list "a") }}`:                                                        "[[1 a]]",
+		`{{ zip (list 1 2) (list 3 4) (list 5 6) }}`:                                               "[[1 3 5] [2 4 6]]",
+		`{{ mustZip (list 1 2) (list) }}`:                                                          "[]",
+		`{{ range zip (list "cpu" "mem") (list 10 20) }}{{ index . 0 }}={{ index . 1 }} {{ end }}`: "cpu=10 mem=20 ",
+	}
+	for tpl, expect := range tests {
+		assert.NoError(t, runt(tpl, expect))
+	}
+	_, err := runRaw(`{{ mustZip (list 1 2) "abc" }}`, nil)
+	assert.ErrorContains(t, err, "cannot zip type string")
+	_, err = runRaw(`{{ zip (until 6000) (until 6000) }}`, nil)
+	assert.ErrorContains(t, err, "exceeds maximum limit of 10000 elements")
+}
+
+func TestAggregates(t *testing.T) {
+	tests := map[string]string{
+		`{{ sum (list 1 2 3.5) }}`:    "6.5",
+		`{{ sum (list "1" " 2 ") }}`:  "3",
+		`{{ sum (list) }}`:            "0",
+		`{{ avg (list 1 2 3 4) }}`:    "2.5",
+		`{{ avg (list) }}`:            "0",
+		`{{ minOf (list 3 -1.5 2) }}`: "-1.5",
+		`{{ maxOf (list 3 -1.5 2) }}`: "3",
+		`{{ minOf (list) }}`:          "0",
+		`{{ maxOf (until 10000) }}`:   "9999",
+		`{{ mustSum (list 1 2) }}`:    "3",
+		`{{ mustAvg (list 1 2) }}`:    "1.5",
+		`{{ mustMinOf (list 1 2) }}`:  "1",
+		`{{ mustMaxOf (list 1 2) }}`:  "2",
+		`{{ $d := fromJSON "{\"values\":[12.5,13,11.5]}" }}{{ avg $d.values | printf "%.1f" }}`: "12.3",
+	}
+	for tpl, expect := range tests {
+		assert.NoError(t, runt(tpl, expect))
+	}
+	for _, tpl := range []string{`{{ mustAvg (list) }}`, `{{ mustMinOf (list) }}`, `{{ mustMaxOf (list) }}`} {
+		_, err := runRaw(tpl, nil)
+		assert.ErrorContains(t, err, "list is empty")
+	}
+	_, err := runRaw(`{{ sum (list 1 "abc") }}`, nil)
+	assert.ErrorContains(t, err, `cannot sum list element 1: "abc" is not a number`)
+	_, err = runRaw(`{{ mustAvg (list 1 (list 2)) }}`, nil)
+	assert.ErrorContains(t, err, "cannot avg list element 1 of type []interface {}")
+	_, err = runRaw(`{{ maxOf 5 }}`, nil)
+	assert.ErrorContains(t, err, "cannot maxOf type int")
+	_, err = runRaw(`{{ sum (concat (until 6000) (until 6000)) }}`, nil)
+	assert.ErrorContains(t, err, "list length 12000 exceeds maximum limit of 10000")
+}
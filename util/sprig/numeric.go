@@ -294,12 +294,12 @@ func minAsFloat64(a any, i ...any) float64 {
 //
 // Returns:
 //   - []int: A slice containing the generated sequence
-func until(count int) []int {
+func (l Limits) until(count int) []int {
 	step := 1
 	if count < 0 {
 		step = -1
 	}
-	return untilStep(0, count, step)
+	return l.untilStep(0, count, step)
 }
 
 // untilStep generates a sequence of integers from start to stop with the specified step.
@@ -318,15 +318,15 @@ func until(count int) []int {
 //   - []int: A slice containing the generated sequence
 //
 // Panics:
-//   - If the number of iterations would exceed loopExecutionLimit
-func untilStep(start, stop, step int) []int {
+//   - If the number of iterations would exceed the loop execution limit
+func (l Limits) untilStep(start, stop, step int) []int {
 	var v []int
 	if step == 0 {
 		return v
 	}
 	iterations := math.Abs(float64(stop)-float64(start)) / float64(step)
-	if iterations > loopExecutionLimit {
-		panic(fmt.Sprintf("too many iterations in untilStep; max allowed is %d, got %f", loopExecutionLimit, iterations))
+	if iterations > float64(l.LoopExecutionLimit) {
+		panic(fmt.Sprintf("too many iterations in untilStep; max allowed is %d, got %f", l.LoopExecutionLimit, iterations))
 	}
 	if stop < start {
 		if step >= 0 {
@@ -448,7 +448,7 @@ func atoi(a string) int {
 //
 // Returns:
 //   - string: A space-delimited string of the generated sequence
-func seq(params ...int) string {
+func (l Limits) seq(params ...int) string {
 	increment := 1
 	switch len(params) {
 	case 0:
@@ -459,7 +459,7 @@ func seq(params ...int) string {
 		if end < start {
 			increment = -1
 		}
-		return intArrayToString(untilStep(start, end+increment, increment), " ")
+		return intArrayToString(l.untilStep(start, end+increment, increment), " ")
 	case 3:
 		start := params[0]
 		end := params[2]
@@ -470,7 +470,7 @@ func seq(params ...int) string {
 				return ""
 			}
 		}
-		return intArrayToString(untilStep(start, end+increment, step), " ")
+		return intArrayToString(l.untilStep(start, end+increment, step), " ")
 	case 2:
 		start := params[0]
 		end := params[1]
@@ -478,7 +478,7 @@ func seq(params ...int) string {
 		if end < start {
 			step = -1
 		}
-		return intArrayToString(untilStep(start, end+step, step), " ")
+		return intArrayToString(l.untilStep(start, end+step, step), " ")
 	default:
 		return ""
 	}
@@ -398,13 +398,13 @@ func substring(start, end int, s string) string {
 //   - string: The repeated string
 //
 // Panics:
-//   - If count exceeds loopExecutionLimit
-//   - If the resulting string length would exceed stringLengthLimit
-func repeat(count int, str string) string {
-	if count > loopExecutionLimit {
-		panic(fmt.Sprintf("repeat count %d exceeds limit of %d", count, loopExecutionLimit))
-	} else if count*len(str) >= stringLengthLimit {
-		panic(fmt.Sprintf("repeat count %d with string length %d exceeds limit of %d", count, len(str), stringLengthLimit))
+//   - If count exceeds the loop execution limit
+//   - If the resulting string length would exceed the string length limit
+func (l Limits) repeat(count int, str string) string {
+	if count > l.LoopExecutionLimit {
+		panic(fmt.Sprintf("repeat count %d exceeds limit of %d", count, l.LoopExecutionLimit))
+	} else if count*len(str) >= l.StringLengthLimit {
+		panic(fmt.Sprintf("repeat count %d with string length %d exceeds limit of %d", count, len(str), l.StringLengthLimit))
 	}
 	return strings.Repeat(str, count)
 }
@@ -0,0 +1,213 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// staticCompressMinSize is the minimum file size for which a compressed variant is generated. Smaller
+	// files barely benefit from compression.
+	staticCompressMinSize = 512
+
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+// staticCompressibleTypes are the content type prefixes for which compressed variants are generated
+var staticCompressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// StaticHandler is an http.Handler that serves files from a file system (typically an embed.FS) with strong
+// ETags, a configurable Cache-Control header, and precompressed gzip and brotli variants.
+//
+// Compressed variants are picked up from the file system if they exist next to the original file (e.g.
+// app.js.gz or app.js.br, generated at build time), and gzip variants are generated and cached in memory
+// otherwise, so that each file is compressed only once. Brotli variants cannot be generated at runtime.
+//
+// Directories, redirects and missing files are handed off to http.FileServer.
+type StaticHandler struct {
+	fs           fs.FS
+	fileServer   http.Handler
+	cacheControl func(name string) string
+	files        map[string]*staticFile
+	mu           sync.RWMutex
+}
+
+type staticFile struct {
+	content     []byte
+	contentType string
+	modTime     time.Time
+	etag        string
+	gzip        []byte // nil if the file is not compressible
+	brotli      []byte // nil if there is no precompressed .br file
+}
+
+// NewStaticHandler creates a new StaticHandler for the given file system. The cacheControl function returns
+// the Cache-Control header for a file (e.g. "no-cache" or "public, max-age=3600"), or an empty string to not
+// send the header. It may be nil.
+func NewStaticHandler(fsys fs.FS, cacheControl func(name string) string) *StaticHandler {
+	return &StaticHandler{
+		fs:           fsys,
+		fileServer:   http.FileServer(http.FS(fsys)),
+		cacheControl: cacheControl,
+		files:        make(map[string]*staticFile),
+	}
+}
+
+// Precompress loads all files of the file system and generates their compressed variants, so that the
+// first requests do not have to. It is meant to be called once (in the background) at startup.
+func (h *StaticHandler) Precompress() error {
+	return fs.WalkDir(h.fs, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() || strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".br") {
+			return nil
+		}
+		_, err = h.file(name)
+		return err
+	})
+}
+
+// ServeHTTP serves the file at the request path
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if strings.HasSuffix(r.URL.Path, "/index.html") {
+		h.fileServer.ServeHTTP(w, r) // Redirects to "./"
+		return
+	} else if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	f, err := h.file(name)
+	if err != nil {
+		h.fileServer.ServeHTTP(w, r) // Directory redirect, directory listing, or 404
+		return
+	}
+	encoding, content, etag := f.negotiate(r.Header.Get("Accept-Encoding"))
+	w.Header().Set("Content-Type", f.contentType)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept-Encoding")
+	if h.cacheControl != nil {
+		if cacheControl := h.cacheControl(name); cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	http.ServeContent(w, r, name, f.modTime, bytes.NewReader(content)) // Handles If-None-Match, Range, HEAD, ...
+}
+
+func (h *StaticHandler) file(name string) (*staticFile, error) {
+	h.mu.RLock()
+	f, ok := h.files[name]
+	h.mu.RUnlock()
+	if ok {
+		return f, nil
+	}
+	stat, err := fs.Stat(h.fs, name)
+	if err != nil {
+		return nil, err
+	} else if stat.IsDir() {
+		return nil, errors.New("file is a directory")
+	}
+	content, err := fs.ReadFile(h.fs, name)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(content)
+	f = &staticFile{
+		content:     content,
+		contentType: staticContentType(name, content),
+		modTime:     stat.ModTime(),
+		etag:        hex.EncodeToString(hash[:8]),
+	}
+	if len(content) >= staticCompressMinSize && staticCompressible(f.contentType) {
+		if f.gzip, err = fs.ReadFile(h.fs, name+".gz"); err != nil {
+			if f.gzip, err = gzipBytes(content); err != nil {
+				return nil, err
+			}
+		}
+		if f.brotli, err = fs.ReadFile(h.fs, name+".br"); err != nil {
+			f.brotli = nil
+		}
+	}
+	h.mu.Lock()
+	h.files[name] = f
+	h.mu.Unlock()
+	return f, nil
+}
+
+// negotiate picks the best representation of the file based on the Accept-Encoding header, and
+// returns the content encoding (empty for identity), the content, and the ETag of the representation
+func (f *staticFile) negotiate(acceptEncoding string) (encoding string, content []byte, etag string) {
+	accepted := parseAcceptEncoding(acceptEncoding)
+	if f.brotli != nil && accepted[encodingBrotli] {
+		return encodingBrotli, f.brotli, strconv.Quote(f.etag + "-" + encodingBrotli)
+	} else if f.gzip != nil && len(f.gzip) < len(f.content) && accepted[encodingGzip] {
+		return encodingGzip, f.gzip, strconv.Quote(f.etag + "-" + encodingGzip)
+	}
+	return "", f.content, strconv.Quote(f.etag)
+}
+
+func parseAcceptEncoding(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(coding))] = true
+	}
+	return accepted
+}
+
+func staticContentType(name string, content []byte) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(content)
+}
+
+func staticCompressible(contentType string) bool {
+	for _, prefix := range staticCompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipBytes(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(content); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	staticTestJS = strings.Repeat("console.log('hello world');\n", 100)
+	staticTestFS = fstest.MapFS{
+		"site/app.js":     {Data: []byte(staticTestJS)},
+		"site/app.js.br":  {Data: []byte("pretend this is brotli")},
+		"site/style.css":  {Data: []byte(strings.Repeat("body { color: red; }\n", 100))},
+		"site/small.txt":  {Data: []byte("small")},
+		"site/image.png":  {Data: bytes.Repeat([]byte{0x89, 0x50}, 1000)},
+		"site/index.html": {Data: []byte("<html></html>")},
+		"site/sub/a.txt":  {Data: []byte("a")},
+	}
+)
+
+func TestStaticHandler_ETagAndNotModified(t *testing.T) {
+	h := NewStaticHandler(staticTestFS, func(name string) string {
+		if strings.HasSuffix(name, ".html") {
+			return "no-cache"
+		}
+		return "public, max-age=3600"
+	})
+
+	rr := staticTestRequest(h, "/site/small.txt", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "small", rr.Body.String())
+	require.Equal(t, "public, max-age=3600", rr.Header().Get("Cache-Control"))
+	require.Equal(t, "", rr.Header().Get("Content-Encoding"))
+	etag := rr.Header().Get("ETag")
+	require.Regexp(t, `^"[0-9a-f]{16}"$`, etag)
+
+	rr = staticTestRequest(h, "/site/small.txt", map[string]string{"If-None-Match": etag})
+	require.Equal(t, 304, rr.Code)
+	require.Equal(t, "", rr.Body.String())
+
+	rr = staticTestRequest(h, "/site/", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "<html></html>", rr.Body.String())
+	require.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+}
+
+func TestStaticHandler_Gzip(t *testing.T) {
+	h := NewStaticHandler(staticTestFS, nil)
+
+	rr := staticTestRequest(h, "/site/style.css", map[string]string{"Accept-Encoding": "gzip, deflate"})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+	require.True(t, strings.HasPrefix(rr.Header().Get("Content-Type"), "text/css"))
+	require.True(t, strings.HasSuffix(rr.Header().Get("ETag"), `-gzip"`))
+	require.Equal(t, "", rr.Header().Get("Cache-Control"))
+	gz, err := gzip.NewReader(rr.Body)
+	require.Nil(t, err)
+	content, err := io.ReadAll(gz)
+	require.Nil(t, err)
+	require.Equal(t, strings.Repeat("body { color: red; }\n", 100), string(content))
+
+	// Not accepted, or explicitly refused
+	rr = staticTestRequest(h, "/site/style.css", map[string]string{"Accept-Encoding": "gzip;q=0"})
+	require.Equal(t, "", rr.Header().Get("Content-Encoding"))
+
+	// Not compressible
+	rr = staticTestRequest(h, "/site/image.png", map[string]string{"Accept-Encoding": "gzip"})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "", rr.Header().Get("Content-Encoding"))
+	require.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+}
+
+func TestStaticHandler_PrecompressedBrotli(t *testing.T) {
+	h := NewStaticHandler(staticTestFS, nil)
+	require.Nil(t, h.Precompress())
+
+	rr := staticTestRequest(h, "/site/app.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "br", rr.Header().Get("Content-Encoding"))
+	require.Equal(t, "pretend this is brotli", rr.Body.String())
+	require.True(t, strings.HasSuffix(rr.Header().Get("ETag"), `-br"`))
+
+	rr = staticTestRequest(h, "/site/app.js", map[string]string{"Accept-Encoding": "gzip"})
+	require.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+
+	rr = staticTestRequest(h, "/site/app.js", nil)
+	require.Equal(t, "", rr.Header().Get("Content-Encoding"))
+	require.Equal(t, staticTestJS, rr.Body.String())
+}
+
+func TestStaticHandler_PrecompressCachingEmbedFS(t *testing.T) {
+	h := NewStaticHandler(testFsCached, nil)
+	require.Nil(t, h.Precompress())
+	h.mu.RLock()
+	_, ok := h.files["embedfs/test.txt"]
+	h.mu.RUnlock()
+	require.True(t, ok)
+
+	rr := staticTestRequest(h, "/embedfs/test.txt", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "This is a test file for embedfs_test.go\n", rr.Body.String())
+}
+
+func TestStaticHandler_FallbackToFileServer(t *testing.T) {
+	h := NewStaticHandler(staticTestFS, nil)
+
+	rr := staticTestRequest(h, "/site/does-not-exist.txt", nil)
+	require.Equal(t, 404, rr.Code)
+
+	rr = staticTestRequest(h, "/site/sub", nil)
+	require.Equal(t, 301, rr.Code)
+	require.Equal(t, "sub/", rr.Header().Get("Location"))
+
+	rr = staticTestRequest(h, "/site/index.html", nil)
+	require.Equal(t, 301, rr.Code)
+}
+
+func staticTestRequest(h http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	return rr
+}
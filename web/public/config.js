@@ -19,4 +19,8 @@ var config = {
   billing_contact: "",
   web_push_public_key: "",
   disallowed_topics: ["docs", "static", "file", "app", "account", "settings", "signup", "login", "v1"],
+  app_name: "",
+  app_logo_url: "",
+  app_accent_color: "",
+  app_terms_url: "",
 };
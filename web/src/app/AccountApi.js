@@ -2,6 +2,8 @@ import i18n from "i18next";
 import {
   accountBillingPortalUrl,
   accountBillingSubscriptionUrl,
+  accountEmailUrl,
+  accountEmailVerifyUrl,
   accountPasswordUrl,
   accountPhoneUrl,
   accountPhoneVerifyUrl,
@@ -9,6 +11,7 @@ import {
   accountReservationUrl,
   accountSettingsUrl,
   accountSubscriptionUrl,
+  accountTokenRefreshUrl,
   accountTokenUrl,
   accountUrl,
   maybeWithBearerAuth,
@@ -46,12 +49,13 @@ class AccountApi {
     const response = await fetchOrThrow(url, {
       method: "POST",
       headers: withBasicAuth({}, user.username, user.password),
+      body: JSON.stringify({ refresh: true }),
     });
     const json = await response.json(); // May throw SyntaxError
     if (!json.token) {
       throw new Error(`Unexpected server response: Cannot find token`);
     }
-    return json.token;
+    return { token: json.token, refreshToken: json.refresh_token };
   }
 
   async logout() {
@@ -155,6 +159,26 @@ class AccountApi {
     });
   }
 
+  async refreshToken() {
+    const refreshToken = session.refreshToken();
+    const url = accountTokenRefreshUrl(config.base_url);
+    console.log(`[AccountApi] Refreshing user access token ${url}`);
+    try {
+      const response = await fetchOrThrow(url, {
+        method: "POST",
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      });
+      const json = await response.json(); // May throw SyntaxError
+      await session.update(json.token, json.refresh_token);
+    } catch (e) {
+      if (e instanceof UnauthorizedError && session.refreshToken() !== refreshToken) {
+        console.log(`[AccountApi] Session was refreshed by another tab in the meantime`);
+        return;
+      }
+      throw e;
+    }
+  }
+
   async deleteToken(token) {
     const url = accountTokenUrl(config.base_url);
     console.log(`[AccountApi] Deleting user access token ${url}`);
@@ -339,7 +363,41 @@ class AccountApi {
     });
   }
 
-  async sync() {
+  async verifyEmail(email) {
+    const url = accountEmailVerifyUrl(config.base_url);
+    console.log(`[AccountApi] Sending email verification ${url}`);
+    await fetchOrThrow(url, {
+      method: "PUT",
+      headers: withBearerAuth({}, session.token()),
+      body: JSON.stringify({
+        email,
+      }),
+    });
+  }
+
+  async changeEmail(email, code) {
+    const url = accountEmailUrl(config.base_url);
+    console.log(`[AccountApi] Changing email address with verification code ${url}`);
+    await fetchOrThrow(url, {
+      method: "PUT",
+      headers: withBearerAuth({}, session.token()),
+      body: JSON.stringify({
+        email,
+        code,
+      }),
+    });
+  }
+
+  async deleteEmail() {
+    const url = accountEmailUrl(config.base_url);
+    console.log(`[AccountApi] Deleting email address ${url}`);
+    await fetchOrThrow(url, {
+      method: "DELETE",
+      headers: withBearerAuth({}, session.token()),
+    });
+  }
+
+  async sync(retry = true) {
     try {
       if (!session.token()) {
         return null;
@@ -367,6 +425,14 @@ class AccountApi {
     } catch (e) {
       console.log(`[AccountApi] Error fetching account`, e);
       if (e instanceof UnauthorizedError) {
+        if (retry && session.refreshToken()) {
+          try {
+            await this.refreshToken(); // Access token may have expired while the tab was in the background
+            return this.sync(false);
+          } catch (refreshError) {
+            console.log(`[AccountApi] Error refreshing user access token`, refreshError);
+          }
+        }
         await session.resetAndRedirect(routes.login);
       }
       return undefined;
@@ -390,11 +456,15 @@ class AccountApi {
     if (!session.token()) {
       return;
     }
-    console.log(`[AccountApi] Extending user access token`);
     try {
-      await this.extendToken();
+      if (session.refreshToken()) {
+        await this.refreshToken();
+      } else {
+        console.log(`[AccountApi] Extending user access token`);
+        await this.extendToken(); // Sessions from before refresh tokens were introduced
+      }
     } catch (e) {
-      console.log(`[AccountApi] Error extending user access token`, e);
+      console.log(`[AccountApi] Error renewing user access token`, e);
     }
   }
 }
@@ -1,8 +1,9 @@
 import Dexie from "dexie";
 
 /**
- * Manages the logged-in user's session and access token.
+ * Manages the logged-in user's session, i.e. the short-lived access token and the refresh token.
  * The session replica is stored in IndexedDB so that the service worker can access it.
+ * The refresh token is only kept in localStorage, since the service worker does not need it.
  */
 class Session {
   constructor() {
@@ -32,19 +33,29 @@ class Session {
     }
   }
 
-  async store(username, token) {
+  async store(username, token, refreshToken) {
     await this.db.kv.bulkPut([
       { key: "user", value: username },
       { key: "token", value: token },
     ]);
     localStorage.setItem("user", username);
     localStorage.setItem("token", token);
+    if (refreshToken) {
+      localStorage.setItem("refresh_token", refreshToken);
+    }
+  }
+
+  async update(token, refreshToken) {
+    await this.db.kv.put({ key: "token", value: token });
+    localStorage.setItem("token", token);
+    localStorage.setItem("refresh_token", refreshToken);
   }
 
   async resetAndRedirect(url) {
     await this.db.delete();
     localStorage.removeItem("user");
     localStorage.removeItem("token");
+    localStorage.removeItem("refresh_token");
     window.location.href = url;
   }
 
@@ -63,6 +74,10 @@ class Session {
   token() {
     return localStorage.getItem("token");
   }
+
+  refreshToken() {
+    return localStorage.getItem("refresh_token");
+  }
 }
 
 const session = new Session();
@@ -20,6 +20,7 @@ import accountApi from "../app/AccountApi";
 import PopupMenu from "./PopupMenu";
 import { SubscriptionPopup } from "./SubscriptionPopup";
 import { useIsLaunchedPWA } from "./hooks";
+import config from "../app/config";
 
 const ActionBar = (props) => {
   const theme = useTheme();
@@ -27,7 +28,7 @@ const ActionBar = (props) => {
   const location = useLocation();
   const isLaunchedPWA = useIsLaunchedPWA();
 
-  let title = "ntfy";
+  let title = config.app_name || "ntfy";
   if (props.selected) {
     title = topicDisplayName(props.selected);
   } else if (location.pathname === routes.settings) {
@@ -37,6 +38,9 @@ const ActionBar = (props) => {
   }
 
   const getActionBarBackground = () => {
+    if (config.app_accent_color) {
+      return config.app_accent_color;
+    }
     if (isLaunchedPWA) {
       return "#317f6f";
     }
@@ -77,7 +81,7 @@ const ActionBar = (props) => {
         </IconButton>
         <Box
           component="img"
-          src={logo}
+          src={config.app_logo_url || logo}
           alt={t("action_bar_logo_alt")}
           sx={{
             display: { xs: "none", sm: "block" },
@@ -43,6 +43,21 @@ const darkModeEnabled = (prefersDarkMode, themePreference) => {
   }
 };
 
+// withAccentColor overrides the primary color of the theme if the server is configured with a custom
+// accent color (web-app-accent-color)
+const withAccentColor = (themeOptions) => {
+  if (!config.app_accent_color) {
+    return themeOptions;
+  }
+  return {
+    ...themeOptions,
+    palette: {
+      ...themeOptions.palette,
+      primary: { main: config.app_accent_color },
+    },
+  };
+};
+
 const App = () => {
   const { i18n } = useTranslation();
   const languageDir = i18n.dir();
@@ -51,7 +66,7 @@ const App = () => {
   const prefersDarkMode = useMediaQuery("(prefers-color-scheme: dark)");
   const themePreference = useLiveQuery(() => prefs.theme());
   const theme = React.useMemo(
-    () => createTheme({ ...(darkModeEnabled(prefersDarkMode, themePreference) ? darkTheme : lightTheme), direction: languageDir }),
+    () => createTheme({ ...withAccentColor(darkModeEnabled(prefersDarkMode, themePreference) ? darkTheme : lightTheme), direction: languageDir }),
     [prefersDarkMode, themePreference, languageDir]
   );
 
@@ -95,7 +110,8 @@ const App = () => {
 };
 
 const updateTitle = (newNotificationsCount) => {
-  document.title = newNotificationsCount > 0 ? `(${newNotificationsCount}) ntfy` : "ntfy";
+  const appName = config.app_name || "ntfy";
+  document.title = newNotificationsCount > 0 ? `(${newNotificationsCount}) ${appName}` : appName;
   window.navigator.setAppBadge?.(newNotificationsCount);
 };
 
@@ -22,9 +22,9 @@ const Login = () => {
     event.preventDefault();
     const user = { username, password };
     try {
-      const token = await accountApi.login(user);
+      const { token, refreshToken } = await accountApi.login(user);
       console.log(`[Login] User auth for user ${user.username} successful, token is ${token}`);
-      await session.store(user.username, token);
+      await session.store(user.username, token, refreshToken);
       window.location.href = routes.app;
     } catch (e) {
       console.log(`[Login] User auth for user ${user.username} failed`, e);
@@ -29,6 +29,7 @@ import AddIcon from "@mui/icons-material/Add";
 import { useLocation, useNavigate } from "react-router-dom";
 import { ChatBubble, MoreVert, NotificationsOffOutlined, Send } from "@mui/icons-material";
 import ArticleIcon from "@mui/icons-material/Article";
+import GavelIcon from "@mui/icons-material/Gavel";
 import { Trans, useTranslation } from "react-i18next";
 import CelebrationIcon from "@mui/icons-material/Celebration";
 import SubscribeDialog from "./SubscribeDialog";
@@ -176,6 +177,14 @@ const NavList = (props) => {
           </ListItemIcon>
           <ListItemText primary={t("nav_button_documentation")} />
         </ListItemButton>
+        {config.app_terms_url && (
+          <ListItemButton onClick={() => openUrl(config.app_terms_url)}>
+            <ListItemIcon>
+              <GavelIcon />
+            </ListItemIcon>
+            <ListItemText primary={t("nav_button_terms")} />
+          </ListItemButton>
+        )}
         <ListItemButton onClick={() => props.onPublishMessageClick()}>
           <ListItemIcon>
             <Send />
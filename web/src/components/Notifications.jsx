@@ -32,6 +32,7 @@ import {
   formatShortDateTime,
   maybeActionErrors,
   openUrl,
+  resizedImageUrl,
   shortUrl,
   topicShortUrl,
   unmatchedTags,
@@ -308,6 +309,7 @@ const NotificationItem = (props) => {
           {maybeActionErrors(notification)}
         </Typography>
         {attachment && <Attachment attachment={attachment} />}
+        {!attachment && notification.preview && <LinkPreview preview={notification.preview} />}
         {tags && (
           <Typography sx={{ fontSize: 14 }} color="text.secondary">
             {t("notifications_tags")}: {tags}
@@ -439,6 +441,51 @@ const Attachment = (props) => {
   );
 };
 
+const LinkPreview = (props) => {
+  const { preview } = props;
+  return (
+    <ButtonBase sx={{ marginTop: 2, display: "block", width: 1 }}>
+      <Link
+        href={preview.url}
+        target="_blank"
+        rel="noopener"
+        underline="none"
+        sx={{
+          display: "flex",
+          alignItems: "center",
+          padding: 1,
+          border: 1,
+          borderColor: "divider",
+          borderRadius: "4px",
+          "&:hover": {
+            backgroundColor: "rgba(0, 0, 0, 0.05)",
+          },
+        }}
+      >
+        {preview.image && (
+          <Box
+            component="img"
+            src={preview.image}
+            loading="lazy"
+            alt=""
+            sx={{ width: 64, height: 64, flexShrink: 0, marginRight: 1, borderRadius: "4px", objectFit: "cover" }}
+          />
+        )}
+        <Typography variant="body2" sx={{ textAlign: "left", color: "text.primary", overflow: "hidden" }}>
+          {preview.site_name && (
+            <Typography component="span" variant="caption" color="text.secondary" sx={{ display: "block" }}>
+              {preview.site_name}
+            </Typography>
+          )}
+          {preview.title && <b>{preview.title}</b>}
+          {preview.title && preview.description && <br />}
+          {preview.description}
+        </Typography>
+      </Link>
+    </ButtonBase>
+  );
+};
+
 const Image = (props) => {
   const { t } = useTranslation();
   const [open, setOpen] = useState(false);
@@ -446,7 +493,7 @@ const Image = (props) => {
     <>
       <Box
         component="img"
-        src={props.attachment.url}
+        src={resizedImageUrl(props.attachment.url, 800)}
         loading="lazy"
         alt={t("notifications_attachment_image")}
         onClick={() => setOpen(true)}
@@ -25,9 +25,9 @@ const Signup = () => {
     const user = { username, password };
     try {
       await accountApi.create(user.username, user.password);
-      const token = await accountApi.login(user);
+      const { token, refreshToken } = await accountApi.login(user);
       console.log(`[Signup] User signup for user ${user.username} successful, token is ${token}`);
-      await session.store(user.username, token);
+      await session.store(user.username, token, refreshToken);
       window.location.href = routes.app;
     } catch (e) {
       console.log(`[Signup] Signup for user ${user.username} failed`, e);